@@ -19,11 +19,15 @@ package command
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"strings"
 
+	"github.com/fatih/color"
 	yamljsontool "github.com/ghodss/yaml"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v2"
@@ -61,6 +65,8 @@ const (
 	statusObjectURL  = apiURL + "/status/objects/%s"
 	statusObjectsURL = apiURL + "/status/objects"
 
+	ownershipURL = apiURL + "/ownership"
+
 	wasmURL = apiURL + "/wasm/code"
 
 	// MeshTenantsURL is the mesh tenant prefix.
@@ -106,8 +112,34 @@ const (
 	MeshIngressURL = apiURL + "/mesh/ingresses/%s"
 )
 
+// unixSocketPrefix is the prefix of the server which is a Unix domain socket,
+// e.g. unix:///var/run/easegress.sock.
+const unixSocketPrefix = "unix://"
+
 func makeURL(urlTemplate string, a ...interface{}) string {
-	return "http://" + CommandlineGlobalFlags.Server + fmt.Sprintf(urlTemplate, a...)
+	host := CommandlineGlobalFlags.Server
+	if strings.HasPrefix(host, unixSocketPrefix) {
+		// NOTE: The host is ignored as the client dials the socket.
+		host = "localhost"
+	}
+	return "http://" + host + fmt.Sprintf(urlTemplate, a...)
+}
+
+// httpClient returns the client talking to the server.
+func httpClient() *http.Client {
+	if !strings.HasPrefix(CommandlineGlobalFlags.Server, unixSocketPrefix) {
+		return http.DefaultClient
+	}
+
+	path := strings.TrimPrefix(CommandlineGlobalFlags.Server, unixSocketPrefix)
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
 }
 
 func successfulStatusCode(code int) bool {
@@ -115,12 +147,42 @@ func successfulStatusCode(code int) bool {
 }
 
 func handleRequest(httpMethod string, url string, reqBody []byte, cmd *cobra.Command) {
+	statusCode, body, header := doRequest(httpMethod, url, reqBody, cmd)
+
+	if !successfulStatusCode(statusCode) {
+		exitWithAPIError(body)
+	}
+
+	if len(body) != 0 {
+		printBody(body, strings.HasPrefix(header.Get("Content-Type"), "application/json"))
+	}
+}
+
+func exitWithAPIError(body []byte) {
+	msg := string(body)
+	apiErr := &APIErr{}
+	err := yaml.Unmarshal(body, apiErr)
+	if err == nil {
+		msg = apiErr.Message
+	}
+	ExitWithErrorf("%d: %s", apiErr.Code, msg)
+}
+
+// doRequest sends the request, and returns the status code, body and header
+// of the response.
+func doRequest(httpMethod string, url string, reqBody []byte, cmd *cobra.Command) (int, []byte, http.Header) {
 	req, err := http.NewRequest(httpMethod, url, bytes.NewReader(reqBody))
 	if err != nil {
 		ExitWithError(err)
 	}
+	if isJSON(reqBody) {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if CommandlineGlobalFlags.OutputFormat == "json" {
+		req.Header.Set("Accept", "application/json")
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient().Do(req)
 	if err != nil {
 		ExitWithErrorf("%s failed: %v", cmd.Short, err)
 	}
@@ -131,31 +193,44 @@ func handleRequest(httpMethod string, url string, reqBody []byte, cmd *cobra.Com
 		ExitWithErrorf("%s failed: %v", cmd.Short, err)
 	}
 
-	if !successfulStatusCode(resp.StatusCode) {
-		msg := string(body)
-		apiErr := &APIErr{}
-		err = yaml.Unmarshal(body, apiErr)
-		if err == nil {
-			msg = apiErr.Message
-		}
-		ExitWithErrorf("%d: %s", apiErr.Code, msg)
+	for _, warning := range resp.Header.Values("Warning") {
+		printWarning(warning)
 	}
 
-	if len(body) != 0 {
-		printBody(body)
-	}
+	return resp.StatusCode, body, resp.Header
+}
+
+// printWarning prints the warning to stderr, e.g. deprecated fields of specs.
+func printWarning(warning string) {
+	color.New(color.FgYellow).Fprint(os.Stderr, "Warning: ")
+	fmt.Fprintf(os.Stderr, "%s\n", warning)
+}
+
+// isJSON returns true if the body looks like a JSON document.
+func isJSON(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	return len(body) != 0 && (body[0] == '{' || body[0] == '[')
 }
 
-func printBody(body []byte) {
+func printBody(body []byte, bodyJSON bool) {
 	var output []byte
+	var err error
 	switch CommandlineGlobalFlags.OutputFormat {
 	case "yaml":
 		output = body
+		if bodyJSON {
+			output, err = yamljsontool.JSONToYAML(body)
+			if err != nil {
+				ExitWithErrorf("json %s to yaml failed: %v", body, err)
+			}
+		}
 	case "json":
-		var err error
-		output, err = yamljsontool.YAMLToJSON(body)
-		if err != nil {
-			ExitWithErrorf("yaml %s to json failed: %v", body, err)
+		output = body
+		if !bodyJSON {
+			output, err = yamljsontool.YAMLToJSON(body)
+			if err != nil {
+				ExitWithErrorf("yaml %s to json failed: %v", body, err)
+			}
 		}
 	}
 
@@ -165,7 +240,17 @@ func printBody(body []byte) {
 func readFromFileOrStdin(specFile string, cmd *cobra.Command) ([]byte, string) {
 	var buff []byte
 	var err error
-	if specFile != "" {
+	if needEvaluate(specFile) {
+		specs, err := evaluateSpecFile(specFile)
+		if err != nil {
+			ExitWithErrorf("%s failed: %v", cmd.Short, err)
+		}
+		if len(specs) != 1 {
+			ExitWithErrorf("%s failed: %s evaluates to %d objects, use apply instead",
+				cmd.Short, specFile, len(specs))
+		}
+		buff = specs[0]
+	} else if specFile != "" {
 		buff, err = ioutil.ReadFile(specFile)
 		if err != nil {
 			ExitWithErrorf("%s failed: %v", cmd.Short, err)
@@ -188,3 +273,18 @@ func readFromFileOrStdin(specFile string, cmd *cobra.Command) ([]byte, string) {
 
 	return buff, spec.Name
 }
+
+// readSpecs reads object specs from the file or stdin, a CUE or Jsonnet
+// file could be evaluated into several object specs.
+func readSpecs(specFile string, cmd *cobra.Command) [][]byte {
+	if !needEvaluate(specFile) {
+		buff, _ := readFromFileOrStdin(specFile, cmd)
+		return [][]byte{buff}
+	}
+
+	specs, err := evaluateSpecFile(specFile)
+	if err != nil {
+		ExitWithErrorf("%s failed: %v", cmd.Short, err)
+	}
+	return specs
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/megaease/easegress/pkg/convert"
+	"github.com/megaease/easegress/pkg/convert/nginx"
+	"github.com/megaease/easegress/pkg/convert/springcloudgateway"
+)
+
+// ConvertCmd defines convert command.
+func ConvertCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "convert",
+		Short: "Convert configurations of other gateways into Easegress specs",
+	}
+
+	cmd.AddCommand(convertSpringCloudGatewayCmd())
+	cmd.AddCommand(convertNginxCmd())
+
+	return cmd
+}
+
+func convertSpringCloudGatewayCmd() *cobra.Command {
+	var file, name, serviceRegistry string
+	cmd := &cobra.Command{
+		Use:     "springcloudgateway",
+		Aliases: []string{"scg"},
+		Short:   "Convert routes of Spring Cloud Gateway into an HTTPServer and HTTPPipelines",
+		Example: `  egctl convert springcloudgateway -f application.yml > specs.yaml
+  egctl convert scg --name gateway --service-registry eureka-service-registry -f application.yml`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if file == "" {
+				ExitWithErrorf("%s failed: application yaml file is required", cmd.Short)
+			}
+			buff, err := ioutil.ReadFile(file)
+			if err != nil {
+				ExitWithErrorf("%s failed: %v", cmd.Short, err)
+			}
+
+			result, err := springcloudgateway.Convert(buff, &springcloudgateway.Options{
+				Name:            name,
+				ServiceRegistry: serviceRegistry,
+			})
+			if err != nil {
+				ExitWithErrorf("%s failed: %v", cmd.Short, err)
+			}
+			printConvertResult(result, cmd)
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "", "The application yaml file of Spring Cloud Gateway.")
+	cmd.Flags().StringVar(&name, "name", "spring-cloud-gateway",
+		"The name of the HTTPServer, and the prefix of the names of the HTTPPipelines.")
+	cmd.Flags().StringVar(&serviceRegistry, "service-registry", "",
+		"The service registry to discover the services of lb:// URIs.")
+
+	return cmd
+}
+
+func convertNginxCmd() *cobra.Command {
+	var file, name, dir string
+	cmd := &cobra.Command{
+		Use:   "nginx",
+		Short: "Convert http servers of nginx into HTTPServers and HTTPPipelines",
+		Example: `  egctl convert nginx -f /etc/nginx/nginx.conf > specs.yaml
+  egctl convert nginx --name gateway --prefix /usr/local/nginx/conf -f nginx.conf`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if file == "" {
+				ExitWithErrorf("%s failed: nginx configuration file is required", cmd.Short)
+			}
+
+			result, err := nginx.Convert(file, &nginx.Options{Name: name, Dir: dir})
+			if err != nil {
+				ExitWithErrorf("%s failed: %v", cmd.Short, err)
+			}
+			printConvertResult(result, cmd)
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "", "The nginx configuration file.")
+	cmd.Flags().StringVar(&name, "name", "nginx", "The prefix of the names of the HTTPServers and HTTPPipelines.")
+	cmd.Flags().StringVar(&dir, "prefix", "",
+		"The directory of relative paths in the configuration, default to the directory of the file.")
+
+	return cmd
+}
+
+// printConvertResult prints the converted specs to stdout, and the
+// warnings to stderr, so that the specs could be redirected to a file.
+func printConvertResult(result *convert.Result, cmd *cobra.Command) {
+	for _, warning := range result.Warnings {
+		printWarning(warning)
+	}
+
+	buff, err := result.YAML()
+	if err != nil {
+		ExitWithErrorf("%s failed: %v", cmd.Short, err)
+	}
+	os.Stdout.Write(buff)
+}
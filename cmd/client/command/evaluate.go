@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// evaluators are the commands evaluating spec files of typed configuration
+// languages into JSON, keyed by the file extension.
+var evaluators = map[string][]string{
+	".cue":       {"cue", "export", "--out", "json"},
+	".jsonnet":   {"jsonnet"},
+	".libsonnet": {"jsonnet"},
+}
+
+// needEvaluate returns true if the spec file has to be evaluated
+// before sending to Easegress.
+func needEvaluate(specFile string) bool {
+	_, exists := evaluators[strings.ToLower(filepath.Ext(specFile))]
+	return exists
+}
+
+// evaluateSpecFile evaluates the CUE or Jsonnet file into JSON documents,
+// the top-level value could be an object spec or a list of object specs.
+func evaluateSpecFile(specFile string) ([][]byte, error) {
+	evaluator := evaluators[strings.ToLower(filepath.Ext(specFile))]
+	if evaluator == nil {
+		return nil, fmt.Errorf("unsupported spec file %s", specFile)
+	}
+
+	path, err := exec.LookPath(evaluator[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s is required to evaluate %s: %v", evaluator[0], specFile, err)
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.Command(path, append(evaluator[1:], specFile)...)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	err = cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("evaluate %s failed: %v: %s", specFile, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return splitJSONSpecs(stdout.Bytes())
+}
+
+// splitJSONSpecs splits the JSON document into object specs.
+func splitJSONSpecs(buff []byte) ([][]byte, error) {
+	buff = bytes.TrimSpace(buff)
+	if len(buff) == 0 || buff[0] != '[' {
+		if !json.Valid(buff) {
+			return nil, fmt.Errorf("invalid json: %s", buff)
+		}
+		return [][]byte{buff}, nil
+	}
+
+	var items []json.RawMessage
+	err := json.Unmarshal(buff, &items)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json: %v", err)
+	}
+
+	specs := make([][]byte, 0, len(items))
+	for _, item := range items {
+		specs = append(specs, []byte(item))
+	}
+
+	return specs, nil
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/megaease/easegress/pkg/migration"
+)
+
+// documentSeparator separates the documents of a YAML file.
+var documentSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// MigrateCmd defines migrate command.
+func MigrateCmd() *cobra.Command {
+	var specFile, from, to string
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrate spec files to the schema of another version",
+		Example: `  egctl migrate --from v1 -f <object_spec.yaml>
+  egctl migrate --from v1 --to v2 -f <specs/>
+  egctl migrate --from v1 --dry-run -f <object_spec.yaml>`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if specFile == "" {
+				ExitWithErrorf("%s failed: spec file or directory is required", cmd.Short)
+			}
+			if err := migration.ValidateVersions(from, to); err != nil {
+				ExitWithErrorf("%s failed: %v", cmd.Short, err)
+			}
+			migrateSpecFiles(specFile, from, to, dryRun, cmd)
+		},
+	}
+
+	cmd.Flags().StringVarP(&specFile, "file", "f", "",
+		"A yaml file, or a directory whose yaml files are migrated recursively.")
+	cmd.Flags().StringVar(&from, "from", "", fmt.Sprintf("The version of the specs, one of %v.", migration.Versions))
+	cmd.Flags().StringVar(&to, "to", migration.Latest, "The version to migrate to.")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the migrated specs instead of rewriting the files.")
+
+	return cmd
+}
+
+func specFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && (strings.HasSuffix(file, ".yaml") || strings.HasSuffix(file, ".yml")) {
+			files = append(files, file)
+		}
+		return nil
+	})
+	return files, err
+}
+
+func migrateSpecFiles(path, from, to string, dryRun bool, cmd *cobra.Command) {
+	files, err := specFiles(path)
+	if err != nil {
+		ExitWithErrorf("%s failed: %v", cmd.Short, err)
+	}
+
+	for _, file := range files {
+		buff, err := ioutil.ReadFile(file)
+		if err != nil {
+			ExitWithErrorf("%s failed: %v", cmd.Short, err)
+		}
+
+		docs := documentSeparator.Split(string(buff), -1)
+		changed := false
+		for i, doc := range docs {
+			if strings.TrimSpace(doc) == "" {
+				continue
+			}
+			migrated, warnings, err := migration.MigrateYAML([]byte(doc), from, to)
+			if err != nil {
+				ExitWithErrorf("%s failed: %s: %v", cmd.Short, file, err)
+			}
+			for _, warning := range warnings {
+				printWarning(fmt.Sprintf("%s: %s", file, warning))
+			}
+			if !bytes.Equal(migrated, []byte(doc)) {
+				// NOTE: Keep the leading newline after the separator.
+				if i > 0 {
+					migrated = append([]byte("\n"), migrated...)
+				}
+				docs[i], changed = string(migrated), true
+			}
+		}
+
+		output := strings.Join(docs, "---")
+		if dryRun {
+			fmt.Printf("# %s\n%s", file, output)
+			continue
+		}
+		if changed {
+			err = ioutil.WriteFile(file, []byte(output), 0o644)
+			if err != nil {
+				ExitWithErrorf("%s failed: %v", cmd.Short, err)
+			}
+			fmt.Printf("%s migrated\n", file)
+		}
+	}
+}
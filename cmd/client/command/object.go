@@ -19,9 +19,11 @@ package command
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
 )
 
 // ObjectCmd defines object command.
@@ -37,8 +39,10 @@ func ObjectCmd() *cobra.Command {
 	cmd.AddCommand(getObjectCmd())
 	cmd.AddCommand(createObjectCmd())
 	cmd.AddCommand(updateObjectCmd())
+	cmd.AddCommand(ApplyCmd())
 	cmd.AddCommand(deleteObjectCmd())
 	cmd.AddCommand(statusObjectCmd())
+	cmd.AddCommand(ownershipObjectsCmd())
 
 	return cmd
 }
@@ -66,7 +70,7 @@ func createObjectCmd() *cobra.Command {
 		},
 	}
 
-	cmd.Flags().StringVarP(&specFile, "file", "f", "", "A yaml file specifying the object.")
+	cmd.Flags().StringVarP(&specFile, "file", "f", "", "A yaml, json, cue or jsonnet file specifying the object.")
 
 	return cmd
 }
@@ -82,16 +86,65 @@ func updateObjectCmd() *cobra.Command {
 		},
 	}
 
-	cmd.Flags().StringVarP(&specFile, "file", "f", "", "A yaml file specifying the object.")
+	cmd.Flags().StringVarP(&specFile, "file", "f", "", "A yaml, json, cue or jsonnet file specifying the object.")
 
 	return cmd
 }
 
+// ApplyCmd defines apply command, which creates or updates objects.
+func ApplyCmd() *cobra.Command {
+	var specFile string
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Create or update objects from a yaml, json, cue, jsonnet file or stdin",
+		Example: `  egctl apply -f <object_spec.yaml>
+  egctl apply -f <object_specs.cue>
+  egctl apply -f <object_specs.jsonnet>`,
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, buff := range readSpecs(specFile, cmd) {
+				applyObject(buff, cmd)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&specFile, "file", "f", "",
+		"A yaml, json, cue or jsonnet file specifying the objects, "+
+			"cue and jsonnet files could evaluate to a list of objects.")
+
+	return cmd
+}
+
+func applyObject(buff []byte, cmd *cobra.Command) {
+	var spec struct {
+		Name string `yaml:"name"`
+	}
+	err := yaml.Unmarshal(buff, &spec)
+	if err != nil {
+		ExitWithErrorf("%s failed, invalid spec: %v", cmd.Short, err)
+	}
+	if spec.Name == "" {
+		ExitWithErrorf("%s failed, invalid spec: name is required", cmd.Short)
+	}
+
+	action := "updated"
+	statusCode, body, _ := doRequest(http.MethodPut, makeURL(objectURL, spec.Name), buff, cmd)
+	if statusCode == http.StatusNotFound {
+		action = "created"
+		statusCode, body, _ = doRequest(http.MethodPost, makeURL(objectsURL), buff, cmd)
+	}
+	if !successfulStatusCode(statusCode) {
+		exitWithAPIError(body)
+	}
+
+	fmt.Printf("%s %s\n", spec.Name, action)
+}
+
 func deleteObjectCmd() *cobra.Command {
+	var cascade bool
 	cmd := &cobra.Command{
 		Use:     "delete",
 		Short:   "Delete an object",
-		Example: "egctl object delete <object_name>",
+		Example: "egctl object delete <object_name> [--cascade]",
 		Args: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 1 {
 				return errors.New("requires one object name to be deleted")
@@ -101,10 +154,16 @@ func deleteObjectCmd() *cobra.Command {
 		},
 
 		Run: func(cmd *cobra.Command, args []string) {
-			handleRequest(http.MethodDelete, makeURL(objectURL, args[0]), nil, cmd)
+			url := makeURL(objectURL, args[0])
+			if cascade {
+				url += "?cascade=true"
+			}
+			handleRequest(http.MethodDelete, url, nil, cmd)
 		},
 	}
 
+	cmd.Flags().BoolVar(&cascade, "cascade", false, "Delete the objects referencing it too.")
+
 	return cmd
 }
 
@@ -188,3 +247,16 @@ func listStatusObjectsCmd() *cobra.Command {
 
 	return cmd
 }
+
+func ownershipObjectsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "ownership",
+		Short:   "List objects generated by controllers grouped by owners",
+		Example: "egctl object ownership",
+		Run: func(cmd *cobra.Command, args []string) {
+			handleRequest(http.MethodGet, makeURL(ownershipURL), nil, cmd)
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,349 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package command
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/version"
+)
+
+const (
+	versionURL    = apiURL + "/version"
+	metricsURL    = apiURL + "/metrics"
+	goroutinesURL = apiURL + "/debug/goroutines"
+	logsURL       = apiURL + "/debug/logs"
+	logURL        = apiURL + "/debug/logs/%s?lines=%d"
+
+	// snapshotAttempts is the max attempts to take a snapshot of objects,
+	// their status and members in the same config version.
+	snapshotAttempts = 3
+
+	redactedValue = "<redacted>"
+)
+
+type (
+	// bundleManifest describes the content of a support bundle.
+	bundleManifest struct {
+		CreatedAt     string   `yaml:"createdAt"`
+		Server        string   `yaml:"server"`
+		Client        string   `yaml:"client"`
+		ConfigVersion string   `yaml:"configVersion"`
+		Consistent    bool     `yaml:"consistent"`
+		Redacted      bool     `yaml:"redacted"`
+		Files         []string `yaml:"files"`
+		Errors        []string `yaml:"errors,omitempty"`
+	}
+
+	bundleFile struct {
+		name string
+		data []byte
+	}
+
+	supportBundle struct {
+		manifest *bundleManifest
+		files    []*bundleFile
+		redact   bool
+	}
+)
+
+// sensitiveKeywords are the keywords of the keys whose values are redacted,
+// the keys are compared in lower case without '-' and '_'.
+var sensitiveKeywords = []string{
+	"password", "passwd", "secret", "token", "credential", "apikey",
+	"privatekey", "authorization", "cookie", "cert", "base64",
+}
+
+// SupportBundleCmd defines support-bundle command.
+func SupportBundleCmd() *cobra.Command {
+	var file string
+	var logLines int
+	var noRedact bool
+
+	cmd := &cobra.Command{
+		Use:   "support-bundle",
+		Short: "Collect the runtime state of Easegress into an archive for bug reports",
+		Long: `Collect the runtime state of Easegress into an archive for bug reports.
+
+The archive contains the version, members, object specs and status, metrics,
+goroutine dumps and recent logs of the member serving the admin API. Secrets
+in object specs and member options are redacted unless --no-redact is given,
+logs are included as is, please review them before sharing.`,
+		Example: "egctl support-bundle -f easegress-bundle.tar.gz --log-lines 5000",
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if logLines <= 0 {
+				ExitWithErrorf("%s failed: log-lines must be positive", cmd.Short)
+			}
+			if file == "" {
+				file = fmt.Sprintf("easegress-support-bundle-%s.tar.gz", time.Now().Format("20060102-150405"))
+			}
+
+			b := &supportBundle{
+				manifest: &bundleManifest{
+					CreatedAt: time.Now().Format(time.RFC3339),
+					Server:    CommandlineGlobalFlags.Server,
+					Client:    version.Long,
+					Redacted:  !noRedact,
+				},
+				redact: !noRedact,
+			}
+			b.collect(logLines)
+
+			err := b.writeArchive(file)
+			if err != nil {
+				ExitWithErrorf("%s failed: %v", cmd.Short, err)
+			}
+
+			fmt.Printf("support bundle written to %s\n", file)
+			for _, e := range b.manifest.Errors {
+				printWarning(e)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "", "The archive file to write, defaults to easegress-support-bundle-<time>.tar.gz.")
+	cmd.Flags().IntVar(&logLines, "log-lines", 1000, "The number of recent lines to collect from each log file.")
+	cmd.Flags().BoolVar(&noRedact, "no-redact", false, "Keep secrets in object specs and member options.")
+
+	return cmd
+}
+
+func (b *supportBundle) collect(logLines int) {
+	b.add("version.yaml", makeURL(versionURL))
+	b.snapshot()
+	b.add("metrics.txt", makeURL(metricsURL))
+	b.add("goroutines.txt", makeURL(goroutinesURL))
+	b.collectLogs(logLines)
+}
+
+// snapshot collects objects, their status and members, it retries if the
+// config version changed during the collection, so that they are consistent.
+func (b *supportBundle) snapshot() {
+	urls := []struct {
+		name string
+		url  string
+	}{
+		{"objects.yaml", objectsURL},
+		{"status/objects.yaml", statusObjectsURL},
+		{"members.yaml", membersURL},
+	}
+
+	var files []*bundleFile
+	var errs []string
+	for i := 0; i < snapshotAttempts; i++ {
+		files, errs = nil, nil
+		versions := map[string]bool{}
+
+		for _, u := range urls {
+			body, header, err := b.fetch(makeURL(u.url))
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			versions[header.Get("X-Config-Version")] = true
+			b.manifest.ConfigVersion = header.Get("X-Config-Version")
+
+			if b.redact {
+				body, err = redactYAML(body)
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("redact %s failed: %v", u.name, err))
+					continue
+				}
+			}
+			files = append(files, &bundleFile{name: u.name, data: body})
+		}
+
+		if len(versions) <= 1 {
+			b.manifest.Consistent = true
+			break
+		}
+	}
+
+	b.files = append(b.files, files...)
+	b.manifest.Errors = append(b.manifest.Errors, errs...)
+	if !b.manifest.Consistent {
+		b.manifest.Errors = append(b.manifest.Errors,
+			fmt.Sprintf("config changed during %d attempts, objects, status and members may be inconsistent", snapshotAttempts))
+	}
+}
+
+func (b *supportBundle) collectLogs(logLines int) {
+	body, _, err := b.fetch(makeURL(logsURL))
+	if err != nil {
+		b.manifest.Errors = append(b.manifest.Errors, err.Error())
+		return
+	}
+
+	var logs []struct {
+		Name string `yaml:"name"`
+	}
+	err = yaml.Unmarshal(body, &logs)
+	if err != nil {
+		b.manifest.Errors = append(b.manifest.Errors, fmt.Sprintf("unmarshal log files failed: %v", err))
+		return
+	}
+
+	for _, l := range logs {
+		b.add(path.Join("logs", l.Name), makeURL(logURL, l.Name, logLines))
+	}
+}
+
+// add fetches the url and adds the body as a file, failures are recorded
+// in the manifest instead of aborting the collection.
+func (b *supportBundle) add(name, url string) {
+	body, _, err := b.fetch(url)
+	if err != nil {
+		b.manifest.Errors = append(b.manifest.Errors, err.Error())
+		return
+	}
+	b.files = append(b.files, &bundleFile{name: name, data: body})
+}
+
+func (b *supportBundle) fetch(url string) ([]byte, http.Header, error) {
+	resp, err := httpClient().Get(url)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read %s failed: %v", url, err)
+	}
+
+	if !successfulStatusCode(resp.StatusCode) {
+		msg := strings.TrimSpace(string(body))
+		apiErr := &APIErr{}
+		if yaml.Unmarshal(body, apiErr) == nil && apiErr.Message != "" {
+			msg = apiErr.Message
+		}
+		return nil, nil, fmt.Errorf("get %s failed: %d: %s", url, resp.StatusCode, msg)
+	}
+
+	return body, resp.Header, nil
+}
+
+func (b *supportBundle) writeArchive(file string) error {
+	manifest := b.manifest
+	for _, f := range b.files {
+		manifest.Files = append(manifest.Files, f.name)
+	}
+	buff, err := yaml.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("marshal manifest failed: %v", err)
+	}
+	files := append([]*bundleFile{{name: "manifest.yaml", data: buff}}, b.files...)
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	dir := strings.TrimSuffix(path.Base(file), ".tar.gz")
+	now := time.Now()
+	for _, bf := range files {
+		hdr := &tar.Header{
+			Name:    path.Join(dir, bf.name),
+			Mode:    0o600,
+			Size:    int64(len(bf.data)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(bf.data); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// redactYAML redacts the values of sensitive keys and PEM blocks in the
+// YAML document.
+func redactYAML(doc []byte) ([]byte, error) {
+	var v interface{}
+	err := yaml.Unmarshal(doc, &v)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(redactValue(v, false))
+}
+
+func redactValue(v interface{}, sensitive bool) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		for k, child := range v {
+			v[k] = redactValue(child, sensitive || isSensitiveKey(fmt.Sprint(k)))
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactValue(child, sensitive)
+		}
+		return v
+	case string:
+		if v != "" && (sensitive || strings.Contains(v, "-----BEGIN")) {
+			return redactedValue
+		}
+		return v
+	case nil:
+		return v
+	default:
+		if sensitive {
+			return redactedValue
+		}
+		return v
+	}
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	key = strings.NewReplacer("-", "", "_", "").Replace(key)
+
+	if strings.HasSuffix(key, "key") || strings.HasSuffix(key, "keys") {
+		return true
+	}
+	for _, keyword := range sensitiveKeywords {
+		if strings.Contains(key, keyword) {
+			return true
+		}
+	}
+	return false
+}
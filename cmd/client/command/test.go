@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline/pipelinetest"
+
+	// NOTE: Register the real filters to run the pipelines.
+	_ "github.com/megaease/easegress/pkg/registry"
+)
+
+// TestCmd defines test command.
+func TestCmd() *cobra.Command {
+	var testFile string
+	cmd := &cobra.Command{
+		Use:   "test",
+		Short: "Test pipelines locally by test files",
+		Example: `  egctl test -f <pipeline_test.yaml>
+  egctl test -f <tests/>`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if testFile == "" {
+				ExitWithErrorf("%s failed: test file or directory is required", cmd.Short)
+			}
+			runTests(testFile, cmd)
+		},
+	}
+
+	cmd.Flags().StringVarP(&testFile, "file", "f", "",
+		"A test file, or a directory whose *_test.yaml files are tested recursively.")
+
+	return cmd
+}
+
+// testFiles returns the file itself, or the test files under the directory.
+func testFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && (strings.HasSuffix(file, "_test.yaml") || strings.HasSuffix(file, "_test.yml")) {
+			files = append(files, file)
+		}
+		return nil
+	})
+	return files, err
+}
+
+func runTests(path string, cmd *cobra.Command) {
+	logger.InitNop()
+
+	files, err := testFiles(path)
+	if err != nil {
+		ExitWithErrorf("%s failed: %v", cmd.Short, err)
+	}
+	if len(files) == 0 {
+		ExitWithErrorf("%s failed: no test files in %s", cmd.Short, path)
+	}
+
+	failed := 0
+	reports := make([]*pipelinetest.Report, 0, len(files))
+	for _, file := range files {
+		report, err := pipelinetest.RunFile(file)
+		if err != nil {
+			ExitWithErrorf("%s failed: %v", cmd.Short, err)
+		}
+		failed += report.Failed
+		reports = append(reports, report)
+	}
+
+	output, err := yaml.Marshal(reports)
+	if err != nil {
+		ExitWithErrorf("%s failed: %v", cmd.Short, err)
+	}
+	printBody(output, false)
+
+	if failed != 0 {
+		ExitWithErrorf("%d cases failed", failed)
+	}
+}
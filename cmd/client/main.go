@@ -35,12 +35,18 @@ var exampleUsage = `  # List APIs.
   # Probe health.
   egctl health
 
+  # Probe health through the Unix domain socket of the admin API.
+  egctl health --server unix:///var/run/easegress.sock
+
   # List member information.
   egctl member list
 
   # Purge a easegress member
   egctl member purge <member name>
 
+  # Collect the runtime state into an archive for bug reports.
+  egctl support-bundle -f easegress-bundle.tar.gz
+
   # List object kinds.
   egctl object kinds
 
@@ -50,6 +56,9 @@ var exampleUsage = `  # List APIs.
   # Create an object from stdout.
   cat <object_spec.yaml> | egctl object create
 
+  # Create or update objects from a yaml, json, cue or jsonnet file.
+  egctl apply -f <object_specs.cue>
+
   # Delete an object.
   egctl object delete <object_name>
 
@@ -70,6 +79,18 @@ var exampleUsage = `  # List APIs.
 
   # Get object status
   egctl object status get <object_name>
+
+  # Test pipelines locally by the test files under a directory.
+  egctl test -f <tests/>
+
+  # Migrate the spec files under a directory from v1 to the current schema.
+  egctl migrate --from v1 -f <specs/>
+
+  # Convert the routes of Spring Cloud Gateway into Easegress specs.
+  egctl convert springcloudgateway -f <application.yml>
+
+  # Convert the http servers of nginx into Easegress specs.
+  egctl convert nginx -f <nginx.conf>
 `
 
 func main() {
@@ -108,13 +129,18 @@ func main() {
 		command.APICmd(),
 		command.HealthCmd(),
 		command.ObjectCmd(),
+		command.ApplyCmd(),
 		command.MemberCmd(),
 		command.WasmCmd(),
+		command.SupportBundleCmd(),
+		command.TestCmd(),
+		command.MigrateCmd(),
+		command.ConvertCmd(),
 		completionCmd,
 	)
 
 	rootCmd.PersistentFlags().StringVar(&command.CommandlineGlobalFlags.Server,
-		"server", "localhost:2381", "The address of the Easegress endpoint")
+		"server", "localhost:2381", "The address of the Easegress endpoint, or the Unix domain socket in the form of unix:///path/to/socket")
 	rootCmd.PersistentFlags().StringVarP(&command.CommandlineGlobalFlags.OutputFormat,
 		"output", "o", "yaml", "Output format(json, yaml)")
 
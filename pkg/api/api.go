@@ -24,8 +24,10 @@ import (
 	"sync"
 	"time"
 
-	"github.com/megaease/easegress/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/logger"
 )
 
 func aboutText() string {
@@ -100,6 +102,9 @@ func (s *Server) registerAPIs() {
 	group.Entries = append(group.Entries, s.metadataAPIEntries()...)
 	group.Entries = append(group.Entries, s.healthAPIEntries()...)
 	group.Entries = append(group.Entries, s.aboutAPIEntries()...)
+	group.Entries = append(group.Entries, s.metricsAPIEntries()...)
+	group.Entries = append(group.Entries, s.debugAPIEntries()...)
+	group.Entries = append(group.Entries, s.electionAPIEntries()...)
 
 	for _, fn := range appendAddonAPIs {
 		fn(s, group)
@@ -142,6 +147,17 @@ func (s *Server) aboutAPIEntries() []*Entry {
 	}
 }
 
+func (s *Server) metricsAPIEntries() []*Entry {
+	return []*Entry{
+		{
+			// Prometheus metrics of the member, e.g. rule matches of Mock.
+			Path:    "/metrics",
+			Method:  "GET",
+			Handler: promhttp.Handler().ServeHTTP,
+		},
+	}
+}
+
 func (s *Server) listAPIs(w http.ResponseWriter, r *http.Request) {
 	apisMutex.Lock()
 	defer apisMutex.Unlock()
@@ -158,6 +174,5 @@ func (s *Server) listAPIs(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		panic(fmt.Errorf("marshal %#v to yaml failed: %v", apiGroups, err))
 	}
-	w.Header().Set("Content-Type", "text/vnd.yaml")
-	w.Write(buff)
+	writeYAML(w, r, buff)
 }
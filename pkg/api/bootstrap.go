@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+// bootstrapObjects creates the objects in the initial objects directory
+// on the first boot of the cluster. It's idempotent: existing objects are
+// left untouched, and nothing happens once the cluster is bootstrapped.
+func (s *Server) bootstrapObjects() {
+	dir := s.opt.AbsInitialObjectsDir
+	if dir == "" {
+		return
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Errorf("bootstrap objects from %s failed: %v", dir, err)
+		}
+	}()
+
+	specs, err := s.readInitialObjects(dir)
+	if err != nil {
+		logger.Errorf("read initial objects from %s failed: %v", dir, err)
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	key := s.cluster.Layout().ConfigBootstrapped()
+	value, err := s.cluster.Get(key)
+	if err != nil {
+		ClusterPanic(err)
+	}
+	if value != nil {
+		logger.Infof("cluster bootstrapped at %s, ignore initial objects", *value)
+		return
+	}
+
+	created := 0
+	for _, spec := range specs {
+		if s._getObject(spec.Name()) != nil {
+			logger.Infof("initial object %s existed, skip it", spec.Name())
+			continue
+		}
+		s._putObject(spec)
+		created++
+	}
+	if created > 0 {
+		s._plusOneVersion()
+	}
+
+	err = s.cluster.Put(key, time.Now().Format(time.RFC3339))
+	if err != nil {
+		ClusterPanic(err)
+	}
+
+	logger.Infof("created %d initial objects from %s", created, dir)
+}
+
+// readInitialObjects reads all specs in the YAML files of the directory,
+// in the order of file names. A file could contain multiple specs
+// separated by "---".
+func (s *Server) readInitialObjects(dir string) ([]*supervisor.Spec, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	specs := []*supervisor.Spec{}
+	names := map[string]string{}
+	for _, file := range files {
+		ext := strings.ToLower(filepath.Ext(file.Name()))
+		if file.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+
+		path := filepath.Join(dir, file.Name())
+		buff, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(buff))
+		for {
+			var doc map[string]interface{}
+			err := decoder.Decode(&doc)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			if len(doc) == 0 {
+				continue
+			}
+
+			docBuff, err := yaml.Marshal(doc)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			spec, err := s.super.NewSpec(string(docBuff))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+
+			if prev, exists := names[spec.Name()]; exists {
+				return nil, fmt.Errorf("%s: object %s defined in %s too",
+					path, spec.Name(), prev)
+			}
+			names[spec.Name()] = path
+			specs = append(specs, spec)
+		}
+	}
+
+	return specs, nil
+}
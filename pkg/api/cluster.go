@@ -19,6 +19,7 @@ package api
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -116,6 +117,48 @@ func (s *Server) _deleteObject(name string) {
 	}
 }
 
+// _listReferrers returns the sorted names of objects referencing the object.
+func (s *Server) _listReferrers(name string) []string {
+	referrers := []string{}
+	for _, spec := range s._listObjects() {
+		for _, ref := range spec.References() {
+			if ref == name && spec.Name() != name {
+				referrers = append(referrers, spec.Name())
+				break
+			}
+		}
+	}
+	sort.Strings(referrers)
+
+	return referrers
+}
+
+// _cascadeDeleteObject deletes the object and all objects referencing it
+// directly or indirectly, it returns the names of deleted objects.
+func (s *Server) _cascadeDeleteObject(name string) []string {
+	deleted := []string{}
+	visited := map[string]bool{}
+
+	var deleteObject func(name string)
+	deleteObject = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+
+		// NOTE: Delete referrers first to never leave dangling references.
+		for _, referrer := range s._listReferrers(name) {
+			deleteObject(referrer)
+		}
+
+		s._deleteObject(name)
+		deleted = append(deleted, name)
+	}
+	deleteObject(name)
+
+	return deleted
+}
+
 func (s *Server) _getStatusObject(name string) map[string]string {
 	prefix := s.cluster.Layout().StatusObjectPrefix(name)
 	kvs, err := s.cluster.GetPrefix(prefix)
@@ -0,0 +1,56 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	yamljsontool "github.com/ghodss/yaml"
+)
+
+// acceptJSON returns true if the client prefers JSON to YAML.
+func acceptJSON(r *http.Request) bool {
+	return r != nil && strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// contentJSON returns true if the body of the request is in JSON.
+func contentJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// writeYAML writes the YAML document, it's converted to JSON
+// if the client prefers JSON.
+func writeYAML(w http.ResponseWriter, r *http.Request, buff []byte) {
+	if acceptJSON(r) {
+		jsonBuff, err := yamljsontool.YAMLToJSON(buff)
+		if err != nil {
+			panic(fmt.Errorf("convert %s to json failed: %v", buff, err))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonBuff)
+		return
+	}
+
+	// Reference: https://mailarchive.ietf.org/arch/msg/media-types/e9ZNC0hDXKXeFlAVRWxLCCaG9GI
+	w.Header().Set("Content-Type", "text/vnd.yaml")
+	w.Write(buff)
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/version"
+)
+
+const (
+	// DebugPrefix is the prefix of debug APIs.
+	DebugPrefix = "/debug"
+
+	defaultLogLines = 1000
+	maxLogLines     = 100000
+)
+
+type (
+	// VersionResp is the response of the version API.
+	VersionResp struct {
+		Release   string `yaml:"release"`
+		Repo      string `yaml:"repo"`
+		Commit    string `yaml:"commit"`
+		GoVersion string `yaml:"goVersion"`
+		OS        string `yaml:"os"`
+		Arch      string `yaml:"arch"`
+	}
+
+	// LogFile is the brief information of a log file.
+	LogFile struct {
+		Name string `yaml:"name"`
+		Size int64  `yaml:"size"`
+	}
+)
+
+func (s *Server) debugAPIEntries() []*Entry {
+	return []*Entry{
+		{
+			Path:    "/version",
+			Method:  "GET",
+			Handler: s.getVersion,
+		},
+		{
+			Path:    DebugPrefix + "/goroutines",
+			Method:  "GET",
+			Handler: s.dumpGoroutines,
+		},
+		{
+			Path:    DebugPrefix + "/logs",
+			Method:  "GET",
+			Handler: s.listLogs,
+		},
+		{
+			Path:    DebugPrefix + "/logs/{name}",
+			Method:  "GET",
+			Handler: s.tailLog,
+		},
+	}
+}
+
+func (s *Server) getVersion(w http.ResponseWriter, r *http.Request) {
+	resp := &VersionResp{
+		Release:   version.RELEASE,
+		Repo:      version.REPO,
+		Commit:    version.COMMIT,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+
+	buff, err := yaml.Marshal(resp)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", resp, err))
+	}
+	writeYAML(w, r, buff)
+}
+
+func (s *Server) dumpGoroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	// NOTE: Debug level 2 prints the full stacks in the same format
+	// as an unrecovered panic.
+	pprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+func (s *Server) listLogs(w http.ResponseWriter, r *http.Request) {
+	infos, err := ioutil.ReadDir(s.opt.AbsLogDir)
+	if err != nil {
+		HandleAPIError(w, r, http.StatusInternalServerError, fmt.Errorf("read log directory failed: %v", err))
+		return
+	}
+
+	files := []*LogFile{}
+	for _, info := range infos {
+		if info.Mode().IsRegular() && strings.HasSuffix(info.Name(), ".log") {
+			files = append(files, &LogFile{Name: info.Name(), Size: info.Size()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	buff, err := yaml.Marshal(files)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", files, err))
+	}
+	writeYAML(w, r, buff)
+}
+
+// tailLog writes the last lines of the log file, the number of lines is
+// specified by the query parameter lines.
+func (s *Server) tailLog(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if name != filepath.Base(name) || !strings.HasSuffix(name, ".log") {
+		HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("invalid log file name: %s", name))
+		return
+	}
+
+	lines := defaultLogLines
+	if v := r.URL.Query().Get("lines"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("invalid lines: %s", v))
+			return
+		}
+		lines = n
+	}
+	if lines > maxLogLines {
+		lines = maxLogLines
+	}
+
+	// Flush the cached logs, or the latest ones are missing.
+	logger.Sync()
+
+	f, err := os.Open(filepath.Join(s.opt.AbsLogDir, name))
+	if os.IsNotExist(err) {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("log file %s not found", name))
+		return
+	}
+	if err != nil {
+		HandleAPIError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+
+	ring := make([]string, lines)
+	count := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		ring[count%lines] = scanner.Text()
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		HandleAPIError(w, r, http.StatusInternalServerError, fmt.Errorf("read log file %s failed: %v", name, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	start := 0
+	if count > lines {
+		start = count - lines
+	}
+	for i := start; i < count; i++ {
+		fmt.Fprintln(w, ring[i%lines])
+	}
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/cluster"
+)
+
+const (
+	// ElectionPrefix is the prefix of election APIs.
+	ElectionPrefix = "/elections"
+
+	minLeaseTTL = time.Second
+)
+
+var electionNameRegexp = regexp.MustCompile(`^[A-Za-z0-9\-_\.~]{1,253}$`)
+
+// AcquireLeaseReq is the request to acquire or renew the lease of an
+// election.
+type AcquireLeaseReq struct {
+	Holder string `yaml:"holder"`
+	TTL    string `yaml:"ttl"`
+}
+
+func (s *Server) electionAPIEntries() []*Entry {
+	return []*Entry{
+		{
+			Path:    ElectionPrefix,
+			Method:  "GET",
+			Handler: s.listElections,
+		},
+		{
+			Path:    ElectionPrefix + "/{name}",
+			Method:  "GET",
+			Handler: s.getElection,
+		},
+		{
+			Path:    ElectionPrefix + "/{name}",
+			Method:  "PUT",
+			Handler: s.acquireLease,
+		},
+		{
+			Path:    ElectionPrefix + "/{name}",
+			Method:  "DELETE",
+			Handler: s.releaseLease,
+		},
+	}
+}
+
+func (s *Server) readElectionName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := chi.URLParam(r, "name")
+	if !electionNameRegexp.MatchString(name) {
+		HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("invalid election name: %s", name))
+		return "", false
+	}
+	return name, true
+}
+
+func writeElectionResp(w http.ResponseWriter, r *http.Request, v interface{}) {
+	buff, err := yaml.Marshal(v)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", v, err))
+	}
+	writeYAML(w, r, buff)
+}
+
+func (s *Server) listElections(w http.ResponseWriter, r *http.Request) {
+	leases, err := cluster.NewElections(s.cluster).List()
+	if err != nil {
+		ClusterPanic(err)
+	}
+	writeElectionResp(w, r, leases)
+}
+
+func (s *Server) getElection(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.readElectionName(w, r)
+	if !ok {
+		return
+	}
+
+	lease, err := cluster.NewElections(s.cluster).Get(name)
+	if err != nil {
+		ClusterPanic(err)
+	}
+	if lease == nil {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("election %s has no leader", name))
+		return
+	}
+	writeElectionResp(w, r, lease)
+}
+
+// acquireLease acquires or renews the lease of the election, it fails with
+// 409 if the lease is held by another holder.
+func (s *Server) acquireLease(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.readElectionName(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("read body failed: %v", err))
+		return
+	}
+
+	// NOTE: JSON is a subset of YAML.
+	req := &AcquireLeaseReq{}
+	err = yaml.Unmarshal(body, req)
+	if err != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("unmarshal request failed: %v", err))
+		return
+	}
+	if req.Holder == "" {
+		HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("holder is required"))
+		return
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil || ttl < minLeaseTTL {
+		HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("invalid ttl %q, it must be at least %s", req.TTL, minLeaseTTL))
+		return
+	}
+
+	lease, ok, err := cluster.NewElections(s.cluster).Acquire(name, req.Holder, ttl)
+	if err != nil {
+		ClusterPanic(err)
+	}
+	if !ok {
+		HandleAPIError(w, r, http.StatusConflict, fmt.Errorf("election %s is led by %s until %s",
+			name, lease.Holder, lease.ExpireAt.Format(time.RFC3339)))
+		return
+	}
+	writeElectionResp(w, r, lease)
+}
+
+// releaseLease releases the lease of the election held by the holder in
+// the query parameter holder, it fails with 409 if the lease is held by
+// another holder.
+func (s *Server) releaseLease(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.readElectionName(w, r)
+	if !ok {
+		return
+	}
+
+	holder := r.URL.Query().Get("holder")
+	if holder == "" {
+		HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("holder is required"))
+		return
+	}
+
+	ok, err := cluster.NewElections(s.cluster).Release(name, holder)
+	if err != nil {
+		ClusterPanic(err)
+	}
+	if !ok {
+		HandleAPIError(w, r, http.StatusConflict, fmt.Errorf("election %s isn't led by %s", name, holder))
+	}
+}
@@ -20,6 +20,7 @@ package api
 import (
 	"net/http"
 
+	yamljsontool "github.com/ghodss/yaml"
 	yaml "gopkg.in/yaml.v2"
 )
 
@@ -44,7 +45,6 @@ func ClusterPanic(err error) {
 
 // HandleAPIError handles api error.
 func HandleAPIError(w http.ResponseWriter, r *http.Request, code int, err error) {
-	w.WriteHeader(code)
 	buff, err := yaml.Marshal(Err{
 		Code:    code,
 		Message: err.Error(),
@@ -52,5 +52,15 @@ func HandleAPIError(w http.ResponseWriter, r *http.Request, code int, err error)
 	if err != nil {
 		panic(err)
 	}
+
+	if acceptJSON(r) {
+		buff, err = yamljsontool.YAMLToJSON(buff)
+		if err != nil {
+			panic(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	w.WriteHeader(code)
 	w.Write(buff)
 }
@@ -0,0 +1,418 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/api/adminpb"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+type (
+	// grpcServer serves the admin API over gRPC, it shares
+	// the same underlying operations with the REST API.
+	grpcServer struct {
+		adminpb.UnimplementedAdminServer
+
+		s      *Server
+		server *grpc.Server
+	}
+)
+
+func (s *Server) startGRPC() {
+	if s.opt.GRPCAPIAddr == "" {
+		return
+	}
+
+	listener, err := net.Listen("tcp", s.opt.GRPCAPIAddr)
+	if err != nil {
+		logger.Errorf("listen grpc api addr %s failed: %v", s.opt.GRPCAPIAddr, err)
+		return
+	}
+
+	gs := &grpcServer{s: s}
+	gs.server = grpc.NewServer(
+		grpc.UnaryInterceptor(grpcUnaryRecoverer),
+		grpc.StreamInterceptor(grpcStreamRecoverer),
+	)
+	adminpb.RegisterAdminServer(gs.server, gs)
+	s.grpc = gs
+
+	go func() {
+		logger.Infof("grpc api server running in %s", s.opt.GRPCAPIAddr)
+		gs.server.Serve(listener)
+	}()
+}
+
+func (s *Server) closeGRPC() {
+	if s.grpc == nil {
+		return
+	}
+	s.grpc.server.GracefulStop()
+}
+
+func recoverToGRPCError(method string, rvr interface{}) error {
+	logger.Errorf("recover from %s, err: %v, stack trace:\n%s\n",
+		method, rvr, debug.Stack())
+
+	if ce, ok := rvr.(clusterErr); ok {
+		return status.Error(codes.Unavailable, ce.Error())
+	}
+	return status.Errorf(codes.Internal, "%v", rvr)
+}
+
+func grpcUnaryRecoverer(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if rvr := recover(); rvr != nil {
+			resp, err = nil, recoverToGRPCError(info.FullMethod, rvr)
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+func grpcStreamRecoverer(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if rvr := recover(); rvr != nil {
+			err = recoverToGRPCError(info.FullMethod, rvr)
+		}
+	}()
+
+	return handler(srv, ss)
+}
+
+func specToPB(spec *supervisor.Spec) *adminpb.Object {
+	return &adminpb.Object{
+		Name: spec.Name(),
+		Kind: spec.Kind(),
+		Spec: spec.YAMLConfig(),
+		Etag: specETag(spec),
+	}
+}
+
+// newSpec creates the spec the same way as the REST API, the warnings of
+// the migrated deprecated fields are sent in the "warning" header.
+func (gs *grpcServer) newSpec(ctx context.Context, yamlSpec string) (*supervisor.Spec, error) {
+	spec, warnings, err := gs.s.newObjectSpec([]byte(yamlSpec))
+	if len(warnings) != 0 {
+		grpc.SetHeader(ctx, metadata.MD{"warning": warnings})
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return spec, nil
+}
+
+func (gs *grpcServer) ListObjectKinds(ctx context.Context,
+	req *adminpb.ListObjectKindsRequest) (*adminpb.ListObjectKindsResponse, error) {
+	return &adminpb.ListObjectKindsResponse{Kinds: supervisor.ObjectKinds()}, nil
+}
+
+func (gs *grpcServer) CreateObject(ctx context.Context,
+	req *adminpb.CreateObjectRequest) (*adminpb.ObjectResponse, error) {
+	spec, err := gs.newSpec(ctx, req.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	s := gs.s
+	s.Lock()
+	defer s.Unlock()
+
+	if s._getObject(spec.Name()) != nil {
+		return nil, status.Errorf(codes.AlreadyExists, "conflict name: %s", spec.Name())
+	}
+
+	s._putObject(spec)
+
+	return &adminpb.ObjectResponse{
+		ConfigVersion: s._plusOneVersion(),
+		Etag:          specETag(spec),
+	}, nil
+}
+
+func (gs *grpcServer) UpdateObject(ctx context.Context,
+	req *adminpb.UpdateObjectRequest) (*adminpb.ObjectResponse, error) {
+	spec, err := gs.newSpec(ctx, req.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	s := gs.s
+	s.Lock()
+	defer s.Unlock()
+
+	existedSpec := s._getObject(spec.Name())
+	if existedSpec == nil {
+		return nil, status.Errorf(codes.NotFound, "not found: %s", spec.Name())
+	}
+
+	if existedSpec.Kind() != spec.Kind() {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"different kinds: %s, %s", existedSpec.Kind(), spec.Kind())
+	}
+
+	if !etagMatch(req.Etag, existedSpec) {
+		return nil, status.Error(codes.FailedPrecondition,
+			"etag mismatch, the object has been changed")
+	}
+
+	s._putObject(spec)
+
+	return &adminpb.ObjectResponse{
+		ConfigVersion: s._plusOneVersion(),
+		Etag:          specETag(spec),
+	}, nil
+}
+
+func (gs *grpcServer) DeleteObject(ctx context.Context,
+	req *adminpb.DeleteObjectRequest) (*adminpb.ObjectResponse, error) {
+	s := gs.s
+	s.Lock()
+	defer s.Unlock()
+
+	spec := s._getObject(req.Name)
+	if spec == nil {
+		return nil, status.Errorf(codes.NotFound, "not found: %s", req.Name)
+	}
+
+	if !etagMatch(req.Etag, spec) {
+		return nil, status.Error(codes.FailedPrecondition,
+			"etag mismatch, the object has been changed")
+	}
+
+	if req.Cascade {
+		deleted := s._cascadeDeleteObject(req.Name)
+		return &adminpb.ObjectResponse{
+			ConfigVersion:  s._plusOneVersion(),
+			DeletedObjects: deleted,
+		}, nil
+	}
+
+	if referrers := s._listReferrers(req.Name); len(referrers) != 0 {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"referenced by objects: %s", strings.Join(referrers, ", "))
+	}
+
+	s._deleteObject(req.Name)
+
+	return &adminpb.ObjectResponse{ConfigVersion: s._plusOneVersion()}, nil
+}
+
+func (gs *grpcServer) GetObject(ctx context.Context,
+	req *adminpb.GetObjectRequest) (*adminpb.Object, error) {
+	spec := gs.s._getObject(req.Name)
+	if spec == nil {
+		return nil, status.Errorf(codes.NotFound, "not found: %s", req.Name)
+	}
+
+	return specToPB(spec), nil
+}
+
+func (gs *grpcServer) ListObjects(ctx context.Context,
+	req *adminpb.ListObjectsRequest) (*adminpb.ListObjectsResponse, error) {
+	specs := specList(gs.s._listObjects())
+	sort.Sort(specs)
+
+	resp := &adminpb.ListObjectsResponse{}
+	for _, spec := range specs {
+		if req.Kind != "" && spec.Kind() != req.Kind {
+			continue
+		}
+		resp.Objects = append(resp.Objects, specToPB(spec))
+	}
+
+	return resp, nil
+}
+
+// WatchObjects sends all existing objects as PUT events first,
+// then streams the following changes.
+func (gs *grpcServer) WatchObjects(req *adminpb.WatchObjectsRequest,
+	stream adminpb.Admin_WatchObjectsServer) error {
+	s := gs.s
+
+	watcher, err := s.cluster.Watcher()
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	defer watcher.Close()
+
+	prefix := s.cluster.Layout().ConfigObjectPrefix()
+	ch, err := watcher.WatchPrefix(prefix)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	// NOTE: Kinds of objects are recorded to filter DELETE events.
+	kinds := make(map[string]string)
+	for _, spec := range s._listObjects() {
+		kinds[spec.Name()] = spec.Kind()
+		if req.Kind != "" && spec.Kind() != req.Kind {
+			continue
+		}
+		err := stream.Send(&adminpb.WatchObjectsEvent{
+			Type:   adminpb.WatchObjectsEvent_PUT,
+			Object: specToPB(spec),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case kvs, ok := <-ch:
+			if !ok {
+				return status.Error(codes.Unavailable, "watch canceled")
+			}
+			for key, value := range kvs {
+				event := gs.watchEvent(strings.TrimPrefix(key, prefix), value, kinds)
+				if event == nil {
+					continue
+				}
+				if req.Kind != "" && kinds[event.Object.Name] != req.Kind {
+					continue
+				}
+				if event.Type == adminpb.WatchObjectsEvent_DELETE {
+					delete(kinds, event.Object.Name)
+				}
+				if err := stream.Send(event); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (gs *grpcServer) watchEvent(name string, value *string,
+	kinds map[string]string) *adminpb.WatchObjectsEvent {
+	if value == nil {
+		return &adminpb.WatchObjectsEvent{
+			Type:   adminpb.WatchObjectsEvent_DELETE,
+			Object: &adminpb.Object{Name: name, Kind: kinds[name]},
+		}
+	}
+
+	spec, err := gs.s.super.NewSpec(*value)
+	if err != nil {
+		logger.Errorf("BUG: bad spec(err: %v) from yaml: %s", err, *value)
+		return nil
+	}
+	kinds[name] = spec.Kind()
+
+	return &adminpb.WatchObjectsEvent{
+		Type:   adminpb.WatchObjectsEvent_PUT,
+		Object: specToPB(spec),
+	}
+}
+
+func (gs *grpcServer) GetObjectStatus(ctx context.Context,
+	req *adminpb.GetObjectStatusRequest) (*adminpb.ObjectStatus, error) {
+	if gs.s._getObject(req.Name) == nil {
+		return nil, status.Errorf(codes.NotFound, "not found: %s", req.Name)
+	}
+
+	return &adminpb.ObjectStatus{
+		Name:    req.Name,
+		Members: gs.s._getStatusObject(req.Name),
+	}, nil
+}
+
+func (gs *grpcServer) ListObjectStatuses(ctx context.Context,
+	req *adminpb.ListObjectStatusesRequest) (*adminpb.ListObjectStatusesResponse, error) {
+	statuses := gs.s._listStatusObjects()
+
+	names := make([]string, 0, len(statuses))
+	for name := range statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	resp := &adminpb.ListObjectStatusesResponse{}
+	for _, name := range names {
+		objectStatus := &adminpb.ObjectStatus{
+			Name:    name,
+			Members: make(map[string]string),
+		}
+		for member, memberStatus := range statuses[name] {
+			buff, err := yaml.Marshal(memberStatus)
+			if err != nil {
+				panic(fmt.Errorf("marshal %#v to yaml failed: %v", memberStatus, err))
+			}
+			objectStatus.Members[member] = string(buff)
+		}
+		resp.Statuses = append(resp.Statuses, objectStatus)
+	}
+
+	return resp, nil
+}
+
+func (gs *grpcServer) ListMembers(ctx context.Context,
+	req *adminpb.ListMembersRequest) (*adminpb.ListMembersResponse, error) {
+	members := gs.s._listMembers()
+
+	resp := &adminpb.ListMembersResponse{}
+	for _, member := range members {
+		buff, err := yaml.Marshal(member)
+		if err != nil {
+			panic(fmt.Errorf("marshal %#v to yaml failed: %v", member, err))
+		}
+		resp.Members = append(resp.Members, &adminpb.Member{
+			Name:   member.Options.Name,
+			Status: string(buff),
+		})
+	}
+
+	return resp, nil
+}
+
+func (gs *grpcServer) PurgeMember(ctx context.Context,
+	req *adminpb.PurgeMemberRequest) (*adminpb.PurgeMemberResponse, error) {
+	s := gs.s
+	s.Lock()
+	defer s.Unlock()
+
+	leaseStr, err := s.cluster.Get(s.cluster.Layout().OtherLease(req.Name))
+	if err != nil {
+		ClusterPanic(err)
+	}
+	if leaseStr == nil {
+		return nil, status.Errorf(codes.NotFound, "not found: %s", req.Name)
+	}
+
+	s._purgeMember(req.Name)
+
+	return &adminpb.PurgeMemberResponse{}, nil
+}
@@ -54,7 +54,7 @@ func (r ListMembersResp) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
 
 // These methods which operate with cluster guarantee atomicity.
 
-func (s *Server) listMembers(w http.ResponseWriter, r *http.Request) {
+func (s *Server) _listMembers() ListMembersResp {
 	kv, err := s.cluster.GetPrefix(s.cluster.Layout().StatusMemberPrefix())
 	if err != nil {
 		ClusterPanic(err)
@@ -73,12 +73,18 @@ func (s *Server) listMembers(w http.ResponseWriter, r *http.Request) {
 
 	sort.Sort(resp)
 
+	return resp
+}
+
+func (s *Server) listMembers(w http.ResponseWriter, r *http.Request) {
+	resp := s._listMembers()
+
 	buff, err := yaml.Marshal(resp)
 	if err != nil {
 		panic(fmt.Errorf("marshal %#v to yaml failed: %v", resp, err))
 	}
 
-	w.Write(buff)
+	writeYAML(w, r, buff)
 }
 
 func (s *Server) purgeMember(w http.ResponseWriter, r *http.Request) {
@@ -18,15 +18,19 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"reflect"
 	"sort"
 
+	yamljsontool "github.com/ghodss/yaml"
 	"github.com/go-chi/chi/v5"
 	yaml "gopkg.in/yaml.v2"
 
+	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/supervisor"
 	"github.com/megaease/easegress/pkg/v"
 )
 
@@ -49,16 +53,35 @@ type (
 		SpecType    reflect.Type
 		Description string
 	}
+
+	// KindMeta is the discoverable metadata of an object kind or a filter kind.
+	KindMeta struct {
+		Kind        string   `yaml:"kind" json:"kind"`
+		Category    string   `yaml:"category,omitempty" json:"category,omitempty"`
+		Description string   `yaml:"description,omitempty" json:"description,omitempty"`
+		Results     []string `yaml:"results,omitempty" json:"results,omitempty"`
+		// Schema is the JSON schema of the spec.
+		Schema interface{} `yaml:"schema" json:"schema"`
+		// Defaults is the default spec.
+		Defaults interface{} `yaml:"defaults" json:"defaults"`
+	}
 )
 
 var (
 	filterMetaBook = map[string]*FilterMeta{}
 	filterKinds    []string
+
+	objectKindMetas = map[string]*KindMeta{}
+	objectKinds     []string
+	filterKindMetas = map[string]*KindMeta{}
 )
 
 func (s *Server) initMetadata() {
 	filterRegistry := httppipeline.GetFilterRegistry()
 	for kind, f := range filterRegistry {
+		if _, exists := filterMetaBook[kind]; exists {
+			continue
+		}
 		filterMetaBook[kind] = &FilterMeta{
 			Kind:        kind,
 			Results:     f.Results(),
@@ -67,18 +90,98 @@ func (s *Server) initMetadata() {
 		}
 		filterKinds = append(filterKinds, kind)
 		sort.Strings(filterMetaBook[kind].Results)
+
+		filterKindMetas[kind] = &KindMeta{
+			Kind:        kind,
+			Description: f.Description(),
+			Results:     filterMetaBook[kind].Results,
+			Schema:      kindSchema(kind, f.DefaultSpec()),
+			Defaults:    kindDefaults(kind, f.DefaultSpec()),
+		}
 	}
 	sort.Strings(filterKinds)
 
+	for kind, o := range supervisor.GetObjectRegistry() {
+		if _, exists := objectKindMetas[kind]; exists {
+			continue
+		}
+		objectKindMetas[kind] = &KindMeta{
+			Kind:     kind,
+			Category: string(o.Category()),
+			Schema:   kindSchema(kind, o.DefaultSpec()),
+			Defaults: kindDefaults(kind, o.DefaultSpec()),
+		}
+		objectKinds = append(objectKinds, kind)
+	}
+	sort.Strings(objectKinds)
+}
+
+// kindSchema returns the JSON schema of the spec as a generic value,
+// which could be marshaled to both YAML and JSON.
+func kindSchema(kind string, spec interface{}) interface{} {
+	buff, err := v.GetSchemaInJSON(reflect.TypeOf(spec))
+	if err != nil {
+		logger.Errorf("get schema of %s failed: %v", kind, err)
+		return nil
+	}
+
+	var schema interface{}
+	err = json.Unmarshal(buff, &schema)
+	if err != nil {
+		logger.Errorf("unmarshal schema of %s failed: %v", kind, err)
+		return nil
+	}
+
+	return schema
+}
+
+// kindDefaults returns the default spec as a generic value,
+// which could be marshaled to both YAML and JSON.
+func kindDefaults(kind string, spec interface{}) interface{} {
+	buff, err := yaml.Marshal(spec)
+	if err != nil {
+		logger.Errorf("marshal default spec of %s failed: %v", kind, err)
+		return nil
+	}
+
+	buff, err = yamljsontool.YAMLToJSON(buff)
+	if err != nil {
+		logger.Errorf("convert default spec of %s to json failed: %v", kind, err)
+		return nil
+	}
+
+	var defaults interface{}
+	err = json.Unmarshal(buff, &defaults)
+	if err != nil {
+		logger.Errorf("unmarshal default spec of %s failed: %v", kind, err)
+		return nil
+	}
+
+	return defaults
 }
 
 func (s *Server) metadataAPIEntries() []*Entry {
 	return []*Entry{
+		{
+			Path:    ObjectMetadataPrefix,
+			Method:  "GET",
+			Handler: s.listObjectMetas,
+		},
+		{
+			Path:    ObjectMetadataPrefix + "/{kind}",
+			Method:  "GET",
+			Handler: s.getObjectMeta,
+		},
 		{
 			Path:    FilterMetaPrefix,
 			Method:  "GET",
 			Handler: s.listFilters,
 		},
+		{
+			Path:    FilterMetaPrefix + "/{kind}",
+			Method:  "GET",
+			Handler: s.getFilterMeta,
+		},
 		{
 			Path:    FilterMetaPrefix + "/{kind}" + "/description",
 			Method:  "GET",
@@ -97,14 +200,60 @@ func (s *Server) metadataAPIEntries() []*Entry {
 	}
 }
 
-func (s *Server) listFilters(w http.ResponseWriter, r *http.Request) {
-	buff, err := yaml.Marshal(filterKinds)
+// writeMeta writes the metadata in the format preferred by the client.
+func writeMeta(w http.ResponseWriter, r *http.Request, meta interface{}) {
+	buff, err := yaml.Marshal(meta)
 	if err != nil {
-		panic(fmt.Errorf("marshal %#v to yaml failed: %v", filterKinds, err))
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", meta, err))
+	}
+	writeYAML(w, r, buff)
+}
+
+// listKindMetas lists the kinds, or the complete metadata
+// of the kinds if the query detail is true.
+func listKindMetas(w http.ResponseWriter, r *http.Request, kinds []string, metas map[string]*KindMeta) {
+	if r.URL.Query().Get("detail") != "true" {
+		writeMeta(w, r, kinds)
+		return
+	}
+
+	result := make([]*KindMeta, 0, len(kinds))
+	for _, kind := range kinds {
+		result = append(result, metas[kind])
+	}
+	writeMeta(w, r, result)
+}
+
+func (s *Server) listObjectMetas(w http.ResponseWriter, r *http.Request) {
+	listKindMetas(w, r, objectKinds, objectKindMetas)
+}
+
+func (s *Server) getObjectMeta(w http.ResponseWriter, r *http.Request) {
+	kind := chi.URLParam(r, "kind")
+
+	meta, exists := objectKindMetas[kind]
+	if !exists {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	writeMeta(w, r, meta)
+}
+
+func (s *Server) listFilters(w http.ResponseWriter, r *http.Request) {
+	listKindMetas(w, r, filterKinds, filterKindMetas)
+}
+
+func (s *Server) getFilterMeta(w http.ResponseWriter, r *http.Request) {
+	kind := chi.URLParam(r, "kind")
+
+	meta, exists := filterKindMetas[kind]
+	if !exists {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
 	}
 
-	w.Header().Set("Content-Type", "text/vnd.yaml")
-	w.Write(buff)
+	writeMeta(w, r, meta)
 }
 
 func (s *Server) getFilterDescription(w http.ResponseWriter, r *http.Request) {
@@ -132,8 +281,7 @@ func (s *Server) getFilterSchema(w http.ResponseWriter, r *http.Request) {
 		panic(fmt.Errorf("get schema for %v failed: %v", fm.Kind, err))
 	}
 
-	w.Header().Set("Content-Type", "text/vnd.yaml")
-	w.Write(buff)
+	writeYAML(w, r, buff)
 }
 
 func (s *Server) getFilterResults(w http.ResponseWriter, r *http.Request) {
@@ -150,6 +298,5 @@ func (s *Server) getFilterResults(w http.ResponseWriter, r *http.Request) {
 		panic(fmt.Errorf("marshal %#v to yaml failed: %v", fm.Results, err))
 	}
 
-	w.Header().Set("Content-Type", "text/vnd.yaml")
-	w.Write(buff)
+	writeYAML(w, r, buff)
 }
@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/filter/mock"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newMetadataRouter() *chi.Mux {
+	s := &Server{}
+	s.initMetadata()
+
+	router := chi.NewRouter()
+	for _, entry := range s.metadataAPIEntries() {
+		router.MethodFunc(entry.Method, APIPrefix+entry.Path, entry.Handler)
+	}
+	return router
+}
+
+func getMetadata(router http.Handler, path string, jsonFormat bool) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, APIPrefix+path, nil)
+	if jsonFormat {
+		r.Header.Set("Accept", "application/json")
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestMetadataNotFound(t *testing.T) {
+	router := newMetadataRouter()
+
+	for _, path := range []string{
+		ObjectMetadataPrefix + "/NoSuchKind",
+		FilterMetaPrefix + "/NoSuchKind",
+		FilterMetaPrefix + "/NoSuchKind/description",
+		FilterMetaPrefix + "/NoSuchKind/schema",
+		FilterMetaPrefix + "/NoSuchKind/results",
+		// NOTE: Object kinds and filter kinds are not mixed up.
+		ObjectMetadataPrefix + "/" + mock.Kind,
+		FilterMetaPrefix + "/" + httppipeline.Kind,
+	} {
+		w := getMetadata(router, path, false)
+		e := &Err{}
+		if err := yaml.Unmarshal(w.Body.Bytes(), e); err != nil {
+			t.Fatalf("%s: unmarshal error failed: %v", path, err)
+		}
+		if w.Code != http.StatusNotFound || e.Code != http.StatusNotFound || e.Message != "not found" {
+			t.Errorf("%s: expect 404 not found, got %d %+v", path, w.Code, e)
+		}
+
+		w = getMetadata(router, path, true)
+		jsonErr := map[string]interface{}{}
+		if err := json.Unmarshal(w.Body.Bytes(), &jsonErr); err != nil {
+			t.Fatalf("%s: unmarshal json error failed: %v", path, err)
+		}
+		if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != "application/json" ||
+			jsonErr["code"] != float64(http.StatusNotFound) || jsonErr["message"] != "not found" {
+			t.Errorf("%s: expect 404 in json, got %d %s", path, w.Code, w.Body)
+		}
+	}
+}
+
+func TestMetadataFound(t *testing.T) {
+	router := newMetadataRouter()
+
+	w := getMetadata(router, FilterMetaPrefix+"/"+mock.Kind, true)
+	meta := &KindMeta{}
+	if err := json.Unmarshal(w.Body.Bytes(), meta); err != nil {
+		t.Fatalf("unmarshal meta failed: %v", err)
+	}
+	if w.Code != http.StatusOK || meta.Kind != mock.Kind || meta.Schema == nil || meta.Defaults == nil ||
+		len(meta.Results) != 1 || meta.Results[0] != "mocked" {
+		t.Errorf("unexpected meta %d %+v", w.Code, meta)
+	}
+
+	w = getMetadata(router, ObjectMetadataPrefix+"/"+httppipeline.Kind, false)
+	meta = &KindMeta{}
+	if err := yaml.Unmarshal(w.Body.Bytes(), meta); err != nil {
+		t.Fatalf("unmarshal meta failed: %v", err)
+	}
+	if w.Code != http.StatusOK || meta.Kind != httppipeline.Kind || meta.Category == "" || meta.Schema == nil {
+		t.Errorf("unexpected meta %d %+v", w.Code, meta)
+	}
+
+	// The kinds are listed by default, and the metadata with detail.
+	w = getMetadata(router, FilterMetaPrefix, true)
+	kinds := []string{}
+	if err := json.Unmarshal(w.Body.Bytes(), &kinds); err != nil || len(kinds) == 0 {
+		t.Fatalf("unexpected kinds %s: %v", w.Body, err)
+	}
+	w = getMetadata(router, FilterMetaPrefix+"?detail=true", true)
+	metas := []*KindMeta{}
+	if err := json.Unmarshal(w.Body.Bytes(), &metas); err != nil || len(metas) != len(kinds) {
+		t.Fatalf("unexpected metas %s: %v", w.Body, err)
+	}
+	for i, meta := range metas {
+		if meta.Kind != kinds[i] {
+			t.Errorf("meta %d: expect kind %s, got %s", i, kinds[i], meta.Kind)
+		}
+	}
+}
+
+type unsupportedSpec struct {
+	Name string `yaml:"name" jsonschema:"pattern=["`
+}
+
+func (spec *unsupportedSpec) MarshalYAML() (interface{}, error) {
+	return nil, fmt.Errorf("unsupported")
+}
+
+func TestKindSchemaAndDefaultsFailure(t *testing.T) {
+	// NOTE: The failures are logged, and the metadata is served
+	// without the schema or the defaults.
+	if defaults := kindDefaults("Unsupported", &unsupportedSpec{}); defaults != nil {
+		t.Errorf("defaults of unsupported spec should be nil, got %v", defaults)
+	}
+	if schema := kindSchema("Unsupported", &unsupportedSpec{}); schema != nil {
+		t.Errorf("schema of unsupported spec should be nil, got %v", schema)
+	}
+
+	if defaults := kindDefaults(mock.Kind, &mock.Spec{}); defaults == nil {
+		t.Errorf("defaults of mock should not be nil")
+	}
+	if schema := kindSchema(mock.Kind, &mock.Spec{}); schema == nil {
+		t.Errorf("schema of mock should not be nil")
+	}
+}
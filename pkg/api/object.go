@@ -18,14 +18,21 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"sort"
+	"strings"
 
+	yamljsontool "github.com/ghodss/yaml"
 	"github.com/go-chi/chi/v5"
 	yaml "gopkg.in/yaml.v2"
 
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/migration"
 	"github.com/megaease/easegress/pkg/supervisor"
 )
 
@@ -91,7 +98,17 @@ func (s *Server) readObjectSpec(w http.ResponseWriter, r *http.Request) (*superv
 		return nil, fmt.Errorf("read body failed: %v", err)
 	}
 
-	spec, err := s.super.NewSpec(string(body))
+	if contentJSON(r) {
+		body, err = yamljsontool.JSONToYAML(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid json: %v", err)
+		}
+	}
+
+	spec, warnings, err := s.newObjectSpec(body)
+	for _, warning := range warnings {
+		w.Header().Add("Warning", fmt.Sprintf("299 - %q", warning))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -104,6 +121,26 @@ func (s *Server) readObjectSpec(w http.ResponseWriter, r *http.Request) (*superv
 	return spec, err
 }
 
+// newObjectSpec creates the spec of an object from YAML, it's shared by
+// the REST and gRPC APIs.
+func (s *Server) newObjectSpec(body []byte) (*supervisor.Spec, []string, error) {
+	// NOTE: Specs with deprecated fields are migrated to the current schema
+	// during the deprecation window, the clients are warned to upgrade them.
+	body, warnings, err := migration.MigrateYAML(body, migration.Versions[0], migration.Latest)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, warning := range warnings {
+		logger.Warnf("%s", warning)
+	}
+
+	spec, err := s.super.NewSpec(string(body))
+	if err != nil {
+		return nil, warnings, err
+	}
+	return spec, warnings, nil
+}
+
 func (s *Server) upgradeConfigVersion(w http.ResponseWriter, r *http.Request) {
 	version := s._plusOneVersion()
 	w.Header().Set(ConfigVersionKey, fmt.Sprintf("%d", version))
@@ -130,9 +167,10 @@ func (s *Server) createObject(w http.ResponseWriter, r *http.Request) {
 	s._putObject(spec)
 	s.upgradeConfigVersion(w, r)
 
-	w.WriteHeader(http.StatusCreated)
 	location := fmt.Sprintf("%s/%s", r.URL.Path, name)
 	w.Header().Set("Location", location)
+	w.Header().Set("ETag", specETag(spec))
+	w.WriteHeader(http.StatusCreated)
 }
 
 func (s *Server) deleteObject(w http.ResponseWriter, r *http.Request) {
@@ -147,6 +185,31 @@ func (s *Server) deleteObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !ifMatch(r, spec) {
+		HandleAPIError(w, r, http.StatusPreconditionFailed,
+			fmt.Errorf("etag mismatch, the object has been changed"))
+		return
+	}
+
+	if r.URL.Query().Get("cascade") == "true" {
+		deleted := s._cascadeDeleteObject(name)
+		s.upgradeConfigVersion(w, r)
+
+		buff, err := yaml.Marshal(deleted)
+		if err != nil {
+			panic(fmt.Errorf("marshal %#v to yaml failed: %v", deleted, err))
+		}
+		writeYAML(w, r, buff)
+		return
+	}
+
+	if referrers := s._listReferrers(name); len(referrers) != 0 {
+		HandleAPIError(w, r, http.StatusConflict,
+			fmt.Errorf("referenced by objects: %s (delete with cascade=true to delete them too)",
+				strings.Join(referrers, ", ")))
+		return
+	}
+
 	s._deleteObject(name)
 	s.upgradeConfigVersion(w, r)
 }
@@ -162,10 +225,20 @@ func (s *Server) getObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Reference: https://mailarchive.ietf.org/arch/msg/media-types/e9ZNC0hDXKXeFlAVRWxLCCaG9GI
-	w.Header().Set("Content-Type", "text/vnd.yaml")
+	etag := specETag(spec)
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 
-	w.Write([]byte(spec.YAMLConfig()))
+	if acceptJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(specJSON(spec))
+		return
+	}
+
+	writeYAML(w, r, []byte(spec.YAMLConfig()))
 }
 
 func (s *Server) updateObject(w http.ResponseWriter, r *http.Request) {
@@ -193,6 +266,21 @@ func (s *Server) updateObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !ifMatch(r, existedSpec) {
+		HandleAPIError(w, r, http.StatusPreconditionFailed,
+			fmt.Errorf("etag mismatch, the object has been changed"))
+		return
+	}
+
+	etag := specETag(spec)
+	w.Header().Set("ETag", etag)
+
+	// NOTE: Applying the same spec again is a no-op,
+	// so that external reconcilers could apply repeatedly.
+	if etag == specETag(existedSpec) {
+		return
+	}
+
 	s._putObject(spec)
 	s.upgradeConfigVersion(w, r)
 }
@@ -204,14 +292,18 @@ func (s *Server) listObjects(w http.ResponseWriter, r *http.Request) {
 	// NOTE: Keep it consistent.
 	sort.Sort(specs)
 
+	if acceptJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(specs.JSON())
+		return
+	}
+
 	buff, err := specs.Marshal()
 	if err != nil {
 		panic(err)
 	}
 
-	w.Header().Set("Content-Type", "text/vnd.yaml")
-
-	w.Write(buff)
+	writeYAML(w, r, buff)
 }
 
 func (s *Server) getStatusObject(w http.ResponseWriter, r *http.Request) {
@@ -232,9 +324,7 @@ func (s *Server) getStatusObject(w http.ResponseWriter, r *http.Request) {
 		panic(fmt.Errorf("marshal %#v to yaml failed: %v", status, err))
 	}
 
-	w.Header().Set("Content-Type", "text/vnd.yaml")
-
-	w.Write(buff)
+	writeYAML(w, r, buff)
 }
 
 func (s *Server) listStatusObjects(w http.ResponseWriter, r *http.Request) {
@@ -247,9 +337,7 @@ func (s *Server) listStatusObjects(w http.ResponseWriter, r *http.Request) {
 		panic(fmt.Errorf("marshal %#v to yaml failed: %v", status, err))
 	}
 
-	w.Header().Set("Content-Type", "text/vnd.yaml")
-
-	w.Write(buff)
+	writeYAML(w, r, buff)
 }
 
 type specList []*supervisor.Spec
@@ -277,6 +365,75 @@ func (s specList) Marshal() ([]byte, error) {
 	return buff, nil
 }
 
+// JSON marshals the specs to the stable JSON representation.
+func (s specList) JSON() []byte {
+	specs := make([]json.RawMessage, 0, len(s))
+	for _, spec := range s {
+		specs = append(specs, specJSON(spec))
+	}
+
+	buff, err := json.Marshal(specs)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to json failed: %v", specs, err))
+	}
+
+	return buff
+}
+
+// specJSON returns the stable JSON representation of the spec,
+// in which the keys of objects are sorted.
+func specJSON(spec *supervisor.Spec) []byte {
+	buff, err := yamljsontool.YAMLToJSON([]byte(spec.YAMLConfig()))
+	if err != nil {
+		panic(fmt.Errorf("convert %s to json failed: %v", spec.YAMLConfig(), err))
+	}
+
+	var v interface{}
+	err = json.Unmarshal(buff, &v)
+	if err != nil {
+		panic(fmt.Errorf("unmarshal %s to json failed: %v", buff, err))
+	}
+
+	// NOTE: encoding/json sorts the keys of maps.
+	buff, err = json.Marshal(v)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to json failed: %v", v, err))
+	}
+
+	return buff
+}
+
+// specETag returns the strong ETag of the spec, which only changes
+// when the content of the spec changes.
+func specETag(spec *supervisor.Spec) string {
+	sum := sha256.Sum256(specJSON(spec))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// ifMatch checks the If-Match header against the spec,
+// it returns true if the header is absent.
+func ifMatch(r *http.Request, spec *supervisor.Spec) bool {
+	return etagMatch(r.Header.Get("If-Match"), spec)
+}
+
+// etagMatch checks the ETags in the format of the If-Match header
+// against the spec, it returns true if there are none.
+func etagMatch(header string, spec *supervisor.Spec) bool {
+	if header == "" {
+		return true
+	}
+
+	etag := specETag(spec)
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimSpace(value)
+		if value == "*" || value == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (s *Server) listObjectKinds(w http.ResponseWriter, r *http.Request) {
 	kinds := supervisor.ObjectKinds()
 	buff, err := yaml.Marshal(kinds)
@@ -284,5 +441,5 @@ func (s *Server) listObjectKinds(w http.ResponseWriter, r *http.Request) {
 		panic(fmt.Errorf("marshal %#v to yaml failed: %v", kinds, err))
 	}
 
-	w.Write(buff)
+	writeYAML(w, r, buff)
 }
@@ -19,7 +19,10 @@ package api
 
 import (
 	"context"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -35,6 +38,7 @@ type (
 		opt     *option.Options
 		server  http.Server
 		router  *dynamicMux
+		grpc    *grpcServer
 		cluster cluster.Cluster
 		super   *supervisor.Supervisor
 
@@ -73,12 +77,19 @@ func MustNewServer(opt *option.Options, cluster cluster.Cluster, super *supervis
 
 	s.initMetadata()
 	s.registerAPIs()
+	s.bootstrapObjects()
 
 	go func() {
 		logger.Infof("api server running in %s", opt.APIAddr)
 		s.server.ListenAndServe()
 	}()
 
+	if opt.AbsAPISocket != "" {
+		s.serveUnixSocket()
+	}
+
+	s.startGRPC()
+
 	return s
 }
 
@@ -93,11 +104,69 @@ func (s *Server) Close(wg *sync.WaitGroup) {
 		logger.Errorf("gracefully shutdown the server failed: %v", err)
 	}
 
+	s.closeGRPC()
 	s.router.close()
 
 	logger.Infof("server stopped")
 }
 
+// serveUnixSocket serves the admin API on the Unix domain socket as well,
+// local administration is protected by the file permissions of it.
+func (s *Server) serveUnixSocket() {
+	path, mode := s.opt.AbsAPISocket, os.FileMode(s.opt.APISocketFileMode)
+
+	// NOTE: The socket file left by the last process must be removed,
+	// or the listening fails.
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0o750)
+	if err != nil {
+		logger.Errorf("create directory of api socket %s failed: %v", path, err)
+		return
+	}
+
+	// NOTE: The socket is created in a private directory and moved to the
+	// path after its mode is set, so it's never reachable with the looser
+	// permissions of the umask.
+	tmpDir, err := os.MkdirTemp(filepath.Dir(path), ".api-socket-")
+	if err != nil {
+		logger.Errorf("create temporary directory of api socket %s failed: %v", path, err)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	tmpPath := filepath.Join(tmpDir, filepath.Base(path))
+
+	l, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		logger.Errorf("listen on api socket %s failed: %v", path, err)
+		return
+	}
+	// The socket file is removed after serving, as it's moved.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	err = os.Chmod(tmpPath, mode)
+	if err != nil {
+		logger.Errorf("chmod api socket %s to %o failed: %v", path, mode, err)
+		l.Close()
+		return
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		logger.Errorf("move api socket to %s failed: %v", path, err)
+		l.Close()
+		return
+	}
+
+	go func() {
+		logger.Infof("api server running in unix://%s", path)
+		s.server.Serve(l)
+		os.Remove(path)
+	}()
+}
+
 func (s *Server) getMutex() (cluster.Mutex, error) {
 	s.mutexMutex.Lock()
 	defer s.mutexMutex.Unlock()
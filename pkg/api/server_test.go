@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/option"
+)
+
+func TestServeUnixSocket(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api.sock")
+
+	s := &Server{opt: option.New()}
+	s.opt.AbsAPISocket = path
+	s.opt.APISocketFileMode = 0o600
+	s.server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	s.serveUnixSocket()
+
+	fi, err := os.Lstat(path)
+	if err != nil {
+		t.Fatalf("api socket is not created: %v", err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0o600 {
+		t.Errorf("unexpected mode of api socket: %v", fi.Mode())
+	}
+	// NOTE: Only the socket is left, the private directory is removed.
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("unexpected files in the directory: %d", len(entries))
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://unix/")
+	if err != nil {
+		t.Fatalf("request to api socket failed: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Errorf("unexpected body %s", body)
+	}
+
+	s.server.Shutdown(context.Background())
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("api socket is not removed after shutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,233 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package client is the Go SDK of the admin API of Easegress.
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	apiPrefix = "/apis/v1"
+
+	healthPath            = apiPrefix + "/healthz"
+	objectKindsPath       = apiPrefix + "/object-kinds"
+	objectsPath           = apiPrefix + "/objects"
+	objectPathFormat      = apiPrefix + "/objects/%s"
+	statusObjectsPath     = apiPrefix + "/status/objects"
+	statusObjectFormat    = apiPrefix + "/status/objects/%s"
+	membersPath           = apiPrefix + "/status/members"
+	memberPathFormat      = apiPrefix + "/status/members/%s"
+	configVersionKey      = "X-Config-Version"
+	defaultTimeout        = 30 * time.Second
+	defaultMaxRetries     = 3
+	defaultRetryBaseDelay = 200 * time.Millisecond
+)
+
+type (
+	// Client is the client of the admin API.
+	Client struct {
+		server         string
+		httpClient     *http.Client
+		maxRetries     int
+		retryBaseDelay time.Duration
+		headers        http.Header
+	}
+
+	// Option is the option to create a Client.
+	Option func(c *Client)
+
+	// APIError is the error returned by the admin API.
+	APIError struct {
+		Code    int    `yaml:"code"`
+		Message string `yaml:"message"`
+	}
+)
+
+// WithHTTPClient sets the underlying HTTP client.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetry sets the max retries and the base delay of exponential backoff.
+// Requests are retried on network errors and 503 responses.
+func WithRetry(maxRetries int, baseDelay time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries, c.retryBaseDelay = maxRetries, baseDelay
+	}
+}
+
+// WithBasicAuth sets the basic authorization for every request.
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(username, password)
+		c.headers.Set("Authorization", req.Header.Get("Authorization"))
+	}
+}
+
+// WithBearerToken sets the bearer token for every request.
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.headers.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// WithHeader sets an extra header for every request.
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		c.headers.Set(key, value)
+	}
+}
+
+// New creates a client, server is the address of the admin API,
+// e.g. localhost:2381 or https://easegress.example.com:2381.
+func New(server string, opts ...Option) *Client {
+	if !strings.HasPrefix(server, "http://") && !strings.HasPrefix(server, "https://") {
+		server = "http://" + server
+	}
+
+	c := &Client{
+		server:         strings.TrimSuffix(server, "/"),
+		httpClient:     &http.Client{Timeout: defaultTimeout},
+		maxRetries:     defaultMaxRetries,
+		retryBaseDelay: defaultRetryBaseDelay,
+		headers:        http.Header{},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+// IsNotFound returns true if err is a not found error of the admin API.
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.Code == http.StatusNotFound
+}
+
+// IsPreconditionFailed returns true if err is caused by the mismatched ETag.
+func IsPreconditionFailed(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.Code == http.StatusPreconditionFailed
+}
+
+// IsConflict returns true if err is a conflict error of the admin API.
+func IsConflict(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.Code == http.StatusConflict
+}
+
+// response is the successful response of the admin API.
+type response struct {
+	body          []byte
+	configVersion int64
+	etag          string
+}
+
+func (c *Client) do(method, path string, body []byte, header http.Header) (*response, error) {
+	var lastErr error
+	for i := 0; i <= c.maxRetries; i++ {
+		if i > 0 {
+			time.Sleep(c.retryBaseDelay * time.Duration(1<<uint(i-1)))
+		}
+
+		resp, retryable, err := c.doOnce(method, path, body, header)
+		if err == nil {
+			return resp, nil
+		}
+		if !retryable {
+			return nil, err
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+func (c *Client) doOnce(method, path string, body []byte, header http.Header) (*response, bool, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, c.server+path, reader)
+	if err != nil {
+		return nil, false, err
+	}
+	for key, values := range c.headers {
+		req.Header[key] = values
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &APIError{}
+		if yaml.Unmarshal(respBody, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = string(respBody)
+		}
+		apiErr.Code = resp.StatusCode
+		return nil, resp.StatusCode == http.StatusServiceUnavailable, apiErr
+	}
+
+	version, _ := strconv.ParseInt(resp.Header.Get(configVersionKey), 10, 64)
+
+	return &response{
+		body:          respBody,
+		configVersion: version,
+		etag:          resp.Header.Get("ETag"),
+	}, false, nil
+}
+
+// Health probes the health of the server, and returns the config version.
+func (c *Client) Health() (int64, error) {
+	resp, err := c.do(http.MethodGet, healthPath, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	return resp.configVersion, nil
+}
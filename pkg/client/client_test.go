@@ -0,0 +1,278 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// fakeServer is a minimal in-memory admin API.
+type fakeServer struct {
+	mutex    sync.Mutex
+	version  int64
+	objects  map[string]string
+	failures int
+	auth     string
+}
+
+func etag(spec string) string {
+	return fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(spec)))
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	writeErr := func(code int, msg string) {
+		w.WriteHeader(code)
+		buff, _ := yaml.Marshal(APIError{Code: code, Message: msg})
+		w.Write(buff)
+	}
+
+	if s.failures > 0 {
+		s.failures--
+		writeErr(http.StatusServiceUnavailable, "cluster error")
+		return
+	}
+	if s.auth != "" && r.Header.Get("Authorization") != s.auth {
+		writeErr(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	name := strings.TrimPrefix(r.URL.Path, objectsPath+"/")
+	switch {
+	case r.URL.Path == healthPath:
+	case r.URL.Path == objectsPath && r.Method == http.MethodGet:
+		specs := []map[string]interface{}{}
+		for _, spec := range s.objects {
+			m := map[string]interface{}{}
+			yaml.Unmarshal([]byte(spec), &m)
+			specs = append(specs, m)
+		}
+		buff, _ := yaml.Marshal(specs)
+		defer w.Write(buff)
+	case r.URL.Path == objectsPath && r.Method == http.MethodPost:
+		o, _ := ParseObject(body)
+		if _, exists := s.objects[o.Name]; exists {
+			writeErr(http.StatusConflict, "existed")
+			return
+		}
+		s.version++
+		s.objects[o.Name] = string(body)
+		w.Header().Set(configVersionKey, fmt.Sprintf("%d", s.version))
+		w.Header().Set("ETag", etag(string(body)))
+		w.WriteHeader(http.StatusCreated)
+		return
+	case r.Method == http.MethodPut, r.Method == http.MethodGet, r.Method == http.MethodDelete:
+		spec, exists := s.objects[name]
+		if !exists {
+			writeErr(http.StatusNotFound, "not found")
+			return
+		}
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != etag(spec) {
+			writeErr(http.StatusPreconditionFailed, "etag mismatch")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("ETag", etag(spec))
+			defer w.Write([]byte(spec))
+		case http.MethodPut:
+			w.Header().Set("ETag", etag(string(body)))
+			if spec == string(body) {
+				break
+			}
+			s.version++
+			s.objects[name] = string(body)
+		case http.MethodDelete:
+			s.version++
+			delete(s.objects, name)
+		}
+	default:
+		writeErr(http.StatusBadRequest, "bad request")
+		return
+	}
+
+	w.Header().Set(configVersionKey, fmt.Sprintf("%d", s.version))
+}
+
+func (s *fakeServer) setFailures(n int) {
+	s.mutex.Lock()
+	s.failures = n
+	s.mutex.Unlock()
+}
+
+func newTestClient(t *testing.T, s *fakeServer, opts ...Option) *Client {
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	opts = append([]Option{WithRetry(2, time.Millisecond)}, opts...)
+	return New(ts.URL, opts...)
+}
+
+type pipelineSpec struct {
+	Name  string   `yaml:"name"`
+	Kind  string   `yaml:"kind"`
+	Flow  []string `yaml:"flow"`
+	Retry int      `yaml:"retry,omitempty"`
+}
+
+func TestApplyGetDelete(t *testing.T) {
+	s := &fakeServer{objects: map[string]string{}}
+	c := newTestClient(t, s)
+
+	o, err := NewObject("HTTPPipeline", "demo", &pipelineSpec{Flow: []string{"proxy"}})
+	if err != nil {
+		t.Fatalf("new object failed: %v", err)
+	}
+
+	if version, err := c.ApplyObject(o); err != nil || version != 1 {
+		t.Fatalf("apply failed: %d %v", version, err)
+	}
+	o.Spec["retry"] = 3
+	if version, err := c.ApplyObject(o); err != nil || version != 2 {
+		t.Fatalf("apply failed: %d %v", version, err)
+	}
+	if _, err := c.CreateObject(o); !IsConflict(err) {
+		t.Fatalf("want conflict, got %v", err)
+	}
+
+	got, err := c.GetObject("demo")
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	spec := &pipelineSpec{}
+	if err = got.DecodeSpec(spec); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if spec.Name != "demo" || spec.Kind != "HTTPPipeline" || spec.Retry != 3 || spec.Flow[0] != "proxy" {
+		t.Fatalf("unexpected spec: %+v", spec)
+	}
+
+	objects, err := c.ListObjects()
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("list failed: %v %v", objects, err)
+	}
+
+	if _, err = c.DeleteObject("demo"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, err = c.GetObject("demo"); !IsNotFound(err) {
+		t.Fatalf("want not found, got %v", err)
+	}
+}
+
+func TestConditionalUpdate(t *testing.T) {
+	s := &fakeServer{objects: map[string]string{}}
+	c := newTestClient(t, s)
+
+	o, _ := NewObject("HTTPPipeline", "demo", map[string]interface{}{"flow": []string{"proxy"}})
+	if _, err := c.CreateObject(o); err != nil || o.ETag == "" {
+		t.Fatalf("create failed: %v, etag: %s", err, o.ETag)
+	}
+
+	o1, _ := c.GetObject("demo")
+	o2, _ := c.GetObject("demo")
+	if o1.ETag != o.ETag || o2.ETag != o.ETag {
+		t.Fatalf("unexpected etags: %s %s %s", o.ETag, o1.ETag, o2.ETag)
+	}
+
+	o1.Spec["retry"] = 1
+	if _, err := c.UpdateObject(o1); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+
+	o2.Spec["retry"] = 2
+	if _, err := c.UpdateObject(o2); !IsPreconditionFailed(err) {
+		t.Fatalf("want precondition failed, got %v", err)
+	}
+	if _, err := c.DeleteObjectIfMatch("demo", o2.ETag); !IsPreconditionFailed(err) {
+		t.Fatalf("want precondition failed, got %v", err)
+	}
+
+	// Applying the same spec doesn't change the config version.
+	version, _ := c.Health()
+	if newVersion, err := c.UpdateObject(o1); err != nil || newVersion != version {
+		t.Fatalf("want version %d, got %d, %v", version, newVersion, err)
+	}
+
+	if _, err := c.DeleteObjectIfMatch("demo", o1.ETag); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+}
+
+func TestRetryAndAuth(t *testing.T) {
+	s := &fakeServer{objects: map[string]string{}, auth: "Bearer abc"}
+
+	c := newTestClient(t, s)
+	if _, err := c.Health(); err == nil || err.(*APIError).Code != http.StatusUnauthorized {
+		t.Fatalf("want unauthorized, got %v", err)
+	}
+
+	c = newTestClient(t, s, WithBearerToken("abc"))
+	s.setFailures(2)
+	if _, err := c.Health(); err != nil {
+		t.Fatalf("health failed after retries: %v", err)
+	}
+
+	s.setFailures(3)
+	if _, err := c.Health(); err == nil || err.(*APIError).Code != http.StatusServiceUnavailable {
+		t.Fatalf("want service unavailable, got %v", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	s := &fakeServer{objects: map[string]string{}}
+	c := newTestClient(t, s)
+
+	o, _ := NewObject("HTTPPipeline", "demo", map[string]interface{}{"flow": []string{"proxy"}})
+	c.CreateObject(o)
+
+	done := make(chan struct{})
+	defer close(done)
+	ch := c.Watch(5*time.Millisecond, done)
+
+	next := func() *Event {
+		select {
+		case e := <-ch:
+			return e
+		case <-time.After(3 * time.Second):
+			t.Fatalf("watch timeout")
+		}
+		return nil
+	}
+
+	if e := next(); e.Type != EventPut || e.Object.Name != "demo" {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+
+	c.DeleteObject("demo")
+	if e := next(); e.Type != EventDelete || e.Object.Name != "demo" || e.Object.Kind != "HTTPPipeline" {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+}
@@ -0,0 +1,45 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"fmt"
+	"net/http"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/cluster"
+)
+
+// ListMembers lists all members of the cluster.
+func (c *Client) ListMembers() ([]cluster.MemberStatus, error) {
+	resp, err := c.do(http.MethodGet, membersPath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []cluster.MemberStatus{}
+	err = yaml.Unmarshal(resp.body, &members)
+	return members, err
+}
+
+// PurgeMember purges a member from the cluster.
+func (c *Client) PurgeMember(name string) error {
+	_, err := c.do(http.MethodDelete, fmt.Sprintf(memberPathFormat, escape(name)), nil, nil)
+	return err
+}
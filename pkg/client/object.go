@@ -0,0 +1,288 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+type (
+	// Object is an object of Easegress.
+	Object struct {
+		Name string
+		Kind string
+		// Spec is the spec of the object except name and kind.
+		Spec map[string]interface{}
+		// ETag is the version of the spec got from the server, if it's
+		// not empty, UpdateObject only succeeds when the object on the
+		// server is not changed since then.
+		ETag string `yaml:"-"`
+	}
+
+	// ObjectStatus is the status of an object, key is member name.
+	ObjectStatus map[string]interface{}
+)
+
+// NewObject creates an object from a typed spec, spec could be a struct
+// with yaml tags such as httpserver.Spec, or a map.
+func NewObject(kind, name string, spec interface{}) (*Object, error) {
+	o := &Object{Name: name, Kind: kind, Spec: map[string]interface{}{}}
+	if spec == nil {
+		return o, nil
+	}
+
+	buff, err := yaml.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("marshal %#v to yaml failed: %v", spec, err)
+	}
+	if err = yaml.Unmarshal(buff, &o.Spec); err != nil {
+		return nil, fmt.Errorf("unmarshal %s to map failed: %v", buff, err)
+	}
+	delete(o.Spec, "name")
+	delete(o.Spec, "kind")
+
+	return o, nil
+}
+
+// ParseObject parses an object from its YAML or JSON spec.
+func ParseObject(spec []byte) (*Object, error) {
+	m := map[string]interface{}{}
+	if err := yaml.Unmarshal(spec, &m); err != nil {
+		return nil, fmt.Errorf("unmarshal spec failed: %v", err)
+	}
+
+	o := &Object{Spec: m}
+	o.Name, _ = m["name"].(string)
+	o.Kind, _ = m["kind"].(string)
+	delete(m, "name")
+	delete(m, "kind")
+
+	if o.Name == "" || o.Kind == "" {
+		return nil, fmt.Errorf("name and kind are required")
+	}
+
+	return o, nil
+}
+
+// YAML returns the complete spec in YAML format.
+func (o *Object) YAML() ([]byte, error) {
+	m := yaml.MapSlice{
+		{Key: "name", Value: o.Name},
+		{Key: "kind", Value: o.Kind},
+	}
+
+	keys := make([]string, 0, len(o.Spec))
+	for k := range o.Spec {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		m = append(m, yaml.MapItem{Key: k, Value: o.Spec[k]})
+	}
+
+	return yaml.Marshal(m)
+}
+
+// DecodeSpec decodes the spec into a typed spec such as *httpserver.Spec.
+func (o *Object) DecodeSpec(spec interface{}) error {
+	buff, err := o.YAML()
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(buff, spec)
+}
+
+func escape(name string) string {
+	return url.PathEscape(name)
+}
+
+// ListObjectKinds lists all object kinds.
+func (c *Client) ListObjectKinds() ([]string, error) {
+	resp, err := c.do(http.MethodGet, objectKindsPath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	kinds := []string{}
+	err = yaml.Unmarshal(resp.body, &kinds)
+	return kinds, err
+}
+
+// CreateObject creates an object, and returns the new config version.
+func (c *Client) CreateObject(o *Object) (int64, error) {
+	buff, err := o.YAML()
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.do(http.MethodPost, objectsPath, buff, nil)
+	if err != nil {
+		return 0, err
+	}
+	o.ETag = resp.etag
+	return resp.configVersion, nil
+}
+
+// UpdateObject updates an object, and returns the new config version.
+// It's a no-op on the server if the spec is not changed.
+func (c *Client) UpdateObject(o *Object) (int64, error) {
+	buff, err := o.YAML()
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.do(http.MethodPut, fmt.Sprintf(objectPathFormat, escape(o.Name)),
+		buff, ifMatchHeader(o.ETag))
+	if err != nil {
+		return 0, err
+	}
+	o.ETag = resp.etag
+	return resp.configVersion, nil
+}
+
+func ifMatchHeader(etag string) http.Header {
+	if etag == "" {
+		return nil
+	}
+	return http.Header{"If-Match": []string{etag}}
+}
+
+// ApplyObject creates the object if it doesn't exist, updates it otherwise.
+// The ETag of the object is ignored.
+func (c *Client) ApplyObject(o *Object) (int64, error) {
+	o.ETag = ""
+
+	version, err := c.UpdateObject(o)
+	if !IsNotFound(err) {
+		return version, err
+	}
+
+	version, err = c.CreateObject(o)
+	if IsConflict(err) {
+		// NOTE: Created by others in the meantime.
+		return c.UpdateObject(o)
+	}
+	return version, err
+}
+
+// DeleteObject deletes an object, and returns the new config version.
+func (c *Client) DeleteObject(name string) (int64, error) {
+	return c.DeleteObjectIfMatch(name, "")
+}
+
+// DeleteObjectCascade deletes an object and all objects referencing it,
+// it returns the names of deleted objects. DeleteObject fails with
+// a conflict error if the object is referenced by others.
+func (c *Client) DeleteObjectCascade(name string) ([]string, int64, error) {
+	resp, err := c.do(http.MethodDelete, fmt.Sprintf(objectPathFormat, escape(name))+"?cascade=true",
+		nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	deleted := []string{}
+	err = yaml.Unmarshal(resp.body, &deleted)
+	return deleted, resp.configVersion, err
+}
+
+// DeleteObjectIfMatch deletes an object only if its ETag matches.
+func (c *Client) DeleteObjectIfMatch(name, etag string) (int64, error) {
+	resp, err := c.do(http.MethodDelete, fmt.Sprintf(objectPathFormat, escape(name)),
+		nil, ifMatchHeader(etag))
+	if err != nil {
+		return 0, err
+	}
+	return resp.configVersion, nil
+}
+
+// GetObject gets an object.
+func (c *Client) GetObject(name string) (*Object, error) {
+	resp, err := c.do(http.MethodGet, fmt.Sprintf(objectPathFormat, escape(name)), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := ParseObject(resp.body)
+	if err != nil {
+		return nil, err
+	}
+	o.ETag = resp.etag
+	return o, nil
+}
+
+// ListObjects lists all objects.
+func (c *Client) ListObjects() ([]*Object, error) {
+	objects, _, err := c.listObjects()
+	return objects, err
+}
+
+func (c *Client) listObjects() ([]*Object, int64, error) {
+	resp, err := c.do(http.MethodGet, objectsPath, nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	specs := []map[string]interface{}{}
+	if err = yaml.Unmarshal(resp.body, &specs); err != nil {
+		return nil, 0, fmt.Errorf("unmarshal objects failed: %v", err)
+	}
+
+	objects := make([]*Object, 0, len(specs))
+	for _, spec := range specs {
+		buff, err := yaml.Marshal(spec)
+		if err != nil {
+			return nil, 0, err
+		}
+		o, err := ParseObject(buff)
+		if err != nil {
+			return nil, 0, err
+		}
+		objects = append(objects, o)
+	}
+
+	return objects, resp.configVersion, nil
+}
+
+// GetObjectStatus gets the status of an object.
+func (c *Client) GetObjectStatus(name string) (ObjectStatus, error) {
+	resp, err := c.do(http.MethodGet, fmt.Sprintf(statusObjectFormat, escape(name)), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	status := ObjectStatus{}
+	err = yaml.Unmarshal(resp.body, &status)
+	return status, err
+}
+
+// ListObjectStatuses lists the status of all objects, key is object name.
+func (c *Client) ListObjectStatuses() (map[string]ObjectStatus, error) {
+	resp, err := c.do(http.MethodGet, statusObjectsPath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := map[string]ObjectStatus{}
+	err = yaml.Unmarshal(resp.body, &statuses)
+	return statuses, err
+}
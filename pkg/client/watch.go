@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"reflect"
+	"time"
+)
+
+type (
+	// EventType is the type of watch event.
+	EventType string
+
+	// Event is the change of an object.
+	Event struct {
+		Type EventType
+		// Object only contains the name and kind if the type is EventDelete.
+		Object *Object
+	}
+)
+
+const (
+	// EventPut means the object is created or updated.
+	EventPut EventType = "PUT"
+	// EventDelete means the object is deleted.
+	EventDelete EventType = "DELETE"
+)
+
+// Watch watches the changes of objects by polling the config version,
+// all existing objects are sent as EventPut at first. The returned
+// channel is closed after done is closed.
+func (c *Client) Watch(interval time.Duration, done <-chan struct{}) <-chan *Event {
+	ch := make(chan *Event, 10)
+
+	go func() {
+		defer close(ch)
+
+		objects := map[string]*Object{}
+		version := int64(-1)
+		for {
+			latest, err := c.Health()
+			if err == nil && latest != version {
+				list, listVersion, err := c.listObjects()
+				if err == nil {
+					if !sendEvents(ch, objects, list, done) {
+						return
+					}
+					version = listVersion
+				}
+			}
+
+			select {
+			case <-done:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+
+	return ch
+}
+
+func sendEvents(ch chan<- *Event, objects map[string]*Object, list []*Object, done <-chan struct{}) bool {
+	send := func(e *Event) bool {
+		select {
+		case <-done:
+			return false
+		case ch <- e:
+			return true
+		}
+	}
+
+	latest := make(map[string]*Object, len(list))
+	for _, o := range list {
+		latest[o.Name] = o
+		prev, exists := objects[o.Name]
+		if exists && prev.Kind == o.Kind && reflect.DeepEqual(prev.Spec, o.Spec) {
+			continue
+		}
+		if !send(&Event{Type: EventPut, Object: o}) {
+			return false
+		}
+	}
+
+	for name, o := range objects {
+		if _, exists := latest[name]; exists {
+			continue
+		}
+		if !send(&Event{Type: EventDelete, Object: &Object{Name: name, Kind: o.Kind}}) {
+			return false
+		}
+	}
+
+	for name := range objects {
+		delete(objects, name)
+	}
+	for name, o := range latest {
+		objects[name] = o
+	}
+
+	return true
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	boltFilename = "standalone.db"
+	boltTimeout  = 5 * time.Second
+)
+
+var (
+	boltKVBucket   = []byte("kvs")
+	boltMetaBucket = []byte("meta")
+	boltRevision   = []byte("revision")
+)
+
+// boltPersister persists the data of memStore in a bbolt database.
+type boltPersister struct {
+	db *bbolt.DB
+}
+
+func openBoltPersister(path string) (*boltPersister, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: boltTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{boltKVBucket, boltMetaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltPersister{db: db}, nil
+}
+
+func (p *boltPersister) load() (map[string]*mvccpb.KeyValue, int64, error) {
+	kvs := make(map[string]*mvccpb.KeyValue)
+	var revision int64
+
+	err := p.db.View(func(tx *bbolt.Tx) error {
+		if buff := tx.Bucket(boltMetaBucket).Get(boltRevision); len(buff) == 8 {
+			revision = int64(binary.BigEndian.Uint64(buff))
+		}
+
+		return tx.Bucket(boltKVBucket).ForEach(func(k, v []byte) error {
+			kv := &mvccpb.KeyValue{}
+			if err := kv.Unmarshal(v); err != nil {
+				return fmt.Errorf("unmarshal %s failed: %v", k, err)
+			}
+			kvs[string(k)] = kv
+			return nil
+		})
+	})
+
+	return kvs, revision, err
+}
+
+// save writes the events and the revision in one transaction.
+func (p *boltPersister) save(events []*clientv3.Event, revision int64) error {
+	return p.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltKVBucket)
+		for _, event := range events {
+			if event.Type == mvccpb.DELETE {
+				if err := bucket.Delete(event.Kv.Key); err != nil {
+					return err
+				}
+				continue
+			}
+
+			buff, err := event.Kv.Marshal()
+			if err != nil {
+				return err
+			}
+			if err = bucket.Put(event.Kv.Key, buff); err != nil {
+				return err
+			}
+		}
+
+		buff := make([]byte, 8)
+		binary.BigEndian.PutUint64(buff, uint64(revision))
+		return tx.Bucket(boltMetaBucket).Put(boltRevision, buff)
+	})
+}
+
+func (p *boltPersister) close() error {
+	return p.db.Close()
+}
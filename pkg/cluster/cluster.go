@@ -137,6 +137,10 @@ type cluster struct {
 // New creates a cluster asynchronously,
 // return non-nil err only if reaching hard limit.
 func New(opt *option.Options) (Cluster, error) {
+	if opt.Standalone {
+		return newStandaloneCluster(opt)
+	}
+
 	// defensive programming
 	requestTimeout, err := time.ParseDuration(opt.ClusterRequestTimeout)
 	if err != nil {
@@ -39,6 +39,10 @@ type (
 		PutUnderLease(key, value string) error
 		PutAndDelete(map[string]*string) error
 		PutAndDeleteUnderLease(map[string]*string) error
+		// PutIfModRevision puts the key-value only if the ModRevision of
+		// the key equals to modRevision, zero means the key is missing,
+		// and reports whether it's put.
+		PutIfModRevision(key, value string, modRevision int64) (bool, error)
 
 		Delete(key string) error
 		DeletePrefix(prefix string) error
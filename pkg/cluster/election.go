@@ -0,0 +1,284 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+type (
+	// Elections elects the leaders by the leases stored in the cluster,
+	// so the processes sharing the cluster, e.g. sidecars and custom
+	// controllers, could coordinate singleton work without deploying
+	// their own coordination service.
+	//
+	// A lease is held by a holder until it expires, and the holder renews
+	// it by acquiring it again before then. The expire time is in the
+	// clock of the members, so their clocks should be roughly synced.
+	Elections struct {
+		cluster Cluster
+	}
+
+	// Lease is the lease of the leader of an election.
+	Lease struct {
+		Name     string    `yaml:"name" json:"-"`
+		Holder   string    `yaml:"holder" json:"holder"`
+		ExpireAt time.Time `yaml:"expireAt" json:"expireAt"`
+	}
+)
+
+// NewElections creates Elections.
+func NewElections(c Cluster) *Elections {
+	return &Elections{cluster: c}
+}
+
+func (l *Lease) expired(now time.Time) bool {
+	return !l.ExpireAt.After(now)
+}
+
+func (e *Elections) parseLease(name, value string) (*Lease, error) {
+	lease := &Lease{}
+	err := json.Unmarshal([]byte(value), lease)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal lease of election %s failed: %v", name, err)
+	}
+	lease.Name = name
+	return lease, nil
+}
+
+func (e *Elections) get(name string) (*Lease, error) {
+	value, err := e.cluster.Get(e.cluster.Layout().ElectionKey(name))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	lease, err := e.parseLease(name, *value)
+	if err != nil || lease.expired(time.Now()) {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// lock locks the election, and returns the function to unlock it.
+func (e *Elections) lock(name string) (func(), error) {
+	key := e.cluster.Layout().ElectionKey(name)
+	mutex, err := e.cluster.Mutex(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = mutex.Lock()
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		err := mutex.Unlock()
+		if err != nil {
+			logger.Errorf("unlock %s failed: %v", key, err)
+		}
+	}, nil
+}
+
+// Acquire acquires or renews the lease of the election for the holder if
+// it's not held by others, and returns the current lease and whether the
+// holder holds it.
+func (e *Elections) Acquire(name, holder string, ttl time.Duration) (*Lease, bool, error) {
+	unlock, err := e.lock(name)
+	if err != nil {
+		return nil, false, err
+	}
+	defer unlock()
+
+	lease, err := e.get(name)
+	if err != nil {
+		return nil, false, err
+	}
+	if lease != nil && lease.Holder != holder {
+		return lease, false, nil
+	}
+
+	lease = &Lease{Name: name, Holder: holder, ExpireAt: time.Now().Add(ttl)}
+	buff, err := json.Marshal(lease)
+	if err != nil {
+		return nil, false, fmt.Errorf("BUG: marshal %#v to json failed: %v", lease, err)
+	}
+
+	err = e.cluster.Put(e.cluster.Layout().ElectionKey(name), string(buff))
+	if err != nil {
+		return nil, false, err
+	}
+	return lease, true, nil
+}
+
+// Release releases the lease of the election if it's held by the holder,
+// and reports whether the election has no leader now.
+func (e *Elections) Release(name, holder string) (bool, error) {
+	unlock, err := e.lock(name)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	lease, err := e.get(name)
+	if err != nil {
+		return false, err
+	}
+	if lease != nil && lease.Holder != holder {
+		return false, nil
+	}
+
+	err = e.cluster.Delete(e.cluster.Layout().ElectionKey(name))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Get returns the lease of the election, or nil if it has no leader.
+func (e *Elections) Get(name string) (*Lease, error) {
+	return e.get(name)
+}
+
+// List returns the unexpired leases of all elections sorted by name.
+func (e *Elections) List() ([]*Lease, error) {
+	prefix := e.cluster.Layout().ElectionPrefix()
+	kvs, err := e.cluster.GetPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	leases := []*Lease{}
+	for k, v := range kvs {
+		lease, err := e.parseLease(strings.TrimPrefix(k, prefix), v)
+		if err != nil {
+			logger.Errorf("%v", err)
+			continue
+		}
+		if !lease.expired(now) {
+			leases = append(leases, lease)
+		}
+	}
+
+	sort.Slice(leases, func(i, j int) bool { return leases[i].Name < leases[j].Name })
+	return leases, nil
+}
+
+// Elector campaigns for the leader of an election continuously, it renews
+// the lease every third of the ttl, and releases it when it's closed.
+type Elector struct {
+	elections *Elections
+	name      string
+	holder    string
+	ttl       time.Duration
+	onChange  func(leader bool)
+
+	mutex  sync.RWMutex
+	leader bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewElector creates an Elector and starts campaigning, onChange is
+// called in the campaigning goroutine when the holder becomes or is no
+// longer the leader, it could be nil.
+func NewElector(c Cluster, name, holder string, ttl time.Duration, onChange func(leader bool)) *Elector {
+	e := &Elector{
+		elections: NewElections(c),
+		name:      name,
+		holder:    holder,
+		ttl:       ttl,
+		onChange:  onChange,
+		done:      make(chan struct{}),
+	}
+
+	e.wg.Add(1)
+	go e.run()
+
+	return e
+}
+
+func (e *Elector) run() {
+	defer e.wg.Done()
+
+	for {
+		e.campaign()
+
+		select {
+		case <-e.done:
+			return
+		case <-time.After(e.ttl / 3):
+		}
+	}
+}
+
+func (e *Elector) campaign() {
+	_, leader, err := e.elections.Acquire(e.name, e.holder, e.ttl)
+	if err != nil {
+		// NOTE: The holder is likely to lose the lease if it fails to
+		// renew it, so it steps down at once rather than risking two
+		// leaders.
+		logger.Errorf("election %s: %s acquire lease failed: %v", e.name, e.holder, err)
+		leader = false
+	}
+	e.setLeader(leader)
+}
+
+func (e *Elector) setLeader(leader bool) {
+	e.mutex.Lock()
+	changed := e.leader != leader
+	e.leader = leader
+	e.mutex.Unlock()
+
+	if changed {
+		logger.Infof("election %s: %s leader: %v", e.name, e.holder, leader)
+		if e.onChange != nil {
+			e.onChange(leader)
+		}
+	}
+}
+
+// IsLeader reports whether the holder is the leader.
+func (e *Elector) IsLeader() bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.leader
+}
+
+// Close stops campaigning, and releases the lease if it's the leader, so
+// another holder takes over at once.
+func (e *Elector) Close() {
+	close(e.done)
+	e.wg.Wait()
+
+	if !e.IsLeader() {
+		return
+	}
+	if _, err := e.elections.Release(e.name, e.holder); err != nil {
+		logger.Errorf("election %s: %s release lease failed: %v", e.name, e.holder, err)
+	}
+	e.setLeader(false)
+}
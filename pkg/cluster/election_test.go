@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestElections(t *testing.T) {
+	c := mockStandaloneCluster(t, "")
+	e := NewElections(c)
+
+	lease, ok, err := e.Acquire("sync-job", "sidecar-1", time.Second)
+	if err != nil || !ok || lease.Holder != "sidecar-1" {
+		t.Fatalf("want sidecar-1 acquiring the lease, got %+v, %v, %v", lease, ok, err)
+	}
+
+	lease, ok, err = e.Acquire("sync-job", "sidecar-2", time.Second)
+	if err != nil || ok || lease.Holder != "sidecar-1" {
+		t.Fatalf("want the lease held by sidecar-1, got %+v, %v, %v", lease, ok, err)
+	}
+
+	// The holder renews the lease.
+	renewed, ok, err := e.Acquire("sync-job", "sidecar-1", time.Minute)
+	if err != nil || !ok || !renewed.ExpireAt.After(lease.ExpireAt) {
+		t.Fatalf("want the lease renewed, got %+v, %v, %v", renewed, ok, err)
+	}
+
+	if _, _, err := e.Acquire("report-job", "sidecar-2", time.Minute); err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	leases, err := e.List()
+	if err != nil || len(leases) != 2 || leases[0].Name != "report-job" || leases[1].Name != "sync-job" {
+		t.Fatalf("unexpected leases %+v, %v", leases, err)
+	}
+
+	if ok, err := e.Release("sync-job", "sidecar-2"); err != nil || ok {
+		t.Fatalf("want sidecar-2 failing to release the lease, got %v, %v", ok, err)
+	}
+	if ok, err := e.Release("sync-job", "sidecar-1"); err != nil || !ok {
+		t.Fatalf("want the lease released, got %v, %v", ok, err)
+	}
+	if lease, err := e.Get("sync-job"); err != nil || lease != nil {
+		t.Fatalf("want no lease, got %+v, %v", lease, err)
+	}
+
+	// An expired lease could be acquired by others.
+	if _, _, err := e.Acquire("sync-job", "sidecar-1", 10*time.Millisecond); err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok, err := e.Acquire("sync-job", "sidecar-2", time.Minute); err != nil || !ok {
+		t.Fatalf("want sidecar-2 acquiring the expired lease, got %v, %v", ok, err)
+	}
+}
+
+func TestElector(t *testing.T) {
+	c := mockStandaloneCluster(t, "")
+
+	var mutex sync.Mutex
+	changes := []bool{}
+	e1 := NewElector(c, "singleton", "member-1", 300*time.Millisecond, func(leader bool) {
+		mutex.Lock()
+		changes = append(changes, leader)
+		mutex.Unlock()
+	})
+
+	waitLeader := func(e *Elector) {
+		deadline := time.Now().Add(3 * time.Second)
+		for !e.IsLeader() {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s isn't elected", e.holder)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitLeader(e1)
+
+	e2 := NewElector(c, "singleton", "member-2", 300*time.Millisecond, nil)
+	defer e2.Close()
+
+	// The lease is renewed, so member-2 never takes over.
+	time.Sleep(500 * time.Millisecond)
+	if !e1.IsLeader() || e2.IsLeader() {
+		t.Fatalf("want member-1 keeping the leadership")
+	}
+
+	// member-2 takes over after member-1 releases the lease.
+	e1.Close()
+	waitLeader(e2)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Fatalf("unexpected leadership changes %v", changes)
+	}
+}
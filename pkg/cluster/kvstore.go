@@ -0,0 +1,331 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	kvStoreSyncInterval  = 10 * time.Second
+	kvStorePurgeInterval = time.Minute
+)
+
+type (
+	// KVStore is a namespaced key-value store backed by the cluster
+	// storage, so the data is shared across members. Reads are served
+	// from a local cache which is kept in sync with the cluster.
+	KVStore struct {
+		cluster Cluster
+		prefix  string
+		syncer  *Syncer
+
+		mutex sync.RWMutex
+		cache map[string]*kvEntry
+
+		done chan struct{}
+	}
+
+	kvEntry struct {
+		Value string `json:"value"`
+		// ExpireAt is the unix time in nanoseconds, zero means never.
+		ExpireAt int64 `json:"expireAt,omitempty"`
+	}
+)
+
+func (e *kvEntry) expired(now time.Time) bool {
+	return e.ExpireAt != 0 && e.ExpireAt <= now.UnixNano()
+}
+
+func newKVEntry(value string, ttl time.Duration) *kvEntry {
+	e := &kvEntry{Value: value}
+	if ttl > 0 {
+		e.ExpireAt = time.Now().Add(ttl).UnixNano()
+	}
+	return e
+}
+
+func parseKVEntry(value string) (*kvEntry, error) {
+	e := &kvEntry{}
+	err := json.Unmarshal([]byte(value), e)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// NewKVStore creates a key-value store in the namespace.
+func NewKVStore(c Cluster, namespace string) (*KVStore, error) {
+	syncer, err := c.Syncer(kvStoreSyncInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := c.Layout().KVStorePrefix(namespace)
+	ch, err := syncer.SyncPrefix(prefix)
+	if err != nil {
+		syncer.Close()
+		return nil, err
+	}
+
+	s := &KVStore{
+		cluster: c,
+		prefix:  prefix,
+		syncer:  syncer,
+		cache:   make(map[string]*kvEntry),
+		done:    make(chan struct{}),
+	}
+
+	go s.run(ch)
+
+	return s, nil
+}
+
+func (s *KVStore) run(ch <-chan map[string]string) {
+	ticker := time.NewTicker(kvStorePurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case kvs, ok := <-ch:
+			if !ok {
+				return
+			}
+			s.refresh(kvs)
+		case <-ticker.C:
+			s.purge()
+		}
+	}
+}
+
+func (s *KVStore) refresh(kvs map[string]string) {
+	cache := make(map[string]*kvEntry, len(kvs))
+	for k, v := range kvs {
+		e, err := parseKVEntry(v)
+		if err != nil {
+			logger.Errorf("BUG: parse kv entry %s failed: %v", k, err)
+			continue
+		}
+		cache[strings.TrimPrefix(k, s.prefix)] = e
+	}
+
+	s.mutex.Lock()
+	s.cache = cache
+	s.mutex.Unlock()
+}
+
+// purge deletes the expired entries from the cluster storage,
+// all members do it, but it's harmless.
+func (s *KVStore) purge() {
+	now := time.Now()
+	kvs := make(map[string]*string)
+
+	s.mutex.RLock()
+	for k, e := range s.cache {
+		if e.expired(now) {
+			kvs[s.prefix+k] = nil
+		}
+	}
+	s.mutex.RUnlock()
+
+	if len(kvs) == 0 {
+		return
+	}
+
+	err := s.cluster.PutAndDelete(kvs)
+	if err != nil {
+		logger.Errorf("purge expired kv entries failed: %v", err)
+	}
+}
+
+// Get gets the value of the key from the local cache.
+func (s *KVStore) Get(key string) (string, bool) {
+	s.mutex.RLock()
+	e := s.cache[key]
+	s.mutex.RUnlock()
+
+	if e == nil || e.expired(time.Now()) {
+		return "", false
+	}
+	return e.Value, true
+}
+
+// Keys returns all unexpired keys in the local cache.
+func (s *KVStore) Keys() []string {
+	now := time.Now()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	keys := make([]string, 0, len(s.cache))
+	for k, e := range s.cache {
+		if !e.expired(now) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Put puts the key-value, zero ttl means it never expires.
+func (s *KVStore) Put(key, value string, ttl time.Duration) error {
+	return s.put(key, newKVEntry(value, ttl))
+}
+
+func (s *KVStore) put(key string, e *kvEntry) error {
+	buff, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("BUG: marshal %#v to json failed: %v", e, err)
+	}
+
+	err = s.cluster.Put(s.prefix+key, string(buff))
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	s.cache[key] = e
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// Delete deletes the key.
+func (s *KVStore) Delete(key string) error {
+	err := s.cluster.Delete(s.prefix + key)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	delete(s.cache, key)
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// update updates the entry of the key atomically across the cluster.
+// The modifier gets the current entry, which is nil if it's missing or
+// expired, and returns the new entry, or nil to leave it unchanged. The
+// entry is put only if it isn't changed by others since it's got, or the
+// update is retried with the latest one, so no lock is needed.
+func (s *KVStore) update(key string, modify func(e *kvEntry) (*kvEntry, error)) (bool, error) {
+	for {
+		kv, err := s.cluster.GetRaw(s.prefix + key)
+		if err != nil {
+			return false, err
+		}
+
+		var e *kvEntry
+		revision := int64(0)
+		if kv != nil {
+			revision = kv.ModRevision
+			e, err = parseKVEntry(string(kv.Value))
+			if err != nil {
+				return false, err
+			}
+			if e.expired(time.Now()) {
+				e = nil
+			}
+		}
+
+		e, err = modify(e)
+		if err != nil || e == nil {
+			return false, err
+		}
+
+		buff, err := json.Marshal(e)
+		if err != nil {
+			return false, fmt.Errorf("BUG: marshal %#v to json failed: %v", e, err)
+		}
+
+		ok, err := s.cluster.PutIfModRevision(s.prefix+key, string(buff), revision)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			s.mutex.Lock()
+			s.cache[key] = e
+			s.mutex.Unlock()
+			return true, nil
+		}
+	}
+}
+
+// Incr increases the integer value of the key by delta atomically across
+// the cluster, and returns the new value. A missing or expired key counts
+// from zero with the ttl, otherwise the original expire time is kept.
+func (s *KVStore) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	var n int64
+	_, err := s.update(key, func(e *kvEntry) (*kvEntry, error) {
+		if e == nil {
+			e = newKVEntry("0", ttl)
+		}
+
+		v, err := strconv.ParseInt(e.Value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("value of %s is not an integer", key)
+		}
+		n = v + delta
+
+		return &kvEntry{Value: strconv.FormatInt(n, 10), ExpireAt: e.ExpireAt}, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// PutIfAbsent puts the key-value atomically across the cluster if the
+// key is missing or expired, and reports whether it's put.
+func (s *KVStore) PutIfAbsent(key, value string, ttl time.Duration) (bool, error) {
+	return s.update(key, func(e *kvEntry) (*kvEntry, error) {
+		if e != nil {
+			return nil, nil
+		}
+		return newKVEntry(value, ttl), nil
+	})
+}
+
+// Acquire puts the owner as the value of the key atomically across the
+// cluster if the key is missing, expired or already owned by the owner,
+// and reports whether the owner holds the key, so it works as a lease
+// which is renewed by acquiring it again before it expires.
+func (s *KVStore) Acquire(key, owner string, ttl time.Duration) (bool, error) {
+	return s.update(key, func(e *kvEntry) (*kvEntry, error) {
+		if e != nil && e.Value != owner {
+			return nil, nil
+		}
+		return newKVEntry(owner, ttl), nil
+	})
+}
+
+// Close closes the store.
+func (s *KVStore) Close() {
+	close(s.done)
+	s.syncer.Close()
+}
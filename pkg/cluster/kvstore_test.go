@@ -0,0 +1,292 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKVStore(t *testing.T) {
+	c := mockStandaloneCluster(t, "")
+
+	s, err := NewKVStore(c, "pipeline-demo")
+	if err != nil {
+		t.Fatalf("new kv store failed: %v", err)
+	}
+	defer s.Close()
+
+	if _, ok := s.Get("flag"); ok {
+		t.Fatalf("want no flag")
+	}
+
+	if err := s.Put("flag", "on", 0); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if v, ok := s.Get("flag"); !ok || v != "on" {
+		t.Fatalf("want on, got %s", v)
+	}
+
+	// The data is visible to another store in the same namespace.
+	other, err := NewKVStore(c, "pipeline-demo")
+	if err != nil {
+		t.Fatalf("new kv store failed: %v", err)
+	}
+	defer other.Close()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if v, _ := other.Get("flag"); v == "on" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sync timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := s.Delete("flag"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, ok := s.Get("flag"); ok {
+		t.Fatalf("want flag deleted")
+	}
+
+	if err := s.Put("temp", "1", 50*time.Millisecond); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := s.Get("temp"); ok {
+		t.Fatalf("want temp expired")
+	}
+}
+
+func TestKVStoreIncr(t *testing.T) {
+	c := mockStandaloneCluster(t, "")
+
+	s, err := NewKVStore(c, "pipeline-demo")
+	if err != nil {
+		t.Fatalf("new kv store failed: %v", err)
+	}
+	defer s.Close()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Incr("counter", 2, 0); err != nil {
+				t.Errorf("incr failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, _ := s.Get("counter"); v != "20" {
+		t.Fatalf("want 20, got %s", v)
+	}
+
+	s.Put("name", "abc", 0)
+	if _, err := s.Incr("name", 1, 0); err == nil {
+		t.Fatalf("want error for non-integer value")
+	}
+}
+
+func TestKVStorePutIfAbsent(t *testing.T) {
+	c := mockStandaloneCluster(t, "")
+
+	s, err := NewKVStore(c, "idempotency")
+	if err != nil {
+		t.Fatalf("new kv store failed: %v", err)
+	}
+	defer s.Close()
+
+	if ok, err := s.PutIfAbsent("req-1", "a", 50*time.Millisecond); !ok || err != nil {
+		t.Fatalf("want put, got %v, %v", ok, err)
+	}
+	if ok, err := s.PutIfAbsent("req-1", "b", 0); ok || err != nil {
+		t.Fatalf("want not put, got %v, %v", ok, err)
+	}
+	if v, _ := s.Get("req-1"); v != "a" {
+		t.Fatalf("want a, got %s", v)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if ok, err := s.PutIfAbsent("req-1", "c", 0); !ok || err != nil {
+		t.Fatalf("want put after expired, got %v, %v", ok, err)
+	}
+}
+
+func TestKVStoreAcquire(t *testing.T) {
+	c := mockStandaloneCluster(t, "")
+
+	s, err := NewKVStore(c, "lease")
+	if err != nil {
+		t.Fatalf("new kv store failed: %v", err)
+	}
+	defer s.Close()
+
+	if ok, err := s.Acquire("leader", "a", 50*time.Millisecond); !ok || err != nil {
+		t.Fatalf("want acquired, got %v, %v", ok, err)
+	}
+	if ok, err := s.Acquire("leader", "b", 50*time.Millisecond); ok || err != nil {
+		t.Fatalf("want not acquired, got %v, %v", ok, err)
+	}
+
+	// The owner renews the lease.
+	time.Sleep(30 * time.Millisecond)
+	if ok, err := s.Acquire("leader", "a", 50*time.Millisecond); !ok || err != nil {
+		t.Fatalf("want renewed, got %v, %v", ok, err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if ok, err := s.Acquire("leader", "b", 50*time.Millisecond); ok || err != nil {
+		t.Fatalf("want not acquired before expired, got %v, %v", ok, err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if ok, err := s.Acquire("leader", "b", 50*time.Millisecond); !ok || err != nil {
+		t.Fatalf("want acquired after expired, got %v, %v", ok, err)
+	}
+	if v, _ := s.Get("leader"); v != "b" {
+		t.Fatalf("want b, got %s", v)
+	}
+}
+
+// mockEtcdCluster creates a cluster of a single member backed by etcd.
+func mockEtcdCluster(t *testing.T) *cluster {
+	opts, _, _ := mockMembers(1)
+	cls, err := New(opts[0])
+	if err != nil {
+		t.Fatalf("new cluster failed: %v", err)
+	}
+	c := cls.(*cluster)
+	t.Cleanup(func() {
+		closeClusters([]*cluster{c})
+	})
+
+	if _, err = c.getClient(); err != nil {
+		t.Fatalf("get client failed: %v", err)
+	}
+	return c
+}
+
+func TestPutIfModRevision(t *testing.T) {
+	for _, c := range []Cluster{mockEtcdCluster(t), mockStandaloneCluster(t, "")} {
+		key := c.Layout().KVStorePrefix("cas") + "key"
+
+		if ok, err := c.PutIfModRevision(key, "a", 1); ok || err != nil {
+			t.Fatalf("want not put for missing key, got %v, %v", ok, err)
+		}
+		if ok, err := c.PutIfModRevision(key, "a", 0); !ok || err != nil {
+			t.Fatalf("want put for missing key, got %v, %v", ok, err)
+		}
+
+		kv, err := c.GetRaw(key)
+		if err != nil || kv == nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		if ok, err := c.PutIfModRevision(key, "b", 0); ok || err != nil {
+			t.Fatalf("want not put for existing key, got %v, %v", ok, err)
+		}
+		if ok, err := c.PutIfModRevision(key, "b", kv.ModRevision); !ok || err != nil {
+			t.Fatalf("want put for same revision, got %v, %v", ok, err)
+		}
+		if ok, err := c.PutIfModRevision(key, "c", kv.ModRevision); ok || err != nil {
+			t.Fatalf("want not put for stale revision, got %v, %v", ok, err)
+		}
+		if v, _ := c.Get(key); v == nil || *v != "b" {
+			t.Fatalf("want b, got %v", v)
+		}
+	}
+}
+
+func TestKVStoreEtcd(t *testing.T) {
+	c := mockEtcdCluster(t)
+
+	// Two stores of the same namespace work like two members.
+	stores := make([]*KVStore, 2)
+	for i := range stores {
+		s, err := NewKVStore(c, "pipeline-demo")
+		if err != nil {
+			t.Fatalf("new kv store failed: %v", err)
+		}
+		defer s.Close()
+		stores[i] = s
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(s *KVStore) {
+			defer wg.Done()
+			if _, err := s.Incr("counter", 1, 0); err != nil {
+				t.Errorf("incr failed: %v", err)
+			}
+		}(stores[i%2])
+	}
+	wg.Wait()
+
+	if n, err := stores[1].Incr("counter", 0, 0); n != 20 || err != nil {
+		t.Fatalf("want 20, got %d, %v", n, err)
+	}
+
+	puts := int32(0)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := stores[i%2].PutIfAbsent("req-1", strconv.Itoa(i), time.Minute)
+			if err != nil {
+				t.Errorf("put if absent failed: %v", err)
+			}
+			if ok {
+				atomic.AddInt32(&puts, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if puts != 1 {
+		t.Fatalf("want put once, got %d", puts)
+	}
+
+	if ok, err := stores[0].Acquire("leader", "a", time.Minute); !ok || err != nil {
+		t.Fatalf("want acquired, got %v, %v", ok, err)
+	}
+	if ok, err := stores[1].Acquire("leader", "b", time.Minute); ok || err != nil {
+		t.Fatalf("want not acquired, got %v, %v", ok, err)
+	}
+
+	// Only the entries are stored under the prefix, so all of them are
+	// synced to the caches of the stores.
+	kvs, err := c.GetPrefix(c.Layout().KVStorePrefix("pipeline-demo"))
+	if err != nil {
+		t.Fatalf("get prefix failed: %v", err)
+	}
+	if len(kvs) != 3 {
+		t.Fatalf("want 3 keys, got %v", kvs)
+	}
+	for k, v := range kvs {
+		if _, err := parseKVEntry(v); err != nil {
+			t.Fatalf("invalid entry of %s: %v", k, err)
+		}
+	}
+}
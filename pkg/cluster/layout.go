@@ -32,7 +32,11 @@ const (
 	configObjectPrefix       = "/config/objects/"
 	configObjectFormat       = "/config/objects/%s" // +objectName
 	configVersion            = "/config/version"
+	configBootstrapped       = "/config/bootstrapped"
 	wasmCodeEvent            = "/wasm/code"
+	kvStorePrefixFormat      = "/kvstore/%s/" // +namespace
+	electionPrefix           = "/elections/"
+	electionFormat           = "/elections/%s" // +electionName
 
 	// the cluster name of this eg group will be registered under this path in etcd
 	// any new member(reader or writer ) will be rejected if it is configured a different cluster name
@@ -118,7 +122,27 @@ func (l *Layout) ConfigVersion() string {
 	return configVersion
 }
 
+// ConfigBootstrapped returns the key marking the initial objects are created.
+func (l *Layout) ConfigBootstrapped() string {
+	return configBootstrapped
+}
+
 // WasmCodeEvent returns the key of wasm code event
 func (l *Layout) WasmCodeEvent() string {
 	return wasmCodeEvent
 }
+
+// KVStorePrefix returns the prefix of the key-value store in the namespace.
+func (l *Layout) KVStorePrefix(namespace string) string {
+	return fmt.Sprintf(kvStorePrefixFormat, namespace)
+}
+
+// ElectionPrefix returns the prefix of the leases of elections.
+func (l *Layout) ElectionPrefix() string {
+	return electionPrefix
+}
+
+// ElectionKey returns the key of the lease of the election.
+func (l *Layout) ElectionKey(name string) string {
+	return fmt.Sprintf(electionFormat, name)
+}
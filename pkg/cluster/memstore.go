@@ -0,0 +1,319 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"context"
+	"sync"
+
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+type (
+	// memStore is an in-memory key-value store with etcd compatible
+	// watchers, it backs the cluster in standalone mode.
+	memStore struct {
+		mutex    sync.RWMutex
+		revision int64
+		kvs      map[string]*mvccpb.KeyValue
+		watches  map[*memWatch]struct{}
+
+		// persister is optional, all reads are served from memory.
+		persister *boltPersister
+	}
+
+	// memWatcher implements clientv3.Watcher upon memStore.
+	memWatcher struct {
+		store *memStore
+		done  chan struct{}
+		once  sync.Once
+	}
+
+	memWatch struct {
+		// key and end follow the semantics of etcd range:
+		// empty end means the single key, "\x00" means all keys >= key.
+		key string
+		end string
+
+		mutex  sync.Mutex
+		queue  []*clientv3.Event
+		notify chan struct{}
+	}
+)
+
+func newMemStore() *memStore {
+	return &memStore{
+		kvs:     make(map[string]*mvccpb.KeyValue),
+		watches: make(map[*memWatch]struct{}),
+	}
+}
+
+// newPersistentMemStore creates a memStore which loads data from
+// and writes changes through the persister.
+func newPersistentMemStore(persister *boltPersister) (*memStore, error) {
+	kvs, revision, err := persister.load()
+	if err != nil {
+		return nil, err
+	}
+
+	s := newMemStore()
+	s.kvs = kvs
+	s.revision = revision
+	s.persister = persister
+
+	return s, nil
+}
+
+func (s *memStore) close() error {
+	if s.persister == nil {
+		return nil
+	}
+	return s.persister.close()
+}
+
+func (w *memWatch) match(key string) bool {
+	switch w.end {
+	case "":
+		return key == w.key
+	case "\x00":
+		return key >= w.key
+	default:
+		return key >= w.key && key < w.end
+	}
+}
+
+func (w *memWatch) push(events []*clientv3.Event) {
+	matched := make([]*clientv3.Event, 0, len(events))
+	for _, event := range events {
+		if w.match(string(event.Kv.Key)) {
+			matched = append(matched, event)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	w.mutex.Lock()
+	w.queue = append(w.queue, matched...)
+	w.mutex.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *memWatch) pop() []*clientv3.Event {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	events := w.queue
+	w.queue = nil
+	return events
+}
+
+func (s *memStore) get(key string) *mvccpb.KeyValue {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.kvs[key]
+}
+
+func (s *memStore) getPrefix(prefix string) map[string]*mvccpb.KeyValue {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	w := &memWatch{key: prefix, end: clientv3.GetPrefixRangeEnd(prefix)}
+	kvs := make(map[string]*mvccpb.KeyValue)
+	for k, kv := range s.kvs {
+		if w.match(k) {
+			kvs[k] = kv
+		}
+	}
+
+	return kvs
+}
+
+// apply puts and deletes (nil value) keys atomically. The changes are
+// written through the persister if persist is true, keys put without
+// persisting are lost after restarting just like the leased keys of etcd.
+func (s *memStore) apply(kvs map[string]*string, persist bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.applyLocked(kvs, persist)
+}
+
+// putIfModRevision puts the key-value if the ModRevision of the key
+// equals to modRevision, the same as the comparison of etcd, the
+// ModRevision of a missing key is zero.
+func (s *memStore) putIfModRevision(key, value string, modRevision int64) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current := int64(0)
+	if kv := s.kvs[key]; kv != nil {
+		current = kv.ModRevision
+	}
+	if current != modRevision {
+		return false, nil
+	}
+
+	err := s.applyLocked(map[string]*string{key: &value}, true)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *memStore) applyLocked(kvs map[string]*string, persist bool) error {
+	revision := s.revision + 1
+	events := make([]*clientv3.Event, 0, len(kvs))
+	for k, v := range kvs {
+		prev := s.kvs[k]
+
+		if v == nil {
+			if prev == nil {
+				continue
+			}
+			events = append(events, &clientv3.Event{
+				Type: mvccpb.DELETE,
+				Kv:   &mvccpb.KeyValue{Key: []byte(k), ModRevision: revision},
+			})
+			continue
+		}
+
+		kv := &mvccpb.KeyValue{
+			Key:            []byte(k),
+			Value:          []byte(*v),
+			CreateRevision: revision,
+			ModRevision:    revision,
+			Version:        1,
+		}
+		if prev != nil {
+			kv.CreateRevision = prev.CreateRevision
+			kv.Version = prev.Version + 1
+		}
+		events = append(events, &clientv3.Event{Type: mvccpb.PUT, Kv: kv})
+	}
+
+	if persist && s.persister != nil {
+		err := s.persister.save(events, revision)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.revision = revision
+	for _, event := range events {
+		if event.Type == mvccpb.DELETE {
+			delete(s.kvs, string(event.Kv.Key))
+		} else {
+			s.kvs[string(event.Kv.Key)] = event.Kv
+		}
+	}
+
+	for w := range s.watches {
+		w.push(events)
+	}
+
+	return nil
+}
+
+func (s *memStore) deletePrefix(prefix string) error {
+	kvs := make(map[string]*string)
+	for k := range s.getPrefix(prefix) {
+		kvs[k] = nil
+	}
+	return s.apply(kvs, true)
+}
+
+func (s *memStore) newWatcher() clientv3.Watcher {
+	return &memWatcher{
+		store: s,
+		done:  make(chan struct{}),
+	}
+}
+
+func (w *memWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	op := clientv3.OpGet(key, opts...)
+	watch := &memWatch{
+		key:    key,
+		end:    string(op.RangeBytes()),
+		notify: make(chan struct{}, 1),
+	}
+
+	s := w.store
+	s.mutex.Lock()
+	s.watches[watch] = struct{}{}
+	s.mutex.Unlock()
+
+	ch := make(chan clientv3.WatchResponse)
+	go func() {
+		defer func() {
+			s.mutex.Lock()
+			delete(s.watches, watch)
+			s.mutex.Unlock()
+			close(ch)
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.done:
+				return
+			case <-watch.notify:
+			}
+
+			events := watch.pop()
+			if len(events) == 0 {
+				continue
+			}
+			resp := clientv3.WatchResponse{
+				Header: etcdserverpb.ResponseHeader{
+					Revision: events[len(events)-1].Kv.ModRevision,
+				},
+				Events: events,
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.done:
+				return
+			case ch <- resp:
+			}
+		}
+	}()
+
+	return ch
+}
+
+func (w *memWatcher) RequestProgress(ctx context.Context) error {
+	return nil
+}
+
+func (w *memWatcher) Close() error {
+	w.once.Do(func() {
+		close(w.done)
+	})
+	return nil
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+// reloadDelay merges the burst of file events, such as saving by editors.
+const reloadDelay = 500 * time.Millisecond
+
+// objectsLoader loads the object specs in the YAML files of the directory
+// into the cluster, and reloads them on file change. Objects created by
+// the admin API are kept, unless the files define objects with the same
+// names.
+type objectsLoader struct {
+	cluster Cluster
+	dir     string
+	watcher *fsnotify.Watcher
+
+	// objects is the object specs from files in last loading,
+	// key is object name.
+	objects map[string]string
+
+	done chan struct{}
+}
+
+func newObjectsLoader(cluster Cluster, dir string) (*objectsLoader, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	err = watcher.Add(dir)
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	l := &objectsLoader{
+		cluster: cluster,
+		dir:     dir,
+		watcher: watcher,
+		objects: make(map[string]string),
+		done:    make(chan struct{}),
+	}
+
+	err = l.reload()
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go l.run()
+
+	return l, nil
+}
+
+func (l *objectsLoader) run() {
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-l.done:
+			return
+		case event, ok := <-l.watcher.Events:
+			if !ok {
+				return
+			}
+			if isObjectsFile(event.Name) {
+				reload = time.After(reloadDelay)
+			}
+		case err, ok := <-l.watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Errorf("watch objects dir %s failed: %v", l.dir, err)
+		case <-reload:
+			reload = nil
+			err := l.reload()
+			if err != nil {
+				logger.Errorf("reload objects from %s failed, keep the previous ones: %v",
+					l.dir, err)
+			}
+		}
+	}
+}
+
+func isObjectsFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// reload loads all files, and applies the changes atomically.
+func (l *objectsLoader) reload() error {
+	objects, err := readObjectsDir(l.dir)
+	if err != nil {
+		return err
+	}
+
+	kvs := make(map[string]*string)
+	layout := l.cluster.Layout()
+	for name := range l.objects {
+		if _, exists := objects[name]; !exists {
+			kvs[layout.ConfigObjectKey(name)] = nil
+		}
+	}
+	for name, spec := range objects {
+		if l.objects[name] != spec {
+			spec := spec
+			kvs[layout.ConfigObjectKey(name)] = &spec
+		}
+	}
+
+	if len(kvs) == 0 {
+		return nil
+	}
+
+	err = l.cluster.PutAndDelete(kvs)
+	if err != nil {
+		return err
+	}
+	l.objects = objects
+
+	logger.Infof("loaded %d objects from %s, %d changed", len(objects), l.dir, len(kvs))
+
+	return nil
+}
+
+// readObjectsDir reads object specs in the YAML files of the directory,
+// a file could contain multiple specs separated by "---".
+func readObjectsDir(dir string) (map[string]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make(map[string]string)
+	paths := make(map[string]string)
+	for _, file := range files {
+		if file.IsDir() || !isObjectsFile(file.Name()) {
+			continue
+		}
+
+		path := filepath.Join(dir, file.Name())
+		buff, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(buff))
+		for {
+			var doc map[string]interface{}
+			err := decoder.Decode(&doc)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			if len(doc) == 0 {
+				continue
+			}
+
+			name, _ := doc["name"].(string)
+			kind, _ := doc["kind"].(string)
+			if name == "" || kind == "" {
+				return nil, fmt.Errorf("%s: name and kind are required", path)
+			}
+			if prev, exists := paths[name]; exists {
+				return nil, fmt.Errorf("%s: object %s defined in %s too", path, name, prev)
+			}
+
+			spec, err := yaml.Marshal(doc)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+
+			objects[name] = string(spec)
+			paths[name] = path
+		}
+	}
+
+	return objects, nil
+}
+
+func (l *objectsLoader) close() {
+	close(l.done)
+	l.watcher.Close()
+}
@@ -52,6 +52,23 @@ func (c *cluster) Put(key, value string) error {
 	return err
 }
 
+func (c *cluster) PutIfModRevision(key, value string, modRevision int64) (bool, error) {
+	client, err := c.getClient()
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := client.Txn(c.requestContext()).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", modRevision)).
+		Then(clientv3.OpPut(key, value)).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return resp.Succeeded, nil
+}
+
 func (c *cluster) PutAndDeleteUnderLease(kvs map[string]*string) error {
 	return c.putAndDelete(kvs, true)
 }
@@ -0,0 +1,248 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/option"
+)
+
+type (
+	// standaloneCluster is the cluster of a single member without etcd,
+	// all data is kept in memory and optionally persisted in bbolt,
+	// and the config objects could come from the local objects directory.
+	standaloneCluster struct {
+		opt    *option.Options
+		layout *Layout
+		store  *memStore
+
+		mutexes      map[string]*sync.Mutex
+		mutexesMutex sync.Mutex
+
+		loader *objectsLoader
+
+		done chan struct{}
+	}
+
+	localMutex struct {
+		m *sync.Mutex
+	}
+)
+
+func newStandaloneCluster(opt *option.Options) (*standaloneCluster, error) {
+	c := &standaloneCluster{
+		opt:     opt,
+		layout:  &Layout{memberName: opt.Name},
+		store:   newMemStore(),
+		mutexes: make(map[string]*sync.Mutex),
+		done:    make(chan struct{}),
+	}
+
+	if opt.StandaloneStorage == "bbolt" {
+		path := filepath.Join(opt.AbsDataDir, boltFilename)
+		persister, err := openBoltPersister(path)
+		if err != nil {
+			return nil, fmt.Errorf("open %s failed: %v", path, err)
+		}
+		c.store, err = newPersistentMemStore(persister)
+		if err != nil {
+			persister.close()
+			return nil, fmt.Errorf("load data from %s failed: %v", path, err)
+		}
+	}
+
+	if opt.AbsObjectsDir != "" {
+		loader, err := newObjectsLoader(c, opt.AbsObjectsDir)
+		if err != nil {
+			c.store.close()
+			return nil, fmt.Errorf("load objects from %s failed: %v", opt.AbsObjectsDir, err)
+		}
+		c.loader = loader
+	}
+
+	c.syncStatus()
+	go c.heartbeat()
+
+	logger.Infof("cluster is ready in standalone mode")
+
+	return c, nil
+}
+
+func (c *standaloneCluster) heartbeat() {
+	for {
+		select {
+		case <-time.After(HeartbeatInterval):
+			c.syncStatus()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *standaloneCluster) syncStatus() {
+	status := MemberStatus{
+		Options:           *c.opt,
+		LastHeartbeatTime: time.Now().Format(time.RFC3339),
+	}
+
+	buff, err := yaml.Marshal(status)
+	if err != nil {
+		logger.Errorf("BUG: marshal %#v to yaml failed: %v", status, err)
+		return
+	}
+
+	c.PutUnderLease(c.layout.StatusMemberKey(), string(buff))
+}
+
+func (c *standaloneCluster) Layout() *Layout {
+	return c.layout
+}
+
+func (c *standaloneCluster) Get(key string) (*string, error) {
+	kv := c.store.get(key)
+	if kv == nil {
+		return nil, nil
+	}
+
+	value := string(kv.Value)
+	return &value, nil
+}
+
+func (c *standaloneCluster) GetPrefix(prefix string) (map[string]string, error) {
+	kvs := make(map[string]string)
+	for k, kv := range c.store.getPrefix(prefix) {
+		kvs[k] = string(kv.Value)
+	}
+	return kvs, nil
+}
+
+func (c *standaloneCluster) GetRaw(key string) (*mvccpb.KeyValue, error) {
+	return c.store.get(key), nil
+}
+
+func (c *standaloneCluster) GetRawPrefix(prefix string) (map[string]*mvccpb.KeyValue, error) {
+	return c.store.getPrefix(prefix), nil
+}
+
+func (c *standaloneCluster) Put(key, value string) error {
+	return c.store.apply(map[string]*string{key: &value}, true)
+}
+
+// PutUnderLease stores data without persisting, since the lifecycle
+// of the lease is the same with the only member.
+func (c *standaloneCluster) PutUnderLease(key, value string) error {
+	return c.store.apply(map[string]*string{key: &value}, false)
+}
+
+func (c *standaloneCluster) PutAndDelete(kvs map[string]*string) error {
+	return c.store.apply(kvs, true)
+}
+
+func (c *standaloneCluster) PutIfModRevision(key, value string, modRevision int64) (bool, error) {
+	return c.store.putIfModRevision(key, value, modRevision)
+}
+
+func (c *standaloneCluster) PutAndDeleteUnderLease(kvs map[string]*string) error {
+	return c.store.apply(kvs, false)
+}
+
+func (c *standaloneCluster) Delete(key string) error {
+	return c.store.apply(map[string]*string{key: nil}, true)
+}
+
+func (c *standaloneCluster) DeletePrefix(prefix string) error {
+	return c.store.deletePrefix(prefix)
+}
+
+func (c *standaloneCluster) Watcher() (Watcher, error) {
+	return &watcher{
+		w:    c.store.newWatcher(),
+		done: make(chan struct{}),
+	}, nil
+}
+
+func (c *standaloneCluster) Syncer(pullInterval time.Duration) (*Syncer, error) {
+	return &Syncer{
+		cluster:      c,
+		newWatcher:   c.store.newWatcher,
+		pullInterval: pullInterval,
+		done:         make(chan struct{}),
+	}, nil
+}
+
+func (c *standaloneCluster) Mutex(name string) (Mutex, error) {
+	c.mutexesMutex.Lock()
+	defer c.mutexesMutex.Unlock()
+
+	m, exists := c.mutexes[name]
+	if !exists {
+		m = &sync.Mutex{}
+		c.mutexes[name] = m
+	}
+
+	return &localMutex{m: m}, nil
+}
+
+func (m *localMutex) Lock() error {
+	m.m.Lock()
+	return nil
+}
+
+func (m *localMutex) Unlock() error {
+	m.m.Unlock()
+	return nil
+}
+
+// CloseServer does nothing since there is no server in standalone mode.
+func (c *standaloneCluster) CloseServer(wg *sync.WaitGroup) {
+	wg.Done()
+}
+
+// StartServer does nothing since there is no server in standalone mode.
+func (c *standaloneCluster) StartServer() (done, timeout chan struct{}, err error) {
+	done = make(chan struct{})
+	close(done)
+	return done, make(chan struct{}), nil
+}
+
+func (c *standaloneCluster) PurgeMember(memberName string) error {
+	return fmt.Errorf("can't purge the only member %s in standalone mode", memberName)
+}
+
+func (c *standaloneCluster) Close(wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	close(c.done)
+
+	if c.loader != nil {
+		c.loader.close()
+	}
+
+	err := c.store.close()
+	if err != nil {
+		logger.Errorf("close store failed: %v", err)
+	}
+}
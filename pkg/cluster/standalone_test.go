@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/option"
+)
+
+func mockStandaloneCluster(t *testing.T, objectsDir string) *standaloneCluster {
+	opt := option.New()
+	opt.Name = "standalone-member"
+	opt.Standalone = true
+	opt.AbsObjectsDir = objectsDir
+
+	cls, err := New(opt)
+	if err != nil {
+		t.Fatalf("new standalone cluster failed: %v", err)
+	}
+	t.Cleanup(func() {
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		cls.Close(wg)
+		wg.Wait()
+	})
+
+	return cls.(*standaloneCluster)
+}
+
+func TestStandaloneOps(t *testing.T) {
+	c := mockStandaloneCluster(t, "")
+
+	if _, err := c.Get(c.Layout().StatusMemberKey()); err != nil {
+		t.Fatalf("get member status failed: %v", err)
+	}
+
+	w, _ := c.Watcher()
+	defer w.Close()
+	ch, err := w.WatchPrefix("/test/")
+	if err != nil {
+		t.Fatalf("watch prefix failed: %v", err)
+	}
+
+	c.Put("/test/a", "1")
+	c.Put("/other", "2")
+	c.Delete("/test/a")
+
+	want := []*string{strPtr("1"), nil}
+	for _, v := range want {
+		select {
+		case m := <-ch:
+			got := m["/test/a"]
+			if (got == nil) != (v == nil) || (got != nil && *got != *v) {
+				t.Fatalf("want %v, got %v", v, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("watch timeout")
+		}
+	}
+
+	c.PutAndDelete(map[string]*string{"/test/b": strPtr("2"), "/test/c": strPtr("3")})
+	kvs, _ := c.GetPrefix("/test/")
+	if len(kvs) != 2 || kvs["/test/b"] != "2" {
+		t.Fatalf("unexpected kvs: %v", kvs)
+	}
+	c.DeletePrefix("/test/")
+	if kvs, _ := c.GetPrefix("/test/"); len(kvs) != 0 {
+		t.Fatalf("unexpected kvs: %v", kvs)
+	}
+
+	m, _ := c.Mutex("lock")
+	m.Lock()
+	m.Unlock()
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestStandaloneObjectsDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "objects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(file, content string) {
+		err := ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("a.yaml", "name: a\nkind: HTTPPipeline\n---\nname: b\nkind: HTTPPipeline\n")
+	write("ignored.txt", "name: c\nkind: HTTPPipeline\n")
+
+	c := mockStandaloneCluster(t, dir)
+
+	syncer, _ := c.Syncer(time.Minute)
+	defer syncer.Close()
+	ch, _ := syncer.SyncPrefix(c.Layout().ConfigObjectPrefix())
+
+	next := func() map[string]string {
+		select {
+		case m := <-ch:
+			return m
+		case <-time.After(3 * time.Second):
+			t.Fatalf("sync timeout")
+		}
+		return nil
+	}
+
+	if m := next(); len(m) != 2 {
+		t.Fatalf("want objects a and b, got %v", m)
+	}
+
+	// Objects created by API survive the reloading.
+	c.Put(c.Layout().ConfigObjectKey("api"), "name: api\nkind: HTTPPipeline\n")
+	if m := next(); len(m) != 3 {
+		t.Fatalf("want 3 objects, got %v", m)
+	}
+
+	write("a.yaml", "name: a\nkind: HTTPPipeline\n")
+	if m := next(); len(m) != 2 || m[c.Layout().ConfigObjectKey("b")] != "" {
+		t.Fatalf("want objects a and api, got %v", m)
+	}
+
+	// Invalid files keep the previous objects.
+	write("bad.yaml", "name: a\nkind: HTTPPipeline\n")
+	time.Sleep(2 * reloadDelay)
+	if kvs, _ := c.GetPrefix(c.Layout().ConfigObjectPrefix()); len(kvs) != 2 {
+		t.Fatalf("want 2 objects, got %v", kvs)
+	}
+}
+
+func TestStandaloneBbolt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newCluster := func() *standaloneCluster {
+		opt := option.New()
+		opt.Name = "standalone-member"
+		opt.Standalone = true
+		opt.StandaloneStorage = "bbolt"
+		opt.AbsDataDir = dir
+
+		cls, err := New(opt)
+		if err != nil {
+			t.Fatalf("new standalone cluster failed: %v", err)
+		}
+		return cls.(*standaloneCluster)
+	}
+	closeCluster := func(c *standaloneCluster) {
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		c.Close(wg)
+		wg.Wait()
+	}
+
+	c := newCluster()
+	c.Put("/config/a", "1")
+	c.Put("/config/b", "2")
+	c.Delete("/config/b")
+	c.PutUnderLease("/status/a", "1")
+	closeCluster(c)
+
+	c = newCluster()
+	defer closeCluster(c)
+
+	kv, _ := c.GetRaw("/config/a")
+	if kv == nil || string(kv.Value) != "1" {
+		t.Fatalf("want /config/a persisted, got %v", kv)
+	}
+	if v, _ := c.Get("/config/b"); v != nil {
+		t.Fatalf("want /config/b deleted, got %s", *v)
+	}
+	if v, _ := c.Get("/status/a"); v != nil {
+		t.Fatalf("want /status/a not persisted, got %s", *v)
+	}
+
+	// Revision keeps increasing after restarting.
+	c.Put("/config/c", "3")
+	if newKV, _ := c.GetRaw("/config/c"); newKV.ModRevision <= kv.ModRevision {
+		t.Fatalf("revision %d not greater than %d", newKV.ModRevision, kv.ModRevision)
+	}
+}
@@ -35,8 +35,8 @@ import (
 // is to ensure data consistency, as Etcd watcher may be cancelled if it cannot catch
 // up with the key-value store.
 type Syncer struct {
-	cluster      *cluster
-	client       *clientv3.Client
+	cluster      Cluster
+	newWatcher   func() clientv3.Watcher
 	pullInterval time.Duration
 	done         chan struct{}
 }
@@ -47,8 +47,10 @@ func (c *cluster) Syncer(pullInterval time.Duration) (*Syncer, error) {
 		return nil, err
 	}
 	return &Syncer{
-		cluster:      c,
-		client:       client,
+		cluster: c,
+		newWatcher: func() clientv3.Watcher {
+			return clientv3.NewWatcher(client)
+		},
 		pullInterval: pullInterval,
 		done:         make(chan struct{}),
 	}, nil
@@ -82,7 +84,7 @@ func (s *Syncer) watch(key string, prefix bool) (clientv3.Watcher, clientv3.Watc
 	if prefix {
 		opts = append(opts, clientv3.WithPrefix())
 	}
-	watcher := clientv3.NewWatcher(s.client)
+	watcher := s.newWatcher()
 	watchChan := watcher.Watch(context.Background(), key, opts...)
 	logger.Debugf("watcher created for key %s (prefix: %v)", key, prefix)
 	return watcher, watchChan
@@ -18,11 +18,16 @@
 package contexttest
 
 import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"sync"
 	"time"
 
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/httpheader"
 	"github.com/megaease/easegress/pkg/util/httpstat"
 	"github.com/megaease/easegress/pkg/util/texttemplate"
 )
@@ -42,6 +47,7 @@ type MockedHTTPContext struct {
 	MockedValue              func(key interface{}) interface{}
 	MockedCancel             func(err error)
 	MockedCancelled          func() bool
+	MockedSetDeadline        func(deadline time.Time)
 	MockedClientDisconnected func() bool
 	MockedDuration           func() time.Duration
 	MockedOnFinish           func(func())
@@ -57,6 +63,54 @@ type MockedHTTPContext struct {
 	MockedSetHandlerCaller   func(caller context.HandlerCaller)
 }
 
+// NewMockedHTTPContext creates a MockedHTTPContext which serves req and
+// writes the response to w, the body of w is the initial response body.
+// Callers could still override any mocked function.
+func NewMockedHTTPContext(req *http.Request, w *httptest.ResponseRecorder) *MockedHTTPContext {
+	c := &MockedHTTPContext{}
+
+	var reqBody io.Reader = req.Body
+	c.MockedRequest.MockedRealIP = func() string {
+		host, _, _ := net.SplitHostPort(req.RemoteAddr)
+		return host
+	}
+	c.MockedRequest.MockedMethod = func() string { return req.Method }
+	c.MockedRequest.MockedSetMethod = func(method string) { req.Method = method }
+	c.MockedRequest.MockedScheme = func() string {
+		if req.TLS != nil {
+			return "https"
+		}
+		return "http"
+	}
+	c.MockedRequest.MockedHost = func() string { return req.Host }
+	c.MockedRequest.MockedSetHost = func(host string) { req.Host = host }
+	c.MockedRequest.MockedPath = func() string { return req.URL.Path }
+	c.MockedRequest.MockedSetPath = func(path string) { req.URL.Path = path }
+	c.MockedRequest.MockedEscapedPath = func() string { return req.URL.EscapedPath() }
+	c.MockedRequest.MockedQuery = func() string { return req.URL.RawQuery }
+	c.MockedRequest.MockedSetQuery = func(query string) { req.URL.RawQuery = query }
+	c.MockedRequest.MockedFragment = func() string { return req.URL.Fragment }
+	c.MockedRequest.MockedProto = func() string { return req.Proto }
+	c.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(req.Header) }
+	c.MockedRequest.MockedCookie = req.Cookie
+	c.MockedRequest.MockedCookies = req.Cookies
+	c.MockedRequest.MockedAddCookie = req.AddCookie
+	c.MockedRequest.MockedBody = func() io.Reader { return reqBody }
+	c.MockedRequest.MockedSetBody = func(body io.Reader) { reqBody = body }
+	c.MockedRequest.MockedStd = func() *http.Request { return req }
+
+	var respBody io.Reader = w.Body
+	c.MockedResponse.MockedStatusCode = func() int { return w.Code }
+	c.MockedResponse.MockedSetStatusCode = func(code int) { w.Code = code }
+	c.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(w.Header()) }
+	c.MockedResponse.MockedSetCookie = func(cookie *http.Cookie) { http.SetCookie(w, cookie) }
+	c.MockedResponse.MockedBody = func() io.Reader { return respBody }
+	c.MockedResponse.MockedSetBody = func(body io.Reader) { respBody = body }
+	c.MockedResponse.MockedStd = func() http.ResponseWriter { return w }
+
+	return c
+}
+
 // Lock mocks the Lock function of HTTPContext
 func (c *MockedHTTPContext) Lock() {
 	if c.MockedLock != nil {
@@ -128,6 +182,13 @@ func (c *MockedHTTPContext) Cancel(err error) {
 	}
 }
 
+// SetDeadline mocks the SetDeadline function of HTTPContext
+func (c *MockedHTTPContext) SetDeadline(deadline time.Time) {
+	if c.MockedSetDeadline != nil {
+		c.MockedSetDeadline(deadline)
+	}
+}
+
 // Cancelled mocks the Cancelled function of HTTPContext
 func (c *MockedHTTPContext) Cancelled() bool {
 	if c.MockedCancelled != nil {
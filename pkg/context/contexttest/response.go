@@ -27,15 +27,16 @@ import (
 
 // MockedHTTPResponse is the mocked HTTP response
 type MockedHTTPResponse struct {
-	MockedStatusCode    func() int
-	MockedSetStatusCode func(code int)
-	MockedHeader        func() *httpheader.HTTPHeader
-	MockedSetCookie     func(cookie *http.Cookie)
-	MockedSetBody       func(body io.Reader)
-	MockedBody          func() io.Reader
-	MockedOnFlushBody   func(func(body []byte, complete bool) (newBody []byte))
-	MockedStd           func() http.ResponseWriter
-	MockedSize          func() uint64
+	MockedStatusCode      func() int
+	MockedSetStatusCode   func(code int)
+	MockedHeader          func() *httpheader.HTTPHeader
+	MockedSetCookie       func(cookie *http.Cookie)
+	MockedSetBody         func(body io.Reader)
+	MockedBody            func() io.Reader
+	MockedOnFlushBody     func(func(body []byte, complete bool) (newBody []byte))
+	MockedWriteEarlyHints func(header http.Header) error
+	MockedStd             func() http.ResponseWriter
+	MockedSize            func() uint64
 }
 
 // StatusCode returns the status code
@@ -90,6 +91,14 @@ func (r *MockedHTTPResponse) OnFlushBody(fn func(body []byte, complete bool) (ne
 	}
 }
 
+// WriteEarlyHints writes a 103 Early Hints response
+func (r *MockedHTTPResponse) WriteEarlyHints(header http.Header) error {
+	if r.MockedWriteEarlyHints != nil {
+		return r.MockedWriteEarlyHints(header)
+	}
+	return nil
+}
+
 // Std returns the stardard response
 func (r *MockedHTTPResponse) Std() http.ResponseWriter {
 	if r.MockedStd != nil {
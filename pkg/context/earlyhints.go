@@ -0,0 +1,35 @@
+// +build !go1.19
+
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package context
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// EarlyHintsSupported reports whether 103 Early Hints could be written.
+// The net/http before Go 1.19 takes 1xx status codes as the final one.
+const EarlyHintsSupported = false
+
+var errEarlyHintsUnsupported = fmt.Errorf("early hints require Easegress built by Go 1.19 or later")
+
+func (w *httpResponse) writeEarlyHints(header http.Header) {
+	panic(errEarlyHintsUnsupported)
+}
@@ -0,0 +1,56 @@
+// +build go1.19
+
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package context
+
+import (
+	"net/http"
+)
+
+// EarlyHintsSupported reports whether 103 Early Hints could be written.
+// The net/http since Go 1.19 writes 1xx status codes as informational
+// responses.
+const EarlyHintsSupported = true
+
+var errEarlyHintsUnsupported error
+
+// writeEarlyHints must be called with the mutex held.
+func (w *httpResponse) writeEarlyHints(header http.Header) {
+	// NOTE: The standard library writes the header of the
+	// ResponseWriter in informational responses, which is the header of
+	// the final response here, so it's swapped with the hints temporarily.
+	std := w.std.Header()
+	final := make(http.Header, len(std))
+	for key, values := range std {
+		final[key] = values
+		delete(std, key)
+	}
+	for key, values := range header {
+		std[key] = values
+	}
+
+	w.std.WriteHeader(http.StatusEarlyHints)
+
+	for key := range std {
+		delete(std, key)
+	}
+	for key, values := range final {
+		std[key] = values
+	}
+}
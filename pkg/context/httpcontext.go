@@ -57,6 +57,10 @@ type (
 		stdcontext.Context
 		Cancel(err error)
 		Cancelled() bool
+		// SetDeadline sets the deadline of the context, which is only
+		// shortened. The context is cancelled at the deadline, so filters
+		// and outbound requests stop by ctx.Done().
+		SetDeadline(deadline time.Time)
 		ClientDisconnected() bool
 
 		Duration() time.Duration // For log, sample, etc.
@@ -122,6 +126,12 @@ type (
 		Body() io.Reader
 		OnFlushBody(func(body []byte, complete bool) (newBody []byte))
 
+		// WriteEarlyHints writes a 103 Early Hints response with the
+		// header only, the header of the final response is untouched.
+		// It must be called before the final response is written, and it
+		// fails unless EarlyHintsSupported.
+		WriteEarlyHints(header http.Header) error
+
 		Std() http.ResponseWriter
 
 		Size() uint64 // bytes
@@ -134,6 +144,11 @@ type (
 	httpContext struct {
 		mutex sync.Mutex
 
+		// stateMutex guards the fields changed by the deadline timer in
+		// its own goroutine, it's not exposed like mutex, so it's safe
+		// to be locked by SetDeadline while filters hold the lock.
+		stateMutex sync.Mutex
+
 		startTime   *time.Time
 		endTime     *time.Time
 		finishFuncs []FinishFunc
@@ -150,6 +165,9 @@ type (
 		stdctx         stdcontext.Context
 		cancelFunc     stdcontext.CancelFunc
 		err            error
+		deadline       time.Time
+		deadlineTimer  *time.Timer
+		finished       bool
 	}
 )
 
@@ -197,6 +215,8 @@ func (ctx *httpContext) Span() tracing.Span {
 }
 
 func (ctx *httpContext) AddTag(tag string) {
+	ctx.stateMutex.Lock()
+	defer ctx.stateMutex.Unlock()
 	ctx.tags = append(ctx.tags, tag)
 }
 
@@ -209,7 +229,39 @@ func (ctx *httpContext) Response() HTTPResponse {
 }
 
 func (ctx *httpContext) Deadline() (time.Time, bool) {
-	return ctx.stdctx.Deadline()
+	ctx.stateMutex.Lock()
+	defer ctx.stateMutex.Unlock()
+	return ctx.deadlineLocked()
+}
+
+func (ctx *httpContext) deadlineLocked() (time.Time, bool) {
+	d, ok := ctx.stdctx.Deadline()
+	if !ctx.deadline.IsZero() && (!ok || ctx.deadline.Before(d)) {
+		return ctx.deadline, true
+	}
+	return d, ok
+}
+
+func (ctx *httpContext) SetDeadline(deadline time.Time) {
+	ctx.stateMutex.Lock()
+	defer ctx.stateMutex.Unlock()
+
+	if d, ok := ctx.deadlineLocked(); ok && !deadline.Before(d) {
+		return
+	}
+	if ctx.finished {
+		// NOTE: The timer is never started after the context finished,
+		// as nothing would stop it.
+		return
+	}
+
+	ctx.deadline = deadline
+	if ctx.deadlineTimer != nil {
+		ctx.deadlineTimer.Stop()
+	}
+	ctx.deadlineTimer = time.AfterFunc(time.Until(deadline), func() {
+		ctx.Cancel(stdcontext.DeadlineExceeded)
+	})
 }
 
 func (ctx *httpContext) Done() <-chan struct{} {
@@ -217,8 +269,12 @@ func (ctx *httpContext) Done() <-chan struct{} {
 }
 
 func (ctx *httpContext) Err() error {
-	if ctx.err != nil {
-		return ctx.err
+	ctx.stateMutex.Lock()
+	err := ctx.err
+	ctx.stateMutex.Unlock()
+
+	if err != nil {
+		return err
 	}
 
 	return ctx.stdctx.Err()
@@ -229,11 +285,16 @@ func (ctx *httpContext) Value(key interface{}) interface{} {
 }
 
 func (ctx *httpContext) Cancel(err error) {
-	if !ctx.Cancelled() {
-		ctx.AddTag(stringtool.Cat("cancelErr: ", err.Error()))
-		ctx.err = err
-		ctx.cancelFunc()
+	ctx.stateMutex.Lock()
+	if ctx.err != nil || ctx.stdctx.Err() != nil {
+		ctx.stateMutex.Unlock()
+		return
 	}
+	ctx.tags = append(ctx.tags, stringtool.Cat("cancelErr: ", err.Error()))
+	ctx.err = err
+	ctx.stateMutex.Unlock()
+
+	ctx.cancelFunc()
 }
 
 func (ctx *httpContext) OnFinish(fn FinishFunc) {
@@ -241,7 +302,7 @@ func (ctx *httpContext) OnFinish(fn FinishFunc) {
 }
 
 func (ctx *httpContext) Cancelled() bool {
-	return ctx.err != nil || ctx.stdctx.Err() != nil
+	return ctx.Err() != nil
 }
 
 func (ctx *httpContext) Duration() time.Duration {
@@ -263,6 +324,14 @@ func (ctx *httpContext) Finish() {
 		ctx.w.SetStatusCode(EGStatusClientClosedRequest /* consistent with nginx */)
 	}
 
+	ctx.stateMutex.Lock()
+	ctx.finished = true
+	if ctx.deadlineTimer != nil {
+		ctx.deadlineTimer.Stop()
+		ctx.deadlineTimer = nil
+	}
+	ctx.stateMutex.Unlock()
+
 	ctx.r.finish()
 	ctx.w.finish()
 
@@ -314,7 +383,13 @@ func (ctx *httpContext) Log() string {
 		ctx.startTime.Format(timetool.RFC3339Milli),
 		stdr.RemoteAddr, ctx.r.RealIP(), stdr.Method, stdr.RequestURI, stdr.Proto, ctx.w.code,
 		ctx.Duration(), ctx.r.Size(), ctx.w.Size(),
-		strings.Join(ctx.tags, " | "))
+		strings.Join(ctx.copyTags(), " | "))
+}
+
+func (ctx *httpContext) copyTags() []string {
+	ctx.stateMutex.Lock()
+	defer ctx.stateMutex.Unlock()
+	return append([]string(nil), ctx.tags...)
 }
 
 // Template returns the template engine
@@ -19,10 +19,12 @@ package context
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/util/httpheader"
@@ -46,6 +48,11 @@ type (
 		body           io.Reader
 		bodyWritten    uint64
 		bodyFlushFuncs []BodyFlushFunc
+
+		// mutex guards finished and the writing of headers, as early
+		// hints may be forwarded by other goroutines.
+		mutex    sync.Mutex
+		finished bool
 	}
 )
 
@@ -155,13 +162,37 @@ func (w *httpResponse) flushBody() {
 	}
 }
 
+func (w *httpResponse) WriteEarlyHints(header http.Header) error {
+	// NOTE: Reference: https://tools.ietf.org/html/rfc7231#section-6.2
+	// A server MUST NOT send a 1xx response to an HTTP/1.0 client.
+	if !w.stdr.ProtoAtLeast(1, 1) {
+		return fmt.Errorf("early hints are unsupported by %s", w.stdr.Proto)
+	}
+	if !EarlyHintsSupported {
+		return errEarlyHintsUnsupported
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.finished {
+		return fmt.Errorf("final response is written")
+	}
+	w.writeEarlyHints(header)
+	return nil
+}
+
 func (w *httpResponse) FlushedBodyBytes() uint64 {
 	return w.bodyWritten
 }
 
 func (w *httpResponse) finish() {
+	w.mutex.Lock()
+	w.finished = true
 	// NOTE: WriteHeader must be called at most one time.
 	w.std.WriteHeader(w.StatusCode())
+	w.mutex.Unlock()
+
 	w.flushBody()
 }
 
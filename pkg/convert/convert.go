@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package convert converts the configurations of other gateways into the
+// specs of Easegress objects, for migrations to Easegress.
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	// Spec is the spec of an Easegress object, it keeps the order of the
+	// keys for readability of the output.
+	Spec = yaml.MapSlice
+
+	// Adaptor is the spec of a RequestAdaptor or ResponseAdaptor.
+	Adaptor struct {
+		Path Spec
+		Del  []string
+		Set  Spec
+		Add  Spec
+	}
+
+	// Result is the result of a conversion.
+	Result struct {
+		Specs []Spec
+		// Warnings are the configurations which are not converted, or
+		// converted with different semantics, they require manual steps.
+		Warnings []string
+	}
+)
+
+var invalidNameChars = regexp.MustCompile(`[^A-Za-z0-9\-_\.~]+`)
+
+// Warnf appends a warning to the result.
+func (r *Result) Warnf(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// YAML marshals the specs into YAML documents separated by `---`.
+func (r *Result) YAML() ([]byte, error) {
+	buff := &bytes.Buffer{}
+	for i, spec := range r.Specs {
+		if i > 0 {
+			buff.WriteString("---\n")
+		}
+		doc, err := yaml.Marshal(spec)
+		if err != nil {
+			return nil, err
+		}
+		buff.Write(doc)
+	}
+	return buff.Bytes(), nil
+}
+
+// ObjectName joins the parts by `-` into a valid name of objects and
+// filters, the invalid characters are replaced by `-`.
+func ObjectName(parts ...string) string {
+	name := strings.Join(parts, "-")
+	name = invalidNameChars.ReplaceAllString(name, "-")
+	name = strings.Trim(name, "-")
+	if len(name) > 253 {
+		name = name[:253]
+	}
+	return name
+}
+
+// Spec returns the spec of the adaptor of the kind.
+func (a *Adaptor) Spec(name, kind string) Spec {
+	spec := Spec{
+		{Key: "name", Value: name},
+		{Key: "kind", Value: kind},
+	}
+	if a.Path != nil {
+		spec = append(spec, yaml.MapItem{Key: "path", Value: a.Path})
+	}
+
+	header := Spec{}
+	if len(a.Del) != 0 {
+		header = append(header, yaml.MapItem{Key: "del", Value: a.Del})
+	}
+	if len(a.Set) != 0 {
+		header = append(header, yaml.MapItem{Key: "set", Value: a.Set})
+	}
+	if len(a.Add) != 0 {
+		header = append(header, yaml.MapItem{Key: "add", Value: a.Add})
+	}
+	// NOTE: The header of ResponseAdaptor is required.
+	if len(header) != 0 || kind == "ResponseAdaptor" {
+		spec = append(spec, yaml.MapItem{Key: "header", Value: header})
+	}
+	return spec
+}
+
+// ResilienceFilter returns the spec of a resilience filter, like
+// RateLimiter, which applies the policy to all requests of the methods,
+// empty methods means all methods.
+func ResilienceFilter(name, kind string, policy Spec, methods []string) Spec {
+	urlRule := Spec{}
+	if len(methods) != 0 {
+		urlRule = append(urlRule, yaml.MapItem{Key: "methods", Value: methods})
+	}
+	urlRule = append(urlRule,
+		yaml.MapItem{Key: "url", Value: Spec{{Key: "prefix", Value: "/"}}},
+		yaml.MapItem{Key: "policyRef", Value: policy[0].Value},
+	)
+
+	return Spec{
+		{Key: "name", Value: name},
+		{Key: "kind", Value: kind},
+		{Key: "policies", Value: []Spec{policy}},
+		{Key: "defaultPolicyRef", Value: policy[0].Value},
+		{Key: "urls", Value: []Spec{urlRule}},
+	}
+}
@@ -0,0 +1,589 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nginx
+
+import (
+	"fmt"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/convert"
+)
+
+type location struct {
+	modifier string
+	uri      string
+	path     convert.Spec
+}
+
+var (
+	variable         = regexp.MustCompile(`\$(\w+|\{\w+\})`)
+	replacementIndex = regexp.MustCompile(`\$(\d)`)
+
+	// templateVariables are the variables supported by templates of Mock.
+	templateVariables = map[string]string{
+		"host":           "{{.Request.Host}}",
+		"http_host":      "{{.Request.Host}}",
+		"uri":            "{{.Request.Path}}",
+		"request_uri":    "{{.Request.Path}}",
+		"request_method": "{{.Request.Method}}",
+	}
+)
+
+// convertLocations converts the locations into pipelines, and returns the
+// paths of HTTPServer in the order of nginx searching locations: exact
+// matches, prefixes with `^~` from the longest, regular expressions in
+// order, and the other prefixes from the longest.
+func (c *converter) convertLocations(label string, locations []*directive, parent *inherited) []convert.Spec {
+	var exact, preferred, regexps, prefixes []*location
+	for _, d := range locations {
+		l, err := parseLocation(d.args)
+		if err != nil {
+			c.result.Warnf("%s: %v", d.position(), err)
+			continue
+		}
+
+		inh := *parent
+		inh.collect(d.block)
+		backend := c.convertLocation(label, l, d, &inh)
+		if backend == "" {
+			continue
+		}
+
+		switch l.modifier {
+		case "=":
+			l.path = convert.Spec{{Key: "path", Value: l.uri}}
+			exact = append(exact, l)
+		case "^~":
+			l.path = convert.Spec{{Key: "pathPrefix", Value: l.uri}}
+			preferred = append(preferred, l)
+		case "~":
+			l.path = convert.Spec{{Key: "pathRegexp", Value: pcreRegexp(l.uri)}}
+			regexps = append(regexps, l)
+		case "~*":
+			l.path = convert.Spec{{Key: "pathRegexp", Value: "(?i)" + pcreRegexp(l.uri)}}
+			regexps = append(regexps, l)
+		default:
+			l.path = convert.Spec{{Key: "pathPrefix", Value: l.uri}}
+			prefixes = append(prefixes, l)
+		}
+		if ipFilter := c.convertAccess(inh.access); ipFilter != nil {
+			l.path = append(l.path, yaml.MapItem{Key: "ipFilter", Value: ipFilter})
+		}
+		l.path = append(l.path, yaml.MapItem{Key: "backend", Value: backend})
+	}
+
+	for _, locations := range [][]*location{preferred, prefixes} {
+		sort.SliceStable(locations, func(i, j int) bool {
+			return len(locations[i].uri) > len(locations[j].uri)
+		})
+	}
+
+	var paths []convert.Spec
+	for _, locations := range [][]*location{exact, preferred, regexps, prefixes} {
+		for _, l := range locations {
+			paths = append(paths, l.path)
+		}
+	}
+	return paths
+}
+
+func parseLocation(args []string) (*location, error) {
+	switch len(args) {
+	case 1:
+		if strings.HasPrefix(args[0], "@") {
+			return nil, fmt.Errorf("named location %s is not supported", args[0])
+		}
+		// NOTE: The modifier could be adjacent to the uri, like =/foo.
+		for _, modifier := range []string{"=", "^~", "~*", "~"} {
+			if strings.HasPrefix(args[0], modifier) {
+				return parseLocation([]string{modifier, args[0][len(modifier):]})
+			}
+		}
+		return &location{uri: args[0]}, nil
+	case 2:
+		switch args[0] {
+		case "=", "^~", "~", "~*":
+		default:
+			return nil, fmt.Errorf("invalid location modifier %s", args[0])
+		}
+		l := &location{modifier: args[0], uri: args[1]}
+		if l.modifier == "~" || l.modifier == "~*" {
+			if _, err := regexp.Compile(pcreRegexp(l.uri)); err != nil {
+				return nil, fmt.Errorf("invalid regular expression of location: %v", err)
+			}
+		}
+		return l, nil
+	default:
+		return nil, fmt.Errorf("invalid number of arguments in location")
+	}
+}
+
+// convertLocation converts the location into a pipeline, and returns its
+// name, the name is empty if the location is skipped.
+func (c *converter) convertLocation(label string, l *location, d *directive, inh *inherited) string {
+	var proxyPass, ret *directive
+	var rewrites []*directive
+	for _, sub := range d.block {
+		switch sub.name {
+		case "proxy_set_header", "add_header", "limit_req", "allow", "deny":
+		case "proxy_pass":
+			proxyPass = sub
+		case "rewrite":
+			rewrites = append(rewrites, sub)
+		case "return":
+			if ret == nil {
+				ret = sub
+			}
+		case "location":
+			c.result.Warnf("%s: nested location is not supported", sub.position())
+		default:
+			c.unsupported(sub)
+		}
+	}
+
+	var filters []convert.Spec
+	if rateLimiter := c.convertLimitReq(inh.limitReqs); rateLimiter != nil {
+		filters = append(filters, rateLimiter)
+	}
+
+	switch {
+	case ret != nil:
+		mock := c.convertReturn(ret)
+		if mock == nil {
+			return ""
+		}
+		filters = append(filters, mock)
+	case proxyPass != nil:
+		proxy, uri := c.convertProxyPass(proxyPass)
+		if proxy == nil {
+			return ""
+		}
+		adaptor := &convert.Adaptor{}
+		c.convertPath(l, adaptor, rewrites, uri, proxyPass)
+		c.convertProxySetHeaders(adaptor, inh.proxySetHeaders)
+		if adaptor.Path != nil || adaptor.Set != nil || adaptor.Del != nil {
+			filters = append(filters, adaptor.Spec("request-adaptor", "RequestAdaptor"))
+		}
+		filters = append(filters, proxy)
+		if responseAdaptor := c.convertAddHeaders(inh.addHeaders); responseAdaptor != nil {
+			filters = append(filters, responseAdaptor)
+		}
+	default:
+		c.result.Warnf("%s: location without proxy_pass or return is skipped", d.position())
+		return ""
+	}
+
+	name := c.pipelineName(label, l.uri)
+	flow := []convert.Spec{}
+	for _, f := range filters {
+		flow = append(flow, convert.Spec{{Key: "filter", Value: f[0].Value}})
+	}
+	c.pipelineSpecs = append(c.pipelineSpecs, convert.Spec{
+		{Key: "name", Value: name},
+		{Key: "kind", Value: "HTTPPipeline"},
+		{Key: "flow", Value: flow},
+		{Key: "filters", Value: filters},
+	})
+	return name
+}
+
+func (c *converter) pipelineName(label, uri string) string {
+	part := strings.Trim(uri, "/")
+	if part == "" {
+		part = "root"
+	}
+	base := convert.ObjectName(c.opts.Name, label, part)
+	name := base
+	for i := 2; c.pipelines[name]; i++ {
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+	c.pipelines[name] = true
+	return name
+}
+
+// convertPath converts the path rewriting of the location, the uri of
+// proxy_pass replaces the part matching the location, and is ignored if
+// the path is rewritten.
+func (c *converter) convertPath(l *location, adaptor *convert.Adaptor, rewrites []*directive, uri string, proxyPass *directive) {
+	if len(rewrites) != 0 {
+		d := rewrites[0]
+		if len(rewrites) > 1 {
+			c.result.Warnf("%s: only the first rewrite of the location is converted", rewrites[1].position())
+		}
+		if len(d.args) < 2 {
+			c.result.Warnf("%s: invalid number of arguments in rewrite", d.position())
+			return
+		}
+		if len(d.args) > 2 && (d.args[2] == "redirect" || d.args[2] == "permanent") {
+			c.result.Warnf("%s: rewrite with flag %s is not supported", d.position(), d.args[2])
+			return
+		}
+		re := pcreRegexp(d.args[0])
+		if _, err := regexp.Compile(re); err != nil {
+			c.result.Warnf("%s: invalid regular expression of rewrite: %v", d.position(), err)
+			return
+		}
+		if variable.MatchString(replacementIndex.ReplaceAllString(d.args[1], "")) {
+			c.result.Warnf("%s: variables in replacement of rewrite are not supported", d.position())
+			return
+		}
+		adaptor.Path = convert.Spec{{Key: "regexpReplace", Value: convert.Spec{
+			{Key: "regexp", Value: re},
+			{Key: "replace", Value: replacementIndex.ReplaceAllString(d.args[1], "$${$1}")},
+		}}}
+		return
+	}
+
+	if uri == "" {
+		return
+	}
+	if l.modifier == "~" || l.modifier == "~*" {
+		c.result.Warnf("%s: uri of proxy_pass in location with regular expression is not supported", proxyPass.position())
+		return
+	}
+	adaptor.Path = convert.Spec{{Key: "regexpReplace", Value: convert.Spec{
+		{Key: "regexp", Value: "^" + regexp.QuoteMeta(l.uri)},
+		{Key: "replace", Value: strings.ReplaceAll(uri, "$", "$$")},
+	}}}
+}
+
+func (c *converter) convertProxySetHeaders(adaptor *convert.Adaptor, directives []*directive) {
+	for _, d := range directives {
+		if len(d.args) != 2 {
+			c.result.Warnf("%s: invalid number of arguments in proxy_set_header", d.position())
+			continue
+		}
+		key, value := d.args[0], d.args[1]
+		switch {
+		case strings.EqualFold(key, "Host") && (value == "$host" || value == "$http_host"):
+			// NOTE: Proxy keeps the host of the requests.
+		case strings.EqualFold(key, "X-Forwarded-For") && value == "$proxy_add_x_forwarded_for":
+			// NOTE: It's converted into xForwardedFor of HTTPServer.
+		case value == "":
+			adaptor.Del = append(adaptor.Del, key)
+		case variable.MatchString(value):
+			c.warnOnce(d, "%s: variables in proxy_set_header %s are not supported", d.position(), key)
+		default:
+			adaptor.Set = append(adaptor.Set, yaml.MapItem{Key: key, Value: value})
+		}
+	}
+}
+
+func (c *converter) convertAddHeaders(directives []*directive) convert.Spec {
+	adaptor := &convert.Adaptor{}
+	for _, d := range directives {
+		if len(d.args) < 2 {
+			c.result.Warnf("%s: invalid number of arguments in add_header", d.position())
+			continue
+		}
+		if variable.MatchString(d.args[1]) {
+			c.warnOnce(d, "%s: variables in add_header %s are not supported", d.position(), d.args[0])
+			continue
+		}
+		adaptor.Add = append(adaptor.Add, yaml.MapItem{Key: d.args[0], Value: d.args[1]})
+	}
+	if adaptor.Add == nil {
+		return nil
+	}
+	return adaptor.Spec("response-adaptor", "ResponseAdaptor")
+}
+
+func (c *converter) convertAccess(directives []*directive) convert.Spec {
+	if len(directives) == 0 {
+		return nil
+	}
+
+	var allowIPs, blockIPs []string
+	blockByDefault := false
+	for _, d := range directives {
+		if len(d.args) != 1 {
+			c.result.Warnf("%s: invalid number of arguments in %s", d.position(), d.name)
+			continue
+		}
+		if d.args[0] == "all" {
+			blockByDefault = d.name == "deny"
+			continue
+		}
+		if d.name == "allow" {
+			allowIPs = append(allowIPs, d.args[0])
+		} else {
+			blockIPs = append(blockIPs, d.args[0])
+		}
+	}
+
+	ipFilter := convert.Spec{{Key: "blockByDefault", Value: blockByDefault}}
+	if allowIPs != nil {
+		ipFilter = append(ipFilter, yaml.MapItem{Key: "allowIPs", Value: allowIPs})
+	}
+	if blockIPs != nil {
+		ipFilter = append(ipFilter, yaml.MapItem{Key: "blockIPs", Value: blockIPs})
+	}
+	return ipFilter
+}
+
+func (c *converter) convertReturn(d *directive) convert.Spec {
+	code, text := 302, ""
+	switch len(d.args) {
+	case 1:
+		n, err := strconv.Atoi(d.args[0])
+		if err == nil {
+			code = n
+		} else {
+			text = d.args[0]
+		}
+	case 2:
+		n, err := strconv.Atoi(d.args[0])
+		if err != nil {
+			c.result.Warnf("%s: invalid code of return", d.position())
+			return nil
+		}
+		code, text = n, d.args[1]
+	default:
+		c.result.Warnf("%s: invalid number of arguments in return", d.position())
+		return nil
+	}
+	if code < 100 || code > 999 {
+		c.result.Warnf("%s: invalid code of return", d.position())
+		return nil
+	}
+
+	template := false
+	var unsupported []string
+	text = variable.ReplaceAllStringFunc(text, func(v string) string {
+		name := strings.Trim(v[1:], "{}")
+		if t, exists := templateVariables[name]; exists {
+			template = true
+			if name == "request_uri" {
+				c.warnOnce(d, "%s: $request_uri is converted without the query", d.position())
+			}
+			return t
+		}
+		unsupported = append(unsupported, v)
+		return v
+	})
+	if len(unsupported) != 0 {
+		c.result.Warnf("%s: variables %s in return are not supported", d.position(), strings.Join(unsupported, ", "))
+		return nil
+	}
+
+	rule := convert.Spec{
+		{Key: "pathPrefix", Value: "/"},
+		{Key: "code", Value: code},
+	}
+	switch code {
+	case 301, 302, 303, 307, 308:
+		rule = append(rule, yaml.MapItem{Key: "headers", Value: convert.Spec{{Key: "Location", Value: text}}})
+	default:
+		if text != "" {
+			rule = append(rule, yaml.MapItem{Key: "body", Value: text})
+		}
+	}
+	if template {
+		rule = append(rule, yaml.MapItem{Key: "template", Value: true})
+	}
+
+	return convert.Spec{
+		{Key: "name", Value: "return"},
+		{Key: "kind", Value: "Mock"},
+		{Key: "rules", Value: []convert.Spec{rule}},
+	}
+}
+
+func (c *converter) convertLimitReq(directives []*directive) convert.Spec {
+	if len(directives) == 0 {
+		return nil
+	}
+	d := directives[0]
+	if len(directives) > 1 {
+		c.warnOnce(directives[1], "%s: only the first limit_req is converted", directives[1].position())
+	}
+
+	zone, burst, nodelay := "", 0, false
+	for _, arg := range d.args {
+		switch {
+		case strings.HasPrefix(arg, "zone="):
+			zone = strings.TrimPrefix(arg, "zone=")
+		case strings.HasPrefix(arg, "burst="):
+			burst, _ = strconv.Atoi(strings.TrimPrefix(arg, "burst="))
+		case arg == "nodelay":
+			nodelay = true
+		}
+	}
+	z := c.zones[zone]
+	if z == nil {
+		c.warnOnce(d, "%s: zone %s of limit_req is not defined", d.position(), zone)
+		return nil
+	}
+
+	var rate string
+	for _, arg := range z.args {
+		if strings.HasPrefix(arg, "rate=") {
+			rate = strings.TrimPrefix(arg, "rate=")
+		}
+	}
+	period := time.Second
+	if strings.HasSuffix(rate, "r/m") {
+		period = time.Minute
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSuffix(rate, "r/s"), "r/m"))
+	if err != nil || n < 1 {
+		c.warnOnce(z, "%s: invalid rate %s of limit_req_zone", z.position(), rate)
+		return nil
+	}
+	c.warnOnce(z, "%s: key %s of limit_req_zone is ignored, the limit is applied to all clients of every Easegress instance", z.position(), z.args[0])
+
+	// NOTE: The requests are evenly spaced like nginx, and the burst
+	// requests wait for permissions unless nodelay.
+	refresh := period / time.Duration(n)
+	timeout := time.Duration(0)
+	if burst > 0 && nodelay {
+		c.warnOnce(d, "%s: burst with nodelay of limit_req is not supported, requests exceeding the rate are rejected", d.position())
+	} else {
+		timeout = refresh * time.Duration(burst)
+	}
+
+	policy := convert.Spec{
+		{Key: "name", Value: zone},
+		{Key: "timeoutDuration", Value: timeout.String()},
+		{Key: "limitRefreshPeriod", Value: refresh.String()},
+		{Key: "limitForPeriod", Value: 1},
+	}
+	return convert.ResilienceFilter("rate-limiter", "RateLimiter", policy, nil)
+}
+
+// convertProxyPass converts the proxy_pass into a Proxy, and returns the
+// uri of it.
+func (c *converter) convertProxyPass(d *directive) (convert.Spec, string) {
+	if len(d.args) != 1 {
+		c.result.Warnf("%s: invalid number of arguments in proxy_pass", d.position())
+		return nil, ""
+	}
+	if variable.MatchString(d.args[0]) {
+		c.result.Warnf("%s: variables in proxy_pass are not supported", d.position())
+		return nil, ""
+	}
+	u, err := url.Parse(d.args[0])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.result.Warnf("%s: proxy_pass %s is not supported", d.position(), d.args[0])
+		return nil, ""
+	}
+
+	pool := c.pools[u.Scheme+"://"+u.Host]
+	if pool == nil {
+		if upstream := c.upstreams[u.Host]; upstream != nil {
+			pool = c.convertUpstream(u.Scheme, upstream)
+		} else {
+			server := convert.Spec{{Key: "url", Value: u.Scheme + "://" + u.Host}}
+			pool = convert.Spec{
+				{Key: "servers", Value: []convert.Spec{server}},
+				{Key: "loadBalance", Value: convert.Spec{{Key: "policy", Value: "roundRobin"}}},
+			}
+		}
+		c.pools[u.Scheme+"://"+u.Host] = pool
+	}
+
+	proxy := convert.Spec{
+		{Key: "name", Value: "proxy"},
+		{Key: "kind", Value: "Proxy"},
+		{Key: "mainPool", Value: pool},
+	}
+	return proxy, u.EscapedPath()
+}
+
+func (c *converter) convertUpstream(scheme string, upstream *directive) convert.Spec {
+	var servers []convert.Spec
+	loadBalance := convert.Spec{{Key: "policy", Value: "roundRobin"}}
+	weighted := false
+	for _, d := range upstream.block {
+		switch d.name {
+		case "server":
+			if len(d.args) == 0 || strings.HasPrefix(d.args[0], "unix:") {
+				c.result.Warnf("%s: server %s of upstream is not supported", d.position(), strings.Join(d.args, " "))
+				continue
+			}
+			server := convert.Spec{{Key: "url", Value: scheme + "://" + d.args[0]}}
+			skip := false
+			for _, arg := range d.args[1:] {
+				switch {
+				case strings.HasPrefix(arg, "weight="):
+					weight, err := strconv.Atoi(strings.TrimPrefix(arg, "weight="))
+					if err != nil || weight < 1 || weight > 100 {
+						c.result.Warnf("%s: weight of server must be in [1, 100]", d.position())
+						continue
+					}
+					server, weighted = append(server, yaml.MapItem{Key: "weight", Value: weight}), true
+				case arg == "down":
+					skip = true
+				case arg == "backup":
+					c.result.Warnf("%s: backup server %s is not supported", d.position(), d.args[0])
+					skip = true
+				default:
+					c.result.Warnf("%s: parameter %s of server is not supported", d.position(), arg)
+				}
+			}
+			if !skip {
+				servers = append(servers, server)
+			}
+		case "ip_hash":
+			loadBalance = convert.Spec{{Key: "policy", Value: "ipHash"}}
+		case "random":
+			loadBalance = convert.Spec{{Key: "policy", Value: "random"}}
+		case "hash":
+			if len(d.args) == 0 || !strings.HasPrefix(d.args[0], "$http_") {
+				c.result.Warnf("%s: hash is only supported by the variables of headers", d.position())
+				continue
+			}
+			key := textproto.CanonicalMIMEHeaderKey(strings.ReplaceAll(strings.TrimPrefix(d.args[0], "$http_"), "_", "-"))
+			loadBalance = convert.Spec{
+				{Key: "policy", Value: "headerHash"},
+				{Key: "headerHashKey", Value: key},
+			}
+		case "keepalive", "keepalive_requests", "keepalive_timeout":
+			// NOTE: Proxy keeps the connections alive.
+		default:
+			c.unsupported(d)
+		}
+	}
+
+	if weighted && loadBalance[0].Value == "roundRobin" {
+		loadBalance[0].Value = "weightedRandom"
+	}
+	return convert.Spec{
+		{Key: "servers", Value: servers},
+		{Key: "loadBalance", Value: loadBalance},
+	}
+}
+
+// warnOnce reports the warning of the directive only once, for directives
+// inherited by many locations.
+func (c *converter) warnOnce(d *directive, format string, args ...interface{}) {
+	key := d.position() + format
+	if c.warned[key] {
+		return
+	}
+	c.warned[key] = true
+	c.result.Warnf(format, args...)
+}
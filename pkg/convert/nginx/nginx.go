@@ -0,0 +1,469 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package nginx converts the nginx configuration into HTTPServers and
+// HTTPPipelines of Easegress.
+package nginx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/convert"
+)
+
+type (
+	// Options are the options of the conversion.
+	Options struct {
+		// Name is the prefix of the names of the objects.
+		Name string
+		// Dir is the directory of the relative paths in the configuration,
+		// it's the directory of the configuration file if empty.
+		Dir string
+	}
+
+	converter struct {
+		opts      *Options
+		dir       string
+		result    *convert.Result
+		upstreams map[string]*directive
+		zones     map[string]*directive
+		// pools are the converted upstreams.
+		pools map[string]convert.Spec
+		// warned is the warnings reported, to report only once.
+		warned map[string]bool
+
+		keepAliveTimeout string
+		servers          map[uint16]*httpServer
+		defaultServers   map[uint16]bool
+		pipelines        map[string]bool
+		pipelineSpecs    []convert.Spec
+	}
+
+	// httpServer is the HTTPServer of a port.
+	httpServer struct {
+		port          uint16
+		https         bool
+		xForwardedFor bool
+		certs         convert.Spec
+		keys          convert.Spec
+		rules         []convert.Spec
+		defaultRule   convert.Spec
+	}
+
+	// inherited is the directives inherited from the outer levels, the
+	// directives of a kind are inherited only if the level has none of
+	// the kind, the same as nginx.
+	inherited struct {
+		proxySetHeaders []*directive
+		addHeaders      []*directive
+		limitReqs       []*directive
+		access          []*directive
+	}
+
+	listen struct {
+		port          uint16
+		ssl           bool
+		defaultServer bool
+	}
+)
+
+// Convert converts the http servers of the nginx configuration file into
+// an HTTPServer for every listening port, and an HTTPPipeline for every
+// location. The directives which can't be converted are reported as
+// warnings of the result.
+func Convert(file string, opts *Options) (*convert.Result, error) {
+	dir := opts.Dir
+	if dir == "" {
+		dir = filepath.Dir(file)
+	}
+	directives, err := parseFile(file, dir, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &converter{
+		opts:           opts,
+		dir:            dir,
+		result:         &convert.Result{},
+		upstreams:      map[string]*directive{},
+		zones:          map[string]*directive{},
+		pools:          map[string]convert.Spec{},
+		warned:         map[string]bool{},
+		servers:        map[uint16]*httpServer{},
+		defaultServers: map[uint16]bool{},
+		pipelines:      map[string]bool{},
+	}
+
+	found := false
+	for _, d := range directives {
+		if d.name == "http" && d.block != nil {
+			found = true
+			c.convertHTTP(d)
+			continue
+		}
+		c.unsupported(d)
+	}
+	if !found {
+		return nil, fmt.Errorf("no http block found in %s", file)
+	}
+	if len(c.servers) == 0 {
+		return nil, fmt.Errorf("no server converted from %s", file)
+	}
+
+	ports := []int{}
+	for port := range c.servers {
+		ports = append(ports, int(port))
+	}
+	sort.Ints(ports)
+	for _, port := range ports {
+		c.result.Specs = append(c.result.Specs, c.servers[uint16(port)].spec(c))
+	}
+	c.result.Specs = append(c.result.Specs, c.pipelineSpecs...)
+
+	return c.result, nil
+}
+
+func (c *converter) unsupported(d *directive) {
+	c.result.Warnf("%s: directive %s is not supported", d.position(), d.name)
+}
+
+func (c *converter) convertHTTP(http *directive) {
+	// NOTE: Upstreams and zones could be defined after the servers using
+	// them.
+	for _, d := range http.block {
+		switch d.name {
+		case "upstream":
+			if len(d.args) == 1 && d.block != nil {
+				c.upstreams[d.args[0]] = d
+			}
+		case "limit_req_zone":
+			for _, arg := range d.args {
+				if strings.HasPrefix(arg, "zone=") {
+					name := strings.SplitN(strings.TrimPrefix(arg, "zone="), ":", 2)[0]
+					c.zones[name] = d
+				}
+			}
+		case "server":
+			for _, listen := range d.block {
+				if listen.name != "listen" {
+					continue
+				}
+				if l, err := parseListen(listen.args); err == nil && l.defaultServer {
+					c.defaultServers[l.port] = true
+				}
+			}
+		}
+	}
+
+	inh := &inherited{}
+	inh.collect(http.block)
+	for _, d := range http.block {
+		switch d.name {
+		case "upstream", "limit_req_zone", "proxy_set_header", "add_header", "limit_req", "allow", "deny":
+		case "keepalive_timeout":
+			if len(d.args) > 0 {
+				c.keepAliveTimeout = nginxDuration(d.args[0])
+			}
+		case "server":
+			if d.block != nil {
+				c.convertServer(d, inh)
+			}
+		default:
+			c.unsupported(d)
+		}
+	}
+}
+
+// collect overrides the inherited directives by the ones of the level.
+func (inh *inherited) collect(directives []*directive) {
+	var proxySetHeaders, addHeaders, limitReqs, access []*directive
+	for _, d := range directives {
+		switch d.name {
+		case "proxy_set_header":
+			proxySetHeaders = append(proxySetHeaders, d)
+		case "add_header":
+			addHeaders = append(addHeaders, d)
+		case "limit_req":
+			limitReqs = append(limitReqs, d)
+		case "allow", "deny":
+			access = append(access, d)
+		}
+	}
+	if proxySetHeaders != nil {
+		inh.proxySetHeaders = proxySetHeaders
+	}
+	if addHeaders != nil {
+		inh.addHeaders = addHeaders
+	}
+	if limitReqs != nil {
+		inh.limitReqs = limitReqs
+	}
+	if access != nil {
+		inh.access = access
+	}
+}
+
+func (c *converter) convertServer(server *directive, parent *inherited) {
+	inh := *parent
+	inh.collect(server.block)
+
+	var listens []*listen
+	var names []string
+	var locations, returns []*directive
+	var cert, key string
+	for _, d := range server.block {
+		switch d.name {
+		case "proxy_set_header", "add_header", "limit_req", "allow", "deny":
+		case "listen":
+			l, err := parseListen(d.args)
+			if err != nil {
+				c.result.Warnf("%s: %v", d.position(), err)
+				continue
+			}
+			listens = append(listens, l)
+		case "server_name":
+			names = append(names, d.args...)
+		case "ssl_certificate":
+			cert = c.readFile(d)
+		case "ssl_certificate_key":
+			key = c.readFile(d)
+		case "location":
+			locations = append(locations, d)
+		case "return":
+			returns = append(returns, d)
+		default:
+			c.unsupported(d)
+		}
+	}
+	if len(listens) == 0 {
+		listens = []*listen{{port: 80}}
+	}
+
+	label := "default"
+	for _, name := range names {
+		if name != "_" && name != "" {
+			label = strings.Trim(name, "~^$*.")
+			break
+		}
+	}
+
+	// NOTE: The return directive of the server level returns before
+	// searching the locations.
+	var paths []convert.Spec
+	if len(returns) != 0 {
+		loc := &directive{name: "location", args: []string{"/"}, block: returns, file: server.file, line: returns[0].line}
+		paths = c.convertLocations(label, []*directive{loc}, &inh)
+		if len(locations) != 0 {
+			c.result.Warnf("%s: locations are skipped by the return of the server", server.position())
+		}
+	} else {
+		paths = c.convertLocations(label, locations, &inh)
+	}
+	if len(paths) == 0 {
+		c.result.Warnf("%s: no location converted, the server is skipped", server.position())
+		return
+	}
+
+	hostRegexp := serverNamesRegexp(names)
+	for _, l := range listens {
+		s := c.servers[l.port]
+		if s == nil {
+			s = &httpServer{port: l.port}
+			c.servers[l.port] = s
+		}
+		for _, d := range inh.proxySetHeaders {
+			if len(d.args) == 2 && strings.EqualFold(d.args[0], "X-Forwarded-For") && d.args[1] == "$proxy_add_x_forwarded_for" {
+				s.xForwardedFor = true
+			}
+		}
+
+		if l.ssl {
+			s.https = true
+			if cert == "" || key == "" {
+				c.result.Warnf("%s: ssl_certificate and ssl_certificate_key are required by ssl of port %d", server.position(), l.port)
+			} else {
+				domain := label
+				for _, item := range s.certs {
+					if item.Key == domain {
+						domain = fmt.Sprintf("%s-%d", label, len(s.certs))
+					}
+				}
+				s.certs = append(s.certs, yaml.MapItem{Key: domain, Value: cert})
+				s.keys = append(s.keys, yaml.MapItem{Key: domain, Value: key})
+			}
+		}
+
+		// NOTE: The default server serves the requests matching no server
+		// names, it's the first server of the port if none is specified.
+		isDefault := l.defaultServer || (!c.defaultServers[l.port] && s.defaultRule == nil && len(s.rules) == 0)
+		switch {
+		case isDefault && s.defaultRule == nil:
+			s.defaultRule = convert.Spec{{Key: "paths", Value: paths}}
+		case hostRegexp == "":
+			c.result.Warnf("%s: server without names is not the default server of port %d, it's skipped", server.position(), l.port)
+		default:
+			s.rules = append(s.rules, convert.Spec{
+				{Key: "hostRegexp", Value: hostRegexp},
+				{Key: "paths", Value: paths},
+			})
+		}
+	}
+}
+
+func (c *converter) readFile(d *directive) string {
+	if len(d.args) != 1 {
+		c.result.Warnf("%s: invalid number of arguments in %s", d.position(), d.name)
+		return ""
+	}
+	file := d.args[0]
+	if strings.Contains(file, "$") {
+		c.result.Warnf("%s: variables in %s are not supported", d.position(), d.name)
+		return ""
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(c.dir, file)
+	}
+	buff, err := ioutil.ReadFile(file)
+	if err != nil {
+		c.result.Warnf("%s: read %s failed: %v", d.position(), file, err)
+		return ""
+	}
+	return string(buff)
+}
+
+func parseListen(args []string) (*listen, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("invalid number of arguments in listen")
+	}
+
+	addr := args[0]
+	if strings.HasPrefix(addr, "unix:") {
+		return nil, fmt.Errorf("listening on unix socket %s is not supported", addr)
+	}
+	port := addr
+	if strings.Contains(addr, ":") || strings.Contains(addr, ".") || strings.HasPrefix(addr, "[") {
+		_, p, err := net.SplitHostPort(addr)
+		if err != nil {
+			// NOTE: An address without port listens on port 80.
+			p = "80"
+		}
+		port = p
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || n == 0 {
+		return nil, fmt.Errorf("invalid port of listen %s", addr)
+	}
+
+	l := &listen{port: uint16(n)}
+	for _, arg := range args[1:] {
+		switch arg {
+		case "ssl":
+			l.ssl = true
+		case "default_server", "default":
+			l.defaultServer = true
+		}
+	}
+	return l, nil
+}
+
+// serverNamesRegexp converts the server names into a regular expression
+// of hosts, which ignores the ports.
+func serverNamesRegexp(names []string) string {
+	var alternatives []string
+	for _, name := range names {
+		name = strings.ToLower(name)
+		switch {
+		case name == "" || name == "_":
+			continue
+		case strings.HasPrefix(name, "~"):
+			re := strings.TrimSuffix(strings.TrimPrefix(name[1:], "^"), "$")
+			alternatives = append(alternatives, "(?:"+pcreRegexp(re)+")")
+		case strings.HasPrefix(name, "*."):
+			alternatives = append(alternatives, `.+`+regexp.QuoteMeta(name[1:]))
+		case strings.HasPrefix(name, "."):
+			alternatives = append(alternatives, `(?:.+\.)?`+regexp.QuoteMeta(name[1:]))
+		case strings.HasSuffix(name, ".*"):
+			alternatives = append(alternatives, regexp.QuoteMeta(name[:len(name)-1])+`[^:]+`)
+		default:
+			alternatives = append(alternatives, regexp.QuoteMeta(name))
+		}
+	}
+	if len(alternatives) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(`^(?:%s)(?::\d+)?$`, strings.Join(alternatives, "|"))
+}
+
+var pcreNamedGroup = regexp.MustCompile(`\(\?<([A-Za-z_][A-Za-z0-9_]*)>`)
+
+// pcreRegexp converts the named groups of PCRE to the syntax of Go.
+func pcreRegexp(re string) string {
+	return pcreNamedGroup.ReplaceAllString(re, "(?P<$1>")
+}
+
+// nginxDuration converts the time of nginx, whose default unit is second,
+// into a Go duration.
+func nginxDuration(s string) string {
+	if _, err := strconv.Atoi(s); err == nil {
+		return s + "s"
+	}
+	return s
+}
+
+func (s *httpServer) spec(c *converter) convert.Spec {
+	spec := convert.Spec{
+		{Key: "name", Value: convert.ObjectName(c.opts.Name, strconv.Itoa(int(s.port)))},
+		{Key: "kind", Value: "HTTPServer"},
+		{Key: "port", Value: s.port},
+	}
+	switch c.keepAliveTimeout {
+	case "":
+		spec = append(spec, yaml.MapItem{Key: "keepAlive", Value: true})
+	case "0s":
+		spec = append(spec, yaml.MapItem{Key: "keepAlive", Value: false})
+	default:
+		spec = append(spec,
+			yaml.MapItem{Key: "keepAlive", Value: true},
+			yaml.MapItem{Key: "keepAliveTimeout", Value: c.keepAliveTimeout},
+		)
+	}
+	spec = append(spec, yaml.MapItem{Key: "https", Value: s.https})
+	if len(s.certs) != 0 {
+		spec = append(spec,
+			yaml.MapItem{Key: "certs", Value: s.certs},
+			yaml.MapItem{Key: "keys", Value: s.keys},
+		)
+	}
+	if s.xForwardedFor {
+		spec = append(spec, yaml.MapItem{Key: "xForwardedFor", Value: true})
+	}
+
+	rules := s.rules
+	if s.defaultRule != nil {
+		rules = append(rules, s.defaultRule)
+	}
+	return append(spec, yaml.MapItem{Key: "rules", Value: rules})
+}
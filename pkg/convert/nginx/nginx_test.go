@@ -0,0 +1,337 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nginx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+const nginxConf = `
+worker_processes 4;
+events { worker_connections 1024; }
+http {
+    keepalive_timeout 65;
+    limit_req_zone $binary_remote_addr zone=api:10m rate=10r/s;
+    upstream backend {
+        server 10.0.0.1:8080 weight=3;
+        server 10.0.0.2:8080;
+        server 10.0.0.3:8080 backup;
+    }
+    include conf.d/*.conf;
+}
+`
+
+const siteConf = `
+server {
+    listen 80;
+    server_name example.com;
+    return 301 https://$host$request_uri;
+}
+server {
+    listen 443 ssl;
+    server_name example.com;
+    ssl_certificate cert.pem;
+    ssl_certificate_key key.pem;
+    proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+    location / {
+        proxy_pass http://backend;
+    }
+    location /api/ {
+        limit_req zone=api burst=5;
+        proxy_set_header X-Gateway "easegress";  # comment
+        add_header X-Served-By nginx;
+        proxy_pass http://backend/v1/;
+    }
+    location = /healthz {
+        return 200 'ok';
+    }
+    location ~* \.(png|jpg)$ {
+        root /var/www;
+    }
+    location ^~ /admin/ {
+        allow 10.0.0.0/8;
+        deny all;
+        rewrite ^/admin/(.*)$ /internal/$1 break;
+        proxy_pass https://admin.internal:8443;
+    }
+}
+server {
+    listen 443 ssl;
+    server_name *.example.org;
+    ssl_certificate cert.pem;
+    ssl_certificate_key key.pem;
+    location / {
+        proxy_pass http://10.0.0.4:8080;
+    }
+}
+`
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "nginx")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %v", err)
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(file), 0o755)
+		if err := ioutil.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s failed: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestTokenize(t *testing.T) {
+	tokens, err := tokenize(`a "b c" 'd\'e' f\;g ${h}i; # comment
+location ~ \.php$ { }`, "test.conf")
+	if err != nil {
+		t.Fatalf("tokenize failed: %v", err)
+	}
+	texts := []string{}
+	for _, t := range tokens {
+		texts = append(texts, t.text)
+	}
+	expected := []string{"a", "b c", "d'e", `f\;g`, "${h}i", ";", "location", "~", `\.php$`, "{", "}"}
+	if strings.Join(texts, "|") != strings.Join(expected, "|") {
+		t.Errorf("unexpected tokens %q", texts)
+	}
+	if tokens[len(tokens)-1].line != 2 {
+		t.Errorf("unexpected line %d", tokens[len(tokens)-1].line)
+	}
+
+	if _, err := tokenize(`a "b;`, "test.conf"); err == nil {
+		t.Errorf("tokenize should fail")
+	}
+}
+
+func TestParse(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"nginx.conf":  "http { include sub.conf; }",
+		"sub.conf":    "server { listen 80; }",
+		"cycle.conf":  "include cycle.conf;",
+		"broken.conf": "http { server {}",
+	})
+	defer os.RemoveAll(dir)
+
+	directives, err := parseFile(filepath.Join(dir, "nginx.conf"), dir, map[string]bool{})
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	server := directives[0].block[0]
+	if server.name != "server" || server.block[0].name != "listen" || server.block[0].args[0] != "80" {
+		t.Errorf("unexpected directives %+v", server)
+	}
+
+	for _, file := range []string{"cycle.conf", "broken.conf", "missing.conf"} {
+		if _, err := parseFile(filepath.Join(dir, file), dir, map[string]bool{}); err == nil {
+			t.Errorf("parse %s should fail", file)
+		}
+	}
+}
+
+func TestServerNamesRegexp(t *testing.T) {
+	re := regexp.MustCompile(serverNamesRegexp([]string{"example.com", "*.example.org", ".example.net", "www.example.*", "~^(?<user>\\w+)\\.example\\.io$"}))
+	for _, host := range []string{"example.com", "example.com:8080", "a.b.example.org", "example.net", "www.example.net", "www.example.cn", "bob.example.io"} {
+		if !re.MatchString(host) {
+			t.Errorf("%s should match %s", re, host)
+		}
+	}
+	for _, host := range []string{"api.example.com", "example.org", "a.b.example.io"} {
+		if re.MatchString(host) {
+			t.Errorf("%s should not match %s", re, host)
+		}
+	}
+	if serverNamesRegexp([]string{"_"}) != "" {
+		t.Errorf("invalid name should be ignored")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"nginx.conf":         nginxConf,
+		"conf.d/site.conf":   siteConf,
+		"cert.pem":           "cert",
+		"key.pem":            "key",
+		"conf.d/ignored.txt": "ignored",
+	})
+	defer os.RemoveAll(dir)
+
+	result, err := Convert(filepath.Join(dir, "nginx.conf"), &Options{Name: "nginx"})
+	if err != nil {
+		t.Fatalf("convert failed: %v", err)
+	}
+
+	warnings := strings.Join(result.Warnings, "\n")
+	for _, w := range []string{
+		"directive worker_processes is not supported",
+		"backup server 10.0.0.3:8080 is not supported",
+		"directive root is not supported",
+		"key $binary_remote_addr of limit_req_zone is ignored",
+	} {
+		if !strings.Contains(warnings, w) {
+			t.Errorf("warning %q not found in %s", w, warnings)
+		}
+	}
+
+	buff, err := result.YAML()
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	docs := strings.Split(string(buff), "---\n")
+	if len(docs) != 8 {
+		t.Fatalf("expected 8 objects, got %d:\n%s", len(docs), buff)
+	}
+
+	type path struct {
+		Path       string `yaml:"path"`
+		PathPrefix string `yaml:"pathPrefix"`
+		PathRegexp string `yaml:"pathRegexp"`
+		IPFilter   *struct {
+			BlockByDefault bool     `yaml:"blockByDefault"`
+			AllowIPs       []string `yaml:"allowIPs"`
+		} `yaml:"ipFilter"`
+		Backend string `yaml:"backend"`
+	}
+	type server struct {
+		Name             string            `yaml:"name"`
+		Port             uint16            `yaml:"port"`
+		KeepAliveTimeout string            `yaml:"keepAliveTimeout"`
+		HTTPS            bool              `yaml:"https"`
+		XForwardedFor    bool              `yaml:"xForwardedFor"`
+		Certs            map[string]string `yaml:"certs"`
+		Rules            []struct {
+			HostRegexp string  `yaml:"hostRegexp"`
+			Paths      []*path `yaml:"paths"`
+		} `yaml:"rules"`
+	}
+
+	http := &server{}
+	yaml.Unmarshal([]byte(docs[0]), http)
+	if http.Name != "nginx-80" || http.Port != 80 || http.KeepAliveTimeout != "65s" || http.HTTPS || len(http.Rules) != 1 {
+		t.Errorf("unexpected server:\n%s", docs[0])
+	}
+
+	https := &server{}
+	yaml.Unmarshal([]byte(docs[1]), https)
+	if https.Port != 443 || !https.HTTPS || !https.XForwardedFor || https.Certs["example.com"] != "cert" || len(https.Rules) != 2 {
+		t.Fatalf("unexpected server:\n%s", docs[1])
+	}
+	// NOTE: The first server is the default one, so it's the last rule.
+	if https.Rules[0].HostRegexp == "" || https.Rules[1].HostRegexp != "" {
+		t.Errorf("unexpected rules:\n%s", docs[1])
+	}
+	paths := https.Rules[1].Paths
+	order := []string{}
+	for _, p := range paths {
+		order = append(order, p.Path+p.PathPrefix+p.PathRegexp)
+	}
+	if strings.Join(order, " ") != "/healthz /admin/ /api/ /" {
+		t.Errorf("unexpected order of paths %v", order)
+	}
+	if ip := paths[1].IPFilter; ip == nil || !ip.BlockByDefault || ip.AllowIPs[0] != "10.0.0.0/8" {
+		t.Errorf("unexpected ip filter %+v", ip)
+	}
+
+	type filter struct {
+		Kind  string `yaml:"kind"`
+		Rules []struct {
+			Code     int               `yaml:"code"`
+			Headers  map[string]string `yaml:"headers"`
+			Body     string            `yaml:"body"`
+			Template bool              `yaml:"template"`
+		} `yaml:"rules"`
+		Policies []struct {
+			TimeoutDuration    string `yaml:"timeoutDuration"`
+			LimitRefreshPeriod string `yaml:"limitRefreshPeriod"`
+		} `yaml:"policies"`
+		Path struct {
+			RegexpReplace struct {
+				Regexp  string `yaml:"regexp"`
+				Replace string `yaml:"replace"`
+			} `yaml:"regexpReplace"`
+		} `yaml:"path"`
+		Header struct {
+			Set map[string]string `yaml:"set"`
+			Add map[string]string `yaml:"add"`
+		} `yaml:"header"`
+		MainPool struct {
+			Servers []struct {
+				URL    string `yaml:"url"`
+				Weight int    `yaml:"weight"`
+			} `yaml:"servers"`
+			LoadBalance struct {
+				Policy string `yaml:"policy"`
+			} `yaml:"loadBalance"`
+		} `yaml:"mainPool"`
+	}
+	pipelines := map[string][]*filter{}
+	for _, doc := range docs[2:] {
+		pipeline := struct {
+			Name    string    `yaml:"name"`
+			Filters []*filter `yaml:"filters"`
+		}{}
+		yaml.Unmarshal([]byte(doc), &pipeline)
+		pipelines[pipeline.Name] = pipeline.Filters
+	}
+
+	redirect := pipelines[http.Rules[0].Paths[0].Backend]
+	if len(redirect) != 1 || redirect[0].Rules[0].Code != 301 || !redirect[0].Rules[0].Template ||
+		redirect[0].Rules[0].Headers["Location"] != "https://{{.Request.Host}}{{.Request.Path}}" {
+		t.Errorf("unexpected redirect %+v", redirect)
+	}
+
+	api := pipelines["nginx-example.com-api"]
+	if len(api) != 4 || api[0].Kind != "RateLimiter" || api[3].Kind != "ResponseAdaptor" {
+		t.Fatalf("unexpected filters of api %+v", api)
+	}
+	if p := api[0].Policies[0]; p.LimitRefreshPeriod != "100ms" || p.TimeoutDuration != "500ms" {
+		t.Errorf("unexpected rate limit policy %+v", p)
+	}
+	replace := api[1].Path.RegexpReplace
+	if got := regexp.MustCompile(replace.Regexp).ReplaceAllString("/api/users", replace.Replace); got != "/v1/users" {
+		t.Errorf("unexpected path %s", got)
+	}
+	if api[1].Header.Set["X-Gateway"] != "easegress" || api[3].Header.Add["X-Served-By"] != "nginx" {
+		t.Errorf("unexpected headers %+v %+v", api[1].Header, api[3].Header)
+	}
+	pool := api[2].MainPool
+	if len(pool.Servers) != 2 || pool.Servers[0].Weight != 3 || pool.LoadBalance.Policy != "weightedRandom" {
+		t.Errorf("unexpected pool %+v", pool)
+	}
+
+	healthz := pipelines["nginx-example.com-healthz"]
+	if len(healthz) != 1 || healthz[0].Rules[0].Code != 200 || healthz[0].Rules[0].Body != "ok" {
+		t.Errorf("unexpected healthz %+v", healthz)
+	}
+
+	admin := pipelines["nginx-example.com-admin"]
+	replace = admin[0].Path.RegexpReplace
+	if got := regexp.MustCompile(replace.Regexp).ReplaceAllString("/admin/users", replace.Replace); got != "/internal/users" {
+		t.Errorf("unexpected path %s", got)
+	}
+	if admin[1].MainPool.Servers[0].URL != "https://admin.internal:8443" {
+		t.Errorf("unexpected pool %+v", admin[1].MainPool)
+	}
+}
@@ -0,0 +1,248 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nginx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// directive is a directive of the nginx configuration, block is nil if
+	// the directive is a simple one.
+	directive struct {
+		name  string
+		args  []string
+		block []*directive
+		file  string
+		line  int
+	}
+
+	token struct {
+		text   string
+		quoted bool
+		line   int
+	}
+
+	parser struct {
+		file   string
+		dir    string
+		tokens []*token
+		pos    int
+		// includes is the files being parsed, to detect the include cycles.
+		includes map[string]bool
+	}
+)
+
+// maxIncludeDepth limits the nested includes.
+const maxIncludeDepth = 16
+
+// parseFile parses the nginx configuration file, the relative paths of
+// the include directives are relative to dir.
+func parseFile(file, dir string, includes map[string]bool) ([]*directive, error) {
+	if includes[file] {
+		return nil, fmt.Errorf("include cycle of %s", file)
+	}
+	if len(includes) >= maxIncludeDepth {
+		return nil, fmt.Errorf("too many nested includes at %s", file)
+	}
+
+	buff, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := tokenize(string(buff), file)
+	if err != nil {
+		return nil, err
+	}
+
+	includes[file] = true
+	defer delete(includes, file)
+
+	p := &parser{file: file, dir: dir, tokens: tokens, includes: includes}
+	directives, err := p.parseBlock(false)
+	if err != nil {
+		return nil, err
+	}
+	return directives, nil
+}
+
+func (p *parser) parseBlock(inBlock bool) ([]*directive, error) {
+	directives := []*directive{}
+	for {
+		if p.pos >= len(p.tokens) {
+			if inBlock {
+				return nil, fmt.Errorf("%s: unexpected end of file, expecting \"}\"", p.file)
+			}
+			return directives, nil
+		}
+
+		t := p.tokens[p.pos]
+		p.pos++
+		if !t.quoted {
+			switch t.text {
+			case "}":
+				if !inBlock {
+					return nil, fmt.Errorf("%s:%d: unexpected \"}\"", p.file, t.line)
+				}
+				return directives, nil
+			case "{", ";":
+				return nil, fmt.Errorf("%s:%d: unexpected %q", p.file, t.line, t.text)
+			}
+		}
+
+		d := &directive{name: t.text, file: p.file, line: t.line}
+		for {
+			if p.pos >= len(p.tokens) {
+				return nil, fmt.Errorf("%s: unexpected end of file, expecting \";\" or \"}\"", p.file)
+			}
+			t := p.tokens[p.pos]
+			p.pos++
+			if !t.quoted && t.text == ";" {
+				break
+			}
+			if !t.quoted && t.text == "{" {
+				block, err := p.parseBlock(true)
+				if err != nil {
+					return nil, err
+				}
+				d.block = block
+				break
+			}
+			if !t.quoted && t.text == "}" {
+				return nil, fmt.Errorf("%s:%d: unexpected \"}\"", p.file, t.line)
+			}
+			d.args = append(d.args, t.text)
+		}
+
+		if d.name != "include" || d.block != nil {
+			directives = append(directives, d)
+			continue
+		}
+
+		included, err := p.include(d)
+		if err != nil {
+			return nil, err
+		}
+		directives = append(directives, included...)
+	}
+}
+
+func (p *parser) include(d *directive) ([]*directive, error) {
+	if len(d.args) != 1 {
+		return nil, fmt.Errorf("%s:%d: invalid number of arguments in include", d.file, d.line)
+	}
+
+	pattern := d.args[0]
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(p.dir, pattern)
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%d: %v", d.file, d.line, err)
+	}
+	// NOTE: Include without wildcards requires the file to exist.
+	if len(files) == 0 && !strings.ContainsAny(d.args[0], "*?[") {
+		return nil, fmt.Errorf("%s:%d: include file %s not found", d.file, d.line, pattern)
+	}
+
+	var directives []*directive
+	for _, file := range files {
+		included, err := parseFile(file, p.dir, p.includes)
+		if err != nil {
+			return nil, err
+		}
+		directives = append(directives, included...)
+	}
+	return directives, nil
+}
+
+// tokenize splits the configuration into tokens, the special characters
+// `{`, `}` and `;` are separate tokens unless they are quoted.
+func tokenize(s, file string) ([]*token, error) {
+	var tokens []*token
+	line := 1
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case c == '{' || c == '}' || c == ';':
+			tokens = append(tokens, &token{text: string(c), line: line})
+			i++
+		case c == '"' || c == '\'':
+			start := line
+			b := &strings.Builder{}
+			i++
+			for ; i < len(s) && s[i] != c; i++ {
+				if s[i] == '\\' && i+1 < len(s) && (s[i+1] == c || s[i+1] == '\\') {
+					i++
+				}
+				if s[i] == '\n' {
+					line++
+				}
+				b.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("%s:%d: unterminated quoted string", file, start)
+			}
+			i++
+			tokens = append(tokens, &token{text: b.String(), quoted: true, line: start})
+		default:
+			b := &strings.Builder{}
+			for i < len(s) {
+				c := s[i]
+				if c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == ';' || c == '{' || c == '}' {
+					// NOTE: Variables could be in the form of ${name}.
+					if c == '{' && i > 0 && s[i-1] == '$' {
+						end := strings.IndexByte(s[i:], '}')
+						if end > 0 {
+							b.WriteString(s[i : i+end+1])
+							i += end + 1
+							continue
+						}
+					}
+					break
+				}
+				if c == '\\' && i+1 < len(s) {
+					b.WriteByte(c)
+					i++
+					c = s[i]
+				}
+				b.WriteByte(c)
+				i++
+			}
+			tokens = append(tokens, &token{text: b.String(), line: line})
+		}
+	}
+	return tokens, nil
+}
+
+// position returns the position of the directive for warnings.
+func (d *directive) position() string {
+	return fmt.Sprintf("%s:%d", d.file, d.line)
+}
@@ -0,0 +1,361 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package springcloudgateway
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/convert"
+)
+
+type (
+	// pipeline is the converted filters of a route.
+	pipeline struct {
+		redirect        convert.Spec
+		rateLimiter     convert.Spec
+		requestAdaptors []*convert.Adaptor
+		retryer         convert.Spec
+		circuitBreaker  convert.Spec
+		proxy           convert.Spec
+		responseAdaptor *convert.Adaptor
+	}
+)
+
+var (
+	javaReplacementVar   = regexp.MustCompile(`\$\\?\{`)
+	javaReplacementIndex = regexp.MustCompile(`\$(\d+)`)
+	templateVar          = regexp.MustCompile(`\{([A-Za-z][A-Za-z0-9]*)\}`)
+)
+
+func (cv *converter) convertFilter(id string, f *definition, m *matcher, p *pipeline) {
+	warnDuplicated := func(spec convert.Spec) bool {
+		if spec != nil {
+			cv.result.Warnf("route %s: only the first %s filter is converted", id, f.Name)
+		}
+		return spec != nil
+	}
+
+	switch f.Name {
+	case "AddRequestHeader":
+		a := p.requestAdaptor()
+		a.Add = append(a.Add, yaml.MapItem{Key: f.arg(0, "name"), Value: f.arg(1, "value")})
+	case "SetRequestHeader":
+		a := p.requestAdaptor()
+		a.Set = append(a.Set, yaml.MapItem{Key: f.arg(0, "name"), Value: f.arg(1, "value")})
+	case "RemoveRequestHeader":
+		a := p.requestAdaptor()
+		a.Del = append(a.Del, f.arg(0, "name"))
+	case "AddResponseHeader":
+		a := p.responseHeaderAdaptor()
+		a.Add = append(a.Add, yaml.MapItem{Key: f.arg(0, "name"), Value: f.arg(1, "value")})
+	case "SetResponseHeader":
+		a := p.responseHeaderAdaptor()
+		a.Set = append(a.Set, yaml.MapItem{Key: f.arg(0, "name"), Value: f.arg(1, "value")})
+	case "RemoveResponseHeader":
+		a := p.responseHeaderAdaptor()
+		a.Del = append(a.Del, f.arg(0, "name"))
+	case "StripPrefix":
+		parts, err := strconv.Atoi(f.arg(0, "parts"))
+		if err != nil || parts < 1 {
+			cv.result.Warnf("route %s: invalid parts of StripPrefix filter", id)
+			return
+		}
+		p.pathAdaptor().Path = convert.Spec{{Key: "regexpReplace", Value: convert.Spec{
+			{Key: "regexp", Value: fmt.Sprintf("^(?:/[^/]*){%d}", parts)},
+			{Key: "replace", Value: ""},
+		}}}
+	case "PrefixPath":
+		p.pathAdaptor().Path = convert.Spec{{Key: "addPrefix", Value: f.arg(0, "prefix")}}
+	case "RewritePath":
+		re, err := javaRegexp(f.arg(0, "regexp"))
+		if err != nil {
+			cv.result.Warnf("route %s: regexp of RewritePath filter is invalid: %v", id, err)
+			return
+		}
+		replacement := javaReplacementVar.ReplaceAllString(f.arg(1, "replacement"), "${")
+		replacement = javaReplacementIndex.ReplaceAllString(replacement, "$${$1}")
+		p.pathAdaptor().Path = convert.Spec{{Key: "regexpReplace", Value: convert.Spec{
+			{Key: "regexp", Value: re},
+			{Key: "replace", Value: replacement},
+		}}}
+	case "SetPath":
+		if len(m.patterns) != 1 {
+			cv.result.Warnf("route %s: SetPath filter requires exactly one pattern of Path predicate", id)
+			return
+		}
+		re := "^" + antRegexp(m.patterns[0], '/', true) + "$"
+		names := map[string]bool{}
+		for _, name := range regexp.MustCompile(re).SubexpNames() {
+			names[name] = true
+		}
+		template := strings.ReplaceAll(f.arg(0, "template"), "$", "$$")
+		for _, match := range templateVar.FindAllStringSubmatch(template, -1) {
+			if !names[match[1]] {
+				cv.result.Warnf("route %s: variable %s of SetPath filter is not in the Path predicate", id, match[1])
+				return
+			}
+		}
+		p.pathAdaptor().Path = convert.Spec{{Key: "regexpReplace", Value: convert.Spec{
+			{Key: "regexp", Value: re},
+			{Key: "replace", Value: templateVar.ReplaceAllString(template, "$${$1}")},
+		}}}
+	case "RedirectTo":
+		if warnDuplicated(p.redirect) {
+			return
+		}
+		code, err := strconv.Atoi(f.arg(0, "status"))
+		if err != nil || code < 300 || code > 399 {
+			cv.result.Warnf("route %s: invalid status of RedirectTo filter", id)
+			return
+		}
+		rule := convert.Spec{
+			{Key: "pathPrefix", Value: "/"},
+			{Key: "code", Value: code},
+			{Key: "headers", Value: convert.Spec{{Key: "Location", Value: f.arg(1, "url")}}},
+		}
+		p.redirect = convert.Spec{
+			{Key: "name", Value: "redirect"},
+			{Key: "kind", Value: "Mock"},
+			{Key: "rules", Value: []convert.Spec{rule}},
+		}
+	case "RequestRateLimiter":
+		if warnDuplicated(p.rateLimiter) {
+			return
+		}
+		rate, err := strconv.Atoi(f.arg(-1, "redis-rate-limiter.replenishRate"))
+		if err != nil || rate < 1 {
+			cv.result.Warnf("route %s: RequestRateLimiter filter without redis-rate-limiter.replenishRate is not supported", id)
+			return
+		}
+		cv.result.Warnf("route %s: RequestRateLimiter filter is converted to a RateLimiter of every Easegress instance, regardless of the key-resolver", id)
+		policy := convert.Spec{
+			{Key: "name", Value: "default"},
+			{Key: "timeoutDuration", Value: "0s"},
+			{Key: "limitRefreshPeriod", Value: "1s"},
+			{Key: "limitForPeriod", Value: rate},
+		}
+		p.rateLimiter = convert.ResilienceFilter("rate-limiter", "RateLimiter", policy, nil)
+	case "Retry":
+		if warnDuplicated(p.retryer) {
+			return
+		}
+		p.retryer = cv.convertRetry(id, f)
+	case "CircuitBreaker":
+		if warnDuplicated(p.circuitBreaker) {
+			return
+		}
+		if fallback := f.arg(1, "fallbackUri"); fallback != "" {
+			cv.result.Warnf("route %s: fallbackUri %s of CircuitBreaker filter is not supported", id, fallback)
+		}
+		policy := convert.Spec{
+			{Key: "name", Value: "default"},
+			{Key: "slidingWindowType", Value: "COUNT_BASED"},
+			{Key: "failureRateThreshold", Value: 50},
+			{Key: "slidingWindowSize", Value: 100},
+			{Key: "countingNetworkError", Value: true},
+		}
+		if codes := cv.statusCodes(id, f.values(-1, "statusCodes")); len(codes) != 0 {
+			policy = append(policy, yaml.MapItem{Key: "failureStatusCodes", Value: codes})
+		}
+		p.circuitBreaker = convert.ResilienceFilter("circuit-breaker", "CircuitBreaker", policy, nil)
+	default:
+		cv.result.Warnf("route %s: %s filter is not supported", id, f.Name)
+	}
+}
+
+func (cv *converter) convertRetry(id string, f *definition) convert.Spec {
+	retries := 3
+	if s := f.arg(0, "retries"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			cv.result.Warnf("route %s: invalid retries of Retry filter", id)
+			return nil
+		}
+		retries = n
+	}
+
+	codes := cv.statusCodes(id, f.values(-1, "statuses"))
+	series := f.values(-1, "series")
+	if len(codes) == 0 && len(series) == 0 {
+		series = []string{"SERVER_ERROR"}
+	}
+	for _, s := range series {
+		var from int
+		switch s {
+		case "INFORMATIONAL":
+			from = 100
+		case "SUCCESSFUL":
+			from = 200
+		case "REDIRECTION":
+			from = 300
+		case "CLIENT_ERROR":
+			from = 400
+		case "SERVER_ERROR":
+			from = 500
+		default:
+			cv.result.Warnf("route %s: invalid series %s of Retry filter", id, s)
+			continue
+		}
+		for code := from; code < from+100; code++ {
+			if http.StatusText(code) != "" {
+				codes = append(codes, code)
+			}
+		}
+	}
+
+	policy := convert.Spec{
+		{Key: "name", Value: "default"},
+		{Key: "maxAttempts", Value: retries + 1},
+	}
+	if s := f.arg(-1, "backoff.firstBackoff"); s != "" {
+		d, err := springDuration(s)
+		if err != nil {
+			cv.result.Warnf("route %s: invalid backoff.firstBackoff of Retry filter", id)
+		} else {
+			policy = append(policy,
+				yaml.MapItem{Key: "waitDuration", Value: d.String()},
+				yaml.MapItem{Key: "backOffPolicy", Value: "exponential"},
+			)
+		}
+	}
+	policy = append(policy,
+		yaml.MapItem{Key: "countingNetworkError", Value: true},
+		yaml.MapItem{Key: "failureStatusCodes", Value: codes},
+	)
+
+	methods := []string{}
+	for _, method := range f.values(-1, "methods") {
+		methods = append(methods, strings.ToUpper(method))
+	}
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet}
+	}
+
+	return convert.ResilienceFilter("retryer", "Retryer", policy, methods)
+}
+
+// statusCodes converts the status codes in numbers or names of the Java
+// enum HttpStatus, like BAD_GATEWAY.
+func (cv *converter) statusCodes(id string, values []string) []int {
+	var codes []int
+	for _, value := range values {
+		for _, s := range strings.Split(value, ",") {
+			s = strings.TrimSpace(s)
+			if code, err := strconv.Atoi(s); err == nil {
+				codes = append(codes, code)
+				continue
+			}
+
+			found := false
+			for code := 100; code < 600 && !found; code++ {
+				name := strings.ToUpper(strings.ReplaceAll(http.StatusText(code), " ", "_"))
+				if name != "" && name == strings.ReplaceAll(s, "-", "_") {
+					codes, found = append(codes, code), true
+				}
+			}
+			if !found {
+				cv.result.Warnf("route %s: unknown status code %s", id, s)
+			}
+		}
+	}
+	return codes
+}
+
+// springDuration parses the durations of Spring, whose default unit is
+// milliseconds.
+func springDuration(s string) (time.Duration, error) {
+	if ms, err := strconv.Atoi(s); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	return time.ParseDuration(strings.ToLower(s))
+}
+
+// requestAdaptor returns the request adaptor for headers.
+func (p *pipeline) requestAdaptor() *convert.Adaptor {
+	if len(p.requestAdaptors) == 0 {
+		p.requestAdaptors = append(p.requestAdaptors, &convert.Adaptor{})
+	}
+	return p.requestAdaptors[0]
+}
+
+// pathAdaptor returns a request adaptor without path adaptation, as a
+// RequestAdaptor adapts the path only once.
+func (p *pipeline) pathAdaptor() *convert.Adaptor {
+	if len(p.requestAdaptors) != 0 {
+		if last := p.requestAdaptors[len(p.requestAdaptors)-1]; last.Path == nil {
+			return last
+		}
+	}
+	a := &convert.Adaptor{}
+	p.requestAdaptors = append(p.requestAdaptors, a)
+	return a
+}
+
+func (p *pipeline) responseHeaderAdaptor() *convert.Adaptor {
+	if p.responseAdaptor == nil {
+		p.responseAdaptor = &convert.Adaptor{}
+	}
+	return p.responseAdaptor
+}
+
+// spec returns the spec of the pipeline, the filters run in the order of
+// redirect, rate limiting, request adaption, retry, circuit breaking,
+// proxy and response adaption.
+func (p *pipeline) spec(name string) convert.Spec {
+	var flow, filters []convert.Spec
+	appendFilter := func(filter convert.Spec) {
+		if filter == nil {
+			return
+		}
+		flow = append(flow, convert.Spec{{Key: "filter", Value: filter[0].Value}})
+		filters = append(filters, filter)
+	}
+
+	if p.redirect != nil {
+		appendFilter(p.redirect)
+		flow[0] = append(flow[0], yaml.MapItem{Key: "jumpIf", Value: convert.Spec{{Key: "mocked", Value: "END"}}})
+	}
+	appendFilter(p.rateLimiter)
+	for i, a := range p.requestAdaptors {
+		name := "request-adaptor"
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d", name, i+1)
+		}
+		appendFilter(a.Spec(name, "RequestAdaptor"))
+	}
+	appendFilter(p.retryer)
+	appendFilter(p.circuitBreaker)
+	appendFilter(p.proxy)
+	if p.responseAdaptor != nil {
+		appendFilter(p.responseAdaptor.Spec("response-adaptor", "ResponseAdaptor"))
+	}
+
+	return convert.Spec{
+		{Key: "name", Value: name},
+		{Key: "kind", Value: "HTTPPipeline"},
+		{Key: "flow", Value: flow},
+		{Key: "filters", Value: filters},
+	}
+}
@@ -0,0 +1,331 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package springcloudgateway
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/convert"
+)
+
+type (
+	// definition is the definition of a predicate or filter, in either the
+	// shortcut form `Name=arg0,arg1` or the fully expanded form.
+	definition struct {
+		Name string
+		Args []*arg
+	}
+
+	// arg is an argument of a definition, the key of the arguments in the
+	// shortcut form is empty.
+	arg struct {
+		key   string
+		value string
+	}
+
+	// matcher is the converted predicates of a route.
+	matcher struct {
+		hostRegexp  string
+		patterns    []string
+		methods     []string
+		headerKey   string
+		headerRE    string
+		remoteAddrs []string
+	}
+)
+
+var javaNamedGroup = regexp.MustCompile(`\(\?<([A-Za-z][A-Za-z0-9]*)>`)
+
+// UnmarshalYAML unmarshals both forms of the definition.
+func (d *definition) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var shortcut string
+	if err := unmarshal(&shortcut); err == nil {
+		name, args := shortcut, ""
+		if i := strings.IndexByte(shortcut, '='); i >= 0 {
+			name, args = shortcut[:i], shortcut[i+1:]
+		}
+		d.Name = strings.TrimSpace(name)
+		if args != "" {
+			for _, v := range strings.Split(args, ",") {
+				d.Args = append(d.Args, &arg{value: strings.TrimSpace(v)})
+			}
+		}
+		return nil
+	}
+
+	full := struct {
+		Name string        `yaml:"name"`
+		Args yaml.MapSlice `yaml:"args"`
+	}{}
+	if err := unmarshal(&full); err != nil {
+		return err
+	}
+	d.Name = full.Name
+	for _, item := range full.Args {
+		key := fmt.Sprint(item.Key)
+		values, ok := item.Value.([]interface{})
+		if !ok {
+			values = []interface{}{item.Value}
+		}
+		for _, v := range values {
+			if v == nil {
+				v = ""
+			}
+			d.Args = append(d.Args, &arg{key: key, value: fmt.Sprint(v)})
+		}
+	}
+	return nil
+}
+
+// arg returns the argument of the key, or the argument at the index if
+// no argument has the key, which is how Spring binds the shortcut form.
+// A negative index means the argument is only bound by the key.
+func (d *definition) arg(index int, key string) string {
+	for _, a := range d.Args {
+		if a.key == key {
+			return a.value
+		}
+	}
+	if index >= 0 && index < len(d.Args) && (d.Args[index].key == "" || strings.HasPrefix(d.Args[index].key, "_genkey_")) {
+		return d.Args[index].value
+	}
+	return ""
+}
+
+// values returns the arguments of the key, or the arguments from the index
+// if no argument has the key. A negative index means the arguments are
+// only bound by the key.
+func (d *definition) values(index int, key string) []string {
+	var values []string
+	for _, a := range d.Args {
+		if a.key == key {
+			values = append(values, a.value)
+		}
+	}
+	if values != nil {
+		return values
+	}
+	for i := index; i >= 0 && i < len(d.Args); i++ {
+		if a := d.Args[i]; a.key == "" || strings.HasPrefix(a.key, "_genkey_") {
+			values = append(values, a.value)
+		}
+	}
+	return values
+}
+
+func (cv *converter) convertPredicates(id string, predicates []*definition) *matcher {
+	m := &matcher{}
+	converted := map[string]bool{}
+	for _, p := range predicates {
+		if converted[p.Name] {
+			cv.result.Warnf("route %s: only the first %s predicate is converted", id, p.Name)
+			continue
+		}
+
+		switch p.Name {
+		case "Path":
+			for _, pattern := range p.values(0, "patterns") {
+				// NOTE: The trailing argument of the shortcut form may be
+				// matchTrailingSlash.
+				if pattern == "true" || pattern == "false" {
+					continue
+				}
+				if !strings.HasPrefix(pattern, "/") {
+					pattern = "/" + pattern
+				}
+				m.patterns = append(m.patterns, pattern)
+			}
+		case "Host":
+			var alternatives []string
+			for _, pattern := range p.values(0, "patterns") {
+				alternatives = append(alternatives, antRegexp(pattern, '.', false))
+			}
+			m.hostRegexp = fmt.Sprintf(`^(?:%s)(?::\d+)?$`, strings.Join(alternatives, "|"))
+		case "Method":
+			for _, method := range p.values(0, "methods") {
+				m.methods = append(m.methods, strings.ToUpper(method))
+			}
+		case "Header":
+			re := p.arg(1, "regexp")
+			if re == "" {
+				re = ".*"
+			}
+			re, err := javaRegexp(re)
+			if err != nil {
+				cv.result.Warnf("route %s: regexp of Header predicate is invalid: %v", id, err)
+				continue
+			}
+			m.headerKey, m.headerRE = p.arg(0, "header"), "^(?:"+re+")$"
+		case "RemoteAddr":
+			m.remoteAddrs = p.values(0, "sources")
+			cv.result.Warnf("route %s: requests from other addresses are rejected by 403 instead of trying the next routes", id)
+		default:
+			cv.result.Warnf("route %s: %s predicate is not supported, the route matches regardless of it", id, p.Name)
+			continue
+		}
+		converted[p.Name] = true
+	}
+
+	return m
+}
+
+// paths returns the paths of HTTPServer for the matcher.
+func (m *matcher) paths(backend string) []convert.Spec {
+	patterns := m.patterns
+	if len(patterns) == 0 {
+		patterns = []string{""}
+	}
+
+	var paths []convert.Spec
+	for _, pattern := range patterns {
+		var path convert.Spec
+		switch {
+		case pattern == "":
+			path = append(path, yaml.MapItem{Key: "pathPrefix", Value: "/"})
+		case !strings.ContainsAny(pattern, "*?{"):
+			path = append(path, yaml.MapItem{Key: "path", Value: pattern})
+		default:
+			path = append(path, yaml.MapItem{Key: "pathRegexp", Value: "^" + antRegexp(pattern, '/', false) + "$"})
+		}
+		if len(m.methods) != 0 {
+			path = append(path, yaml.MapItem{Key: "methods", Value: m.methods})
+		}
+		if m.headerKey != "" {
+			header := convert.Spec{
+				{Key: "key", Value: m.headerKey},
+				{Key: "regexp", Value: m.headerRE},
+				{Key: "backend", Value: backend},
+			}
+			path = append(path, yaml.MapItem{Key: "headers", Value: []convert.Spec{header}})
+		}
+		if len(m.remoteAddrs) != 0 {
+			ipFilter := convert.Spec{
+				{Key: "blockByDefault", Value: true},
+				{Key: "allowIPs", Value: m.remoteAddrs},
+			}
+			path = append(path, yaml.MapItem{Key: "ipFilter", Value: ipFilter})
+		}
+		path = append(path, yaml.MapItem{Key: "backend", Value: backend})
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// javaRegexp converts the named groups of Java regular expressions to the
+// syntax of Go, and validates it.
+func javaRegexp(re string) (string, error) {
+	re = javaNamedGroup.ReplaceAllString(re, "(?P<$1>")
+	_, err := regexp.Compile(re)
+	return re, err
+}
+
+// antRegexp converts the Ant-style pattern of Spring, whose segments are
+// separated by sep, into a regular expression without anchors. The
+// variables are converted into named groups if named is true.
+func antRegexp(pattern string, sep byte, named bool) string {
+	quotedSep := regexp.QuoteMeta(string(sep))
+	segment := "[^" + string(sep) + "]"
+
+	b := &strings.Builder{}
+	skipSep := false
+	for i, seg := range strings.Split(pattern, string(sep)) {
+		// A capture-the-rest variable {*name} matches like **.
+		rest := ""
+		if strings.HasPrefix(seg, "{*") && strings.HasSuffix(seg, "}") {
+			rest, seg = seg[2:len(seg)-1], "**"
+		}
+
+		if seg == "**" {
+			var re string
+			if i == 0 {
+				re, skipSep = "(?:"+segment+"+"+quotedSep+")*", true
+			} else {
+				re = "(?:" + quotedSep + segment + "*)*"
+			}
+			if named && rest != "" {
+				re = "(?P<" + rest + ">" + re + ")"
+			}
+			b.WriteString(re)
+			continue
+		}
+
+		if i > 0 && !skipSep {
+			b.WriteString(quotedSep)
+		}
+		skipSep = false
+		b.WriteString(segmentRegexp(seg, segment, named))
+	}
+
+	return b.String()
+}
+
+func segmentRegexp(seg, segment string, named bool) string {
+	b := &strings.Builder{}
+	for i := 0; i < len(seg); {
+		switch c := seg[i]; c {
+		case '*':
+			b.WriteString(segment + "*")
+			i++
+		case '?':
+			b.WriteString(segment)
+			i++
+		case '{':
+			// NOTE: The regular expression of the variable may contain braces.
+			depth, end := 0, -1
+			for j := i; j < len(seg) && end < 0; j++ {
+				switch seg[j] {
+				case '{':
+					depth++
+				case '}':
+					depth--
+					if depth == 0 {
+						end = j
+					}
+				}
+			}
+			if end < 0 {
+				b.WriteString(regexp.QuoteMeta(seg[i:]))
+				return b.String()
+			}
+
+			name, re := seg[i+1:end], segment+"+"
+			if k := strings.IndexByte(name, ':'); k >= 0 {
+				name, re = name[:k], name[k+1:]
+				re, _ = javaRegexp(re)
+				re = "(?:" + re + ")"
+			}
+			if named {
+				re = "(?P<" + name + ">" + re + ")"
+			}
+			b.WriteString(re)
+			i = end + 1
+		default:
+			j := i
+			for j < len(seg) && !strings.ContainsRune("*?{", rune(seg[j])) {
+				j++
+			}
+			b.WriteString(regexp.QuoteMeta(seg[i:j]))
+			i = j
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package springcloudgateway converts the routes of Spring Cloud Gateway
+// into an HTTPServer and HTTPPipelines of Easegress.
+package springcloudgateway
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/convert"
+)
+
+const defaultPort = 8080
+
+type (
+	// Options are the options of the conversion.
+	Options struct {
+		// Name is the name of the HTTPServer, and the prefix of the names
+		// of the HTTPPipelines.
+		Name string
+		// ServiceRegistry is the service registry to discover the services
+		// of the `lb://` URIs.
+		ServiceRegistry string
+	}
+
+	config struct {
+		Server struct {
+			Port uint16 `yaml:"port"`
+			SSL  struct {
+				Enabled bool `yaml:"enabled"`
+			} `yaml:"ssl"`
+		} `yaml:"server"`
+		Spring struct {
+			Cloud struct {
+				Gateway struct {
+					Routes         []*route      `yaml:"routes"`
+					DefaultFilters []*definition `yaml:"default-filters"`
+				} `yaml:"gateway"`
+			} `yaml:"cloud"`
+		} `yaml:"spring"`
+	}
+
+	route struct {
+		ID         string        `yaml:"id"`
+		URI        string        `yaml:"uri"`
+		Order      int           `yaml:"order"`
+		Predicates []*definition `yaml:"predicates"`
+		Filters    []*definition `yaml:"filters"`
+	}
+
+	rule struct {
+		hostRegexp string
+		paths      []convert.Spec
+	}
+
+	converter struct {
+		opts   *Options
+		result *convert.Result
+	}
+)
+
+// Convert converts the routes in the application YAML of Spring Cloud
+// Gateway into an HTTPServer, and an HTTPPipeline for every route. The
+// routes are kept in the order of their `order`, predicates and filters
+// which can't be converted are reported as warnings of the result.
+func Convert(buff []byte, opts *Options) (*convert.Result, error) {
+	c := &config{}
+	err := yaml.Unmarshal(buff, c)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal yaml failed: %v", err)
+	}
+
+	gateway := &c.Spring.Cloud.Gateway
+	if len(gateway.Routes) == 0 {
+		return nil, fmt.Errorf("no routes found in spring.cloud.gateway.routes")
+	}
+
+	cv := &converter{opts: opts, result: &convert.Result{}}
+
+	routes := make([]*route, len(gateway.Routes))
+	copy(routes, gateway.Routes)
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].Order < routes[j].Order
+	})
+
+	var rules []*rule
+	pipelines := map[string]string{}
+	var pipelineSpecs []convert.Spec
+	for i, r := range routes {
+		id := r.ID
+		if id == "" {
+			id = fmt.Sprintf("route%d", i)
+		}
+		name := convert.ObjectName(opts.Name, id)
+		if other, exists := pipelines[name]; exists {
+			return nil, fmt.Errorf("routes %s and %s are converted to the same pipeline %s", other, id, name)
+		}
+		pipelines[name] = id
+
+		m := cv.convertPredicates(id, r.Predicates)
+		pipeline := cv.convertRoute(id, name, r, m, gateway.DefaultFilters)
+		if pipeline == nil {
+			continue
+		}
+		pipelineSpecs = append(pipelineSpecs, pipeline)
+
+		// NOTE: A new rule is required whenever the host changes, to keep
+		// the order of the routes.
+		if len(rules) == 0 || rules[len(rules)-1].hostRegexp != m.hostRegexp {
+			rules = append(rules, &rule{hostRegexp: m.hostRegexp})
+		}
+		last := rules[len(rules)-1]
+		last.paths = append(last.paths, m.paths(name)...)
+	}
+
+	port := c.Server.Port
+	if port == 0 {
+		port = defaultPort
+	}
+	if c.Server.SSL.Enabled {
+		cv.result.Warnf("server.ssl is not converted, set https and certs of HTTPServer %s", opts.Name)
+	}
+
+	ruleSpecs := []convert.Spec{}
+	for _, r := range rules {
+		spec := convert.Spec{}
+		if r.hostRegexp != "" {
+			spec = append(spec, yaml.MapItem{Key: "hostRegexp", Value: r.hostRegexp})
+		}
+		spec = append(spec, yaml.MapItem{Key: "paths", Value: r.paths})
+		ruleSpecs = append(ruleSpecs, spec)
+	}
+
+	server := convert.Spec{
+		{Key: "name", Value: opts.Name},
+		{Key: "kind", Value: "HTTPServer"},
+		{Key: "port", Value: port},
+		{Key: "keepAlive", Value: true},
+		{Key: "https", Value: false},
+		{Key: "rules", Value: ruleSpecs},
+	}
+	cv.result.Specs = append([]convert.Spec{server}, pipelineSpecs...)
+
+	return cv.result, nil
+}
+
+// convertRoute converts the route into a pipeline, it returns nil if the
+// URI of the route is not supported.
+func (cv *converter) convertRoute(id, name string, r *route, m *matcher, defaultFilters []*definition) convert.Spec {
+	proxy := cv.convertURI(id, r.URI)
+	if proxy == nil {
+		return nil
+	}
+
+	p := &pipeline{proxy: proxy}
+	for _, f := range defaultFilters {
+		cv.convertFilter(id, f, m, p)
+	}
+	for _, f := range r.Filters {
+		cv.convertFilter(id, f, m, p)
+	}
+
+	return p.spec(name)
+}
+
+func (cv *converter) convertURI(id, uri string) convert.Spec {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" {
+		cv.result.Warnf("route %s: uri %s is not supported, the route is skipped", id, uri)
+		return nil
+	}
+
+	loadBalance := convert.Spec{{Key: "policy", Value: "roundRobin"}}
+	var pool convert.Spec
+	switch u.Scheme {
+	case "http", "https":
+		server := convert.Spec{{Key: "url", Value: u.Scheme + "://" + u.Host}}
+		pool = convert.Spec{
+			{Key: "servers", Value: []convert.Spec{server}},
+			{Key: "loadBalance", Value: loadBalance},
+		}
+	case "lb":
+		if cv.opts.ServiceRegistry == "" {
+			cv.result.Warnf("route %s: set serviceRegistry of the proxy to discover service %s", id, u.Host)
+		} else {
+			pool = convert.Spec{{Key: "serviceRegistry", Value: cv.opts.ServiceRegistry}}
+		}
+		pool = append(pool,
+			yaml.MapItem{Key: "serviceName", Value: u.Host},
+			yaml.MapItem{Key: "loadBalance", Value: loadBalance},
+		)
+	default:
+		cv.result.Warnf("route %s: uri %s is not supported, the route is skipped", id, uri)
+		return nil
+	}
+
+	return convert.Spec{
+		{Key: "name", Value: "proxy"},
+		{Key: "kind", Value: "Proxy"},
+		{Key: "mainPool", Value: pool},
+	}
+}
@@ -0,0 +1,253 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package springcloudgateway
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+const application = `
+server:
+  port: 9090
+spring:
+  cloud:
+    gateway:
+      default-filters:
+      - AddResponseHeader=X-Gateway, easegress
+      routes:
+      - id: orders
+        uri: lb://order-service
+        order: 1
+        predicates:
+        - Path=/orders/**,/order/{id}
+        - Method=GET,POST
+        filters:
+        - StripPrefix=1
+        - PrefixPath=/api
+        - name: Retry
+          args:
+            retries: 2
+            statuses: BAD_GATEWAY, 503
+      - id: users
+        uri: http://users:8080/ignored
+        predicates:
+        - Host=**.example.org
+        - Path=/users/{segment}
+        - Header=X-Request-Id, \d+
+        - Query=green
+        filters:
+        - SetPath=/v1/{segment}
+        - AddRequestHeader=X-Request-Red, blue
+      - id: local
+        uri: forward:/local
+`
+
+func TestAntRegexp(t *testing.T) {
+	cases := []struct {
+		pattern string
+		sep     byte
+		match   []string
+		noMatch []string
+	}{
+		{"/foo/**", '/', []string{"/foo", "/foo/", "/foo/a/b"}, []string{"/foobar", "/bar"}},
+		{"/foo/{id}/bar", '/', []string{"/foo/1/bar"}, []string{"/foo//bar", "/foo/1/2/bar"}},
+		{"/foo/{id:\\d+}", '/', []string{"/foo/12"}, []string{"/foo/a"}},
+		{"/foo/{*rest}", '/', []string{"/foo", "/foo/a/b"}, []string{"/fo"}},
+		{"/*.html", '/', []string{"/index.html"}, []string{"/a/index.html", "/index.htm"}},
+		{"**.example.org", '.', []string{"example.org", "www.example.org", "a.b.example.org"}, []string{"example.com", "wwwexample.org"}},
+		{"{sub}.example.org", '.', []string{"www.example.org"}, []string{"example.org", "a.b.example.org"}},
+	}
+
+	for _, c := range cases {
+		re := regexp.MustCompile("^" + antRegexp(c.pattern, c.sep, false) + "$")
+		for _, s := range c.match {
+			if !re.MatchString(s) {
+				t.Errorf("%s: %s should match %s", c.pattern, re, s)
+			}
+		}
+		for _, s := range c.noMatch {
+			if re.MatchString(s) {
+				t.Errorf("%s: %s should not match %s", c.pattern, re, s)
+			}
+		}
+	}
+
+	re := regexp.MustCompile("^" + antRegexp("/foo/{id}/{*rest}", '/', true) + "$")
+	if got := re.ReplaceAllString("/foo/1/a/b", "${rest}/${id}"); got != "/a/b/1" {
+		t.Errorf("unexpected replaced path %s", got)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	var definitions []*definition
+	err := yaml.Unmarshal([]byte(`
+- Header=X-Id, \d+
+- name: Path
+  args:
+    patterns: [/a, /b]
+- name: Header
+  args:
+    _genkey_0: X-Id
+    _genkey_1: \d+
+`), &definitions)
+	if err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+
+	if d := definitions[0]; d.Name != "Header" || d.arg(0, "header") != "X-Id" || d.arg(1, "regexp") != `\d+` {
+		t.Errorf("unexpected shortcut definition %+v", d)
+	}
+	if values := definitions[1].values(0, "patterns"); len(values) != 2 || values[1] != "/b" {
+		t.Errorf("unexpected patterns %v", values)
+	}
+	if d := definitions[2]; d.arg(0, "header") != "X-Id" || d.arg(1, "regexp") != `\d+` || d.arg(-1, "regexp") != "" {
+		t.Errorf("unexpected generated keys definition %+v", d)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	_, err := Convert([]byte("spring: {}"), &Options{Name: "scg"})
+	if err == nil {
+		t.Errorf("convert should fail without routes")
+	}
+
+	result, err := Convert([]byte(application), &Options{Name: "scg", ServiceRegistry: "eureka"})
+	if err != nil {
+		t.Fatalf("convert failed: %v", err)
+	}
+
+	warnings := strings.Join(result.Warnings, "\n")
+	for _, w := range []string{"Query predicate is not supported", "uri forward:/local is not supported"} {
+		if !strings.Contains(warnings, w) {
+			t.Errorf("warning %q not found in %s", w, warnings)
+		}
+	}
+
+	buff, err := result.YAML()
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	docs := strings.Split(string(buff), "---\n")
+	if len(docs) != 3 {
+		t.Fatalf("expected 3 objects, got %d:\n%s", len(docs), buff)
+	}
+
+	type header struct {
+		Key    string `yaml:"key"`
+		Regexp string `yaml:"regexp"`
+	}
+	type path struct {
+		Path       string    `yaml:"path"`
+		PathPrefix string    `yaml:"pathPrefix"`
+		PathRegexp string    `yaml:"pathRegexp"`
+		Methods    []string  `yaml:"methods"`
+		Headers    []*header `yaml:"headers"`
+		Backend    string    `yaml:"backend"`
+	}
+	server := struct {
+		Kind  string `yaml:"kind"`
+		Port  uint16 `yaml:"port"`
+		Rules []struct {
+			HostRegexp string  `yaml:"hostRegexp"`
+			Paths      []*path `yaml:"paths"`
+		} `yaml:"rules"`
+	}{}
+	if err := yaml.Unmarshal([]byte(docs[0]), &server); err != nil {
+		t.Fatalf("unmarshal server failed: %v", err)
+	}
+	if server.Kind != "HTTPServer" || server.Port != 9090 || len(server.Rules) != 2 {
+		t.Fatalf("unexpected server:\n%s", docs[0])
+	}
+
+	// The route users comes first by its order.
+	users := server.Rules[0]
+	if !regexp.MustCompile(users.HostRegexp).MatchString("www.example.org:9090") {
+		t.Errorf("unexpected host regexp %s", users.HostRegexp)
+	}
+	if p := users.Paths[0]; p.Backend != "scg-users" || len(p.Headers) != 1 || p.Headers[0].Key != "X-Request-Id" {
+		t.Errorf("unexpected path %+v", p)
+	}
+	orders := server.Rules[1]
+	if len(orders.Paths) != 2 || orders.Paths[1].Backend != "scg-orders" || len(orders.Paths[1].Methods) != 2 {
+		t.Errorf("unexpected paths of orders:\n%s", docs[0])
+	}
+
+	type filter struct {
+		Name string `yaml:"name"`
+		Kind string `yaml:"kind"`
+		Path struct {
+			AddPrefix     string `yaml:"addPrefix"`
+			RegexpReplace struct {
+				Regexp  string `yaml:"regexp"`
+				Replace string `yaml:"replace"`
+			} `yaml:"regexpReplace"`
+		} `yaml:"path"`
+		MainPool struct {
+			ServiceRegistry string `yaml:"serviceRegistry"`
+			ServiceName     string `yaml:"serviceName"`
+		} `yaml:"mainPool"`
+		Policies []struct {
+			MaxAttempts        int   `yaml:"maxAttempts"`
+			FailureStatusCodes []int `yaml:"failureStatusCodes"`
+		} `yaml:"policies"`
+	}
+	pipeline := struct {
+		Name    string    `yaml:"name"`
+		Filters []*filter `yaml:"filters"`
+	}{}
+
+	if err := yaml.Unmarshal([]byte(docs[1]), &pipeline); err != nil {
+		t.Fatalf("unmarshal pipeline failed: %v", err)
+	}
+	if pipeline.Name != "scg-users" || len(pipeline.Filters) != 3 {
+		t.Fatalf("unexpected pipeline:\n%s", docs[1])
+	}
+	replace := pipeline.Filters[0].Path.RegexpReplace
+	if got := regexp.MustCompile(replace.Regexp).ReplaceAllString("/users/1", replace.Replace); got != "/v1/1" {
+		t.Errorf("unexpected path %s adapted by %+v", got, replace)
+	}
+
+	pipeline.Filters = nil
+	if err := yaml.Unmarshal([]byte(docs[2]), &pipeline); err != nil {
+		t.Fatalf("unmarshal pipeline failed: %v", err)
+	}
+	kinds := []string{}
+	for _, f := range pipeline.Filters {
+		kinds = append(kinds, f.Kind)
+	}
+	if strings.Join(kinds, ",") != "RequestAdaptor,RequestAdaptor,Retryer,Proxy,ResponseAdaptor" {
+		t.Fatalf("unexpected filters %v", kinds)
+	}
+	replace = pipeline.Filters[0].Path.RegexpReplace
+	if got := regexp.MustCompile(replace.Regexp).ReplaceAllString("/orders/1", replace.Replace); got != "/1" {
+		t.Errorf("unexpected path %s adapted by %+v", got, replace)
+	}
+	if pipeline.Filters[1].Path.AddPrefix != "/api" {
+		t.Errorf("unexpected prefix %s", pipeline.Filters[1].Path.AddPrefix)
+	}
+	if p := pipeline.Filters[2].Policies[0]; p.MaxAttempts != 3 || len(p.FailureStatusCodes) != 2 || p.FailureStatusCodes[0] != 502 {
+		t.Errorf("unexpected retry policy %+v", p)
+	}
+	if pool := pipeline.Filters[3].MainPool; pool.ServiceRegistry != "eureka" || pool.ServiceName != "order-service" {
+		t.Errorf("unexpected main pool %+v", pool)
+	}
+}
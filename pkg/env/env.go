@@ -30,6 +30,20 @@ func InitServerDir(opt *option.Options) error {
 		return err
 	}
 
+	err = common.MkdirAll(opt.AbsLogDir)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: The standalone mode needs no etcd directories,
+	// and the data directory is only for the bbolt storage.
+	if opt.Standalone {
+		if opt.StandaloneStorage == "bbolt" {
+			return common.MkdirAll(opt.AbsDataDir)
+		}
+		return nil
+	}
+
 	err = common.MkdirAll(opt.AbsDataDir)
 	if err != nil {
 		return err
@@ -42,11 +56,6 @@ func InitServerDir(opt *option.Options) error {
 		}
 	}
 
-	err = common.MkdirAll(opt.AbsLogDir)
-	if err != nil {
-		return err
-	}
-
 	err = common.MkdirAll(opt.AbsMemberDir)
 	if err != nil {
 		return err
@@ -0,0 +1,341 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package aigatewayproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/consumer"
+)
+
+const (
+	// Kind is the kind of AIGatewayProxy.
+	Kind = "AIGatewayProxy"
+
+	resultInvalidRequest = "invalidRequest"
+	resultTokenLimited   = "tokenLimited"
+	resultProviderError  = "providerError"
+	resultClientError    = "clientError"
+
+	maxRequestBodySize = 16 * 1024 * 1024
+)
+
+var results = []string{resultInvalidRequest, resultTokenLimited, resultProviderError, resultClientError}
+
+func init() {
+	httppipeline.Register(&AIGatewayProxy{})
+}
+
+type (
+	// AIGatewayProxy proxies OpenAI-compatible APIs to LLM providers,
+	// with failover, API key injection and token accounting.
+	AIGatewayProxy struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		resolve   consumer.Resolver
+		providers []*provider
+		limiter   *tokenLimiter
+
+		mutex  sync.Mutex
+		tokens map[string]*Usage
+	}
+
+	// Spec describes the AIGatewayProxy.
+	Spec struct {
+		// Providers are tried in order until one succeeds.
+		Providers []*ProviderSpec `yaml:"providers" jsonschema:"required,minItems=1"`
+		// Consumer is where the consumer is resolved from, tokens
+		// are counted for anonymous consumers if it's nil.
+		Consumer       *consumer.Spec  `yaml:"consumer" jsonschema:"omitempty"`
+		TokenRateLimit *TokenRateLimit `yaml:"tokenRateLimit" jsonschema:"omitempty"`
+		// UsageHeader is the response header of the token usage,
+		// it's only set for non-streaming responses.
+		UsageHeader string `yaml:"usageHeader" jsonschema:"omitempty"`
+	}
+
+	// TokenRateLimit limits the tokens of every consumer on every
+	// member in every Period.
+	TokenRateLimit struct {
+		Tokens int64  `yaml:"tokens" jsonschema:"required,minimum=1"`
+		Period string `yaml:"period" jsonschema:"required,format=duration"`
+	}
+
+	// Status is the status of AIGatewayProxy.
+	Status struct {
+		Providers []*ProviderStatus `yaml:"providers"`
+		// Tokens are the tokens used by every consumer.
+		Tokens map[string]*Usage `yaml:"tokens"`
+	}
+
+	// request is the part of the request body the filter cares about.
+	request struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	names := map[string]bool{}
+	for _, p := range spec.Providers {
+		if names[p.Name] {
+			return fmt.Errorf("duplicated provider %s", p.Name)
+		}
+		names[p.Name] = true
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("provider %s: %v", p.Name, err)
+		}
+	}
+
+	if spec.Consumer != nil {
+		if err := spec.Consumer.Validate(); err != nil {
+			return fmt.Errorf("consumer: %v", err)
+		}
+	}
+
+	if spec.TokenRateLimit != nil {
+		d, err := time.ParseDuration(spec.TokenRateLimit.Period)
+		if err != nil || d < time.Second {
+			return fmt.Errorf("invalid token rate limit period %s", spec.TokenRateLimit.Period)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of AIGatewayProxy.
+func (ap *AIGatewayProxy) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of AIGatewayProxy.
+func (ap *AIGatewayProxy) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of AIGatewayProxy.
+func (ap *AIGatewayProxy) Description() string {
+	return "AIGatewayProxy proxies OpenAI-compatible APIs to LLM providers with failover and token accounting."
+}
+
+// Results returns the results of AIGatewayProxy.
+func (ap *AIGatewayProxy) Results() []string {
+	return results
+}
+
+// Init initializes AIGatewayProxy.
+func (ap *AIGatewayProxy) Init(filterSpec *httppipeline.FilterSpec) {
+	ap.filterSpec, ap.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	ap.tokens = make(map[string]*Usage)
+	ap.reload()
+}
+
+// Inherit inherits previous generation of AIGatewayProxy.
+func (ap *AIGatewayProxy) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+
+	prev := previousGeneration.(*AIGatewayProxy)
+	ap.filterSpec, ap.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	prev.mutex.Lock()
+	ap.tokens = prev.tokens
+	prev.mutex.Unlock()
+	if prev.limiter != nil && ap.spec.TokenRateLimit != nil {
+		ap.limiter = prev.limiter
+	}
+	ap.reload()
+}
+
+func (ap *AIGatewayProxy) reload() {
+	if ap.spec.Consumer != nil {
+		ap.resolve = consumer.NewResolver(ap.spec.Consumer)
+	} else {
+		ap.resolve = func(ctx context.HTTPContext) string { return "" }
+	}
+
+	ap.providers = make([]*provider, len(ap.spec.Providers))
+	for i, spec := range ap.spec.Providers {
+		ap.providers[i] = newProvider(spec)
+	}
+
+	if limit := ap.spec.TokenRateLimit; limit != nil {
+		period, _ := time.ParseDuration(limit.Period)
+		if ap.limiter == nil {
+			ap.limiter = newTokenLimiter(limit.Tokens, period)
+		} else {
+			ap.limiter.mutex.Lock()
+			ap.limiter.limit, ap.limiter.period = limit.Tokens, period
+			ap.limiter.mutex.Unlock()
+		}
+	} else {
+		ap.limiter = nil
+	}
+}
+
+// Handle handles HTTPContext.
+func (ap *AIGatewayProxy) Handle(ctx context.HTTPContext) string {
+	result := ap.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (ap *AIGatewayProxy) handle(ctx context.HTTPContext) string {
+	w := ctx.Response()
+
+	body, err := ioutil.ReadAll(io.LimitReader(ctx.Request().Body(), maxRequestBodySize+1))
+	if err != nil || len(body) > maxRequestBodySize {
+		w.SetStatusCode(http.StatusBadRequest)
+		ctx.AddTag("aiGatewayProxy: invalid request body")
+		return resultInvalidRequest
+	}
+
+	req := &request{}
+	if len(body) > 0 {
+		if err = json.Unmarshal(body, req); err != nil {
+			w.SetStatusCode(http.StatusBadRequest)
+			ctx.AddTag(fmt.Sprintf("aiGatewayProxy: unmarshal request body failed: %v", err))
+			return resultInvalidRequest
+		}
+	}
+
+	c := ap.resolve(ctx)
+	if ap.limiter != nil && !ap.limiter.allow(c) {
+		w.SetStatusCode(http.StatusTooManyRequests)
+		return resultTokenLimited
+	}
+
+	for i, p := range ap.providers {
+		resp, err := p.do(ctx, body, req.Model)
+		if err == nil && !retriable(resp.StatusCode) {
+			ap.respond(ctx, p, c, resp)
+			return ""
+		}
+
+		atomic.AddUint64(&p.failures, 1)
+		if err != nil {
+			ctx.AddTag(fmt.Sprintf("aiGatewayProxy: provider %s failed: %v", p.spec.Name, err))
+		} else {
+			ctx.AddTag(fmt.Sprintf("aiGatewayProxy: provider %s failed: status code %d", p.spec.Name, resp.StatusCode))
+		}
+
+		if ctx.ClientDisconnected() {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return resultClientError
+		}
+
+		// NOTE: Pass the response of the last provider through.
+		if resp != nil && i == len(ap.providers)-1 {
+			ap.respond(ctx, p, c, resp)
+			return resultProviderError
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+
+	w.SetStatusCode(http.StatusBadGateway)
+	return resultProviderError
+}
+
+// retriable returns whether the next provider should be tried.
+func retriable(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+func (ap *AIGatewayProxy) respond(ctx context.HTTPContext, p *provider, c string, resp *http.Response) {
+	w := ctx.Response()
+	w.SetStatusCode(resp.StatusCode)
+	w.Header().AddFromStd(resp.Header)
+	ctx.AddTag("aiGatewayProxy: provider " + p.spec.Name)
+
+	stream := strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+	if !stream && ap.spec.UsageHeader != "" {
+		// NOTE: The body is small enough to be read at once, so the
+		// usage could be sent in the header.
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			ctx.AddTag(fmt.Sprintf("aiGatewayProxy: read response body failed: %v", err))
+		}
+		if u := parseUsage(body); u != nil {
+			ap.account(ctx, c, u)
+			w.Header().Set(ap.spec.UsageHeader, fmt.Sprintf("prompt=%d, completion=%d, total=%d",
+				u.PromptTokens, u.CompletionTokens, u.TotalTokens))
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.SetBody(bytes.NewReader(body))
+		return
+	}
+
+	w.SetBody(newUsageReader(resp.Body, stream, func(u *Usage) {
+		ap.account(ctx, c, u)
+	}))
+}
+
+func (ap *AIGatewayProxy) account(ctx context.HTTPContext, c string, u *Usage) {
+	ctx.AddTag(fmt.Sprintf("aiGatewayProxy: consumer %s used %d tokens", c, u.TotalTokens))
+
+	ap.mutex.Lock()
+	total := ap.tokens[c]
+	if total == nil {
+		total = &Usage{}
+		ap.tokens[c] = total
+	}
+	total.add(u)
+	ap.mutex.Unlock()
+
+	if ap.limiter != nil {
+		ap.limiter.add(c, u.TotalTokens)
+	}
+}
+
+// Status returns status.
+func (ap *AIGatewayProxy) Status() interface{} {
+	s := &Status{Tokens: map[string]*Usage{}}
+	for _, p := range ap.providers {
+		s.Providers = append(s.Providers, p.status())
+	}
+
+	ap.mutex.Lock()
+	for c, u := range ap.tokens {
+		copied := *u
+		s.Tokens[c] = &copied
+	}
+	ap.mutex.Unlock()
+
+	return s
+}
+
+// Close closes AIGatewayProxy.
+func (ap *AIGatewayProxy) Close() {
+	for _, p := range ap.providers {
+		p.close()
+	}
+}
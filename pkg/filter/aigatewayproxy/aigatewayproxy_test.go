@@ -0,0 +1,247 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package aigatewayproxy
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newAIGatewayProxy(t *testing.T, yamlSpec string) *AIGatewayProxy {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ap := &AIGatewayProxy{}
+	ap.Init(spec)
+	return ap
+}
+
+type response struct {
+	result string
+	code   int
+	header *httpheader.HTTPHeader
+	body   string
+}
+
+func handle(ap *AIGatewayProxy, consumer, body string) *response {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("X-Consumer", consumer)
+	req.Header.Set("Authorization", "Bearer gateway-token")
+	w := httptest.NewRecorder()
+
+	ctx := contexttest.NewMockedHTTPContext(req, w)
+	resp := &response{result: ap.Handle(ctx), code: w.Code, header: httpheader.New(w.Header())}
+	respBody := ctx.Response().Body()
+	buff, _ := ioutil.ReadAll(respBody)
+	if c, ok := respBody.(io.Closer); ok {
+		c.Close()
+	}
+	resp.body = string(buff)
+	return resp
+}
+
+func TestFailover(t *testing.T) {
+	failed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failed.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+	ioutil.WriteFile(keyFile, []byte("file-key\n"), 0o600)
+
+	var gotKey, gotBody string
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("Api-Key") + "|" + r.Header.Get("Authorization")
+		body, _ := ioutil.ReadAll(r.Body)
+		gotBody = string(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[],"usage":{"prompt_tokens":3,"completion_tokens":7,"total_tokens":10}}`))
+	}))
+	defer ok.Close()
+
+	ap := newAIGatewayProxy(t, fmt.Sprintf(`
+kind: AIGatewayProxy
+name: ai
+consumer:
+  source: Header
+  name: X-Consumer
+providers:
+- name: primary
+  baseURL: %s
+  apiKey: primary-key
+- name: secondary
+  baseURL: %s
+  apiKeyFile: %s
+  apiKeyHeader: Api-Key
+  models:
+    gpt-4o: gpt-4o-mini
+tokenRateLimit:
+  tokens: 15
+  period: 1h
+usageHeader: X-Usage
+`, failed.URL, ok.URL, keyFile))
+	defer ap.Close()
+
+	resp := handle(ap, "alice", `{"model":"gpt-4o","messages":[]}`)
+	if resp.result != "" || resp.code != http.StatusOK {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if gotKey != "file-key|" {
+		t.Errorf("unexpected key %s", gotKey)
+	}
+	if !strings.Contains(gotBody, `"model":"gpt-4o-mini"`) {
+		t.Errorf("model is not mapped: %s", gotBody)
+	}
+	if h := resp.header.Get("X-Usage"); h != "prompt=3, completion=7, total=10" {
+		t.Errorf("unexpected usage header %s", h)
+	}
+
+	resp = handle(ap, "alice", `{"model":"gpt-4o"}`)
+	if resp.result != "" {
+		t.Errorf("unexpected result %s", resp.result)
+	}
+	resp = handle(ap, "alice", `{"model":"gpt-4o"}`)
+	if resp.result != resultTokenLimited || resp.code != http.StatusTooManyRequests {
+		t.Errorf("expected token limited, got %+v", resp)
+	}
+	resp = handle(ap, "bob", `{"model":"gpt-4o"}`)
+	if resp.result != "" {
+		t.Errorf("unexpected result %s", resp.result)
+	}
+
+	s := ap.Status().(*Status)
+	if s.Tokens["alice"].TotalTokens != 20 || s.Tokens["bob"].TotalTokens != 10 {
+		t.Errorf("unexpected tokens %+v", s.Tokens)
+	}
+	if s.Providers[0].Requests != 3 || s.Providers[0].Failures != 3 || s.Providers[1].Failures != 0 {
+		t.Errorf("unexpected providers %+v %+v", s.Providers[0], s.Providers[1])
+	}
+
+	resp = handle(ap, "bob", `{"model":`)
+	if resp.result != resultInvalidRequest || resp.code != http.StatusBadRequest {
+		t.Errorf("expected invalid request, got %+v", resp)
+	}
+}
+
+func TestAllProvidersFailed(t *testing.T) {
+	failed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("slow down"))
+	}))
+	defer failed.Close()
+
+	ap := newAIGatewayProxy(t, fmt.Sprintf(`
+kind: AIGatewayProxy
+name: ai
+providers:
+- name: unreachable
+  baseURL: http://127.0.0.1:1
+- name: limited
+  baseURL: %s
+`, failed.URL))
+	defer ap.Close()
+
+	resp := handle(ap, "", `{}`)
+	if resp.result != resultProviderError || resp.code != http.StatusTooManyRequests || resp.body != "slow down" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
+
+func TestStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("data: {\"choices\":[],\"usage\":{\"prompt_tokens\":1,\"completion_tokens\":2,\"total_tokens\":3}}\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	ap := newAIGatewayProxy(t, fmt.Sprintf(`
+kind: AIGatewayProxy
+name: ai
+consumer:
+  source: Header
+  name: X-Consumer
+providers:
+- name: provider
+  baseURL: %s
+usageHeader: X-Usage
+`, server.URL))
+	defer ap.Close()
+
+	resp := handle(ap, "alice", `{"model":"gpt-4o","stream":true}`)
+	if resp.result != "" || !strings.HasSuffix(resp.body, "data: [DONE]\n\n") {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if resp.header.Get("X-Usage") != "" {
+		t.Errorf("usage header should not be set for streams")
+	}
+	if u := ap.Status().(*Status).Tokens["alice"]; u == nil || *u != (Usage{1, 2, 3}) {
+		t.Errorf("unexpected usage %+v", u)
+	}
+}
+
+type flushRecorder struct {
+	strings.Builder
+	flushes int
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushes++
+}
+
+func TestUsageReaderWriteTo(t *testing.T) {
+	var usage *Usage
+	body := "data: {\"usage\":{\"total_tokens\":5}}\n"
+	r := newUsageReader(ioutil.NopCloser(strings.NewReader(body)), true, func(u *Usage) { usage = u })
+
+	w := &flushRecorder{}
+	if _, err := io.Copy(w, r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.String() != body || w.flushes == 0 {
+		t.Errorf("unexpected output %q, flushes %d", w.String(), w.flushes)
+	}
+	if usage == nil || usage.TotalTokens != 5 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+}
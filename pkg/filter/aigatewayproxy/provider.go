@@ -0,0 +1,237 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package aigatewayproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+// secretTTL is how long a secret read from a file is cached,
+// so rotated secrets are picked up without reloading.
+const secretTTL = time.Minute
+
+type (
+	// ProviderSpec describes an OpenAI-compatible provider.
+	ProviderSpec struct {
+		Name string `yaml:"name" jsonschema:"required"`
+		// BaseURL is prepended to the request path,
+		// e.g. https://api.openai.com.
+		BaseURL string `yaml:"baseURL" jsonschema:"required,format=uri"`
+		// Exactly one of APIKey, APIKeyEnv and APIKeyFile could be
+		// specified, the key is not injected if none is specified.
+		APIKey     string `yaml:"apiKey" jsonschema:"omitempty"`
+		APIKeyEnv  string `yaml:"apiKeyEnv" jsonschema:"omitempty"`
+		APIKeyFile string `yaml:"apiKeyFile" jsonschema:"omitempty"`
+		// APIKeyHeader is the header carrying the key, the key is
+		// sent as a bearer token if it's Authorization.
+		APIKeyHeader string `yaml:"apiKeyHeader" jsonschema:"omitempty"`
+		// Models maps the requested models to the models of the provider.
+		Models map[string]string `yaml:"models" jsonschema:"omitempty"`
+		// Timeout is the timeout of waiting for the response header.
+		Timeout string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	provider struct {
+		spec   *ProviderSpec
+		client *http.Client
+		secret *secret
+
+		requests uint64
+		failures uint64
+	}
+
+	// secret is an API key read from the spec, the environment or a file.
+	secret struct {
+		spec *ProviderSpec
+
+		mutex    sync.Mutex
+		value    string
+		expireAt time.Time
+	}
+
+	// ProviderStatus is the status of a provider.
+	ProviderStatus struct {
+		Name     string `yaml:"name"`
+		Requests uint64 `yaml:"requests"`
+		Failures uint64 `yaml:"failures"`
+	}
+)
+
+// Validate validates ProviderSpec.
+func (spec ProviderSpec) Validate() error {
+	n := 0
+	for _, s := range []string{spec.APIKey, spec.APIKeyEnv, spec.APIKeyFile} {
+		if s != "" {
+			n++
+		}
+	}
+	if n > 1 {
+		return fmt.Errorf("at most one of apiKey, apiKeyEnv and apiKeyFile could be specified")
+	}
+	if spec.Timeout != "" {
+		if _, err := time.ParseDuration(spec.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout %s: %v", spec.Timeout, err)
+		}
+	}
+	return nil
+}
+
+func newProvider(spec *ProviderSpec) *provider {
+	timeout, _ := time.ParseDuration(spec.Timeout)
+	return &provider{
+		spec:   spec,
+		secret: &secret{spec: spec},
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{
+					Timeout:   30 * time.Second,
+					KeepAlive: 60 * time.Second,
+				}).DialContext,
+				MaxIdleConnsPerHost:   512,
+				IdleConnTimeout:       90 * time.Second,
+				TLSHandshakeTimeout:   10 * time.Second,
+				ResponseHeaderTimeout: timeout,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func (s *secret) get() (string, error) {
+	switch {
+	case s.spec.APIKey != "":
+		return s.spec.APIKey, nil
+	case s.spec.APIKeyEnv != "":
+		value := os.Getenv(s.spec.APIKeyEnv)
+		if value == "" {
+			return "", fmt.Errorf("environment variable %s is empty", s.spec.APIKeyEnv)
+		}
+		return value, nil
+	case s.spec.APIKeyFile != "":
+	default:
+		return "", nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if time.Now().Before(s.expireAt) {
+		return s.value, nil
+	}
+
+	buff, err := ioutil.ReadFile(s.spec.APIKeyFile)
+	if err != nil {
+		// NOTE: Keep using the cached key if the file is being rotated.
+		if s.value != "" {
+			logger.Warnf("read api key file %s failed: %v", s.spec.APIKeyFile, err)
+			return s.value, nil
+		}
+		return "", fmt.Errorf("read api key file %s failed: %v", s.spec.APIKeyFile, err)
+	}
+	s.value, s.expireAt = strings.TrimSpace(string(buff)), time.Now().Add(secretTTL)
+	return s.value, nil
+}
+
+// mapModel replaces the model of the request body by the mapping of
+// the provider, the body is returned as is if it needs no change.
+func (p *provider) mapModel(body []byte, model string) []byte {
+	mapped, ok := p.spec.Models[model]
+	if !ok || mapped == model {
+		return body
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return body
+	}
+	m["model"] = mapped
+	buff, err := json.Marshal(m)
+	if err != nil {
+		return body
+	}
+	return buff
+}
+
+// do sends the request to the provider.
+func (p *provider) do(ctx context.HTTPContext, body []byte, model string) (*http.Response, error) {
+	atomic.AddUint64(&p.requests, 1)
+
+	r := ctx.Request()
+	url := strings.TrimSuffix(p.spec.BaseURL, "/") + r.Path()
+	if r.Query() != "" {
+		url += "?" + r.Query()
+	}
+
+	body = p.mapModel(body, model)
+	req, err := http.NewRequestWithContext(ctx, r.Method(), url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("BUG: new request failed: %v", err)
+	}
+
+	req.Header = r.Header().Std().Clone()
+	req.Header.Del("Content-Length")
+	req.ContentLength = int64(len(body))
+	// NOTE: The credential of clients is for the gateway,
+	// it must never be sent to providers.
+	req.Header.Del("Authorization")
+	// NOTE: Let the transport negotiate the compression, so the
+	// response body is always decoded for counting tokens.
+	req.Header.Del("Accept-Encoding")
+
+	key, err := p.secret.get()
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		header := p.spec.APIKeyHeader
+		if header == "" || strings.EqualFold(header, "Authorization") {
+			req.Header.Set("Authorization", "Bearer "+key)
+		} else {
+			req.Header.Set(header, key)
+		}
+	}
+
+	return p.client.Do(req)
+}
+
+func (p *provider) status() *ProviderStatus {
+	return &ProviderStatus{
+		Name:     p.spec.Name,
+		Requests: atomic.LoadUint64(&p.requests),
+		Failures: atomic.LoadUint64(&p.failures),
+	}
+}
+
+func (p *provider) close() {
+	p.client.CloseIdleConnections()
+}
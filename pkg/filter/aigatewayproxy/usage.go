@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package aigatewayproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// maxUsageBodySize is the max size of non-streaming bodies
+	// buffered for parsing the usage.
+	maxUsageBodySize = 4 * 1024 * 1024
+	// maxLineSize is the max size of a line of event streams.
+	maxLineSize = 1024 * 1024
+)
+
+type (
+	// Usage is the token usage of OpenAI-compatible APIs.
+	Usage struct {
+		PromptTokens     int64 `yaml:"promptTokens" json:"prompt_tokens"`
+		CompletionTokens int64 `yaml:"completionTokens" json:"completion_tokens"`
+		TotalTokens      int64 `yaml:"totalTokens" json:"total_tokens"`
+	}
+
+	// usageReader passes the response body through and parses the
+	// usage from the JSON body or the event stream of server-sent
+	// events, onDone is called once the body is read or closed.
+	usageReader struct {
+		body   io.ReadCloser
+		stream bool
+		buff   bytes.Buffer
+		usage  *Usage
+		onDone func(u *Usage)
+		done   bool
+	}
+
+	// tokenLimiter limits the tokens of every consumer in fixed
+	// windows aligned to the unix epoch.
+	tokenLimiter struct {
+		limit  int64
+		period time.Duration
+
+		mutex       sync.Mutex
+		windowStart time.Time
+		used        map[string]int64
+	}
+)
+
+func (u *Usage) add(other *Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+}
+
+// parseUsage parses the usage from a JSON response body.
+func parseUsage(body []byte) *Usage {
+	resp := &struct {
+		Usage *Usage `json:"usage"`
+	}{}
+	if json.Unmarshal(body, resp) != nil {
+		return nil
+	}
+	return resp.Usage
+}
+
+func newUsageReader(body io.ReadCloser, stream bool, onDone func(u *Usage)) *usageReader {
+	return &usageReader{body: body, stream: stream, onDone: onDone}
+}
+
+func (r *usageReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.feed(p[:n])
+	if err == io.EOF {
+		r.finish()
+	}
+	return n, err
+}
+
+// WriteTo is called by io.Copy, it flushes every chunk to the client,
+// so the events are passed through as soon as they arrive.
+func (r *usageReader) WriteTo(w io.Writer) (int64, error) {
+	flusher, _ := w.(http.Flusher)
+	buff := make([]byte, 32*1024)
+
+	var written int64
+	for {
+		n, err := r.Read(buff)
+		if n > 0 {
+			m, werr := w.Write(buff[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, werr
+			}
+			if flusher != nil && r.stream {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// Close closes the body, the usage is reported even if the body is
+// not read to the end, e.g. the client disconnected.
+func (r *usageReader) Close() error {
+	r.finish()
+	return r.body.Close()
+}
+
+func (r *usageReader) feed(p []byte) {
+	if !r.stream {
+		if r.buff.Len()+len(p) <= maxUsageBodySize {
+			r.buff.Write(p)
+		}
+		return
+	}
+
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			if r.buff.Len()+len(p) <= maxLineSize {
+				r.buff.Write(p)
+			}
+			return
+		}
+		if r.buff.Len()+i <= maxLineSize {
+			r.buff.Write(p[:i])
+		}
+		r.parseEvent(r.buff.Bytes())
+		r.buff.Reset()
+		p = p[i+1:]
+	}
+}
+
+// parseEvent parses a line of the event stream, OpenAI-compatible APIs
+// send the usage in the last data event if it's requested.
+func (r *usageReader) parseEvent(line []byte) {
+	line = bytes.TrimSpace(line)
+	if !bytes.HasPrefix(line, []byte("data:")) {
+		return
+	}
+	data := bytes.TrimSpace(line[len("data:"):])
+	if len(data) == 0 || data[0] != '{' {
+		return
+	}
+
+	event := &struct {
+		Usage *Usage `json:"usage"`
+	}{}
+	if json.Unmarshal(data, event) == nil && event.Usage != nil {
+		r.usage = event.Usage
+	}
+}
+
+func (r *usageReader) finish() {
+	if r.done {
+		return
+	}
+	r.done = true
+
+	if r.stream {
+		r.parseEvent(r.buff.Bytes())
+	} else {
+		r.usage = parseUsage(r.buff.Bytes())
+	}
+	r.buff.Reset()
+
+	if r.usage != nil {
+		r.onDone(r.usage)
+	}
+}
+
+func newTokenLimiter(limit int64, period time.Duration) *tokenLimiter {
+	return &tokenLimiter{
+		limit:  limit,
+		period: period,
+		used:   make(map[string]int64),
+	}
+}
+
+// rotate must be called with mutex held.
+func (l *tokenLimiter) rotate(now time.Time) {
+	start := now.Truncate(l.period)
+	if !start.Equal(l.windowStart) {
+		l.windowStart, l.used = start, make(map[string]int64)
+	}
+}
+
+// allow returns whether the consumer has tokens left in the current
+// window, as tokens are known after responses, the last request of a
+// window could exceed the limit.
+func (l *tokenLimiter) allow(consumer string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.rotate(time.Now())
+	return l.used[consumer] < l.limit
+}
+
+func (l *tokenLimiter) add(consumer string, tokens int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.rotate(time.Now())
+	l.used[consumer] += tokens
+}
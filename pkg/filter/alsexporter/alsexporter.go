@@ -0,0 +1,426 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package alsexporter provides ALSExporter, which ships access logs to
+// the collectors of the Envoy Access Log Service.
+package alsexporter
+
+import (
+	stdcontext "context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filter/alsexporter/alspb"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of ALSExporter.
+	Kind = "ALSExporter"
+
+	defaultBatchSize     = 100
+	defaultQueueSize     = 10000
+	defaultFlushInterval = time.Second
+	closeTimeout         = 5 * time.Second
+)
+
+var results = []string{}
+
+func init() {
+	httppipeline.Register(&ALSExporter{})
+}
+
+type (
+	// ALSExporter streams the access logs of requests to a collector of
+	// the Envoy Access Log Service (ALS) by gRPC, as the HTTP access
+	// logs of Envoy, so the existing ALS collectors ingest them as is.
+	ALSExporter struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		node    string
+		entries chan *alspb.HTTPAccessLogEntry
+		done    chan struct{}
+
+		conn   *grpc.ClientConn
+		stream alspb.AccessLogService_StreamAccessLogsClient
+		ctx    stdcontext.Context
+		cancel stdcontext.CancelFunc
+
+		logged, sent, dropped, failed uint64
+	}
+
+	// Spec describes the ALSExporter.
+	Spec struct {
+		// Server is the host:port of the ALS collector.
+		Server string `yaml:"server" jsonschema:"required"`
+		TLS    bool   `yaml:"tls" jsonschema:"omitempty"`
+		// LogName identifies the access logs in the collector.
+		LogName string `yaml:"logName" jsonschema:"required"`
+		// RequestHeaders and ResponseHeaders are the headers to log.
+		RequestHeaders  []string `yaml:"requestHeaders" jsonschema:"omitempty,uniqueItems=true"`
+		ResponseHeaders []string `yaml:"responseHeaders" jsonschema:"omitempty,uniqueItems=true"`
+
+		BatchSize     int    `yaml:"batchSize" jsonschema:"omitempty,minimum=1"`
+		QueueSize     int    `yaml:"queueSize" jsonschema:"omitempty,minimum=1"`
+		FlushInterval string `yaml:"flushInterval" jsonschema:"omitempty,format=duration"`
+	}
+
+	// Status is the status of ALSExporter.
+	Status struct {
+		Logged uint64 `yaml:"logged"`
+		Sent   uint64 `yaml:"sent"`
+		// Dropped is the number of access logs dropped because the queue is full.
+		Dropped uint64 `yaml:"dropped"`
+		// Failed is the number of access logs failed to send.
+		Failed uint64 `yaml:"failed"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if _, _, err := net.SplitHostPort(spec.Server); err != nil {
+		return fmt.Errorf("invalid server %s: %v", spec.Server, err)
+	}
+	if spec.FlushInterval != "" {
+		d, err := time.ParseDuration(spec.FlushInterval)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid flushInterval %s", spec.FlushInterval)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of ALSExporter.
+func (ae *ALSExporter) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of ALSExporter.
+func (ae *ALSExporter) DefaultSpec() interface{} {
+	return &Spec{
+		LogName:       "easegress",
+		BatchSize:     defaultBatchSize,
+		QueueSize:     defaultQueueSize,
+		FlushInterval: defaultFlushInterval.String(),
+	}
+}
+
+// Description returns the description of ALSExporter.
+func (ae *ALSExporter) Description() string {
+	return "ALSExporter streams access logs to the collectors of Envoy Access Log Service."
+}
+
+// Results returns the results of ALSExporter.
+func (ae *ALSExporter) Results() []string {
+	return results
+}
+
+// Init initializes ALSExporter.
+func (ae *ALSExporter) Init(filterSpec *httppipeline.FilterSpec) {
+	ae.filterSpec, ae.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	ae.reload()
+}
+
+// Inherit inherits previous generation of ALSExporter.
+func (ae *ALSExporter) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	ae.Init(filterSpec)
+}
+
+func (ae *ALSExporter) reload() {
+	if super := ae.filterSpec.Super(); super != nil {
+		ae.node = super.Options().Name
+	} else {
+		ae.node, _ = os.Hostname()
+	}
+
+	creds := grpc.WithInsecure()
+	if ae.spec.TLS {
+		creds = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{}))
+	}
+	// NOTE: Dial doesn't block, the connection is established in
+	// background and reconnected if it's broken.
+	conn, err := grpc.Dial(ae.spec.Server, creds)
+	if err != nil {
+		logger.Errorf("ALS exporter %s/%s: dial %s failed: %v",
+			ae.filterSpec.Pipeline(), ae.filterSpec.Name(), ae.spec.Server, err)
+	}
+	ae.conn = conn
+	ae.ctx, ae.cancel = stdcontext.WithCancel(stdcontext.Background())
+
+	ae.entries = make(chan *alspb.HTTPAccessLogEntry, ae.spec.QueueSize)
+	ae.done = make(chan struct{})
+	go ae.run()
+}
+
+// Handle handles HTTPContext.
+func (ae *ALSExporter) Handle(ctx context.HTTPContext) string {
+	// NOTE: The original path is recorded before the following filters,
+	// which may rewrite it.
+	r := ctx.Request()
+	startTime := time.Now()
+	originalPath := requestPath(r)
+
+	ctx.OnFinish(func() {
+		entry := ae.newEntry(ctx, startTime)
+		if path := entry.Request.Path; path != originalPath {
+			entry.Request.OriginalPath = originalPath
+		}
+		ae.enqueue(entry)
+	})
+	return ctx.CallNextHandler("")
+}
+
+func requestPath(r context.HTTPRequest) string {
+	if query := r.Query(); query != "" {
+		return r.Path() + "?" + query
+	}
+	return r.Path()
+}
+
+// newEntry creates the access log of the request, in the way of the HTTP
+// access logs of Envoy.
+func (ae *ALSExporter) newEntry(ctx context.HTTPContext, startTime time.Time) *alspb.HTTPAccessLogEntry {
+	r, w := ctx.Request(), ctx.Response()
+	stdr := r.Std()
+
+	duration := ctx.Duration()
+	common := &alspb.AccessLogCommon{
+		SampleRate:                    1,
+		DownstreamRemoteAddress:       address(stdr.RemoteAddr),
+		DownstreamDirectRemoteAddress: address(stdr.RemoteAddr),
+		StartTime: &alspb.Timestamp{
+			Seconds: startTime.Unix(),
+			Nanos:   int32(startTime.Nanosecond()),
+		},
+		TimeToLastRxByte:           durationPB(duration),
+		TimeToLastDownstreamTxByte: durationPB(duration),
+		RouteName:                  ae.filterSpec.Pipeline(),
+	}
+	if addr, ok := stdr.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		common.DownstreamLocalAddress = address(addr.String())
+	}
+	if realIP := r.RealIP(); realIP != "" && !strings.HasPrefix(stdr.RemoteAddr, realIP+":") {
+		common.DownstreamRemoteAddress = &alspb.Address{
+			SocketAddress: &alspb.SocketAddress{Address: realIP},
+		}
+	}
+
+	req := &alspb.HTTPRequestProperties{
+		RequestMethod:    alspb.RequestMethod(alspb.RequestMethod_value[r.Method()]),
+		Scheme:           r.Scheme(),
+		Authority:        r.Host(),
+		Path:             requestPath(r),
+		UserAgent:        r.Header().Get("User-Agent"),
+		Referer:          r.Header().Get("Referer"),
+		ForwardedFor:     r.Header().Get("X-Forwarded-For"),
+		RequestId:        r.Header().Get("X-Request-Id"),
+		RequestBodyBytes: r.Size(),
+		RequestHeaders:   headers(ae.spec.RequestHeaders, r.Header().GetAll),
+	}
+	if _, port, err := net.SplitHostPort(r.Host()); err == nil {
+		if n, err := strconv.ParseUint(port, 10, 16); err == nil {
+			req.Port = &alspb.UInt32Value{Value: uint32(n)}
+		}
+	}
+
+	resp := &alspb.HTTPResponseProperties{
+		ResponseCode:      &alspb.UInt32Value{Value: uint32(w.StatusCode())},
+		ResponseBodyBytes: w.Size(),
+		ResponseHeaders:   headers(ae.spec.ResponseHeaders, w.Header().GetAll),
+	}
+
+	return &alspb.HTTPAccessLogEntry{
+		CommonProperties: common,
+		ProtocolVersion:  protocolVersion(r.Proto()),
+		Request:          req,
+		Response:         resp,
+	}
+}
+
+func address(hostport string) *alspb.Address {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return &alspb.Address{SocketAddress: &alspb.SocketAddress{Address: hostport}}
+	}
+	n, _ := strconv.ParseUint(port, 10, 16)
+	return &alspb.Address{SocketAddress: &alspb.SocketAddress{Address: host, PortValue: uint32(n)}}
+}
+
+func durationPB(d time.Duration) *alspb.Duration {
+	return &alspb.Duration{
+		Seconds: int64(d / time.Second),
+		Nanos:   int32(d % time.Second),
+	}
+}
+
+// headers returns the values of the headers by their lowercase names, as
+// the header names of HTTP/2.
+func headers(names []string, getAll func(key string) []string) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(names))
+	for _, name := range names {
+		if values := getAll(name); len(values) != 0 {
+			m[strings.ToLower(name)] = strings.Join(values, ",")
+		}
+	}
+	return m
+}
+
+func protocolVersion(proto string) alspb.HTTPAccessLogEntry_HTTPVersion {
+	switch proto {
+	case "HTTP/1.0":
+		return alspb.HTTPAccessLogEntry_HTTP10
+	case "HTTP/1.1":
+		return alspb.HTTPAccessLogEntry_HTTP11
+	case "HTTP/2.0":
+		return alspb.HTTPAccessLogEntry_HTTP2
+	case "HTTP/3", "HTTP/3.0":
+		return alspb.HTTPAccessLogEntry_HTTP3
+	default:
+		return alspb.HTTPAccessLogEntry_PROTOCOL_UNSPECIFIED
+	}
+}
+
+func (ae *ALSExporter) enqueue(entry *alspb.HTTPAccessLogEntry) {
+	atomic.AddUint64(&ae.logged, 1)
+	select {
+	case ae.entries <- entry:
+	default:
+		atomic.AddUint64(&ae.dropped, 1)
+	}
+}
+
+func (ae *ALSExporter) run() {
+	interval, _ := time.ParseDuration(ae.spec.FlushInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var batch []*alspb.HTTPAccessLogEntry
+	for {
+		select {
+		case entry := <-ae.entries:
+			batch = append(batch, entry)
+			if len(batch) >= ae.spec.BatchSize {
+				ae.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			ae.flush(batch)
+			batch = nil
+		case <-ae.done:
+			// NOTE: Flush the queued access logs before exiting.
+			for {
+				select {
+				case entry := <-ae.entries:
+					batch = append(batch, entry)
+				default:
+					ae.flush(batch)
+					ae.closeStream()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (ae *ALSExporter) flush(batch []*alspb.HTTPAccessLogEntry) {
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := ae.send(batch); err != nil {
+		atomic.AddUint64(&ae.failed, uint64(len(batch)))
+		logger.Errorf("ALS exporter %s/%s: send %d access logs failed: %v",
+			ae.filterSpec.Pipeline(), ae.filterSpec.Name(), len(batch), err)
+		return
+	}
+	atomic.AddUint64(&ae.sent, uint64(len(batch)))
+}
+
+// send sends the batch in a message of the stream, the stream is opened
+// on demand, and its first message carries the identifier.
+func (ae *ALSExporter) send(batch []*alspb.HTTPAccessLogEntry) error {
+	if ae.conn == nil {
+		return fmt.Errorf("no connection to %s", ae.spec.Server)
+	}
+
+	msg := &alspb.StreamAccessLogsMessage{
+		HttpLogs: &alspb.StreamAccessLogsMessage_HTTPAccessLogEntries{LogEntry: batch},
+	}
+	if ae.stream == nil {
+		stream, err := alspb.NewAccessLogServiceClient(ae.conn).StreamAccessLogs(ae.ctx)
+		if err != nil {
+			return err
+		}
+		ae.stream = stream
+		msg.Identifier = &alspb.StreamAccessLogsMessage_Identifier{
+			Node:    &alspb.Node{Id: ae.node, Cluster: "easegress"},
+			LogName: ae.spec.LogName,
+		}
+	}
+
+	if err := ae.stream.Send(msg); err != nil {
+		// NOTE: The stream is broken, a new one is opened next time.
+		ae.stream = nil
+		return err
+	}
+	return nil
+}
+
+func (ae *ALSExporter) closeStream() {
+	if ae.stream != nil {
+		// NOTE: Wait a while for the collector to receive the messages.
+		timer := time.AfterFunc(closeTimeout, ae.cancel)
+		ae.stream.CloseAndRecv()
+		timer.Stop()
+	}
+	ae.cancel()
+	if ae.conn != nil {
+		ae.conn.Close()
+	}
+}
+
+// Status returns status.
+func (ae *ALSExporter) Status() interface{} {
+	return &Status{
+		Logged:  atomic.LoadUint64(&ae.logged),
+		Sent:    atomic.LoadUint64(&ae.sent),
+		Dropped: atomic.LoadUint64(&ae.dropped),
+		Failed:  atomic.LoadUint64(&ae.failed),
+	}
+}
+
+// Close closes ALSExporter.
+func (ae *ALSExporter) Close() {
+	close(ae.done)
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package alsexporter
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/filter/alsexporter/alspb"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newALSExporter(t *testing.T, yamlSpec string) *ALSExporter {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ae := &ALSExporter{}
+	ae.Init(spec)
+	return ae
+}
+
+type collector struct {
+	alspb.UnimplementedAccessLogServiceServer
+
+	mutex    sync.Mutex
+	messages []*alspb.StreamAccessLogsMessage
+}
+
+func (c *collector) StreamAccessLogs(stream alspb.AccessLogService_StreamAccessLogsServer) error {
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&alspb.StreamAccessLogsResponse{})
+		}
+		if err != nil {
+			return err
+		}
+		c.mutex.Lock()
+		c.messages = append(c.messages, msg)
+		c.mutex.Unlock()
+	}
+}
+
+func handle(ae *ALSExporter, path string) {
+	req := httptest.NewRequest(http.MethodPost, "http://example.com:8080"+path, nil)
+	req.Header.Set("User-Agent", "curl/7.0")
+	req.Header.Set("X-Request-Id", "abc")
+	req.Header.Set("X-Tenant", "megaease")
+	w := httptest.NewRecorder()
+	w.Code = http.StatusCreated
+	w.Header().Set("Content-Type", "text/plain")
+
+	ctx := contexttest.NewMockedHTTPContext(req, w)
+	ctx.MockedRequest.MockedRealIP = func() string { return "198.51.100.1" }
+	ctx.MockedRequest.MockedSize = func() uint64 { return 100 }
+	ctx.MockedResponse.MockedSize = func() uint64 { return 200 }
+	ctx.MockedDuration = func() time.Duration { return 1500 * time.Millisecond }
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		req.URL.Path = "/rewritten"
+		return lastResult
+	}
+
+	ae.Handle(ctx)
+	ctx.Finish()
+}
+
+func TestALSExporter(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	c := &collector{}
+	server := grpc.NewServer()
+	alspb.RegisterAccessLogServiceServer(server, c)
+	go server.Serve(l)
+	defer server.Stop()
+
+	ae := newALSExporter(t, `
+kind: ALSExporter
+name: als
+server: `+l.Addr().String()+`
+logName: gateway
+requestHeaders: [X-Tenant]
+responseHeaders: [Content-Type]
+batchSize: 2
+flushInterval: 1h
+`)
+
+	handle(ae, "/users/1")
+	handle(ae, "/users/2")
+	handle(ae, "/users/3")
+	ae.Close()
+
+	for i := 0; i < 100; i++ {
+		if ae.Status().(*Status).Sent == 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	status := ae.Status().(*Status)
+	if status.Logged != 3 || status.Sent != 3 || status.Failed != 0 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	// NOTE: The queued access logs may be flushed in one message on
+	// closing, so the entries are counted instead of the messages.
+	var entries []*alspb.HTTPAccessLogEntry
+	for i := 0; i < 100 && len(entries) != 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+		c.mutex.Lock()
+		entries = nil
+		for _, msg := range c.messages {
+			entries = append(entries, msg.HttpLogs.LogEntry...)
+		}
+		c.mutex.Unlock()
+	}
+	if len(entries) != 3 {
+		t.Fatalf("unexpected %d entries", len(entries))
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	id := c.messages[0].Identifier
+	if id == nil || id.LogName != "gateway" || id.Node.Cluster != "easegress" {
+		t.Errorf("unexpected identifier: %v", id)
+	}
+	for _, msg := range c.messages[1:] {
+		if msg.Identifier != nil {
+			t.Errorf("identifier should be sent only in the first message")
+		}
+	}
+
+	e := entries[0]
+	if e.ProtocolVersion != alspb.HTTPAccessLogEntry_HTTP11 {
+		t.Errorf("unexpected protocol version %v", e.ProtocolVersion)
+	}
+	req := e.Request
+	if req.RequestMethod != alspb.RequestMethod_POST || req.Authority != "example.com:8080" || req.Port.Value != 8080 {
+		t.Errorf("unexpected request: %v", req)
+	}
+	if req.Path != "/rewritten" || req.OriginalPath != "/users/1" || req.RequestId != "abc" || req.UserAgent != "curl/7.0" {
+		t.Errorf("unexpected request: %v", req)
+	}
+	if req.RequestBodyBytes != 100 || req.RequestHeaders["x-tenant"] != "megaease" {
+		t.Errorf("unexpected request: %v", req)
+	}
+	resp := e.Response
+	if resp.ResponseCode.Value != 201 || resp.ResponseBodyBytes != 200 || resp.ResponseHeaders["content-type"] != "text/plain" {
+		t.Errorf("unexpected response: %v", resp)
+	}
+	common := e.CommonProperties
+	if common.DownstreamRemoteAddress.SocketAddress.Address != "198.51.100.1" || common.TimeToLastDownstreamTxByte.Seconds != 1 ||
+		common.TimeToLastDownstreamTxByte.Nanos != 5e8 {
+		t.Errorf("unexpected common properties: %v", common)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, spec := range []Spec{
+		{Server: "localhost"},
+		{Server: "localhost:9001", FlushInterval: "0s"},
+	} {
+		if spec.Validate() == nil {
+			t.Errorf("spec %+v should be invalid", spec)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package analyticssampler
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/consumer"
+	"github.com/megaease/easegress/pkg/util/urlclusteranalyzer"
+)
+
+const (
+	// Kind is the kind of AnalyticsSampler.
+	Kind = "AnalyticsSampler"
+
+	defaultBatchSize     = 500
+	defaultQueueSize     = 10000
+	defaultFlushInterval = 5 * time.Second
+)
+
+var results = []string{}
+
+func init() {
+	httppipeline.Register(&AnalyticsSampler{})
+}
+
+type (
+	// AnalyticsSampler samples requests and ships their records to
+	// analytics storages for offline API analytics.
+	AnalyticsSampler struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		resolve   consumer.Resolver
+		scrubber  *scrubber
+		templates [][]string
+		analyzer  *urlclusteranalyzer.URLClusterAnalyzer
+		sink      sink
+
+		records chan *Record
+		done    chan struct{}
+
+		sampled, sent, dropped, failed uint64
+	}
+
+	// Spec describes the AnalyticsSampler.
+	Spec struct {
+		// SampleRate is the percentage of sampled requests.
+		SampleRate float64        `yaml:"sampleRate" jsonschema:"required"`
+		Consumer   *consumer.Spec `yaml:"consumer" jsonschema:"omitempty"`
+		// PathTemplates are templates of paths like /users/{id}, paths
+		// matching none of them are templated by learning their patterns.
+		PathTemplates []string `yaml:"pathTemplates" jsonschema:"omitempty,uniqueItems=true"`
+		// Headers are the request headers to record.
+		Headers []string   `yaml:"headers" jsonschema:"omitempty,uniqueItems=true"`
+		Scrub   *ScrubSpec `yaml:"scrub" jsonschema:"omitempty"`
+
+		ClickHouse *ClickHouseSpec `yaml:"clickHouse" jsonschema:"omitempty"`
+		Kafka      *KafkaSpec      `yaml:"kafka" jsonschema:"omitempty"`
+
+		BatchSize     int    `yaml:"batchSize" jsonschema:"omitempty"`
+		QueueSize     int    `yaml:"queueSize" jsonschema:"omitempty"`
+		FlushInterval string `yaml:"flushInterval" jsonschema:"omitempty,format=duration"`
+	}
+
+	// Record is the record of a sampled request.
+	Record struct {
+		Time          time.Time         `json:"time"`
+		Pipeline      string            `json:"pipeline"`
+		Method        string            `json:"method"`
+		Path          string            `json:"path"`
+		Query         string            `json:"query"`
+		StatusCode    int               `json:"statusCode"`
+		LatencyMs     float64           `json:"latencyMs"`
+		RequestBytes  uint64            `json:"requestBytes"`
+		ResponseBytes uint64            `json:"responseBytes"`
+		Consumer      string            `json:"consumer"`
+		ClientIP      string            `json:"clientIP"`
+		Result        string            `json:"result"`
+		Headers       map[string]string `json:"headers"`
+	}
+
+	// Status is the status of AnalyticsSampler.
+	Status struct {
+		Sampled uint64 `yaml:"sampled"`
+		Sent    uint64 `yaml:"sent"`
+		// Dropped is the number of records dropped because the queue is full.
+		Dropped uint64 `yaml:"dropped"`
+		// Failed is the number of records failed to send.
+		Failed uint64 `yaml:"failed"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if spec.SampleRate <= 0 || spec.SampleRate > 100 {
+		return fmt.Errorf("sampleRate must be in (0, 100]")
+	}
+	if (spec.ClickHouse == nil) == (spec.Kafka == nil) {
+		return fmt.Errorf("exactly one of clickHouse and kafka must be specified")
+	}
+	if spec.BatchSize < 0 || spec.QueueSize < 0 {
+		return fmt.Errorf("batchSize and queueSize must not be negative")
+	}
+	if spec.FlushInterval != "" {
+		d, err := time.ParseDuration(spec.FlushInterval)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid flushInterval %s", spec.FlushInterval)
+		}
+	}
+	for _, t := range spec.PathTemplates {
+		if !strings.HasPrefix(t, "/") {
+			return fmt.Errorf("path template %s doesn't start with /", t)
+		}
+	}
+	if spec.Scrub != nil {
+		if err := spec.Scrub.Validate(); err != nil {
+			return fmt.Errorf("scrub: %v", err)
+		}
+	}
+	if spec.Consumer != nil {
+		if err := spec.Consumer.Validate(); err != nil {
+			return fmt.Errorf("consumer: %v", err)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of AnalyticsSampler.
+func (as *AnalyticsSampler) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of AnalyticsSampler.
+func (as *AnalyticsSampler) DefaultSpec() interface{} {
+	return &Spec{
+		BatchSize:     defaultBatchSize,
+		QueueSize:     defaultQueueSize,
+		FlushInterval: defaultFlushInterval.String(),
+	}
+}
+
+// Description returns the description of AnalyticsSampler.
+func (as *AnalyticsSampler) Description() string {
+	return "AnalyticsSampler samples requests and ships their records to ClickHouse or Kafka."
+}
+
+// Results returns the results of AnalyticsSampler.
+func (as *AnalyticsSampler) Results() []string {
+	return results
+}
+
+// Init initializes AnalyticsSampler.
+func (as *AnalyticsSampler) Init(filterSpec *httppipeline.FilterSpec) {
+	as.filterSpec, as.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	as.reload()
+}
+
+// Inherit inherits previous generation of AnalyticsSampler.
+func (as *AnalyticsSampler) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	as.Init(filterSpec)
+}
+
+func (as *AnalyticsSampler) reload() {
+	if as.spec.Consumer != nil {
+		as.resolve = consumer.NewResolver(as.spec.Consumer)
+	} else {
+		as.resolve = func(ctx context.HTTPContext) string { return "" }
+	}
+
+	as.scrubber = newScrubber(as.spec.Scrub)
+	as.templates = nil
+	for _, t := range as.spec.PathTemplates {
+		as.templates = append(as.templates, strings.Split(t[1:], "/"))
+	}
+	as.analyzer = urlclusteranalyzer.New()
+	as.sink = newSink(as.spec)
+
+	queueSize := as.spec.QueueSize
+	if queueSize == 0 {
+		queueSize = defaultQueueSize
+	}
+	as.records = make(chan *Record, queueSize)
+	as.done = make(chan struct{})
+	go as.run()
+}
+
+// Handle handles HTTPContext.
+func (as *AnalyticsSampler) Handle(ctx context.HTTPContext) string {
+	if rand.Float64()*100 >= as.spec.SampleRate {
+		return ctx.CallNextHandler("")
+	}
+
+	// NOTE: The request is recorded before the following filters,
+	// which may rewrite it.
+	r := ctx.Request()
+	record := &Record{
+		Time:     time.Now(),
+		Pipeline: as.filterSpec.Pipeline(),
+		Method:   r.Method(),
+		Path:     as.scrubber.text(as.templateOf(r.Path())),
+		Query:    as.scrubber.query(r.Query()),
+		Consumer: as.scrubber.consumer(as.resolve(ctx)),
+		ClientIP: as.scrubber.clientIP(r.RealIP()),
+	}
+	if len(as.spec.Headers) > 0 {
+		record.Headers = make(map[string]string, len(as.spec.Headers))
+		for _, key := range as.spec.Headers {
+			if v := r.Header().Get(key); v != "" {
+				record.Headers[key] = as.scrubber.text(v)
+			}
+		}
+	}
+
+	result := ctx.CallNextHandler("")
+	record.Result = result
+
+	ctx.OnFinish(func() {
+		record.StatusCode = ctx.Response().StatusCode()
+		record.LatencyMs = float64(ctx.Duration().Microseconds()) / 1000
+		record.RequestBytes = ctx.Request().Size()
+		record.ResponseBytes = ctx.Response().Size()
+		as.enqueue(record)
+	})
+	return result
+}
+
+// templateOf returns the template of the path, variable parts of
+// paths matching no template are replaced by '*'.
+func (as *AnalyticsSampler) templateOf(path string) string {
+	if len(as.templates) > 0 && strings.HasPrefix(path, "/") {
+		segments := strings.Split(path[1:], "/")
+	LOOP:
+		for i, t := range as.templates {
+			if len(t) != len(segments) {
+				continue
+			}
+			for j, s := range t {
+				isVar := strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}")
+				if !isVar && s != segments[j] {
+					continue LOOP
+				}
+			}
+			return as.spec.PathTemplates[i]
+		}
+	}
+	return as.analyzer.GetPattern(path)
+}
+
+func (as *AnalyticsSampler) enqueue(record *Record) {
+	atomic.AddUint64(&as.sampled, 1)
+	select {
+	case as.records <- record:
+	default:
+		atomic.AddUint64(&as.dropped, 1)
+	}
+}
+
+func (as *AnalyticsSampler) run() {
+	batchSize := as.spec.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultBatchSize
+	}
+	interval, _ := time.ParseDuration(as.spec.FlushInterval)
+	if interval <= 0 {
+		interval = defaultFlushInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var batch []*Record
+	for {
+		select {
+		case r := <-as.records:
+			batch = append(batch, r)
+			if len(batch) >= batchSize {
+				as.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			as.flush(batch)
+			batch = nil
+		case <-as.done:
+			// NOTE: Flush the queued records before exiting.
+			for {
+				select {
+				case r := <-as.records:
+					batch = append(batch, r)
+				default:
+					as.flush(batch)
+					as.sink.close()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (as *AnalyticsSampler) flush(batch []*Record) {
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := as.sink.send(batch); err != nil {
+		atomic.AddUint64(&as.failed, uint64(len(batch)))
+		logger.Errorf("analytics sampler %s/%s: send %d records failed: %v",
+			as.filterSpec.Pipeline(), as.filterSpec.Name(), len(batch), err)
+		return
+	}
+	atomic.AddUint64(&as.sent, uint64(len(batch)))
+}
+
+// Status returns status.
+func (as *AnalyticsSampler) Status() interface{} {
+	return &Status{
+		Sampled: atomic.LoadUint64(&as.sampled),
+		Sent:    atomic.LoadUint64(&as.sent),
+		Dropped: atomic.LoadUint64(&as.dropped),
+		Failed:  atomic.LoadUint64(&as.failed),
+	}
+}
+
+// Close closes AnalyticsSampler.
+func (as *AnalyticsSampler) Close() {
+	close(as.done)
+}
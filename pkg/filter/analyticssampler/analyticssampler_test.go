@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package analyticssampler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/vault"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newAnalyticsSampler(t *testing.T, yamlSpec string) *AnalyticsSampler {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	as := &AnalyticsSampler{}
+	as.Init(spec)
+	return as
+}
+
+type clickHouse struct {
+	*httptest.Server
+
+	mutex   sync.Mutex
+	queries []string
+	records []*Record
+}
+
+func newClickHouse() *clickHouse {
+	ch := &clickHouse{}
+	ch.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-ClickHouse-User") != "default" || r.Header.Get("X-ClickHouse-Key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		ch.mutex.Lock()
+		defer ch.mutex.Unlock()
+		ch.queries = append(ch.queries, r.URL.Query().Get("query"))
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			record := &Record{}
+			json.Unmarshal(scanner.Bytes(), record)
+			ch.records = append(ch.records, record)
+		}
+	}))
+	return ch
+}
+
+func handle(as *AnalyticsSampler, path, query string) {
+	req := httptest.NewRequest(http.MethodGet, path+"?"+query, nil)
+	req.RemoteAddr = "192.168.1.100:1234"
+	req.Header.Set("X-Consumer", "alice")
+	req.Header.Set("User-Agent", "curl/7.0 alice@example.com")
+
+	ctx := contexttest.NewMockedHTTPContext(req, httptest.NewRecorder())
+	ctx.MockedRequest.MockedSize = func() uint64 { return 100 }
+	ctx.MockedResponse.MockedSize = func() uint64 { return 200 }
+	ctx.MockedDuration = func() time.Duration { return 1500 * time.Microsecond }
+
+	as.Handle(ctx)
+	ctx.Finish()
+}
+
+func TestAnalyticsSampler(t *testing.T) {
+	ch := newClickHouse()
+	defer ch.Close()
+
+	as := newAnalyticsSampler(t, fmt.Sprintf(`
+kind: AnalyticsSampler
+name: sampler
+sampleRate: 100
+consumer:
+  source: Header
+  name: X-Consumer
+pathTemplates: ['/users/{id}/orders/{orderId}']
+headers: [User-Agent]
+scrub:
+  regexps:
+  - regexp: '[\w.+-]+@[\w-]+\.[\w.]+'
+    replacement: '[EMAIL]'
+  queryParams: [token]
+  hashConsumer: true
+  maskClientIP: true
+clickHouse:
+  url: %s
+  table: requests
+  username: default
+  password: secret
+batchSize: 2
+flushInterval: 1h
+`, ch.URL))
+
+	handle(as, "/users/1/orders/2", "token=abc&email=bob@example.com&a=1")
+	handle(as, "/users/3/orders/4", "")
+	handle(as, "/health", "")
+	as.Close()
+
+	for i := 0; i < 100; i++ {
+		if as.Status().(*Status).Sent == 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	status := as.Status().(*Status)
+	if status.Sampled != 3 || status.Sent != 3 || status.Failed != 0 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+
+	if len(ch.queries) == 0 || ch.queries[0] != "INSERT INTO requests FORMAT JSONEachRow" {
+		t.Fatalf("unexpected queries: %v", ch.queries)
+	}
+
+	r := ch.records[0]
+	if r.Path != "/users/{id}/orders/{orderId}" || r.Query != "a=1&email=[EMAIL]&token=[SCRUBBED]" {
+		t.Errorf("unexpected path %s and query %s", r.Path, r.Query)
+	}
+	if r.ClientIP != "192.168.1.0" || len(r.Consumer) != 64 || r.Headers["User-Agent"] != "curl/7.0 [EMAIL]" {
+		t.Errorf("unexpected record: %+v", r)
+	}
+	if r.StatusCode != 200 || r.LatencyMs != 1.5 || r.RequestBytes != 100 || r.ResponseBytes != 200 {
+		t.Errorf("unexpected record: %+v", r)
+	}
+	if ch.records[1].Path != "/users/{id}/orders/{orderId}" || ch.records[2].Path != "/health" {
+		t.Errorf("unexpected paths %s and %s", ch.records[1].Path, ch.records[2].Path)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	kafka := &KafkaSpec{Brokers: []string{"127.0.0.1:9092"}, Topic: "requests"}
+	for _, spec := range []Spec{
+		{SampleRate: 0, Kafka: kafka},
+		{SampleRate: 101, Kafka: kafka},
+		{SampleRate: 10},
+		{SampleRate: 10, Kafka: kafka, ClickHouse: &ClickHouseSpec{}},
+		{SampleRate: 10, Kafka: kafka, PathTemplates: []string{"users/{id}"}},
+		{SampleRate: 10, Kafka: kafka, FlushInterval: "0s"},
+	} {
+		if spec.Validate() == nil {
+			t.Errorf("spec %+v should be invalid", spec)
+		}
+	}
+}
+
+func TestClickHouseSinkVault(t *testing.T) {
+	ch := newClickHouse()
+	defer ch.Close()
+
+	vaultServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" || r.URL.Path != "/v1/database/creds/clickhouse" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"lease_id": "l1", "lease_duration": 3600, "renewable": true,
+			"data": {"username": "default", "password": "secret"}}`))
+	}))
+	defer vaultServer.Close()
+
+	s := newSink(&Spec{ClickHouse: &ClickHouseSpec{
+		URL:   ch.URL,
+		Table: "requests",
+		Vault: &vault.Spec{Address: vaultServer.URL, Path: "database/creds/clickhouse", Token: "root"},
+	}})
+	defer s.close()
+
+	if err := s.send([]*Record{{Path: "/users"}}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+	if len(ch.records) != 1 || ch.records[0].Path != "/users" {
+		t.Errorf("unexpected records %+v", ch.records)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package analyticssampler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const scrubbedValue = "[SCRUBBED]"
+
+type (
+	// ScrubSpec describes how PII is scrubbed from records.
+	ScrubSpec struct {
+		// Regexps are applied to the path, the query and the headers.
+		Regexps []*RegexpSpec `yaml:"regexps" jsonschema:"omitempty"`
+		// QueryParams are the query parameters whose values are scrubbed.
+		QueryParams []string `yaml:"queryParams" jsonschema:"omitempty,uniqueItems=true"`
+		// HashConsumer replaces the consumer with its SHA256 hash.
+		HashConsumer bool `yaml:"hashConsumer" jsonschema:"omitempty"`
+		// MaskClientIP zeroes the last byte of IPv4 addresses and
+		// the last 80 bits of IPv6 addresses.
+		MaskClientIP bool `yaml:"maskClientIP" jsonschema:"omitempty"`
+	}
+
+	// RegexpSpec replaces the matched contents with the replacement.
+	RegexpSpec struct {
+		Regexp      string `yaml:"regexp" jsonschema:"required,format=regexp"`
+		Replacement string `yaml:"replacement" jsonschema:"omitempty"`
+	}
+
+	scrubber struct {
+		spec        *ScrubSpec
+		regexps     []*regexp.Regexp
+		queryParams map[string]bool
+	}
+)
+
+// Validate validates ScrubSpec.
+func (spec ScrubSpec) Validate() error {
+	for _, r := range spec.Regexps {
+		if _, err := regexp.Compile(r.Regexp); err != nil {
+			return fmt.Errorf("invalid regexp %s: %v", r.Regexp, err)
+		}
+	}
+	return nil
+}
+
+func newScrubber(spec *ScrubSpec) *scrubber {
+	if spec == nil {
+		spec = &ScrubSpec{}
+	}
+
+	s := &scrubber{spec: spec, queryParams: map[string]bool{}}
+	for _, r := range spec.Regexps {
+		s.regexps = append(s.regexps, regexp.MustCompile(r.Regexp))
+	}
+	for _, p := range spec.QueryParams {
+		s.queryParams[p] = true
+	}
+	return s
+}
+
+func (s *scrubber) text(text string) string {
+	for i, re := range s.regexps {
+		replacement := s.spec.Regexps[i].Replacement
+		if replacement == "" {
+			replacement = scrubbedValue
+		}
+		text = re.ReplaceAllLiteralString(text, replacement)
+	}
+	return text
+}
+
+// query scrubs the raw query, parameters are sorted to make
+// records of the same query identical.
+func (s *scrubber) query(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return s.text(rawQuery)
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		for _, v := range values[k] {
+			if s.queryParams[k] {
+				v = scrubbedValue
+			} else {
+				v = s.text(v)
+			}
+			if sb.Len() > 0 {
+				sb.WriteByte('&')
+			}
+			sb.WriteString(k)
+			sb.WriteByte('=')
+			sb.WriteString(v)
+		}
+	}
+	return sb.String()
+}
+
+func (s *scrubber) consumer(c string) string {
+	if c == "" || !s.spec.HashConsumer {
+		return c
+	}
+	sum := sha256.Sum256([]byte(c))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *scrubber) clientIP(ip string) string {
+	if !s.spec.MaskClientIP {
+		return ip
+	}
+
+	parsed := net.ParseIP(ip)
+	switch {
+	case parsed == nil:
+		return ""
+	case parsed.To4() != nil:
+		return parsed.Mask(net.CIDRMask(24, 32)).String()
+	default:
+		return parsed.Mask(net.CIDRMask(48, 128)).String()
+	}
+}
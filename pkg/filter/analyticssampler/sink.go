@@ -0,0 +1,227 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package analyticssampler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/megaease/easegress/pkg/util/vault"
+)
+
+const sinkTimeout = 30 * time.Second
+
+type (
+	// ClickHouseSpec is the spec of the ClickHouse sink, records are
+	// inserted by the HTTP interface in the format JSONEachRow.
+	ClickHouseSpec struct {
+		URL      string `yaml:"url" jsonschema:"required,format=url"`
+		Table    string `yaml:"table" jsonschema:"required"`
+		Username string `yaml:"username" jsonschema:"omitempty"`
+		Password string `yaml:"password" jsonschema:"omitempty"`
+		// Vault overrides the username and password by the credentials in Vault.
+		Vault *vault.Spec `yaml:"vault,omitempty" jsonschema:"omitempty"`
+	}
+
+	// KafkaSpec is the spec of the Kafka sink, every record is a
+	// message keyed by the path. SASL/PLAIN is enabled if there is
+	// a username.
+	KafkaSpec struct {
+		Brokers  []string `yaml:"brokers" jsonschema:"required,uniqueItems=true"`
+		Topic    string   `yaml:"topic" jsonschema:"required"`
+		Username string   `yaml:"username" jsonschema:"omitempty"`
+		Password string   `yaml:"password" jsonschema:"omitempty"`
+		// Vault overrides the username and password by the credentials in Vault.
+		Vault *vault.Spec `yaml:"vault,omitempty" jsonschema:"omitempty"`
+	}
+
+	sink interface {
+		send(records []*Record) error
+		close()
+	}
+
+	clickHouseSink struct {
+		spec   *ClickHouseSpec
+		client *http.Client
+		vault  *vault.Client
+	}
+
+	kafkaSink struct {
+		spec  *KafkaSpec
+		vault *vault.Client
+
+		mutex    sync.Mutex
+		producer sarama.SyncProducer
+		// credentials are the Vault credentials of producer.
+		credentials *vault.Credentials
+	}
+)
+
+func newSink(spec *Spec) sink {
+	if spec.ClickHouse != nil {
+		s := &clickHouseSink{spec: spec.ClickHouse, client: &http.Client{Timeout: sinkTimeout}}
+		if s.spec.Vault != nil {
+			s.vault = vault.New(s.spec.Vault)
+		}
+		return s
+	}
+
+	s := &kafkaSink{spec: spec.Kafka}
+	if s.spec.Vault != nil {
+		s.vault = vault.New(s.spec.Vault)
+	}
+	return s
+}
+
+func (s *clickHouseSink) send(records []*Record) error {
+	body := bytes.NewBuffer(nil)
+	encoder := json.NewEncoder(body)
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			return fmt.Errorf("marshal record to json failed: %v", err)
+		}
+	}
+
+	u, err := url.Parse(s.spec.URL)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("query", fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", s.spec.Table))
+	// NOTE: Times are in RFC3339, which is not the default input format.
+	q.Set("date_time_input_format", "best_effort")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodPost, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	username, password := s.spec.Username, s.spec.Password
+	if s.vault != nil {
+		creds, err := s.vault.Get()
+		if err != nil {
+			return fmt.Errorf("get credentials from vault failed: %v", err)
+		}
+		username, password = creds.Username, creds.Password
+	}
+	if username != "" {
+		req.Header.Set("X-ClickHouse-User", username)
+		req.Header.Set("X-ClickHouse-Key", password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, msg)
+}
+
+func (s *clickHouseSink) close() {
+	if s.vault != nil {
+		s.vault.Close()
+	}
+}
+
+func (s *kafkaSink) getProducer() (sarama.SyncProducer, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	username, password := s.spec.Username, s.spec.Password
+	if s.vault != nil {
+		creds, err := s.vault.Get()
+		if err != nil {
+			return nil, fmt.Errorf("get credentials from vault failed: %v", err)
+		}
+		// NOTE: Restart the producer by the rotated credentials.
+		if creds != s.credentials && s.producer != nil {
+			s.producer.Close()
+			s.producer = nil
+		}
+		s.credentials = creds
+		username, password = creds.Username, creds.Password
+	}
+
+	if s.producer != nil {
+		return s.producer, nil
+	}
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V0_10_2_0
+	config.Producer.Return.Successes = true
+	if username != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		config.Net.SASL.User = username
+		config.Net.SASL.Password = password
+	}
+
+	producer, err := sarama.NewSyncProducer(s.spec.Brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("start sarama producer failed(brokers: %v): %v", s.spec.Brokers, err)
+	}
+	s.producer = producer
+	return producer, nil
+}
+
+func (s *kafkaSink) send(records []*Record) error {
+	producer, err := s.getProducer()
+	if err != nil {
+		return err
+	}
+
+	messages := make([]*sarama.ProducerMessage, len(records))
+	for i, r := range records {
+		value, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("marshal record to json failed: %v", err)
+		}
+		messages[i] = &sarama.ProducerMessage{
+			Topic: s.spec.Topic,
+			Key:   sarama.StringEncoder(r.Path),
+			Value: sarama.ByteEncoder(value),
+		}
+	}
+
+	return producer.SendMessages(messages)
+}
+
+func (s *kafkaSink) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.producer != nil {
+		s.producer.Close()
+		s.producer = nil
+	}
+	if s.vault != nil {
+		s.vault.Close()
+	}
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package audittrail
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/api"
+)
+
+const (
+	apiGroupName = "audittrail_admin"
+	apiPrefix    = "/audittrail/{pipeline}/{filter}"
+
+	defaultLimit = 1000
+)
+
+var (
+	auditTrailsMutex sync.RWMutex
+	auditTrails      = make(map[string]*AuditTrail)
+	registerOnce     sync.Once
+)
+
+func auditTrailKey(pipeline, filter string) string {
+	return pipeline + "/" + filter
+}
+
+func registerAuditTrail(a *AuditTrail) {
+	registerOnce.Do(registerAPIs)
+
+	auditTrailsMutex.Lock()
+	defer auditTrailsMutex.Unlock()
+
+	auditTrails[auditTrailKey(a.filterSpec.Pipeline(), a.filterSpec.Name())] = a
+}
+
+func unregisterAuditTrail(a *AuditTrail) {
+	auditTrailsMutex.Lock()
+	defer auditTrailsMutex.Unlock()
+
+	key := auditTrailKey(a.filterSpec.Pipeline(), a.filterSpec.Name())
+	// NOTE: The next generation may have registered itself.
+	if auditTrails[key] == a {
+		delete(auditTrails, key)
+	}
+}
+
+func findStore(pipeline, filter string) (*store, error) {
+	auditTrailsMutex.RLock()
+	a := auditTrails[auditTrailKey(pipeline, filter)]
+	auditTrailsMutex.RUnlock()
+
+	if a == nil || a.store == nil {
+		return nil, fmt.Errorf("audit trail %s not found in pipeline %s", filter, pipeline)
+	}
+	return a.store, nil
+}
+
+// Walk calls fn for every record matching the query of the AuditTrail
+// in the pipeline on the local member, until fn returns false.
+func Walk(pipeline, filter string, q *Query, fn func(r *Record) bool) error {
+	s, err := findStore(pipeline, filter)
+	if err != nil {
+		return err
+	}
+	return s.walk(q, fn)
+}
+
+// Usages returns the usage of consumers of records matching the query
+// of the AuditTrail in the pipeline on the local member.
+func Usages(pipeline, filter string, q *Query) ([]*Usage, error) {
+	s, err := findStore(pipeline, filter)
+	if err != nil {
+		return nil, err
+	}
+	return s.usage(q)
+}
+
+func registerAPIs() {
+	api.RegisterAPIs(&api.Group{
+		Group: apiGroupName,
+		Entries: []*api.Entry{
+			{Path: apiPrefix + "/records", Method: "GET", Handler: listRecords},
+			{Path: apiPrefix + "/usage", Method: "GET", Handler: listUsage},
+		},
+	})
+}
+
+func getStore(w http.ResponseWriter, r *http.Request) *store {
+	pipeline, filter := chi.URLParam(r, "pipeline"), chi.URLParam(r, "filter")
+
+	auditTrailsMutex.RLock()
+	a := auditTrails[auditTrailKey(pipeline, filter)]
+	auditTrailsMutex.RUnlock()
+
+	if a == nil {
+		api.HandleAPIError(w, r, http.StatusNotFound,
+			fmt.Errorf("audit trail %s not found in pipeline %s", filter, pipeline))
+		return nil
+	}
+	if a.store == nil {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable,
+			fmt.Errorf("audit trail %s in pipeline %s is unavailable", filter, pipeline))
+		return nil
+	}
+	return a.store
+}
+
+// parseQuery parses the query from URL parameters:
+// since, until (RFC3339), consumer, method, class and limit.
+func parseQuery(r *http.Request) (*Query, error) {
+	values := r.URL.Query()
+	q := &Query{
+		Method: values.Get("method"),
+		Class:  values.Get("class"),
+		Limit:  defaultLimit,
+	}
+
+	if _, ok := values["consumer"]; ok {
+		q.Consumer, q.HasConsumer = values.Get("consumer"), true
+	}
+
+	var err error
+	if v := values.Get("since"); v != "" {
+		q.Since, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid since: %v", err)
+		}
+	}
+	if v := values.Get("until"); v != "" {
+		q.Until, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid until: %v", err)
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil || q.Limit <= 0 {
+			return nil, fmt.Errorf("invalid limit: %s", v)
+		}
+	}
+
+	return q, nil
+}
+
+func writeYAML(w http.ResponseWriter, v interface{}) {
+	buff, err := yaml.Marshal(v)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", v, err))
+	}
+	w.Header().Set("Content-Type", "text/vnd.yaml")
+	w.Write(buff)
+}
+
+func listRecords(w http.ResponseWriter, r *http.Request) {
+	s := getStore(w, r)
+	if s == nil {
+		return
+	}
+
+	q, err := parseQuery(r)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	records, err := s.query(q)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeYAML(w, records)
+}
+
+func listUsage(w http.ResponseWriter, r *http.Request) {
+	s := getStore(w, r)
+	if s == nil {
+		return
+	}
+
+	q, err := parseQuery(r)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	usage, err := s.usage(q)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeYAML(w, usage)
+}
@@ -0,0 +1,223 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package audittrail
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/consumer"
+)
+
+const (
+	// Kind is the kind of AuditTrail.
+	Kind = "AuditTrail"
+)
+
+var results = []string{}
+
+func init() {
+	httppipeline.Register(&AuditTrail{})
+}
+
+type (
+	// AuditTrail records the requests of every consumer in rolling files.
+	AuditTrail struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		resolve consumer.Resolver
+		store   *store
+
+		numOfRecords uint64
+	}
+
+	// Spec describes the AuditTrail.
+	Spec struct {
+		Consumer *consumer.Spec `yaml:"consumer" jsonschema:"required"`
+		// Dir is the directory of the record files, default to
+		// audittrail/{pipeline}/{filter} in the data directory.
+		Dir string `yaml:"dir" jsonschema:"omitempty"`
+		// Rotation is the time span of a record file.
+		Rotation string `yaml:"rotation" jsonschema:"omitempty,format=duration"`
+		// Retention is how long the records are kept.
+		Retention string `yaml:"retention" jsonschema:"omitempty,format=duration"`
+	}
+
+	// Status is the status of AuditTrail.
+	Status struct {
+		Dir          string `yaml:"dir"`
+		NumOfRecords uint64 `yaml:"numOfRecords"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	err := spec.Consumer.Validate()
+	if err != nil {
+		return fmt.Errorf("consumer: %v", err)
+	}
+
+	rotation, err := time.ParseDuration(spec.Rotation)
+	if err != nil {
+		return fmt.Errorf("invalid rotation: %v", err)
+	}
+	retention, err := time.ParseDuration(spec.Retention)
+	if err != nil {
+		return fmt.Errorf("invalid retention: %v", err)
+	}
+	if rotation < time.Minute {
+		return fmt.Errorf("rotation must be at least 1m")
+	}
+	if retention < rotation {
+		return fmt.Errorf("retention must not be less than rotation")
+	}
+
+	return nil
+}
+
+// Kind returns the kind of AuditTrail.
+func (a *AuditTrail) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of AuditTrail.
+func (a *AuditTrail) DefaultSpec() interface{} {
+	return &Spec{
+		Rotation:  "1h",
+		Retention: "720h",
+	}
+}
+
+// Description returns the description of AuditTrail.
+func (a *AuditTrail) Description() string {
+	return "AuditTrail records the routes, methods and response classes called by every consumer."
+}
+
+// Results returns the results of AuditTrail.
+func (a *AuditTrail) Results() []string {
+	return results
+}
+
+// Init initializes AuditTrail.
+func (a *AuditTrail) Init(filterSpec *httppipeline.FilterSpec) {
+	a.filterSpec, a.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	a.reload(nil)
+}
+
+// Inherit inherits previous generation of AuditTrail.
+func (a *AuditTrail) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	a.filterSpec, a.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	a.reload(previousGeneration.(*AuditTrail))
+}
+
+func (a *AuditTrail) dir() string {
+	if a.spec.Dir != "" {
+		return a.spec.Dir
+	}
+	return filepath.Join(a.filterSpec.Super().Options().AbsDataDir,
+		"audittrail", a.filterSpec.Pipeline(), a.filterSpec.Name())
+}
+
+func (a *AuditTrail) reload(previousGeneration *AuditTrail) {
+	a.resolve = consumer.NewResolver(a.spec.Consumer)
+
+	rotation, _ := time.ParseDuration(a.spec.Rotation)
+	retention, _ := time.ParseDuration(a.spec.Retention)
+	dir := a.dir()
+
+	// NOTE: Reuse the store of the previous generation if possible,
+	// so that records of requests still in flight are not dropped.
+	if previousGeneration != nil {
+		unregisterAuditTrail(previousGeneration)
+		if previousGeneration.store != nil && previousGeneration.store.dir == dir {
+			a.store = previousGeneration.store
+			a.store.update(rotation, retention)
+		} else {
+			previousGeneration.Close()
+		}
+	}
+
+	if a.store == nil {
+		s, err := newStore(dir, rotation, retention)
+		if err != nil {
+			logger.Errorf("create audit trail %s/%s failed: %v",
+				a.filterSpec.Pipeline(), a.filterSpec.Name(), err)
+		}
+		a.store = s
+	}
+
+	registerAuditTrail(a)
+}
+
+// Handle records the request when it's finished.
+func (a *AuditTrail) Handle(ctx context.HTTPContext) string {
+	result := a.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (a *AuditTrail) handle(ctx context.HTTPContext) string {
+	s := a.store
+	if s == nil {
+		return ""
+	}
+
+	// NOTE: Resolve the consumer before the request is changed
+	// by the following filters.
+	r := ctx.Request()
+	record := &Record{
+		Consumer: a.resolve(ctx),
+		Method:   r.Method(),
+		Path:     r.Path(),
+	}
+
+	ctx.OnFinish(func() {
+		record.Time = time.Now()
+		record.StatusCode = ctx.Response().StatusCode()
+		record.Class = statusClass(record.StatusCode)
+		record.DurationMs = ctx.Duration().Milliseconds()
+		record.RequestBytes = ctx.Request().Size()
+		record.ResponseBytes = ctx.Response().Size()
+
+		s.append(record)
+		atomic.AddUint64(&a.numOfRecords, 1)
+	})
+
+	return ""
+}
+
+// Status returns status.
+func (a *AuditTrail) Status() interface{} {
+	s := &Status{NumOfRecords: atomic.LoadUint64(&a.numOfRecords)}
+	if a.store != nil {
+		s.Dir = a.store.dir
+	}
+	return s
+}
+
+// Close closes AuditTrail.
+func (a *AuditTrail) Close() {
+	unregisterAuditTrail(a)
+	if a.store != nil {
+		a.store.close()
+	}
+}
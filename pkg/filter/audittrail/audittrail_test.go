@@ -0,0 +1,215 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package audittrail
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newAuditTrail(t *testing.T, dir string) *AuditTrail {
+	yamlSpec := fmt.Sprintf(`
+kind: AuditTrail
+name: audit
+consumer:
+  source: Header
+  name: X-Consumer
+dir: %s
+`, dir)
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	a := &AuditTrail{}
+	a.Init(spec)
+	return a
+}
+
+func handleRequest(a *AuditTrail, consumer, method, path string, code int) {
+	req := httptest.NewRequest(method, path, nil)
+	if consumer != "" {
+		req.Header.Set("X-Consumer", consumer)
+	}
+	w := httptest.NewRecorder()
+	w.Code = code
+
+	ctx := contexttest.NewMockedHTTPContext(req, w)
+	ctx.MockedRequest.MockedSize = func() uint64 { return 10 }
+	ctx.MockedResponse.MockedSize = func() uint64 { return 100 }
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		// NOTE: The following filters must not change the consumer.
+		req.Header.Del("X-Consumer")
+		return lastResult
+	}
+
+	a.Handle(ctx)
+	ctx.Finish()
+}
+
+func TestAuditTrail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audittrail")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAuditTrail(t, dir)
+	defer a.Close()
+
+	handleRequest(a, "alice", http.MethodGet, "/orders", http.StatusOK)
+	handleRequest(a, "alice", http.MethodPost, "/orders", http.StatusCreated)
+	handleRequest(a, "alice", http.MethodGet, "/orders", http.StatusNotFound)
+	handleRequest(a, "bob", http.MethodGet, "/users", http.StatusInternalServerError)
+	handleRequest(a, "", http.MethodGet, "/", http.StatusOK)
+
+	if n := a.Status().(*Status).NumOfRecords; n != 5 {
+		t.Errorf("want 5 records, got %d", n)
+	}
+
+	records, err := a.store.query(&Query{})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(records) != 5 {
+		t.Fatalf("want 5 records, got %d", len(records))
+	}
+	r := records[0]
+	if r.Consumer != "alice" || r.Method != http.MethodGet || r.Path != "/orders" ||
+		r.StatusCode != http.StatusOK || r.Class != "2xx" || r.RequestBytes != 10 || r.ResponseBytes != 100 {
+		t.Errorf("unexpected record %+v", r)
+	}
+
+	records, _ = a.store.query(&Query{Consumer: "alice", HasConsumer: true, Class: "2xx"})
+	if len(records) != 2 {
+		t.Errorf("want 2 records, got %d", len(records))
+	}
+	records, _ = a.store.query(&Query{HasConsumer: true})
+	if len(records) != 1 || records[0].Path != "/" {
+		t.Errorf("want 1 anonymous record, got %v", records)
+	}
+	records, _ = a.store.query(&Query{Limit: 3})
+	if len(records) != 3 {
+		t.Errorf("want 3 records, got %d", len(records))
+	}
+	records, _ = a.store.query(&Query{Since: time.Now().Add(time.Minute)})
+	if len(records) != 0 {
+		t.Errorf("want no records, got %d", len(records))
+	}
+
+	usage, err := a.store.usage(&Query{})
+	if err != nil {
+		t.Fatalf("usage failed: %v", err)
+	}
+	if len(usage) != 3 {
+		t.Fatalf("want usage of 3 consumers, got %d", len(usage))
+	}
+	u := usage[1]
+	if u.Consumer != "alice" || u.Requests != 3 || u.Classes["2xx"] != 2 || u.Classes["4xx"] != 1 ||
+		u.Routes["GET /orders"] != 2 || u.RequestBytes != 30 || u.ResponseBytes != 300 {
+		t.Errorf("unexpected usage %+v", u)
+	}
+}
+
+func TestAuditTrailInherit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audittrail")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAuditTrail(t, dir)
+	handleRequest(a, "alice", http.MethodGet, "/", http.StatusOK)
+
+	b := &AuditTrail{}
+	b.Inherit(a.filterSpec, a)
+	defer b.Close()
+	if b.store != a.store {
+		t.Errorf("store should be reused")
+	}
+
+	// Requests of the previous generation are still recorded.
+	handleRequest(a, "alice", http.MethodGet, "/", http.StatusOK)
+	handleRequest(b, "bob", http.MethodGet, "/", http.StatusOK)
+
+	records, _ := b.store.query(&Query{})
+	if len(records) != 3 {
+		t.Errorf("want 3 records, got %d", len(records))
+	}
+}
+
+func TestStorePurge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audittrail")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := newStore(dir, time.Hour, 2*time.Hour)
+	if err != nil {
+		t.Fatalf("create store failed: %v", err)
+	}
+	defer s.close()
+
+	old := time.Now().Add(-4 * time.Hour).Truncate(time.Hour)
+	err = ioutil.WriteFile(s.fileName(old), []byte("{}\n"), 0o640)
+	if err != nil {
+		t.Fatalf("write file failed: %v", err)
+	}
+	s.append(&Record{Time: time.Now(), Consumer: "alice"})
+
+	s.purge()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 || files[0] == s.fileName(old) {
+		t.Errorf("old file should be purged, got %v", files)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, s := range []string{
+		"consumer: {source: Header}\nrotation: 1h\nretention: 1h",
+		"consumer: {source: ClientIP}\nrotation: 1s\nretention: 1h",
+		"consumer: {source: ClientIP}\nrotation: 1h\nretention: 30m",
+	} {
+		spec := &Spec{}
+		yamltool.Unmarshal([]byte(s), spec)
+		if spec.Validate() == nil {
+			t.Errorf("%q should be invalid", s)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package audittrail
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	filePrefix = "records-"
+	fileSuffix = ".jsonl"
+
+	flushInterval = time.Second
+	purgeInterval = time.Minute
+)
+
+type (
+	// Record is an audit record of a request.
+	Record struct {
+		// Time is the time the request finished.
+		Time          time.Time `yaml:"time" json:"time"`
+		Consumer      string    `yaml:"consumer" json:"consumer"`
+		Method        string    `yaml:"method" json:"method"`
+		Path          string    `yaml:"path" json:"path"`
+		StatusCode    int       `yaml:"statusCode" json:"statusCode"`
+		Class         string    `yaml:"class" json:"class"`
+		DurationMs    int64     `yaml:"durationMs" json:"durationMs"`
+		RequestBytes  uint64    `yaml:"requestBytes" json:"requestBytes"`
+		ResponseBytes uint64    `yaml:"responseBytes" json:"responseBytes"`
+	}
+
+	// Usage is the accounting of a consumer.
+	Usage struct {
+		Consumer string `yaml:"consumer" json:"consumer"`
+		Requests uint64 `yaml:"requests" json:"requests"`
+		// Classes is the number of requests of every response class.
+		Classes map[string]uint64 `yaml:"classes" json:"classes"`
+		// Routes is the number of requests of every method and path.
+		Routes        map[string]uint64 `yaml:"routes" json:"routes"`
+		RequestBytes  uint64            `yaml:"requestBytes" json:"requestBytes"`
+		ResponseBytes uint64            `yaml:"responseBytes" json:"responseBytes"`
+	}
+
+	// Query is the criteria of records, zero values match everything
+	// except Consumer, which is only applied when HasConsumer is set,
+	// because anonymous requests have an empty consumer.
+	Query struct {
+		Since       time.Time
+		Until       time.Time
+		Consumer    string
+		HasConsumer bool
+		Method      string
+		Class       string
+		Limit       int
+	}
+
+	// store stores records in rolling files, one file per rotation
+	// interval, files older than the retention are removed.
+	store struct {
+		dir string
+
+		mutex     sync.Mutex
+		rotation  time.Duration
+		retention time.Duration
+		file      *os.File
+		writer    *bufio.Writer
+		fileStart time.Time
+		closed    bool
+
+		done chan struct{}
+	}
+)
+
+// statusClass returns the class of the status code, e.g. 2xx.
+func statusClass(code int) string {
+	if code < 100 || code > 599 {
+		return "other"
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+func (q *Query) match(r *Record) bool {
+	if !q.Since.IsZero() && r.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !r.Time.Before(q.Until) {
+		return false
+	}
+	if q.HasConsumer && r.Consumer != q.Consumer {
+		return false
+	}
+	if q.Method != "" && r.Method != q.Method {
+		return false
+	}
+	if q.Class != "" && r.Class != q.Class {
+		return false
+	}
+	return true
+}
+
+func newStore(dir string, rotation, retention time.Duration) (*store, error) {
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return nil, fmt.Errorf("create directory %s failed: %v", dir, err)
+	}
+
+	s := &store{
+		dir:       dir,
+		rotation:  rotation,
+		retention: retention,
+		done:      make(chan struct{}),
+	}
+	s.purge()
+	go s.run()
+
+	return s, nil
+}
+
+// update updates the rotation and retention, it takes effect
+// from the next file.
+func (s *store) update(rotation, retention time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.rotation, s.retention = rotation, retention
+}
+
+func (s *store) run() {
+	flushTicker := time.NewTicker(flushInterval)
+	defer flushTicker.Stop()
+	purgeTicker := time.NewTicker(purgeInterval)
+	defer purgeTicker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-flushTicker.C:
+			s.mutex.Lock()
+			s.flush()
+			s.mutex.Unlock()
+		case <-purgeTicker.C:
+			s.purge()
+		}
+	}
+}
+
+func (s *store) fileName(start time.Time) string {
+	return filepath.Join(s.dir, filePrefix+strconv.FormatInt(start.Unix(), 10)+fileSuffix)
+}
+
+// files returns the start time and name of all record files,
+// sorted by the start time.
+func (s *store) files() ([]time.Time, []string, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var starts []time.Time
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		sec, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		starts = append(starts, time.Unix(sec, 0))
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	names := make([]string, len(starts))
+	for i, start := range starts {
+		names[i] = s.fileName(start)
+	}
+
+	return starts, names, nil
+}
+
+func (s *store) purge() {
+	s.mutex.Lock()
+	retention, rotation := s.retention, s.rotation
+	s.mutex.Unlock()
+
+	starts, names, err := s.files()
+	if err != nil {
+		logger.Errorf("list audit records in %s failed: %v", s.dir, err)
+		return
+	}
+
+	deadline := time.Now().Add(-retention)
+	for i, start := range starts {
+		// NOTE: The file is kept while any of its records is retained.
+		if start.Add(rotation).After(deadline) {
+			continue
+		}
+		err := os.Remove(names[i])
+		if err != nil {
+			logger.Errorf("remove %s failed: %v", names[i], err)
+		}
+	}
+}
+
+func (s *store) append(r *Record) {
+	buff, err := json.Marshal(r)
+	if err != nil {
+		logger.Errorf("BUG: marshal %#v to json failed: %v", r, err)
+		return
+	}
+	buff = append(buff, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		logger.Errorf("audit trail %s closed, record dropped: %s", s.dir, buff)
+		return
+	}
+
+	start := r.Time.Truncate(s.rotation)
+	if s.file == nil || !start.Equal(s.fileStart) {
+		s.closeFile()
+		err := s.openFile(start)
+		if err != nil {
+			logger.Errorf("%v", err)
+			return
+		}
+	}
+
+	_, err = s.writer.Write(buff)
+	if err != nil {
+		logger.Errorf("write audit record to %s failed: %v", s.file.Name(), err)
+	}
+}
+
+func (s *store) openFile(start time.Time) error {
+	name := s.fileName(start)
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("open %s failed: %v", name, err)
+	}
+
+	s.file, s.writer, s.fileStart = file, bufio.NewWriter(file), start
+	return nil
+}
+
+func (s *store) flush() {
+	if s.writer == nil {
+		return
+	}
+	err := s.writer.Flush()
+	if err != nil {
+		logger.Errorf("flush audit records to %s failed: %v", s.file.Name(), err)
+	}
+}
+
+func (s *store) closeFile() {
+	if s.file == nil {
+		return
+	}
+	s.flush()
+	err := s.file.Close()
+	if err != nil {
+		logger.Errorf("close %s failed: %v", s.file.Name(), err)
+	}
+	s.file, s.writer = nil, nil
+}
+
+// walk calls fn for every record matching the query until fn returns false.
+func (s *store) walk(q *Query, fn func(r *Record) bool) error {
+	s.mutex.Lock()
+	s.flush()
+	s.mutex.Unlock()
+
+	starts, names, err := s.files()
+	if err != nil {
+		return err
+	}
+
+	for i, start := range starts {
+		// NOTE: All records of a file are not earlier than its start.
+		if !q.Until.IsZero() && !start.Before(q.Until) {
+			break
+		}
+
+		cont, err := walkFile(names[i], q, fn)
+		if err != nil {
+			return err
+		}
+		if !cont {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func walkFile(name string, q *Query, fn func(r *Record) bool) (bool, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		r := &Record{}
+		err := json.Unmarshal(scanner.Bytes(), r)
+		if err != nil {
+			// NOTE: The last line may be partially written.
+			continue
+		}
+		if !q.match(r) {
+			continue
+		}
+		if !fn(r) {
+			return false, nil
+		}
+	}
+
+	return true, scanner.Err()
+}
+
+// query returns at most q.Limit records matching the query.
+func (s *store) query(q *Query) ([]*Record, error) {
+	records := []*Record{}
+	err := s.walk(q, func(r *Record) bool {
+		records = append(records, r)
+		return q.Limit <= 0 || len(records) < q.Limit
+	})
+	return records, err
+}
+
+func (s *store) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	s.closeFile()
+}
+
+// usage returns the usage of consumers of records matching the query,
+// sorted by the consumer.
+func (s *store) usage(q *Query) ([]*Usage, error) {
+	usages := map[string]*Usage{}
+	err := s.walk(q, func(r *Record) bool {
+		u := usages[r.Consumer]
+		if u == nil {
+			u = &Usage{
+				Consumer: r.Consumer,
+				Classes:  map[string]uint64{},
+				Routes:   map[string]uint64{},
+			}
+			usages[r.Consumer] = u
+		}
+		u.Requests++
+		u.Classes[r.Class]++
+		u.Routes[r.Method+" "+r.Path]++
+		u.RequestBytes += r.RequestBytes
+		u.ResponseBytes += r.ResponseBytes
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Usage, 0, len(usages))
+	for _, u := range usages {
+		result = append(result, u)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Consumer < result[j].Consumer })
+
+	return result, nil
+}
@@ -0,0 +1,370 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package bodylimit
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of BodyLimit.
+	Kind = "BodyLimit"
+
+	resultTooLarge             = "tooLarge"
+	resultUnsupportedMediaType = "unsupportedMediaType"
+
+	defaultScanWindow = 4096
+)
+
+var results = []string{resultTooLarge, resultUnsupportedMediaType}
+
+func init() {
+	httppipeline.Register(&BodyLimit{})
+}
+
+type (
+	// BodyLimit limits the sizes of the request and response bodies, and
+	// scans them against the content type and pattern policies as they
+	// are streamed, so an oversize payload is rejected before it's
+	// buffered in memory.
+	BodyLimit struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		request  *policy
+		response *policy
+
+		requestsTooLarge          uint64
+		requestsDenied            uint64
+		requestsUnsupportedMedia  uint64
+		responsesTooLarge         uint64
+		responsesDenied           uint64
+		responsesUnsupportedMedia uint64
+	}
+
+	// Spec describes the BodyLimit.
+	Spec struct {
+		Request  *Policy `yaml:"request,omitempty" jsonschema:"omitempty"`
+		Response *Policy `yaml:"response,omitempty" jsonschema:"omitempty"`
+		// ScanWindow is the max length in bytes of the matches of the deny
+		// patterns, longer matches split across reads may be missed.
+		ScanWindow int `yaml:"scanWindow" jsonschema:"omitempty,minimum=1"`
+	}
+
+	// Policy is the policy of the request or response bodies.
+	Policy struct {
+		// MaxBytes is the max size of the bodies, 0 means unlimited.
+		MaxBytes int64 `yaml:"maxBytes" jsonschema:"omitempty"`
+		// ContentTypes are the allowed media types of the bodies, like
+		// application/json or text/*, empty means any.
+		ContentTypes []string `yaml:"contentTypes" jsonschema:"omitempty,uniqueItems=true"`
+		// DenyPatterns are the regular expressions of the denied content
+		// of the bodies.
+		DenyPatterns []string `yaml:"denyPatterns" jsonschema:"omitempty,uniqueItems=true"`
+		// ScanContentTypes are the media types of the bodies to scan by the
+		// deny patterns, empty means all, e.g. binary bodies like images
+		// are better excluded.
+		ScanContentTypes []string `yaml:"scanContentTypes" jsonschema:"omitempty,uniqueItems=true"`
+	}
+
+	// Status is the status of BodyLimit.
+	Status struct {
+		RequestsTooLarge          uint64 `yaml:"requestsTooLarge"`
+		RequestsDenied            uint64 `yaml:"requestsDenied"`
+		RequestsUnsupportedMedia  uint64 `yaml:"requestsUnsupportedMedia"`
+		ResponsesTooLarge         uint64 `yaml:"responsesTooLarge"`
+		ResponsesDenied           uint64 `yaml:"responsesDenied"`
+		ResponsesUnsupportedMedia uint64 `yaml:"responsesUnsupportedMedia"`
+	}
+
+	policy struct {
+		*Policy
+		patterns []*regexp.Regexp
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if spec.Request == nil && spec.Response == nil {
+		return fmt.Errorf("neither request nor response is specified")
+	}
+	return nil
+}
+
+// Validate validates Policy.
+func (p Policy) Validate() error {
+	if p.MaxBytes < 0 {
+		return fmt.Errorf("maxBytes must not be negative")
+	}
+	if p.MaxBytes == 0 && len(p.ContentTypes) == 0 && len(p.DenyPatterns) == 0 {
+		return fmt.Errorf("none of maxBytes, contentTypes and denyPatterns is specified")
+	}
+	for _, pattern := range p.DenyPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid deny pattern %s: %v", pattern, err)
+		}
+	}
+	for _, ct := range append(p.ContentTypes, p.ScanContentTypes...) {
+		if !validMediaRange(ct) {
+			return fmt.Errorf("invalid media type %s", ct)
+		}
+	}
+	return nil
+}
+
+func validMediaRange(mediaRange string) bool {
+	parts := strings.Split(mediaRange, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return false
+	}
+	return parts[0] != "*" || parts[1] == "*"
+}
+
+// Kind returns the kind of BodyLimit.
+func (bl *BodyLimit) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of BodyLimit.
+func (bl *BodyLimit) DefaultSpec() interface{} {
+	return &Spec{ScanWindow: defaultScanWindow}
+}
+
+// Description returns the description of BodyLimit.
+func (bl *BodyLimit) Description() string {
+	return "BodyLimit limits the sizes, content types and content of streaming request and response bodies."
+}
+
+// Results returns the results of BodyLimit.
+func (bl *BodyLimit) Results() []string {
+	return results
+}
+
+// Init initializes BodyLimit.
+func (bl *BodyLimit) Init(filterSpec *httppipeline.FilterSpec) {
+	bl.filterSpec, bl.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	bl.reload()
+}
+
+// Inherit inherits previous generation of BodyLimit.
+func (bl *BodyLimit) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	bl.Init(filterSpec)
+}
+
+func (bl *BodyLimit) reload() {
+	bl.request = newPolicy(bl.spec.Request)
+	bl.response = newPolicy(bl.spec.Response)
+}
+
+func newPolicy(spec *Policy) *policy {
+	if spec == nil {
+		return nil
+	}
+
+	p := &policy{Policy: spec}
+	for _, pattern := range spec.DenyPatterns {
+		// NOTE: The patterns are validated by Policy.Validate.
+		p.patterns = append(p.patterns, regexp.MustCompile(pattern))
+	}
+	return p
+}
+
+// Handle limits the request and response bodies of HTTPContext.
+func (bl *BodyLimit) Handle(ctx context.HTTPContext) string {
+	result, body := bl.handle(ctx)
+	if result != "" {
+		return ctx.CallNextHandler(result)
+	}
+
+	result = ctx.CallNextHandler(result)
+
+	// NOTE: The request body is read by the following filters, e.g. Proxy,
+	// so it's only known now whether it's rejected, and the response of
+	// them is replaced.
+	if body != nil && body.err != nil {
+		if body.err == errTooLarge {
+			atomic.AddUint64(&bl.requestsTooLarge, 1)
+			ctx.AddTag("bodyLimit: request body too large")
+			replaceResponse(ctx, http.StatusRequestEntityTooLarge)
+		} else {
+			atomic.AddUint64(&bl.requestsDenied, 1)
+			ctx.AddTag(stringtool.Cat("bodyLimit: request body denied by ", body.pattern))
+			replaceResponse(ctx, http.StatusForbidden)
+		}
+		return result
+	}
+
+	bl.handleResponse(ctx)
+	return result
+}
+
+func (bl *BodyLimit) handle(ctx context.HTTPContext) (string, *guardedBody) {
+	p, r := bl.request, ctx.Request()
+	if p == nil {
+		return "", nil
+	}
+
+	contentLength := r.Std().ContentLength
+	if contentLength == 0 {
+		return "", nil
+	}
+
+	mediaType := parseMediaType(r.Header().Get("Content-Type"))
+	if len(p.ContentTypes) > 0 && !matchMediaType(p.ContentTypes, mediaType) {
+		atomic.AddUint64(&bl.requestsUnsupportedMedia, 1)
+		ctx.AddTag(stringtool.Cat("bodyLimit: unsupported request media type ", mediaType))
+		ctx.Response().SetStatusCode(http.StatusUnsupportedMediaType)
+		return resultUnsupportedMediaType, nil
+	}
+
+	// NOTE: The body isn't read at all, and the client doesn't even send
+	// it if it expects 100-continue.
+	if p.MaxBytes > 0 && contentLength > p.MaxBytes {
+		atomic.AddUint64(&bl.requestsTooLarge, 1)
+		ctx.AddTag(stringtool.Cat("bodyLimit: request content length ", strconv.FormatInt(contentLength, 10), " too large"))
+		ctx.Response().SetStatusCode(http.StatusRequestEntityTooLarge)
+		return resultTooLarge, nil
+	}
+
+	body := newGuardedBody(r.Body(), p.MaxBytes, bl.scanPatterns(p, mediaType), bl.spec.ScanWindow)
+	r.SetBody(body)
+	return "", body
+}
+
+func (bl *BodyLimit) handleResponse(ctx context.HTTPContext) {
+	p, w := bl.response, ctx.Response()
+	if p == nil || w.Body() == nil {
+		return
+	}
+
+	mediaType := parseMediaType(w.Header().Get("Content-Type"))
+	if len(p.ContentTypes) > 0 && !matchMediaType(p.ContentTypes, mediaType) {
+		atomic.AddUint64(&bl.responsesUnsupportedMedia, 1)
+		ctx.AddTag(stringtool.Cat("bodyLimit: unsupported response media type ", mediaType))
+		replaceResponse(ctx, http.StatusBadGateway)
+		return
+	}
+
+	if p.MaxBytes > 0 {
+		contentLength, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64)
+		if err == nil && contentLength > p.MaxBytes {
+			atomic.AddUint64(&bl.responsesTooLarge, 1)
+			ctx.AddTag(stringtool.Cat("bodyLimit: response content length ", strconv.FormatInt(contentLength, 10), " too large"))
+			replaceResponse(ctx, http.StatusBadGateway)
+			return
+		}
+	}
+
+	// NOTE: The status and header are sent before the body, so the
+	// response exceeding the limit or matching a deny pattern while it's
+	// streamed can only be truncated.
+	body := newGuardedBody(w.Body(), p.MaxBytes, bl.scanPatterns(p, mediaType), bl.spec.ScanWindow)
+	w.SetBody(&countedBody{guardedBody: body, bl: bl})
+}
+
+// scanPatterns returns the deny patterns to scan the body of the media type.
+func (bl *BodyLimit) scanPatterns(p *policy, mediaType string) []*regexp.Regexp {
+	if len(p.ScanContentTypes) > 0 && !matchMediaType(p.ScanContentTypes, mediaType) {
+		return nil
+	}
+	return p.patterns
+}
+
+// countedBody counts the responses rejected while they're streamed.
+type countedBody struct {
+	*guardedBody
+	bl      *BodyLimit
+	counted bool
+}
+
+func (cb *countedBody) Read(p []byte) (int, error) {
+	n, err := cb.guardedBody.Read(p)
+	if cb.err != nil && !cb.counted {
+		cb.counted = true
+		if cb.err == errTooLarge {
+			atomic.AddUint64(&cb.bl.responsesTooLarge, 1)
+		} else {
+			atomic.AddUint64(&cb.bl.responsesDenied, 1)
+		}
+	}
+	return n, err
+}
+
+// replaceResponse replaces the response with an empty one of the status code.
+func replaceResponse(ctx context.HTTPContext, code int) {
+	w := ctx.Response()
+	if closer, ok := w.Body().(io.Closer); ok {
+		closer.Close()
+	}
+	w.SetBody(nil)
+	w.Header().Reset(http.Header{})
+	w.SetStatusCode(code)
+}
+
+func parseMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// matchMediaType reports whether the media type matches any of the media
+// ranges, like text/html, text/* or */*.
+func matchMediaType(mediaRanges []string, mediaType string) bool {
+	if mediaType == "" {
+		return false
+	}
+
+	for _, mr := range mediaRanges {
+		mr = strings.ToLower(mr)
+		if mr == "*/*" || mr == mediaType {
+			return true
+		}
+		if strings.HasSuffix(mr, "/*") && strings.HasPrefix(mediaType, mr[:len(mr)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns status.
+func (bl *BodyLimit) Status() interface{} {
+	return &Status{
+		RequestsTooLarge:          atomic.LoadUint64(&bl.requestsTooLarge),
+		RequestsDenied:            atomic.LoadUint64(&bl.requestsDenied),
+		RequestsUnsupportedMedia:  atomic.LoadUint64(&bl.requestsUnsupportedMedia),
+		ResponsesTooLarge:         atomic.LoadUint64(&bl.responsesTooLarge),
+		ResponsesDenied:           atomic.LoadUint64(&bl.responsesDenied),
+		ResponsesUnsupportedMedia: atomic.LoadUint64(&bl.responsesUnsupportedMedia),
+	}
+}
+
+// Close closes BodyLimit.
+func (bl *BodyLimit) Close() {}
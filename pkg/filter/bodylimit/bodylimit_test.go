@@ -0,0 +1,250 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package bodylimit
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newBodyLimit(t *testing.T, yamlSpec string) *BodyLimit {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bl := &BodyLimit{}
+	bl.Init(spec)
+	return bl
+}
+
+// newContext creates a context whose next handler reads the request body
+// like Proxy, and responds with the response body and content type.
+func newContext(body io.Reader, contentLength int64, contentType string, respBody, respContentType string) (context.HTTPContext, *[]byte) {
+	stdr, _ := http.NewRequest(http.MethodPost, "http://example.com/", nil)
+	stdr.Body = ioutil.NopCloser(body)
+	stdr.ContentLength = contentLength
+	if contentType != "" {
+		stdr.Header.Set("Content-Type", contentType)
+	}
+
+	received := &[]byte{}
+	ctx := context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		// The following filters are skipped if the request is rejected.
+		if lastResult != "" {
+			return lastResult
+		}
+		*received, _ = ioutil.ReadAll(ctx.Request().Body())
+		ctx.Response().Header().Set("Content-Type", respContentType)
+		ctx.Response().SetBody(iotest.HalfReader(strings.NewReader(respBody)))
+		return lastResult
+	})
+	return ctx, received
+}
+
+func TestValidate(t *testing.T) {
+	for i, yamlSpec := range []string{
+		"kind: BodyLimit\nname: bl\n",
+		"kind: BodyLimit\nname: bl\nrequest: {}\n",
+		"kind: BodyLimit\nname: bl\nrequest:\n  maxBytes: -1\n",
+		"kind: BodyLimit\nname: bl\nrequest:\n  denyPatterns: ['(']\n",
+		"kind: BodyLimit\nname: bl\nresponse:\n  contentTypes: [json]\n",
+		"kind: BodyLimit\nname: bl\nresponse:\n  maxBytes: 10\n  scanContentTypes: ['*/json']\n",
+	} {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("case %d: spec should be invalid", i)
+		}
+	}
+}
+
+func TestRequestContentLength(t *testing.T) {
+	bl := newBodyLimit(t, "kind: BodyLimit\nname: bl\nrequest:\n  maxBytes: 10\n")
+
+	ctx, received := newContext(strings.NewReader("0123456789"), 10, "", "ok", "text/plain")
+	if result := bl.Handle(ctx); result != "" {
+		t.Errorf("result should be empty, but got %s", result)
+	}
+	if string(*received) != "0123456789" {
+		t.Errorf("received body should be intact, but got %q", *received)
+	}
+
+	ctx, received = newContext(strings.NewReader("0123456789a"), 11, "", "ok", "text/plain")
+	if result := bl.Handle(ctx); result != resultTooLarge {
+		t.Errorf("result should be %s, but got %s", resultTooLarge, result)
+	}
+	if ctx.Response().StatusCode() != http.StatusRequestEntityTooLarge {
+		t.Errorf("status code should be 413, but got %d", ctx.Response().StatusCode())
+	}
+	if len(*received) != 0 {
+		t.Errorf("body should not be read, but got %q", *received)
+	}
+}
+
+func TestRequestStreaming(t *testing.T) {
+	bl := newBodyLimit(t, `
+kind: BodyLimit
+name: bl
+scanWindow: 16
+request:
+  maxBytes: 1000
+  denyPatterns: ['(?i)<script']
+`)
+
+	// The length is unknown, e.g. chunked encoding.
+	ctx, _ := newContext(strings.NewReader(strings.Repeat("a", 1001)), -1, "", "ok", "text/plain")
+	bl.Handle(ctx)
+	if ctx.Response().StatusCode() != http.StatusRequestEntityTooLarge {
+		t.Errorf("status code should be 413, but got %d", ctx.Response().StatusCode())
+	}
+	if ctx.Response().Body() != nil {
+		t.Errorf("response body should be replaced")
+	}
+
+	// The match is split across one byte reads.
+	body := iotest.OneByteReader(strings.NewReader(strings.Repeat("a", 100) + "<SCRIPT>"))
+	ctx, received := newContext(body, -1, "", "ok", "text/plain")
+	bl.Handle(ctx)
+	if ctx.Response().StatusCode() != http.StatusForbidden {
+		t.Errorf("status code should be 403, but got %d", ctx.Response().StatusCode())
+	}
+	if strings.Contains(string(*received), "<SCRIPT") {
+		t.Errorf("the matched content should not be passed on, but got %q", *received)
+	}
+
+	status := bl.Status().(*Status)
+	if status.RequestsTooLarge != 1 || status.RequestsDenied != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestRequestContentTypes(t *testing.T) {
+	bl := newBodyLimit(t, `
+kind: BodyLimit
+name: bl
+request:
+  contentTypes: [application/json, text/*]
+`)
+
+	for _, c := range []struct {
+		contentType string
+		allowed     bool
+	}{
+		{"application/json; charset=utf-8", true},
+		{"text/plain", true},
+		{"application/xml", false},
+		{"", false},
+	} {
+		ctx, _ := newContext(strings.NewReader("{}"), 2, c.contentType, "ok", "text/plain")
+		result := bl.Handle(ctx)
+		if c.allowed && result != "" {
+			t.Errorf("%q should be allowed, but got %s", c.contentType, result)
+		}
+		if !c.allowed && (result != resultUnsupportedMediaType || ctx.Response().StatusCode() != http.StatusUnsupportedMediaType) {
+			t.Errorf("%q should be rejected, but got %s", c.contentType, result)
+		}
+	}
+
+	// Requests without bodies are not checked.
+	ctx, _ := newContext(strings.NewReader(""), 0, "", "ok", "text/plain")
+	if result := bl.Handle(ctx); result != "" {
+		t.Errorf("result should be empty, but got %s", result)
+	}
+}
+
+func TestResponse(t *testing.T) {
+	bl := newBodyLimit(t, `
+kind: BodyLimit
+name: bl
+response:
+  maxBytes: 10
+  contentTypes: [text/*, image/png]
+  denyPatterns: ['secret']
+  scanContentTypes: [text/*]
+`)
+
+	ctx, _ := newContext(strings.NewReader(""), 0, "", "0123456789abc", "text/plain")
+	bl.Handle(ctx)
+	body, err := ioutil.ReadAll(ctx.Response().Body())
+	if err != errTooLarge || string(body) != "0123456789" {
+		t.Errorf("response should be truncated to 10 bytes, but got %q, %v", body, err)
+	}
+
+	ctx, _ = newContext(strings.NewReader(""), 0, "", "a secret", "text/plain")
+	bl.Handle(ctx)
+	body, err = ioutil.ReadAll(ctx.Response().Body())
+	if err != errDenied || strings.Contains(string(body), "secret") {
+		t.Errorf("response should be denied, but got %q, %v", body, err)
+	}
+
+	// Images are not scanned.
+	ctx, _ = newContext(strings.NewReader(""), 0, "", "a secret", "image/png")
+	bl.Handle(ctx)
+	body, err = ioutil.ReadAll(ctx.Response().Body())
+	if err != nil || string(body) != "a secret" {
+		t.Errorf("response should be intact, but got %q, %v", body, err)
+	}
+
+	ctx, _ = newContext(strings.NewReader(""), 0, "", "{}", "application/json")
+	bl.Handle(ctx)
+	if ctx.Response().StatusCode() != http.StatusBadGateway {
+		t.Errorf("status code should be 502, but got %d", ctx.Response().StatusCode())
+	}
+
+	status := bl.Status().(*Status)
+	if status.ResponsesTooLarge != 1 || status.ResponsesDenied != 1 || status.ResponsesUnsupportedMedia != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestResponseContentLength(t *testing.T) {
+	bl := newBodyLimit(t, "kind: BodyLimit\nname: bl\nresponse:\n  maxBytes: 10\n")
+
+	ctx, _ := newContext(strings.NewReader(""), 0, "", "0123456789abc", "text/plain")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		ctx.Response().Header().Set("Content-Length", "13")
+		ctx.Response().SetBody(strings.NewReader("0123456789abc"))
+		return lastResult
+	})
+	bl.Handle(ctx)
+	if ctx.Response().StatusCode() != http.StatusBadGateway || ctx.Response().Body() != nil {
+		t.Errorf("response should be replaced by 502, but got %d", ctx.Response().StatusCode())
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package bodylimit
+
+import (
+	"errors"
+	"io"
+	"regexp"
+)
+
+var (
+	errTooLarge = errors.New("body too large")
+	errDenied   = errors.New("body denied")
+)
+
+// guardedBody limits the size of a streaming body and scans it against
+// the deny patterns as it's read, without buffering the whole body.
+//
+// The patterns are matched against a sliding window of the body, the
+// window keeps the last scanWindow bytes of the previous reads, so any
+// match no longer than scanWindow bytes is found even if it's split
+// across reads.
+type guardedBody struct {
+	body     io.Reader
+	maxBytes int64
+	patterns []*regexp.Regexp
+	window   int
+
+	read int64
+	tail []byte
+	// err is sticky, once the body is rejected, the following reads
+	// fail with the same error.
+	err     error
+	pattern string
+}
+
+func newGuardedBody(body io.Reader, maxBytes int64, patterns []*regexp.Regexp, window int) *guardedBody {
+	return &guardedBody{
+		body:     body,
+		maxBytes: maxBytes,
+		patterns: patterns,
+		window:   window,
+	}
+}
+
+func (gb *guardedBody) Read(p []byte) (int, error) {
+	if gb.err != nil {
+		return 0, gb.err
+	}
+
+	n, err := gb.body.Read(p)
+	if n <= 0 {
+		return n, err
+	}
+
+	if len(gb.patterns) > 0 && gb.scan(p[:n]) {
+		// NOTE: None of the matched read is passed on.
+		gb.err = errDenied
+		return 0, gb.err
+	}
+
+	gb.read += int64(n)
+	if gb.maxBytes > 0 && gb.read > gb.maxBytes {
+		// NOTE: The bytes within the limit are passed on, so a truncated
+		// response is exactly maxBytes long.
+		gb.err = errTooLarge
+		return n - int(gb.read-gb.maxBytes), gb.err
+	}
+
+	return n, err
+}
+
+// scan reports whether any pattern matches the window ending with p.
+func (gb *guardedBody) scan(p []byte) bool {
+	buff := append(gb.tail, p...)
+	for _, re := range gb.patterns {
+		if re.Match(buff) {
+			gb.pattern = re.String()
+			return true
+		}
+	}
+
+	if len(buff) > gb.window {
+		buff = buff[len(buff)-gb.window:]
+	}
+	gb.tail = append(gb.tail[:0], buff...)
+	return false
+}
+
+func (gb *guardedBody) Close() error {
+	if closer, ok := gb.body.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
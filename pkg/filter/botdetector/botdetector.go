@@ -0,0 +1,537 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package botdetector
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/ratelimiter"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+	"github.com/megaease/easegress/pkg/util/tlsfingerprint"
+)
+
+const (
+	// Kind is the kind of BotDetector.
+	Kind = "BotDetector"
+
+	resultBlocked    = "blocked"
+	resultChallenged = "challenged"
+	resultThrottled  = "throttled"
+
+	actionBlock     = "block"
+	actionChallenge = "challenge"
+	actionTag       = "tag"
+	actionThrottle  = "throttle"
+
+	classHuman   = "human"
+	classGoodBot = "goodBot"
+	classBadBot  = "badBot"
+	classBot     = "bot"
+
+	defaultThreshold        = 50
+	defaultHeader           = "X-EG-Bot"
+	defaultChallengeCookie  = "eg_bot_challenge"
+	defaultChallengeTTL     = time.Hour
+	maxLimiters             = 10000
+	challengeSignatureBytes = 16
+)
+
+var results = []string{resultBlocked, resultChallenged, resultThrottled}
+
+func init() {
+	httppipeline.Register(&BotDetector{})
+}
+
+type (
+	// BotDetector classifies requests into humans and bots by the user
+	// agents, the TLS fingerprints, the headers and the allow and deny
+	// lists, and blocks, challenges, tags or throttles the bots.
+	BotDetector struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		allow     []*rule
+		deny      []*rule
+		scores    map[string]int
+		threshold int
+		header    string
+		ja3Header string
+
+		secret       []byte
+		challengeTTL time.Duration
+		limiters     *lru.Cache
+
+		humans, goodBots, badBots, bots uint64
+		blocked, challenged, throttled  uint64
+	}
+
+	// Spec describes the BotDetector.
+	Spec struct {
+		// Allow are the good bots, like the crawlers of search engines
+		// from their IPs, which are never blocked.
+		Allow []*Rule `yaml:"allow" jsonschema:"omitempty"`
+		// Deny are the bad bots, which are always blocked.
+		Deny []*Rule `yaml:"deny" jsonschema:"omitempty"`
+		// Scores overrides the scores of the heuristic signals, zero
+		// disables a signal.
+		Scores map[string]int `yaml:"scores" jsonschema:"omitempty"`
+		// Threshold is the score of bots, 50 by default.
+		Threshold int `yaml:"threshold" jsonschema:"omitempty,minimum=1"`
+		// Action is the action on the bots detected by heuristics.
+		Action string `yaml:"action" jsonschema:"required,enum=block,enum=challenge,enum=tag,enum=throttle"`
+		// Status is the status code of the blocked requests, 403 by
+		// default.
+		Status int `yaml:"status" jsonschema:"omitempty,format=httpcode"`
+		// Header is the request header of the classification, it's set
+		// on the requests passed to the next filters.
+		Header string `yaml:"header" jsonschema:"omitempty"`
+		// JA3Header is the header of the JA3 hashes set by HTTPServer.
+		JA3Header string `yaml:"ja3Header" jsonschema:"omitempty"`
+
+		Challenge *Challenge `yaml:"challenge,omitempty" jsonschema:"omitempty"`
+		Throttle  *Throttle  `yaml:"throttle,omitempty" jsonschema:"omitempty"`
+	}
+
+	// Rule matches the requests meeting all of its non-empty fields.
+	Rule struct {
+		Name string `yaml:"name" jsonschema:"required"`
+		// UserAgents are the regular expressions of user agents, which
+		// are case insensitive.
+		UserAgents []string `yaml:"userAgents" jsonschema:"omitempty"`
+		// JA3 are the JA3 hashes of the TLS clients.
+		JA3 []string `yaml:"ja3" jsonschema:"omitempty,uniqueItems=true"`
+		// IPs are the IPs or CIDRs of the clients.
+		IPs []string `yaml:"ips" jsonschema:"omitempty,uniqueItems=true,format=ipcidr-array"`
+	}
+
+	// Challenge describes the JavaScript challenge, the clients running
+	// it get a cookie, which proves they are browsers until it expires.
+	Challenge struct {
+		// Secret signs the cookies, a random one is used if it's empty,
+		// which is different between instances and restarts.
+		Secret string `yaml:"secret" jsonschema:"omitempty"`
+		// CookieName is the name of the cookie, eg_bot_challenge by
+		// default.
+		CookieName string `yaml:"cookieName" jsonschema:"omitempty"`
+		// TTL is the lifetime of the cookie, 1h by default.
+		TTL string `yaml:"ttl" jsonschema:"omitempty,format=duration"`
+	}
+
+	// Throttle limits the requests of every bot IP.
+	Throttle struct {
+		LimitRefreshPeriod string `yaml:"limitRefreshPeriod" jsonschema:"required,format=duration"`
+		LimitForPeriod     int    `yaml:"limitForPeriod" jsonschema:"required,minimum=1"`
+	}
+
+	// Status is the status of BotDetector.
+	Status struct {
+		Humans     uint64 `yaml:"humans"`
+		GoodBots   uint64 `yaml:"goodBots"`
+		BadBots    uint64 `yaml:"badBots"`
+		Bots       uint64 `yaml:"bots"`
+		Blocked    uint64 `yaml:"blocked"`
+		Challenged uint64 `yaml:"challenged"`
+		Throttled  uint64 `yaml:"throttled"`
+	}
+
+	rule struct {
+		name       string
+		userAgents []*regexp.Regexp
+		ja3        map[string]bool
+		ips        []*net.IPNet
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	for signal := range spec.Scores {
+		if _, ok := defaultScores[signal]; !ok {
+			return fmt.Errorf("unknown signal %s", signal)
+		}
+	}
+	if spec.Action == actionThrottle && spec.Throttle == nil {
+		return fmt.Errorf("throttle is required by action throttle")
+	}
+	return nil
+}
+
+// Validate validates Rule.
+func (r Rule) Validate() error {
+	if len(r.UserAgents) == 0 && len(r.JA3) == 0 && len(r.IPs) == 0 {
+		return fmt.Errorf("rule %s: userAgents, ja3 and ips are all empty", r.Name)
+	}
+	_, err := newRule(&r)
+	return err
+}
+
+// Validate validates Throttle.
+func (t Throttle) Validate() error {
+	if d, _ := time.ParseDuration(t.LimitRefreshPeriod); d <= 0 {
+		return fmt.Errorf("limitRefreshPeriod must be positive")
+	}
+	return nil
+}
+
+func newRule(r *Rule) (*rule, error) {
+	nr := &rule{name: r.Name, ja3: map[string]bool{}}
+	for _, ua := range r.UserAgents {
+		re, err := regexp.Compile("(?i)" + ua)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: invalid user agent %s: %v", r.Name, ua, err)
+		}
+		nr.userAgents = append(nr.userAgents, re)
+	}
+	for _, hash := range r.JA3 {
+		nr.ja3[strings.ToLower(hash)] = true
+	}
+	for _, ip := range r.IPs {
+		if !strings.Contains(ip, "/") {
+			if strings.Contains(ip, ":") {
+				ip += "/128"
+			} else {
+				ip += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(ip)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: invalid ip %s: %v", r.Name, ip, err)
+		}
+		nr.ips = append(nr.ips, ipNet)
+	}
+	return nr, nil
+}
+
+func (r *rule) match(userAgent, ja3 string, ip net.IP) bool {
+	if len(r.userAgents) > 0 {
+		matched := false
+		for _, re := range r.userAgents {
+			if re.MatchString(userAgent) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(r.ja3) > 0 && !r.ja3[strings.ToLower(ja3)] {
+		return false
+	}
+
+	if len(r.ips) > 0 {
+		if ip == nil {
+			return false
+		}
+		matched := false
+		for _, ipNet := range r.ips {
+			if ipNet.Contains(ip) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Kind returns the kind of BotDetector.
+func (bd *BotDetector) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of BotDetector.
+func (bd *BotDetector) DefaultSpec() interface{} {
+	return &Spec{
+		Threshold: defaultThreshold,
+		Status:    http.StatusForbidden,
+	}
+}
+
+// Description returns the description of BotDetector.
+func (bd *BotDetector) Description() string {
+	return "BotDetector classifies requests into humans and bots, and blocks, challenges, tags or throttles bots."
+}
+
+// Results returns the results of BotDetector.
+func (bd *BotDetector) Results() []string {
+	return results
+}
+
+// Init initializes BotDetector.
+func (bd *BotDetector) Init(filterSpec *httppipeline.FilterSpec) {
+	bd.filterSpec, bd.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	bd.reload(nil)
+}
+
+// Inherit inherits previous generation of BotDetector.
+func (bd *BotDetector) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	bd.filterSpec, bd.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	bd.reload(previousGeneration.(*BotDetector))
+}
+
+func (bd *BotDetector) reload(prev *BotDetector) {
+	bd.allow, bd.deny = nil, nil
+	for _, r := range bd.spec.Allow {
+		if nr, err := newRule(r); err == nil {
+			bd.allow = append(bd.allow, nr)
+		}
+	}
+	for _, r := range bd.spec.Deny {
+		if nr, err := newRule(r); err == nil {
+			bd.deny = append(bd.deny, nr)
+		}
+	}
+
+	bd.scores = map[string]int{}
+	for signal, score := range defaultScores {
+		bd.scores[signal] = score
+	}
+	for signal, score := range bd.spec.Scores {
+		bd.scores[signal] = score
+	}
+
+	bd.threshold = bd.spec.Threshold
+	bd.header = bd.spec.Header
+	if bd.header == "" {
+		bd.header = defaultHeader
+	}
+	bd.ja3Header = bd.spec.JA3Header
+	if bd.ja3Header == "" {
+		bd.ja3Header = tlsfingerprint.DefaultHeader
+	}
+
+	bd.challengeTTL = defaultChallengeTTL
+	if c := bd.spec.Challenge; c != nil && c.TTL != "" {
+		if d, err := time.ParseDuration(c.TTL); err == nil && d > 0 {
+			bd.challengeTTL = d
+		}
+	}
+	switch {
+	case bd.spec.Challenge != nil && bd.spec.Challenge.Secret != "":
+		bd.secret = []byte(bd.spec.Challenge.Secret)
+	case prev != nil && prev.secret != nil && (prev.spec.Challenge == nil || prev.spec.Challenge.Secret == ""):
+		// NOTE: Keep the random secret, so the cookies stay valid.
+		bd.secret = prev.secret
+	default:
+		bd.secret = make([]byte, 32)
+		if _, err := rand.Read(bd.secret); err != nil {
+			logger.Errorf("BUG: generate challenge secret failed: %v", err)
+		}
+	}
+
+	bd.limiters, _ = lru.New(maxLimiters)
+}
+
+// Handle classifies the request of HTTPContext.
+func (bd *BotDetector) Handle(ctx context.HTTPContext) string {
+	result := bd.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (bd *BotDetector) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	userAgent := r.Header().Get("User-Agent")
+	ja3 := r.Header().Get(bd.ja3Header)
+	realIP := r.RealIP()
+	ip := net.ParseIP(realIP)
+
+	for _, rule := range bd.deny {
+		if rule.match(userAgent, ja3, ip) {
+			atomic.AddUint64(&bd.badBots, 1)
+			ctx.AddTag(stringtool.Cat("botDetector: denied by rule ", rule.name))
+			return bd.block(ctx)
+		}
+	}
+
+	for _, rule := range bd.allow {
+		if rule.match(userAgent, ja3, ip) {
+			atomic.AddUint64(&bd.goodBots, 1)
+			r.Header().Set(bd.header, stringtool.Cat(classGoodBot, ";rule=", rule.name))
+			return ""
+		}
+	}
+
+	if bd.spec.Action == actionChallenge && bd.verifyChallenge(ctx, realIP, userAgent) {
+		atomic.AddUint64(&bd.humans, 1)
+		r.Header().Set(bd.header, stringtool.Cat(classHuman, ";challenge=passed"))
+		return ""
+	}
+
+	signals := bd.signals(&request{
+		userAgent: userAgent,
+		header:    r.Header().Std(),
+		proto:     r.Proto(),
+		https:     r.Std().TLS != nil,
+		ja3:       ja3,
+	})
+	score := bd.score(signals)
+	class := classHuman
+	if score >= bd.threshold {
+		class = classBot
+	}
+
+	value := stringtool.Cat(class, ";score=", strconv.Itoa(score))
+	if len(signals) > 0 {
+		value = stringtool.Cat(value, ";signals=", strings.Join(signals, ","))
+	}
+
+	if class == classHuman {
+		atomic.AddUint64(&bd.humans, 1)
+		r.Header().Set(bd.header, value)
+		return ""
+	}
+
+	atomic.AddUint64(&bd.bots, 1)
+	ctx.AddTag(stringtool.Cat("botDetector: ", value))
+
+	switch bd.spec.Action {
+	case actionBlock:
+		return bd.block(ctx)
+	case actionChallenge:
+		return bd.challenge(ctx, realIP, userAgent)
+	case actionThrottle:
+		if !bd.acquire(realIP) {
+			atomic.AddUint64(&bd.throttled, 1)
+			ctx.Response().SetStatusCode(http.StatusTooManyRequests)
+			return resultThrottled
+		}
+	}
+
+	r.Header().Set(bd.header, value)
+	return ""
+}
+
+func (bd *BotDetector) block(ctx context.HTTPContext) string {
+	atomic.AddUint64(&bd.blocked, 1)
+	ctx.Response().SetStatusCode(bd.spec.Status)
+	return resultBlocked
+}
+
+func (bd *BotDetector) acquire(ip string) bool {
+	v, ok := bd.limiters.Get(ip)
+	l, _ := v.(*ratelimiter.RateLimiter)
+	if !ok {
+		// NOTE: The period has been validated.
+		period, _ := time.ParseDuration(bd.spec.Throttle.LimitRefreshPeriod)
+		l = ratelimiter.New(&ratelimiter.Policy{
+			LimitRefreshPeriod: period,
+			LimitForPeriod:     bd.spec.Throttle.LimitForPeriod,
+		})
+		bd.limiters.Add(ip, l)
+	}
+
+	permitted, _ := l.AcquirePermission()
+	return permitted
+}
+
+func (bd *BotDetector) cookieName() string {
+	if c := bd.spec.Challenge; c != nil && c.CookieName != "" {
+		return c.CookieName
+	}
+	return defaultChallengeCookie
+}
+
+// challengeToken returns the token of the client until expires, which is
+// the expiry and the signature of the client and the expiry.
+func (bd *BotDetector) challengeToken(ip, userAgent string, expires int64) string {
+	exp := strconv.FormatInt(expires, 10)
+	mac := hmac.New(sha256.New, bd.secret)
+	mac.Write([]byte(stringtool.Cat(ip, "\n", userAgent, "\n", exp)))
+	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:challengeSignatureBytes])
+	return stringtool.Cat(exp, ".", sig)
+}
+
+func (bd *BotDetector) verifyChallenge(ctx context.HTTPContext, ip, userAgent string) bool {
+	cookie, err := ctx.Request().Cookie(bd.cookieName())
+	if err != nil {
+		return false
+	}
+
+	i := strings.IndexByte(cookie.Value, '.')
+	if i < 0 {
+		return false
+	}
+	expires, err := strconv.ParseInt(cookie.Value[:i], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	expected := bd.challengeToken(ip, userAgent, expires)
+	return hmac.Equal([]byte(cookie.Value), []byte(expected))
+}
+
+var challengePage = template.Must(template.New("challenge").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Checking your browser</title></head>
+<body><noscript>Please enable JavaScript to continue.</noscript>
+<script>document.cookie = {{.Cookie}}; location.reload();</script>
+</body></html>
+`))
+
+func (bd *BotDetector) challenge(ctx context.HTTPContext, ip, userAgent string) string {
+	atomic.AddUint64(&bd.challenged, 1)
+
+	expires := time.Now().Add(bd.challengeTTL).Unix()
+	cookie := stringtool.Cat(bd.cookieName(), "=", bd.challengeToken(ip, userAgent, expires),
+		"; Path=/; Max-Age=", strconv.Itoa(int(bd.challengeTTL/time.Second)), "; SameSite=Lax")
+
+	w := ctx.Response()
+	w.SetStatusCode(http.StatusForbidden)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	var sb strings.Builder
+	challengePage.Execute(&sb, struct{ Cookie string }{cookie})
+	w.SetBody(strings.NewReader(sb.String()))
+	return resultChallenged
+}
+
+// Status returns status.
+func (bd *BotDetector) Status() interface{} {
+	return &Status{
+		Humans:     atomic.LoadUint64(&bd.humans),
+		GoodBots:   atomic.LoadUint64(&bd.goodBots),
+		BadBots:    atomic.LoadUint64(&bd.badBots),
+		Bots:       atomic.LoadUint64(&bd.bots),
+		Blocked:    atomic.LoadUint64(&bd.blocked),
+		Challenged: atomic.LoadUint64(&bd.challenged),
+		Throttled:  atomic.LoadUint64(&bd.throttled),
+	}
+}
+
+// Close closes BotDetector.
+func (bd *BotDetector) Close() {}
@@ -0,0 +1,277 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package botdetector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+const (
+	chrome = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/96.0.4664.110 Safari/537.36"
+	google = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newBotDetector(t *testing.T, yamlSpec string) *BotDetector {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bd := &BotDetector{}
+	bd.Init(spec)
+	return bd
+}
+
+func newContext(remoteAddr string, header map[string]string) (context.HTTPContext, *httptest.ResponseRecorder) {
+	stdr, _ := http.NewRequest(http.MethodGet, "http://example.com/api", nil)
+	stdr.RemoteAddr = remoteAddr
+	for k, v := range header {
+		stdr.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	ctx := context.New(w, stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		return lastResult
+	})
+	return ctx, w
+}
+
+func browserHeader() map[string]string {
+	return map[string]string{
+		"User-Agent":      chrome,
+		"Accept":          "text/html",
+		"Accept-Language": "en-US",
+		"Accept-Encoding": "gzip",
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for i, yamlSpec := range []string{
+		"kind: BotDetector\nname: bd\n",
+		"kind: BotDetector\nname: bd\naction: drop\n",
+		"kind: BotDetector\nname: bd\naction: throttle\n",
+		"kind: BotDetector\nname: bd\naction: block\nscores:\n  unknown: 10\n",
+		"kind: BotDetector\nname: bd\naction: block\ndeny:\n- name: empty\n",
+		"kind: BotDetector\nname: bd\naction: block\ndeny:\n- name: bad\n  userAgents: ['(']\n",
+		"kind: BotDetector\nname: bd\naction: block\nallow:\n- name: bad\n  ips: [300.0.0.1]\n",
+	} {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("case %d: spec should be invalid", i)
+		}
+	}
+}
+
+func TestBlock(t *testing.T) {
+	bd := newBotDetector(t, `
+kind: BotDetector
+name: bd
+action: block
+allow:
+- name: googlebot
+  userAgents: [googlebot]
+  ips: [66.249.64.0/19]
+deny:
+- name: scanner
+  ja3: [E7D705A3286E19EA42F587B344EE6865]
+`)
+
+	ctx, _ := newContext("10.0.0.1:1234", browserHeader())
+	if result := bd.Handle(ctx); result != "" {
+		t.Errorf("browser should pass, but got %s", result)
+	}
+	if v := ctx.Request().Header().Get(defaultHeader); v != "human;score=0" {
+		t.Errorf("unexpected classification %q", v)
+	}
+
+	ctx, _ = newContext("10.0.0.1:1234", map[string]string{"User-Agent": "curl/7.79.1"})
+	if result := bd.Handle(ctx); result != resultBlocked {
+		t.Errorf("curl should be blocked, but got %q", result)
+	}
+	if ctx.Response().StatusCode() != http.StatusForbidden {
+		t.Errorf("unexpected status code %d", ctx.Response().StatusCode())
+	}
+
+	ctx, _ = newContext("66.249.66.1:1234", map[string]string{"User-Agent": google})
+	if result := bd.Handle(ctx); result != "" {
+		t.Errorf("googlebot should pass, but got %s", result)
+	}
+	if v := ctx.Request().Header().Get(defaultHeader); v != "goodBot;rule=googlebot" {
+		t.Errorf("unexpected classification %q", v)
+	}
+
+	// The fake googlebot is not from the IPs of Google.
+	ctx, _ = newContext("10.0.0.2:1234", map[string]string{"User-Agent": google})
+	if result := bd.Handle(ctx); result != resultBlocked {
+		t.Errorf("fake googlebot should be blocked, but got %q", result)
+	}
+
+	header := browserHeader()
+	header["X-EG-JA3"] = "e7d705a3286e19ea42f587b344ee6865"
+	ctx, _ = newContext("10.0.0.1:1234", header)
+	if result := bd.Handle(ctx); result != resultBlocked {
+		t.Errorf("denied fingerprint should be blocked, but got %q", result)
+	}
+
+	status := bd.Status().(*Status)
+	if status.Humans != 1 || status.GoodBots != 1 || status.BadBots != 1 || status.Bots != 2 || status.Blocked != 3 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestTagAndScores(t *testing.T) {
+	bd := newBotDetector(t, `
+kind: BotDetector
+name: bd
+action: tag
+header: X-Bot
+threshold: 40
+scores:
+  missingAcceptLanguage: 40
+  http10: 0
+`)
+
+	header := browserHeader()
+	delete(header, "Accept-Language")
+	ctx, _ := newContext("10.0.0.1:1234", header)
+	ctx.Request().Std().Proto = "HTTP/1.0"
+	if result := bd.Handle(ctx); result != "" {
+		t.Errorf("bot should be tagged only, but got %s", result)
+	}
+	if v := ctx.Request().Header().Get("X-Bot"); v != "bot;score=40;signals=missingAcceptLanguage" {
+		t.Errorf("unexpected classification %q", v)
+	}
+
+	// The classification sent by the client is overwritten.
+	header = browserHeader()
+	header["X-Bot"] = "goodBot"
+	ctx, _ = newContext("10.0.0.1:1234", header)
+	bd.Handle(ctx)
+	if v := ctx.Request().Header().Get("X-Bot"); v != "human;score=0" {
+		t.Errorf("unexpected classification %q", v)
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	bd := newBotDetector(t, `
+kind: BotDetector
+name: bd
+action: challenge
+challenge:
+  secret: s3cret
+  ttl: 10m
+`)
+
+	header := map[string]string{"User-Agent": "python-requests/2.26.0"}
+	ctx, w := newContext("10.0.0.1:1234", header)
+	if result := bd.Handle(ctx); result != resultChallenged {
+		t.Fatalf("bot should be challenged, but got %q", result)
+	}
+	ctx.Finish()
+	body := w.Body.String()
+	if !strings.Contains(body, "document.cookie") || !strings.Contains(body, defaultChallengeCookie) {
+		t.Fatalf("unexpected challenge page %s", body)
+	}
+
+	cookie := bd.challengeToken("10.0.0.1", header["User-Agent"], time.Now().Add(bd.challengeTTL).Unix())
+
+	header["Cookie"] = defaultChallengeCookie + "=" + cookie
+	ctx, _ = newContext("10.0.0.1:1234", header)
+	if result := bd.Handle(ctx); result != "" {
+		t.Errorf("client passed the challenge should pass, but got %s", result)
+	}
+
+	// The cookie is bound to the IP of the client.
+	ctx, _ = newContext("10.0.0.2:1234", header)
+	if result := bd.Handle(ctx); result != resultChallenged {
+		t.Errorf("cookie of other clients should be rejected, but got %q", result)
+	}
+
+	header["Cookie"] = defaultChallengeCookie + "=1.forged"
+	ctx, _ = newContext("10.0.0.1:1234", header)
+	if result := bd.Handle(ctx); result != resultChallenged {
+		t.Errorf("forged cookie should be rejected, but got %q", result)
+	}
+}
+
+func TestInheritSecret(t *testing.T) {
+	yamlSpec := "kind: BotDetector\nname: bd\naction: challenge\n"
+	prev := newBotDetector(t, yamlSpec)
+
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+	spec, _ := httppipeline.NewFilterSpec(rawSpec, nil)
+	bd := &BotDetector{}
+	bd.Inherit(spec, prev)
+
+	if string(bd.secret) != string(prev.secret) {
+		t.Errorf("random secret should be inherited")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	bd := newBotDetector(t, `
+kind: BotDetector
+name: bd
+action: throttle
+throttle:
+  limitRefreshPeriod: 1h
+  limitForPeriod: 2
+`)
+
+	for i := 0; i < 3; i++ {
+		ctx, _ := newContext("10.0.0.1:1234", map[string]string{"User-Agent": "Wget/1.21"})
+		result := bd.Handle(ctx)
+		if i < 2 && result != "" {
+			t.Errorf("request %d should pass, but got %s", i, result)
+		}
+		if i == 2 && (result != resultThrottled || ctx.Response().StatusCode() != http.StatusTooManyRequests) {
+			t.Errorf("request %d should be throttled, but got %q", i, result)
+		}
+	}
+
+	// Other bots and humans are not affected.
+	ctx, _ := newContext("10.0.0.2:1234", map[string]string{"User-Agent": "Wget/1.21"})
+	if result := bd.Handle(ctx); result != "" {
+		t.Errorf("other bot should pass, but got %s", result)
+	}
+	ctx, _ = newContext("10.0.0.1:1234", browserHeader())
+	if result := bd.Handle(ctx); result != "" {
+		t.Errorf("human should pass, but got %s", result)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package botdetector
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// signals of the heuristics.
+const (
+	signalEmptyUserAgent        = "emptyUserAgent"
+	signalAutomationUserAgent   = "automationUserAgent"
+	signalCrawlerUserAgent      = "crawlerUserAgent"
+	signalMissingAccept         = "missingAccept"
+	signalMissingAcceptLanguage = "missingAcceptLanguage"
+	signalMissingAcceptEncoding = "missingAcceptEncoding"
+	signalMissingFetchMetadata  = "missingFetchMetadata"
+	signalConnectionClose       = "connectionClose"
+	signalHTTP10                = "http10"
+	signalNoTLSFingerprint      = "noTLSFingerprint"
+)
+
+// defaultScores are the scores of the signals, a request is a bot if the
+// sum of the scores of its signals reaches the threshold.
+var defaultScores = map[string]int{
+	signalEmptyUserAgent:        60,
+	signalAutomationUserAgent:   60,
+	signalCrawlerUserAgent:      40,
+	signalMissingAccept:         15,
+	signalMissingAcceptLanguage: 25,
+	signalMissingAcceptEncoding: 15,
+	signalMissingFetchMetadata:  15,
+	signalConnectionClose:       10,
+	signalHTTP10:                20,
+	signalNoTLSFingerprint:      0,
+}
+
+var (
+	automationRE = regexp.MustCompile(`(?i)\b(?:curl|wget|python|aiohttp|go-http-client|java|okhttp|apache-httpclient|libwww|lwp|scrapy|httpclient|axios|node-fetch|undici|guzzle|ruby|perl|headlesschrome|phantomjs|selenium|puppeteer|playwright|postmanruntime|insomnia|httpie)\b`)
+	crawlerRE    = regexp.MustCompile(`(?i)(?:bot|crawl|spider|slurp|fetcher|scanner|archiver)\b`)
+	browserRE    = regexp.MustCompile(`^Mozilla/5\.0 \(`)
+	// chromiumRE matches the browsers sending the fetch metadata since
+	// Chrome 76.
+	chromiumRE = regexp.MustCompile(`Chrome/(?:7[6-9]|[89]\d|\d{3,})\.`)
+)
+
+// request is the part of a request inspected by heuristics.
+type request struct {
+	userAgent string
+	header    http.Header
+	proto     string
+	https     bool
+	ja3       string
+}
+
+// signals returns the signals of the request, the ones with zero scores
+// are not returned.
+func (bd *BotDetector) signals(r *request) []string {
+	var signals []string
+	add := func(signal string) {
+		if bd.scores[signal] != 0 {
+			signals = append(signals, signal)
+		}
+	}
+
+	ua := r.userAgent
+	switch {
+	case strings.TrimSpace(ua) == "":
+		add(signalEmptyUserAgent)
+	case automationRE.MatchString(ua):
+		add(signalAutomationUserAgent)
+	case crawlerRE.MatchString(ua):
+		add(signalCrawlerUserAgent)
+	}
+
+	// NOTE: The order of headers is lost in the HTTP server, so the
+	// browsers are checked by the headers they always send instead.
+	if browserRE.MatchString(ua) {
+		if r.header.Get("Accept") == "" {
+			add(signalMissingAccept)
+		}
+		if r.header.Get("Accept-Language") == "" {
+			add(signalMissingAcceptLanguage)
+		}
+		if r.header.Get("Accept-Encoding") == "" {
+			add(signalMissingAcceptEncoding)
+		}
+		if r.https && chromiumRE.MatchString(ua) && r.header.Get("Sec-Fetch-Mode") == "" {
+			add(signalMissingFetchMetadata)
+		}
+		if strings.EqualFold(r.header.Get("Connection"), "close") {
+			add(signalConnectionClose)
+		}
+	}
+
+	if r.proto == "HTTP/1.0" {
+		add(signalHTTP10)
+	}
+	if r.https && r.ja3 == "" {
+		add(signalNoTLSFingerprint)
+	}
+
+	return signals
+}
+
+func (bd *BotDetector) score(signals []string) int {
+	score := 0
+	for _, s := range signals {
+		score += bd.scores[s]
+	}
+	return score
+}
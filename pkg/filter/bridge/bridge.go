@@ -18,12 +18,21 @@
 package bridge
 
 import (
+	"bytes"
+	stdcontext "context"
+	"fmt"
 	"net/http"
+	"net/url"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
 	"github.com/megaease/easegress/pkg/protocol"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+	"github.com/megaease/easegress/pkg/util/urlrule"
 )
 
 const (
@@ -33,28 +42,33 @@ const (
 	// Description is the Description of Bridge.
 	Description = `# Bridge Filter
 
-A Bridge Filter route requests to from one pipeline to other pipelines or http proxies under a http server.
+A Bridge Filter route requests to from one pipeline to other pipelines under a http server.
 
-1. The upstream filter set the target pipeline/proxy to the http header,  'X-Easegress-Bridge-Dest'.
-2. Bridge will extract the value from 'X-Easegress-Bridge-Dest' and try to match in the configuration.
-   It will send the request if a dest matched. abort the process if no match.
-3. Bridge will select the first dest from the filter configuration if there's no header named 'X-Easegress-Bridge-Dest'`
+1. The routes are matched in order, the destination of the first matched route is used,
+   which could also be rendered from a template of the request.
+2. If no route matches, Bridge will extract the value from 'X-Easegress-Bridge-Dest' and try
+   to match in the destinations. It will send the request if a dest matched, abort the process if no match.
+3. Bridge will select the first dest from the destinations if there's no header named 'X-Easegress-Bridge-Dest'.
+4. Bridge aborts the process if the destination is already in the bridged pipelines of the request,
+   or the bridged pipelines exceed the max depth.
+5. Bridge sends copies of the request to the mirrors asynchronously, their responses are discarded.`
 
 	resultDestinationNotFound     = "destinationNotFound"
 	resultInvokeDestinationFailed = "invokeDestinationFailed"
+	resultLoopDetected            = "loopDetected"
 
 	bridgeDestHeader = "X-Easegress-Bridge-Dest"
 )
 
-var results = []string{resultDestinationNotFound, resultInvokeDestinationFailed}
+var results = []string{resultDestinationNotFound, resultInvokeDestinationFailed, resultLoopDetected}
 
 func init() {
-	// FIXME: Bridge is a temporary product for some historical reason.
-	// I(@xxx7xxxx) think we should not empower filter to cross pipelines.
-
-	// httppipeline.Register(&Bridge{})
+	httppipeline.Register(&Bridge{})
 }
 
+// context.HTTPContext: []string, the pipelines bridged by the request.
+var bridgedPipelines = sync.Map{}
+
 type (
 	// Bridge is filter Bridge.
 	Bridge struct {
@@ -62,14 +76,105 @@ type (
 		spec       *Spec
 
 		muxMapper protocol.MuxMapper
+
+		mirrorTimeout time.Duration
+		mirrorTokens  chan struct{}
+		mirrorCtx     stdcontext.Context
+		mirrorCancel  stdcontext.CancelFunc
+		wg            sync.WaitGroup
+
+		mutex   sync.Mutex
+		bridged map[string]uint64
+		mirrors map[string]*MirrorStatus
+		loops   uint64
 	}
 
-	// Spec describes the Mock.
+	// Spec describes the Bridge.
 	Spec struct {
-		Destinations []string `yaml:"destinations" jsonschema:"required,pattern=^[^ \t]+$"`
+		// Destinations are selected by the header X-Easegress-Bridge-Dest
+		// if no route matches, the first one is the default.
+		Destinations []string `yaml:"destinations" jsonschema:"omitempty,uniqueItems=true"`
+		Routes       []*Route `yaml:"routes" jsonschema:"omitempty"`
+		// MaxDepth is the max number of bridges a request could pass.
+		MaxDepth int `yaml:"maxDepth" jsonschema:"required,minimum=1"`
+
+		// Mirrors are the destinations the requests are mirrored to.
+		Mirrors              []*Mirror `yaml:"mirrors" jsonschema:"omitempty"`
+		MirrorTimeout        string    `yaml:"mirrorTimeout" jsonschema:"required,format=duration"`
+		MirrorMaxBodySize    int64     `yaml:"mirrorMaxBodySize" jsonschema:"required,minimum=0"`
+		MaxConcurrentMirrors int       `yaml:"maxConcurrentMirrors" jsonschema:"required,minimum=1"`
+	}
+
+	// Route selects the destination by the method, path and headers of
+	// the request, all specified conditions must be satisfied.
+	Route struct {
+		Methods []string             `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		Path    *urlrule.StringMatch `yaml:"path" jsonschema:"omitempty"`
+		// Headers match if any value of every header matches.
+		Headers map[string]*urlrule.StringMatch `yaml:"headers" jsonschema:"omitempty"`
+		// Destination is the pipeline name, or a Go template of the
+		// request if DestinationTemplate is true,
+		// e.g. pipeline-{{index .Header "X-Tenant"}}.
+		Destination         string `yaml:"destination" jsonschema:"required"`
+		DestinationTemplate bool   `yaml:"destinationTemplate" jsonschema:"omitempty"`
+
+		template *template.Template
+	}
+
+	// Status is the status of Bridge.
+	Status struct {
+		// Bridged are the number of requests bridged to every destination.
+		Bridged map[string]uint64        `yaml:"bridged"`
+		Mirrors map[string]*MirrorStatus `yaml:"mirrors,omitempty"`
+		Loops   uint64                   `yaml:"loops"`
+	}
+
+	// templateData is the data of the destination template.
+	templateData struct {
+		Method string
+		Host   string
+		Path   string
+		// Header and Query hold the first values of the keys.
+		Header map[string]string
+		Query  map[string]string
 	}
 )
 
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if len(spec.Destinations) == 0 && len(spec.Routes) == 0 {
+		return fmt.Errorf("none of destinations and routes is specified")
+	}
+
+	for i, r := range spec.Routes {
+		if r.Path != nil {
+			if err := r.Path.Validate(); err != nil {
+				return fmt.Errorf("route %d: path: %v", i, err)
+			}
+		}
+		for key, sm := range r.Headers {
+			if err := sm.Validate(); err != nil {
+				return fmt.Errorf("route %d: header %s: %v", i, key, err)
+			}
+		}
+		if r.DestinationTemplate {
+			if _, err := template.New("destination").Parse(r.Destination); err != nil {
+				return fmt.Errorf("route %d: invalid destination template: %v", i, err)
+			}
+		}
+	}
+
+	mirrors := map[string]bool{}
+	for _, m := range spec.Mirrors {
+		if mirrors[m.Destination] {
+			return fmt.Errorf("duplicated mirror destination %s", m.Destination)
+		}
+		mirrors[m.Destination] = true
+	}
+
+	return nil
+}
+
 // Kind returns the kind of Bridge.
 func (b *Bridge) Kind() string {
 	return Kind
@@ -77,7 +182,12 @@ func (b *Bridge) Kind() string {
 
 // DefaultSpec returns the default spec of Bridge.
 func (b *Bridge) DefaultSpec() interface{} {
-	return &Spec{}
+	return &Spec{
+		MaxDepth:             8,
+		MirrorTimeout:        "10s",
+		MirrorMaxBodySize:    1024 * 1024,
+		MaxConcurrentMirrors: 64,
+	}
 }
 
 // Description returns the description of Bridge.
@@ -98,21 +208,26 @@ func (b *Bridge) Init(filterSpec *httppipeline.FilterSpec) {
 
 // Inherit inherits previous generation of Bridge.
 func (b *Bridge) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
-
 	previousGeneration.Close()
 	b.Init(filterSpec)
 }
 
 func (b *Bridge) reload() {
-	if len(b.spec.Destinations) <= 0 {
-		logger.Errorf("not any destination defined")
+	b.bridged = map[string]uint64{}
+
+	for _, r := range b.spec.Routes {
+		if r.Path != nil {
+			r.Path.Init()
+		}
+		for _, sm := range r.Headers {
+			sm.Init()
+		}
+		if r.DestinationTemplate {
+			r.template, _ = template.New("destination").Parse(r.Destination)
+		}
 	}
-}
 
-// Handle builds a bridge for pipeline.
-func (b *Bridge) Handle(ctx context.HTTPContext) (result string) {
-	result = b.handle(ctx)
-	return ctx.CallNextHandler(result)
+	b.reloadMirrors()
 }
 
 // InjectMuxMapper injects mux mapper into Bridge.
@@ -120,51 +235,180 @@ func (b *Bridge) InjectMuxMapper(mapper protocol.MuxMapper) {
 	b.muxMapper = mapper
 }
 
+// Handle builds a bridge for pipeline.
+func (b *Bridge) Handle(ctx context.HTTPContext) (result string) {
+	result = b.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
 func (b *Bridge) handle(ctx context.HTTPContext) (result string) {
-	if len(b.spec.Destinations) <= 0 {
-		panic("not any destination defined")
+	dest, err := b.destination(ctx)
+	if err != nil {
+		logger.Errorf("%s/%s: %v", b.filterSpec.Pipeline(), b.filterSpec.Name(), err)
+		ctx.AddTag(stringtool.Cat("bridge: ", err.Error()))
+		ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
+		return resultDestinationNotFound
 	}
 
-	r := ctx.Request()
-	dest := r.Header().Get(bridgeDestHeader)
-	found := false
-	if dest == "" {
-		logger.Warnf("destination not defined, will choose the first dest: %s", b.spec.Destinations[0])
-		dest = b.spec.Destinations[0]
-		found = true
+	key := httppipeline.UnwrapContext(ctx)
+	var pipelines []string
+	if value, ok := bridgedPipelines.Load(key); ok {
+		pipelines = value.([]string)
 	} else {
-		for _, d := range b.spec.Destinations {
-			if d == dest {
-				r.Header().Del(bridgeDestHeader)
-				found = true
-				break
-			}
-		}
+		pipelines = []string{b.filterSpec.Pipeline()}
 	}
 
-	if !found {
-		logger.Errorf("dest not found: %s", dest)
-		ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
-		return resultDestinationNotFound
+	if stringtool.StrInSlice(dest, pipelines) || len(pipelines) > b.spec.MaxDepth {
+		b.mutex.Lock()
+		b.loops++
+		b.mutex.Unlock()
+
+		logger.Errorf("%s/%s: loop detected bridging to %s, bridged pipelines: %v",
+			b.filterSpec.Pipeline(), b.filterSpec.Name(), dest, pipelines)
+		ctx.AddTag(stringtool.Cat("bridge: loop detected bridging to ", dest))
+		ctx.Response().SetStatusCode(http.StatusLoopDetected)
+		return resultLoopDetected
 	}
 
+	if b.muxMapper == nil {
+		ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
+		return resultInvokeDestinationFailed
+	}
 	handler, exists := b.muxMapper.GetHandler(dest)
-
 	if !exists {
-		logger.Errorf("failed to get running object %s", b.spec.Destinations[0])
+		logger.Errorf("%s/%s: destination %s not found", b.filterSpec.Pipeline(), b.filterSpec.Name(), dest)
 		ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
 		return resultDestinationNotFound
 	}
 
+	b.mutex.Lock()
+	b.bridged[dest]++
+	b.mutex.Unlock()
+
+	// NOTE: Copy to not modify the pipelines of the outer bridges.
+	next := make([]string, len(pipelines), len(pipelines)+1)
+	copy(next, pipelines)
+	bridgedPipelines.Store(key, append(next, dest))
+	defer func() {
+		if len(pipelines) == 1 {
+			bridgedPipelines.Delete(key)
+		} else {
+			bridgedPipelines.Store(key, pipelines)
+		}
+	}()
+
+	b.mirror(ctx, pipelines)
 	handler.Handle(ctx)
 
 	return ""
 }
 
+// destination selects the destination by the routes, and then by the
+// header X-Easegress-Bridge-Dest.
+func (b *Bridge) destination(ctx context.HTTPContext) (string, error) {
+	r := ctx.Request()
+
+	for _, route := range b.spec.Routes {
+		if !route.match(r) {
+			continue
+		}
+		if route.template == nil {
+			return route.Destination, nil
+		}
+
+		buff := &bytes.Buffer{}
+		if err := route.template.Execute(buff, newTemplateData(r)); err != nil {
+			return "", fmt.Errorf("render destination failed: %v", err)
+		}
+		return buff.String(), nil
+	}
+
+	if len(b.spec.Destinations) == 0 {
+		return "", fmt.Errorf("no route matched")
+	}
+
+	dest := r.Header().Get(bridgeDestHeader)
+	if dest == "" {
+		logger.Debugf("destination not defined, will choose the first dest: %s", b.spec.Destinations[0])
+		return b.spec.Destinations[0], nil
+	}
+
+	if !stringtool.StrInSlice(dest, b.spec.Destinations) {
+		return "", fmt.Errorf("dest not found: %s", dest)
+	}
+	r.Header().Del(bridgeDestHeader)
+	return dest, nil
+}
+
+func (r *Route) match(req context.HTTPRequest) bool {
+	if len(r.Methods) != 0 && !stringtool.StrInSlice(req.Method(), r.Methods) {
+		return false
+	}
+	if r.Path != nil && !r.Path.Match(req.Path()) {
+		return false
+	}
+
+	for key, sm := range r.Headers {
+		matched := false
+		for _, value := range req.Header().GetAll(key) {
+			if sm.Match(value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newTemplateData(r context.HTTPRequest) *templateData {
+	data := &templateData{
+		Method: r.Method(),
+		Host:   r.Host(),
+		Path:   r.Path(),
+		Header: map[string]string{},
+		Query:  map[string]string{},
+	}
+
+	for key, values := range r.Header().Std() {
+		if len(values) > 0 {
+			data.Header[key] = values[0]
+		}
+	}
+	query, _ := url.ParseQuery(r.Query())
+	for key, values := range query {
+		if len(values) > 0 {
+			data.Query[key] = values[0]
+		}
+	}
+
+	return data
+}
+
 // Status returns status.
 func (b *Bridge) Status() interface{} {
-	return nil
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	s := &Status{Bridged: make(map[string]uint64, len(b.bridged)), Loops: b.loops}
+	for dest, n := range b.bridged {
+		s.Bridged[dest] = n
+	}
+	if len(b.mirrors) != 0 {
+		s.Mirrors = make(map[string]*MirrorStatus, len(b.mirrors))
+		for dest, ms := range b.mirrors {
+			copied := *ms
+			s.Mirrors[dest] = &copied
+		}
+	}
+	return s
 }
 
-// Close closes Bridge.
-func (b *Bridge) Close() {}
+// Close closes Bridge, the requests being mirrored are cancelled.
+func (b *Bridge) Close() {
+	b.mirrorCancel()
+	b.wg.Wait()
+}
@@ -0,0 +1,272 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bridge
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/protocol"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+type (
+	muxMapper map[string]protocol.HTTPHandler
+
+	handlerFunc func(ctx context.HTTPContext)
+)
+
+func (m muxMapper) GetHandler(name string) (protocol.HTTPHandler, bool) {
+	h, ok := m[name]
+	return h, ok
+}
+
+func (f handlerFunc) Handle(ctx context.HTTPContext) {
+	f(ctx)
+}
+
+func newBridge(t *testing.T, yamlSpec string) *Bridge {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, e := httppipeline.NewFilterSpec(rawSpec, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	b := &Bridge{}
+	b.Init(spec)
+	return b
+}
+
+func newContext(method, path string, header http.Header) (*contexttest.MockedHTTPContext, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header = header
+	w := httptest.NewRecorder()
+	return contexttest.NewMockedHTTPContext(req, w), w
+}
+
+func TestBridgeRoutes(t *testing.T) {
+	b := newBridge(t, `
+kind: Bridge
+name: bridge
+destinations: [default, legacy]
+routes:
+- methods: [POST]
+  path:
+    prefix: /orders
+  destination: orders
+- headers:
+    X-Tenant:
+      regex: ^[a-z]+$
+  destination: tenant-{{index .Header "X-Tenant"}}
+  destinationTemplate: true
+`)
+
+	called := ""
+	mapper := muxMapper{}
+	for _, name := range []string{"default", "legacy", "orders", "tenant-acme"} {
+		name := name
+		mapper[name] = handlerFunc(func(ctx context.HTTPContext) {
+			called = name
+		})
+	}
+	b.InjectMuxMapper(mapper)
+
+	cases := []struct {
+		method, path string
+		header       http.Header
+		dest         string
+		result       string
+	}{
+		{"POST", "/orders/1", http.Header{}, "orders", ""},
+		{"GET", "/orders/1", http.Header{"X-Tenant": {"acme"}}, "tenant-acme", ""},
+		{"GET", "/orders/1", http.Header{"X-Tenant": {"other"}}, "", resultDestinationNotFound},
+		{"GET", "/users", http.Header{bridgeDestHeader: {"legacy"}}, "legacy", ""},
+		{"GET", "/users", http.Header{bridgeDestHeader: {"unknown"}}, "", resultDestinationNotFound},
+		{"GET", "/users", http.Header{}, "default", ""},
+	}
+	for i, c := range cases {
+		called = ""
+		ctx, _ := newContext(c.method, c.path, c.header)
+		if result := b.Handle(ctx); result != c.result {
+			t.Errorf("case %d: expected result %q, got %q", i, c.result, result)
+		}
+		if called != c.dest {
+			t.Errorf("case %d: expected destination %q, got %q", i, c.dest, called)
+		}
+	}
+
+	s := b.Status().(*Status)
+	if s.Bridged["default"] != 1 || s.Bridged["orders"] != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestBridgeLoop(t *testing.T) {
+	b := newBridge(t, `
+kind: Bridge
+name: bridge
+maxDepth: 2
+routes:
+- headers:
+    X-Dest:
+      regex: .*
+  destination: '{{index .Header "X-Dest"}}'
+  destinationTemplate: true
+`)
+
+	header := http.Header{}
+	results := []string{}
+	mapper := muxMapper{}
+	for _, name := range []string{"a", "b", "c"} {
+		next := map[string]string{"a": "b", "b": "c", "c": "a"}[name]
+		mapper[name] = handlerFunc(func(ctx context.HTTPContext) {
+			header.Set("X-Dest", next)
+			results = append(results, b.Handle(ctx))
+		})
+	}
+	b.InjectMuxMapper(mapper)
+
+	// NOTE: a -> b -> c exceeds the max depth.
+	header.Set("X-Dest", "a")
+	ctx, w := newContext("GET", "/", header)
+	b.Handle(ctx)
+	if len(results) != 2 || results[0] != resultLoopDetected || w.Code != http.StatusLoopDetected {
+		t.Errorf("max depth should be detected, got %v %d", results, w.Code)
+	}
+
+	// NOTE: a -> b -> a is a loop.
+	b.spec.MaxDepth = 8
+	mapper["b"] = handlerFunc(func(ctx context.HTTPContext) {
+		header.Set("X-Dest", "a")
+		results = append(results, b.Handle(ctx))
+	})
+	results = nil
+	header.Set("X-Dest", "a")
+	ctx, w = newContext("GET", "/", header)
+	b.Handle(ctx)
+	if len(results) != 2 || results[0] != resultLoopDetected || w.Code != http.StatusLoopDetected {
+		t.Errorf("loop should be detected, got %v %d", results, w.Code)
+	}
+
+	if _, ok := bridgedPipelines.Load(ctx); ok {
+		t.Error("bridged pipelines should be deleted")
+	}
+	if s := b.Status().(*Status); s.Loops != 2 {
+		t.Errorf("expected 2 loops, got %d", s.Loops)
+	}
+}
+
+func TestBridgeMirror(t *testing.T) {
+	b := newBridge(t, `
+kind: Bridge
+name: bridge
+destinations: [primary]
+mirrorMaxBodySize: 8
+maxConcurrentMirrors: 2
+mirrors:
+- destination: v2
+- destination: v3
+  filter:
+    headers:
+      X-Mirror:
+        exact: v3
+`)
+	defer b.Close()
+
+	type call struct {
+		dest, body string
+	}
+	calls := make(chan call, 10)
+	release := make(chan struct{})
+	mapper := muxMapper{}
+	for _, name := range []string{"primary", "v2", "v3"} {
+		name := name
+		mapper[name] = handlerFunc(func(ctx context.HTTPContext) {
+			if name != "primary" {
+				<-release
+			}
+			body, _ := io.ReadAll(ctx.Request().Body())
+			calls <- call{name, string(body)}
+			ctx.Response().SetStatusCode(http.StatusOK)
+		})
+	}
+	b.InjectMuxMapper(mapper)
+
+	send := func(body string, header http.Header) {
+		stdr := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+		for key, values := range header {
+			stdr.Header[key] = values
+		}
+		ctx := context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+		ctx.SetHandlerCaller(func(lastResult string) string {
+			return lastResult
+		})
+		if result := b.Handle(ctx); result != "" {
+			t.Errorf("unexpected result %q", result)
+		}
+	}
+
+	send("order", http.Header{"X-Mirror": {"v3"}})
+	if c := <-calls; c.dest != "primary" || c.body != "order" {
+		t.Errorf("primary should be called first, got %+v", c)
+	}
+
+	// NOTE: The mirrors are still running, so the next request can't be
+	// mirrored because of the concurrency limit.
+	send("order", nil)
+	if c := <-calls; c.dest != "primary" {
+		t.Errorf("primary should be called, got %+v", c)
+	}
+
+	close(release)
+	got := map[string]string{}
+	for i := 0; i < 2; i++ {
+		c := <-calls
+		got[c.dest] = c.body
+	}
+	if got["v2"] != "order" || got["v3"] != "order" {
+		t.Errorf("unexpected mirrored requests %v", got)
+	}
+
+	send("a large order", nil)
+	if c := <-calls; c.dest != "primary" || c.body != "a large order" {
+		t.Errorf("primary should get the whole body, got %+v", c)
+	}
+
+	b.wg.Wait()
+	s := b.Status().(*Status)
+	if s.Mirrors["v2"].Mirrored != 1 || s.Mirrors["v2"].Dropped != 2 || s.Mirrors["v3"].Mirrored != 1 {
+		t.Errorf("unexpected status %+v %+v", s.Mirrors["v2"], s.Mirrors["v3"])
+	}
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bridge
+
+import (
+	"bytes"
+	stdcontext "context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/httpfilter"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+type (
+	// Mirror sends a copy of the requests to a destination asynchronously,
+	// its response is discarded.
+	Mirror struct {
+		Destination string `yaml:"destination" jsonschema:"required"`
+		// Filter selects the requests to mirror, all requests are
+		// mirrored if it's nil.
+		Filter *httpfilter.Spec `yaml:"filter,omitempty" jsonschema:"omitempty"`
+
+		filter *httpfilter.HTTPFilter
+	}
+
+	// MirrorStatus is the status of a mirror destination.
+	MirrorStatus struct {
+		Mirrored uint64 `yaml:"mirrored"`
+		// Dropped are the requests not mirrored since the body is too
+		// large, or there are too many requests being mirrored.
+		Dropped uint64 `yaml:"dropped"`
+		Failed  uint64 `yaml:"failed"`
+	}
+)
+
+func (b *Bridge) reloadMirrors() {
+	b.mirrorTimeout, _ = time.ParseDuration(b.spec.MirrorTimeout)
+	b.mirrorTokens = make(chan struct{}, b.spec.MaxConcurrentMirrors)
+	b.mirrorCtx, b.mirrorCancel = stdcontext.WithCancel(stdcontext.Background())
+	b.mirrors = map[string]*MirrorStatus{}
+
+	for _, m := range b.spec.Mirrors {
+		if m.Filter != nil {
+			m.filter = httpfilter.New(m.Filter)
+		}
+		b.mirrors[m.Destination] = &MirrorStatus{}
+	}
+}
+
+// mirror sends copies of the request to the matched mirror destinations,
+// pipelines are the pipelines bridged by the request.
+func (b *Bridge) mirror(ctx context.HTTPContext, pipelines []string) {
+	var matched []*Mirror
+	for _, m := range b.spec.Mirrors {
+		if m.filter == nil || m.filter.Filter(ctx) {
+			matched = append(matched, m)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	body, ok := b.readMirrorBody(ctx)
+	if !ok {
+		ctx.AddTag("bridge: request body is too large to mirror")
+		b.mutex.Lock()
+		for _, m := range matched {
+			b.mirrors[m.Destination].Dropped++
+		}
+		b.mutex.Unlock()
+		return
+	}
+
+	for _, m := range matched {
+		if stringtool.StrInSlice(m.Destination, pipelines) {
+			b.mutex.Lock()
+			b.loops++
+			b.mutex.Unlock()
+			ctx.AddTag(stringtool.Cat("bridge: loop detected mirroring to ", m.Destination))
+			continue
+		}
+
+		select {
+		case b.mirrorTokens <- struct{}{}:
+		default:
+			b.mutex.Lock()
+			b.mirrors[m.Destination].Dropped++
+			b.mutex.Unlock()
+			continue
+		}
+
+		// NOTE: The request is cloned synchronously, since the original one
+		// may be modified by the following filters.
+		stdr := ctx.Request().Std().Clone(b.mirrorCtx)
+		stdr.Body = ioutil.NopCloser(bytes.NewReader(body))
+		stdr.ContentLength = int64(len(body))
+
+		b.wg.Add(1)
+		go func(dest string, stdr *http.Request) {
+			defer b.wg.Done()
+			defer func() { <-b.mirrorTokens }()
+			b.sendMirror(dest, stdr, pipelines)
+		}(m.Destination, stdr)
+	}
+}
+
+// readMirrorBody reads the request body and restores it, it returns
+// false if the body is larger than MirrorMaxBodySize.
+func (b *Bridge) readMirrorBody(ctx context.HTTPContext) ([]byte, bool) {
+	r := ctx.Request()
+	if r.Body() == nil {
+		return nil, true
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body(), b.spec.MirrorMaxBodySize+1))
+	if err != nil {
+		logger.Errorf("%s/%s: read request body failed: %v", b.filterSpec.Pipeline(), b.filterSpec.Name(), err)
+	}
+	if int64(len(body)) > b.spec.MirrorMaxBodySize {
+		r.SetBody(io.MultiReader(bytes.NewReader(body), r.Body()))
+		return nil, false
+	}
+	r.SetBody(bytes.NewReader(body))
+	return body, err == nil
+}
+
+func (b *Bridge) sendMirror(dest string, stdr *http.Request, pipelines []string) {
+	handler, exists := b.muxMapper.GetHandler(dest)
+	if !exists {
+		logger.Errorf("%s/%s: mirror destination %s not found", b.filterSpec.Pipeline(), b.filterSpec.Name(), dest)
+		b.mutex.Lock()
+		b.mirrors[dest].Failed++
+		b.mutex.Unlock()
+		return
+	}
+
+	stdctx, cancel := stdcontext.WithTimeout(stdr.Context(), b.mirrorTimeout)
+	defer cancel()
+
+	mctx := context.New(httptest.NewRecorder(), stdr.WithContext(stdctx), tracing.NoopTracing, "bridge mirror")
+
+	// NOTE: The mirrored request inherits the bridged pipelines to detect
+	// loops of the mirror destination.
+	next := make([]string, len(pipelines), len(pipelines)+1)
+	copy(next, pipelines)
+	bridgedPipelines.Store(mctx, append(next, dest))
+	defer bridgedPipelines.Delete(mctx)
+
+	handler.Handle(mctx)
+	mctx.Finish()
+
+	b.mutex.Lock()
+	b.mirrors[dest].Mirrored++
+	if mctx.Response().StatusCode() >= http.StatusInternalServerError {
+		b.mirrors[dest].Failed++
+	}
+	b.mutex.Unlock()
+}
@@ -0,0 +1,204 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package canary
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/api"
+)
+
+const (
+	apiGroupName = "canary_admin"
+	apiPrefix    = "/canary/{pipeline}/{filter}"
+)
+
+var (
+	canariesMutex sync.RWMutex
+	canaries      = make(map[string]*Canary)
+	registerOnce  sync.Once
+)
+
+type (
+	// Pin pins the user to the target regardless of the percentage.
+	Pin struct {
+		User   string `yaml:"user"`
+		Target string `yaml:"target"`
+	}
+)
+
+func canaryKey(pipeline, filter string) string {
+	return pipeline + "/" + filter
+}
+
+func registerCanary(c *Canary) {
+	registerOnce.Do(registerAPIs)
+
+	canariesMutex.Lock()
+	defer canariesMutex.Unlock()
+
+	canaries[canaryKey(c.filterSpec.Pipeline(), c.filterSpec.Name())] = c
+}
+
+func unregisterCanary(c *Canary) {
+	canariesMutex.Lock()
+	defer canariesMutex.Unlock()
+
+	key := canaryKey(c.filterSpec.Pipeline(), c.filterSpec.Name())
+	// NOTE: The next generation may have registered itself.
+	if canaries[key] == c {
+		delete(canaries, key)
+	}
+}
+
+func registerAPIs() {
+	api.RegisterAPIs(&api.Group{
+		Group: apiGroupName,
+		Entries: []*api.Entry{
+			{Path: apiPrefix + "/users/{user}", Method: "GET", Handler: getAssignment},
+			{Path: apiPrefix + "/pins", Method: "GET", Handler: listPins},
+			{Path: apiPrefix + "/pins/{user}", Method: "PUT", Handler: putPin},
+			{Path: apiPrefix + "/pins/{user}", Method: "DELETE", Handler: deletePin},
+		},
+	})
+}
+
+func getCanary(w http.ResponseWriter, r *http.Request) *Canary {
+	pipeline, filter := chi.URLParam(r, "pipeline"), chi.URLParam(r, "filter")
+
+	canariesMutex.RLock()
+	c := canaries[canaryKey(pipeline, filter)]
+	canariesMutex.RUnlock()
+
+	if c == nil {
+		api.HandleAPIError(w, r, http.StatusNotFound,
+			fmt.Errorf("canary %s not found in pipeline %s", filter, pipeline))
+	}
+	return c
+}
+
+func writeYAML(w http.ResponseWriter, v interface{}) {
+	buff, err := yaml.Marshal(v)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", v, err))
+	}
+	w.Header().Set("Content-Type", "text/vnd.yaml")
+	w.Write(buff)
+}
+
+func getAssignment(w http.ResponseWriter, r *http.Request) {
+	c := getCanary(w, r)
+	if c == nil {
+		return
+	}
+
+	writeYAML(w, c.assign(chi.URLParam(r, "user")))
+}
+
+func listPins(w http.ResponseWriter, r *http.Request) {
+	c := getCanary(w, r)
+	if c == nil {
+		return
+	}
+
+	store, err := c.filterSpec.KVStore()
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	prefix := c.pinKey("")
+	pins := []*Pin{}
+	for _, key := range store.Keys() {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		target, ok := store.Get(key)
+		if !ok {
+			continue
+		}
+		pins = append(pins, &Pin{User: strings.TrimPrefix(key, prefix), Target: target})
+	}
+	sort.Slice(pins, func(i, j int) bool { return pins[i].User < pins[j].User })
+
+	writeYAML(w, pins)
+}
+
+func putPin(w http.ResponseWriter, r *http.Request) {
+	c := getCanary(w, r)
+	if c == nil {
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("read body failed: %v", err))
+		return
+	}
+
+	pin := &Pin{}
+	err = yaml.Unmarshal(body, pin)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("unmarshal %s to yaml failed: %v", body, err))
+		return
+	}
+	if pin.Target != targetCanary && pin.Target != targetStable {
+		api.HandleAPIError(w, r, http.StatusBadRequest,
+			fmt.Errorf("target must be %s or %s", targetCanary, targetStable))
+		return
+	}
+
+	store, err := c.filterSpec.KVStore()
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	err = store.Put(c.pinKey(chi.URLParam(r, "user")), pin.Target, 0)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+}
+
+func deletePin(w http.ResponseWriter, r *http.Request) {
+	c := getCanary(w, r)
+	if c == nil {
+		return
+	}
+
+	store, err := c.filterSpec.KVStore()
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	err = store.Delete(c.pinKey(chi.URLParam(r, "user")))
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+}
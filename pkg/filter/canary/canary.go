@@ -0,0 +1,233 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package canary
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/hashtool"
+)
+
+const (
+	// Kind is the kind of Canary.
+	Kind = "Canary"
+
+	resultCanary = "canary"
+
+	// numOfBuckets is the number of buckets users are hashed into,
+	// it makes the percentage precise to 0.01.
+	numOfBuckets = 10000
+
+	targetCanary = "canary"
+	targetStable = "stable"
+)
+
+var results = []string{resultCanary}
+
+func init() {
+	httppipeline.Register(&Canary{})
+}
+
+type (
+	// Canary is filter Canary.
+	Canary struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		getUserKey getActVal
+		threshold  uint32
+
+		numOfCanary    uint64
+		numOfStable    uint64
+		numOfAnonymous uint64
+	}
+
+	// Spec describes the Canary.
+	Spec struct {
+		// Percentage is the percentage of users assigned to canary.
+		Percentage float64 `yaml:"percentage" jsonschema:"minimum=0,maximum=100"`
+		// Salt changes the assignment of all users, users are reshuffled
+		// when it's changed.
+		Salt    string   `yaml:"salt" jsonschema:"omitempty"`
+		UserKey *UserKey `yaml:"userKey" jsonschema:"required"`
+		// TagKey is the HTTP header added to the requests of canary users.
+		TagKey   string `yaml:"tagKey" jsonschema:"omitempty"`
+		TagValue string `yaml:"tagValue" jsonschema:"omitempty"`
+	}
+
+	// UserKey describes where to get the stable key of a user,
+	// requests without the key are always assigned to stable.
+	UserKey struct {
+		Source string `yaml:"source" jsonschema:"required,enum=Header,enum=Cookie,enum=Jwt,enum=ClientIP"`
+		// Name is the name of the header, the cookie or the jwt claim.
+		Name string `yaml:"name" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of Canary.
+	Status struct {
+		NumOfCanary    uint64 `yaml:"numOfCanary"`
+		NumOfStable    uint64 `yaml:"numOfStable"`
+		NumOfAnonymous uint64 `yaml:"numOfAnonymous"`
+	}
+
+	// Assignment is the assignment of a user.
+	Assignment struct {
+		User   string `yaml:"user"`
+		Bucket uint32 `yaml:"bucket"`
+		Target string `yaml:"target"`
+		Pinned bool   `yaml:"pinned"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if spec.UserKey.Source != clientIP && spec.UserKey.Name == "" {
+		return fmt.Errorf("name of user key is required for source %s", spec.UserKey.Source)
+	}
+	return nil
+}
+
+// Kind returns the kind of Canary.
+func (c *Canary) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of Canary.
+func (c *Canary) DefaultSpec() interface{} {
+	return &Spec{
+		TagValue: "true",
+	}
+}
+
+// Description returns the description of Canary.
+func (c *Canary) Description() string {
+	return "Canary assigns users to canary or stable consistently by hashing the stable key of users."
+}
+
+// Results returns the results of Canary.
+func (c *Canary) Results() []string {
+	return results
+}
+
+// Init initializes Canary.
+func (c *Canary) Init(filterSpec *httppipeline.FilterSpec) {
+	c.filterSpec, c.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	c.reload()
+}
+
+// Inherit inherits previous generation of Canary.
+func (c *Canary) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	c.Init(filterSpec)
+}
+
+func (c *Canary) reload() {
+	c.getUserKey = makeGetActVal(c.spec.UserKey.Source, c.spec.UserKey.Name)
+	c.threshold = uint32(c.spec.Percentage * numOfBuckets / 100)
+
+	registerCanary(c)
+}
+
+// bucket returns the bucket of the user, the user is assigned to
+// canary if the bucket is less than the threshold. As the bucket of
+// a user never changes, increasing the percentage only brings more
+// users to canary, and all users already in canary stay there.
+func (c *Canary) bucket(user string) uint32 {
+	return hashtool.Hash32(c.spec.Salt+user) % numOfBuckets
+}
+
+func (c *Canary) pinKey(user string) string {
+	return "canary/" + c.filterSpec.Name() + "/pins/" + user
+}
+
+// pinnedTarget returns the target the user is pinned to,
+// or an empty string if the user is not pinned.
+func (c *Canary) pinnedTarget(user string) string {
+	store, err := c.filterSpec.KVStore()
+	if err != nil {
+		return ""
+	}
+	target, _ := store.Get(c.pinKey(user))
+	return target
+}
+
+func (c *Canary) assign(user string) *Assignment {
+	a := &Assignment{
+		User:   user,
+		Bucket: c.bucket(user),
+		Target: c.pinnedTarget(user),
+	}
+
+	if a.Target != "" {
+		a.Pinned = true
+	} else if a.Bucket < c.threshold {
+		a.Target = targetCanary
+	} else {
+		a.Target = targetStable
+	}
+
+	return a
+}
+
+// Handle assigns the request to canary or stable.
+func (c *Canary) Handle(ctx context.HTTPContext) string {
+	result := c.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (c *Canary) handle(ctx context.HTTPContext) string {
+	user := c.getUserKey(&sourceData{
+		req:      ctx.Request().Std(),
+		clientIP: ctx.Request().RealIP(),
+	})
+	if user == "" {
+		atomic.AddUint64(&c.numOfAnonymous, 1)
+		return ""
+	}
+
+	a := c.assign(user)
+	if a.Target != targetCanary {
+		atomic.AddUint64(&c.numOfStable, 1)
+		return ""
+	}
+
+	atomic.AddUint64(&c.numOfCanary, 1)
+	ctx.AddTag("canary bucket " + strconv.Itoa(int(a.Bucket)))
+	if c.spec.TagKey != "" {
+		ctx.Request().Header().Set(c.spec.TagKey, c.spec.TagValue)
+	}
+	return resultCanary
+}
+
+// Status returns status.
+func (c *Canary) Status() interface{} {
+	return &Status{
+		NumOfCanary:    atomic.LoadUint64(&c.numOfCanary),
+		NumOfStable:    atomic.LoadUint64(&c.numOfStable),
+		NumOfAnonymous: atomic.LoadUint64(&c.numOfAnonymous),
+	}
+}
+
+// Close closes Canary.
+func (c *Canary) Close() {
+	unregisterCanary(c)
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package canary
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func newCanary(t *testing.T, yamlSpec string) *Canary {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c := &Canary{}
+	c.Init(spec)
+	return c
+}
+
+func handleUser(c *Canary, user string) (string, http.Header) {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	if user != "" {
+		req.AddCookie(&http.Cookie{Name: "uid", Value: user})
+	}
+
+	return c.Handle(contexttest.NewMockedHTTPContext(req, httptest.NewRecorder())), req.Header
+}
+
+func canarySpec(percentage float64) string {
+	return fmt.Sprintf(`
+kind: Canary
+name: canary
+percentage: %v
+userKey:
+  source: Cookie
+  name: uid
+tagKey: X-Canary
+`, percentage)
+}
+
+func TestCanarySticky(t *testing.T) {
+	c := newCanary(t, canarySpec(30))
+	defer c.Close()
+
+	canaryUsers := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		result, header := handleUser(c, user)
+		if result == resultCanary {
+			canaryUsers[user] = true
+			if header.Get("X-Canary") != "true" {
+				t.Fatalf("want canary header for %s", user)
+			}
+		}
+
+		// The same user always lands on the same side.
+		for j := 0; j < 3; j++ {
+			if r, _ := handleUser(c, user); r != result {
+				t.Fatalf("user %s is not sticky", user)
+			}
+		}
+	}
+
+	if n := len(canaryUsers); n < 250 || n > 350 {
+		t.Fatalf("want about 300 canary users, got %d", n)
+	}
+
+	// Raising the percentage keeps all canary users in canary.
+	c2 := newCanary(t, canarySpec(60))
+	defer c2.Close()
+	for user := range canaryUsers {
+		if r, _ := handleUser(c2, user); r != resultCanary {
+			t.Fatalf("user %s left canary after raising percentage", user)
+		}
+	}
+
+	if r, _ := handleUser(c, ""); r != "" {
+		t.Fatalf("want anonymous user in stable")
+	}
+	status := c.Status().(*Status)
+	if status.NumOfAnonymous != 1 {
+		t.Fatalf("want 1 anonymous request, got %d", status.NumOfAnonymous)
+	}
+}
+
+func TestCanaryBoundary(t *testing.T) {
+	none := newCanary(t, canarySpec(0))
+	defer none.Close()
+	all := newCanary(t, canarySpec(100))
+	defer all.Close()
+
+	for i := 0; i < 100; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		if r, _ := handleUser(none, user); r != "" {
+			t.Fatalf("want %s in stable", user)
+		}
+		if r, _ := handleUser(all, user); r != resultCanary {
+			t.Fatalf("want %s in canary", user)
+		}
+	}
+
+	a := all.assign("user-1")
+	if a.Target != targetCanary || a.Pinned {
+		t.Fatalf("unexpected assignment %+v", a)
+	}
+}
+
+func TestCanarySpecValidate(t *testing.T) {
+	spec := Spec{UserKey: &UserKey{Source: "Header"}}
+	if spec.Validate() == nil {
+		t.Fatalf("want error for missing header name")
+	}
+
+	spec = Spec{UserKey: &UserKey{Source: "ClientIP"}}
+	if err := spec.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -319,45 +319,7 @@ func (p *parser) doParseCond() (m matcher, err error) {
 	}
 
 	// Define a getActVal function here to hide source detail.
-	var getAct getActVal
-
-	switch src { // Choose right way to handle source.
-	case clientIP:
-		getAct = func(data *sourceData) string {
-			return data.clientIP
-		}
-	case jwt:
-		getAct = func(data *sourceData) string {
-			auth := data.req.Header.Get("Authorization")
-			if auth == "" {
-				return ""
-			}
-			token := strings.Split(auth, " ")[1]
-			t, _, err := new(jwtgo.Parser).ParseUnverified(token, jwtgo.MapClaims{})
-			if err != nil {
-				return ""
-			}
-			claim, ok := t.Claims.(jwtgo.MapClaims)
-			if !ok {
-				logger.Debugf("jwt claims is not MapClaims")
-				return ""
-			}
-			return claim[key].(string)
-		}
-	case header:
-		getAct = func(data *sourceData) string {
-			return data.req.Header.Get(key)
-		}
-	case cookie:
-		getAct = func(data *sourceData) string {
-			c, err := data.req.Cookie(key)
-			if err != nil {
-				logger.Debugf("try to get cookie failed: %s", err.Error())
-				return ""
-			}
-			return c.Value
-		}
-	}
+	getAct := makeGetActVal(src, key)
 
 	// Preprocessing special operator for accelerating matcher.
 	var expMod uint32
@@ -421,6 +383,56 @@ func (p *parser) doParseCond() (m matcher, err error) {
 	return m, nil
 }
 
+// makeGetActVal makes the function getting the value of the key from
+// the source, the key is ignored for ClientIP.
+func makeGetActVal(src, key string) getActVal {
+	var getAct getActVal
+
+	switch src { // Choose right way to handle source.
+	case clientIP:
+		getAct = func(data *sourceData) string {
+			return data.clientIP
+		}
+	case jwt:
+		getAct = func(data *sourceData) string {
+			auth := data.req.Header.Get("Authorization")
+			if auth == "" {
+				return ""
+			}
+			fields := strings.Fields(auth)
+			if len(fields) != 2 {
+				return ""
+			}
+			t, _, err := new(jwtgo.Parser).ParseUnverified(fields[1], jwtgo.MapClaims{})
+			if err != nil {
+				return ""
+			}
+			claim, ok := t.Claims.(jwtgo.MapClaims)
+			if !ok {
+				logger.Debugf("jwt claims is not MapClaims")
+				return ""
+			}
+			v, _ := claim[key].(string)
+			return v
+		}
+	case header:
+		getAct = func(data *sourceData) string {
+			return data.req.Header.Get(key)
+		}
+	case cookie:
+		getAct = func(data *sourceData) string {
+			c, err := data.req.Cookie(key)
+			if err != nil {
+				logger.Debugf("try to get cookie failed: %s", err.Error())
+				return ""
+			}
+			return c.Value
+		}
+	}
+
+	return getAct
+}
+
 // Legal form: source.key
 // pop key here.
 func (p *parser) getSourceKey() (string, error) {
@@ -19,6 +19,7 @@ package circuitbreaker
 
 import (
 	"fmt"
+	"math"
 	"net/http"
 	"reflect"
 	"strings"
@@ -28,6 +29,7 @@ import (
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
 	libcb "github.com/megaease/easegress/pkg/util/circuitbreaker"
+	"github.com/megaease/easegress/pkg/util/retryafter"
 	"github.com/megaease/easegress/pkg/util/urlrule"
 )
 
@@ -72,12 +74,16 @@ type (
 		Policies         []*Policy  `yaml:"policies" jsonschema:"required"`
 		DefaultPolicyRef string     `yaml:"defaultPolicyRef" jsonschema:"omitempty"`
 		URLs             []*URLRule `yaml:"urls" jsonschema:"required"`
+		// RetryAfter hints clients when the circuit breaker permits
+		// calls again, if the circuit is broken.
+		RetryAfter *retryafter.Spec `yaml:"retryAfter,omitempty" jsonschema:"omitempty"`
 	}
 
 	// CircuitBreaker defines the circuit breaker
 	CircuitBreaker struct {
 		filterSpec *httppipeline.FilterSpec
 		spec       *Spec
+		hinter     *retryafter.Hinter
 	}
 
 	// Status is the status of CircuitBreaker.
@@ -246,6 +252,10 @@ func isSamePolicy(spec1, spec2 *Spec, policyName string) bool {
 }
 
 func (cb *CircuitBreaker) reload(previousGeneration *CircuitBreaker) {
+	if cb.spec.RetryAfter != nil {
+		cb.hinter = retryafter.New(cb.spec.RetryAfter)
+	}
+
 	if previousGeneration == nil {
 		for _, u := range cb.spec.URLs {
 			cb.createCircuitBreakerForURL(u)
@@ -292,6 +302,9 @@ func (cb *CircuitBreaker) handle(ctx context.HTTPContext, u *URLRule) string {
 		ctx.AddTag("circuitBreaker: circuit is broken")
 		ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
 		ctx.Response().Std().Header().Set("X-EG-Circuit-Breaker", "circurit-is-broken")
+		if cb.hinter != nil {
+			cb.hintRetryAfter(ctx, u)
+		}
 		return ctx.CallNextHandler(resultShortCircuited)
 	}
 
@@ -322,6 +335,22 @@ func (cb *CircuitBreaker) handle(ctx context.HTTPContext, u *URLRule) string {
 	return result
 }
 
+func (cb *CircuitBreaker) hintRetryAfter(ctx context.HTTPContext, u *URLRule) {
+	d, ok := u.cb.RetryAfter()
+	var reason string
+	switch {
+	case !ok && u.cb.State() == libcb.StateForceOpen:
+		d = time.Duration(math.MaxInt64)
+		reason = "circuit breaker is forced open"
+	case !ok:
+		// the probe calls decide the next state, retry soon.
+		reason = "circuit breaker is half open, probe calls in flight"
+	default:
+		reason = fmt.Sprintf("circuit breaker is open, half open in %v", d)
+	}
+	cb.hinter.Hint(ctx.Response().Std().Header(), d, reason)
+}
+
 // Handle handles HTTP request
 func (cb *CircuitBreaker) Handle(ctx context.HTTPContext) string {
 	for _, u := range cb.spec.URLs {
@@ -159,3 +159,71 @@ func TestBuildPolicy(t *testing.T) {
 		t.Error("wait duration in open is not 1m")
 	}
 }
+
+func TestRetryAfter(t *testing.T) {
+	const yamlSpec = `
+kind: CircuitBreaker
+name: circuitbreaker
+policies:
+- name: default
+  slowCallRateThreshold: 100
+  failureRateThreshold: 50
+  slidingWindowType: COUNT_BASED
+  slidingWindowSize: 10
+  minimumNumberOfCalls: 5
+  waitDurationInOpenState: 10s
+  failureStatusCodes: [500]
+defaultPolicyRef: default
+urls:
+- url:
+    prefix: /
+retryAfter:
+  min: 2s
+  debug: true
+`
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, e := httppipeline.NewFilterSpec(rawSpec, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+
+	cb := &CircuitBreaker{}
+	cb.Init(spec)
+
+	resp := httptest.NewRecorder()
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedPath = func() string {
+		return "/retry"
+	}
+	ctx.MockedResponse.MockedStd = func() http.ResponseWriter {
+		return resp
+	}
+	ctx.MockedResponse.MockedStatusCode = func() int {
+		return http.StatusInternalServerError
+	}
+
+	for i := 0; i < 5; i++ {
+		cb.Handle(ctx)
+	}
+	if resp.Header().Get("Retry-After") != "" {
+		t.Error("calls permitted should not be hinted")
+	}
+
+	if cb.Handle(ctx) != resultShortCircuited {
+		t.Fatal("should be short circuited")
+	}
+	if got := resp.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("expected Retry-After 10, got %q", got)
+	}
+	if resp.Header().Get("X-EG-Retry-After-Reason") == "" {
+		t.Error("reason should be set in debug mode")
+	}
+
+	cb.spec.URLs[0].cb.SetState(libcb.StateForceOpen)
+	cb.Handle(ctx)
+	if got := resp.Header().Get("Retry-After"); got != "120" {
+		t.Errorf("expected Retry-After 120, got %q", got)
+	}
+}
@@ -0,0 +1,429 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package contentmoderator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/consumer"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const (
+	// Kind is the kind of ContentModerator.
+	Kind = "ContentModerator"
+
+	resultBlocked         = "blocked"
+	resultModerationError = "moderationError"
+
+	maxBodySize    = 16 * 1024 * 1024
+	maxAuditEvents = 100
+
+	actionBlocked  = "blocked"
+	actionRedacted = "redacted"
+)
+
+var results = []string{resultBlocked, resultModerationError}
+
+func init() {
+	httppipeline.Register(&ContentModerator{})
+}
+
+type (
+	// ContentModerator moderates prompts and completions of
+	// OpenAI-compatible APIs by policies.
+	ContentModerator struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		resolve  consumer.Resolver
+		policies []*policy
+		external *external
+
+		mutex  sync.Mutex
+		status *Status
+	}
+
+	// Spec describes the ContentModerator.
+	Spec struct {
+		// Consumer is where the consumer of audit events is resolved from.
+		Consumer *consumer.Spec `yaml:"consumer" jsonschema:"omitempty"`
+		// Policies are applied in order, the first blocking one
+		// stops the moderation.
+		Policies []*PolicySpec `yaml:"policies" jsonschema:"omitempty"`
+		// External is the external moderation API, it's called with
+		// prompts passing the policies.
+		External *ExternalSpec `yaml:"external" jsonschema:"omitempty"`
+	}
+
+	// AuditEvent is an audit record of a moderation, the content
+	// itself is never recorded.
+	AuditEvent struct {
+		Time     time.Time `yaml:"time"`
+		Consumer string    `yaml:"consumer"`
+		Target   string    `yaml:"target"`
+		Policy   string    `yaml:"policy"`
+		Action   string    `yaml:"action"`
+		// Matches is the number of matched contents, it's zero for
+		// size limits and the external moderation.
+		Matches int `yaml:"matches"`
+	}
+
+	// Status is the status of ContentModerator.
+	Status struct {
+		Blocked  uint64 `yaml:"blocked"`
+		Redacted uint64 `yaml:"redacted"`
+		// Events are the latest audit events, the oldest first.
+		Events []*AuditEvent `yaml:"events"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if len(spec.Policies) == 0 && spec.External == nil {
+		return fmt.Errorf("none of policies and external is specified")
+	}
+	names := map[string]bool{}
+	for _, p := range spec.Policies {
+		if names[p.Name] {
+			return fmt.Errorf("duplicated policy %s", p.Name)
+		}
+		names[p.Name] = true
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("policy %s: %v", p.Name, err)
+		}
+	}
+	if spec.Consumer != nil {
+		if err := spec.Consumer.Validate(); err != nil {
+			return fmt.Errorf("consumer: %v", err)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of ContentModerator.
+func (cm *ContentModerator) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of ContentModerator.
+func (cm *ContentModerator) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of ContentModerator.
+func (cm *ContentModerator) Description() string {
+	return "ContentModerator blocks or redacts prompts and completions of LLM APIs by policies."
+}
+
+// Results returns the results of ContentModerator.
+func (cm *ContentModerator) Results() []string {
+	return results
+}
+
+// Init initializes ContentModerator.
+func (cm *ContentModerator) Init(filterSpec *httppipeline.FilterSpec) {
+	cm.filterSpec, cm.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	cm.status = &Status{}
+	cm.reload()
+}
+
+// Inherit inherits previous generation of ContentModerator.
+func (cm *ContentModerator) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+
+	prev := previousGeneration.(*ContentModerator)
+	cm.filterSpec, cm.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	prev.mutex.Lock()
+	cm.status = prev.status
+	prev.mutex.Unlock()
+	cm.reload()
+}
+
+func (cm *ContentModerator) reload() {
+	if cm.spec.Consumer != nil {
+		cm.resolve = consumer.NewResolver(cm.spec.Consumer)
+	} else {
+		cm.resolve = func(ctx context.HTTPContext) string { return "" }
+	}
+
+	cm.policies = make([]*policy, len(cm.spec.Policies))
+	for i, spec := range cm.spec.Policies {
+		cm.policies[i] = newPolicy(spec)
+	}
+
+	cm.external = nil
+	if cm.spec.External != nil {
+		cm.external = newExternal(cm.spec.External)
+	}
+}
+
+// Handle handles HTTPContext.
+func (cm *ContentModerator) Handle(ctx context.HTTPContext) string {
+	c := cm.resolve(ctx)
+	if result := cm.handleRequest(ctx, c); result != "" {
+		return ctx.CallNextHandler(result)
+	}
+
+	result := ctx.CallNextHandler("")
+	cm.handleResponse(ctx, c)
+	return result
+}
+
+func (cm *ContentModerator) audit(c, target, policy, action string, matches int) {
+	e := &AuditEvent{
+		Time:     time.Now(),
+		Consumer: c,
+		Target:   target,
+		Policy:   policy,
+		Action:   action,
+		Matches:  matches,
+	}
+	logger.Infof("content moderator %s/%s: %s of consumer %s %s by policy %s",
+		cm.filterSpec.Pipeline(), cm.filterSpec.Name(), target, c, action, policy)
+
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	if action == actionBlocked {
+		cm.status.Blocked++
+	} else {
+		cm.status.Redacted++
+	}
+	cm.status.Events = append(cm.status.Events, e)
+	if len(cm.status.Events) > maxAuditEvents {
+		cm.status.Events = cm.status.Events[len(cm.status.Events)-maxAuditEvents:]
+	}
+}
+
+// moderate applies the policies of the target to the text, it returns
+// the redacted text and the name of the blocking policy if it's blocked.
+func (cm *ContentModerator) moderate(c, target, text string) (string, string) {
+	for _, p := range cm.policies {
+		if !p.appliesTo(target) {
+			continue
+		}
+
+		overSize, matches := p.overSize(text), p.matches(text)
+		if !overSize && matches == 0 {
+			continue
+		}
+
+		if p.spec.Action == ActionBlock {
+			cm.audit(c, target, p.spec.Name, actionBlocked, matches)
+			return text, p.spec.Name
+		}
+		cm.audit(c, target, p.spec.Name, actionRedacted, matches)
+		text = p.redact(text)
+	}
+	return text, ""
+}
+
+// moderateStream moderates a delta of a streamed completion, tail is
+// the end of the completion before the delta and size is its size.
+// Block policies and size limits are checked against the tail and the
+// delta, so contents spanning events are blocked, but only contents
+// inside the delta are redacted.
+func (cm *ContentModerator) moderateStream(c, text, tail string, size int) (string, bool) {
+	for _, p := range cm.policies {
+		if !p.completion {
+			continue
+		}
+
+		if p.spec.MaxSize > 0 && size+len(text) > p.spec.MaxSize {
+			cm.audit(c, TargetCompletion, p.spec.Name, actionBlocked, 0)
+			return "", true
+		}
+
+		if p.spec.Action == ActionBlock {
+			if matches := p.matches(tail + text); matches > 0 {
+				cm.audit(c, TargetCompletion, p.spec.Name, actionBlocked, matches)
+				return "", true
+			}
+			continue
+		}
+
+		if matches := p.matches(text); matches > 0 {
+			cm.audit(c, TargetCompletion, p.spec.Name, actionRedacted, matches)
+			text = p.redactMatches(text)
+		}
+	}
+	return text, false
+}
+
+// readJSON reads and unmarshals the JSON body, the returned reader
+// reproduces the body, even if it's too large or not JSON.
+func readJSON(r io.Reader) (io.Reader, []byte, map[string]interface{}) {
+	body, err := ioutil.ReadAll(io.LimitReader(r, maxBodySize+1))
+	if err != nil || len(body) > maxBodySize {
+		return io.MultiReader(bytes.NewReader(body), r), nil, nil
+	}
+
+	var m map[string]interface{}
+	if json.Unmarshal(body, &m) != nil {
+		return bytes.NewReader(body), nil, nil
+	}
+	return bytes.NewReader(body), body, m
+}
+
+func (cm *ContentModerator) block(ctx context.HTTPContext, code int, message string) {
+	w := ctx.Response()
+	w.SetStatusCode(code)
+	w.Header().Set(httpheader.KeyContentType, "application/json")
+
+	buff, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]string{
+			"message": message,
+			"type":    "invalid_request_error",
+			"code":    finishReasonContentFilter,
+		},
+	})
+	w.SetBody(bytes.NewReader(buff))
+}
+
+func (cm *ContentModerator) handleRequest(ctx context.HTTPContext, c string) string {
+	r := ctx.Request()
+	reader, body, m := readJSON(r.Body())
+	if m == nil {
+		// NOTE: Only JSON requests are moderated, others are passed
+		// through as is.
+		r.SetBody(reader)
+		return ""
+	}
+
+	blockedBy, changed := "", false
+	var prompts []string
+	walkPrompts(m, func(text string) string {
+		if blockedBy != "" {
+			return text
+		}
+		moderated, policy := cm.moderate(c, TargetPrompt, text)
+		blockedBy, changed = policy, changed || moderated != text
+		prompts = append(prompts, moderated)
+		return moderated
+	})
+
+	if blockedBy != "" {
+		cm.block(ctx, http.StatusBadRequest, "the prompt is blocked by policy "+blockedBy)
+		return resultBlocked
+	}
+
+	if cm.external != nil && len(prompts) > 0 {
+		flagged, err := cm.external.flagged(ctx, strings.Join(prompts, "\n"))
+		switch {
+		case err != nil && !cm.external.spec.FailOpen:
+			ctx.AddTag(fmt.Sprintf("contentModerator: external moderation failed: %v", err))
+			cm.block(ctx, http.StatusServiceUnavailable, "the moderation is unavailable")
+			return resultModerationError
+		case err != nil:
+			ctx.AddTag(fmt.Sprintf("contentModerator: external moderation failed: %v", err))
+		case flagged:
+			cm.audit(c, TargetPrompt, policyExternal, actionBlocked, 0)
+			cm.block(ctx, http.StatusBadRequest, "the prompt is flagged by the moderation")
+			return resultBlocked
+		}
+	}
+
+	if changed {
+		if buff, err := json.Marshal(m); err == nil {
+			body = buff
+			r.Header().Set(httpheader.KeyContentLength, strconv.Itoa(len(body)))
+		}
+	}
+	r.SetBody(bytes.NewReader(body))
+	return ""
+}
+
+func (cm *ContentModerator) handleResponse(ctx context.HTTPContext, c string) {
+	w := ctx.Response()
+	if w.Body() == nil || w.Header().Get(httpheader.KeyContentEncoding) != "" {
+		return
+	}
+
+	contentType := w.Header().Get(httpheader.KeyContentType)
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		w.SetBody(newStreamReader(w.Body(), func(text, tail string, size int) (string, bool) {
+			return cm.moderateStream(c, text, tail, size)
+		}))
+		return
+	}
+	if !isJSON(contentType) {
+		return
+	}
+
+	original := w.Body()
+	reader, body, m := readJSON(original)
+	if m == nil {
+		w.SetBody(struct {
+			io.Reader
+			io.Closer
+		}{reader, closerOf(original)})
+		return
+	}
+	if c, ok := original.(io.Closer); ok {
+		c.Close()
+	}
+
+	changed := false
+	walkChoices(m, "message", func(index int, text string) (string, bool) {
+		moderated, policy := cm.moderate(c, TargetCompletion, text)
+		changed = changed || moderated != text || policy != ""
+		return moderated, policy != ""
+	})
+
+	if changed {
+		if buff, err := json.Marshal(m); err == nil {
+			body = buff
+			w.Header().Set(httpheader.KeyContentLength, strconv.Itoa(len(body)))
+		}
+	}
+	w.SetBody(bytes.NewReader(body))
+}
+
+// closerOf returns the closer of the body, the flushing of the
+// response closes it.
+func closerOf(body io.Reader) io.Closer {
+	if c, ok := body.(io.Closer); ok {
+		return c
+	}
+	return ioutil.NopCloser(nil)
+}
+
+// Status returns status.
+func (cm *ContentModerator) Status() interface{} {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	s := *cm.status
+	s.Events = append([]*AuditEvent(nil), cm.status.Events...)
+	return &s
+}
+
+// Close closes ContentModerator.
+func (cm *ContentModerator) Close() {}
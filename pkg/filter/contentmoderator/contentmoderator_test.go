@@ -0,0 +1,261 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package contentmoderator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newContentModerator(t *testing.T, yamlSpec string) *ContentModerator {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cm := &ContentModerator{}
+	cm.Init(spec)
+	return cm
+}
+
+const policies = `
+kind: ContentModerator
+name: moderator
+consumer:
+  source: Header
+  name: X-Consumer
+policies:
+- name: ssn
+  regexps: ['\d{3}-\d{2}-\d{4}']
+  action: Redact
+- name: secret
+  keywords: [Project X]
+  action: Block
+- name: size
+  maxSize: 64
+  action: Block
+  targets: [prompt]
+`
+
+type exchange struct {
+	result      string
+	code        int
+	upstream    string
+	body        string
+	contentType string
+}
+
+// handle sends the request body through the moderator, the next
+// handler records the moderated request and responds with response.
+func handle(cm *ContentModerator, reqBody, contentType, response string) *exchange {
+	e := &exchange{}
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(reqBody))
+	req.Header.Set("X-Consumer", "alice")
+	w := httptest.NewRecorder()
+
+	ctx := contexttest.NewMockedHTTPContext(req, w)
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		if lastResult != "" {
+			return lastResult
+		}
+		buff, _ := ioutil.ReadAll(ctx.Request().Body())
+		e.upstream = string(buff)
+		w.Header().Set("Content-Type", contentType)
+		ctx.Response().SetBody(strings.NewReader(response))
+		return ""
+	}
+
+	e.result = cm.Handle(ctx)
+	e.code = w.Code
+	buff, _ := ioutil.ReadAll(ctx.Response().Body())
+	e.body = string(buff)
+	return e
+}
+
+func TestPrompt(t *testing.T) {
+	cm := newContentModerator(t, policies)
+	defer cm.Close()
+
+	e := handle(cm, `{"messages":[{"role":"user","content":"my ssn is 123-45-6789"}]}`, "application/json", `{}`)
+	if e.result != "" || !strings.Contains(e.upstream, "my ssn is [REDACTED]") {
+		t.Errorf("prompt should be redacted, got %+v", e)
+	}
+
+	e = handle(cm, `{"messages":[{"role":"user","content":[{"type":"text","text":"about project x"}]}]}`, "application/json", `{}`)
+	if e.result != resultBlocked || e.code != http.StatusBadRequest || e.upstream != "" {
+		t.Errorf("prompt should be blocked, got %+v", e)
+	}
+
+	e = handle(cm, `{"prompt":"`+strings.Repeat("a", 65)+`"}`, "application/json", `{}`)
+	if e.result != resultBlocked {
+		t.Errorf("large prompt should be blocked, got %+v", e)
+	}
+
+	e = handle(cm, `not json`, "application/json", `{}`)
+	if e.result != "" || e.upstream != "not json" {
+		t.Errorf("non JSON request should be passed through, got %+v", e)
+	}
+
+	s := cm.Status().(*Status)
+	if s.Blocked != 2 || s.Redacted != 1 || len(s.Events) != 3 {
+		t.Errorf("unexpected status %+v", s)
+	}
+	if ev := s.Events[0]; ev.Consumer != "alice" || ev.Policy != "ssn" || ev.Target != TargetPrompt || ev.Matches != 1 {
+		t.Errorf("unexpected event %+v", ev)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	cm := newContentModerator(t, policies)
+	defer cm.Close()
+
+	e := handle(cm, `{}`, "application/json",
+		`{"choices":[{"index":0,"message":{"content":"call 123-45-6789"}},{"index":1,"message":{"content":"PROJECT X is secret"}}]}`)
+	if !strings.Contains(e.body, `"content":"call [REDACTED]"`) {
+		t.Errorf("completion should be redacted, got %s", e.body)
+	}
+	if !strings.Contains(e.body, `"finish_reason":"content_filter"`) {
+		t.Errorf("completion should be filtered, got %s", e.body)
+	}
+	if strings.Contains(e.body, "secret") {
+		t.Errorf("blocked content leaked: %s", e.body)
+	}
+
+	// NOTE: Size limits of prompts don't apply to completions.
+	long := strings.Repeat("b", 100)
+	e = handle(cm, `{}`, "application/json", `{"choices":[{"text":"`+long+`"}]}`)
+	if !strings.Contains(e.body, long) {
+		t.Errorf("completion should be passed, got %s", e.body)
+	}
+}
+
+func TestStream(t *testing.T) {
+	cm := newContentModerator(t, policies)
+	defer cm.Close()
+
+	event := func(content string) string {
+		return fmt.Sprintf("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", content)
+	}
+
+	stream := event("ssn 123-45-6789") + event("about Proj") + event("ect X now") + event("never sent") + "data: [DONE]\n\n"
+	e := handle(cm, `{}`, "text/event-stream", stream)
+
+	if !strings.Contains(e.body, "ssn [REDACTED]") {
+		t.Errorf("delta should be redacted, got %s", e.body)
+	}
+	if !strings.Contains(e.body, `"finish_reason":"content_filter"`) || !strings.HasSuffix(e.body, "data: [DONE]\n\n") {
+		t.Errorf("stream should be finished by content filter, got %s", e.body)
+	}
+	if strings.Contains(e.body, "never sent") || strings.Contains(e.body, "ect X") {
+		t.Errorf("blocked content leaked: %s", e.body)
+	}
+
+	e = handle(cm, `{}`, "text/event-stream", event("hello")+"data: [DONE]\n\n")
+	if e.body != event("hello")+"data: [DONE]\n\n" {
+		t.Errorf("stream should be passed through, got %q", e.body)
+	}
+}
+
+func TestExternal(t *testing.T) {
+	failed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		flagged := strings.Contains(string(body), "violence")
+		fmt.Fprintf(w, `{"results":[{"flagged":%v}]}`, flagged)
+	}))
+	defer server.Close()
+
+	cm := newContentModerator(t, fmt.Sprintf(`
+kind: ContentModerator
+name: moderator
+external:
+  url: %s
+`, server.URL))
+	defer cm.Close()
+
+	e := handle(cm, `{"input":"peace"}`, "application/json", `{}`)
+	if e.result != "" {
+		t.Errorf("unexpected result %+v", e)
+	}
+	e = handle(cm, `{"input":["peace","violence"]}`, "application/json", `{}`)
+	if e.result != resultBlocked {
+		t.Errorf("prompt should be blocked, got %+v", e)
+	}
+
+	failed = true
+	e = handle(cm, `{"input":"peace"}`, "application/json", `{}`)
+	if e.result != resultModerationError || e.code != http.StatusServiceUnavailable {
+		t.Errorf("expected moderation error, got %+v", e)
+	}
+
+	cm.spec.External.FailOpen = true
+	e = handle(cm, `{"input":"peace"}`, "application/json", `{}`)
+	if e.result != "" {
+		t.Errorf("should fail open, got %+v", e)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		spec  *Spec
+		valid bool
+	}{
+		{&Spec{}, false},
+		{&Spec{External: &ExternalSpec{URL: "http://127.0.0.1"}}, true},
+		{&Spec{Policies: []*PolicySpec{{Name: "p", Action: ActionBlock}}}, false},
+		{&Spec{Policies: []*PolicySpec{{Name: "p", Regexps: []string{"("}, Action: ActionBlock}}}, false},
+		{&Spec{Policies: []*PolicySpec{{Name: "p", MaxSize: 1, Action: ActionBlock, Targets: []string{"x"}}}}, false},
+		{&Spec{Policies: []*PolicySpec{{Name: "p", MaxSize: 1, Action: ActionBlock}, {Name: "p", MaxSize: 1, Action: ActionBlock}}}, false},
+	}
+	for i, c := range cases {
+		if err := c.spec.Validate(); (err == nil) != c.valid {
+			t.Errorf("case %d: unexpected validation result %v", i, err)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if s := truncate("héllo", 2); s != "h" {
+		t.Errorf("unexpected %q", s)
+	}
+	if s := truncate("hello", 10); s != "hello" {
+		t.Errorf("unexpected %q", s)
+	}
+}
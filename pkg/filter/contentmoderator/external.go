@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package contentmoderator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+// policyExternal is the policy name of the external moderation in audit events.
+const policyExternal = "external"
+
+type (
+	// ExternalSpec describes an OpenAI-compatible moderation API.
+	ExternalSpec struct {
+		URL     string            `yaml:"url" jsonschema:"required,format=uri"`
+		Headers map[string]string `yaml:"headers" jsonschema:"omitempty"`
+		Timeout string            `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+		// FailOpen passes requests if the API fails, they are
+		// rejected otherwise.
+		FailOpen bool `yaml:"failOpen" jsonschema:"omitempty"`
+	}
+
+	external struct {
+		spec   *ExternalSpec
+		client *http.Client
+	}
+)
+
+func newExternal(spec *ExternalSpec) *external {
+	timeout, err := time.ParseDuration(spec.Timeout)
+	if err != nil || timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &external{spec: spec, client: &http.Client{Timeout: timeout}}
+}
+
+// flagged returns whether the text is flagged by the moderation API.
+func (e *external) flagged(ctx context.HTTPContext, text string) (bool, error) {
+	body, _ := json.Marshal(map[string]string{"input": text})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.spec.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.spec.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return false, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+	}
+
+	result := &struct {
+		Results []struct {
+			Flagged bool `json:"flagged"`
+		} `json:"results"`
+	}{}
+	if err = json.Unmarshal(body, result); err != nil {
+		return false, fmt.Errorf("unmarshal %s to json failed: %v", body, err)
+	}
+	for _, r := range result.Results {
+		if r.Flagged {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,281 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package contentmoderator
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	finishReasonContentFilter = "content_filter"
+
+	// streamWindow is the size of the tail of streamed completions
+	// kept for matching contents spanning events.
+	streamWindow = 1024
+)
+
+type (
+	// textFunc is called with every text, and the text is replaced
+	// with the returned one.
+	textFunc func(text string) string
+
+	// streamReader moderates completions streamed in server-sent
+	// events of OpenAI-compatible APIs.
+	streamReader struct {
+		body     io.Reader
+		moderate func(text, tail string, size int) (string, bool)
+
+		in      bytes.Buffer
+		out     bytes.Buffer
+		choices map[int]*streamChoice
+		eof     bool
+	}
+
+	streamChoice struct {
+		tail string
+		size int
+	}
+)
+
+// walkText calls fn with v if it's a string, or the text parts if it's
+// an array of content parts, it returns the replaced value.
+func walkText(v interface{}, fn textFunc) interface{} {
+	switch v := v.(type) {
+	case string:
+		return fn(v)
+	case []interface{}:
+		for i, item := range v {
+			switch item := item.(type) {
+			case string:
+				v[i] = fn(item)
+			case map[string]interface{}:
+				if text, ok := item["text"].(string); ok {
+					item["text"] = fn(text)
+				}
+			}
+		}
+	}
+	return v
+}
+
+// walkPrompts calls fn with every prompt of the request body of chat
+// completions, completions, embeddings and moderations.
+func walkPrompts(body map[string]interface{}, fn textFunc) {
+	if messages, ok := body["messages"].([]interface{}); ok {
+		for _, m := range messages {
+			if m, ok := m.(map[string]interface{}); ok && m["content"] != nil {
+				m["content"] = walkText(m["content"], fn)
+			}
+		}
+	}
+	for _, key := range []string{"prompt", "input"} {
+		if body[key] != nil {
+			body[key] = walkText(body[key], fn)
+		}
+	}
+}
+
+// walkChoices calls fn with the content of every choice of the response
+// body, key is message for responses and delta for streamed events,
+// fn returns the new content and whether the choice should be filtered.
+func walkChoices(body map[string]interface{}, key string, fn func(index int, text string) (string, bool)) {
+	choices, _ := body["choices"].([]interface{})
+	for i, c := range choices {
+		choice, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		index := i
+		if f, ok := choice["index"].(float64); ok {
+			index = int(f)
+		}
+
+		var filtered bool
+		if m, ok := choice[key].(map[string]interface{}); ok {
+			if text, ok := m["content"].(string); ok {
+				m["content"], filtered = fn(index, text)
+				if filtered {
+					m["content"] = ""
+				}
+			}
+		} else if text, ok := choice["text"].(string); ok {
+			choice["text"], filtered = fn(index, text)
+			if filtered {
+				choice["text"] = ""
+			}
+		}
+		if filtered {
+			choice["finish_reason"] = finishReasonContentFilter
+		}
+	}
+}
+
+func newStreamReader(body io.Reader, moderate func(text, tail string, size int) (string, bool)) *streamReader {
+	return &streamReader{
+		body:     body,
+		moderate: moderate,
+		choices:  make(map[int]*streamChoice),
+	}
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	buff := make([]byte, 32*1024)
+	for r.out.Len() == 0 && !r.eof {
+		n, err := r.body.Read(buff)
+		r.feed(buff[:n])
+		if err == io.EOF {
+			r.eof = true
+			if r.in.Len() > 0 {
+				r.processLine(r.in.Bytes(), false)
+				r.in.Reset()
+			}
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	if r.out.Len() == 0 {
+		return 0, io.EOF
+	}
+	return r.out.Read(p)
+}
+
+// WriteTo flushes every chunk to the client, see Read.
+func (r *streamReader) WriteTo(w io.Writer) (int64, error) {
+	flusher, _ := w.(http.Flusher)
+	buff := make([]byte, 32*1024)
+
+	var written int64
+	for {
+		n, err := r.Read(buff)
+		if n > 0 {
+			m, werr := w.Write(buff[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, werr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// Close closes the underlying body.
+func (r *streamReader) Close() error {
+	if c, ok := r.body.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (r *streamReader) feed(p []byte) {
+	for len(p) > 0 && !r.eof {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			r.in.Write(p)
+			return
+		}
+		r.in.Write(p[:i])
+		r.processLine(r.in.Bytes(), true)
+		r.in.Reset()
+		p = p[i+1:]
+	}
+}
+
+// processLine moderates a data event, other lines are passed through.
+// The stream is finished with the content_filter finish reason once
+// a choice is blocked.
+func (r *streamReader) processLine(line []byte, newline bool) {
+	writeLine := func(line []byte) {
+		r.out.Write(line)
+		if newline {
+			r.out.WriteByte('\n')
+		}
+	}
+
+	trimmed := bytes.TrimSpace(line)
+	if !bytes.HasPrefix(trimmed, []byte("data:")) {
+		writeLine(line)
+		return
+	}
+	data := bytes.TrimSpace(trimmed[len("data:"):])
+	if len(data) == 0 || data[0] != '{' {
+		writeLine(line)
+		return
+	}
+
+	var event map[string]interface{}
+	if json.Unmarshal(data, &event) != nil {
+		writeLine(line)
+		return
+	}
+
+	changed, blocked := false, false
+	walkChoices(event, "delta", func(index int, text string) (string, bool) {
+		c := r.choices[index]
+		if c == nil {
+			c = &streamChoice{}
+			r.choices[index] = c
+		}
+
+		moderated, filtered := r.moderate(text, c.tail, c.size)
+		c.size += len(text)
+		c.tail += text
+		if len(c.tail) > streamWindow {
+			c.tail = c.tail[len(c.tail)-streamWindow:]
+		}
+
+		changed = changed || moderated != text || filtered
+		blocked = blocked || filtered
+		return moderated, filtered
+	})
+
+	if !changed {
+		writeLine(line)
+		return
+	}
+
+	buff, err := json.Marshal(event)
+	if err != nil {
+		writeLine(line)
+		return
+	}
+	r.out.WriteString("data: ")
+	r.out.Write(buff)
+	r.out.WriteByte('\n')
+
+	if blocked {
+		r.out.WriteString("\ndata: [DONE]\n\n")
+		r.eof = true
+	}
+}
+
+// isJSON returns whether the content type is JSON.
+func isJSON(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/json")
+}
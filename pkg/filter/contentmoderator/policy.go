@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package contentmoderator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// ActionBlock blocks the content.
+	ActionBlock = "Block"
+	// ActionRedact replaces the matched content.
+	ActionRedact = "Redact"
+
+	// TargetPrompt applies the policy to prompts.
+	TargetPrompt = "prompt"
+	// TargetCompletion applies the policy to completions.
+	TargetCompletion = "completion"
+
+	defaultReplacement = "[REDACTED]"
+)
+
+type (
+	// PolicySpec describes a moderation policy.
+	PolicySpec struct {
+		Name     string   `yaml:"name" jsonschema:"required"`
+		Regexps  []string `yaml:"regexps" jsonschema:"omitempty"`
+		Keywords []string `yaml:"keywords" jsonschema:"omitempty"`
+		// MaxSize is the max size in bytes of a text, larger texts
+		// are blocked, or truncated if the action is Redact.
+		MaxSize int    `yaml:"maxSize" jsonschema:"omitempty"`
+		Action  string `yaml:"action" jsonschema:"required,enum=Block,enum=Redact"`
+		// Replacement replaces the matched content if the action is Redact.
+		Replacement string `yaml:"replacement" jsonschema:"omitempty"`
+		// Targets are what the policy applies to, default to both.
+		Targets []string `yaml:"targets" jsonschema:"omitempty,uniqueItems=true"`
+	}
+
+	policy struct {
+		spec       *PolicySpec
+		pattern    *regexp.Regexp
+		prompt     bool
+		completion bool
+	}
+)
+
+// Validate validates PolicySpec.
+func (spec PolicySpec) Validate() error {
+	if spec.MaxSize < 0 {
+		return fmt.Errorf("invalid maxSize %d", spec.MaxSize)
+	}
+	if len(spec.Regexps) == 0 && len(spec.Keywords) == 0 && spec.MaxSize == 0 {
+		return fmt.Errorf("none of regexps, keywords and maxSize is specified")
+	}
+	for _, r := range spec.Regexps {
+		if _, err := regexp.Compile(r); err != nil {
+			return fmt.Errorf("invalid regexp %s: %v", r, err)
+		}
+	}
+	for _, t := range spec.Targets {
+		if t != TargetPrompt && t != TargetCompletion {
+			return fmt.Errorf("invalid target %s", t)
+		}
+	}
+	return nil
+}
+
+func newPolicy(spec *PolicySpec) *policy {
+	p := &policy{spec: spec}
+
+	var alternatives []string
+	for _, r := range spec.Regexps {
+		alternatives = append(alternatives, "(?:"+r+")")
+	}
+	for _, k := range spec.Keywords {
+		alternatives = append(alternatives, `(?i:\b`+regexp.QuoteMeta(k)+`\b)`)
+	}
+	if len(alternatives) > 0 {
+		p.pattern = regexp.MustCompile(strings.Join(alternatives, "|"))
+	}
+
+	if len(spec.Targets) == 0 {
+		p.prompt, p.completion = true, true
+	}
+	for _, t := range spec.Targets {
+		switch t {
+		case TargetPrompt:
+			p.prompt = true
+		case TargetCompletion:
+			p.completion = true
+		}
+	}
+	return p
+}
+
+func (p *policy) appliesTo(target string) bool {
+	if target == TargetPrompt {
+		return p.prompt
+	}
+	return p.completion
+}
+
+// overSize returns whether the text is larger than MaxSize.
+func (p *policy) overSize(text string) bool {
+	return p.spec.MaxSize > 0 && len(text) > p.spec.MaxSize
+}
+
+// matches returns the number of matched contents of the text.
+func (p *policy) matches(text string) int {
+	if p.pattern == nil {
+		return 0
+	}
+	return len(p.pattern.FindAllStringIndex(text, -1))
+}
+
+// redact truncates the text to MaxSize and replaces the matched contents.
+func (p *policy) redact(text string) string {
+	if p.overSize(text) {
+		text = truncate(text, p.spec.MaxSize)
+	}
+	return p.redactMatches(text)
+}
+
+func (p *policy) redactMatches(text string) string {
+	if p.pattern == nil {
+		return text
+	}
+
+	replacement := p.spec.Replacement
+	if replacement == "" {
+		replacement = defaultReplacement
+	}
+	return p.pattern.ReplaceAllLiteralString(text, replacement)
+}
+
+// truncate truncates the text to at most size bytes, without
+// breaking UTF-8 characters.
+func truncate(text string, size int) string {
+	if size >= len(text) {
+		return text
+	}
+	for size > 0 && text[size]&0xC0 == 0x80 {
+		size--
+	}
+	return text[:size]
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package deprecationenforcer
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/deprecationpolicy"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/consumer"
+)
+
+const (
+	// Kind is the kind of DeprecationEnforcer.
+	Kind = "DeprecationEnforcer"
+
+	resultGone      = "gone"
+	resultThrottled = "throttled"
+
+	headerDeprecation = "Deprecation"
+	headerSunset      = "Sunset"
+	headerLink        = "Link"
+	headerWarning     = "Warning"
+)
+
+var results = []string{resultGone, resultThrottled}
+
+func init() {
+	httppipeline.Register(&DeprecationEnforcer{})
+}
+
+type (
+	// DeprecationEnforcer enforces the DeprecationPolicies of requests.
+	DeprecationEnforcer struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		resolve consumer.Resolver
+		// now is replaced in tests.
+		now func() time.Time
+	}
+
+	// Spec describes the DeprecationEnforcer.
+	Spec struct {
+		// Consumer is where the consumer of usages is resolved from.
+		Consumer *consumer.Spec `yaml:"consumer" jsonschema:"omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if spec.Consumer != nil {
+		if err := spec.Consumer.Validate(); err != nil {
+			return fmt.Errorf("consumer: %v", err)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of DeprecationEnforcer.
+func (de *DeprecationEnforcer) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of DeprecationEnforcer.
+func (de *DeprecationEnforcer) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of DeprecationEnforcer.
+func (de *DeprecationEnforcer) Description() string {
+	return "DeprecationEnforcer adds deprecation headers and applies sunset actions by DeprecationPolicies."
+}
+
+// Results returns the results of DeprecationEnforcer.
+func (de *DeprecationEnforcer) Results() []string {
+	return results
+}
+
+// Init initializes DeprecationEnforcer.
+func (de *DeprecationEnforcer) Init(filterSpec *httppipeline.FilterSpec) {
+	de.filterSpec, de.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	de.reload()
+}
+
+// Inherit inherits previous generation of DeprecationEnforcer.
+func (de *DeprecationEnforcer) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	de.Init(filterSpec)
+}
+
+func (de *DeprecationEnforcer) reload() {
+	if de.spec.Consumer != nil {
+		de.resolve = consumer.NewResolver(de.spec.Consumer)
+	} else {
+		de.resolve = func(ctx context.HTTPContext) string { return "" }
+	}
+	de.now = time.Now
+}
+
+// Handle handles HTTPContext.
+func (de *DeprecationEnforcer) Handle(ctx context.HTTPContext) string {
+	result := de.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (de *DeprecationEnforcer) handle(ctx context.HTTPContext) string {
+	r, w := ctx.Request(), ctx.Response()
+	p := deprecationpolicy.Match(r.Method(), r.Path())
+	if p == nil {
+		return ""
+	}
+
+	// NOTE: The headers are added before the deprecation date too,
+	// which tells clients the routes will be deprecated.
+	header := w.Header()
+	header.Set(headerDeprecation, "@"+strconv.FormatInt(p.DeprecatedAt.Unix(), 10))
+	if !p.SunsetAt.IsZero() {
+		header.Set(headerSunset, p.SunsetAt.UTC().Format(http.TimeFormat))
+	}
+	if p.Spec.Link != "" {
+		header.Add(headerLink, fmt.Sprintf(`<%s>; rel="deprecation"; type="text/html"`, p.Spec.Link))
+	}
+
+	now := de.now()
+	if !p.Deprecated(now) {
+		return ""
+	}
+
+	c := de.resolve(ctx)
+	p.Record(c)
+
+	afterSunset := p.Spec.AfterSunset
+	if !p.Sunset(now) || afterSunset == nil {
+		return ""
+	}
+
+	if afterSunset.Gone {
+		ctx.AddTag(fmt.Sprintf("deprecationEnforcer: %s is gone by policy %s", r.Path(), p.Name))
+		w.SetStatusCode(http.StatusGone)
+		return resultGone
+	}
+	if !p.Acquire(c) {
+		ctx.AddTag(fmt.Sprintf("deprecationEnforcer: %s is throttled by policy %s", r.Path(), p.Name))
+		w.SetStatusCode(http.StatusTooManyRequests)
+		return resultThrottled
+	}
+	if afterSunset.Warning != "" {
+		header.Add(headerWarning, fmt.Sprintf("299 - %s", strconv.Quote(afterSunset.Warning)))
+	}
+	return ""
+}
+
+// Status returns status.
+func (de *DeprecationEnforcer) Status() interface{} {
+	return nil
+}
+
+// Close closes DeprecationEnforcer.
+func (de *DeprecationEnforcer) Close() {}
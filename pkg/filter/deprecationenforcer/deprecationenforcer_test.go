@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package deprecationenforcer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/deprecationpolicy"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newPolicy(t *testing.T, yamlConfig string) *deprecationpolicy.DeprecationPolicy {
+	spec, err := supervisor.NewSpec(yamlConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dp := &deprecationpolicy.DeprecationPolicy{}
+	dp.Init(spec)
+	return dp
+}
+
+func newDeprecationEnforcer(t *testing.T) *DeprecationEnforcer {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(`
+kind: DeprecationEnforcer
+name: deprecation-enforcer
+consumer:
+  source: Header
+  name: X-Consumer
+`), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	de := &DeprecationEnforcer{}
+	de.Init(spec)
+	return de
+}
+
+func handle(de *DeprecationEnforcer, consumer, path string) (string, int, http.Header) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("X-Consumer", consumer)
+	w := httptest.NewRecorder()
+
+	result := de.Handle(contexttest.NewMockedHTTPContext(req, w))
+	return result, w.Code, w.Header()
+}
+
+func TestDeprecationEnforcer(t *testing.T) {
+	v1 := newPolicy(t, `
+kind: DeprecationPolicy
+name: v1
+pathPrefixes: [/api/v1]
+deprecatedAt: 2030-01-01T00:00:00Z
+sunsetAt: 2030-07-01T00:00:00Z
+link: https://example.com/migration
+afterSunset:
+  warning: API v1 is retired
+  rateLimit:
+    limitRefreshPeriod: 1h
+    limitForPeriod: 1
+`)
+	defer v1.Close()
+	legacy := newPolicy(t, `
+kind: DeprecationPolicy
+name: legacy
+pathPrefixes: [/legacy]
+deprecatedAt: 2020-01-01T00:00:00Z
+sunsetAt: 2020-07-01T00:00:00Z
+afterSunset:
+  gone: true
+`)
+	defer legacy.Close()
+
+	de := newDeprecationEnforcer(t)
+	defer de.Close()
+
+	de.now = func() time.Time { return time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC) }
+	result, code, header := handle(de, "alice", "/api/v1/users")
+	if result != "" || code != http.StatusOK {
+		t.Fatalf("unexpected result %s and code %d", result, code)
+	}
+	if header.Get("Deprecation") != "@1893456000" ||
+		header.Get("Sunset") != "Mon, 01 Jul 2030 00:00:00 GMT" ||
+		header.Get("Link") != `<https://example.com/migration>; rel="deprecation"; type="text/html"` {
+		t.Fatalf("unexpected headers: %v", header)
+	}
+
+	de.now = func() time.Time { return time.Date(2030, 3, 1, 0, 0, 0, 0, time.UTC) }
+	handle(de, "alice", "/api/v1/users")
+	handle(de, "alice", "/api/v1/users")
+	if result, _, header = handle(de, "bob", "/api/v1/users"); result != "" || header.Get("Warning") != "" {
+		t.Fatalf("requests before the sunset should pass without warnings")
+	}
+
+	de.now = func() time.Time { return time.Date(2030, 8, 1, 0, 0, 0, 0, time.UTC) }
+	result, _, header = handle(de, "bob", "/api/v1/users")
+	if result != "" || header.Get("Warning") != `299 - "API v1 is retired"` {
+		t.Fatalf("unexpected result %s and headers %v", result, header)
+	}
+	if result, code, _ = handle(de, "bob", "/api/v1/users"); result != resultThrottled || code != http.StatusTooManyRequests {
+		t.Fatalf("unexpected result %s and code %d", result, code)
+	}
+
+	if result, code, _ = handle(de, "", "/legacy/users"); result != resultGone || code != http.StatusGone {
+		t.Fatalf("unexpected result %s and code %d", result, code)
+	}
+
+	if result, _, header = handle(de, "alice", "/api/v2/users"); result != "" || header.Get("Deprecation") != "" {
+		t.Fatalf("routes without policies should not be affected")
+	}
+
+	usages := v1.Status().ObjectStatus.(*deprecationpolicy.Status).Usages
+	if usages["alice"] != 2 || usages["bob"] != 3 {
+		t.Fatalf("unexpected usages: %v", usages)
+	}
+	usages = legacy.Status().ObjectStatus.(*deprecationpolicy.Status).Usages
+	if usages[deprecationpolicy.Anonymous] != 1 {
+		t.Fatalf("unexpected usages: %v", usages)
+	}
+}
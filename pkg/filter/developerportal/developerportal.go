@@ -0,0 +1,405 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package developerportal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filter/audittrail"
+	"github.com/megaease/easegress/pkg/object/apiproduct"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/consumer"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const (
+	// Kind is the kind of DeveloperPortal.
+	Kind = "DeveloperPortal"
+
+	resultServed = "served"
+
+	maxBodySize = 64 * 1024
+)
+
+var results = []string{resultServed}
+
+func init() {
+	httppipeline.Register(&DeveloperPortal{})
+}
+
+type (
+	// DeveloperPortal serves the self-service endpoints of consumers,
+	// consumers register apps, obtain API keys and view their own
+	// subscription and usage.
+	DeveloperPortal struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		resolve consumer.Resolver
+	}
+
+	// Spec describes the DeveloperPortal.
+	Spec struct {
+		// PathPrefix is the path prefix of the endpoints, other
+		// requests pass through.
+		PathPrefix string `yaml:"pathPrefix" jsonschema:"required,pattern=^/"`
+		// Consumer is where the consumer identity is resolved from, it
+		// must be authenticated by filters before the DeveloperPortal.
+		Consumer *consumer.Spec `yaml:"consumer" jsonschema:"required"`
+		// MaxApps is the max number of apps of a consumer.
+		MaxApps int `yaml:"maxApps" jsonschema:"omitempty,minimum=1"`
+		// DefaultPlan is subscribed to when a consumer without any
+		// subscription registers an app.
+		DefaultPlan string `yaml:"defaultPlan" jsonschema:"omitempty"`
+		// AuditTrail is the name of the AuditTrail filter in the same
+		// pipeline, the usage endpoint is disabled if it's empty.
+		AuditTrail string `yaml:"auditTrail" jsonschema:"omitempty"`
+	}
+
+	// AppWithKey is an app with its API key, the key is only
+	// returned when it's generated.
+	AppWithKey struct {
+		*apiproduct.App
+		APIKey string `json:"apiKey"`
+	}
+
+	// Subscription is the subscription of a consumer.
+	Subscription struct {
+		Plan        string                `json:"plan"`
+		Description string                `json:"description,omitempty"`
+		Products    []string              `json:"products"`
+		Features    []string              `json:"features,omitempty"`
+		RateLimit   *apiproduct.RateLimit `json:"rateLimit,omitempty"`
+		Quota       *QuotaUsage           `json:"quota,omitempty"`
+	}
+
+	// QuotaUsage is the usage of the quota in the current period,
+	// it's synchronized among members every few seconds.
+	QuotaUsage struct {
+		Requests    int64     `json:"requests"`
+		Used        int64     `json:"used"`
+		Remaining   int64     `json:"remaining"`
+		PeriodStart time.Time `json:"periodStart"`
+		PeriodEnd   time.Time `json:"periodEnd"`
+	}
+
+	// Error is the body of error responses.
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+
+	// response is the response of an endpoint.
+	response struct {
+		code int
+		body interface{}
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if strings.HasSuffix(spec.PathPrefix, "/") && spec.PathPrefix != "/" {
+		return fmt.Errorf("pathPrefix must not end with /")
+	}
+	return spec.Consumer.Validate()
+}
+
+// Kind returns the kind of DeveloperPortal.
+func (dp *DeveloperPortal) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of DeveloperPortal.
+func (dp *DeveloperPortal) DefaultSpec() interface{} {
+	return &Spec{MaxApps: 10}
+}
+
+// Description returns the description of DeveloperPortal.
+func (dp *DeveloperPortal) Description() string {
+	return "DeveloperPortal serves the endpoints for consumers to register apps, obtain API keys and view their usage."
+}
+
+// Results returns the results of DeveloperPortal.
+func (dp *DeveloperPortal) Results() []string {
+	return results
+}
+
+// Init initializes DeveloperPortal.
+func (dp *DeveloperPortal) Init(filterSpec *httppipeline.FilterSpec) {
+	dp.filterSpec, dp.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	dp.resolve = consumer.NewResolver(dp.spec.Consumer)
+}
+
+// Inherit inherits previous generation of DeveloperPortal.
+func (dp *DeveloperPortal) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	dp.Init(filterSpec)
+}
+
+// Handle handles HTTPContext.
+func (dp *DeveloperPortal) Handle(ctx context.HTTPContext) string {
+	result := dp.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (dp *DeveloperPortal) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+
+	path := r.Path()
+	prefix := strings.TrimSuffix(dp.spec.PathPrefix, "/")
+	if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+		return ""
+	}
+
+	var resp *response
+	c := dp.resolve(ctx)
+	if c == "" {
+		resp = errorResponse(http.StatusUnauthorized, fmt.Errorf("consumer is not authenticated"))
+	} else {
+		resp = dp.route(ctx, c, strings.Trim(strings.TrimPrefix(path, prefix), "/"))
+	}
+
+	dp.write(ctx, resp)
+	return resultServed
+}
+
+// route dispatches the request to the endpoint:
+//
+//	GET    apps
+//	POST   apps
+//	DELETE apps/{app}
+//	POST   apps/{app}/key
+//	GET    subscription
+//	GET    usage
+func (dp *DeveloperPortal) route(ctx context.HTTPContext, c, path string) *response {
+	method := ctx.Request().Method()
+	segments := strings.Split(path, "/")
+
+	var allowed string
+	switch {
+	case path == "apps":
+		allowed = "GET, POST"
+		switch method {
+		case http.MethodGet:
+			return dp.listApps(c)
+		case http.MethodPost:
+			return dp.registerApp(ctx, c)
+		}
+	case len(segments) == 2 && segments[0] == "apps":
+		allowed = "DELETE"
+		if method == http.MethodDelete {
+			return dp.deleteApp(c, segments[1])
+		}
+	case len(segments) == 3 && segments[0] == "apps" && segments[2] == "key":
+		allowed = "POST"
+		if method == http.MethodPost {
+			return dp.rotateKey(c, segments[1])
+		}
+	case path == "subscription":
+		allowed = "GET"
+		if method == http.MethodGet {
+			return dp.getSubscription(c)
+		}
+	case path == "usage":
+		allowed = "GET"
+		if method == http.MethodGet {
+			return dp.getUsage(ctx, c)
+		}
+	default:
+		return errorResponse(http.StatusNotFound, fmt.Errorf("%s not found", path))
+	}
+
+	ctx.Response().Header().Set("Allow", allowed)
+	return errorResponse(http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", method))
+}
+
+func (dp *DeveloperPortal) listApps(c string) *response {
+	apps, err := apiproduct.Apps(c)
+	if err != nil {
+		return errorResponse(http.StatusServiceUnavailable, err)
+	}
+	return &response{code: http.StatusOK, body: apps}
+}
+
+func (dp *DeveloperPortal) registerApp(ctx context.HTTPContext, c string) *response {
+	body, err := ioutil.ReadAll(io.LimitReader(ctx.Request().Body(), maxBodySize+1))
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, fmt.Errorf("read body failed: %v", err))
+	}
+	if len(body) > maxBodySize {
+		return errorResponse(http.StatusRequestEntityTooLarge, fmt.Errorf("body exceeds %d bytes", maxBodySize))
+	}
+
+	req := &struct {
+		Name string `json:"name"`
+	}{}
+	if err = json.Unmarshal(body, req); err != nil {
+		return errorResponse(http.StatusBadRequest, fmt.Errorf("unmarshal body failed: %v", err))
+	}
+
+	apps, err := apiproduct.Apps(c)
+	if err != nil {
+		return errorResponse(http.StatusServiceUnavailable, err)
+	}
+	if dp.spec.MaxApps > 0 && len(apps) >= dp.spec.MaxApps {
+		return errorResponse(http.StatusConflict, fmt.Errorf("at most %d apps are allowed", dp.spec.MaxApps))
+	}
+
+	if dp.spec.DefaultPlan != "" {
+		if name, _ := apiproduct.SubscribedPlan(c); name == "" {
+			if err = apiproduct.Subscribe(c, dp.spec.DefaultPlan); err != nil {
+				return errorResponse(http.StatusServiceUnavailable, err)
+			}
+		}
+	}
+
+	app, key, err := apiproduct.RegisterApp(c, req.Name)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, err)
+	}
+	return &response{code: http.StatusCreated, body: &AppWithKey{App: app, APIKey: key}}
+}
+
+func (dp *DeveloperPortal) deleteApp(c, name string) *response {
+	if err := apiproduct.DeleteApp(c, name); err != nil {
+		return errorResponse(http.StatusNotFound, err)
+	}
+	return &response{code: http.StatusNoContent}
+}
+
+func (dp *DeveloperPortal) rotateKey(c, name string) *response {
+	app, key, err := apiproduct.RotateAPIKey(c, name)
+	if err != nil {
+		return errorResponse(http.StatusNotFound, err)
+	}
+	return &response{code: http.StatusOK, body: &AppWithKey{App: app, APIKey: key}}
+}
+
+func (dp *DeveloperPortal) getSubscription(c string) *response {
+	name, plan := apiproduct.SubscribedPlan(c)
+	if plan == nil {
+		return errorResponse(http.StatusNotFound, fmt.Errorf("no subscription"))
+	}
+
+	s := &Subscription{
+		Plan:        name,
+		Description: plan.Description,
+		Products:    plan.Products,
+		Features:    plan.Features,
+		RateLimit:   plan.RateLimit,
+	}
+	if plan.Quota != nil {
+		start, end := plan.Quota.Window(time.Now())
+		used := apiproduct.QuotaUsed(name, c, start)
+		remaining := plan.Quota.Requests - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		s.Quota = &QuotaUsage{
+			Requests:    plan.Quota.Requests,
+			Used:        used,
+			Remaining:   remaining,
+			PeriodStart: start,
+			PeriodEnd:   end,
+		}
+	}
+
+	return &response{code: http.StatusOK, body: s}
+}
+
+// getUsage returns the usage of the consumer recorded by the AuditTrail
+// on the local member, the period is specified by the since and until
+// (RFC3339) query parameters, default to the last 24 hours.
+func (dp *DeveloperPortal) getUsage(ctx context.HTTPContext, c string) *response {
+	if dp.spec.AuditTrail == "" {
+		return errorResponse(http.StatusNotFound, fmt.Errorf("usage is not enabled"))
+	}
+
+	values, err := url.ParseQuery(ctx.Request().Query())
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, err)
+	}
+
+	now := time.Now()
+	q := &audittrail.Query{
+		Since:       now.Add(-24 * time.Hour),
+		Until:       now,
+		Consumer:    c,
+		HasConsumer: true,
+	}
+	if v := values.Get("since"); v != "" {
+		if q.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return errorResponse(http.StatusBadRequest, fmt.Errorf("invalid since: %v", err))
+		}
+	}
+	if v := values.Get("until"); v != "" {
+		if q.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return errorResponse(http.StatusBadRequest, fmt.Errorf("invalid until: %v", err))
+		}
+	}
+
+	usages, err := audittrail.Usages(dp.filterSpec.Pipeline(), dp.spec.AuditTrail, q)
+	if err != nil {
+		return errorResponse(http.StatusServiceUnavailable, err)
+	}
+
+	usage := &audittrail.Usage{
+		Consumer: c,
+		Classes:  map[string]uint64{},
+		Routes:   map[string]uint64{},
+	}
+	if len(usages) > 0 {
+		usage = usages[0]
+	}
+	return &response{code: http.StatusOK, body: usage}
+}
+
+func errorResponse(code int, err error) *response {
+	return &response{code: code, body: &Error{Code: code, Message: err.Error()}}
+}
+
+func (dp *DeveloperPortal) write(ctx context.HTTPContext, resp *response) {
+	w := ctx.Response()
+	w.SetStatusCode(resp.code)
+	if resp.body == nil {
+		return
+	}
+
+	buff, err := json.Marshal(resp.body)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to json failed: %v", resp.body, err))
+	}
+	w.Header().Set(httpheader.KeyContentType, "application/json")
+	w.SetBody(bytes.NewReader(buff))
+}
+
+// Status returns status.
+func (dp *DeveloperPortal) Status() interface{} {
+	return nil
+}
+
+// Close closes DeveloperPortal.
+func (dp *DeveloperPortal) Close() {}
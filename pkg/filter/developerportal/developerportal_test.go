@@ -0,0 +1,208 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package developerportal
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/filter/audittrail"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/apiproduct"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newFilterSpec(t *testing.T, yamlSpec string) *httppipeline.FilterSpec {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return spec
+}
+
+func newDeveloperPortal(t *testing.T) *DeveloperPortal {
+	dp := &DeveloperPortal{}
+	dp.Init(newFilterSpec(t, `
+kind: DeveloperPortal
+name: portal
+pathPrefix: /portal
+consumer:
+  source: Header
+  name: X-Consumer
+auditTrail: audit
+`))
+	return dp
+}
+
+type result struct {
+	result string
+	code   int
+	body   map[string]interface{}
+}
+
+func handle(dp *DeveloperPortal, consumer, method, path, body string) *result {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if consumer != "" {
+		req.Header.Set("X-Consumer", consumer)
+	}
+	w := httptest.NewRecorder()
+
+	ctx := contexttest.NewMockedHTTPContext(req, w)
+	r := &result{result: dp.Handle(ctx), code: w.Code}
+	buff, _ := ioutil.ReadAll(ctx.Response().Body())
+	json.Unmarshal(buff, &r.body)
+	return r
+}
+
+func TestRoute(t *testing.T) {
+	dp := newDeveloperPortal(t)
+	defer dp.Close()
+
+	r := handle(dp, "alice", http.MethodGet, "/orders", "")
+	if r.result != "" || r.code != http.StatusOK {
+		t.Errorf("requests out of the prefix should pass through, got %v", r)
+	}
+	r = handle(dp, "alice", http.MethodGet, "/portalx/apps", "")
+	if r.result != "" {
+		t.Errorf("requests out of the prefix should pass through, got %v", r)
+	}
+
+	cases := []struct {
+		consumer string
+		method   string
+		path     string
+		code     int
+	}{
+		{"", http.MethodGet, "/portal/apps", http.StatusUnauthorized},
+		{"alice", http.MethodGet, "/portal", http.StatusNotFound},
+		{"alice", http.MethodGet, "/portal/unknown", http.StatusNotFound},
+		{"alice", http.MethodPut, "/portal/apps", http.StatusMethodNotAllowed},
+		{"alice", http.MethodGet, "/portal/apps/app1", http.StatusMethodNotAllowed},
+		{"alice", http.MethodGet, "/portal/apps/app1/key", http.StatusMethodNotAllowed},
+		// NOTE: The key-value store is unavailable without a cluster.
+		{"alice", http.MethodGet, "/portal/apps", http.StatusServiceUnavailable},
+		{"alice", http.MethodPost, "/portal/apps/app1/key", http.StatusNotFound},
+		{"alice", http.MethodGet, "/portal/subscription", http.StatusNotFound},
+		{"alice", http.MethodGet, "/portal/usage", http.StatusServiceUnavailable},
+	}
+	for _, c := range cases {
+		r := handle(dp, c.consumer, c.method, c.path, "")
+		if r.result != resultServed {
+			t.Errorf("%s %s: expected result %s, got %s", c.method, c.path, resultServed, r.result)
+		}
+		if r.code != c.code {
+			t.Errorf("%s %s: expected code %d, got %d", c.method, c.path, c.code, r.code)
+		}
+		if r.body["code"] != float64(c.code) || r.body["message"] == "" {
+			t.Errorf("%s %s: unexpected body %v", c.method, c.path, r.body)
+		}
+	}
+
+	r = handle(dp, "alice", http.MethodPost, "/portal/apps", "{")
+	if r.code != http.StatusBadRequest {
+		t.Errorf("expected code %d, got %d", http.StatusBadRequest, r.code)
+	}
+	r = handle(dp, "alice", http.MethodPost, "/portal/apps", `{"name": "`+string(bytes.Repeat([]byte("a"), maxBodySize))+`"}`)
+	if r.code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected code %d, got %d", http.StatusRequestEntityTooLarge, r.code)
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	dp := newDeveloperPortal(t)
+	defer dp.Close()
+
+	product := &apiproduct.APIProduct{}
+	spec, _ := supervisor.NewSpec("kind: APIProduct\nname: orders\npathPrefixes: [/orders]")
+	product.Init(spec)
+	defer product.Close()
+
+	plan := &apiproduct.Plan{}
+	spec, err := supervisor.NewSpec(`
+kind: Plan
+name: free
+products: [orders]
+quota:
+  requests: 100
+  period: 24h
+features: [export]
+consumers: [alice]
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plan.Init(spec)
+	defer plan.Close()
+
+	r := handle(dp, "alice", http.MethodGet, "/portal/subscription", "")
+	if r.code != http.StatusOK {
+		t.Fatalf("expected code %d, got %d", http.StatusOK, r.code)
+	}
+	if r.body["plan"] != "free" {
+		t.Errorf("expected plan free, got %v", r.body["plan"])
+	}
+	quota, _ := r.body["quota"].(map[string]interface{})
+	if quota["requests"] != float64(100) || quota["used"] != float64(0) || quota["remaining"] != float64(100) {
+		t.Errorf("unexpected quota %v", quota)
+	}
+
+	r = handle(dp, "bob", http.MethodGet, "/portal/subscription", "")
+	if r.code != http.StatusNotFound {
+		t.Errorf("expected code %d, got %d", http.StatusNotFound, r.code)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	dp := newDeveloperPortal(t)
+	defer dp.Close()
+
+	a := &audittrail.AuditTrail{}
+	a.Init(newFilterSpec(t, `
+kind: AuditTrail
+name: audit
+consumer:
+  source: Header
+  name: X-Consumer
+dir: `+t.TempDir()))
+	defer a.Close()
+
+	r := handle(dp, "alice", http.MethodGet, "/portal/usage", "")
+	if r.code != http.StatusOK {
+		t.Fatalf("expected code %d, got %d: %v", http.StatusOK, r.code, r.body)
+	}
+	if r.body["consumer"] != "alice" || r.body["requests"] != float64(0) {
+		t.Errorf("unexpected usage %v", r.body)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package earlyhints
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const (
+	// Kind is the kind of EarlyHints.
+	Kind = "EarlyHints"
+)
+
+var results = []string{}
+
+func init() {
+	httppipeline.Register(&EarlyHints{})
+}
+
+type (
+	// EarlyHints sends the links to preload or preconnect by 103 Early
+	// Hints, so browsers fetch them while the following filters are
+	// handling the request.
+	EarlyHints struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		pushTargets []string
+
+		hinted, pushed, failed uint64
+	}
+
+	// Spec describes the EarlyHints.
+	Spec struct {
+		// Links are the values of Link headers, like
+		// </style.css>; rel=preload; as=style.
+		Links []string `yaml:"links" jsonschema:"required,minItems=1"`
+		// HTMLOnly limits the hints to the requests accepting HTML.
+		HTMLOnly bool `yaml:"htmlOnly" jsonschema:"omitempty"`
+		// Push pushes the preload links of local paths by HTTP/2 server
+		// push, if clients enable it.
+		Push bool `yaml:"push" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of EarlyHints.
+	Status struct {
+		Hinted uint64 `yaml:"hinted"`
+		Pushed uint64 `yaml:"pushed"`
+		Failed uint64 `yaml:"failed"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	for _, link := range spec.Links {
+		if _, _, err := parseLink(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseLink returns the target and the rel of the link.
+// Reference: https://tools.ietf.org/html/rfc8288#section-3
+func parseLink(link string) (string, string, error) {
+	link = strings.TrimSpace(link)
+	end := strings.IndexByte(link, '>')
+	if !strings.HasPrefix(link, "<") || end < 0 {
+		return "", "", fmt.Errorf("invalid link %s: target must be in angle brackets", link)
+	}
+
+	target, rel := link[1:end], ""
+	for _, param := range strings.Split(link[end+1:], ";") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "rel") {
+			rel = strings.ToLower(strings.Trim(strings.TrimSpace(kv[1]), `"`))
+		}
+	}
+	if rel == "" {
+		return "", "", fmt.Errorf("invalid link %s: rel is required", link)
+	}
+	return target, rel, nil
+}
+
+// Kind returns the kind of EarlyHints.
+func (eh *EarlyHints) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of EarlyHints.
+func (eh *EarlyHints) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of EarlyHints.
+func (eh *EarlyHints) Description() string {
+	return "EarlyHints sends links to preload or preconnect by 103 Early Hints and HTTP/2 server push."
+}
+
+// Results returns the results of EarlyHints.
+func (eh *EarlyHints) Results() []string {
+	return results
+}
+
+// Init initializes EarlyHints.
+func (eh *EarlyHints) Init(filterSpec *httppipeline.FilterSpec) {
+	eh.filterSpec, eh.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	eh.reload()
+}
+
+// Inherit inherits previous generation of EarlyHints.
+func (eh *EarlyHints) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	eh.Init(filterSpec)
+}
+
+func (eh *EarlyHints) reload() {
+	eh.pushTargets = nil
+	if !eh.spec.Push {
+		return
+	}
+
+	// NOTE: Only the resources of the same origin could be pushed.
+	for _, link := range eh.spec.Links {
+		target, rel, _ := parseLink(link)
+		if rel == "preload" && strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") {
+			eh.pushTargets = append(eh.pushTargets, target)
+		}
+	}
+}
+
+// Handle handles HTTPContext.
+func (eh *EarlyHints) Handle(ctx context.HTTPContext) string {
+	result := eh.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (eh *EarlyHints) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	if eh.spec.HTMLOnly && !strings.Contains(r.Header().Get(httpheader.KeyAccept), "text/html") {
+		return ""
+	}
+
+	if pusher, ok := ctx.Response().Std().(http.Pusher); ok && len(eh.pushTargets) > 0 {
+		for _, target := range eh.pushTargets {
+			if err := pusher.Push(target, nil); err != nil {
+				// NOTE: http.ErrNotSupported means the client disables
+				// server push, the hints are still sent.
+				if err != http.ErrNotSupported {
+					ctx.AddTag(fmt.Sprintf("earlyHints: push %s failed: %v", target, err))
+				}
+				break
+			}
+			atomic.AddUint64(&eh.pushed, 1)
+		}
+	}
+
+	header := http.Header{"Link": eh.spec.Links}
+	if err := ctx.Response().WriteEarlyHints(header); err != nil {
+		atomic.AddUint64(&eh.failed, 1)
+		ctx.AddTag(fmt.Sprintf("earlyHints: %v", err))
+		return ""
+	}
+	atomic.AddUint64(&eh.hinted, 1)
+
+	// NOTE: The links are in the final response too, for the clients
+	// and intermediaries ignoring informational responses.
+	w := ctx.Response().Header()
+	for _, link := range eh.spec.Links {
+		w.Add("Link", link)
+	}
+	return ""
+}
+
+// Status returns status.
+func (eh *EarlyHints) Status() interface{} {
+	return &Status{
+		Hinted: atomic.LoadUint64(&eh.hinted),
+		Pushed: atomic.LoadUint64(&eh.pushed),
+		Failed: atomic.LoadUint64(&eh.failed),
+	}
+}
+
+// Close closes EarlyHints.
+func (eh *EarlyHints) Close() {}
@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package earlyhints
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newEarlyHints(t *testing.T, yamlSpec string) *EarlyHints {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	eh := &EarlyHints{}
+	eh.Init(spec)
+	return eh
+}
+
+const yamlSpec = `
+kind: EarlyHints
+name: early-hints
+htmlOnly: true
+push: true
+links:
+- "</style.css>; rel=preload; as=style"
+- "<https://cdn.example.com>; rel=preconnect"
+- "<//cdn.example.com/app.js>; rel=preload; as=script"
+`
+
+// get sends a request to a server handling it by eh, and returns the
+// early hints and the final response.
+func get(t *testing.T, eh *EarlyHints, accept string) ([]http.Header, *http.Response) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.New(w, r, tracing.NoopTracing, "test")
+		ctx.SetHandlerCaller(func(lastResult string) string {
+			ctx.Response().Header().Set("X-Final", "yes")
+			return lastResult
+		})
+		ctx.Response().Header().Set("X-Before", "yes")
+		eh.Handle(ctx)
+		ctx.Finish()
+	}))
+	defer server.Close()
+
+	var hints []http.Header
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				hints = append(hints, http.Header(header))
+			}
+			return nil
+		},
+	}
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	req.Header.Set("Accept", accept)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	return hints, resp
+}
+
+func TestEarlyHints(t *testing.T) {
+	eh := newEarlyHints(t, yamlSpec)
+
+	hints, resp := get(t, eh, "text/html,application/xhtml+xml")
+	if !context.EarlyHintsSupported {
+		if len(hints) != 0 || resp.StatusCode != http.StatusOK || resp.Header.Get("X-Final") != "yes" {
+			t.Fatalf("the final response should be intact without early hints")
+		}
+		if eh.Status().(*Status).Failed != 1 {
+			t.Errorf("expected 1 failure")
+		}
+		return
+	}
+
+	if len(hints) != 1 {
+		t.Fatalf("expected 1 early hints, got %d", len(hints))
+	}
+	if !reflect.DeepEqual(hints[0]["Link"], eh.spec.Links) {
+		t.Errorf("unexpected links: %v", hints[0]["Link"])
+	}
+	if hints[0].Get("X-Before") != "" {
+		t.Errorf("header of the final response is in early hints")
+	}
+	if resp.Header.Get("X-Before") != "yes" || resp.Header.Get("X-Final") != "yes" {
+		t.Errorf("header of the final response is lost: %v", resp.Header)
+	}
+	if !reflect.DeepEqual(resp.Header["Link"], eh.spec.Links) {
+		t.Errorf("unexpected links of the final response: %v", resp.Header["Link"])
+	}
+
+	hints, resp = get(t, eh, "application/json")
+	if len(hints) != 0 || resp.Header.Get("Link") != "" {
+		t.Errorf("early hints should be sent to HTML requests only")
+	}
+
+	status := eh.Status().(*Status)
+	if status.Hinted != 1 || status.Failed != 0 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestHTTP10(t *testing.T) {
+	eh := newEarlyHints(t, yamlSpec)
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	stdr.Proto, stdr.ProtoMajor, stdr.ProtoMinor = "HTTP/1.0", 1, 0
+	stdr.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	ctx := context.New(w, stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string { return lastResult })
+	eh.Handle(ctx)
+	ctx.Finish()
+
+	if w.Code != http.StatusOK {
+		t.Errorf("early hints should not be sent to HTTP/1.0 clients")
+	}
+	if eh.Status().(*Status).Failed != 1 {
+		t.Errorf("expected 1 failure")
+	}
+}
+
+func TestEarlyHintsAfterFinish(t *testing.T) {
+	if !context.EarlyHintsSupported {
+		t.Skip("early hints are unsupported by the toolchain")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.New(w, r, tracing.NoopTracing, "test")
+		ctx.Response().SetStatusCode(http.StatusCreated)
+
+		// NOTE: Hints are forwarded by other goroutines, e.g. of Proxy.
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for ctx.Response().WriteEarlyHints(http.Header{"Link": []string{"</a.css>; rel=preload"}}) == nil {
+			}
+		}()
+		ctx.Finish()
+		<-done
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("the final status code is lost, got %d", resp.StatusCode)
+	}
+}
+
+func TestPushTargets(t *testing.T) {
+	eh := newEarlyHints(t, yamlSpec)
+	if !reflect.DeepEqual(eh.pushTargets, []string{"/style.css"}) {
+		t.Errorf("unexpected push targets: %v", eh.pushTargets)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, link := range []string{
+		"/style.css; rel=preload",
+		"</style.css>; as=style",
+		"<https://cdn.example.com>",
+	} {
+		if (Spec{Links: []string{link}}).Validate() == nil {
+			t.Errorf("%s should be invalid", link)
+		}
+	}
+	if err := (Spec{Links: []string{`</a.js>; REL="Preload"`}}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,331 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fairqueue
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/consumer"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+	"github.com/megaease/easegress/pkg/util/urlrule"
+)
+
+const (
+	// Kind is the kind of FairQueue.
+	Kind = "FairQueue"
+
+	resultQueueFull    = "queueFull"
+	resultQueueTimeout = "queueTimeout"
+	resultCanceled     = "canceled"
+
+	defaultMaxQueueSize = 1000
+	defaultMaxWait      = 10 * time.Second
+)
+
+var results = []string{resultQueueFull, resultQueueTimeout, resultCanceled}
+
+func init() {
+	httppipeline.Register(&FairQueue{})
+}
+
+type (
+	// FairQueue classifies requests into priority classes, and limits the
+	// concurrency of the following filters, the requests exceeding it are
+	// queued and dispatched by weighted fair queueing of the classes.
+	FairQueue struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		resolve   consumer.Resolver
+		scheduler *scheduler
+		classes   []*class
+		fallback  *class
+	}
+
+	// Spec describes the FairQueue.
+	Spec struct {
+		// MaxConcurrency is the max requests handled by the following
+		// filters concurrently.
+		MaxConcurrency int `yaml:"maxConcurrency" jsonschema:"required,minimum=1"`
+		// Consumer resolves the consumers of requests, it's required by
+		// the classes matching consumers.
+		Consumer *consumer.Spec `yaml:"consumer,omitempty" jsonschema:"omitempty"`
+		// Classes are matched in order, the first matched one is the
+		// class of the request.
+		Classes []*Class `yaml:"classes" jsonschema:"required,minItems=1"`
+		// DefaultClass is the class of the requests matching no class.
+		DefaultClass string `yaml:"defaultClass" jsonschema:"required"`
+		// Header is the request header of the class name, which is not
+		// set if it's empty.
+		Header string `yaml:"header" jsonschema:"omitempty"`
+	}
+
+	// Class is a priority class, it matches the requests meeting all of
+	// its non-empty conditions.
+	Class struct {
+		Name string `yaml:"name" jsonschema:"required"`
+		// Weight is the share of the concurrency during contention.
+		Weight int `yaml:"weight" jsonschema:"required,minimum=1"`
+		// MaxQueueSize is the max queued requests of the class, 1000 by
+		// default, and the requests are rejected if it's negative.
+		MaxQueueSize int `yaml:"maxQueueSize" jsonschema:"omitempty"`
+		// MaxWait is the max duration of a request in the queue, 10s by
+		// default.
+		MaxWait string `yaml:"maxWait" jsonschema:"omitempty,format=duration"`
+
+		Methods   []string                        `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		Paths     []*urlrule.StringMatch          `yaml:"paths" jsonschema:"omitempty"`
+		Headers   map[string]*urlrule.StringMatch `yaml:"headers" jsonschema:"omitempty"`
+		Consumers []string                        `yaml:"consumers" jsonschema:"omitempty,uniqueItems=true"`
+	}
+
+	// Status is the status of FairQueue.
+	Status struct {
+		InFlight int                     `yaml:"inFlight"`
+		Classes  map[string]*QueueStatus `yaml:"classes"`
+	}
+
+	class struct {
+		*Class
+		maxQueueSize int
+		maxWait      time.Duration
+		consumers    map[string]bool
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	names := map[string]bool{}
+	for _, c := range spec.Classes {
+		if names[c.Name] {
+			return fmt.Errorf("duplicated class %s", c.Name)
+		}
+		names[c.Name] = true
+
+		if len(c.Consumers) > 0 && spec.Consumer == nil {
+			return fmt.Errorf("class %s: consumer is required to match consumers", c.Name)
+		}
+	}
+
+	if !names[spec.DefaultClass] {
+		return fmt.Errorf("default class %s not found", spec.DefaultClass)
+	}
+	if spec.Consumer != nil {
+		return spec.Consumer.Validate()
+	}
+	return nil
+}
+
+// Validate validates Class.
+func (c Class) Validate() error {
+	if c.MaxWait != "" {
+		if d, _ := time.ParseDuration(c.MaxWait); d <= 0 {
+			return fmt.Errorf("class %s: maxWait must be positive", c.Name)
+		}
+	}
+	return nil
+}
+
+func newClass(c *Class) *class {
+	nc := &class{
+		Class:        c,
+		maxQueueSize: c.MaxQueueSize,
+		maxWait:      defaultMaxWait,
+		consumers:    map[string]bool{},
+	}
+
+	switch {
+	case nc.maxQueueSize == 0:
+		nc.maxQueueSize = defaultMaxQueueSize
+	case nc.maxQueueSize < 0:
+		// NOTE: The requests are admitted or rejected immediately.
+		nc.maxQueueSize = 0
+	}
+	if c.MaxWait != "" {
+		nc.maxWait, _ = time.ParseDuration(c.MaxWait)
+	}
+	for _, p := range c.Paths {
+		p.Init()
+	}
+	for _, h := range c.Headers {
+		h.Init()
+	}
+	for _, name := range c.Consumers {
+		nc.consumers[name] = true
+	}
+
+	return nc
+}
+
+func (c *class) match(ctx context.HTTPContext, resolve consumer.Resolver) bool {
+	r := ctx.Request()
+
+	if len(c.Methods) > 0 && !stringtool.StrInSlice(r.Method(), c.Methods) {
+		return false
+	}
+
+	if len(c.Paths) > 0 {
+		matched := false
+		for _, p := range c.Paths {
+			if p.Match(r.Path()) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	for name, h := range c.Headers {
+		if !h.Match(r.Header().Get(name)) {
+			return false
+		}
+	}
+
+	if len(c.consumers) > 0 && !c.consumers[resolve(ctx)] {
+		return false
+	}
+
+	return true
+}
+
+// Kind returns the kind of FairQueue.
+func (fq *FairQueue) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of FairQueue.
+func (fq *FairQueue) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of FairQueue.
+func (fq *FairQueue) Description() string {
+	return "FairQueue queues requests by priority classes, and dispatches them by weighted fair queueing."
+}
+
+// Results returns the results of FairQueue.
+func (fq *FairQueue) Results() []string {
+	return results
+}
+
+// Init initializes FairQueue.
+func (fq *FairQueue) Init(filterSpec *httppipeline.FilterSpec) {
+	fq.filterSpec, fq.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	fq.scheduler = newScheduler(fq.spec.MaxConcurrency)
+	fq.reload()
+}
+
+// Inherit inherits previous generation of FairQueue.
+func (fq *FairQueue) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+
+	fq.filterSpec, fq.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	// NOTE: Keep the scheduler, so the in-flight and queued requests of
+	// the previous generation are still counted.
+	fq.scheduler = previousGeneration.(*FairQueue).scheduler
+	fq.scheduler.setMaxConcurrency(fq.spec.MaxConcurrency)
+	fq.reload()
+}
+
+func (fq *FairQueue) reload() {
+	if fq.spec.Consumer != nil {
+		fq.resolve = consumer.NewResolver(fq.spec.Consumer)
+	}
+
+	fq.classes = nil
+	for _, c := range fq.spec.Classes {
+		nc := newClass(c)
+		fq.classes = append(fq.classes, nc)
+		if c.Name == fq.spec.DefaultClass {
+			fq.fallback = nc
+		}
+	}
+}
+
+func (fq *FairQueue) classify(ctx context.HTTPContext) *class {
+	for _, c := range fq.classes {
+		if c.match(ctx, fq.resolve) {
+			return c
+		}
+	}
+	return fq.fallback
+}
+
+// Handle queues the request of HTTPContext, and handles it by the following
+// filters when it's dispatched.
+func (fq *FairQueue) Handle(ctx context.HTTPContext) string {
+	c := fq.classify(ctx)
+	if fq.spec.Header != "" {
+		ctx.Request().Header().Set(fq.spec.Header, c.Name)
+	}
+
+	if result := fq.wait(ctx, c); result != "" {
+		return ctx.CallNextHandler(result)
+	}
+	defer fq.scheduler.release()
+
+	return ctx.CallNextHandler("")
+}
+
+func (fq *FairQueue) wait(ctx context.HTTPContext, c *class) string {
+	start := time.Now()
+	w, rejected := fq.scheduler.enqueue(c.Name, c.Weight, c.maxQueueSize)
+	if rejected {
+		ctx.AddTag(stringtool.Cat("fairQueue: queue of ", c.Name, " is full"))
+		ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
+		return resultQueueFull
+	}
+	if w == nil {
+		return ""
+	}
+
+	timer := time.NewTimer(c.maxWait)
+	defer timer.Stop()
+
+	select {
+	case <-w.ready:
+	case <-timer.C:
+		if fq.scheduler.cancel(w, true) {
+			ctx.AddTag(stringtool.Cat("fairQueue: timeout in queue of ", c.Name))
+			ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
+			return resultQueueTimeout
+		}
+	case <-ctx.Request().Std().Context().Done():
+		// NOTE: HTTPContext sets 499 by itself.
+		if fq.scheduler.cancel(w, false) {
+			return resultCanceled
+		}
+	}
+
+	ctx.AddTag(stringtool.Cat("fairQueue: queued ", time.Since(start).String(), " in ", c.Name))
+	return ""
+}
+
+// Status returns status.
+func (fq *FairQueue) Status() interface{} {
+	inFlight, classes := fq.scheduler.status()
+	return &Status{InFlight: inFlight, Classes: classes}
+}
+
+// Close closes FairQueue.
+func (fq *FairQueue) Close() {}
@@ -0,0 +1,283 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fairqueue
+
+import (
+	stdcontext "context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newFairQueue(t *testing.T, yamlSpec string) *FairQueue {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fq := &FairQueue{}
+	fq.Init(spec)
+	return fq
+}
+
+func newContext(stdctx stdcontext.Context, path string, header map[string]string, next func()) context.HTTPContext {
+	stdr, _ := http.NewRequestWithContext(stdctx, http.MethodGet, "http://example.com"+path, nil)
+	for k, v := range header {
+		stdr.Header.Set(k, v)
+	}
+	ctx := context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		if lastResult == "" && next != nil {
+			next()
+		}
+		return lastResult
+	})
+	return ctx
+}
+
+func waitInFlight(t *testing.T, fq *FairQueue, inFlight int) {
+	for i := 0; i < 100; i++ {
+		if n, _ := fq.scheduler.status(); n == inFlight {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("in-flight requests should be %d", inFlight)
+}
+
+func TestValidate(t *testing.T) {
+	for i, yamlSpec := range []string{
+		"kind: FairQueue\nname: fq\nmaxConcurrency: 1\nclasses:\n- name: a\n  weight: 1\n",
+		"kind: FairQueue\nname: fq\nmaxConcurrency: 1\ndefaultClass: b\nclasses:\n- name: a\n  weight: 1\n",
+		"kind: FairQueue\nname: fq\nmaxConcurrency: 1\ndefaultClass: a\nclasses:\n- name: a\n  weight: 1\n- name: a\n  weight: 2\n",
+		"kind: FairQueue\nname: fq\nmaxConcurrency: 1\ndefaultClass: a\nclasses:\n- name: a\n  weight: 0\n",
+		"kind: FairQueue\nname: fq\nmaxConcurrency: 1\ndefaultClass: a\nclasses:\n- name: a\n  weight: 1\n  consumers: [alice]\n",
+		"kind: FairQueue\nname: fq\nmaxConcurrency: 1\ndefaultClass: a\nclasses:\n- name: a\n  weight: 1\n  maxWait: -1s\n",
+	} {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("case %d: spec should be invalid", i)
+		}
+	}
+}
+
+func TestSchedulerWeights(t *testing.T) {
+	s := newScheduler(1)
+	if w, _ := s.enqueue("bulk", 1, 100); w != nil {
+		t.Fatalf("first request should be admitted")
+	}
+
+	waiters := map[*waiter]string{}
+	for i := 0; i < 10; i++ {
+		w, _ := s.enqueue("bulk", 1, 100)
+		waiters[w] = "bulk"
+	}
+	for i := 0; i < 10; i++ {
+		w, _ := s.enqueue("high", 4, 100)
+		waiters[w] = "high"
+	}
+
+	// The high class gets 4/5 of the slots, though its requests are queued
+	// after the bulk ones.
+	high := 0
+	for i := 0; i < 5; i++ {
+		s.release()
+		for w, class := range waiters {
+			select {
+			case <-w.ready:
+				if class == "high" {
+					high++
+				}
+				delete(waiters, w)
+			default:
+			}
+		}
+	}
+	if high < 4 {
+		t.Errorf("high class should get at least 4 of 5 slots, but got %d", high)
+	}
+
+	_, queues := s.status()
+	if queues["bulk"].Length+queues["high"].Length != 15 {
+		t.Errorf("unexpected queues %+v %+v", queues["bulk"], queues["high"])
+	}
+}
+
+func TestClassify(t *testing.T) {
+	fq := newFairQueue(t, `
+kind: FairQueue
+name: fq
+maxConcurrency: 10
+header: X-Priority-Class
+consumer:
+  source: Header
+  name: X-Consumer
+defaultClass: standard
+classes:
+- name: checkout
+  weight: 8
+  paths:
+  - prefix: /checkout
+- name: premium
+  weight: 4
+  headers:
+    X-Plan:
+      exact: premium
+- name: partner
+  weight: 4
+  consumers: [acme]
+- name: standard
+  weight: 2
+- name: bulk
+  weight: 1
+  methods: [GET]
+  paths:
+  - prefix: /export
+`)
+
+	for _, c := range []struct {
+		path   string
+		header map[string]string
+		class  string
+	}{
+		{"/checkout/pay", nil, "checkout"},
+		{"/orders", map[string]string{"X-Plan": "premium"}, "premium"},
+		{"/orders", map[string]string{"X-Consumer": "acme"}, "partner"},
+		{"/orders", map[string]string{"X-Consumer": "other"}, "standard"},
+		{"/export", nil, "standard"},
+	} {
+		ctx := newContext(stdcontext.Background(), c.path, c.header, nil)
+		if result := fq.Handle(ctx); result != "" {
+			t.Errorf("%s: unexpected result %s", c.path, result)
+		}
+		if class := ctx.Request().Header().Get("X-Priority-Class"); class != c.class {
+			t.Errorf("%s: class should be %s, but got %s", c.path, c.class, class)
+		}
+	}
+
+	inFlight, classes := fq.scheduler.status()
+	if inFlight != 0 || classes["standard"].Admitted != 2 {
+		t.Errorf("unexpected status %d %+v", inFlight, classes["standard"])
+	}
+}
+
+func TestQueue(t *testing.T) {
+	fq := newFairQueue(t, `
+kind: FairQueue
+name: fq
+maxConcurrency: 1
+defaultClass: bulk
+classes:
+- name: interactive
+  weight: 4
+  maxWait: 5s
+  paths:
+  - prefix: /api
+- name: bulk
+  weight: 1
+  maxQueueSize: 1
+  maxWait: 50ms
+`)
+
+	block := make(chan struct{})
+	done := make(chan string)
+	go func() {
+		ctx := newContext(stdcontext.Background(), "/api", nil, func() { <-block })
+		done <- fq.Handle(ctx)
+	}()
+	waitInFlight(t, fq, 1)
+
+	// The bulk request times out in the queue.
+	ctx := newContext(stdcontext.Background(), "/export", nil, nil)
+	if result := fq.Handle(ctx); result != resultQueueTimeout {
+		t.Errorf("result should be %s, but got %q", resultQueueTimeout, result)
+	}
+	if ctx.Response().StatusCode() != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status code %d", ctx.Response().StatusCode())
+	}
+
+	// The canceled request leaves the queue.
+	stdctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	ctx = newContext(stdctx, "/api", nil, nil)
+	if result := fq.Handle(ctx); result != resultCanceled {
+		t.Errorf("result should be %s, but got %q", resultCanceled, result)
+	}
+
+	// The queued request is dispatched after the first one finishes.
+	go func() {
+		ctx := newContext(stdcontext.Background(), "/api", nil, nil)
+		done <- fq.Handle(ctx)
+	}()
+	for {
+		if _, classes := fq.scheduler.status(); classes["interactive"].Length == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The bulk queue is full with one request.
+	go func() {
+		ctx := newContext(stdcontext.Background(), "/export", nil, nil)
+		done <- fq.Handle(ctx)
+	}()
+	for {
+		if _, classes := fq.scheduler.status(); classes["bulk"].Length == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	ctx = newContext(stdcontext.Background(), "/export", nil, nil)
+	if result := fq.Handle(ctx); result != resultQueueFull {
+		t.Errorf("result should be %s, but got %q", resultQueueFull, result)
+	}
+
+	close(block)
+	for i := 0; i < 3; i++ {
+		if result := <-done; result != "" {
+			t.Errorf("unexpected result %s", result)
+		}
+	}
+
+	status := fq.Status().(*Status)
+	interactive, bulk := status.Classes["interactive"], status.Classes["bulk"]
+	if status.InFlight != 0 || interactive.Admitted != 2 || interactive.Canceled != 1 ||
+		bulk.Admitted != 1 || bulk.Timeouts != 1 || bulk.Rejected != 1 {
+		t.Errorf("unexpected status %+v %+v %+v", status, interactive, bulk)
+	}
+}
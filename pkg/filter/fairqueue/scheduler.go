@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fairqueue
+
+import (
+	"container/list"
+	"sync"
+)
+
+type (
+	// scheduler dispatches the requests by weighted fair queueing, a
+	// queued request is tagged with the virtual finish time of its class,
+	// which advances by the reciprocal of the weight of the class for every
+	// request, and the request with the smallest tag is dispatched first.
+	// So the classes share the concurrency in proportion to their weights
+	// during contention, and a class can use all of it when it's idle.
+	scheduler struct {
+		mutex          sync.Mutex
+		maxConcurrency int
+		inFlight       int
+		queued         int
+		virtualTime    float64
+		queues         map[string]*queue
+	}
+
+	queue struct {
+		lastFinish float64
+		waiters    *list.List
+
+		admitted, queued, rejected, timeouts, canceled uint64
+	}
+
+	waiter struct {
+		tag   float64
+		ready chan struct{}
+		queue *queue
+		elem  *list.Element
+	}
+
+	// QueueStatus is the status of the queue of a class.
+	QueueStatus struct {
+		Length   int    `yaml:"length"`
+		Admitted uint64 `yaml:"admitted"`
+		Queued   uint64 `yaml:"queued"`
+		Rejected uint64 `yaml:"rejected"`
+		Timeouts uint64 `yaml:"timeouts"`
+		Canceled uint64 `yaml:"canceled"`
+	}
+)
+
+func newScheduler(maxConcurrency int) *scheduler {
+	return &scheduler{
+		maxConcurrency: maxConcurrency,
+		queues:         map[string]*queue{},
+	}
+}
+
+func (s *scheduler) getQueue(class string) *queue {
+	q := s.queues[class]
+	if q == nil {
+		q = &queue{waiters: list.New()}
+		s.queues[class] = q
+	}
+	return q
+}
+
+// setMaxConcurrency updates the max concurrency, and dispatches the queued
+// requests if it's increased.
+func (s *scheduler) setMaxConcurrency(maxConcurrency int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.maxConcurrency = maxConcurrency
+	s.dispatch()
+}
+
+// enqueue admits the request of class if there's an idle slot and no
+// queued request, or queues it. It returns nil if the request is admitted,
+// or the waiter of the queued request. The request is rejected if the
+// queue of the class is full.
+func (s *scheduler) enqueue(class string, weight, maxQueueSize int) (w *waiter, rejected bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	q := s.getQueue(class)
+	if s.queued == 0 && s.inFlight < s.maxConcurrency {
+		s.inFlight++
+		q.admitted++
+		return nil, false
+	}
+
+	if q.waiters.Len() >= maxQueueSize {
+		q.rejected++
+		return nil, true
+	}
+
+	start := s.virtualTime
+	if q.lastFinish > start {
+		start = q.lastFinish
+	}
+	q.lastFinish = start + 1/float64(weight)
+
+	w = &waiter{tag: q.lastFinish, ready: make(chan struct{}), queue: q}
+	w.elem = q.waiters.PushBack(w)
+	q.queued++
+	s.queued++
+	return w, false
+}
+
+// cancel removes the waiter from its queue, it returns false if the waiter
+// has been dispatched, and the caller owns the slot.
+func (s *scheduler) cancel(w *waiter, timeout bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	select {
+	case <-w.ready:
+		return false
+	default:
+	}
+
+	w.queue.waiters.Remove(w.elem)
+	s.queued--
+	if timeout {
+		w.queue.timeouts++
+	} else {
+		w.queue.canceled++
+	}
+	return true
+}
+
+// release releases the slot of a finished request, and dispatches the
+// queued requests.
+func (s *scheduler) release() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.inFlight--
+	s.dispatch()
+}
+
+// dispatch must be called with the mutex held.
+func (s *scheduler) dispatch() {
+	for s.queued > 0 && s.inFlight < s.maxConcurrency {
+		var next *waiter
+		for _, q := range s.queues {
+			front := q.waiters.Front()
+			if front == nil {
+				continue
+			}
+			w := front.Value.(*waiter)
+			if next == nil || w.tag < next.tag {
+				next = w
+			}
+		}
+
+		next.queue.waiters.Remove(next.elem)
+		next.queue.admitted++
+		s.queued--
+		s.inFlight++
+		s.virtualTime = next.tag
+		close(next.ready)
+	}
+}
+
+func (s *scheduler) status() (inFlight int, queues map[string]*QueueStatus) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	queues = make(map[string]*QueueStatus, len(s.queues))
+	for class, q := range s.queues {
+		queues[class] = &QueueStatus{
+			Length:   q.waiters.Len(),
+			Admitted: q.admitted,
+			Queued:   q.queued,
+			Rejected: q.rejected,
+			Timeouts: q.timeouts,
+			Canceled: q.canceled,
+		}
+	}
+	return s.inFlight, queues
+}
@@ -0,0 +1,304 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package featureflag
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of FeatureFlag.
+	Kind = "FeatureFlag"
+
+	defaultPollInterval = 30 * time.Second
+	retryInterval       = 5 * time.Second
+)
+
+var results = []string{}
+
+func init() {
+	httppipeline.Register(&FeatureFlag{})
+}
+
+type (
+	// FeatureFlag is filter FeatureFlag.
+	FeatureFlag struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		provider provider
+
+		mutex     sync.RWMutex
+		flags     map[string]flag
+		lastSync  time.Time
+		lastError string
+
+		done chan struct{}
+	}
+
+	// Spec describes the FeatureFlag.
+	Spec struct {
+		UserKey      *UserKeySpec      `yaml:"userKey" jsonschema:"required"`
+		LaunchDarkly *LaunchDarklySpec `yaml:"launchDarkly,omitempty" jsonschema:"omitempty"`
+		Unleash      *UnleashSpec      `yaml:"unleash,omitempty" jsonschema:"omitempty"`
+		Flags        []*FlagHeader     `yaml:"flags" jsonschema:"required"`
+	}
+
+	// UserKeySpec describes where to get the key of the user
+	// which the flags are evaluated for, the client IP is used
+	// if the key is not found in the request.
+	UserKeySpec struct {
+		Header string `yaml:"header" jsonschema:"omitempty"`
+		Cookie string `yaml:"cookie" jsonschema:"omitempty"`
+	}
+
+	// FlagHeader maps the evaluated value of a flag to a request header.
+	FlagHeader struct {
+		Key        string `yaml:"key" jsonschema:"required"`
+		HeaderName string `yaml:"headerName" jsonschema:"required"`
+		// Default is used when the flag is not (yet) synced from the provider.
+		Default string `yaml:"default" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of FeatureFlag.
+	Status struct {
+		Provider  string   `yaml:"provider"`
+		Flags     []string `yaml:"flags"`
+		LastSync  string   `yaml:"lastSync,omitempty"`
+		LastError string   `yaml:"lastError,omitempty"`
+	}
+
+	// flag is a flag definition synced from provider,
+	// it's evaluated locally for every request.
+	flag interface {
+		evaluate(userKey string) string
+	}
+
+	// provider syncs flags from the external flag system.
+	provider interface {
+		name() string
+		// run keeps syncing flags until done is closed.
+		run(ff *FeatureFlag, done <-chan struct{})
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if spec.LaunchDarkly == nil && spec.Unleash == nil {
+		return fmt.Errorf("one of launchDarkly and unleash is required")
+	}
+	if spec.LaunchDarkly != nil && spec.Unleash != nil {
+		return fmt.Errorf("launchDarkly and unleash are mutually exclusive")
+	}
+
+	keys := make(map[string]struct{})
+	for _, f := range spec.Flags {
+		if _, exists := keys[f.Key]; exists {
+			return fmt.Errorf("repeated flag %s", f.Key)
+		}
+		keys[f.Key] = struct{}{}
+	}
+
+	return nil
+}
+
+// Kind returns the kind of FeatureFlag.
+func (ff *FeatureFlag) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of FeatureFlag.
+func (ff *FeatureFlag) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of FeatureFlag.
+func (ff *FeatureFlag) Description() string {
+	return "FeatureFlag syncs flags from LaunchDarkly or Unleash, evaluates them locally and sets the values to request headers."
+}
+
+// Results returns the results of FeatureFlag.
+func (ff *FeatureFlag) Results() []string {
+	return results
+}
+
+// Init initializes FeatureFlag.
+func (ff *FeatureFlag) Init(filterSpec *httppipeline.FilterSpec) {
+	ff.filterSpec, ff.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	ff.reload()
+}
+
+// Inherit inherits previous generation of FeatureFlag.
+func (ff *FeatureFlag) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	ff.Init(filterSpec)
+}
+
+func (ff *FeatureFlag) reload() {
+	ff.flags = make(map[string]flag)
+	ff.done = make(chan struct{})
+
+	if ff.spec.LaunchDarkly != nil {
+		ff.provider = newLaunchDarkly(ff.spec.LaunchDarkly)
+	} else {
+		ff.provider = newUnleash(ff.spec.Unleash)
+	}
+
+	go ff.provider.run(ff, ff.done)
+}
+
+// replaceFlags replaces all flags with the full set from the provider.
+func (ff *FeatureFlag) replaceFlags(flags map[string]flag) {
+	ff.mutex.Lock()
+	defer ff.mutex.Unlock()
+
+	ff.flags = flags
+	ff.lastSync = time.Now()
+	ff.lastError = ""
+}
+
+// patchFlag updates one flag, nil flag means deleting it.
+func (ff *FeatureFlag) patchFlag(key string, f flag) {
+	ff.mutex.Lock()
+	defer ff.mutex.Unlock()
+
+	flags := make(map[string]flag, len(ff.flags))
+	for k, v := range ff.flags {
+		flags[k] = v
+	}
+	if f == nil {
+		delete(flags, key)
+	} else {
+		flags[key] = f
+	}
+
+	ff.flags = flags
+	ff.lastSync = time.Now()
+}
+
+func (ff *FeatureFlag) recordError(err error) {
+	logger.Errorf("%s: sync flags from %s failed: %v",
+		ff.filterSpec.Name(), ff.provider.name(), err)
+
+	ff.mutex.Lock()
+	ff.lastError = err.Error()
+	ff.mutex.Unlock()
+}
+
+func (ff *FeatureFlag) getFlags() map[string]flag {
+	ff.mutex.RLock()
+	defer ff.mutex.RUnlock()
+	return ff.flags
+}
+
+func (ff *FeatureFlag) userKey(ctx context.HTTPContext) string {
+	req := ctx.Request()
+	if ff.spec.UserKey.Header != "" {
+		if key := req.Header().Get(ff.spec.UserKey.Header); key != "" {
+			return key
+		}
+	}
+	if ff.spec.UserKey.Cookie != "" {
+		if c, err := req.Cookie(ff.spec.UserKey.Cookie); err == nil && c.Value != "" {
+			return c.Value
+		}
+	}
+	return req.RealIP()
+}
+
+// Handle evaluates flags and sets them to request headers.
+func (ff *FeatureFlag) Handle(ctx context.HTTPContext) string {
+	result := ff.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (ff *FeatureFlag) handle(ctx context.HTTPContext) string {
+	userKey := ff.userKey(ctx)
+	flags := ff.getFlags()
+	header := ctx.Request().Header()
+
+	for _, fh := range ff.spec.Flags {
+		value := fh.Default
+		if f, exists := flags[fh.Key]; exists {
+			value = f.evaluate(userKey)
+		}
+		if value == "" {
+			header.Del(fh.HeaderName)
+			continue
+		}
+		header.Set(fh.HeaderName, value)
+		ctx.AddTag(stringtool.Cat("featureFlag: ", fh.Key, "=", value))
+	}
+
+	return ""
+}
+
+// Status returns status.
+func (ff *FeatureFlag) Status() interface{} {
+	ff.mutex.RLock()
+	defer ff.mutex.RUnlock()
+
+	s := &Status{
+		Provider:  ff.provider.name(),
+		LastError: ff.lastError,
+	}
+	for key := range ff.flags {
+		s.Flags = append(s.Flags, key)
+	}
+	sort.Strings(s.Flags)
+	if !ff.lastSync.IsZero() {
+		s.LastSync = ff.lastSync.Format(time.RFC3339)
+	}
+
+	return s
+}
+
+// Close closes FeatureFlag.
+func (ff *FeatureFlag) Close() {
+	close(ff.done)
+}
+
+func parsePollInterval(interval string) time.Duration {
+	if interval == "" {
+		return defaultPollInterval
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil || d <= 0 {
+		logger.Errorf("BUG: invalid poll interval %s", interval)
+		return defaultPollInterval
+	}
+	return d
+}
+
+// sleep waits for d, returns false if done is closed.
+func sleep(d time.Duration, done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
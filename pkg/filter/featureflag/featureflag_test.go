@@ -0,0 +1,288 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package featureflag
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+const ldFlags = `{
+  "new-checkout": {
+    "key": "new-checkout", "on": true, "salt": "abc",
+    "variations": ["v1", "v2"],
+    "offVariation": 0,
+    "targets": [{"values": ["alice"], "variation": 1}],
+    "rules": [{"variation": 1, "clauses": [{"attribute": "key", "op": "startsWith", "values": ["beta-"]}]}],
+    "fallthrough": {"variation": 0}
+  },
+  "dark-mode": {
+    "key": "dark-mode", "on": false,
+    "variations": [true, false],
+    "offVariation": 1,
+    "fallthrough": {"variation": 0}
+  }
+}`
+
+func newFilter(t *testing.T, yamlSpec string) *FeatureFlag {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ff := &FeatureFlag{}
+	ff.Init(spec)
+	return ff
+}
+
+func handle(ff *FeatureFlag, user string) http.Header {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	req.Header.Set("X-User", user)
+
+	ff.Handle(contexttest.NewMockedHTTPContext(req, httptest.NewRecorder()))
+	return req.Header
+}
+
+func waitSynced(t *testing.T, ff *FeatureFlag, count int) {
+	for i := 0; i < 100; i++ {
+		if len(ff.getFlags()) == count {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("flags not synced")
+}
+
+func TestLaunchDarklyPoll(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != ldPollPath || r.Header.Get("Authorization") != "sdk-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(ldFlags))
+	}))
+	defer server.Close()
+
+	ff := newFilter(t, fmt.Sprintf(`
+kind: FeatureFlag
+name: ff
+userKey:
+  header: X-User
+launchDarkly:
+  relayURL: %s
+  sdkKey: sdk-key
+flags:
+- key: new-checkout
+  headerName: X-Flag-Checkout
+- key: dark-mode
+  headerName: X-Flag-Dark
+- key: missing
+  headerName: X-Flag-Missing
+  default: fallback
+`, server.URL))
+	defer ff.Close()
+	waitSynced(t, ff, 2)
+
+	cases := []struct {
+		user     string
+		checkout string
+	}{
+		{"alice", "v2"},
+		{"beta-bob", "v2"},
+		{"carol", "v1"},
+	}
+	for _, c := range cases {
+		header := handle(ff, c.user)
+		if got := header.Get("X-Flag-Checkout"); got != c.checkout {
+			t.Errorf("user %s: expected %s, got %s", c.user, c.checkout, got)
+		}
+		if got := header.Get("X-Flag-Dark"); got != "false" {
+			t.Errorf("user %s: expected false, got %s", c.user, got)
+		}
+		if got := header.Get("X-Flag-Missing"); got != "fallback" {
+			t.Errorf("user %s: expected fallback, got %s", c.user, got)
+		}
+	}
+
+	status := ff.Status().(*Status)
+	if status.Provider != "LaunchDarkly" || len(status.Flags) != 2 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestLaunchDarklyStream(t *testing.T) {
+	events := strings.Join([]string{
+		"event: put",
+		`data: {"path": "/", "data": {"flags": ` + strings.ReplaceAll(ldFlags, "\n", "") + `}}`,
+		"",
+		"event: patch",
+		`data: {"path": "/flags/dark-mode", "data": {"key": "dark-mode", "on": true, "variations": [true, false], "fallthrough": {"variation": 0}}}`,
+		"",
+		"event: delete",
+		`data: {"path": "/flags/new-checkout", "version": 2}`,
+		"",
+		"",
+	}, "\n")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(events))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ff := newFilter(t, fmt.Sprintf(`
+kind: FeatureFlag
+name: ff
+userKey:
+  header: X-User
+launchDarkly:
+  relayURL: %s
+  sdkKey: sdk-key
+  stream: true
+flags:
+- key: dark-mode
+  headerName: X-Flag-Dark
+`, server.URL))
+	defer ff.Close()
+	waitSynced(t, ff, 1)
+
+	if got := handle(ff, "carol").Get("X-Flag-Dark"); got != "true" {
+		t.Errorf("expected true, got %s", got)
+	}
+}
+
+func TestLDRollout(t *testing.T) {
+	zero, one := 0, 1
+	f := &ldFlag{
+		Key:        "rollout",
+		On:         true,
+		Salt:       "salt",
+		Variations: nil,
+		Fallthrough: ldVariationOrRoll{
+			Rollout: &ldRollout{Variations: []ldWeightedVariation{
+				{Variation: zero, Weight: 50000},
+				{Variation: one, Weight: 50000},
+			}},
+		},
+	}
+	f.Variations = append(f.Variations, []byte(`"a"`), []byte(`"b"`))
+
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		value := f.evaluate(user)
+		if value != f.evaluate(user) {
+			t.Fatalf("assignment is not consistent for %s", user)
+		}
+		counts[value]++
+	}
+	if counts["a"] < 400 || counts["b"] < 400 {
+		t.Errorf("unbalanced rollout: %v", counts)
+	}
+}
+
+func TestUnleash(t *testing.T) {
+	const features = `{"features": [
+  {"name": "beta", "enabled": true, "strategies": [{"name": "userWithId", "parameters": {"userIds": "alice, bob"}}]},
+  {"name": "retired", "enabled": false, "strategies": [{"name": "default"}]},
+  {"name": "color", "enabled": true, "strategies": [{"name": "default"}], "variants": [{"name": "red", "weight": 1000}]}
+]}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == "v1" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", "v1")
+		w.Write([]byte(features))
+	}))
+	defer server.Close()
+
+	ff := newFilter(t, fmt.Sprintf(`
+kind: FeatureFlag
+name: ff
+userKey:
+  header: X-User
+unleash:
+  url: %s/api
+  appName: easegress
+  pollInterval: 10ms
+flags:
+- key: beta
+  headerName: X-Beta
+- key: retired
+  headerName: X-Off
+- key: color
+  headerName: X-Color
+`, server.URL))
+	defer ff.Close()
+	waitSynced(t, ff, 3)
+
+	header := handle(ff, "alice")
+	if header.Get("X-Beta") != "true" || header.Get("X-Off") != "false" || header.Get("X-Color") != "red" {
+		t.Errorf("unexpected headers %v", header)
+	}
+	if handle(ff, "carol").Get("X-Beta") != "false" {
+		t.Errorf("expected beta disabled for carol")
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := Spec{}
+	if spec.Validate() == nil {
+		t.Errorf("expected error for missing provider")
+	}
+
+	spec = Spec{
+		LaunchDarkly: &LaunchDarklySpec{},
+		Unleash:      &UnleashSpec{},
+	}
+	if spec.Validate() == nil {
+		t.Errorf("expected error for multiple providers")
+	}
+
+	spec = Spec{
+		Unleash: &UnleashSpec{},
+		Flags:   []*FlagHeader{{Key: "a"}, {Key: "a"}},
+	}
+	if spec.Validate() == nil {
+		t.Errorf("expected error for repeated flags")
+	}
+}
@@ -0,0 +1,419 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package featureflag
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ldStreamPath = "/all"
+	ldPollPath   = "/sdk/latest-flags"
+	ldFlagsPath  = "/flags/"
+
+	// ldBucketScale is the scale of the bucket of LaunchDarkly,
+	// the weights of rollout are in the range [0, 100000].
+	ldBucketScale = 100000
+)
+
+type (
+	// LaunchDarklySpec describes the LaunchDarkly provider,
+	// it connects to LaunchDarkly Relay Proxy in general.
+	LaunchDarklySpec struct {
+		RelayURL string `yaml:"relayURL" jsonschema:"required,format=url"`
+		SDKKey   string `yaml:"sdkKey" jsonschema:"required"`
+		// Stream receives updates by server-sent events, polling is used if false.
+		Stream       bool   `yaml:"stream" jsonschema:"omitempty"`
+		PollInterval string `yaml:"pollInterval" jsonschema:"omitempty,format=duration"`
+	}
+
+	launchDarkly struct {
+		spec         *LaunchDarklySpec
+		client       *http.Client
+		pollInterval time.Duration
+	}
+
+	ldFlag struct {
+		Key          string            `json:"key"`
+		On           bool              `json:"on"`
+		Salt         string            `json:"salt"`
+		Variations   []json.RawMessage `json:"variations"`
+		OffVariation *int              `json:"offVariation"`
+		Fallthrough  ldVariationOrRoll `json:"fallthrough"`
+		Targets      []ldTarget        `json:"targets"`
+		Rules        []ldRule          `json:"rules"`
+		Deleted      bool              `json:"deleted"`
+	}
+
+	ldTarget struct {
+		Values    []string `json:"values"`
+		Variation int      `json:"variation"`
+	}
+
+	ldRule struct {
+		ldVariationOrRoll
+		Clauses []ldClause `json:"clauses"`
+	}
+
+	ldClause struct {
+		Attribute string        `json:"attribute"`
+		Op        string        `json:"op"`
+		Values    []interface{} `json:"values"`
+		Negate    bool          `json:"negate"`
+	}
+
+	ldVariationOrRoll struct {
+		Variation *int       `json:"variation"`
+		Rollout   *ldRollout `json:"rollout"`
+	}
+
+	ldRollout struct {
+		Variations []ldWeightedVariation `json:"variations"`
+	}
+
+	ldWeightedVariation struct {
+		Variation int `json:"variation"`
+		Weight    int `json:"weight"`
+	}
+
+	ldPutData struct {
+		Path string `json:"path"`
+		Data struct {
+			Flags map[string]*ldFlag `json:"flags"`
+		} `json:"data"`
+	}
+
+	ldPatchData struct {
+		Path string  `json:"path"`
+		Data *ldFlag `json:"data"`
+	}
+)
+
+func newLaunchDarkly(spec *LaunchDarklySpec) *launchDarkly {
+	return &launchDarkly{
+		spec:         spec,
+		client:       &http.Client{},
+		pollInterval: parsePollInterval(spec.PollInterval),
+	}
+}
+
+func (ld *launchDarkly) name() string {
+	return "LaunchDarkly"
+}
+
+func (ld *launchDarkly) run(ff *FeatureFlag, done <-chan struct{}) {
+	for {
+		var err error
+		if ld.spec.Stream {
+			err = ld.stream(ff, done)
+		} else {
+			err = ld.poll(ff)
+		}
+
+		interval := ld.pollInterval
+		if err != nil {
+			ff.recordError(err)
+			interval = retryInterval
+		}
+
+		if !sleep(interval, done) {
+			return
+		}
+	}
+}
+
+func (ld *launchDarkly) newRequest(path string) (*http.Request, error) {
+	url := strings.TrimSuffix(ld.spec.RelayURL, "/") + path
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", ld.spec.SDKKey)
+	return req, nil
+}
+
+func (ld *launchDarkly) poll(ff *FeatureFlag) error {
+	req, err := ld.newRequest(ldPollPath)
+	if err != nil {
+		return err
+	}
+
+	resp, err := ld.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	ldFlags := map[string]*ldFlag{}
+	if err = json.Unmarshal(body, &ldFlags); err != nil {
+		return err
+	}
+
+	ff.replaceFlags(convertLDFlags(ldFlags))
+	return nil
+}
+
+// stream receives flags by server-sent events until the stream
+// is broken or done is closed.
+func (ld *launchDarkly) stream(ff *FeatureFlag, done <-chan struct{}) error {
+	req, err := ld.newRequest(ldStreamPath)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := ld.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-done
+		resp.Body.Close()
+	}()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	err = readEvents(resp.Body, func(event, data string) error {
+		return ld.handleEvent(ff, event, []byte(data))
+	})
+
+	select {
+	case <-done:
+		return nil
+	default:
+		return err
+	}
+}
+
+func (ld *launchDarkly) handleEvent(ff *FeatureFlag, event string, data []byte) error {
+	switch event {
+	case "put":
+		put := &ldPutData{}
+		if err := json.Unmarshal(data, put); err != nil {
+			return fmt.Errorf("unmarshal put event failed: %v", err)
+		}
+		ff.replaceFlags(convertLDFlags(put.Data.Flags))
+	case "patch":
+		patch := &ldPatchData{}
+		if err := json.Unmarshal(data, patch); err != nil {
+			return fmt.Errorf("unmarshal patch event failed: %v", err)
+		}
+		if !strings.HasPrefix(patch.Path, ldFlagsPath) || patch.Data == nil {
+			return nil
+		}
+		key := strings.TrimPrefix(patch.Path, ldFlagsPath)
+		if patch.Data.Deleted {
+			ff.patchFlag(key, nil)
+		} else {
+			ff.patchFlag(key, patch.Data)
+		}
+	case "delete":
+		patch := &ldPatchData{}
+		if err := json.Unmarshal(data, patch); err != nil {
+			return fmt.Errorf("unmarshal delete event failed: %v", err)
+		}
+		if strings.HasPrefix(patch.Path, ldFlagsPath) {
+			ff.patchFlag(strings.TrimPrefix(patch.Path, ldFlagsPath), nil)
+		}
+	}
+
+	return nil
+}
+
+// readEvents reads server-sent events from r and calls fn for every event.
+func readEvents(r io.Reader, fn func(event, data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	event, data := "", []string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if len(data) > 0 {
+				if err := fn(event, strings.Join(data, "\n")); err != nil {
+					return err
+				}
+			}
+			event, data = "", data[:0]
+		case strings.HasPrefix(line, ":"):
+			// Comment, used as heartbeat.
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func convertLDFlags(ldFlags map[string]*ldFlag) map[string]flag {
+	flags := make(map[string]flag, len(ldFlags))
+	for key, f := range ldFlags {
+		if f == nil || f.Deleted {
+			continue
+		}
+		flags[key] = f
+	}
+	return flags
+}
+
+func (f *ldFlag) evaluate(userKey string) string {
+	if !f.On {
+		if f.OffVariation == nil {
+			return ""
+		}
+		return f.variation(*f.OffVariation)
+	}
+
+	for _, t := range f.Targets {
+		for _, v := range t.Values {
+			if v == userKey {
+				return f.variation(t.Variation)
+			}
+		}
+	}
+
+	for _, rule := range f.Rules {
+		if rule.match(userKey) {
+			return f.variation(rule.index(f.Key, f.Salt, userKey))
+		}
+	}
+
+	return f.variation(f.Fallthrough.index(f.Key, f.Salt, userKey))
+}
+
+func (f *ldFlag) variation(index int) string {
+	if index < 0 || index >= len(f.Variations) {
+		return ""
+	}
+
+	raw := f.Variations[index]
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+func (vr *ldVariationOrRoll) index(key, salt, userKey string) int {
+	if vr.Variation != nil {
+		return *vr.Variation
+	}
+	if vr.Rollout == nil || len(vr.Rollout.Variations) == 0 {
+		return -1
+	}
+
+	bucket := ldBucket(key, salt, userKey)
+	sum := 0
+	for _, wv := range vr.Rollout.Variations {
+		sum += wv.Weight
+		if bucket < sum {
+			return wv.Variation
+		}
+	}
+
+	// NOTE: The weights may not sum up to the scale exactly.
+	return vr.Rollout.Variations[len(vr.Rollout.Variations)-1].Variation
+}
+
+// ldBucket follows the bucketing algorithm of LaunchDarkly SDKs,
+// so the gateway assigns users the same variations with other SDKs.
+func ldBucket(key, salt, userKey string) int {
+	sum := sha1.Sum([]byte(key + "." + salt + "." + userKey))
+	hash := hex.EncodeToString(sum[:])[:15]
+	value, _ := strconv.ParseInt(hash, 16, 64)
+	return int(float64(value) / float64(0xFFFFFFFFFFFFFFF) * ldBucketScale)
+}
+
+// match matches clauses, only the attribute key is supported
+// since the gateway knows nothing else about the user.
+func (r *ldRule) match(userKey string) bool {
+	for _, c := range r.Clauses {
+		if c.match(userKey) == c.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *ldClause) match(userKey string) bool {
+	if c.Attribute != "key" {
+		return false
+	}
+
+	for _, v := range c.Values {
+		value, ok := v.(string)
+		if !ok {
+			continue
+		}
+
+		switch c.Op {
+		case "in":
+			if userKey == value {
+				return true
+			}
+		case "startsWith":
+			if strings.HasPrefix(userKey, value) {
+				return true
+			}
+		case "endsWith":
+			if strings.HasSuffix(userKey, value) {
+				return true
+			}
+		case "contains":
+			if strings.Contains(userKey, value) {
+				return true
+			}
+		case "matches":
+			if matched, _ := regexp.MatchString(value, userKey); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
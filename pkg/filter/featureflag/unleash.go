@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package featureflag
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/hashtool"
+)
+
+const (
+	unleashFeaturesPath = "/client/features"
+
+	unleashEnabled  = "true"
+	unleashDisabled = "false"
+)
+
+type (
+	// UnleashSpec describes the Unleash provider.
+	UnleashSpec struct {
+		// URL is the API URL of Unleash, e.g. http://unleash.example.com/api
+		URL          string `yaml:"url" jsonschema:"required,format=url"`
+		APIToken     string `yaml:"apiToken" jsonschema:"omitempty"`
+		AppName      string `yaml:"appName" jsonschema:"omitempty"`
+		InstanceID   string `yaml:"instanceID" jsonschema:"omitempty"`
+		PollInterval string `yaml:"pollInterval" jsonschema:"omitempty,format=duration"`
+	}
+
+	unleash struct {
+		spec         *UnleashSpec
+		client       *http.Client
+		pollInterval time.Duration
+		etag         string
+	}
+
+	unleashFeatures struct {
+		Features []*unleashFeature `json:"features"`
+	}
+
+	unleashFeature struct {
+		Name       string            `json:"name"`
+		Enabled    bool              `json:"enabled"`
+		Strategies []unleashStrategy `json:"strategies"`
+		Variants   []unleashVariant  `json:"variants"`
+	}
+
+	unleashStrategy struct {
+		Name       string            `json:"name"`
+		Parameters map[string]string `json:"parameters"`
+	}
+
+	unleashVariant struct {
+		Name   string `json:"name"`
+		Weight int    `json:"weight"`
+	}
+)
+
+func newUnleash(spec *UnleashSpec) *unleash {
+	return &unleash{
+		spec:         spec,
+		client:       &http.Client{},
+		pollInterval: parsePollInterval(spec.PollInterval),
+	}
+}
+
+func (u *unleash) name() string {
+	return "Unleash"
+}
+
+func (u *unleash) run(ff *FeatureFlag, done <-chan struct{}) {
+	for {
+		interval := u.pollInterval
+		if err := u.poll(ff); err != nil {
+			ff.recordError(err)
+			interval = retryInterval
+		}
+
+		if !sleep(interval, done) {
+			return
+		}
+	}
+}
+
+func (u *unleash) poll(ff *FeatureFlag) error {
+	url := strings.TrimSuffix(u.spec.URL, "/") + unleashFeaturesPath
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if u.spec.APIToken != "" {
+		req.Header.Set("Authorization", u.spec.APIToken)
+	}
+	if u.spec.AppName != "" {
+		req.Header.Set("UNLEASH-APPNAME", u.spec.AppName)
+	}
+	if u.spec.InstanceID != "" {
+		req.Header.Set("UNLEASH-INSTANCEID", u.spec.InstanceID)
+	}
+	if u.etag != "" {
+		req.Header.Set("If-None-Match", u.etag)
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	features := &unleashFeatures{}
+	if err = json.Unmarshal(body, features); err != nil {
+		return err
+	}
+
+	flags := make(map[string]flag, len(features.Features))
+	for _, f := range features.Features {
+		flags[f.Name] = f
+	}
+	ff.replaceFlags(flags)
+	u.etag = resp.Header.Get("ETag")
+
+	return nil
+}
+
+func (f *unleashFeature) evaluate(userKey string) string {
+	if !f.enabled(userKey) {
+		return unleashDisabled
+	}
+
+	total := 0
+	for _, v := range f.Variants {
+		total += v.Weight
+	}
+	if total <= 0 {
+		return unleashEnabled
+	}
+
+	bucket := int(hashtool.Hash32(f.Name+":"+userKey) % uint32(total))
+	sum := 0
+	for _, v := range f.Variants {
+		sum += v.Weight
+		if bucket < sum {
+			return v.Name
+		}
+	}
+
+	return unleashEnabled
+}
+
+func (f *unleashFeature) enabled(userKey string) bool {
+	if !f.Enabled {
+		return false
+	}
+	if len(f.Strategies) == 0 {
+		return true
+	}
+
+	for _, s := range f.Strategies {
+		if s.enabled(f.Name, userKey) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// enabled evaluates the built-in strategies which only depend on the user,
+// the others are treated as disabled.
+func (s *unleashStrategy) enabled(featureName, userKey string) bool {
+	switch s.Name {
+	case "default":
+		return true
+	case "userWithId":
+		for _, id := range strings.Split(s.Parameters["userIds"], ",") {
+			if strings.TrimSpace(id) == userKey {
+				return true
+			}
+		}
+		return false
+	case "flexibleRollout", "gradualRolloutUserId":
+		percentage, err := strconv.Atoi(s.Parameters["rollout"])
+		if err != nil {
+			percentage, err = strconv.Atoi(s.Parameters["percentage"])
+			if err != nil {
+				return false
+			}
+		}
+		groupID := s.Parameters["groupId"]
+		if groupID == "" {
+			groupID = featureName
+		}
+		return int(hashtool.Hash32(groupID+":"+userKey)%100) < percentage
+	default:
+		return false
+	}
+}
@@ -0,0 +1,491 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graphqlgateway
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/util/graphql"
+)
+
+// All GraphQLGateway instances use one globalTransport in order to reuse
+// some resounces such as keepalive connections.
+var globalTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 60 * time.Second,
+	}).DialContext,
+	TLSClientConfig: &tls.Config{
+		// NOTE: Could make it an paramenter,
+		// when the requests need cross WAN.
+		InsecureSkipVerify: true,
+	},
+	MaxIdleConns:        10240,
+	MaxIdleConnsPerHost: 512,
+	IdleConnTimeout:     90 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+}
+
+// skippedHeaders are the request headers not forwarded to backends.
+var skippedHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade",
+	"Content-Length", "Content-Type", "Accept", "Accept-Encoding",
+}
+
+var placeholderRegexp = regexp.MustCompile(`\{(\w+)\}`)
+
+type (
+	// execution executes a GraphQL request.
+	execution struct {
+		gg        *GraphQLGateway
+		ctx       context.HTTPContext
+		req       *graphqlRequest
+		doc       *graphql.Document
+		op        *graphql.Operation
+		fields    []*graphql.Field
+		variables map[string]interface{}
+	}
+
+	// group is the root fields executed by one request to a backend.
+	group struct {
+		route  *route
+		fields []*graphql.Field
+
+		data   map[string]json.RawMessage
+		errors []interface{}
+		err    error
+	}
+)
+
+// plan groups the root fields by their backends, the fields served by REST
+// backends are grouped individually. Fields named __typename are answered
+// locally so they aren't in any group.
+func (e *execution) plan() ([]*group, map[*graphql.Field]*group, error) {
+	var groups []*group
+	fieldGroups := map[*graphql.Field]*group{}
+	backendGroups := map[*Backend]*group{}
+
+	for _, f := range e.fields {
+		if f.Name == "__typename" {
+			continue
+		}
+
+		key := fieldKey(string(e.op.Type), f.Name)
+		r := e.gg.routes[key]
+		if r == nil && e.gg.spec.DefaultBackend != "" {
+			for _, b := range e.gg.spec.Backends {
+				if b.Name == e.gg.spec.DefaultBackend {
+					r = &route{backend: b}
+				}
+			}
+		}
+		if r == nil {
+			return nil, nil, fmt.Errorf("field %s is not routed", key)
+		}
+
+		if r.rest != nil {
+			g := &group{route: r, fields: []*graphql.Field{f}}
+			groups = append(groups, g)
+			fieldGroups[f] = g
+			continue
+		}
+
+		g := backendGroups[r.backend]
+		if g == nil {
+			g = &group{route: r}
+			backendGroups[r.backend] = g
+			groups = append(groups, g)
+		}
+		g.fields = append(g.fields, f)
+		fieldGroups[f] = g
+	}
+
+	return groups, fieldGroups, nil
+}
+
+func (e *execution) execute() string {
+	groups, fieldGroups, err := e.plan()
+	if err != nil {
+		return e.gg.reject(e.ctx, http.StatusBadRequest, resultInvalidRequest, err.Error())
+	}
+
+	// NOTE: The request is forwarded as is if it's served by one GraphQL
+	// backend entirely, which keeps everything of the backend response.
+	if len(groups) == 1 && groups[0].route.backend != nil && len(groups[0].fields) == len(e.fields) {
+		return e.forward(groups[0].route.backend)
+	}
+
+	if e.op.Type == graphql.OperationMutation {
+		// NOTE: The root fields of mutations are executed serially.
+		for _, g := range groups {
+			e.executeGroup(g)
+		}
+	} else {
+		var wg sync.WaitGroup
+		wg.Add(len(groups))
+		for _, g := range groups {
+			go func(g *group) {
+				defer wg.Done()
+				e.executeGroup(g)
+			}(g)
+		}
+		wg.Wait()
+	}
+
+	result := ""
+	var errors []interface{}
+	for _, g := range groups {
+		errors = append(errors, g.errors...)
+		if g.err != nil {
+			result = resultBackendError
+		}
+	}
+
+	typeName, _ := json.Marshal(strings.Title(string(e.op.Type)))
+	data := bytes.NewBufferString("{")
+	seen := map[string]bool{}
+	for _, f := range e.fields {
+		key := f.ResponseKey()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if len(seen) > 1 {
+			data.WriteString(",")
+		}
+		k, _ := json.Marshal(key)
+		data.Write(k)
+		data.WriteString(":")
+
+		if f.Name == "__typename" {
+			data.Write(typeName)
+		} else if value := fieldGroups[f].data[key]; value != nil {
+			data.Write(value)
+		} else {
+			data.WriteString("null")
+		}
+	}
+	data.WriteString("}")
+
+	writeResponse(e.ctx, http.StatusOK, data.Bytes(), errors)
+	return result
+}
+
+func (e *execution) executeGroup(g *group) {
+	if g.route.rest != nil {
+		g.err = e.executeREST(g)
+	} else {
+		g.err = e.executeGraphQL(g)
+	}
+
+	if g.err == nil {
+		return
+	}
+
+	var name string
+	if g.route.backend != nil {
+		name = g.route.backend.Name
+	} else {
+		name = g.route.rest.backendName()
+	}
+	for _, f := range g.fields {
+		g.errors = append(g.errors, &graphqlError{
+			Message: fmt.Sprintf("backend %s: %v", name, g.err),
+			Path:    []interface{}{f.ResponseKey()},
+		})
+	}
+}
+
+// forward forwards the request to the backend as is.
+func (e *execution) forward(b *Backend) string {
+	body, _ := json.Marshal(e.req)
+	resp, err := e.post(b.URL, http.MethodPost, body)
+	if err != nil {
+		writeResponse(e.ctx, http.StatusBadGateway, nil, []interface{}{
+			&graphqlError{Message: fmt.Sprintf("backend %s: %v", b.Name, err)},
+		})
+		return resultBackendError
+	}
+
+	w := e.ctx.Response()
+	w.SetStatusCode(resp.StatusCode)
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.SetBody(resp.Body)
+	return ""
+}
+
+// executeGraphQL executes the fields of the group by a sub-operation with
+// the fragments and the variables used by them.
+func (e *execution) executeGraphQL(g *group) error {
+	sels := make([]graphql.Selection, len(g.fields))
+	for i, f := range g.fields {
+		sels[i] = f
+	}
+	fragments := e.doc.FragmentsOf(sels)
+
+	// NOTE: The directives of the operation may use variables too.
+	used := graphql.VariablesOf(append(sels, &graphql.InlineFragment{Directives: e.op.Directives}), fragments)
+	op := &graphql.Operation{
+		Type:         e.op.Type,
+		Name:         e.op.Name,
+		Directives:   e.op.Directives,
+		SelectionSet: sels,
+	}
+	var variables map[string]interface{}
+	for _, def := range e.op.VariableDefinitions {
+		if !used[def.Variable] {
+			continue
+		}
+		op.VariableDefinitions = append(op.VariableDefinitions, def)
+		if value, exists := e.req.Variables[def.Variable]; exists {
+			if variables == nil {
+				variables = map[string]interface{}{}
+			}
+			variables[def.Variable] = value
+		}
+	}
+
+	body, _ := json.Marshal(&graphqlRequest{
+		Query:         graphql.PrintOperation(op, fragments),
+		OperationName: e.op.Name,
+		Variables:     variables,
+	})
+	resp, err := e.post(g.route.backend.URL, http.MethodPost, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	result := struct {
+		Data   map[string]json.RawMessage `json:"data"`
+		Errors []json.RawMessage          `json:"errors"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("status code %d, invalid response: %v", resp.StatusCode, err)
+	}
+
+	g.data = result.Data
+	for _, err := range result.Errors {
+		g.errors = append(g.errors, err)
+	}
+	return nil
+}
+
+// executeREST executes the field of the group by the REST API, the
+// arguments of the field are either in the placeholders of the URL, the
+// query (GET and DELETE) or the JSON body (others). The response is
+// projected by the selection set of the field.
+func (e *execution) executeREST(g *group) error {
+	rest, f := g.route.rest, g.fields[0]
+
+	args := map[string]interface{}{}
+	for _, arg := range f.Arguments {
+		args[arg.Name] = arg.Value.Resolve(e.variables)
+	}
+
+	u := placeholderRegexp.ReplaceAllStringFunc(rest.URL, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		value, exists := args[name]
+		if !exists {
+			return placeholder
+		}
+		delete(args, name)
+		return url.PathEscape(formatArgument(value))
+	})
+
+	method := rest.method()
+	var body []byte
+	if method == http.MethodGet || method == http.MethodDelete {
+		if len(args) != 0 {
+			query := url.Values{}
+			for name, value := range args {
+				if list, ok := value.([]interface{}); ok {
+					for _, elem := range list {
+						query.Add(name, formatArgument(elem))
+					}
+				} else {
+					query.Set(name, formatArgument(value))
+				}
+			}
+			if strings.Contains(u, "?") {
+				u += "&" + query.Encode()
+			} else {
+				u += "?" + query.Encode()
+			}
+		}
+	} else {
+		body, _ = json.Marshal(args)
+	}
+
+	resp, err := e.post(u, method, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+
+	var value interface{}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil && err != io.EOF {
+		return fmt.Errorf("invalid response: %v", err)
+	}
+
+	buff := bytes.NewBuffer(nil)
+	e.project(buff, value, f.SelectionSet)
+	g.data = map[string]json.RawMessage{f.ResponseKey(): buff.Bytes()}
+	return nil
+}
+
+// project writes the value in JSON with only the fields in the selection
+// set, which are renamed by their aliases.
+func (e *execution) project(buff *bytes.Buffer, value interface{}, sels []graphql.Selection) {
+	if len(sels) == 0 {
+		data, _ := json.Marshal(value)
+		buff.Write(data)
+		return
+	}
+
+	switch value := value.(type) {
+	case []interface{}:
+		buff.WriteString("[")
+		for i, elem := range value {
+			if i > 0 {
+				buff.WriteString(",")
+			}
+			e.project(buff, elem, sels)
+		}
+		buff.WriteString("]")
+	case map[string]interface{}:
+		buff.WriteString("{")
+		seen := map[string]bool{}
+		for _, f := range e.doc.CollectFields(sels, e.variables) {
+			key := f.ResponseKey()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			if len(seen) > 1 {
+				buff.WriteString(",")
+			}
+			k, _ := json.Marshal(key)
+			buff.Write(k)
+			buff.WriteString(":")
+			// NOTE: The types of REST responses are unknown,
+			// so __typename is always null.
+			e.project(buff, value[f.Name], f.SelectionSet)
+		}
+		buff.WriteString("}")
+	default:
+		data, _ := json.Marshal(value)
+		buff.Write(data)
+	}
+}
+
+func (e *execution) post(u, method string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(e.ctx.Request().Std().Context(), method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = e.ctx.Request().Header().Std().Clone()
+	for _, key := range skippedHeaders {
+		req.Header.Del(key)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return e.gg.client.Do(req)
+}
+
+func (r *RESTField) method() string {
+	if r.Method == "" {
+		return http.MethodGet
+	}
+	return r.Method
+}
+
+func (r *RESTField) backendName() string {
+	return fmt.Sprintf("%s %s", r.method(), r.URL)
+}
+
+func formatArgument(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		data, _ := json.Marshal(value)
+		return string(data)
+	}
+}
+
+// writeResponse writes the GraphQL response, the data is omitted if it's nil.
+func writeResponse(ctx context.HTTPContext, statusCode int, data []byte, errors []interface{}) {
+	buff := bytes.NewBufferString("{")
+	if data != nil {
+		buff.WriteString(`"data":`)
+		buff.Write(data)
+	}
+	if len(errors) != 0 {
+		if data != nil {
+			buff.WriteString(",")
+		}
+		e, _ := json.Marshal(errors)
+		buff.WriteString(`"errors":`)
+		buff.Write(e)
+	}
+	buff.WriteString("}")
+
+	w := ctx.Response()
+	w.SetStatusCode(statusCode)
+	w.Header().Set("Content-Type", "application/json")
+	w.SetBody(bytes.NewReader(buff.Bytes()))
+}
@@ -0,0 +1,409 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graphqlgateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/graphql"
+	"github.com/megaease/easegress/pkg/util/ratelimiter"
+)
+
+const (
+	// Kind is the kind of GraphQLGateway.
+	Kind = "GraphQLGateway"
+
+	resultInvalidRequest = "invalidRequest"
+	resultLimitExceeded  = "limitExceeded"
+	resultRateLimited    = "rateLimited"
+	resultBackendError   = "backendError"
+)
+
+var results = []string{resultInvalidRequest, resultLimitExceeded, resultRateLimited, resultBackendError}
+
+func init() {
+	httppipeline.Register(&GraphQLGateway{})
+}
+
+type (
+	// GraphQLGateway is the filter parsing GraphQL requests, enforcing
+	// limits on them, and routing their root fields to GraphQL or REST
+	// backends, whose results are stitched into one response.
+	GraphQLGateway struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		client   *http.Client
+		routes   map[string]*route
+		limiters map[string]*ratelimiter.RateLimiter
+
+		statusMutex sync.Mutex
+		fields      map[string]uint64
+		rejected    uint64
+	}
+
+	// Spec describes the GraphQLGateway.
+	Spec struct {
+		Backends   []*Backend   `yaml:"backends" jsonschema:"omitempty"`
+		RESTFields []*RESTField `yaml:"restFields" jsonschema:"omitempty"`
+		// DefaultBackend serves the root fields not routed explicitly,
+		// and the introspection queries.
+		DefaultBackend  string            `yaml:"defaultBackend" jsonschema:"omitempty"`
+		MaxDepth        int               `yaml:"maxDepth" jsonschema:"omitempty,minimum=0"`
+		MaxComplexity   int               `yaml:"maxComplexity" jsonschema:"omitempty,minimum=0"`
+		FieldRateLimits []*FieldRateLimit `yaml:"fieldRateLimits" jsonschema:"omitempty"`
+		Timeout         string            `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+		MaxBodySize     int64             `yaml:"maxBodySize" jsonschema:"omitempty,minimum=1"`
+	}
+
+	// Backend is a GraphQL backend.
+	Backend struct {
+		Name string `yaml:"name" jsonschema:"required"`
+		URL  string `yaml:"url" jsonschema:"required,format=uri"`
+		// Queries and Mutations are the root fields served by the backend.
+		Queries   []string `yaml:"queries" jsonschema:"omitempty,uniqueItems=true"`
+		Mutations []string `yaml:"mutations" jsonschema:"omitempty,uniqueItems=true"`
+	}
+
+	// RESTField is a root field served by a REST backend.
+	RESTField struct {
+		Operation string `yaml:"operation" jsonschema:"omitempty,enum=,enum=query,enum=mutation"`
+		Field     string `yaml:"field" jsonschema:"required"`
+		Method    string `yaml:"method" jsonschema:"omitempty,enum=,enum=GET,enum=POST,enum=PUT,enum=PATCH,enum=DELETE"`
+		// URL is the URL of the REST API, the placeholders like {id} are
+		// replaced by the arguments of the field.
+		URL string `yaml:"url" jsonschema:"required"`
+	}
+
+	// FieldRateLimit limits the requests per second of a root field.
+	FieldRateLimit struct {
+		Operation string `yaml:"operation" jsonschema:"omitempty,enum=,enum=query,enum=mutation"`
+		Field     string `yaml:"field" jsonschema:"required"`
+		Limit     int    `yaml:"limit" jsonschema:"required,minimum=1"`
+	}
+
+	// Status is the status of GraphQLGateway.
+	Status struct {
+		// Fields are the numbers of requests of root fields.
+		Fields   map[string]uint64 `yaml:"fields"`
+		Rejected uint64            `yaml:"rejected"`
+	}
+
+	// route is the route of a root field, exactly one of backend and
+	// rest is not nil.
+	route struct {
+		backend *Backend
+		rest    *RESTField
+	}
+
+	graphqlRequest struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName,omitempty"`
+		Variables     map[string]interface{} `json:"variables,omitempty"`
+	}
+
+	graphqlError struct {
+		Message string        `json:"message"`
+		Path    []interface{} `json:"path,omitempty"`
+	}
+)
+
+// fieldKey returns the key of the root field like query.user.
+func fieldKey(operation, field string) string {
+	if operation == "" {
+		operation = string(graphql.OperationQuery)
+	}
+	return operation + "." + field
+}
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if len(spec.Backends) == 0 && len(spec.RESTFields) == 0 {
+		return fmt.Errorf("none of backends and restFields")
+	}
+
+	names := map[string]bool{}
+	fields := map[string]bool{}
+	addField := func(key string) error {
+		if fields[key] {
+			return fmt.Errorf("field %s is routed repeatedly", key)
+		}
+		fields[key] = true
+		return nil
+	}
+
+	for _, b := range spec.Backends {
+		if names[b.Name] {
+			return fmt.Errorf("backend %s is repeated", b.Name)
+		}
+		names[b.Name] = true
+		for _, field := range b.Queries {
+			if err := addField(fieldKey("query", field)); err != nil {
+				return err
+			}
+		}
+		for _, field := range b.Mutations {
+			if err := addField(fieldKey("mutation", field)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, r := range spec.RESTFields {
+		if err := addField(fieldKey(r.Operation, r.Field)); err != nil {
+			return err
+		}
+	}
+
+	if spec.DefaultBackend != "" && !names[spec.DefaultBackend] {
+		return fmt.Errorf("default backend %s not found", spec.DefaultBackend)
+	}
+
+	limits := map[string]bool{}
+	for _, l := range spec.FieldRateLimits {
+		key := fieldKey(l.Operation, l.Field)
+		if limits[key] {
+			return fmt.Errorf("rate limit of field %s is repeated", key)
+		}
+		limits[key] = true
+	}
+	return nil
+}
+
+// Kind returns the kind of GraphQLGateway.
+func (gg *GraphQLGateway) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of GraphQLGateway.
+func (gg *GraphQLGateway) DefaultSpec() interface{} {
+	return &Spec{
+		MaxDepth:      10,
+		MaxComplexity: 1000,
+		Timeout:       "30s",
+		MaxBodySize:   1024 * 1024,
+	}
+}
+
+// Description returns the description of GraphQLGateway.
+func (gg *GraphQLGateway) Description() string {
+	return "GraphQLGateway limits GraphQL requests and routes them to GraphQL or REST backends."
+}
+
+// Results returns the results of GraphQLGateway.
+func (gg *GraphQLGateway) Results() []string {
+	return results
+}
+
+// Init initializes GraphQLGateway.
+func (gg *GraphQLGateway) Init(filterSpec *httppipeline.FilterSpec) {
+	gg.filterSpec, gg.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	gg.reload()
+}
+
+// Inherit inherits previous generation of GraphQLGateway.
+func (gg *GraphQLGateway) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	gg.Init(filterSpec)
+}
+
+func (gg *GraphQLGateway) reload() {
+	timeout, _ := time.ParseDuration(gg.spec.Timeout)
+	gg.client = &http.Client{Timeout: timeout, Transport: globalTransport}
+
+	gg.routes = map[string]*route{}
+	for _, b := range gg.spec.Backends {
+		for _, field := range b.Queries {
+			gg.routes[fieldKey("query", field)] = &route{backend: b}
+		}
+		for _, field := range b.Mutations {
+			gg.routes[fieldKey("mutation", field)] = &route{backend: b}
+		}
+	}
+	for _, r := range gg.spec.RESTFields {
+		gg.routes[fieldKey(r.Operation, r.Field)] = &route{rest: r}
+	}
+
+	gg.limiters = map[string]*ratelimiter.RateLimiter{}
+	for _, l := range gg.spec.FieldRateLimits {
+		gg.limiters[fieldKey(l.Operation, l.Field)] = ratelimiter.New(ratelimiter.NewPolicy(0, 1000, l.Limit))
+	}
+
+	gg.fields = map[string]uint64{}
+}
+
+// Handle handles the GraphQL request.
+func (gg *GraphQLGateway) Handle(ctx context.HTTPContext) string {
+	result := gg.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (gg *GraphQLGateway) handle(ctx context.HTTPContext) string {
+	req, err := gg.readRequest(ctx)
+	if err != nil {
+		return gg.reject(ctx, http.StatusBadRequest, resultInvalidRequest, err.Error())
+	}
+
+	doc, err := graphql.Parse(req.Query)
+	if err != nil {
+		return gg.reject(ctx, http.StatusBadRequest, resultInvalidRequest, err.Error())
+	}
+	op, err := doc.Operation(req.OperationName)
+	if err != nil {
+		return gg.reject(ctx, http.StatusBadRequest, resultInvalidRequest, err.Error())
+	}
+	if op.Type == graphql.OperationSubscription {
+		return gg.reject(ctx, http.StatusBadRequest, resultInvalidRequest, "subscription is not supported")
+	}
+	ctx.AddTag(fmt.Sprintf("graphql: %s %s", op.Type, op.Name))
+
+	if depth := doc.Depth(op.SelectionSet); gg.spec.MaxDepth > 0 && depth > gg.spec.MaxDepth {
+		return gg.reject(ctx, http.StatusBadRequest, resultLimitExceeded,
+			fmt.Sprintf("query depth %d exceeds the limit %d", depth, gg.spec.MaxDepth))
+	}
+	if complexity := doc.Complexity(op.SelectionSet); gg.spec.MaxComplexity > 0 && complexity > gg.spec.MaxComplexity {
+		return gg.reject(ctx, http.StatusBadRequest, resultLimitExceeded,
+			fmt.Sprintf("query complexity %d exceeds the limit %d", complexity, gg.spec.MaxComplexity))
+	}
+
+	variables := op.VariableValues(req.Variables)
+	fields := doc.CollectFields(op.SelectionSet, variables)
+	if message := gg.limitFields(op, fields); message != "" {
+		return gg.reject(ctx, http.StatusTooManyRequests, resultRateLimited, message)
+	}
+
+	e := &execution{
+		gg:        gg,
+		ctx:       ctx,
+		req:       req,
+		doc:       doc,
+		op:        op,
+		fields:    fields,
+		variables: variables,
+	}
+	return e.execute()
+}
+
+// limitFields records the requests of the root fields and checks their
+// rate limits, it returns the error message if any field is limited.
+func (gg *GraphQLGateway) limitFields(op *graphql.Operation, fields []*graphql.Field) string {
+	seen := map[string]bool{}
+	for _, f := range fields {
+		key := fieldKey(string(op.Type), f.Name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if limiter := gg.limiters[key]; limiter != nil {
+			if permitted, _ := limiter.AcquirePermission(); !permitted {
+				return fmt.Sprintf("field %s is rate limited", key)
+			}
+		}
+	}
+
+	gg.statusMutex.Lock()
+	for key := range seen {
+		gg.fields[key]++
+	}
+	gg.statusMutex.Unlock()
+	return ""
+}
+
+// readRequest reads the GraphQL request of GET or POST, the body of POST
+// is either JSON or the query with the content type application/graphql.
+func (gg *GraphQLGateway) readRequest(ctx context.HTTPContext) (*graphqlRequest, error) {
+	r := ctx.Request()
+	req := &graphqlRequest{}
+
+	switch r.Method() {
+	case http.MethodGet:
+		query := r.Std().URL.Query()
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			if err := unmarshal([]byte(variables), &req.Variables); err != nil {
+				return nil, fmt.Errorf("invalid variables: %v", err)
+			}
+		}
+	case http.MethodPost:
+		body := bytes.NewBuffer(nil)
+		n, err := io.CopyN(body, r.Body(), gg.spec.MaxBodySize+1)
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("read body failed: %v", err)
+		}
+		if n > gg.spec.MaxBodySize {
+			return nil, fmt.Errorf("body is larger than %dB", gg.spec.MaxBodySize)
+		}
+
+		contentType := strings.ToLower(r.Header().Get("Content-Type"))
+		if strings.HasPrefix(contentType, "application/graphql") {
+			req.Query = body.String()
+		} else if err := unmarshal(body.Bytes(), req); err != nil {
+			return nil, fmt.Errorf("invalid body: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("method %s is not allowed", r.Method())
+	}
+
+	if req.Query == "" {
+		return nil, fmt.Errorf("empty query")
+	}
+	return req, nil
+}
+
+// unmarshal unmarshals JSON with numbers kept as json.Number,
+// so that the integers are forwarded to backends precisely.
+func unmarshal(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func (gg *GraphQLGateway) reject(ctx context.HTTPContext, statusCode int, result, message string) string {
+	gg.statusMutex.Lock()
+	gg.rejected++
+	gg.statusMutex.Unlock()
+
+	ctx.AddTag(fmt.Sprintf("graphqlGatewayErr: %s", message))
+	writeResponse(ctx, statusCode, nil, []interface{}{&graphqlError{Message: message}})
+	return result
+}
+
+// Status returns status.
+func (gg *GraphQLGateway) Status() interface{} {
+	gg.statusMutex.Lock()
+	defer gg.statusMutex.Unlock()
+
+	s := &Status{Fields: make(map[string]uint64, len(gg.fields)), Rejected: gg.rejected}
+	for key, count := range gg.fields {
+		s.Fields[key] = count
+	}
+	return s
+}
+
+// Close closes GraphQLGateway.
+func (gg *GraphQLGateway) Close() {}
@@ -0,0 +1,218 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graphqlgateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+type testBackends struct {
+	graphql *httptest.Server
+	rest    *httptest.Server
+
+	lastGraphQL *graphqlRequest
+	lastREST    *http.Request
+	lastBody    string
+}
+
+func newTestBackends(t *testing.T) *testBackends {
+	tb := &testBackends{}
+	tb.graphql = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &graphqlRequest{}
+		json.NewDecoder(r.Body).Decode(req)
+		tb.lastGraphQL = req
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{"user":{"id":"1","name":"alice"}}}`))
+	}))
+	tb.rest = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		tb.lastREST, tb.lastBody = r, string(body)
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"id":7,"secret":"x","items":[{"sku":"a","qty":1},{"sku":"b","qty":2}]}`))
+	}))
+	return tb
+}
+
+func (tb *testBackends) close() {
+	tb.graphql.Close()
+	tb.rest.Close()
+}
+
+func newGateway(t *testing.T, tb *testBackends, extra string) *GraphQLGateway {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(`
+kind: GraphQLGateway
+name: graphql
+maxDepth: 4
+backends:
+- name: users
+  url: `+tb.graphql.URL+`
+  queries: [user]
+restFields:
+- field: order
+  url: `+tb.rest.URL+`/orders/{id}
+- operation: mutation
+  field: createOrder
+  method: POST
+  url: `+tb.rest.URL+`/orders
+- field: broken
+  url: `+tb.rest.URL+`/broken
+`+extra), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gg := &GraphQLGateway{}
+	gg.Init(spec)
+	return gg
+}
+
+func doRequest(gg *GraphQLGateway, req *graphqlRequest) (string, int, string) {
+	body, _ := json.Marshal(req)
+	stdr, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1/graphql", bytes.NewReader(body))
+	stdr.Header.Set("Content-Type", "application/json")
+	stdr.Header.Set("Authorization", "Bearer token")
+
+	ctx := context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		return lastResult
+	})
+
+	result := gg.Handle(ctx)
+	respBody, _ := ioutil.ReadAll(ctx.Response().Body())
+	return result, ctx.Response().StatusCode(), string(respBody)
+}
+
+func TestGraphQLGatewayStitch(t *testing.T) {
+	tb := newTestBackends(t)
+	defer tb.close()
+	gg := newGateway(t, tb, "")
+
+	result, code, body := doRequest(gg, &graphqlRequest{
+		Query: `query Q($id: ID!, $orderID: Int, $unused: Int) {
+			user(id: $id) { ...UserFields }
+			o: order(id: $orderID) { id items { sku } }
+			__typename
+		}
+		fragment UserFields on User { id name }`,
+		Variables: map[string]interface{}{"id": "1", "orderID": 7, "unused": 1},
+	})
+	expected := `{"data":{"user":{"id":"1","name":"alice"},"o":{"id":7,"items":[{"sku":"a"},{"sku":"b"}]},"__typename":"Query"}}`
+	if result != "" || code != http.StatusOK || body != expected {
+		t.Errorf("unexpected response: %s, %d, %s", result, code, body)
+	}
+
+	expectedQuery := `query Q($id:ID!){user(id:$id){...UserFields}} fragment UserFields on User{id name}`
+	if tb.lastGraphQL.Query != expectedQuery || len(tb.lastGraphQL.Variables) != 1 {
+		t.Errorf("unexpected sub request: %+v", tb.lastGraphQL)
+	}
+	if tb.lastREST.URL.Path != "/orders/7" || tb.lastREST.Header.Get("Authorization") != "Bearer token" {
+		t.Errorf("unexpected REST request: %s %v", tb.lastREST.URL, tb.lastREST.Header)
+	}
+
+	result, _, body = doRequest(gg, &graphqlRequest{
+		Query: `mutation { createOrder(sku: "a", qty: 2) { id } }`,
+	})
+	if result != "" || body != `{"data":{"createOrder":{"id":7}}}` {
+		t.Errorf("unexpected response: %s, %s", result, body)
+	}
+	if tb.lastREST.Method != http.MethodPost || tb.lastBody != `{"qty":2,"sku":"a"}` {
+		t.Errorf("unexpected REST request: %s %s", tb.lastREST.Method, tb.lastBody)
+	}
+
+	result, _, body = doRequest(gg, &graphqlRequest{Query: `{ user(id: 1) { id } broken { id } }`})
+	if result != resultBackendError || !strings.Contains(body, `"broken":null`) ||
+		!strings.Contains(body, `"path":["broken"]`) {
+		t.Errorf("unexpected response: %s, %s", result, body)
+	}
+}
+
+func TestGraphQLGatewayForward(t *testing.T) {
+	tb := newTestBackends(t)
+	defer tb.close()
+	gg := newGateway(t, tb, "defaultBackend: users\n")
+
+	query := `{ user(id: 1) { name } __schema { types { name } } }`
+	result, code, body := doRequest(gg, &graphqlRequest{Query: query})
+	if result != "" || code != http.StatusOK || body != `{"data":{"user":{"id":"1","name":"alice"}}}` {
+		t.Errorf("unexpected response: %s, %d, %s", result, code, body)
+	}
+	if tb.lastGraphQL.Query != query {
+		t.Errorf("query should be forwarded as is: %s", tb.lastGraphQL.Query)
+	}
+}
+
+func TestGraphQLGatewayLimits(t *testing.T) {
+	tb := newTestBackends(t)
+	defer tb.close()
+	gg := newGateway(t, tb, `
+maxComplexity: 5
+fieldRateLimits:
+- field: order
+  limit: 1
+`)
+
+	cases := []struct {
+		query  string
+		result string
+		code   int
+	}{
+		{`{ user { a { b { c { d } } } } }`, resultLimitExceeded, http.StatusBadRequest},
+		{`{ user { a b c d e f } }`, resultLimitExceeded, http.StatusBadRequest},
+		{`{ unknown }`, resultInvalidRequest, http.StatusBadRequest},
+		{`{ user `, resultInvalidRequest, http.StatusBadRequest},
+		{`subscription { user }`, resultInvalidRequest, http.StatusBadRequest},
+		{`{ order(id: 1) { id } }`, "", http.StatusOK},
+		{`{ order(id: 1) { id } }`, resultRateLimited, http.StatusTooManyRequests},
+	}
+
+	for _, c := range cases {
+		result, code, body := doRequest(gg, &graphqlRequest{Query: c.query})
+		if result != c.result || code != c.code {
+			t.Errorf("%s: unexpected response: %s, %d, %s", c.query, result, code, body)
+		}
+	}
+
+	status := gg.Status().(*Status)
+	if status.Rejected != 6 || status.Fields["query.order"] != 1 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
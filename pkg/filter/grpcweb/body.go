@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcweb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	trailerFlag = 0x80
+	readBuffLen = 32 * 1024
+)
+
+// responseBody translates the body of the gRPC response into gRPC-Web,
+// it appends the trailers to the body as a frame, and encodes the body
+// by base64 in text mode.
+type responseBody struct {
+	resp   *http.Response
+	cancel func()
+	text   bool
+
+	readBuff []byte
+	// buff is the translated data not read yet.
+	buff bytes.Buffer
+	// rest is the data not encoded yet in text mode, base64 encodes
+	// every 3 bytes, so the rest is less than 3 bytes.
+	rest []byte
+	eof  bool
+}
+
+func newResponseBody(resp *http.Response, cancel func(), text bool) *responseBody {
+	return &responseBody{
+		resp:     resp,
+		cancel:   cancel,
+		text:     text,
+		readBuff: make([]byte, readBuffLen),
+	}
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	for b.buff.Len() == 0 && !b.eof {
+		b.fill()
+	}
+	if b.buff.Len() == 0 {
+		return 0, io.EOF
+	}
+	return b.buff.Read(p)
+}
+
+// WriteTo writes the body to w, and flushes w after every read of the
+// gRPC response, so that the messages of server streaming are sent to
+// clients in time. io.Copy uses it instead of Read.
+func (b *responseBody) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for {
+		for b.buff.Len() == 0 && !b.eof {
+			b.fill()
+		}
+		if b.buff.Len() == 0 {
+			return written, nil
+		}
+
+		n, err := w.Write(b.buff.Bytes())
+		written += int64(n)
+		b.buff.Reset()
+		if err != nil {
+			return written, err
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+}
+
+// fill reads the gRPC response once, and writes the translated data into
+// buff. The trailers are written after the end of the response.
+func (b *responseBody) fill() {
+	n, err := b.resp.Body.Read(b.readBuff)
+	if n > 0 {
+		b.write(b.readBuff[:n])
+	}
+
+	switch err {
+	case nil:
+		return
+	case io.EOF:
+		b.write(trailerFrame(b.resp.Trailer))
+	default:
+		logger.Errorf("read grpc response failed: %v", err)
+		b.write(trailerFrame(http.Header{
+			"Grpc-Status":  []string{"14"},
+			"Grpc-Message": []string{fmt.Sprintf("read response failed: %v", err)},
+		}))
+	}
+
+	if b.text && len(b.rest) > 0 {
+		b.buff.WriteString(base64.StdEncoding.EncodeToString(b.rest))
+		b.rest = nil
+	}
+	b.eof = true
+}
+
+func (b *responseBody) write(data []byte) {
+	if !b.text {
+		b.buff.Write(data)
+		return
+	}
+
+	data = append(b.rest, data...)
+	n := len(data) / 3 * 3
+	b.buff.WriteString(base64.StdEncoding.EncodeToString(data[:n]))
+	b.rest = append([]byte(nil), data[n:]...)
+}
+
+// Close closes the gRPC response.
+func (b *responseBody) Close() error {
+	err := b.resp.Body.Close()
+	b.cancel()
+	return err
+}
+
+// trailerFrame returns the frame of trailers, which are encoded like
+// HTTP/1 headers with lower case keys. It returns nil if there's no
+// trailer, which happens in trailers-only responses.
+func trailerFrame(trailer http.Header) []byte {
+	if len(trailer) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(trailer))
+	for key := range trailer {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	payload := bytes.NewBuffer(nil)
+	for _, key := range keys {
+		for _, value := range trailer[key] {
+			payload.WriteString(strings.ToLower(key))
+			payload.WriteString(": ")
+			payload.WriteString(value)
+			payload.WriteString("\r\n")
+		}
+	}
+
+	if payload.Len() == 0 {
+		return nil
+	}
+
+	frame := make([]byte, 5, 5+payload.Len())
+	frame[0] = trailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(payload.Len()))
+	return append(frame, payload.Bytes()...)
+}
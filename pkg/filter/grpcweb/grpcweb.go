@@ -0,0 +1,297 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcweb
+
+import (
+	"bytes"
+	stdcontext "context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of GRPCWeb.
+	Kind = "GRPCWeb"
+
+	resultInvalidRequest = "invalidRequest"
+	resultServerError    = "serverError"
+
+	contentTypeGRPC    = "application/grpc"
+	contentTypeWeb     = "application/grpc-web"
+	contentTypeWebText = "application/grpc-web-text"
+
+	// maxTextBodyBytes is the max size of request bodies in text mode,
+	// which are decoded before forwarding. It's the default max message
+	// size of gRPC.
+	maxTextBodyBytes = 4 * 1024 * 1024
+)
+
+var results = []string{resultInvalidRequest, resultServerError}
+
+// hopHeaders are the headers not forwarded to the gRPC server.
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding",
+	"Upgrade", "Content-Length", "Accept-Encoding", "X-Grpc-Web",
+}
+
+func init() {
+	httppipeline.Register(&GRPCWeb{})
+}
+
+type (
+	// GRPCWeb is the filter terminating gRPC-Web requests of browsers and
+	// forwarding them to the gRPC server as native gRPC.
+	GRPCWeb struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		serverURL *url.URL
+		timeout   time.Duration
+		transport *http2.Transport
+	}
+
+	// Spec describes the GRPCWeb.
+	Spec struct {
+		// Server is the URL of the gRPC server, the scheme http means
+		// HTTP/2 over cleartext, and https means HTTP/2 over TLS.
+		Server  string `yaml:"server" jsonschema:"required,format=uri"`
+		Timeout string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	u, err := url.Parse(spec.Server)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %s of server", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("empty host of server")
+	}
+	return nil
+}
+
+// Kind returns the kind of GRPCWeb.
+func (gw *GRPCWeb) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of GRPCWeb.
+func (gw *GRPCWeb) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of GRPCWeb.
+func (gw *GRPCWeb) Description() string {
+	return "GRPCWeb translates gRPC-Web requests into gRPC ones."
+}
+
+// Results returns the results of GRPCWeb.
+func (gw *GRPCWeb) Results() []string {
+	return results
+}
+
+// Init initializes GRPCWeb.
+func (gw *GRPCWeb) Init(filterSpec *httppipeline.FilterSpec) {
+	gw.filterSpec, gw.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	gw.reload()
+}
+
+// Inherit inherits previous generation of GRPCWeb.
+func (gw *GRPCWeb) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	gw.Init(filterSpec)
+}
+
+func (gw *GRPCWeb) reload() {
+	// NOTE: The spec has been validated.
+	gw.serverURL, _ = url.Parse(gw.spec.Server)
+	if gw.spec.Timeout != "" {
+		gw.timeout, _ = time.ParseDuration(gw.spec.Timeout)
+	}
+
+	gw.transport = &http2.Transport{
+		DisableCompression: true,
+		TLSClientConfig: &tls.Config{
+			// NOTE: Could make it an paramenter,
+			// when the requests need cross WAN.
+			InsecureSkipVerify: true,
+		},
+	}
+	if gw.serverURL.Scheme == "http" {
+		// NOTE: HTTP/2 over cleartext, i.e. h2c with prior knowledge.
+		gw.transport.AllowHTTP = true
+		gw.transport.DialTLS = func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.DialTimeout(network, addr, 30*time.Second)
+		}
+	}
+}
+
+// Handle translates the gRPC-Web request and forwards it to the gRPC server.
+func (gw *GRPCWeb) Handle(ctx context.HTTPContext) string {
+	result := gw.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (gw *GRPCWeb) handle(ctx context.HTTPContext) string {
+	r, w := ctx.Request(), ctx.Response()
+
+	webType, subtype, ok := parseContentType(r.Header().Get("Content-Type"))
+	if !ok {
+		w.SetStatusCode(http.StatusUnsupportedMediaType)
+		ctx.AddTag(fmt.Sprintf("grpcWebErr: unsupported content type %s", r.Header().Get("Content-Type")))
+		return resultInvalidRequest
+	}
+	text := webType == contentTypeWebText
+
+	body := r.Body()
+	if text {
+		decoded, err := decodeTextBody(body)
+		if err != nil {
+			w.SetStatusCode(http.StatusBadRequest)
+			ctx.AddTag(fmt.Sprintf("grpcWebErr: decode body: %v", err))
+			return resultInvalidRequest
+		}
+		body = bytes.NewReader(decoded)
+	}
+
+	u := *gw.serverURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + r.Path()
+
+	stdctx, cancel := stdcontext.WithCancel(r.Std().Context())
+	if gw.timeout > 0 {
+		stdctx, cancel = stdcontext.WithTimeout(r.Std().Context(), gw.timeout)
+	}
+
+	req, err := http.NewRequestWithContext(stdctx, http.MethodPost, u.String(), body)
+	if err != nil {
+		cancel()
+		logger.Errorf("BUG: new request failed: %v", err)
+		w.SetStatusCode(http.StatusInternalServerError)
+		return resultServerError
+	}
+	if reader, ok := body.(*bytes.Reader); ok {
+		req.ContentLength = int64(reader.Len())
+	}
+	req.Header = r.Header().Std().Clone()
+	for _, key := range hopHeaders {
+		req.Header.Del(key)
+	}
+	req.Header.Set("Content-Type", contentTypeGRPC+subtype)
+	req.Header.Set("Te", "trailers")
+
+	resp, err := gw.transport.RoundTrip(req)
+	if err != nil {
+		cancel()
+		w.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.AddTag(fmt.Sprintf("grpcWebErr: %v", err))
+		return resultServerError
+	}
+
+	for _, key := range []string{"Connection", "Content-Length", "Trailer", "Transfer-Encoding"} {
+		resp.Header.Del(key)
+	}
+	respSubtype := subtype
+	if _, s, ok := parseContentType(resp.Header.Get("Content-Type")); ok {
+		respSubtype = s
+	}
+	resp.Header.Set("Content-Type", webType+respSubtype)
+
+	w.SetStatusCode(resp.StatusCode)
+	w.Header().Reset(resp.Header)
+	w.SetBody(newResponseBody(resp, cancel, text))
+
+	return ""
+}
+
+// parseContentType parses the content type of gRPC or gRPC-Web, and
+// returns the type without the subtype and the subtype like +proto.
+func parseContentType(contentType string) (string, string, bool) {
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = strings.TrimSpace(contentType[:i])
+	}
+
+	for _, typ := range []string{contentTypeWebText, contentTypeWeb, contentTypeGRPC} {
+		if contentType == typ {
+			return typ, "", true
+		}
+		if strings.HasPrefix(contentType, typ+"+") {
+			return typ, contentType[len(typ):], true
+		}
+	}
+	return "", "", false
+}
+
+// decodeTextBody decodes the base64 body in text mode, which may be
+// concatenated base64 chunks with paddings.
+func decodeTextBody(body io.Reader) ([]byte, error) {
+	buff := bytes.NewBuffer(nil)
+	n, err := io.CopyN(buff, body, maxTextBodyBytes+1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n > maxTextBodyBytes {
+		return nil, fmt.Errorf("larger than %dB", maxTextBodyBytes)
+	}
+
+	encoded := strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, buff.String())
+	if len(encoded)%4 != 0 {
+		return nil, fmt.Errorf("invalid base64 length %d", len(encoded))
+	}
+
+	decoded := make([]byte, 0, len(encoded)/4*3)
+	quantum := make([]byte, 3)
+	for i := 0; i < len(encoded); i += 4 {
+		n, err := base64.StdEncoding.Decode(quantum, []byte(encoded[i:i+4]))
+		if err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, quantum[:n]...)
+	}
+	return decoded, nil
+}
+
+// Status returns status.
+func (gw *GRPCWeb) Status() interface{} { return nil }
+
+// Close closes GRPCWeb.
+func (gw *GRPCWeb) Close() {
+	gw.transport.CloseIdleConnections()
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcweb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func frame(flag byte, payload string) []byte {
+	f := make([]byte, 5, 5+len(payload))
+	f[0] = flag
+	binary.BigEndian.PutUint32(f[1:], uint32(len(payload)))
+	return append(f, payload...)
+}
+
+// newGRPCServer returns a h2c server greeting the name in the request
+// message twice, like a server streaming gRPC method.
+func newGRPCServer(t *testing.T) *httptest.Server {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") || r.Header.Get("X-Grpc-Web") != "" ||
+			r.Header.Get("Te") != "trailers" || r.URL.Path != "/helloworld.Greeter/SayHello" {
+			t.Errorf("unexpected request: %s %s %v", r.Proto, r.URL.Path, r.Header)
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		name := string(body[5:])
+
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.WriteHeader(http.StatusOK)
+		w.Write(frame(0, "hello "+name))
+		w.(http.Flusher).Flush()
+		w.Write(frame(0, "bye "+name))
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "OK")
+	})
+	return httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+}
+
+func newGRPCWeb(t *testing.T, server string) *GRPCWeb {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(`
+kind: GRPCWeb
+name: grpcweb
+server: `+server+`
+timeout: 5s
+`), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gw := &GRPCWeb{}
+	gw.Init(spec)
+	return gw
+}
+
+func doRequest(gw *GRPCWeb, contentType string, body []byte) (string, *httptest.ResponseRecorder, []byte) {
+	stdr, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1/helloworld.Greeter/SayHello", bytes.NewReader(body))
+	stdr.Header.Set("Content-Type", contentType)
+	stdr.Header.Set("X-Grpc-Web", "1")
+
+	recorder := httptest.NewRecorder()
+	ctx := context.New(recorder, stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		return lastResult
+	})
+
+	result := gw.Handle(ctx)
+	var respBody []byte
+	if body := ctx.Response().Body(); body != nil {
+		buff := bytes.NewBuffer(nil)
+		io.Copy(buff, body)
+		body.(io.Closer).Close()
+		respBody = buff.Bytes()
+	}
+	return result, recorder, respBody
+}
+
+func TestGRPCWeb(t *testing.T) {
+	server := newGRPCServer(t)
+	defer server.Close()
+
+	gw := newGRPCWeb(t, server.URL)
+	defer gw.Close()
+
+	expected := append(frame(0, "hello world"), frame(0, "bye world")...)
+	expected = append(expected, frame(trailerFlag, "grpc-message: OK\r\ngrpc-status: 0\r\n")...)
+
+	result, _, body := doRequest(gw, "application/grpc-web+proto", frame(0, "world"))
+	if result != "" {
+		t.Fatalf("unexpected result: %s", result)
+	}
+	if !bytes.Equal(body, expected) {
+		t.Errorf("unexpected body: %q", body)
+	}
+
+	// NOTE: Concatenated base64 chunks with paddings.
+	reqBody := frame(0, "world")
+	encoded := base64.StdEncoding.EncodeToString(reqBody[:4]) + base64.StdEncoding.EncodeToString(reqBody[4:])
+	result, _, body = doRequest(gw, "application/grpc-web-text", []byte(encoded))
+	if result != "" {
+		t.Fatalf("unexpected result: %s", result)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil || !bytes.Equal(decoded, expected) {
+		t.Errorf("unexpected body: %q, %v", body, err)
+	}
+
+	result, _, _ = doRequest(gw, "application/json", nil)
+	if result != resultInvalidRequest {
+		t.Errorf("unexpected result: %s", result)
+	}
+	result, _, _ = doRequest(gw, "application/grpc-web-text", []byte("not base64"))
+	if result != resultInvalidRequest {
+		t.Errorf("unexpected result: %s", result)
+	}
+}
+
+func TestGRPCWebServerError(t *testing.T) {
+	server := newGRPCServer(t)
+	url := server.URL
+	server.Close()
+
+	gw := newGRPCWeb(t, url)
+	defer gw.Close()
+
+	result, _, _ := doRequest(gw, "application/grpc-web+proto", frame(0, "world"))
+	if result != resultServerError {
+		t.Errorf("unexpected result: %s", result)
+	}
+}
+
+func TestParseContentType(t *testing.T) {
+	cases := []struct {
+		contentType string
+		typ         string
+		subtype     string
+		ok          bool
+	}{
+		{"application/grpc-web", contentTypeWeb, "", true},
+		{"application/grpc-web+proto", contentTypeWeb, "+proto", true},
+		{"Application/GRPC-Web-Text+proto; charset=utf-8", contentTypeWebText, "+proto", true},
+		{"application/grpc+json", contentTypeGRPC, "+json", true},
+		{"application/grpc-webx", "", "", false},
+		{"text/plain", "", "", false},
+	}
+
+	for _, c := range cases {
+		typ, subtype, ok := parseContentType(c.contentType)
+		if typ != c.typ || subtype != c.subtype || ok != c.ok {
+			t.Errorf("%s: unexpected result: %s, %s, %v", c.contentType, typ, subtype, ok)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package htmlrewriter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync/atomic"
+
+	"golang.org/x/net/html/charset"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of HTMLRewriter.
+	Kind = "HTMLRewriter"
+
+	positionBeforeHeadEnd = "beforeHeadEnd"
+	positionBeforeBodyEnd = "beforeBodyEnd"
+
+	// sniffSize is the size of the content to detect the charset,
+	// it's the same as the HTML5 prescan.
+	sniffSize = 1024
+)
+
+var results = []string{}
+
+// asciiIncompatible are the encodings in which the markup is not
+// encoded as ASCII, the documents in them are passed through.
+var asciiIncompatible = map[string]bool{
+	"utf-16be":    true,
+	"utf-16le":    true,
+	"iso-2022-jp": true,
+	"replacement": true,
+}
+
+func init() {
+	httppipeline.Register(&HTMLRewriter{})
+}
+
+type (
+	// HTMLRewriter is filter HTMLRewriter.
+	HTMLRewriter struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		numOfRewritten uint64
+		numOfSkipped   uint64
+	}
+
+	// Spec describes the HTMLRewriter.
+	Spec struct {
+		Injections  []*Injection  `yaml:"injections" jsonschema:"omitempty"`
+		URLRewrites []*URLRewrite `yaml:"urlRewrites" jsonschema:"omitempty"`
+	}
+
+	// Injection injects the content at the position of the document.
+	Injection struct {
+		Position string `yaml:"position" jsonschema:"required,enum=beforeHeadEnd,enum=beforeBodyEnd"`
+		Content  string `yaml:"content" jsonschema:"required"`
+	}
+
+	// URLRewrite rewrites the absolute URLs starting with From to start with To.
+	URLRewrite struct {
+		From string `yaml:"from" jsonschema:"required,format=uri"`
+		To   string `yaml:"to" jsonschema:"required"`
+	}
+
+	// readCloser reads from the reader and closes the origin body.
+	readCloser struct {
+		io.Reader
+		origin io.Reader
+	}
+
+	// Status is the status of HTMLRewriter.
+	Status struct {
+		NumOfRewritten uint64 `yaml:"numOfRewritten"`
+		NumOfSkipped   uint64 `yaml:"numOfSkipped"`
+	}
+)
+
+// Close closes the origin body if it's an io.Closer.
+func (rc readCloser) Close() error {
+	if closer, ok := rc.origin.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if len(spec.Injections) == 0 && len(spec.URLRewrites) == 0 {
+		return fmt.Errorf("none of injections and urlRewrites is specified")
+	}
+	return nil
+}
+
+// Kind returns the kind of HTMLRewriter.
+func (hr *HTMLRewriter) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of HTMLRewriter.
+func (hr *HTMLRewriter) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of HTMLRewriter.
+func (hr *HTMLRewriter) Description() string {
+	return "HTMLRewriter injects snippets into HTML responses and rewrites absolute URLs in them as a stream."
+}
+
+// Results returns the results of HTMLRewriter.
+func (hr *HTMLRewriter) Results() []string {
+	return results
+}
+
+// Init initializes HTMLRewriter.
+func (hr *HTMLRewriter) Init(filterSpec *httppipeline.FilterSpec) {
+	hr.filterSpec, hr.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+}
+
+// Inherit inherits previous generation of HTMLRewriter.
+func (hr *HTMLRewriter) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	hr.Init(filterSpec)
+}
+
+// Handle rewrites the HTML response.
+func (hr *HTMLRewriter) Handle(ctx context.HTTPContext) string {
+	result := hr.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (hr *HTMLRewriter) handle(ctx context.HTTPContext) string {
+	if !hr.rewritable(ctx) {
+		atomic.AddUint64(&hr.numOfSkipped, 1)
+		return ""
+	}
+
+	resp := ctx.Response()
+	body := bufio.NewReaderSize(resp.Body(), sniffSize)
+	content, _ := body.Peek(sniffSize)
+
+	_, name, _ := charset.DetermineEncoding(content, resp.Header().Get("Content-Type"))
+	if asciiIncompatible[name] {
+		ctx.AddTag(fmt.Sprintf("htmlrewriter: skip charset %s", name))
+		resp.SetBody(readCloser{body, resp.Body()})
+		atomic.AddUint64(&hr.numOfSkipped, 1)
+		return ""
+	}
+
+	rules, err := hr.rules(name)
+	if err != nil {
+		ctx.AddTag(fmt.Sprintf("htmlrewriter: %v", err))
+		resp.SetBody(readCloser{body, resp.Body()})
+		atomic.AddUint64(&hr.numOfSkipped, 1)
+		return ""
+	}
+
+	resp.SetBody(newRewriter(readCloser{body, resp.Body()}, rules))
+	resp.Header().Del("Content-Length")
+	atomic.AddUint64(&hr.numOfRewritten, 1)
+
+	return ""
+}
+
+// rewritable returns true if the response is an uncompressed HTML document.
+func (hr *HTMLRewriter) rewritable(ctx context.HTTPContext) bool {
+	if ctx.Request().Method() == http.MethodHead || ctx.Response().Body() == nil {
+		return false
+	}
+
+	switch ctx.Response().StatusCode() {
+	case http.StatusNoContent, http.StatusNotModified:
+		return false
+	}
+
+	header := ctx.Response().Header()
+	if encoding := header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// rules builds the rules with the replacements encoded in the charset
+// of the document.
+func (hr *HTMLRewriter) rules(name string) ([]*rule, error) {
+	enc, _ := charset.Lookup(name)
+	if enc == nil {
+		return nil, fmt.Errorf("unknown charset %s", name)
+	}
+	encoder := enc.NewEncoder()
+
+	encode := func(s string) ([]byte, error) {
+		b, err := encoder.Bytes([]byte(s))
+		if err != nil {
+			return nil, fmt.Errorf("encode %q to %s failed: %v", s, name, err)
+		}
+		return b, nil
+	}
+
+	// NOTE: Injections at the same position are merged into one rule,
+	// because the pattern is consumed by the first match.
+	contents := map[string][]byte{}
+	for _, inj := range hr.spec.Injections {
+		content, err := encode(inj.Content)
+		if err != nil {
+			return nil, err
+		}
+		contents[inj.Position] = append(contents[inj.Position], content...)
+	}
+
+	rules := make([]*rule, 0, len(contents)+len(hr.spec.URLRewrites))
+	if content, exists := contents[positionBeforeHeadEnd]; exists {
+		rules = append(rules, &rule{
+			pattern:      []byte("</head>"),
+			replacement:  content,
+			insertBefore: true,
+			once:         true,
+		})
+	}
+	if content, exists := contents[positionBeforeBodyEnd]; exists {
+		rules = append(rules, &rule{
+			pattern:      []byte("</body>"),
+			replacement:  content,
+			insertBefore: true,
+			once:         true,
+			appendAtEOF:  true,
+		})
+	}
+
+	for _, ur := range hr.spec.URLRewrites {
+		to, err := encode(ur.To)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, &rule{
+			pattern:     []byte(ur.From),
+			replacement: to,
+		})
+	}
+
+	return rules, nil
+}
+
+// Status returns status.
+func (hr *HTMLRewriter) Status() interface{} {
+	return &Status{
+		NumOfRewritten: atomic.LoadUint64(&hr.numOfRewritten),
+		NumOfSkipped:   atomic.LoadUint64(&hr.numOfSkipped),
+	}
+}
+
+// Close closes HTMLRewriter.
+func (hr *HTMLRewriter) Close() {}
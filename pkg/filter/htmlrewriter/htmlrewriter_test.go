@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package htmlrewriter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestRewriter(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{
+			in:   `<html><head></head><body><a href="http://backend:8080/a">a</a></BODY></html>`,
+			want: `<html><head></head><body><a href="https://example.com/a">a</a><script>x</script></BODY></html>`,
+		},
+		{
+			in:   `<html><body>no end`,
+			want: `<html><body>no end<script>x</script>`,
+		},
+		{
+			in:   `</body></body>`,
+			want: `<script>x</script></body></body>`,
+		},
+	}
+
+	for _, c := range cases {
+		// NOTE: Read one byte per time to test patterns spanning reads.
+		src := iotest.OneByteReader(strings.NewReader(c.in))
+		rw := newRewriter(src, []*rule{
+			{pattern: []byte("</body>"), replacement: []byte("<script>x</script>"), insertBefore: true, once: true, appendAtEOF: true},
+			{pattern: []byte("http://backend:8080"), replacement: []byte("https://example.com")},
+		})
+
+		got, err := ioutil.ReadAll(iotest.OneByteReader(rw))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(got) != c.want {
+			t.Errorf("want %s, got %s", c.want, got)
+		}
+	}
+}
+
+func newRewriterFilter(t *testing.T, yamlSpec string) *HTMLRewriter {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hr := &HTMLRewriter{}
+	hr.Init(spec)
+	return hr
+}
+
+func handle(hr *HTMLRewriter, header http.Header, body []byte) []byte {
+	w := httptest.NewRecorder()
+	w.Body.Write(body)
+
+	ctx := contexttest.NewMockedHTTPContext(httptest.NewRequest(http.MethodGet, "/", nil), w)
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(header) }
+	hr.Handle(ctx)
+
+	result, _ := ioutil.ReadAll(ctx.Response().Body())
+	return result
+}
+
+const rewriterSpec = `
+kind: HTMLRewriter
+name: html
+injections:
+- position: beforeBodyEnd
+  content: <p>é</p>
+- position: beforeHeadEnd
+  content: <script src="/a.js"></script>
+urlRewrites:
+- from: http://backend:8080
+  to: https://example.com
+`
+
+func TestHTMLRewriter(t *testing.T) {
+	hr := newRewriterFilter(t, rewriterSpec)
+	defer hr.Close()
+
+	header := http.Header{}
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	header.Set("Content-Length", "100")
+	got := handle(hr, header, []byte(`<head></head><body><img src="http://backend:8080/x.png"></body>`))
+	want := `<head><script src="/a.js"></script></head><body><img src="https://example.com/x.png"><p>é</p></body>`
+	if string(got) != want {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+	if header.Get("Content-Length") != "" {
+		t.Fatalf("want content length removed")
+	}
+
+	// The injected content is encoded in the charset of the document.
+	header = http.Header{}
+	header.Set("Content-Type", "text/html")
+	got = handle(hr, header, []byte(`<meta charset="iso-8859-1"><body></body>`))
+	want = "<meta charset=\"iso-8859-1\"><body><p>\xe9</p></body>"
+	if string(got) != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+
+	// Documents not in ASCII-compatible charsets are passed through.
+	header = http.Header{}
+	header.Set("Content-Type", "text/html; charset=utf-16le")
+	body := []byte("<\x00/\x00b\x00o\x00d\x00y\x00>\x00")
+	if got = handle(hr, header, body); !bytes.Equal(got, body) {
+		t.Fatalf("want body unchanged, got %q", got)
+	}
+
+	// Compressed responses are not rewritten.
+	header = http.Header{}
+	header.Set("Content-Type", "text/html")
+	header.Set("Content-Encoding", "gzip")
+	if got = handle(hr, header, []byte("</body>")); string(got) != "</body>" {
+		t.Fatalf("want body unchanged, got %q", got)
+	}
+
+	status := hr.Status().(*Status)
+	if status.NumOfRewritten != 2 || status.NumOfSkipped != 2 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package htmlrewriter
+
+import (
+	"io"
+)
+
+const readBuffSize = 32 * 1024
+
+type (
+	// rule replaces the pattern with the replacement, patterns are
+	// matched case-insensitively for ASCII letters.
+	rule struct {
+		pattern     []byte
+		replacement []byte
+		// insertBefore keeps the matched bytes and inserts
+		// the replacement before them.
+		insertBefore bool
+		// once means the rule only applies to the first match.
+		once bool
+		// appendAtEOF appends the replacement to the end of the
+		// document if the pattern is never matched.
+		appendAtEOF bool
+
+		matched bool
+	}
+
+	// rewriter rewrites the stream without buffering the whole document,
+	// it only holds back the bytes which could be the beginning of a
+	// pattern spanning two reads.
+	rewriter struct {
+		src   io.Reader
+		rules []*rule
+
+		maxPatternLen int
+		buff          []byte
+		in            []byte
+		out           []byte
+		eof           bool
+		err           error
+	}
+)
+
+func newRewriter(src io.Reader, rules []*rule) *rewriter {
+	rw := &rewriter{
+		src:   src,
+		rules: rules,
+		buff:  make([]byte, readBuffSize),
+	}
+	for _, r := range rules {
+		if len(r.pattern) > rw.maxPatternLen {
+			rw.maxPatternLen = len(r.pattern)
+		}
+	}
+	return rw
+}
+
+// Read implements io.Reader.
+func (rw *rewriter) Read(p []byte) (int, error) {
+	for len(rw.out) == 0 {
+		if rw.eof && len(rw.in) == 0 {
+			return 0, rw.err
+		}
+
+		if !rw.eof {
+			n, err := rw.src.Read(rw.buff)
+			rw.in = append(rw.in, rw.buff[:n]...)
+			if err != nil {
+				rw.eof, rw.err = true, err
+			}
+		}
+
+		rw.process()
+	}
+
+	n := copy(p, rw.out)
+	rw.out = rw.out[n:]
+	return n, nil
+}
+
+// Close closes the source if it's an io.Closer.
+func (rw *rewriter) Close() error {
+	if closer, ok := rw.src.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (rw *rewriter) process() {
+	for {
+		pos, r := rw.firstMatch()
+		if r == nil {
+			break
+		}
+
+		end := pos + len(r.pattern)
+		rw.out = append(rw.out, rw.in[:pos]...)
+		rw.out = append(rw.out, r.replacement...)
+		if r.insertBefore {
+			rw.out = append(rw.out, rw.in[pos:end]...)
+		}
+		rw.in = rw.in[end:]
+		r.matched = true
+	}
+
+	safe := len(rw.in)
+	if !rw.eof {
+		safe -= rw.maxPatternLen - 1
+		if safe < 0 {
+			safe = 0
+		}
+	}
+	rw.out = append(rw.out, rw.in[:safe]...)
+	rw.in = rw.in[safe:]
+
+	if rw.eof && len(rw.in) == 0 {
+		for _, r := range rw.rules {
+			if r.appendAtEOF && !r.matched {
+				rw.out = append(rw.out, r.replacement...)
+				r.matched = true
+			}
+		}
+	}
+
+	// NOTE: Shrink the input to avoid the underlying array growing forever.
+	rw.in = append([]byte(nil), rw.in...)
+}
+
+// firstMatch returns the position and the rule of the first match.
+func (rw *rewriter) firstMatch() (int, *rule) {
+	pos, matched := -1, (*rule)(nil)
+	for _, r := range rw.rules {
+		if r.once && r.matched {
+			continue
+		}
+		i := indexFold(rw.in, r.pattern)
+		if i >= 0 && (pos < 0 || i < pos) {
+			pos, matched = i, r
+		}
+	}
+	return pos, matched
+}
+
+func lowerASCII(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// indexFold is like bytes.Index, but ASCII letters are compared
+// case-insensitively, other bytes are compared as they are, so it
+// works for all ASCII-compatible encodings.
+func indexFold(s, pattern []byte) int {
+	if len(pattern) == 0 {
+		return -1
+	}
+
+	first := lowerASCII(pattern[0])
+	for i := 0; i+len(pattern) <= len(s); i++ {
+		if lowerASCII(s[i]) != first {
+			continue
+		}
+		j := 1
+		for ; j < len(pattern); j++ {
+			if lowerASCII(s[i+j]) != lowerASCII(pattern[j]) {
+				break
+			}
+		}
+		if j == len(pattern) {
+			return i
+		}
+	}
+	return -1
+}
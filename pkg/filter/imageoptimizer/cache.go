@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package imageoptimizer
+
+import (
+	"container/list"
+	"sync"
+)
+
+type (
+	// cache is an LRU cache bounded by the total size of the values.
+	cache struct {
+		mutex    sync.Mutex
+		maxBytes int64
+		bytes    int64
+		entries  map[string]*list.Element
+		lru      *list.List
+	}
+
+	cacheEntry struct {
+		key         string
+		contentType string
+		body        []byte
+	}
+)
+
+func newCache(maxBytes int64) *cache {
+	return &cache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (c *cache) get(key string) *cacheEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, exists := c.entries[key]
+	if !exists {
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cacheEntry)
+}
+
+func (c *cache) put(entry *cacheEntry) {
+	size := int64(len(entry.body))
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e, exists := c.entries[entry.key]; exists {
+		c.bytes -= int64(len(e.Value.(*cacheEntry).body))
+		c.lru.Remove(e)
+	}
+
+	for c.bytes+size > c.maxBytes {
+		oldest := c.lru.Back()
+		old := c.lru.Remove(oldest).(*cacheEntry)
+		delete(c.entries, old.key)
+		c.bytes -= int64(len(old.body))
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.bytes += size
+}
+
+func (c *cache) size() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.bytes
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package imageoptimizer
+
+import (
+	"bytes"
+	stdcontext "context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os/exec"
+	"time"
+)
+
+const externalEncoderTimeout = 10 * time.Second
+
+// targetSize returns the size the image is resized to, zero width or height
+// means it's computed from the other one to keep the aspect ratio, and
+// images are never enlarged.
+func targetSize(bounds image.Rectangle, width, height int) (int, int) {
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW == 0 || srcH == 0 {
+		return srcW, srcH
+	}
+
+	switch {
+	case width == 0 && height == 0:
+		return srcW, srcH
+	case width == 0:
+		width = srcW * height / srcH
+	case height == 0:
+		height = srcH * width / srcW
+	}
+
+	if width >= srcW && height >= srcH {
+		return srcW, srcH
+	}
+	if width > srcW {
+		width = srcW
+	}
+	if height > srcH {
+		height = srcH
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	return width, height
+}
+
+// resize resizes the image with a box filter, which averages the source
+// pixels covered by every destination pixel, it's good for downscaling.
+func resize(src image.Image, width, height int) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if width == srcW && height == srcH {
+		return src
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, srcW, srcH))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*srcH/height, (y+1)*srcH/height
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := x*srcW/width, (x+1)*srcW/width
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				offset := rgba.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(rgba.Pix[offset])
+					g += uint32(rgba.Pix[offset+1])
+					b += uint32(rgba.Pix[offset+2])
+					a += uint32(rgba.Pix[offset+3])
+					offset += 4
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n),
+				G: uint8(g / n),
+				B: uint8(b / n),
+				A: uint8(a / n),
+			})
+		}
+	}
+
+	return dst
+}
+
+// encode encodes the image in the format, the external encoder is used
+// if it's not nil, which reads PNG from stdin and writes to stdout. The
+// external encoder is killed when the context is done.
+func encode(ctx stdcontext.Context, img image.Image, format string, quality int, external []string) ([]byte, error) {
+	buff := &bytes.Buffer{}
+
+	if external != nil {
+		err := png.Encode(buff, img)
+		if err != nil {
+			return nil, err
+		}
+		return runExternalEncoder(ctx, external, buff.Bytes())
+	}
+
+	var err error
+	switch format {
+	case formatJPEG:
+		err = jpeg.Encode(buff, img, &jpeg.Options{Quality: quality})
+	case formatPNG:
+		err = png.Encode(buff, img)
+	case formatGIF:
+		err = gif.Encode(buff, img, nil)
+	default:
+		err = fmt.Errorf("no encoder for %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func runExternalEncoder(ctx stdcontext.Context, command []string, input []byte) ([]byte, error) {
+	ctx, cancel := stdcontext.WithTimeout(ctx, externalEncoderTimeout)
+	defer cancel()
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(input), stdout, stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("run %s failed: %v: %s", command[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("%s outputs nothing", command[0])
+	}
+	return stdout.Bytes(), nil
+}
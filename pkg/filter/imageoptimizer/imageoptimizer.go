@@ -0,0 +1,402 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package imageoptimizer
+
+import (
+	"bytes"
+	stdcontext "context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of ImageOptimizer.
+	Kind = "ImageOptimizer"
+
+	formatJPEG = "jpeg"
+	formatPNG  = "png"
+	formatGIF  = "gif"
+	formatWebP = "webp"
+	formatAVIF = "avif"
+
+	qualityPlaceholder = "{quality}"
+)
+
+var results = []string{}
+
+// builtinFormats are the formats could be decoded and encoded
+// without external encoders.
+var builtinFormats = map[string]bool{
+	formatJPEG: true,
+	formatPNG:  true,
+	formatGIF:  true,
+}
+
+func init() {
+	httppipeline.Register(&ImageOptimizer{})
+}
+
+type (
+	// ImageOptimizer is filter ImageOptimizer.
+	ImageOptimizer struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		encoders map[string][]string
+		cache    *cache
+
+		numOfOptimized uint64
+		numOfCacheHits uint64
+		numOfSkipped   uint64
+		numOfFailures  uint64
+	}
+
+	// Spec describes the ImageOptimizer.
+	Spec struct {
+		WidthParam     string `yaml:"widthParam" jsonschema:"omitempty"`
+		HeightParam    string `yaml:"heightParam" jsonschema:"omitempty"`
+		MaxWidth       int    `yaml:"maxWidth" jsonschema:"omitempty,minimum=1"`
+		MaxHeight      int    `yaml:"maxHeight" jsonschema:"omitempty,minimum=1"`
+		Quality        int    `yaml:"quality" jsonschema:"omitempty,minimum=1,maximum=100"`
+		MaxSourceBytes int64  `yaml:"maxSourceBytes" jsonschema:"omitempty,minimum=1"`
+		CacheBytes     int64  `yaml:"cacheBytes" jsonschema:"omitempty,minimum=0"`
+		// Formats are the formats the images are converted to if the
+		// client explicitly accepts them, in the order of preference.
+		Formats  []string   `yaml:"formats" jsonschema:"omitempty,uniqueItems=true"`
+		Encoders []*Encoder `yaml:"encoders" jsonschema:"omitempty"`
+	}
+
+	// Encoder is an external encoder command, it reads the image in PNG
+	// from stdin and writes the encoded image to stdout, the argument
+	// {quality} is replaced with the quality.
+	Encoder struct {
+		Format  string   `yaml:"format" jsonschema:"required,enum=webp,enum=avif,enum=jpeg,enum=png"`
+		Command []string `yaml:"command" jsonschema:"required,minItems=1"`
+	}
+
+	// Status is the status of ImageOptimizer.
+	Status struct {
+		NumOfOptimized uint64 `yaml:"numOfOptimized"`
+		NumOfCacheHits uint64 `yaml:"numOfCacheHits"`
+		NumOfSkipped   uint64 `yaml:"numOfSkipped"`
+		NumOfFailures  uint64 `yaml:"numOfFailures"`
+		CacheBytes     int64  `yaml:"cacheBytes"`
+	}
+
+	// readCloser reads from the reader and closes the origin body.
+	readCloser struct {
+		io.Reader
+		origin io.Reader
+	}
+
+	// request is the optimization requested by the client.
+	request struct {
+		width  int
+		height int
+		format string
+	}
+)
+
+// Close closes the origin body if it's an io.Closer.
+func (rc readCloser) Close() error {
+	if closer, ok := rc.origin.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	encoders := map[string]bool{}
+	for _, e := range spec.Encoders {
+		if encoders[e.Format] {
+			return fmt.Errorf("repeated encoder for %s", e.Format)
+		}
+		encoders[e.Format] = true
+	}
+
+	for _, f := range spec.Formats {
+		if !builtinFormats[f] && !encoders[f] {
+			return fmt.Errorf("no encoder for format %s", f)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of ImageOptimizer.
+func (o *ImageOptimizer) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of ImageOptimizer.
+func (o *ImageOptimizer) DefaultSpec() interface{} {
+	return &Spec{
+		WidthParam:     "w",
+		HeightParam:    "h",
+		MaxWidth:       4096,
+		MaxHeight:      4096,
+		Quality:        80,
+		MaxSourceBytes: 10 * 1024 * 1024,
+		CacheBytes:     64 * 1024 * 1024,
+	}
+}
+
+// Description returns the description of ImageOptimizer.
+func (o *ImageOptimizer) Description() string {
+	return "ImageOptimizer resizes images and converts them to the formats accepted by clients."
+}
+
+// Results returns the results of ImageOptimizer.
+func (o *ImageOptimizer) Results() []string {
+	return results
+}
+
+// Init initializes ImageOptimizer.
+func (o *ImageOptimizer) Init(filterSpec *httppipeline.FilterSpec) {
+	o.filterSpec, o.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	o.reload()
+}
+
+// Inherit inherits previous generation of ImageOptimizer.
+func (o *ImageOptimizer) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	o.Init(filterSpec)
+}
+
+func (o *ImageOptimizer) reload() {
+	o.encoders = make(map[string][]string)
+	quality := strconv.Itoa(o.spec.Quality)
+	for _, e := range o.spec.Encoders {
+		command := make([]string, len(e.Command))
+		for i, arg := range e.Command {
+			command[i] = strings.ReplaceAll(arg, qualityPlaceholder, quality)
+		}
+		o.encoders[e.Format] = command
+	}
+
+	if o.spec.CacheBytes > 0 {
+		o.cache = newCache(o.spec.CacheBytes)
+	}
+}
+
+// Handle optimizes the image response.
+func (o *ImageOptimizer) Handle(ctx context.HTTPContext) string {
+	result := o.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (o *ImageOptimizer) handle(ctx context.HTTPContext) string {
+	srcFormat := o.sourceFormat(ctx)
+	if srcFormat == "" {
+		atomic.AddUint64(&o.numOfSkipped, 1)
+		return ""
+	}
+
+	// NOTE: The response differs with the Accept header
+	// even if the image is not converted this time.
+	if len(o.spec.Formats) != 0 {
+		ctx.Response().Header().Add("Vary", "Accept")
+	}
+
+	req := o.parseRequest(ctx, srcFormat)
+	if req.width == 0 && req.height == 0 && req.format == srcFormat {
+		atomic.AddUint64(&o.numOfSkipped, 1)
+		return ""
+	}
+
+	resp := ctx.Response()
+	raw, replay, ok := o.readSource(resp.Body())
+	if !ok {
+		ctx.AddTag("imageoptimizer: source image is too large")
+		resp.SetBody(replay)
+		atomic.AddUint64(&o.numOfSkipped, 1)
+		return ""
+	}
+
+	key := o.cacheKey(raw, req)
+	if o.cache != nil {
+		if entry := o.cache.get(key); entry != nil {
+			atomic.AddUint64(&o.numOfCacheHits, 1)
+			o.setResponse(ctx, entry.contentType, entry.body)
+			return ""
+		}
+	}
+
+	out, err := o.optimize(ctx, raw, req)
+	if err != nil {
+		ctx.AddTag(fmt.Sprintf("imageoptimizer: %v", err))
+		resp.SetBody(bytes.NewReader(raw))
+		atomic.AddUint64(&o.numOfFailures, 1)
+		return ""
+	}
+
+	contentType := "image/" + req.format
+	if o.cache != nil {
+		o.cache.put(&cacheEntry{key: key, contentType: contentType, body: out})
+	}
+	o.setResponse(ctx, contentType, out)
+	atomic.AddUint64(&o.numOfOptimized, 1)
+
+	return ""
+}
+
+// sourceFormat returns the format of the image in the response,
+// or an empty string if the response is not an image could be decoded.
+func (o *ImageOptimizer) sourceFormat(ctx context.HTTPContext) string {
+	if ctx.Request().Method() != http.MethodGet || ctx.Response().StatusCode() != http.StatusOK {
+		return ""
+	}
+
+	header := ctx.Response().Header()
+	if encoding := header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return ""
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "image/") {
+		return ""
+	}
+
+	format := strings.TrimPrefix(mediaType, "image/")
+	if format == "jpg" {
+		format = formatJPEG
+	}
+	if !builtinFormats[format] {
+		return ""
+	}
+	return format
+}
+
+func (o *ImageOptimizer) parseRequest(ctx context.HTTPContext, srcFormat string) *request {
+	values, _ := url.ParseQuery(ctx.Request().Query())
+
+	req := &request{format: srcFormat}
+	req.width, _ = strconv.Atoi(values.Get(o.spec.WidthParam))
+	req.height, _ = strconv.Atoi(values.Get(o.spec.HeightParam))
+	req.width = clamp(req.width, o.spec.MaxWidth)
+	req.height = clamp(req.height, o.spec.MaxHeight)
+
+	accepted := acceptedImageFormats(ctx.Request().Header().Get("Accept"))
+	for _, f := range o.spec.Formats {
+		if accepted[f] {
+			req.format = f
+			break
+		}
+	}
+
+	return req
+}
+
+func clamp(n, max int) int {
+	if n < 0 {
+		return 0
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// acceptedImageFormats returns the image formats explicitly accepted,
+// wildcards are ignored, because browsers send image/* for all images.
+func acceptedImageFormats(accept string) map[string]bool {
+	formats := map[string]bool{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || !strings.HasPrefix(mediaType, "image/") || mediaType == "image/*" {
+			continue
+		}
+		if q, exists := params["q"]; exists {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v <= 0 {
+				continue
+			}
+		}
+		formats[strings.TrimPrefix(mediaType, "image/")] = true
+	}
+	return formats
+}
+
+// readSource reads the whole source image, it returns false if the image
+// is larger than the limit, and the returned reader replays the body.
+func (o *ImageOptimizer) readSource(body io.Reader) ([]byte, io.Reader, bool) {
+	buff, err := ioutil.ReadAll(io.LimitReader(body, o.spec.MaxSourceBytes+1))
+	if err != nil || int64(len(buff)) > o.spec.MaxSourceBytes {
+		return nil, readCloser{io.MultiReader(bytes.NewReader(buff), body), body}, false
+	}
+
+	// NOTE: The original body is replaced, so it must be closed here.
+	if closer, ok := body.(io.Closer); ok {
+		closer.Close()
+	}
+	return buff, nil, true
+}
+
+func (o *ImageOptimizer) cacheKey(raw []byte, req *request) string {
+	sum := sha256.Sum256(raw)
+	return fmt.Sprintf("%s/%d/%d/%s", hex.EncodeToString(sum[:]), req.width, req.height, req.format)
+}
+
+func (o *ImageOptimizer) optimize(ctx stdcontext.Context, raw []byte, req *request) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("decode image failed: %v", err)
+	}
+
+	width, height := targetSize(img.Bounds(), req.width, req.height)
+	img = resize(img, width, height)
+
+	return encode(ctx, img, req.format, o.spec.Quality, o.encoders[req.format])
+}
+
+func (o *ImageOptimizer) setResponse(ctx context.HTTPContext, contentType string, body []byte) {
+	header := ctx.Response().Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Del("ETag")
+	ctx.Response().SetBody(bytes.NewReader(body))
+}
+
+// Status returns status.
+func (o *ImageOptimizer) Status() interface{} {
+	s := &Status{
+		NumOfOptimized: atomic.LoadUint64(&o.numOfOptimized),
+		NumOfCacheHits: atomic.LoadUint64(&o.numOfCacheHits),
+		NumOfSkipped:   atomic.LoadUint64(&o.numOfSkipped),
+		NumOfFailures:  atomic.LoadUint64(&o.numOfFailures),
+	}
+	if o.cache != nil {
+		s.CacheBytes = o.cache.size()
+	}
+	return s
+}
+
+// Close closes ImageOptimizer.
+func (o *ImageOptimizer) Close() {}
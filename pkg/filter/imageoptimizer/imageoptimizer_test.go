@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package imageoptimizer
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newOptimizer(t *testing.T, yamlSpec string) *ImageOptimizer {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	o := &ImageOptimizer{}
+	o.Init(spec)
+	return o
+}
+
+func pngImage(width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	buff := &bytes.Buffer{}
+	png.Encode(buff, img)
+	return buff.Bytes()
+}
+
+func handle(o *ImageOptimizer, query, accept string, body []byte) (http.Header, []byte) {
+	req := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+	req.Header.Set("Accept", accept)
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Type", "image/png")
+	w.Body.Write(body)
+
+	ctx := contexttest.NewMockedHTTPContext(req, w)
+	o.Handle(ctx)
+
+	result, _ := ioutil.ReadAll(ctx.Response().Body())
+	return w.Header(), result
+}
+
+func TestImageOptimizerResize(t *testing.T) {
+	o := newOptimizer(t, `
+kind: ImageOptimizer
+name: image
+maxWidth: 80
+`)
+	defer o.Close()
+
+	src := pngImage(100, 50)
+
+	// No optimization requested.
+	_, body := handle(o, "", "image/*", src)
+	if !bytes.Equal(body, src) {
+		t.Fatalf("want image unchanged")
+	}
+
+	_, body = handle(o, "w=40", "image/*", src)
+	img, err := png.Decode(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 40 || b.Dy() != 20 {
+		t.Fatalf("want 40x20, got %dx%d", b.Dx(), b.Dy())
+	}
+
+	// The width is limited by maxWidth.
+	_, body = handle(o, "w=1000", "image/*", src)
+	img, _ = png.Decode(bytes.NewReader(body))
+	if b := img.Bounds(); b.Dx() != 80 || b.Dy() != 40 {
+		t.Fatalf("want 80x40, got %dx%d", b.Dx(), b.Dy())
+	}
+
+	// Served from the cache.
+	handle(o, "w=40", "image/*", src)
+	status := o.Status().(*Status)
+	if status.NumOfOptimized != 2 || status.NumOfCacheHits != 1 || status.CacheBytes == 0 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestImageOptimizerFormat(t *testing.T) {
+	o := newOptimizer(t, `
+kind: ImageOptimizer
+name: image
+formats: [webp, jpeg]
+encoders:
+- format: webp
+  command: [cat]
+`)
+	defer o.Close()
+
+	src := pngImage(10, 10)
+
+	header, body := handle(o, "", "image/webp,image/*,*/*;q=0.8", src)
+	if header.Get("Content-Type") != "image/webp" || len(body) == 0 {
+		t.Fatalf("want webp, got %s", header.Get("Content-Type"))
+	}
+	if header.Get("Vary") != "Accept" {
+		t.Fatalf("want vary header")
+	}
+
+	header, body = handle(o, "", "image/jpeg", src)
+	if header.Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("want jpeg, got %s", header.Get("Content-Type"))
+	}
+	if _, format, err := image.Decode(bytes.NewReader(body)); err != nil || format != "jpeg" {
+		t.Fatalf("want jpeg image, got %s, %v", format, err)
+	}
+
+	header, _ = handle(o, "", "image/webp;q=0, image/*", src)
+	if header.Get("Content-Type") != "image/png" {
+		t.Fatalf("want png, got %s", header.Get("Content-Type"))
+	}
+}
+
+func TestImageOptimizerLargeSource(t *testing.T) {
+	o := newOptimizer(t, `
+kind: ImageOptimizer
+name: image
+maxSourceBytes: 100
+`)
+	defer o.Close()
+
+	src := pngImage(100, 100)
+	_, body := handle(o, "w=10", "", src)
+	if !bytes.Equal(body, src) {
+		t.Fatalf("want the large image unchanged")
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := Spec{Formats: []string{"avif"}}
+	if spec.Validate() == nil {
+		t.Fatalf("want error for format without encoder")
+	}
+}
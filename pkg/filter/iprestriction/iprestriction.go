@@ -0,0 +1,462 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package iprestriction
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/geoip"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of IPRestriction.
+	Kind = "IPRestriction"
+
+	resultDenied = "denied"
+
+	actionAllow = "allow"
+	actionDeny  = "deny"
+	actionTag   = "tag"
+
+	defaultRefreshInterval = time.Hour
+	defaultTagHeader       = "X-EG-IP-Tags"
+)
+
+var results = []string{resultDenied}
+
+func init() {
+	httppipeline.Register(&IPRestriction{})
+}
+
+type (
+	// IPRestriction allows, denies or tags requests by the IPs of clients,
+	// the lists of IPs and the geolocations and the autonomous systems of
+	// the IPs.
+	IPRestriction struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		rules []*rule
+		geo   *geoDatabases
+
+		allowed, denied uint64
+
+		done chan struct{}
+	}
+
+	// Spec describes the IPRestriction.
+	Spec struct {
+		// Rules are matched in order, the first matched allow or deny
+		// rule decides, and the tag rules tag the requests and continue.
+		Rules []*Rule `yaml:"rules" jsonschema:"required,minItems=1"`
+		// DefaultAction is the action of the requests matching no allow
+		// or deny rule.
+		DefaultAction string `yaml:"defaultAction" jsonschema:"omitempty,enum=,enum=allow,enum=deny"`
+		// Status is the status code of the denied requests.
+		Status int `yaml:"status" jsonschema:"omitempty,format=httpcode"`
+		// TagHeader is the request header of the names of the matched
+		// tag rules, separated by comma.
+		TagHeader string `yaml:"tagHeader" jsonschema:"omitempty"`
+		// RefreshInterval is the interval to reload the IP files, the IP
+		// URLs and the GeoIP databases.
+		RefreshInterval string `yaml:"refreshInterval" jsonschema:"omitempty,format=duration"`
+
+		GeoIP *GeoIP `yaml:"geoIP,omitempty" jsonschema:"omitempty"`
+	}
+
+	// Rule matches the requests whose IPs are in any of its IP lists, or
+	// whose geolocations or autonomous systems are in any of its lists.
+	Rule struct {
+		Name   string `yaml:"name" jsonschema:"required"`
+		Action string `yaml:"action" jsonschema:"required,enum=allow,enum=deny,enum=tag"`
+
+		IPs []string `yaml:"ips" jsonschema:"omitempty,uniqueItems=true,format=ipcidr-array"`
+		// IPFiles are the files of IPs or CIDRs, one per line.
+		IPFiles []string `yaml:"ipFiles" jsonschema:"omitempty,uniqueItems=true"`
+		// IPURLs are the URLs of IPs or CIDRs, one per line.
+		IPURLs []string `yaml:"ipURLs" jsonschema:"omitempty,uniqueItems=true"`
+
+		// Countries are ISO 3166-1 country codes, like US.
+		Countries []string `yaml:"countries" jsonschema:"omitempty,uniqueItems=true"`
+		// Continents are continent codes, like EU.
+		Continents []string `yaml:"continents" jsonschema:"omitempty,uniqueItems=true"`
+		// ASNs are autonomous system numbers.
+		ASNs []uint `yaml:"asns" jsonschema:"omitempty,uniqueItems=true"`
+	}
+
+	// GeoIP describes the GeoIP databases and the geo headers.
+	GeoIP struct {
+		// Databases are the MaxMind DB files, e.g. GeoLite2-Country and
+		// GeoLite2-ASN, the former ones take precedence.
+		Databases []string `yaml:"databases" jsonschema:"required,minItems=1"`
+		// Headers are the request headers of the geolocations, which are
+		// passed to the backends.
+		Headers *GeoHeaders `yaml:"headers,omitempty" jsonschema:"omitempty"`
+	}
+
+	// GeoHeaders are the request headers of the geolocations, the empty
+	// ones are not set.
+	GeoHeaders struct {
+		Country        string `yaml:"country" jsonschema:"omitempty"`
+		Continent      string `yaml:"continent" jsonschema:"omitempty"`
+		City           string `yaml:"city" jsonschema:"omitempty"`
+		ASN            string `yaml:"asn" jsonschema:"omitempty"`
+		ASOrganization string `yaml:"asOrganization" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of IPRestriction.
+	Status struct {
+		Allowed uint64                 `yaml:"allowed"`
+		Denied  uint64                 `yaml:"denied"`
+		Rules   map[string]*RuleStatus `yaml:"rules"`
+		// GeoIP are the types and the build times of the databases.
+		GeoIP []string `yaml:"geoIP,omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	names := map[string]bool{}
+	geo := false
+	for _, r := range spec.Rules {
+		if names[r.Name] {
+			return fmt.Errorf("duplicated rule %s", r.Name)
+		}
+		names[r.Name] = true
+		if len(r.Countries)+len(r.Continents)+len(r.ASNs) > 0 {
+			geo = true
+		}
+	}
+
+	if geo && spec.GeoIP == nil {
+		return fmt.Errorf("geoIP is required by the rules of countries, continents or asns")
+	}
+
+	if spec.RefreshInterval != "" {
+		if d, _ := time.ParseDuration(spec.RefreshInterval); d < time.Minute {
+			return fmt.Errorf("refreshInterval must be at least 1m")
+		}
+	}
+
+	return nil
+}
+
+// Validate validates Rule.
+func (r Rule) Validate() error {
+	if len(r.IPs)+len(r.IPFiles)+len(r.IPURLs)+len(r.Countries)+len(r.Continents)+len(r.ASNs) == 0 {
+		return fmt.Errorf("rule %s: no ips, ipFiles, ipURLs, countries, continents or asns", r.Name)
+	}
+	for _, u := range r.IPURLs {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			return fmt.Errorf("rule %s: invalid ip url %s", r.Name, u)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of IPRestriction.
+func (ir *IPRestriction) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of IPRestriction.
+func (ir *IPRestriction) DefaultSpec() interface{} {
+	return &Spec{
+		DefaultAction: actionAllow,
+		Status:        http.StatusForbidden,
+		TagHeader:     defaultTagHeader,
+	}
+}
+
+// Description returns the description of IPRestriction.
+func (ir *IPRestriction) Description() string {
+	return "IPRestriction allows, denies or tags requests by IP lists, countries and autonomous systems."
+}
+
+// Results returns the results of IPRestriction.
+func (ir *IPRestriction) Results() []string {
+	return results
+}
+
+// Init initializes IPRestriction.
+func (ir *IPRestriction) Init(filterSpec *httppipeline.FilterSpec) {
+	ir.filterSpec, ir.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	ir.reload(nil)
+}
+
+// Inherit inherits previous generation of IPRestriction.
+func (ir *IPRestriction) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	ir.filterSpec, ir.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	ir.reload(previousGeneration.(*IPRestriction))
+}
+
+func (ir *IPRestriction) reload(prev *IPRestriction) {
+	prevRules := map[string]*rule{}
+	if prev != nil {
+		for _, r := range prev.rules {
+			prevRules[r.Name] = r
+		}
+	}
+
+	ir.rules = nil
+	for _, r := range ir.spec.Rules {
+		nr := newRule(r)
+		// NOTE: The IPs of the unchanged lists are kept if they can't be
+		// loaded now, e.g. the server of the URLs is down.
+		nr.load(prevRules[r.Name])
+		ir.rules = append(ir.rules, nr)
+	}
+
+	if ir.spec.GeoIP != nil {
+		ir.geo = newGeoDatabases(ir.spec.GeoIP.Databases)
+		if prev != nil && prev.geo != nil {
+			ir.geo.inherit(prev.geo)
+		}
+	}
+
+	ir.done = make(chan struct{})
+	go ir.run()
+}
+
+func (ir *IPRestriction) run() {
+	interval := defaultRefreshInterval
+	if ir.spec.RefreshInterval != "" {
+		interval, _ = time.ParseDuration(ir.spec.RefreshInterval)
+	}
+
+	for {
+		select {
+		case <-ir.done:
+			return
+		case <-time.After(interval):
+			for _, r := range ir.rules {
+				r.load(r)
+			}
+			if ir.geo != nil {
+				ir.geo.load()
+			}
+		}
+	}
+}
+
+// Handle restricts the request of HTTPContext by its IP.
+func (ir *IPRestriction) Handle(ctx context.HTTPContext) string {
+	result := ir.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (ir *IPRestriction) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	ip := net.ParseIP(r.RealIP())
+
+	var record *geoip.Record
+	if ir.geo != nil {
+		record = ir.geo.lookup(ip)
+		ir.setGeoHeaders(ctx, record)
+	}
+
+	var tags []string
+	action := ir.spec.DefaultAction
+	for _, rule := range ir.rules {
+		if !rule.match(ip, record) {
+			continue
+		}
+		atomic.AddUint64(&rule.hits, 1)
+
+		if rule.Action == actionTag {
+			tags = append(tags, rule.Name)
+			continue
+		}
+
+		action = rule.Action
+		ctx.AddTag(stringtool.Cat("ipRestriction: ", action, " by rule ", rule.Name))
+		break
+	}
+
+	if ir.spec.TagHeader != "" {
+		// NOTE: The tags sent by the client are always removed.
+		r.Header().Del(ir.spec.TagHeader)
+		if len(tags) > 0 {
+			r.Header().Set(ir.spec.TagHeader, strings.Join(tags, ","))
+		}
+	}
+
+	if action == actionDeny {
+		atomic.AddUint64(&ir.denied, 1)
+		ctx.Response().SetStatusCode(ir.spec.Status)
+		return resultDenied
+	}
+
+	atomic.AddUint64(&ir.allowed, 1)
+	return ""
+}
+
+func (ir *IPRestriction) setGeoHeaders(ctx context.HTTPContext, record *geoip.Record) {
+	headers := ir.spec.GeoIP.Headers
+	if headers == nil {
+		return
+	}
+	if record == nil {
+		record = &geoip.Record{}
+	}
+
+	h := ctx.Request().Header()
+	set := func(key, value string) {
+		if key == "" {
+			return
+		}
+		// NOTE: The headers sent by the client are always removed.
+		h.Del(key)
+		if value != "" {
+			h.Set(key, value)
+		}
+	}
+
+	asn := ""
+	if record.ASN != 0 {
+		asn = strconv.FormatUint(uint64(record.ASN), 10)
+	}
+	set(headers.Country, record.Country)
+	set(headers.Continent, record.Continent)
+	set(headers.City, record.City)
+	set(headers.ASN, asn)
+	set(headers.ASOrganization, record.ASOrganization)
+}
+
+// Status returns status.
+func (ir *IPRestriction) Status() interface{} {
+	s := &Status{
+		Allowed: atomic.LoadUint64(&ir.allowed),
+		Denied:  atomic.LoadUint64(&ir.denied),
+		Rules:   map[string]*RuleStatus{},
+	}
+	for _, r := range ir.rules {
+		s.Rules[r.Name] = r.status()
+	}
+	if ir.geo != nil {
+		s.GeoIP = ir.geo.status()
+	}
+	return s
+}
+
+// Close closes IPRestriction.
+func (ir *IPRestriction) Close() {
+	close(ir.done)
+}
+
+// geoDatabases are the GeoIP databases, which are reloaded periodically.
+type geoDatabases struct {
+	paths []string
+
+	mutex   sync.RWMutex
+	readers []*geoip.Reader
+}
+
+func newGeoDatabases(paths []string) *geoDatabases {
+	g := &geoDatabases{paths: paths, readers: make([]*geoip.Reader, len(paths))}
+	g.load()
+	return g
+}
+
+// inherit uses the databases of the previous generation which failed to
+// load now.
+func (g *geoDatabases) inherit(prev *geoDatabases) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	prev.mutex.RLock()
+	defer prev.mutex.RUnlock()
+
+	for i, path := range g.paths {
+		if g.readers[i] != nil {
+			continue
+		}
+		for j, prevPath := range prev.paths {
+			if prevPath == path {
+				g.readers[i] = prev.readers[j]
+			}
+		}
+	}
+}
+
+func (g *geoDatabases) load() {
+	for i, path := range g.paths {
+		reader, err := geoip.Open(path)
+		if err != nil {
+			logger.Errorf("load GeoIP database %s failed: %v", path, err)
+			continue
+		}
+		g.mutex.Lock()
+		g.readers[i] = reader
+		g.mutex.Unlock()
+	}
+}
+
+func (g *geoDatabases) lookup(ip net.IP) *geoip.Record {
+	if ip == nil {
+		return nil
+	}
+
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	var record *geoip.Record
+	for _, reader := range g.readers {
+		if reader == nil {
+			continue
+		}
+		r, err := reader.Lookup(ip)
+		if err != nil {
+			logger.Errorf("lookup %s in GeoIP database %s failed: %v", ip, reader.DatabaseType(), err)
+			continue
+		}
+		if record == nil {
+			record = r
+		} else {
+			record.Merge(r)
+		}
+	}
+	return record
+}
+
+func (g *geoDatabases) status() []string {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	var s []string
+	for i, reader := range g.readers {
+		if reader == nil {
+			s = append(s, stringtool.Cat(g.paths[i], ": not loaded"))
+			continue
+		}
+		built := time.Unix(int64(reader.BuildEpoch()), 0).UTC().Format(time.RFC3339)
+		s = append(s, stringtool.Cat(g.paths[i], ": ", reader.DatabaseType(), " built at ", built))
+	}
+	return s
+}
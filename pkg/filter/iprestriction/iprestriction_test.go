@@ -0,0 +1,263 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package iprestriction
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/geoip"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newIPRestriction(t *testing.T, yamlSpec string) *IPRestriction {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ir := &IPRestriction{}
+	ir.Init(spec)
+	return ir
+}
+
+func newContext(ip string, header map[string]string) context.HTTPContext {
+	stdr, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	stdr.RemoteAddr = net.JoinHostPort(ip, "1234")
+	for k, v := range header {
+		stdr.Header.Set(k, v)
+	}
+	ctx := context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		return lastResult
+	})
+	return ctx
+}
+
+func TestValidate(t *testing.T) {
+	for i, yamlSpec := range []string{
+		"kind: IPRestriction\nname: ir\n",
+		"kind: IPRestriction\nname: ir\nrules:\n- name: a\n  action: deny\n",
+		"kind: IPRestriction\nname: ir\nrules:\n- name: a\n  action: drop\n  ips: [10.0.0.1]\n",
+		"kind: IPRestriction\nname: ir\nrules:\n- name: a\n  action: deny\n  ips: [10.0.0.300]\n",
+		"kind: IPRestriction\nname: ir\nrules:\n- name: a\n  action: deny\n  ipURLs: [ftp://example.com/ips]\n",
+		"kind: IPRestriction\nname: ir\nrules:\n- name: a\n  action: deny\n  countries: [KP]\n",
+		"kind: IPRestriction\nname: ir\nrules:\n- name: a\n  action: deny\n  ips: [10.0.0.1]\n- name: a\n  action: tag\n  ips: [10.0.0.2]\n",
+		"kind: IPRestriction\nname: ir\nrefreshInterval: 1s\nrules:\n- name: a\n  action: deny\n  ips: [10.0.0.1]\n",
+	} {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("case %d: spec should be invalid", i)
+		}
+	}
+}
+
+func TestParseIPList(t *testing.T) {
+	ipNets := parseIPList([]byte(`
+; Spamhaus DROP List
+1.10.16.0/20 ; SBL256894
+# comment
+2.56.192.0/22
+192.0.2.1 extra fields
+2001:db8::1
+invalid
+`))
+	expected := []string{"1.10.16.0/20", "2.56.192.0/22", "192.0.2.1/32", "2001:db8::1/128"}
+	if len(ipNets) != len(expected) {
+		t.Fatalf("unexpected ip list %v", ipNets)
+	}
+	for i, ipNet := range ipNets {
+		if ipNet.String() != expected[i] {
+			t.Errorf("ip %d should be %s, but got %s", i, expected[i], ipNet.String())
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "iprestriction")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "partners.txt")
+	ioutil.WriteFile(file, []byte("203.0.113.0/24\n"), 0o644)
+
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("198.51.100.0/24 ; SBL1\n"))
+	}))
+	defer server.Close()
+
+	ir := newIPRestriction(t, `
+kind: IPRestriction
+name: ir
+rules:
+- name: office
+  action: allow
+  ips: [10.0.0.0/8]
+- name: drop
+  action: deny
+  ipURLs: [`+server.URL+`]
+- name: partners
+  action: tag
+  ipFiles: [`+file+`]
+- name: blocked
+  action: deny
+  ips: [10.1.0.0/16, 203.0.113.7]
+`)
+	defer ir.Close()
+
+	for _, c := range []struct {
+		ip     string
+		result string
+		tags   string
+	}{
+		{"10.1.2.3", "", ""},
+		{"198.51.100.9", resultDenied, ""},
+		{"203.0.113.8", "", "partners"},
+		{"203.0.113.7", resultDenied, "partners"},
+		{"192.0.2.1", "", ""},
+	} {
+		ctx := newContext(c.ip, map[string]string{defaultTagHeader: "spoofed"})
+		if result := ir.Handle(ctx); result != c.result {
+			t.Errorf("%s: result should be %q, but got %q", c.ip, c.result, result)
+		}
+		if tags := ctx.Request().Header().Get(defaultTagHeader); tags != c.tags {
+			t.Errorf("%s: tags should be %q, but got %q", c.ip, c.tags, tags)
+		}
+		if c.result == resultDenied && ctx.Response().StatusCode() != http.StatusForbidden {
+			t.Errorf("%s: unexpected status code %d", c.ip, ctx.Response().StatusCode())
+		}
+	}
+
+	// The IPs of the URL are kept if it fails to refresh.
+	fail = true
+	drop := ir.rules[1]
+	drop.load(drop)
+	if result := ir.Handle(newContext("198.51.100.9", nil)); result != resultDenied {
+		t.Errorf("IPs loaded before should be kept, but got %q", result)
+	}
+
+	status := ir.Status().(*Status)
+	if status.Allowed != 3 || status.Denied != 3 {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if s := status.Rules["drop"]; s.Hits != 2 || s.IPs != 1 || len(s.Errors) != 1 {
+		t.Errorf("unexpected rule status %+v", s)
+	}
+}
+
+func TestDefaultDeny(t *testing.T) {
+	ir := newIPRestriction(t, `
+kind: IPRestriction
+name: ir
+defaultAction: deny
+status: 451
+rules:
+- name: internal
+  action: allow
+  ips: [10.0.0.0/8, "fd00::/8"]
+`)
+	defer ir.Close()
+
+	if result := ir.Handle(newContext("fd00::1", nil)); result != "" {
+		t.Errorf("internal IP should be allowed, but got %q", result)
+	}
+	ctx := newContext("192.0.2.1", nil)
+	if result := ir.Handle(ctx); result != resultDenied || ctx.Response().StatusCode() != 451 {
+		t.Errorf("unexpected result %q %d", result, ctx.Response().StatusCode())
+	}
+}
+
+func TestGeo(t *testing.T) {
+	ir := newIPRestriction(t, `
+kind: IPRestriction
+name: ir
+geoIP:
+  databases: [/nonexistent/GeoLite2-Country.mmdb]
+  headers:
+    country: X-Geo-Country
+    asn: X-Geo-ASN
+rules:
+- name: sanctioned
+  action: deny
+  countries: [kp, IR]
+- name: cloud
+  action: tag
+  asns: [16509]
+- name: europe
+  action: tag
+  continents: [EU]
+`)
+	defer ir.Close()
+
+	ip := net.ParseIP("192.0.2.1")
+	for _, c := range []struct {
+		record *geoip.Record
+		rules  []string
+	}{
+		{&geoip.Record{Country: "KP", Continent: "AS"}, []string{"sanctioned"}},
+		{&geoip.Record{Country: "DE", Continent: "EU", ASN: 16509}, []string{"cloud", "europe"}},
+		{&geoip.Record{Country: "US", Continent: "NA"}, nil},
+		{nil, nil},
+	} {
+		var rules []string
+		for _, r := range ir.rules {
+			if r.match(ip, c.record) {
+				rules = append(rules, r.Name)
+			}
+		}
+		if len(rules) != len(c.rules) || (len(rules) > 0 && rules[0] != c.rules[0]) {
+			t.Errorf("%+v: rules should be %v, but got %v", c.record, c.rules, rules)
+		}
+	}
+
+	ctx := newContext("192.0.2.1", map[string]string{"X-Geo-Country": "US"})
+	ir.setGeoHeaders(ctx, &geoip.Record{ASN: 16509})
+	h := ctx.Request().Header()
+	if h.Get("X-Geo-Country") != "" || h.Get("X-Geo-ASN") != "16509" {
+		t.Errorf("unexpected geo headers %s %s", h.Get("X-Geo-Country"), h.Get("X-Geo-ASN"))
+	}
+
+	// Requests pass if the database is unavailable.
+	if result := ir.Handle(newContext("192.0.2.1", nil)); result != "" {
+		t.Errorf("unexpected result %q", result)
+	}
+	if status := ir.Status().(*Status); len(status.GeoIP) != 1 {
+		t.Errorf("unexpected geo status %v", status.GeoIP)
+	}
+}
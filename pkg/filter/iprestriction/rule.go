@@ -0,0 +1,224 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package iprestriction
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yl2chen/cidranger"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/geoip"
+)
+
+const (
+	maxListSize  = 64 * 1024 * 1024
+	fetchTimeout = 30 * time.Second
+)
+
+var fetchClient = &http.Client{Timeout: fetchTimeout}
+
+type (
+	// rule is a Rule with its IP lists loaded.
+	rule struct {
+		*Rule
+		countries  map[string]bool
+		continents map[string]bool
+		asns       map[uint]bool
+
+		hits uint64
+
+		mutex       sync.RWMutex
+		ranger      cidranger.Ranger
+		sources     map[string][]net.IPNet
+		size        int
+		lastRefresh time.Time
+		errors      []string
+	}
+
+	// RuleStatus is the status of a rule.
+	RuleStatus struct {
+		Hits uint64 `yaml:"hits"`
+		// IPs is the count of IPs and CIDRs of the rule.
+		IPs         int       `yaml:"ips"`
+		LastRefresh time.Time `yaml:"lastRefresh"`
+		// Errors are the errors of the last refresh, the IPs of the
+		// lists failed are the ones loaded before.
+		Errors []string `yaml:"errors,omitempty"`
+	}
+)
+
+func newRule(r *Rule) *rule {
+	nr := &rule{
+		Rule:       r,
+		countries:  map[string]bool{},
+		continents: map[string]bool{},
+		asns:       map[uint]bool{},
+	}
+	for _, c := range r.Countries {
+		nr.countries[strings.ToUpper(c)] = true
+	}
+	for _, c := range r.Continents {
+		nr.continents[strings.ToUpper(c)] = true
+	}
+	for _, asn := range r.ASNs {
+		nr.asns[asn] = true
+	}
+	return nr
+}
+
+// load loads the IPs of the rule, the lists failed to load use the IPs in
+// the previous rule if it's not nil.
+func (r *rule) load(prev *rule) {
+	var prevSources map[string][]net.IPNet
+	if prev != nil {
+		prev.mutex.RLock()
+		prevSources = prev.sources
+		prev.mutex.RUnlock()
+	}
+
+	var errors []string
+	sources := map[string][]net.IPNet{}
+	loadSource := func(key string, read func() ([]byte, error)) {
+		content, err := read()
+		if err != nil {
+			err = fmt.Errorf("load %s failed: %v", key, err)
+			logger.Errorf("rule %s: %v", r.Name, err)
+			errors = append(errors, err.Error())
+			if ipNets, ok := prevSources[key]; ok {
+				sources[key] = ipNets
+			}
+			return
+		}
+		sources[key] = parseIPList(content)
+	}
+
+	for _, file := range r.IPFiles {
+		file := file
+		loadSource(file, func() ([]byte, error) {
+			return ioutil.ReadFile(file)
+		})
+	}
+	for _, u := range r.IPURLs {
+		u := u
+		loadSource(u, func() ([]byte, error) {
+			return fetch(u)
+		})
+	}
+
+	ranger := cidranger.NewPCTrieRanger()
+	size := 0
+	insert := func(ipNets []net.IPNet) {
+		for _, ipNet := range ipNets {
+			ranger.Insert(cidranger.NewBasicRangerEntry(ipNet))
+		}
+		size += len(ipNets)
+	}
+	insert(parseIPList([]byte(strings.Join(r.IPs, "\n"))))
+	for _, ipNets := range sources {
+		insert(ipNets)
+	}
+
+	r.mutex.Lock()
+	r.ranger, r.sources, r.size = ranger, sources, size
+	r.lastRefresh, r.errors = time.Now(), errors
+	r.mutex.Unlock()
+}
+
+func fetch(url string) ([]byte, error) {
+	resp, err := fetchClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxListSize))
+}
+
+// parseIPList parses the IPs or CIDRs, one per line. The comments after #
+// or ; and the fields after the first one are ignored, so the common
+// formats like the DROP list of Spamhaus are supported.
+func parseIPList(content []byte) []net.IPNet {
+	var ipNets []net.IPNet
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		s := fields[0]
+		if ip := net.ParseIP(s); ip != nil {
+			if ip4 := ip.To4(); ip4 != nil {
+				ipNets = append(ipNets, net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)})
+			} else {
+				ipNets = append(ipNets, net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+			}
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(s); err == nil {
+			ipNets = append(ipNets, *ipNet)
+		}
+	}
+	return ipNets
+}
+
+func (r *rule) match(ip net.IP, record *geoip.Record) bool {
+	if ip == nil {
+		return false
+	}
+
+	r.mutex.RLock()
+	ranger := r.ranger
+	r.mutex.RUnlock()
+	if contains, _ := ranger.Contains(ip); contains {
+		return true
+	}
+
+	if record == nil {
+		return false
+	}
+	return r.countries[record.Country] || r.continents[record.Continent] || r.asns[record.ASN]
+}
+
+func (r *rule) status() *RuleStatus {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return &RuleStatus{
+		Hits:        atomic.LoadUint64(&r.hits),
+		IPs:         r.size,
+		LastRefresh: r.lastRefresh,
+		Errors:      r.errors,
+	}
+}
@@ -0,0 +1,203 @@
+// +build javascript
+
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package javascript
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dop251/goja"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+// bindGlobals binds the API to the global object, the API is bound once
+// for a runtime and operates on the context attached to the runtime.
+func (rt *runtime) bindGlobals(params map[string]string) {
+	vm := rt.vm
+
+	if params == nil {
+		params = map[string]string{}
+	}
+	vm.Set("params", params)
+	vm.Set("require", rt.require)
+
+	rt.request = rt.newRequest()
+	rt.response = rt.newResponse()
+	vm.Set("kv", rt.newKV())
+	vm.Set("log", rt.newLog())
+}
+
+func (rt *runtime) bindHeader(obj *goja.Object, header func() *httpheader.HTTPHeader) {
+	obj.Set("header", func(name string) string {
+		return header().Get(name)
+	})
+	obj.Set("headerValues", func(name string) []string {
+		return header().GetAll(name)
+	})
+	obj.Set("setHeader", func(name, value string) {
+		header().Set(name, value)
+	})
+	obj.Set("addHeader", func(name, value string) {
+		header().Add(name, value)
+	})
+	obj.Set("delHeader", func(name string) {
+		header().Del(name)
+	})
+}
+
+func readAll(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return []byte{}, nil
+	}
+	data, err := io.ReadAll(r)
+	if data == nil {
+		data = []byte{}
+	}
+	return data, err
+}
+
+func (rt *runtime) newRequest() *goja.Object {
+	obj := rt.vm.NewObject()
+
+	obj.Set("method", func() string { return rt.ctx.Request().Method() })
+	obj.Set("setMethod", func(m string) { rt.ctx.Request().SetMethod(m) })
+	obj.Set("scheme", func() string { return rt.ctx.Request().Scheme() })
+	obj.Set("host", func() string { return rt.ctx.Request().Host() })
+	obj.Set("setHost", func(h string) { rt.ctx.Request().SetHost(h) })
+	obj.Set("path", func() string { return rt.ctx.Request().Path() })
+	obj.Set("setPath", func(p string) { rt.ctx.Request().SetPath(p) })
+	obj.Set("query", func() string { return rt.ctx.Request().Query() })
+	obj.Set("setQuery", func(q string) { rt.ctx.Request().SetQuery(q) })
+	obj.Set("proto", func() string { return rt.ctx.Request().Proto() })
+	obj.Set("realIP", func() string { return rt.ctx.Request().RealIP() })
+
+	obj.Set("cookie", func(name string) goja.Value {
+		c, err := rt.ctx.Request().Cookie(name)
+		if err != nil {
+			return goja.Null()
+		}
+		return rt.vm.ToValue(c.Value)
+	})
+
+	rt.bindHeader(obj, func() *httpheader.HTTPHeader { return rt.ctx.Request().Header() })
+
+	obj.Set("body", func() string {
+		if rt.reqBody == nil {
+			data, err := readAll(rt.ctx.Request().Body())
+			if err != nil {
+				rt.throw(fmt.Errorf("failed to read request body: %v", err))
+			}
+			rt.reqBody = data
+			rt.ctx.Request().SetBody(bytes.NewReader(data))
+		}
+		return string(rt.reqBody)
+	})
+	obj.Set("setBody", func(body string) {
+		rt.reqBody = []byte(body)
+		rt.ctx.Request().SetBody(bytes.NewReader(rt.reqBody))
+	})
+
+	return obj
+}
+
+func (rt *runtime) newResponse() *goja.Object {
+	obj := rt.vm.NewObject()
+
+	obj.Set("statusCode", func() int { return rt.ctx.Response().StatusCode() })
+	obj.Set("setStatusCode", func(code int) { rt.ctx.Response().SetStatusCode(code) })
+
+	rt.bindHeader(obj, func() *httpheader.HTTPHeader { return rt.ctx.Response().Header() })
+
+	obj.Set("body", func() string {
+		if rt.respBody == nil {
+			data, err := readAll(rt.ctx.Response().Body())
+			if err != nil {
+				rt.throw(fmt.Errorf("failed to read response body: %v", err))
+			}
+			rt.respBody = data
+			rt.ctx.Response().SetBody(bytes.NewReader(data))
+		}
+		return string(rt.respBody)
+	})
+	obj.Set("setBody", func(body string) {
+		rt.respBody = []byte(body)
+		rt.ctx.Response().SetBody(bytes.NewReader(rt.respBody))
+	})
+
+	return obj
+}
+
+func (rt *runtime) kvStore() *cluster.KVStore {
+	store, err := rt.filterSpec.KVStore()
+	if err != nil {
+		rt.throw(err)
+	}
+	return store
+}
+
+func (rt *runtime) newKV() *goja.Object {
+	obj := rt.vm.NewObject()
+
+	// get returns null if the key doesn't exist.
+	obj.Set("get", func(key string) goja.Value {
+		value, ok := rt.kvStore().Get(key)
+		if !ok {
+			return goja.Null()
+		}
+		return rt.vm.ToValue(value)
+	})
+	obj.Set("put", func(key, value string, ttlInMs int64) {
+		err := rt.kvStore().Put(key, value, time.Duration(ttlInMs)*time.Millisecond)
+		if err != nil {
+			rt.throw(err)
+		}
+	})
+	obj.Set("delete", func(key string) {
+		if err := rt.kvStore().Delete(key); err != nil {
+			rt.throw(err)
+		}
+	})
+	obj.Set("incr", func(key string, delta, ttlInMs int64) int64 {
+		n, err := rt.kvStore().Incr(key, delta, time.Duration(ttlInMs)*time.Millisecond)
+		if err != nil {
+			rt.throw(err)
+		}
+		return n
+	})
+
+	return obj
+}
+
+func (rt *runtime) newLog() *goja.Object {
+	obj := rt.vm.NewObject()
+	name := rt.filterSpec.Name()
+
+	obj.Set("debug", func(msg string) { logger.Debugf("[javascript %s] %s", name, msg) })
+	obj.Set("info", func(msg string) { logger.Infof("[javascript %s] %s", name, msg) })
+	obj.Set("warn", func(msg string) { logger.Warnf("[javascript %s] %s", name, msg) })
+	obj.Set("error", func(msg string) { logger.Errorf("[javascript %s] %s", name, msg) })
+
+	return obj
+}
@@ -0,0 +1,321 @@
+// +build javascript
+
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package javascript
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dop251/goja"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of JavaScript.
+	Kind = "JavaScript"
+
+	maxScriptResult = 9
+	handlerName     = "handle"
+)
+
+var (
+	resultScriptError = "scriptError"
+	resultTimeout     = "timeout"
+	results           = []string{resultScriptError, resultTimeout}
+)
+
+func scriptResultToFilterResult(r int64) string {
+	if r == 0 {
+		return ""
+	}
+	return fmt.Sprintf("jsResult%d", r)
+}
+
+func init() {
+	for i := int64(1); i <= maxScriptResult; i++ {
+		results = append(results, scriptResultToFilterResult(i))
+	}
+	httppipeline.Register(&JavaScript{})
+}
+
+type (
+	// Spec is the spec of JavaScript.
+	Spec struct {
+		MaxConcurrency int32  `yaml:"maxConcurrency" jsonschema:"required,minimum=1"`
+		Code           string `yaml:"code" jsonschema:"required"`
+		Timeout        string `yaml:"timeout" jsonschema:"required,format=duration"`
+		// MaxCallStackSize limits the depth of recursive calls, zero
+		// means no limit.
+		MaxCallStackSize int               `yaml:"maxCallStackSize,omitempty" jsonschema:"omitempty,minimum=1"`
+		Parameters       map[string]string `yaml:"parameters" jsonschema:"omitempty"`
+		// Modules are the modules could be loaded by 'require', the keys
+		// are module names and the values are the code.
+		Modules map[string]string `yaml:"modules" jsonschema:"omitempty"`
+		timeout time.Duration
+	}
+
+	// JavaScript is a filter executing user-developed JavaScript code.
+	JavaScript struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		pool *runtimePool
+
+		numOfRequest int64
+		numOfError   int64
+		numOfTimeout int64
+	}
+
+	// Status is the status of JavaScript.
+	Status struct {
+		Health       string `yaml:"health"`
+		NumOfRequest int64  `yaml:"numOfRequest"`
+		NumOfError   int64  `yaml:"numOfError"`
+		NumOfTimeout int64  `yaml:"numOfTimeout"`
+	}
+)
+
+// Validate validates the spec.
+func (spec Spec) Validate() error {
+	if spec.Code == "" {
+		return fmt.Errorf("code is required")
+	}
+	code, err := readCode(spec.Code)
+	if err != nil {
+		return fmt.Errorf("failed to read code: %v", err)
+	}
+	if _, err = compile("main", code); err != nil {
+		return err
+	}
+
+	for name, m := range spec.Modules {
+		code, err := readCode(m)
+		if err != nil {
+			return fmt.Errorf("failed to read module %s: %v", name, err)
+		}
+		if _, err = compileModule(name, code); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Kind returns the kind of JavaScript.
+func (js *JavaScript) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of JavaScript.
+func (js *JavaScript) DefaultSpec() interface{} {
+	return &Spec{
+		MaxConcurrency: 10,
+		Timeout:        "100ms",
+	}
+}
+
+// Description returns the description of JavaScript
+func (js *JavaScript) Description() string {
+	return "JavaScript executes user-developed JavaScript code."
+}
+
+// Results returns the results of JavaScript.
+func (js *JavaScript) Results() []string {
+	return results
+}
+
+func isURL(str string) bool {
+	for _, p := range []string{"http://", "https://"} {
+		if len(str) > len(p) && p == strings.ToLower(str[:len(p)]) {
+			return true
+		}
+	}
+	return false
+}
+
+// readCode reads the code from a URL, a file, or returns the code itself
+// if it is not a URL or a file. Base64 encoded code is prefixed by
+// 'base64:'.
+func readCode(code string) (string, error) {
+	if isURL(code) {
+		resp, err := http.DefaultClient.Get(code)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+		data, err := io.ReadAll(resp.Body)
+		return string(data), err
+	}
+
+	if strings.HasPrefix(code, "base64:") {
+		data, err := base64.StdEncoding.DecodeString(code[len("base64:"):])
+		return string(data), err
+	}
+
+	// NOTE: Inline code always contains characters which are not in a
+	// path, so the file is checked only if the code is a single line.
+	if !strings.ContainsAny(code, "\n;(") {
+		if _, err := os.Stat(code); err == nil {
+			data, err := os.ReadFile(code)
+			return string(data), err
+		}
+	}
+
+	return code, nil
+}
+
+func (js *JavaScript) reload() {
+	js.spec.timeout, _ = time.ParseDuration(js.spec.Timeout)
+
+	p, err := newRuntimePool(js.filterSpec, js.spec)
+	if err != nil {
+		logger.Errorf("failed to create JavaScript runtime pool for %s: %v", js.filterSpec.Name(), err)
+		return
+	}
+	js.pool = p
+}
+
+// Init initializes JavaScript.
+func (js *JavaScript) Init(filterSpec *httppipeline.FilterSpec) {
+	js.filterSpec, js.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	js.reload()
+}
+
+// Inherit inherits previous generation of JavaScript.
+func (js *JavaScript) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	js.Init(filterSpec)
+}
+
+// Handle handles HTTP request
+func (js *JavaScript) Handle(ctx context.HTTPContext) string {
+	result := js.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (js *JavaScript) handle(ctx context.HTTPContext) (result string) {
+	if js.pool == nil {
+		ctx.AddTag("javascript: runtime pool is not initialized")
+		return resultScriptError
+	}
+
+	rt := js.pool.get()
+	if rt == nil {
+		ctx.AddTag("javascript: failed to get a runtime")
+		return resultScriptError
+	}
+	atomic.AddInt64(&js.numOfRequest, 1)
+
+	rt.attach(ctx)
+
+	var wg sync.WaitGroup
+	var timedOut int32
+	chDone := make(chan struct{})
+	defer func() {
+		close(chDone)
+		wg.Wait()
+		rt.vm.ClearInterrupt()
+		rt.detach()
+
+		// the runtime may be in an unknown state after a Go panic, drop it
+		// and a new one will be created by the pool later.
+		if e := recover(); e != nil {
+			logger.Errorf("javascript %s: recovered from %v", js.filterSpec.Name(), e)
+			ctx.AddTag(fmt.Sprintf("javascript: %v", e))
+			atomic.AddInt64(&js.numOfError, 1)
+			result = resultScriptError
+			rt = nil
+		}
+
+		js.pool.put(rt)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		timer := time.NewTimer(js.spec.timeout)
+		defer timer.Stop()
+
+		select {
+		case <-chDone:
+		case <-timer.C:
+			atomic.StoreInt32(&timedOut, 1)
+			rt.vm.Interrupt("timeout")
+		case <-ctx.Done():
+			rt.vm.Interrupt("request canceled")
+		}
+	}()
+
+	v, err := rt.handler(goja.Undefined(), rt.request, rt.response)
+	if err != nil {
+		if atomic.LoadInt32(&timedOut) == 1 {
+			ctx.AddTag(fmt.Sprintf("javascript: execution timeout after %s", js.spec.timeout))
+			atomic.AddInt64(&js.numOfTimeout, 1)
+			return resultTimeout
+		}
+		ctx.AddTag(fmt.Sprintf("javascript: %v", err))
+		atomic.AddInt64(&js.numOfError, 1)
+		return resultScriptError
+	}
+
+	if goja.IsUndefined(v) || goja.IsNull(v) {
+		return ""
+	}
+	n := v.ToInteger()
+	if n < 0 || n > maxScriptResult {
+		ctx.AddTag(fmt.Sprintf("javascript: invalid result %v", v))
+		atomic.AddInt64(&js.numOfError, 1)
+		return resultScriptError
+	}
+	return scriptResultToFilterResult(n)
+}
+
+// Status returns Status generated by the filter.
+func (js *JavaScript) Status() interface{} {
+	s := &Status{
+		NumOfRequest: atomic.LoadInt64(&js.numOfRequest),
+		NumOfError:   atomic.LoadInt64(&js.numOfError),
+		NumOfTimeout: atomic.LoadInt64(&js.numOfTimeout),
+	}
+	if js.pool == nil {
+		s.Health = "runtime pool is not initialized"
+	} else {
+		s.Health = "ready"
+	}
+	return s
+}
+
+// Close closes JavaScript.
+func (js *JavaScript) Close() {
+}
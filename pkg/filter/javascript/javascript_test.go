@@ -0,0 +1,274 @@
+// +build javascript
+
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package javascript
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newFilterSpec(yamlSpec string) (*httppipeline.FilterSpec, error) {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+	return httppipeline.NewFilterSpec(rawSpec, nil)
+}
+
+func newJavaScript(t *testing.T, yamlSpec string) *JavaScript {
+	spec, err := newFilterSpec(yamlSpec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	js := &JavaScript{}
+	js.Init(spec)
+	return js
+}
+
+func newContext(method, url, body string) context.HTTPContext {
+	stdr, _ := http.NewRequest(method, url, strings.NewReader(body))
+	stdr.Header.Set("X-Request", "foo")
+	ctx := context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		return lastResult
+	})
+	return ctx
+}
+
+func TestValidate(t *testing.T) {
+	for i, yamlSpec := range []string{
+		"kind: JavaScript\nname: js\n",
+		"kind: JavaScript\nname: js\ncode: 'function handle( {'\n",
+		"kind: JavaScript\nname: js\ncode: 'function handle() {}'\nmodules:\n  util: 'exports.f = function( {'\n",
+		"kind: JavaScript\nname: js\ncode: 'function handle() {}'\nmaxConcurrency: 0\n",
+	} {
+		if _, err := newFilterSpec(yamlSpec); err == nil {
+			t.Errorf("case %d: spec should be invalid", i)
+		}
+	}
+}
+
+func TestReadCode(t *testing.T) {
+	code, err := readCode("base64:ZnVuY3Rpb24gaGFuZGxlKCkge30=")
+	if err != nil || code != "function handle() {}" {
+		t.Errorf("unexpected code %q, error %v", code, err)
+	}
+
+	f, err := ioutil.TempFile("", "javascript")
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("function handle() { return 1; }")
+	f.Close()
+
+	code, err = readCode(f.Name())
+	if err != nil || code != "function handle() { return 1; }" {
+		t.Errorf("unexpected code %q, error %v", code, err)
+	}
+
+	code, err = readCode("function handle() {}")
+	if err != nil || code != "function handle() {}" {
+		t.Errorf("unexpected code %q, error %v", code, err)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	js := newJavaScript(t, `
+kind: JavaScript
+name: js
+parameters:
+  prefix: /admin
+modules:
+  util: |
+    exports.isAdmin = function(path) { return path.indexOf(params.prefix) === 0; };
+code: |
+  var util = require("util");
+  function handle(request, response) {
+    if (util.isAdmin(request.path())) {
+      response.setStatusCode(403);
+      response.setHeader("X-Reason", "admin");
+      return 1;
+    }
+    request.setHeader("X-Request", request.header("X-Request") + "bar");
+    request.delHeader("X-Deleted");
+    request.setBody(request.method() + " " + request.body());
+  }
+`)
+	defer js.Close()
+
+	ctx := newContext(http.MethodPost, "http://example.com/admin/users", "")
+	if result := js.Handle(ctx); result != "jsResult1" {
+		t.Errorf("result should be jsResult1, but got %q", result)
+	}
+	if ctx.Response().StatusCode() != http.StatusForbidden {
+		t.Errorf("status code should be 403, but got %d", ctx.Response().StatusCode())
+	}
+	if v := ctx.Response().Header().Get("X-Reason"); v != "admin" {
+		t.Errorf("X-Reason should be admin, but got %q", v)
+	}
+
+	ctx = newContext(http.MethodPost, "http://example.com/users", "hello")
+	ctx.Request().Header().Set("X-Deleted", "1")
+	if result := js.Handle(ctx); result != "" {
+		t.Errorf("result should be empty, but got %q", result)
+	}
+	if v := ctx.Request().Header().Get("X-Request"); v != "foobar" {
+		t.Errorf("X-Request should be foobar, but got %q", v)
+	}
+	if v := ctx.Request().Header().Get("X-Deleted"); v != "" {
+		t.Errorf("X-Deleted should be deleted, but got %q", v)
+	}
+	body, _ := ioutil.ReadAll(ctx.Request().Body())
+	if string(body) != "POST hello" {
+		t.Errorf("body should be %q, but got %q", "POST hello", body)
+	}
+
+	status := js.Status().(*Status)
+	if status.Health != "ready" || status.NumOfRequest != 2 || status.NumOfError != 0 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestResults(t *testing.T) {
+	js := newJavaScript(t, `
+kind: JavaScript
+name: js
+maxConcurrency: 1
+code: |
+  var count = 0;
+  function handle(request, response) {
+    count++;
+    var q = request.query();
+    if (q === "throw") {
+      throw new Error("oops");
+    }
+    if (q === "count") {
+      return count;
+    }
+    return parseInt(q);
+  }
+`)
+	defer js.Close()
+
+	for _, c := range []struct {
+		query  string
+		result string
+	}{
+		{"0", ""},
+		{"3", "jsResult3"},
+		{"9", "jsResult9"},
+		{"10", resultScriptError},
+		{"-1", resultScriptError},
+		{"throw", resultScriptError},
+		// The global variables are kept across requests by the only runtime.
+		{"count", "jsResult7"},
+	} {
+		ctx := newContext(http.MethodGet, "http://example.com/?"+c.query, "")
+		if result := js.Handle(ctx); result != c.result {
+			t.Errorf("query %s: result should be %q, but got %q", c.query, c.result, result)
+		}
+	}
+
+	status := js.Status().(*Status)
+	if status.NumOfRequest != 7 || status.NumOfError != 3 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	js := newJavaScript(t, `
+kind: JavaScript
+name: js
+maxConcurrency: 1
+timeout: 20ms
+code: |
+  function handle(request, response) {
+    if (request.query() === "loop") {
+      for (;;) {}
+    }
+  }
+`)
+	defer js.Close()
+
+	ctx := newContext(http.MethodGet, "http://example.com/?loop", "")
+	if result := js.Handle(ctx); result != resultTimeout {
+		t.Errorf("result should be %s, but got %q", resultTimeout, result)
+	}
+
+	// The interrupted runtime is reused by the next request.
+	ctx = newContext(http.MethodGet, "http://example.com/", "")
+	if result := js.Handle(ctx); result != "" {
+		t.Errorf("result should be empty, but got %q", result)
+	}
+
+	status := js.Status().(*Status)
+	if status.NumOfTimeout != 1 || status.NumOfError != 0 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestMaxCallStackSize(t *testing.T) {
+	js := newJavaScript(t, `
+kind: JavaScript
+name: js
+maxCallStackSize: 100
+code: |
+  function f(n) { return n === 0 ? 0 : f(n - 1); }
+  function handle(request, response) { f(parseInt(request.query())); }
+`)
+	defer js.Close()
+
+	ctx := newContext(http.MethodGet, "http://example.com/?50", "")
+	if result := js.Handle(ctx); result != "" {
+		t.Errorf("result should be empty, but got %q", result)
+	}
+	ctx = newContext(http.MethodGet, "http://example.com/?1000", "")
+	if result := js.Handle(ctx); result != resultScriptError {
+		t.Errorf("result should be %s, but got %q", resultScriptError, result)
+	}
+}
+
+func TestNoHandler(t *testing.T) {
+	js := newJavaScript(t, "kind: JavaScript\nname: js\ncode: 'var handler = 1;'\n")
+	defer js.Close()
+
+	ctx := newContext(http.MethodGet, "http://example.com/", "")
+	if result := js.Handle(ctx); result != resultScriptError {
+		t.Errorf("result should be %s, but got %q", resultScriptError, result)
+	}
+	if status := js.Status().(*Status); status.Health == "ready" {
+		t.Errorf("the filter should not be ready")
+	}
+}
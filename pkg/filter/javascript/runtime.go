@@ -0,0 +1,275 @@
+// +build javascript
+
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package javascript
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/dop251/goja"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+// maxCachedPrograms is the maximum number of compiled programs kept in
+// the cache shared by all filters.
+const maxCachedPrograms = 256
+
+type (
+	// programCache caches the compiled programs by the hash of their
+	// code, programs are immutable and could be run by many runtimes, so
+	// the same code is only compiled once even if it is used by many
+	// filters or generations of a filter.
+	programCache struct {
+		mutex    sync.Mutex
+		lru      *list.List
+		programs map[[sha256.Size]byte]*list.Element
+	}
+
+	cachedProgram struct {
+		hash    [sha256.Size]byte
+		program *goja.Program
+	}
+
+	runtime struct {
+		vm       *goja.Runtime
+		handler  goja.Callable
+		request  goja.Value
+		response goja.Value
+
+		filterSpec *httppipeline.FilterSpec
+		modules    map[string]*goja.Program
+		exports    map[string]goja.Value
+
+		ctx context.HTTPContext
+		// the bodies are read at most once for a request.
+		reqBody  []byte
+		respBody []byte
+	}
+
+	runtimePool struct {
+		chRuntime  chan *runtime
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+		main       *goja.Program
+		modules    map[string]*goja.Program
+	}
+)
+
+var programs = &programCache{
+	lru:      list.New(),
+	programs: map[[sha256.Size]byte]*list.Element{},
+}
+
+func (pc *programCache) get(name, code string) (*goja.Program, error) {
+	hash := sha256.Sum256([]byte(code))
+
+	pc.mutex.Lock()
+	if e := pc.programs[hash]; e != nil {
+		pc.lru.MoveToFront(e)
+		pc.mutex.Unlock()
+		return e.Value.(*cachedProgram).program, nil
+	}
+	pc.mutex.Unlock()
+
+	p, err := goja.Compile(name, code, false)
+	if err != nil {
+		return nil, err
+	}
+
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	if e := pc.programs[hash]; e != nil {
+		return e.Value.(*cachedProgram).program, nil
+	}
+	pc.programs[hash] = pc.lru.PushFront(&cachedProgram{hash: hash, program: p})
+	if pc.lru.Len() > maxCachedPrograms {
+		e := pc.lru.Back()
+		pc.lru.Remove(e)
+		delete(pc.programs, e.Value.(*cachedProgram).hash)
+	}
+	return p, nil
+}
+
+func compile(name, code string) (*goja.Program, error) {
+	return programs.get(name, code)
+}
+
+// compileModule compiles a module in the CommonJS style, the module code
+// is wrapped by a function whose arguments are the exports, module and
+// require.
+func compileModule(name, code string) (*goja.Program, error) {
+	code = "(function(exports, module, require) {" + code + "\n})"
+	return programs.get(name, code)
+}
+
+func newRuntimePool(filterSpec *httppipeline.FilterSpec, spec *Spec) (*runtimePool, error) {
+	code, err := readCode(spec.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &runtimePool{
+		filterSpec: filterSpec,
+		spec:       spec,
+		modules:    map[string]*goja.Program{},
+	}
+
+	p.main, err = compile(filterSpec.Name(), code)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, m := range spec.Modules {
+		code, err := readCode(m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read module %s: %v", name, err)
+		}
+		p.modules[name], err = compileModule(name, code)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p.chRuntime = make(chan *runtime, spec.MaxConcurrency)
+	for i := int32(0); i < spec.MaxConcurrency; i++ {
+		rt, err := p.newRuntime()
+		if err != nil {
+			// the error of the first runtime is returned as all runtimes
+			// run the same code.
+			if i == 0 {
+				return nil, err
+			}
+			logger.Errorf("failed to create JavaScript runtime: %v", err)
+		}
+		p.chRuntime <- rt
+	}
+
+	return p, nil
+}
+
+func (p *runtimePool) newRuntime() (*runtime, error) {
+	rt := &runtime{
+		vm:         goja.New(),
+		filterSpec: p.filterSpec,
+		modules:    p.modules,
+		exports:    map[string]goja.Value{},
+	}
+	if p.spec.MaxCallStackSize > 0 {
+		rt.vm.SetMaxCallStackSize(p.spec.MaxCallStackSize)
+	}
+
+	rt.bindGlobals(p.spec.Parameters)
+
+	if _, err := rt.vm.RunProgram(p.main); err != nil {
+		return nil, err
+	}
+
+	handler, ok := goja.AssertFunction(rt.vm.Get(handlerName))
+	if !ok {
+		return nil, fmt.Errorf("code does not define function '%s'", handlerName)
+	}
+	rt.handler = handler
+
+	return rt, nil
+}
+
+// get gets a runtime from the pool, it blocks if all runtimes are busy.
+func (p *runtimePool) get() *runtime {
+	rt := <-p.chRuntime
+	if rt != nil {
+		return rt
+	}
+
+	rt, err := p.newRuntime()
+	if err != nil {
+		p.chRuntime <- nil
+		logger.Errorf("failed to create JavaScript runtime: %v", err)
+		return nil
+	}
+	return rt
+}
+
+// put puts a runtime to the pool, putting a nil runtime is allowed and
+// will cause get to create a new runtime later.
+func (p *runtimePool) put(rt *runtime) {
+	p.chRuntime <- rt
+}
+
+// require loads a module, the exports of a module are cached, so the
+// code of a module is only executed once in a runtime.
+func (rt *runtime) require(call goja.FunctionCall) goja.Value {
+	name := call.Argument(0).String()
+	if exports, ok := rt.exports[name]; ok {
+		return exports
+	}
+
+	p := rt.modules[name]
+	if p == nil {
+		panic(rt.vm.NewTypeError("module '%s' is not found", name))
+	}
+
+	v, err := rt.vm.RunProgram(p)
+	if err != nil {
+		rt.throw(err)
+	}
+	fn, _ := goja.AssertFunction(v)
+
+	module := rt.vm.NewObject()
+	exports := rt.vm.NewObject()
+	module.Set("exports", exports)
+	// NOTE: The exports are cached before running the module to support
+	// circular requires, the same as Node.js.
+	rt.exports[name] = exports
+
+	if _, err = fn(goja.Undefined(), exports, module, rt.vm.Get("require")); err != nil {
+		delete(rt.exports, name)
+		rt.throw(err)
+	}
+
+	result := module.Get("exports")
+	rt.exports[name] = result
+	return result
+}
+
+// throw throws err as a JavaScript exception.
+func (rt *runtime) throw(err error) {
+	switch e := err.(type) {
+	case *goja.Exception:
+		panic(e.Value())
+	case *goja.InterruptedError:
+		panic(e)
+	default:
+		panic(rt.vm.NewGoError(err))
+	}
+}
+
+func (rt *runtime) attach(ctx context.HTTPContext) {
+	rt.ctx = ctx
+}
+
+func (rt *runtime) detach() {
+	rt.ctx, rt.reqBody, rt.respBody = nil, nil, nil
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mock
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+// matchRequest holds the parts of the request parsed lazily, since most
+// rules don't need them.
+type matchRequest struct {
+	req context.HTTPRequest
+
+	query url.Values
+	body  []byte
+	read  bool
+}
+
+func (mr *matchRequest) getQuery() url.Values {
+	if mr.query == nil {
+		mr.query, _ = url.ParseQuery(mr.req.Query())
+	}
+	return mr.query
+}
+
+// getBody reads the body and restores it for the following filters.
+func (mr *matchRequest) getBody() []byte {
+	if !mr.read {
+		mr.read = true
+		if body := mr.req.Body(); body != nil {
+			mr.body, _ = ioutil.ReadAll(body)
+			mr.req.SetBody(bytes.NewReader(mr.body))
+		}
+	}
+	return mr.body
+}
+
+func (r *Rule) initMatch() {
+	for _, q := range r.Queries {
+		q.Init()
+	}
+	for _, v := range r.BodyJSON {
+		v.Init()
+	}
+	if r.BodyRegexp != "" {
+		r.bodyRE = regexp.MustCompile(r.BodyRegexp)
+	}
+}
+
+func (r *Rule) match(mr *matchRequest) bool {
+	if r.Path != "" || r.PathPrefix != "" {
+		path := mr.req.Path()
+		if r.Path != path && (r.PathPrefix == "" || !strings.HasPrefix(path, r.PathPrefix)) {
+			return false
+		}
+	}
+
+	if len(r.Methods) > 0 && !stringtool.StrInSlice(mr.req.Method(), r.Methods) {
+		return false
+	}
+
+	for key, sm := range r.Queries {
+		matched := false
+		for _, value := range mr.getQuery()[key] {
+			if sm.Match(value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if r.bodyRE != nil && !r.bodyRE.Match(mr.getBody()) {
+		return false
+	}
+
+	if len(r.BodyJSON) > 0 {
+		body := mr.getBody()
+		if !gjson.ValidBytes(body) {
+			return false
+		}
+		for path, sm := range r.BodyJSON {
+			result := gjson.GetBytes(body, path)
+			if !result.Exists() || !sm.Match(result.String()) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -18,12 +18,23 @@
 package mock
 
 import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+	"github.com/megaease/easegress/pkg/util/urlrule"
 )
 
 const (
@@ -35,8 +46,18 @@ const (
 
 var results = []string{resultMocked}
 
+// ruleMatches counts the requests matching every rule, so that load tests
+// could verify which rule served the requests.
+var ruleMatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "easegress",
+	Subsystem: "mock",
+	Name:      "rule_matches_total",
+	Help:      "The number of requests matching the rules of Mock filters.",
+}, []string{"pipeline", "filter", "rule"})
+
 func init() {
 	httppipeline.Register(&Mock{})
+	prometheus.MustRegister(ruleMatches)
 }
 
 type (
@@ -45,27 +66,139 @@ type (
 		filterSpec *httppipeline.FilterSpec
 		spec       *Spec
 
-		body []byte
+		body      []byte
+		recorder  *recorder
+		done      chan struct{}
+		closeOnce sync.Once
+		wg        sync.WaitGroup
 	}
 
 	// Spec describes the Mock.
 	Spec struct {
 		Rules []*Rule `yaml:"rules"`
+		// MatchedRuleHeader is the response header to carry the name of
+		// the matched rule, no header is set if it's empty.
+		MatchedRuleHeader string `yaml:"matchedRuleHeader" jsonschema:"omitempty"`
+		// Recording records the responses of the requests unmatched by
+		// the rules, or replays the recorded responses.
+		Recording *Recording `yaml:"recording,omitempty" jsonschema:"omitempty"`
 	}
 
 	// Rule is the mock rule.
 	Rule struct {
-		Path       string            `yaml:"path,omitempty" jsonschema:"omitempty,pattern=^/"`
-		PathPrefix string            `yaml:"pathPrefix,omitempty" jsonschema:"omitempty,pattern=^/"`
-		Code       int               `yaml:"code" jsonschema:"required,format=httpcode"`
-		Headers    map[string]string `yaml:"headers" jsonschema:"omitempty"`
-		Body       string            `yaml:"body" jsonschema:"omitempty"`
-		Delay      string            `yaml:"delay" jsonschema:"omitempty,format=duration"`
+		// Name is the name of the rule, the index is used if it's empty.
+		Name       string `yaml:"name,omitempty" jsonschema:"omitempty"`
+		Path       string `yaml:"path,omitempty" jsonschema:"omitempty,pattern=^/"`
+		PathPrefix string `yaml:"pathPrefix,omitempty" jsonschema:"omitempty,pattern=^/"`
+		// Methods, Queries, BodyRegexp and BodyJSON narrow the requests
+		// matching the path, BodyJSON maps JSON paths of the body, e.g.
+		// user.id, to the matches of their values.
+		Methods    []string                        `yaml:"methods,omitempty" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		Queries    map[string]*urlrule.StringMatch `yaml:"queries,omitempty" jsonschema:"omitempty"`
+		BodyRegexp string                          `yaml:"bodyRegexp,omitempty" jsonschema:"omitempty,format=regexp"`
+		BodyJSON   map[string]*urlrule.StringMatch `yaml:"bodyJSON,omitempty" jsonschema:"omitempty"`
+		Code       int                             `yaml:"code,omitempty" jsonschema:"omitempty,format=httpcode"`
+		Headers    map[string]string               `yaml:"headers" jsonschema:"omitempty"`
+		Body       string                          `yaml:"body" jsonschema:"omitempty"`
+		// BodyFile and BodyFromEtcd load the body from a file or an etcd
+		// key instead, which is reloaded when it changes.
+		BodyFile     string `yaml:"bodyFile,omitempty" jsonschema:"omitempty"`
+		BodyFromEtcd string `yaml:"bodyFromEtcd,omitempty" jsonschema:"omitempty"`
+		// BodyBase64 decodes the loaded body as base64, for binary bodies.
+		BodyBase64 bool   `yaml:"bodyBase64,omitempty" jsonschema:"omitempty"`
+		Delay      string `yaml:"delay" jsonschema:"omitempty,format=duration"`
+		// Template renders the body and headers as Go templates of the request.
+		Template bool `yaml:"template" jsonschema:"omitempty"`
+		// Responses are the weighted candidate responses, one of which is
+		// picked randomly for every request, the headers of the rule are
+		// shared by them.
+		Responses []*Response `yaml:"responses,omitempty" jsonschema:"omitempty"`
+
+		delay    time.Duration
+		template *ruleTemplate
+		name     string
+		matches  uint64
+		counter  prometheus.Counter
+		body     atomic.Value
+		bodyRE   *regexp.Regexp
+	}
+
+	// Response is a candidate response of a rule.
+	Response struct {
+		Weight  int               `yaml:"weight" jsonschema:"required,minimum=1"`
+		Code    int               `yaml:"code" jsonschema:"required,format=httpcode"`
+		Headers map[string]string `yaml:"headers" jsonschema:"omitempty"`
+		Body    string            `yaml:"body" jsonschema:"omitempty"`
+		// Delay overrides the delay of the rule.
+		Delay string `yaml:"delay" jsonschema:"omitempty,format=duration"`
 
 		delay time.Duration
 	}
+
+	// Status is the status of Mock.
+	Status struct {
+		// Matches are the number of requests matching every rule.
+		Matches map[string]uint64 `yaml:"matches"`
+		// Recorded and Replayed are the number of recorded and replayed
+		// responses.
+		Recorded uint64 `yaml:"recorded,omitempty"`
+		Replayed uint64 `yaml:"replayed,omitempty"`
+	}
 )
 
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	names := map[string]bool{}
+	for i, r := range spec.Rules {
+		name := r.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		if names[name] {
+			return fmt.Errorf("duplicated rule name %s", name)
+		}
+		names[name] = true
+
+		for key, sm := range r.Queries {
+			if err := sm.Validate(); err != nil {
+				return fmt.Errorf("rule %s: query %s: %v", name, key, err)
+			}
+		}
+		for path, sm := range r.BodyJSON {
+			if err := sm.Validate(); err != nil {
+				return fmt.Errorf("rule %s: body json %s: %v", name, path, err)
+			}
+		}
+
+		if len(r.Responses) == 0 && r.Code == 0 {
+			return fmt.Errorf("rule %s: code or responses is required", name)
+		}
+		if len(r.Responses) != 0 && (r.Code != 0 || r.Body != "" || r.hasExternalBody() || r.Template) {
+			return fmt.Errorf("rule %s: responses conflict with code, body and template", name)
+		}
+
+		if r.BodyFile != "" && r.BodyFromEtcd != "" {
+			return fmt.Errorf("rule %s: both bodyFile and bodyFromEtcd are specified", name)
+		}
+		if r.hasExternalBody() && r.Body != "" {
+			return fmt.Errorf("rule %s: body conflicts with bodyFile and bodyFromEtcd", name)
+		}
+		if r.hasExternalBody() && r.Template {
+			return fmt.Errorf("rule %s: template requires an inline body", name)
+		}
+		if r.BodyBase64 && !r.hasExternalBody() {
+			return fmt.Errorf("rule %s: bodyBase64 requires bodyFile or bodyFromEtcd", name)
+		}
+
+		if r.Template {
+			if _, err := newRuleTemplate(r); err != nil {
+				return fmt.Errorf("rule %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
 // Kind returns the kind of Mock.
 func (m *Mock) Kind() string {
 	return Kind
@@ -99,7 +232,32 @@ func (m *Mock) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration h
 }
 
 func (m *Mock) reload() {
-	for _, r := range m.spec.Rules {
+	m.done = make(chan struct{})
+	if m.spec.Recording != nil {
+		m.recorder = newRecorder(m, m.spec.Recording)
+	}
+	for i, r := range m.spec.Rules {
+		r.name = r.Name
+		if r.name == "" {
+			r.name = strconv.Itoa(i)
+		}
+		r.counter = ruleMatches.WithLabelValues(m.filterSpec.Pipeline(), m.filterSpec.Name(), r.name)
+		r.initMatch()
+		if r.Template {
+			r.template, _ = newRuleTemplate(r)
+		}
+
+		if r.BodyFile != "" {
+			m.watchBodyFile(r)
+		} else if r.BodyFromEtcd != "" {
+			m.wg.Add(1)
+			go m.watchBodyFromEtcd(r)
+		}
+
+		for _, resp := range r.Responses {
+			resp.delay, _ = time.ParseDuration(resp.Delay)
+		}
+
 		if r.Delay == "" {
 			continue
 		}
@@ -107,48 +265,104 @@ func (m *Mock) reload() {
 	}
 }
 
+// pick picks a response by the weights, it returns -1 if the rule has
+// no responses.
+func (r *Rule) pick() int {
+	if len(r.Responses) == 0 {
+		return -1
+	}
+
+	total := 0
+	for _, resp := range r.Responses {
+		total += resp.Weight
+	}
+	n := rand.Intn(total)
+	for i, resp := range r.Responses {
+		if n < resp.Weight {
+			return i
+		}
+		n -= resp.Weight
+	}
+	return len(r.Responses) - 1
+}
+
 // Handle mocks HTTPContext.
 func (m *Mock) Handle(ctx context.HTTPContext) (result string) {
 	result = m.handle(ctx)
+	if result == "" && m.recorder != nil {
+		return m.recorder.handle(ctx)
+	}
 	return ctx.CallNextHandler(result)
 }
 
 func (m *Mock) handle(ctx context.HTTPContext) (result string) {
-	path := ctx.Request().Path()
 	w := ctx.Response()
 
 	mock := func(rule *Rule) {
-		w.SetStatusCode(rule.Code)
-		for key, value := range rule.Headers {
+		atomic.AddUint64(&rule.matches, 1)
+		rule.counter.Inc()
+		ctx.AddTag(stringtool.Cat("mock rule ", rule.name))
+
+		body, headers := rule.Body, rule.Headers
+		if rule.hasExternalBody() {
+			loaded := rule.loadedBody()
+			if loaded == nil {
+				ctx.AddTag(stringtool.Cat("mock body of rule ", rule.name, " is unavailable"))
+				w.SetStatusCode(http.StatusInternalServerError)
+				result = resultMocked
+				return
+			}
+			body = string(loaded)
+		} else if rule.template != nil {
+			var err error
+			body, headers, err = rule.template.render(newTemplateData(ctx))
+			if err != nil {
+				ctx.AddTag(stringtool.Cat("mock template failed: ", err.Error()))
+				w.SetStatusCode(http.StatusInternalServerError)
+				result = resultMocked
+				return
+			}
+		}
+
+		code, delay := rule.Code, rule.delay
+		var respHeaders map[string]string
+		if i := rule.pick(); i >= 0 {
+			resp := rule.Responses[i]
+			ctx.AddTag(fmt.Sprintf("mock response %d", i))
+			code, body, respHeaders = resp.Code, resp.Body, resp.Headers
+			if resp.delay > 0 {
+				delay = resp.delay
+			}
+		}
+
+		w.SetStatusCode(code)
+		for key, value := range headers {
+			w.Header().Set(key, value)
+		}
+		for key, value := range respHeaders {
 			w.Header().Set(key, value)
 		}
-		w.SetBody(strings.NewReader(rule.Body))
+		if m.spec.MatchedRuleHeader != "" {
+			w.Header().Set(m.spec.MatchedRuleHeader, rule.name)
+		}
+		w.SetBody(strings.NewReader(body))
 		result = resultMocked
 
-		if rule.delay <= 0 {
+		if delay <= 0 {
 			return
 		}
 
-		logger.Debugf("delay for %v ...", rule.delay)
+		logger.Debugf("delay for %v ...", delay)
 		select {
 		case <-ctx.Done():
 			logger.Debugf("request cancelled in the middle of delay mocking")
-		case <-time.After(rule.delay):
+		case <-time.After(delay):
 		}
 	}
 
+	mr := &matchRequest{req: ctx.Request()}
 	for _, rule := range m.spec.Rules {
-		if rule.Path == "" && rule.PathPrefix == "" {
-			mock(rule)
-			return
-		}
-
-		if rule.Path == path {
-			mock(rule)
-			return
-		}
-
-		if rule.PathPrefix != "" && strings.HasPrefix(path, rule.PathPrefix) {
+		if rule.match(mr) {
 			mock(rule)
 			return
 		}
@@ -159,8 +373,22 @@ func (m *Mock) handle(ctx context.HTTPContext) (result string) {
 
 // Status returns status.
 func (m *Mock) Status() interface{} {
-	return nil
+	s := &Status{Matches: map[string]uint64{}}
+	for _, r := range m.spec.Rules {
+		s.Matches[r.name] = atomic.LoadUint64(&r.matches)
+	}
+	if m.recorder != nil {
+		s.Recorded = atomic.LoadUint64(&m.recorder.recorded)
+		s.Replayed = atomic.LoadUint64(&m.recorder.replayed)
+	}
+	return s
 }
 
 // Close closes Mock.
-func (m *Mock) Close() {}
+func (m *Mock) Close() {
+	m.closeOnce.Do(func() { close(m.done) })
+	m.wg.Wait()
+	for _, r := range m.spec.Rules {
+		ruleMatches.DeleteLabelValues(m.filterSpec.Pipeline(), m.filterSpec.Name(), r.name)
+	}
+}
@@ -18,11 +18,19 @@
 package mock
 
 import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
 	"github.com/megaease/easegress/pkg/context/contexttest"
 	"github.com/megaease/easegress/pkg/logger"
@@ -41,6 +49,7 @@ func TestMock(t *testing.T) {
 	const yamlSpec = `
 kind: Mock
 name: mock
+matchedRuleHeader: X-Mock-Rule
 rules:
 - pathPrefix: /login/
   code: 202
@@ -48,6 +57,7 @@ rules:
   headers:
     X-Test: test1
 - path: /sales
+  name: sales
   code: 203
   body: 'mocked body'
   headers:
@@ -114,8 +124,16 @@ rules:
 		t.Error("header 'X-Test' should be 'test2'")
 	}
 
-	if m.Status() != nil {
-		t.Error("behavior changed, please update this case")
+	if resp.Header().Get("X-Mock-Rule") != "sales" {
+		t.Error("header 'X-Mock-Rule' should be 'sales'")
+	}
+
+	status := m.Status().(*Status)
+	if status.Matches["0"] != 1 || status.Matches["sales"] != 1 || status.Matches["2"] != 0 {
+		t.Errorf("unexpected matches %v", status.Matches)
+	}
+	if v := testutil.ToFloat64(ruleMatches.WithLabelValues("", "mock", "sales")); v != 1 {
+		t.Errorf("counter of rule sales should be 1, got %v", v)
 	}
 	m.Description()
 
@@ -132,3 +150,444 @@ rules:
 		t.Error("status code is not 204")
 	}
 }
+
+func TestSpecValidate(t *testing.T) {
+	spec := Spec{Rules: []*Rule{{Name: "1", Code: 200}, {Code: 200}}}
+	if spec.Validate() == nil {
+		t.Error("validate should fail")
+	}
+
+	spec.Rules[0].Name = "login"
+	if spec.Validate() != nil {
+		t.Error("validate should succeed")
+	}
+}
+
+func TestMockTemplate(t *testing.T) {
+	const yamlSpec = `
+kind: Mock
+name: mock
+rules:
+- code: 200
+  template: true
+  headers:
+    X-Request-Id: '{{index .Request.Header "X-Request-Id"}}'
+  body: '{{.Request.Method}} {{.Request.Path}} {{.Request.Query.page}} {{.Request.JSON.user.id}}'
+`
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, e := httppipeline.NewFilterSpec(rawSpec, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+
+	m := &Mock{}
+	m.Init(spec)
+
+	ctx := &contexttest.MockedHTTPContext{}
+	reqHeader := http.Header{}
+	reqHeader.Set("X-Request-Id", "abc")
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(reqHeader)
+	}
+	ctx.MockedRequest.MockedMethod = func() string {
+		return http.MethodPost
+	}
+	ctx.MockedRequest.MockedPath = func() string {
+		return "/users"
+	}
+	ctx.MockedRequest.MockedQuery = func() string {
+		return "page=2"
+	}
+	var reqBody io.Reader = bytes.NewReader([]byte(`{"user": {"id": 7}}`))
+	ctx.MockedRequest.MockedBody = func() io.Reader {
+		return reqBody
+	}
+	ctx.MockedRequest.MockedSetBody = func(body io.Reader) {
+		reqBody = body
+	}
+
+	resp := httptest.NewRecorder()
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) {
+		resp.WriteHeader(code)
+	}
+	ctx.MockedResponse.MockedSetBody = func(body io.Reader) {
+		data, _ := io.ReadAll(body)
+		resp.Write(data)
+	}
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(resp.Header())
+	}
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		return lastResult
+	}
+
+	m.Handle(ctx)
+	if resp.Body.String() != "POST /users 2 7" {
+		t.Errorf("unexpected body %q", resp.Body.String())
+	}
+	if resp.Header().Get("X-Request-Id") != "abc" {
+		t.Errorf("unexpected header %q", resp.Header().Get("X-Request-Id"))
+	}
+	if data, _ := io.ReadAll(reqBody); string(data) != `{"user": {"id": 7}}` {
+		t.Error("request body should be kept")
+	}
+
+	spec.FilterSpec().(*Spec).Rules[0].Body = "{{.Request"
+	if spec.FilterSpec().(*Spec).Validate() == nil {
+		t.Error("validate should fail")
+	}
+}
+
+func TestMockBodyFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "body.b64")
+	if err := os.WriteFile(file, []byte(base64.StdEncoding.EncodeToString([]byte("v1"))), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	yamlSpec := fmt.Sprintf(`
+kind: Mock
+name: mock
+rules:
+- code: 200
+  bodyFile: %s
+  bodyBase64: true
+`, file)
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, e := httppipeline.NewFilterSpec(rawSpec, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	m := &Mock{}
+	m.Init(spec)
+	defer m.Close()
+
+	body := func() string {
+		ctx := &contexttest.MockedHTTPContext{}
+		ctx.MockedRequest.MockedPath = func() string {
+			return "/"
+		}
+		resp := httptest.NewRecorder()
+		ctx.MockedResponse.MockedSetBody = func(body io.Reader) {
+			data, _ := io.ReadAll(body)
+			resp.Write(data)
+		}
+		ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader {
+			return httpheader.New(resp.Header())
+		}
+		m.handle(ctx)
+		return resp.Body.String()
+	}
+
+	if b := body(); b != "v1" {
+		t.Errorf("unexpected body %q", b)
+	}
+
+	if err := os.WriteFile(file, []byte(base64.StdEncoding.EncodeToString([]byte("v2"))), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && body() != "v2"; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if b := body(); b != "v2" {
+		t.Errorf("body should be reloaded, got %q", b)
+	}
+
+	spec.FilterSpec().(*Spec).Rules[0].Body = "inline"
+	if spec.FilterSpec().(*Spec).Validate() == nil {
+		t.Error("validate should fail")
+	}
+}
+
+func TestMockResponses(t *testing.T) {
+	const yamlSpec = `
+kind: Mock
+name: mock
+rules:
+- headers:
+    X-Test: rule
+  responses:
+  - weight: 9
+    code: 200
+    body: ok
+  - weight: 1
+    code: 503
+    headers:
+      X-Test: response
+`
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, e := httppipeline.NewFilterSpec(rawSpec, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	m := &Mock{}
+	m.Init(spec)
+	defer m.Close()
+
+	codes := map[int]int{}
+	for i := 0; i < 1000; i++ {
+		ctx := &contexttest.MockedHTTPContext{}
+		ctx.MockedRequest.MockedPath = func() string {
+			return "/"
+		}
+		resp := httptest.NewRecorder()
+		ctx.MockedResponse.MockedSetStatusCode = func(code int) {
+			resp.WriteHeader(code)
+		}
+		ctx.MockedResponse.MockedSetBody = func(body io.Reader) {
+			data, _ := io.ReadAll(body)
+			resp.Write(data)
+		}
+		ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader {
+			return httpheader.New(resp.Header())
+		}
+		m.handle(ctx)
+
+		codes[resp.Code]++
+		switch resp.Code {
+		case 200:
+			if resp.Body.String() != "ok" || resp.Header().Get("X-Test") != "rule" {
+				t.Fatalf("unexpected response %q %v", resp.Body.String(), resp.Header())
+			}
+		case 503:
+			if resp.Header().Get("X-Test") != "response" {
+				t.Fatalf("unexpected header %v", resp.Header())
+			}
+		default:
+			t.Fatalf("unexpected code %d", resp.Code)
+		}
+	}
+	if codes[200] < 800 || codes[503] < 50 {
+		t.Errorf("unexpected distribution %v", codes)
+	}
+
+	spec.FilterSpec().(*Spec).Rules[0].Code = 200
+	if spec.FilterSpec().(*Spec).Validate() == nil {
+		t.Error("validate should fail")
+	}
+}
+
+func TestMockMatch(t *testing.T) {
+	const yamlSpec = `
+kind: Mock
+name: mock
+rules:
+- name: vip
+  path: /orders
+  methods: [POST]
+  bodyJSON:
+    user.level:
+      exact: vip
+  code: 201
+- name: post
+  path: /orders
+  methods: [POST]
+  bodyRegexp: '"id":\s*\d+'
+  code: 202
+- name: page
+  path: /orders
+  queries:
+    page:
+      regex: ^[0-9]+$
+  code: 206
+- name: get
+  path: /orders
+  methods: [GET]
+  code: 200
+`
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, e := httppipeline.NewFilterSpec(rawSpec, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	m := &Mock{}
+	m.Init(spec)
+	defer m.Close()
+
+	mock := func(method, query, body string) string {
+		ctx := &contexttest.MockedHTTPContext{}
+		ctx.MockedRequest.MockedPath = func() string {
+			return "/orders"
+		}
+		ctx.MockedRequest.MockedMethod = func() string {
+			return method
+		}
+		ctx.MockedRequest.MockedQuery = func() string {
+			return query
+		}
+		var reqBody io.Reader = strings.NewReader(body)
+		ctx.MockedRequest.MockedBody = func() io.Reader {
+			return reqBody
+		}
+		ctx.MockedRequest.MockedSetBody = func(body io.Reader) {
+			reqBody = body
+		}
+		resp := httptest.NewRecorder()
+		ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader {
+			return httpheader.New(resp.Header())
+		}
+
+		name := ""
+		ctx.MockedAddTag = func(tag string) {
+			name = strings.TrimPrefix(tag, "mock rule ")
+		}
+		if m.handle(ctx) == "" {
+			return ""
+		}
+		if data, _ := io.ReadAll(reqBody); string(data) != body {
+			t.Errorf("request body should be kept, got %q", data)
+		}
+		return name
+	}
+
+	cases := []struct {
+		method, query, body, rule string
+	}{
+		{"POST", "", `{"id": 1, "user": {"level": "vip"}}`, "vip"},
+		{"POST", "", `{"id": 1, "user": {"level": "normal"}}`, "post"},
+		{"POST", "", `{"user": {}}`, ""},
+		{"GET", "page=2", "", "page"},
+		{"GET", "page=x", "", "get"},
+		{"DELETE", "", "", ""},
+	}
+	for _, c := range cases {
+		if rule := mock(c.method, c.query, c.body); rule != c.rule {
+			t.Errorf("%s %s %s: expected rule %q, got %q", c.method, c.query, c.body, c.rule, rule)
+		}
+	}
+
+	spec.FilterSpec().(*Spec).Rules[0].BodyJSON["user.level"].Exact = ""
+	if spec.FilterSpec().(*Spec).Validate() == nil {
+		t.Error("validate should fail")
+	}
+}
+
+func TestMockRecordReplay(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mock-record")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	newMock := func(mode string) *Mock {
+		yamlSpec := fmt.Sprintf(`
+kind: Mock
+name: mock
+rules:
+- path: /mocked
+  code: 200
+recording:
+  mode: %s
+  dir: %s
+  matchBody: true
+`, mode, dir)
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+		spec, e := httppipeline.NewFilterSpec(rawSpec, nil)
+		if e != nil {
+			t.Fatalf("unexpected error: %v", e)
+		}
+		m := &Mock{}
+		m.Init(spec)
+		return m
+	}
+
+	type response struct {
+		code   int
+		header http.Header
+		body   string
+		next   bool
+	}
+	newContext := func(body string, resp *response) *contexttest.MockedHTTPContext {
+		ctx := &contexttest.MockedHTTPContext{}
+		ctx.MockedRequest.MockedMethod = func() string {
+			return http.MethodPost
+		}
+		ctx.MockedRequest.MockedPath = func() string {
+			return "/users"
+		}
+		ctx.MockedRequest.MockedQuery = func() string {
+			return "id=1"
+		}
+		var reqBody io.Reader = strings.NewReader(body)
+		ctx.MockedRequest.MockedBody = func() io.Reader {
+			return reqBody
+		}
+		ctx.MockedRequest.MockedSetBody = func(body io.Reader) {
+			reqBody = body
+		}
+		ctx.MockedResponse.MockedStatusCode = func() int {
+			return resp.code
+		}
+		ctx.MockedResponse.MockedSetStatusCode = func(code int) {
+			resp.code = code
+		}
+		ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader {
+			return httpheader.New(resp.header)
+		}
+		ctx.MockedResponse.MockedSetBody = func(body io.Reader) {
+			data, _ := io.ReadAll(body)
+			resp.body = string(data)
+		}
+		ctx.MockedResponse.MockedOnFlushBody = func(fn func(body []byte, complete bool) []byte) {
+			fn([]byte(resp.body), true)
+		}
+		ctx.MockedCallNextHandler = func(lastResult string) string {
+			resp.next = lastResult == ""
+			return lastResult
+		}
+		return ctx
+	}
+
+	m := newMock("record")
+	resp := &response{code: http.StatusOK, header: http.Header{"X-Backend": {"a"}}}
+	ctx := newContext("alice", resp)
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		resp.code, resp.body = http.StatusCreated, `{"name":"alice"}`
+		return lastResult
+	}
+	m.Handle(ctx)
+	m.Close()
+
+	if s := m.Status().(*Status); s.Recorded != 1 {
+		t.Fatalf("1 response should be recorded, got %d", s.Recorded)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("1 record file should be created, got %d", len(files))
+	}
+
+	m = newMock("replay")
+	defer m.Close()
+
+	resp = &response{code: http.StatusOK, header: http.Header{}}
+	if result := m.Handle(newContext("alice", resp)); result != resultMocked {
+		t.Errorf("the request should be replayed, got %q", result)
+	}
+	if resp.code != http.StatusCreated || resp.body != `{"name":"alice"}` || resp.header.Get("X-Backend") != "a" {
+		t.Errorf("unexpected replayed response %+v", resp)
+	}
+
+	resp = &response{code: http.StatusOK, header: http.Header{}}
+	m.Handle(newContext("bob", resp))
+	if !resp.next || resp.body != "" {
+		t.Error("the request with a different body should not be replayed")
+	}
+
+	if s := m.Status().(*Status); s.Replayed != 1 {
+		t.Errorf("1 response should be replayed, got %d", s.Replayed)
+	}
+
+	spec := &Recording{Mode: "record"}
+	if spec.Validate() == nil {
+		t.Error("validate should fail")
+	}
+}
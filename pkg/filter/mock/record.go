@@ -0,0 +1,356 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mock
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	recordModeRecord = "record"
+	recordModeReplay = "replay"
+
+	defaultRecordMaxBodySize = 4 * 1024 * 1024
+	recordQueueSize          = 1024
+)
+
+// headers not recorded since they are decided by the transport.
+var unrecordedHeaders = []string{"Content-Length", "Transfer-Encoding", "Connection", "Date"}
+
+type (
+	// Recording is the spec to record the responses of the requests
+	// unmatched by the rules, or to replay the recorded responses.
+	Recording struct {
+		Mode string `yaml:"mode" jsonschema:"required,enum=record,enum=replay"`
+		// Dir and EtcdPrefix are where to persist the recorded responses,
+		// one and only one of them must be specified.
+		Dir        string `yaml:"dir,omitempty" jsonschema:"omitempty"`
+		EtcdPrefix string `yaml:"etcdPrefix,omitempty" jsonschema:"omitempty"`
+		// MatchBody takes the request body into account to identify the
+		// requests, besides the method, path and query.
+		MatchBody bool `yaml:"matchBody,omitempty" jsonschema:"omitempty"`
+		// MaxBodySize is the max size of the response body to record, the
+		// larger responses are not recorded.
+		MaxBodySize int64 `yaml:"maxBodySize,omitempty" jsonschema:"omitempty,minimum=1"`
+	}
+
+	// Record is a recorded request/response pair.
+	Record struct {
+		Key     string      `json:"key"`
+		Time    time.Time   `json:"time"`
+		Method  string      `json:"method"`
+		Path    string      `json:"path"`
+		Query   string      `json:"query,omitempty"`
+		Code    int         `json:"code"`
+		Headers http.Header `json:"headers,omitempty"`
+		Body    []byte      `json:"body,omitempty"`
+	}
+
+	recorder struct {
+		m    *Mock
+		spec *Recording
+
+		records  atomic.Value // map[string]*Record
+		queue    chan *Record
+		recorded uint64
+		replayed uint64
+	}
+)
+
+// Validate validates Recording.
+func (r Recording) Validate() error {
+	if (r.Dir == "") == (r.EtcdPrefix == "") {
+		return fmt.Errorf("one and only one of dir and etcdPrefix must be specified")
+	}
+	return nil
+}
+
+func newRecorder(m *Mock, spec *Recording) *recorder {
+	r := &recorder{m: m, spec: spec}
+	r.records.Store(map[string]*Record{})
+
+	switch {
+	case spec.Mode == recordModeRecord:
+		r.queue = make(chan *Record, recordQueueSize)
+		m.wg.Add(1)
+		go r.persist()
+	case spec.Dir != "":
+		r.loadDir()
+	default:
+		m.wg.Add(1)
+		go r.syncEtcd()
+	}
+
+	return r
+}
+
+// recordKey identifies a request by its method, path, query and
+// optionally the body.
+func (r *recorder) recordKey(mr *matchRequest) string {
+	req := mr.req
+	key := req.Method() + " " + req.Path()
+	if q := req.Query(); q != "" {
+		key += "?" + q
+	}
+	if r.spec.MatchBody {
+		sum := sha256.Sum256(mr.getBody())
+		key += " " + hex.EncodeToString(sum[:])
+	}
+	return key
+}
+
+// storeKey converts a record key to a file name or an etcd key suffix.
+func storeKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (r *recorder) handle(ctx context.HTTPContext) string {
+	mr := &matchRequest{req: ctx.Request()}
+	key := r.recordKey(mr)
+
+	if r.spec.Mode == recordModeReplay {
+		return r.replay(ctx, key)
+	}
+	return r.record(ctx, key)
+}
+
+func (r *recorder) replay(ctx context.HTTPContext, key string) string {
+	rec := r.records.Load().(map[string]*Record)[key]
+	if rec == nil {
+		return ctx.CallNextHandler("")
+	}
+
+	atomic.AddUint64(&r.replayed, 1)
+	ctx.AddTag("mock replayed")
+
+	w := ctx.Response()
+	w.SetStatusCode(rec.Code)
+	for key, values := range rec.Headers {
+		for _, v := range values {
+			w.Header().Add(key, v)
+		}
+	}
+	w.SetBody(strings.NewReader(string(rec.Body)))
+	return ctx.CallNextHandler(resultMocked)
+}
+
+func (r *recorder) record(ctx context.HTTPContext, key string) string {
+	req := ctx.Request()
+	rec := &Record{
+		Key:    key,
+		Method: req.Method(),
+		Path:   req.Path(),
+		Query:  req.Query(),
+	}
+
+	result := ctx.CallNextHandler("")
+
+	w := ctx.Response()
+	rec.Code = w.StatusCode()
+	rec.Headers = w.Header().Std().Clone()
+	for _, h := range unrecordedHeaders {
+		rec.Headers.Del(h)
+	}
+
+	maxBodySize := r.spec.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultRecordMaxBodySize
+	}
+
+	var body []byte
+	exceeded := false
+	w.OnFlushBody(func(data []byte, complete bool) []byte {
+		if !exceeded {
+			if int64(len(body)+len(data)) > maxBodySize {
+				exceeded = true
+				body = nil
+			} else {
+				body = append(body, data...)
+			}
+		}
+		if complete {
+			if exceeded {
+				logger.Warnf("mock: response of %s exceeds %d bytes, not recorded", key, maxBodySize)
+				return data
+			}
+			rec.Body, rec.Time = body, time.Now()
+			select {
+			case r.queue <- rec:
+			default:
+				logger.Warnf("mock: too many pending records, %s not recorded", key)
+			}
+		}
+		return data
+	})
+
+	return result
+}
+
+// persist saves the records in the background to not block the responses.
+func (r *recorder) persist() {
+	defer r.m.wg.Done()
+
+	for {
+		select {
+		case rec := <-r.queue:
+			if err := r.save(rec); err != nil {
+				logger.Errorf("mock: record %s failed: %v", rec.Key, err)
+				continue
+			}
+			atomic.AddUint64(&r.recorded, 1)
+		case <-r.m.done:
+			r.drain()
+			return
+		}
+	}
+}
+
+// drain saves the pending records on closing.
+func (r *recorder) drain() {
+	for {
+		select {
+		case rec := <-r.queue:
+			if err := r.save(rec); err != nil {
+				logger.Errorf("mock: record %s failed: %v", rec.Key, err)
+				continue
+			}
+			atomic.AddUint64(&r.recorded, 1)
+		default:
+			return
+		}
+	}
+}
+
+func (r *recorder) save(rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	name := storeKey(rec.Key)
+	if r.spec.Dir != "" {
+		if err := os.MkdirAll(r.spec.Dir, 0o755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(r.spec.Dir, name+".json"), data, 0o644)
+	}
+
+	super := r.m.filterSpec.Super()
+	if super == nil || super.Cluster() == nil {
+		return fmt.Errorf("cluster is unavailable")
+	}
+	return super.Cluster().Put(r.spec.EtcdPrefix+name, string(data))
+}
+
+func (r *recorder) storeRecords(values map[string][]byte) {
+	records := make(map[string]*Record, len(values))
+	for name, data := range values {
+		rec := &Record{}
+		if err := json.Unmarshal(data, rec); err != nil {
+			logger.Errorf("mock: load record %s failed: %v", name, err)
+			continue
+		}
+		records[rec.Key] = rec
+	}
+	r.records.Store(records)
+	logger.Infof("mock: %d records loaded", len(records))
+}
+
+func (r *recorder) loadDir() {
+	files, err := ioutil.ReadDir(r.spec.Dir)
+	if err != nil {
+		logger.Errorf("mock: load records from %s failed: %v", r.spec.Dir, err)
+		return
+	}
+
+	values := map[string][]byte{}
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+		name := filepath.Join(r.spec.Dir, f.Name())
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			logger.Errorf("mock: load record %s failed: %v", name, err)
+			continue
+		}
+		values[name] = data
+	}
+	r.storeRecords(values)
+}
+
+// syncEtcd keeps the records in sync with the etcd prefix.
+func (r *recorder) syncEtcd() {
+	defer r.m.wg.Done()
+
+	var (
+		ch     <-chan map[string]string
+		syncer *cluster.Syncer
+		err    error
+	)
+
+	for {
+		if super := r.m.filterSpec.Super(); super == nil || super.Cluster() == nil {
+			err = fmt.Errorf("cluster is unavailable")
+		} else if syncer, err = super.Cluster().Syncer(time.Minute); err == nil {
+			if ch, err = syncer.SyncPrefix(r.spec.EtcdPrefix); err == nil {
+				break
+			}
+			syncer.Close()
+		}
+		logger.Errorf("mock: sync records from etcd prefix %s failed: %v", r.spec.EtcdPrefix, err)
+
+		select {
+		case <-time.After(10 * time.Second):
+		case <-r.m.done:
+			return
+		}
+	}
+	defer syncer.Close()
+
+	for {
+		select {
+		case kvs, ok := <-ch:
+			if !ok {
+				return
+			}
+			values := make(map[string][]byte, len(kvs))
+			for k, v := range kvs {
+				values[k] = []byte(v)
+			}
+			r.storeRecords(values)
+		case <-r.m.done:
+			return
+		}
+	}
+}
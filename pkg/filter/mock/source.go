@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mock
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+// hasExternalBody returns whether the body of the rule is loaded from
+// a file or an etcd key.
+func (r *Rule) hasExternalBody() bool {
+	return r.BodyFile != "" || r.BodyFromEtcd != ""
+}
+
+// setBody decodes and stores the loaded body of the rule.
+func (r *Rule) setBody(body []byte) error {
+	if r.BodyBase64 {
+		decoded, err := base64.StdEncoding.DecodeString(string(body))
+		if err != nil {
+			return fmt.Errorf("decode base64 body failed: %v", err)
+		}
+		body = decoded
+	}
+	r.body.Store(body)
+	return nil
+}
+
+// loadedBody returns the loaded body, it's nil if the body is unavailable.
+func (r *Rule) loadedBody() []byte {
+	body, _ := r.body.Load().([]byte)
+	return body
+}
+
+func (m *Mock) loadBodyFile(r *Rule) {
+	body, err := ioutil.ReadFile(r.BodyFile)
+	if err == nil {
+		err = r.setBody(body)
+	}
+	if err != nil {
+		logger.Errorf("mock rule %s: load body file %s failed: %v", r.name, r.BodyFile, err)
+		return
+	}
+	logger.Infof("mock rule %s: body file %s loaded", r.name, r.BodyFile)
+}
+
+// watchBodyFile loads the body file and reloads it when it changes. The
+// directory is watched since editors usually replace the file rather than
+// write it.
+func (m *Mock) watchBodyFile(r *Rule) {
+	file := filepath.Clean(r.BodyFile)
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = watcher.Add(filepath.Dir(file)); err != nil {
+			watcher.Close()
+		}
+	}
+	// NOTE: Load after watching to not miss the changes in between.
+	m.loadBodyFile(r)
+	if err != nil {
+		logger.Errorf("mock rule %s: watch body file %s failed: %v", r.name, r.BodyFile, err)
+		return
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer watcher.Close()
+
+		for {
+			select {
+			case event := <-watcher.Events:
+				if filepath.Clean(event.Name) == file && event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+					m.loadBodyFile(r)
+				}
+			case err := <-watcher.Errors:
+				logger.Errorf("mock rule %s: watch body file %s failed: %v", r.name, r.BodyFile, err)
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+// watchBodyFromEtcd syncs the body with the etcd key, the last body is
+// kept if the key is deleted.
+func (m *Mock) watchBodyFromEtcd(r *Rule) {
+	defer m.wg.Done()
+
+	var (
+		ch     <-chan *string
+		syncer *cluster.Syncer
+		err    error
+	)
+
+	for {
+		if super := m.filterSpec.Super(); super == nil || super.Cluster() == nil {
+			err = fmt.Errorf("cluster is unavailable")
+		} else if syncer, err = super.Cluster().Syncer(time.Minute); err == nil {
+			if ch, err = syncer.Sync(r.BodyFromEtcd); err == nil {
+				break
+			}
+			syncer.Close()
+		}
+		logger.Errorf("mock rule %s: watch etcd key %s failed: %v", r.name, r.BodyFromEtcd, err)
+
+		select {
+		case <-time.After(10 * time.Second):
+		case <-m.done:
+			return
+		}
+	}
+	defer syncer.Close()
+
+	for {
+		select {
+		case value, ok := <-ch:
+			if !ok {
+				return
+			}
+			if value == nil {
+				logger.Warnf("mock rule %s: etcd key %s not found", r.name, r.BodyFromEtcd)
+				continue
+			}
+			if err := r.setBody([]byte(*value)); err != nil {
+				logger.Errorf("mock rule %s: load body from etcd key %s failed: %v", r.name, r.BodyFromEtcd, err)
+				continue
+			}
+			logger.Infof("mock rule %s: body loaded from etcd key %s", r.name, r.BodyFromEtcd)
+		case <-m.done:
+			return
+		}
+	}
+}
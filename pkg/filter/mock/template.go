@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"text/template"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+type (
+	// ruleTemplate is the compiled templates of the body and headers.
+	ruleTemplate struct {
+		body    *template.Template
+		headers map[string]*template.Template
+	}
+
+	// templateData is the data of templates, e.g. {{.Request.Path}},
+	// {{index .Request.Header "X-Request-Id"}} and {{.Request.JSON.user.id}}.
+	templateData struct {
+		Request *templateRequest
+	}
+
+	templateRequest struct {
+		Method string
+		Host   string
+		Path   string
+		// Header and Query hold the first values of the keys.
+		Header map[string]string
+		Query  map[string]string
+		Body   string
+		// JSON is the decoded body, it's nil if the body isn't JSON.
+		JSON interface{}
+	}
+)
+
+func newRuleTemplate(r *Rule) (*ruleTemplate, error) {
+	rt := &ruleTemplate{headers: map[string]*template.Template{}}
+
+	var err error
+	rt.body, err = template.New("body").Parse(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template of body: %v", err)
+	}
+
+	for key, value := range r.Headers {
+		rt.headers[key], err = template.New(key).Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template of header %s: %v", key, err)
+		}
+	}
+
+	return rt, nil
+}
+
+func newTemplateData(ctx context.HTTPContext) *templateData {
+	r := ctx.Request()
+
+	body, _ := ioutil.ReadAll(r.Body())
+	r.SetBody(bytes.NewReader(body))
+
+	tr := &templateRequest{
+		Method: r.Method(),
+		Host:   r.Host(),
+		Path:   r.Path(),
+		Header: map[string]string{},
+		Query:  map[string]string{},
+		Body:   string(body),
+	}
+	r.Header().VisitAll(func(key, value string) {
+		if _, exists := tr.Header[key]; !exists {
+			tr.Header[key] = value
+		}
+	})
+	query, _ := url.ParseQuery(r.Query())
+	for key, values := range query {
+		tr.Query[key] = values[0]
+	}
+	if json.Unmarshal(body, &tr.JSON) != nil {
+		tr.JSON = nil
+	}
+
+	return &templateData{Request: tr}
+}
+
+// render renders the body and headers by the data.
+func (rt *ruleTemplate) render(data *templateData) (string, map[string]string, error) {
+	buf := &bytes.Buffer{}
+	if err := rt.body.Execute(buf, data); err != nil {
+		return "", nil, err
+	}
+	body := buf.String()
+
+	headers := make(map[string]string, len(rt.headers))
+	for key, t := range rt.headers {
+		buf.Reset()
+		if err := t.Execute(buf, data); err != nil {
+			return "", nil, err
+		}
+		headers[key] = buf.String()
+	}
+
+	return body, headers, nil
+}
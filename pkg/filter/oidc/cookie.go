@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oidc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+type (
+	// sealer encrypts the cookies by AES-GCM, so they can't be read or
+	// forged by clients.
+	sealer struct {
+		aead cipher.AEAD
+	}
+
+	// loginState is kept in the state cookie between the redirection to
+	// the provider and the callback.
+	loginState struct {
+		State    string `json:"state"`
+		Nonce    string `json:"nonce"`
+		Verifier string `json:"verifier"`
+		ReturnTo string `json:"returnTo"`
+	}
+
+	// session is kept in the session cookie.
+	session struct {
+		User      string            `json:"user"`
+		Claims    map[string]string `json:"claims,omitempty"`
+		ExpiresAt int64             `json:"expiresAt"`
+	}
+)
+
+func newSealer(secret string) *sealer {
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(fmt.Errorf("BUG: create cipher failed: %v", err))
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(fmt.Errorf("BUG: create gcm failed: %v", err))
+	}
+	return &sealer{aead: aead}
+}
+
+// seal encrypts v, the name of the cookie is authenticated, so a cookie
+// can't be used as another one.
+func (s *sealer) seal(name string, v interface{}) (string, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("BUG: marshal %#v to json failed: %v", v, err)
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	buff := s.aead.Seal(nonce, nonce, plaintext, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(buff), nil
+}
+
+// open decrypts the value to v, it returns false if the value is invalid.
+func (s *sealer) open(name, value string, v interface{}) bool {
+	buff, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(buff) < s.aead.NonceSize() {
+		return false
+	}
+
+	nonce, ciphertext := buff[:s.aead.NonceSize()], buff[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(plaintext, v) == nil
+}
+
+// setCookie sets the cookie, it's deleted if maxAge is negative.
+func (o *OIDC) setCookie(w context.HTTPResponse, name, value string, maxAge time.Duration, sameSite http.SameSite) {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Domain:   o.spec.Cookie.Domain,
+		Path:     o.spec.Cookie.Path,
+		Secure:   o.spec.Cookie.Secure,
+		HttpOnly: true,
+		MaxAge:   int(maxAge / time.Second),
+		SameSite: sameSite,
+	}
+	if maxAge < 0 {
+		c.MaxAge = -1
+	}
+	w.SetCookie(c)
+}
+
+// stripCookies removes the cookies of the filter from the request, which
+// are meaningless to the backends.
+func (o *OIDC) stripCookies(r context.HTTPRequest) {
+	cookies := r.Cookies()
+	r.Header().Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != o.spec.Cookie.Name && c.Name != o.stateCookie {
+			r.AddCookie(c)
+		}
+	}
+}
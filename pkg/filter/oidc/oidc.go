@@ -0,0 +1,582 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package oidc provides the OIDC filter, which signs in users by OpenID
+// Connect providers with the authorization code flow.
+package oidc
+
+import (
+	stdcontext "context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of OIDC.
+	Kind = "OIDC"
+
+	resultUnauthenticated = "unauthenticated"
+	resultServed          = "served"
+	resultRejected        = "rejected"
+
+	defaultCookieName = "EG_OIDC"
+	minSecretLength   = 16
+
+	// loginTTL is how long users have to sign in at the provider.
+	loginTTL    = 10 * time.Minute
+	httpTimeout = 10 * time.Second
+)
+
+var results = []string{resultUnauthenticated, resultServed, resultRejected}
+
+func init() {
+	httppipeline.Register(&OIDC{})
+}
+
+type (
+	// OIDC is an OpenID Connect relying party, unauthenticated users are
+	// redirected to the provider, and the callback exchanges the code for
+	// the ID token, which is turned into a session cookie. Requests with
+	// valid sessions are passed to the backends with the claims in
+	// headers.
+	OIDC struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		provider       *provider
+		sealer         *sealer
+		callbackPath   string
+		stateCookie    string
+		sessionTimeout time.Duration
+		clockSkew      time.Duration
+
+		redirected uint64
+		loggedIn   uint64
+		rejected   uint64
+
+		mutex     sync.Mutex
+		lastError string
+
+		// now is replaced in tests.
+		now func() time.Time
+	}
+
+	// Spec describes the OIDC.
+	Spec struct {
+		// Issuer is the issuer of the provider, the discovery document is
+		// at Issuer + "/.well-known/openid-configuration".
+		Issuer       string `yaml:"issuer" jsonschema:"required,format=uri"`
+		ClientID     string `yaml:"clientID" jsonschema:"required"`
+		ClientSecret string `yaml:"clientSecret" jsonschema:"required"`
+		// RedirectURL is the callback registered at the provider, the
+		// filter serves its path.
+		RedirectURL string   `yaml:"redirectURL" jsonschema:"required,format=uri"`
+		Scopes      []string `yaml:"scopes" jsonschema:"omitempty,uniqueItems=true"`
+		// AuthParams are extra parameters of the authorization request,
+		// like prompt or audience.
+		AuthParams map[string]string `yaml:"authParams" jsonschema:"omitempty"`
+		// CookieSecret is the key to encrypt cookies, which must be the
+		// same in all filters of the client.
+		CookieSecret   string      `yaml:"cookieSecret" jsonschema:"required"`
+		Cookie         *CookieSpec `yaml:"cookie" jsonschema:"omitempty"`
+		SessionTimeout string      `yaml:"sessionTimeout" jsonschema:"omitempty,format=duration"`
+		ClockSkew      string      `yaml:"clockSkew" jsonschema:"omitempty,format=duration"`
+		// UserHeader carries the UserClaim of the ID token to backends.
+		UserHeader string `yaml:"userHeader" jsonschema:"omitempty"`
+		UserClaim  string `yaml:"userClaim" jsonschema:"omitempty"`
+		// ClaimHeaders maps claims to headers, values of array claims
+		// are separated by commas.
+		ClaimHeaders map[string]string `yaml:"claimHeaders" jsonschema:"omitempty"`
+		// LogoutPath destroys the session and redirects to the end
+		// session endpoint of the provider if it has.
+		LogoutPath            string `yaml:"logoutPath" jsonschema:"omitempty,pattern=^/"`
+		PostLogoutRedirectURL string `yaml:"postLogoutRedirectURL" jsonschema:"omitempty"`
+	}
+
+	// CookieSpec describes the session cookie.
+	CookieSpec struct {
+		Name     string `yaml:"name" jsonschema:"omitempty"`
+		Domain   string `yaml:"domain" jsonschema:"omitempty"`
+		Path     string `yaml:"path" jsonschema:"omitempty"`
+		Secure   bool   `yaml:"secure" jsonschema:"omitempty"`
+		SameSite string `yaml:"sameSite" jsonschema:"omitempty,enum=Lax,enum=Strict,enum=None"`
+	}
+
+	// Status is the status of OIDC.
+	Status struct {
+		Redirected uint64 `yaml:"redirected"`
+		LoggedIn   uint64 `yaml:"loggedIn"`
+		Rejected   uint64 `yaml:"rejected"`
+		LastError  string `yaml:"lastError,omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if len(spec.CookieSecret) < minSecretLength {
+		return fmt.Errorf("cookieSecret must be at least %d bytes", minSecretLength)
+	}
+	u, err := url.Parse(spec.RedirectURL)
+	if err != nil || u.Path == "" {
+		return fmt.Errorf("invalid redirectURL %s", spec.RedirectURL)
+	}
+	if spec.LogoutPath == u.Path {
+		return fmt.Errorf("logoutPath conflicts with the path of redirectURL")
+	}
+	hasOpenID := false
+	for _, s := range spec.Scopes {
+		hasOpenID = hasOpenID || s == "openid"
+	}
+	if len(spec.Scopes) > 0 && !hasOpenID {
+		return fmt.Errorf("scopes must include openid")
+	}
+	return nil
+}
+
+// Kind returns the kind of OIDC.
+func (o *OIDC) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of OIDC.
+func (o *OIDC) DefaultSpec() interface{} {
+	return &Spec{
+		Scopes:         []string{"openid", "profile", "email"},
+		SessionTimeout: "8h",
+		ClockSkew:      "60s",
+		UserHeader:     "X-OIDC-User",
+		UserClaim:      "sub",
+	}
+}
+
+// Description returns the description of OIDC.
+func (o *OIDC) Description() string {
+	return "OIDC signs in users by OpenID Connect providers."
+}
+
+// Results returns the results of OIDC.
+func (o *OIDC) Results() []string {
+	return results
+}
+
+// Init initializes OIDC.
+func (o *OIDC) Init(filterSpec *httppipeline.FilterSpec) {
+	o.filterSpec, o.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	o.provider = newProvider(o.spec.Issuer, &http.Client{Timeout: httpTimeout})
+	o.reload()
+}
+
+// Inherit inherits previous generation of OIDC.
+func (o *OIDC) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+
+	o.filterSpec, o.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	// NOTE: The cached discovery document and keys are kept if the
+	// provider is not changed.
+	if prev := previousGeneration.(*OIDC); prev.spec.Issuer == o.spec.Issuer {
+		o.provider = prev.provider
+	} else {
+		o.provider = newProvider(o.spec.Issuer, &http.Client{Timeout: httpTimeout})
+	}
+	o.reload()
+}
+
+func (o *OIDC) reload() {
+	if o.spec.Cookie == nil {
+		o.spec.Cookie = &CookieSpec{}
+	}
+	if o.spec.Cookie.Name == "" {
+		o.spec.Cookie.Name = defaultCookieName
+	}
+	if o.spec.Cookie.Path == "" {
+		o.spec.Cookie.Path = "/"
+	}
+	o.stateCookie = o.spec.Cookie.Name + "_STATE"
+
+	u, _ := url.Parse(o.spec.RedirectURL)
+	o.callbackPath = u.Path
+	o.sealer = newSealer(o.spec.CookieSecret)
+	o.sessionTimeout, _ = time.ParseDuration(o.spec.SessionTimeout)
+	o.clockSkew, _ = time.ParseDuration(o.spec.ClockSkew)
+	o.now = time.Now
+}
+
+func (o *OIDC) sameSite() http.SameSite {
+	switch o.spec.Cookie.SameSite {
+	case "Strict":
+		return http.SameSiteStrictMode
+	case "None":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// Handle handles HTTPContext.
+func (o *OIDC) Handle(ctx context.HTTPContext) string {
+	result := o.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (o *OIDC) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+
+	// NOTE: Clients must not claim identities themselves.
+	r.Header().Del(o.spec.UserHeader)
+	for _, header := range o.spec.ClaimHeaders {
+		r.Header().Del(header)
+	}
+
+	switch r.Path() {
+	case o.callbackPath:
+		return o.callback(ctx)
+	case o.spec.LogoutPath:
+		if o.spec.LogoutPath != "" {
+			return o.logout(ctx)
+		}
+	}
+
+	sess := o.currentSession(r)
+	if sess == nil {
+		return o.authenticate(ctx)
+	}
+
+	if o.spec.UserHeader != "" {
+		r.Header().Set(o.spec.UserHeader, sess.User)
+	}
+	for claim, header := range o.spec.ClaimHeaders {
+		if v, ok := sess.Claims[claim]; ok {
+			r.Header().Set(header, v)
+		}
+	}
+	o.stripCookies(r)
+	return ""
+}
+
+func (o *OIDC) currentSession(r context.HTTPRequest) *session {
+	c, err := r.Cookie(o.spec.Cookie.Name)
+	if err != nil {
+		return nil
+	}
+	sess := &session{}
+	if !o.sealer.open(o.spec.Cookie.Name, c.Value, sess) {
+		return nil
+	}
+	if sess.ExpiresAt <= o.now().Unix() {
+		return nil
+	}
+	return sess
+}
+
+func randomString(n int) string {
+	buff := make([]byte, n)
+	rand.Read(buff)
+	return base64.RawURLEncoding.EncodeToString(buff)
+}
+
+// isLocalPath reports whether s is a path of the gateway, which defends
+// against open redirects.
+func isLocalPath(s string) bool {
+	return strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//") && !strings.HasPrefix(s, "/\\")
+}
+
+// authenticate redirects browsers to the provider, other requests are
+// rejected with 401, as they can't follow the login pages.
+func (o *OIDC) authenticate(ctx context.HTTPContext) string {
+	r, w := ctx.Request(), ctx.Response()
+
+	isBrowser := (r.Method() == http.MethodGet || r.Method() == http.MethodHead) &&
+		r.Header().Get("X-Requested-With") != "XMLHttpRequest"
+	if !isBrowser {
+		w.SetStatusCode(http.StatusUnauthorized)
+		ctx.AddTag("oidc: unauthenticated")
+		return resultUnauthenticated
+	}
+
+	d, err := o.provider.endpoints()
+	if err != nil {
+		o.setLastError(err)
+		w.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.AddTag(fmt.Sprintf("oidc: %v", err))
+		return resultUnauthenticated
+	}
+
+	returnTo := r.Path()
+	if q := r.Query(); q != "" {
+		returnTo += "?" + q
+	}
+	ls := &loginState{
+		State:    randomString(24),
+		Nonce:    randomString(24),
+		Verifier: randomString(32),
+		ReturnTo: returnTo,
+	}
+	value, err := o.sealer.seal(o.stateCookie, ls)
+	if err != nil {
+		o.setLastError(err)
+		w.SetStatusCode(http.StatusInternalServerError)
+		return resultUnauthenticated
+	}
+	// NOTE: The state cookie must be sent with the top-level redirection
+	// from the provider, which is blocked by the strict mode.
+	sameSite := http.SameSiteLaxMode
+	if o.sameSite() == http.SameSiteNoneMode {
+		sameSite = http.SameSiteNoneMode
+	}
+	o.setCookie(w, o.stateCookie, value, loginTTL, sameSite)
+
+	challenge := sha256.Sum256([]byte(ls.Verifier))
+	query := url.Values{}
+	for k, v := range o.spec.AuthParams {
+		query.Set(k, v)
+	}
+	query.Set("response_type", "code")
+	query.Set("client_id", o.spec.ClientID)
+	query.Set("redirect_uri", o.spec.RedirectURL)
+	query.Set("scope", strings.Join(o.spec.Scopes, " "))
+	query.Set("state", ls.State)
+	query.Set("nonce", ls.Nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	location := d.AuthorizationEndpoint
+	if strings.Contains(location, "?") {
+		location += "&" + query.Encode()
+	} else {
+		location += "?" + query.Encode()
+	}
+
+	atomic.AddUint64(&o.redirected, 1)
+	w.Header().Set("Location", location)
+	w.SetStatusCode(http.StatusFound)
+	return resultUnauthenticated
+}
+
+// callback handles the redirection from the provider, it exchanges the
+// code for the ID token and creates the session.
+func (o *OIDC) callback(ctx context.HTTPContext) string {
+	r, w := ctx.Request(), ctx.Response()
+
+	reject := func(code int, err error) string {
+		atomic.AddUint64(&o.rejected, 1)
+		o.setLastError(err)
+		ctx.AddTag(fmt.Sprintf("oidc: %v", err))
+		w.SetStatusCode(code)
+		return resultRejected
+	}
+
+	query, err := url.ParseQuery(r.Query())
+	if err != nil {
+		return reject(http.StatusBadRequest, fmt.Errorf("invalid query: %v", err))
+	}
+	if e := query.Get("error"); e != "" {
+		return reject(http.StatusForbidden, fmt.Errorf("provider error %s: %s", e, query.Get("error_description")))
+	}
+
+	ls := &loginState{}
+	c, err := r.Cookie(o.stateCookie)
+	if err != nil || !o.sealer.open(o.stateCookie, c.Value, ls) {
+		return reject(http.StatusForbidden, fmt.Errorf("login state not found"))
+	}
+	if query.Get("state") != ls.State {
+		return reject(http.StatusForbidden, fmt.Errorf("state mismatches"))
+	}
+	// NOTE: The state is used only once.
+	o.setCookie(w, o.stateCookie, "", -1, http.SameSiteLaxMode)
+
+	token, err := o.exchange(ctx, query.Get("code"), ls.Verifier)
+	if err != nil {
+		return reject(http.StatusBadGateway, err)
+	}
+
+	claims, err := o.provider.verifyIDToken(token, o.spec.ClientID, ls.Nonce, o.clockSkew, o.now())
+	if err != nil {
+		return reject(http.StatusForbidden, fmt.Errorf("invalid ID token: %v", err))
+	}
+
+	sess := &session{
+		User:      claimString(claims[o.spec.UserClaim]),
+		Claims:    map[string]string{},
+		ExpiresAt: o.now().Add(o.sessionTimeout).Unix(),
+	}
+	if sess.User == "" {
+		return reject(http.StatusForbidden, fmt.Errorf("claim %s not found", o.spec.UserClaim))
+	}
+	for claim := range o.spec.ClaimHeaders {
+		if v, ok := claims[claim]; ok {
+			sess.Claims[claim] = claimString(v)
+		}
+	}
+
+	value, err := o.sealer.seal(o.spec.Cookie.Name, sess)
+	if err != nil {
+		return reject(http.StatusInternalServerError, err)
+	}
+	o.setCookie(w, o.spec.Cookie.Name, value, o.sessionTimeout, o.sameSite())
+
+	returnTo := ls.ReturnTo
+	if !isLocalPath(returnTo) {
+		returnTo = "/"
+	}
+
+	atomic.AddUint64(&o.loggedIn, 1)
+	w.Header().Set("Location", returnTo)
+	w.SetStatusCode(http.StatusFound)
+	return resultServed
+}
+
+// exchange exchanges the code for the ID token at the token endpoint.
+func (o *OIDC) exchange(ctx stdcontext.Context, code, verifier string) (string, error) {
+	if code == "" {
+		return "", fmt.Errorf("code is missing")
+	}
+
+	d, err := o.provider.endpoints()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", o.spec.RedirectURL)
+	form.Set("client_id", o.spec.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// NOTE: The credentials are form-urlencoded before the basic
+	// authentication, as required by RFC 6749.
+	req.SetBasicAuth(url.QueryEscape(o.spec.ClientID), url.QueryEscape(o.spec.ClientSecret))
+
+	resp, err := o.provider.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("exchange code failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return "", fmt.Errorf("read token response failed: %v", err)
+	}
+
+	tr := &tokenResponse{}
+	if err := json.Unmarshal(body, tr); err != nil {
+		return "", fmt.Errorf("parse token response failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("exchange code failed: status code %d, %s %s", resp.StatusCode, tr.Error, tr.ErrorDescription)
+	}
+	if tr.IDToken == "" {
+		return "", fmt.Errorf("ID token is missing in token response")
+	}
+	return tr.IDToken, nil
+}
+
+// claimString converts the claim to a string for headers.
+func claimString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			values = append(values, claimString(e))
+		}
+		return strings.Join(values, ",")
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// logout destroys the session and redirects to the end session endpoint
+// of the provider.
+func (o *OIDC) logout(ctx context.HTTPContext) string {
+	w := ctx.Response()
+	o.setCookie(w, o.spec.Cookie.Name, "", -1, o.sameSite())
+
+	location := o.spec.PostLogoutRedirectURL
+	if d, err := o.provider.endpoints(); err == nil && d.EndSessionEndpoint != "" {
+		query := url.Values{}
+		query.Set("client_id", o.spec.ClientID)
+		if o.spec.PostLogoutRedirectURL != "" {
+			query.Set("post_logout_redirect_uri", o.spec.PostLogoutRedirectURL)
+		}
+		location = d.EndSessionEndpoint
+		if strings.Contains(location, "?") {
+			location += "&" + query.Encode()
+		} else {
+			location += "?" + query.Encode()
+		}
+	}
+	if location == "" {
+		location = "/"
+	}
+
+	w.Header().Set("Location", location)
+	w.SetStatusCode(http.StatusFound)
+	return resultServed
+}
+
+func (o *OIDC) setLastError(err error) {
+	logger.Warnf("oidc %s: %v", o.filterSpec.Name(), err)
+
+	o.mutex.Lock()
+	o.lastError = err.Error()
+	o.mutex.Unlock()
+}
+
+// Status returns status.
+func (o *OIDC) Status() interface{} {
+	o.mutex.Lock()
+	lastError := o.lastError
+	o.mutex.Unlock()
+
+	return &Status{
+		Redirected: atomic.LoadUint64(&o.redirected),
+		LoggedIn:   atomic.LoadUint64(&o.loggedIn),
+		Rejected:   atomic.LoadUint64(&o.rejected),
+		LastError:  lastError,
+	}
+}
+
+// Close closes OIDC.
+func (o *OIDC) Close() {}
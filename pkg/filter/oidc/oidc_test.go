@@ -0,0 +1,278 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+// newProviderServer starts a provider which issues ID tokens for alice,
+// the nonce is taken from the code.
+func newProviderServer(t *testing.T) *httptest.Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+			"end_session_endpoint":   server.URL + "/logout",
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "gateway" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+
+		// code is "nonce:challenge"
+		var nonce, challenge string
+		fmt.Sscanf(r.PostFormValue("code"), "%s %s", &nonce, &challenge)
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":    server.URL,
+			"aud":    "gateway",
+			"sub":    "alice",
+			"nonce":  nonce,
+			"groups": []string{"dev", "ops"},
+			"iat":    time.Now().Unix(),
+			"exp":    time.Now().Add(time.Minute).Unix(),
+		})
+		token.Header["kid"] = "key1"
+		signed, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+
+	return server
+}
+
+func newOIDC(t *testing.T, issuer string) *OIDC {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(fmt.Sprintf(`
+kind: OIDC
+name: oidc
+issuer: %s
+clientID: gateway
+clientSecret: secret
+redirectURL: https://gateway.example.com/oidc/callback
+cookieSecret: 0123456789abcdef
+logoutPath: /logout
+claimHeaders:
+  groups: X-Groups
+`, issuer)), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	o := &OIDC{}
+	o.Init(spec)
+	return o
+}
+
+type response struct {
+	result  string
+	code    int
+	header  http.Header
+	cookies map[string]*http.Cookie
+}
+
+func handle(o *OIDC, req *http.Request) *response {
+	w := httptest.NewRecorder()
+	resp := &response{
+		result:  o.Handle(contexttest.NewMockedHTTPContext(req, w)),
+		code:    w.Code,
+		header:  w.Header(),
+		cookies: map[string]*http.Cookie{},
+	}
+	for _, c := range w.Result().Cookies() {
+		resp.cookies[c.Name] = c
+	}
+	return resp
+}
+
+func TestLogin(t *testing.T) {
+	server := newProviderServer(t)
+	defer server.Close()
+
+	o := newOIDC(t, server.URL)
+	defer o.Close()
+
+	// Unauthenticated API requests are rejected.
+	resp := handle(o, httptest.NewRequest(http.MethodPost, "/app", nil))
+	if resp.result != resultUnauthenticated || resp.code != http.StatusUnauthorized {
+		t.Fatalf("unexpected result %s, code %d", resp.result, resp.code)
+	}
+
+	// Browsers are redirected to the provider.
+	resp = handle(o, httptest.NewRequest(http.MethodGet, "/app?x=1", nil))
+	if resp.result != resultUnauthenticated || resp.code != http.StatusFound {
+		t.Fatalf("unexpected result %s, code %d", resp.result, resp.code)
+	}
+	location, _ := url.Parse(resp.header.Get("Location"))
+	query := location.Query()
+	if location.Path != "/authorize" || query.Get("client_id") != "gateway" ||
+		query.Get("redirect_uri") != "https://gateway.example.com/oidc/callback" ||
+		query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected location %s", location)
+	}
+	stateCookie := resp.cookies["EG_OIDC_STATE"]
+	if stateCookie == nil || !stateCookie.HttpOnly {
+		t.Fatalf("state cookie is not set")
+	}
+
+	// The state must match.
+	req := httptest.NewRequest(http.MethodGet, "/oidc/callback?code=x&state=forged", nil)
+	req.AddCookie(stateCookie)
+	if resp = handle(o, req); resp.result != resultRejected || resp.code != http.StatusForbidden {
+		t.Fatalf("unexpected result %s, code %d", resp.result, resp.code)
+	}
+
+	code := query.Get("nonce") + " " + query.Get("code_challenge")
+	req = httptest.NewRequest(http.MethodGet, "/oidc/callback?"+url.Values{
+		"code":  {code},
+		"state": {query.Get("state")},
+	}.Encode(), nil)
+	req.AddCookie(stateCookie)
+	resp = handle(o, req)
+	if resp.result != resultServed || resp.code != http.StatusFound {
+		t.Fatalf("unexpected result %s, code %d, status %+v", resp.result, resp.code, o.Status())
+	}
+	if resp.header.Get("Location") != "/app?x=1" {
+		t.Errorf("unexpected location %s", resp.header.Get("Location"))
+	}
+	if c := resp.cookies["EG_OIDC_STATE"]; c == nil || c.MaxAge >= 0 {
+		t.Errorf("state cookie is not deleted")
+	}
+	sessionCookie := resp.cookies["EG_OIDC"]
+	if sessionCookie == nil {
+		t.Fatalf("session cookie is not set")
+	}
+
+	// Requests with the session are passed with the claims.
+	req = httptest.NewRequest(http.MethodGet, "/app", nil)
+	req.Header.Set("X-OIDC-User", "mallory")
+	req.AddCookie(sessionCookie)
+	req.AddCookie(&http.Cookie{Name: "other", Value: "1"})
+	if resp = handle(o, req); resp.result != "" {
+		t.Fatalf("unexpected result %s", resp.result)
+	}
+	if req.Header.Get("X-OIDC-User") != "alice" || req.Header.Get("X-Groups") != "dev,ops" {
+		t.Errorf("unexpected headers %v", req.Header)
+	}
+	if _, err := req.Cookie("EG_OIDC"); err == nil {
+		t.Errorf("session cookie is not stripped")
+	}
+	if _, err := req.Cookie("other"); err != nil {
+		t.Errorf("other cookies are stripped")
+	}
+
+	// The session is expired.
+	o.now = func() time.Time { return time.Now().Add(9 * time.Hour) }
+	req = httptest.NewRequest(http.MethodPost, "/app", nil)
+	req.AddCookie(sessionCookie)
+	if resp = handle(o, req); resp.result != resultUnauthenticated {
+		t.Errorf("unexpected result %s", resp.result)
+	}
+	o.now = time.Now
+
+	// The state cookie can't be used as the session cookie.
+	req = httptest.NewRequest(http.MethodPost, "/app", nil)
+	req.AddCookie(&http.Cookie{Name: "EG_OIDC", Value: stateCookie.Value})
+	if resp = handle(o, req); resp.result != resultUnauthenticated {
+		t.Errorf("unexpected result %s", resp.result)
+	}
+
+	// Logout
+	req = httptest.NewRequest(http.MethodGet, "/logout", nil)
+	req.AddCookie(sessionCookie)
+	resp = handle(o, req)
+	if resp.result != resultServed || resp.cookies["EG_OIDC"].MaxAge >= 0 {
+		t.Errorf("unexpected result %s, cookies %v", resp.result, resp.cookies)
+	}
+	if location, _ := url.Parse(resp.header.Get("Location")); location.Path != "/logout" {
+		t.Errorf("unexpected location %s", location)
+	}
+}
+
+func TestIsLocalPath(t *testing.T) {
+	for s, expected := range map[string]bool{
+		"/app?x=1":             true,
+		"//evil.example.com":   false,
+		"/\\evil.example.com":  false,
+		"https://example.com/": false,
+		"":                     false,
+	} {
+		if isLocalPath(s) != expected {
+			t.Errorf("isLocalPath(%q) should be %v", s, expected)
+		}
+	}
+}
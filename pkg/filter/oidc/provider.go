@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	"github.com/megaease/easegress/pkg/util/jwks"
+)
+
+const (
+	// refreshInterval is the minimum interval to fetch the discovery
+	// document, so failures don't flood the provider.
+	refreshInterval = 10 * time.Second
+	maxResponseSize = 1024 * 1024
+)
+
+type (
+	// provider is the OpenID provider, the discovery document and the
+	// keys are fetched on demand and cached.
+	provider struct {
+		issuer string
+		client *http.Client
+
+		mutex       sync.Mutex
+		discovery   *discovery
+		keys        *jwks.KeySet
+		lastRefresh time.Time
+	}
+
+	discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+		EndSessionEndpoint    string `json:"end_session_endpoint"`
+	}
+
+	tokenResponse struct {
+		IDToken          string `json:"id_token"`
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+)
+
+func newProvider(issuer string, client *http.Client) *provider {
+	return &provider{issuer: strings.TrimSuffix(issuer, "/"), client: client}
+}
+
+func (p *provider) getJSON(url string, v interface{}) error {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status code %d", url, resp.StatusCode)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// refresh fetches the discovery document if it's not fetched yet, the
+// caller must hold the lock.
+func (p *provider) refresh() error {
+	if p.discovery != nil || time.Since(p.lastRefresh) < refreshInterval {
+		return nil
+	}
+	p.lastRefresh = time.Now()
+
+	d := &discovery{}
+	if err := p.getJSON(p.issuer+"/.well-known/openid-configuration", d); err != nil {
+		return fmt.Errorf("fetch discovery document failed: %v", err)
+	}
+	// NOTE: The issuer must be exactly the same as the configured one,
+	// as required by OpenID Connect Discovery.
+	if strings.TrimSuffix(d.Issuer, "/") != p.issuer {
+		return fmt.Errorf("issuer %s of discovery document mismatches", d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return fmt.Errorf("discovery document misses endpoints")
+	}
+	p.discovery = d
+	p.keys = jwks.New(d.JWKSURI, p.client, 0)
+	return nil
+}
+
+// endpoints returns the discovery document.
+func (p *provider) endpoints() (*discovery, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.refresh(); err != nil {
+		return nil, err
+	}
+	if p.discovery == nil {
+		return nil, fmt.Errorf("discovery document is unavailable")
+	}
+	return p.discovery, nil
+}
+
+// keySet returns the keys of the provider, which are fetched again for
+// unknown kids, as the provider may have rotated its keys.
+func (p *provider) keySet() (*jwks.KeySet, error) {
+	if _, err := p.endpoints(); err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.keys, nil
+}
+
+// verifyIDToken verifies the signature and the claims of the ID token,
+// and returns the claims.
+func (p *provider) verifyIDToken(token, clientID, nonce string, skew time.Duration, now time.Time) (jwt.MapClaims, error) {
+	keys, err := p.keySet()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	if _, err := parser.ParseWithClaims(token, claims, keys.Keyfunc); err != nil {
+		return nil, err
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != p.issuer {
+		return nil, fmt.Errorf("unexpected issuer %s", iss)
+	}
+	if !claims.VerifyAudience(clientID, true) {
+		return nil, fmt.Errorf("client is not in the audience")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("nonce mismatches")
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.Add(-skew).Unix() >= int64(exp) {
+		return nil, fmt.Errorf("token is expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && now.Add(skew).Unix() < int64(iat) {
+		return nil, fmt.Errorf("token is issued in the future")
+	}
+	return claims, nil
+}
@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package piimasker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// BuiltinCreditCard detects the numbers of payment cards, which pass
+	// the Luhn check.
+	BuiltinCreditCard = "creditCard"
+	// BuiltinEmail detects email addresses.
+	BuiltinEmail = "email"
+	// BuiltinUSSSN detects US social security numbers, like 123-45-6789.
+	BuiltinUSSSN = "usSSN"
+	// BuiltinCNResidentID detects the 18 characters Chinese resident
+	// identity numbers, which pass the checksum.
+	BuiltinCNResidentID = "cnResidentID"
+
+	defaultReplacement = "[REDACTED]"
+)
+
+type builtin struct {
+	pattern  string
+	validate func(string) bool
+}
+
+var builtins = map[string]*builtin{
+	BuiltinCreditCard: {
+		pattern:  `\b\d(?:[ -]?\d){12,18}\b`,
+		validate: luhnValid,
+	},
+	BuiltinEmail: {
+		pattern: `[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`,
+	},
+	BuiltinUSSSN: {
+		pattern: `\b\d{3}-\d{2}-\d{4}\b`,
+	},
+	BuiltinCNResidentID: {
+		pattern:  `\b\d{17}[\dXx]\b`,
+		validate: cnResidentIDValid,
+	},
+}
+
+type (
+	// Detector detects a kind of PII, by a builtin detector, a regular
+	// expression, or the names of JSON fields.
+	Detector struct {
+		Name    string `yaml:"name" jsonschema:"required"`
+		Builtin string `yaml:"builtin" jsonschema:"omitempty,enum=,enum=creditCard,enum=email,enum=usSSN,enum=cnResidentID"`
+		Regexp  string `yaml:"regexp" jsonschema:"omitempty,format=regexp"`
+		// JSONFields are the names of the JSON fields whose values are
+		// PII regardless of the content, they're case-insensitive.
+		JSONFields []string `yaml:"jsonFields" jsonschema:"omitempty,uniqueItems=true"`
+		// Replacement replaces the PII, default is [REDACTED].
+		Replacement string `yaml:"replacement" jsonschema:"omitempty"`
+		// KeepLast keeps the last characters of the PII and masks the
+		// others with '*' instead of replacing it, e.g. the last 4 digits
+		// of card numbers.
+		KeepLast int `yaml:"keepLast" jsonschema:"omitempty,minimum=0"`
+	}
+
+	detector struct {
+		*Detector
+		pattern  *regexp.Regexp
+		validate func(string) bool
+		fields   map[string]bool
+	}
+)
+
+// Validate validates Detector.
+func (d Detector) Validate() error {
+	if d.Builtin != "" && d.Regexp != "" {
+		return fmt.Errorf("detector %s: builtin and regexp are exclusive", d.Name)
+	}
+	if d.Builtin == "" && d.Regexp == "" && len(d.JSONFields) == 0 {
+		return fmt.Errorf("detector %s: none of builtin, regexp and jsonFields is specified", d.Name)
+	}
+	if d.KeepLast < 0 {
+		return fmt.Errorf("detector %s: keepLast must not be negative", d.Name)
+	}
+	return nil
+}
+
+func newDetector(spec *Detector) *detector {
+	d := &detector{Detector: spec, fields: map[string]bool{}}
+	if b := builtins[spec.Builtin]; b != nil {
+		d.pattern, d.validate = regexp.MustCompile(b.pattern), b.validate
+	} else if spec.Regexp != "" {
+		d.pattern = regexp.MustCompile(spec.Regexp)
+	}
+	for _, f := range spec.JSONFields {
+		d.fields[strings.ToLower(f)] = true
+	}
+	return d
+}
+
+// mask masks a piece of PII.
+func (d *detector) mask(pii string) string {
+	if d.KeepLast == 0 {
+		if d.Replacement == "" {
+			return defaultReplacement
+		}
+		return d.Replacement
+	}
+
+	runes := []rune(pii)
+	for i := 0; i < len(runes)-d.KeepLast; i++ {
+		runes[i] = '*'
+	}
+	return string(runes)
+}
+
+// replace masks the PII in the text, and returns the number of them.
+func (d *detector) replace(text string) (string, int) {
+	if d.pattern == nil {
+		return text, 0
+	}
+
+	count := 0
+	text = d.pattern.ReplaceAllStringFunc(text, func(s string) string {
+		if d.validate != nil && !d.validate(s) {
+			return s
+		}
+		count++
+		return d.mask(s)
+	})
+	return text, count
+}
+
+// luhnValid reports whether the digits in s pass the Luhn check.
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		digit := int(c - '0')
+		if n%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		n++
+	}
+	return n >= 13 && n <= 19 && sum%10 == 0
+}
+
+// cnResidentIDValid reports whether s passes the checksum of GB 11643.
+func cnResidentIDValid(s string) bool {
+	weights := []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	const checks = "10X98765432"
+
+	sum := 0
+	for i, w := range weights {
+		sum += int(s[i]-'0') * w
+	}
+	return checks[sum%11] == strings.ToUpper(s[17:])[0]
+}
@@ -0,0 +1,414 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package piimasker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+	"github.com/megaease/easegress/pkg/util/urlrule"
+)
+
+const (
+	// Kind is the kind of PIIMasker.
+	Kind = "PIIMasker"
+
+	// ActionMask masks the PII in the responses.
+	ActionMask = "mask"
+	// ActionBlock replaces the responses containing PII with empty ones.
+	ActionBlock = "block"
+	// ActionReport only counts the PII in the responses.
+	ActionReport = "report"
+
+	defaultMaxBodySize = 4 * 1024 * 1024
+	defaultBlockStatus = http.StatusForbidden
+)
+
+var (
+	results = []string{}
+
+	defaultContentTypes = []string{"application/json", "text/*"}
+)
+
+// detections counts the PII detected by every detector, so that the leaks
+// could be monitored per route.
+var detections = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "easegress",
+	Subsystem: "piimasker",
+	Name:      "detections_total",
+	Help:      "The number of PII detected in the responses by PIIMasker filters.",
+}, []string{"pipeline", "filter", "detector"})
+
+func init() {
+	httppipeline.Register(&PIIMasker{})
+	prometheus.MustRegister(detections)
+}
+
+type (
+	// PIIMasker scans the response bodies for PII like card numbers and
+	// email addresses, and masks them or blocks the responses according to
+	// the policy of the route.
+	PIIMasker struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		detectors map[string]*detector
+		policies  []*policy
+
+		scanned uint64
+		masked  uint64
+		blocked uint64
+		skipped uint64
+		// counts is map[string]*uint64, the detections by detector name.
+		counts sync.Map
+	}
+
+	// Spec describes the PIIMasker.
+	Spec struct {
+		Detectors []*Detector `yaml:"detectors" jsonschema:"required,minItems=1"`
+		Policies  []*Policy   `yaml:"policies" jsonschema:"required,minItems=1"`
+		// ContentTypes are the media types of the responses to scan.
+		ContentTypes []string `yaml:"contentTypes" jsonschema:"omitempty,uniqueItems=true"`
+		// MaxBodySize is the max size of the responses to scan, the
+		// responses are buffered to be scanned, the larger ones pass
+		// through without being scanned.
+		MaxBodySize int64 `yaml:"maxBodySize" jsonschema:"omitempty,minimum=1"`
+		// BlockStatus is the status code of the blocked responses.
+		BlockStatus int `yaml:"blockStatus" jsonschema:"omitempty,format=httpcode"`
+	}
+
+	// Policy is the policy of the requests matching the methods and paths,
+	// it applies to all requests if neither is specified.
+	Policy struct {
+		Name    string                 `yaml:"name" jsonschema:"required"`
+		Methods []string               `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		Paths   []*urlrule.StringMatch `yaml:"paths" jsonschema:"omitempty"`
+		// Detectors are the names of the detectors to use, empty means all.
+		Detectors []string `yaml:"detectors" jsonschema:"omitempty,uniqueItems=true"`
+		Action    string   `yaml:"action" jsonschema:"omitempty,enum=,enum=mask,enum=block,enum=report"`
+	}
+
+	// Status is the status of PIIMasker.
+	Status struct {
+		Scanned uint64 `yaml:"scanned"`
+		Masked  uint64 `yaml:"masked"`
+		Blocked uint64 `yaml:"blocked"`
+		// Skipped is the number of responses too large or encoded to scan.
+		Skipped    uint64            `yaml:"skipped"`
+		Detections map[string]uint64 `yaml:"detections"`
+	}
+
+	policy struct {
+		*Policy
+		detectors []*detector
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	names := map[string]bool{}
+	for _, d := range spec.Detectors {
+		if names[d.Name] {
+			return fmt.Errorf("detector %s is duplicated", d.Name)
+		}
+		names[d.Name] = true
+	}
+
+	for _, p := range spec.Policies {
+		for _, name := range p.Detectors {
+			if !names[name] {
+				return fmt.Errorf("policy %s: detector %s not found", p.Name, name)
+			}
+		}
+	}
+
+	for _, ct := range spec.ContentTypes {
+		if len(strings.Split(ct, "/")) != 2 {
+			return fmt.Errorf("invalid content type %s", ct)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of PIIMasker.
+func (pm *PIIMasker) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of PIIMasker.
+func (pm *PIIMasker) DefaultSpec() interface{} {
+	return &Spec{
+		ContentTypes: defaultContentTypes,
+		MaxBodySize:  defaultMaxBodySize,
+		BlockStatus:  defaultBlockStatus,
+	}
+}
+
+// Description returns the description of PIIMasker.
+func (pm *PIIMasker) Description() string {
+	return "PIIMasker masks or blocks the PII in the response bodies."
+}
+
+// Results returns the results of PIIMasker.
+func (pm *PIIMasker) Results() []string {
+	return results
+}
+
+// Init initializes PIIMasker.
+func (pm *PIIMasker) Init(filterSpec *httppipeline.FilterSpec) {
+	pm.filterSpec, pm.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	pm.reload()
+}
+
+// Inherit inherits previous generation of PIIMasker.
+func (pm *PIIMasker) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	pm.Init(filterSpec)
+}
+
+func (pm *PIIMasker) reload() {
+	pm.detectors = map[string]*detector{}
+	all := []*detector{}
+	for _, spec := range pm.spec.Detectors {
+		d := newDetector(spec)
+		pm.detectors[spec.Name] = d
+		all = append(all, d)
+		var count uint64
+		pm.counts.Store(spec.Name, &count)
+	}
+
+	pm.policies = nil
+	for _, spec := range pm.spec.Policies {
+		for _, p := range spec.Paths {
+			p.Init()
+		}
+
+		p := &policy{Policy: spec, detectors: all}
+		if len(spec.Detectors) > 0 {
+			p.detectors = nil
+			for _, name := range spec.Detectors {
+				p.detectors = append(p.detectors, pm.detectors[name])
+			}
+		}
+		pm.policies = append(pm.policies, p)
+	}
+}
+
+// Handle scans the response of HTTPContext for PII.
+func (pm *PIIMasker) Handle(ctx context.HTTPContext) string {
+	p := pm.matchPolicy(ctx)
+	result := ctx.CallNextHandler("")
+	if p != nil {
+		pm.handleResponse(ctx, p)
+	}
+	return result
+}
+
+// matchPolicy returns the first policy matching the request, or nil if
+// none matches.
+func (pm *PIIMasker) matchPolicy(ctx context.HTTPContext) *policy {
+	r := ctx.Request()
+	for _, p := range pm.policies {
+		if len(p.Methods) > 0 && !stringtool.StrInSlice(r.Method(), p.Methods) {
+			continue
+		}
+		if len(p.Paths) > 0 && !matchPath(p.Paths, r.Path()) {
+			continue
+		}
+		return p
+	}
+	return nil
+}
+
+func matchPath(paths []*urlrule.StringMatch, path string) bool {
+	for _, p := range paths {
+		if p.Match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pm *PIIMasker) handleResponse(ctx context.HTTPContext, p *policy) {
+	w := ctx.Response()
+	body := w.Body()
+	if body == nil {
+		return
+	}
+
+	mediaType := parseMediaType(w.Header().Get("Content-Type"))
+	if !matchMediaType(pm.spec.ContentTypes, mediaType) {
+		return
+	}
+
+	// NOTE: The encoded bodies like gzip ones can't be scanned, so
+	// PIIMasker should be placed before the filters compressing them.
+	encoding := w.Header().Get("Content-Encoding")
+	if encoding != "" && encoding != "identity" {
+		atomic.AddUint64(&pm.skipped, 1)
+		return
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, pm.spec.MaxBodySize+1))
+	if int64(len(data)) > pm.spec.MaxBodySize {
+		atomic.AddUint64(&pm.skipped, 1)
+		w.SetBody(&readCloser{
+			Reader: io.MultiReader(bytes.NewReader(data), body),
+			body:   body,
+		})
+		return
+	}
+	closeBody(body)
+	if err != nil {
+		logger.Errorf("%s: read response body failed: %v", pm.filterSpec.Name(), err)
+		ctx.AddTag(stringtool.Cat("piiMasker: read response body failed: ", err.Error()))
+		replaceResponse(ctx, http.StatusBadGateway)
+		return
+	}
+
+	atomic.AddUint64(&pm.scanned, 1)
+	s := newScanner(p.detectors)
+	var masked []byte
+	if strings.HasSuffix(mediaType, "json") {
+		if masked, err = s.maskJSON(data); err != nil {
+			// Fall back to the patterns for the invalid JSON documents.
+			masked, s = nil, newScanner(p.detectors)
+		}
+	}
+	if masked == nil {
+		masked = []byte(s.maskText(string(data)))
+	}
+	pm.count(s)
+
+	if s.total() == 0 || p.Action == ActionReport {
+		w.SetBody(bytes.NewReader(data))
+		return
+	}
+
+	ctx.AddTag(stringtool.Cat("piiMasker: ", strconv.Itoa(s.total()), " PII detected by policy ", p.Name))
+	if p.Action == ActionBlock {
+		atomic.AddUint64(&pm.blocked, 1)
+		replaceResponse(ctx, pm.spec.BlockStatus)
+		return
+	}
+
+	atomic.AddUint64(&pm.masked, 1)
+	w.Header().Set("Content-Length", strconv.Itoa(len(masked)))
+	w.SetBody(bytes.NewReader(masked))
+}
+
+func (pm *PIIMasker) count(s *scanner) {
+	for name, n := range s.counts {
+		if n == 0 {
+			continue
+		}
+		if count, ok := pm.counts.Load(name); ok {
+			atomic.AddUint64(count.(*uint64), uint64(n))
+		}
+		detections.WithLabelValues(pm.filterSpec.Pipeline(), pm.filterSpec.Name(), name).Add(float64(n))
+	}
+}
+
+// readCloser reads the buffered head and the rest of the body, and closes
+// the body.
+type readCloser struct {
+	io.Reader
+	body io.Reader
+}
+
+func (rc *readCloser) Close() error {
+	closeBody(rc.body)
+	return nil
+}
+
+func closeBody(body io.Reader) {
+	if closer, ok := body.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// replaceResponse replaces the response with an empty one of the status code.
+func replaceResponse(ctx context.HTTPContext, code int) {
+	w := ctx.Response()
+	w.SetBody(nil)
+	w.Header().Reset(http.Header{})
+	w.SetStatusCode(code)
+}
+
+func parseMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// matchMediaType reports whether the media type matches any of the media
+// ranges, like application/json or text/*.
+func matchMediaType(mediaRanges []string, mediaType string) bool {
+	if mediaType == "" {
+		return false
+	}
+
+	for _, mr := range mediaRanges {
+		mr = strings.ToLower(mr)
+		if mr == "*/*" || mr == mediaType {
+			return true
+		}
+		if strings.HasSuffix(mr, "/*") && strings.HasPrefix(mediaType, mr[:len(mr)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns status.
+func (pm *PIIMasker) Status() interface{} {
+	s := &Status{
+		Scanned:    atomic.LoadUint64(&pm.scanned),
+		Masked:     atomic.LoadUint64(&pm.masked),
+		Blocked:    atomic.LoadUint64(&pm.blocked),
+		Skipped:    atomic.LoadUint64(&pm.skipped),
+		Detections: map[string]uint64{},
+	}
+	pm.counts.Range(func(key, value interface{}) bool {
+		s.Detections[key.(string)] = atomic.LoadUint64(value.(*uint64))
+		return true
+	})
+	return s
+}
+
+// Close closes PIIMasker.
+func (pm *PIIMasker) Close() {
+	for name := range pm.detectors {
+		detections.DeleteLabelValues(pm.filterSpec.Pipeline(), pm.filterSpec.Name(), name)
+	}
+}
@@ -0,0 +1,289 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package piimasker
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+const testSpec = `
+kind: PIIMasker
+name: pii
+detectors:
+- name: card
+  builtin: creditCard
+  keepLast: 4
+- name: email
+  builtin: email
+- name: ssn
+  builtin: usSSN
+- name: cnid
+  builtin: cnResidentID
+- name: secret
+  jsonFields: [password, Token]
+  replacement: "***"
+policies:
+- name: admin
+  paths:
+  - prefix: /admin
+  action: report
+- name: orders
+  methods: [GET]
+  paths:
+  - prefix: /orders
+  detectors: [card]
+  action: block
+- name: default
+`
+
+func newPIIMasker(t *testing.T, yamlSpec string) *PIIMasker {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pm := &PIIMasker{}
+	pm.Init(spec)
+	t.Cleanup(pm.Close)
+	return pm
+}
+
+// handle handles a request whose next handler responds with the body and
+// content type, and returns the response.
+func handle(pm *PIIMasker, method, path, respBody, respContentType string) (context.HTTPContext, string) {
+	stdr, _ := http.NewRequest(method, "http://example.com"+path, nil)
+	ctx := context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		ctx.Response().Header().Set("Content-Type", respContentType)
+		ctx.Response().SetBody(ioutil.NopCloser(strings.NewReader(respBody)))
+		return lastResult
+	})
+
+	pm.Handle(ctx)
+	body := ""
+	if ctx.Response().Body() != nil {
+		data, _ := ioutil.ReadAll(ctx.Response().Body())
+		body = string(data)
+	}
+	return ctx, body
+}
+
+func TestValidate(t *testing.T) {
+	for _, spec := range []string{
+		`
+kind: PIIMasker
+name: pii
+detectors:
+- name: none
+policies:
+- name: default
+`, `
+kind: PIIMasker
+name: pii
+detectors:
+- name: card
+  builtin: creditCard
+  regexp: "\\d+"
+policies:
+- name: default
+`, `
+kind: PIIMasker
+name: pii
+detectors:
+- name: card
+  builtin: creditCard
+policies:
+- name: default
+  detectors: [email]
+`, `
+kind: PIIMasker
+name: pii
+detectors:
+- name: card
+  builtin: creditCard
+- name: card
+  builtin: email
+policies:
+- name: default
+`,
+	} {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(spec), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("spec should be invalid:%s", spec)
+		}
+	}
+}
+
+func TestBuiltinDetectors(t *testing.T) {
+	for _, c := range []struct {
+		builtin string
+		text    string
+		want    string
+	}{
+		{BuiltinCreditCard, "card 4111 1111 1111 1111.", "card [REDACTED]."},
+		{BuiltinCreditCard, "card 4111-1111-1111-1112.", "card 4111-1111-1111-1112."},
+		{BuiltinCreditCard, "order 20211015", "order 20211015"},
+		{BuiltinEmail, "mail to john.doe@example.com", "mail to [REDACTED]"},
+		{BuiltinUSSSN, "ssn: 078-05-1120", "ssn: [REDACTED]"},
+		{BuiltinCNResidentID, "id 11010519491231002X", "id [REDACTED]"},
+		{BuiltinCNResidentID, "id 110105194912310021", "id 110105194912310021"},
+	} {
+		d := newDetector(&Detector{Name: "test", Builtin: c.builtin})
+		if got, _ := d.replace(c.text); got != c.want {
+			t.Errorf("%s: %q: want %q, got %q", c.builtin, c.text, c.want, got)
+		}
+	}
+
+	d := newDetector(&Detector{Name: "card", Builtin: BuiltinCreditCard, KeepLast: 4})
+	if got, n := d.replace("4111111111111111"); got != "************1111" || n != 1 {
+		t.Errorf("want masked card, got %q, %d", got, n)
+	}
+}
+
+func TestMaskJSON(t *testing.T) {
+	pm := newPIIMasker(t, testSpec)
+
+	resp := `{"user":{"email":"a@example.com","password":"p@ss","tokens":["x"],"Token":{"id":123}},` +
+		`"card":4111111111111111,"ssn":"078-05-1120","note":"<b>&</b>","ok":true,"none":null,"n":1.50}`
+	ctx, body := handle(pm, http.MethodGet, "/users", resp, "application/json; charset=utf-8")
+
+	want := `{"user":{"email":"[REDACTED]","password":"***","tokens":["x"],"Token":{"id":"***"}},` +
+		`"card":"************1111","ssn":"[REDACTED]","note":"<b>&</b>","ok":true,"none":null,"n":1.50}`
+	if body != want {
+		t.Fatalf("want %s, got %s", want, body)
+	}
+	if cl := ctx.Response().Header().Get("Content-Length"); cl != strconv.Itoa(len(want)) {
+		t.Errorf("want content length %d, got %s", len(want), cl)
+	}
+
+	status := pm.Status().(*Status)
+	if status.Scanned != 1 || status.Masked != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+	for name, n := range map[string]uint64{"card": 1, "email": 1, "ssn": 1, "secret": 2, "cnid": 0} {
+		if status.Detections[name] != n {
+			t.Errorf("want %d detections of %s, got %d", n, name, status.Detections[name])
+		}
+	}
+}
+
+func TestMaskText(t *testing.T) {
+	pm := newPIIMasker(t, testSpec)
+
+	// Invalid JSON documents fall back to the patterns.
+	_, body := handle(pm, http.MethodGet, "/users", `{"email":"a@example.com",`, "application/json")
+	if want := `{"email":"[REDACTED]",`; body != want {
+		t.Errorf("want %s, got %s", want, body)
+	}
+
+	_, body = handle(pm, http.MethodGet, "/users", "Dear a@example.com, password: p@ss", "text/plain")
+	if want := "Dear [REDACTED], password: p@ss"; body != want {
+		t.Errorf("want %s, got %s", want, body)
+	}
+
+	// Unlisted media types aren't scanned.
+	_, body = handle(pm, http.MethodGet, "/users", "a@example.com", "application/octet-stream")
+	if body != "a@example.com" {
+		t.Errorf("want unscanned body, got %s", body)
+	}
+	if status := pm.Status().(*Status); status.Scanned != 2 || status.Masked != 2 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	pm := newPIIMasker(t, testSpec)
+
+	resp := `{"card":"4111 1111 1111 1111","email":"a@example.com"}`
+	ctx, body := handle(pm, http.MethodGet, "/admin/users", resp, "application/json")
+	if body != resp || ctx.Response().StatusCode() != http.StatusOK {
+		t.Errorf("reported response should be unchanged, got %d %s", ctx.Response().StatusCode(), body)
+	}
+
+	ctx, body = handle(pm, http.MethodGet, "/orders/1", resp, "application/json")
+	if body != "" || ctx.Response().StatusCode() != http.StatusForbidden {
+		t.Errorf("want blocked response, got %d %s", ctx.Response().StatusCode(), body)
+	}
+
+	// The orders policy only detects cards.
+	resp = `{"email":"a@example.com"}`
+	ctx, body = handle(pm, http.MethodGet, "/orders/1", resp, "application/json")
+	if body != resp || ctx.Response().StatusCode() != http.StatusOK {
+		t.Errorf("want unchanged response, got %d %s", ctx.Response().StatusCode(), body)
+	}
+
+	// The methods of the orders policy don't match, so the default one
+	// applies.
+	_, body = handle(pm, http.MethodPost, "/orders", resp, "application/json")
+	if want := `{"email":"[REDACTED]"}`; body != want {
+		t.Errorf("want %s, got %s", want, body)
+	}
+
+	status := pm.Status().(*Status)
+	if status.Scanned != 4 || status.Masked != 1 || status.Blocked != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if status.Detections["card"] != 2 || status.Detections["email"] != 2 {
+		t.Errorf("unexpected detections %+v", status.Detections)
+	}
+}
+
+func TestSkipped(t *testing.T) {
+	pm := newPIIMasker(t, testSpec+"maxBodySize: 16\n")
+
+	resp := `{"email":"a@example.com"}`
+	_, body := handle(pm, http.MethodGet, "/users", resp, "application/json")
+	if body != resp {
+		t.Errorf("want the whole body passing through, got %s", body)
+	}
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://example.com/users", nil)
+	ctx := context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		ctx.Response().Header().Set("Content-Type", "application/json")
+		ctx.Response().Header().Set("Content-Encoding", "gzip")
+		ctx.Response().SetBody(strings.NewReader("gzipped"))
+		return lastResult
+	})
+	pm.Handle(ctx)
+
+	if status := pm.Status().(*Status); status.Scanned != 0 || status.Skipped != 2 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package piimasker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// scanner masks the PII in a response body, and counts the detections of
+// every detector.
+type scanner struct {
+	detectors []*detector
+	counts    map[string]int
+}
+
+func newScanner(detectors []*detector) *scanner {
+	return &scanner{detectors: detectors, counts: map[string]int{}}
+}
+
+// total returns the number of all detections.
+func (s *scanner) total() int {
+	total := 0
+	for _, n := range s.counts {
+		total += n
+	}
+	return total
+}
+
+// maskText masks the PII in the text by the patterns of the detectors.
+func (s *scanner) maskText(text string) string {
+	for _, d := range s.detectors {
+		var n int
+		text, n = d.replace(text)
+		s.counts[d.Name] += n
+	}
+	return text
+}
+
+// fieldDetector returns the detector of the JSON field, or nil if the
+// field isn't sensitive.
+func (s *scanner) fieldDetector(field string) *detector {
+	field = strings.ToLower(field)
+	for _, d := range s.detectors {
+		if d.fields[field] {
+			return d
+		}
+	}
+	return nil
+}
+
+// maskJSON masks the PII in the JSON document, the values of the sensitive
+// fields are masked as a whole, and the other strings and numbers are
+// masked by the patterns. The order of the fields is kept.
+func (s *scanner) maskJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)))
+	if err := s.maskJSONValue(dec, buf, nil); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid JSON: extra data after the value")
+	}
+	return buf.Bytes(), nil
+}
+
+// maskJSONValue masks the next value of the decoder, field is the detector
+// of the sensitive field enclosing the value, if any.
+func (s *scanner) maskJSONValue(dec *json.Decoder, buf *bytes.Buffer, field *detector) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch v := token.(type) {
+	case json.Delim:
+		return s.maskJSONContainer(dec, buf, v, field)
+	case string:
+		s.writeScalar(buf, v, true, field)
+	case json.Number:
+		s.writeScalar(buf, string(v), false, field)
+	case bool:
+		fmt.Fprint(buf, v)
+	case nil:
+		buf.WriteString("null")
+	}
+	return nil
+}
+
+func (s *scanner) maskJSONContainer(dec *json.Decoder, buf *bytes.Buffer, delim json.Delim, field *detector) error {
+	isObject := delim == '{'
+	buf.WriteByte(byte(delim))
+
+	for i := 0; dec.More(); i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		d := field
+		if isObject {
+			token, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key := token.(string)
+			writeJSONString(buf, key)
+			buf.WriteByte(':')
+			if d == nil {
+				d = s.fieldDetector(key)
+			}
+		}
+
+		if err := s.maskJSONValue(dec, buf, d); err != nil {
+			return err
+		}
+	}
+
+	// Consume the closing delimiter.
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	if isObject {
+		buf.WriteByte('}')
+	} else {
+		buf.WriteByte(']')
+	}
+	return nil
+}
+
+// writeScalar writes a string or number, the masked numbers become strings.
+func (s *scanner) writeScalar(buf *bytes.Buffer, value string, isString bool, field *detector) {
+	var masked string
+	if field != nil {
+		masked = field.mask(value)
+		s.counts[field.Name]++
+	} else {
+		masked = s.maskText(value)
+	}
+
+	if isString || masked != value {
+		writeJSONString(buf, masked)
+	} else {
+		buf.WriteString(value)
+	}
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	// Remove the newline appended by Encode.
+	buf.Truncate(buf.Len() - 1)
+}
@@ -0,0 +1,253 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package planenforcer
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/apiproduct"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/consumer"
+	"github.com/megaease/easegress/pkg/util/ratelimiter"
+)
+
+const (
+	// Kind is the kind of PlanEnforcer.
+	Kind = "PlanEnforcer"
+
+	resultNoPlan        = "noPlan"
+	resultNotInPlan     = "notInPlan"
+	resultRateLimited   = "rateLimited"
+	resultQuotaExceeded = "quotaExceeded"
+
+	maxLimiters       = 10000
+	quotaSyncInterval = 5 * time.Second
+)
+
+var results = []string{resultNoPlan, resultNotInPlan, resultRateLimited, resultQuotaExceeded}
+
+func init() {
+	httppipeline.Register(&PlanEnforcer{})
+}
+
+type (
+	// PlanEnforcer enforces the plans consumers subscribe to.
+	PlanEnforcer struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		resolve  consumer.Resolver
+		limiters *lru.Cache
+		quota    *quotaCounter
+
+		done chan struct{}
+	}
+
+	// Spec describes the PlanEnforcer.
+	Spec struct {
+		Consumer *consumer.Spec `yaml:"consumer" jsonschema:"required"`
+		// PlanHeader is the request header of the plan name.
+		PlanHeader string `yaml:"planHeader" jsonschema:"omitempty"`
+		// FeaturesHeader is the request header of the features
+		// of the plan, separated by comma.
+		FeaturesHeader string `yaml:"featuresHeader" jsonschema:"omitempty"`
+	}
+
+	// limiter is the rate limiter of a consumer, it's recreated
+	// if the rate limit of the plan is changed.
+	limiter struct {
+		rateLimit *apiproduct.RateLimit
+		limiter   *ratelimiter.RateLimiter
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	return spec.Consumer.Validate()
+}
+
+// Kind returns the kind of PlanEnforcer.
+func (pe *PlanEnforcer) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of PlanEnforcer.
+func (pe *PlanEnforcer) DefaultSpec() interface{} {
+	return &Spec{
+		PlanHeader:     "X-Plan",
+		FeaturesHeader: "X-Plan-Features",
+	}
+}
+
+// Description returns the description of PlanEnforcer.
+func (pe *PlanEnforcer) Description() string {
+	return "PlanEnforcer enforces the products, rate limits and quotas of the plans consumers subscribe to."
+}
+
+// Results returns the results of PlanEnforcer.
+func (pe *PlanEnforcer) Results() []string {
+	return results
+}
+
+// Init initializes PlanEnforcer.
+func (pe *PlanEnforcer) Init(filterSpec *httppipeline.FilterSpec) {
+	pe.filterSpec, pe.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	pe.limiters, _ = lru.New(maxLimiters)
+	pe.quota = newQuotaCounter()
+	pe.reload()
+}
+
+// Inherit inherits previous generation of PlanEnforcer.
+func (pe *PlanEnforcer) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+
+	pe.filterSpec, pe.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	prev := previousGeneration.(*PlanEnforcer)
+	pe.limiters, pe.quota = prev.limiters, prev.quota
+	pe.reload()
+}
+
+func (pe *PlanEnforcer) reload() {
+	pe.resolve = consumer.NewResolver(pe.spec.Consumer)
+	pe.done = make(chan struct{})
+	go pe.run()
+}
+
+func (pe *PlanEnforcer) run() {
+	for {
+		select {
+		case <-pe.done:
+			pe.syncQuota()
+			return
+		case <-time.After(quotaSyncInterval):
+			pe.syncQuota()
+		}
+	}
+}
+
+func (pe *PlanEnforcer) syncQuota() {
+	store, err := apiproduct.QuotaStore()
+	if err != nil {
+		// NOTE: Quotas are counted by every member alone.
+		return
+	}
+	pe.quota.sync(store)
+}
+
+// Handle enforces the plan of the consumer.
+func (pe *PlanEnforcer) Handle(ctx context.HTTPContext) string {
+	result := pe.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (pe *PlanEnforcer) handle(ctx context.HTTPContext) string {
+	r, w := ctx.Request(), ctx.Response()
+
+	// NOTE: Clients must not grant plans or features to themselves.
+	if pe.spec.PlanHeader != "" {
+		r.Header().Del(pe.spec.PlanHeader)
+	}
+	if pe.spec.FeaturesHeader != "" {
+		r.Header().Del(pe.spec.FeaturesHeader)
+	}
+
+	product := apiproduct.MatchProduct(r.Method(), r.Path())
+	if product == "" {
+		return ""
+	}
+
+	c := pe.resolve(ctx)
+	planName, plan := apiproduct.SubscribedPlan(c)
+	if plan == nil {
+		if c == "" {
+			w.SetStatusCode(http.StatusUnauthorized)
+		} else {
+			w.SetStatusCode(http.StatusForbidden)
+		}
+		ctx.AddTag("plan enforcer: no plan")
+		return resultNoPlan
+	}
+
+	if !plan.HasProduct(product) {
+		w.SetStatusCode(http.StatusForbidden)
+		ctx.AddTag("plan enforcer: " + product + " not in plan " + planName)
+		return resultNotInPlan
+	}
+
+	if plan.RateLimit != nil && !pe.acquire(planName, c, plan.RateLimit) {
+		w.SetStatusCode(http.StatusTooManyRequests)
+		return resultRateLimited
+	}
+
+	if plan.Quota != nil {
+		start, end := plan.Quota.Window(time.Now())
+		key := apiproduct.QuotaKey(planName, c, start)
+		if !pe.quota.take(key, plan.Quota.Requests, end) {
+			w.SetStatusCode(http.StatusTooManyRequests)
+			return resultQuotaExceeded
+		}
+	}
+
+	if pe.spec.PlanHeader != "" {
+		r.Header().Set(pe.spec.PlanHeader, planName)
+	}
+	if pe.spec.FeaturesHeader != "" && len(plan.Features) > 0 {
+		r.Header().Set(pe.spec.FeaturesHeader, strings.Join(plan.Features, ","))
+	}
+
+	return ""
+}
+
+func (pe *PlanEnforcer) acquire(plan, c string, rateLimit *apiproduct.RateLimit) bool {
+	key := plan + "/" + c
+	v, ok := pe.limiters.Get(key)
+	l, _ := v.(*limiter)
+	if !ok || l.rateLimit != rateLimit {
+		period, err := time.ParseDuration(rateLimit.LimitRefreshPeriod)
+		if err != nil {
+			logger.Errorf("BUG: parse duration %s failed: %v", rateLimit.LimitRefreshPeriod, err)
+			return true
+		}
+		l = &limiter{
+			rateLimit: rateLimit,
+			limiter: ratelimiter.New(&ratelimiter.Policy{
+				LimitRefreshPeriod: period,
+				LimitForPeriod:     rateLimit.LimitForPeriod,
+			}),
+		}
+		pe.limiters.Add(key, l)
+	}
+
+	permitted, _ := l.limiter.AcquirePermission()
+	return permitted
+}
+
+// Status returns status.
+func (pe *PlanEnforcer) Status() interface{} {
+	return nil
+}
+
+// Close closes PlanEnforcer.
+func (pe *PlanEnforcer) Close() {
+	close(pe.done)
+}
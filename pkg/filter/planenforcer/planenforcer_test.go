@@ -0,0 +1,159 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package planenforcer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/apiproduct"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newObject(t *testing.T, o supervisor.Controller, yamlConfig string) supervisor.Controller {
+	spec, err := supervisor.NewSpec(yamlConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	o.Init(spec)
+	return o
+}
+
+func newPlanEnforcer(t *testing.T) *PlanEnforcer {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(`
+kind: PlanEnforcer
+name: plan-enforcer
+consumer:
+  source: Header
+  name: X-Consumer
+`), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pe := &PlanEnforcer{}
+	pe.Init(spec)
+	return pe
+}
+
+func handle(pe *PlanEnforcer, consumer, path string, header http.Header) (string, int) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if header != nil {
+		req.Header = header
+	}
+	if consumer != "" {
+		req.Header.Set("X-Consumer", consumer)
+	}
+	w := httptest.NewRecorder()
+
+	result := pe.Handle(contexttest.NewMockedHTTPContext(req, w))
+	return result, w.Code
+}
+
+func TestPlanEnforcer(t *testing.T) {
+	for _, o := range []struct {
+		object supervisor.Controller
+		yaml   string
+	}{
+		{&apiproduct.APIProduct{}, "kind: APIProduct\nname: orders\npathPrefixes: [/orders]"},
+		{&apiproduct.APIProduct{}, "kind: APIProduct\nname: reports\npathPrefixes: [/reports]"},
+		{&apiproduct.Plan{}, `
+kind: Plan
+name: free
+products: [orders]
+rateLimit:
+  limitRefreshPeriod: 1h
+  limitForPeriod: 2
+consumers: [alice]
+`},
+		{&apiproduct.Plan{}, `
+kind: Plan
+name: pro
+products: [orders, reports]
+quota:
+  requests: 3
+  period: 24h
+features: [export, bulk]
+consumers: [bob]
+`},
+	} {
+		defer newObject(t, o.object, o.yaml).Close()
+	}
+
+	pe := newPlanEnforcer(t)
+	defer func() { pe.Close() }()
+
+	// Routes not in any product are not enforced.
+	if result, _ := handle(pe, "", "/health", nil); result != "" {
+		t.Errorf("unexpected result %s", result)
+	}
+
+	if result, code := handle(pe, "", "/orders", nil); result != resultNoPlan || code != http.StatusUnauthorized {
+		t.Errorf("anonymous: unexpected result %s, code %d", result, code)
+	}
+	if result, code := handle(pe, "carol", "/orders", nil); result != resultNoPlan || code != http.StatusForbidden {
+		t.Errorf("carol: unexpected result %s, code %d", result, code)
+	}
+	if result, code := handle(pe, "alice", "/reports", nil); result != resultNotInPlan || code != http.StatusForbidden {
+		t.Errorf("alice: unexpected result %s, code %d", result, code)
+	}
+
+	header := http.Header{}
+	header.Set("X-Plan-Features", "everything")
+	handle(pe, "alice", "/orders", header)
+	if header.Get("X-Plan") != "free" || header.Get("X-Plan-Features") != "" {
+		t.Errorf("unexpected headers %v", header)
+	}
+	handle(pe, "alice", "/orders", nil)
+	if result, code := handle(pe, "alice", "/orders", nil); result != resultRateLimited || code != http.StatusTooManyRequests {
+		t.Errorf("alice should be rate limited, got result %s, code %d", result, code)
+	}
+
+	header = http.Header{}
+	handle(pe, "bob", "/reports", header)
+	if header.Get("X-Plan") != "pro" || header.Get("X-Plan-Features") != "export,bulk" {
+		t.Errorf("unexpected headers %v", header)
+	}
+	handle(pe, "bob", "/orders", nil)
+	handle(pe, "bob", "/orders", nil)
+	if result, code := handle(pe, "bob", "/orders", nil); result != resultQuotaExceeded || code != http.StatusTooManyRequests {
+		t.Errorf("bob should exceed the quota, got result %s, code %d", result, code)
+	}
+
+	// The counts are inherited by the next generation.
+	next := &PlanEnforcer{}
+	next.Inherit(pe.filterSpec, pe)
+	pe = next
+	if result, _ := handle(pe, "bob", "/orders", nil); result != resultQuotaExceeded {
+		t.Errorf("bob should still exceed the quota, got result %s", result)
+	}
+}
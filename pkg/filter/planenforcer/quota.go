@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package planenforcer
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/sharedstate"
+)
+
+type (
+	// quotaCounter counts requests locally and synchronizes the counts
+	// with the shared state periodically, so it's not accessed by
+	// every request, at the cost of the quota being slightly exceeded.
+	quotaCounter struct {
+		mutex  sync.Mutex
+		counts map[string]*quotaCount
+	}
+
+	quotaCount struct {
+		// synced is the count of all members at the last synchronization.
+		synced int64
+		// pending is the local count not yet synchronized.
+		pending  int64
+		expireAt time.Time
+	}
+)
+
+func newQuotaCounter() *quotaCounter {
+	return &quotaCounter{counts: make(map[string]*quotaCount)}
+}
+
+// take takes a request from the quota, it returns false if the quota
+// is used up.
+func (qc *quotaCounter) take(key string, limit int64, expireAt time.Time) bool {
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+
+	c := qc.counts[key]
+	if c == nil {
+		c = &quotaCount{expireAt: expireAt}
+		qc.counts[key] = c
+	}
+	if c.synced+c.pending >= limit {
+		return false
+	}
+	c.pending++
+	return true
+}
+
+// sync synchronizes the counts with the store.
+func (qc *quotaCounter) sync(store sharedstate.Store) {
+	type snapshot struct {
+		delta    int64
+		expireAt time.Time
+	}
+
+	now := time.Now()
+	snapshots := map[string]snapshot{}
+
+	qc.mutex.Lock()
+	for key, c := range qc.counts {
+		if !c.expireAt.After(now) {
+			delete(qc.counts, key)
+			continue
+		}
+		snapshots[key] = snapshot{delta: c.pending, expireAt: c.expireAt}
+		c.pending = 0
+	}
+	qc.mutex.Unlock()
+
+	for key, s := range snapshots {
+		var total int64
+		var err error
+		if s.delta > 0 {
+			total, err = store.Incr(key, s.delta, s.expireAt.Sub(now))
+		} else {
+			// NOTE: Pick up the counts of other members.
+			var value string
+			var ok bool
+			value, ok, err = store.Get(key)
+			if err == nil {
+				if !ok {
+					continue
+				}
+				total, err = strconv.ParseInt(value, 10, 64)
+			}
+		}
+
+		qc.mutex.Lock()
+		if c := qc.counts[key]; c != nil {
+			if err != nil {
+				c.pending += s.delta
+			} else {
+				c.synced = total
+			}
+		}
+		qc.mutex.Unlock()
+
+		if err != nil {
+			logger.Errorf("sync quota %s failed: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package policy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/golang-jwt/jwt"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/celexpr"
+)
+
+const (
+	// Kind is the kind of Policy.
+	Kind = "Policy"
+
+	resultDenied = "denied"
+
+	effectAllow = "allow"
+	effectDeny  = "deny"
+)
+
+var results = []string{resultDenied}
+
+func init() {
+	httppipeline.Register(&Policy{})
+}
+
+type (
+	// Policy evaluates expressions against requests to allow or deny
+	// them, and to set headers consumed by the later filters.
+	Policy struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		rules     []*rule
+		variables []*variable
+
+		allowed, denied, errors uint64
+	}
+
+	// Spec describes the Policy.
+	Spec struct {
+		// Default is the effect if no rule matches.
+		Default   string      `yaml:"default" jsonschema:"omitempty,enum=,enum=allow,enum=deny"`
+		Rules     []*Rule     `yaml:"rules" jsonschema:"omitempty"`
+		Variables []*Variable `yaml:"variables" jsonschema:"omitempty"`
+	}
+
+	// Rule is a rule of the policy, the first matched rule decides the
+	// effect.
+	Rule struct {
+		Name string `yaml:"name" jsonschema:"required"`
+		// Expr is a CEL expression whose result must be bool.
+		Expr   string `yaml:"expr" jsonschema:"required"`
+		Effect string `yaml:"effect" jsonschema:"required,enum=allow,enum=deny"`
+	}
+
+	// Variable sets the request header to the result of the expression,
+	// the header is removed if the result is null or an empty string.
+	Variable struct {
+		Header string `yaml:"header" jsonschema:"required"`
+		Expr   string `yaml:"expr" jsonschema:"required"`
+	}
+
+	// Status is the status of Policy.
+	Status struct {
+		Allowed uint64 `yaml:"allowed"`
+		Denied  uint64 `yaml:"denied"`
+		// Errors is the count of the failed evaluations.
+		Errors uint64 `yaml:"errors"`
+	}
+
+	rule struct {
+		name    string
+		allow   bool
+		program *celexpr.Program
+	}
+
+	variable struct {
+		header  string
+		program *celexpr.Program
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	names := map[string]struct{}{}
+	for _, r := range spec.Rules {
+		if _, exists := names[r.Name]; exists {
+			return fmt.Errorf("rule %s is repeated", r.Name)
+		}
+		names[r.Name] = struct{}{}
+		if _, err := celexpr.Compile(r.Expr); err != nil {
+			return fmt.Errorf("rule %s: invalid expr: %v", r.Name, err)
+		}
+	}
+	for _, v := range spec.Variables {
+		if _, err := celexpr.Compile(v.Expr); err != nil {
+			return fmt.Errorf("variable %s: invalid expr: %v", v.Header, err)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of Policy.
+func (p *Policy) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of Policy.
+func (p *Policy) DefaultSpec() interface{} {
+	return &Spec{Default: effectDeny}
+}
+
+// Description returns the description of Policy.
+func (p *Policy) Description() string {
+	return "Policy allows or denies requests and sets headers by CEL expressions."
+}
+
+// Results returns the results of Policy.
+func (p *Policy) Results() []string {
+	return results
+}
+
+// Init initializes Policy.
+func (p *Policy) Init(filterSpec *httppipeline.FilterSpec) {
+	p.filterSpec, p.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	p.reload()
+}
+
+// Inherit inherits previous generation of Policy.
+func (p *Policy) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	p.Init(filterSpec)
+}
+
+func (p *Policy) reload() {
+	// NOTE: The expressions are compiled successfully in Validate.
+	p.rules = nil
+	for _, r := range p.spec.Rules {
+		program, _ := celexpr.Compile(r.Expr)
+		p.rules = append(p.rules, &rule{
+			name:    r.Name,
+			allow:   r.Effect == effectAllow,
+			program: program,
+		})
+	}
+
+	p.variables = nil
+	for _, v := range p.spec.Variables {
+		program, _ := celexpr.Compile(v.Expr)
+		p.variables = append(p.variables, &variable{header: v.Header, program: program})
+	}
+}
+
+// Handle handles HTTPContext.
+func (p *Policy) Handle(ctx context.HTTPContext) string {
+	result := p.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (p *Policy) handle(ctx context.HTTPContext) string {
+	a := &activation{ctx: ctx}
+
+	allowed, by := p.decide(ctx, a)
+	if !allowed {
+		atomic.AddUint64(&p.denied, 1)
+		ctx.AddTag(fmt.Sprintf("policy: denied by %s", by))
+		ctx.Response().SetStatusCode(http.StatusForbidden)
+		return resultDenied
+	}
+	atomic.AddUint64(&p.allowed, 1)
+
+	header := ctx.Request().Header()
+	for _, v := range p.variables {
+		// NOTE: The header is always removed at first, so clients can't
+		// fake it.
+		header.Del(v.header)
+		value, err := v.program.EvalString(a)
+		if err != nil {
+			atomic.AddUint64(&p.errors, 1)
+			ctx.AddTag(fmt.Sprintf("policy: evaluate variable %s failed: %v", v.header, err))
+			continue
+		}
+		if value != "" {
+			header.Set(v.header, value)
+		}
+	}
+	return ""
+}
+
+// decide returns whether the request is allowed and the rule deciding it.
+// A deny rule failed to evaluate denies the request, while an allow rule
+// failed to evaluate is skipped, so errors never allow requests.
+func (p *Policy) decide(ctx context.HTTPContext, a *activation) (bool, string) {
+	for _, r := range p.rules {
+		matched, err := r.program.EvalBool(a)
+		if err != nil {
+			atomic.AddUint64(&p.errors, 1)
+			ctx.AddTag(fmt.Sprintf("policy: evaluate rule %s failed: %v", r.name, err))
+			if !r.allow {
+				return false, r.name
+			}
+			continue
+		}
+		if matched {
+			return r.allow, r.name
+		}
+	}
+	return p.spec.Default == effectAllow, "default"
+}
+
+// Status returns status.
+func (p *Policy) Status() interface{} {
+	return &Status{
+		Allowed: atomic.LoadUint64(&p.allowed),
+		Denied:  atomic.LoadUint64(&p.denied),
+		Errors:  atomic.LoadUint64(&p.errors),
+	}
+}
+
+// Close closes Policy.
+func (p *Policy) Close() {}
+
+type (
+	// activation resolves the variables of expressions, which are
+	// request and jwt. The values are only built when they are used.
+	activation struct {
+		ctx    context.HTTPContext
+		claims map[string]interface{}
+		query  url.Values
+	}
+
+	requestObject struct{ a *activation }
+	headerObject  struct{ a *activation }
+	queryObject   struct{ a *activation }
+)
+
+func (a *activation) Resolve(name string) (interface{}, bool) {
+	switch name {
+	case "request":
+		return requestObject{a}, true
+	case "jwt":
+		return a.jwtClaims(), true
+	}
+	return nil, false
+}
+
+// jwtClaims returns the claims of the bearer token. The token is NOT
+// verified here, so the Policy must be placed after a Validator verifying
+// it.
+func (a *activation) jwtClaims() map[string]interface{} {
+	if a.claims != nil {
+		return a.claims
+	}
+
+	a.claims = map[string]interface{}{}
+	auth := a.ctx.Request().Header().Get("Authorization")
+	const prefix = "Bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return a.claims
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(auth[len(prefix):], claims); err == nil {
+		a.claims = claims
+	}
+	return a.claims
+}
+
+func (o requestObject) Field(name string) (interface{}, bool) {
+	r := o.a.ctx.Request()
+	switch name {
+	case "method":
+		return r.Method(), true
+	case "scheme":
+		return r.Scheme(), true
+	case "host":
+		return r.Host(), true
+	case "path":
+		return r.Path(), true
+	case "proto":
+		return r.Proto(), true
+	case "clientIP":
+		return r.RealIP(), true
+	case "headers":
+		return headerObject(o), true
+	case "query":
+		return queryObject(o), true
+	}
+	return nil, false
+}
+
+// Field returns the values of the header joined by commas, it's an empty
+// string for the missing header, like http.Header.Get.
+func (o headerObject) Field(name string) (interface{}, bool) {
+	values := o.a.ctx.Request().Header().GetAll(name)
+	if len(values) == 1 {
+		return values[0], true
+	}
+	return strings.Join(values, ", "), true
+}
+
+// Field returns the first value of the query parameter, it's an empty
+// string for the missing parameter.
+func (o queryObject) Field(name string) (interface{}, bool) {
+	if o.a.query == nil {
+		o.a.query, _ = url.ParseQuery(o.a.ctx.Request().Query())
+	}
+	return o.a.query.Get(name), true
+}
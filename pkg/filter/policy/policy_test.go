@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package policy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newPolicy(t *testing.T, yamlSpec string) *Policy {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p := &Policy{}
+	p.Init(spec)
+	return p
+}
+
+func newContext(method, url string, header http.Header) context.HTTPContext {
+	stdr, _ := http.NewRequest(method, url, nil)
+	for key, values := range header {
+		stdr.Header[key] = values
+	}
+	stdr.RemoteAddr = "10.1.2.3:1234"
+
+	ctx := context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string { return lastResult })
+	return ctx
+}
+
+func bearer(t *testing.T, claims jwt.MapClaims) http.Header {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("sign token failed: %v", err)
+	}
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+const yamlSpec = `
+kind: Policy
+name: policy
+rules:
+- name: block-bots
+  expr: "request.headers['User-Agent'].lowerAscii().contains('bot')"
+  effect: deny
+- name: admins
+  expr: "request.path.startsWith('/admin') && 'admin' in jwt.roles"
+  effect: allow
+- name: public
+  expr: "!request.path.startsWith('/admin') && request.method in ['GET', 'HEAD']"
+  effect: allow
+- name: internal
+  expr: "cidr('10.0.0.0/8').containsIP(request.clientIP) && request.query['debug'] == '1'"
+  effect: allow
+variables:
+- header: X-Policy-Tier
+  expr: "has(jwt.tier) ? jwt.tier : 'free'"
+- header: X-Policy-Subject
+  expr: "jwt.sub"
+`
+
+func TestPolicy(t *testing.T) {
+	p := newPolicy(t, yamlSpec)
+
+	cases := []struct {
+		name    string
+		method  string
+		url     string
+		header  http.Header
+		allowed bool
+	}{
+		{"public", "GET", "http://example.com/items", nil, true},
+		{"post", "POST", "http://example.com/items", nil, false},
+		{"bot", "GET", "http://example.com/items", http.Header{"User-Agent": {"GoogleBot/2.1"}}, false},
+		{"admin", "DELETE", "http://example.com/admin/users", bearer(t, jwt.MapClaims{"sub": "alice", "roles": []string{"admin"}}), true},
+		{"not admin", "GET", "http://example.com/admin/users", bearer(t, jwt.MapClaims{"sub": "bob", "roles": []string{"dev"}}), false},
+		{"no token", "GET", "http://example.com/admin/users", nil, false},
+		{"internal", "POST", "http://example.com/items?debug=1", nil, true},
+	}
+
+	for _, c := range cases {
+		ctx := newContext(c.method, c.url, c.header)
+		result := p.Handle(ctx)
+		if c.allowed {
+			if result != "" {
+				t.Errorf("%s: expected allowed, got %s", c.name, result)
+			}
+		} else if result != resultDenied || ctx.Response().StatusCode() != http.StatusForbidden {
+			t.Errorf("%s: expected denied, got %s", c.name, result)
+		}
+	}
+
+	status := p.Status().(*Status)
+	if status.Allowed != 3 || status.Denied != 4 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestVariables(t *testing.T) {
+	p := newPolicy(t, yamlSpec)
+
+	header := bearer(t, jwt.MapClaims{"sub": "alice", "tier": "gold"})
+	header.Set("X-Policy-Subject", "mallory")
+	ctx := newContext("GET", "http://example.com/items", header)
+	p.Handle(ctx)
+	h := ctx.Request().Header()
+	if h.Get("X-Policy-Tier") != "gold" || h.Get("X-Policy-Subject") != "alice" {
+		t.Errorf("unexpected headers: %v", h.Std())
+	}
+
+	// jwt.sub fails to evaluate without a token, the faked header must
+	// be removed anyway.
+	ctx = newContext("GET", "http://example.com/items", http.Header{"X-Policy-Subject": {"mallory"}})
+	p.Handle(ctx)
+	h = ctx.Request().Header()
+	if h.Get("X-Policy-Tier") != "free" || h.Get("X-Policy-Subject") != "" {
+		t.Errorf("unexpected headers: %v", h.Std())
+	}
+	if p.Status().(*Status).Errors != 1 {
+		t.Errorf("expected 1 error")
+	}
+}
+
+func TestDefaultAndErrors(t *testing.T) {
+	p := newPolicy(t, `
+kind: Policy
+name: policy
+default: allow
+rules:
+- name: deny-on-error
+  expr: "jwt.missing == 'x'"
+  effect: deny
+`)
+	ctx := newContext("GET", "http://example.com/", nil)
+	if result := p.Handle(ctx); result != resultDenied {
+		t.Errorf("deny rule failed to evaluate should deny, got %q", result)
+	}
+
+	p = newPolicy(t, `
+kind: Policy
+name: policy
+default: deny
+rules:
+- name: allow-on-error
+  expr: "jwt.missing == 'x'"
+  effect: allow
+`)
+	ctx = newContext("GET", "http://example.com/", nil)
+	if result := p.Handle(ctx); result != resultDenied {
+		t.Errorf("allow rule failed to evaluate should be skipped, got %q", result)
+	}
+
+	p = newPolicy(t, `
+kind: Policy
+name: policy
+default: allow
+`)
+	ctx = newContext("GET", "http://example.com/", nil)
+	if result := p.Handle(ctx); result != "" {
+		t.Errorf("expected allowed by default, got %q", result)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []Spec{
+		{Rules: []*Rule{{Name: "a", Expr: "true", Effect: "allow"}, {Name: "a", Expr: "false", Effect: "deny"}}},
+		{Rules: []*Rule{{Name: "a", Expr: "request.path ==", Effect: "allow"}}},
+		{Variables: []*Variable{{Header: "X-A", Expr: "foo("}}},
+	}
+	for i, c := range cases {
+		if err := c.Validate(); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+}
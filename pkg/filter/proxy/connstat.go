@@ -0,0 +1,99 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// connStat is the statistics of the connections to servers of a pool.
+	connStat struct {
+		requests      uint64
+		reused        uint64
+		newConns      uint64
+		newConnTime   uint64
+		tlsHandshakes uint64
+		tlsResumed    uint64
+	}
+
+	// ConnStatus is the status of the connections to servers of a pool.
+	ConnStatus struct {
+		Requests uint64 `yaml:"requests"`
+		// ReuseRatio is the ratio of requests sent by idle connections.
+		ReuseRatio float64 `yaml:"reuseRatio"`
+		// NewConnections is the number of connections created, and
+		// MeanNewConnLatency is the mean time from dialing to ready.
+		NewConnections     uint64 `yaml:"newConnections"`
+		MeanNewConnLatency string `yaml:"meanNewConnLatency"`
+		TLSHandshakes      uint64 `yaml:"tlsHandshakes"`
+		// TLSResumptionRatio is the ratio of handshakes resuming sessions.
+		TLSResumptionRatio float64 `yaml:"tlsResumptionRatio"`
+	}
+)
+
+// trace returns the trace of a request.
+func (cs *connStat) trace() *httptrace.ClientTrace {
+	var getConnAt time.Time
+	return &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			getConnAt = time.Now()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			atomic.AddUint64(&cs.requests, 1)
+			if info.Reused {
+				atomic.AddUint64(&cs.reused, 1)
+				return
+			}
+			// NOTE: It includes the time of dialing and TLS handshaking,
+			// or waiting for another request to release the connection.
+			atomic.AddUint64(&cs.newConns, 1)
+			atomic.AddUint64(&cs.newConnTime, uint64(time.Since(getConnAt)))
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err != nil {
+				return
+			}
+			atomic.AddUint64(&cs.tlsHandshakes, 1)
+			if state.DidResume {
+				atomic.AddUint64(&cs.tlsResumed, 1)
+			}
+		},
+	}
+}
+
+func (cs *connStat) status() *ConnStatus {
+	s := &ConnStatus{
+		Requests:       atomic.LoadUint64(&cs.requests),
+		NewConnections: atomic.LoadUint64(&cs.newConns),
+		TLSHandshakes:  atomic.LoadUint64(&cs.tlsHandshakes),
+	}
+	if s.Requests > 0 {
+		s.ReuseRatio = float64(atomic.LoadUint64(&cs.reused)) / float64(s.Requests)
+	}
+	if s.NewConnections > 0 {
+		s.MeanNewConnLatency = time.Duration(atomic.LoadUint64(&cs.newConnTime) / s.NewConnections).String()
+	}
+	if s.TLSHandshakes > 0 {
+		s.TLSResumptionRatio = float64(atomic.LoadUint64(&cs.tlsResumed)) / float64(s.TLSHandshakes)
+	}
+	return s
+}
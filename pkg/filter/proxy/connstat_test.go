@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+)
+
+func TestConnStat(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	client := newClient("", nil, nil, &HTTP2Spec{PingInterval: "30s", PingTimeout: "5s"})
+	cs := &connStat{}
+	get := func() string {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), cs.trace()))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 16))
+		return string(body)
+	}
+
+	if proto := get(); proto != "HTTP/2.0" {
+		t.Fatalf("want HTTP/2.0, got %s", proto)
+	}
+	get()
+	client.CloseIdleConnections()
+	get()
+
+	s := cs.status()
+	if s.Requests != 3 || s.NewConnections != 2 || s.ReuseRatio < 0.3 || s.ReuseRatio > 0.4 {
+		t.Errorf("unexpected status %+v", s)
+	}
+	if s.MeanNewConnLatency == "" || s.TLSHandshakes != 2 || s.TLSResumptionRatio != 0.5 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestHTTP2SpecValidate(t *testing.T) {
+	invalid := []HTTP2Spec{
+		{PingInterval: "-1s"},
+		{PingTimeout: "5s"},
+		{PingInterval: "30s", PingTimeout: "-5s"},
+	}
+	for i, spec := range invalid {
+		if spec.Validate() == nil {
+			t.Errorf("spec %d should be invalid", i)
+		}
+	}
+
+	if err := (HTTP2Spec{PingInterval: "30s", PingTimeout: "5s"}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
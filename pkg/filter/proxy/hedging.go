@@ -0,0 +1,340 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	stdcontext "context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/tracing"
+)
+
+const (
+	// hedgingWindow is the number of the latest latencies to calculate
+	// the percentile.
+	hedgingWindow = 1024
+	// hedgingMinSamples is the number of latencies required to hedge.
+	hedgingMinSamples = 32
+	// hedgingRefreshInterval is the interval of recalculating the delay.
+	hedgingRefreshInterval = time.Second
+	// hedgingMaxTokens is the burst of the budget.
+	hedgingMaxTokens = 10
+	// hedgingTokenUnit is the precision of tokens, which avoids the
+	// errors of floats.
+	hedgingTokenUnit = 10000
+)
+
+type (
+	// HedgingSpec describes the hedging of requests.
+	HedgingSpec struct {
+		// Percentile of the latencies of the pool, requests not
+		// responded in it are hedged, e.g. 95 hedges the slowest 5%.
+		Percentile float64 `yaml:"percentile" jsonschema:"required,minimum=50,maximum=99.9"`
+		// MinDelay is the lower bound of the delay before hedging.
+		MinDelay string `yaml:"minDelay" jsonschema:"omitempty,format=duration"`
+		// MaxRatio is the max percentage of requests to be hedged, which
+		// protects servers from doubled traffic when they are slow.
+		MaxRatio float64 `yaml:"maxRatio" jsonschema:"required,minimum=0,maximum=100"`
+		// Methods are the idempotent methods to hedge, requests with
+		// bodies are never hedged.
+		Methods []string `yaml:"methods" jsonschema:"omitempty,uniqueItems=true"`
+	}
+
+	// HedgingStatus is the status of the hedging of a pool.
+	HedgingStatus struct {
+		// Delay is the current delay before hedging, it's empty if there
+		// are not enough latencies yet.
+		Delay string `yaml:"delay"`
+		// Hedged is the number of hedged requests, and Won is how many
+		// of them are responded by the hedged attempts first.
+		Hedged uint64 `yaml:"hedged"`
+		Won    uint64 `yaml:"won"`
+		// Throttled is the number of requests not hedged for the budget.
+		Throttled uint64 `yaml:"throttled"`
+	}
+
+	hedger struct {
+		spec     *HedgingSpec
+		minDelay time.Duration
+		methods  map[string]struct{}
+
+		mutex     sync.Mutex
+		latencies []time.Duration
+		next      int
+		delay     time.Duration
+		refreshAt time.Time
+		// tokens and refill are in hedgingTokenUnit.
+		tokens int64
+		refill int64
+
+		hedged    uint64
+		won       uint64
+		throttled uint64
+	}
+)
+
+// Validate validates HedgingSpec.
+func (s HedgingSpec) Validate() error {
+	if s.MinDelay != "" {
+		if d, _ := time.ParseDuration(s.MinDelay); d < 0 {
+			return fmt.Errorf("minDelay must not be negative")
+		}
+	}
+	for _, m := range s.Methods {
+		switch m {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+			http.MethodPut, http.MethodDelete:
+		default:
+			return fmt.Errorf("method %s is not idempotent", m)
+		}
+	}
+	return nil
+}
+
+func newHedger(spec *HedgingSpec) *hedger {
+	h := &hedger{
+		spec:      spec,
+		methods:   map[string]struct{}{},
+		latencies: make([]time.Duration, 0, hedgingWindow),
+		tokens:    hedgingMaxTokens * hedgingTokenUnit,
+		refill:    int64(spec.MaxRatio / 100 * hedgingTokenUnit),
+	}
+	h.minDelay, _ = time.ParseDuration(spec.MinDelay)
+
+	methods := spec.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+	}
+	for _, m := range methods {
+		h.methods[m] = struct{}{}
+	}
+	return h
+}
+
+// eligible returns whether the request could be hedged.
+func (h *hedger) eligible(r *http.Request) bool {
+	if _, ok := h.methods[r.Method]; !ok {
+		return false
+	}
+	return r.ContentLength == 0 && len(r.TransferEncoding) == 0 &&
+		r.Header.Get("Content-Length") == "" && r.Header.Get("Transfer-Encoding") == ""
+}
+
+// observe records the latency of an attempt, and refills the budget
+// by a request.
+func (h *hedger) observe(latency time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if len(h.latencies) < hedgingWindow {
+		h.latencies = append(h.latencies, latency)
+	} else {
+		h.latencies[h.next] = latency
+		h.next = (h.next + 1) % hedgingWindow
+	}
+
+	h.tokens += h.refill
+	if h.tokens > hedgingMaxTokens*hedgingTokenUnit {
+		h.tokens = hedgingMaxTokens * hedgingTokenUnit
+	}
+}
+
+// currentDelay returns the delay before hedging, zero means no hedging.
+func (h *hedger) currentDelay() time.Duration {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := time.Now()
+	if now.Before(h.refreshAt) {
+		return h.delay
+	}
+	h.refreshAt = now.Add(hedgingRefreshInterval)
+
+	if len(h.latencies) < hedgingMinSamples {
+		h.delay = 0
+		return 0
+	}
+
+	sorted := make([]time.Duration, len(h.latencies))
+	copy(sorted, h.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	h.delay = sorted[int(float64(len(sorted)-1)*h.spec.Percentile/100)]
+	if h.delay < h.minDelay {
+		h.delay = h.minDelay
+	}
+	return h.delay
+}
+
+// allow takes a token from the budget.
+func (h *hedger) allow() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.tokens < hedgingTokenUnit {
+		atomic.AddUint64(&h.throttled, 1)
+		return false
+	}
+	h.tokens -= hedgingTokenUnit
+	atomic.AddUint64(&h.hedged, 1)
+	return true
+}
+
+func (h *hedger) status() *HedgingStatus {
+	s := &HedgingStatus{
+		Hedged:    atomic.LoadUint64(&h.hedged),
+		Won:       atomic.LoadUint64(&h.won),
+		Throttled: atomic.LoadUint64(&h.throttled),
+	}
+
+	h.mutex.Lock()
+	if h.delay > 0 {
+		s.Delay = h.delay.String()
+	}
+	h.mutex.Unlock()
+
+	return s
+}
+
+type hedgingAttempt struct {
+	req    *request
+	resp   *http.Response
+	span   tracing.Span
+	err    error
+	hedged bool
+	cancel stdcontext.CancelFunc
+}
+
+// doHedgedRequest sends the request, and sends it to another server
+// again if it's not responded in the delay, the first successful
+// attempt wins and the other one is canceled.
+func (p *pool) doHedgedRequest(ctx context.HTTPContext, req *request,
+	addTag func(subPrefix, msg string)) (*request, *http.Response, tracing.Span, error) {
+
+	results := make(chan *hedgingAttempt, 2)
+	var attempts []*hedgingAttempt
+	launch := func(req *request, hedged bool) {
+		reqCtx, cancel := stdcontext.WithCancel(req.std.Context())
+		req.std = req.std.WithContext(reqCtx)
+		// NOTE: The attempts are sent concurrently, and the tracing
+		// headers are injected into them, so they can't share the
+		// header of the original request.
+		req.std.Header = req.std.Header.Clone()
+		a := &hedgingAttempt{req: req, hedged: hedged, cancel: cancel}
+		attempts = append(attempts, a)
+
+		go func() {
+			startAt := time.Now()
+			a.resp, a.span, a.err = p.doRequest(ctx, req)
+			if a.err == nil {
+				p.hedger.observe(time.Since(startAt))
+			}
+			results <- a
+		}()
+	}
+
+	launch(req, false)
+
+	var timer <-chan time.Time
+	if delay := p.hedger.currentDelay(); delay > 0 {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	pending := 1
+	for {
+		select {
+		case <-timer:
+			timer = nil
+			if hedgedReq := p.hedgedRequest(ctx, req); hedgedReq != nil {
+				addTag("hedgedAddr", hedgedReq.server.URL)
+				launch(hedgedReq, true)
+				pending++
+			}
+		case a := <-results:
+			pending--
+			if a.err != nil && pending > 0 {
+				a.cancel()
+				continue
+			}
+
+			for _, other := range attempts {
+				if other != a {
+					other.cancel()
+				}
+			}
+			if pending > 0 {
+				go discardHedgingAttempt(results)
+			}
+
+			if a.err != nil {
+				a.cancel()
+				return a.req, nil, nil, a.err
+			}
+			if a.hedged {
+				atomic.AddUint64(&p.hedger.won, 1)
+			}
+			// NOTE: The body is read after returning, so the winner is
+			// canceled after finishing.
+			ctx.Lock()
+			ctx.OnFinish(a.cancel)
+			ctx.Unlock()
+			return a.req, a.resp, a.span, nil
+		}
+	}
+}
+
+// hedgedRequest returns the request to another server, or nil if there
+// is no other server or the budget is exhausted.
+func (p *pool) hedgedRequest(ctx context.HTTPContext, req *request) *request {
+	// NOTE: Load balance policies like ipHash always pick the same one.
+	var server *Server
+	for i := 0; i < 3; i++ {
+		s, err := p.servers.next(ctx)
+		if err == nil && s.URL != req.server.URL {
+			server = s
+			break
+		}
+	}
+	if server == nil || !p.hedger.allow() {
+		return nil
+	}
+
+	hedgedReq, err := p.prepareRequest(ctx, server, nil)
+	if err != nil {
+		return nil
+	}
+	return hedgedReq
+}
+
+// discardHedgingAttempt releases the attempt losing the race.
+func discardHedgingAttempt(results chan *hedgingAttempt) {
+	a := <-results
+	if a.err != nil {
+		return
+	}
+	a.resp.Body.Close()
+	a.span.Finish()
+}
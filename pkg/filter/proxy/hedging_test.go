@@ -0,0 +1,253 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go/mocktracer"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestHedgerDelay(t *testing.T) {
+	h := newHedger(&HedgingSpec{Percentile: 90, MinDelay: "5ms", MaxRatio: 10})
+
+	for i := 0; i < hedgingMinSamples-1; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	if h.currentDelay() != 0 {
+		t.Fatalf("no hedging without enough latencies")
+	}
+
+	h.refreshAt = time.Time{}
+	for i := 0; i < 100; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	if d := h.currentDelay(); d < 80*time.Millisecond || d > 100*time.Millisecond {
+		t.Fatalf("unexpected delay %v", d)
+	}
+
+	h = newHedger(&HedgingSpec{Percentile: 50, MinDelay: "1s", MaxRatio: 10})
+	for i := 0; i < hedgingMinSamples; i++ {
+		h.observe(time.Millisecond)
+	}
+	if d := h.currentDelay(); d != time.Second {
+		t.Fatalf("delay should be at least minDelay, got %v", d)
+	}
+}
+
+func TestHedgerBudget(t *testing.T) {
+	h := newHedger(&HedgingSpec{Percentile: 95, MaxRatio: 10})
+
+	for i := 0; i < hedgingMaxTokens; i++ {
+		if !h.allow() {
+			t.Fatalf("hedging should be allowed by the burst")
+		}
+	}
+	if h.allow() {
+		t.Fatalf("hedging should be throttled")
+	}
+
+	// NOTE: 10% of 10 requests refills a token.
+	for i := 0; i < 10; i++ {
+		h.observe(time.Millisecond)
+	}
+	if !h.allow() || h.allow() {
+		t.Fatalf("unexpected budget %v", h.tokens)
+	}
+
+	s := h.status()
+	if s.Hedged != hedgingMaxTokens+1 || s.Throttled != 2 {
+		t.Fatalf("unexpected status %+v", s)
+	}
+}
+
+func TestHedgerEligible(t *testing.T) {
+	h := newHedger(&HedgingSpec{Percentile: 95, MaxRatio: 10})
+
+	r, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	if !h.eligible(r) {
+		t.Errorf("GET without body should be eligible")
+	}
+	r, _ = http.NewRequest(http.MethodPost, "http://127.0.0.1/", nil)
+	if h.eligible(r) {
+		t.Errorf("POST should not be eligible")
+	}
+	r, _ = http.NewRequest(http.MethodGet, "http://127.0.0.1/", strings.NewReader("body"))
+	if h.eligible(r) {
+		t.Errorf("requests with body should not be eligible")
+	}
+
+	if (HedgingSpec{Methods: []string{http.MethodPost}}).Validate() == nil {
+		t.Errorf("POST should be invalid")
+	}
+}
+
+func TestHedgedRequest(t *testing.T) {
+	defer func(fn func(*http.Client, *http.Request) (*http.Response, error)) {
+		fnSendRequest = fn
+	}(fnSendRequest)
+
+	canceled := make(chan string, 2)
+	fnSendRequest = func(client *http.Client, r *http.Request) (*http.Response, error) {
+		if r.URL.Host == "127.0.0.1:9095" {
+			select {
+			case <-r.Context().Done():
+				canceled <- r.URL.Host
+				return nil, r.Context().Err()
+			case <-time.After(time.Second):
+			}
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(r.URL.Host)),
+		}, nil
+	}
+
+	p := newPool(&PoolSpec{
+		Servers: []*Server{
+			{URL: "http://127.0.0.1:9095"},
+			{URL: "http://127.0.0.1:9096"},
+		},
+		LoadBalance: &LoadBalance{Policy: PolicyRoundRobin},
+		Hedging:     &HedgingSpec{Percentile: 95, MinDelay: "20ms", MaxRatio: 10},
+	}, "proxy#main", true, nil)
+	for i := 0; i < hedgingMinSamples; i++ {
+		p.hedger.observe(time.Millisecond)
+	}
+
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedMethod = func() string { return http.MethodGet }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(http.Header{}) }
+	tags := []string{}
+	addTag := func(subPrefix, msg string) { tags = append(tags, fmt.Sprintf("%s: %s", subPrefix, msg)) }
+
+	server, _ := p.servers.next(ctx)
+	if server.URL != "http://127.0.0.1:9095" {
+		server, _ = p.servers.next(ctx)
+	}
+	req, _ := p.prepareRequest(ctx, server, nil)
+
+	startAt := time.Now()
+	req, resp, _, err := p.doHedgedRequest(ctx, req, addTag)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(startAt); elapsed > 500*time.Millisecond {
+		t.Fatalf("hedged request takes too long: %v", elapsed)
+	}
+	if req.server.URL != "http://127.0.0.1:9096" || resp.StatusCode != http.StatusOK {
+		t.Fatalf("the hedged attempt should win, got %s", req.server.URL)
+	}
+	if len(tags) != 1 || tags[0] != "hedgedAddr: http://127.0.0.1:9096" {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+
+	select {
+	case host := <-canceled:
+		if host != "127.0.0.1:9095" {
+			t.Fatalf("unexpected canceled attempt %s", host)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the losing attempt is not canceled")
+	}
+
+	s := p.status().Hedging
+	if s.Hedged != 1 || s.Won != 1 || s.Delay != "20ms" {
+		t.Fatalf("unexpected status %+v", s)
+	}
+}
+
+func TestHedgedRequestHeaders(t *testing.T) {
+	defer func(fn func(*http.Client, *http.Request) (*http.Response, error)) {
+		fnSendRequest = fn
+	}(fnSendRequest)
+
+	injected := make(chan http.Header, 2)
+	fnSendRequest = func(client *http.Client, r *http.Request) (*http.Response, error) {
+		injected <- r.Header
+		if r.URL.Host == "127.0.0.1:9095" {
+			// Keep reading the header while the hedged attempt is sent.
+			for {
+				select {
+				case <-r.Context().Done():
+					return nil, r.Context().Err()
+				default:
+					for k := range r.Header {
+						r.Header.Get(k)
+					}
+				}
+			}
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(r.URL.Host)),
+		}, nil
+	}
+
+	p := newPool(&PoolSpec{
+		Servers: []*Server{
+			{URL: "http://127.0.0.1:9095"},
+			{URL: "http://127.0.0.1:9096"},
+		},
+		LoadBalance: &LoadBalance{Policy: PolicyRoundRobin},
+		Hedging:     &HedgingSpec{Percentile: 95, MinDelay: "10ms", MaxRatio: 10},
+	}, "proxy#main", true, nil)
+	for i := 0; i < hedgingMinSamples; i++ {
+		p.hedger.observe(time.Millisecond)
+	}
+
+	tracer := &tracing.Tracing{Tracer: mocktracer.New()}
+	header := http.Header{"X-Foo": []string{"bar"}}
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedSpan = func() tracing.Span { return tracing.NewSpan(tracer, "test") }
+	ctx.MockedRequest.MockedMethod = func() string { return http.MethodGet }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(header) }
+
+	server, _ := p.servers.next(ctx)
+	if server.URL != "http://127.0.0.1:9095" {
+		server, _ = p.servers.next(ctx)
+	}
+	req, _ := p.prepareRequest(ctx, server, nil)
+	_, resp, _, err := p.doHedgedRequest(ctx, req, func(subPrefix, msg string) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	first, hedged := <-injected, <-injected
+	for _, h := range []http.Header{first, hedged} {
+		if h.Get("X-Foo") != "bar" || h.Get("Mockpfx-Ids-Traceid") == "" {
+			t.Fatalf("unexpected header of the attempt: %v", h)
+		}
+	}
+	if first.Get("Mockpfx-Ids-Spanid") == hedged.Get("Mockpfx-Ids-Spanid") {
+		t.Fatalf("the attempts should have their own spans")
+	}
+	if len(header) != 1 {
+		t.Fatalf("the header of the original request is modified: %v", header)
+	}
+}
@@ -18,11 +18,14 @@
 package proxy
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 
@@ -30,11 +33,16 @@ import (
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/tracing"
 	"github.com/megaease/easegress/pkg/util/callbackreader"
+	"github.com/megaease/easegress/pkg/util/dialer"
+	"github.com/megaease/easegress/pkg/util/dnsresolver"
 	"github.com/megaease/easegress/pkg/util/httpfilter"
 	"github.com/megaease/easegress/pkg/util/httpheader"
 	"github.com/megaease/easegress/pkg/util/httpstat"
 	"github.com/megaease/easegress/pkg/util/memorycache"
+	"github.com/megaease/easegress/pkg/util/retryafter"
+	"github.com/megaease/easegress/pkg/util/spiffe"
 	"github.com/megaease/easegress/pkg/util/stringtool"
+	"github.com/megaease/easegress/pkg/util/tlsprofile"
 )
 
 type (
@@ -43,8 +51,21 @@ type (
 
 		tagPrefix     string
 		writeResponse bool
+		// earlyHints forwards the 103 Early Hints of servers, only the
+		// pools writing responses forward them.
+		earlyHints bool
 
 		filter *httpfilter.HTTPFilter
+		client *http.Client
+		spiffe *spiffe.Source
+		dialer *dialer.Dialer
+		hedger *hedger
+		hinter *retryafter.Hinter
+
+		connStat connStat
+		// failures is the number of the consecutive server failures,
+		// only counted if hinter is set.
+		failures uint32
 
 		servers     *servers
 		httpStat    *httpstat.HTTPStat
@@ -61,16 +82,56 @@ type (
 		ServiceName     string            `yaml:"serviceName" jsonschema:"omitempty"`
 		LoadBalance     *LoadBalance      `yaml:"loadBalance" jsonschema:"required"`
 		MemoryCache     *memorycache.Spec `yaml:"memoryCache,omitempty" jsonschema:"omitempty"`
+		TLSProfile      string            `yaml:"tlsProfile" jsonschema:"omitempty"`
+		// SPIFFE presents the X.509-SVID from SPIRE agents to servers,
+		// and requires server X.509-SVIDs.
+		SPIFFE *spiffe.Spec `yaml:"spiffe,omitempty" jsonschema:"omitempty"`
+		// DNS resolves the servers by custom DNS servers, search domains
+		// and hosts overrides instead of the ones of the system.
+		DNS *dnsresolver.Spec `yaml:"dns,omitempty" jsonschema:"omitempty"`
+		// Dial connects to the dual-stack servers by Happy Eyeballs.
+		Dial *dialer.Spec `yaml:"dial,omitempty" jsonschema:"omitempty"`
+		// HTTP2 enables HTTP/2 to HTTPS servers, and probes idle
+		// connections by pings.
+		HTTP2 *HTTP2Spec `yaml:"http2,omitempty" jsonschema:"omitempty"`
+		// Hedging sends slow idempotent requests to another server again,
+		// and uses whichever responds first.
+		Hedging *HedgingSpec `yaml:"hedging,omitempty" jsonschema:"omitempty"`
+		// EarlyHints forwards the 103 Early Hints of servers to clients.
+		EarlyHints bool `yaml:"earlyHints" jsonschema:"omitempty"`
+		// RetryAfter hints clients when to retry if no server is
+		// available or the servers failed.
+		RetryAfter *retryafter.Spec `yaml:"retryAfter,omitempty" jsonschema:"omitempty"`
+	}
+
+	// HTTP2Spec describes HTTP/2 to servers.
+	HTTP2Spec struct {
+		// PingInterval is how long a connection could be idle before
+		// a ping, no pings are sent if it's empty.
+		PingInterval string `yaml:"pingInterval" jsonschema:"omitempty,format=duration"`
+		// PingTimeout is how long a ping could be unanswered before the
+		// connection is closed.
+		PingTimeout string `yaml:"pingTimeout" jsonschema:"omitempty,format=duration"`
 	}
 
 	// PoolStatus is the status of Pool.
 	PoolStatus struct {
-		Stat *httpstat.Status `yaml:"stat"`
+		Stat   *httpstat.Status `yaml:"stat"`
+		SPIFFE *spiffe.Status   `yaml:"spiffe,omitempty"`
+		Dial   *dialer.Status   `yaml:"dial,omitempty"`
+		// Connections is the status of the connections to the servers.
+		Connections *ConnStatus    `yaml:"connections"`
+		Hedging     *HedgingStatus `yaml:"hedging,omitempty"`
 	}
 )
 
 // Validate validates poolSpec.
 func (s PoolSpec) Validate() error {
+	err := tlsprofile.Validate(s.TLSProfile)
+	if err != nil {
+		return err
+	}
+
 	if s.ServiceName == "" && len(s.Servers) == 0 {
 		return fmt.Errorf("both serviceName and servers are empty")
 	}
@@ -96,6 +157,24 @@ func (s PoolSpec) Validate() error {
 	return nil
 }
 
+// Validate validates HTTP2Spec.
+func (s HTTP2Spec) Validate() error {
+	if s.PingInterval != "" {
+		if d, _ := time.ParseDuration(s.PingInterval); d <= 0 {
+			return fmt.Errorf("pingInterval must be positive")
+		}
+	}
+	if s.PingTimeout != "" {
+		if s.PingInterval == "" {
+			return fmt.Errorf("pingTimeout requires pingInterval")
+		}
+		if d, _ := time.ParseDuration(s.PingTimeout); d <= 0 {
+			return fmt.Errorf("pingTimeout must be positive")
+		}
+	}
+	return nil
+}
+
 func newPool(spec *PoolSpec, tagPrefix string,
 	writeResponse bool, failureCodes []int) *pool {
 
@@ -109,13 +188,50 @@ func newPool(spec *PoolSpec, tagPrefix string,
 		memoryCache = memorycache.New(spec.MemoryCache)
 	}
 
+	var source *spiffe.Source
+	var tlsConfig *tls.Config
+	if spec.SPIFFE != nil {
+		source = spiffe.Acquire(spec.SPIFFE.SocketPath)
+		tlsConfig = source.ClientTLSConfig(spec.SPIFFE.AllowedIDs)
+	}
+
+	// NOTE: Pools without both of them dial by the global client.
+	var d *dialer.Dialer
+	if spec.DNS != nil || spec.Dial != nil {
+		dialSpec := spec.Dial
+		if dialSpec == nil {
+			dialSpec = &dialer.Spec{}
+		}
+		var resolver dialer.Resolver
+		if spec.DNS != nil {
+			resolver = dnsresolver.New(spec.DNS)
+		}
+		d = dialer.New(dialSpec, resolver)
+	}
+
+	var h *hedger
+	if spec.Hedging != nil {
+		h = newHedger(spec.Hedging)
+	}
+
+	var hinter *retryafter.Hinter
+	if spec.RetryAfter != nil && writeResponse {
+		hinter = retryafter.New(spec.RetryAfter)
+	}
+
 	return &pool{
 		spec: spec,
 
 		tagPrefix:     tagPrefix,
 		writeResponse: writeResponse,
+		earlyHints:    spec.EarlyHints && writeResponse,
 
 		filter:      filter,
+		client:      newClient(spec.TLSProfile, tlsConfig, d, spec.HTTP2),
+		spiffe:      source,
+		dialer:      d,
+		hedger:      h,
+		hinter:      hinter,
 		servers:     newServers(spec),
 		httpStat:    httpstat.New(),
 		memoryCache: memoryCache,
@@ -123,7 +239,16 @@ func newPool(spec *PoolSpec, tagPrefix string,
 }
 
 func (p *pool) status() *PoolStatus {
-	s := &PoolStatus{Stat: p.httpStat.Status()}
+	s := &PoolStatus{Stat: p.httpStat.Status(), Connections: p.connStat.status()}
+	if p.spiffe != nil {
+		s.SPIFFE = p.spiffe.Status()
+	}
+	if p.dialer != nil {
+		s.Dial = p.dialer.Status()
+	}
+	if p.hedger != nil {
+		s.Hedging = p.hedger.status()
+	}
 	return s
 }
 
@@ -141,6 +266,12 @@ func (p *pool) handle(ctx context.HTTPContext, reqBody io.Reader) string {
 	if err != nil {
 		addTag("serverErr", err.Error())
 		w.SetStatusCode(http.StatusServiceUnavailable)
+		if p.hinter != nil {
+			// servers are updated as soon as the service is, or
+			// fetched again after retryTimeout.
+			p.hinter.Hint(w.Header().Std(), retryTimeout,
+				fmt.Sprintf("%v, service retried every %v", err, retryTimeout))
+		}
 		return resultInternalError
 	}
 	addTag("addr", server.URL)
@@ -154,7 +285,14 @@ func (p *pool) handle(ctx context.HTTPContext, reqBody io.Reader) string {
 		return resultInternalError
 	}
 
-	resp, span, err := p.doRequest(ctx, req)
+	var resp *http.Response
+	var span tracing.Span
+	// NOTE: Mirror pools never hedge, their responses are discarded.
+	if p.hedger != nil && p.writeResponse && p.hedger.eligible(req.std) {
+		req, resp, span, err = p.doHedgedRequest(ctx, req, addTag)
+	} else {
+		resp, span, err = p.doRequest(ctx, req)
+	}
 	if err != nil {
 		// NOTE: May add option to cancel the tracing if failed here.
 		// ctx.Span().Cancel()
@@ -168,9 +306,18 @@ func (p *pool) handle(ctx context.HTTPContext, reqBody io.Reader) string {
 		}
 
 		w.SetStatusCode(http.StatusServiceUnavailable)
+		if p.hinter != nil {
+			failures := atomic.AddUint32(&p.failures, 1)
+			p.hinter.Hint(w.Header().Std(), p.hinter.Backoff(failures),
+				fmt.Sprintf("%d consecutive server failures", failures))
+		}
 		return resultServerError
 	}
 
+	if p.hinter != nil {
+		atomic.StoreUint32(&p.failures, 0)
+	}
+
 	addTag("code", strconv.Itoa(resp.StatusCode))
 
 	ctx.Lock()
@@ -212,7 +359,7 @@ func (p *pool) doRequest(ctx context.HTTPContext, req *request) (*http.Response,
 	span := ctx.Span().NewChildWithStart(spanName, req.startTime())
 	span.Tracer().Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.std.Header))
 
-	resp, err := fnSendRequest(req.std)
+	resp, err := fnSendRequest(p.client, req.std)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -277,4 +424,10 @@ func responseMetaSize(resp *http.Response) int {
 
 func (p *pool) close() {
 	p.servers.close()
+	if p.client != globalClient {
+		p.client.CloseIdleConnections()
+	}
+	if p.spiffe != nil {
+		p.spiffe.Release()
+	}
 }
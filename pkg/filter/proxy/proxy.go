@@ -25,9 +25,14 @@ import (
 	"sync"
 	"time"
 
+	"golang.org/x/net/http2"
+
 	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/dialer"
 	"github.com/megaease/easegress/pkg/util/fallback"
+	"github.com/megaease/easegress/pkg/util/tlsprofile"
 )
 
 const (
@@ -67,6 +72,9 @@ var globalClient = &http.Client{
 			// NOTE: Could make it an paramenter,
 			// when the requests need cross WAN.
 			InsecureSkipVerify: true,
+			// NOTE: Resuming sessions saves the full handshakes of
+			// new connections to the same servers.
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
 		},
 		DisableCompression: false,
 		// NOTE: The large number of Idle Connections can
@@ -82,8 +90,50 @@ var globalClient = &http.Client{
 	},
 }
 
-var fnSendRequest = func(r *http.Request) (*http.Response, error) {
-	return globalClient.Do(r)
+// newClient returns the client of the TLS profile, the TLS config, the
+// dialer and the HTTP/2 spec, the TLS config and the dialer of the
+// globalClient are used if they are nil, and HTTP/2 is disabled if
+// http2Spec is nil. Pools without all of them share the globalClient.
+func newClient(tlsProfile string, tlsConfig *tls.Config, d *dialer.Dialer, http2Spec *HTTP2Spec) *http.Client {
+	if tlsProfile == "" && tlsConfig == nil && d == nil && http2Spec == nil {
+		return globalClient
+	}
+
+	transport := globalClient.Transport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	// NOTE: The profile has been validated.
+	tlsprofile.Apply(transport.TLSClientConfig, tlsProfile)
+	if d != nil {
+		transport.DialContext = d.DialContext
+	}
+	if http2Spec != nil {
+		configureHTTP2(transport, http2Spec)
+	}
+
+	client := *globalClient
+	client.Transport = transport
+	return &client
+}
+
+// configureHTTP2 enables HTTP/2 of the transport, the spec has been validated.
+func configureHTTP2(transport *http.Transport, spec *HTTP2Spec) {
+	// NOTE: The TLS config may be shared, but HTTP/2 changes its NextProtos.
+	transport.TLSClientConfig = transport.TLSClientConfig.Clone()
+	t2, err := http2.ConfigureTransports(transport)
+	if err != nil {
+		logger.Errorf("BUG: configure http2 failed: %v", err)
+		return
+	}
+	t2.ReadIdleTimeout, _ = time.ParseDuration(spec.PingInterval)
+	if spec.PingTimeout != "" {
+		t2.PingTimeout, _ = time.ParseDuration(spec.PingTimeout)
+	}
+}
+
+var fnSendRequest = func(client *http.Client, r *http.Request) (*http.Response, error) {
+	return client.Do(r)
 }
 
 type (
@@ -218,6 +268,17 @@ func (b *Proxy) reload() {
 	if b.spec.Compression != nil {
 		b.compression = newCompression(b.spec.Compression)
 	}
+
+	// NOTE: Weight factors are set by controllers at runtime,
+	// see SetWeightFactors.
+	wf := weightFactorOf(b.filterSpec.Pipeline(), b.filterSpec.Name())
+	b.mainPool.servers.weightFactor = wf
+	for _, p := range b.candidatePools {
+		p.servers.weightFactor = wf
+	}
+	if b.mirrorPool != nil {
+		b.mirrorPool.servers.weightFactor = wf
+	}
 }
 
 // Status returns Proxy status.
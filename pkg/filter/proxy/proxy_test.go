@@ -18,8 +18,10 @@
 package proxy
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strings"
 	"testing"
@@ -27,9 +29,12 @@ import (
 
 	"github.com/megaease/easegress/pkg/context/contexttest"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/dialer"
 	"github.com/megaease/easegress/pkg/util/httpfilter"
 	"github.com/megaease/easegress/pkg/util/httpheader"
 	"github.com/megaease/easegress/pkg/util/memorycache"
+	"github.com/megaease/easegress/pkg/util/retryafter"
+	"github.com/megaease/easegress/pkg/util/tlsprofile"
 	"github.com/megaease/easegress/pkg/util/yamltool"
 )
 
@@ -122,7 +127,7 @@ failureCodes: [503, 504]
 		t.Error("fallback for 500 should be false")
 	}
 
-	fnSendRequest = func(r *http.Request) (*http.Response, error) {
+	fnSendRequest = func(client *http.Client, r *http.Request) (*http.Response, error) {
 		return &http.Response{
 			Body: io.NopCloser(strings.NewReader("this is the body")),
 		}, nil
@@ -133,7 +138,7 @@ failureCodes: [503, 504]
 	}
 	ctx.Finish()
 
-	fnSendRequest = func(r *http.Request) (*http.Response, error) {
+	fnSendRequest = func(client *http.Client, r *http.Request) (*http.Response, error) {
 		return nil, fmt.Errorf("mocked error")
 	}
 	result = proxy.Handle(ctx)
@@ -235,3 +240,82 @@ func TestPoolSpecValidate(t *testing.T) {
 		t.Error("validate should succeed")
 	}
 }
+
+func TestNewClient(t *testing.T) {
+	if newClient("", nil, nil, nil) != globalClient {
+		t.Error("pools without tls profile should share the global client")
+	}
+
+	client := newClient(tlsprofile.Modern, nil, nil, nil)
+	config := client.Transport.(*http.Transport).TLSClientConfig
+	if config.MinVersion != tls.VersionTLS13 || !config.InsecureSkipVerify {
+		t.Errorf("unexpected tls config %+v", config)
+	}
+	if globalClient.Transport.(*http.Transport).TLSClientConfig.MinVersion != 0 {
+		t.Error("tls config of the global client should not be changed")
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: true, ServerName: "spiffe"}
+	client = newClient(tlsprofile.Intermediate, tlsConfig, nil, nil)
+	config = client.Transport.(*http.Transport).TLSClientConfig
+	if config != tlsConfig || config.MinVersion != tls.VersionTLS12 {
+		t.Errorf("unexpected tls config %+v", config)
+	}
+
+	client = newClient("", nil, dialer.New(&dialer.Spec{}, nil), nil)
+	if client == globalClient || client.Transport.(*http.Transport).TLSClientConfig.MinVersion != 0 {
+		t.Error("pools with dialer should have their own client")
+	}
+}
+
+func TestPoolRetryAfter(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	p := newPool(&PoolSpec{
+		Servers:     []*Server{{URL: "http://" + addr}},
+		LoadBalance: &LoadBalance{Policy: PolicyRoundRobin},
+		RetryAfter:  &retryafter.Spec{Min: "1s", Max: "10s", Debug: true},
+	}, "proxy#main", true, nil)
+	defer p.close()
+
+	header := httpheader.New(http.Header{})
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedMethod = func() string { return http.MethodGet }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(http.Header{}) }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return header }
+
+	for i, expected := range []string{"1", "2", "4"} {
+		if result := p.handle(ctx, nil); result != resultServerError {
+			t.Fatalf("expected result %s, got %s", resultServerError, result)
+		}
+		if got := header.Get("Retry-After"); got != expected {
+			t.Errorf("failure %d: expected Retry-After %s, got %s", i+1, expected, got)
+		}
+	}
+	if got := header.Get(retryafter.ReasonHeader); got != "3 consecutive server failures" {
+		t.Errorf("unexpected reason %q", got)
+	}
+
+	p.servers.static = newStaticServers(nil, nil, nil)
+	if result := p.handle(ctx, nil); result != resultInternalError {
+		t.Fatalf("expected result %s, got %s", resultInternalError, result)
+	}
+	if got := header.Get("Retry-After"); got != "3" {
+		t.Errorf("expected Retry-After 3, got %s", got)
+	}
+
+	mirror := newPool(&PoolSpec{
+		Servers:     []*Server{{URL: "http://" + addr}},
+		LoadBalance: &LoadBalance{Policy: PolicyRoundRobin},
+		RetryAfter:  &retryafter.Spec{},
+	}, "proxy#mirror", false, nil)
+	defer mirror.close()
+	if mirror.hinter != nil {
+		t.Error("pools not writing responses should not hint")
+	}
+}
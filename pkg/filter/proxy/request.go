@@ -22,6 +22,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
 	"time"
 
 	httpstat "github.com/tcnksm/go-httpstat"
@@ -60,6 +62,10 @@ func (p *pool) newRequest(ctx context.HTTPContext, server *Server, reqBody io.Re
 	}
 
 	newCtx := httpstat.WithHTTPStat(ctx, req.statResult)
+	newCtx = httptrace.WithClientTrace(newCtx, p.connStat.trace())
+	if p.earlyHints {
+		newCtx = httptrace.WithClientTrace(newCtx, earlyHintsTrace(ctx))
+	}
 	stdr, err := http.NewRequestWithContext(newCtx, r.Method(), url, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("BUG: new request failed: %v", err)
@@ -73,6 +79,26 @@ func (p *pool) newRequest(ctx context.HTTPContext, server *Server, reqBody io.Re
 	return req, nil
 }
 
+// earlyHintsTrace returns the trace forwarding 103 Early Hints, which are
+// informational responses before the final one.
+func earlyHintsTrace(ctx context.HTTPContext) *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code != http.StatusEarlyHints {
+				return nil
+			}
+
+			// NOTE: The hedged requests may receive hints concurrently.
+			ctx.Lock()
+			defer ctx.Unlock()
+			if err := ctx.Response().WriteEarlyHints(http.Header(header)); err != nil {
+				logger.Debugf("forward early hints failed: %v", err)
+			}
+			return nil
+		},
+	}
+}
+
 func (r *request) start() {
 	if r._startTime != nil {
 		logger.Errorf("BUG: started already")
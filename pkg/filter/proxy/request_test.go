@@ -20,6 +20,7 @@ package proxy
 import (
 	"bytes"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
@@ -93,3 +94,49 @@ func TestResultState(t *testing.T) {
 		t.Error("implementation changed, this case should be updated")
 	}
 }
+
+func TestRequestEarlyHints(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Del("Link")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	var hints []http.Header
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedMethod = func() string { return http.MethodGet }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(http.Header{}) }
+	ctx.MockedResponse.MockedWriteEarlyHints = func(header http.Header) error {
+		hints = append(hints, header)
+		return nil
+	}
+
+	for _, earlyHints := range []bool{false, true} {
+		hints = nil
+		p := &pool{earlyHints: earlyHints}
+		req, err := p.newRequest(ctx, &Server{URL: backend.URL}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req.std)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+
+		if !earlyHints {
+			if len(hints) != 0 {
+				t.Errorf("early hints should not be forwarded")
+			}
+			continue
+		}
+		if len(hints) != 1 || hints[0].Get("Link") != "</style.css>; rel=preload; as=style" {
+			t.Errorf("unexpected early hints: %v", hints)
+		}
+		if resp.Header.Get("Link") != "" {
+			t.Errorf("unexpected link in final response")
+		}
+	}
+}
@@ -58,7 +58,8 @@ const (
 
 type (
 	servers struct {
-		poolSpec *PoolSpec
+		poolSpec     *PoolSpec
+		weightFactor *weightFactor
 
 		mutex   sync.Mutex
 		service *serviceregistry.Service
@@ -240,6 +241,12 @@ func (s *servers) next(ctx context.HTTPContext) (*Server, error) {
 		return nil, fmt.Errorf("no server available")
 	}
 
+	if static.lb.Policy == PolicyWeightedRandom {
+		if factors := s.weightFactor.load(); len(factors) > 0 {
+			return static.factoredWeightedRandom(ctx, factors), nil
+		}
+	}
+
 	return static.next(ctx), nil
 }
 
@@ -331,6 +338,29 @@ func (ss *staticServers) weightedRandom(ctx context.HTTPContext) *Server {
 	return ss.random(ctx)
 }
 
+// factoredWeightedRandom is weightedRandom with weights multiplied by
+// the factors, the configured weights are used if all factors are zero.
+func (ss *staticServers) factoredWeightedRandom(ctx context.HTTPContext, factors map[string]int) *Server {
+	sum := 0
+	weights := make([]int, len(ss.servers))
+	for i, server := range ss.servers {
+		weights[i] = server.Weight * factorOf(server, factors)
+		sum += weights[i]
+	}
+	if sum == 0 {
+		return ss.weightedRandom(ctx)
+	}
+
+	randomWeight := rand.Intn(sum)
+	for i, server := range ss.servers {
+		randomWeight -= weights[i]
+		if randomWeight < 0 {
+			return server
+		}
+	}
+	return ss.weightedRandom(ctx)
+}
+
 func (ss *staticServers) ipHash(ctx context.HTTPContext) *Server {
 	sum32 := int(hashtool.Hash32(ctx.Request().RealIP()))
 	return ss.servers[sum32%len(ss.servers)]
@@ -434,3 +434,50 @@ func TestServers(t *testing.T) {
 	service.Close("close")
 	s.close()
 }
+
+func TestWeightFactors(t *testing.T) {
+	poolSpec := &PoolSpec{
+		Servers: []*Server{
+			{URL: "http://127.0.0.1:9090", Tags: []string{"east"}, Weight: 50},
+			{URL: "http://127.0.0.1:9091", Tags: []string{"west"}, Weight: 50},
+		},
+		LoadBalance: &LoadBalance{Policy: PolicyWeightedRandom},
+	}
+	s := newServers(poolSpec)
+	defer s.close()
+	s.weightFactor = weightFactorOf("pipeline", "weight-factors")
+
+	count := func() int {
+		east := 0
+		for i := 0; i < 1000; i++ {
+			server, _ := s.next(&contexttest.MockedHTTPContext{})
+			if server.URL == "http://127.0.0.1:9090" {
+				east++
+			}
+		}
+		return east
+	}
+
+	if east := count(); east < 400 || east > 600 {
+		t.Errorf("expected about 500 requests to east, got %d", east)
+	}
+
+	SetWeightFactors("pipeline", "weight-factors", map[string]int{"east": 0})
+	if east := count(); east != 0 {
+		t.Errorf("expected no requests to east, got %d", east)
+	}
+	if f := WeightFactors("pipeline", "weight-factors"); !reflect.DeepEqual(f, map[string]int{"east": 0}) {
+		t.Errorf("unexpected factors %v", f)
+	}
+
+	// NOTE: The configured weights are used if all factors are zero.
+	SetWeightFactors("pipeline", "weight-factors", map[string]int{"east": 0, "west": 0})
+	if east := count(); east < 400 || east > 600 {
+		t.Errorf("expected about 500 requests to east, got %d", east)
+	}
+
+	SetWeightFactors("pipeline", "weight-factors", nil)
+	if east := count(); east < 400 || east > 600 {
+		t.Errorf("expected about 500 requests to east, got %d", east)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// weightFactors holds the *weightFactor of every Proxy, keyed by
+// pipeline/filter. Entries are never deleted, so factors survive
+// the reloading of proxies.
+var weightFactors sync.Map
+
+type weightFactor struct {
+	// map[string]int
+	factors atomic.Value
+}
+
+func weightFactorOf(pipeline, filter string) *weightFactor {
+	wf, _ := weightFactors.LoadOrStore(pipeline+"/"+filter, &weightFactor{})
+	return wf.(*weightFactor)
+}
+
+func (wf *weightFactor) load() map[string]int {
+	if wf == nil {
+		return nil
+	}
+	factors, _ := wf.factors.Load().(map[string]int)
+	return factors
+}
+
+// SetWeightFactors sets the factors of the weights of the servers of the
+// Proxy in the pipeline at runtime. The keys are server tags and the
+// values are percentages, the weight of a server becomes its configured
+// weight times the smallest factor of its tags. It only takes effect
+// on pools of the weightedRandom policy, and a nil map restores the
+// configured weights.
+func SetWeightFactors(pipeline, filter string, factors map[string]int) {
+	copied := make(map[string]int, len(factors))
+	for tag, factor := range factors {
+		copied[tag] = factor
+	}
+	weightFactorOf(pipeline, filter).factors.Store(copied)
+}
+
+// WeightFactors returns the factors set by SetWeightFactors.
+func WeightFactors(pipeline, filter string) map[string]int {
+	factors := weightFactorOf(pipeline, filter).load()
+	copied := make(map[string]int, len(factors))
+	for tag, factor := range factors {
+		copied[tag] = factor
+	}
+	return copied
+}
+
+// factorOf returns the factor of the server in percentage.
+func factorOf(server *Server, factors map[string]int) int {
+	result := 100
+	for _, tag := range server.Tags {
+		if f, ok := factors[tag]; ok && f < result {
+			result = f
+		}
+	}
+	return result
+}
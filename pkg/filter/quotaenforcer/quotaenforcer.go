@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package quotaenforcer
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/quotacontroller"
+	"github.com/megaease/easegress/pkg/util/consumer"
+)
+
+const (
+	// Kind is the kind of QuotaEnforcer.
+	Kind = "QuotaEnforcer"
+
+	resultNoConsumer    = "noConsumer"
+	resultQuotaExceeded = "quotaExceeded"
+)
+
+var results = []string{resultNoConsumer, resultQuotaExceeded}
+
+func init() {
+	httppipeline.Register(&QuotaEnforcer{})
+}
+
+type (
+	// QuotaEnforcer enforces a quota of a QuotaController on consumers.
+	QuotaEnforcer struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		resolve consumer.Resolver
+	}
+
+	// Spec describes the QuotaEnforcer.
+	Spec struct {
+		// Controller is the name of the QuotaController.
+		Controller string         `yaml:"controller" jsonschema:"required"`
+		Quota      string         `yaml:"quota" jsonschema:"required"`
+		Consumer   *consumer.Spec `yaml:"consumer" jsonschema:"required"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	return spec.Consumer.Validate()
+}
+
+// Kind returns the kind of QuotaEnforcer.
+func (qe *QuotaEnforcer) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of QuotaEnforcer.
+func (qe *QuotaEnforcer) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of QuotaEnforcer.
+func (qe *QuotaEnforcer) Description() string {
+	return "QuotaEnforcer rejects the requests of consumers over their quotas."
+}
+
+// Results returns the results of QuotaEnforcer.
+func (qe *QuotaEnforcer) Results() []string {
+	return results
+}
+
+// Init initializes QuotaEnforcer.
+func (qe *QuotaEnforcer) Init(filterSpec *httppipeline.FilterSpec) {
+	qe.filterSpec, qe.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	qe.resolve = consumer.NewResolver(qe.spec.Consumer)
+}
+
+// Inherit inherits previous generation of QuotaEnforcer.
+func (qe *QuotaEnforcer) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	qe.Init(filterSpec)
+}
+
+// Handle enforces the quota of the consumer.
+func (qe *QuotaEnforcer) Handle(ctx context.HTTPContext) string {
+	result := qe.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (qe *QuotaEnforcer) handle(ctx context.HTTPContext) string {
+	w := ctx.Response()
+
+	c := qe.resolve(ctx)
+	if c == "" {
+		w.SetStatusCode(http.StatusUnauthorized)
+		ctx.AddTag("quota enforcer: no consumer")
+		return resultNoConsumer
+	}
+
+	d, err := quotacontroller.Take(qe.spec.Controller, qe.spec.Quota, c)
+	if err != nil {
+		// NOTE: Requests are permitted if the quota is unavailable,
+		// rather than rejecting all of them.
+		ctx.AddTag(fmt.Sprintf("quota enforcer: %v", err))
+		return ""
+	}
+
+	d.SetHeaders(w.Header())
+	if d.Allowed {
+		return ""
+	}
+
+	retryAfter := int64(time.Until(d.Reset).Seconds() + 0.5)
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	w.SetStatusCode(http.StatusTooManyRequests)
+	ctx.AddTag("quota enforcer: quota " + qe.spec.Quota + " exceeded by " + c)
+	return resultQuotaExceeded
+}
+
+// Status returns status.
+func (qe *QuotaEnforcer) Status() interface{} {
+	return nil
+}
+
+// Close closes QuotaEnforcer.
+func (qe *QuotaEnforcer) Close() {
+}
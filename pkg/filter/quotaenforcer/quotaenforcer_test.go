@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package quotaenforcer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/quotacontroller"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newQuotaEnforcer(t *testing.T) *QuotaEnforcer {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(`
+kind: QuotaEnforcer
+name: quota-enforcer
+controller: quota
+quota: daily
+consumer:
+  source: Header
+  name: X-Consumer
+`), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	qe := &QuotaEnforcer{}
+	qe.Init(spec)
+	return qe
+}
+
+func handle(qe *QuotaEnforcer, consumer string) (string, int, http.Header) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if consumer != "" {
+		req.Header.Set("X-Consumer", consumer)
+	}
+	w := httptest.NewRecorder()
+
+	result := qe.Handle(contexttest.NewMockedHTTPContext(req, w))
+	return result, w.Code, w.Header()
+}
+
+func TestQuotaEnforcer(t *testing.T) {
+	qe := newQuotaEnforcer(t)
+	defer qe.Close()
+
+	// Requests are permitted if the controller doesn't exist.
+	if result, _, _ := handle(qe, "alice"); result != "" {
+		t.Errorf("unexpected result %s", result)
+	}
+
+	spec, err := supervisor.NewSpec(`
+kind: QuotaController
+name: quota
+quotas:
+- name: daily
+  window: day
+  limit: 2
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	qc := &quotacontroller.QuotaController{}
+	qc.Init(spec)
+	defer qc.Close()
+
+	if result, code, _ := handle(qe, ""); result != resultNoConsumer || code != http.StatusUnauthorized {
+		t.Errorf("anonymous: unexpected result %s, code %d", result, code)
+	}
+
+	result, _, header := handle(qe, "alice")
+	if result != "" {
+		t.Errorf("unexpected result %s", result)
+	}
+	if header.Get("X-RateLimit-Limit") != "2" || header.Get("X-RateLimit-Remaining") != "1" || header.Get("X-RateLimit-Reset") == "" {
+		t.Errorf("unexpected headers %v", header)
+	}
+
+	handle(qe, "alice")
+	result, code, header := handle(qe, "alice")
+	if result != resultQuotaExceeded || code != http.StatusTooManyRequests {
+		t.Errorf("unexpected result %s, code %d", result, code)
+	}
+	if header.Get("X-RateLimit-Remaining") != "0" || header.Get("Retry-After") == "" {
+		t.Errorf("unexpected headers %v", header)
+	}
+
+	// The quota of a consumer is not affected by others.
+	if result, _, _ := handle(qe, "bob"); result != "" {
+		t.Errorf("bob: unexpected result %s", result)
+	}
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/sharedstate"
+)
+
+const sharedStateNamespace = "ratelimiter"
+
+type (
+	// clusterLimiter limits the requests of all members by a fixed window
+	// counter in the shared state. To avoid accessing the shared state
+	// by every request, members lease the permissions from the counter
+	// in batches, the leased but unused permissions of a window are
+	// wasted, so the limit is never exceeded but could be under reached.
+	clusterLimiter struct {
+		store  func() (sharedstate.Store, error)
+		prefix string
+		limit  int64
+		batch  int64
+		period time.Duration
+		// maxKeys is the max number of keys in a window, the requests
+		// with new keys beyond it are limited by the empty key.
+		maxKeys int
+
+		// mutex guards the keys only, the windows of a key are guarded
+		// by the mutex of the key, so the requests of a key waiting for
+		// the shared state don't block the requests of other keys.
+		mutex sync.Mutex
+		// windowIndex is the index of the latest window seen.
+		windowIndex int64
+		keys        map[string]*clusterKey
+	}
+
+	// clusterKey is the leased permissions of a key, of the current
+	// window and the next one, for the requests waiting for it.
+	clusterKey struct {
+		// lastIndex is the index of the window the key is last accessed,
+		// it's guarded by the mutex of the limiter.
+		lastIndex int64
+
+		mutex   sync.Mutex
+		current clusterWindow
+		next    clusterWindow
+	}
+
+	clusterWindow struct {
+		index   int64
+		permits int64
+		// exhausted is true if the permissions of all members are leased.
+		exhausted bool
+	}
+)
+
+func newClusterLimiter(store func() (sharedstate.Store, error), prefix string, policy *Policy, maxKeys int) *clusterLimiter {
+	cl := &clusterLimiter{
+		store:   store,
+		prefix:  prefix,
+		limit:   int64(policy.LimitForPeriod),
+		batch:   int64(policy.ClusterBatchSize),
+		keys:    make(map[string]*clusterKey),
+		maxKeys: maxKeys,
+	}
+	cl.period, _ = time.ParseDuration(policy.LimitRefreshPeriod)
+
+	if cl.limit == 0 {
+		cl.limit = 50
+	}
+	if cl.batch == 0 {
+		// NOTE: It takes about 100 accesses to the shared state to use up
+		// the permissions of a window.
+		cl.batch = cl.limit / 100
+	}
+	if cl.batch < 1 {
+		cl.batch = 1
+	}
+	if cl.batch > cl.limit {
+		cl.batch = cl.limit
+	}
+	return cl
+}
+
+// acquirePermission acquires a permission of the key, it returns the
+// duration to wait if the permission is of the next window, which is
+// acquired only if the duration doesn't exceed timeout. The permission
+// is granted if the shared state is unavailable, along with the error.
+func (cl *clusterLimiter) acquirePermission(key string, timeout time.Duration) (bool, time.Duration, error) {
+	now := time.Now().UnixNano()
+	index := now / int64(cl.period)
+	key, k := cl.getKey(key, index)
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.current.index != index {
+		if k.next.index == index {
+			k.current = k.next
+		} else {
+			k.current = clusterWindow{index: index}
+		}
+		k.next = clusterWindow{index: index + 1}
+	}
+
+	permitted, err := cl.take(key, &k.current)
+	if err != nil || permitted {
+		return true, 0, err
+	}
+
+	d := time.Duration((index+1)*int64(cl.period) - now)
+	if d > timeout {
+		return false, 0, nil
+	}
+	permitted, err = cl.take(key, &k.next)
+	if err != nil {
+		return true, 0, err
+	}
+	if permitted {
+		return true, d, nil
+	}
+	return false, 0, nil
+}
+
+// getKey gets the key to limit the request and its permissions, which are
+// created if missing.
+func (cl *clusterLimiter) getKey(key string, index int64) (string, *clusterKey) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	if index > cl.windowIndex {
+		cl.windowIndex = index
+		cl.prune(index)
+	}
+
+	k := cl.keys[key]
+	if k == nil {
+		if len(cl.keys) >= cl.maxKeys {
+			key, k = "", cl.keys[""]
+		}
+		if k == nil {
+			k = &clusterKey{}
+			cl.keys[key] = k
+		}
+	}
+	if index > k.lastIndex {
+		k.lastIndex = index
+	}
+	return key, k
+}
+
+// take takes a permission of the window, the permissions are leased from
+// the shared state if there are none left locally.
+func (cl *clusterLimiter) take(key string, w *clusterWindow) (bool, error) {
+	if w.permits == 0 && !w.exhausted {
+		store, err := cl.store()
+		if err != nil {
+			return false, err
+		}
+
+		counterKey := fmt.Sprintf("%s/%s/%d", cl.prefix, key, w.index)
+		// NOTE: The counter expires after the next window, so the members
+		// with slightly different clocks still see it.
+		total, err := store.Incr(counterKey, cl.batch, 2*cl.period)
+		if err != nil {
+			return false, err
+		}
+
+		leased := cl.batch
+		if over := total - cl.limit; over > 0 {
+			leased -= over
+			if leased < 0 {
+				leased = 0
+			}
+		}
+		w.permits = leased
+		w.exhausted = total >= cl.limit
+	}
+
+	if w.permits == 0 {
+		return false, nil
+	}
+	w.permits--
+	return true, nil
+}
+
+// prune removes the keys not accessed in the last window. The requests
+// holding a removed key still work, the permissions leased by them are
+// wasted at most.
+func (cl *clusterLimiter) prune(index int64) {
+	for key, k := range cl.keys {
+		if k.lastIndex < index-1 {
+			delete(cl.keys, key)
+		}
+	}
+}
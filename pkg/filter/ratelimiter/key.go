@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	jwtgo "github.com/golang-jwt/jwt"
+
+	"github.com/megaease/easegress/pkg/context"
+	librl "github.com/megaease/easegress/pkg/util/ratelimiter"
+)
+
+const defaultMaxKeys = 10000
+
+type (
+	// keyData is the data of key templates, e.g. {{.ClientIP}},
+	// {{.JWT.sub}}, {{index .Header "X-Api-Key"}} and {{.Query.key}}.
+	// The fields are methods, so they are evaluated only if they're
+	// referenced by the template.
+	keyData struct {
+		r context.HTTPRequest
+	}
+
+	// keyedLimiters is the rate limiters of the keys, the least recently
+	// used keys are evicted beyond maxKeys.
+	keyedLimiters struct {
+		policy  *librl.Policy
+		maxKeys int
+
+		mutex    sync.Mutex
+		lru      *list.List
+		limiters map[string]*list.Element
+	}
+
+	keyedLimiter struct {
+		key string
+		rl  *librl.RateLimiter
+	}
+)
+
+func newKeyTemplate(text string) (*template.Template, error) {
+	return template.New("key").Option("missingkey=zero").Parse(text)
+}
+
+// renderKey renders the key of the request, the requests with errors
+// share the empty key.
+func renderKey(t *template.Template, r context.HTTPRequest) string {
+	buff := bytes.NewBuffer(nil)
+	if err := t.Execute(buff, &keyData{r: r}); err != nil {
+		return ""
+	}
+	return buff.String()
+}
+
+// Method returns the method of the request.
+func (d *keyData) Method() string {
+	return d.r.Method()
+}
+
+// Host returns the host of the request.
+func (d *keyData) Host() string {
+	return d.r.Host()
+}
+
+// Path returns the path of the request.
+func (d *keyData) Path() string {
+	return d.r.Path()
+}
+
+// ClientIP returns the real IP of the client.
+func (d *keyData) ClientIP() string {
+	return d.r.RealIP()
+}
+
+// Header returns the first values of the headers.
+func (d *keyData) Header() map[string]string {
+	m := map[string]string{}
+	for key, values := range d.r.Header().Std() {
+		if len(values) > 0 {
+			m[key] = values[0]
+		}
+	}
+	return m
+}
+
+// Query returns the first values of the query parameters.
+func (d *keyData) Query() map[string]string {
+	m := map[string]string{}
+	query, _ := url.ParseQuery(d.r.Query())
+	for key, values := range query {
+		if len(values) > 0 {
+			m[key] = values[0]
+		}
+	}
+	return m
+}
+
+// JWT returns the claims of the bearer token without verifying it, the
+// token should have been verified by the Validator filter.
+func (d *keyData) JWT() map[string]string {
+	m := map[string]string{}
+	fields := strings.Fields(d.r.Header().Get("Authorization"))
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "Bearer") {
+		return m
+	}
+
+	claims := jwtgo.MapClaims{}
+	if _, _, err := new(jwtgo.Parser).ParseUnverified(fields[1], claims); err != nil {
+		return m
+	}
+	for key, value := range claims {
+		switch v := value.(type) {
+		case string:
+			m[key] = v
+		case float64:
+			m[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			b, _ := json.Marshal(v)
+			m[key] = string(b)
+		}
+	}
+	return m
+}
+
+func newKeyedLimiters(policy *librl.Policy, maxKeys int) *keyedLimiters {
+	return &keyedLimiters{
+		policy:   policy,
+		maxKeys:  maxKeys,
+		lru:      list.New(),
+		limiters: make(map[string]*list.Element),
+	}
+}
+
+// get gets the rate limiter of the key, it's created if missing.
+func (kl *keyedLimiters) get(key string) *librl.RateLimiter {
+	kl.mutex.Lock()
+	defer kl.mutex.Unlock()
+
+	if e := kl.limiters[key]; e != nil {
+		kl.lru.MoveToFront(e)
+		return e.Value.(*keyedLimiter).rl
+	}
+
+	l := &keyedLimiter{key: key, rl: librl.New(kl.policy)}
+	kl.limiters[key] = kl.lru.PushFront(l)
+	for kl.lru.Len() > kl.maxKeys {
+		e := kl.lru.Back()
+		kl.lru.Remove(e)
+		delete(kl.limiters, e.Value.(*keyedLimiter).key)
+	}
+	return l.rl
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package ratelimiter
+
+import (
+	"io"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	librl "github.com/megaease/easegress/pkg/util/ratelimiter"
+)
+
+// meteredBody deducts the bytes of the response body from the rate limiter
+// as they're read, and slows down the reading if the limit is reached, so
+// streaming responses, e.g. downloads and server-sent events, are sent at
+// the rate of the limit.
+type meteredBody struct {
+	ctx   context.HTTPContext
+	body  io.Reader
+	meter *librl.RateLimiter
+	// chunk is the max bytes of a read, which is the limit of a period,
+	// so the body is sent smoothly rather than in bursts.
+	chunk int
+}
+
+func newMeteredBody(ctx context.HTTPContext, body io.Reader, meter *librl.RateLimiter, chunk int) *meteredBody {
+	return &meteredBody{ctx: ctx, body: body, meter: meter, chunk: chunk}
+}
+
+func (mb *meteredBody) Read(p []byte) (int, error) {
+	if len(p) > mb.chunk {
+		p = p[:mb.chunk]
+	}
+
+	n, err := mb.body.Read(p)
+	if n <= 0 {
+		return n, err
+	}
+
+	d := mb.meter.Consume(n)
+	if d <= 0 {
+		return n, err
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return n, err
+	case <-mb.ctx.Done():
+		// NOTE: The client is gone, the rest of the body is discarded.
+		return n, io.ErrUnexpectedEOF
+	}
+}
+
+func (mb *meteredBody) Close() error {
+	if closer, ok := mb.body.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
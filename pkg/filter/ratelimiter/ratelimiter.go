@@ -21,11 +21,13 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
+	"text/template"
 	"time"
 
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/sharedstate"
 	librl "github.com/megaease/easegress/pkg/util/ratelimiter"
 	"github.com/megaease/easegress/pkg/util/urlrule"
 )
@@ -34,6 +36,12 @@ const (
 	// Kind is the kind of RateLimiter.
 	Kind              = "RateLimiter"
 	resultRateLimited = "rateLimited"
+
+	modeLocal   = "local"
+	modeCluster = "cluster"
+
+	unitRequests = "requests"
+	unitBytes    = "bytes"
 )
 
 var results = []string{resultRateLimited}
@@ -49,6 +57,19 @@ type (
 		TimeoutDuration    string `yaml:"timeoutDuration" jsonschema:"omitempty,format=duration"`
 		LimitRefreshPeriod string `yaml:"limitRefreshPeriod" jsonschema:"omitempty,format=duration"`
 		LimitForPeriod     int    `yaml:"limitForPeriod" jsonschema:"omitempty,minimum=1"`
+		// Mode is local or cluster, the limit of the cluster mode is for
+		// the requests of all members.
+		Mode             string `yaml:"mode" jsonschema:"omitempty,enum=,enum=local,enum=cluster"`
+		ClusterBatchSize int    `yaml:"clusterBatchSize" jsonschema:"omitempty"`
+		// Key is a template of the request attributes, the requests of
+		// each key are limited separately, all requests are limited
+		// together if it's empty.
+		Key     string `yaml:"key" jsonschema:"omitempty"`
+		MaxKeys int    `yaml:"maxKeys" jsonschema:"omitempty"`
+		// Unit is requests or bytes, the limit of the bytes unit is for
+		// the bytes of response bodies, which are deducted as the bodies
+		// are sent, and the bodies are slowed down if it's reached.
+		Unit string `yaml:"unit" jsonschema:"omitempty,enum=,enum=requests,enum=bytes"`
 	}
 
 	// URLRule defines the rate limiter rule for a URL pattern
@@ -56,6 +77,10 @@ type (
 		urlrule.URLRule `yaml:",inline"`
 		policy          *Policy
 		rl              *librl.RateLimiter
+		kl              *keyedLimiters
+		cl              *clusterLimiter
+		key             *template.Template
+		timeout         time.Duration
 	}
 
 	// Spec is the configuration of a rate limiter
@@ -90,9 +115,62 @@ URLLoop:
 		return fmt.Errorf("policy '%s' is not defined", name)
 	}
 
+	for _, p := range spec.Policies {
+		// NOTE: Zero means the defaults, so they're not validated by
+		// the minimum of the schema.
+		if p.MaxKeys < 0 || p.ClusterBatchSize < 0 {
+			return fmt.Errorf("policy '%s': maxKeys and clusterBatchSize can't be negative", p.Name)
+		}
+
+		if p.Key != "" {
+			if _, err := newKeyTemplate(p.Key); err != nil {
+				return fmt.Errorf("policy '%s': invalid key: %v", p.Name, err)
+			}
+		}
+
+		if p.Unit == unitBytes {
+			if p.Mode == modeCluster {
+				return fmt.Errorf("policy '%s': bytes unit doesn't support cluster mode", p.Name)
+			}
+			if p.LimitForPeriod == 0 {
+				return fmt.Errorf("policy '%s': limitForPeriod is required by bytes unit", p.Name)
+			}
+		}
+
+		if p.Mode != modeCluster {
+			if p.ClusterBatchSize != 0 {
+				return fmt.Errorf("policy '%s': clusterBatchSize is for cluster mode only", p.Name)
+			}
+			continue
+		}
+
+		// NOTE: The windows of the cluster mode are shared by members, so
+		// they shouldn't be too short for the latency of the shared state
+		// and the difference of the clocks.
+		d, _ := time.ParseDuration(p.LimitRefreshPeriod)
+		if d < time.Second {
+			return fmt.Errorf("policy '%s': limitRefreshPeriod of cluster mode must be at least 1s", p.Name)
+		}
+	}
+
 	return nil
 }
 
+func (url *URLRule) timeoutDuration() time.Duration {
+	if d := url.policy.TimeoutDuration; d != "" {
+		timeout, _ := time.ParseDuration(d)
+		return timeout
+	}
+	return 100 * time.Millisecond
+}
+
+func (url *URLRule) maxKeys() int {
+	if url.policy.MaxKeys != 0 {
+		return url.policy.MaxKeys
+	}
+	return defaultMaxKeys
+}
+
 func (url *URLRule) createRateLimiter() {
 	policy := librl.Policy{
 		LimitForPeriod: url.policy.LimitForPeriod,
@@ -102,10 +180,11 @@ func (url *URLRule) createRateLimiter() {
 		policy.LimitForPeriod = 50
 	}
 
-	if d := url.policy.TimeoutDuration; d != "" {
-		policy.TimeoutDuration, _ = time.ParseDuration(d)
-	} else {
-		policy.TimeoutDuration = 100 * time.Millisecond
+	policy.TimeoutDuration = url.timeoutDuration()
+	if url.policy.Unit == unitBytes {
+		// NOTE: Bodies are slowed down rather than waiting for permission,
+		// so no bytes are reserved in advance.
+		policy.TimeoutDuration = 0
 	}
 
 	if d := url.policy.LimitRefreshPeriod; d != "" {
@@ -114,6 +193,10 @@ func (url *URLRule) createRateLimiter() {
 		policy.LimitRefreshPeriod = 10 * time.Millisecond
 	}
 
+	if url.key != nil {
+		url.kl = newKeyedLimiters(&policy, url.maxKeys())
+		return
+	}
 	url.rl = librl.New(&policy)
 }
 
@@ -165,8 +248,28 @@ func (rl *RateLimiter) bindPolicyToURL(u *URLRule) {
 func (rl *RateLimiter) createRateLimiterForURL(u *URLRule) {
 	u.Init()
 	rl.bindPolicyToURL(u)
+	u.timeout = u.timeoutDuration()
+	if u.policy.Key != "" {
+		u.key, _ = newKeyTemplate(u.policy.Key)
+	}
+	if u.policy.Mode == modeCluster {
+		prefix := fmt.Sprintf("%s/%s/%s", rl.filterSpec.Pipeline(), rl.filterSpec.Name(), u.ID())
+		u.cl = newClusterLimiter(rl.sharedStore, prefix, u.policy, u.maxKeys())
+		return
+	}
 	u.createRateLimiter()
-	rl.setStateListenerForURL(u)
+	if u.rl != nil {
+		rl.setStateListenerForURL(u)
+	}
+}
+
+func (rl *RateLimiter) sharedStore() (sharedstate.Store, error) {
+	// NOTE: The store is got every time, as it's invalid after the
+	// provider of shared state is replaced.
+	if super := rl.filterSpec.Super(); super != nil {
+		return sharedstate.Namespace(super.Cluster(), sharedStateNamespace)
+	}
+	return sharedstate.Namespace(nil, sharedStateNamespace)
 }
 
 func isSamePolicy(spec1, spec2 *Spec, policyName string) bool {
@@ -215,9 +318,12 @@ OuterLoop:
 
 			url.Init()
 			rl.bindPolicyToURL(url)
-			url.rl = prev.rl
-			prev.rl = nil
-			rl.setStateListenerForURL(url)
+			url.timeout, url.key = prev.timeout, prev.key
+			url.rl, url.kl, url.cl = prev.rl, prev.kl, prev.cl
+			prev.rl, prev.kl, prev.cl = nil, nil, nil
+			if url.rl != nil {
+				rl.setStateListenerForURL(url)
+			}
 			continue OuterLoop
 		}
 		rl.createRateLimiterForURL(url)
@@ -238,22 +344,56 @@ func (rl *RateLimiter) Inherit(filterSpec *httppipeline.FilterSpec, previousGene
 
 // Handle handles HTTP request
 func (rl *RateLimiter) Handle(ctx context.HTTPContext) string {
-	result := rl.handle(ctx)
-	return ctx.CallNextHandler(result)
+	result, u, meter := rl.handle(ctx)
+	result = ctx.CallNextHandler(result)
+	if meter != nil {
+		w := ctx.Response()
+		if body := w.Body(); body != nil {
+			w.SetBody(newMeteredBody(ctx, body, meter, u.policy.LimitForPeriod))
+		}
+	}
+	return result
 }
 
-func (rl *RateLimiter) handle(ctx context.HTTPContext) string {
+// handle limits the request, it returns the matched URL and the rate
+// limiter of the response bytes if the policy of the URL is of the bytes
+// unit.
+func (rl *RateLimiter) handle(ctx context.HTTPContext) (string, *URLRule, *librl.RateLimiter) {
 	for _, u := range rl.spec.URLs {
 		if !u.Match(ctx.Request()) {
 			continue
 		}
 
-		permitted, d := u.rl.AcquirePermission()
+		if u.policy.Unit == unitBytes {
+			meter := u.rl
+			if u.kl != nil {
+				meter = u.kl.get(u.renderKey(ctx))
+			}
+			// NOTE: The bytes are deducted after they're sent, so the
+			// request is rejected only if the limit has been reached.
+			if meter.Remaining() <= 0 {
+				return rl.reject(ctx), nil, nil
+			}
+			return "", u, meter
+		}
+
+		var permitted bool
+		var d time.Duration
+		if u.cl != nil {
+			var err error
+			permitted, d, err = u.cl.acquirePermission(u.renderKey(ctx), u.timeout)
+			if err != nil {
+				// NOTE: Requests are permitted if the shared state is
+				// unavailable, rather than rejecting all of them.
+				ctx.AddTag(fmt.Sprintf("rateLimiter: shared state unavailable: %v", err))
+			}
+		} else if u.kl != nil {
+			permitted, d = u.kl.get(u.renderKey(ctx)).AcquirePermission()
+		} else {
+			permitted, d = u.rl.AcquirePermission()
+		}
 		if !permitted {
-			ctx.AddTag("rateLimiter: too many requests")
-			ctx.Response().SetStatusCode(http.StatusTooManyRequests)
-			ctx.Response().Std().Header().Set("X-EG-Rate-Limiter", "too-many-requests")
-			return resultRateLimited
+			return rl.reject(ctx), nil, nil
 		}
 
 		if d <= 0 {
@@ -264,13 +404,27 @@ func (rl *RateLimiter) handle(ctx context.HTTPContext) string {
 		select {
 		case <-ctx.Done():
 			timer.Stop()
-			return ""
+			return "", nil, nil
 		case <-timer.C:
 			ctx.AddTag(fmt.Sprintf("rateLimiter: waiting duration: %s", d.String()))
-			return ""
+			return "", nil, nil
 		}
 	}
-	return ""
+	return "", nil, nil
+}
+
+func (rl *RateLimiter) reject(ctx context.HTTPContext) string {
+	ctx.AddTag("rateLimiter: too many requests")
+	ctx.Response().SetStatusCode(http.StatusTooManyRequests)
+	ctx.Response().Std().Header().Set("X-EG-Rate-Limiter", "too-many-requests")
+	return resultRateLimited
+}
+
+func (url *URLRule) renderKey(ctx context.HTTPContext) string {
+	if url.key == nil {
+		return ""
+	}
+	return renderKey(url.key, ctx.Request())
 }
 
 // Status returns Status generated by Runtime.
@@ -0,0 +1,419 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jwtgo "github.com/golang-jwt/jwt"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/sharedstate"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+// mockStore is a shared state which counts the calls of Incr, and blocks
+// the calls of the keys in blocked until the channels are closed, entered
+// is notified when a call is blocked.
+type mockStore struct {
+	sharedstate.Store
+
+	mutex    sync.Mutex
+	counters map[string]int64
+	incrs    int
+	blocked  map[string]chan struct{}
+	entered  chan struct{}
+}
+
+func newMockStore() *mockStore {
+	return &mockStore{
+		counters: map[string]int64{},
+		blocked:  map[string]chan struct{}{},
+		entered:  make(chan struct{}, 1),
+	}
+}
+
+func (s *mockStore) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	s.mutex.Lock()
+	ch := s.blocked[strings.Split(key, "/")[1]]
+	s.mutex.Unlock()
+	if ch != nil {
+		s.entered <- struct{}{}
+		<-ch
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.incrs++
+	s.counters[key] += delta
+	return s.counters[key], nil
+}
+
+func newTestClusterLimiter(store *mockStore, limit, batch, maxKeys int) *clusterLimiter {
+	policy := &Policy{
+		LimitForPeriod:     limit,
+		LimitRefreshPeriod: "1h",
+		ClusterBatchSize:   batch,
+	}
+	return newClusterLimiter(func() (sharedstate.Store, error) {
+		return store, nil
+	}, "prefix", policy, maxKeys)
+}
+
+func TestClusterLimiter(t *testing.T) {
+	store := newMockStore()
+	// Two limiters sharing the store work like two members.
+	members := []*clusterLimiter{
+		newTestClusterLimiter(store, 10, 3, 10),
+		newTestClusterLimiter(store, 10, 3, 10),
+	}
+
+	permits := 0
+	for i := 0; i < 20; i++ {
+		permitted, d, err := members[i%2].acquirePermission("", 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if d != 0 {
+			t.Fatalf("want no waiting, got %s", d)
+		}
+		if permitted {
+			permits++
+		}
+	}
+
+	// The leased but unused permissions are wasted, so the limit could be
+	// under reached but never exceeded.
+	if permits > 10 || permits < 7 {
+		t.Errorf("want permits in [7, 10], got %d", permits)
+	}
+	// The permissions are leased in batches.
+	if store.incrs > 6 {
+		t.Errorf("want at most 6 accesses to the shared state, got %d", store.incrs)
+	}
+}
+
+func TestClusterLimiterUnavailable(t *testing.T) {
+	cl := newClusterLimiter(func() (sharedstate.Store, error) {
+		return nil, fmt.Errorf("unavailable")
+	}, "prefix", &Policy{LimitForPeriod: 1, LimitRefreshPeriod: "1h"}, 10)
+
+	for i := 0; i < 3; i++ {
+		permitted, _, err := cl.acquirePermission("", 0)
+		if !permitted || err == nil {
+			t.Fatalf("want permitted with error, got %v, %v", permitted, err)
+		}
+	}
+}
+
+func TestClusterLimiterKeys(t *testing.T) {
+	store := newMockStore()
+	cl := newTestClusterLimiter(store, 1, 1, 2)
+
+	// The key waiting for the shared state doesn't block other keys.
+	store.blocked["slow"] = make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if permitted, _, _ := cl.acquirePermission("slow", 0); !permitted {
+			t.Errorf("slow should be permitted")
+		}
+	}()
+	<-store.entered
+
+	chFast := make(chan bool)
+	go func() {
+		permitted, _, _ := cl.acquirePermission("fast", 0)
+		chFast <- permitted
+	}()
+	select {
+	case permitted := <-chFast:
+		if !permitted {
+			t.Errorf("fast should be permitted")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("fast is blocked by slow")
+	}
+
+	close(store.blocked["slow"])
+	<-done
+
+	if permitted, _, _ := cl.acquirePermission("fast", 0); permitted {
+		t.Errorf("fast should be limited")
+	}
+
+	// The keys beyond maxKeys share the empty key.
+	if permitted, _, _ := cl.acquirePermission("a", 0); !permitted {
+		t.Errorf("a should be permitted by the empty key")
+	}
+	if permitted, _, _ := cl.acquirePermission("b", 0); permitted {
+		t.Errorf("b should be limited by the empty key")
+	}
+	if _, ok := store.counters["prefix/a/"+fmt.Sprint(cl.windowIndex)]; ok {
+		t.Errorf("a should not be counted separately")
+	}
+}
+
+func newRateLimiter(t *testing.T, yamlSpec string) *RateLimiter {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rl := &RateLimiter{}
+	rl.Init(spec)
+	return rl
+}
+
+func newContext(header http.Header, query string, respBody string) context.HTTPContext {
+	stdr := httptest.NewRequest(http.MethodGet, "http://example.com/api?"+query, nil)
+	for k, v := range header {
+		stdr.Header[k] = v
+	}
+	ctx := context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		if lastResult == "" && respBody != "" {
+			ctx.Response().SetBody(strings.NewReader(respBody))
+		}
+		return lastResult
+	})
+	return ctx
+}
+
+func TestValidate(t *testing.T) {
+	for i, policy := range []string{
+		"{name: p, key: '{{.ClientIP'}",
+		"{name: p, maxKeys: -1}",
+		"{name: p, clusterBatchSize: 10}",
+		"{name: p, mode: cluster, limitRefreshPeriod: 100ms}",
+		"{name: p, unit: bytes}",
+		"{name: p, unit: bytes, limitForPeriod: 10, mode: cluster, limitRefreshPeriod: 1s}",
+	} {
+		yamlSpec := fmt.Sprintf(`
+kind: RateLimiter
+name: rl
+policies: [%s]
+defaultPolicyRef: p
+urls: [{url: {prefix: /}}]
+`, policy)
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("case %d: spec should be invalid", i)
+		}
+	}
+}
+
+func TestRenderKey(t *testing.T) {
+	token, _ := jwtgo.NewWithClaims(jwtgo.SigningMethodHS256, jwtgo.MapClaims{
+		"sub": "alice",
+		"n":   3,
+	}).SignedString([]byte("secret"))
+	header := http.Header{}
+	header.Set("X-Api-Key", "key1")
+	header.Set("Authorization", "Bearer "+token)
+	ctx := newContext(header, "key=q1", "")
+
+	for _, c := range []struct {
+		template string
+		key      string
+	}{
+		{`{{.Method}} {{.Host}}{{.Path}}`, "GET example.com/api"},
+		{`{{.ClientIP}}`, "192.0.2.1"},
+		{`{{index .Header "X-Api-Key"}}`, "key1"},
+		{`{{.Query.key}}`, "q1"},
+		{`{{.JWT.sub}}/{{.JWT.n}}`, "alice/3"},
+		// Missing attributes are empty.
+		{`{{.Query.missing}}{{index .Header "X-Missing"}}`, ""},
+		// The requests with errors share the empty key.
+		{`{{.Unknown}}`, ""},
+	} {
+		tmpl, err := newKeyTemplate(c.template)
+		if err != nil {
+			t.Fatalf("parse %s failed: %v", c.template, err)
+		}
+		if key := renderKey(tmpl, ctx.Request()); key != c.key {
+			t.Errorf("%s: want %q, got %q", c.template, c.key, key)
+		}
+	}
+
+	// The JWT is optional.
+	tmpl, _ := newKeyTemplate(`{{.JWT.sub}}`)
+	if key := renderKey(tmpl, newContext(nil, "", "").Request()); key != "" {
+		t.Errorf("want empty key, got %q", key)
+	}
+}
+
+func TestKeyedLimit(t *testing.T) {
+	rl := newRateLimiter(t, `
+kind: RateLimiter
+name: rl
+policies:
+- name: p
+  key: '{{index .Header "X-Api-Key"}}'
+  maxKeys: 2
+  timeoutDuration: 0s
+  limitRefreshPeriod: 1h
+  limitForPeriod: 1
+defaultPolicyRef: p
+urls: [{url: {prefix: /}}]
+`)
+
+	for i, c := range []struct {
+		key    string
+		result string
+	}{
+		{"a", ""},
+		{"a", resultRateLimited},
+		{"b", ""},
+		{"b", resultRateLimited},
+		// a is evicted as the least recently used key beyond maxKeys.
+		{"c", ""},
+		{"a", ""},
+	} {
+		header := http.Header{}
+		header.Set("X-Api-Key", c.key)
+		ctx := newContext(header, "", "")
+		if result := rl.Handle(ctx); result != c.result {
+			t.Errorf("case %d: want %q, got %q", i, c.result, result)
+		}
+		if c.result != "" && ctx.Response().StatusCode() != http.StatusTooManyRequests {
+			t.Errorf("case %d: want 429, got %d", i, ctx.Response().StatusCode())
+		}
+	}
+}
+
+func TestClusterMode(t *testing.T) {
+	p, err := sharedstate.New(&sharedstate.Spec{Provider: sharedstate.ProviderMemory}, nil)
+	if err != nil {
+		t.Fatalf("new provider failed: %v", err)
+	}
+	sharedstate.SetProvider(p)
+	defer func() {
+		sharedstate.ResetProvider(p)
+		p.Close()
+	}()
+
+	yamlSpec := `
+kind: RateLimiter
+name: rl
+policies:
+- name: p
+  mode: cluster
+  key: '{{.Query.user}}'
+  timeoutDuration: 0s
+  limitRefreshPeriod: 1h
+  limitForPeriod: 4
+  clusterBatchSize: 2
+defaultPolicyRef: p
+urls: [{url: {prefix: /}}]
+`
+	// The filters of the same name in two pipelines work like two members.
+	members := []*RateLimiter{newRateLimiter(t, yamlSpec), newRateLimiter(t, yamlSpec)}
+
+	for _, user := range []string{"alice", "bob"} {
+		permits := 0
+		for i := 0; i < 8; i++ {
+			ctx := newContext(nil, "user="+user, "")
+			if members[i%2].Handle(ctx) == "" {
+				permits++
+			}
+		}
+		if permits != 4 {
+			t.Errorf("%s: want 4 permits, got %d", user, permits)
+		}
+	}
+}
+
+func TestBytesUnit(t *testing.T) {
+	rl := newRateLimiter(t, `
+kind: RateLimiter
+name: rl
+policies:
+- name: p
+  unit: bytes
+  limitRefreshPeriod: 100ms
+  limitForPeriod: 10
+defaultPolicyRef: p
+urls: [{url: {prefix: /}}]
+`)
+
+	ctx := newContext(nil, "", strings.Repeat("a", 30))
+	if result := rl.Handle(ctx); result != "" {
+		t.Fatalf("want permitted, got %q", result)
+	}
+
+	// The body is sent at the rate of 10 bytes per 100ms.
+	start := time.Now()
+	body, err := ioutil.ReadAll(ctx.Response().Body())
+	if err != nil || len(body) != 30 {
+		t.Fatalf("want 30 bytes, got %d, %v", len(body), err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("want the body slowed down, but it's read in %s", d)
+	}
+
+	// The request is rejected if the bytes are beyond the limit.
+	ctx = newContext(nil, "", "a")
+	if result := rl.Handle(ctx); result != resultRateLimited {
+		t.Errorf("want rate limited, got %q", result)
+	}
+}
+
+func TestMeteredBodyClientGone(t *testing.T) {
+	rl := newRateLimiter(t, `
+kind: RateLimiter
+name: rl
+policies:
+- name: p
+  unit: bytes
+  limitRefreshPeriod: 1h
+  limitForPeriod: 10
+defaultPolicyRef: p
+urls: [{url: {prefix: /}}]
+`)
+
+	ctx := newContext(nil, "", strings.Repeat("a", 30))
+	rl.Handle(ctx)
+	ctx.Cancel(fmt.Errorf("client gone"))
+
+	body, err := ioutil.ReadAll(ctx.Response().Body())
+	if err == nil || len(body) >= 30 {
+		t.Errorf("want the body truncated, got %d bytes, %v", len(body), err)
+	}
+}
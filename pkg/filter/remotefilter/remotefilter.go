@@ -215,18 +215,15 @@ func (rf *RemoteFilter) handle(ctx context.HTTPContext) (result string) {
 	errPrefix = "marshal context"
 	ctxBuff := rf.marshalHTTPContext(ctx, reqBody, respBody)
 
-	var (
-		req *http.Request
-		err error
-	)
-
+	// NOTE: The request is cancelled with the context, e.g. when the
+	// client disconnects.
+	var reqCtx stdcontext.Context = ctx
 	if rf.spec.timeout > 0 {
-		timeoutCtx, cancelFunc := stdcontext.WithTimeout(stdcontext.Background(), rf.spec.timeout)
+		timeoutCtx, cancelFunc := stdcontext.WithTimeout(ctx, rf.spec.timeout)
 		defer cancelFunc()
-		req, err = http.NewRequestWithContext(timeoutCtx, http.MethodPost, rf.spec.URL, bytes.NewReader(ctxBuff))
-	} else {
-		req, err = http.NewRequest(http.MethodPost, rf.spec.URL, bytes.NewReader(ctxBuff))
+		reqCtx = timeoutCtx
 	}
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, rf.spec.URL, bytes.NewReader(ctxBuff))
 
 	if err != nil {
 		logger.Errorf("BUG: new request failed: %v", err)
@@ -0,0 +1,333 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package requestcoalescer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of RequestCoalescer.
+	Kind = "RequestCoalescer"
+
+	resultCoalesced = "coalesced"
+)
+
+var results = []string{resultCoalesced}
+
+// implicitKeyHeaders are always parts of the key, since the responses vary
+// by them, e.g. they may be compressed according to Accept-Encoding.
+var implicitKeyHeaders = []string{
+	httpheader.KeyAcceptEncoding,
+	httpheader.KeyRange,
+	httpheader.KeyIfRange,
+	httpheader.KeyIfNoneMatch,
+	httpheader.KeyIfModifiedSince,
+}
+
+func init() {
+	httppipeline.Register(&RequestCoalescer{})
+}
+
+type (
+	// RequestCoalescer deduplicates the concurrent identical requests, only
+	// the first one of them is handled by the following filters, and its
+	// response is shared by the others waiting for it.
+	RequestCoalescer struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		timeout time.Duration
+
+		mutex sync.Mutex
+		calls map[string]*call
+
+		leaders, coalesced, timeouts, uncoalesced uint64
+	}
+
+	// Spec describes the RequestCoalescer.
+	Spec struct {
+		Methods []string `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		// KeyHeaders are the request headers which are parts of the key
+		// besides the method, URL, Accept-Encoding and the headers of
+		// range and conditional requests.
+		// Requests with Authorization or Cookie are not coalesced unless
+		// the headers are in KeyHeaders.
+		KeyHeaders []string `yaml:"keyHeaders" jsonschema:"omitempty,uniqueItems=true"`
+		// Timeout is the maximum duration to wait for the shared response,
+		// the waiting requests are handled by themselves after it.
+		Timeout string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+		// MaxBodySize is the maximum size of the shared response bodies,
+		// the waiting requests are handled by themselves if it's exceeded.
+		MaxBodySize int64 `yaml:"maxBodySize" jsonschema:"omitempty,minimum=1"`
+	}
+
+	// Status is the status of RequestCoalescer.
+	Status struct {
+		InFlight int `yaml:"inFlight"`
+		// Leaders is the count of the requests handled for the others.
+		Leaders uint64 `yaml:"leaders"`
+		// Coalesced is the count of the requests served by the shared
+		// responses.
+		Coalesced uint64 `yaml:"coalesced"`
+		// Timeouts is the count of the requests which waited too long.
+		Timeouts uint64 `yaml:"timeouts"`
+		// Uncoalesced is the count of the requests waited for responses
+		// which can't be shared.
+		Uncoalesced uint64 `yaml:"uncoalesced"`
+	}
+
+	// call is an in-flight request, resp is set before done is closed,
+	// and it's nil if the response can't be shared.
+	call struct {
+		done chan struct{}
+		resp *response
+	}
+
+	response struct {
+		statusCode int
+		header     *httpheader.HTTPHeader
+		body       []byte
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if spec.Timeout != "" {
+		d, err := time.ParseDuration(spec.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout: %v", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("timeout must be positive")
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of RequestCoalescer.
+func (rc *RequestCoalescer) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of RequestCoalescer.
+func (rc *RequestCoalescer) DefaultSpec() interface{} {
+	return &Spec{
+		Methods:     []string{http.MethodGet, http.MethodHead},
+		Timeout:     "10s",
+		MaxBodySize: 4 * 1024 * 1024,
+	}
+}
+
+// Description returns the description of RequestCoalescer.
+func (rc *RequestCoalescer) Description() string {
+	return "RequestCoalescer shares the response of concurrent identical requests."
+}
+
+// Results returns the results of RequestCoalescer.
+func (rc *RequestCoalescer) Results() []string {
+	return results
+}
+
+// Init initializes RequestCoalescer.
+func (rc *RequestCoalescer) Init(filterSpec *httppipeline.FilterSpec) {
+	rc.filterSpec, rc.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	rc.reload()
+}
+
+// Inherit inherits previous generation of RequestCoalescer.
+func (rc *RequestCoalescer) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	rc.Init(filterSpec)
+}
+
+func (rc *RequestCoalescer) reload() {
+	rc.timeout, _ = time.ParseDuration(rc.spec.Timeout)
+	if rc.timeout <= 0 {
+		rc.timeout = 10 * time.Second
+	}
+	rc.calls = map[string]*call{}
+}
+
+// Handle handles HTTPContext.
+func (rc *RequestCoalescer) Handle(ctx context.HTTPContext) string {
+	key := rc.keyOf(ctx)
+	if key == "" {
+		return ctx.CallNextHandler("")
+	}
+
+	rc.mutex.Lock()
+	if c, exists := rc.calls[key]; exists {
+		rc.mutex.Unlock()
+		return rc.wait(ctx, c)
+	}
+	c := &call{done: make(chan struct{})}
+	rc.calls[key] = c
+	rc.mutex.Unlock()
+
+	atomic.AddUint64(&rc.leaders, 1)
+
+	// NOTE: The waiting requests must be released even if the following
+	// filters panic.
+	defer func() {
+		rc.mutex.Lock()
+		delete(rc.calls, key)
+		rc.mutex.Unlock()
+		close(c.done)
+	}()
+
+	result := ctx.CallNextHandler("")
+	c.resp = rc.capture(ctx)
+	return result
+}
+
+// keyOf returns the key of the request, it's empty if the request is not
+// coalesced.
+func (rc *RequestCoalescer) keyOf(ctx context.HTTPContext) string {
+	r := ctx.Request()
+
+	methods := rc.spec.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead}
+	}
+	if !stringtool.StrInSlice(r.Method(), methods) {
+		return ""
+	}
+
+	for _, value := range r.Header().GetAll(httpheader.KeyCacheControl) {
+		if strings.Contains(value, "no-cache") {
+			return ""
+		}
+	}
+	for _, key := range []string{httpheader.KeyAuthorization, httpheader.KeyCookie} {
+		if r.Header().Get(key) != "" && !rc.isKeyHeader(key) {
+			return ""
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(stringtool.Cat(r.Method(), " ", r.Scheme(), "://", r.Host(), r.Path(), "?", r.Query()))
+	for _, key := range append(implicitKeyHeaders, rc.spec.KeyHeaders...) {
+		sb.WriteString("\n")
+		sb.WriteString(key)
+		sb.WriteString(": ")
+		sb.WriteString(strings.Join(r.Header().GetAll(key), ", "))
+	}
+	return sb.String()
+}
+
+func (rc *RequestCoalescer) isKeyHeader(key string) bool {
+	for _, k := range rc.spec.KeyHeaders {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rc *RequestCoalescer) wait(ctx context.HTTPContext, c *call) string {
+	timer := time.NewTimer(rc.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.done:
+	case <-timer.C:
+		atomic.AddUint64(&rc.timeouts, 1)
+		ctx.AddTag("requestCoalescer: wait timeout")
+		return ctx.CallNextHandler("")
+	case <-ctx.Done():
+		return ctx.CallNextHandler("")
+	}
+
+	if c.resp == nil {
+		atomic.AddUint64(&rc.uncoalesced, 1)
+		return ctx.CallNextHandler("")
+	}
+
+	atomic.AddUint64(&rc.coalesced, 1)
+	w := ctx.Response()
+	w.SetStatusCode(c.resp.statusCode)
+	w.Header().AddFrom(c.resp.header)
+	w.SetBody(bytes.NewReader(c.resp.body))
+	ctx.AddTag("requestCoalescer: coalesced")
+	return ctx.CallNextHandler(resultCoalesced)
+}
+
+// capture reads the response body to share it, the body is restored if
+// it's too large to share.
+func (rc *RequestCoalescer) capture(ctx context.HTTPContext) *response {
+	w := ctx.Response()
+	resp := &response{statusCode: w.StatusCode(), header: w.Header().Copy()}
+	original := w.Body()
+	if original == nil {
+		return resp
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(original, rc.spec.MaxBodySize+1))
+	if err != nil || int64(len(body)) > rc.spec.MaxBodySize {
+		w.SetBody(struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), original), closerOf(original)})
+		return nil
+	}
+	if c, ok := original.(io.Closer); ok {
+		c.Close()
+	}
+	w.SetBody(bytes.NewReader(body))
+	resp.body = body
+	return resp
+}
+
+func closerOf(r io.Reader) io.Closer {
+	if c, ok := r.(io.Closer); ok {
+		return c
+	}
+	return ioutil.NopCloser(nil)
+}
+
+// Status returns status.
+func (rc *RequestCoalescer) Status() interface{} {
+	rc.mutex.Lock()
+	inFlight := len(rc.calls)
+	rc.mutex.Unlock()
+
+	return &Status{
+		InFlight:    inFlight,
+		Leaders:     atomic.LoadUint64(&rc.leaders),
+		Coalesced:   atomic.LoadUint64(&rc.coalesced),
+		Timeouts:    atomic.LoadUint64(&rc.timeouts),
+		Uncoalesced: atomic.LoadUint64(&rc.uncoalesced),
+	}
+}
+
+// Close closes RequestCoalescer.
+func (rc *RequestCoalescer) Close() {}
@@ -0,0 +1,215 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package requestcoalescer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newRequestCoalescer(t *testing.T, yamlSpec string) *RequestCoalescer {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rc := &RequestCoalescer{}
+	rc.Init(spec)
+	return rc
+}
+
+type upstream struct {
+	calls   int32
+	release chan struct{}
+	body    string
+}
+
+type exchange struct {
+	result string
+	code   int
+	body   string
+}
+
+func handle(rc *RequestCoalescer, u *upstream, method, url string, header http.Header) *exchange {
+	stdr, _ := http.NewRequest(method, url, nil)
+	for key, values := range header {
+		stdr.Header[key] = values
+	}
+
+	ctx := context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		if lastResult != "" {
+			return lastResult
+		}
+		atomic.AddInt32(&u.calls, 1)
+		<-u.release
+		ctx.Response().SetStatusCode(http.StatusOK)
+		ctx.Response().Header().Set("X-Upstream", "yes")
+		ctx.Response().SetBody(strings.NewReader(u.body))
+		return ""
+	})
+
+	e := &exchange{result: rc.Handle(ctx), code: ctx.Response().StatusCode()}
+	if body := ctx.Response().Body(); body != nil {
+		buff, _ := ioutil.ReadAll(body)
+		e.body = string(buff)
+	}
+	return e
+}
+
+// handleConcurrently handles the requests concurrently, and releases the
+// upstream after all of them are handled or waiting.
+func handleConcurrently(rc *RequestCoalescer, u *upstream, n int, method string, headerOf func(i int) http.Header) []*exchange {
+	exchanges := make([]*exchange, n)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			exchanges[i] = handle(rc, u, method, "http://example.com/items?page=1", headerOf(i))
+		}(i)
+	}
+
+	// NOTE: Give the requests time to arrive before releasing the upstream.
+	time.Sleep(100 * time.Millisecond)
+	close(u.release)
+	wg.Wait()
+	return exchanges
+}
+
+func TestValidate(t *testing.T) {
+	if (Spec{Timeout: "-1s"}).Validate() == nil {
+		t.Errorf("validate should fail")
+	}
+	if (Spec{Timeout: "1s"}).Validate() != nil {
+		t.Errorf("validate should succeed")
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	rc := newRequestCoalescer(t, `
+kind: RequestCoalescer
+name: coalescer
+`)
+	defer rc.Close()
+
+	u := &upstream{release: make(chan struct{}), body: "items"}
+	exchanges := handleConcurrently(rc, u, 10, http.MethodGet, func(i int) http.Header { return nil })
+	if calls := atomic.LoadInt32(&u.calls); calls != 1 {
+		t.Fatalf("expected 1 upstream call, got %d", calls)
+	}
+
+	coalesced := 0
+	for _, e := range exchanges {
+		if e.code != http.StatusOK || e.body != "items" {
+			t.Errorf("unexpected response %+v", e)
+		}
+		if e.result == resultCoalesced {
+			coalesced++
+		}
+	}
+	if coalesced != 9 {
+		t.Errorf("expected 9 coalesced requests, got %d", coalesced)
+	}
+
+	status := rc.Status().(*Status)
+	if status.Leaders != 1 || status.Coalesced != 9 || status.InFlight != 0 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestNotCoalesced(t *testing.T) {
+	rc := newRequestCoalescer(t, `
+kind: RequestCoalescer
+name: coalescer
+keyHeaders: [X-Tenant]
+`)
+	defer rc.Close()
+
+	// Requests of other methods, with credentials or no-cache are not
+	// coalesced, and the key headers tell requests apart.
+	cases := []struct {
+		method   string
+		headerOf func(i int) http.Header
+	}{
+		{http.MethodPost, func(i int) http.Header { return nil }},
+		{http.MethodGet, func(i int) http.Header { return http.Header{"Authorization": []string{"Bearer token"}} }},
+		{http.MethodGet, func(i int) http.Header { return http.Header{"Cache-Control": []string{"no-cache"}} }},
+		{http.MethodGet, func(i int) http.Header { return http.Header{"X-Tenant": []string{string(rune('a' + i))}} }},
+	}
+	for i, c := range cases {
+		u := &upstream{release: make(chan struct{})}
+		handleConcurrently(rc, u, 3, c.method, c.headerOf)
+		if calls := atomic.LoadInt32(&u.calls); calls != 3 {
+			t.Errorf("case %d: expected 3 upstream calls, got %d", i, calls)
+		}
+	}
+}
+
+func TestLargeBodyAndTimeout(t *testing.T) {
+	rc := newRequestCoalescer(t, `
+kind: RequestCoalescer
+name: coalescer
+maxBodySize: 4
+`)
+	defer rc.Close()
+
+	u := &upstream{release: make(chan struct{}), body: "too large"}
+	exchanges := handleConcurrently(rc, u, 3, http.MethodGet, func(i int) http.Header { return nil })
+	for _, e := range exchanges {
+		if e.body != "too large" || e.result != "" {
+			t.Errorf("unexpected response %+v", e)
+		}
+	}
+	if status := rc.Status().(*Status); status.Uncoalesced != 2 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	rc = newRequestCoalescer(t, `
+kind: RequestCoalescer
+name: coalescer
+timeout: 10ms
+`)
+	u = &upstream{release: make(chan struct{}), body: "items"}
+	handleConcurrently(rc, u, 3, http.MethodGet, func(i int) http.Header { return nil })
+	if status := rc.Status().(*Status); status.Timeouts != 2 || atomic.LoadInt32(&u.calls) != 3 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package requestsigner
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/signer"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of RequestSigner.
+	Kind = "RequestSigner"
+
+	resultSignFailed = "signFailed"
+)
+
+var results = []string{resultSignFailed}
+
+func init() {
+	httppipeline.Register(&RequestSigner{})
+}
+
+type (
+	// RequestSigner signs the requests to upstreams requiring Amazon
+	// Signature V4 or similar HMAC signatures, it is the counterpart of
+	// the signature mode of Validator.
+	RequestSigner struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		signer *signer.Signer
+
+		signed, failed uint64
+	}
+
+	// Spec describes the RequestSigner.
+	Spec struct {
+		signer.Spec `yaml:",inline"`
+
+		// Scopes are the parts of the credential scope between the date
+		// and the scope suffix, e.g. the region and the service of AWS.
+		Scopes []string `yaml:"scopes" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of RequestSigner.
+	Status struct {
+		Signed uint64 `yaml:"signed"`
+		Failed uint64 `yaml:"failed"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if spec.AccessKeyID == "" || spec.AccessKeySecret == "" {
+		return fmt.Errorf("accessKeyId and accessKeySecret are required")
+	}
+	return nil
+}
+
+// Kind returns the kind of RequestSigner.
+func (rs *RequestSigner) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of RequestSigner.
+func (rs *RequestSigner) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of RequestSigner.
+func (rs *RequestSigner) Description() string {
+	return "RequestSigner signs requests by Amazon Signature V4 compatible signatures."
+}
+
+// Results returns the results of RequestSigner.
+func (rs *RequestSigner) Results() []string {
+	return results
+}
+
+// Init initializes RequestSigner.
+func (rs *RequestSigner) Init(filterSpec *httppipeline.FilterSpec) {
+	rs.filterSpec, rs.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	rs.reload()
+}
+
+// Inherit inherits previous generation of RequestSigner.
+func (rs *RequestSigner) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	rs.Init(filterSpec)
+}
+
+func (rs *RequestSigner) reload() {
+	rs.signer = signer.CreateFromSpec(&rs.spec.Spec)
+}
+
+// Handle signs the request of HTTPContext.
+func (rs *RequestSigner) Handle(ctx context.HTTPContext) string {
+	result := rs.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (rs *RequestSigner) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+
+	// NOTE: Sign a copy of the request as it will be sent by Proxy, which
+	// uses the path, query and host of the context, but the Host header
+	// must not be signed twice.
+	header := r.Header().Std().Clone()
+	header.Del("Host")
+	stdr := &http.Request{
+		Method: r.Method(),
+		URL:    &url.URL{Scheme: r.Scheme(), Host: r.Host(), Path: r.Path(), RawQuery: r.Query()},
+		Host:   r.Host(),
+		Header: header,
+	}
+
+	if !rs.spec.ExcludeBody {
+		body, err := ioutil.ReadAll(r.Body())
+		if err != nil {
+			atomic.AddUint64(&rs.failed, 1)
+			ctx.Response().SetStatusCode(http.StatusBadRequest)
+			ctx.AddTag(stringtool.Cat("requestSigner: read body failed: ", err.Error()))
+			return resultSignFailed
+		}
+		r.SetBody(bytes.NewReader(body))
+		stdr.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	err := rs.signer.NewContext(time.Now(), rs.spec.Scopes...).Sign(stdr)
+	if err != nil {
+		atomic.AddUint64(&rs.failed, 1)
+		ctx.Response().SetStatusCode(http.StatusInternalServerError)
+		ctx.AddTag(stringtool.Cat("requestSigner: ", err.Error()))
+		return resultSignFailed
+	}
+
+	// Copy back the headers added by signing: the authorization, the
+	// request time and the payload hash.
+	for k, v := range stdr.Header {
+		if len(v) > 0 && v[0] != r.Header().Get(k) {
+			r.Header().Set(k, v[0])
+		}
+	}
+
+	atomic.AddUint64(&rs.signed, 1)
+	return ""
+}
+
+// Status returns status.
+func (rs *RequestSigner) Status() interface{} {
+	return &Status{
+		Signed: atomic.LoadUint64(&rs.signed),
+		Failed: atomic.LoadUint64(&rs.failed),
+	}
+}
+
+// Close closes RequestSigner.
+func (rs *RequestSigner) Close() {}
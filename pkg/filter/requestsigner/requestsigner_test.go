@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package requestsigner
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/signer"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newRequestSigner(t *testing.T, yamlSpec string) *RequestSigner {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rs := &RequestSigner{}
+	rs.Init(spec)
+	return rs
+}
+
+func TestValidate(t *testing.T) {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(`
+kind: RequestSigner
+name: signer
+accessKeyId: AKID
+`), &rawSpec)
+
+	if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+		t.Errorf("spec without access key secret should be invalid")
+	}
+}
+
+func TestSign(t *testing.T) {
+	verifier := signer.CreateFromSpec(&signer.Spec{
+		AccessKeys:    map[string]string{"AKID": "SECRET"},
+		SignedHeaders: []string{"X-Target"},
+	})
+
+	var verifyErr error
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verifyErr = verifier.Verify(r)
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer server.Close()
+
+	rs := newRequestSigner(t, `
+kind: RequestSigner
+name: signer
+accessKeyId: AKID
+accessKeySecret: SECRET
+signedHeaders: [X-Target]
+scopes: [us-east-1, dynamodb]
+`)
+
+	stdr, _ := http.NewRequest(http.MethodPost, "http://dynamodb.local/tables/a%20b?x=1&y=2", strings.NewReader("{}"))
+	stdr.Header.Set("X-Target", "prefix.Operation")
+	ctx := context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		return lastResult
+	})
+
+	if result := rs.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result: %s", result)
+	}
+
+	auth := ctx.Request().Header().Get("Authorization")
+	if !strings.HasPrefix(auth, "ME-HMAC-SHA256 Credential=AKID/") ||
+		!strings.Contains(auth, "/us-east-1/dynamodb/megaease_request, SignedHeaders=host;x-me-date;x-target,") {
+		t.Errorf("unexpected authorization header: %s", auth)
+	}
+
+	// send the request as Proxy does
+	r := ctx.Request()
+	req, _ := http.NewRequest(r.Method(), server.URL+r.Path()+"?"+r.Query(), r.Body())
+	req.Header = r.Header().Std()
+	req.Host = r.Host()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if verifyErr != nil {
+		t.Errorf("verification failed: %v", verifyErr)
+	}
+	if body != "{}" {
+		t.Errorf("expect body {}, got %s", body)
+	}
+
+	status := rs.Status().(*Status)
+	if status.Signed != 1 || status.Failed != 0 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package responsecache
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+// directives are the directives of Cache-Control by their lowercase names.
+// Reference: https://tools.ietf.org/html/rfc7234#section-5.2
+type directives map[string]string
+
+func parseCacheControl(values []string) directives {
+	d := directives{}
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, arg := directive, ""
+			if i := strings.IndexByte(directive, '='); i >= 0 {
+				name, arg = directive[:i], strings.Trim(directive[i+1:], `"`)
+			}
+			d[strings.ToLower(strings.TrimSpace(name))] = arg
+		}
+	}
+	return d
+}
+
+func (d directives) has(name string) bool {
+	_, exists := d[name]
+	return exists
+}
+
+// seconds returns the delta-seconds argument of the directive, a malformed
+// argument is treated as zero.
+func (d directives) seconds(name string) (time.Duration, bool) {
+	arg, exists := d[name]
+	if !exists {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(arg, 10, 32)
+	if err != nil || n < 0 {
+		return 0, true
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// parseVary returns the lowercase header names of Vary in order.
+func parseVary(values []string) []string {
+	var names []string
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// varyValues returns the values of the headers nominated by Vary.
+func varyValues(names []string, h *httpheader.HTTPHeader) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		values[name] = strings.Join(h.GetAll(name), ", ")
+	}
+	return values
+}
+
+// freshnessLifetime returns the freshness lifetime of the response, and
+// whether it's explicit, the heuristic lifetime is used otherwise.
+// Reference: https://tools.ietf.org/html/rfc7234#section-4.2.1
+func freshnessLifetime(cc directives, h *httpheader.HTTPHeader, heuristic time.Duration) (time.Duration, bool) {
+	if lifetime, exists := cc.seconds("s-maxage"); exists {
+		return lifetime, true
+	}
+	if lifetime, exists := cc.seconds("max-age"); exists {
+		return lifetime, true
+	}
+	if expires := h.Get(httpheader.KeyExpires); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			// NOTE: An invalid Expires means it's expired already.
+			return 0, true
+		}
+		date, err := http.ParseTime(h.Get(httpheader.KeyDate))
+		if err != nil {
+			date = time.Now()
+		}
+		return expiresAt.Sub(date), true
+	}
+	return heuristic, false
+}
+
+// heuristicCodes are the status codes cacheable by default.
+// Reference: https://tools.ietf.org/html/rfc7231#section-6.1
+var heuristicCodes = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// notModified reports whether the conditional request is satisfied by the
+// validators of the stored response.
+// Reference: https://tools.ietf.org/html/rfc7232#section-6
+func notModified(r *httpheader.HTTPHeader, etag, lastModified string) bool {
+	if inm := r.Get(httpheader.KeyIfNoneMatch); inm != "" {
+		if etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, err := http.ParseTime(r.Get(httpheader.KeyIfModifiedSince))
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(lastModified)
+	return err == nil && !lm.After(ims)
+}
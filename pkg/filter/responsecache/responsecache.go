@@ -0,0 +1,517 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package responsecache
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/sharedstate"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of ResponseCache.
+	Kind = "ResponseCache"
+
+	// StorageMemory stores the responses in the memory of every member.
+	StorageMemory = "memory"
+	// StorageShared stores the responses in the shared state of the cluster.
+	StorageShared = "shared"
+
+	resultCached = "cached"
+)
+
+var results = []string{resultCached}
+
+func init() {
+	httppipeline.Register(&ResponseCache{})
+}
+
+type (
+	// ResponseCache is a shared HTTP cache following RFC 7234, it serves
+	// the stored responses while they're fresh, and revalidates them by
+	// conditional requests once they're stale.
+	ResponseCache struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		storage              storage
+		defaultTTL           time.Duration
+		maxTTL               time.Duration
+		staleWhileRevalidate time.Duration
+		now                  func() time.Time
+
+		mutex        sync.Mutex
+		revalidating map[string]struct{}
+
+		hits          uint64
+		staleHits     uint64
+		misses        uint64
+		revalidated   uint64
+		stores        uint64
+		invalidations uint64
+		errors        uint64
+	}
+
+	// Spec describes the ResponseCache.
+	Spec struct {
+		// Storage is memory or shared, the shared storage is the etcd of
+		// the cluster, or Redis if it's the provider of SharedStateProvider.
+		Storage string `yaml:"storage" jsonschema:"omitempty,enum=,enum=memory,enum=shared"`
+		// MaxEntries is the max number of URLs in the memory storage.
+		MaxEntries int `yaml:"maxEntries" jsonschema:"omitempty,minimum=1"`
+		// MaxEntryBytes is the max size of response bodies to store.
+		MaxEntryBytes int64 `yaml:"maxEntryBytes" jsonschema:"omitempty,minimum=1"`
+		// DefaultTTL is the freshness lifetime of the responses without
+		// explicit expiration time, they aren't stored if it's empty.
+		DefaultTTL string `yaml:"defaultTTL" jsonschema:"omitempty,format=duration"`
+		// MaxTTL caps the freshness lifetime of all responses.
+		MaxTTL string `yaml:"maxTTL" jsonschema:"omitempty,format=duration"`
+		// StaleWhileRevalidate is the time stale responses are served
+		// while they're being revalidated, it's overridden by the
+		// stale-while-revalidate directive of the responses.
+		StaleWhileRevalidate string `yaml:"staleWhileRevalidate" jsonschema:"omitempty,format=duration"`
+	}
+
+	// Status is the status of ResponseCache.
+	Status struct {
+		Hits          uint64 `yaml:"hits"`
+		StaleHits     uint64 `yaml:"staleHits"`
+		Misses        uint64 `yaml:"misses"`
+		Revalidated   uint64 `yaml:"revalidated"`
+		Stores        uint64 `yaml:"stores"`
+		Invalidations uint64 `yaml:"invalidations"`
+		Errors        uint64 `yaml:"errors"`
+		// Entries is the number of URLs in the memory storage.
+		Entries int `yaml:"entries,omitempty"`
+	}
+)
+
+// Kind returns the kind of ResponseCache.
+func (rc *ResponseCache) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of ResponseCache.
+func (rc *ResponseCache) DefaultSpec() interface{} {
+	return &Spec{
+		Storage:       StorageMemory,
+		MaxEntries:    10000,
+		MaxEntryBytes: 1024 * 1024,
+	}
+}
+
+// Description returns the description of ResponseCache.
+func (rc *ResponseCache) Description() string {
+	return "ResponseCache caches responses following the semantics of RFC 7234."
+}
+
+// Results returns the results of ResponseCache.
+func (rc *ResponseCache) Results() []string {
+	return results
+}
+
+// Init initializes ResponseCache.
+func (rc *ResponseCache) Init(filterSpec *httppipeline.FilterSpec) {
+	rc.filterSpec, rc.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	rc.reload()
+}
+
+// Inherit inherits previous generation of ResponseCache.
+func (rc *ResponseCache) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	rc.Init(filterSpec)
+}
+
+func (rc *ResponseCache) reload() {
+	rc.defaultTTL, _ = time.ParseDuration(rc.spec.DefaultTTL)
+	rc.maxTTL, _ = time.ParseDuration(rc.spec.MaxTTL)
+	rc.staleWhileRevalidate, _ = time.ParseDuration(rc.spec.StaleWhileRevalidate)
+	rc.now = time.Now
+	rc.revalidating = map[string]struct{}{}
+
+	if rc.spec.Storage == StorageShared {
+		rc.storage = &sharedStorage{
+			namespace: "responsecache/" + rc.filterSpec.Pipeline() + "/" + rc.filterSpec.Name(),
+			state:     rc.state,
+		}
+	} else {
+		rc.storage = newMemoryStorage(rc.spec.MaxEntries)
+	}
+}
+
+func (rc *ResponseCache) state(namespace string) (sharedstate.Store, error) {
+	var cls cluster.Cluster
+	if super := rc.filterSpec.Super(); super != nil {
+		cls = super.Cluster()
+	}
+	return sharedstate.Namespace(cls, namespace)
+}
+
+// Handle handles HTTPContext.
+func (rc *ResponseCache) Handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+
+	switch r.Method() {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		// NOTE: The stored responses are invalidated by the successful
+		// unsafe requests to the same URL.
+		// Reference: https://tools.ietf.org/html/rfc7234#section-4.4
+		result := ctx.CallNextHandler("")
+		if code := ctx.Response().StatusCode(); code >= 200 && code < 400 {
+			atomic.AddUint64(&rc.invalidations, 1)
+			rc.check(ctx, rc.storage.delete(rc.keyOf(ctx)))
+		}
+		return result
+	default:
+		return ctx.CallNextHandler("")
+	}
+
+	reqCC := parseCacheControl(r.Header().GetAll(httpheader.KeyCacheControl))
+	if reqCC.has("no-store") || r.Header().Get(httpheader.KeyRange) != "" {
+		return ctx.CallNextHandler("")
+	}
+	noCache := reqCC.has("no-cache")
+	if len(reqCC) == 0 && strings.Contains(r.Header().Get(httpheader.KeyPragma), "no-cache") {
+		noCache = true
+	}
+
+	key := rc.keyOf(ctx)
+	now := rc.now()
+	rec, err := rc.storage.get(key)
+	rc.check(ctx, err)
+	e := rec.match(func(names []string) map[string]string {
+		return varyValues(names, r.Header())
+	})
+
+	if e != nil && !noCache {
+		age := e.age(now)
+		if rc.fresh(e, reqCC, age) {
+			atomic.AddUint64(&rc.hits, 1)
+			rc.serve(ctx, e, age)
+			ctx.AddTag("responseCache: hit")
+			return ctx.CallNextHandler(resultCached)
+		}
+		if rc.staleServable(e, reqCC, age) {
+			if !rc.startRevalidating(key) {
+				atomic.AddUint64(&rc.staleHits, 1)
+				rc.serve(ctx, e, age)
+				ctx.AddTag("responseCache: stale hit")
+				return ctx.CallNextHandler(resultCached)
+			}
+			defer rc.stopRevalidating(key)
+		}
+	}
+
+	// NOTE: The stale response is revalidated by a conditional request,
+	// unless the client sends its own conditional request.
+	conditional := false
+	if e != nil && r.Header().Get(httpheader.KeyIfNoneMatch) == "" &&
+		r.Header().Get(httpheader.KeyIfModifiedSince) == "" {
+		if etag := e.Header.Get(httpheader.KeyETag); etag != "" {
+			r.Header().Set(httpheader.KeyIfNoneMatch, etag)
+			conditional = true
+		}
+		if lm := e.Header.Get(httpheader.KeyLastModified); lm != "" {
+			r.Header().Set(httpheader.KeyIfModifiedSince, lm)
+			conditional = true
+		}
+	}
+
+	result := ctx.CallNextHandler("")
+	w := ctx.Response()
+
+	if conditional {
+		r.Header().Del(httpheader.KeyIfNoneMatch)
+		r.Header().Del(httpheader.KeyIfModifiedSince)
+		if w.StatusCode() == http.StatusNotModified {
+			atomic.AddUint64(&rc.revalidated, 1)
+			e = rc.refresh(e, w.Header(), rc.now())
+			rc.put(ctx, key, rec, e)
+			rc.serve(ctx, e, e.age(rc.now()))
+			ctx.AddTag("responseCache: revalidated")
+			return result
+		}
+	}
+
+	atomic.AddUint64(&rc.misses, 1)
+	if r.Method() == http.MethodGet {
+		if e := rc.newEntry(ctx, reqCC); e != nil {
+			rc.put(ctx, key, rec, e)
+		}
+	}
+	return result
+}
+
+func (rc *ResponseCache) keyOf(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	return stringtool.Cat(r.Scheme(), "://", r.Host(), r.Path(), "?", r.Query())
+}
+
+func (rc *ResponseCache) check(ctx context.HTTPContext, err error) {
+	if err != nil {
+		atomic.AddUint64(&rc.errors, 1)
+		ctx.AddTag(stringtool.Cat("responseCache: ", err.Error()))
+	}
+}
+
+// fresh reports whether the stored response could be served without
+// revalidation, with the constraints of the request.
+// Reference: https://tools.ietf.org/html/rfc7234#section-4.2
+func (rc *ResponseCache) fresh(e *entry, reqCC directives, age time.Duration) bool {
+	if maxAge, exists := reqCC.seconds("max-age"); exists && age > maxAge {
+		return false
+	}
+	lifetime := e.Lifetime
+	if minFresh, exists := reqCC.seconds("min-fresh"); exists {
+		lifetime -= minFresh
+	}
+	if age < lifetime {
+		return true
+	}
+
+	if e.MustRevalidate || !reqCC.has("max-stale") {
+		return false
+	}
+	if reqCC["max-stale"] == "" {
+		return true
+	}
+	maxStale, _ := reqCC.seconds("max-stale")
+	return age-e.Lifetime <= maxStale
+}
+
+// staleServable reports whether the stale response could be served while
+// it's being revalidated.
+// Reference: https://tools.ietf.org/html/rfc5861#section-3
+func (rc *ResponseCache) staleServable(e *entry, reqCC directives, age time.Duration) bool {
+	if e.MustRevalidate || reqCC.has("max-age") || reqCC.has("min-fresh") {
+		return false
+	}
+	return age < e.Lifetime+e.StaleWhileRevalidate
+}
+
+// startRevalidating marks the response of the key as being revalidated,
+// it returns false if it's being revalidated already, so only one request
+// revalidates it while the others are served the stale response.
+func (rc *ResponseCache) startRevalidating(key string) bool {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	if _, exists := rc.revalidating[key]; exists {
+		return false
+	}
+	rc.revalidating[key] = struct{}{}
+	return true
+}
+
+func (rc *ResponseCache) stopRevalidating(key string) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	delete(rc.revalidating, key)
+}
+
+func (rc *ResponseCache) serve(ctx context.HTTPContext, e *entry, age time.Duration) {
+	r, w := ctx.Request(), ctx.Response()
+
+	w.Header().Reset(e.Header)
+	w.Header().Set(httpheader.KeyAge, strconv.Itoa(int(age/time.Second)))
+
+	if notModified(r.Header(), e.Header.Get(httpheader.KeyETag), e.Header.Get(httpheader.KeyLastModified)) {
+		w.Header().Del(httpheader.KeyContentLength)
+		w.SetStatusCode(http.StatusNotModified)
+		w.SetBody(bytes.NewReader(nil))
+		return
+	}
+
+	w.SetStatusCode(e.StatusCode)
+	if r.Method() == http.MethodHead {
+		w.SetBody(bytes.NewReader(nil))
+	} else {
+		w.SetBody(bytes.NewReader(e.Body))
+	}
+}
+
+// newEntry creates the entry of the response, it returns nil if the
+// response isn't storable.
+// Reference: https://tools.ietf.org/html/rfc7234#section-3
+func (rc *ResponseCache) newEntry(ctx context.HTTPContext, reqCC directives) *entry {
+	r, w := ctx.Request(), ctx.Response()
+
+	respCC := parseCacheControl(w.Header().GetAll(httpheader.KeyCacheControl))
+	if respCC.has("no-store") || respCC.has("private") || respCC.has("no-cache") {
+		return nil
+	}
+	if r.Header().Get(httpheader.KeyAuthorization) != "" &&
+		!respCC.has("public") && !respCC.has("s-maxage") && !respCC.has("must-revalidate") {
+		return nil
+	}
+	if w.Header().Get(httpheader.KeySetCookie) != "" {
+		return nil
+	}
+	vary := parseVary(w.Header().GetAll(httpheader.KeyVary))
+	for _, name := range vary {
+		if name == "*" {
+			return nil
+		}
+	}
+
+	code := w.StatusCode()
+	lifetime, explicit := freshnessLifetime(respCC, w.Header(), rc.defaultTTL)
+	if explicit {
+		if code < 200 || code == http.StatusPartialContent || code == http.StatusNotModified {
+			return nil
+		}
+	} else if !heuristicCodes[code] {
+		return nil
+	}
+	if rc.maxTTL > 0 && lifetime > rc.maxTTL {
+		lifetime = rc.maxTTL
+	}
+
+	swr := rc.staleWhileRevalidate
+	if d, exists := respCC.seconds("stale-while-revalidate"); exists {
+		swr = d
+	}
+	if lifetime+swr <= 0 {
+		return nil
+	}
+
+	body, ok := rc.capture(ctx)
+	if !ok {
+		return nil
+	}
+
+	header := w.Header().Copy().Std()
+	header.Del(httpheader.KeyAge)
+	now := rc.now()
+	return &entry{
+		StatusCode:           code,
+		Header:               header,
+		Body:                 body,
+		Vary:                 varyValues(vary, r.Header()),
+		Date:                 now.Add(-initialAge(w.Header())),
+		Lifetime:             lifetime,
+		StaleWhileRevalidate: swr,
+		MustRevalidate:       respCC.has("must-revalidate") || respCC.has("proxy-revalidate"),
+	}
+}
+
+// refresh returns the entry updated by the 304 response of revalidation.
+// Reference: https://tools.ietf.org/html/rfc7234#section-4.3.4
+func (rc *ResponseCache) refresh(e *entry, h *httpheader.HTTPHeader, now time.Time) *entry {
+	n := *e
+	n.Header = e.Header.Clone()
+	for key, values := range h.Std() {
+		switch http.CanonicalHeaderKey(key) {
+		case httpheader.KeyContentLength, httpheader.KeyAge:
+		default:
+			n.Header[key] = values
+		}
+	}
+
+	updated := httpheader.New(n.Header)
+	respCC := parseCacheControl(updated.GetAll(httpheader.KeyCacheControl))
+	n.Lifetime, _ = freshnessLifetime(respCC, updated, rc.defaultTTL)
+	if rc.maxTTL > 0 && n.Lifetime > rc.maxTTL {
+		n.Lifetime = rc.maxTTL
+	}
+	n.Date = now.Add(-initialAge(h))
+	return &n
+}
+
+func (rc *ResponseCache) put(ctx context.HTTPContext, key string, rec *record, e *entry) {
+	now := rc.now()
+	rec = rec.with(e, now)
+	if ttl := rec.ttl(now); ttl > 0 {
+		atomic.AddUint64(&rc.stores, 1)
+		rc.check(ctx, rc.storage.put(key, rec, ttl))
+	}
+}
+
+// capture reads the response body to store it, the body is restored if
+// it's too large to store.
+func (rc *ResponseCache) capture(ctx context.HTTPContext) ([]byte, bool) {
+	w := ctx.Response()
+	original := w.Body()
+	if original == nil {
+		return nil, true
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(original, rc.spec.MaxEntryBytes+1))
+	if err != nil || int64(len(body)) > rc.spec.MaxEntryBytes {
+		w.SetBody(struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), original), closerOf(original)})
+		return nil, false
+	}
+	if c, ok := original.(io.Closer); ok {
+		c.Close()
+	}
+	w.SetBody(bytes.NewReader(body))
+	return body, true
+}
+
+func closerOf(r io.Reader) io.Closer {
+	if c, ok := r.(io.Closer); ok {
+		return c
+	}
+	return ioutil.NopCloser(nil)
+}
+
+func initialAge(h *httpheader.HTTPHeader) time.Duration {
+	age, err := strconv.ParseInt(h.Get(httpheader.KeyAge), 10, 32)
+	if err != nil || age < 0 {
+		return 0
+	}
+	return time.Duration(age) * time.Second
+}
+
+// Status returns status.
+func (rc *ResponseCache) Status() interface{} {
+	s := &Status{
+		Hits:          atomic.LoadUint64(&rc.hits),
+		StaleHits:     atomic.LoadUint64(&rc.staleHits),
+		Misses:        atomic.LoadUint64(&rc.misses),
+		Revalidated:   atomic.LoadUint64(&rc.revalidated),
+		Stores:        atomic.LoadUint64(&rc.stores),
+		Invalidations: atomic.LoadUint64(&rc.invalidations),
+		Errors:        atomic.LoadUint64(&rc.errors),
+	}
+	if n := rc.storage.len(); n >= 0 {
+		s.Entries = n
+	}
+	return s
+}
+
+// Close closes ResponseCache.
+func (rc *ResponseCache) Close() {}
@@ -0,0 +1,335 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package responsecache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/sharedstate"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newResponseCache(t *testing.T, yamlSpec string) *ResponseCache {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rc := &ResponseCache{}
+	rc.Init(spec)
+	return rc
+}
+
+// origin is the upstream of the cache, it responds with the header and
+// the body, and records the requests.
+type origin struct {
+	calls  int
+	code   int
+	header http.Header
+	body   string
+	// request is the header of the last request.
+	request http.Header
+}
+
+type exchange struct {
+	result string
+	code   int
+	header http.Header
+	body   string
+}
+
+func (o *origin) respond(code int, body string, header ...string) {
+	o.code, o.body, o.header = code, body, http.Header{}
+	for i := 0; i+1 < len(header); i += 2 {
+		o.header.Add(header[i], header[i+1])
+	}
+}
+
+func handle(rc *ResponseCache, o *origin, method, url string, header ...string) *exchange {
+	stdr, _ := http.NewRequest(method, url, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		stdr.Header.Add(header[i], header[i+1])
+	}
+
+	ctx := context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		if lastResult != "" {
+			return lastResult
+		}
+		o.calls++
+		o.request = ctx.Request().Header().Std().Clone()
+		w := ctx.Response()
+		w.SetStatusCode(o.code)
+		for key, values := range o.header {
+			for _, value := range values {
+				w.Header().Add(key, value)
+			}
+		}
+		if method != http.MethodHead {
+			w.SetBody(strings.NewReader(o.body))
+		}
+		return ""
+	})
+
+	e := &exchange{result: rc.Handle(ctx), code: ctx.Response().StatusCode()}
+	e.header = ctx.Response().Header().Std()
+	if body := ctx.Response().Body(); body != nil {
+		buff, _ := ioutil.ReadAll(body)
+		e.body = string(buff)
+	}
+	return e
+}
+
+const url = "http://example.com/items?page=1"
+
+func TestFreshAndRevalidate(t *testing.T) {
+	rc := newResponseCache(t, "kind: ResponseCache\nname: cache\nstorage: memory\n")
+	now := time.Now()
+	rc.now = func() time.Time { return now }
+
+	o := &origin{}
+	o.respond(http.StatusOK, "v1", "Cache-Control", "max-age=10", "ETag", `"v1"`)
+
+	e := handle(rc, o, http.MethodGet, url)
+	if e.result != "" || e.body != "v1" || o.calls != 1 {
+		t.Fatalf("unexpected miss: %+v, calls %d", e, o.calls)
+	}
+
+	now = now.Add(3 * time.Second)
+	e = handle(rc, o, http.MethodGet, url)
+	if e.result != resultCached || e.code != http.StatusOK || e.body != "v1" ||
+		e.header.Get("Age") != "3" || o.calls != 1 {
+		t.Fatalf("unexpected hit: %+v, calls %d", e, o.calls)
+	}
+
+	e = handle(rc, o, http.MethodHead, url)
+	if e.result != resultCached || e.code != http.StatusOK || e.body != "" || o.calls != 1 {
+		t.Errorf("unexpected hit of HEAD: %+v, calls %d", e, o.calls)
+	}
+
+	e = handle(rc, o, http.MethodGet, url, "If-None-Match", `W/"v1"`)
+	if e.result != resultCached || e.code != http.StatusNotModified || e.body != "" || o.calls != 1 {
+		t.Errorf("unexpected conditional hit: %+v, calls %d", e, o.calls)
+	}
+
+	e = handle(rc, o, http.MethodGet, url, "Cache-Control", "max-age=1")
+	if e.result != "" || o.calls != 2 {
+		t.Errorf("max-age of the request should be honored: %+v, calls %d", e, o.calls)
+	}
+
+	// The stale response is revalidated by a conditional request.
+	now = now.Add(11 * time.Second)
+	o.respond(http.StatusNotModified, "", "Cache-Control", "max-age=20", "ETag", `"v1"`)
+	e = handle(rc, o, http.MethodGet, url)
+	if o.request.Get("If-None-Match") != `"v1"` || e.code != http.StatusOK || e.body != "v1" ||
+		e.header.Get("Cache-Control") != "max-age=20" || o.calls != 3 {
+		t.Fatalf("unexpected revalidation: %+v, request %v, calls %d", e, o.request, o.calls)
+	}
+
+	now = now.Add(15 * time.Second)
+	e = handle(rc, o, http.MethodGet, url)
+	if e.result != resultCached || e.body != "v1" || o.calls != 3 {
+		t.Errorf("unexpected hit after revalidation: %+v, calls %d", e, o.calls)
+	}
+
+	// The response is replaced if it's modified.
+	now = now.Add(10 * time.Second)
+	o.respond(http.StatusOK, "v2", "Cache-Control", "max-age=10", "ETag", `"v2"`)
+	e = handle(rc, o, http.MethodGet, url)
+	if e.body != "v2" || o.calls != 4 {
+		t.Errorf("unexpected response: %+v, calls %d", e, o.calls)
+	}
+	e = handle(rc, o, http.MethodGet, url)
+	if e.result != resultCached || e.body != "v2" || o.calls != 4 {
+		t.Errorf("unexpected hit: %+v, calls %d", e, o.calls)
+	}
+
+	// Unsafe requests invalidate the stored responses.
+	o.respond(http.StatusNoContent, "")
+	handle(rc, o, http.MethodDelete, url)
+	o.respond(http.StatusOK, "v3", "Cache-Control", "max-age=10")
+	e = handle(rc, o, http.MethodGet, url)
+	if e.result != "" || e.body != "v3" || o.calls != 6 {
+		t.Errorf("unexpected response after invalidation: %+v, calls %d", e, o.calls)
+	}
+
+	status := rc.Status().(*Status)
+	if status.Hits != 5 || status.Misses != 4 || status.Revalidated != 1 ||
+		status.Invalidations != 1 || status.Entries != 1 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestNotStored(t *testing.T) {
+	rc := newResponseCache(t, "kind: ResponseCache\nname: cache\nstorage: memory\nmaxEntryBytes: 4\n")
+
+	for i, c := range []struct {
+		header  []string
+		request []string
+		body    string
+	}{
+		{header: []string{"Cache-Control", "no-store, max-age=10"}},
+		{header: []string{"Cache-Control", "private, max-age=10"}},
+		{header: []string{"Cache-Control", "no-cache"}},
+		{header: []string{"Cache-Control", "max-age=10", "Set-Cookie", "a=b"}},
+		{header: []string{"Cache-Control", "max-age=10", "Vary", "*"}},
+		{header: []string{"Cache-Control", "max-age=0"}},
+		{header: []string{"Cache-Control", "max-age=10"}, request: []string{"Authorization", "Bearer x"}},
+		{header: []string{"Cache-Control", "max-age=10"}, request: []string{"Cache-Control", "no-store"}},
+		{header: []string{"Cache-Control", "max-age=10"}, body: "too large"},
+		// No explicit expiration and no defaultTTL.
+		{header: []string{"ETag", `"v1"`}},
+	} {
+		body := c.body
+		if body == "" {
+			body = "body"
+		}
+		o := &origin{}
+		o.respond(http.StatusOK, body, c.header...)
+		for j := 0; j < 2; j++ {
+			e := handle(rc, o, http.MethodGet, url, c.request...)
+			if e.result != "" || e.body != body {
+				t.Errorf("case %d: unexpected response: %+v", i, e)
+			}
+		}
+		if o.calls != 2 {
+			t.Errorf("case %d: response should not be stored", i)
+		}
+	}
+}
+
+func TestVaryAndDefaultTTL(t *testing.T) {
+	rc := newResponseCache(t, "kind: ResponseCache\nname: cache\nstorage: memory\ndefaultTTL: 10s\nmaxTTL: 20s\n")
+	now := time.Now()
+	rc.now = func() time.Time { return now }
+
+	o := &origin{}
+	o.respond(http.StatusOK, "plain", "Vary", "Accept-Encoding")
+	handle(rc, o, http.MethodGet, url)
+	o.respond(http.StatusOK, "gzip", "Vary", "Accept-Encoding", "Cache-Control", "max-age=100")
+	handle(rc, o, http.MethodGet, url, "Accept-Encoding", "gzip")
+
+	now = now.Add(5 * time.Second)
+	if e := handle(rc, o, http.MethodGet, url); e.body != "plain" || e.result != resultCached {
+		t.Errorf("unexpected response: %+v", e)
+	}
+	if e := handle(rc, o, http.MethodGet, url, "Accept-Encoding", "gzip"); e.body != "gzip" || e.result != resultCached {
+		t.Errorf("unexpected response: %+v", e)
+	}
+
+	// The heuristic lifetime is defaultTTL, and lifetimes are capped by maxTTL.
+	now = now.Add(10 * time.Second)
+	if e := handle(rc, o, http.MethodGet, url); e.result != "" {
+		t.Errorf("response should be expired: %+v", e)
+	}
+	if e := handle(rc, o, http.MethodGet, url, "Accept-Encoding", "gzip"); e.result != resultCached {
+		t.Errorf("unexpected response: %+v", e)
+	}
+	now = now.Add(10 * time.Second)
+	if e := handle(rc, o, http.MethodGet, url, "Accept-Encoding", "gzip"); e.result != "" {
+		t.Errorf("response should be expired: %+v", e)
+	}
+	if o.calls != 4 {
+		t.Errorf("expect 4 calls, got %d", o.calls)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	rc := newResponseCache(t, "kind: ResponseCache\nname: cache\nstorage: memory\nstaleWhileRevalidate: 30s\n")
+	now := time.Now()
+	rc.now = func() time.Time { return now }
+
+	o := &origin{}
+	o.respond(http.StatusOK, "v1", "Cache-Control", "max-age=10")
+	handle(rc, o, http.MethodGet, url)
+
+	// The stale response is served while another request revalidates it.
+	now = now.Add(20 * time.Second)
+	key := "http://example.com/items?page=1"
+	if !rc.startRevalidating(key) {
+		t.Fatalf("response should not be revalidating")
+	}
+	o.respond(http.StatusOK, "v2", "Cache-Control", "max-age=10")
+	if e := handle(rc, o, http.MethodGet, url); e.result != resultCached || e.body != "v1" || o.calls != 1 {
+		t.Errorf("stale response should be served: %+v", e)
+	}
+	rc.stopRevalidating(key)
+
+	if e := handle(rc, o, http.MethodGet, url); e.result != "" || e.body != "v2" || o.calls != 2 {
+		t.Errorf("response should be revalidated: %+v", e)
+	}
+	if len(rc.revalidating) != 0 {
+		t.Errorf("revalidating should be cleared")
+	}
+
+	// must-revalidate forbids serving stale responses.
+	o.respond(http.StatusOK, "v3", "Cache-Control", "max-age=10, must-revalidate")
+	handle(rc, o, http.MethodGet, url, "Cache-Control", "no-cache")
+	now = now.Add(20 * time.Second)
+	rc.startRevalidating(key)
+	if e := handle(rc, o, http.MethodGet, url); e.result != "" || o.calls != 4 {
+		t.Errorf("stale response should not be served: %+v", e)
+	}
+}
+
+func TestSharedStorage(t *testing.T) {
+	provider, err := sharedstate.New(&sharedstate.Spec{Provider: sharedstate.ProviderMemory}, nil)
+	if err != nil {
+		t.Fatalf("create provider failed: %v", err)
+	}
+	sharedstate.SetProvider(provider)
+	defer func() {
+		sharedstate.ResetProvider(provider)
+		provider.Close()
+	}()
+
+	spec := "kind: ResponseCache\nname: cache\nstorage: shared\n"
+	rc1, rc2 := newResponseCache(t, spec), newResponseCache(t, spec)
+
+	o := &origin{}
+	o.respond(http.StatusOK, "v1", "Cache-Control", "max-age=10", "Content-Type", "text/plain")
+	handle(rc1, o, http.MethodGet, url)
+	e := handle(rc2, o, http.MethodGet, url)
+	if e.result != resultCached || e.body != "v1" || e.header.Get("Content-Type") != "text/plain" || o.calls != 1 {
+		t.Errorf("response should be shared: %+v", e)
+	}
+
+	status := rc2.Status().(*Status)
+	if status.Hits != 1 || status.Entries != 0 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
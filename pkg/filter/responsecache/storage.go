@@ -0,0 +1,269 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package responsecache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/sharedstate"
+)
+
+const maxVariants = 8
+
+type (
+	// record contains the stored responses of a URL, which are variants
+	// selected by the headers nominated by their Vary. Records are
+	// immutable once stored, as they are shared by concurrent requests.
+	record struct {
+		Entries []*entry `json:"entries"`
+	}
+
+	// entry is a stored response.
+	entry struct {
+		StatusCode int         `json:"statusCode"`
+		Header     http.Header `json:"header"`
+		Body       []byte      `json:"body"`
+		// Vary contains the values of the request headers nominated by
+		// the Vary of the response.
+		Vary map[string]string `json:"vary,omitempty"`
+		// Date is the time the response was generated by the origin
+		// server, which is the response time minus its initial age.
+		Date     time.Time     `json:"date"`
+		Lifetime time.Duration `json:"lifetime"`
+		// StaleWhileRevalidate is the time the response could be served
+		// after it's stale, while it's being revalidated.
+		StaleWhileRevalidate time.Duration `json:"staleWhileRevalidate"`
+		MustRevalidate       bool          `json:"mustRevalidate"`
+	}
+
+	// storage stores the records by their keys.
+	storage interface {
+		get(key string) (*record, error)
+		put(key string, rec *record, ttl time.Duration) error
+		delete(key string) error
+		// len returns the number of records, -1 means unknown.
+		len() int
+	}
+
+	// memoryStorage stores the records in the memory of the member, the
+	// least recently used ones are evicted if it's full.
+	memoryStorage struct {
+		mutex      sync.Mutex
+		maxEntries int
+		lru        *list.List
+		items      map[string]*list.Element
+	}
+
+	memoryItem struct {
+		key      string
+		rec      *record
+		expireAt time.Time
+	}
+
+	// sharedStorage stores the records in the shared state, which is the
+	// etcd of the cluster or the provider of SharedStateProvider, the keys
+	// are hashed as they could be too long.
+	sharedStorage struct {
+		namespace string
+		state     func(namespace string) (sharedstate.Store, error)
+	}
+)
+
+func (e *entry) age(now time.Time) time.Duration {
+	if age := now.Sub(e.Date); age > 0 {
+		return age
+	}
+	return 0
+}
+
+func (e *entry) expireAt() time.Time {
+	return e.Date.Add(e.Lifetime + e.StaleWhileRevalidate)
+}
+
+// match returns the entry whose Vary values match the request.
+func (rec *record) match(vary func(names []string) map[string]string) *entry {
+	if rec == nil {
+		return nil
+	}
+	for _, e := range rec.Entries {
+		names := make([]string, 0, len(e.Vary))
+		for name := range e.Vary {
+			names = append(names, name)
+		}
+		if varyEqual(e.Vary, vary(names)) {
+			return e
+		}
+	}
+	return nil
+}
+
+// with returns a new record with the entry added, it replaces the entry
+// of the same variant, and the expired or the oldest ones are dropped.
+func (rec *record) with(e *entry, now time.Time) *record {
+	n := &record{Entries: []*entry{e}}
+	if rec == nil {
+		return n
+	}
+	for _, old := range rec.Entries {
+		if len(n.Entries) == maxVariants {
+			break
+		}
+		if !varyEqual(old.Vary, e.Vary) && old.expireAt().After(now) {
+			n.Entries = append(n.Entries, old)
+		}
+	}
+	return n
+}
+
+// ttl returns the time the record should be kept.
+func (rec *record) ttl(now time.Time) time.Duration {
+	var ttl time.Duration
+	for _, e := range rec.Entries {
+		if d := e.expireAt().Sub(now); d > ttl {
+			ttl = d
+		}
+	}
+	return ttl
+}
+
+func varyEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if v, exists := b[name]; !exists || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+func newMemoryStorage(maxEntries int) *memoryStorage {
+	return &memoryStorage{
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		items:      map[string]*list.Element{},
+	}
+}
+
+func (s *memoryStorage) get(key string) (*record, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	elem, exists := s.items[key]
+	if !exists {
+		return nil, nil
+	}
+	item := elem.Value.(*memoryItem)
+	if !item.expireAt.After(time.Now()) {
+		s.lru.Remove(elem)
+		delete(s.items, key)
+		return nil, nil
+	}
+	s.lru.MoveToFront(elem)
+	return item.rec, nil
+}
+
+func (s *memoryStorage) put(key string, rec *record, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	item := &memoryItem{key: key, rec: rec, expireAt: time.Now().Add(ttl)}
+	if elem, exists := s.items[key]; exists {
+		elem.Value = item
+		s.lru.MoveToFront(elem)
+		return nil
+	}
+
+	s.items[key] = s.lru.PushFront(item)
+	for s.lru.Len() > s.maxEntries {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.items, oldest.Value.(*memoryItem).key)
+	}
+	return nil
+}
+
+func (s *memoryStorage) delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if elem, exists := s.items[key]; exists {
+		s.lru.Remove(elem)
+		delete(s.items, key)
+	}
+	return nil
+}
+
+func (s *memoryStorage) len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.lru.Len()
+}
+
+func (s *sharedStorage) get(key string) (*record, error) {
+	store, err := s.state(s.namespace)
+	if err != nil {
+		return nil, err
+	}
+	value, exists, err := store.Get(hashKey(key))
+	if err != nil || !exists {
+		return nil, err
+	}
+	rec := &record{}
+	if err := json.Unmarshal([]byte(value), rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (s *sharedStorage) put(key string, rec *record, ttl time.Duration) error {
+	store, err := s.state(s.namespace)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return store.Put(hashKey(key), string(value), ttl)
+}
+
+func (s *sharedStorage) delete(key string) error {
+	store, err := s.state(s.namespace)
+	if err != nil {
+		return err
+	}
+	return store.Delete(hashKey(key))
+}
+
+func (s *sharedStorage) len() int {
+	return -1
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
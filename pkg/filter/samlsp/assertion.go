@@ -0,0 +1,212 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package samlsp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/xmldsig"
+)
+
+const (
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+
+	statusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	methodBearer  = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+)
+
+// assertion is a validated assertion.
+type assertion struct {
+	id           string
+	inResponseTo string
+	nameID       string
+	attributes   map[string][]string
+	// notOnOrAfter is when the assertion can't be consumed anymore.
+	notOnOrAfter time.Time
+}
+
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// validate validates the response and returns its assertion. Only the
+// signed element is trusted, the signature must be of the response or
+// the assertion.
+func (sp *SAMLServiceProvider) validate(data []byte) (*assertion, error) {
+	resp, err := xmldsig.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse response failed: %v", err)
+	}
+	if !resp.Is(nsProtocol, "Response") {
+		return nil, fmt.Errorf("root element is not Response")
+	}
+	if resp.Attr("Version") != "2.0" {
+		return nil, fmt.Errorf("unsupported version %s", resp.Attr("Version"))
+	}
+	if d := resp.Attr("Destination"); d != "" && d != sp.acsURL {
+		return nil, fmt.Errorf("destination %s mismatch", d)
+	}
+
+	status := resp.Element(nsProtocol, "Status")
+	if status == nil {
+		return nil, fmt.Errorf("status not found")
+	}
+	if code := status.Element(nsProtocol, "StatusCode"); code == nil || code.Attr("Value") != statusSuccess {
+		return nil, fmt.Errorf("identity provider responds failure")
+	}
+
+	if len(resp.Elements(nsAssertion, "EncryptedAssertion")) != 0 {
+		return nil, fmt.Errorf("encrypted assertions are not supported")
+	}
+	assertions := resp.Elements(nsAssertion, "Assertion")
+	if len(assertions) != 1 {
+		return nil, fmt.Errorf("want exactly one assertion, got %d", len(assertions))
+	}
+	a := assertions[0]
+
+	if xmldsig.Signature(resp) != nil {
+		err = xmldsig.Verify(resp, sp.certs)
+	} else {
+		err = xmldsig.Verify(a, sp.certs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("verify signature failed: %v", err)
+	}
+
+	if issuer := resp.Element(nsAssertion, "Issuer"); issuer != nil && issuer.Text() != sp.spec.IdP.EntityID {
+		return nil, fmt.Errorf("issuer %s of response mismatch", issuer.Text())
+	}
+	if issuer := a.Element(nsAssertion, "Issuer"); issuer == nil || issuer.Text() != sp.spec.IdP.EntityID {
+		return nil, fmt.Errorf("issuer of assertion mismatch")
+	}
+
+	result := &assertion{
+		id:           a.Attr("ID"),
+		inResponseTo: resp.Attr("InResponseTo"),
+		attributes:   make(map[string][]string),
+	}
+	if result.id == "" {
+		return nil, fmt.Errorf("assertion has no ID")
+	}
+
+	if err := sp.validateConditions(a, result); err != nil {
+		return nil, err
+	}
+	if err := sp.validateSubject(a, result); err != nil {
+		return nil, err
+	}
+
+	for _, stmt := range a.Elements(nsAssertion, "AttributeStatement") {
+		for _, attr := range stmt.Elements(nsAssertion, "Attribute") {
+			name := attr.Attr("Name")
+			for _, v := range attr.Elements(nsAssertion, "AttributeValue") {
+				result.attributes[name] = append(result.attributes[name], v.Text())
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (sp *SAMLServiceProvider) validateConditions(a *xmldsig.Element, result *assertion) error {
+	now := sp.now()
+
+	conditions := a.Element(nsAssertion, "Conditions")
+	if conditions == nil {
+		return fmt.Errorf("conditions not found")
+	}
+	if s := conditions.Attr("NotBefore"); s != "" {
+		t, err := parseTime(s)
+		if err != nil {
+			return fmt.Errorf("invalid NotBefore: %v", err)
+		}
+		if now.Add(sp.clockSkew).Before(t) {
+			return fmt.Errorf("assertion is not yet valid")
+		}
+	}
+	if s := conditions.Attr("NotOnOrAfter"); s != "" {
+		t, err := parseTime(s)
+		if err != nil {
+			return fmt.Errorf("invalid NotOnOrAfter: %v", err)
+		}
+		if !now.Add(-sp.clockSkew).Before(t) {
+			return fmt.Errorf("assertion is expired")
+		}
+		result.notOnOrAfter = t
+	}
+
+	// NOTE: Every audience restriction must be satisfied, and there
+	// must be at least one.
+	restrictions := conditions.Elements(nsAssertion, "AudienceRestriction")
+	if len(restrictions) == 0 {
+		return fmt.Errorf("audience restriction not found")
+	}
+	for _, r := range restrictions {
+		matched := false
+		for _, audience := range r.Elements(nsAssertion, "Audience") {
+			if audience.Text() == sp.spec.EntityID {
+				matched = true
+			}
+		}
+		if !matched {
+			return fmt.Errorf("audience mismatch")
+		}
+	}
+	return nil
+}
+
+func (sp *SAMLServiceProvider) validateSubject(a *xmldsig.Element, result *assertion) error {
+	now := sp.now()
+
+	subject := a.Element(nsAssertion, "Subject")
+	if subject == nil {
+		return fmt.Errorf("subject not found")
+	}
+	if nameID := subject.Element(nsAssertion, "NameID"); nameID != nil {
+		result.nameID = nameID.Text()
+	}
+
+	for _, sc := range subject.Elements(nsAssertion, "SubjectConfirmation") {
+		if sc.Attr("Method") != methodBearer {
+			continue
+		}
+		data := sc.Element(nsAssertion, "SubjectConfirmationData")
+		if data == nil {
+			continue
+		}
+		if data.Attr("Recipient") != sp.acsURL {
+			continue
+		}
+		if data.Attr("InResponseTo") != result.inResponseTo {
+			continue
+		}
+		t, err := parseTime(data.Attr("NotOnOrAfter"))
+		if err != nil || !now.Add(-sp.clockSkew).Before(t) {
+			continue
+		}
+
+		if result.notOnOrAfter.IsZero() || t.Before(result.notOnOrAfter) {
+			result.notOnOrAfter = t
+		}
+		return nil
+	}
+
+	return fmt.Errorf("no valid bearer subject confirmation")
+}
@@ -0,0 +1,468 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package samlsp provides the SAMLServiceProvider filter, which signs in
+// users by SAML 2.0 identity providers.
+package samlsp
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/sharedstate"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const (
+	// Kind is the kind of SAMLServiceProvider.
+	Kind = "SAMLServiceProvider"
+
+	resultServed   = "served"
+	resultRejected = "rejected"
+
+	maxBodySize = 256 * 1024
+
+	// requestTTL is how long the identity provider has to respond
+	// to an AuthnRequest.
+	requestTTL = 10 * time.Minute
+
+	bindingHTTPPost = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+)
+
+var results = []string{resultServed, resultRejected}
+
+func init() {
+	httppipeline.Register(&SAMLServiceProvider{})
+}
+
+type (
+	// SAMLServiceProvider is a SAML 2.0 service provider, it serves the
+	// endpoints under the path prefix:
+	//
+	//	GET  {pathPrefix}/metadata  the metadata of the service provider
+	//	GET  {pathPrefix}/login     redirects to the identity provider
+	//	POST {pathPrefix}/acs       the assertion consumer service
+	//
+	// The assertion consumer service responds the user and the mapped
+	// attributes in headers with a redirect to the relay state, which
+	// are turned into a session by a Session filter before it.
+	SAMLServiceProvider struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		certs     []*x509.Certificate
+		clockSkew time.Duration
+		acsURL    string
+
+		redirected uint64
+		accepted   uint64
+		rejected   uint64
+
+		mutex     sync.Mutex
+		lastError string
+
+		// now is replaced in tests.
+		now func() time.Time
+	}
+
+	// Spec describes the SAMLServiceProvider.
+	Spec struct {
+		PathPrefix string `yaml:"pathPrefix" jsonschema:"required,pattern=^/"`
+		EntityID   string `yaml:"entityID" jsonschema:"required"`
+		// BaseURL is the external URL of the gateway, the assertion
+		// consumer service is at BaseURL + PathPrefix + "/acs".
+		BaseURL      string   `yaml:"baseURL" jsonschema:"required,format=uri"`
+		IdP          *IdPSpec `yaml:"idp" jsonschema:"required"`
+		NameIDFormat string   `yaml:"nameIDFormat" jsonschema:"omitempty"`
+		ClockSkew    string   `yaml:"clockSkew" jsonschema:"omitempty,format=duration"`
+		// AllowIdPInitiated accepts responses which are not requested by
+		// the service provider.
+		AllowIdPInitiated bool `yaml:"allowIdPInitiated" jsonschema:"omitempty"`
+		// UserHeader carries the user, which is the NameID or the
+		// UserAttribute if it's specified.
+		UserHeader    string `yaml:"userHeader" jsonschema:"omitempty"`
+		UserAttribute string `yaml:"userAttribute" jsonschema:"omitempty"`
+		// AttributeHeaders maps attributes to headers, values of
+		// multi-valued attributes are separated by commas.
+		AttributeHeaders map[string]string `yaml:"attributeHeaders" jsonschema:"omitempty"`
+		// DefaultRelayState is where users are redirected to after login,
+		// if the login endpoint is requested without returnTo.
+		DefaultRelayState string `yaml:"defaultRelayState" jsonschema:"omitempty"`
+	}
+
+	// IdPSpec describes the identity provider.
+	IdPSpec struct {
+		EntityID string `yaml:"entityID" jsonschema:"required"`
+		// SSOURL is the single sign-on service of the HTTP-Redirect binding.
+		SSOURL string `yaml:"ssoURL" jsonschema:"required,format=uri"`
+		// Certificates are the signing certificates in PEM.
+		Certificates []string `yaml:"certificates" jsonschema:"required,minItems=1"`
+	}
+
+	// Status is the status of SAMLServiceProvider.
+	Status struct {
+		Redirected uint64 `yaml:"redirected"`
+		Accepted   uint64 `yaml:"accepted"`
+		Rejected   uint64 `yaml:"rejected"`
+		LastError  string `yaml:"lastError,omitempty"`
+	}
+)
+
+func parseCertificates(pems []string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for i, p := range pems {
+		block, _ := pem.Decode([]byte(p))
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("certificate %d is not in PEM", i)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse certificate %d failed: %v", i, err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if _, err := parseCertificates(spec.IdP.Certificates); err != nil {
+		return fmt.Errorf("idp: %v", err)
+	}
+	if d, _ := time.ParseDuration(spec.ClockSkew); d < 0 {
+		return fmt.Errorf("clockSkew is negative")
+	}
+	if spec.DefaultRelayState != "" && !isLocalPath(spec.DefaultRelayState) {
+		return fmt.Errorf("defaultRelayState must be a path")
+	}
+	return nil
+}
+
+// Kind returns the kind of SAMLServiceProvider.
+func (sp *SAMLServiceProvider) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of SAMLServiceProvider.
+func (sp *SAMLServiceProvider) DefaultSpec() interface{} {
+	return &Spec{
+		NameIDFormat:      "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified",
+		ClockSkew:         "90s",
+		UserHeader:        "X-Session-User",
+		DefaultRelayState: "/",
+	}
+}
+
+// Description returns the description of SAMLServiceProvider.
+func (sp *SAMLServiceProvider) Description() string {
+	return "SAMLServiceProvider signs in users by SAML 2.0 identity providers."
+}
+
+// Results returns the results of SAMLServiceProvider.
+func (sp *SAMLServiceProvider) Results() []string {
+	return results
+}
+
+// Init initializes SAMLServiceProvider.
+func (sp *SAMLServiceProvider) Init(filterSpec *httppipeline.FilterSpec) {
+	sp.filterSpec, sp.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	sp.reload()
+}
+
+// Inherit inherits previous generation of SAMLServiceProvider.
+func (sp *SAMLServiceProvider) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	sp.Init(filterSpec)
+}
+
+func (sp *SAMLServiceProvider) reload() {
+	sp.certs, _ = parseCertificates(sp.spec.IdP.Certificates)
+	sp.clockSkew, _ = time.ParseDuration(sp.spec.ClockSkew)
+	sp.spec.PathPrefix = strings.TrimSuffix(sp.spec.PathPrefix, "/")
+	sp.acsURL = strings.TrimSuffix(sp.spec.BaseURL, "/") + sp.spec.PathPrefix + "/acs"
+	sp.now = time.Now
+}
+
+// state returns the shared state tracking requests and assertions.
+func (sp *SAMLServiceProvider) state() (sharedstate.Store, error) {
+	var cls cluster.Cluster
+	if super := sp.filterSpec.Super(); super != nil {
+		cls = super.Cluster()
+	}
+	return sharedstate.Namespace(cls, "saml/"+sp.spec.EntityID)
+}
+
+// Handle handles HTTPContext.
+func (sp *SAMLServiceProvider) Handle(ctx context.HTTPContext) string {
+	result := sp.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (sp *SAMLServiceProvider) handle(ctx context.HTTPContext) string {
+	r, w := ctx.Request(), ctx.Response()
+
+	var allowed string
+	switch strings.TrimPrefix(r.Path(), sp.spec.PathPrefix) {
+	case "/metadata":
+		if allowed = http.MethodGet; r.Method() == allowed {
+			sp.metadata(w)
+			return resultServed
+		}
+	case "/login":
+		if allowed = http.MethodGet; r.Method() == allowed {
+			sp.login(r, w)
+			return resultServed
+		}
+	case "/acs":
+		if allowed = http.MethodPost; r.Method() == allowed {
+			return sp.acs(r, w)
+		}
+	default:
+		return ""
+	}
+
+	w.Header().Set("Allow", allowed)
+	w.SetStatusCode(http.StatusMethodNotAllowed)
+	return resultServed
+}
+
+func (sp *SAMLServiceProvider) metadata(w context.HTTPResponse) {
+	metadata := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="` + html.EscapeString(sp.spec.EntityID) + `">` +
+		`<md:SPSSODescriptor AuthnRequestsSigned="false" WantAssertionsSigned="true" protocolSupportEnumeration="` + nsProtocol + `">` +
+		`<md:NameIDFormat>` + html.EscapeString(sp.spec.NameIDFormat) + `</md:NameIDFormat>` +
+		`<md:AssertionConsumerService Binding="` + bindingHTTPPost + `" Location="` + html.EscapeString(sp.acsURL) + `" index="0" isDefault="true"/>` +
+		`</md:SPSSODescriptor></md:EntityDescriptor>`
+
+	w.Header().Set(httpheader.KeyContentType, "application/samlmetadata+xml")
+	w.SetStatusCode(http.StatusOK)
+	w.SetBody(strings.NewReader(metadata))
+}
+
+func newID() string {
+	buff := make([]byte, 20)
+	rand.Read(buff)
+	// NOTE: IDs are xs:ID, which can't start with digits.
+	return "_" + hex.EncodeToString(buff)
+}
+
+// isLocalPath reports whether the relay state is a path of the gateway,
+// which defends against open redirects.
+func isLocalPath(s string) bool {
+	return strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//") && !strings.HasPrefix(s, "/\\")
+}
+
+// login redirects the user to the identity provider by the HTTP-Redirect
+// binding.
+func (sp *SAMLServiceProvider) login(r context.HTTPRequest, w context.HTTPResponse) {
+	id := newID()
+
+	state, err := sp.state()
+	if err == nil {
+		err = state.Put("requests/"+id, "", requestTTL)
+	}
+	if err != nil {
+		sp.setLastError(fmt.Errorf("track request failed: %v", err))
+		w.SetStatusCode(http.StatusServiceUnavailable)
+		return
+	}
+
+	request := `<samlp:AuthnRequest xmlns:samlp="` + nsProtocol + `" xmlns:saml="` + nsAssertion + `"` +
+		` ID="` + id + `" Version="2.0" IssueInstant="` + sp.now().UTC().Format(time.RFC3339) + `"` +
+		` Destination="` + html.EscapeString(sp.spec.IdP.SSOURL) + `"` +
+		` AssertionConsumerServiceURL="` + html.EscapeString(sp.acsURL) + `"` +
+		` ProtocolBinding="` + bindingHTTPPost + `">` +
+		`<saml:Issuer>` + html.EscapeString(sp.spec.EntityID) + `</saml:Issuer>` +
+		`<samlp:NameIDPolicy Format="` + html.EscapeString(sp.spec.NameIDFormat) + `" AllowCreate="true"/>` +
+		`</samlp:AuthnRequest>`
+
+	buff := &bytes.Buffer{}
+	fw, _ := flate.NewWriter(buff, flate.DefaultCompression)
+	fw.Write([]byte(request))
+	fw.Close()
+
+	relayState := ""
+	if query, err := url.ParseQuery(r.Query()); err == nil {
+		relayState = query.Get("returnTo")
+	}
+	if !isLocalPath(relayState) {
+		relayState = sp.spec.DefaultRelayState
+	}
+
+	query := url.Values{}
+	query.Set("SAMLRequest", base64.StdEncoding.EncodeToString(buff.Bytes()))
+	query.Set("RelayState", relayState)
+
+	location := sp.spec.IdP.SSOURL
+	if strings.Contains(location, "?") {
+		location += "&" + query.Encode()
+	} else {
+		location += "?" + query.Encode()
+	}
+
+	atomic.AddUint64(&sp.redirected, 1)
+	w.Header().Set("Location", location)
+	w.SetStatusCode(http.StatusFound)
+}
+
+// acs consumes the response of the identity provider by the HTTP-POST
+// binding.
+func (sp *SAMLServiceProvider) acs(r context.HTTPRequest, w context.HTTPResponse) string {
+	form, err := readForm(r)
+	var user string
+	var attrs map[string][]string
+	if err == nil {
+		user, attrs, err = sp.consume(form)
+	}
+	if err != nil {
+		atomic.AddUint64(&sp.rejected, 1)
+		sp.setLastError(err)
+		w.SetStatusCode(http.StatusForbidden)
+		return resultRejected
+	}
+
+	relayState := form.Get("RelayState")
+	if !isLocalPath(relayState) {
+		relayState = sp.spec.DefaultRelayState
+	}
+
+	atomic.AddUint64(&sp.accepted, 1)
+	w.Header().Set(sp.spec.UserHeader, user)
+	for attr, header := range sp.spec.AttributeHeaders {
+		if values := attrs[attr]; len(values) > 0 {
+			w.Header().Set(header, strings.Join(values, ","))
+		}
+	}
+	w.Header().Set("Location", relayState)
+	w.SetStatusCode(http.StatusSeeOther)
+	return resultServed
+}
+
+func readForm(r context.HTTPRequest) (url.Values, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body(), maxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read body failed: %v", err)
+	}
+	if len(body) > maxBodySize {
+		return nil, fmt.Errorf("body is too large")
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("parse form failed: %v", err)
+	}
+	return form, nil
+}
+
+// consume validates the SAMLResponse of the form, and returns the user
+// and the attributes.
+func (sp *SAMLServiceProvider) consume(form url.Values) (string, map[string][]string, error) {
+	data, err := base64.StdEncoding.DecodeString(form.Get("SAMLResponse"))
+	if err != nil || len(data) == 0 {
+		return "", nil, fmt.Errorf("invalid SAMLResponse")
+	}
+
+	a, err := sp.validate(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	state, err := sp.state()
+	if err != nil {
+		return "", nil, fmt.Errorf("shared state is unavailable: %v", err)
+	}
+
+	if a.inResponseTo != "" {
+		_, ok, err := state.Get("requests/" + a.inResponseTo)
+		if err != nil {
+			return "", nil, err
+		}
+		if !ok {
+			return "", nil, fmt.Errorf("response to unknown request %s", a.inResponseTo)
+		}
+		state.Delete("requests/" + a.inResponseTo)
+	} else if !sp.spec.AllowIdPInitiated {
+		return "", nil, fmt.Errorf("unsolicited response is not allowed")
+	}
+
+	// NOTE: Assertions are consumed only once against replays.
+	ttl := a.notOnOrAfter.Add(sp.clockSkew).Sub(sp.now())
+	ok, err := state.PutIfAbsent("assertions/"+a.id, "", ttl)
+	if err != nil {
+		return "", nil, err
+	}
+	if !ok {
+		return "", nil, fmt.Errorf("assertion %s is replayed", a.id)
+	}
+
+	user := a.nameID
+	if sp.spec.UserAttribute != "" {
+		values := a.attributes[sp.spec.UserAttribute]
+		if len(values) == 0 {
+			return "", nil, fmt.Errorf("attribute %s not found", sp.spec.UserAttribute)
+		}
+		user = values[0]
+	}
+	if user == "" {
+		return "", nil, fmt.Errorf("user is empty")
+	}
+
+	return user, a.attributes, nil
+}
+
+func (sp *SAMLServiceProvider) setLastError(err error) {
+	logger.Warnf("saml service provider %s: %v", sp.filterSpec.Name(), err)
+
+	sp.mutex.Lock()
+	sp.lastError = err.Error()
+	sp.mutex.Unlock()
+}
+
+// Status returns status.
+func (sp *SAMLServiceProvider) Status() interface{} {
+	sp.mutex.Lock()
+	lastError := sp.lastError
+	sp.mutex.Unlock()
+
+	return &Status{
+		Redirected: atomic.LoadUint64(&sp.redirected),
+		Accepted:   atomic.LoadUint64(&sp.accepted),
+		Rejected:   atomic.LoadUint64(&sp.rejected),
+		LastError:  lastError,
+	}
+}
+
+// Close closes SAMLServiceProvider.
+func (sp *SAMLServiceProvider) Close() {}
@@ -0,0 +1,219 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package samlsp
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/sharedstate"
+	"github.com/megaease/easegress/pkg/util/xmldsig/xmldsigtest"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+const responseTemplate = `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="_resp" Version="2.0" IssueInstant="{{now}}" Destination="https://app.example.com/saml/acs" InResponseTo="{{request}}">` +
+	`<saml:Issuer xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">https://idp.example.com</saml:Issuer>` +
+	`<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>` +
+	`<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="{{assertion}}" Version="2.0" IssueInstant="{{now}}">` +
+	`<saml:Issuer>https://idp.example.com</saml:Issuer>` + xmldsigtest.Placeholder +
+	`<saml:Subject><saml:NameID>alice@example.com</saml:NameID>` +
+	`<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">` +
+	`<saml:SubjectConfirmationData InResponseTo="{{request}}" NotOnOrAfter="{{expire}}" Recipient="https://app.example.com/saml/acs"/>` +
+	`</saml:SubjectConfirmation></saml:Subject>` +
+	`<saml:Conditions NotBefore="{{now}}" NotOnOrAfter="{{expire}}">` +
+	`<saml:AudienceRestriction><saml:Audience>{{audience}}</saml:Audience></saml:AudienceRestriction></saml:Conditions>` +
+	`<saml:AttributeStatement>` +
+	`<saml:Attribute Name="groups"><saml:AttributeValue>admin</saml:AttributeValue><saml:AttributeValue>dev</saml:AttributeValue></saml:Attribute>` +
+	`</saml:AttributeStatement></saml:Assertion></samlp:Response>`
+
+func newSP(t *testing.T, signer *xmldsigtest.Signer) *SAMLServiceProvider {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(`
+kind: SAMLServiceProvider
+name: saml
+pathPrefix: /saml
+entityID: https://app.example.com/saml/metadata
+baseURL: https://app.example.com
+attributeHeaders:
+  groups: X-Groups
+idp:
+  entityID: https://idp.example.com
+  ssoURL: https://idp.example.com/sso
+  certificates:
+  - |
+`+indent(signer.PEM, "    ")), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sp := &SAMLServiceProvider{}
+	sp.Init(spec)
+	return sp
+}
+
+func indent(s, prefix string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return prefix + strings.Join(lines, "\n"+prefix) + "\n"
+}
+
+type response struct {
+	result string
+	code   int
+	header http.Header
+	body   string
+}
+
+func handle(sp *SAMLServiceProvider, method, path, query, body string) *response {
+	req := httptest.NewRequest(method, path+"?"+query, strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	ctx := contexttest.NewMockedHTTPContext(req, w)
+	resp := &response{result: sp.Handle(ctx), code: w.Code, header: w.Header()}
+	buff, _ := ioutil.ReadAll(ctx.Response().Body())
+	resp.body = string(buff)
+	return resp
+}
+
+// login requests the login endpoint and returns the ID of the AuthnRequest.
+func login(t *testing.T, sp *SAMLServiceProvider) string {
+	resp := handle(sp, http.MethodGet, "/saml/login", "returnTo=%2Forders", "")
+	if resp.code != http.StatusFound {
+		t.Fatalf("unexpected code %d", resp.code)
+	}
+
+	u, _ := url.Parse(resp.header.Get("Location"))
+	if u.Host != "idp.example.com" || u.Query().Get("RelayState") != "/orders" {
+		t.Fatalf("unexpected location %s", u)
+	}
+	compressed, _ := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	request, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		t.Fatalf("inflate request failed: %v", err)
+	}
+	if !strings.Contains(string(request), `AssertionConsumerServiceURL="https://app.example.com/saml/acs"`) {
+		t.Fatalf("unexpected request %s", request)
+	}
+
+	return regexp.MustCompile(`ID="([^"]+)"`).FindStringSubmatch(string(request))[1]
+}
+
+var assertionSeq int
+
+func samlResponse(t *testing.T, signer *xmldsigtest.Signer, replacements ...string) string {
+	now := time.Now().UTC()
+	r := strings.NewReplacer(append(replacements,
+		"{{now}}", now.Format(time.RFC3339),
+		"{{expire}}", now.Add(5*time.Minute).Format(time.RFC3339),
+		"{{audience}}", "https://app.example.com/saml/metadata",
+	)...)
+
+	assertionSeq++
+	id := fmt.Sprintf("_assertion%d", assertionSeq)
+	doc, err := signer.Sign(r.Replace(strings.Replace(responseTemplate, "{{assertion}}", id, -1)), id)
+	if err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+
+	form := url.Values{}
+	form.Set("SAMLResponse", base64.StdEncoding.EncodeToString([]byte(doc)))
+	form.Set("RelayState", "/orders")
+	return form.Encode()
+}
+
+func TestSAMLServiceProvider(t *testing.T) {
+	p, _ := sharedstate.New(&sharedstate.Spec{Provider: sharedstate.ProviderMemory}, nil)
+	defer p.Close()
+	sharedstate.SetProvider(p)
+	defer sharedstate.ResetProvider(p)
+
+	signer := xmldsigtest.NewSigner()
+	sp := newSP(t, signer)
+
+	resp := handle(sp, http.MethodGet, "/saml/metadata", "", "")
+	if resp.code != http.StatusOK || !strings.Contains(resp.body, `Location="https://app.example.com/saml/acs"`) {
+		t.Fatalf("unexpected metadata %d %s", resp.code, resp.body)
+	}
+
+	if resp = handle(sp, http.MethodGet, "/other", "", ""); resp.result != "" {
+		t.Fatalf("other paths should be passed through")
+	}
+
+	request := login(t, sp)
+	body := samlResponse(t, signer, "{{request}}", request)
+	resp = handle(sp, http.MethodPost, "/saml/acs", "", body)
+	if resp.result != resultServed || resp.code != http.StatusSeeOther {
+		t.Fatalf("unexpected result %s and code %d: %s", resp.result, resp.code, sp.Status().(*Status).LastError)
+	}
+	if resp.header.Get("X-Session-User") != "alice@example.com" ||
+		resp.header.Get("X-Groups") != "admin,dev" ||
+		resp.header.Get("Location") != "/orders" {
+		t.Fatalf("unexpected header %v", resp.header)
+	}
+
+	// Replays are rejected.
+	if resp = handle(sp, http.MethodPost, "/saml/acs", "", body); resp.result != resultRejected {
+		t.Fatalf("replayed response is accepted")
+	}
+
+	cases := map[string][]string{
+		"unknown request": {"{{request}}", "_unknown"},
+		"unsolicited":     {"{{request}}", ""},
+		"wrong audience":  {"{{request}}", login(t, sp), "{{audience}}", "https://other.example.com"},
+		"expired": {"{{request}}", login(t, sp),
+			"{{expire}}", time.Now().Add(-5 * time.Minute).UTC().Format(time.RFC3339)},
+	}
+	for name, replacements := range cases {
+		body := samlResponse(t, signer, replacements...)
+		if resp = handle(sp, http.MethodPost, "/saml/acs", "", body); resp.result != resultRejected {
+			t.Errorf("%s: response is accepted", name)
+		}
+	}
+
+	// Responses signed by others are rejected.
+	body = samlResponse(t, xmldsigtest.NewSigner(), "{{request}}", login(t, sp))
+	if resp = handle(sp, http.MethodPost, "/saml/acs", "", body); resp.result != resultRejected {
+		t.Fatalf("response signed by others is accepted")
+	}
+
+	status := sp.Status().(*Status)
+	if status.Accepted != 1 || status.Rejected != 6 || status.LastError == "" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package semanticcache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type (
+	// cache stores responses by the embeddings of their prompts,
+	// entries are isolated in buckets, e.g. by consumers and models.
+	cache struct {
+		maxEntries int
+
+		mutex   sync.Mutex
+		buckets map[string]map[*entry]struct{}
+		// order is the list of *entry, the oldest first.
+		order *list.List
+	}
+
+	entry struct {
+		bucket      string
+		embedding   []float64
+		contentType string
+		body        []byte
+		expireAt    time.Time
+		elem        *list.Element
+	}
+)
+
+func newCache(maxEntries int) *cache {
+	return &cache{
+		maxEntries: maxEntries,
+		buckets:    make(map[string]map[*entry]struct{}),
+		order:      list.New(),
+	}
+}
+
+// get returns the most similar entry whose similarity is not less than
+// the threshold, expired entries are removed.
+func (c *cache) get(bucket string, embedding []float64, threshold float64) (*entry, float64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	var best *entry
+	bestSimilarity := threshold
+	for e := range c.buckets[bucket] {
+		if !e.expireAt.After(now) {
+			c.removeLocked(e)
+			continue
+		}
+		if s := similarity(e.embedding, embedding); s >= bestSimilarity {
+			best, bestSimilarity = e, s
+		}
+	}
+	return best, bestSimilarity
+}
+
+func (c *cache) put(e *entry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entries := c.buckets[e.bucket]
+	if entries == nil {
+		entries = make(map[*entry]struct{})
+		c.buckets[e.bucket] = entries
+	}
+	entries[e] = struct{}{}
+	e.elem = c.order.PushBack(e)
+
+	for c.order.Len() > c.maxEntries {
+		c.removeLocked(c.order.Front().Value.(*entry))
+	}
+}
+
+func (c *cache) removeLocked(e *entry) {
+	c.order.Remove(e.elem)
+	entries := c.buckets[e.bucket]
+	delete(entries, e)
+	if len(entries) == 0 {
+		delete(c.buckets, e.bucket)
+	}
+}
+
+func (c *cache) len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
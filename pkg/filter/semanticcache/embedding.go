@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package semanticcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+type (
+	// EmbeddingSpec describes an OpenAI-compatible embedding API.
+	EmbeddingSpec struct {
+		URL     string            `yaml:"url" jsonschema:"required,format=uri"`
+		Model   string            `yaml:"model" jsonschema:"required"`
+		Headers map[string]string `yaml:"headers" jsonschema:"omitempty"`
+		Timeout string            `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	embedder struct {
+		spec   *EmbeddingSpec
+		client *http.Client
+	}
+)
+
+func newEmbedder(spec *EmbeddingSpec) *embedder {
+	timeout, err := time.ParseDuration(spec.Timeout)
+	if err != nil || timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &embedder{spec: spec, client: &http.Client{Timeout: timeout}}
+}
+
+// embed returns the normalized embedding of the text.
+func (e *embedder) embed(ctx context.HTTPContext, text string) ([]float64, error) {
+	body, _ := json.Marshal(map[string]string{"model": e.spec.Model, "input": text})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.spec.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.spec.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+	}
+
+	result := &struct {
+		Data []struct {
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}{}
+	if err = json.Unmarshal(body, result); err != nil {
+		return nil, fmt.Errorf("unmarshal embedding failed: %v", err)
+	}
+	if len(result.Data) == 0 || len(result.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("no embedding in the response")
+	}
+
+	v := result.Data[0].Embedding
+	if !normalize(v) {
+		return nil, fmt.Errorf("zero embedding")
+	}
+	return v, nil
+}
+
+// normalize normalizes the vector to unit length in place,
+// so the cosine similarity is the dot product.
+func normalize(v []float64) bool {
+	var sum float64
+	for _, x := range v {
+		sum += x * x
+	}
+	if sum == 0 {
+		return false
+	}
+	norm := math.Sqrt(sum)
+	for i := range v {
+		v[i] /= norm
+	}
+	return true
+}
+
+// similarity returns the cosine similarity of normalized vectors,
+// vectors of different dimensions are not similar at all.
+func similarity(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return -1
+	}
+	var dot float64
+	for i := range a {
+		dot += a[i] * b[i]
+	}
+	return dot
+}
@@ -0,0 +1,356 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package semanticcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/consumer"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const (
+	// Kind is the kind of SemanticCache.
+	Kind = "SemanticCache"
+
+	resultCached = "cached"
+
+	maxBodySize = 4 * 1024 * 1024
+
+	// headerCache is set to hit or miss on responses of cacheable requests.
+	headerCache = "X-Semantic-Cache"
+)
+
+var results = []string{resultCached}
+
+func init() {
+	httppipeline.Register(&SemanticCache{})
+}
+
+type (
+	// SemanticCache caches completions of LLM APIs by the embeddings of
+	// their prompts, and serves near-duplicate prompts from the cache.
+	SemanticCache struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		resolve  consumer.Resolver
+		embedder *embedder
+		ttl      time.Duration
+		cache    *cache
+
+		hits, misses, embeddingErrors uint64
+	}
+
+	// Spec describes the SemanticCache.
+	Spec struct {
+		// Consumer isolates cached completions per consumer, they are
+		// shared by all clients if it's empty.
+		Consumer  *consumer.Spec `yaml:"consumer" jsonschema:"omitempty"`
+		Embedding *EmbeddingSpec `yaml:"embedding" jsonschema:"required"`
+		// Threshold is the minimum cosine similarity of prompts to
+		// share a completion.
+		Threshold  float64 `yaml:"threshold" jsonschema:"omitempty"`
+		TTL        string  `yaml:"ttl" jsonschema:"omitempty,format=duration"`
+		MaxEntries int     `yaml:"maxEntries" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of SemanticCache.
+	Status struct {
+		Entries         int    `yaml:"entries"`
+		Hits            uint64 `yaml:"hits"`
+		Misses          uint64 `yaml:"misses"`
+		EmbeddingErrors uint64 `yaml:"embeddingErrors"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if spec.Threshold <= 0 || spec.Threshold > 1 {
+		return fmt.Errorf("threshold must be in (0, 1]")
+	}
+	if spec.MaxEntries < 0 {
+		return fmt.Errorf("maxEntries must not be negative")
+	}
+	if spec.TTL != "" {
+		ttl, err := time.ParseDuration(spec.TTL)
+		if err != nil {
+			return fmt.Errorf("invalid ttl: %v", err)
+		}
+		if ttl <= 0 {
+			return fmt.Errorf("ttl must be positive")
+		}
+	}
+	if spec.Consumer != nil {
+		if err := spec.Consumer.Validate(); err != nil {
+			return fmt.Errorf("consumer: %v", err)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of SemanticCache.
+func (sc *SemanticCache) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of SemanticCache.
+func (sc *SemanticCache) DefaultSpec() interface{} {
+	return &Spec{
+		Threshold:  0.95,
+		TTL:        "1h",
+		MaxEntries: 10000,
+	}
+}
+
+// Description returns the description of SemanticCache.
+func (sc *SemanticCache) Description() string {
+	return "SemanticCache serves completions of near-duplicate prompts from the cache."
+}
+
+// Results returns the results of SemanticCache.
+func (sc *SemanticCache) Results() []string {
+	return results
+}
+
+// Init initializes SemanticCache.
+func (sc *SemanticCache) Init(filterSpec *httppipeline.FilterSpec) {
+	sc.filterSpec, sc.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	sc.reload(nil)
+}
+
+// Inherit inherits previous generation of SemanticCache.
+func (sc *SemanticCache) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+
+	prev := previousGeneration.(*SemanticCache)
+	sc.filterSpec, sc.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+
+	// NOTE: The cached entries are dropped if the embedding model
+	// is changed, since embeddings of different models are not comparable.
+	if prev.spec.Embedding.URL == sc.spec.Embedding.URL &&
+		prev.spec.Embedding.Model == sc.spec.Embedding.Model {
+		sc.reload(prev.cache)
+	} else {
+		sc.reload(nil)
+	}
+}
+
+func (sc *SemanticCache) reload(prev *cache) {
+	if sc.spec.Consumer != nil {
+		sc.resolve = consumer.NewResolver(sc.spec.Consumer)
+	} else {
+		sc.resolve = func(ctx context.HTTPContext) string { return "" }
+	}
+
+	sc.embedder = newEmbedder(sc.spec.Embedding)
+
+	sc.ttl, _ = time.ParseDuration(sc.spec.TTL)
+	if sc.ttl <= 0 {
+		sc.ttl = time.Hour
+	}
+
+	maxEntries := sc.spec.MaxEntries
+	if maxEntries == 0 {
+		maxEntries = 10000
+	}
+	if prev != nil {
+		prev.mutex.Lock()
+		prev.maxEntries = maxEntries
+		prev.mutex.Unlock()
+		sc.cache = prev
+	} else {
+		sc.cache = newCache(maxEntries)
+	}
+}
+
+// Handle handles HTTPContext.
+func (sc *SemanticCache) Handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	original := r.Body()
+	body, err := ioutil.ReadAll(io.LimitReader(original, maxBodySize+1))
+	if err != nil || len(body) > maxBodySize {
+		r.SetBody(io.MultiReader(bytes.NewReader(body), original))
+		return ctx.CallNextHandler("")
+	}
+	r.SetBody(bytes.NewReader(body))
+
+	bucket, prompt := sc.keyOf(ctx, body)
+	if prompt == "" {
+		return ctx.CallNextHandler("")
+	}
+
+	embedding, err := sc.embedder.embed(ctx, prompt)
+	if err != nil {
+		atomic.AddUint64(&sc.embeddingErrors, 1)
+		ctx.AddTag(fmt.Sprintf("semanticCache: embedding failed: %v", err))
+		return ctx.CallNextHandler("")
+	}
+
+	w := ctx.Response()
+	if e, similarity := sc.cache.get(bucket, embedding, sc.spec.Threshold); e != nil {
+		atomic.AddUint64(&sc.hits, 1)
+		ctx.AddTag(fmt.Sprintf("semanticCache: hit with similarity %.4f", similarity))
+		w.SetStatusCode(http.StatusOK)
+		w.Header().Set(httpheader.KeyContentType, e.contentType)
+		w.Header().Set(headerCache, "hit")
+		w.SetBody(bytes.NewReader(e.body))
+		return ctx.CallNextHandler(resultCached)
+	}
+
+	atomic.AddUint64(&sc.misses, 1)
+	result := ctx.CallNextHandler("")
+	sc.store(ctx, bucket, embedding)
+	return result
+}
+
+// keyOf returns the cache bucket and the prompt of the request, the prompt
+// is empty if the request is not cacheable. Requests share a bucket only if
+// they are of the same consumer and have the same parameters except prompts.
+func (sc *SemanticCache) keyOf(ctx context.HTTPContext, body []byte) (string, string) {
+	var m map[string]interface{}
+	if json.Unmarshal(body, &m) != nil {
+		return "", ""
+	}
+	// NOTE: Streamed completions are not cached.
+	if stream, _ := m["stream"].(bool); stream {
+		return "", ""
+	}
+
+	prompt := promptOf(m)
+	if prompt == "" {
+		return "", ""
+	}
+
+	delete(m, "messages")
+	delete(m, "prompt")
+	delete(m, "input")
+	params, _ := json.Marshal(m)
+	sum := sha256.Sum256(params)
+
+	return sc.resolve(ctx) + "/" + ctx.Request().Path() + "/" + hex.EncodeToString(sum[:]), prompt
+}
+
+// promptOf returns the prompt of chat completions, completions and
+// embeddings requests, roles of messages are kept to tell them apart.
+func promptOf(m map[string]interface{}) string {
+	var sb strings.Builder
+	if messages, ok := m["messages"].([]interface{}); ok {
+		for _, msg := range messages {
+			msg, ok := msg.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			role, _ := msg["role"].(string)
+			sb.WriteString(role)
+			sb.WriteString(": ")
+			writeText(&sb, msg["content"])
+			sb.WriteString("\n")
+		}
+	}
+	for _, key := range []string{"prompt", "input"} {
+		writeText(&sb, m[key])
+	}
+	return sb.String()
+}
+
+func writeText(sb *strings.Builder, v interface{}) {
+	switch v := v.(type) {
+	case string:
+		sb.WriteString(v)
+	case []interface{}:
+		for _, item := range v {
+			if part, ok := item.(map[string]interface{}); ok {
+				writeText(sb, part["text"])
+			} else {
+				writeText(sb, item)
+			}
+			sb.WriteString("\n")
+		}
+	}
+}
+
+// store caches the successful JSON response.
+func (sc *SemanticCache) store(ctx context.HTTPContext, bucket string, embedding []float64) {
+	w := ctx.Response()
+	contentType := w.Header().Get(httpheader.KeyContentType)
+	if w.StatusCode() != http.StatusOK || w.Body() == nil ||
+		w.Header().Get(httpheader.KeyContentEncoding) != "" ||
+		!strings.Contains(contentType, "json") {
+		return
+	}
+
+	original := w.Body()
+	body, err := ioutil.ReadAll(io.LimitReader(original, maxBodySize+1))
+	if err != nil || len(body) > maxBodySize {
+		w.SetBody(struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), original), closerOf(original)})
+		return
+	}
+	if c, ok := original.(io.Closer); ok {
+		c.Close()
+	}
+	w.Header().Set(headerCache, "miss")
+	w.Header().Set(httpheader.KeyContentLength, strconv.Itoa(len(body)))
+	w.SetBody(bytes.NewReader(body))
+
+	if !json.Valid(body) {
+		return
+	}
+	sc.cache.put(&entry{
+		bucket:      bucket,
+		embedding:   embedding,
+		contentType: contentType,
+		body:        body,
+		expireAt:    time.Now().Add(sc.ttl),
+	})
+}
+
+func closerOf(r io.Reader) io.Closer {
+	if c, ok := r.(io.Closer); ok {
+		return c
+	}
+	return ioutil.NopCloser(nil)
+}
+
+// Status returns status.
+func (sc *SemanticCache) Status() interface{} {
+	return &Status{
+		Entries:         sc.cache.len(),
+		Hits:            atomic.LoadUint64(&sc.hits),
+		Misses:          atomic.LoadUint64(&sc.misses),
+		EmbeddingErrors: atomic.LoadUint64(&sc.embeddingErrors),
+	}
+}
+
+// Close closes SemanticCache.
+func (sc *SemanticCache) Close() {}
@@ -0,0 +1,250 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package semanticcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+var vocabulary = []string{"weather", "paris", "london", "joke", "system"}
+
+// newEmbeddingServer returns a server embedding texts by the counts of
+// the words of the vocabulary.
+func newEmbeddingServer(t *testing.T, calls *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		req := struct {
+			Model string `json:"model"`
+			Input string `json:"input"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Model != "embed" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		text := strings.ToLower(req.Input)
+		v := []float64{0.01}
+		for _, word := range vocabulary {
+			v = append(v, float64(strings.Count(text, word)))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": []interface{}{map[string]interface{}{"embedding": v}},
+		})
+	}))
+}
+
+func newSemanticCache(t *testing.T, yamlSpec string) *SemanticCache {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sc := &SemanticCache{}
+	sc.Init(spec)
+	return sc
+}
+
+func specOf(url string) string {
+	return fmt.Sprintf(`
+kind: SemanticCache
+name: cache
+consumer:
+  source: Header
+  name: X-Consumer
+embedding:
+  url: %s
+  model: embed
+  headers:
+    Authorization: Bearer key
+threshold: 0.9
+ttl: 1h
+maxEntries: 3
+`, url)
+}
+
+type exchange struct {
+	result   string
+	code     int
+	upstream bool
+	body     string
+	cache    string
+}
+
+func handle(sc *SemanticCache, consumer, reqBody string, code int, response string) *exchange {
+	e := &exchange{}
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(reqBody))
+	req.Header.Set("X-Consumer", consumer)
+	w := httptest.NewRecorder()
+
+	ctx := contexttest.NewMockedHTTPContext(req, w)
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		if lastResult != "" {
+			return lastResult
+		}
+		e.upstream = true
+		ioutil.ReadAll(ctx.Request().Body())
+		w.Code = code
+		w.Header().Set("Content-Type", "application/json")
+		ctx.Response().SetBody(strings.NewReader(response))
+		return ""
+	}
+
+	e.result = sc.Handle(ctx)
+	e.code = w.Code
+	e.cache = w.Header().Get(headerCache)
+	buff, _ := ioutil.ReadAll(ctx.Response().Body())
+	e.body = string(buff)
+	return e
+}
+
+func chat(content string) string {
+	return fmt.Sprintf(`{"model":"gpt","messages":[{"role":"user","content":%q}]}`, content)
+}
+
+func TestSemanticCache(t *testing.T) {
+	calls := 0
+	server := newEmbeddingServer(t, &calls)
+	defer server.Close()
+
+	sc := newSemanticCache(t, specOf(server.URL))
+	defer sc.Close()
+
+	e := handle(sc, "alice", chat("What is the weather in Paris?"), http.StatusOK, `{"answer":"sunny"}`)
+	if e.result != "" || !e.upstream || e.cache != "miss" || e.body != `{"answer":"sunny"}` {
+		t.Fatalf("unexpected exchange: %+v", e)
+	}
+
+	e = handle(sc, "alice", chat("Tell me the weather of paris"), http.StatusOK, `{"answer":"rainy"}`)
+	if e.result != resultCached || e.upstream || e.cache != "hit" || e.body != `{"answer":"sunny"}` {
+		t.Fatalf("near-duplicate prompt should hit the cache: %+v", e)
+	}
+
+	e = handle(sc, "alice", chat("What is the weather in London?"), http.StatusOK, `{"answer":"foggy"}`)
+	if !e.upstream || e.cache != "miss" {
+		t.Fatalf("different prompt should miss the cache: %+v", e)
+	}
+
+	e = handle(sc, "bob", chat("What is the weather in Paris?"), http.StatusOK, `{"answer":"cloudy"}`)
+	if !e.upstream || e.body != `{"answer":"cloudy"}` {
+		t.Fatalf("cache should be isolated per consumer: %+v", e)
+	}
+
+	e = handle(sc, "alice", `{"model":"gpt","temperature":1,"messages":[{"role":"user","content":"weather in Paris"}]}`,
+		http.StatusOK, `{"answer":"windy"}`)
+	if !e.upstream {
+		t.Fatalf("requests with different parameters should not share completions: %+v", e)
+	}
+
+	e = handle(sc, "alice", `{"model":"gpt","stream":true,"messages":[{"role":"user","content":"weather in Paris"}]}`,
+		http.StatusOK, `{}`)
+	if !e.upstream || e.cache != "" {
+		t.Fatalf("streamed requests should not be cached: %+v", e)
+	}
+
+	e = handle(sc, "alice", chat("Tell me a joke"), http.StatusInternalServerError, `{"error":"oops"}`)
+	e = handle(sc, "alice", chat("Tell me a joke"), http.StatusOK, `{"answer":"haha"}`)
+	if !e.upstream || e.body != `{"answer":"haha"}` {
+		t.Fatalf("failed responses should not be cached: %+v", e)
+	}
+
+	status := sc.Status().(*Status)
+	if status.Hits != 1 || status.Entries != 3 || status.EmbeddingErrors != 0 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if calls != 7 {
+		t.Fatalf("expected 7 embedding calls, got %d", calls)
+	}
+}
+
+func TestEmbeddingError(t *testing.T) {
+	sc := newSemanticCache(t, specOf("http://127.0.0.1:1/v1/embeddings"))
+	defer sc.Close()
+
+	e := handle(sc, "alice", chat("hello"), http.StatusOK, `{"answer":"hi"}`)
+	if e.result != "" || !e.upstream || e.body != `{"answer":"hi"}` {
+		t.Fatalf("embedding errors should pass requests through: %+v", e)
+	}
+	if sc.Status().(*Status).EmbeddingErrors != 1 {
+		t.Fatalf("embedding error should be counted")
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := newCache(2)
+	v := []float64{1, 0}
+	normalize(v)
+
+	c.put(&entry{bucket: "a", embedding: v, body: []byte("1"), expireAt: time.Now().Add(-time.Second)})
+	if e, _ := c.get("a", v, 0.9); e != nil {
+		t.Fatalf("expired entry should not be returned")
+	}
+	if c.len() != 0 {
+		t.Fatalf("expired entry should be removed")
+	}
+
+	for i := 0; i < 3; i++ {
+		c.put(&entry{bucket: "a", embedding: v, body: []byte{byte('0' + i)}, expireAt: time.Now().Add(time.Hour)})
+	}
+	if c.len() != 2 {
+		t.Fatalf("the oldest entry should be evicted, got %d entries", c.len())
+	}
+	if e, s := c.get("a", []float64{0.6, 0.8}, 0.5); e == nil || s < 0.59 || s > 0.61 {
+		t.Fatalf("unexpected similarity %f", s)
+	}
+	if e, _ := c.get("b", v, 0.5); e != nil {
+		t.Fatalf("buckets should be isolated")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, spec := range []Spec{
+		{Threshold: 0},
+		{Threshold: 1.5},
+		{Threshold: 0.9, MaxEntries: -1},
+		{Threshold: 0.9, TTL: "-1s"},
+	} {
+		if spec.Validate() == nil {
+			t.Errorf("spec %+v should be invalid", spec)
+		}
+	}
+}
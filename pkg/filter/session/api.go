@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/api"
+)
+
+const (
+	apiGroupName = "session_admin"
+	apiPrefix    = "/sessions/{realm}"
+)
+
+var (
+	realmsMutex  sync.RWMutex
+	realms       = make(map[string]*serverStore)
+	registerOnce sync.Once
+)
+
+type (
+	// UserSession is a session of a user, the ID is not exposed as it's
+	// the credential of the user.
+	UserSession struct {
+		CreatedAt  time.Time         `yaml:"createdAt"`
+		LastSeen   time.Time         `yaml:"lastSeen"`
+		Attributes map[string]string `yaml:"attributes,omitempty"`
+	}
+
+	// DestroyResult is the result of destroying sessions of a user.
+	DestroyResult struct {
+		Destroyed int `yaml:"destroyed"`
+	}
+)
+
+func registerRealm(realm string, ss *serverStore) {
+	registerOnce.Do(registerAPIs)
+
+	realmsMutex.Lock()
+	defer realmsMutex.Unlock()
+
+	realms[realm] = ss
+}
+
+func unregisterRealm(realm string, ss *serverStore) {
+	realmsMutex.Lock()
+	defer realmsMutex.Unlock()
+
+	// NOTE: Other filters of the realm may have registered themselves.
+	if realms[realm] == ss {
+		delete(realms, realm)
+	}
+}
+
+func registerAPIs() {
+	api.RegisterAPIs(&api.Group{
+		Group: apiGroupName,
+		Entries: []*api.Entry{
+			{Path: apiPrefix + "/users/{user}", Method: "GET", Handler: listUserSessions},
+			{Path: apiPrefix + "/users/{user}", Method: "DELETE", Handler: destroyUserSessions},
+		},
+	})
+}
+
+func getRealm(w http.ResponseWriter, r *http.Request) *serverStore {
+	realm := chi.URLParam(r, "realm")
+
+	realmsMutex.RLock()
+	ss := realms[realm]
+	realmsMutex.RUnlock()
+
+	if ss == nil {
+		api.HandleAPIError(w, r, http.StatusNotFound,
+			fmt.Errorf("realm %s of the server store not found", realm))
+	}
+	return ss
+}
+
+func writeYAML(w http.ResponseWriter, v interface{}) {
+	buff, err := yaml.Marshal(v)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", v, err))
+	}
+	w.Header().Set("Content-Type", "text/vnd.yaml")
+	w.Write(buff)
+}
+
+func listUserSessions(w http.ResponseWriter, r *http.Request) {
+	ss := getRealm(w, r)
+	if ss == nil {
+		return
+	}
+
+	state, err := ss.state()
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+	sessions, err := ss.userSessions(state, chi.URLParam(r, "user"))
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	result := make([]*UserSession, len(sessions))
+	for i, sess := range sessions {
+		result[i] = &UserSession{
+			CreatedAt:  time.Unix(sess.CreatedAt, 0).UTC(),
+			LastSeen:   time.Unix(sess.LastSeen, 0).UTC(),
+			Attributes: sess.Attributes,
+		}
+	}
+	writeYAML(w, result)
+}
+
+func destroyUserSessions(w http.ResponseWriter, r *http.Request) {
+	ss := getRealm(w, r)
+	if ss == nil {
+		return
+	}
+
+	n, err := ss.destroyUser(chi.URLParam(r, "user"))
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeYAML(w, &DestroyResult{Destroyed: n})
+}
@@ -0,0 +1,434 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package session provides the Session filter, which manages sessions of
+// browsers on behalf of the backends.
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of Session.
+	Kind = "Session"
+
+	// ActionLogin creates sessions by the responses of the backend.
+	ActionLogin = "login"
+	// ActionLogout destroys sessions.
+	ActionLogout = "logout"
+	// ActionRequire rejects requests without valid sessions.
+	ActionRequire = "require"
+
+	// StoreCookie keeps sessions in encrypted cookies.
+	StoreCookie = "cookie"
+	// StoreServer keeps sessions in the shared state, cookies only
+	// carry the random session IDs.
+	StoreServer = "server"
+
+	resultUnauthenticated = "unauthenticated"
+	resultUnavailable     = "unavailable"
+
+	defaultCookieName = "EG_SESSION"
+	minSecretLength   = 16
+)
+
+var results = []string{resultUnauthenticated, resultUnavailable}
+
+func init() {
+	httppipeline.Register(&Session{})
+}
+
+type (
+	// Session is the filter managing sessions of browsers, so legacy
+	// backends get the authenticated user from a header instead of
+	// managing sessions themselves. A login pipeline creates the session
+	// from the user header of the backend response, a logout pipeline
+	// destroys it, and other pipelines require it.
+	Session struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		store           store
+		idleTimeout     time.Duration
+		absoluteTimeout time.Duration
+		// touchInterval is how long the last seen time of a session is
+		// kept before updating, so sessions are not written by every
+		// request, at the cost of the idle timeout being slightly late.
+		touchInterval time.Duration
+
+		created   uint64
+		destroyed uint64
+		evicted   uint64
+		rejected  uint64
+
+		// now is replaced in tests.
+		now func() time.Time
+	}
+
+	// Spec describes the Session.
+	Spec struct {
+		Action string `yaml:"action" jsonschema:"required,enum=login,enum=logout,enum=require"`
+		// Realm is shared by the Session filters of the same sessions.
+		Realm string `yaml:"realm" jsonschema:"required"`
+		Store string `yaml:"store" jsonschema:"omitempty,enum=cookie,enum=server"`
+		// Secret is the key to encrypt cookies of the cookie store,
+		// which must be the same in all filters of the realm.
+		Secret          string      `yaml:"secret" jsonschema:"omitempty"`
+		Cookie          *CookieSpec `yaml:"cookie" jsonschema:"omitempty"`
+		IdleTimeout     string      `yaml:"idleTimeout" jsonschema:"omitempty,format=duration"`
+		AbsoluteTimeout string      `yaml:"absoluteTimeout" jsonschema:"omitempty,format=duration"`
+		// MaxSessionsPerUser evicts the oldest sessions of the user on
+		// login if exceeded, it's supported by the server store only.
+		MaxSessionsPerUser int `yaml:"maxSessionsPerUser" jsonschema:"omitempty,minimum=0"`
+		// UserHeader is the response header of the login backend carrying
+		// the user, and the request header to the other backends.
+		UserHeader string `yaml:"userHeader" jsonschema:"omitempty"`
+		// AttributeHeaders are the response headers of the login backend
+		// kept in the session and sent to the other backends.
+		AttributeHeaders []string `yaml:"attributeHeaders" jsonschema:"omitempty,uniqueItems=true"`
+		// LoginURL is where browsers are redirected to if the session
+		// is required, 401 is responded if it's empty.
+		LoginURL string `yaml:"loginURL" jsonschema:"omitempty"`
+	}
+
+	// CookieSpec describes the session cookie.
+	CookieSpec struct {
+		Name     string `yaml:"name" jsonschema:"omitempty"`
+		Domain   string `yaml:"domain" jsonschema:"omitempty"`
+		Path     string `yaml:"path" jsonschema:"omitempty"`
+		Secure   bool   `yaml:"secure" jsonschema:"omitempty"`
+		SameSite string `yaml:"sameSite" jsonschema:"omitempty,enum=Lax,enum=Strict,enum=None"`
+	}
+
+	// Status is the status of Session.
+	Status struct {
+		Created   uint64 `yaml:"created"`
+		Destroyed uint64 `yaml:"destroyed"`
+		Evicted   uint64 `yaml:"evicted"`
+		Rejected  uint64 `yaml:"rejected"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if spec.Store == StoreCookie {
+		if len(spec.Secret) < minSecretLength {
+			return fmt.Errorf("secret of at least %d bytes is required by the cookie store", minSecretLength)
+		}
+		if spec.MaxSessionsPerUser > 0 {
+			return fmt.Errorf("maxSessionsPerUser is supported by the server store only")
+		}
+	}
+
+	idle, _ := time.ParseDuration(spec.IdleTimeout)
+	absolute, _ := time.ParseDuration(spec.AbsoluteTimeout)
+	if idle <= 0 || absolute <= 0 {
+		return fmt.Errorf("timeouts must be positive")
+	}
+	if idle > absolute {
+		return fmt.Errorf("idleTimeout is longer than absoluteTimeout")
+	}
+
+	if spec.LoginURL != "" {
+		if _, err := url.Parse(spec.LoginURL); err != nil {
+			return fmt.Errorf("invalid loginURL: %v", err)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of Session.
+func (s *Session) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of Session.
+func (s *Session) DefaultSpec() interface{} {
+	return &Spec{
+		Store:           StoreCookie,
+		IdleTimeout:     "30m",
+		AbsoluteTimeout: "8h",
+		UserHeader:      "X-Session-User",
+	}
+}
+
+// Description returns the description of Session.
+func (s *Session) Description() string {
+	return "Session creates, destroys and requires sessions of browsers."
+}
+
+// Results returns the results of Session.
+func (s *Session) Results() []string {
+	return results
+}
+
+// Init initializes Session.
+func (s *Session) Init(filterSpec *httppipeline.FilterSpec) {
+	s.filterSpec, s.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	s.reload()
+}
+
+// Inherit inherits previous generation of Session.
+func (s *Session) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	s.Init(filterSpec)
+}
+
+func (s *Session) reload() {
+	if s.spec.Cookie == nil {
+		s.spec.Cookie = &CookieSpec{}
+	}
+	if s.spec.Cookie.Name == "" {
+		s.spec.Cookie.Name = defaultCookieName
+	}
+	if s.spec.Cookie.Path == "" {
+		s.spec.Cookie.Path = "/"
+	}
+
+	s.idleTimeout, _ = time.ParseDuration(s.spec.IdleTimeout)
+	s.absoluteTimeout, _ = time.ParseDuration(s.spec.AbsoluteTimeout)
+	s.touchInterval = s.idleTimeout / 10
+	if s.touchInterval > time.Minute {
+		s.touchInterval = time.Minute
+	}
+	s.now = time.Now
+
+	if s.spec.Store == StoreServer {
+		ss := newServerStore(s)
+		s.store = ss
+		registerRealm(s.spec.Realm, ss)
+	} else {
+		s.store = newCookieStore(s.spec.Realm, s.spec.Secret)
+	}
+}
+
+// Handle handles HTTPContext.
+func (s *Session) Handle(ctx context.HTTPContext) string {
+	switch s.spec.Action {
+	case ActionLogin:
+		result := ctx.CallNextHandler("")
+		s.login(ctx)
+		return result
+	case ActionLogout:
+		s.logout(ctx)
+		return ctx.CallNextHandler("")
+	default:
+		result := s.require(ctx)
+		return ctx.CallNextHandler(result)
+	}
+}
+
+// current returns the cookie value and the valid session of the request.
+func (s *Session) current(ctx context.HTTPContext) (string, *session, error) {
+	c, err := ctx.Request().Cookie(s.spec.Cookie.Name)
+	if err != nil || c.Value == "" {
+		return "", nil, nil
+	}
+
+	sess, err := s.store.load(c.Value)
+	if err != nil || sess == nil {
+		return c.Value, nil, err
+	}
+	if s.expired(sess) {
+		return c.Value, nil, nil
+	}
+	return c.Value, sess, nil
+}
+
+func (s *Session) expired(sess *session) bool {
+	now := s.now()
+	return now.Sub(time.Unix(sess.LastSeen, 0)) > s.idleTimeout ||
+		now.Sub(time.Unix(sess.CreatedAt, 0)) > s.absoluteTimeout
+}
+
+// ttl returns how long the session lives if it's not used anymore.
+func (s *Session) ttl(sess *session) time.Duration {
+	now := s.now()
+	ttl := time.Unix(sess.CreatedAt, 0).Add(s.absoluteTimeout).Sub(now)
+	if ttl > s.idleTimeout {
+		ttl = s.idleTimeout
+	}
+	return ttl
+}
+
+func (s *Session) login(ctx context.HTTPContext) {
+	w := ctx.Response()
+	user := w.Header().Get(s.spec.UserHeader)
+	// NOTE: Login backends usually redirect users after success.
+	if user == "" || w.StatusCode() < 200 || w.StatusCode() > 399 {
+		return
+	}
+
+	// NOTE: The headers are for the gateway only.
+	w.Header().Del(s.spec.UserHeader)
+	sess := newSession(user, s.now())
+	for _, h := range s.spec.AttributeHeaders {
+		if v := w.Header().Get(h); v != "" {
+			sess.Attributes[h] = v
+		}
+		w.Header().Del(h)
+	}
+
+	// NOTE: The previous session is destroyed against session fixation.
+	if value, old, _ := s.current(ctx); old != nil {
+		s.store.destroy(value, old)
+		atomic.AddUint64(&s.destroyed, 1)
+	}
+
+	value, err := s.store.save(sess, s.ttl(sess))
+	if err != nil {
+		logger.Errorf("create session of %s failed: %v", user, err)
+		w.SetStatusCode(http.StatusServiceUnavailable)
+		return
+	}
+	atomic.AddUint64(&s.created, 1)
+
+	if ss, ok := s.store.(*serverStore); ok {
+		n, err := ss.track(sess, s.spec.MaxSessionsPerUser, s.absoluteTimeout)
+		if err != nil {
+			logger.Errorf("track sessions of %s failed: %v", user, err)
+		}
+		atomic.AddUint64(&s.evicted, uint64(n))
+	}
+
+	s.setCookie(w, value, s.absoluteTimeout)
+}
+
+func (s *Session) logout(ctx context.HTTPContext) {
+	value, sess, _ := s.current(ctx)
+	if sess != nil {
+		s.store.destroy(value, sess)
+		atomic.AddUint64(&s.destroyed, 1)
+	}
+	if value != "" {
+		s.setCookie(ctx.Response(), "", -1)
+	}
+}
+
+func (s *Session) require(ctx context.HTTPContext) string {
+	r, w := ctx.Request(), ctx.Response()
+
+	// NOTE: Clients must not authenticate themselves by the headers.
+	r.Header().Del(s.spec.UserHeader)
+	for _, h := range s.spec.AttributeHeaders {
+		r.Header().Del(h)
+	}
+
+	value, sess, err := s.current(ctx)
+	if err != nil {
+		logger.Errorf("load session failed: %v", err)
+		w.SetStatusCode(http.StatusServiceUnavailable)
+		return resultUnavailable
+	}
+	if sess == nil {
+		atomic.AddUint64(&s.rejected, 1)
+		if value != "" {
+			s.setCookie(w, "", -1)
+		}
+		if s.spec.LoginURL != "" && r.Method() == http.MethodGet {
+			w.Header().Set("Location", s.spec.LoginURL)
+			w.SetStatusCode(http.StatusFound)
+		} else {
+			w.SetStatusCode(http.StatusUnauthorized)
+		}
+		return resultUnauthenticated
+	}
+
+	now := s.now()
+	if now.Sub(time.Unix(sess.LastSeen, 0)) >= s.touchInterval {
+		sess.LastSeen = now.Unix()
+		newValue, err := s.store.save(sess, s.ttl(sess))
+		if err != nil {
+			logger.Errorf("touch session of %s failed: %v", sess.User, err)
+		} else if newValue != value {
+			s.setCookie(w, newValue, time.Unix(sess.CreatedAt, 0).Add(s.absoluteTimeout).Sub(now))
+		}
+	}
+
+	r.Header().Set(s.spec.UserHeader, sess.User)
+	for k, v := range sess.Attributes {
+		r.Header().Set(k, v)
+	}
+	s.stripCookie(r)
+
+	return ""
+}
+
+// stripCookie removes the session cookie from the request, which is
+// meaningless to the backends.
+func (s *Session) stripCookie(r context.HTTPRequest) {
+	cookies := r.Cookies()
+	r.Header().Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != s.spec.Cookie.Name {
+			r.AddCookie(c)
+		}
+	}
+}
+
+// setCookie sets the session cookie, it's deleted if maxAge is negative.
+func (s *Session) setCookie(w context.HTTPResponse, value string, maxAge time.Duration) {
+	c := &http.Cookie{
+		Name:     s.spec.Cookie.Name,
+		Value:    value,
+		Domain:   s.spec.Cookie.Domain,
+		Path:     s.spec.Cookie.Path,
+		Secure:   s.spec.Cookie.Secure,
+		HttpOnly: true,
+		MaxAge:   int(maxAge / time.Second),
+	}
+	if maxAge < 0 {
+		c.MaxAge = -1
+	}
+
+	switch s.spec.Cookie.SameSite {
+	case "Strict":
+		c.SameSite = http.SameSiteStrictMode
+	case "None":
+		c.SameSite = http.SameSiteNoneMode
+	default:
+		c.SameSite = http.SameSiteLaxMode
+	}
+
+	w.SetCookie(c)
+}
+
+// Status returns status.
+func (s *Session) Status() interface{} {
+	return &Status{
+		Created:   atomic.LoadUint64(&s.created),
+		Destroyed: atomic.LoadUint64(&s.destroyed),
+		Evicted:   atomic.LoadUint64(&s.evicted),
+		Rejected:  atomic.LoadUint64(&s.rejected),
+	}
+}
+
+// Close closes Session.
+func (s *Session) Close() {
+	if ss, ok := s.store.(*serverStore); ok {
+		unregisterRealm(s.spec.Realm, ss)
+	}
+}
@@ -0,0 +1,274 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/sharedstate"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newSessionFilter(t *testing.T, yamlConfig string) *Session {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlConfig), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s := &Session{}
+	s.Init(spec)
+	return s
+}
+
+type result struct {
+	result  string
+	code    int
+	req     *http.Request
+	header  http.Header
+	cookies []*http.Cookie
+}
+
+// handle handles a request with the cookie, next is the backend.
+func handle(s *Session, method, cookie string, reqHeader http.Header, next func(w http.Header) int) *result {
+	stdr := httptest.NewRequest(method, "/", nil)
+	for k, v := range reqHeader {
+		stdr.Header[k] = v
+	}
+	if cookie != "" {
+		stdr.AddCookie(&http.Cookie{Name: defaultCookieName, Value: cookie})
+	}
+	stdr.AddCookie(&http.Cookie{Name: "other", Value: "1"})
+	w := httptest.NewRecorder()
+
+	ctx := contexttest.NewMockedHTTPContext(stdr, w)
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		if lastResult == "" && next != nil {
+			w.Code = next(w.Header())
+		}
+		return lastResult
+	}
+
+	res := &result{req: stdr, result: s.Handle(ctx)}
+	res.code, res.header, res.cookies = w.Code, w.Header(), w.Result().Cookies()
+	return res
+}
+
+func loginBackend(user string) func(w http.Header) int {
+	return func(w http.Header) int {
+		w.Set("X-Session-User", user)
+		w.Set("X-Roles", "admin")
+		return http.StatusOK
+	}
+}
+
+func sessionCookie(t *testing.T, res *result) string {
+	for _, c := range res.cookies {
+		if c.Name == defaultCookieName {
+			return c.Value
+		}
+	}
+	t.Fatalf("no session cookie is set")
+	return ""
+}
+
+func TestCookieStore(t *testing.T) {
+	const spec = `
+kind: Session
+name: session
+realm: app
+secret: 0123456789abcdef
+idleTimeout: 10m
+absoluteTimeout: 1h
+attributeHeaders: [X-Roles]
+`
+	login := newSessionFilter(t, spec+"action: login\n")
+	require := newSessionFilter(t, spec+"action: require\nloginURL: /login\n")
+	logout := newSessionFilter(t, spec+"action: logout\n")
+
+	res := handle(login, http.MethodPost, "", nil, loginBackend("alice"))
+	cookie := sessionCookie(t, res)
+	if res.header.Get("X-Session-User") != "" || res.header.Get("X-Roles") != "" {
+		t.Fatalf("session headers are leaked to the client")
+	}
+	if res.cookies[0].MaxAge != 3600 || !res.cookies[0].HttpOnly {
+		t.Fatalf("unexpected cookie %+v", res.cookies[0])
+	}
+
+	res = handle(require, http.MethodGet, cookie, http.Header{"X-Roles": {"root"}}, nil)
+	if res.result != "" {
+		t.Fatalf("unexpected result %s", res.result)
+	}
+	if res.req.Header.Get("X-Session-User") != "alice" || res.req.Header.Get("X-Roles") != "admin" {
+		t.Fatalf("unexpected request header %v", res.req.Header)
+	}
+	if _, err := res.req.Cookie(defaultCookieName); err == nil {
+		t.Fatalf("session cookie is not stripped")
+	}
+	if _, err := res.req.Cookie("other"); err != nil {
+		t.Fatalf("other cookie is stripped")
+	}
+
+	res = handle(require, http.MethodGet, "", http.Header{"X-Session-User": {"bob"}}, nil)
+	if res.result != resultUnauthenticated || res.code != http.StatusFound {
+		t.Fatalf("unexpected result %s and code %d", res.result, res.code)
+	}
+	if res.req.Header.Get("X-Session-User") != "" {
+		t.Fatalf("spoofed user header is not removed")
+	}
+
+	res = handle(require, http.MethodPost, cookie+"x", nil, nil)
+	if res.result != resultUnauthenticated || res.code != http.StatusUnauthorized {
+		t.Fatalf("unexpected result %s and code %d", res.result, res.code)
+	}
+
+	// NOTE: Other realms reject the cookie.
+	other := newSessionFilter(t, `
+kind: Session
+name: session
+realm: other
+secret: 0123456789abcdef
+action: require
+`)
+	if res = handle(other, http.MethodGet, cookie, nil, nil); res.result != resultUnauthenticated {
+		t.Fatalf("cookie of other realm is accepted")
+	}
+
+	// The cookie is renewed after the touch interval.
+	base := time.Now()
+	require.now = func() time.Time { return base.Add(5 * time.Minute) }
+	res = handle(require, http.MethodGet, cookie, nil, nil)
+	renewed := sessionCookie(t, res)
+
+	require.now = func() time.Time { return base.Add(14 * time.Minute) }
+	if res = handle(require, http.MethodGet, cookie, nil, nil); res.result != resultUnauthenticated {
+		t.Fatalf("idle session is accepted")
+	}
+	if res = handle(require, http.MethodGet, renewed, nil, nil); res.result != "" {
+		t.Fatalf("renewed session is rejected")
+	}
+
+	require.now = func() time.Time { return base.Add(61 * time.Minute) }
+	if res = handle(require, http.MethodGet, renewed, nil, nil); res.result != resultUnauthenticated {
+		t.Fatalf("session beyond absolute timeout is accepted")
+	}
+
+	res = handle(logout, http.MethodPost, cookie, nil, nil)
+	if len(res.cookies) != 1 || res.cookies[0].MaxAge != -1 {
+		t.Fatalf("session cookie is not deleted")
+	}
+
+	res = handle(login, http.MethodPost, "", nil, func(w http.Header) int { return http.StatusUnauthorized })
+	if len(res.cookies) != 0 {
+		t.Fatalf("session is created for failed login")
+	}
+}
+
+func TestServerStore(t *testing.T) {
+	p, _ := sharedstate.New(&sharedstate.Spec{Provider: sharedstate.ProviderMemory}, nil)
+	defer p.Close()
+	sharedstate.SetProvider(p)
+	defer sharedstate.ResetProvider(p)
+
+	const spec = `
+kind: Session
+name: session
+realm: app
+store: server
+maxSessionsPerUser: 2
+`
+	login := newSessionFilter(t, spec+"action: login\n")
+	require := newSessionFilter(t, spec+"action: require\n")
+	logout := newSessionFilter(t, spec+"action: logout\n")
+	defer login.Close()
+	defer require.Close()
+	defer logout.Close()
+
+	var cookies []string
+	for i := 0; i < 3; i++ {
+		res := handle(login, http.MethodPost, "", nil, loginBackend("alice"))
+		cookies = append(cookies, sessionCookie(t, res))
+	}
+	if status := login.Status().(*Status); status.Created != 3 || status.Evicted != 1 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	if res := handle(require, http.MethodGet, cookies[0], nil, nil); res.result != resultUnauthenticated {
+		t.Fatalf("evicted session is accepted")
+	}
+	res := handle(require, http.MethodGet, cookies[1], nil, nil)
+	if res.result != "" || res.req.Header.Get("X-Session-User") != "alice" {
+		t.Fatalf("unexpected result %s", res.result)
+	}
+
+	handle(logout, http.MethodPost, cookies[1], nil, nil)
+	if res := handle(require, http.MethodGet, cookies[1], nil, nil); res.result != resultUnauthenticated {
+		t.Fatalf("destroyed session is accepted")
+	}
+
+	ss := realms["app"]
+	state, _ := ss.state()
+	sessions, err := ss.userSessions(state, "alice")
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("unexpected sessions %v %v", sessions, err)
+	}
+	if n, err := ss.destroyUser("alice"); err != nil || n != 1 {
+		t.Fatalf("destroy user sessions: %d %v", n, err)
+	}
+	if res := handle(require, http.MethodGet, cookies[2], nil, nil); res.result != resultUnauthenticated {
+		t.Fatalf("revoked session is accepted")
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	cases := []struct {
+		modify func(spec *Spec)
+		valid  bool
+	}{
+		{func(spec *Spec) {}, false},
+		{func(spec *Spec) { spec.Secret = "0123456789abcdef" }, true},
+		{func(spec *Spec) { spec.Secret = "0123456789" }, false},
+		{func(spec *Spec) { spec.Secret, spec.MaxSessionsPerUser = "0123456789abcdef", 1 }, false},
+		{func(spec *Spec) { spec.Store, spec.MaxSessionsPerUser = StoreServer, 1 }, true},
+		{func(spec *Spec) { spec.Store, spec.IdleTimeout = StoreServer, "2h" }, true},
+		{func(spec *Spec) { spec.Store, spec.IdleTimeout = StoreServer, "9h" }, false},
+	}
+	for i, c := range cases {
+		spec := (&Session{}).DefaultSpec().(*Spec)
+		spec.Action, spec.Realm = ActionRequire, "app"
+		c.modify(spec)
+		if err := spec.Validate(); (err == nil) != c.valid {
+			t.Errorf("case %d: unexpected error %v", i, err)
+		}
+	}
+}
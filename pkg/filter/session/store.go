@@ -0,0 +1,296 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/sharedstate"
+)
+
+const (
+	sessionPrefix = "sessions/"
+	userPrefix    = "users/"
+)
+
+type (
+	session struct {
+		// ID is the ID of sessions in the server store.
+		ID         string            `json:"id,omitempty"`
+		User       string            `json:"user"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+		CreatedAt  int64             `json:"createdAt"`
+		LastSeen   int64             `json:"lastSeen"`
+	}
+
+	// store keeps sessions, the value is the value of the session cookie.
+	store interface {
+		// load returns nil if the session doesn't exist.
+		load(value string) (*session, error)
+		// save returns the value of the session cookie.
+		save(sess *session, ttl time.Duration) (string, error)
+		destroy(value string, sess *session)
+	}
+
+	// cookieStore keeps sessions in cookies encrypted by AES-GCM, they
+	// can't be destroyed before expired, as they are not on the server.
+	cookieStore struct {
+		realm string
+		aead  cipher.AEAD
+	}
+
+	// serverStore keeps sessions in the shared state, and maintains the
+	// sessions of every user for the concurrent session limit.
+	serverStore struct {
+		realm string
+		cls   cluster.Cluster
+	}
+)
+
+func newSession(user string, now time.Time) *session {
+	return &session{
+		User:       user,
+		Attributes: make(map[string]string),
+		CreatedAt:  now.Unix(),
+		LastSeen:   now.Unix(),
+	}
+}
+
+func newCookieStore(realm, secret string) *cookieStore {
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(fmt.Errorf("BUG: create cipher failed: %v", err))
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(fmt.Errorf("BUG: create gcm failed: %v", err))
+	}
+	return &cookieStore{realm: realm, aead: aead}
+}
+
+func (cs *cookieStore) load(value string) (*session, error) {
+	buff, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(buff) < cs.aead.NonceSize() {
+		return nil, nil
+	}
+
+	nonce, ciphertext := buff[:cs.aead.NonceSize()], buff[cs.aead.NonceSize():]
+	// NOTE: The realm is authenticated, so cookies of other realms
+	// sharing the secret are rejected.
+	plaintext, err := cs.aead.Open(nil, nonce, ciphertext, []byte(cs.realm))
+	if err != nil {
+		return nil, nil
+	}
+
+	sess := &session{}
+	if err := json.Unmarshal(plaintext, sess); err != nil {
+		return nil, nil
+	}
+	return sess, nil
+}
+
+func (cs *cookieStore) save(sess *session, ttl time.Duration) (string, error) {
+	plaintext, err := json.Marshal(sess)
+	if err != nil {
+		return "", fmt.Errorf("BUG: marshal %#v to json failed: %v", sess, err)
+	}
+
+	nonce := make([]byte, cs.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	buff := cs.aead.Seal(nonce, nonce, plaintext, []byte(cs.realm))
+	return base64.RawURLEncoding.EncodeToString(buff), nil
+}
+
+func (cs *cookieStore) destroy(value string, sess *session) {
+}
+
+func newServerStore(s *Session) *serverStore {
+	ss := &serverStore{realm: s.spec.Realm}
+	if super := s.filterSpec.Super(); super != nil {
+		ss.cls = super.Cluster()
+	}
+	return ss
+}
+
+func newSessionID() (string, error) {
+	buff := make([]byte, 32)
+	if _, err := rand.Read(buff); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buff), nil
+}
+
+func (ss *serverStore) state() (sharedstate.Store, error) {
+	return sharedstate.Namespace(ss.cls, "sessions/"+ss.realm)
+}
+
+func (ss *serverStore) get(state sharedstate.Store, id string) (*session, error) {
+	value, ok, err := state.Get(sessionPrefix + id)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	sess := &session{}
+	if err := json.Unmarshal([]byte(value), sess); err != nil {
+		return nil, fmt.Errorf("unmarshal session failed: %v", err)
+	}
+	return sess, nil
+}
+
+func (ss *serverStore) load(value string) (*session, error) {
+	state, err := ss.state()
+	if err != nil {
+		return nil, err
+	}
+	return ss.get(state, value)
+}
+
+func (ss *serverStore) save(sess *session, ttl time.Duration) (string, error) {
+	state, err := ss.state()
+	if err != nil {
+		return "", err
+	}
+
+	if sess.ID == "" {
+		sess.ID, err = newSessionID()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	buff, err := json.Marshal(sess)
+	if err != nil {
+		return "", fmt.Errorf("BUG: marshal %#v to json failed: %v", sess, err)
+	}
+	if err := state.Put(sessionPrefix+sess.ID, string(buff), ttl); err != nil {
+		return "", err
+	}
+	return sess.ID, nil
+}
+
+func (ss *serverStore) destroy(value string, sess *session) {
+	state, err := ss.state()
+	if err != nil {
+		logger.Errorf("destroy session of %s failed: %v", sess.User, err)
+		return
+	}
+
+	if err := state.Delete(sessionPrefix + value); err != nil {
+		logger.Errorf("destroy session of %s failed: %v", sess.User, err)
+	}
+}
+
+// userSessions returns the live sessions of the user, the oldest first.
+func (ss *serverStore) userSessions(state sharedstate.Store, user string) ([]*session, error) {
+	value, ok, err := state.Get(userPrefix + user)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	var ids []string
+	if err := json.Unmarshal([]byte(value), &ids); err != nil {
+		return nil, fmt.Errorf("unmarshal sessions of %s failed: %v", user, err)
+	}
+
+	sessions := make([]*session, 0, len(ids))
+	for _, id := range ids {
+		sess, err := ss.get(state, id)
+		if err != nil {
+			return nil, err
+		}
+		// NOTE: Expired and destroyed sessions are dropped here.
+		if sess != nil {
+			sessions = append(sessions, sess)
+		}
+	}
+	return sessions, nil
+}
+
+func (ss *serverStore) putUserSessions(state sharedstate.Store, user string, sessions []*session, ttl time.Duration) error {
+	if len(sessions) == 0 {
+		return state.Delete(userPrefix + user)
+	}
+
+	ids := make([]string, len(sessions))
+	for i, sess := range sessions {
+		ids[i] = sess.ID
+	}
+	buff, _ := json.Marshal(ids)
+	return state.Put(userPrefix+user, string(buff), ttl)
+}
+
+// track adds the new session to the sessions of the user, and destroys
+// the oldest ones beyond max if it's positive, it returns the number of
+// destroyed ones.
+// NOTE: Concurrent logins of the same user on different members may
+// exceed the limit, as the sessions of the user are not locked.
+func (ss *serverStore) track(sess *session, max int, ttl time.Duration) (int, error) {
+	state, err := ss.state()
+	if err != nil {
+		return 0, err
+	}
+
+	sessions, err := ss.userSessions(state, sess.User)
+	if err != nil {
+		return 0, err
+	}
+	sessions = append(sessions, sess)
+
+	evicted := 0
+	for max > 0 && len(sessions) > max {
+		if err := state.Delete(sessionPrefix + sessions[0].ID); err != nil {
+			return evicted, err
+		}
+		sessions = sessions[1:]
+		evicted++
+	}
+
+	return evicted, ss.putUserSessions(state, sess.User, sessions, ttl)
+}
+
+// destroyUser destroys all sessions of the user.
+func (ss *serverStore) destroyUser(user string) (int, error) {
+	state, err := ss.state()
+	if err != nil {
+		return 0, err
+	}
+
+	sessions, err := ss.userSessions(state, user)
+	if err != nil {
+		return 0, err
+	}
+	for _, sess := range sessions {
+		if err := state.Delete(sessionPrefix + sess.ID); err != nil {
+			return 0, err
+		}
+	}
+	return len(sessions), state.Delete(userPrefix + user)
+}
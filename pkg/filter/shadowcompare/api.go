@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shadowcompare
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/api"
+)
+
+const (
+	apiGroupName = "shadowcompare_admin"
+	apiPrefix    = "/shadowcompare/{pipeline}/{filter}"
+)
+
+var (
+	shadowComparesMutex sync.RWMutex
+	shadowCompares      = make(map[string]*ShadowCompare)
+	registerOnce        sync.Once
+)
+
+func shadowCompareKey(pipeline, filter string) string {
+	return pipeline + "/" + filter
+}
+
+func registerShadowCompare(sc *ShadowCompare) {
+	registerOnce.Do(registerAPIs)
+
+	shadowComparesMutex.Lock()
+	defer shadowComparesMutex.Unlock()
+
+	shadowCompares[shadowCompareKey(sc.filterSpec.Pipeline(), sc.filterSpec.Name())] = sc
+}
+
+func unregisterShadowCompare(sc *ShadowCompare) {
+	shadowComparesMutex.Lock()
+	defer shadowComparesMutex.Unlock()
+
+	key := shadowCompareKey(sc.filterSpec.Pipeline(), sc.filterSpec.Name())
+	// NOTE: The next generation may have registered itself.
+	if shadowCompares[key] == sc {
+		delete(shadowCompares, key)
+	}
+}
+
+func registerAPIs() {
+	api.RegisterAPIs(&api.Group{
+		Group: apiGroupName,
+		Entries: []*api.Entry{
+			{Path: apiPrefix + "/reports", Method: "GET", Handler: listReports},
+			{Path: apiPrefix + "/reports", Method: "DELETE", Handler: deleteReports},
+		},
+	})
+}
+
+func getShadowCompare(w http.ResponseWriter, r *http.Request) *ShadowCompare {
+	pipeline, filter := chi.URLParam(r, "pipeline"), chi.URLParam(r, "filter")
+
+	shadowComparesMutex.RLock()
+	sc := shadowCompares[shadowCompareKey(pipeline, filter)]
+	shadowComparesMutex.RUnlock()
+
+	if sc == nil {
+		api.HandleAPIError(w, r, http.StatusNotFound,
+			fmt.Errorf("shadow compare %s not found in pipeline %s", filter, pipeline))
+	}
+	return sc
+}
+
+func listReports(w http.ResponseWriter, r *http.Request) {
+	sc := getShadowCompare(w, r)
+	if sc == nil {
+		return
+	}
+
+	buff, err := yaml.Marshal(sc.latestReports())
+	if err != nil {
+		panic(fmt.Errorf("marshal reports to yaml failed: %v", err))
+	}
+	w.Header().Set("Content-Type", "text/vnd.yaml")
+	w.Write(buff)
+}
+
+func deleteReports(w http.ResponseWriter, r *http.Request) {
+	sc := getShadowCompare(w, r)
+	if sc == nil {
+		return
+	}
+	sc.clearReports()
+}
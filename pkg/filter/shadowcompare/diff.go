@@ -0,0 +1,244 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shadowcompare
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxPreviewSize = 256
+
+type (
+	// Report is the differences between the responses of a request.
+	Report struct {
+		Time   time.Time `yaml:"time" json:"time"`
+		Method string    `yaml:"method" json:"method"`
+		Path   string    `yaml:"path" json:"path"`
+		// Status holds the status codes of the primary and the candidate
+		// if they differ.
+		Status []int `yaml:"status,omitempty" json:"status,omitempty"`
+		// Headers and Body are the differing header names and JSON paths.
+		Headers []*FieldDiff `yaml:"headers,omitempty" json:"headers,omitempty"`
+		Body    []*FieldDiff `yaml:"body,omitempty" json:"body,omitempty"`
+		// Error is the error of the candidate.
+		Error string `yaml:"error,omitempty" json:"error,omitempty"`
+	}
+
+	// FieldDiff is a differing field, the absent value is nil.
+	FieldDiff struct {
+		Name      string      `yaml:"name" json:"name"`
+		Primary   interface{} `yaml:"primary" json:"primary"`
+		Candidate interface{} `yaml:"candidate" json:"candidate"`
+	}
+
+	// response is the captured response of the primary or the candidate,
+	// the body is nil if it exceeds the max size.
+	response struct {
+		code   int
+		header http.Header
+		body   []byte
+	}
+
+	differ struct {
+		ignoreHeaders map[string]bool
+		ignorePaths   [][]string
+	}
+)
+
+// Differs returns whether there are differences in the report.
+func (r *Report) Differs() bool {
+	return len(r.Status) != 0 || len(r.Headers) != 0 || len(r.Body) != 0 || r.Error != ""
+}
+
+func newDiffer(ignoreHeaders, ignoreJSONPaths []string) *differ {
+	d := &differ{ignoreHeaders: map[string]bool{}}
+	for _, h := range ignoreHeaders {
+		d.ignoreHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, p := range ignoreJSONPaths {
+		d.ignorePaths = append(d.ignorePaths, strings.Split(p, "."))
+	}
+	return d
+}
+
+func (d *differ) diff(report *Report, primary, candidate *response) {
+	if primary.code != candidate.code {
+		report.Status = []int{primary.code, candidate.code}
+	}
+	report.Headers = d.diffHeaders(primary.header, candidate.header)
+	report.Body = d.diffBody(primary.body, candidate.body)
+}
+
+func (d *differ) diffHeaders(primary, candidate http.Header) []*FieldDiff {
+	keys := map[string]bool{}
+	for k := range primary {
+		keys[k] = true
+	}
+	for k := range candidate {
+		keys[k] = true
+	}
+
+	var diffs []*FieldDiff
+	for k := range keys {
+		if d.ignoreHeaders[k] {
+			continue
+		}
+		p, c := strings.Join(primary.Values(k), ","), strings.Join(candidate.Values(k), ",")
+		if p == c {
+			continue
+		}
+		diff := &FieldDiff{Name: k}
+		if _, ok := primary[k]; ok {
+			diff.Primary = p
+		}
+		if _, ok := candidate[k]; ok {
+			diff.Candidate = c
+		}
+		diffs = append(diffs, diff)
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Name < diffs[j].Name })
+	return diffs
+}
+
+// diffBody compares the bodies as JSON by paths, e.g. items.0.price, and
+// as bytes if either of them isn't JSON.
+func (d *differ) diffBody(primary, candidate []byte) []*FieldDiff {
+	if primary == nil || candidate == nil {
+		// NOTE: The bodies exceeding the max size are not compared.
+		return nil
+	}
+
+	var p, c interface{}
+	if json.Unmarshal(primary, &p) != nil || json.Unmarshal(candidate, &c) != nil {
+		if bytes.Equal(primary, candidate) {
+			return nil
+		}
+		return []*FieldDiff{{Primary: preview(primary), Candidate: preview(candidate)}}
+	}
+
+	var diffs []*FieldDiff
+	d.diffJSON(nil, p, c, true, true, &diffs)
+	return diffs
+}
+
+func (d *differ) diffJSON(path []string, p, c interface{}, hasP, hasC bool, diffs *[]*FieldDiff) {
+	if d.ignored(path) {
+		return
+	}
+
+	add := func() {
+		diff := &FieldDiff{Name: strings.Join(path, ".")}
+		if hasP {
+			diff.Primary = p
+		}
+		if hasC {
+			diff.Candidate = c
+		}
+		*diffs = append(*diffs, diff)
+	}
+
+	if !hasP || !hasC {
+		add()
+		return
+	}
+
+	switch pv := p.(type) {
+	case map[string]interface{}:
+		cv, ok := c.(map[string]interface{})
+		if !ok {
+			add()
+			return
+		}
+		keys := make([]string, 0, len(pv)+len(cv))
+		for k := range pv {
+			keys = append(keys, k)
+		}
+		for k := range cv {
+			if _, ok := pv[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			pe, okP := pv[k]
+			ce, okC := cv[k]
+			d.diffJSON(append(path[:len(path):len(path)], k), pe, ce, okP, okC, diffs)
+		}
+
+	case []interface{}:
+		cv, ok := c.([]interface{})
+		if !ok {
+			add()
+			return
+		}
+		n := len(pv)
+		if len(cv) > n {
+			n = len(cv)
+		}
+		for i := 0; i < n; i++ {
+			var pe, ce interface{}
+			if i < len(pv) {
+				pe = pv[i]
+			}
+			if i < len(cv) {
+				ce = cv[i]
+			}
+			d.diffJSON(append(path[:len(path):len(path)], strconv.Itoa(i)), pe, ce, i < len(pv), i < len(cv), diffs)
+		}
+
+	default:
+		if p != c {
+			add()
+		}
+	}
+}
+
+// ignored returns whether the path matches an ignored path, in which *
+// matches any key or index, the children of ignored paths are ignored too.
+func (d *differ) ignored(path []string) bool {
+	for _, ignore := range d.ignorePaths {
+		if len(ignore) > len(path) {
+			continue
+		}
+		matched := true
+		for i, seg := range ignore {
+			if seg != "*" && seg != path[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func preview(body []byte) string {
+	if len(body) > maxPreviewSize {
+		return string(body[:maxPreviewSize]) + "..."
+	}
+	return string(body)
+}
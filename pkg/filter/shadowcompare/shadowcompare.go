@@ -0,0 +1,393 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package shadowcompare provides the ShadowCompare filter, which mirrors
+// requests to a candidate backend and records the differences between the
+// responses of the primary and the candidate, to validate service rewrites.
+package shadowcompare
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpfilter"
+)
+
+const (
+	// Kind is the kind of ShadowCompare.
+	Kind = "ShadowCompare"
+)
+
+var results = []string{}
+
+// alwaysIgnoredHeaders are the headers differing in every response.
+var alwaysIgnoredHeaders = []string{"Date"}
+
+func init() {
+	httppipeline.Register(&ShadowCompare{})
+}
+
+type (
+	// ShadowCompare mirrors requests to a candidate backend and compares
+	// the responses.
+	ShadowCompare struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		candidate   *url.URL
+		filter      *httpfilter.HTTPFilter
+		client      *http.Client
+		differ      *differ
+		maxBodySize int64
+		maxReports  int
+
+		mutex   sync.Mutex
+		reports []*Report
+		file    *os.File
+
+		compared uint64
+		differed uint64
+		failed   uint64
+	}
+
+	// Spec describes the ShadowCompare.
+	Spec struct {
+		// Candidate is the URL of the candidate backend, the paths and
+		// queries of requests are appended to it.
+		Candidate string `yaml:"candidate" jsonschema:"required,format=uri"`
+		// Filter selects the requests to mirror, all requests are mirrored
+		// if it's empty.
+		Filter  *httpfilter.Spec `yaml:"filter,omitempty" jsonschema:"omitempty"`
+		Timeout string           `yaml:"timeout" jsonschema:"required,format=duration"`
+		// MaxBodySize is the max size of bodies to compare, requests with
+		// larger bodies are not mirrored.
+		MaxBodySize int64 `yaml:"maxBodySize" jsonschema:"required,minimum=1"`
+		// IgnoreHeaders and IgnoreJSONPaths are excluded from comparing,
+		// the JSON paths are separated by dots and * matches any key.
+		IgnoreHeaders   []string `yaml:"ignoreHeaders" jsonschema:"omitempty"`
+		IgnoreJSONPaths []string `yaml:"ignoreJSONPaths" jsonschema:"omitempty"`
+		// MaxReports is the number of the latest reports kept in memory.
+		MaxReports int `yaml:"maxReports" jsonschema:"required,minimum=1"`
+		// ReportFile is the file the reports are appended to as JSON lines
+		// for offline analysis, no file is written if it's empty.
+		ReportFile string `yaml:"reportFile" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of ShadowCompare.
+	Status struct {
+		Compared uint64 `yaml:"compared"`
+		Differed uint64 `yaml:"differed"`
+		// Failed is the number of failed requests to the candidate.
+		Failed uint64 `yaml:"failed"`
+	}
+
+	candidateResult struct {
+		resp *response
+		err  error
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	u, err := url.Parse(spec.Candidate)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid candidate %s", spec.Candidate)
+	}
+	if d, _ := time.ParseDuration(spec.Timeout); d <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	return nil
+}
+
+// Kind returns the kind of ShadowCompare.
+func (sc *ShadowCompare) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of ShadowCompare.
+func (sc *ShadowCompare) DefaultSpec() interface{} {
+	return &Spec{
+		Timeout:     "10s",
+		MaxBodySize: 1 << 20,
+		MaxReports:  100,
+	}
+}
+
+// Description returns the description of ShadowCompare.
+func (sc *ShadowCompare) Description() string {
+	return "ShadowCompare mirrors requests to a candidate backend and records the differences of responses."
+}
+
+// Results returns the results of ShadowCompare.
+func (sc *ShadowCompare) Results() []string {
+	return results
+}
+
+// Init initializes ShadowCompare.
+func (sc *ShadowCompare) Init(filterSpec *httppipeline.FilterSpec) {
+	sc.filterSpec, sc.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	sc.reload()
+}
+
+// Inherit inherits previous generation of ShadowCompare.
+func (sc *ShadowCompare) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	sc.Init(filterSpec)
+
+	prev := previousGeneration.(*ShadowCompare)
+	prev.mutex.Lock()
+	sc.reports = append(sc.reports, prev.reports...)
+	prev.mutex.Unlock()
+	sc.trimReports()
+}
+
+func (sc *ShadowCompare) reload() {
+	sc.candidate, _ = url.Parse(sc.spec.Candidate)
+	if sc.spec.Filter != nil {
+		sc.filter = httpfilter.New(sc.spec.Filter)
+	}
+
+	timeout, _ := time.ParseDuration(sc.spec.Timeout)
+	sc.client = &http.Client{
+		Timeout: timeout,
+		// NOTE: The redirections are compared rather than followed.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	sc.differ = newDiffer(append(alwaysIgnoredHeaders, sc.spec.IgnoreHeaders...), sc.spec.IgnoreJSONPaths)
+	sc.maxBodySize, sc.maxReports = sc.spec.MaxBodySize, sc.spec.MaxReports
+
+	if sc.spec.ReportFile != "" {
+		file, err := os.OpenFile(sc.spec.ReportFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			logger.Errorf("open report file %s failed: %v", sc.spec.ReportFile, err)
+		} else {
+			sc.file = file
+		}
+	}
+
+	registerShadowCompare(sc)
+}
+
+// Handle mirrors the request and compares the responses.
+func (sc *ShadowCompare) Handle(ctx context.HTTPContext) string {
+	if sc.filter != nil && !sc.filter.Filter(ctx) {
+		return ctx.CallNextHandler("")
+	}
+
+	req, err := sc.newCandidateRequest(ctx)
+	if err != nil {
+		ctx.AddTag(fmt.Sprintf("shadowCompare: %v", err))
+		return ctx.CallNextHandler("")
+	}
+
+	ch := make(chan *candidateResult, 1)
+	go func() {
+		ch <- sc.sendCandidate(req)
+	}()
+
+	result := ctx.CallNextHandler("")
+
+	w := ctx.Response()
+	report := &Report{
+		Time:   time.Now(),
+		Method: ctx.Request().Method(),
+		Path:   ctx.Request().Path(),
+	}
+	primary := &response{code: w.StatusCode(), header: w.Header().Std().Clone()}
+
+	if w.Body() == nil {
+		primary.body = []byte{}
+		sc.compareAsync(report, primary, ch)
+		return result
+	}
+
+	buff, exceeded := bytes.NewBuffer(nil), false
+	w.OnFlushBody(func(body []byte, complete bool) []byte {
+		if !exceeded {
+			if int64(buff.Len()+len(body)) > sc.maxBodySize {
+				exceeded = true
+			} else {
+				buff.Write(body)
+			}
+		}
+		if complete {
+			if !exceeded {
+				primary.body = buff.Bytes()
+			}
+			sc.compareAsync(report, primary, ch)
+		}
+		return body
+	})
+
+	return result
+}
+
+// newCandidateRequest creates the request to the candidate, the body of
+// the original request is restored after reading.
+func (sc *ShadowCompare) newCandidateRequest(ctx context.HTTPContext) (*http.Request, error) {
+	r := ctx.Request()
+
+	var body []byte
+	if reader := r.Body(); reader != nil {
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(reader, sc.maxBodySize+1))
+		if err != nil {
+			return nil, fmt.Errorf("read request body failed: %v", err)
+		}
+		if int64(len(body)) > sc.maxBodySize {
+			r.SetBody(io.MultiReader(bytes.NewReader(body), reader))
+			return nil, fmt.Errorf("request body exceeds %d bytes", sc.maxBodySize)
+		}
+		r.SetBody(bytes.NewReader(body))
+	}
+
+	u := *sc.candidate
+	u.Path = sc.candidate.Path + r.Path()
+	u.RawPath = ""
+	u.RawQuery = r.Query()
+
+	req, err := http.NewRequest(r.Method(), u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create candidate request failed: %v", err)
+	}
+	req.Header = r.Header().Std().Clone()
+	return req, nil
+}
+
+func (sc *ShadowCompare) sendCandidate(req *http.Request) *candidateResult {
+	resp, err := sc.client.Do(req)
+	if err != nil {
+		return &candidateResult{err: err}
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, sc.maxBodySize+1))
+	if err != nil {
+		return &candidateResult{err: fmt.Errorf("read body failed: %v", err)}
+	}
+	if int64(len(body)) > sc.maxBodySize {
+		body = nil
+	}
+	return &candidateResult{resp: &response{code: resp.StatusCode, header: resp.Header, body: body}}
+}
+
+func (sc *ShadowCompare) compareAsync(report *Report, primary *response, ch <-chan *candidateResult) {
+	// NOTE: The candidate requests are bounded by the timeout.
+	go func() {
+		sc.compare(report, primary, <-ch)
+	}()
+}
+
+func (sc *ShadowCompare) compare(report *Report, primary *response, result *candidateResult) {
+	atomic.AddUint64(&sc.compared, 1)
+	if result.err != nil {
+		atomic.AddUint64(&sc.failed, 1)
+		report.Error = result.err.Error()
+	} else {
+		sc.differ.diff(report, primary, result.resp)
+	}
+
+	if !report.Differs() {
+		return
+	}
+	atomic.AddUint64(&sc.differed, 1)
+	sc.record(report)
+}
+
+func (sc *ShadowCompare) record(report *Report) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.reports = append(sc.reports, report)
+	if len(sc.reports) > sc.maxReports {
+		sc.reports = sc.reports[len(sc.reports)-sc.maxReports:]
+	}
+
+	if sc.file == nil {
+		return
+	}
+	buff, err := json.Marshal(report)
+	if err != nil {
+		logger.Errorf("BUG: marshal %#v to json failed: %v", report, err)
+		return
+	}
+	if _, err = sc.file.Write(append(buff, '\n')); err != nil {
+		logger.Errorf("write report file %s failed: %v", sc.spec.ReportFile, err)
+	}
+}
+
+func (sc *ShadowCompare) trimReports() {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	if len(sc.reports) > sc.maxReports {
+		sc.reports = sc.reports[len(sc.reports)-sc.maxReports:]
+	}
+}
+
+// latestReports returns the reports, the latest first.
+func (sc *ShadowCompare) latestReports() []*Report {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	reports := make([]*Report, 0, len(sc.reports))
+	for i := len(sc.reports) - 1; i >= 0; i-- {
+		reports = append(reports, sc.reports[i])
+	}
+	return reports
+}
+
+func (sc *ShadowCompare) clearReports() {
+	sc.mutex.Lock()
+	sc.reports = nil
+	sc.mutex.Unlock()
+}
+
+// Status returns status.
+func (sc *ShadowCompare) Status() interface{} {
+	return &Status{
+		Compared: atomic.LoadUint64(&sc.compared),
+		Differed: atomic.LoadUint64(&sc.differed),
+		Failed:   atomic.LoadUint64(&sc.failed),
+	}
+}
+
+// Close closes ShadowCompare.
+func (sc *ShadowCompare) Close() {
+	unregisterShadowCompare(sc)
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	if sc.file != nil {
+		sc.file.Close()
+		sc.file = nil
+	}
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shadowcompare
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestDiff(t *testing.T) {
+	d := newDiffer([]string{"x-request-id"}, []string{"meta.*.time"})
+	report := &Report{}
+	d.diff(report, &response{
+		code:   200,
+		header: http.Header{"X-Request-Id": {"1"}, "X-Version": {"1"}},
+		body:   []byte(`{"items": [{"id": 1, "price": 10}], "meta": {"a": {"time": 1}}}`),
+	}, &response{
+		code:   201,
+		header: http.Header{"X-Request-Id": {"2"}, "X-Version": {"2"}, "X-New": {"yes"}},
+		body:   []byte(`{"items": [{"id": 1, "price": 12}, {"id": 2}], "meta": {"a": {"time": 2}}}`),
+	})
+
+	if len(report.Status) != 2 || report.Status[0] != 200 || report.Status[1] != 201 {
+		t.Errorf("unexpected status %v", report.Status)
+	}
+
+	if len(report.Headers) != 2 || report.Headers[0].Name != "X-New" || report.Headers[0].Primary != nil ||
+		report.Headers[1].Name != "X-Version" {
+		t.Errorf("unexpected headers %+v", report.Headers)
+	}
+
+	names := []string{}
+	for _, f := range report.Body {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "items.0.price,items.1" {
+		t.Errorf("unexpected body diffs %v", names)
+	}
+
+	if diffs := d.diffBody([]byte("a"), []byte("b")); len(diffs) != 1 || diffs[0].Primary != "a" {
+		t.Errorf("unexpected body diffs %+v", diffs)
+	}
+	if diffs := d.diffBody([]byte("a"), nil); diffs != nil {
+		t.Error("bodies exceeding the max size should not be compared")
+	}
+}
+
+func TestShadowCompare(t *testing.T) {
+	candidate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"path": "` + r.URL.Path + `", "body": "` + string(body) + `", "version": 2}`))
+	}))
+	defer candidate.Close()
+
+	reportFile := filepath.Join(t.TempDir(), "reports.jsonl")
+	yamlSpec := `
+kind: ShadowCompare
+name: shadow
+candidate: ` + candidate.URL + `
+reportFile: ` + reportFile + `
+`
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, e := httppipeline.NewFilterSpec(rawSpec, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	sc := &ShadowCompare{}
+	sc.Init(spec)
+	defer sc.Close()
+
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedMethod = func() string {
+		return http.MethodPost
+	}
+	ctx.MockedRequest.MockedPath = func() string {
+		return "/users"
+	}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(http.Header{})
+	}
+	var reqBody io.Reader = strings.NewReader("alice")
+	ctx.MockedRequest.MockedBody = func() io.Reader {
+		return reqBody
+	}
+	ctx.MockedRequest.MockedSetBody = func(body io.Reader) {
+		reqBody = body
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	ctx.MockedResponse.MockedStatusCode = func() int {
+		return http.StatusOK
+	}
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(header)
+	}
+	ctx.MockedResponse.MockedBody = func() io.Reader {
+		return bytes.NewReader(nil)
+	}
+	var flush func([]byte, bool) []byte
+	ctx.MockedResponse.MockedOnFlushBody = func(fn func(body []byte, complete bool) []byte) {
+		flush = fn
+	}
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		if data, _ := ioutil.ReadAll(reqBody); string(data) != "alice" {
+			t.Errorf("request body should be kept, got %q", data)
+		}
+		return lastResult
+	}
+
+	sc.Handle(ctx)
+	if flush == nil {
+		t.Fatal("body flush function should be registered")
+	}
+	flush([]byte(`{"path": "/users", `), false)
+	flush([]byte(`"body": "alice", "version": 1}`), true)
+
+	for i := 0; i < 100 && sc.Status().(*Status).Compared == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	status := sc.Status().(*Status)
+	if status.Compared != 1 || status.Differed != 1 || status.Failed != 0 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	reports := sc.latestReports()
+	if len(reports) != 1 || len(reports[0].Body) != 1 || reports[0].Body[0].Name != "version" ||
+		len(reports[0].Headers) != 1 || reports[0].Headers[0].Name != "Content-Length" {
+		t.Errorf("unexpected reports %+v", reports[0])
+	}
+
+	data, _ := ioutil.ReadFile(reportFile)
+	if !strings.Contains(string(data), `"name":"version"`) {
+		t.Errorf("unexpected report file %s", data)
+	}
+
+	sc.clearReports()
+	if len(sc.latestReports()) != 0 {
+		t.Error("reports should be cleared")
+	}
+}
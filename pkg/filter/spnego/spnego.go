@@ -0,0 +1,265 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package spnego provides the SPNEGO filter, which authenticates clients
+// by Kerberos tickets in the HTTP Negotiate authentication of RFC 4559.
+package spnego
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+	cache "github.com/patrickmn/go-cache"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of SPNEGO.
+	Kind = "SPNEGO"
+
+	resultUnauthenticated = "unauthenticated"
+
+	negotiate = "Negotiate"
+)
+
+var results = []string{resultUnauthenticated}
+
+func init() {
+	httppipeline.Register(&SPNEGO{})
+}
+
+type (
+	// SPNEGO is the filter authenticating clients by Kerberos tickets, so
+	// the clients of the Active Directory domains get authenticated
+	// transparently, and the backends get the principal from a header.
+	SPNEGO struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		keytab    *keytab.Keytab
+		clockSkew time.Duration
+		// replays caches the authenticators seen in the clock skew, the
+		// ones seen again are replayed by attackers.
+		replays *cache.Cache
+
+		authenticated uint64
+		rejected      uint64
+	}
+
+	// Spec describes the SPNEGO.
+	Spec struct {
+		// Keytab is the base64 encoded keytab of the service principal.
+		Keytab string `yaml:"keytab" jsonschema:"required,format=base64"`
+		// ServicePrincipal is the principal the tickets must be issued
+		// for, like HTTP/gateway.example.com, all principals in the
+		// keytab are accepted if it's empty.
+		ServicePrincipal string `yaml:"servicePrincipal" jsonschema:"omitempty"`
+		// Realms are the realms of the accepted clients, all realms
+		// trusted by the KDC are accepted if it's empty.
+		Realms          []string `yaml:"realms" jsonschema:"omitempty,uniqueItems=true"`
+		PrincipalHeader string   `yaml:"principalHeader" jsonschema:"omitempty"`
+		// StripRealm forwards alice instead of alice@EXAMPLE.COM.
+		StripRealm bool   `yaml:"stripRealm" jsonschema:"omitempty"`
+		ClockSkew  string `yaml:"clockSkew" jsonschema:"omitempty,format=duration"`
+	}
+
+	// Status is the status of SPNEGO.
+	Status struct {
+		Authenticated uint64 `yaml:"authenticated"`
+		Rejected      uint64 `yaml:"rejected"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if _, err := loadKeytab(spec.Keytab); err != nil {
+		return err
+	}
+	if d, _ := time.ParseDuration(spec.ClockSkew); d <= 0 {
+		return fmt.Errorf("clockSkew must be positive")
+	}
+	return nil
+}
+
+func loadKeytab(s string) (*keytab.Keytab, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keytab: %v", err)
+	}
+	kt := keytab.New()
+	if err := kt.Unmarshal(b); err != nil {
+		return nil, fmt.Errorf("invalid keytab: %v", err)
+	}
+	return kt, nil
+}
+
+// Kind returns the kind of SPNEGO.
+func (s *SPNEGO) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of SPNEGO.
+func (s *SPNEGO) DefaultSpec() interface{} {
+	return &Spec{
+		PrincipalHeader: "X-Authenticated-Principal",
+		ClockSkew:       "5m",
+	}
+}
+
+// Description returns the description of SPNEGO.
+func (s *SPNEGO) Description() string {
+	return "SPNEGO authenticates clients by Kerberos tickets in the Negotiate authentication."
+}
+
+// Results returns the results of SPNEGO.
+func (s *SPNEGO) Results() []string {
+	return results
+}
+
+// Init initializes SPNEGO.
+func (s *SPNEGO) Init(filterSpec *httppipeline.FilterSpec) {
+	s.filterSpec, s.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	s.reload()
+}
+
+// Inherit inherits previous generation of SPNEGO.
+func (s *SPNEGO) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	s.Init(filterSpec)
+}
+
+func (s *SPNEGO) reload() {
+	// NOTE: The spec has been validated.
+	s.keytab, _ = loadKeytab(s.spec.Keytab)
+	s.clockSkew, _ = time.ParseDuration(s.spec.ClockSkew)
+	// NOTE: Authenticators within the clock skew of both sides are
+	// accepted, so they must be remembered twice as long.
+	s.replays = cache.New(2*s.clockSkew, s.clockSkew)
+}
+
+// Handle handles HTTPContext.
+func (s *SPNEGO) Handle(ctx context.HTTPContext) string {
+	result := s.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (s *SPNEGO) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+
+	// NOTE: Clients must not authenticate themselves by the header.
+	r.Header().Del(s.spec.PrincipalHeader)
+
+	principal, err := s.authenticate(r)
+	if err != nil {
+		logger.Debugf("SPNEGO authentication failed: %v", err)
+		atomic.AddUint64(&s.rejected, 1)
+		w := ctx.Response()
+		w.Header().Set("WWW-Authenticate", negotiate)
+		w.SetStatusCode(http.StatusUnauthorized)
+		return resultUnauthenticated
+	}
+	atomic.AddUint64(&s.authenticated, 1)
+
+	// NOTE: The ticket is for the gateway, which is useless to backends.
+	r.Header().Del("Authorization")
+	r.Header().Set(s.spec.PrincipalHeader, principal)
+	return ""
+}
+
+func (s *SPNEGO) authenticate(r context.HTTPRequest) (string, error) {
+	auth := r.Header().Get("Authorization")
+	if len(auth) <= len(negotiate) || !strings.EqualFold(auth[:len(negotiate)+1], negotiate+" ") {
+		return "", fmt.Errorf("no Negotiate authorization")
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(auth[len(negotiate)+1:]))
+	if err != nil {
+		return "", fmt.Errorf("invalid Negotiate authorization: %v", err)
+	}
+
+	apReq, err := parseAPReq(b)
+	if err != nil {
+		return "", err
+	}
+
+	if s.spec.ServicePrincipal != "" && apReq.Ticket.SName.PrincipalNameString() != s.spec.ServicePrincipal {
+		return "", fmt.Errorf("ticket is issued for %s", apReq.Ticket.SName.PrincipalNameString())
+	}
+
+	var addr types.HostAddress
+	if ip := net.ParseIP(r.RealIP()); ip != nil {
+		addr = types.HostAddressFromNetIP(ip)
+	}
+	if ok, err := apReq.Verify(s.keytab, s.clockSkew, addr, nil); !ok {
+		return "", fmt.Errorf("verify AP-REQ failed: %v", err)
+	}
+
+	tkt := apReq.Ticket.DecryptedEncPart
+	if len(s.spec.Realms) > 0 && !contains(s.spec.Realms, tkt.CRealm) {
+		return "", fmt.Errorf("realm %s is not allowed", tkt.CRealm)
+	}
+
+	if !s.remember(apReq) {
+		return "", fmt.Errorf("authenticator is replayed")
+	}
+
+	principal := tkt.CName.PrincipalNameString()
+	if !s.spec.StripRealm {
+		principal += "@" + tkt.CRealm
+	}
+	return principal, nil
+}
+
+// remember remembers the authenticator, it returns false if the
+// authenticator has been seen.
+func (s *SPNEGO) remember(apReq *messages.APReq) bool {
+	a := apReq.Authenticator
+	key := fmt.Sprintf("%s@%s/%d/%d", a.CName.PrincipalNameString(), a.CRealm, a.CTime.UnixNano(), a.Cusec)
+	return s.replays.Add(key, nil, cache.DefaultExpiration) == nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns status.
+func (s *SPNEGO) Status() interface{} {
+	return &Status{
+		Authenticated: atomic.LoadUint64(&s.authenticated),
+		Rejected:      atomic.LoadUint64(&s.rejected),
+	}
+}
+
+// Close closes SPNEGO.
+func (s *SPNEGO) Close() {
+	s.replays.Flush()
+}
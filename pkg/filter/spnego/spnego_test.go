@@ -0,0 +1,242 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spnego
+
+import (
+	"encoding/asn1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+const (
+	realm   = "EXAMPLE.COM"
+	service = "HTTP/gw.example.com"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newKeytab(t *testing.T, password string) *keytab.Keytab {
+	kt := keytab.New()
+	err := kt.AddEntry(service, realm, password, time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return kt
+}
+
+func newSPNEGO(t *testing.T, kt *keytab.Keytab, extra string) *SPNEGO {
+	b, err := kt.Marshal()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	yamlConfig := `
+kind: SPNEGO
+name: spnego
+servicePrincipal: ` + service + `
+keytab: ` + base64.StdEncoding.EncodeToString(b) + "\n" + extra
+
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s := &SPNEGO{}
+	s.Init(spec)
+	return s
+}
+
+// newToken creates the Negotiate token of the client for the service,
+// the ticket is encrypted by the key in the keytab like the KDC does.
+func newToken(t *testing.T, kt *keytab.Keytab, client, clientRealm string, ctime time.Time) string {
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, client)
+	sname := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, service)
+	now := time.Now().UTC()
+	tkt, key, err := messages.NewTicket(cname, clientRealm, sname, realm, types.NewKrbFlags(), kt,
+		etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, now.Add(time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	auth, err := types.NewAuthenticator(clientRealm, cname)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	auth.CTime = ctime.UTC().Truncate(time.Second)
+	apReq, err := messages.NewAPReq(tkt, key, auth)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := apReq.Marshal()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mechToken := wrap(t, oidKRB5, append(append([]byte{}, tokenIDAPReq...), b...))
+	init, err := asn1.Marshal(negTokenInit{
+		MechTypes: []asn1.ObjectIdentifier{oidKRB5, oidMSLegacyKRB5},
+		MechToken: mechToken,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	choice, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: init})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(wrap(t, oidSPNEGO, choice))
+}
+
+func wrap(t *testing.T, mech asn1.ObjectIdentifier, inner []byte) []byte {
+	oid, err := asn1.Marshal(mech)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassApplication, Tag: 0, IsCompound: true, Bytes: append(oid, inner...)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return b
+}
+
+type result struct {
+	result string
+	code   int
+	req    *http.Request
+	header http.Header
+}
+
+func handle(s *SPNEGO, auth string) *result {
+	stdr := httptest.NewRequest(http.MethodGet, "/", nil)
+	stdr.RemoteAddr = "192.168.1.10:1234"
+	stdr.Header.Set("X-Authenticated-Principal", "root@EXAMPLE.COM")
+	if auth != "" {
+		stdr.Header.Set("Authorization", auth)
+	}
+	w := httptest.NewRecorder()
+
+	res := &result{req: stdr, result: s.Handle(contexttest.NewMockedHTTPContext(stdr, w))}
+	res.code, res.header = w.Code, w.Header()
+	return res
+}
+
+func assertRejected(t *testing.T, res *result) {
+	t.Helper()
+	if res.result != resultUnauthenticated || res.code != http.StatusUnauthorized {
+		t.Fatalf("unexpected result %s, code %d", res.result, res.code)
+	}
+	if res.header.Get("WWW-Authenticate") != "Negotiate" {
+		t.Fatalf("no Negotiate challenge")
+	}
+	if res.req.Header.Get("X-Authenticated-Principal") != "" {
+		t.Fatalf("spoofed principal is forwarded")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	kt := newKeytab(t, "secret")
+	s := newSPNEGO(t, kt, "")
+
+	token := newToken(t, kt, "alice", realm, time.Now())
+	res := handle(s, "Negotiate "+token)
+	if res.result != "" {
+		t.Fatalf("unexpected result %s", res.result)
+	}
+	if p := res.req.Header.Get("X-Authenticated-Principal"); p != "alice@EXAMPLE.COM" {
+		t.Fatalf("unexpected principal %s", p)
+	}
+	if res.req.Header.Get("Authorization") != "" {
+		t.Fatalf("ticket is forwarded")
+	}
+
+	// replayed
+	assertRejected(t, handle(s, "Negotiate "+token))
+
+	s = newSPNEGO(t, kt, "stripRealm: true\n")
+	res = handle(s, "Negotiate "+newToken(t, kt, "bob", realm, time.Now()))
+	if p := res.req.Header.Get("X-Authenticated-Principal"); p != "bob" {
+		t.Fatalf("unexpected principal %s", p)
+	}
+
+	status := s.Status().(*Status)
+	if status.Authenticated != 1 || status.Rejected != 0 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestReject(t *testing.T) {
+	kt := newKeytab(t, "secret")
+	s := newSPNEGO(t, kt, "realms: [CORP.EXAMPLE.COM]\n")
+
+	assertRejected(t, handle(s, ""))
+	assertRejected(t, handle(s, "Basic YWxpY2U6c2VjcmV0"))
+	assertRejected(t, handle(s, "Negotiate !!!"))
+	assertRejected(t, handle(s, "Negotiate TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAAKAGFKAAAADw=="))
+
+	// realm not allowed
+	assertRejected(t, handle(s, "Negotiate "+newToken(t, kt, "alice", realm, time.Now())))
+
+	s = newSPNEGO(t, kt, "clockSkew: 1m\n")
+	// clock skew
+	assertRejected(t, handle(s, "Negotiate "+newToken(t, kt, "alice", realm, time.Now().Add(-2*time.Minute))))
+	// issued by a KDC with another key
+	assertRejected(t, handle(s, "Negotiate "+newToken(t, newKeytab(t, "other"), "alice", realm, time.Now())))
+
+	res := handle(s, "Negotiate "+newToken(t, kt, "alice", realm, time.Now()))
+	if res.result != "" {
+		t.Fatalf("unexpected result %s", res.result)
+	}
+	status := s.Status().(*Status)
+	if status.Authenticated != 1 || status.Rejected != 2 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := Spec{Keytab: "bm90IGEga2V5dGFi", ClockSkew: "5m"}
+	if spec.Validate() == nil {
+		t.Fatalf("invalid keytab should fail")
+	}
+
+	b, _ := newKeytab(t, "secret").Marshal()
+	spec.Keytab = base64.StdEncoding.EncodeToString(b)
+	if err := spec.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
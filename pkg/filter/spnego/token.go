@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spnego
+
+import (
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/jcmturner/gokrb5/v8/messages"
+)
+
+var (
+	oidSPNEGO = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 2}
+	oidKRB5   = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2}
+	// oidMSLegacyKRB5 is the misencoded Kerberos OID sent by old Windows.
+	oidMSLegacyKRB5 = asn1.ObjectIdentifier{1, 2, 840, 48018, 1, 2, 2}
+
+	// tokenIDAPReq is the TOK_ID of KRB_AP_REQ in RFC 1964 section 1.1.1.
+	tokenIDAPReq = []byte{0x01, 0x00}
+)
+
+type (
+	// negTokenInit is the NegTokenInit of RFC 4178 section 4.2.1.
+	negTokenInit struct {
+		MechTypes    []asn1.ObjectIdentifier `asn1:"explicit,tag:0"`
+		ReqFlags     asn1.BitString          `asn1:"explicit,optional,tag:1"`
+		MechToken    []byte                  `asn1:"explicit,optional,tag:2"`
+		MechTokenMIC []byte                  `asn1:"explicit,optional,tag:3"`
+	}
+)
+
+// unwrapInitialContextToken unwraps the InitialContextToken of
+// RFC 2743 section 3.1, it returns the mechanism and the inner token.
+func unwrapInitialContextToken(b []byte) (asn1.ObjectIdentifier, []byte, error) {
+	var outer asn1.RawValue
+	rest, err := asn1.Unmarshal(b, &outer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rest) != 0 || outer.Class != asn1.ClassApplication || outer.Tag != 0 {
+		return nil, nil, fmt.Errorf("not an initial context token")
+	}
+
+	var mech asn1.ObjectIdentifier
+	inner, err := asn1.Unmarshal(outer.Bytes, &mech)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mech, inner, nil
+}
+
+// parseAPReq parses the AP-REQ from the Negotiate token, which is either
+// a SPNEGO NegTokenInit carrying a Kerberos token, or a Kerberos token
+// sent directly by some clients.
+func parseAPReq(b []byte) (*messages.APReq, error) {
+	mech, inner, err := unwrapInitialContextToken(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+
+	if mech.Equal(oidSPNEGO) {
+		var choice asn1.RawValue
+		if _, err := asn1.Unmarshal(inner, &choice); err != nil {
+			return nil, fmt.Errorf("invalid SPNEGO token: %v", err)
+		}
+		// NOTE: Only the NegTokenInit is expected in the first and only
+		// leg of HTTP negotiation, NegTokenResp is for the subsequent ones.
+		if choice.Class != asn1.ClassContextSpecific || choice.Tag != 0 {
+			return nil, fmt.Errorf("SPNEGO token is not a NegTokenInit")
+		}
+		init := &negTokenInit{}
+		if _, err := asn1.Unmarshal(choice.Bytes, init); err != nil {
+			return nil, fmt.Errorf("invalid NegTokenInit: %v", err)
+		}
+		if len(init.MechToken) == 0 {
+			return nil, fmt.Errorf("no mechanism token in NegTokenInit")
+		}
+		// NOTE: The optimistic token is for the first mechanism of the
+		// client, which must be Kerberos since we support nothing else.
+		if len(init.MechTypes) == 0 || !isKerberos(init.MechTypes[0]) {
+			return nil, fmt.Errorf("preferred mechanism of the client is not Kerberos")
+		}
+
+		mech, inner, err = unwrapInitialContextToken(init.MechToken)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Kerberos token: %v", err)
+		}
+	}
+
+	if !isKerberos(mech) {
+		return nil, fmt.Errorf("unsupported mechanism %v", mech)
+	}
+	if len(inner) < len(tokenIDAPReq) || string(inner[:2]) != string(tokenIDAPReq) {
+		return nil, fmt.Errorf("Kerberos token is not an AP-REQ")
+	}
+
+	apReq := &messages.APReq{}
+	if err := apReq.Unmarshal(inner[2:]); err != nil {
+		return nil, fmt.Errorf("invalid AP-REQ: %v", err)
+	}
+	return apReq, nil
+}
+
+func isKerberos(mech asn1.ObjectIdentifier) bool {
+	return mech.Equal(oidKRB5) || mech.Equal(oidMSLegacyKRB5)
+}
@@ -18,7 +18,7 @@
 package timelimiter
 
 import (
-	"fmt"
+	stdcontext "context"
 	"net/http"
 	"time"
 
@@ -34,10 +34,7 @@ const (
 	resultTimeout = "timeout"
 )
 
-var (
-	results    = []string{resultTimeout}
-	errTimeout = fmt.Errorf("timeout")
-)
+var results = []string{resultTimeout}
 
 func init() {
 	httppipeline.Register(&TimeLimiter{})
@@ -111,12 +108,16 @@ func (tl *TimeLimiter) Inherit(filterSpec *httppipeline.FilterSpec, previousGene
 }
 
 func (tl *TimeLimiter) handle(ctx context.HTTPContext, u *URLRule) string {
-	timer := time.AfterFunc(u.timeout, func() {
-		ctx.Cancel(errTimeout)
-	})
+	// NOTE: The deadline is propagated to the next filters and their
+	// outbound requests by the context.
+	deadline := time.Now().Add(u.timeout)
+	ctx.SetDeadline(deadline)
 
 	result := ctx.CallNextHandler("")
-	if !timer.Stop() {
+
+	// NOTE: The context may be cancelled by an earlier deadline of the
+	// request, which is a timeout of the URL too.
+	if ctx.Err() == stdcontext.DeadlineExceeded {
 		ctx.AddTag("timeLimiter: timed out")
 		logger.Infof("time limiter %s timed out on URL(%s)", tl.filterSpec.Name(), u.ID())
 		ctx.Response().SetStatusCode(http.StatusRequestTimeout)
@@ -18,6 +18,7 @@
 package timelimiter
 
 import (
+	stdcontext "context"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -84,6 +85,16 @@ urls:
 	ctx.MockedCallNextHandler = func(lastResult string) string {
 		return ""
 	}
+	var deadline time.Time
+	ctx.MockedSetDeadline = func(d time.Time) {
+		deadline = d
+	}
+	ctx.MockedErr = func() error {
+		if !time.Now().Before(deadline) {
+			return stdcontext.DeadlineExceeded
+		}
+		return nil
+	}
 
 	result := tl.Handle(ctx)
 	if result == resultTimeout {
@@ -100,6 +111,19 @@ urls:
 		t.Error("expected timeout didn't happen")
 	}
 
+	// An earlier deadline of the request is a timeout of the URL too.
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		return ""
+	}
+	ctx.MockedErr = func() error {
+		return stdcontext.DeadlineExceeded
+	}
+	result = tl.Handle(ctx)
+	if result != resultTimeout {
+		t.Error("expected timeout of the earlier deadline didn't happen")
+	}
+	ctx.MockedErr = nil
+
 	ctx.MockedRequest.MockedPath = func() string {
 		return "/notimelimit"
 	}
@@ -0,0 +1,400 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+
+	yamljsontool "github.com/ghodss/yaml"
+	"github.com/jmespath/go-jmespath"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	typeString  = "string"
+	typeNumber  = "number"
+	typeInteger = "integer"
+	typeBoolean = "boolean"
+)
+
+type (
+	// Operation is an operation on the JSON document, exactly one of the
+	// fields must be specified.
+	Operation struct {
+		// Move moves the value to another path, renaming a field is moving
+		// it in the same object.
+		Move *MoveOp `yaml:"move,omitempty" jsonschema:"omitempty"`
+		// Delete deletes the values at the path.
+		Delete string `yaml:"delete,omitempty" jsonschema:"omitempty"`
+		// Set sets the values at the path.
+		Set *ValueOp `yaml:"set,omitempty" jsonschema:"omitempty"`
+		// Default sets the values at the path if they're missing or null.
+		Default *ValueOp `yaml:"default,omitempty" jsonschema:"omitempty"`
+		// Coerce converts the values at the path to the type.
+		Coerce *CoerceOp `yaml:"coerce,omitempty" jsonschema:"omitempty"`
+		// Expression replaces the document with the result of the JMESPath
+		// expression, see https://jmespath.org.
+		Expression string `yaml:"expression,omitempty" jsonschema:"omitempty"`
+	}
+
+	// MoveOp moves the value from a path to another.
+	MoveOp struct {
+		From string `yaml:"from" jsonschema:"required"`
+		To   string `yaml:"to" jsonschema:"required"`
+	}
+
+	// ValueOp sets the values at the path.
+	ValueOp struct {
+		Path  string      `yaml:"path" jsonschema:"required"`
+		Value interface{} `yaml:"value" jsonschema:"required"`
+	}
+
+	// CoerceOp converts the values at the path to the type, the elements of
+	// the arrays are converted one by one.
+	CoerceOp struct {
+		Path string `yaml:"path" jsonschema:"required"`
+		Type string `yaml:"type" jsonschema:"required,enum=string,enum=number,enum=integer,enum=boolean"`
+	}
+
+	// operation is the compiled Operation.
+	operation struct {
+		*Operation
+		from, to   *path
+		path       *path
+		value      []byte
+		expression *jmespath.JMESPath
+	}
+)
+
+// Validate validates Operation.
+func (op Operation) Validate() error {
+	count := 0
+	for _, specified := range []bool{
+		op.Move != nil, op.Delete != "", op.Set != nil,
+		op.Default != nil, op.Coerce != nil, op.Expression != "",
+	} {
+		if specified {
+			count++
+		}
+	}
+	if count != 1 {
+		return fmt.Errorf("exactly one of move, delete, set, default, coerce and expression must be specified")
+	}
+
+	_, err := newOperation(&op)
+	return err
+}
+
+func newOperation(spec *Operation) (*operation, error) {
+	op := &operation{Operation: spec}
+
+	var err error
+	switch {
+	case spec.Move != nil:
+		if op.from, err = parsePath(spec.Move.From); err != nil {
+			return nil, err
+		}
+		if op.to, err = parsePath(spec.Move.To); err != nil {
+			return nil, err
+		}
+		if op.from.hasWildcard() || op.to.hasWildcard() {
+			return nil, fmt.Errorf("move: wildcards are not allowed")
+		}
+	case spec.Delete != "":
+		op.path, err = parsePath(spec.Delete)
+	case spec.Set != nil, spec.Default != nil:
+		v := spec.Set
+		if v == nil {
+			v = spec.Default
+		}
+		if op.path, err = parsePath(v.Path); err != nil {
+			return nil, err
+		}
+		op.value, err = toJSON(v.Value)
+	case spec.Coerce != nil:
+		op.path, err = parsePath(spec.Coerce.Path)
+	case spec.Expression != "":
+		op.expression, err = jmespath.Compile(spec.Expression)
+		if err != nil {
+			err = fmt.Errorf("invalid expression %s: %v", spec.Expression, err)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// toJSON converts the value decoded from YAML to JSON, as its maps are
+// keyed by interface{}.
+func toJSON(v interface{}) ([]byte, error) {
+	buff, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return yamljsontool.YAMLToJSON(buff)
+}
+
+// decodeJSON decodes the JSON document, the numbers are decoded as
+// json.Number to keep their precision.
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("extra data after the document")
+	}
+	return doc, nil
+}
+
+// apply applies the operation to the document, and returns the new one.
+func (op *operation) apply(doc interface{}) (interface{}, error) {
+	switch {
+	case op.Move != nil:
+		return op.move(doc)
+	case op.Delete != "":
+		return op.path.walk(doc, false, deleteLeaf)
+	case op.Set != nil:
+		return op.path.walk(doc, true, op.setLeaf(false))
+	case op.Default != nil:
+		return op.path.walk(doc, true, op.setLeaf(true))
+	case op.Coerce != nil:
+		return op.path.walk(doc, false, op.coerceLeaf)
+	default:
+		return op.evaluate(doc)
+	}
+}
+
+func (op *operation) move(doc interface{}) (interface{}, error) {
+	var value interface{}
+	found := false
+	doc, err := op.from.walk(doc, false, func(parent interface{}, last segment) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			if v, ok := p[last.key]; ok && !last.isIndex {
+				value, found = v, true
+				delete(p, last.key)
+			}
+		case []interface{}:
+			if last.isIndex && last.index < len(p) {
+				value, found = p[last.index], true
+				return append(p[:last.index:last.index], p[last.index+1:]...), nil
+			}
+		}
+		return parent, nil
+	})
+	if err != nil || !found {
+		return doc, err
+	}
+
+	return op.to.walk(doc, true, func(parent interface{}, last segment) (interface{}, error) {
+		return setValue(op.to, parent, last, value, false)
+	})
+}
+
+func deleteLeaf(parent interface{}, last segment) (interface{}, error) {
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		for _, key := range keysOf(p, last) {
+			delete(p, key)
+		}
+	case []interface{}:
+		if last.all {
+			return []interface{}{}, nil
+		}
+		if last.isIndex && last.index < len(p) {
+			return append(p[:last.index:last.index], p[last.index+1:]...), nil
+		}
+	}
+	return parent, nil
+}
+
+func (op *operation) setLeaf(onlyMissing bool) leafFunc {
+	return func(parent interface{}, last segment) (interface{}, error) {
+		// NOTE: Every value is decoded from the spec, so the documents
+		// don't share it.
+		value, err := decodeJSON(op.value)
+		if err != nil {
+			return nil, err
+		}
+		return setValue(op.path, parent, last, value, onlyMissing)
+	}
+}
+
+func setValue(p *path, parent interface{}, last segment, value interface{}, onlyMissing bool) (interface{}, error) {
+	switch n := parent.(type) {
+	case map[string]interface{}:
+		if last.isIndex && !last.all {
+			return nil, p.mismatch(true, last, "an object")
+		}
+		keys := keysOf(n, last)
+		for _, key := range keys {
+			if onlyMissing && n[key] != nil {
+				continue
+			}
+			n[key] = value
+		}
+	case []interface{}:
+		if !last.isIndex && !last.all {
+			return nil, p.mismatch(true, last, "an array")
+		}
+		for _, i := range indexesOf(n, last) {
+			if onlyMissing && n[i] != nil {
+				continue
+			}
+			n[i] = value
+		}
+	case nil:
+		if last.isIndex || last.all {
+			return parent, nil
+		}
+		return map[string]interface{}{last.key: value}, nil
+	default:
+		return nil, p.mismatch(true, last, "a scalar")
+	}
+	return parent, nil
+}
+
+func (op *operation) coerceLeaf(parent interface{}, last segment) (interface{}, error) {
+	coerceChild := func(v interface{}) (interface{}, error) {
+		if a, ok := v.([]interface{}); ok {
+			for i := range a {
+				c, err := coerce(a[i], op.Coerce.Type)
+				if err != nil {
+					return nil, fmt.Errorf("path %s: %v", op.path.raw, err)
+				}
+				a[i] = c
+			}
+			return a, nil
+		}
+
+		c, err := coerce(v, op.Coerce.Type)
+		if err != nil {
+			return nil, fmt.Errorf("path %s: %v", op.path.raw, err)
+		}
+		return c, nil
+	}
+
+	var err error
+	switch n := parent.(type) {
+	case map[string]interface{}:
+		for _, key := range keysOf(n, last) {
+			if v, ok := n[key]; ok {
+				if n[key], err = coerceChild(v); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case []interface{}:
+		for _, i := range indexesOf(n, last) {
+			if n[i], err = coerceChild(n[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return parent, nil
+}
+
+// coerce converts the scalar to the type, the nulls, objects and arrays
+// are kept as they are.
+func coerce(v interface{}, typ string) (interface{}, error) {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case json.Number:
+		s = v.String()
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		return v, nil
+	}
+
+	switch typ {
+	case typeString:
+		return s, nil
+	case typeNumber, typeInteger:
+		switch s {
+		case "true":
+			return json.Number("1"), nil
+		case "false":
+			return json.Number("0"), nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("can't convert %q to %s", s, typ)
+		}
+		if typ == typeNumber {
+			// Keep the original text of the valid JSON numbers for the
+			// precision of the large integers.
+			if json.Valid([]byte(s)) {
+				return json.Number(s), nil
+			}
+			return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+		}
+		if f != math.Trunc(f) {
+			return nil, fmt.Errorf("can't convert %q to %s", s, typ)
+		}
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return json.Number(strconv.FormatInt(i, 10)), nil
+		}
+		return json.Number(strconv.FormatFloat(f, 'f', 0, 64)), nil
+	default:
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return n != 0, nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("can't convert %q to %s", s, typ)
+		}
+		return b, nil
+	}
+}
+
+// evaluate evaluates the expression against the document.
+func (op *operation) evaluate(doc interface{}) (interface{}, error) {
+	// NOTE: JMESPath works on float64 numbers only, so the document is
+	// decoded again without json.Number, and so is the result.
+	buff, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	if err := json.Unmarshal(buff, &data); err != nil {
+		return nil, err
+	}
+
+	result, err := op.expression.Search(data)
+	if err != nil {
+		return nil, fmt.Errorf("evaluate expression %s failed: %v", op.Expression, err)
+	}
+
+	buff, err = json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(buff)
+}
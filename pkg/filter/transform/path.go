@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package transform
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// path is the path of the values in a JSON document, like a.b[0].c,
+	// the wildcards in items[*].price or headers.* match all elements of
+	// the array or object.
+	path struct {
+		raw      string
+		segments []segment
+	}
+
+	segment struct {
+		key   string
+		index int
+		// isIndex reports whether the segment is an array index.
+		isIndex bool
+		// all reports whether the segment is a wildcard.
+		all bool
+	}
+
+	// leafFunc processes the values of the last segment of the path in the
+	// parent, and returns the new parent.
+	leafFunc func(parent interface{}, last segment) (interface{}, error)
+)
+
+func parsePath(s string) (*path, error) {
+	p := &path{raw: s}
+
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '.':
+			if i == 0 || i == len(s)-1 || s[i+1] == '.' || s[i+1] == '[' {
+				return nil, fmt.Errorf("invalid path %s: unexpected '.'", s)
+			}
+			i++
+		case s[i] == '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %s: missing ']'", s)
+			}
+			inner := s[i+1 : i+end]
+			if inner == "*" {
+				p.segments = append(p.segments, segment{isIndex: true, all: true})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("invalid path %s: invalid index %s", s, inner)
+				}
+				p.segments = append(p.segments, segment{isIndex: true, index: index})
+			}
+			i += end + 1
+			if i < len(s) && s[i] != '.' && s[i] != '[' {
+				return nil, fmt.Errorf("invalid path %s: unexpected %q after ']'", s, s[i])
+			}
+		default:
+			end := strings.IndexAny(s[i:], ".[")
+			if end < 0 {
+				end = len(s) - i
+			}
+			key := s[i : i+end]
+			p.segments = append(p.segments, segment{key: key, all: key == "*"})
+			i += end
+		}
+	}
+
+	if len(p.segments) == 0 {
+		return nil, fmt.Errorf("invalid path %s: empty", s)
+	}
+	return p, nil
+}
+
+func (p *path) hasWildcard() bool {
+	for _, seg := range p.segments {
+		if seg.all {
+			return true
+		}
+	}
+	return false
+}
+
+// walk walks to the parents of the values at the path, and replaces every
+// parent with the result of fn. The missing parents are created if create
+// is true, or skipped.
+func (p *path) walk(doc interface{}, create bool, fn leafFunc) (interface{}, error) {
+	return p.walkSegments(doc, p.segments, create, fn)
+}
+
+func (p *path) walkSegments(node interface{}, segments []segment, create bool, fn leafFunc) (interface{}, error) {
+	if len(segments) == 1 {
+		return fn(node, segments[0])
+	}
+
+	seg, rest := segments[0], segments[1:]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if seg.isIndex {
+			if seg.all {
+				// NOTE: An object matches [*] as an array of itself, as a
+				// single XML element is converted to an object rather than
+				// an array of it.
+				return p.walkSegments(node, rest, create, fn)
+			}
+			return node, p.mismatch(create, seg, "an object")
+		}
+		for _, key := range keysOf(n, seg) {
+			child, ok := n[key]
+			if !ok || child == nil {
+				if !create || seg.all {
+					continue
+				}
+				if rest[0].isIndex {
+					return nil, fmt.Errorf("path %s: can't create array for %s", p.raw, key)
+				}
+				child = map[string]interface{}{}
+			}
+
+			child, err := p.walkSegments(child, rest, create, fn)
+			if err != nil {
+				return nil, err
+			}
+			n[key] = child
+		}
+	case []interface{}:
+		if !seg.isIndex && !seg.all {
+			return node, p.mismatch(create, seg, "an array")
+		}
+		for _, i := range indexesOf(n, seg) {
+			child, err := p.walkSegments(n[i], rest, create, fn)
+			if err != nil {
+				return nil, err
+			}
+			n[i] = child
+		}
+	case nil:
+		if !create || seg.isIndex || seg.all {
+			return node, nil
+		}
+		return p.walkSegments(map[string]interface{}{}, segments, create, fn)
+	default:
+		return node, p.mismatch(create, seg, "a scalar")
+	}
+
+	return node, nil
+}
+
+// mismatch returns the error of the segment mismatching the node when
+// creating the values, or nil to skip the node.
+func (p *path) mismatch(create bool, seg segment, node string) error {
+	if !create {
+		return nil
+	}
+	if seg.isIndex {
+		return fmt.Errorf("path %s: index [%d] of %s", p.raw, seg.index, node)
+	}
+	return fmt.Errorf("path %s: field %s of %s", p.raw, seg.key, node)
+}
+
+// keysOf returns the keys of the object matching the segment.
+func keysOf(m map[string]interface{}, seg segment) []string {
+	if !seg.all {
+		return []string{seg.key}
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// indexesOf returns the existing indexes of the array matching the segment.
+func indexesOf(a []interface{}, seg segment) []int {
+	if !seg.all {
+		if seg.index < len(a) {
+			return []int{seg.index}
+		}
+		return nil
+	}
+
+	indexes := make([]int, len(a))
+	for i := range a {
+		indexes[i] = i
+	}
+	return indexes
+}
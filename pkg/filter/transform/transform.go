@@ -0,0 +1,330 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of Transform.
+	Kind = "Transform"
+
+	resultTransformFailed = "transformFailed"
+
+	formatJSON = "json"
+	formatXML  = "xml"
+
+	defaultMaxBodySize = 4 * 1024 * 1024
+)
+
+var (
+	results = []string{resultTransformFailed}
+
+	errBodyTooLarge = errors.New("body too large")
+)
+
+func init() {
+	httppipeline.Register(&Transform{})
+}
+
+type (
+	// Transform restructures the JSON bodies of the requests and responses
+	// by the operations like moving, deleting and setting fields, type
+	// coercion and JMESPath expressions, and converts them between JSON
+	// and XML.
+	Transform struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		request  *rule
+		response *rule
+
+		requestsTransformed  uint64
+		requestsFailed       uint64
+		responsesTransformed uint64
+		responsesFailed      uint64
+	}
+
+	// Spec describes the Transform.
+	Spec struct {
+		Request  *Rule `yaml:"request,omitempty" jsonschema:"omitempty"`
+		Response *Rule `yaml:"response,omitempty" jsonschema:"omitempty"`
+		// MaxBodySize is the max size of the bodies to transform, as they
+		// are buffered in memory.
+		MaxBodySize int64 `yaml:"maxBodySize" jsonschema:"omitempty,minimum=1"`
+	}
+
+	// Rule is the rule to transform the request or response bodies, the
+	// bodies of other content types than From are kept as they are.
+	Rule struct {
+		From string `yaml:"from" jsonschema:"omitempty,enum=,enum=json,enum=xml"`
+		To   string `yaml:"to" jsonschema:"omitempty,enum=,enum=json,enum=xml"`
+		// XMLRoot is the name of the root element when converting to XML.
+		XMLRoot    string       `yaml:"xmlRoot" jsonschema:"omitempty"`
+		Operations []*Operation `yaml:"operations" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of Transform.
+	Status struct {
+		RequestsTransformed  uint64 `yaml:"requestsTransformed"`
+		RequestsFailed       uint64 `yaml:"requestsFailed"`
+		ResponsesTransformed uint64 `yaml:"responsesTransformed"`
+		ResponsesFailed      uint64 `yaml:"responsesFailed"`
+	}
+
+	rule struct {
+		*Rule
+		operations []*operation
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if spec.Request == nil && spec.Response == nil {
+		return fmt.Errorf("neither request nor response is specified")
+	}
+	return nil
+}
+
+// Kind returns the kind of Transform.
+func (t *Transform) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of Transform.
+func (t *Transform) DefaultSpec() interface{} {
+	return &Spec{MaxBodySize: defaultMaxBodySize}
+}
+
+// Description returns the description of Transform.
+func (t *Transform) Description() string {
+	return "Transform restructures JSON bodies and converts them between JSON and XML."
+}
+
+// Results returns the results of Transform.
+func (t *Transform) Results() []string {
+	return results
+}
+
+// Init initializes Transform.
+func (t *Transform) Init(filterSpec *httppipeline.FilterSpec) {
+	t.filterSpec, t.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	t.reload()
+}
+
+// Inherit inherits previous generation of Transform.
+func (t *Transform) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	t.Init(filterSpec)
+}
+
+func (t *Transform) reload() {
+	t.request = newRule(t.spec.Request)
+	t.response = newRule(t.spec.Response)
+}
+
+func newRule(spec *Rule) *rule {
+	if spec == nil {
+		return nil
+	}
+
+	r := &rule{Rule: spec}
+	for _, opSpec := range spec.Operations {
+		// NOTE: The operations are validated by Operation.Validate.
+		op, _ := newOperation(opSpec)
+		r.operations = append(r.operations, op)
+	}
+	return r
+}
+
+func (r *rule) from() string {
+	if r.From == "" {
+		return formatJSON
+	}
+	return r.From
+}
+
+func (r *rule) to() string {
+	if r.To == "" {
+		return r.from()
+	}
+	return r.To
+}
+
+// accept reports whether the body of the content type is transformed.
+func (r *rule) accept(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	if r.from() == formatXML {
+		return mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// transform transforms the body, and returns the new body and its content
+// type.
+func (r *rule) transform(body []byte) ([]byte, string, error) {
+	var doc interface{}
+	var err error
+	if r.from() == formatXML {
+		doc, err = xmlToJSON(body)
+	} else {
+		doc, err = decodeJSON(body)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid %s: %v", r.from(), err)
+	}
+
+	for _, op := range r.operations {
+		if doc, err = op.apply(doc); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if r.to() == formatXML {
+		body, err = jsonToXML(doc, r.XMLRoot)
+		return body, "application/xml", err
+	}
+
+	buff := &bytes.Buffer{}
+	enc := json.NewEncoder(buff)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return nil, "", err
+	}
+	return bytes.TrimSuffix(buff.Bytes(), []byte("\n")), "application/json", nil
+}
+
+// readBody reads the body up to the max body size.
+func (t *Transform) readBody(body io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(body, t.spec.MaxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read body failed: %v", err)
+	}
+	if int64(len(data)) > t.spec.MaxBodySize {
+		return nil, errBodyTooLarge
+	}
+	return data, nil
+}
+
+// Handle transforms the request and response bodies of HTTPContext.
+func (t *Transform) Handle(ctx context.HTTPContext) string {
+	if result := t.handleRequest(ctx); result != "" {
+		return ctx.CallNextHandler(result)
+	}
+
+	result := ctx.CallNextHandler("")
+	t.handleResponse(ctx)
+	return result
+}
+
+func (t *Transform) handleRequest(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	if t.request == nil || !t.request.accept(r.Header().Get(httpheader.KeyContentType)) {
+		return ""
+	}
+
+	body, contentType, err := t.transformBody(t.request, r.Body())
+	if err != nil {
+		atomic.AddUint64(&t.requestsFailed, 1)
+		ctx.AddTag(stringtool.Cat("transform: request: ", err.Error()))
+		if err == errBodyTooLarge {
+			ctx.Response().SetStatusCode(http.StatusRequestEntityTooLarge)
+		} else {
+			ctx.Response().SetStatusCode(http.StatusBadRequest)
+		}
+		return resultTransformFailed
+	}
+
+	atomic.AddUint64(&t.requestsTransformed, 1)
+	r.Header().Set(httpheader.KeyContentType, contentType)
+	r.Header().Set(httpheader.KeyContentLength, strconv.Itoa(len(body)))
+	r.SetBody(bytes.NewReader(body))
+	return ""
+}
+
+func (t *Transform) handleResponse(ctx context.HTTPContext) {
+	w := ctx.Response()
+	if t.response == nil || w.Body() == nil || !t.response.accept(w.Header().Get(httpheader.KeyContentType)) {
+		return
+	}
+
+	// NOTE: The encoded bodies like gzip ones can't be transformed, so
+	// Transform should be placed before the filters compressing them.
+	if encoding := w.Header().Get(httpheader.KeyContentEncoding); encoding != "" && encoding != "identity" {
+		return
+	}
+
+	oldBody := w.Body()
+	body, contentType, err := t.transformBody(t.response, oldBody)
+	if closer, ok := oldBody.(io.Closer); ok {
+		closer.Close()
+	}
+	if err != nil {
+		atomic.AddUint64(&t.responsesFailed, 1)
+		ctx.AddTag(stringtool.Cat("transform: response: ", err.Error()))
+		w.SetBody(nil)
+		w.Header().Reset(http.Header{})
+		w.SetStatusCode(http.StatusBadGateway)
+		return
+	}
+
+	atomic.AddUint64(&t.responsesTransformed, 1)
+	w.Header().Set(httpheader.KeyContentType, contentType)
+	w.Header().Set(httpheader.KeyContentLength, strconv.Itoa(len(body)))
+	w.SetBody(bytes.NewReader(body))
+}
+
+func (t *Transform) transformBody(r *rule, body io.Reader) ([]byte, string, error) {
+	data, err := t.readBody(body)
+	if err != nil {
+		return nil, "", err
+	}
+	return r.transform(data)
+}
+
+// Status returns status.
+func (t *Transform) Status() interface{} {
+	return &Status{
+		RequestsTransformed:  atomic.LoadUint64(&t.requestsTransformed),
+		RequestsFailed:       atomic.LoadUint64(&t.requestsFailed),
+		ResponsesTransformed: atomic.LoadUint64(&t.responsesTransformed),
+		ResponsesFailed:      atomic.LoadUint64(&t.responsesFailed),
+	}
+}
+
+// Close closes Transform.
+func (t *Transform) Close() {}
@@ -0,0 +1,319 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package transform
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newTransform(t *testing.T, yamlSpec string) *Transform {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tf := &Transform{}
+	tf.Init(spec)
+	return tf
+}
+
+// handle handles a request of the body and content type, the next handler
+// records the request body, and responds with the response body and
+// content type.
+func handle(tf *Transform, body, contentType, respBody, respContentType string) (context.HTTPContext, string, string) {
+	stdr, _ := http.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader(body))
+	stdr.Header.Set("Content-Type", contentType)
+
+	received := ""
+	ctx := context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		if lastResult != "" {
+			return lastResult
+		}
+		data, _ := ioutil.ReadAll(ctx.Request().Body())
+		received = string(data)
+		ctx.Response().Header().Set("Content-Type", respContentType)
+		ctx.Response().SetBody(strings.NewReader(respBody))
+		return lastResult
+	})
+
+	tf.Handle(ctx)
+	resp := ""
+	if ctx.Response().Body() != nil {
+		data, _ := ioutil.ReadAll(ctx.Response().Body())
+		resp = string(data)
+	}
+	return ctx, received, resp
+}
+
+func TestValidate(t *testing.T) {
+	for _, spec := range []string{`
+kind: Transform
+name: transform
+`, `
+kind: Transform
+name: transform
+request:
+  operations:
+  - delete: a
+    coerce:
+      path: b
+      type: string
+`, `
+kind: Transform
+name: transform
+request:
+  operations:
+  - delete: "a..b"
+`, `
+kind: Transform
+name: transform
+request:
+  operations:
+  - move:
+      from: "items[*].id"
+      to: ids
+`, `
+kind: Transform
+name: transform
+request:
+  operations:
+  - expression: "items[?"
+`,
+	} {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(spec), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("spec should be invalid:%s", spec)
+		}
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	p, err := parsePath("a.b[2][*].*.c")
+	if err != nil {
+		t.Fatalf("parse path failed: %v", err)
+	}
+	want := []segment{
+		{key: "a"}, {key: "b"}, {isIndex: true, index: 2},
+		{isIndex: true, all: true}, {key: "*", all: true}, {key: "c"},
+	}
+	if len(p.segments) != len(want) {
+		t.Fatalf("want %v, got %v", want, p.segments)
+	}
+	for i := range want {
+		if p.segments[i] != want[i] {
+			t.Errorf("segment %d: want %v, got %v", i, want[i], p.segments[i])
+		}
+	}
+
+	for _, s := range []string{"", ".a", "a.", "a[", "a[x]", "a[-1]", "a[0]b"} {
+		if _, err := parsePath(s); err == nil {
+			t.Errorf("path %q should be invalid", s)
+		}
+	}
+}
+
+func TestOperations(t *testing.T) {
+	tf := newTransform(t, `
+kind: Transform
+name: transform
+request:
+  operations:
+  - move:
+      from: user.name
+      to: customer.fullName
+  - move:
+      from: items[0]
+      to: first
+  - delete: user.password
+  - delete: items[*].internal
+  - set:
+      path: meta.source
+      value: {channel: web, tags: [a, b]}
+  - default:
+      path: currency
+      value: USD
+  - default:
+      path: note
+      value: none
+  - coerce:
+      path: items[*].price
+      type: number
+  - coerce:
+      path: first.qty
+      type: integer
+  - coerce:
+      path: vip
+      type: boolean
+  - coerce:
+      path: id
+      type: string
+`)
+
+	body := `{"id":12345678901234567890,"user":{"name":"<Jo>","password":"x"},"note":"keep","vip":"1",` +
+		`"items":[{"qty":"2","price":"1.5"},{"price":"10","internal":true}]}`
+	_, received, _ := handle(tf, body, "application/json", "", "")
+
+	want := `{"currency":"USD","customer":{"fullName":"<Jo>"},"first":{"price":"1.5","qty":2},"id":"12345678901234567890",` +
+		`"items":[{"price":10}],"meta":{"source":{"channel":"web","tags":["a","b"]}},"note":"keep","user":{},"vip":true}`
+	if received != want {
+		t.Errorf("want %s, got %s", want, received)
+	}
+}
+
+func TestExpression(t *testing.T) {
+	tf := newTransform(t, `
+kind: Transform
+name: transform
+response:
+  operations:
+  - expression: "{total: length(orders), open: orders[?status=='open'].id, amount: sum(orders[].amount)}"
+`)
+
+	resp := `{"orders":[{"id":1,"status":"open","amount":2.5},{"id":2,"status":"closed","amount":1}]}`
+	ctx, _, got := handle(tf, "", "", resp, "application/json")
+
+	want := `{"amount":3.5,"open":[1],"total":2}`
+	if got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+	if cl := ctx.Response().Header().Get("Content-Length"); cl != strconv.Itoa(len(want)) {
+		t.Errorf("want content length %d, got %s", len(want), cl)
+	}
+}
+
+func TestXML(t *testing.T) {
+	tf := newTransform(t, `
+kind: Transform
+name: transform
+request:
+  from: xml
+  to: json
+  operations:
+  - coerce:
+      path: order.item[*].qty
+      type: integer
+response:
+  to: xml
+  xmlRoot: result
+`)
+
+	body := `<?xml version="1.0"?>
+<order xmlns="urn:example" id="7">
+  <customer>Jo &amp; Co</customer>
+  <item sku="a"><qty>1</qty></item>
+  <item sku="b"><qty>2</qty></item>
+  <note lang="en">fragile</note>
+</order>`
+	resp := `{"ok":true,"ids":[1,2],"detail":{"@code":"X1","#text":"a < b","empty":null}}`
+	ctx, received, got := handle(tf, body, "application/xml", resp, "application/json")
+
+	want := `{"order":{"@id":"7","customer":"Jo & Co","item":[{"@sku":"a","qty":1},{"@sku":"b","qty":2}],` +
+		`"note":{"#text":"fragile","@lang":"en"}}}`
+	if received != want {
+		t.Errorf("want %s, got %s", want, received)
+	}
+
+	wantResp := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<result><detail code="X1">a &lt; b<empty></empty></detail><ids>1</ids><ids>2</ids><ok>true</ok></result>`
+	if got != wantResp {
+		t.Errorf("want %s, got %s", wantResp, got)
+	}
+	if ct := ctx.Response().Header().Get("Content-Type"); ct != "application/xml" {
+		t.Errorf("want application/xml, got %s", ct)
+	}
+
+	// A single element matches [*] as well.
+	body = `<order><item sku="a"><qty>1</qty></item></order>`
+	_, received, _ = handle(tf, body, "text/xml", resp, "application/json")
+	if want := `{"order":{"item":{"@sku":"a","qty":1}}}`; received != want {
+		t.Errorf("want %s, got %s", want, received)
+	}
+
+	// The converted document converts back.
+	doc, err := xmlToJSON([]byte(got))
+	if err != nil {
+		t.Fatalf("convert xml failed: %v", err)
+	}
+	if _, err := jsonToXML(doc, ""); err != nil {
+		t.Fatalf("convert json failed: %v", err)
+	}
+}
+
+func TestFailures(t *testing.T) {
+	tf := newTransform(t, `
+kind: Transform
+name: transform
+maxBodySize: 64
+request:
+  operations:
+  - coerce:
+      path: qty
+      type: integer
+response:
+  operations:
+  - delete: secret
+`)
+
+	ctx, _, _ := handle(tf, `{"qty":"1.5"}`, "application/json", "", "")
+	if ctx.Response().StatusCode() != http.StatusBadRequest {
+		t.Errorf("want 400, got %d", ctx.Response().StatusCode())
+	}
+
+	ctx, _, _ = handle(tf, `{"qty":"`+strings.Repeat("1", 64)+`"}`, "application/json", "", "")
+	if ctx.Response().StatusCode() != http.StatusRequestEntityTooLarge {
+		t.Errorf("want 413, got %d", ctx.Response().StatusCode())
+	}
+
+	// The bodies of other content types are kept as they are.
+	_, received, got := handle(tf, "qty=1.5", "application/x-www-form-urlencoded", "<html/>", "text/html")
+	if received != "qty=1.5" || got != "<html/>" {
+		t.Errorf("want bodies unchanged, got %s, %s", received, got)
+	}
+
+	ctx, _, _ = handle(tf, `{"qty":"1"}`, "application/json", `{"secret":`, "application/json")
+	if ctx.Response().StatusCode() != http.StatusBadGateway {
+		t.Errorf("want 502, got %d", ctx.Response().StatusCode())
+	}
+
+	status := tf.Status().(*Status)
+	if status.RequestsFailed != 2 || status.RequestsTransformed != 1 || status.ResponsesFailed != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
@@ -0,0 +1,257 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The XML documents are converted to JSON ones and vice versa by the
+// following conventions:
+//
+//   - An element is converted to a field named after it, and the repeated
+//     elements to an array.
+//   - An element without attributes and child elements is converted to
+//     its text, and the others to objects.
+//   - An attribute is converted to a field named after it with the prefix
+//     @, and the text of an element with attributes or child elements to
+//     the field #text.
+//   - The namespaces are dropped.
+const (
+	attrPrefix = "@"
+	textKey    = "#text"
+)
+
+var xmlNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.\-]*$`)
+
+type xmlElement struct {
+	attrs    []xml.Attr
+	children []*xmlChild
+	text     strings.Builder
+}
+
+type xmlChild struct {
+	name    string
+	element *xmlElement
+}
+
+// xmlToJSON converts the XML document to a JSON one, whose only field is
+// named after the root element.
+func xmlToJSON(data []byte) (interface{}, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+
+	var root *xmlChild
+	stack := []*xmlElement{}
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			child := &xmlChild{name: t.Name.Local, element: &xmlElement{attrs: t.Attr}}
+			if len(stack) == 0 {
+				if root != nil {
+					return nil, fmt.Errorf("multiple root elements")
+				}
+				root = child
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, child)
+			}
+			stack = append(stack, child.element)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("no root element")
+	}
+	return map[string]interface{}{root.name: root.element.toJSON()}, nil
+}
+
+func (e *xmlElement) toJSON() interface{} {
+	text := strings.TrimSpace(e.text.String())
+
+	attrs := []xml.Attr{}
+	for _, attr := range e.attrs {
+		if attr.Name.Space != "xmlns" && attr.Name.Local != "xmlns" {
+			attrs = append(attrs, attr)
+		}
+	}
+	if len(attrs) == 0 && len(e.children) == 0 {
+		return text
+	}
+
+	m := map[string]interface{}{}
+	for _, attr := range attrs {
+		m[attrPrefix+attr.Name.Local] = attr.Value
+	}
+	for _, child := range e.children {
+		value := child.element.toJSON()
+		switch existing := m[child.name].(type) {
+		case nil:
+			m[child.name] = value
+		case []interface{}:
+			m[child.name] = append(existing, value)
+		default:
+			m[child.name] = []interface{}{existing, value}
+		}
+	}
+	if text != "" {
+		m[textKey] = text
+	}
+	return m
+}
+
+// jsonToXML converts the JSON document to an XML one. The root element is
+// named root if it's not empty, otherwise the document must be an object
+// with only one field, which is converted to the root element.
+func jsonToXML(doc interface{}, root string) ([]byte, error) {
+	if root == "" {
+		m, ok := doc.(map[string]interface{})
+		if !ok || len(m) != 1 {
+			return nil, fmt.Errorf("the document must be an object with only one field to convert to XML")
+		}
+		for k, v := range m {
+			root, doc = k, v
+		}
+	}
+
+	buff := bytes.NewBufferString(xml.Header)
+	enc := xml.NewEncoder(buff)
+	if err := encodeElement(enc, root, doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func encodeElement(enc *xml.Encoder, name string, value interface{}) error {
+	if !xmlNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid XML element name %q", name)
+	}
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		children := []string{}
+		for _, k := range keys {
+			switch {
+			case strings.HasPrefix(k, attrPrefix):
+				attr := strings.TrimPrefix(k, attrPrefix)
+				if !xmlNameRegexp.MatchString(attr) {
+					return fmt.Errorf("invalid XML attribute name %q", attr)
+				}
+				start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: attr}, Value: scalarText(v[k])})
+			case k != textKey:
+				children = append(children, k)
+			}
+		}
+
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		if text, ok := v[textKey]; ok {
+			if err := enc.EncodeToken(xml.CharData(scalarText(text))); err != nil {
+				return err
+			}
+		}
+		for _, k := range children {
+			if err := encodeChild(enc, k, v[k]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		// NOTE: The elements of an array as the root or an element of
+		// another array have no names, so they're named item.
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		if err := encodeChild(enc, "item", v); err != nil {
+			return err
+		}
+	default:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		if v != nil {
+			if err := enc.EncodeToken(xml.CharData(scalarText(v))); err != nil {
+				return err
+			}
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// encodeChild encodes the field of an object, an array is encoded as the
+// repeated elements.
+func encodeChild(enc *xml.Encoder, name string, value interface{}) error {
+	a, ok := value.([]interface{})
+	if !ok {
+		return encodeElement(enc, name, value)
+	}
+
+	for _, v := range a {
+		if err := encodeElement(enc, name, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scalarText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		buff, _ := json.Marshal(v)
+		return string(buff)
+	}
+}
@@ -18,19 +18,27 @@
 package validator
 
 import (
-	"bytes"
+	stdcontext "context"
+	"crypto/sha256"
 	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 
 	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/util/jwks"
+	"github.com/megaease/easegress/pkg/util/stringtool"
 )
 
+const maxIntrospectCacheSize = 10000
+
 type (
 	// OAuth2TokenIntrospect defines the validator configuration for OAuth2 token introspection
 	OAuth2TokenIntrospect struct {
@@ -39,13 +47,21 @@ type (
 		ClientID     string `yaml:"clientId" jsonschema:"omitempty"`
 		ClientSecret string `yaml:"clientSecret" jsonschema:"omitempty"`
 		InsecureTLS  bool   `yaml:"insecureTls"`
+		// CacheTTL caches the introspection results, the results of
+		// active tokens are cached until the tokens expire at most.
+		CacheTTL string `yaml:"cacheTTL" jsonschema:"omitempty,format=duration"`
 	}
 
 	// OAuth2JWT defines the validator configuration for OAuth2 self encoded access token
 	OAuth2JWT struct {
-		Algorithm string `yaml:"algorithm" jsonschema:"enum=HS256,enum=HS384,enum=HS512"`
-		// Secret is in hex encoding
-		Secret      string `yaml:"secret" jsonschema:"required,pattern=^[A-Fa-f0-9]+$"`
+		Algorithm string `yaml:"algorithm" jsonschema:"omitempty,enum=,enum=HS256,enum=HS384,enum=HS512,enum=RS256,enum=RS384,enum=RS512,enum=PS256,enum=PS384,enum=PS512,enum=ES256,enum=ES384,enum=ES512"`
+		// Secret is in hex encoding, it's for the HMAC algorithms.
+		Secret string `yaml:"secret" jsonschema:"omitempty,pattern=^[A-Fa-f0-9]*$"`
+		// JWKSURL is the JSON Web Key Set of the authorization server, for
+		// the asymmetric algorithms. The keys are fetched again for
+		// unknown key IDs, and after JWKSMaxAge.
+		JWKSURL     string `yaml:"jwksURL" jsonschema:"omitempty"`
+		JWKSMaxAge  string `yaml:"jwksMaxAge" jsonschema:"omitempty,format=duration"`
 		secretBytes []byte
 	}
 
@@ -53,29 +69,77 @@ type (
 	OAuth2ValidatorSpec struct {
 		TokenIntrospect *OAuth2TokenIntrospect `yaml:"tokenIntrospect" jsonschema:"omitempty"`
 		JWT             *OAuth2JWT             `yaml:"jwt" jsonschema:"omitempty"`
+		// Issuer, Audiences, RequiredScopes and ClientIDs are the
+		// requirements of the tokens, they're not checked if empty.
+		Issuer string `yaml:"issuer" jsonschema:"omitempty"`
+		// Audiences requires the tokens to have one of them.
+		Audiences []string `yaml:"audiences" jsonschema:"omitempty"`
+		// RequiredScopes requires the tokens to have all of them.
+		RequiredScopes []string `yaml:"requiredScopes" jsonschema:"omitempty"`
+		// ClientIDs are the clients allowed, which is useful for tokens of
+		// the client credentials grant.
+		ClientIDs []string `yaml:"clientIDs" jsonschema:"omitempty"`
 	}
 
 	// OAuth2Validator defines the OAuth2 validator
 	OAuth2Validator struct {
 		spec   *OAuth2ValidatorSpec
 		client *http.Client
+		cache  *introspectCache
+		keys   *jwks.KeySet
 	}
 
 	tokenInfo struct {
-		Active    bool   `json:"active"`
-		Scope     string `json:"scope"`
-		ClientID  string `json:"client_id"`
-		UserName  string `json:"username"`
-		TokenType string `json:"token_type"`
-		ExpiresAt int64  `json:"exp"`
-		IssuedAt  int64  `json:"iat"`
-		NotBefore int64  `json:"nbf"`
-		Subject   string `json:"sub"`
-		Audience  string `json:"aud"`
-		Issuer    string `json:"iss"`
+		Active    bool        `json:"active"`
+		Scope     string      `json:"scope"`
+		ClientID  string      `json:"client_id"`
+		UserName  string      `json:"username"`
+		TokenType string      `json:"token_type"`
+		ExpiresAt int64       `json:"exp"`
+		IssuedAt  int64       `json:"iat"`
+		NotBefore int64       `json:"nbf"`
+		Subject   string      `json:"sub"`
+		Audience  interface{} `json:"aud"`
+		Issuer    string      `json:"iss"`
+	}
+
+	// grant is the authorization of a token.
+	grant struct {
+		subject   string
+		clientID  string
+		scope     string
+		audiences []string
+		issuer    string
+	}
+
+	introspectCache struct {
+		ttl time.Duration
+
+		mutex   sync.Mutex
+		entries map[string]*introspectCacheEntry
+	}
+
+	introspectCacheEntry struct {
+		ti        *tokenInfo
+		expiresAt time.Time
 	}
 )
 
+// Validate validates OAuth2JWT.
+func (spec OAuth2JWT) Validate() error {
+	if spec.JWKSURL == "" && spec.Secret == "" {
+		return fmt.Errorf("secret or jwksURL is required")
+	}
+	isHMAC := strings.HasPrefix(spec.Algorithm, "HS")
+	if isHMAC && spec.Secret == "" {
+		return fmt.Errorf("secret is required by %s", spec.Algorithm)
+	}
+	if !isHMAC && spec.JWKSURL == "" {
+		return fmt.Errorf("jwksURL is required by asymmetric algorithms")
+	}
+	return nil
+}
+
 // NewOAuth2Validator creates a new OAuth2 validator
 func NewOAuth2Validator(spec *OAuth2ValidatorSpec) *OAuth2Validator {
 	if spec.JWT != nil {
@@ -89,30 +153,84 @@ func NewOAuth2Validator(spec *OAuth2ValidatorSpec) *OAuth2Validator {
 		} else {
 			v.client = http.DefaultClient
 		}
+		if ttl, _ := time.ParseDuration(spec.TokenIntrospect.CacheTTL); ttl > 0 {
+			v.cache = &introspectCache{ttl: ttl, entries: map[string]*introspectCacheEntry{}}
+		}
+	}
+	if spec.JWT != nil && spec.JWT.JWKSURL != "" {
+		maxAge, _ := time.ParseDuration(spec.JWT.JWKSMaxAge)
+		v.keys = jwks.New(spec.JWT.JWKSURL, nil, maxAge)
 	}
 	return v
 }
 
+func cacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *introspectCache) get(token string) *tokenInfo {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e := c.entries[cacheKey(token)]
+	if e == nil || time.Now().After(e.expiresAt) {
+		return nil
+	}
+	return e.ti
+}
+
+func (c *introspectCache) put(token string, ti *tokenInfo) {
+	expiresAt := time.Now().Add(c.ttl)
+	if ti.Active && ti.ExpiresAt > 0 {
+		if exp := time.Unix(ti.ExpiresAt, 0); exp.Before(expiresAt) {
+			expiresAt = exp
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.entries) >= maxIntrospectCacheSize {
+		now := time.Now()
+		for k, e := range c.entries {
+			if now.After(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		// NOTE: All entries are dropped if none of them expires, which
+		// is rare and costs only more introspections.
+		if len(c.entries) >= maxIntrospectCacheSize {
+			c.entries = map[string]*introspectCacheEntry{}
+		}
+	}
+	c.entries[cacheKey(token)] = &introspectCacheEntry{ti: ti, expiresAt: expiresAt}
+}
+
 // make it mockable
 var fnSendRequest = func(client *http.Client, r *http.Request) (*http.Response, error) {
 	return client.Do(r)
 }
 
-func (v *OAuth2Validator) introspectToken(tokenStr string) (*tokenInfo, error) {
-	var body bytes.Buffer
-	body.WriteString("token=")
-	body.WriteString(tokenStr)
-	if v.spec.TokenIntrospect.ClientID != "" {
-		body.WriteString("&client_id=")
-		body.WriteString(v.spec.TokenIntrospect.ClientID)
-		body.WriteString("&client_secret=")
-		body.WriteString(v.spec.TokenIntrospect.ClientSecret)
+func (v *OAuth2Validator) introspectToken(ctx stdcontext.Context, tokenStr string) (*tokenInfo, error) {
+	if v.cache != nil {
+		if ti := v.cache.get(tokenStr); ti != nil {
+			return ti, nil
+		}
 	}
 
-	r, _ := http.NewRequest(http.MethodPost, v.spec.TokenIntrospect.EndPoint, &body)
+	form := url.Values{}
+	form.Set("token", tokenStr)
+	form.Set("token_type_hint", "access_token")
 	if v.spec.TokenIntrospect.ClientID != "" {
-		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	} else if v.spec.TokenIntrospect.BasicAuth != "" {
+		form.Set("client_id", v.spec.TokenIntrospect.ClientID)
+		form.Set("client_secret", v.spec.TokenIntrospect.ClientSecret)
+	}
+
+	r, _ := http.NewRequestWithContext(ctx, http.MethodPost, v.spec.TokenIntrospect.EndPoint, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Accept", "application/json")
+	if v.spec.TokenIntrospect.ClientID == "" && v.spec.TokenIntrospect.BasicAuth != "" {
 		r.Header.Set("Authorization", "Basic "+v.spec.TokenIntrospect.BasicAuth)
 	}
 
@@ -120,6 +238,7 @@ func (v *OAuth2Validator) introspectToken(tokenStr string) (*tokenInfo, error) {
 	if e != nil {
 		return nil, e
 	}
+	defer resp.Body.Close()
 
 	var ti struct {
 		tokenInfo
@@ -133,12 +252,108 @@ func (v *OAuth2Validator) introspectToken(tokenStr string) (*tokenInfo, error) {
 	if ti.Error != "" {
 		return nil, fmt.Errorf("%s: %s", ti.Error, ti.ErrorDesc)
 	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("token introspection failed: status code %d", resp.StatusCode)
+	}
 
+	if v.cache != nil {
+		v.cache.put(tokenStr, &ti.tokenInfo)
+	}
 	return &ti.tokenInfo, nil
 }
 
-// Validate validates the access token of a http request
-func (v *OAuth2Validator) Validate(req context.HTTPRequest) error {
+// stringList converts a claim of a string or an array of strings.
+func stringList(claim interface{}) []string {
+	switch claim := claim.(type) {
+	case string:
+		return []string{claim}
+	case []interface{}:
+		values := make([]string, 0, len(claim))
+		for _, v := range claim {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func (v *OAuth2Validator) parseJWT(tokenStr string) (*grant, error) {
+	token, e := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		alg := token.Method.Alg()
+		if v.spec.JWT.Algorithm != "" && alg != v.spec.JWT.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method: %v", alg)
+		}
+		if strings.HasPrefix(alg, "HS") {
+			if len(v.spec.JWT.secretBytes) == 0 {
+				return nil, fmt.Errorf("unexpected signing method: %v", alg)
+			}
+			return v.spec.JWT.secretBytes, nil
+		}
+		if v.keys == nil {
+			return nil, fmt.Errorf("unexpected signing method: %v", alg)
+		}
+		return v.keys.Keyfunc(token)
+	})
+	if e != nil {
+		return nil, e
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	g := &grant{audiences: stringList(claims["aud"])}
+	g.subject, _ = claims["sub"].(string)
+	g.issuer, _ = claims["iss"].(string)
+	g.scope, _ = claims["scope"].(string)
+	if g.scope == "" {
+		// NOTE: Some servers put the scopes in the array claim scp.
+		g.scope = strings.Join(stringList(claims["scp"]), " ")
+	}
+	for _, name := range []string{"client_id", "azp", "cid"} {
+		if g.clientID, _ = claims[name].(string); g.clientID != "" {
+			break
+		}
+	}
+	return g, nil
+}
+
+// check checks the grant against the requirements.
+func (v *OAuth2Validator) check(g *grant) error {
+	if v.spec.Issuer != "" && g.issuer != v.spec.Issuer {
+		return fmt.Errorf("unexpected issuer: %s", g.issuer)
+	}
+
+	if len(v.spec.Audiences) > 0 {
+		found := false
+		for _, aud := range g.audiences {
+			if stringtool.StrInSlice(aud, v.spec.Audiences) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unexpected audiences: %v", g.audiences)
+		}
+	}
+
+	scopes := strings.Fields(g.scope)
+	for _, scope := range v.spec.RequiredScopes {
+		if !stringtool.StrInSlice(scope, scopes) {
+			return fmt.Errorf("insufficient scope, %s is required", scope)
+		}
+	}
+
+	if len(v.spec.ClientIDs) > 0 && !stringtool.StrInSlice(g.clientID, v.spec.ClientIDs) {
+		return fmt.Errorf("unexpected client: %s", g.clientID)
+	}
+
+	return nil
+}
+
+// Validate validates the access token of a http request, the token
+// introspection is cancelled with ctx.
+func (v *OAuth2Validator) Validate(ctx stdcontext.Context, req context.HTTPRequest) error {
 	const prefix = "Bearer "
 
 	hdr := req.Header()
@@ -148,39 +363,48 @@ func (v *OAuth2Validator) Validate(req context.HTTPRequest) error {
 	}
 	tokenStr = tokenStr[len(prefix):]
 
-	var subject, scope string
+	var g *grant
 	if v.spec.TokenIntrospect != nil {
-		ti, e := v.introspectToken(tokenStr)
+		ti, e := v.introspectToken(ctx, tokenStr)
 		if e != nil {
 			return e
 		}
 		if !ti.Active {
 			return fmt.Errorf("oauth2 authorization failed, token is inactive")
 		}
-		subject = ti.Subject
-		scope = ti.Scope
+		g = &grant{
+			subject:   ti.Subject,
+			clientID:  ti.ClientID,
+			scope:     ti.Scope,
+			audiences: stringList(ti.Audience),
+			issuer:    ti.Issuer,
+		}
 	} else {
-		token, e := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-			if alg := token.Method.Alg(); alg != v.spec.JWT.Algorithm {
-				return nil, fmt.Errorf("unexpected signing method: %v", alg)
-			}
-			return v.spec.JWT.secretBytes, nil
-		})
-		if e != nil {
+		var e error
+		if g, e = v.parseJWT(tokenStr); e != nil {
 			return e
 		}
+	}
+
+	if e := v.check(g); e != nil {
+		return e
+	}
+
+	// NOTE: Clients must not claim the authentication themselves.
+	hdr.Del("X-Authenticated-Userid")
+	hdr.Del("X-Authenticated-Scope")
+	hdr.Del("X-Authenticated-Clientid")
 
-		claims := token.Claims.(jwt.MapClaims)
-		subject, _ = claims["sub"].(string)
-		scope, _ = claims["scope"].(string)
+	if g.subject != "" {
+		hdr.Set("X-Authenticated-Userid", g.subject)
 	}
 
-	if subject != "" {
-		hdr.Set("X-Authenticated-Userid", subject)
+	if g.scope != "" {
+		hdr.Set("X-Authenticated-Scope", g.scope)
 	}
 
-	if scope != "" {
-		hdr.Set("X-Authenticated-Scope", scope)
+	if g.clientID != "" {
+		hdr.Set("X-Authenticated-Clientid", g.clientID)
 	}
 
 	return nil
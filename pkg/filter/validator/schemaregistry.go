@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package validator
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/util/schemaregistry"
+)
+
+type (
+	// SchemaRegistryValidatorSpec defines the configuration of schema registry validator
+	SchemaRegistryValidatorSpec struct {
+		schemaregistry.Spec `yaml:",inline"`
+
+		// Topic and RecordName name the subject with the strategy, the
+		// headers override them if they're present in requests.
+		Topic            string `yaml:"topic" jsonschema:"omitempty"`
+		TopicHeader      string `yaml:"topicHeader" jsonschema:"omitempty"`
+		RecordName       string `yaml:"recordName" jsonschema:"omitempty"`
+		RecordNameHeader string `yaml:"recordNameHeader" jsonschema:"omitempty"`
+		// Serialize replaces the JSON body by its serialized form in the
+		// wire format of Confluent.
+		Serialize bool `yaml:"serialize" jsonschema:"omitempty"`
+	}
+
+	// SchemaRegistryValidator validates the request bodies by the schemas
+	// in schema registry
+	SchemaRegistryValidator struct {
+		spec     *SchemaRegistryValidatorSpec
+		registry *schemaregistry.Registry
+	}
+)
+
+// NewSchemaRegistryValidator creates a new schema registry validator
+func NewSchemaRegistryValidator(spec *SchemaRegistryValidatorSpec) *SchemaRegistryValidator {
+	return &SchemaRegistryValidator{
+		spec:     spec,
+		registry: schemaregistry.New(&spec.Spec),
+	}
+}
+
+// Validate validates the body of a http request, the error is a
+// *schemaregistry.ValidationError if the body is invalid.
+func (v *SchemaRegistryValidator) Validate(req context.HTTPRequest) error {
+	topic, record := v.spec.Topic, v.spec.RecordName
+	if v.spec.TopicHeader != "" {
+		if value := req.Header().Get(v.spec.TopicHeader); value != "" {
+			topic = value
+		}
+	}
+	if v.spec.RecordNameHeader != "" {
+		if value := req.Header().Get(v.spec.RecordNameHeader); value != "" {
+			record = value
+		}
+	}
+
+	subject, err := v.registry.Subject(topic, record)
+	if err != nil {
+		return &schemaregistry.ValidationError{Message: err.Error()}
+	}
+
+	schema, err := v.registry.Latest(subject)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(req.Body())
+	if err != nil {
+		return fmt.Errorf("read body failed: %v", err)
+	}
+
+	if !v.spec.Serialize {
+		req.SetBody(bytes.NewReader(body))
+		return schema.Validate(body)
+	}
+
+	data, err := schema.Serialize(body)
+	if err != nil {
+		req.SetBody(bytes.NewReader(body))
+		return err
+	}
+	req.SetBody(bytes.NewReader(data))
+	req.Header().Set("Content-Type", "application/octet-stream")
+	return nil
+}
@@ -22,7 +22,9 @@ import (
 
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/clientcert"
 	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/schemaregistry"
 	"github.com/megaease/easegress/pkg/util/signer"
 	"github.com/megaease/easegress/pkg/util/stringtool"
 )
@@ -49,7 +51,9 @@ type (
 		headers *httpheader.Validator
 		jwt     *JWTValidator
 		signer  *signer.Signer
+		cert    *clientcert.Matcher
 		oauth2  *OAuth2Validator
+		schema  *SchemaRegistryValidator
 	}
 
 	// Spec describes the Validator.
@@ -58,6 +62,12 @@ type (
 		JWT       *JWTValidatorSpec         `yaml:"jwt,omitempty" jsonschema:"omitempty"`
 		Signature *signer.Spec              `yaml:"signature,omitempty" jsonschema:"omitempty"`
 		OAuth2    *OAuth2ValidatorSpec      `yaml:"oauth2,omitempty" jsonschema:"omitempty"`
+		// ClientCertificate validates the identities in the certificates
+		// of clients, which are verified by the HTTPServer.
+		ClientCertificate *clientcert.Spec `yaml:"clientCertificate,omitempty" jsonschema:"omitempty"`
+		// SchemaRegistry validates the JSON bodies by the schemas in
+		// Confluent Schema Registry.
+		SchemaRegistry *SchemaRegistryValidatorSpec `yaml:"schemaRegistry,omitempty" jsonschema:"omitempty"`
 	}
 )
 
@@ -106,9 +116,17 @@ func (v *Validator) reload() {
 		v.signer = signer.CreateFromSpec(v.spec.Signature)
 	}
 
+	if v.spec.ClientCertificate != nil {
+		v.cert = clientcert.NewMatcher(v.spec.ClientCertificate)
+	}
+
 	if v.spec.OAuth2 != nil {
 		v.oauth2 = NewOAuth2Validator(v.spec.OAuth2)
 	}
+
+	if v.spec.SchemaRegistry != nil {
+		v.schema = NewSchemaRegistryValidator(v.spec.SchemaRegistry)
+	}
 }
 
 // Handle validates HTTPContext.
@@ -147,8 +165,21 @@ func (v *Validator) handle(ctx context.HTTPContext) string {
 		}
 	}
 
+	if v.cert != nil {
+		id := clientcert.FromTLS(req.Std().TLS)
+		if !v.cert.Match(id) {
+			ctx.Response().SetStatusCode(http.StatusForbidden)
+			if id == nil {
+				ctx.AddTag("client certificate validator: no client certificate")
+			} else {
+				ctx.AddTag(stringtool.Cat("client certificate validator: ", id.Subject, " not allowed"))
+			}
+			return resultInvalid
+		}
+	}
+
 	if v.oauth2 != nil {
-		err := v.oauth2.Validate(req)
+		err := v.oauth2.Validate(ctx, req)
 		if err != nil {
 			ctx.Response().SetStatusCode(http.StatusForbidden)
 			ctx.AddTag(stringtool.Cat("oauth2 validator: ", err.Error()))
@@ -156,6 +187,19 @@ func (v *Validator) handle(ctx context.HTTPContext) string {
 		}
 	}
 
+	if v.schema != nil {
+		err := v.schema.Validate(req)
+		if err != nil {
+			if _, ok := err.(*schemaregistry.ValidationError); ok {
+				ctx.Response().SetStatusCode(http.StatusBadRequest)
+			} else {
+				ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
+			}
+			ctx.AddTag(stringtool.Cat("schema registry validator: ", err.Error()))
+			return resultInvalid
+		}
+	}
+
 	return ""
 }
 
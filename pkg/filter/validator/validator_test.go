@@ -18,12 +18,23 @@
 package validator
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
 
 	"github.com/megaease/easegress/pkg/context/contexttest"
 	"github.com/megaease/easegress/pkg/logger"
@@ -266,6 +277,130 @@ oauth2:
 	}
 }
 
+func TestOAuth2TokenIntrospectCache(t *testing.T) {
+	yamlSpec := `
+kind: Validator
+name: validator
+oauth2:
+  tokenIntrospect:
+    endPoint: http://oauth2.megaease.com/
+    clientId: megaease
+    clientSecret: secret
+    cacheTTL: 1m
+  audiences: [orders]
+  requiredScopes: [read]
+  clientIDs: [billing]
+`
+	v := createValidator(yamlSpec, nil)
+
+	header := http.Header{}
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(header)
+	}
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) {}
+
+	requests := 0
+	body := ""
+	fnSendRequest = func(client *http.Client, r *http.Request) (*http.Response, error) {
+		requests++
+		r.ParseForm()
+		if r.PostForm.Get("token") != "a+b" || r.PostForm.Get("client_id") != "megaease" {
+			t.Errorf("unexpected form %v", r.PostForm)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}, nil
+	}
+
+	body = fmt.Sprintf(`{"active": true, "client_id": "billing", "scope": "read write", "aud": ["orders"], "exp": %d}`,
+		time.Now().Add(time.Hour).Unix())
+	header.Set("Authorization", "Bearer a+b")
+	header.Set("X-Authenticated-Userid", "mallory")
+	if result := v.Handle(ctx); result != "" {
+		t.Fatalf("OAuth/2 Authorization should succeed")
+	}
+	if header.Get("X-Authenticated-Clientid") != "billing" || header.Get("X-Authenticated-Userid") != "" {
+		t.Errorf("unexpected headers %v", header)
+	}
+
+	// The result is cached.
+	v.Handle(ctx)
+	if requests != 1 {
+		t.Errorf("token should be introspected once, but %d", requests)
+	}
+
+	for _, body = range []string{
+		`{"active": true, "client_id": "billing", "scope": "write", "aud": "orders"}`,
+		`{"active": true, "client_id": "billing", "scope": "read", "aud": "payments"}`,
+		`{"active": true, "client_id": "shipping", "scope": "read", "aud": "orders"}`,
+	} {
+		v = createValidator(yamlSpec, v)
+		if result := v.Handle(ctx); result != resultInvalid {
+			t.Errorf("OAuth/2 Authorization should fail for %s", body)
+		}
+	}
+}
+
+func TestOAuth2JWKS(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"keys": [{"kty": "RSA", "kid": "k1", "n": "%s", "e": "AQAB"}]}`,
+			base64.RawURLEncoding.EncodeToString(key.N.Bytes()))
+	}))
+	defer server.Close()
+
+	v := createValidator(fmt.Sprintf(`
+kind: Validator
+name: validator
+oauth2:
+  jwt:
+    jwksURL: %s
+  issuer: https://auth.megaease.com
+`, server.URL), nil)
+
+	header := http.Header{}
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(header)
+	}
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) {}
+
+	sign := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "k1"
+		signed, _ := token.SignedString(key)
+		return signed
+	}
+
+	header.Set("Authorization", "Bearer "+sign(jwt.MapClaims{
+		"iss": "https://auth.megaease.com",
+		"azp": "billing",
+		"scp": []string{"read", "write"},
+	}))
+	if result := v.Handle(ctx); result != "" {
+		t.Fatalf("OAuth/2 Authorization should succeed")
+	}
+	if header.Get("X-Authenticated-Clientid") != "billing" || header.Get("X-Authenticated-Scope") != "read write" {
+		t.Errorf("unexpected headers %v", header)
+	}
+
+	header.Set("Authorization", "Bearer "+sign(jwt.MapClaims{"iss": "https://evil.com"}))
+	if result := v.Handle(ctx); result != resultInvalid {
+		t.Errorf("OAuth/2 Authorization should fail")
+	}
+
+	// HMAC tokens are rejected without the secret.
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": "https://auth.megaease.com",
+	}).SignedString(key.N.Bytes())
+	header.Set("Authorization", "Bearer "+token)
+	if result := v.Handle(ctx); result != resultInvalid {
+		t.Errorf("OAuth/2 Authorization should fail")
+	}
+}
+
 func TestSignature(t *testing.T) {
 	// This test is almost covered by signer
 
@@ -289,3 +424,115 @@ signature:
 		t.Errorf("OAuth/2 Authorization should fail")
 	}
 }
+
+func TestClientCertificate(t *testing.T) {
+	const yamlSpec = `
+kind: Validator
+name: validator
+clientCertificate:
+  dnsNames: ["*.prod.example.org"]
+`
+	v := createValidator(yamlSpec, nil)
+
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "order"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"order.test.example.org"},
+	}
+
+	var state *tls.ConnectionState
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedStd = func() *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, "https://megaease.com", nil)
+		r.TLS = state
+		return r
+	}
+
+	if result := v.Handle(ctx); result != resultInvalid {
+		t.Errorf("request without client certificate should fail")
+	}
+
+	for _, name := range []string{"order.test.example.org", "order.prod.example.org"} {
+		template.DNSNames = []string{name}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatalf("create certificate failed: %v", err)
+		}
+		cert, _ := x509.ParseCertificate(der)
+		state = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+
+		result := v.Handle(ctx)
+		if name == "order.test.example.org" && result != resultInvalid {
+			t.Errorf("client certificate of %s should fail", name)
+		}
+		if name == "order.prod.example.org" && result != "" {
+			t.Errorf("client certificate of %s should pass", name)
+		}
+	}
+}
+
+func TestSchemaRegistry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/subjects/orders-value/versions/latest" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"id": 3, "version": 1, "schema": "{\"type\": \"record\", \"name\": \"Order\", \"fields\": [{\"name\": \"id\", \"type\": \"int\"}]}"}`))
+	}))
+	defer server.Close()
+
+	yamlSpec := fmt.Sprintf(`
+kind: Validator
+name: validator
+schemaRegistry:
+  url: %s
+  topicHeader: X-Topic
+  serialize: true
+`, server.URL)
+	v := createValidator(yamlSpec, nil)
+
+	ctx := &contexttest.MockedHTTPContext{}
+	header := http.Header{}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(header)
+	}
+	var body io.Reader
+	ctx.MockedRequest.MockedBody = func() io.Reader {
+		return body
+	}
+	ctx.MockedRequest.MockedSetBody = func(b io.Reader) {
+		body = b
+	}
+	code := 0
+	ctx.MockedResponse.MockedSetStatusCode = func(c int) {
+		code = c
+	}
+
+	body = strings.NewReader(`{"id": 1}`)
+	if v.Handle(ctx) != resultInvalid || code != http.StatusBadRequest {
+		t.Error("validation should fail without topic")
+	}
+
+	header.Set("X-Topic", "orders")
+	body = strings.NewReader(`{"id": 1}`)
+	if v.Handle(ctx) == resultInvalid {
+		t.Error("validation should succeed")
+	}
+	if data, _ := io.ReadAll(body); string(data) != "\x00\x00\x00\x00\x03\x02" {
+		t.Errorf("unexpected serialized body %v", data)
+	}
+
+	body = strings.NewReader(`{"id": "1"}`)
+	if v.Handle(ctx) != resultInvalid || code != http.StatusBadRequest {
+		t.Error("validation should fail")
+	}
+
+	header.Set("X-Topic", "users")
+	body = strings.NewReader(`{"id": 1}`)
+	if v.Handle(ctx) != resultInvalid || code != http.StatusServiceUnavailable {
+		t.Error("validation should fail for unknown subjects")
+	}
+}
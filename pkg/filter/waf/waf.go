@@ -0,0 +1,263 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package waf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	libwaf "github.com/megaease/easegress/pkg/util/waf"
+)
+
+const (
+	// Kind is the kind of WAF.
+	Kind = "WAF"
+
+	resultBlocked = "blocked"
+
+	modeBlock = "block"
+	modeLog   = "log"
+	modeOff   = "off"
+
+	// DefaultMaxBodySize is the default max size of the inspected body.
+	DefaultMaxBodySize = 128 * 1024
+)
+
+var results = []string{resultBlocked}
+
+func init() {
+	httppipeline.Register(&WAF{})
+}
+
+type (
+	// WAF is a web application firewall, which inspects the request
+	// lines, headers and bodies by ModSecurity compatible rules.
+	WAF struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		waf  *libwaf.WAF
+		mode string
+
+		inspected, blocked, detected uint64
+
+		mutex    sync.Mutex
+		ruleHits map[int]uint64
+	}
+
+	// Spec describes the WAF.
+	Spec struct {
+		libwaf.Spec `yaml:",inline"`
+
+		// Mode is block or log, the SecRuleEngine of the rules decides
+		// it if it's empty, which is block by default.
+		Mode string `yaml:"mode" jsonschema:"omitempty,enum=,enum=block,enum=log"`
+		// Status is the status code of the blocked requests if the
+		// matched rule doesn't set it, 403 by default.
+		Status int `yaml:"status" jsonschema:"omitempty,format=httpcode"`
+		// MaxBodySize is the max size of the body inspected in phase 2,
+		// the rest of the body is not inspected, and no body is
+		// inspected if it's negative.
+		MaxBodySize int64 `yaml:"maxBodySize" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of WAF.
+	Status struct {
+		Rules     int    `yaml:"rules"`
+		Mode      string `yaml:"mode"`
+		Inspected uint64 `yaml:"inspected"`
+		Blocked   uint64 `yaml:"blocked"`
+		// Detected is the number of the requests matched by rules but
+		// not blocked.
+		Detected uint64 `yaml:"detected"`
+		// RuleHits is the number of the hits of every rule.
+		RuleHits map[int]uint64 `yaml:"ruleHits"`
+	}
+)
+
+// Kind returns the kind of WAF.
+func (w *WAF) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of WAF.
+func (w *WAF) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of WAF.
+func (w *WAF) Description() string {
+	return "WAF inspects requests by ModSecurity compatible rules against SQLi, XSS, RCE and more."
+}
+
+// Results returns the results of WAF.
+func (w *WAF) Results() []string {
+	return results
+}
+
+// Init initializes WAF.
+func (w *WAF) Init(filterSpec *httppipeline.FilterSpec) {
+	w.filterSpec, w.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	w.reload()
+}
+
+// Inherit inherits previous generation of WAF.
+func (w *WAF) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	w.Init(filterSpec)
+}
+
+func (w *WAF) reload() {
+	w.ruleHits = map[int]uint64{}
+	w.mode = modeOff
+
+	lw, err := libwaf.New(&w.spec.Spec)
+	if err != nil {
+		// NOTE: Rule files may be changed after validation.
+		logger.Errorf("%s: load rules failed, requests are not inspected: %v", w.filterSpec.Name(), err)
+		return
+	}
+	w.waf = lw
+
+	switch {
+	case w.spec.Mode != "":
+		w.mode = w.spec.Mode
+	case lw.Engine() == libwaf.EngineOn:
+		w.mode = modeBlock
+	case lw.Engine() == libwaf.EngineDetectionOnly:
+		w.mode = modeLog
+	}
+}
+
+// Handle inspects the request of HTTPContext.
+func (w *WAF) Handle(ctx context.HTTPContext) string {
+	result := w.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (w *WAF) handle(ctx context.HTTPContext) string {
+	if w.mode == modeOff {
+		return ""
+	}
+	atomic.AddUint64(&w.inspected, 1)
+
+	r := ctx.Request()
+	uri := r.EscapedPath()
+	if r.Query() != "" {
+		uri += "?" + r.Query()
+	}
+	tx := libwaf.NewTransaction(&libwaf.Request{
+		RemoteAddr: r.RealIP(),
+		Method:     r.Method(),
+		URI:        uri,
+		Path:       r.Path(),
+		Query:      r.Query(),
+		Proto:      r.Proto(),
+		Header:     r.Header().Std(),
+	})
+	detectionOnly := w.mode == modeLog
+
+	matches := w.waf.Inspect(tx, 1, detectionOnly)
+	if !w.denied(matches, detectionOnly) {
+		if w.spec.MaxBodySize >= 0 {
+			maxBodySize := w.spec.MaxBodySize
+			if maxBodySize == 0 {
+				maxBodySize = DefaultMaxBodySize
+			}
+			body := r.Body()
+			inspected, err := ioutil.ReadAll(io.LimitReader(body, maxBodySize))
+			if err != nil {
+				ctx.AddTag(fmt.Sprintf("waf: read body failed: %v", err))
+				ctx.Response().SetStatusCode(http.StatusBadRequest)
+				return resultBlocked
+			}
+			r.SetBody(io.MultiReader(bytes.NewReader(inspected), body))
+			tx.SetBody(inspected)
+		}
+		matches = append(matches, w.waf.Inspect(tx, 2, detectionOnly)...)
+	}
+
+	if len(matches) == 0 {
+		return ""
+	}
+
+	w.mutex.Lock()
+	for _, m := range matches {
+		w.ruleHits[m.RuleID]++
+	}
+	w.mutex.Unlock()
+
+	for _, m := range matches {
+		ctx.AddTag(fmt.Sprintf("waf: rule %d matched %s: %s", m.RuleID, m.Variable, m.Msg))
+	}
+
+	last := matches[len(matches)-1]
+	if !w.denied(matches, detectionOnly) {
+		atomic.AddUint64(&w.detected, 1)
+		return ""
+	}
+
+	atomic.AddUint64(&w.blocked, 1)
+	status := last.Status
+	if status == 0 {
+		status = w.spec.Status
+	}
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+	ctx.Response().SetStatusCode(status)
+	return resultBlocked
+}
+
+// denied returns whether the request is blocked by the last match, the
+// inspection stops at the first deny rule in block mode.
+func (w *WAF) denied(matches []*libwaf.Match, detectionOnly bool) bool {
+	return !detectionOnly && len(matches) > 0 && matches[len(matches)-1].Deny
+}
+
+// Status returns status.
+func (w *WAF) Status() interface{} {
+	s := &Status{
+		Mode:      w.mode,
+		Inspected: atomic.LoadUint64(&w.inspected),
+		Blocked:   atomic.LoadUint64(&w.blocked),
+		Detected:  atomic.LoadUint64(&w.detected),
+		RuleHits:  map[int]uint64{},
+	}
+	if w.waf != nil {
+		s.Rules = w.waf.Len()
+	}
+
+	w.mutex.Lock()
+	for id, hits := range w.ruleHits {
+		s.RuleHits[id] = hits
+	}
+	w.mutex.Unlock()
+
+	return s
+}
+
+// Close closes WAF.
+func (w *WAF) Close() {}
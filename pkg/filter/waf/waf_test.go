@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package waf
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newWAF(t *testing.T, yamlSpec string) *WAF {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w := &WAF{}
+	w.Init(spec)
+	return w
+}
+
+func handle(w *WAF, method, url, body string) (context.HTTPContext, string) {
+	stdr, _ := http.NewRequest(method, url, strings.NewReader(body))
+	if body != "" {
+		stdr.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	ctx := context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		return lastResult
+	})
+	return ctx, w.Handle(ctx)
+}
+
+func TestValidate(t *testing.T) {
+	for i, yamlSpec := range []string{
+		"kind: WAF\nname: waf\n",
+		"kind: WAF\nname: waf\nrules: SecRule ARGS \"@rx (\" \"id:1\"\n",
+		"kind: WAF\nname: waf\nbaseline: true\nmode: drop\n",
+		"kind: WAF\nname: waf\nbaseline: true\nexclusions:\n- ruleIds: [abc]\n",
+	} {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("case %d: spec should be invalid", i)
+		}
+	}
+}
+
+func TestBlock(t *testing.T) {
+	w := newWAF(t, `
+kind: WAF
+name: waf
+baseline: true
+rules: |
+  SecRule REQUEST_HEADERS:X-Api-Version "!@rx ^v[12]$" "id:10001,phase:1,deny,status:400,msg:'Unknown API version'"
+exclusions:
+- ruleIds: ["942000-942999"]
+  variables: ["ARGS:sql"]
+`)
+
+	ctx, result := handle(w, http.MethodGet, "http://example.com/items?id=1%20union%20select%20password%20from%20users", "")
+	if result != resultBlocked || ctx.Response().StatusCode() != http.StatusForbidden {
+		t.Errorf("sql injection should be blocked")
+	}
+
+	_, result = handle(w, http.MethodGet, "http://example.com/console?sql=1%20union%20select%202", "")
+	if result != "" {
+		t.Errorf("excluded variable should not be blocked")
+	}
+
+	ctx, result = handle(w, http.MethodPost, "http://example.com/comments", "text=%3Cscript%3Ealert(1)%3C%2Fscript%3E&name=x")
+	if result != resultBlocked {
+		t.Errorf("xss in body should be blocked")
+	}
+	body, _ := ioutil.ReadAll(ctx.Request().Body())
+	if !strings.HasPrefix(string(body), "text=") {
+		t.Errorf("body should be kept, got %q", body)
+	}
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	stdr.Header.Set("X-Api-Version", "v3")
+	ctx = context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string { return lastResult })
+	if w.Handle(ctx) != resultBlocked || ctx.Response().StatusCode() != http.StatusBadRequest {
+		t.Errorf("custom rule should block with its status")
+	}
+
+	status := w.Status().(*Status)
+	if status.Mode != modeBlock || status.Inspected != 4 || status.Blocked != 3 ||
+		status.RuleHits[942100] != 1 || status.RuleHits[941100] != 1 || status.RuleHits[10001] != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestLogMode(t *testing.T) {
+	w := newWAF(t, `
+kind: WAF
+name: waf
+rules: |
+  SecRuleEngine DetectionOnly
+  SecRule ARGS "@contains evil" "id:1,deny,msg:'evil'"
+  SecRule REQUEST_BODY "@contains bad" "id:2,deny"
+maxBodySize: 8
+`)
+
+	_, result := handle(w, http.MethodPost, "http://example.com/?q=evil", "0123456789bad")
+	if result != "" {
+		t.Errorf("requests should not be blocked in log mode")
+	}
+	status := w.Status().(*Status)
+	if status.Mode != modeLog || status.Detected != 1 || status.RuleHits[1] != 1 || status.RuleHits[2] != 0 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	w = newWAF(t, `
+kind: WAF
+name: waf
+mode: block
+status: 406
+rules: |
+  SecRuleEngine DetectionOnly
+  SecRule REQUEST_BODY "@contains bad" "id:2,deny"
+maxBodySize: -1
+`)
+	if _, result = handle(w, http.MethodPost, "http://example.com/", "bad"); result != "" {
+		t.Errorf("body should not be inspected")
+	}
+	w.spec.MaxBodySize = 0
+	if ctx, result := handle(w, http.MethodPost, "http://example.com/", "bad"); result != resultBlocked || ctx.Response().StatusCode() != 406 {
+		t.Errorf("mode should override the rule engine")
+	}
+}
@@ -31,6 +31,7 @@ import (
 	"time"
 
 	"github.com/bytecodealliance/wasmtime-go"
+	"github.com/megaease/easegress/pkg/cluster"
 	"github.com/megaease/easegress/pkg/logger"
 )
 
@@ -395,6 +396,50 @@ func (vm *WasmVM) hostRand() float64 {
 	return rand.Float64()
 }
 
+func (vm *WasmVM) kvStore() *cluster.KVStore {
+	store, e := vm.pipeSpec.KVStore()
+	if e != nil {
+		panic(e)
+	}
+	return store
+}
+
+// hostKVGet returns 0 if the key doesn't exist.
+func (vm *WasmVM) hostKVGet(addr int32) int32 {
+	key := vm.readStringFromWasm(addr)
+	value, ok := vm.kvStore().Get(key)
+	if !ok {
+		return 0
+	}
+	return vm.writeStringToWasm(value)
+}
+
+func (vm *WasmVM) hostKVPut(keyAddr, valueAddr int32, ttlInMs int64) {
+	key := vm.readStringFromWasm(keyAddr)
+	value := vm.readStringFromWasm(valueAddr)
+	e := vm.kvStore().Put(key, value, time.Duration(ttlInMs)*time.Millisecond)
+	if e != nil {
+		panic(e)
+	}
+}
+
+func (vm *WasmVM) hostKVDelete(addr int32) {
+	key := vm.readStringFromWasm(addr)
+	e := vm.kvStore().Delete(key)
+	if e != nil {
+		panic(e)
+	}
+}
+
+func (vm *WasmVM) hostKVIncr(addr int32, delta int64, ttlInMs int64) int64 {
+	key := vm.readStringFromWasm(addr)
+	n, e := vm.kvStore().Incr(key, delta, time.Duration(ttlInMs)*time.Millisecond)
+	if e != nil {
+		panic(e)
+	}
+	return n
+}
+
 // importHostFuncs imports host functions into wasm so that user-developed wasm
 // code can call these functions to interoperate with host.
 func (vm *WasmVM) importHostFuncs(linker *wasmtime.Linker) {
@@ -457,4 +502,10 @@ func (vm *WasmVM) importHostFuncs(linker *wasmtime.Linker) {
 	defineFunc("host_log", vm.hostLog)
 	defineFunc("host_get_unix_time_in_ms", vm.hostGetUnixTimeInMs)
 	defineFunc("host_rand", vm.hostRand)
+
+	// key-value store functions
+	defineFunc("host_kv_get", vm.hostKVGet)
+	defineFunc("host_kv_put", vm.hostKVPut)
+	defineFunc("host_kv_delete", vm.hostKVDelete)
+	defineFunc("host_kv_incr", vm.hostKVIncr)
 }
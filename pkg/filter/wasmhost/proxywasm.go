@@ -0,0 +1,584 @@
+// +build wasmhost
+
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package wasmhost
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+// ABIs of the wasm code.
+const (
+	abiEasegress = "easegress"
+	abiProxyWasm = "proxyWasm"
+)
+
+// Status codes of the proxy-wasm ABI.
+const (
+	pwStatusOK                  int32 = 0
+	pwStatusNotFound            int32 = 1
+	pwStatusBadArgument         int32 = 2
+	pwStatusInvalidMemoryAccess int32 = 6
+	pwStatusCasMismatch         int32 = 8
+	pwStatusInternalFailure     int32 = 10
+	pwStatusUnimplemented       int32 = 12
+)
+
+// Buffer types of the proxy-wasm ABI.
+const (
+	pwBufferHTTPRequestBody      int32 = 0
+	pwBufferHTTPResponseBody     int32 = 1
+	pwBufferHTTPCallResponseBody int32 = 4
+	pwBufferVMConfiguration      int32 = 6
+	pwBufferPluginConfiguration  int32 = 7
+)
+
+// Map types of the proxy-wasm ABI.
+const (
+	pwMapHTTPRequestHeaders      int32 = 0
+	pwMapHTTPRequestTrailers     int32 = 1
+	pwMapHTTPResponseHeaders     int32 = 2
+	pwMapHTTPResponseTrailers    int32 = 3
+	pwMapHTTPCallResponseHeaders int32 = 6
+	pwMapHTTPCallResponseTrailer int32 = 7
+)
+
+const pwRootContextID int32 = 1
+
+var errProxyWasmVMBroken = fmt.Errorf("wasm VM is broken")
+
+type (
+	// proxyWasmPlugin is the configuration and the state shared by the
+	// VMs of a WasmHost.
+	proxyWasmPlugin struct {
+		name                string
+		vmID                string
+		vmConfiguration     []byte
+		pluginConfiguration []byte
+		timeout             time.Duration
+		sharedData          *proxyWasmSharedData
+		metrics             *proxyWasmMetrics
+	}
+
+	// proxyWasmVM is a VM running the code of the proxy-wasm ABI. Like the
+	// VMs of the worker threads of Envoy, the contexts of many requests
+	// live in a VM at the same time, and their callbacks are serialized.
+	proxyWasmVM struct {
+		plugin *proxyWasmPlugin
+
+		mutex    sync.Mutex
+		store    *wasmtime.Store
+		inst     *wasmtime.Instance
+		ih       *wasmtime.InterruptHandle
+		memory   *wasmtime.Memory
+		fnMalloc *wasmtime.Func
+
+		// broken is set if a callback traps or times out, the VM is
+		// replaced by a new one then.
+		broken int32
+
+		root     *proxyWasmContext
+		current  *proxyWasmContext
+		contexts map[int32]*proxyWasmContext
+		nextID   int32
+
+		nextToken uint32
+		// callResponse is the response of the HTTP call being delivered.
+		callResponse *proxyWasmHTTPResponse
+
+		tickPeriod time.Duration
+		ticking    bool
+		done       chan struct{}
+	}
+
+	// proxyWasmContext is the context of a request, or the root context
+	// of the plugin whose ctx is nil.
+	proxyWasmContext struct {
+		id            int32
+		ctx           context.HTTPContext
+		localResponse bool
+		properties    map[string][]byte
+		// calls are the HTTP calls dispatched by the context.
+		calls []*proxyWasmHTTPCall
+	}
+
+	// proxyWasmPool is a pool of proxy-wasm VMs, requests are assigned to
+	// the VMs in turn.
+	proxyWasmPool struct {
+		engine *wasmtime.Engine
+		module *wasmtime.Module
+		plugin *proxyWasmPlugin
+
+		mutex sync.Mutex
+		vms   []*proxyWasmVM
+		next  int
+	}
+)
+
+func newProxyWasmPool(size int32, code []byte, plugin *proxyWasmPlugin) (*proxyWasmPool, error) {
+	cfg := wasmtime.NewConfig()
+	cfg.SetInterruptable(true)
+	engine := wasmtime.NewEngineWithConfig(cfg)
+	module, e := wasmtime.NewModule(engine, code)
+	if e != nil {
+		return nil, e
+	}
+
+	p := &proxyWasmPool{
+		engine: engine,
+		module: module,
+		plugin: plugin,
+		vms:    make([]*proxyWasmVM, size),
+	}
+
+	// NOTE: The first VM is created to validate the code, the others
+	// are created on demand.
+	vm, e := newProxyWasmVM(engine, module, plugin)
+	if e != nil {
+		return nil, e
+	}
+	p.vms[0] = vm
+	return p, nil
+}
+
+// get gets the next VM, a new one is created if it's missing or broken.
+func (p *proxyWasmPool) get() (*proxyWasmVM, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	i := p.next
+	p.next = (p.next + 1) % len(p.vms)
+
+	vm := p.vms[i]
+	if vm != nil && !vm.isBroken() {
+		return vm, nil
+	}
+	if vm != nil {
+		vm.close()
+	}
+
+	vm, e := newProxyWasmVM(p.engine, p.module, p.plugin)
+	if e != nil {
+		p.vms[i] = nil
+		return nil, e
+	}
+	p.vms[i] = vm
+	return vm, nil
+}
+
+func (p *proxyWasmPool) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, vm := range p.vms {
+		if vm != nil {
+			vm.close()
+		}
+	}
+}
+
+func newProxyWasmVM(engine *wasmtime.Engine, module *wasmtime.Module, plugin *proxyWasmPlugin) (*proxyWasmVM, error) {
+	store := wasmtime.NewStore(engine)
+	store.SetWasi(wasmtime.NewWasiConfig())
+	ih, e := store.InterruptHandle()
+	if e != nil {
+		return nil, e
+	}
+
+	vm := &proxyWasmVM{
+		plugin:   plugin,
+		store:    store,
+		ih:       ih,
+		contexts: map[int32]*proxyWasmContext{},
+		nextID:   pwRootContextID + 1,
+		done:     make(chan struct{}),
+	}
+	vm.root = &proxyWasmContext{id: pwRootContextID}
+	vm.contexts[vm.root.id] = vm.root
+
+	linker := wasmtime.NewLinker(engine)
+	if e = linker.DefineWasi(); e != nil {
+		return nil, e
+	}
+	vm.importProxyWasmFuncs(linker)
+
+	if vm.inst, e = linker.Instantiate(store, module); e != nil {
+		return nil, e
+	}
+	if extern := vm.inst.GetExport(store, wasmMemory); extern == nil || extern.Memory() == nil {
+		return nil, fmt.Errorf("wasm code hasn't export memory")
+	} else {
+		vm.memory = extern.Memory()
+	}
+	for _, name := range []string{"proxy_on_memory_allocate", "malloc"} {
+		if vm.fnMalloc = vm.inst.GetFunc(store, name); vm.fnMalloc != nil {
+			break
+		}
+	}
+	if vm.fnMalloc == nil {
+		return nil, fmt.Errorf("wasm code hasn't export function 'proxy_on_memory_allocate' or 'malloc'")
+	}
+	if !vm.exported("proxy_abi_version_0_2_0") && !vm.exported("proxy_abi_version_0_2_1") {
+		return nil, fmt.Errorf("wasm code isn't of proxy-wasm ABI 0.2.0 or 0.2.1")
+	}
+
+	if e = vm.start(); e != nil {
+		vm.close()
+		return nil, e
+	}
+	return vm, nil
+}
+
+// start initializes the wasm code like a WASI reactor or command, and
+// then starts the root context.
+func (vm *proxyWasmVM) start() error {
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	for _, name := range []string{"_initialize", "_start"} {
+		if !vm.exported(name) {
+			continue
+		}
+		// NOTE: A WASI command may exit by proc_exit after main returns.
+		if _, e := vm.call(vm.root, name); e != nil && !strings.Contains(e.Error(), "exit status 0") {
+			return fmt.Errorf("%s failed: %v", name, e)
+		}
+		break
+	}
+
+	if _, e := vm.call(vm.root, "proxy_on_context_create", vm.root.id, int32(0)); e != nil {
+		return e
+	}
+
+	if vm.exported("proxy_on_vm_start") {
+		ok, e := vm.call(vm.root, "proxy_on_vm_start", vm.root.id, int32(len(vm.plugin.vmConfiguration)))
+		if e != nil {
+			return e
+		}
+		if ok == 0 {
+			return fmt.Errorf("proxy_on_vm_start failed")
+		}
+	}
+
+	if vm.exported("proxy_on_configure") {
+		ok, e := vm.call(vm.root, "proxy_on_configure", vm.root.id, int32(len(vm.plugin.pluginConfiguration)))
+		if e != nil {
+			return e
+		}
+		if ok == 0 {
+			return fmt.Errorf("proxy_on_configure failed")
+		}
+	}
+	return nil
+}
+
+func (vm *proxyWasmVM) exported(name string) bool {
+	return vm.inst.GetExport(vm.store, name) != nil
+}
+
+func (vm *proxyWasmVM) isBroken() bool {
+	return atomic.LoadInt32(&vm.broken) != 0
+}
+
+// call calls the exported function in the context c, it returns 0 if the
+// function isn't exported. The VM is broken if the function traps or
+// times out. The caller must hold the mutex.
+func (vm *proxyWasmVM) call(c *proxyWasmContext, name string, args ...interface{}) (result int32, err error) {
+	if vm.isBroken() {
+		return 0, errProxyWasmVMBroken
+	}
+	fn := vm.inst.GetFunc(vm.store, name)
+	if fn == nil {
+		return 0, nil
+	}
+
+	vm.current = c
+	timer := time.AfterFunc(vm.plugin.timeout, vm.ih.Interrupt)
+	defer func() {
+		vm.current = nil
+		if !timer.Stop() && err == nil {
+			err = fmt.Errorf("%s timed out", name)
+		}
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%s panicked: %v", name, e)
+		}
+		if err != nil {
+			atomic.StoreInt32(&vm.broken, 1)
+		}
+	}()
+
+	r, e := fn.Call(vm.store, args...)
+	if e != nil {
+		return 0, fmt.Errorf("%s failed: %v", name, e)
+	}
+	if n, ok := r.(int32); ok {
+		return n, nil
+	}
+	return 0, nil
+}
+
+// callback calls the callback in the context c, and then sends the HTTP
+// calls dispatched by it.
+func (vm *proxyWasmVM) callback(c *proxyWasmContext, name string, args ...interface{}) error {
+	vm.mutex.Lock()
+	_, e := vm.call(c, name, args...)
+	vm.mutex.Unlock()
+	if e != nil {
+		return e
+	}
+	return vm.dispatchHTTPCalls(c)
+}
+
+// dispatchHTTPCalls sends the HTTP calls dispatched by the context, and
+// delivers the responses to the root context, until no more calls are
+// dispatched. The VM serves other contexts while the calls are being sent.
+func (vm *proxyWasmVM) dispatchHTTPCalls(c *proxyWasmContext) error {
+	for {
+		vm.mutex.Lock()
+		calls := c.calls
+		c.calls = nil
+		vm.mutex.Unlock()
+
+		if len(calls) == 0 {
+			return nil
+		}
+
+		for _, call := range calls {
+			call.response = call.do()
+		}
+
+		vm.mutex.Lock()
+		for _, call := range calls {
+			vm.callResponse = call.response
+			var numHeaders, bodySize int32
+			if resp := call.response; resp != nil {
+				numHeaders = int32(len(resp.headerPairs()))
+				bodySize = int32(len(resp.body))
+			}
+			_, e := vm.call(vm.root, "proxy_on_http_call_response",
+				vm.root.id, int32(call.token), numHeaders, bodySize, int32(0))
+			vm.callResponse = nil
+			if e != nil {
+				vm.mutex.Unlock()
+				return e
+			}
+		}
+		vm.mutex.Unlock()
+	}
+}
+
+func (vm *proxyWasmVM) newContext(ctx context.HTTPContext) (*proxyWasmContext, error) {
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	c := &proxyWasmContext{id: vm.nextID, ctx: ctx}
+	vm.nextID++
+	vm.contexts[c.id] = c
+
+	if _, e := vm.call(c, "proxy_on_context_create", c.id, vm.root.id); e != nil {
+		delete(vm.contexts, c.id)
+		return nil, e
+	}
+	return c, nil
+}
+
+// deleteContext finishes the context, as the stream of Envoy is done.
+func (vm *proxyWasmVM) deleteContext(c *proxyWasmContext) error {
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	defer delete(vm.contexts, c.id)
+	for _, name := range []string{"proxy_on_done", "proxy_on_log", "proxy_on_delete"} {
+		if _, e := vm.call(c, name, c.id); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// onRequest calls the callbacks of the request, the request body is
+// buffered and delivered in one callback.
+func (vm *proxyWasmVM) onRequest(c *proxyWasmContext) error {
+	r := c.ctx.Request()
+	body, e := readBody(r.Body())
+	if e != nil {
+		return e
+	}
+	r.SetBody(bytes.NewReader(body))
+
+	numHeaders := int32(len(requestHeaderPairs(r)))
+	e = vm.callback(c, "proxy_on_request_headers", c.id, numHeaders, boolToInt32(len(body) == 0))
+	if e != nil || c.localResponse || len(body) == 0 {
+		return e
+	}
+	return vm.callback(c, "proxy_on_request_body", c.id, int32(len(body)), int32(1))
+}
+
+// onResponse calls the callbacks of the response, the response body is
+// buffered and delivered in one callback.
+func (vm *proxyWasmVM) onResponse(c *proxyWasmContext) error {
+	w := c.ctx.Response()
+	body, e := readBody(w.Body())
+	if e != nil {
+		return e
+	}
+	w.SetBody(bytes.NewReader(body))
+
+	numHeaders := int32(len(responseHeaderPairs(w)))
+	e = vm.callback(c, "proxy_on_response_headers", c.id, numHeaders, boolToInt32(len(body) == 0))
+	if e != nil || len(body) == 0 {
+		return e
+	}
+	return vm.callback(c, "proxy_on_response_body", c.id, int32(len(body)), int32(1))
+}
+
+// setTickPeriod sets the tick period of the root context, the caller
+// must hold the mutex.
+func (vm *proxyWasmVM) setTickPeriod(d time.Duration) {
+	vm.tickPeriod = d
+	if d > 0 && !vm.ticking {
+		vm.ticking = true
+		go vm.tick()
+	}
+}
+
+func (vm *proxyWasmVM) tick() {
+	for {
+		vm.mutex.Lock()
+		d := vm.tickPeriod
+		vm.mutex.Unlock()
+
+		if d <= 0 {
+			d = time.Second
+		}
+		select {
+		case <-vm.done:
+			return
+		case <-time.After(d):
+		}
+
+		vm.mutex.Lock()
+		if vm.tickPeriod <= 0 {
+			vm.mutex.Unlock()
+			continue
+		}
+		vm.mutex.Unlock()
+
+		if e := vm.callback(vm.root, "proxy_on_tick", vm.root.id); e != nil {
+			logger.Errorf("proxy-wasm plugin %s: tick failed: %v", vm.plugin.name, e)
+			return
+		}
+	}
+}
+
+func (vm *proxyWasmVM) close() {
+	select {
+	case <-vm.done:
+	default:
+		close(vm.done)
+	}
+}
+
+// readBody reads the body, which is nil if it's not set.
+func readBody(body io.Reader) ([]byte, error) {
+	if body == nil {
+		return nil, nil
+	}
+	return io.ReadAll(body)
+}
+
+func boolToInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (wh *WasmHost) newProxyWasmPlugin() *proxyWasmPlugin {
+	vmID := wh.pipeSpec.Pipeline() + "/" + wh.pipeSpec.Name()
+	return &proxyWasmPlugin{
+		name:                wh.pipeSpec.Name(),
+		vmID:                vmID,
+		vmConfiguration:     []byte(wh.spec.VMConfiguration),
+		pluginConfiguration: []byte(wh.spec.PluginConfiguration),
+		timeout:             wh.spec.timeout,
+		sharedData:          getProxyWasmSharedData(vmID),
+		metrics:             wh.metrics,
+	}
+}
+
+// handleProxyWasm handles the request by the code of the proxy-wasm ABI,
+// the callbacks of the response are called after the following filters.
+func (wh *WasmHost) handleProxyWasm(ctx context.HTTPContext) string {
+	p, _ := wh.vmPool.Load().(*proxyWasmPool)
+	if p == nil {
+		ctx.AddTag("wasm VM pool is not initialized")
+		return ctx.CallNextHandler(resultOutOfVM)
+	}
+
+	vm, e := p.get()
+	if e != nil {
+		ctx.AddTag(fmt.Sprintf("failed to get a wasm VM: %v", e))
+		return ctx.CallNextHandler(resultOutOfVM)
+	}
+	atomic.AddInt64(&wh.numOfRequest, 1)
+
+	wasmError := func(e error) {
+		logger.Errorf("proxy-wasm plugin %s: %v", wh.pipeSpec.Name(), e)
+		ctx.AddTag(fmt.Sprintf("wasm error: %v", e))
+		atomic.AddInt64(&wh.numOfWasmError, 1)
+	}
+
+	c, e := vm.newContext(ctx)
+	if e != nil {
+		wasmError(e)
+		return ctx.CallNextHandler(resultWasmError)
+	}
+	ctx.OnFinish(func() {
+		if e := vm.deleteContext(c); e != nil {
+			logger.Errorf("proxy-wasm plugin %s: %v", wh.pipeSpec.Name(), e)
+		}
+	})
+
+	if e = vm.onRequest(c); e != nil {
+		wasmError(e)
+		return ctx.CallNextHandler(resultWasmError)
+	}
+	if c.localResponse {
+		return ctx.CallNextHandler(resultLocalResponse)
+	}
+
+	result := ctx.CallNextHandler("")
+	if e = vm.onResponse(c); e != nil {
+		wasmError(e)
+	}
+	return result
+}
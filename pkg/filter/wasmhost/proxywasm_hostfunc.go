@@ -0,0 +1,1025 @@
+// +build wasmhost
+
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package wasmhost
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const defaultHTTPCallTimeout = 5 * time.Second
+
+type (
+	// proxyWasmSharedData is the shared data of the VMs with the same VM
+	// ID, the CAS of a value detects the concurrent updates.
+	proxyWasmSharedData struct {
+		mutex  sync.Mutex
+		values map[string]*proxyWasmSharedValue
+	}
+
+	proxyWasmSharedValue struct {
+		data []byte
+		cas  uint32
+	}
+
+	// proxyWasmMetrics is the metrics defined by the plugin.
+	proxyWasmMetrics struct {
+		mutex  sync.Mutex
+		ids    map[string]int32
+		names  []string
+		values []int64
+	}
+
+	proxyWasmHTTPCall struct {
+		token    uint32
+		upstream string
+		headers  [][2]string
+		body     []byte
+		timeout  time.Duration
+		response *proxyWasmHTTPResponse
+	}
+
+	proxyWasmHTTPResponse struct {
+		statusCode int
+		header     http.Header
+		body       []byte
+	}
+)
+
+var (
+	sharedDataMutex sync.Mutex
+	// sharedDataOfVMs is the shared data of the VM IDs, it's kept across
+	// the generations of the filters like Envoy keeps it in the process.
+	sharedDataOfVMs = map[string]*proxyWasmSharedData{}
+)
+
+func getProxyWasmSharedData(vmID string) *proxyWasmSharedData {
+	sharedDataMutex.Lock()
+	defer sharedDataMutex.Unlock()
+
+	sd := sharedDataOfVMs[vmID]
+	if sd == nil {
+		sd = &proxyWasmSharedData{values: map[string]*proxyWasmSharedValue{}}
+		sharedDataOfVMs[vmID] = sd
+	}
+	return sd
+}
+
+func newProxyWasmMetrics() *proxyWasmMetrics {
+	return &proxyWasmMetrics{ids: map[string]int32{}}
+}
+
+func (m *proxyWasmMetrics) status() map[string]int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(m.names) == 0 {
+		return nil
+	}
+	s := make(map[string]int64, len(m.names))
+	for i, name := range m.names {
+		s[name] = m.values[i]
+	}
+	return s
+}
+
+// helper functions
+
+// serializePairs serializes the pairs as the proxy-wasm ABI: the number
+// of pairs, the sizes of the keys and values, and then the keys and
+// values ended by 0.
+func serializePairs(pairs [][2]string) []byte {
+	size := 4
+	for _, p := range pairs {
+		size += 8 + len(p[0]) + len(p[1]) + 2
+	}
+
+	data := make([]byte, size)
+	binary.LittleEndian.PutUint32(data, uint32(len(pairs)))
+	pos := 4
+	for _, p := range pairs {
+		binary.LittleEndian.PutUint32(data[pos:], uint32(len(p[0])))
+		binary.LittleEndian.PutUint32(data[pos+4:], uint32(len(p[1])))
+		pos += 8
+	}
+	for _, p := range pairs {
+		pos += copy(data[pos:], p[0]) + 1
+		pos += copy(data[pos:], p[1]) + 1
+	}
+	return data
+}
+
+func deserializePairs(data []byte) ([][2]string, bool) {
+	if len(data) == 0 {
+		return nil, true
+	}
+	if len(data) < 4 {
+		return nil, false
+	}
+
+	n := int(binary.LittleEndian.Uint32(data))
+	if n > len(data)/8 {
+		return nil, false
+	}
+	sizes := data[4:]
+	pos := 4 + n*8
+	pairs := make([][2]string, 0, n)
+	for i := 0; i < n; i++ {
+		keySize := int(binary.LittleEndian.Uint32(sizes[i*8:]))
+		valueSize := int(binary.LittleEndian.Uint32(sizes[i*8+4:]))
+		if keySize < 0 || valueSize < 0 || pos+keySize+valueSize+2 > len(data) {
+			return nil, false
+		}
+		key := string(data[pos : pos+keySize])
+		pos += keySize + 1
+		value := string(data[pos : pos+valueSize])
+		pos += valueSize + 1
+		pairs = append(pairs, [2]string{key, value})
+	}
+	return pairs, true
+}
+
+// headerPairs returns the pairs of the header with lowercase keys, as the
+// headers of HTTP/2.
+func headerPairs(h http.Header) [][2]string {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs [][2]string
+	for _, key := range keys {
+		for _, value := range h[key] {
+			pairs = append(pairs, [2]string{strings.ToLower(key), value})
+		}
+	}
+	return pairs
+}
+
+func requestHeaderPairs(r context.HTTPRequest) [][2]string {
+	path := r.EscapedPath()
+	if query := r.Query(); query != "" {
+		path += "?" + query
+	}
+	pairs := [][2]string{
+		{":authority", r.Host()},
+		{":method", r.Method()},
+		{":path", path},
+		{":scheme", r.Scheme()},
+	}
+	return append(pairs, headerPairs(r.Header().Std())...)
+}
+
+func responseHeaderPairs(w context.HTTPResponse) [][2]string {
+	pairs := [][2]string{{":status", strconv.Itoa(w.StatusCode())}}
+	return append(pairs, headerPairs(w.Header().Std())...)
+}
+
+func (resp *proxyWasmHTTPResponse) headerPairs() [][2]string {
+	pairs := [][2]string{{":status", strconv.Itoa(resp.statusCode)}}
+	return append(pairs, headerPairs(resp.header)...)
+}
+
+// do sends the HTTP call, the upstream is a host:port or a URL, it
+// returns nil if the call fails.
+func (call *proxyWasmHTTPCall) do() *proxyWasmHTTPResponse {
+	method, path, authority, scheme := http.MethodGet, "", "", "http"
+	header := http.Header{}
+	for _, p := range call.headers {
+		switch p[0] {
+		case ":method":
+			method = p[1]
+		case ":path":
+			path = p[1]
+		case ":authority":
+			authority = p[1]
+		case ":scheme":
+			scheme = p[1]
+		default:
+			header.Add(p[0], p[1])
+		}
+	}
+
+	base := call.upstream
+	if !strings.Contains(base, "://") {
+		base = scheme + "://" + base
+	}
+	req, e := http.NewRequest(method, strings.TrimSuffix(base, "/")+path, bytes.NewReader(call.body))
+	if e != nil {
+		logger.Warnf("proxy-wasm HTTP call to %s failed: %v", call.upstream, e)
+		return nil
+	}
+	req.Header = header
+	if authority != "" {
+		req.Host = authority
+	}
+
+	client := &http.Client{Timeout: call.timeout}
+	resp, e := client.Do(req)
+	if e != nil {
+		logger.Warnf("proxy-wasm HTTP call to %s failed: %v", call.upstream, e)
+		return nil
+	}
+	defer resp.Body.Close()
+
+	body, e := io.ReadAll(resp.Body)
+	if e != nil {
+		logger.Warnf("proxy-wasm HTTP call to %s failed: %v", call.upstream, e)
+		return nil
+	}
+	return &proxyWasmHTTPResponse{statusCode: resp.StatusCode, header: resp.Header, body: body}
+}
+
+func (vm *proxyWasmVM) read(ptr, size int32) ([]byte, bool) {
+	mem := vm.memory.UnsafeData(vm.store)
+	start, end := uint64(uint32(ptr)), uint64(uint32(ptr))+uint64(uint32(size))
+	if end > uint64(len(mem)) {
+		return nil, false
+	}
+	data := make([]byte, end-start)
+	copy(data, mem[start:end])
+	return data, true
+}
+
+func (vm *proxyWasmVM) readString(ptr, size int32) (string, bool) {
+	data, ok := vm.read(ptr, size)
+	return string(data), ok
+}
+
+func (vm *proxyWasmVM) write(ptr int32, data []byte) bool {
+	mem := vm.memory.UnsafeData(vm.store)
+	start := uint64(uint32(ptr))
+	if start+uint64(len(data)) > uint64(len(mem)) {
+		return false
+	}
+	copy(mem[start:], data)
+	return true
+}
+
+func (vm *proxyWasmVM) writeUint32(ptr int32, v uint32) bool {
+	var data [4]byte
+	binary.LittleEndian.PutUint32(data[:], v)
+	return vm.write(ptr, data[:])
+}
+
+func (vm *proxyWasmVM) writeUint64(ptr int32, v uint64) bool {
+	var data [8]byte
+	binary.LittleEndian.PutUint64(data[:], v)
+	return vm.write(ptr, data[:])
+}
+
+// writeReturn copies the data to the memory allocated by the wasm code,
+// and then writes its address and size to ptrPtr and sizePtr.
+func (vm *proxyWasmVM) writeReturn(data []byte, ptrPtr, sizePtr int32) int32 {
+	var addr int32
+	if len(data) > 0 {
+		r, e := vm.fnMalloc.Call(vm.store, int32(len(data)))
+		if e != nil {
+			return pwStatusInternalFailure
+		}
+		addr, _ = r.(int32)
+		// NOTE: The memory may grow, so it's got again by write.
+		if addr == 0 || !vm.write(addr, data) {
+			return pwStatusInvalidMemoryAccess
+		}
+	}
+
+	if !vm.writeUint32(ptrPtr, uint32(addr)) || !vm.writeUint32(sizePtr, uint32(len(data))) {
+		return pwStatusInvalidMemoryAccess
+	}
+	return pwStatusOK
+}
+
+// httpContext returns the current context if it's the context of a request.
+func (vm *proxyWasmVM) httpContext() *proxyWasmContext {
+	if vm.current != nil && vm.current.ctx != nil {
+		return vm.current
+	}
+	return nil
+}
+
+func (vm *proxyWasmVM) headerMapPairs(mapType int32) ([][2]string, int32) {
+	switch mapType {
+	case pwMapHTTPRequestHeaders, pwMapHTTPResponseHeaders:
+		c := vm.httpContext()
+		if c == nil {
+			return nil, pwStatusBadArgument
+		}
+		if mapType == pwMapHTTPRequestHeaders {
+			return requestHeaderPairs(c.ctx.Request()), pwStatusOK
+		}
+		return responseHeaderPairs(c.ctx.Response()), pwStatusOK
+	case pwMapHTTPRequestTrailers, pwMapHTTPResponseTrailers, pwMapHTTPCallResponseTrailer:
+		// NOTE: Trailers are not supported, they're always empty.
+		return nil, pwStatusOK
+	case pwMapHTTPCallResponseHeaders:
+		if vm.callResponse == nil {
+			return nil, pwStatusNotFound
+		}
+		return vm.callResponse.headerPairs(), pwStatusOK
+	}
+	return nil, pwStatusBadArgument
+}
+
+// headerMap returns the modifiable header of the map, and the function
+// setting the pseudo headers.
+func (vm *proxyWasmVM) headerMap(mapType int32) (*httpheader.HTTPHeader, func(key, value string), int32) {
+	c := vm.httpContext()
+	if c == nil {
+		return nil, nil, pwStatusBadArgument
+	}
+
+	switch mapType {
+	case pwMapHTTPRequestHeaders:
+		r := c.ctx.Request()
+		return r.Header(), func(key, value string) {
+			switch key {
+			case ":method":
+				r.SetMethod(value)
+			case ":authority":
+				r.SetHost(value)
+			case ":path":
+				if u, e := url.ParseRequestURI(value); e == nil {
+					r.SetPath(u.Path)
+					r.SetQuery(u.RawQuery)
+				}
+			}
+		}, pwStatusOK
+	case pwMapHTTPResponseHeaders:
+		w := c.ctx.Response()
+		return w.Header(), func(key, value string) {
+			if key != ":status" {
+				return
+			}
+			if code, e := strconv.Atoi(value); e == nil {
+				w.SetStatusCode(code)
+			}
+		}, pwStatusOK
+	}
+	return nil, nil, pwStatusBadArgument
+}
+
+// property returns the property of the path, the values are the same as
+// the attributes of Envoy.
+func (vm *proxyWasmVM) property(path []string) ([]byte, bool) {
+	name := strings.Join(path, ".")
+	switch name {
+	case "plugin_name":
+		return []byte(vm.plugin.name), true
+	case "plugin_root_id":
+		return []byte{}, true
+	case "plugin_vm_id":
+		return []byte(vm.plugin.vmID), true
+	}
+
+	for _, c := range []*proxyWasmContext{vm.current, vm.root} {
+		if c == nil {
+			continue
+		}
+		if value, ok := c.properties[name]; ok {
+			return value, true
+		}
+	}
+
+	c := vm.httpContext()
+	if c == nil {
+		return nil, false
+	}
+	r, w := c.ctx.Request(), c.ctx.Response()
+	int64Value := func(n int64) []byte {
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, uint64(n))
+		return data
+	}
+
+	switch name {
+	case "request.path":
+		return []byte(requestHeaderPairs(r)[2][1]), true
+	case "request.url_path":
+		return []byte(r.Path()), true
+	case "request.host":
+		return []byte(r.Host()), true
+	case "request.scheme":
+		return []byte(r.Scheme()), true
+	case "request.method":
+		return []byte(r.Method()), true
+	case "request.query":
+		return []byte(r.Query()), true
+	case "request.protocol":
+		return []byte(r.Proto()), true
+	case "request.referer":
+		return []byte(r.Header().Get("Referer")), true
+	case "request.useragent":
+		return []byte(r.Header().Get("User-Agent")), true
+	case "request.id":
+		return []byte(r.Header().Get("X-Request-Id")), true
+	case "response.code":
+		return int64Value(int64(w.StatusCode())), true
+	case "source.address":
+		return []byte(r.Std().RemoteAddr), true
+	case "source.port":
+		_, port, _ := net.SplitHostPort(r.Std().RemoteAddr)
+		n, _ := strconv.ParseInt(port, 10, 64)
+		return int64Value(n), true
+	case "destination.address":
+		if addr, ok := r.Std().Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			return []byte(addr.String()), true
+		}
+	case "connection.mtls":
+		tls := r.Std().TLS
+		return []byte{byte(boolToInt32(tls != nil && len(tls.PeerCertificates) > 0))}, true
+	}
+	return nil, false
+}
+
+// host functions of the proxy-wasm ABI
+
+func (vm *proxyWasmVM) proxyLog(level, ptr, size int32) int32 {
+	msg, ok := vm.readString(ptr, size)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+
+	switch level {
+	case 0, 1:
+		logger.Debugf("proxy-wasm plugin %s: %s", vm.plugin.name, msg)
+	case 2:
+		logger.Infof("proxy-wasm plugin %s: %s", vm.plugin.name, msg)
+	case 3:
+		logger.Warnf("proxy-wasm plugin %s: %s", vm.plugin.name, msg)
+	default:
+		logger.Errorf("proxy-wasm plugin %s: %s", vm.plugin.name, msg)
+	}
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) proxyGetLogLevel(levelPtr int32) int32 {
+	// NOTE: All logs are sent to the host, which filters them by its level.
+	if !vm.writeUint32(levelPtr, 0) {
+		return pwStatusInvalidMemoryAccess
+	}
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) proxyGetCurrentTimeNanoseconds(timePtr int32) int32 {
+	if !vm.writeUint64(timePtr, uint64(time.Now().UnixNano())) {
+		return pwStatusInvalidMemoryAccess
+	}
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) proxySetTickPeriodMilliseconds(period int32) int32 {
+	vm.setTickPeriod(time.Duration(period) * time.Millisecond)
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) buffer(bufferType int32) ([]byte, int32) {
+	switch bufferType {
+	case pwBufferVMConfiguration:
+		return vm.plugin.vmConfiguration, pwStatusOK
+	case pwBufferPluginConfiguration:
+		return vm.plugin.pluginConfiguration, pwStatusOK
+	case pwBufferHTTPCallResponseBody:
+		if vm.callResponse == nil {
+			return nil, pwStatusNotFound
+		}
+		return vm.callResponse.body, pwStatusOK
+	case pwBufferHTTPRequestBody, pwBufferHTTPResponseBody:
+		c := vm.httpContext()
+		if c == nil {
+			return nil, pwStatusBadArgument
+		}
+		var body []byte
+		var e error
+		if bufferType == pwBufferHTTPRequestBody {
+			r := c.ctx.Request()
+			body, e = readBody(r.Body())
+			r.SetBody(bytes.NewReader(body))
+		} else {
+			w := c.ctx.Response()
+			body, e = readBody(w.Body())
+			w.SetBody(bytes.NewReader(body))
+		}
+		if e != nil {
+			return nil, pwStatusInternalFailure
+		}
+		return body, pwStatusOK
+	}
+	return nil, pwStatusBadArgument
+}
+
+func (vm *proxyWasmVM) proxyGetBufferBytes(bufferType, start, maxSize, ptrPtr, sizePtr int32) int32 {
+	data, status := vm.buffer(bufferType)
+	if status != pwStatusOK {
+		return status
+	}
+	if len(data) == 0 {
+		return pwStatusNotFound
+	}
+	if start < 0 || maxSize < 0 || int(start) > len(data) {
+		return pwStatusBadArgument
+	}
+
+	data = data[start:]
+	if int(maxSize) < len(data) {
+		data = data[:maxSize]
+	}
+	return vm.writeReturn(data, ptrPtr, sizePtr)
+}
+
+func (vm *proxyWasmVM) proxyGetBufferStatus(bufferType, lengthPtr, flagsPtr int32) int32 {
+	data, status := vm.buffer(bufferType)
+	if status != pwStatusOK {
+		return status
+	}
+	if !vm.writeUint32(lengthPtr, uint32(len(data))) || !vm.writeUint32(flagsPtr, 0) {
+		return pwStatusInvalidMemoryAccess
+	}
+	return pwStatusOK
+}
+
+// proxySetBufferBytes replaces the bytes of the body in [start, start+size)
+// with the data, so it prepends, appends or replaces the body.
+func (vm *proxyWasmVM) proxySetBufferBytes(bufferType, start, size, ptr, dataSize int32) int32 {
+	if bufferType != pwBufferHTTPRequestBody && bufferType != pwBufferHTTPResponseBody {
+		return pwStatusBadArgument
+	}
+	body, status := vm.buffer(bufferType)
+	if status != pwStatusOK {
+		return status
+	}
+	data, ok := vm.read(ptr, dataSize)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+	if start < 0 || size < 0 {
+		return pwStatusBadArgument
+	}
+
+	begin, end := int(start), int(start)+int(size)
+	if begin > len(body) {
+		begin = len(body)
+	}
+	if end > len(body) {
+		end = len(body)
+	}
+	newBody := make([]byte, 0, len(body)-(end-begin)+len(data))
+	newBody = append(newBody, body[:begin]...)
+	newBody = append(newBody, data...)
+	newBody = append(newBody, body[end:]...)
+
+	c := vm.httpContext()
+	if bufferType == pwBufferHTTPRequestBody {
+		c.ctx.Request().SetBody(bytes.NewReader(newBody))
+	} else {
+		c.ctx.Response().SetBody(bytes.NewReader(newBody))
+	}
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) proxyGetHeaderMapPairs(mapType, ptrPtr, sizePtr int32) int32 {
+	pairs, status := vm.headerMapPairs(mapType)
+	if status != pwStatusOK {
+		return status
+	}
+	return vm.writeReturn(serializePairs(pairs), ptrPtr, sizePtr)
+}
+
+func (vm *proxyWasmVM) proxySetHeaderMapPairs(mapType, ptr, size int32) int32 {
+	h, setPseudo, status := vm.headerMap(mapType)
+	if status != pwStatusOK {
+		return status
+	}
+	data, ok := vm.read(ptr, size)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+	pairs, ok := deserializePairs(data)
+	if !ok {
+		return pwStatusBadArgument
+	}
+
+	std := http.Header{}
+	for _, p := range pairs {
+		if strings.HasPrefix(p[0], ":") {
+			setPseudo(p[0], p[1])
+		} else {
+			std.Add(p[0], p[1])
+		}
+	}
+	h.Reset(std)
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) proxyGetHeaderMapValue(mapType, keyPtr, keySize, ptrPtr, sizePtr int32) int32 {
+	key, ok := vm.readString(keyPtr, keySize)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+	pairs, status := vm.headerMapPairs(mapType)
+	if status != pwStatusOK {
+		return status
+	}
+
+	for _, p := range pairs {
+		if strings.EqualFold(p[0], key) {
+			return vm.writeReturn([]byte(p[1]), ptrPtr, sizePtr)
+		}
+	}
+	return pwStatusNotFound
+}
+
+func (vm *proxyWasmVM) proxyGetHeaderMapSize(mapType, sizePtr int32) int32 {
+	pairs, status := vm.headerMapPairs(mapType)
+	if status != pwStatusOK {
+		return status
+	}
+	if !vm.writeUint32(sizePtr, uint32(len(serializePairs(pairs)))) {
+		return pwStatusInvalidMemoryAccess
+	}
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) modifyHeaderMap(mapType, keyPtr, keySize, valuePtr, valueSize int32,
+	fn func(h *httpheader.HTTPHeader, key, value string)) int32 {
+	h, setPseudo, status := vm.headerMap(mapType)
+	if status != pwStatusOK {
+		return status
+	}
+	key, ok := vm.readString(keyPtr, keySize)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+	value, ok := vm.readString(valuePtr, valueSize)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+
+	if strings.HasPrefix(key, ":") {
+		setPseudo(key, value)
+	} else {
+		fn(h, key, value)
+	}
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) proxyAddHeaderMapValue(mapType, keyPtr, keySize, valuePtr, valueSize int32) int32 {
+	return vm.modifyHeaderMap(mapType, keyPtr, keySize, valuePtr, valueSize,
+		func(h *httpheader.HTTPHeader, key, value string) { h.Add(key, value) })
+}
+
+func (vm *proxyWasmVM) proxyReplaceHeaderMapValue(mapType, keyPtr, keySize, valuePtr, valueSize int32) int32 {
+	return vm.modifyHeaderMap(mapType, keyPtr, keySize, valuePtr, valueSize,
+		func(h *httpheader.HTTPHeader, key, value string) { h.Set(key, value) })
+}
+
+func (vm *proxyWasmVM) proxyRemoveHeaderMapValue(mapType, keyPtr, keySize int32) int32 {
+	return vm.modifyHeaderMap(mapType, keyPtr, keySize, 0, 0,
+		func(h *httpheader.HTTPHeader, key, value string) { h.Del(key) })
+}
+
+func (vm *proxyWasmVM) proxyGetProperty(pathPtr, pathSize, ptrPtr, sizePtr int32) int32 {
+	data, ok := vm.read(pathPtr, pathSize)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+	path := strings.Split(strings.TrimSuffix(string(data), "\x00"), "\x00")
+
+	value, ok := vm.property(path)
+	if !ok {
+		return pwStatusNotFound
+	}
+	return vm.writeReturn(value, ptrPtr, sizePtr)
+}
+
+func (vm *proxyWasmVM) proxySetProperty(pathPtr, pathSize, valuePtr, valueSize int32) int32 {
+	data, ok := vm.read(pathPtr, pathSize)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+	value, ok := vm.read(valuePtr, valueSize)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+
+	c := vm.current
+	if c == nil {
+		c = vm.root
+	}
+	if c.properties == nil {
+		c.properties = map[string][]byte{}
+	}
+	name := strings.Join(strings.Split(strings.TrimSuffix(string(data), "\x00"), "\x00"), ".")
+	c.properties[name] = value
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) proxySendLocalResponse(statusCode, detailsPtr, detailsSize,
+	bodyPtr, bodySize, headersPtr, headersSize, grpcStatus int32) int32 {
+	c := vm.httpContext()
+	if c == nil {
+		return pwStatusBadArgument
+	}
+	body, ok := vm.read(bodyPtr, bodySize)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+	data, ok := vm.read(headersPtr, headersSize)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+	pairs, ok := deserializePairs(data)
+	if !ok {
+		return pwStatusBadArgument
+	}
+
+	w := c.ctx.Response()
+	w.SetStatusCode(int(statusCode))
+	for _, p := range pairs {
+		if !strings.HasPrefix(p[0], ":") {
+			w.Header().Add(p[0], p[1])
+		}
+	}
+	w.SetBody(bytes.NewReader(body))
+	c.localResponse = true
+	return pwStatusOK
+}
+
+// proxyContinue continues the request or the response, which is a no-op
+// as the requests and responses are never paused actually.
+func (vm *proxyWasmVM) proxyContinue() int32 {
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) proxyContinueStream(streamType int32) int32 {
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) proxyCloseStream(streamType int32) int32 {
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) proxySetEffectiveContext(id int32) int32 {
+	c := vm.contexts[id]
+	if c == nil {
+		return pwStatusBadArgument
+	}
+	vm.current = c
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) proxyDone() int32 {
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) proxyGetSharedData(keyPtr, keySize, ptrPtr, sizePtr, casPtr int32) int32 {
+	key, ok := vm.readString(keyPtr, keySize)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+
+	sd := vm.plugin.sharedData
+	sd.mutex.Lock()
+	v := sd.values[key]
+	var data []byte
+	var cas uint32
+	if v != nil {
+		data, cas = v.data, v.cas
+	}
+	sd.mutex.Unlock()
+
+	if v == nil {
+		return pwStatusNotFound
+	}
+	if status := vm.writeReturn(data, ptrPtr, sizePtr); status != pwStatusOK {
+		return status
+	}
+	if !vm.writeUint32(casPtr, cas) {
+		return pwStatusInvalidMemoryAccess
+	}
+	return pwStatusOK
+}
+
+// proxySetSharedData sets the value of the key if cas is 0 or matches the
+// CAS of the current value.
+func (vm *proxyWasmVM) proxySetSharedData(keyPtr, keySize, valuePtr, valueSize, cas int32) int32 {
+	key, ok := vm.readString(keyPtr, keySize)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+	value, ok := vm.read(valuePtr, valueSize)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+
+	sd := vm.plugin.sharedData
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+
+	v := sd.values[key]
+	if v == nil {
+		v = &proxyWasmSharedValue{}
+		sd.values[key] = v
+	} else if cas != 0 && uint32(cas) != v.cas {
+		return pwStatusCasMismatch
+	}
+	v.data = value
+	v.cas++
+	if v.cas == 0 {
+		v.cas = 1
+	}
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) proxyHTTPCall(upstreamPtr, upstreamSize, headersPtr, headersSize,
+	bodyPtr, bodySize, trailersPtr, trailersSize, timeoutMs, tokenPtr int32) int32 {
+	upstream, ok := vm.readString(upstreamPtr, upstreamSize)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+	data, ok := vm.read(headersPtr, headersSize)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+	headers, ok := deserializePairs(data)
+	if !ok {
+		return pwStatusBadArgument
+	}
+	body, ok := vm.read(bodyPtr, bodySize)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+
+	vm.nextToken++
+	call := &proxyWasmHTTPCall{
+		token:    vm.nextToken,
+		upstream: upstream,
+		headers:  headers,
+		body:     body,
+		timeout:  time.Duration(timeoutMs) * time.Millisecond,
+	}
+	if call.timeout <= 0 {
+		call.timeout = defaultHTTPCallTimeout
+	}
+	if !vm.writeUint32(tokenPtr, call.token) {
+		return pwStatusInvalidMemoryAccess
+	}
+
+	// NOTE: The call is sent after the callback returns.
+	c := vm.current
+	if c == nil {
+		c = vm.root
+	}
+	c.calls = append(c.calls, call)
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) proxyDefineMetric(metricType, namePtr, nameSize, idPtr int32) int32 {
+	name, ok := vm.readString(namePtr, nameSize)
+	if !ok {
+		return pwStatusInvalidMemoryAccess
+	}
+
+	m := vm.plugin.metrics
+	m.mutex.Lock()
+	id, ok := m.ids[name]
+	if !ok {
+		id = int32(len(m.names))
+		m.ids[name] = id
+		m.names = append(m.names, name)
+		m.values = append(m.values, 0)
+	}
+	m.mutex.Unlock()
+
+	if !vm.writeUint32(idPtr, uint32(id)) {
+		return pwStatusInvalidMemoryAccess
+	}
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) updateMetric(id int32, fn func(value *int64)) int32 {
+	m := vm.plugin.metrics
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if id < 0 || int(id) >= len(m.values) {
+		return pwStatusNotFound
+	}
+	fn(&m.values[id])
+	return pwStatusOK
+}
+
+func (vm *proxyWasmVM) proxyIncrementMetric(id int32, offset int64) int32 {
+	return vm.updateMetric(id, func(value *int64) { *value += offset })
+}
+
+func (vm *proxyWasmVM) proxyRecordMetric(id int32, value int64) int32 {
+	return vm.updateMetric(id, func(v *int64) { *v = value })
+}
+
+func (vm *proxyWasmVM) proxyGetMetric(id, valuePtr int32) int32 {
+	var value int64
+	if status := vm.updateMetric(id, func(v *int64) { value = *v }); status != pwStatusOK {
+		return status
+	}
+	if !vm.writeUint64(valuePtr, uint64(value)) {
+		return pwStatusInvalidMemoryAccess
+	}
+	return pwStatusOK
+}
+
+// importProxyWasmFuncs imports the host functions of the proxy-wasm ABI
+// into wasm, the unsupported ones return Unimplemented.
+func (vm *proxyWasmVM) importProxyWasmFuncs(linker *wasmtime.Linker) {
+	defineFunc := func(name string, fn interface{}) {
+		if e := linker.DefineFunc(vm.store, "env", name, fn); e != nil {
+			panic(e) // should never happen
+		}
+	}
+
+	defineFunc("proxy_log", vm.proxyLog)
+	defineFunc("proxy_get_log_level", vm.proxyGetLogLevel)
+	defineFunc("proxy_get_current_time_nanoseconds", vm.proxyGetCurrentTimeNanoseconds)
+	defineFunc("proxy_set_tick_period_milliseconds", vm.proxySetTickPeriodMilliseconds)
+
+	defineFunc("proxy_get_buffer_bytes", vm.proxyGetBufferBytes)
+	defineFunc("proxy_get_buffer_status", vm.proxyGetBufferStatus)
+	defineFunc("proxy_set_buffer_bytes", vm.proxySetBufferBytes)
+
+	defineFunc("proxy_get_header_map_pairs", vm.proxyGetHeaderMapPairs)
+	defineFunc("proxy_set_header_map_pairs", vm.proxySetHeaderMapPairs)
+	defineFunc("proxy_get_header_map_value", vm.proxyGetHeaderMapValue)
+	defineFunc("proxy_get_header_map_size", vm.proxyGetHeaderMapSize)
+	defineFunc("proxy_add_header_map_value", vm.proxyAddHeaderMapValue)
+	defineFunc("proxy_replace_header_map_value", vm.proxyReplaceHeaderMapValue)
+	defineFunc("proxy_remove_header_map_value", vm.proxyRemoveHeaderMapValue)
+
+	defineFunc("proxy_get_property", vm.proxyGetProperty)
+	defineFunc("proxy_set_property", vm.proxySetProperty)
+
+	defineFunc("proxy_send_local_response", vm.proxySendLocalResponse)
+	defineFunc("proxy_continue_stream", vm.proxyContinueStream)
+	defineFunc("proxy_close_stream", vm.proxyCloseStream)
+	defineFunc("proxy_continue_request", vm.proxyContinue)
+	defineFunc("proxy_continue_response", vm.proxyContinue)
+	defineFunc("proxy_clear_route_cache", vm.proxyContinue)
+	defineFunc("proxy_set_effective_context", vm.proxySetEffectiveContext)
+	defineFunc("proxy_done", vm.proxyDone)
+
+	defineFunc("proxy_get_shared_data", vm.proxyGetSharedData)
+	defineFunc("proxy_set_shared_data", vm.proxySetSharedData)
+
+	defineFunc("proxy_http_call", vm.proxyHTTPCall)
+
+	defineFunc("proxy_define_metric", vm.proxyDefineMetric)
+	defineFunc("proxy_increment_metric", vm.proxyIncrementMetric)
+	defineFunc("proxy_record_metric", vm.proxyRecordMetric)
+	defineFunc("proxy_get_metric", vm.proxyGetMetric)
+
+	// unsupported functions
+	defineFunc("proxy_get_status", func(a, b, c int32) int32 { return pwStatusUnimplemented })
+	defineFunc("proxy_register_shared_queue", func(a, b, c int32) int32 { return pwStatusUnimplemented })
+	defineFunc("proxy_resolve_shared_queue", func(a, b, c, d, e int32) int32 { return pwStatusUnimplemented })
+	defineFunc("proxy_dequeue_shared_queue", func(a, b, c int32) int32 { return pwStatusUnimplemented })
+	defineFunc("proxy_enqueue_shared_queue", func(a, b, c int32) int32 { return pwStatusUnimplemented })
+	defineFunc("proxy_grpc_call", func(a, b, c, d, e, f, g, h, i, j, k, l int32) int32 { return pwStatusUnimplemented })
+	defineFunc("proxy_grpc_stream", func(a, b, c, d, e, f, g, h, i int32) int32 { return pwStatusUnimplemented })
+	defineFunc("proxy_grpc_send", func(a, b, c, d int32) int32 { return pwStatusUnimplemented })
+	defineFunc("proxy_grpc_cancel", func(a int32) int32 { return pwStatusUnimplemented })
+	defineFunc("proxy_grpc_close", func(a int32) int32 { return pwStatusUnimplemented })
+	defineFunc("proxy_call_foreign_function", func(a, b, c, d, e, f int32) int32 { return pwStatusNotFound })
+}
@@ -0,0 +1,537 @@
+// +build wasmhost
+
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package wasmhost
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+// testWat is a plugin of the proxy-wasm ABI: it rejects requests without
+// header x-in with 403, copies x-in to x-out and the method to x-method,
+// and prepends "<" to the request body.
+const testWat = `
+(module
+  (import "env" "proxy_get_header_map_value" (func $get_header (param i32 i32 i32 i32 i32) (result i32)))
+  (import "env" "proxy_replace_header_map_value" (func $replace_header (param i32 i32 i32 i32 i32) (result i32)))
+  (import "env" "proxy_set_buffer_bytes" (func $set_buffer (param i32 i32 i32 i32 i32) (result i32)))
+  (import "env" "proxy_get_property" (func $get_property (param i32 i32 i32 i32) (result i32)))
+  (import "env" "proxy_send_local_response" (func $send_local_response (param i32 i32 i32 i32 i32 i32 i32 i32) (result i32)))
+
+  (memory (export "memory") 1)
+  (global $heap (mut i32) (i32.const 1024))
+
+  (data (i32.const 0) "x-in")
+  (data (i32.const 16) "x-out")
+  (data (i32.const 32) "request\00method")
+  (data (i32.const 48) "x-method")
+  (data (i32.const 64) "<")
+  (data (i32.const 80) "denied")
+
+  (func (export "proxy_abi_version_0_2_0"))
+
+  (func (export "malloc") (param $size i32) (result i32)
+    (local $ptr i32)
+    (local.set $ptr (global.get $heap))
+    (global.set $heap (i32.add (global.get $heap) (local.get $size)))
+    (local.get $ptr))
+
+  (func (export "proxy_on_context_create") (param i32 i32))
+
+  (func (export "proxy_on_request_headers") (param i32 i32 i32) (result i32)
+    (if (call $get_header (i32.const 0) (i32.const 0) (i32.const 4) (i32.const 512) (i32.const 516))
+      (then
+        (drop (call $send_local_response (i32.const 403) (i32.const 0) (i32.const 0)
+          (i32.const 80) (i32.const 6) (i32.const 0) (i32.const 0) (i32.const -1)))
+        (return (i32.const 1))))
+    (drop (call $replace_header (i32.const 0) (i32.const 16) (i32.const 5)
+      (i32.load (i32.const 512)) (i32.load (i32.const 516))))
+    (drop (call $get_property (i32.const 32) (i32.const 14) (i32.const 512) (i32.const 516)))
+    (drop (call $replace_header (i32.const 0) (i32.const 48) (i32.const 8)
+      (i32.load (i32.const 512)) (i32.load (i32.const 516))))
+    (i32.const 0))
+
+  (func (export "proxy_on_request_body") (param i32 i32 i32) (result i32)
+    (drop (call $set_buffer (i32.const 0) (i32.const 0) (i32.const 0) (i32.const 64) (i32.const 1)))
+    (i32.const 0))
+)
+`
+
+// Addresses of the guest memory used by the tests, ptrSlot and sizeSlot
+// receive the data returned by the host functions.
+const (
+	ptrSlot  int32 = 512
+	sizeSlot int32 = 516
+	scratch  int32 = 600
+	memEnd   int32 = 65536
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func testCode(t *testing.T) []byte {
+	code, e := wasmtime.Wat2Wasm(testWat)
+	if e != nil {
+		t.Fatalf("compile wat failed: %v", e)
+	}
+	return code
+}
+
+func newContext(body string, header map[string]string) context.HTTPContext {
+	stdr := httptest.NewRequest(http.MethodPost, "http://example.com/orders?id=1", strings.NewReader(body))
+	for k, v := range header {
+		stdr.Header.Set(k, v)
+	}
+	return context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+}
+
+// newTestVM creates a VM of the test code, and a context of a request
+// which is the current context of the VM.
+func newTestVM(t *testing.T) (*proxyWasmVM, context.HTTPContext) {
+	plugin := &proxyWasmPlugin{
+		name:                "plugin",
+		vmID:                "pipeline/plugin",
+		pluginConfiguration: []byte("config"),
+		timeout:             time.Second,
+		sharedData:          &proxyWasmSharedData{values: map[string]*proxyWasmSharedValue{}},
+		metrics:             newProxyWasmMetrics(),
+	}
+	p, e := newProxyWasmPool(1, testCode(t), plugin)
+	if e != nil {
+		t.Fatalf("new proxy-wasm pool failed: %v", e)
+	}
+	t.Cleanup(p.close)
+
+	vm := p.vms[0]
+	ctx := newContext("hello world", map[string]string{"X-In": "abc", "User-Agent": "test"})
+	c, e := vm.newContext(ctx)
+	if e != nil {
+		t.Fatalf("new context failed: %v", e)
+	}
+	vm.current = c
+	return vm, ctx
+}
+
+func writeMemory(t *testing.T, vm *proxyWasmVM, ptr int32, data []byte) {
+	if !vm.write(ptr, data) {
+		t.Fatalf("write memory at %d failed", ptr)
+	}
+}
+
+// returned returns the data written by the host function to the memory
+// allocated by the wasm code.
+func returned(t *testing.T, vm *proxyWasmVM) []byte {
+	mem := vm.memory.UnsafeData(vm.store)
+	ptr := binary.LittleEndian.Uint32(mem[ptrSlot:])
+	size := binary.LittleEndian.Uint32(mem[sizeSlot:])
+	data, ok := vm.read(int32(ptr), int32(size))
+	if !ok {
+		t.Fatalf("read returned data failed")
+	}
+	return data
+}
+
+func checkStatus(t *testing.T, name string, got, expected int32) {
+	t.Helper()
+	if got != expected {
+		t.Errorf("%s: expect status %d, got %d", name, expected, got)
+	}
+}
+
+func TestPairs(t *testing.T) {
+	pairs := [][2]string{{":method", "GET"}, {"x-a", ""}, {"", "b"}}
+	got, ok := deserializePairs(serializePairs(pairs))
+	if !ok || !reflect.DeepEqual(got, pairs) {
+		t.Errorf("expect %v, got %v", pairs, got)
+	}
+
+	if pairs, ok := deserializePairs(nil); !ok || pairs != nil {
+		t.Errorf("empty data should be no pairs")
+	}
+	data := serializePairs([][2]string{{"key", "value"}})
+	for _, bad := range [][]byte{
+		data[:2],
+		data[:len(data)-1],
+		// the number of pairs is larger than the data
+		append([]byte{0xff, 0xff, 0, 0}, data[4:]...),
+	} {
+		if _, ok := deserializePairs(bad); ok {
+			t.Errorf("malformed data %v should fail", bad)
+		}
+	}
+}
+
+func TestHeaderMap(t *testing.T) {
+	vm, ctx := newTestVM(t)
+	r := ctx.Request()
+
+	status := vm.proxyGetHeaderMapPairs(pwMapHTTPRequestHeaders, ptrSlot, sizeSlot)
+	checkStatus(t, "get pairs", status, pwStatusOK)
+	pairs, ok := deserializePairs(returned(t, vm))
+	expected := [][2]string{
+		{":authority", "example.com"}, {":method", "POST"}, {":path", "/orders?id=1"}, {":scheme", "http"},
+		{"host", "example.com"}, {"user-agent", "test"}, {"x-in", "abc"},
+	}
+	if !ok || !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("expect pairs %v, got %v", expected, pairs)
+	}
+
+	status = vm.proxyGetHeaderMapSize(pwMapHTTPRequestHeaders, scratch)
+	checkStatus(t, "get size", status, pwStatusOK)
+	if size := binary.LittleEndian.Uint32(vm.memory.UnsafeData(vm.store)[scratch:]); int(size) != len(serializePairs(expected)) {
+		t.Errorf("unexpected size %d", size)
+	}
+
+	writeMemory(t, vm, scratch, []byte("X-IN"))
+	status = vm.proxyGetHeaderMapValue(pwMapHTTPRequestHeaders, scratch, 4, ptrSlot, sizeSlot)
+	checkStatus(t, "get value", status, pwStatusOK)
+	if value := string(returned(t, vm)); value != "abc" {
+		t.Errorf("expect value abc, got %s", value)
+	}
+	writeMemory(t, vm, scratch, []byte("x-no"))
+	status = vm.proxyGetHeaderMapValue(pwMapHTTPRequestHeaders, scratch, 4, ptrSlot, sizeSlot)
+	checkStatus(t, "get missing value", status, pwStatusNotFound)
+
+	writeMemory(t, vm, scratch, []byte("x-a1x-a2:method"))
+	checkStatus(t, "add", vm.proxyAddHeaderMapValue(pwMapHTTPRequestHeaders, scratch, 3, scratch+3, 1), pwStatusOK)
+	checkStatus(t, "add", vm.proxyAddHeaderMapValue(pwMapHTTPRequestHeaders, scratch, 3, scratch+4, 4), pwStatusOK)
+	if values := r.Header().Std()["X-A"]; !reflect.DeepEqual(values, []string{"1", "x-a2"}) {
+		t.Errorf("unexpected values %v", values)
+	}
+	checkStatus(t, "replace", vm.proxyReplaceHeaderMapValue(pwMapHTTPRequestHeaders, scratch, 3, scratch+3, 1), pwStatusOK)
+	if values := r.Header().Std()["X-A"]; !reflect.DeepEqual(values, []string{"1"}) {
+		t.Errorf("unexpected values %v", values)
+	}
+	checkStatus(t, "remove", vm.proxyRemoveHeaderMapValue(pwMapHTTPRequestHeaders, scratch, 3), pwStatusOK)
+	if r.Header().Get("X-A") != "" {
+		t.Errorf("header should be removed")
+	}
+	// pseudo headers change the request
+	writeMemory(t, vm, scratch+100, []byte("PUT"))
+	status = vm.proxyReplaceHeaderMapValue(pwMapHTTPRequestHeaders, scratch+8, 7, scratch+100, 3)
+	checkStatus(t, "replace method", status, pwStatusOK)
+	if r.Method() != http.MethodPut {
+		t.Errorf("expect method PUT, got %s", r.Method())
+	}
+
+	data := serializePairs([][2]string{{":path", "/new?x=1"}, {"x-b", "1"}, {"x-b", "2"}})
+	writeMemory(t, vm, scratch, data)
+	status = vm.proxySetHeaderMapPairs(pwMapHTTPRequestHeaders, scratch, int32(len(data)))
+	checkStatus(t, "set pairs", status, pwStatusOK)
+	if r.Path() != "/new" || r.Query() != "x=1" {
+		t.Errorf("unexpected path %s and query %s", r.Path(), r.Query())
+	}
+	if h := r.Header().Std(); len(h) != 1 || !reflect.DeepEqual(h["X-B"], []string{"1", "2"}) {
+		t.Errorf("headers should be replaced, got %v", h)
+	}
+
+	data = serializePairs([][2]string{{":status", "418"}, {"x-c", "1"}})
+	writeMemory(t, vm, scratch, data)
+	status = vm.proxySetHeaderMapPairs(pwMapHTTPResponseHeaders, scratch, int32(len(data)))
+	checkStatus(t, "set response pairs", status, pwStatusOK)
+	if w := ctx.Response(); w.StatusCode() != 418 || w.Header().Get("X-C") != "1" {
+		t.Errorf("unexpected response %d %v", w.StatusCode(), w.Header().Std())
+	}
+
+	// malformed pairs, unknown maps and trailers
+	writeMemory(t, vm, scratch, []byte{0xff, 0xff, 0xff, 0x0f})
+	checkStatus(t, "malformed pairs", vm.proxySetHeaderMapPairs(pwMapHTTPRequestHeaders, scratch, 4), pwStatusBadArgument)
+	checkStatus(t, "unknown map", vm.proxyGetHeaderMapPairs(100, ptrSlot, sizeSlot), pwStatusBadArgument)
+	checkStatus(t, "call response", vm.proxyGetHeaderMapPairs(pwMapHTTPCallResponseHeaders, ptrSlot, sizeSlot), pwStatusNotFound)
+	checkStatus(t, "trailers", vm.proxyGetHeaderMapPairs(pwMapHTTPRequestTrailers, ptrSlot, sizeSlot), pwStatusOK)
+
+	// the root context has no headers
+	vm.current = vm.root
+	checkStatus(t, "root", vm.proxyGetHeaderMapPairs(pwMapHTTPRequestHeaders, ptrSlot, sizeSlot), pwStatusBadArgument)
+	checkStatus(t, "root", vm.proxyRemoveHeaderMapValue(pwMapHTTPRequestHeaders, scratch, 3), pwStatusBadArgument)
+}
+
+func TestBuffer(t *testing.T) {
+	vm, ctx := newTestVM(t)
+
+	status := vm.proxyGetBufferStatus(pwBufferHTTPRequestBody, scratch, scratch+4)
+	checkStatus(t, "status", status, pwStatusOK)
+	if n := binary.LittleEndian.Uint32(vm.memory.UnsafeData(vm.store)[scratch:]); n != 11 {
+		t.Errorf("expect length 11, got %d", n)
+	}
+
+	status = vm.proxyGetBufferBytes(pwBufferHTTPRequestBody, 6, 3, ptrSlot, sizeSlot)
+	checkStatus(t, "get bytes", status, pwStatusOK)
+	if data := string(returned(t, vm)); data != "wor" {
+		t.Errorf("expect wor, got %s", data)
+	}
+	checkStatus(t, "get bytes", vm.proxyGetBufferBytes(pwBufferHTTPRequestBody, 12, 3, ptrSlot, sizeSlot), pwStatusBadArgument)
+	checkStatus(t, "get bytes", vm.proxyGetBufferBytes(pwBufferHTTPRequestBody, -1, 3, ptrSlot, sizeSlot), pwStatusBadArgument)
+
+	// replace, prepend and append
+	writeMemory(t, vm, scratch, []byte("WASM"))
+	checkStatus(t, "set bytes", vm.proxySetBufferBytes(pwBufferHTTPRequestBody, 6, 5, scratch, 4), pwStatusOK)
+	checkStatus(t, "set bytes", vm.proxySetBufferBytes(pwBufferHTTPRequestBody, 0, 0, scratch, 1), pwStatusOK)
+	checkStatus(t, "set bytes", vm.proxySetBufferBytes(pwBufferHTTPRequestBody, 100, 0, scratch+3, 1), pwStatusOK)
+	body, _ := io.ReadAll(ctx.Request().Body())
+	if string(body) != "Whello WASMM" {
+		t.Errorf("unexpected body %s", body)
+	}
+
+	ctx.Response().SetBody(strings.NewReader("response"))
+	status = vm.proxyGetBufferBytes(pwBufferHTTPResponseBody, 0, 100, ptrSlot, sizeSlot)
+	checkStatus(t, "get response bytes", status, pwStatusOK)
+	if data := string(returned(t, vm)); data != "response" {
+		t.Errorf("expect response, got %s", data)
+	}
+
+	status = vm.proxyGetBufferBytes(pwBufferPluginConfiguration, 0, 100, ptrSlot, sizeSlot)
+	checkStatus(t, "get configuration", status, pwStatusOK)
+	if data := string(returned(t, vm)); data != "config" {
+		t.Errorf("expect config, got %s", data)
+	}
+	checkStatus(t, "empty buffer", vm.proxyGetBufferBytes(pwBufferVMConfiguration, 0, 100, ptrSlot, sizeSlot), pwStatusNotFound)
+	checkStatus(t, "set configuration", vm.proxySetBufferBytes(pwBufferPluginConfiguration, 0, 0, scratch, 1), pwStatusBadArgument)
+	checkStatus(t, "call response", vm.proxyGetBufferBytes(pwBufferHTTPCallResponseBody, 0, 100, ptrSlot, sizeSlot), pwStatusNotFound)
+}
+
+func TestProperty(t *testing.T) {
+	vm, ctx := newTestVM(t)
+	ctx.Response().SetStatusCode(http.StatusAccepted)
+
+	getProperty := func(path string) ([]byte, int32) {
+		writeMemory(t, vm, scratch, []byte(path))
+		status := vm.proxyGetProperty(scratch, int32(len(path)), ptrSlot, sizeSlot)
+		if status != pwStatusOK {
+			return nil, status
+		}
+		return returned(t, vm), status
+	}
+
+	for path, expected := range map[string]string{
+		"plugin_name":       "plugin",
+		"plugin_vm_id":      "pipeline/plugin",
+		"request\x00path":   "/orders?id=1",
+		"request\x00method": "POST",
+		"request\x00host":   "example.com",
+		"request\x00query":  "id=1",
+		// the path may be ended by 0
+		"request\x00useragent\x00": "test",
+		"source\x00address":        "192.0.2.1:1234",
+	} {
+		if value, status := getProperty(path); status != pwStatusOK || string(value) != expected {
+			t.Errorf("property %q: expect %q, got %q (status %d)", path, expected, value, status)
+		}
+	}
+
+	value, status := getProperty("response\x00code")
+	if status != pwStatusOK || len(value) != 8 || binary.LittleEndian.Uint64(value) != http.StatusAccepted {
+		t.Errorf("unexpected response.code %v (status %d)", value, status)
+	}
+	if _, status = getProperty("no\x00such"); status != pwStatusNotFound {
+		t.Errorf("missing property should be not found, got status %d", status)
+	}
+
+	writeMemory(t, vm, scratch+100, []byte("custom\x00keyvalue"))
+	checkStatus(t, "set property", vm.proxySetProperty(scratch+100, 10, scratch+110, 5), pwStatusOK)
+	if value, status = getProperty("custom\x00key"); status != pwStatusOK || string(value) != "value" {
+		t.Errorf("expect property value, got %q (status %d)", value, status)
+	}
+
+	// properties of the request context are invisible to the root context
+	vm.current = vm.root
+	if _, status = getProperty("custom\x00key"); status != pwStatusNotFound {
+		t.Errorf("property of request should be invisible to root, got status %d", status)
+	}
+	if _, status = getProperty("request\x00method"); status != pwStatusNotFound {
+		t.Errorf("root context has no request, got status %d", status)
+	}
+}
+
+func TestMemoryBounds(t *testing.T) {
+	vm, _ := newTestVM(t)
+	writeMemory(t, vm, scratch, []byte("x-in"))
+
+	if _, ok := vm.read(memEnd-2, 4); ok {
+		t.Errorf("read beyond the memory should fail")
+	}
+	if _, ok := vm.read(-1, 1); ok {
+		t.Errorf("read at a negative address should fail")
+	}
+	if vm.write(memEnd-2, []byte("data")) {
+		t.Errorf("write beyond the memory should fail")
+	}
+	if _, ok := vm.read(memEnd-4, 4); !ok || !vm.write(memEnd-4, []byte("data")) {
+		t.Errorf("access at the end of the memory should succeed")
+	}
+
+	for name, status := range map[string]int32{
+		"log":                vm.proxyLog(2, memEnd-2, 4),
+		"get log level":      vm.proxyGetLogLevel(memEnd - 2),
+		"get time":           vm.proxyGetCurrentTimeNanoseconds(memEnd - 4),
+		"get header key":     vm.proxyGetHeaderMapValue(pwMapHTTPRequestHeaders, memEnd-2, 4, ptrSlot, sizeSlot),
+		"get header return":  vm.proxyGetHeaderMapValue(pwMapHTTPRequestHeaders, scratch, 4, memEnd-2, sizeSlot),
+		"get header size":    vm.proxyGetHeaderMapSize(pwMapHTTPRequestHeaders, -1),
+		"set header pairs":   vm.proxySetHeaderMapPairs(pwMapHTTPRequestHeaders, memEnd-2, 8),
+		"add header value":   vm.proxyAddHeaderMapValue(pwMapHTTPRequestHeaders, scratch, 4, memEnd-2, 4),
+		"get buffer return":  vm.proxyGetBufferBytes(pwBufferHTTPRequestBody, 0, 4, ptrSlot, memEnd),
+		"get buffer status":  vm.proxyGetBufferStatus(pwBufferHTTPRequestBody, memEnd, scratch),
+		"set buffer":         vm.proxySetBufferBytes(pwBufferHTTPRequestBody, 0, 0, memEnd-2, 4),
+		"get property path":  vm.proxyGetProperty(memEnd-2, 4, ptrSlot, sizeSlot),
+		"set property value": vm.proxySetProperty(scratch, 4, -4, 8),
+		"local response":     vm.proxySendLocalResponse(403, 0, 0, memEnd-2, 4, 0, 0, -1),
+		"get shared data":    vm.proxyGetSharedData(memEnd-2, 4, ptrSlot, sizeSlot, scratch),
+		"define metric":      vm.proxyDefineMetric(0, scratch, 4, memEnd),
+		"http call":          vm.proxyHTTPCall(scratch, 4, 0, 0, memEnd-2, 4, 0, 0, 0, scratch),
+	} {
+		checkStatus(t, name, status, pwStatusInvalidMemoryAccess)
+	}
+
+	body, _ := io.ReadAll(vm.current.ctx.Request().Body())
+	if string(body) != "hello world" {
+		t.Errorf("failed calls should not change the body, got %s", body)
+	}
+}
+
+func TestSharedDataAndMetrics(t *testing.T) {
+	vm, _ := newTestVM(t)
+	writeMemory(t, vm, scratch, []byte("keyv1v2"))
+
+	checkStatus(t, "get missing", vm.proxyGetSharedData(scratch, 3, ptrSlot, sizeSlot, scratch+100), pwStatusNotFound)
+	checkStatus(t, "set", vm.proxySetSharedData(scratch, 3, scratch+3, 2, 0), pwStatusOK)
+	checkStatus(t, "get", vm.proxyGetSharedData(scratch, 3, ptrSlot, sizeSlot, scratch+100), pwStatusOK)
+	cas := binary.LittleEndian.Uint32(vm.memory.UnsafeData(vm.store)[scratch+100:])
+	if value := string(returned(t, vm)); value != "v1" || cas == 0 {
+		t.Errorf("unexpected value %s and cas %d", value, cas)
+	}
+	checkStatus(t, "set stale", vm.proxySetSharedData(scratch, 3, scratch+5, 2, int32(cas+1)), pwStatusCasMismatch)
+	checkStatus(t, "set with cas", vm.proxySetSharedData(scratch, 3, scratch+5, 2, int32(cas)), pwStatusOK)
+	checkStatus(t, "set stale", vm.proxySetSharedData(scratch, 3, scratch+3, 2, int32(cas)), pwStatusCasMismatch)
+	vm.proxyGetSharedData(scratch, 3, ptrSlot, sizeSlot, scratch+100)
+	if value := string(returned(t, vm)); value != "v2" {
+		t.Errorf("expect v2, got %s", value)
+	}
+
+	writeMemory(t, vm, scratch, []byte("requests"))
+	checkStatus(t, "define", vm.proxyDefineMetric(0, scratch, 8, scratch+100), pwStatusOK)
+	id := int32(binary.LittleEndian.Uint32(vm.memory.UnsafeData(vm.store)[scratch+100:]))
+	checkStatus(t, "increment", vm.proxyIncrementMetric(id, 3), pwStatusOK)
+	checkStatus(t, "increment", vm.proxyIncrementMetric(id, 2), pwStatusOK)
+	checkStatus(t, "get", vm.proxyGetMetric(id, scratch+200), pwStatusOK)
+	if v := binary.LittleEndian.Uint64(vm.memory.UnsafeData(vm.store)[scratch+200:]); v != 5 {
+		t.Errorf("expect metric 5, got %d", v)
+	}
+	checkStatus(t, "record", vm.proxyRecordMetric(id, 42), pwStatusOK)
+	checkStatus(t, "unknown metric", vm.proxyIncrementMetric(id+1, 1), pwStatusNotFound)
+	if s := vm.plugin.metrics.status(); s["requests"] != 42 {
+		t.Errorf("unexpected metrics %v", s)
+	}
+}
+
+func newWasmHost(t *testing.T) *WasmHost {
+	yamlSpec := `
+kind: WasmHost
+name: wasm-host
+maxConcurrency: 2
+timeout: 1s
+abi: proxyWasm
+code: ` + base64.StdEncoding.EncodeToString(testCode(t))
+
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+	spec, e := httppipeline.NewFilterSpec(rawSpec, nil)
+	if e != nil {
+		t.Fatalf("new filter spec failed: %v", e)
+	}
+
+	// NOTE: Init watches the code in the cluster, so the code is loaded
+	// directly.
+	wh := &WasmHost{pipeSpec: spec, spec: spec.FilterSpec().(*Spec), metrics: newProxyWasmMetrics()}
+	wh.spec.timeout, _ = time.ParseDuration(wh.spec.Timeout)
+	if e = wh.loadWasmCode(); e != nil {
+		t.Fatalf("load code failed: %v", e)
+	}
+	t.Cleanup(func() { wh.vmPool.Load().(*proxyWasmPool).close() })
+	return wh
+}
+
+func TestHandleProxyWasm(t *testing.T) {
+	wh := newWasmHost(t)
+
+	ctx := newContext("hello", map[string]string{"X-In": "abc"})
+	var header http.Header
+	var body []byte
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		header = ctx.Request().Header().Std().Clone()
+		body, _ = io.ReadAll(ctx.Request().Body())
+		return lastResult
+	})
+	if result := wh.Handle(ctx); result != "" {
+		t.Errorf("unexpected result %s", result)
+	}
+	if header.Get("X-Out") != "abc" || header.Get("X-Method") != http.MethodPost {
+		t.Errorf("unexpected headers %v", header)
+	}
+	if !bytes.Equal(body, []byte("<hello")) {
+		t.Errorf("unexpected body %s", body)
+	}
+
+	p := wh.vmPool.Load().(*proxyWasmPool)
+	vm := p.vms[0]
+	if len(vm.contexts) != 2 {
+		t.Errorf("the context of the request should be alive until finished")
+	}
+	ctx.Finish()
+	if len(vm.contexts) != 1 {
+		t.Errorf("the context of the request should be deleted after finished")
+	}
+
+	// the second VM is created on demand and sends the local response
+	ctx = newContext("", nil)
+	ctx.SetHandlerCaller(func(lastResult string) string { return lastResult })
+	if result := wh.Handle(ctx); result != resultLocalResponse {
+		t.Errorf("expect result %s, got %s", resultLocalResponse, result)
+	}
+	body, _ = io.ReadAll(ctx.Response().Body())
+	if ctx.Response().StatusCode() != http.StatusForbidden || string(body) != "denied" {
+		t.Errorf("unexpected local response %d %s", ctx.Response().StatusCode(), body)
+	}
+	if p.vms[1] == nil {
+		t.Errorf("the second VM should be created")
+	}
+	if s := wh.Status().(*Status); s.NumOfRequest != 2 || s.NumOfWasmError != 0 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
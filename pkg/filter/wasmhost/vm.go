@@ -25,17 +25,19 @@ import (
 	"github.com/bytecodealliance/wasmtime-go"
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
 )
 
 // WasmVM represents a wasm VM
 type WasmVM struct {
-	ctx     context.HTTPContext
-	store   *wasmtime.Store
-	inst    *wasmtime.Instance
-	ih      *wasmtime.InterruptHandle
-	fnRun   *wasmtime.Func
-	fnAlloc *wasmtime.Func
-	fnFree  *wasmtime.Func
+	ctx      context.HTTPContext
+	pipeSpec *httppipeline.FilterSpec
+	store    *wasmtime.Store
+	inst     *wasmtime.Instance
+	ih       *wasmtime.InterruptHandle
+	fnRun    *wasmtime.Func
+	fnAlloc  *wasmtime.Func
+	fnFree   *wasmtime.Func
 }
 
 // Interrupt interrupts the execution of wasm code
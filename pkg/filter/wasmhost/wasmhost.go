@@ -44,9 +44,10 @@ const (
 )
 
 var (
-	resultOutOfVM   = "outOfVM"
-	resultWasmError = "wasmError"
-	results         = []string{resultOutOfVM, resultWasmError}
+	resultOutOfVM       = "outOfVM"
+	resultWasmError     = "wasmError"
+	resultLocalResponse = "localResponse"
+	results             = []string{resultOutOfVM, resultWasmError, resultLocalResponse}
 )
 
 func wasmResultToFilterResult(r int32) string {
@@ -69,16 +70,22 @@ type (
 		Code           string            `yaml:"code" jsonschema:"required"`
 		Timeout        string            `yaml:"timeout" jsonschema:"required,format=duration"`
 		Parameters     map[string]string `yaml:"parameters" jsonschema:"omitempty"`
-		timeout        time.Duration
+		// ABI is the ABI of the wasm code, easegress or proxyWasm, the
+		// configurations are for the proxyWasm ABI.
+		ABI                 string `yaml:"abi" jsonschema:"omitempty,enum=,enum=easegress,enum=proxyWasm"`
+		VMConfiguration     string `yaml:"vmConfiguration" jsonschema:"omitempty"`
+		PluginConfiguration string `yaml:"pluginConfiguration" jsonschema:"omitempty"`
+		timeout             time.Duration
 	}
 
 	WasmHost struct {
 		pipeSpec *httppipeline.FilterSpec
 		spec     *Spec
 
-		code   []byte
-		vmPool atomic.Value
-		chStop chan struct{}
+		code    []byte
+		vmPool  atomic.Value
+		chStop  chan struct{}
+		metrics *proxyWasmMetrics
 
 		numOfRequest   int64
 		numOfWasmError int64
@@ -88,6 +95,8 @@ type (
 		Health         string `yaml:"health"`
 		NumOfRequest   int64  `yaml:"numOfRequest"`
 		NumOfWasmError int64  `yaml:"numOfWasmError"`
+		// Metrics is the metrics defined by the code of the proxyWasm ABI.
+		Metrics map[string]int64 `yaml:"metrics,omitempty"`
 	}
 )
 
@@ -157,6 +166,22 @@ func (wh *WasmHost) loadWasmCode() error {
 		return nil
 	}
 
+	if wh.spec.ABI == abiProxyWasm {
+		p, e := newProxyWasmPool(wh.spec.MaxConcurrency, code, wh.newProxyWasmPlugin())
+		if e != nil {
+			logger.Errorf("failed to create proxy-wasm VM pool: %v", e)
+			return e
+		}
+		wh.code = code
+
+		old, _ := wh.vmPool.Load().(*proxyWasmPool)
+		wh.vmPool.Store(p)
+		if old != nil {
+			old.close()
+		}
+		return nil
+	}
+
 	p, e := NewWasmVMPool(wh.spec.MaxConcurrency, code, wh.spec.Parameters)
 	if e != nil {
 		logger.Errorf("failed to create wasm VM pool: %v", e)
@@ -214,6 +239,7 @@ func (wh *WasmHost) reload(pipeSpec *httppipeline.FilterSpec) {
 
 	wh.spec.timeout, _ = time.ParseDuration(wh.spec.Timeout)
 	wh.chStop = make(chan struct{})
+	wh.metrics = newProxyWasmMetrics()
 
 	wh.loadWasmCode()
 	go wh.watchWasmCode()
@@ -232,6 +258,9 @@ func (wh *WasmHost) Inherit(pipeSpec *httppipeline.FilterSpec, previousGeneratio
 
 // Handle handles HTTP request
 func (wh *WasmHost) Handle(ctx context.HTTPContext) string {
+	if wh.spec.ABI == abiProxyWasm {
+		return wh.handleProxyWasm(ctx)
+	}
 	result := wh.handle(ctx)
 	return ctx.CallNextHandler(result)
 }
@@ -253,7 +282,7 @@ func (wh *WasmHost) handle(ctx context.HTTPContext) (result string) {
 		ctx.AddTag("failed to get a wasm VM")
 		return resultOutOfVM
 	}
-	vm.ctx = ctx
+	vm.ctx, vm.pipeSpec = ctx, wh.pipeSpec
 	atomic.AddInt64(&wh.numOfRequest, 1)
 
 	var wg sync.WaitGroup
@@ -320,10 +349,14 @@ func (wh *WasmHost) Status() interface{} {
 
 	s.NumOfRequest = atomic.LoadInt64(&wh.numOfRequest)
 	s.NumOfWasmError = atomic.LoadInt64(&wh.numOfWasmError)
+	s.Metrics = wh.metrics.status()
 	return s
 }
 
 // Close closes WasmHost.
 func (wh *WasmHost) Close() {
 	close(wh.chStop)
+	if p, ok := wh.vmPool.Load().(*proxyWasmPool); ok {
+		p.close()
+	}
 }
@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package migration rewrites the deprecated fields and kinds of object specs
+// to the current schema, for upgrades across breaking spec changes.
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+
+	yamljsontool "github.com/ghodss/yaml"
+)
+
+type (
+	// Migration rewrites the raw specs of a version to the next version.
+	Migration struct {
+		// From is the version of the specs to migrate.
+		From string
+		// Kind is the kind of the specs to migrate, empty means all kinds.
+		Kind        string
+		Description string
+		// Migrate rewrites the spec in place, and returns the warnings of
+		// the manual steps, it must not change specs of the next version.
+		Migrate func(spec map[string]interface{}) (changed bool, warnings []string)
+	}
+)
+
+// Versions are the versions of the spec schema in order, v1 is the schema
+// of Easegress v1.0, v2 is the current one.
+var Versions = []string{"v1", "v2"}
+
+// Latest is the version of the current spec schema.
+var Latest = Versions[len(Versions)-1]
+
+var migrations []*Migration
+
+// Register registers the migration, migrations of the same version are
+// applied in the order of registration.
+func Register(m *Migration) {
+	if indexOf(m.From) < 0 || m.From == Latest {
+		panic(fmt.Errorf("BUG: invalid version %s of migration", m.From))
+	}
+	migrations = append(migrations, m)
+}
+
+func indexOf(version string) int {
+	for i, v := range Versions {
+		if v == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// ValidateVersions validates the versions to migrate from and to.
+func ValidateVersions(from, to string) error {
+	i, j := indexOf(from), indexOf(to)
+	if i < 0 {
+		return fmt.Errorf("unknown version %s, the versions are %v", from, Versions)
+	}
+	if j < 0 {
+		return fmt.Errorf("unknown version %s, the versions are %v", to, Versions)
+	}
+	if i > j {
+		return fmt.Errorf("can't migrate from %s back to %s", from, to)
+	}
+	return nil
+}
+
+// Migrate migrates the raw spec from a version to another, and returns
+// whether the spec is changed and the warnings of the applied migrations
+// and the manual steps.
+func Migrate(spec map[string]interface{}, from, to string) (bool, []string, error) {
+	if err := ValidateVersions(from, to); err != nil {
+		return false, nil, err
+	}
+
+	changed, warnings := false, []string(nil)
+	for i := indexOf(from); i < indexOf(to); i++ {
+		for _, m := range migrations {
+			if m.From != Versions[i] {
+				continue
+			}
+			if m.Kind != "" && spec["kind"] != m.Kind {
+				continue
+			}
+
+			c, w := m.Migrate(spec)
+			if c {
+				changed = true
+				w = append([]string{"migrated: " + m.Description}, w...)
+			}
+			for _, warning := range w {
+				warnings = append(warnings, fmt.Sprintf("%s %v: %s", spec["kind"], spec["name"], warning))
+			}
+		}
+	}
+	return changed, warnings, nil
+}
+
+// MigrateYAML migrates the spec in YAML, the spec is returned as it is if
+// nothing changes, otherwise the keys of the returned spec are sorted.
+func MigrateYAML(buff []byte, from, to string) ([]byte, []string, error) {
+	jsonBuff, err := yamljsontool.YAMLToJSON(buff)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid yaml: %v", err)
+	}
+
+	spec := map[string]interface{}{}
+	err = json.Unmarshal(jsonBuff, &spec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("spec is not an object: %v", err)
+	}
+
+	changed, warnings, err := Migrate(spec, from, to)
+	if err != nil || !changed {
+		return buff, warnings, err
+	}
+
+	jsonBuff, err = json.Marshal(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	buff, err = yamljsontool.JSONToYAML(jsonBuff)
+	return buff, warnings, err
+}
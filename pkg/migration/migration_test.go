@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migration
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestValidateVersions(t *testing.T) {
+	if ValidateVersions("v1", Latest) != nil {
+		t.Error("validate should succeed")
+	}
+	if ValidateVersions("v0", Latest) == nil {
+		t.Error("validate should fail")
+	}
+	if ValidateVersions(Latest, "v1") == nil {
+		t.Error("validate should fail")
+	}
+}
+
+func TestMigrateCertBase64(t *testing.T) {
+	cert := base64.StdEncoding.EncodeToString([]byte("cert pem"))
+	key := base64.StdEncoding.EncodeToString([]byte("key pem"))
+	spec := `
+kind: HTTPServer
+name: server
+https: true
+certBase64: ` + cert + `
+keyBase64: ` + key + `
+certs:
+  default: old cert
+keys:
+  default: old key
+`
+	buff, warnings, err := MigrateYAML([]byte(spec), "v1", Latest)
+	if err != nil || len(warnings) != 1 || warnings[0] != "HTTPServer server: migrated: certBase64 and keyBase64 are replaced by certs and keys" {
+		t.Fatalf("unexpected error %v, warnings %v", err, warnings)
+	}
+
+	var result struct {
+		CertBase64 string            `yaml:"certBase64"`
+		Certs      map[string]string `yaml:"certs"`
+		Keys       map[string]string `yaml:"keys"`
+	}
+	yaml.Unmarshal(buff, &result)
+	if result.CertBase64 != "" {
+		t.Error("certBase64 should be removed")
+	}
+	if result.Certs["default"] != "old cert" || result.Certs["default-1"] != "cert pem" || result.Keys["default-1"] != "key pem" {
+		t.Errorf("unexpected certs %v, keys %v", result.Certs, result.Keys)
+	}
+
+	// Specs of the latest version are kept as they are.
+	again, _, _ := MigrateYAML(buff, "v1", Latest)
+	if string(again) != string(buff) {
+		t.Error("migrated spec should not change")
+	}
+
+	spec = "kind: HTTPServer\nname: server\ncertBase64: " + cert + "\n"
+	buff, warnings, _ = MigrateYAML([]byte(spec), "v1", Latest)
+	if string(buff) != spec || len(warnings) != 1 || !strings.HasPrefix(warnings[0], "HTTPServer server: ") {
+		t.Errorf("unexpected spec %s, warnings %v", buff, warnings)
+	}
+
+	spec = "kind: HTTPPipeline\nname: pipeline\ncertBase64: " + cert + "\nkeyBase64: " + key + "\n"
+	buff, _, _ = MigrateYAML([]byte(spec), "v1", Latest)
+	if string(buff) != spec {
+		t.Error("specs of other kinds should not change")
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migration
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+func init() {
+	Register(&Migration{
+		From:        "v1",
+		Kind:        "HTTPServer",
+		Description: "certBase64 and keyBase64 are replaced by certs and keys",
+		Migrate:     migrateCertBase64,
+	})
+}
+
+// migrateCertBase64 moves certBase64 and keyBase64 into certs and keys,
+// whose values are PEM instead of base64.
+func migrateCertBase64(spec map[string]interface{}) (bool, []string) {
+	certBase64, _ := spec["certBase64"].(string)
+	keyBase64, _ := spec["keyBase64"].(string)
+	if certBase64 == "" && keyBase64 == "" {
+		return false, nil
+	}
+
+	certPEM, err1 := base64.StdEncoding.DecodeString(certBase64)
+	keyPEM, err2 := base64.StdEncoding.DecodeString(keyBase64)
+	if certBase64 == "" || keyBase64 == "" || err1 != nil || err2 != nil {
+		return false, []string{"certBase64 and keyBase64 are deprecated, move them into certs and keys manually"}
+	}
+
+	certs, _ := spec["certs"].(map[string]interface{})
+	keys, _ := spec["keys"].(map[string]interface{})
+	if certs == nil {
+		certs = map[string]interface{}{}
+	}
+	if keys == nil {
+		keys = map[string]interface{}{}
+	}
+
+	name := "default"
+	for i := 1; certs[name] != nil || keys[name] != nil; i++ {
+		name = fmt.Sprintf("default-%d", i)
+	}
+	certs[name], keys[name] = string(certPEM), string(keyPEM)
+	spec["certs"], spec["keys"] = certs, keys
+	delete(spec, "certBase64")
+	delete(spec, "keyBase64")
+
+	return true, nil
+}
@@ -0,0 +1,397 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package analyticsexporter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/filter/audittrail"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/urlclusteranalyzer"
+)
+
+const (
+	// Kind is the kind of AnalyticsExporter.
+	Kind = "AnalyticsExporter"
+
+	period = time.Minute
+	// exportDelay is the delay of exporting a minute after it ends,
+	// for the records of requests finished at the end of the minute.
+	exportDelay   = 10 * time.Second
+	checkInterval = 10 * time.Second
+)
+
+func init() {
+	supervisor.Register(&AnalyticsExporter{})
+}
+
+type (
+	// AnalyticsExporter aggregates per-minute stats of routes and
+	// consumers from an AuditTrail filter, and inserts them into
+	// ClickHouse or PostgreSQL for long-term API analytics.
+	AnalyticsExporter struct {
+		super     *supervisor.Supervisor
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		member    string
+		stateFile string
+		sink      sink
+		analyzer  *urlclusteranalyzer.URLClusterAnalyzer
+
+		// *state
+		state atomic.Value
+		// string
+		lastErr atomic.Value
+
+		done chan struct{}
+	}
+
+	// Spec describes the AnalyticsExporter.
+	Spec struct {
+		// Pipeline and Filter locate the AuditTrail filter.
+		Pipeline string `yaml:"pipeline" jsonschema:"required"`
+		Filter   string `yaml:"filter" jsonschema:"required"`
+		// Routes are matched against requests in order, the route of
+		// requests matching none of them is the method and the learned
+		// pattern of the path, e.g. GET /users/*.
+		Routes []*Route `yaml:"routes" jsonschema:"omitempty"`
+
+		ClickHouse *ClickHouseSpec `yaml:"clickHouse" jsonschema:"omitempty"`
+		PostgreSQL *PostgreSQLSpec `yaml:"postgreSQL" jsonschema:"omitempty"`
+	}
+
+	// Route is a named group of requests.
+	Route struct {
+		Name         string   `yaml:"name" jsonschema:"required"`
+		PathPrefixes []string `yaml:"pathPrefixes" jsonschema:"required,minItems=1"`
+		Methods      []string `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+	}
+
+	// Status is the status of AnalyticsExporter.
+	Status struct {
+		Member    string    `yaml:"member"`
+		PeriodEnd time.Time `yaml:"periodEnd"`
+		LastError string    `yaml:"lastError,omitempty"`
+	}
+
+	// state is the progress of exporting, which is saved
+	// in the data directory to survive restarts.
+	state struct {
+		// PeriodEnd is the end of the last exported minute.
+		PeriodEnd time.Time `yaml:"periodEnd"`
+	}
+
+	// Stat is the stat of requests of a consumer on a route in a minute.
+	Stat struct {
+		Minute        time.Time `json:"minute"`
+		Exporter      string    `json:"exporter"`
+		Member        string    `json:"member"`
+		Route         string    `json:"route"`
+		Consumer      string    `json:"consumer"`
+		Requests      uint64    `json:"requests"`
+		ClientErrors  uint64    `json:"client_errors"`
+		ServerErrors  uint64    `json:"server_errors"`
+		LatencySumMs  int64     `json:"latency_sum_ms"`
+		LatencyP50Ms  int64     `json:"latency_p50_ms"`
+		LatencyP95Ms  int64     `json:"latency_p95_ms"`
+		LatencyP99Ms  int64     `json:"latency_p99_ms"`
+		LatencyMaxMs  int64     `json:"latency_max_ms"`
+		RequestBytes  uint64    `json:"request_bytes"`
+		ResponseBytes uint64    `json:"response_bytes"`
+
+		latencies []int64
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if (spec.ClickHouse == nil) == (spec.PostgreSQL == nil) {
+		return fmt.Errorf("exactly one of clickHouse and postgreSQL is required")
+	}
+
+	names := map[string]bool{}
+	for _, r := range spec.Routes {
+		if names[r.Name] {
+			return fmt.Errorf("duplicated route %s", r.Name)
+		}
+		names[r.Name] = true
+	}
+
+	return nil
+}
+
+func (r *Route) match(record *audittrail.Record) bool {
+	if len(r.Methods) > 0 {
+		matched := false
+		for _, m := range r.Methods {
+			if m == record.Method {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	for _, prefix := range r.PathPrefixes {
+		if strings.HasPrefix(record.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Category returns the category of AnalyticsExporter.
+func (ae *AnalyticsExporter) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+
+// Kind returns the kind of AnalyticsExporter.
+func (ae *AnalyticsExporter) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of AnalyticsExporter.
+func (ae *AnalyticsExporter) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Init initializes AnalyticsExporter.
+func (ae *AnalyticsExporter) Init(superSpec *supervisor.Spec) {
+	ae.superSpec, ae.spec, ae.super = superSpec, superSpec.ObjectSpec().(*Spec), superSpec.Super()
+	ae.reload()
+}
+
+// Inherit inherits previous generation of AnalyticsExporter.
+func (ae *AnalyticsExporter) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	previousGeneration.Close()
+	ae.Init(superSpec)
+}
+
+func (ae *AnalyticsExporter) reload() {
+	ae.member = ae.super.Options().Name
+	ae.stateFile = filepath.Join(ae.super.Options().AbsDataDir, "analyticsexporter", ae.superSpec.Name()+".yaml")
+	ae.sink = newSink(ae.spec)
+	ae.analyzer = urlclusteranalyzer.New()
+	ae.done = make(chan struct{})
+
+	ae.state.Store(ae.loadState())
+
+	go ae.run()
+}
+
+func (ae *AnalyticsExporter) loadState() *state {
+	s := &state{}
+	buff, err := ioutil.ReadFile(ae.stateFile)
+	if err == nil {
+		err = yaml.Unmarshal(buff, s)
+	}
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Errorf("%s load state from %s failed: %v", ae.superSpec.Name(), ae.stateFile, err)
+		}
+		// NOTE: Start from the current minute, the past is never exported.
+		s.PeriodEnd = time.Now().Truncate(period)
+	}
+	return s
+}
+
+func (ae *AnalyticsExporter) getState() *state {
+	return ae.state.Load().(*state)
+}
+
+func (ae *AnalyticsExporter) saveState(s *state) error {
+	buff, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("marshal %#v to yaml failed: %v", s, err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(ae.stateFile), 0o750)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: Write to a temporary file then rename it,
+	// so that the state is never corrupted.
+	tmpFile := ae.stateFile + ".tmp"
+	err = ioutil.WriteFile(tmpFile, buff, 0o640)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, ae.stateFile)
+}
+
+func (ae *AnalyticsExporter) run() {
+	for {
+		ae.exportDue(time.Now())
+
+		select {
+		case <-ae.done:
+			return
+		case <-time.After(checkInterval):
+		}
+	}
+}
+
+// exportDue exports all ended minutes in order, it stops at the first
+// failure and the minute will be retried next time.
+func (ae *AnalyticsExporter) exportDue(now time.Time) {
+	for {
+		select {
+		case <-ae.done:
+			return
+		default:
+		}
+
+		start := ae.getState().PeriodEnd
+		end := start.Add(period)
+		if end.Add(exportDelay).After(now) {
+			return
+		}
+
+		err := ae.export(start, end)
+		if err != nil {
+			logger.Errorf("%s export minute %s failed: %v",
+				ae.superSpec.Name(), start.Format(time.RFC3339), err)
+			ae.lastErr.Store(err.Error())
+			return
+		}
+
+		next := &state{PeriodEnd: end}
+		err = ae.saveState(next)
+		if err != nil {
+			logger.Errorf("%s save state to %s failed: %v", ae.superSpec.Name(), ae.stateFile, err)
+		}
+		ae.state.Store(next)
+		ae.lastErr.Store("")
+	}
+}
+
+func (ae *AnalyticsExporter) export(start, end time.Time) error {
+	stats, err := ae.stats(start, end)
+	if err != nil {
+		return err
+	}
+	if len(stats) == 0 {
+		return nil
+	}
+	return ae.sink.send(stats)
+}
+
+func (ae *AnalyticsExporter) routeOf(r *audittrail.Record) string {
+	for _, route := range ae.spec.Routes {
+		if route.match(r) {
+			return route.Name
+		}
+	}
+	return r.Method + " " + ae.analyzer.GetPattern(r.Path)
+}
+
+func (ae *AnalyticsExporter) stats(start, end time.Time) ([]*Stat, error) {
+	stats := map[[2]string]*Stat{}
+	q := &audittrail.Query{Since: start, Until: end}
+	err := audittrail.Walk(ae.spec.Pipeline, ae.spec.Filter, q, func(r *audittrail.Record) bool {
+		route := ae.routeOf(r)
+		key := [2]string{route, r.Consumer}
+		s := stats[key]
+		if s == nil {
+			s = &Stat{
+				Minute:   start,
+				Exporter: ae.superSpec.Name(),
+				Member:   ae.member,
+				Route:    route,
+				Consumer: r.Consumer,
+			}
+			stats[key] = s
+		}
+
+		s.Requests++
+		switch {
+		case r.StatusCode >= 500:
+			s.ServerErrors++
+		case r.StatusCode >= 400:
+			s.ClientErrors++
+		}
+		s.LatencySumMs += r.DurationMs
+		s.latencies = append(s.latencies, r.DurationMs)
+		s.RequestBytes += r.RequestBytes
+		s.ResponseBytes += r.ResponseBytes
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Stat, 0, len(stats))
+	for _, s := range stats {
+		sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+		s.LatencyP50Ms = percentile(s.latencies, 50)
+		s.LatencyP95Ms = percentile(s.latencies, 95)
+		s.LatencyP99Ms = percentile(s.latencies, 99)
+		s.LatencyMaxMs = s.latencies[len(s.latencies)-1]
+		s.latencies = nil
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Route != result[j].Route {
+			return result[i].Route < result[j].Route
+		}
+		return result[i].Consumer < result[j].Consumer
+	})
+
+	return result, nil
+}
+
+// percentile returns the nearest-rank percentile of the sorted values.
+func percentile(sorted []int64, p int) int64 {
+	rank := (len(sorted)*p + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Status returns the status of AnalyticsExporter.
+func (ae *AnalyticsExporter) Status() *supervisor.Status {
+	s := &Status{
+		Member:    ae.member,
+		PeriodEnd: ae.getState().PeriodEnd,
+	}
+	if lastErr, ok := ae.lastErr.Load().(string); ok {
+		s.LastError = lastErr
+	}
+
+	return &supervisor.Status{ObjectStatus: s}
+}
+
+// Close closes AnalyticsExporter.
+func (ae *AnalyticsExporter) Close() {
+	close(ae.done)
+	ae.sink.close()
+}
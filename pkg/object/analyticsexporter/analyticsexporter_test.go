@@ -0,0 +1,242 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package analyticsexporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/filter/audittrail"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/urlclusteranalyzer"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newAuditTrail(t *testing.T, dir string) *audittrail.AuditTrail {
+	yamlSpec := fmt.Sprintf(`
+kind: AuditTrail
+name: audit
+consumer:
+  source: Header
+  name: X-Consumer
+dir: %s
+`, dir)
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	a := &audittrail.AuditTrail{}
+	a.Init(spec)
+	return a
+}
+
+func handleRequest(a *audittrail.AuditTrail, consumer, path string, statusCode int, duration time.Duration) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("X-Consumer", consumer)
+	w := httptest.NewRecorder()
+	w.Code = statusCode
+
+	ctx := contexttest.NewMockedHTTPContext(req, w)
+	ctx.MockedRequest.MockedSize = func() uint64 { return 10 }
+	ctx.MockedResponse.MockedSize = func() uint64 { return 100 }
+	ctx.MockedDuration = func() time.Duration { return duration }
+
+	a.Handle(ctx)
+	ctx.Finish()
+}
+
+func newAnalyticsExporter(t *testing.T, dir, sinkSpec string) *AnalyticsExporter {
+	superSpec, err := supervisor.NewSpec(`
+kind: AnalyticsExporter
+name: analytics
+pipeline: ""
+filter: audit
+routes:
+- name: orders
+  pathPrefixes: [/orders]
+` + sinkSpec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ae := &AnalyticsExporter{
+		superSpec: superSpec,
+		spec:      superSpec.ObjectSpec().(*Spec),
+		member:    "member-1",
+		stateFile: filepath.Join(dir, "analytics.yaml"),
+		analyzer:  urlclusteranalyzer.New(),
+		done:      make(chan struct{}),
+	}
+	ae.sink = newSink(ae.spec)
+	return ae
+}
+
+func TestSpecValidate(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"clickHouse: {url: 'http://localhost:8123', table: t}\npostgreSQL: {address: 'localhost:5432', database: d, username: u, table: t}",
+		"postgreSQL: {address: 'localhost:5432', database: d, table: t}",
+	} {
+		_, err := supervisor.NewSpec("kind: AnalyticsExporter\nname: analytics\npipeline: p\nfilter: f\n" + s)
+		if err == nil {
+			t.Errorf("spec with sinks %q should be invalid", s)
+		}
+	}
+
+	_, err := supervisor.NewSpec("kind: AnalyticsExporter\nname: analytics\npipeline: p\nfilter: f\n" +
+		"postgreSQL: {address: 'localhost:5432', database: d, table: t, vault: {address: 'http://localhost:8200', path: database/creds/analytics}}")
+	if err != nil {
+		t.Errorf("spec with vault should be valid: %v", err)
+	}
+}
+
+func TestClickHouseExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "analyticsexporter")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAuditTrail(t, dir)
+	defer a.Close()
+
+	// NOTE: All requests should be in the same minute.
+	if now := time.Now(); now.Add(time.Second).Truncate(period) != now.Truncate(period) {
+		time.Sleep(time.Second)
+	}
+	start := time.Now().Truncate(period)
+	handleRequest(a, "alice", "/orders/1", http.StatusOK, 10*time.Millisecond)
+	handleRequest(a, "alice", "/orders/2", http.StatusNotFound, 20*time.Millisecond)
+	handleRequest(a, "alice", "/orders/3", http.StatusBadGateway, 30*time.Millisecond)
+	handleRequest(a, "bob", "/users", http.StatusOK, 5*time.Millisecond)
+
+	fail := true
+	var queries []string
+	var stats []*Stat
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		queries = append(queries, r.URL.Query().Get("query"))
+		decoder := json.NewDecoder(r.Body)
+		for decoder.More() {
+			s := &Stat{}
+			decoder.Decode(s)
+			stats = append(stats, s)
+		}
+	}))
+	defer server.Close()
+
+	ae := newAnalyticsExporter(t, dir, "clickHouse:\n  url: "+server.URL+"\n  table: api_stats")
+	defer ae.Close()
+	ae.state.Store(&state{PeriodEnd: start})
+
+	due := start.Add(period + exportDelay)
+	ae.exportDue(due)
+	if ae.getState().PeriodEnd != start {
+		t.Fatalf("state should not advance on failure")
+	}
+	if ae.Status().ObjectStatus.(*Status).LastError == "" {
+		t.Errorf("last error should be set")
+	}
+
+	fail = false
+	ae.exportDue(due)
+	if !ae.getState().PeriodEnd.Equal(start.Add(period)) {
+		t.Fatalf("unexpected state %+v", ae.getState())
+	}
+	if len(queries) != 1 || queries[0] != "INSERT INTO api_stats FORMAT JSONEachRow" {
+		t.Fatalf("unexpected queries %v", queries)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("want 2 stats, got %d", len(stats))
+	}
+
+	s := stats[0]
+	if s.Route != "GET /users" || s.Consumer != "bob" || s.Requests != 1 {
+		t.Errorf("unexpected stat %+v", s)
+	}
+	s = stats[1]
+	if s.Route != "orders" || s.Consumer != "alice" || s.Member != "member-1" || s.Exporter != "analytics" {
+		t.Errorf("unexpected stat %+v", s)
+	}
+	if s.Requests != 3 || s.ClientErrors != 1 || s.ServerErrors != 1 {
+		t.Errorf("unexpected counts %+v", s)
+	}
+	if s.LatencySumMs != 60 || s.LatencyP50Ms != 20 || s.LatencyP99Ms != 30 || s.LatencyMaxMs != 30 {
+		t.Errorf("unexpected latencies %+v", s)
+	}
+	if s.RequestBytes != 30 || s.ResponseBytes != 300 {
+		t.Errorf("unexpected bytes %+v", s)
+	}
+
+	// The state is saved and loaded after restarts.
+	end := ae.getState().PeriodEnd
+	if loaded := ae.loadState(); !loaded.PeriodEnd.Equal(end) {
+		t.Errorf("want period end %v, got %v", end, loaded.PeriodEnd)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	values := []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	for p, expected := range map[int]int64{50: 5, 95: 10, 99: 10, 10: 1, 0: 1} {
+		if v := percentile(values, p); v != expected {
+			t.Errorf("p%d: want %d, got %d", p, expected, v)
+		}
+	}
+}
+
+func TestPostgreSQLInsertStatement(t *testing.T) {
+	s := &postgreSQLSink{spec: &PostgreSQLSpec{Table: "api_stats"}}
+	minute := time.Date(2021, 6, 1, 10, 30, 0, 0, time.UTC)
+	stmt := s.insertStatement([]*Stat{
+		{Minute: minute, Exporter: "analytics", Member: "m", Route: "GET /users/*", Consumer: "o'neil", Requests: 2},
+		{Minute: minute, Exporter: "analytics", Member: "m", Route: "orders", Consumer: "bob", Requests: 1},
+	})
+
+	if !strings.HasPrefix(stmt, `INSERT INTO "api_stats" (minute, exporter, member, route, consumer, requests,`) {
+		t.Errorf("unexpected statement %s", stmt)
+	}
+	if !strings.Contains(stmt, `('2021-06-01T10:30:00Z', 'analytics', 'm', 'GET /users/*', 'o''neil', 2, 0, 0`) {
+		t.Errorf("unexpected statement %s", stmt)
+	}
+	if !strings.HasSuffix(stmt, ", 0, 0) ON CONFLICT DO NOTHING") || strings.Count(stmt, "), (") != 1 {
+		t.Errorf("unexpected statement %s", stmt)
+	}
+}
@@ -0,0 +1,270 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package analyticsexporter
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/pgclient"
+	"github.com/megaease/easegress/pkg/util/vault"
+)
+
+const sinkTimeout = 30 * time.Second
+
+type (
+	// ClickHouseSpec is the spec of the ClickHouse sink, stats are
+	// inserted by the HTTP interface in the format JSONEachRow.
+	ClickHouseSpec struct {
+		URL      string `yaml:"url" jsonschema:"required,format=url"`
+		Table    string `yaml:"table" jsonschema:"required"`
+		Username string `yaml:"username" jsonschema:"omitempty"`
+		Password string `yaml:"password" jsonschema:"omitempty"`
+		// Vault overrides the username and password by the credentials in Vault.
+		Vault *vault.Spec `yaml:"vault,omitempty" jsonschema:"omitempty"`
+	}
+
+	// PostgreSQLSpec is the spec of the PostgreSQL sink.
+	PostgreSQLSpec struct {
+		// Address is host:port of the server.
+		Address  string `yaml:"address" jsonschema:"required"`
+		Database string `yaml:"database" jsonschema:"required"`
+		Username string `yaml:"username" jsonschema:"omitempty"`
+		Password string `yaml:"password" jsonschema:"omitempty"`
+		Table    string `yaml:"table" jsonschema:"required"`
+		TLS      bool   `yaml:"tls" jsonschema:"omitempty"`
+		// Vault overrides the username and password by the credentials
+		// in Vault, e.g. the ones of the database secrets engine.
+		Vault *vault.Spec `yaml:"vault,omitempty" jsonschema:"omitempty"`
+	}
+
+	sink interface {
+		send(stats []*Stat) error
+		close()
+	}
+
+	clickHouseSink struct {
+		spec   *ClickHouseSpec
+		client *http.Client
+		vault  *vault.Client
+	}
+
+	postgreSQLSink struct {
+		spec  *PostgreSQLSpec
+		vault *vault.Client
+
+		mutex sync.Mutex
+		conn  *pgclient.Conn
+		// credentials are the Vault credentials of conn.
+		credentials *vault.Credentials
+	}
+)
+
+// columns are the columns of stats in PostgreSQL, in the order of values.
+var columns = []string{
+	"minute", "exporter", "member", "route", "consumer",
+	"requests", "client_errors", "server_errors",
+	"latency_sum_ms", "latency_p50_ms", "latency_p95_ms", "latency_p99_ms", "latency_max_ms",
+	"request_bytes", "response_bytes",
+}
+
+func newSink(spec *Spec) sink {
+	if spec.ClickHouse != nil {
+		s := &clickHouseSink{spec: spec.ClickHouse, client: &http.Client{Timeout: sinkTimeout}}
+		if s.spec.Vault != nil {
+			s.vault = vault.New(s.spec.Vault)
+		}
+		return s
+	}
+
+	s := &postgreSQLSink{spec: spec.PostgreSQL}
+	if s.spec.Vault != nil {
+		s.vault = vault.New(s.spec.Vault)
+	}
+	return s
+}
+
+// Validate validates PostgreSQLSpec.
+func (spec PostgreSQLSpec) Validate() error {
+	if spec.Username == "" && spec.Vault == nil {
+		return fmt.Errorf("username is required without vault")
+	}
+	return nil
+}
+
+func (s *clickHouseSink) send(stats []*Stat) error {
+	body := bytes.NewBuffer(nil)
+	encoder := json.NewEncoder(body)
+	for _, stat := range stats {
+		if err := encoder.Encode(stat); err != nil {
+			return fmt.Errorf("marshal stat to json failed: %v", err)
+		}
+	}
+
+	u, err := url.Parse(s.spec.URL)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("query", fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", s.spec.Table))
+	// NOTE: Times are in RFC3339, which is not the default input format.
+	q.Set("date_time_input_format", "best_effort")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodPost, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	username, password := s.spec.Username, s.spec.Password
+	if s.vault != nil {
+		creds, err := s.vault.Get()
+		if err != nil {
+			return fmt.Errorf("get credentials from vault failed: %v", err)
+		}
+		username, password = creds.Username, creds.Password
+	}
+	if username != "" {
+		req.Header.Set("X-ClickHouse-User", username)
+		req.Header.Set("X-ClickHouse-Key", password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, msg)
+}
+
+func (s *clickHouseSink) close() {
+	if s.vault != nil {
+		s.vault.Close()
+	}
+}
+
+// insertStatement returns the statement inserting the stats, conflicting
+// rows are ignored, so retried stats are not duplicated if the table has
+// a unique key of minute, exporter, member, route and consumer.
+func (s *postgreSQLSink) insertStatement(stats []*Stat) string {
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(pgclient.QuoteIdentifier(s.spec.Table))
+	sb.WriteString(" (")
+	sb.WriteString(strings.Join(columns, ", "))
+	sb.WriteString(") VALUES ")
+
+	for i, stat := range stats {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		values := []string{
+			pgclient.QuoteLiteral(stat.Minute.UTC().Format(time.RFC3339)),
+			pgclient.QuoteLiteral(stat.Exporter),
+			pgclient.QuoteLiteral(stat.Member),
+			pgclient.QuoteLiteral(stat.Route),
+			pgclient.QuoteLiteral(stat.Consumer),
+			strconv.FormatUint(stat.Requests, 10),
+			strconv.FormatUint(stat.ClientErrors, 10),
+			strconv.FormatUint(stat.ServerErrors, 10),
+			strconv.FormatInt(stat.LatencySumMs, 10),
+			strconv.FormatInt(stat.LatencyP50Ms, 10),
+			strconv.FormatInt(stat.LatencyP95Ms, 10),
+			strconv.FormatInt(stat.LatencyP99Ms, 10),
+			strconv.FormatInt(stat.LatencyMaxMs, 10),
+			strconv.FormatUint(stat.RequestBytes, 10),
+			strconv.FormatUint(stat.ResponseBytes, 10),
+		}
+		sb.WriteString("(")
+		sb.WriteString(strings.Join(values, ", "))
+		sb.WriteString(")")
+	}
+	sb.WriteString(" ON CONFLICT DO NOTHING")
+	return sb.String()
+}
+
+func (s *postgreSQLSink) send(stats []*Stat) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	username, password := s.spec.Username, s.spec.Password
+	if s.vault != nil {
+		creds, err := s.vault.Get()
+		if err != nil {
+			return fmt.Errorf("get credentials from vault failed: %v", err)
+		}
+		// NOTE: Reconnect by the rotated credentials.
+		if creds != s.credentials && s.conn != nil {
+			s.conn.Close()
+			s.conn = nil
+		}
+		s.credentials = creds
+		username, password = creds.Username, creds.Password
+	}
+
+	if s.conn == nil {
+		opts := &pgclient.Options{
+			Address:  s.spec.Address,
+			Database: s.spec.Database,
+			Username: username,
+			Password: password,
+			Timeout:  sinkTimeout,
+		}
+		if s.spec.TLS {
+			opts.TLS = &tls.Config{}
+		}
+		conn, err := pgclient.Connect(opts)
+		if err != nil {
+			return fmt.Errorf("connect to %s failed: %v", s.spec.Address, err)
+		}
+		s.conn = conn
+	}
+
+	err := s.conn.Exec(s.insertStatement(stats))
+	if _, ok := err.(*pgclient.Error); err != nil && !ok {
+		// NOTE: Reconnect next time if the connection is broken.
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *postgreSQLSink) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	if s.vault != nil {
+		s.vault.Close()
+	}
+}
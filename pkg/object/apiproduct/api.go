@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package apiproduct
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/api"
+)
+
+const (
+	apiGroupName = "apiproduct_admin"
+	apiPrefix    = "/apiproducts/subscriptions"
+)
+
+var registerOnce sync.Once
+
+func registerAPIs() {
+	api.RegisterAPIs(&api.Group{
+		Group: apiGroupName,
+		Entries: []*api.Entry{
+			{Path: apiPrefix, Method: "GET", Handler: listSubscriptions},
+			{Path: apiPrefix + "/{consumer}", Method: "GET", Handler: getSubscription},
+			{Path: apiPrefix + "/{consumer}", Method: "PUT", Handler: putSubscription},
+			{Path: apiPrefix + "/{consumer}", Method: "DELETE", Handler: deleteSubscription},
+		},
+	})
+}
+
+func writeYAML(w http.ResponseWriter, v interface{}) {
+	buff, err := yaml.Marshal(v)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", v, err))
+	}
+	w.Header().Set("Content-Type", "text/vnd.yaml")
+	w.Write(buff)
+}
+
+func listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	writeYAML(w, Subscriptions())
+}
+
+func getSubscription(w http.ResponseWriter, r *http.Request) {
+	consumer := chi.URLParam(r, "consumer")
+	for _, s := range Subscriptions() {
+		if s.Consumer == consumer {
+			writeYAML(w, s)
+			return
+		}
+	}
+
+	api.HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("subscription of %s not found", consumer))
+}
+
+func putSubscription(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("read body failed: %v", err))
+		return
+	}
+
+	s := &Subscription{}
+	err = yaml.Unmarshal(body, s)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("unmarshal %s to yaml failed: %v", body, err))
+		return
+	}
+
+	err = Subscribe(chi.URLParam(r, "consumer"), s.Plan)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+}
+
+func deleteSubscription(w http.ResponseWriter, r *http.Request) {
+	err := Unsubscribe(chi.URLParam(r, "consumer"))
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+// Package apiproduct models commercial API management: APIProducts are
+// groups of routes, Plans bundle products with rate, quota and feature
+// policies, and consumers subscribe to plans.
+package apiproduct
+
+import (
+	"strings"
+
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// KindAPIProduct is the kind of APIProduct.
+	KindAPIProduct = "APIProduct"
+)
+
+func init() {
+	supervisor.Register(&APIProduct{})
+}
+
+type (
+	// APIProduct is a group of routes sold as a whole.
+	APIProduct struct {
+		superSpec *supervisor.Spec
+		spec      *ProductSpec
+	}
+
+	// ProductSpec describes the APIProduct.
+	ProductSpec struct {
+		Description  string   `yaml:"description" jsonschema:"omitempty"`
+		PathPrefixes []string `yaml:"pathPrefixes" jsonschema:"required,minItems=1,uniqueItems=true"`
+		Methods      []string `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+	}
+
+	// ProductStatus is the status of APIProduct.
+	ProductStatus struct {
+		Plans []string `yaml:"plans"`
+	}
+)
+
+// matchLen returns the length of the longest path prefix matching
+// the request, or -1 if the request doesn't belong to the product.
+func (spec *ProductSpec) matchLen(method, path string) int {
+	if len(spec.Methods) > 0 {
+		matched := false
+		for _, m := range spec.Methods {
+			if m == method {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return -1
+		}
+	}
+
+	l := -1
+	for _, prefix := range spec.PathPrefixes {
+		if len(prefix) > l && strings.HasPrefix(path, prefix) {
+			l = len(prefix)
+		}
+	}
+	return l
+}
+
+// Category returns the category of APIProduct.
+func (p *APIProduct) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+
+// Kind returns the kind of APIProduct.
+func (p *APIProduct) Kind() string {
+	return KindAPIProduct
+}
+
+// DefaultSpec returns the default spec of APIProduct.
+func (p *APIProduct) DefaultSpec() interface{} {
+	return &ProductSpec{}
+}
+
+// Init initializes APIProduct.
+func (p *APIProduct) Init(superSpec *supervisor.Spec) {
+	p.superSpec, p.spec = superSpec, superSpec.ObjectSpec().(*ProductSpec)
+	initStore(superSpec.Super())
+	registerProduct(p.superSpec.Name(), p.spec)
+}
+
+// Inherit inherits previous generation of APIProduct.
+func (p *APIProduct) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	// NOTE: Registering the new generation replaces the previous one.
+	p.Init(superSpec)
+}
+
+// Status returns the status of APIProduct.
+func (p *APIProduct) Status() *supervisor.Status {
+	return &supervisor.Status{
+		ObjectStatus: &ProductStatus{Plans: plansOfProduct(p.superSpec.Name())},
+	}
+}
+
+// Close closes APIProduct.
+func (p *APIProduct) Close() {
+	unregisterProduct(p.superSpec.Name(), p.spec)
+}
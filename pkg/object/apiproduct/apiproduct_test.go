@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package apiproduct
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newObject(t *testing.T, yamlConfig string) supervisor.Controller {
+	spec, err := supervisor.NewSpec(yamlConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var o supervisor.Controller
+	switch spec.Kind() {
+	case KindAPIProduct:
+		o = &APIProduct{}
+	default:
+		o = &Plan{}
+	}
+	o.Init(spec)
+	return o
+}
+
+func TestMatchProduct(t *testing.T) {
+	orders := newObject(t, `
+kind: APIProduct
+name: orders
+pathPrefixes: [/orders]
+`)
+	defer orders.Close()
+	reports := newObject(t, `
+kind: APIProduct
+name: reports
+pathPrefixes: [/orders/reports]
+methods: [GET]
+`)
+	defer reports.Close()
+
+	cases := []struct {
+		method, path, product string
+	}{
+		{"GET", "/orders/1", "orders"},
+		{"GET", "/orders/reports/1", "reports"},
+		{"POST", "/orders/reports/1", "orders"},
+		{"GET", "/users/1", ""},
+	}
+	for _, c := range cases {
+		if got := MatchProduct(c.method, c.path); got != c.product {
+			t.Errorf("%s %s: want product %q, got %q", c.method, c.path, c.product, got)
+		}
+	}
+
+	reports.Close()
+	if got := MatchProduct("GET", "/orders/reports/1"); got != "orders" {
+		t.Errorf("closed product should not match, got %q", got)
+	}
+}
+
+func TestSubscribedPlan(t *testing.T) {
+	free := newObject(t, `
+kind: Plan
+name: free
+products: [orders]
+consumers: [alice]
+`)
+	defer free.Close()
+	pro := newObject(t, `
+kind: Plan
+name: pro
+products: [orders, reports]
+features: [export]
+consumers: [alice, bob]
+`)
+	defer pro.Close()
+
+	if name, spec := SubscribedPlan("alice"); name != "free" || spec.HasProduct("reports") {
+		t.Errorf("alice should subscribe to free, got %s", name)
+	}
+	if name, spec := SubscribedPlan("bob"); name != "pro" || !spec.HasProduct("reports") {
+		t.Errorf("bob should subscribe to pro, got %s", name)
+	}
+	if name, _ := SubscribedPlan("carol"); name != "" {
+		t.Errorf("carol should subscribe to nothing, got %s", name)
+	}
+
+	subscriptions := Subscriptions()
+	expected := []*Subscription{
+		{Consumer: "alice", Plan: "free", Static: true},
+		{Consumer: "bob", Plan: "pro", Static: true},
+	}
+	if !reflect.DeepEqual(subscriptions, expected) {
+		t.Errorf("want subscriptions %v, got %v", expected, subscriptions)
+	}
+
+	if err := Subscribe("carol", "pro"); err == nil {
+		t.Errorf("subscribe should fail without the store")
+	}
+	if err := Subscribe("carol", "enterprise"); err == nil {
+		t.Errorf("subscribe to a missing plan should fail")
+	}
+
+	if refs := pro.(*Plan).spec.References(); !reflect.DeepEqual(refs, []string{"orders", "reports"}) {
+		t.Errorf("unexpected references %v", refs)
+	}
+}
+
+func TestApps(t *testing.T) {
+	if _, _, err := RegisterApp("alice", "-invalid"); err == nil {
+		t.Errorf("register app with invalid name should fail")
+	}
+	if _, _, err := RegisterApp("alice", "app1"); err == nil {
+		t.Errorf("register app should fail without the store")
+	}
+	if c := LookupAPIKey("key"); c != "" {
+		t.Errorf("expected no consumer, got %s", c)
+	}
+
+	key, err := newAPIKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(key) != 32 || hashAPIKey(key) == hashAPIKey(key+"x") {
+		t.Errorf("unexpected key %s", key)
+	}
+}
+
+func TestQuotaWindow(t *testing.T) {
+	q := &Quota{Requests: 10, Period: "1h"}
+	now := time.Unix(3600*5+10, 0)
+	start, end := q.Window(now)
+	if start.Unix() != 3600*5 || end.Unix() != 3600*6 {
+		t.Errorf("unexpected window %v - %v", start, end)
+	}
+	if key := QuotaKey("free", "alice", start); key != "quota/free/alice/18000" {
+		t.Errorf("unexpected key %s", key)
+	}
+}
@@ -0,0 +1,264 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package apiproduct
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/consumer"
+)
+
+const (
+	appPrefix   = "apps/"
+	keyPrefix   = "keys/"
+	quotaPrefix = "quota/"
+
+	// keyLen is the length of the random bytes of API keys.
+	keyLen = 24
+)
+
+var appNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+type (
+	// App is an application registered by a consumer, every app owns
+	// an API key identifying the consumer.
+	App struct {
+		Name      string    `json:"name"`
+		Consumer  string    `json:"consumer"`
+		CreatedAt time.Time `json:"createdAt"`
+		// KeyHint is the leading characters of the API key, it helps
+		// consumers to tell keys apart, as keys are shown only once.
+		KeyHint string `json:"keyHint"`
+		// KeyHash is the hex encoded SHA256 of the API key, the
+		// key itself is never stored.
+		KeyHash string `json:"keyHash"`
+	}
+)
+
+func init() {
+	consumer.RegisterAPIKeyLookup(LookupAPIKey)
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func newAPIKey() (string, error) {
+	buff := make([]byte, keyLen)
+	if _, err := rand.Read(buff); err != nil {
+		return "", fmt.Errorf("generate api key failed: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buff), nil
+}
+
+func appKey(consumer, name string) string {
+	return appPrefix + consumer + "/" + name
+}
+
+// LookupAPIKey returns the consumer owning the API key, or an empty
+// string if the key is unknown.
+func LookupAPIKey(key string) string {
+	s, err := Store()
+	if err != nil {
+		return ""
+	}
+	consumer, _ := s.Get(keyPrefix + hashAPIKey(key))
+	return consumer
+}
+
+func loadApp(consumer, name string) (*App, error) {
+	s, err := Store()
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := s.Get(appKey(consumer, name))
+	if !ok {
+		return nil, nil
+	}
+
+	app := &App{}
+	if err := json.Unmarshal([]byte(value), app); err != nil {
+		return nil, fmt.Errorf("unmarshal app %s failed: %v", name, err)
+	}
+	return app, nil
+}
+
+func storeApp(app *App) error {
+	s, err := Store()
+	if err != nil {
+		return err
+	}
+
+	buff, err := json.Marshal(app)
+	if err != nil {
+		return fmt.Errorf("marshal app %s failed: %v", app.Name, err)
+	}
+	return s.Put(appKey(app.Consumer, app.Name), string(buff), 0)
+}
+
+// Apps returns the apps of the consumer sorted by name.
+func Apps(consumer string) ([]*App, error) {
+	s, err := Store()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := appPrefix + consumer + "/"
+	apps := []*App{}
+	for _, key := range s.Keys() {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		app, err := loadApp(consumer, strings.TrimPrefix(key, prefix))
+		if err != nil {
+			return nil, err
+		}
+		if app != nil {
+			apps = append(apps, app)
+		}
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].Name < apps[j].Name })
+
+	return apps, nil
+}
+
+// RegisterApp registers an app for the consumer, it returns the app
+// and its API key.
+func RegisterApp(consumer, name string) (*App, string, error) {
+	if !appNameRegexp.MatchString(name) {
+		return nil, "", fmt.Errorf("invalid app name %s", name)
+	}
+
+	app, err := loadApp(consumer, name)
+	if err != nil {
+		return nil, "", err
+	}
+	if app != nil {
+		return nil, "", fmt.Errorf("app %s already exists", name)
+	}
+
+	app = &App{Name: name, Consumer: consumer, CreatedAt: time.Now()}
+	key, err := grantAPIKey(app)
+	if err != nil {
+		return nil, "", err
+	}
+	return app, key, nil
+}
+
+// RotateAPIKey replaces the API key of the app, the old key becomes
+// invalid at once.
+func RotateAPIKey(consumer, name string) (*App, string, error) {
+	app, err := loadApp(consumer, name)
+	if err != nil {
+		return nil, "", err
+	}
+	if app == nil {
+		return nil, "", fmt.Errorf("app %s not found", name)
+	}
+
+	oldHash := app.KeyHash
+	key, err := grantAPIKey(app)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s, _ := Store()
+	if err := s.Delete(keyPrefix + oldHash); err != nil {
+		return nil, "", err
+	}
+	return app, key, nil
+}
+
+// DeleteApp deletes the app and revokes its API key.
+func DeleteApp(consumer, name string) error {
+	app, err := loadApp(consumer, name)
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		return fmt.Errorf("app %s not found", name)
+	}
+
+	s, _ := Store()
+	if err := s.Delete(keyPrefix + app.KeyHash); err != nil {
+		return err
+	}
+	return s.Delete(appKey(consumer, name))
+}
+
+// grantAPIKey generates a new API key for the app and stores both.
+func grantAPIKey(app *App) (string, error) {
+	key, err := newAPIKey()
+	if err != nil {
+		return "", err
+	}
+	app.KeyHint, app.KeyHash = key[:6], hashAPIKey(key)
+
+	s, err := Store()
+	if err != nil {
+		return "", err
+	}
+	// NOTE: Store the key first, so the app never refers to a missing key.
+	if err := s.Put(keyPrefix+app.KeyHash, app.Consumer, 0); err != nil {
+		return "", err
+	}
+	if err := storeApp(app); err != nil {
+		s.Delete(keyPrefix + app.KeyHash)
+		return "", err
+	}
+	return key, nil
+}
+
+// Window returns the quota window containing t.
+func (q *Quota) Window(t time.Time) (start, end time.Time) {
+	period, _ := time.ParseDuration(q.Period)
+	start = t.Truncate(period)
+	return start, start.Add(period)
+}
+
+// QuotaKey returns the key of the quota counter of the consumer
+// in the plan for the window starting at start.
+func QuotaKey(plan, consumer string, start time.Time) string {
+	return quotaPrefix + plan + "/" + consumer + "/" + strconv.FormatInt(start.Unix(), 10)
+}
+
+// QuotaUsed returns the requests of the consumer counted in the quota
+// window starting at start, across all members.
+func QuotaUsed(plan, consumer string, start time.Time) int64 {
+	s, err := QuotaStore()
+	if err != nil {
+		return 0
+	}
+	value, ok, err := s.Get(QuotaKey(plan, consumer, start))
+	if err != nil || !ok {
+		return 0
+	}
+	used, _ := strconv.ParseInt(value, 10, 64)
+	return used
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package apiproduct
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// KindPlan is the kind of Plan.
+	KindPlan = "Plan"
+)
+
+func init() {
+	supervisor.Register(&Plan{})
+}
+
+type (
+	// Plan is a tier of API products, e.g. free, pro and enterprise.
+	Plan struct {
+		superSpec *supervisor.Spec
+		spec      *PlanSpec
+	}
+
+	// PlanSpec describes the Plan.
+	PlanSpec struct {
+		Description string `yaml:"description" jsonschema:"omitempty"`
+		// Products are the names of the APIProducts in the plan.
+		Products  []string   `yaml:"products" jsonschema:"required,minItems=1,uniqueItems=true"`
+		RateLimit *RateLimit `yaml:"rateLimit" jsonschema:"omitempty"`
+		Quota     *Quota     `yaml:"quota" jsonschema:"omitempty"`
+		// Features are passed to backends, so they could enable
+		// features by the plan.
+		Features []string `yaml:"features" jsonschema:"omitempty,uniqueItems=true"`
+		// Consumers are subscribed to the plan statically, they could
+		// be overridden by subscriptions made with the admin API.
+		Consumers []string `yaml:"consumers" jsonschema:"omitempty,uniqueItems=true"`
+	}
+
+	// RateLimit limits the requests of every consumer on every member,
+	// at most LimitForPeriod requests are permitted in every
+	// LimitRefreshPeriod.
+	RateLimit struct {
+		LimitRefreshPeriod string `yaml:"limitRefreshPeriod" jsonschema:"required,format=duration"`
+		LimitForPeriod     int    `yaml:"limitForPeriod" jsonschema:"required,minimum=1"`
+	}
+
+	// Quota limits the requests of every consumer in every Period
+	// across all members, periods are aligned to the unix epoch.
+	Quota struct {
+		Requests int64  `yaml:"requests" jsonschema:"required,minimum=1"`
+		Period   string `yaml:"period" jsonschema:"required,format=duration"`
+	}
+
+	// PlanStatus is the status of Plan.
+	PlanStatus struct {
+		Subscribers int `yaml:"subscribers"`
+	}
+)
+
+// Validate validates PlanSpec.
+func (spec PlanSpec) Validate() error {
+	if spec.RateLimit != nil {
+		d, err := time.ParseDuration(spec.RateLimit.LimitRefreshPeriod)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid limitRefreshPeriod %s", spec.RateLimit.LimitRefreshPeriod)
+		}
+	}
+	if spec.Quota != nil {
+		d, err := time.ParseDuration(spec.Quota.Period)
+		if err != nil || d < time.Second {
+			return fmt.Errorf("invalid quota period %s", spec.Quota.Period)
+		}
+	}
+	return nil
+}
+
+// References returns the names of the products in the plan.
+func (spec *PlanSpec) References() []string {
+	return spec.Products
+}
+
+// HasProduct returns whether the product is in the plan.
+func (spec *PlanSpec) HasProduct(product string) bool {
+	for _, p := range spec.Products {
+		if p == product {
+			return true
+		}
+	}
+	return false
+}
+
+// Category returns the category of Plan.
+func (p *Plan) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+
+// Kind returns the kind of Plan.
+func (p *Plan) Kind() string {
+	return KindPlan
+}
+
+// DefaultSpec returns the default spec of Plan.
+func (p *Plan) DefaultSpec() interface{} {
+	return &PlanSpec{}
+}
+
+// Init initializes Plan.
+func (p *Plan) Init(superSpec *supervisor.Spec) {
+	p.superSpec, p.spec = superSpec, superSpec.ObjectSpec().(*PlanSpec)
+	initStore(superSpec.Super())
+	registerPlan(p.superSpec.Name(), p.spec)
+}
+
+// Inherit inherits previous generation of Plan.
+func (p *Plan) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	// NOTE: Registering the new generation replaces the previous one.
+	p.Init(superSpec)
+}
+
+// Status returns the status of Plan.
+func (p *Plan) Status() *supervisor.Status {
+	return &supervisor.Status{
+		ObjectStatus: &PlanStatus{Subscribers: len(subscribersOfPlan(p.superSpec.Name()))},
+	}
+}
+
+// Close closes Plan.
+func (p *Plan) Close() {
+	unregisterPlan(p.superSpec.Name(), p.spec)
+}
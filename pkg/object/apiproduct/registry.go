@@ -0,0 +1,276 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package apiproduct
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/sharedstate"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// storeNamespace is the namespace of the key-value store, '@' is not
+	// allowed in object names, so it never conflicts with pipelines.
+	storeNamespace     = "@apiproduct"
+	subscriptionPrefix = "subscriptions/"
+)
+
+var (
+	mutex    sync.RWMutex
+	products = make(map[string]*ProductSpec)
+	plans    = make(map[string]*PlanSpec)
+
+	storeMutex sync.Mutex
+	store      *cluster.KVStore
+	storeCls   cluster.Cluster
+)
+
+type (
+	// Subscription is the plan a consumer subscribes to.
+	Subscription struct {
+		Consumer string `yaml:"consumer"`
+		Plan     string `yaml:"plan"`
+		// Static is true if the subscription is in the spec of the plan.
+		Static bool `yaml:"static"`
+	}
+)
+
+// initStore creates the key-value store shared by all members,
+// which holds the subscriptions. The store lives as long as the
+// process, as subscriptions outlive objects.
+func initStore(super *supervisor.Supervisor) {
+	registerOnce.Do(registerAPIs)
+
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	if store != nil || super == nil || super.Cluster() == nil {
+		return
+	}
+
+	s, err := cluster.NewKVStore(super.Cluster(), storeNamespace)
+	if err != nil {
+		logger.Errorf("create key-value store of api products failed: %v", err)
+		return
+	}
+	store, storeCls = s, super.Cluster()
+}
+
+// Store returns the key-value store shared by all members.
+func Store() (*cluster.KVStore, error) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	if store == nil {
+		return nil, fmt.Errorf("key-value store of api products is unavailable")
+	}
+	return store, nil
+}
+
+// QuotaStore returns the shared state holding the quota counters.
+func QuotaStore() (sharedstate.Store, error) {
+	storeMutex.Lock()
+	cls := storeCls
+	storeMutex.Unlock()
+
+	return sharedstate.Namespace(cls, storeNamespace)
+}
+
+func registerProduct(name string, spec *ProductSpec) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	products[name] = spec
+}
+
+func unregisterProduct(name string, spec *ProductSpec) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// NOTE: The next generation may have registered itself.
+	if products[name] == spec {
+		delete(products, name)
+	}
+}
+
+func registerPlan(name string, spec *PlanSpec) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	plans[name] = spec
+}
+
+func unregisterPlan(name string, spec *PlanSpec) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if plans[name] == spec {
+		delete(plans, name)
+	}
+}
+
+// MatchProduct returns the name of the product the request belongs to,
+// the product with the longest matching path prefix wins. It returns an
+// empty string if the request doesn't belong to any product.
+func MatchProduct(method, path string) string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	product, longest := "", -1
+	for name, spec := range products {
+		l := spec.matchLen(method, path)
+		if l > longest || (l == longest && l >= 0 && name < product) {
+			product, longest = name, l
+		}
+	}
+	return product
+}
+
+// SubscribedPlan returns the name and the spec of the plan the consumer
+// subscribes to, the name is empty if the consumer subscribes to none.
+func SubscribedPlan(consumer string) (string, *PlanSpec) {
+	if consumer == "" {
+		return "", nil
+	}
+
+	name := ""
+	if s, err := Store(); err == nil {
+		name, _ = s.Get(subscriptionPrefix + consumer)
+	}
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if name != "" {
+		if spec := plans[name]; spec != nil {
+			return name, spec
+		}
+		return "", nil
+	}
+
+	for _, name := range sortedPlanNames() {
+		spec := plans[name]
+		for _, c := range spec.Consumers {
+			if c == consumer {
+				return name, spec
+			}
+		}
+	}
+	return "", nil
+}
+
+// Subscribe subscribes the consumer to the plan.
+func Subscribe(consumer, plan string) error {
+	mutex.RLock()
+	_, exists := plans[plan]
+	mutex.RUnlock()
+	if !exists {
+		return fmt.Errorf("plan %s not found", plan)
+	}
+
+	s, err := Store()
+	if err != nil {
+		return err
+	}
+	return s.Put(subscriptionPrefix+consumer, plan, 0)
+}
+
+// Unsubscribe removes the subscription of the consumer made by Subscribe.
+func Unsubscribe(consumer string) error {
+	s, err := Store()
+	if err != nil {
+		return err
+	}
+	return s.Delete(subscriptionPrefix + consumer)
+}
+
+// Subscriptions returns all subscriptions sorted by the consumer.
+func Subscriptions() []*Subscription {
+	subscriptions := map[string]*Subscription{}
+
+	mutex.RLock()
+	// NOTE: Iterate in reverse order, so the first plan wins
+	// as it does in SubscribedPlan.
+	names := sortedPlanNames()
+	for i := len(names) - 1; i >= 0; i-- {
+		for _, c := range plans[names[i]].Consumers {
+			subscriptions[c] = &Subscription{Consumer: c, Plan: names[i], Static: true}
+		}
+	}
+	mutex.RUnlock()
+
+	if s, err := Store(); err == nil {
+		for _, key := range s.Keys() {
+			if !strings.HasPrefix(key, subscriptionPrefix) {
+				continue
+			}
+			plan, ok := s.Get(key)
+			if !ok {
+				continue
+			}
+			c := strings.TrimPrefix(key, subscriptionPrefix)
+			subscriptions[c] = &Subscription{Consumer: c, Plan: plan}
+		}
+	}
+
+	result := make([]*Subscription, 0, len(subscriptions))
+	for _, s := range subscriptions {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Consumer < result[j].Consumer })
+
+	return result
+}
+
+// sortedPlanNames must be called with mutex held.
+func sortedPlanNames() []string {
+	names := make([]string, 0, len(plans))
+	for name := range plans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func plansOfProduct(product string) []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	result := []string{}
+	for _, name := range sortedPlanNames() {
+		if plans[name].HasProduct(product) {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func subscribersOfPlan(plan string) []string {
+	result := []string{}
+	for _, s := range Subscriptions() {
+		if s.Plan == plan {
+			result = append(result, s.Consumer)
+		}
+	}
+	return result
+}
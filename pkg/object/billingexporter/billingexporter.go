@@ -0,0 +1,419 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package billingexporter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/filter/audittrail"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/apiproduct"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Kind is the kind of BillingExporter.
+	Kind = "BillingExporter"
+
+	// exportDelay is the delay of exporting a period after it ends,
+	// for the records of requests finished at the end of the period.
+	exportDelay   = 10 * time.Second
+	checkInterval = 10 * time.Second
+)
+
+func init() {
+	supervisor.Register(&BillingExporter{})
+}
+
+type (
+	// BillingExporter exports the usage of consumers periodically
+	// from an AuditTrail filter to a sink.
+	BillingExporter struct {
+		super     *supervisor.Supervisor
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		member    string
+		stateFile string
+		sink      sink
+
+		// *state
+		state atomic.Value
+		// string
+		lastErr atomic.Value
+
+		done chan struct{}
+	}
+
+	// Spec describes the BillingExporter.
+	Spec struct {
+		// Pipeline and Filter locate the AuditTrail filter.
+		Pipeline string `yaml:"pipeline" jsonschema:"required"`
+		Filter   string `yaml:"filter" jsonschema:"required"`
+		// Interval is the period of usage records.
+		Interval string `yaml:"interval" jsonschema:"required,format=duration"`
+		// Products are matched against requests in order, the APIProduct
+		// objects are used instead if it's empty.
+		Products []*Product `yaml:"products" jsonschema:"omitempty"`
+
+		Webhook *WebhookSpec `yaml:"webhook" jsonschema:"omitempty"`
+		Kafka   *KafkaSpec   `yaml:"kafka" jsonschema:"omitempty"`
+		S3      *S3Spec      `yaml:"s3" jsonschema:"omitempty"`
+	}
+
+	// Product is an API product, requests are billed to the first
+	// product matching their path and method.
+	Product struct {
+		Name         string   `yaml:"name" jsonschema:"required"`
+		PathPrefixes []string `yaml:"pathPrefixes" jsonschema:"required,minItems=1"`
+		Methods      []string `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+	}
+
+	// Status is the status of BillingExporter.
+	Status struct {
+		Member    string    `yaml:"member"`
+		Sequence  uint64    `yaml:"sequence"`
+		PeriodEnd time.Time `yaml:"periodEnd"`
+		LastError string    `yaml:"lastError,omitempty"`
+	}
+
+	// state is the progress of exporting, which is saved
+	// in the data directory to survive restarts.
+	state struct {
+		// Sequence is the sequence of the last exported batch.
+		Sequence uint64 `yaml:"sequence"`
+		// PeriodEnd is the end of the last exported period.
+		PeriodEnd time.Time `yaml:"periodEnd"`
+	}
+
+	// Usage is the usage of a consumer on a product in a period.
+	Usage struct {
+		Consumer      string `yaml:"consumer" json:"consumer"`
+		Product       string `yaml:"product" json:"product"`
+		Requests      uint64 `yaml:"requests" json:"requests"`
+		RequestBytes  uint64 `yaml:"requestBytes" json:"requestBytes"`
+		ResponseBytes uint64 `yaml:"responseBytes" json:"responseBytes"`
+	}
+
+	// Batch is the usage of a period. Batches of a member are numbered
+	// by Sequence without gaps, and a batch is sent again with the same
+	// BatchID and Sequence until it succeeds, so the receiver could
+	// deduplicate batches and detect missing ones.
+	Batch struct {
+		BatchID     string    `yaml:"batchId" json:"batchId"`
+		Exporter    string    `yaml:"exporter" json:"exporter"`
+		Member      string    `yaml:"member" json:"member"`
+		Sequence    uint64    `yaml:"sequence" json:"sequence"`
+		PeriodStart time.Time `yaml:"periodStart" json:"periodStart"`
+		PeriodEnd   time.Time `yaml:"periodEnd" json:"periodEnd"`
+		Usages      []*Usage  `yaml:"usages" json:"usages"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	interval, err := time.ParseDuration(spec.Interval)
+	if err != nil {
+		return fmt.Errorf("invalid interval: %v", err)
+	}
+	if interval < time.Minute {
+		return fmt.Errorf("interval must be at least 1m")
+	}
+
+	sinks := 0
+	for _, configured := range []bool{spec.Webhook != nil, spec.Kafka != nil, spec.S3 != nil} {
+		if configured {
+			sinks++
+		}
+	}
+	if sinks != 1 {
+		return fmt.Errorf("exactly one of webhook, kafka and s3 is required")
+	}
+
+	names := map[string]bool{}
+	for _, p := range spec.Products {
+		if names[p.Name] {
+			return fmt.Errorf("duplicated product %s", p.Name)
+		}
+		names[p.Name] = true
+	}
+
+	return nil
+}
+
+func (p *Product) match(r *audittrail.Record) bool {
+	if len(p.Methods) > 0 {
+		matched := false
+		for _, m := range p.Methods {
+			if m == r.Method {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	for _, prefix := range p.PathPrefixes {
+		if strings.HasPrefix(r.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Category returns the category of BillingExporter.
+func (be *BillingExporter) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+
+// Kind returns the kind of BillingExporter.
+func (be *BillingExporter) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of BillingExporter.
+func (be *BillingExporter) DefaultSpec() interface{} {
+	return &Spec{
+		Interval: "1h",
+	}
+}
+
+// Init initializes BillingExporter.
+func (be *BillingExporter) Init(superSpec *supervisor.Spec) {
+	be.superSpec, be.spec, be.super = superSpec, superSpec.ObjectSpec().(*Spec), superSpec.Super()
+	be.reload()
+}
+
+// Inherit inherits previous generation of BillingExporter.
+func (be *BillingExporter) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	previousGeneration.Close()
+	be.Init(superSpec)
+}
+
+func (be *BillingExporter) reload() {
+	be.member = be.super.Options().Name
+	be.stateFile = filepath.Join(be.super.Options().AbsDataDir, "billingexporter", be.superSpec.Name()+".yaml")
+	be.sink = newSink(be.spec)
+	be.done = make(chan struct{})
+
+	be.state.Store(be.loadState())
+
+	go be.run()
+}
+
+func (be *BillingExporter) interval() time.Duration {
+	interval, _ := time.ParseDuration(be.spec.Interval)
+	return interval
+}
+
+func (be *BillingExporter) loadState() *state {
+	s := &state{}
+	buff, err := ioutil.ReadFile(be.stateFile)
+	if err == nil {
+		err = yaml.Unmarshal(buff, s)
+	}
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Errorf("%s load state from %s failed: %v", be.superSpec.Name(), be.stateFile, err)
+		}
+		// NOTE: Start from the current period, the past is never exported.
+		s.PeriodEnd = time.Now().Truncate(be.interval())
+	}
+	return s
+}
+
+func (be *BillingExporter) getState() *state {
+	return be.state.Load().(*state)
+}
+
+func (be *BillingExporter) saveState(s *state) error {
+	buff, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("marshal %#v to yaml failed: %v", s, err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(be.stateFile), 0o750)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: Write to a temporary file then rename it,
+	// so that the state is never corrupted.
+	tmpFile := be.stateFile + ".tmp"
+	err = ioutil.WriteFile(tmpFile, buff, 0o640)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, be.stateFile)
+}
+
+func (be *BillingExporter) run() {
+	for {
+		be.exportDue(time.Now())
+
+		select {
+		case <-be.done:
+			return
+		case <-time.After(checkInterval):
+		}
+	}
+}
+
+// exportDue exports all ended periods in order, it stops at the first
+// failure and the period will be retried next time.
+func (be *BillingExporter) exportDue(now time.Time) {
+	interval := be.interval()
+	for {
+		select {
+		case <-be.done:
+			return
+		default:
+		}
+
+		start := be.getState().PeriodEnd
+		end := start.Add(interval)
+		if end.Add(exportDelay).After(now) {
+			return
+		}
+
+		next, err := be.export(start, end)
+		if err != nil {
+			logger.Errorf("%s export period [%s, %s) failed: %v",
+				be.superSpec.Name(), start.Format(time.RFC3339), end.Format(time.RFC3339), err)
+			be.lastErr.Store(err.Error())
+			return
+		}
+
+		err = be.saveState(next)
+		if err != nil {
+			// NOTE: The batch may be exported again after restart,
+			// and the receiver could deduplicate it by the batch id.
+			logger.Errorf("%s save state to %s failed: %v", be.superSpec.Name(), be.stateFile, err)
+		}
+		be.state.Store(next)
+		be.lastErr.Store("")
+	}
+}
+
+// export exports the usage of the period, and returns the next state.
+func (be *BillingExporter) export(start, end time.Time) (*state, error) {
+	usages, err := be.usages(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	next := &state{Sequence: be.getState().Sequence, PeriodEnd: end}
+	// NOTE: Empty periods are skipped without consuming a sequence,
+	// so that the receiver sees no gaps.
+	if len(usages) == 0 {
+		return next, nil
+	}
+
+	next.Sequence++
+	batch := &Batch{
+		BatchID:     fmt.Sprintf("%s/%s/%d", be.superSpec.Name(), be.member, start.Unix()),
+		Exporter:    be.superSpec.Name(),
+		Member:      be.member,
+		Sequence:    next.Sequence,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Usages:      usages,
+	}
+
+	err = be.sink.send(batch)
+	if err != nil {
+		return nil, err
+	}
+
+	return next, nil
+}
+
+func (be *BillingExporter) usages(start, end time.Time) ([]*Usage, error) {
+	usages := map[[2]string]*Usage{}
+	q := &audittrail.Query{Since: start, Until: end}
+	err := audittrail.Walk(be.spec.Pipeline, be.spec.Filter, q, func(r *audittrail.Record) bool {
+		product := ""
+		for _, p := range be.spec.Products {
+			if p.match(r) {
+				product = p.Name
+				break
+			}
+		}
+		if len(be.spec.Products) == 0 {
+			product = apiproduct.MatchProduct(r.Method, r.Path)
+		}
+
+		key := [2]string{r.Consumer, product}
+		u := usages[key]
+		if u == nil {
+			u = &Usage{Consumer: r.Consumer, Product: product}
+			usages[key] = u
+		}
+		u.Requests++
+		u.RequestBytes += r.RequestBytes
+		u.ResponseBytes += r.ResponseBytes
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Usage, 0, len(usages))
+	for _, u := range usages {
+		result = append(result, u)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Consumer != result[j].Consumer {
+			return result[i].Consumer < result[j].Consumer
+		}
+		return result[i].Product < result[j].Product
+	})
+
+	return result, nil
+}
+
+// Status returns the status of BillingExporter.
+func (be *BillingExporter) Status() *supervisor.Status {
+	state := be.getState()
+	s := &Status{
+		Member:    be.member,
+		Sequence:  state.Sequence,
+		PeriodEnd: state.PeriodEnd,
+	}
+	if lastErr, ok := be.lastErr.Load().(string); ok {
+		s.LastError = lastErr
+	}
+
+	return &supervisor.Status{ObjectStatus: s}
+}
+
+// Close closes BillingExporter.
+func (be *BillingExporter) Close() {
+	close(be.done)
+	be.sink.close()
+}
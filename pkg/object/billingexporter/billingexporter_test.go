@@ -0,0 +1,246 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package billingexporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/filter/audittrail"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newAuditTrail(t *testing.T, dir string) *audittrail.AuditTrail {
+	yamlSpec := fmt.Sprintf(`
+kind: AuditTrail
+name: audit
+consumer:
+  source: Header
+  name: X-Consumer
+dir: %s
+`, dir)
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	a := &audittrail.AuditTrail{}
+	a.Init(spec)
+	return a
+}
+
+func handleRequest(a *audittrail.AuditTrail, consumer, path string) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("X-Consumer", consumer)
+
+	ctx := contexttest.NewMockedHTTPContext(req, httptest.NewRecorder())
+	ctx.MockedRequest.MockedSize = func() uint64 { return 10 }
+	ctx.MockedResponse.MockedSize = func() uint64 { return 100 }
+
+	a.Handle(ctx)
+	ctx.Finish()
+}
+
+func newBillingExporter(t *testing.T, dir, sinkSpec string) *BillingExporter {
+	superSpec, err := supervisor.NewSpec(`
+kind: BillingExporter
+name: billing
+pipeline: ""
+filter: audit
+interval: 1h
+products:
+- name: orders
+  pathPrefixes: [/orders]
+` + sinkSpec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	be := &BillingExporter{
+		superSpec: superSpec,
+		spec:      superSpec.ObjectSpec().(*Spec),
+		member:    "member-1",
+		stateFile: filepath.Join(dir, "billing.yaml"),
+		done:      make(chan struct{}),
+	}
+	be.sink = newSink(be.spec)
+	return be
+}
+
+func TestWebhookExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "billingexporter")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAuditTrail(t, dir)
+	defer a.Close()
+	handleRequest(a, "alice", "/orders/1")
+	handleRequest(a, "alice", "/orders/2")
+	handleRequest(a, "alice", "/users/1")
+	handleRequest(a, "bob", "/orders/1")
+
+	fail := true
+	var batches []*Batch
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		batch := &Batch{}
+		json.NewDecoder(r.Body).Decode(batch)
+		batches = append(batches, batch)
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+	}))
+	defer server.Close()
+
+	be := newBillingExporter(t, dir, "webhook:\n  url: "+server.URL)
+	defer be.Close()
+	start := time.Now().Add(-30 * time.Minute)
+	be.state.Store(&state{PeriodEnd: start})
+
+	be.exportDue(time.Now().Add(time.Hour))
+	if be.getState().PeriodEnd != start {
+		t.Fatalf("state should not advance on failure")
+	}
+	if be.Status().ObjectStatus.(*Status).LastError == "" {
+		t.Errorf("last error should be set")
+	}
+
+	fail = false
+	be.exportDue(time.Now().Add(time.Hour))
+	s := be.getState()
+	if s.Sequence != 1 || !s.PeriodEnd.Equal(start.Add(time.Hour)) {
+		t.Fatalf("unexpected state %+v", s)
+	}
+	if len(batches) != 1 {
+		t.Fatalf("want 1 batch, got %d", len(batches))
+	}
+
+	batch := batches[0]
+	if batch.BatchID != keys[0] || batch.Sequence != 1 || batch.Member != "member-1" {
+		t.Errorf("unexpected batch %+v", batch)
+	}
+	expected := []Usage{
+		{Consumer: "alice", Product: "", Requests: 1, RequestBytes: 10, ResponseBytes: 100},
+		{Consumer: "alice", Product: "orders", Requests: 2, RequestBytes: 20, ResponseBytes: 200},
+		{Consumer: "bob", Product: "orders", Requests: 1, RequestBytes: 10, ResponseBytes: 100},
+	}
+	if len(batch.Usages) != len(expected) {
+		t.Fatalf("want %d usages, got %d", len(expected), len(batch.Usages))
+	}
+	for i, u := range batch.Usages {
+		if *u != expected[i] {
+			t.Errorf("want usage %+v, got %+v", expected[i], *u)
+		}
+	}
+
+	// The state survives restarts.
+	loaded := be.loadState()
+	if loaded.Sequence != 1 || !loaded.PeriodEnd.Equal(s.PeriodEnd) {
+		t.Errorf("unexpected loaded state %+v", loaded)
+	}
+
+	// Empty periods don't consume sequences.
+	be.exportDue(time.Now().Add(3 * time.Hour))
+	if s := be.getState(); s.Sequence != 1 || len(batches) != 1 {
+		t.Errorf("empty periods should be skipped, state %+v", s)
+	}
+}
+
+func TestS3Sink(t *testing.T) {
+	var path, auth, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		buff, _ := ioutil.ReadAll(r.Body)
+		body = string(buff)
+	}))
+	defer server.Close()
+
+	sink := newSink(&Spec{S3: &S3Spec{
+		Endpoint:        server.URL,
+		Region:          "us-east-1",
+		Bucket:          "billing",
+		Prefix:          "usage/",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "SECRET",
+	}})
+
+	start := time.Unix(1625097600, 0).UTC()
+	err := sink.send(&Batch{
+		BatchID:     "billing/member-1/1625097600",
+		Exporter:    "billing",
+		Member:      "member-1",
+		Sequence:    7,
+		PeriodStart: start,
+		PeriodEnd:   start.Add(time.Hour),
+		Usages:      []*Usage{{Consumer: "alice", Product: "orders", Requests: 2}},
+	})
+	if err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	if path != "/billing/usage/billing-member-1-1625097600.csv" {
+		t.Errorf("unexpected path %s", path)
+	}
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+		!strings.Contains(auth, "/us-east-1/s3/aws4_request") {
+		t.Errorf("unexpected authorization %s", auth)
+	}
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) != 2 || lines[1] != "billing/member-1/1625097600,billing,member-1,7,2021-07-01T00:00:00Z,2021-07-01T01:00:00Z,alice,orders,2,0,0" {
+		t.Errorf("unexpected body %s", body)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, s := range []string{
+		"interval: 30s\nwebhook: {url: 'http://localhost'}",
+		"interval: 1h",
+		"interval: 1h\nwebhook: {url: 'http://localhost'}\nkafka: {brokers: [localhost:9092], topic: usage}",
+		"interval: 1h\nwebhook: {url: 'http://localhost'}\nproducts: [{name: a, pathPrefixes: [/a]}, {name: a, pathPrefixes: [/b]}]",
+	} {
+		spec := &Spec{}
+		yamltool.Unmarshal([]byte(s), spec)
+		if spec.Validate() == nil {
+			t.Errorf("%q should be invalid", s)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package billingexporter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/megaease/easegress/pkg/util/signer"
+)
+
+const sinkTimeout = 30 * time.Second
+
+type (
+	// WebhookSpec is the spec of the webhook sink, batches are posted
+	// to it in JSON with the batch id in the Idempotency-Key header.
+	WebhookSpec struct {
+		URL     string            `yaml:"url" jsonschema:"required,format=url"`
+		Headers map[string]string `yaml:"headers" jsonschema:"omitempty"`
+	}
+
+	// KafkaSpec is the spec of the Kafka sink, every usage is a message
+	// keyed by the batch id.
+	KafkaSpec struct {
+		Brokers []string `yaml:"brokers" jsonschema:"required,uniqueItems=true"`
+		Topic   string   `yaml:"topic" jsonschema:"required"`
+	}
+
+	// S3Spec is the spec of the S3 sink, every batch is a CSV object
+	// named by the batch id, so retries overwrite the same object.
+	S3Spec struct {
+		// Endpoint is the endpoint of S3 or S3 compatible services,
+		// e.g. https://s3.us-east-1.amazonaws.com.
+		Endpoint        string `yaml:"endpoint" jsonschema:"required,format=url"`
+		Region          string `yaml:"region" jsonschema:"required"`
+		Bucket          string `yaml:"bucket" jsonschema:"required"`
+		Prefix          string `yaml:"prefix" jsonschema:"omitempty"`
+		AccessKeyID     string `yaml:"accessKeyId" jsonschema:"required"`
+		SecretAccessKey string `yaml:"secretAccessKey" jsonschema:"required"`
+	}
+
+	// UsageRecord is a usage with the sequencing metadata of its batch,
+	// it's the message of the Kafka sink.
+	UsageRecord struct {
+		BatchID     string    `json:"batchId"`
+		Exporter    string    `json:"exporter"`
+		Member      string    `json:"member"`
+		Sequence    uint64    `json:"sequence"`
+		Index       int       `json:"index"`
+		Total       int       `json:"total"`
+		PeriodStart time.Time `json:"periodStart"`
+		PeriodEnd   time.Time `json:"periodEnd"`
+		*Usage
+	}
+
+	sink interface {
+		send(batch *Batch) error
+		close()
+	}
+
+	webhookSink struct {
+		spec   *WebhookSpec
+		client *http.Client
+	}
+
+	kafkaSink struct {
+		spec *KafkaSpec
+		name string
+
+		mutex    sync.Mutex
+		producer sarama.SyncProducer
+	}
+
+	s3Sink struct {
+		spec   *S3Spec
+		client *http.Client
+		signer *signer.Signer
+	}
+)
+
+var s3Literal = &signer.Literal{
+	ScopeSuffix:      "aws4_request",
+	AlgorithmName:    "X-Amz-Algorithm",
+	AlgorithmValue:   "AWS4-HMAC-SHA256",
+	SignedHeaders:    "X-Amz-SignedHeaders",
+	Signature:        "X-Amz-Signature",
+	Date:             "X-Amz-Date",
+	Expires:          "X-Amz-Expires",
+	Credential:       "X-Amz-Credential",
+	ContentSHA256:    "X-Amz-Content-Sha256",
+	SigningKeyPrefix: "AWS4",
+}
+
+var csvHeader = []string{
+	"batchId", "exporter", "member", "sequence", "periodStart", "periodEnd",
+	"consumer", "product", "requests", "requestBytes", "responseBytes",
+}
+
+func newSink(spec *Spec) sink {
+	switch {
+	case spec.Webhook != nil:
+		return &webhookSink{spec: spec.Webhook, client: &http.Client{Timeout: sinkTimeout}}
+	case spec.Kafka != nil:
+		return &kafkaSink{spec: spec.Kafka}
+	default:
+		return &s3Sink{
+			spec:   spec.S3,
+			client: &http.Client{Timeout: sinkTimeout},
+			signer: signer.New().SetLiteral(s3Literal).
+				SetCredential(spec.S3.AccessKeyID, spec.S3.SecretAccessKey),
+		}
+	}
+}
+
+// records flattens the batch to usage records.
+func (b *Batch) records() []*UsageRecord {
+	records := make([]*UsageRecord, len(b.Usages))
+	for i, u := range b.Usages {
+		records[i] = &UsageRecord{
+			BatchID:     b.BatchID,
+			Exporter:    b.Exporter,
+			Member:      b.Member,
+			Sequence:    b.Sequence,
+			Index:       i,
+			Total:       len(b.Usages),
+			PeriodStart: b.PeriodStart,
+			PeriodEnd:   b.PeriodEnd,
+			Usage:       u,
+		}
+	}
+	return records
+}
+
+// csv encodes the batch to CSV with a header line.
+func (b *Batch) csv() []byte {
+	buff := bytes.NewBuffer(nil)
+	w := csv.NewWriter(buff)
+	w.Write(csvHeader)
+	for _, u := range b.Usages {
+		w.Write([]string{
+			b.BatchID, b.Exporter, b.Member, strconv.FormatUint(b.Sequence, 10),
+			b.PeriodStart.Format(time.RFC3339), b.PeriodEnd.Format(time.RFC3339),
+			u.Consumer, u.Product, strconv.FormatUint(u.Requests, 10),
+			strconv.FormatUint(u.RequestBytes, 10), strconv.FormatUint(u.ResponseBytes, 10),
+		})
+	}
+	w.Flush()
+	return buff.Bytes()
+}
+
+func checkResponse(resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+}
+
+func (s *webhookSink) send(batch *Batch) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("marshal batch to json failed: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.spec.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", batch.BatchID)
+	for k, v := range s.spec.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkResponse(resp)
+}
+
+func (s *webhookSink) close() {}
+
+func (s *kafkaSink) getProducer() (sarama.SyncProducer, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.producer != nil {
+		return s.producer, nil
+	}
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V0_10_2_0
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+
+	producer, err := sarama.NewSyncProducer(s.spec.Brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("start sarama producer failed(brokers: %v): %v", s.spec.Brokers, err)
+	}
+	s.producer = producer
+	return producer, nil
+}
+
+func (s *kafkaSink) send(batch *Batch) error {
+	producer, err := s.getProducer()
+	if err != nil {
+		return err
+	}
+
+	records := batch.records()
+	messages := make([]*sarama.ProducerMessage, len(records))
+	for i, r := range records {
+		value, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("marshal usage record to json failed: %v", err)
+		}
+		messages[i] = &sarama.ProducerMessage{
+			Topic: s.spec.Topic,
+			Key:   sarama.StringEncoder(batch.BatchID),
+			Value: sarama.ByteEncoder(value),
+		}
+	}
+
+	return producer.SendMessages(messages)
+}
+
+func (s *kafkaSink) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.producer != nil {
+		s.producer.Close()
+		s.producer = nil
+	}
+}
+
+// objectURL returns the path-style URL of the object of the batch.
+func (s *s3Sink) objectURL(batch *Batch) string {
+	key := s.spec.Prefix + strings.ReplaceAll(batch.BatchID, "/", "-") + ".csv"
+	return strings.TrimSuffix(s.spec.Endpoint, "/") + "/" + s.spec.Bucket + "/" + key
+}
+
+func (s *s3Sink) send(batch *Batch) error {
+	body := batch.csv()
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(batch), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256(body)
+	req.Header.Set("Content-Type", "text/csv")
+	req.Header.Set(s3Literal.ContentSHA256, hex.EncodeToString(hash[:]))
+	err = s.signer.NewContext(time.Now(), s.spec.Region, "s3").Sign(req)
+	if err != nil {
+		return fmt.Errorf("sign request failed: %v", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkResponse(resp)
+}
+
+func (s *s3Sink) close() {}
@@ -0,0 +1,257 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cron
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	yamljsontool "github.com/ghodss/yaml"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	defaultHTTPTimeout = 30 * time.Second
+
+	// maxMessageSize is the max size of the response body kept in the
+	// message of a run.
+	maxMessageSize = 256
+)
+
+type (
+	// HTTPAction calls an HTTP endpoint, the run succeeds if the status
+	// code is 2xx.
+	HTTPAction struct {
+		Method  string            `yaml:"method" jsonschema:"omitempty,format=httpmethod"`
+		URL     string            `yaml:"url" jsonschema:"required,format=uri"`
+		Headers map[string]string `yaml:"headers" jsonschema:"omitempty"`
+		Body    string            `yaml:"body" jsonschema:"omitempty"`
+		Timeout string            `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// ObjectAction mutates an object, it either applies the whole spec,
+	// or patches the spec of an existing object.
+	ObjectAction struct {
+		// Spec is the YAML spec of the object to create or replace.
+		Spec string `yaml:"spec" jsonschema:"omitempty"`
+		// Name and Patch are the object to patch and the JSON merge patch
+		// (RFC 7386) to the spec of it, e.g. {"rules": [...]}.
+		Name  string                 `yaml:"name" jsonschema:"omitempty"`
+		Patch map[string]interface{} `yaml:"patch" jsonschema:"omitempty"`
+	}
+)
+
+// Validate validates ObjectAction.
+func (oa ObjectAction) Validate() error {
+	if (oa.Spec == "") == (oa.Patch == nil) {
+		return fmt.Errorf("one and only one of spec and patch is required")
+	}
+	if oa.Patch != nil && oa.Name == "" {
+		return fmt.Errorf("name is required by patch")
+	}
+	if oa.Spec != "" && oa.Name != "" {
+		return fmt.Errorf("name is only used by patch")
+	}
+	if _, ok := oa.Patch["name"]; ok {
+		return fmt.Errorf("name can't be patched")
+	}
+	if _, ok := oa.Patch["kind"]; ok {
+		return fmt.Errorf("kind can't be patched")
+	}
+	return nil
+}
+
+func (ha *HTTPAction) do() (string, error) {
+	method := ha.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	timeout := defaultHTTPTimeout
+	if ha.Timeout != "" {
+		timeout, _ = time.ParseDuration(ha.Timeout)
+	}
+
+	req, err := http.NewRequest(method, ha.URL, strings.NewReader(ha.Body))
+	if err != nil {
+		return "", err
+	}
+	for k, v := range ha.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxMessageSize))
+	msg := strings.TrimSpace(strconv.Itoa(resp.StatusCode) + " " + string(body))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("%s", msg)
+	}
+	return msg, nil
+}
+
+func (oa *ObjectAction) do(cls cluster.Cluster) (string, error) {
+	if cls == nil {
+		return "", fmt.Errorf("cluster is unavailable")
+	}
+
+	var spec *supervisor.Spec
+	var err error
+	if oa.Spec != "" {
+		spec, err = supervisor.NewSpec(oa.Spec)
+	} else {
+		spec, err = oa.patch(cls)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	err = putObject(cls, spec)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s updated", spec.Kind(), spec.Name()), nil
+}
+
+// patch applies the JSON merge patch to the spec of the object.
+func (oa *ObjectAction) patch(cls cluster.Cluster) (*supervisor.Spec, error) {
+	value, err := cls.Get(cls.Layout().ConfigObjectKey(oa.Name))
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, fmt.Errorf("object %s not found", oa.Name)
+	}
+
+	buff, err := yamljsontool.YAMLToJSON([]byte(*value))
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	err = json.Unmarshal(buff, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: The patch is converted to JSON first, as the maps decoded
+	// from YAML are keyed by interface{}.
+	buff, err = json.Marshal(toJSONValue(oa.Patch))
+	if err != nil {
+		return nil, err
+	}
+	var patch interface{}
+	err = json.Unmarshal(buff, &patch)
+	if err != nil {
+		return nil, err
+	}
+
+	buff, err = json.Marshal(mergePatch(doc, patch))
+	if err != nil {
+		return nil, err
+	}
+	buff, err = yamljsontool.JSONToYAML(buff)
+	if err != nil {
+		return nil, err
+	}
+
+	return supervisor.NewSpec(string(buff))
+}
+
+// putObject puts the spec of the object and bumps the config version
+// atomically, like updating it by the admin API.
+func putObject(cls cluster.Cluster, spec *supervisor.Spec) error {
+	layout := cls.Layout()
+	value, err := cls.Get(layout.ConfigVersion())
+	if err != nil {
+		return err
+	}
+
+	version := int64(0)
+	if value != nil {
+		version, err = strconv.ParseInt(*value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse version %s to int failed: %v", *value, err)
+		}
+	}
+
+	config := spec.YAMLConfig()
+	nextVersion := strconv.FormatInt(version+1, 10)
+	return cls.PutAndDelete(map[string]*string{
+		layout.ConfigObjectKey(spec.Name()): &config,
+		layout.ConfigVersion():              &nextVersion,
+	})
+}
+
+// mergePatch applies the JSON merge patch to the document, see RFC 7386.
+func mergePatch(doc, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	docMap, ok := doc.(map[string]interface{})
+	if !ok {
+		docMap = map[string]interface{}{}
+	}
+	for k, v := range patchMap {
+		if v == nil {
+			delete(docMap, k)
+		} else {
+			docMap[k] = mergePatch(docMap[k], v)
+		}
+	}
+	return docMap
+}
+
+// toJSONValue converts the maps keyed by interface{} to the ones keyed by
+// string recursively.
+func toJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			m[fmt.Sprint(k)] = toJSONValue(child)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			m[k] = toJSONValue(child)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, child := range v {
+			s[i] = toJSONValue(child)
+		}
+		return s
+	default:
+		return v
+	}
+}
@@ -0,0 +1,388 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cron
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	cronlib "github.com/robfig/cron/v3"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Kind is the kind of Cron.
+	Kind = "Cron"
+
+	// storeNamespacePrefix is the prefix of the namespace of the key-value
+	// store, '@' is not allowed in object names, so it never conflicts
+	// with pipelines.
+	storeNamespacePrefix = "@cron/"
+
+	leaderKey        = "leader"
+	historyKeyPrefix = "history/"
+
+	// The leader renews its lease every leaseRenewInterval, and another
+	// member takes over if the lease isn't renewed in leaseTTL.
+	leaseTTL           = 15 * time.Second
+	leaseRenewInterval = 5 * time.Second
+
+	defaultMaxHistory = 20
+)
+
+func init() {
+	supervisor.Register(&Cron{})
+}
+
+type (
+	// Cron runs jobs at cron schedules, e.g. calls HTTP endpoints or
+	// mutates objects. The members of the cluster elect a leader, and only
+	// the leader runs the jobs, so a job runs once per schedule in the
+	// whole cluster.
+	Cron struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		cls    cluster.Cluster
+		store  *cluster.KVStore
+		member string
+
+		scheduler *cronlib.Cron
+		entries   map[string]cronlib.EntryID
+
+		mutex   sync.Mutex
+		leader  bool
+		history map[string][]*Run
+
+		done chan struct{}
+	}
+
+	// Spec describes the Cron.
+	Spec struct {
+		// TimeZone is the time zone of the schedules, like UTC or
+		// Asia/Shanghai, default is the local time zone.
+		TimeZone string `yaml:"timeZone" jsonschema:"omitempty"`
+		// MaxHistory is the number of the latest runs kept for every job.
+		MaxHistory int    `yaml:"maxHistory" jsonschema:"omitempty,minimum=1"`
+		Jobs       []*Job `yaml:"jobs" jsonschema:"required,minItems=1"`
+	}
+
+	// Job is a scheduled job.
+	Job struct {
+		Name string `yaml:"name" jsonschema:"required"`
+		// Schedule is a standard cron expression with 5 fields, or a
+		// descriptor like @hourly, @daily or @every 10m.
+		Schedule string `yaml:"schedule" jsonschema:"required"`
+		// Suspend stops scheduling the job but keeps its history.
+		Suspend bool `yaml:"suspend" jsonschema:"omitempty"`
+
+		HTTP   *HTTPAction   `yaml:"http,omitempty" jsonschema:"omitempty"`
+		Object *ObjectAction `yaml:"object,omitempty" jsonschema:"omitempty"`
+	}
+
+	// Run is a record of a run of a job.
+	Run struct {
+		Member    string    `yaml:"member" json:"member"`
+		StartedAt time.Time `yaml:"startedAt" json:"startedAt"`
+		Duration  string    `yaml:"duration" json:"duration"`
+		Succeeded bool      `yaml:"succeeded" json:"succeeded"`
+		Message   string    `yaml:"message,omitempty" json:"message,omitempty"`
+	}
+
+	// Status is the status of Cron.
+	Status struct {
+		Leader bool         `yaml:"leader"`
+		Jobs   []*JobStatus `yaml:"jobs"`
+	}
+
+	// JobStatus is the status of a job.
+	JobStatus struct {
+		Name    string     `yaml:"name"`
+		NextRun *time.Time `yaml:"nextRun,omitempty"`
+		// History are the latest runs of the job in the cluster, the
+		// latest first.
+		History []*Run `yaml:"history"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if _, err := time.LoadLocation(spec.TimeZone); err != nil {
+		return fmt.Errorf("invalid time zone %s: %v", spec.TimeZone, err)
+	}
+
+	names := map[string]bool{}
+	for _, j := range spec.Jobs {
+		if names[j.Name] {
+			return fmt.Errorf("job %s is defined more than once", j.Name)
+		}
+		names[j.Name] = true
+	}
+	return nil
+}
+
+// Validate validates Job.
+func (j Job) Validate() error {
+	if _, err := cronlib.ParseStandard(j.Schedule); err != nil {
+		return fmt.Errorf("job %s: invalid schedule %s: %v", j.Name, j.Schedule, err)
+	}
+	if (j.HTTP == nil) == (j.Object == nil) {
+		return fmt.Errorf("job %s: one and only one of http and object is required", j.Name)
+	}
+	return nil
+}
+
+// Category returns the category of Cron.
+func (c *Cron) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+
+// Kind returns the kind of Cron.
+func (c *Cron) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of Cron.
+func (c *Cron) DefaultSpec() interface{} {
+	return &Spec{
+		TimeZone:   "Local",
+		MaxHistory: defaultMaxHistory,
+	}
+}
+
+// Init initializes Cron.
+func (c *Cron) Init(superSpec *supervisor.Spec) {
+	c.superSpec, c.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	if super := superSpec.Super(); super != nil {
+		c.cls, c.member = super.Cluster(), super.Options().Name
+	}
+	c.reload(nil)
+}
+
+// Inherit inherits previous generation of Cron.
+func (c *Cron) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	prev := previousGeneration.(*Cron)
+	prev.stop()
+
+	// NOTE: The lease isn't released, the new generation renews it at once.
+	c.superSpec, c.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	c.cls, c.member = prev.cls, prev.member
+	c.reload(prev)
+}
+
+func (c *Cron) reload(prev *Cron) {
+	c.history = map[string][]*Run{}
+	if prev != nil {
+		c.store, c.leader = prev.store, prev.leader
+		for name, runs := range prev.history {
+			c.history[name] = runs
+		}
+	} else {
+		c.store = c.newStore()
+	}
+
+	location, _ := time.LoadLocation(c.spec.TimeZone)
+	c.scheduler = cronlib.New(
+		cronlib.WithLocation(location),
+		// NOTE: A run is skipped if the previous run of the job isn't
+		// finished, so slow jobs don't pile up.
+		cronlib.WithChain(cronlib.SkipIfStillRunning(cronlib.DiscardLogger)),
+	)
+	c.entries = map[string]cronlib.EntryID{}
+	for _, job := range c.spec.Jobs {
+		if job.Suspend {
+			continue
+		}
+		job := job
+		schedule, _ := cronlib.ParseStandard(job.Schedule)
+		c.entries[job.Name] = c.scheduler.Schedule(schedule, cronlib.FuncJob(func() {
+			if c.isLeader() {
+				c.runJob(job)
+			}
+		}))
+	}
+
+	c.done = make(chan struct{})
+	go c.run()
+	c.scheduler.Start()
+}
+
+func (c *Cron) newStore() *cluster.KVStore {
+	if c.cls == nil {
+		// NOTE: The member runs the jobs alone.
+		return nil
+	}
+
+	s, err := cluster.NewKVStore(c.cls, storeNamespacePrefix+c.superSpec.Name())
+	if err != nil {
+		logger.Errorf("create key-value store of cron %s failed: %v", c.superSpec.Name(), err)
+		return nil
+	}
+	return s
+}
+
+func (c *Cron) run() {
+	c.campaign()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(leaseRenewInterval):
+			c.campaign()
+		}
+	}
+}
+
+// campaign acquires or renews the lease of the leader.
+func (c *Cron) campaign() {
+	leader := true
+	if c.store != nil {
+		var err error
+		leader, err = c.store.Acquire(leaderKey, c.member, leaseTTL)
+		if err != nil {
+			// NOTE: The member steps down as it can't tell whether the
+			// lease is renewed, a run is missed at worst.
+			logger.Errorf("cron %s acquire leader lease failed: %v", c.superSpec.Name(), err)
+			leader = false
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if leader != c.leader {
+		logger.Infof("cron %s: member %s leader: %v", c.superSpec.Name(), c.member, leader)
+	}
+	c.leader = leader
+}
+
+func (c *Cron) isLeader() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.leader
+}
+
+func (c *Cron) runJob(job *Job) {
+	start := time.Now()
+	var msg string
+	var err error
+	if job.HTTP != nil {
+		msg, err = job.HTTP.do()
+	} else {
+		msg, err = job.Object.do(c.cls)
+	}
+
+	run := &Run{
+		Member:    c.member,
+		StartedAt: start,
+		Duration:  time.Since(start).String(),
+		Succeeded: err == nil,
+		Message:   msg,
+	}
+	if err != nil {
+		run.Message = err.Error()
+		logger.Errorf("cron %s run job %s failed: %v", c.superSpec.Name(), job.Name, err)
+	} else {
+		logger.Infof("cron %s run job %s: %s", c.superSpec.Name(), job.Name, msg)
+	}
+
+	c.record(job.Name, run)
+}
+
+// record adds the run to the history, the history is saved in the cluster,
+// so it's kept when the leader changes.
+func (c *Cron) record(job string, run *Run) {
+	c.mutex.Lock()
+	history := append([]*Run{run}, c.loadHistory(job)...)
+	if len(history) > c.spec.MaxHistory {
+		history = history[:c.spec.MaxHistory]
+	}
+	c.history[job] = history
+	c.mutex.Unlock()
+
+	if c.store == nil {
+		return
+	}
+	buff, err := json.Marshal(history)
+	if err != nil {
+		logger.Errorf("BUG: marshal %#v to json failed: %v", history, err)
+		return
+	}
+	err = c.store.Put(historyKeyPrefix+job, string(buff), 0)
+	if err != nil {
+		logger.Errorf("cron %s save history of job %s failed: %v", c.superSpec.Name(), job, err)
+	}
+}
+
+// loadHistory returns the history of the job, the one in the cluster is
+// preferred as it has the runs of other leaders.
+func (c *Cron) loadHistory(job string) []*Run {
+	if c.store != nil {
+		if value, ok := c.store.Get(historyKeyPrefix + job); ok {
+			history := []*Run{}
+			if err := json.Unmarshal([]byte(value), &history); err == nil {
+				return history
+			}
+		}
+	}
+	return c.history[job]
+}
+
+// Status returns the status of Cron.
+func (c *Cron) Status() *supervisor.Status {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s := &Status{Leader: c.leader}
+	for _, job := range c.spec.Jobs {
+		js := &JobStatus{Name: job.Name, History: c.loadHistory(job.Name)}
+		if id, ok := c.entries[job.Name]; ok {
+			next := c.scheduler.Entry(id).Next
+			js.NextRun = &next
+		}
+		s.Jobs = append(s.Jobs, js)
+	}
+	sort.Slice(s.Jobs, func(i, j int) bool { return s.Jobs[i].Name < s.Jobs[j].Name })
+
+	return &supervisor.Status{ObjectStatus: s}
+}
+
+func (c *Cron) stop() {
+	close(c.done)
+	// NOTE: It doesn't wait for the running jobs.
+	c.scheduler.Stop()
+}
+
+// Close closes Cron, the lease is released so another member takes over.
+func (c *Cron) Close() {
+	c.stop()
+	if c.store == nil {
+		return
+	}
+
+	if c.isLeader() {
+		if err := c.store.Delete(leaderKey); err != nil {
+			logger.Errorf("cron %s release leader lease failed: %v", c.superSpec.Name(), err)
+		}
+	}
+	c.store.Close()
+}
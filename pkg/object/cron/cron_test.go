@@ -0,0 +1,291 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cron
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/option"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newCluster(t *testing.T) cluster.Cluster {
+	opt := option.New()
+	opt.Name = "standalone-member"
+	opt.Standalone = true
+
+	cls, err := cluster.New(opt)
+	if err != nil {
+		t.Fatalf("new standalone cluster failed: %v", err)
+	}
+	t.Cleanup(func() {
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		cls.Close(wg)
+		wg.Wait()
+	})
+	return cls
+}
+
+func newCron(t *testing.T, yamlConfig string, cls cluster.Cluster, member string) *Cron {
+	spec, err := supervisor.NewSpec(yamlConfig)
+	if err != nil {
+		t.Fatalf("new spec failed: %v", err)
+	}
+
+	c := &Cron{cls: cls, member: member}
+	c.Init(spec)
+	return c
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("wait timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for i, yamlConfig := range []string{
+		"kind: Cron\nname: c\n",
+		"kind: Cron\nname: c\njobs:\n- name: a\n  schedule: '* * *'\n  http:\n    url: http://127.0.0.1/\n",
+		"kind: Cron\nname: c\njobs:\n- name: a\n  schedule: '@daily'\n",
+		"kind: Cron\nname: c\njobs:\n- name: a\n  schedule: '@daily'\n  http:\n    url: http://127.0.0.1/\n  object:\n    spec: 'kind: Cron'\n",
+		"kind: Cron\nname: c\njobs:\n- name: a\n  schedule: '@daily'\n  object:\n    patch: {maxHistory: 1}\n",
+		"kind: Cron\nname: c\njobs:\n- name: a\n  schedule: '@daily'\n  object:\n    name: x\n    patch: {name: y}\n",
+		"kind: Cron\nname: c\njobs:\n- name: a\n  schedule: '@daily'\n  http:\n    url: http://127.0.0.1/\n- name: a\n  schedule: '@daily'\n  http:\n    url: http://127.0.0.1/\n",
+		"kind: Cron\nname: c\ntimeZone: Mars/Base\njobs:\n- name: a\n  schedule: '@daily'\n  http:\n    url: http://127.0.0.1/\n",
+	} {
+		if _, err := supervisor.NewSpec(yamlConfig); err == nil {
+			t.Errorf("case %d: spec should be invalid", i)
+		}
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	// Examples of RFC 7386.
+	for i, c := range []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+	} {
+		var doc, patch, want interface{}
+		json.Unmarshal([]byte(c.doc), &doc)
+		json.Unmarshal([]byte(c.patch), &patch)
+		json.Unmarshal([]byte(c.want), &want)
+		if got := mergePatch(doc, patch); !reflect.DeepEqual(got, want) {
+			t.Errorf("case %d: want %v, got %v", i, want, got)
+		}
+	}
+}
+
+func TestHTTPJob(t *testing.T) {
+	var mutex sync.Mutex
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		calls++
+		mutex.Unlock()
+		if r.Method != http.MethodDelete || r.Header.Get("X-Token") != "abc" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("purged"))
+	}))
+	defer server.Close()
+
+	c := newCron(t, `
+kind: Cron
+name: purge-cache
+maxHistory: 2
+jobs:
+- name: purge
+  schedule: '@every 1s'
+  http:
+    method: DELETE
+    url: `+server.URL+`
+    headers: {X-Token: abc}
+- name: suspended
+  schedule: '@every 1s'
+  suspend: true
+  http:
+    url: `+server.URL+`
+`, nil, "")
+	defer c.Close()
+
+	waitFor(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return calls > 0
+	})
+
+	c.runJob(c.spec.Jobs[0])
+	c.runJob(c.spec.Jobs[0])
+
+	status := c.Status().ObjectStatus.(*Status)
+	if !status.Leader {
+		t.Errorf("standalone member should be the leader")
+	}
+	purge, suspended := status.Jobs[0], status.Jobs[1]
+	if purge.NextRun == nil || suspended.NextRun != nil {
+		t.Errorf("only the purge job should be scheduled")
+	}
+	if len(purge.History) != 2 {
+		t.Fatalf("history should be kept at 2, but got %d", len(purge.History))
+	}
+	if run := purge.History[0]; !run.Succeeded || run.Message != "200 purged" {
+		t.Errorf("unexpected run %+v", run)
+	}
+
+	c.runJob(c.spec.Jobs[1])
+	status = c.Status().ObjectStatus.(*Status)
+	if run := status.Jobs[1].History[0]; run.Succeeded || !strings.HasPrefix(run.Message, "400") {
+		t.Errorf("unexpected run %+v", run)
+	}
+}
+
+func TestObjectJob(t *testing.T) {
+	cls := newCluster(t)
+	target := "kind: Cron\nname: target\nmaxHistory: 5\njobs:\n- name: a\n  schedule: '@daily'\n  http:\n    url: http://127.0.0.1/\n"
+	if err := cls.Put(cls.Layout().ConfigObjectKey("target"), target); err != nil {
+		t.Fatalf("put object failed: %v", err)
+	}
+
+	c := newCron(t, `
+kind: Cron
+name: rotate
+jobs:
+- name: patch
+  schedule: '@yearly'
+  object:
+    name: target
+    patch:
+      maxHistory: 8
+      jobs:
+      - name: b
+        schedule: '@hourly'
+        http:
+          url: http://127.0.0.1/b
+- name: apply
+  schedule: '@yearly'
+  object:
+    spec: |
+      kind: Cron
+      name: created
+      jobs:
+      - name: a
+        schedule: '@daily'
+        http:
+          url: http://127.0.0.1/
+- name: invalid
+  schedule: '@yearly'
+  object:
+    name: target
+    patch:
+      maxHistory: 0
+`, cls, "member-a")
+	defer c.Close()
+
+	for _, job := range c.spec.Jobs {
+		c.runJob(job)
+	}
+
+	value, _ := cls.Get(cls.Layout().ConfigObjectKey("target"))
+	spec, err := supervisor.NewSpec(*value)
+	if err != nil {
+		t.Fatalf("patched spec is invalid: %v", err)
+	}
+	patched := spec.ObjectSpec().(*Spec)
+	if patched.MaxHistory != 8 || len(patched.Jobs) != 1 || patched.Jobs[0].Name != "b" {
+		t.Errorf("unexpected patched spec %s", *value)
+	}
+
+	if value, _ := cls.Get(cls.Layout().ConfigObjectKey("created")); value == nil {
+		t.Errorf("object should be created")
+	}
+	if value, _ := cls.Get(cls.Layout().ConfigVersion()); value == nil || *value != "2" {
+		t.Errorf("config version should be bumped to 2")
+	}
+
+	status := c.Status().ObjectStatus.(*Status)
+	for _, js := range status.Jobs {
+		if len(js.History) != 1 {
+			t.Fatalf("job %s should have a run", js.Name)
+		}
+		if run := js.History[0]; run.Member != "member-a" || run.Succeeded != (js.Name != "invalid") {
+			t.Errorf("unexpected run of %s: %+v", js.Name, run)
+		}
+	}
+}
+
+func TestLeader(t *testing.T) {
+	cls := newCluster(t)
+	yamlConfig := "kind: Cron\nname: c\njobs:\n- name: a\n  schedule: '@yearly'\n  http:\n    url: http://127.0.0.1/\n"
+
+	a := newCron(t, yamlConfig, cls, "member-a")
+	waitFor(t, a.isLeader)
+
+	b := newCron(t, yamlConfig, cls, "member-b")
+	defer b.Close()
+	b.campaign()
+	if b.isLeader() {
+		t.Fatalf("member-b should not be the leader")
+	}
+
+	// The new generation keeps the leadership.
+	spec, _ := supervisor.NewSpec(yamlConfig)
+	next := &Cron{}
+	next.Inherit(spec, a)
+	next.campaign()
+	if !next.isLeader() {
+		t.Fatalf("new generation of member-a should be the leader")
+	}
+
+	// Another member takes over after the leader closed.
+	next.Close()
+	b.campaign()
+	if !b.isLeader() {
+		t.Fatalf("member-b should be the leader")
+	}
+}
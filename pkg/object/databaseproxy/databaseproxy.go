@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package databaseproxy provides DatabaseProxy, which passes through the
+// connections of PostgreSQL or MySQL while governing them.
+package databaseproxy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Kind is the kind of DatabaseProxy.
+	Kind = "DatabaseProxy"
+
+	protocolPostgreSQL = "postgresql"
+	protocolMySQL      = "mysql"
+)
+
+var (
+	activeConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "easegress",
+		Subsystem: "databaseproxy",
+		Name:      "active_connections",
+		Help:      "The number of active connections of every database.",
+	}, []string{"proxy", "database"})
+
+	totalConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "easegress",
+		Subsystem: "databaseproxy",
+		Name:      "connections_total",
+		Help:      "The number of connections of every database.",
+	}, []string{"proxy", "database"})
+
+	rejectedConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "easegress",
+		Subsystem: "databaseproxy",
+		Name:      "rejected_connections_total",
+		Help:      "The number of connections rejected by the limits.",
+	}, []string{"proxy"})
+)
+
+func init() {
+	supervisor.Register(&DatabaseProxy{})
+	prometheus.MustRegister(activeConnections, totalConnections, rejectedConnections)
+}
+
+type (
+	// DatabaseProxy passes through the connections of PostgreSQL or MySQL,
+	// it understands the startup handshakes enough to log the users and
+	// databases, and doesn't alter the query traffic.
+	DatabaseProxy struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		proxy *proxy
+	}
+
+	// Spec describes the DatabaseProxy.
+	Spec struct {
+		Protocol string `yaml:"protocol" jsonschema:"required,enum=postgresql,enum=mysql"`
+		// Port is the port to serve the clients.
+		Port uint16 `yaml:"port" jsonschema:"required"`
+		// Server is host:port of the database server.
+		Server string `yaml:"server" jsonschema:"required"`
+		// MaxConnections and MaxConnectionsPerClient limit the connections
+		// of all clients and every client IP, zero means unlimited.
+		MaxConnections          int `yaml:"maxConnections" jsonschema:"omitempty,minimum=0"`
+		MaxConnectionsPerClient int `yaml:"maxConnectionsPerClient" jsonschema:"omitempty,minimum=0"`
+		// HandshakeTimeout is the timeout of dialing the server and
+		// the startup handshake.
+		HandshakeTimeout string `yaml:"handshakeTimeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// Status is the status of DatabaseProxy.
+	Status struct {
+		Connections int64  `yaml:"connections"`
+		Rejected    uint64 `yaml:"rejected"`
+		// Databases are the connection stats of every database, whose name
+		// is unknown if the connection is encrypted.
+		Databases map[string]*DatabaseStatus `yaml:"databases"`
+
+		Error string `yaml:"error,omitempty"`
+	}
+
+	// DatabaseStatus is the connection stats of a database.
+	DatabaseStatus struct {
+		Active int64  `yaml:"active"`
+		Total  uint64 `yaml:"total"`
+	}
+)
+
+// Category returns the category of DatabaseProxy.
+func (dp *DatabaseProxy) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+
+// Kind returns the kind of DatabaseProxy.
+func (dp *DatabaseProxy) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of DatabaseProxy.
+func (dp *DatabaseProxy) DefaultSpec() interface{} {
+	return &Spec{
+		HandshakeTimeout: "10s",
+	}
+}
+
+// Init initializes DatabaseProxy.
+func (dp *DatabaseProxy) Init(superSpec *supervisor.Spec) {
+	dp.superSpec, dp.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	dp.reload()
+}
+
+// Inherit inherits previous generation of DatabaseProxy.
+func (dp *DatabaseProxy) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	// NOTE: Close the previous generation first to release the port,
+	// the connections passed through are closed too.
+	previousGeneration.Close()
+	dp.Init(superSpec)
+}
+
+func (dp *DatabaseProxy) reload() {
+	dp.proxy = newProxy(dp.superSpec.Name(), dp.spec)
+	if err := dp.proxy.listen(); err != nil {
+		logger.Errorf("%s listen on port %d failed: %v", dp.superSpec.Name(), dp.spec.Port, err)
+	}
+}
+
+// Status returns the status of DatabaseProxy.
+func (dp *DatabaseProxy) Status() *supervisor.Status {
+	return &supervisor.Status{ObjectStatus: dp.proxy.status()}
+}
+
+// Close closes DatabaseProxy.
+func (dp *DatabaseProxy) Close() {
+	dp.proxy.close()
+}
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if spec.MaxConnections > 0 && spec.MaxConnectionsPerClient > spec.MaxConnections {
+		return fmt.Errorf("maxConnectionsPerClient is greater than maxConnections")
+	}
+	return nil
+}
+
+func (spec *Spec) handshakeTimeout() time.Duration {
+	d, _ := time.ParseDuration(spec.HandshakeTimeout)
+	if d <= 0 {
+		d = 10 * time.Second
+	}
+	return d
+}
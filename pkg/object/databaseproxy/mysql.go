@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package databaseproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	mysqlClientConnectWithDB    = 0x00000008
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientSSL              = 0x00000800
+	mysqlClientSecureConnection = 0x00008000
+	mysqlClientPluginAuthLenenc = 0x00200000
+
+	// mysqlSSLRequestSize is the payload size of SSLRequest, which is the
+	// beginning of HandshakeResponse41.
+	mysqlSSLRequestSize = 32
+
+	mysqlErrConCount = 1040
+)
+
+// mysql understands the handshake of the MySQL protocol, see
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase.html.
+type mysql struct{}
+
+// readPacket reads a packet, which has a 3-byte length and 1-byte sequence
+// id ahead of the payload.
+func (m *mysql) readPacket(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	size := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	packet := make([]byte, 4+size)
+	copy(packet, header)
+	if _, err := io.ReadFull(r, packet[4:]); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+func (m *mysql) handshake(client, server net.Conn) (string, string, error) {
+	greeting, err := m.readPacket(server)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err = client.Write(greeting); err != nil {
+		return "", "", err
+	}
+
+	resp, err := m.readPacket(client)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err = server.Write(resp); err != nil {
+		return "", "", err
+	}
+
+	return m.parseHandshakeResponse(resp[4:])
+}
+
+// parseHandshakeResponse parses the user and database of the
+// HandshakeResponse41, they're empty for SSLRequest and HandshakeResponse320.
+func (m *mysql) parseHandshakeResponse(payload []byte) (string, string, error) {
+	if len(payload) < 4 {
+		return "", "", fmt.Errorf("invalid handshake response")
+	}
+	caps := binary.LittleEndian.Uint32(payload)
+	if caps&mysqlClientProtocol41 == 0 {
+		return "", "", nil
+	}
+	if len(payload) < mysqlSSLRequestSize {
+		return "", "", fmt.Errorf("invalid handshake response")
+	}
+	if caps&mysqlClientSSL != 0 && len(payload) == mysqlSSLRequestSize {
+		return "", "", nil
+	}
+
+	rest := payload[mysqlSSLRequestSize:]
+	user, rest, ok := m.nullTerminated(rest)
+	if !ok {
+		return "", "", fmt.Errorf("invalid user of handshake response")
+	}
+
+	// NOTE: Skip the auth response.
+	switch {
+	case caps&mysqlClientPluginAuthLenenc != 0:
+		n, size := m.lenencInt(rest)
+		if size == 0 || uint64(len(rest)-size) < n {
+			return "", "", fmt.Errorf("invalid auth response of handshake response")
+		}
+		rest = rest[size+int(n):]
+	case caps&mysqlClientSecureConnection != 0:
+		if len(rest) == 0 || len(rest) < 1+int(rest[0]) {
+			return "", "", fmt.Errorf("invalid auth response of handshake response")
+		}
+		rest = rest[1+int(rest[0]):]
+	default:
+		if _, rest, ok = m.nullTerminated(rest); !ok {
+			return "", "", fmt.Errorf("invalid auth response of handshake response")
+		}
+	}
+
+	database := ""
+	if caps&mysqlClientConnectWithDB != 0 {
+		database, _, _ = m.nullTerminated(rest)
+	}
+	return user, database, nil
+}
+
+func (m *mysql) nullTerminated(data []byte) (string, []byte, bool) {
+	i := bytes.IndexByte(data, 0)
+	if i < 0 {
+		return "", nil, false
+	}
+	return string(data[:i]), data[i+1:], true
+}
+
+// lenencInt parses a length-encoded integer, the size is zero if it's invalid.
+func (m *mysql) lenencInt(data []byte) (uint64, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+
+	size := 0
+	switch data[0] {
+	case 0xfc:
+		size = 2
+	case 0xfd:
+		size = 3
+	case 0xfe:
+		size = 8
+	default:
+		if data[0] < 0xfb {
+			return uint64(data[0]), 1
+		}
+		return 0, 0
+	}
+
+	if len(data) < 1+size {
+		return 0, 0
+	}
+	n := uint64(0)
+	for i := size; i > 0; i-- {
+		n = n<<8 | uint64(data[i])
+	}
+	return n, 1 + size
+}
+
+func (m *mysql) reject(client net.Conn, msg string) {
+	// NOTE: The server speaks first, so the error is sent as the greeting.
+	payload := []byte{0xff, 0, 0, '#', '0', '8', '0', '0', '4'}
+	binary.LittleEndian.PutUint16(payload[1:], mysqlErrConCount)
+	payload = append(payload, msg...)
+
+	packet := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), 0}
+	client.Write(append(packet, payload...))
+}
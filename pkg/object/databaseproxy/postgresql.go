@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package databaseproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	pgSSLRequest    = 80877103
+	pgGSSENCRequest = 80877104
+	pgCancelRequest = 80877102
+
+	// pgMaxStartupSize is the max size of startup messages, which is
+	// the same as the server.
+	pgMaxStartupSize = 10000
+)
+
+// postgresql understands the startup of the PostgreSQL protocol, see
+// https://www.postgresql.org/docs/current/protocol-flow.html#id-1.10.6.7.3.
+type postgresql struct{}
+
+// readStartup reads a startup message, which has no type byte.
+func (pg *postgresql) readStartup(r io.Reader) ([]byte, uint32, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, err
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if size < 8 || size > pgMaxStartupSize {
+		return nil, 0, fmt.Errorf("invalid startup message length %d", size)
+	}
+
+	msg := make([]byte, size)
+	copy(msg, header)
+	if _, err := io.ReadFull(r, msg[8:]); err != nil {
+		return nil, 0, err
+	}
+	return msg, binary.BigEndian.Uint32(header[4:]), nil
+}
+
+func (pg *postgresql) handshake(client, server net.Conn) (string, string, error) {
+	for {
+		msg, code, err := pg.readStartup(client)
+		if err != nil {
+			return "", "", err
+		}
+		if _, err = server.Write(msg); err != nil {
+			return "", "", err
+		}
+
+		switch code {
+		case pgSSLRequest, pgGSSENCRequest:
+			// NOTE: The server answers with a single byte, and the client
+			// sends the startup message again if it's declined.
+			resp := make([]byte, 1)
+			if _, err = io.ReadFull(server, resp); err != nil {
+				return "", "", err
+			}
+			if _, err = client.Write(resp); err != nil {
+				return "", "", err
+			}
+			if resp[0] == 'S' || resp[0] == 'G' {
+				return "", "", nil
+			}
+			continue
+		case pgCancelRequest:
+			return "", "", nil
+		}
+
+		params := pg.parseParams(msg[8:])
+		database := params["database"]
+		if database == "" {
+			database = params["user"]
+		}
+		return params["user"], database, nil
+	}
+}
+
+// parseParams parses the parameters of the startup message, which are
+// pairs of null-terminated names and values.
+func (pg *postgresql) parseParams(data []byte) map[string]string {
+	params := map[string]string{}
+	fields := bytes.Split(data, []byte{0})
+	for i := 0; i+1 < len(fields); i += 2 {
+		if len(fields[i]) == 0 {
+			break
+		}
+		params[string(fields[i])] = string(fields[i+1])
+	}
+	return params
+}
+
+func (pg *postgresql) reject(client net.Conn, msg string) {
+	// NOTE: Decline encryption to send the error in plain text.
+	for {
+		_, code, err := pg.readStartup(client)
+		if err != nil {
+			return
+		}
+		if code != pgSSLRequest && code != pgGSSENCRequest {
+			break
+		}
+		if _, err = client.Write([]byte{'N'}); err != nil {
+			return
+		}
+	}
+
+	body := "SFATAL\x00VFATAL\x00C53300\x00M" + msg + "\x00\x00"
+	resp := make([]byte, 5, 5+len(body))
+	resp[0] = 'E'
+	binary.BigEndian.PutUint32(resp[1:], uint32(len(body)+4))
+	client.Write(append(resp, body...))
+}
@@ -0,0 +1,268 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package databaseproxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+// unknownDatabase is the database of encrypted connections and
+// connections failed in the handshake.
+const unknownDatabase = "unknown"
+
+type (
+	proxy struct {
+		name     string
+		spec     *Spec
+		protocol protocol
+
+		listener  net.Listener
+		listenErr string
+
+		mutex     sync.Mutex
+		conns     map[net.Conn]struct{}
+		clients   map[string]int
+		databases map[string]*DatabaseStatus
+		closed    bool
+		wg        sync.WaitGroup
+
+		connections int64
+		rejected    uint64
+	}
+
+	// protocol understands the startup handshake of a database protocol.
+	protocol interface {
+		// handshake relays the startup handshake between the client and
+		// the server, and returns the user and database, which are empty
+		// if the connection is encrypted.
+		handshake(client, server net.Conn) (user, database string, err error)
+		// reject sends the error to the client in the protocol.
+		reject(client net.Conn, msg string)
+	}
+)
+
+func newProxy(name string, spec *Spec) *proxy {
+	p := &proxy{
+		name:      name,
+		spec:      spec,
+		conns:     map[net.Conn]struct{}{},
+		clients:   map[string]int{},
+		databases: map[string]*DatabaseStatus{},
+	}
+
+	switch spec.Protocol {
+	case protocolMySQL:
+		p.protocol = &mysql{}
+	default:
+		p.protocol = &postgresql{}
+	}
+
+	return p
+}
+
+func (p *proxy) listen() error {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", p.spec.Port))
+	if err != nil {
+		p.listenErr = err.Error()
+		return err
+	}
+	p.listener = l
+
+	p.wg.Add(1)
+	go p.serve()
+	return nil
+}
+
+func (p *proxy) serve() {
+	defer p.wg.Done()
+
+	for {
+		c, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		p.mutex.Lock()
+		if p.closed {
+			p.mutex.Unlock()
+			c.Close()
+			return
+		}
+		p.conns[c] = struct{}{}
+		p.wg.Add(1)
+		p.mutex.Unlock()
+
+		go p.handle(c)
+	}
+}
+
+// acquire checks the limits and counts the connection of the client.
+func (p *proxy) acquire(ip string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if max := p.spec.MaxConnections; max > 0 && atomic.LoadInt64(&p.connections) >= int64(max) {
+		return fmt.Errorf("too many connections")
+	}
+	if max := p.spec.MaxConnectionsPerClient; max > 0 && p.clients[ip] >= max {
+		return fmt.Errorf("too many connections from %s", ip)
+	}
+
+	p.clients[ip]++
+	atomic.AddInt64(&p.connections, 1)
+	return nil
+}
+
+func (p *proxy) release(ip string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.clients[ip]--
+	if p.clients[ip] <= 0 {
+		delete(p.clients, ip)
+	}
+	atomic.AddInt64(&p.connections, -1)
+}
+
+func (p *proxy) removeConn(c net.Conn) {
+	c.Close()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.conns, c)
+}
+
+func (p *proxy) handle(client net.Conn) {
+	defer p.wg.Done()
+	defer p.removeConn(client)
+
+	ip, _, _ := net.SplitHostPort(client.RemoteAddr().String())
+	timeout := p.spec.handshakeTimeout()
+
+	if err := p.acquire(ip); err != nil {
+		atomic.AddUint64(&p.rejected, 1)
+		rejectedConnections.WithLabelValues(p.name).Inc()
+		logger.Warnf("%s reject %s: %v", p.name, client.RemoteAddr(), err)
+		client.SetDeadline(time.Now().Add(timeout))
+		p.protocol.reject(client, err.Error())
+		return
+	}
+	defer p.release(ip)
+
+	server, err := net.DialTimeout("tcp", p.spec.Server, timeout)
+	if err != nil {
+		logger.Errorf("%s dial %s failed: %v", p.name, p.spec.Server, err)
+		client.SetDeadline(time.Now().Add(timeout))
+		p.protocol.reject(client, "server is unavailable")
+		return
+	}
+	defer server.Close()
+
+	client.SetDeadline(time.Now().Add(timeout))
+	server.SetDeadline(time.Now().Add(timeout))
+	user, database, err := p.protocol.handshake(client, server)
+	if err != nil {
+		logger.Errorf("%s handshake of %s failed: %v", p.name, client.RemoteAddr(), err)
+		return
+	}
+	client.SetDeadline(time.Time{})
+	server.SetDeadline(time.Time{})
+
+	if database == "" {
+		database = unknownDatabase
+	}
+	logger.Infof("%s: %s connected to database %s as user %q", p.name, client.RemoteAddr(), database, user)
+
+	p.addDatabaseConn(database, 1)
+	defer p.addDatabaseConn(database, -1)
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(server, client)
+		// NOTE: Closing the server connection stops the other direction.
+		server.Close()
+		close(done)
+	}()
+	io.Copy(client, server)
+	client.Close()
+	<-done
+}
+
+func (p *proxy) addDatabaseConn(database string, delta int64) {
+	p.mutex.Lock()
+	s := p.databases[database]
+	if s == nil {
+		s = &DatabaseStatus{}
+		p.databases[database] = s
+	}
+	s.Active += delta
+	if delta > 0 {
+		s.Total++
+	}
+	p.mutex.Unlock()
+
+	activeConnections.WithLabelValues(p.name, database).Add(float64(delta))
+	if delta > 0 {
+		totalConnections.WithLabelValues(p.name, database).Inc()
+	}
+}
+
+func (p *proxy) status() *Status {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	s := &Status{
+		Connections: atomic.LoadInt64(&p.connections),
+		Rejected:    atomic.LoadUint64(&p.rejected),
+		Databases:   make(map[string]*DatabaseStatus, len(p.databases)),
+		Error:       p.listenErr,
+	}
+	for name, ds := range p.databases {
+		copied := *ds
+		s.Databases[name] = &copied
+	}
+	return s
+}
+
+func (p *proxy) close() {
+	p.mutex.Lock()
+	p.closed = true
+	if p.listener != nil {
+		p.listener.Close()
+	}
+	for c := range p.conns {
+		c.Close()
+	}
+	databases := p.databases
+	p.mutex.Unlock()
+
+	p.wg.Wait()
+
+	for database := range databases {
+		activeConnections.DeleteLabelValues(p.name, database)
+		totalConnections.DeleteLabelValues(p.name, database)
+	}
+	rejectedConnections.DeleteLabelValues(p.name)
+}
@@ -0,0 +1,223 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package databaseproxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/pgclient"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+// startServer starts a fake database server serving every connection
+// with the function.
+func startServer(t *testing.T, serve func(c net.Conn)) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				serve(c)
+			}()
+		}
+	}()
+	return l
+}
+
+func newTestProxy(t *testing.T, spec *Spec) *proxy {
+	p := newProxy("test", spec)
+	if err := p.listen(); err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	return p
+}
+
+func waitStatus(p *proxy, cond func(s *Status) bool) *Status {
+	for i := 0; i < 100; i++ {
+		if s := p.status(); cond(s) {
+			return s
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return p.status()
+}
+
+func TestPostgreSQL(t *testing.T) {
+	server := startServer(t, func(c net.Conn) {
+		r := bufio.NewReader(c)
+		if _, _, err := (&postgresql{}).readStartup(r); err != nil {
+			return
+		}
+		// AuthenticationOk and ReadyForQuery.
+		c.Write([]byte{'R', 0, 0, 0, 8, 0, 0, 0, 0, 'Z', 0, 0, 0, 5, 'I'})
+		for {
+			typ, err := r.ReadByte()
+			if err != nil || typ == 'X' {
+				return
+			}
+			header := make([]byte, 4)
+			io.ReadFull(r, header)
+			io.CopyN(io.Discard, r, int64(binary.BigEndian.Uint32(header)-4))
+			c.Write([]byte{'Z', 0, 0, 0, 5, 'I'})
+		}
+	})
+	defer server.Close()
+
+	p := newTestProxy(t, &Spec{
+		Protocol:                protocolPostgreSQL,
+		Server:                  server.Addr().String(),
+		MaxConnectionsPerClient: 1,
+	})
+	defer p.close()
+
+	opts := &pgclient.Options{
+		Address:  p.listener.Addr().String(),
+		Username: "alice",
+		Database: "orders",
+		Timeout:  time.Second,
+	}
+	conn, err := pgclient.Connect(opts)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	if err = conn.Exec("SELECT 1"); err != nil {
+		t.Errorf("exec failed: %v", err)
+	}
+
+	_, err = pgclient.Connect(opts)
+	if e, ok := err.(*pgclient.Error); !ok || e.Code != "53300" {
+		t.Errorf("the connection should be rejected, got %v", err)
+	}
+
+	s := p.status()
+	if s.Rejected != 1 || s.Databases["orders"] == nil || s.Databases["orders"].Active != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+
+	conn.Close()
+	s = waitStatus(p, func(s *Status) bool { return s.Connections == 0 })
+	if s.Connections != 0 || s.Databases["orders"].Active != 0 || s.Databases["orders"].Total != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func mysqlPacket(seq byte, payload []byte) []byte {
+	return append([]byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), seq}, payload...)
+}
+
+func TestMySQL(t *testing.T) {
+	m := &mysql{}
+	server := startServer(t, func(c net.Conn) {
+		c.Write(mysqlPacket(0, []byte("\x0a8.0.0\x00greeting")))
+		if _, err := m.readPacket(c); err != nil {
+			return
+		}
+		// OK packet.
+		c.Write(mysqlPacket(2, []byte{0, 0, 0, 2, 0, 0, 0}))
+		io.Copy(c, c)
+	})
+	defer server.Close()
+
+	p := newTestProxy(t, &Spec{
+		Protocol:       protocolMySQL,
+		Server:         server.Addr().String(),
+		MaxConnections: 1,
+	})
+	defer p.close()
+
+	c, err := net.Dial("tcp", p.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer c.Close()
+
+	if _, err = m.readPacket(c); err != nil {
+		t.Fatalf("read greeting failed: %v", err)
+	}
+	payload := make([]byte, mysqlSSLRequestSize)
+	binary.LittleEndian.PutUint32(payload, mysqlClientProtocol41|mysqlClientSecureConnection|mysqlClientConnectWithDB)
+	payload = append(payload, "bob\x00"...)
+	payload = append(payload, 3, 'a', 'b', 'c')
+	payload = append(payload, "users\x00"...)
+	c.Write(mysqlPacket(1, payload))
+	if _, err = m.readPacket(c); err != nil {
+		t.Fatalf("read ok failed: %v", err)
+	}
+
+	c.Write([]byte("query"))
+	echo := make([]byte, 5)
+	if _, err = io.ReadFull(c, echo); err != nil || string(echo) != "query" {
+		t.Errorf("traffic should be passed through, got %q %v", echo, err)
+	}
+
+	rejected, err := net.Dial("tcp", p.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer rejected.Close()
+	packet, err := m.readPacket(rejected)
+	if err != nil || packet[4] != 0xff || binary.LittleEndian.Uint16(packet[5:]) != mysqlErrConCount {
+		t.Errorf("the connection should be rejected, got %v %v", packet, err)
+	}
+
+	s := p.status()
+	if s.Rejected != 1 || s.Databases["users"] == nil || s.Databases["users"].Active != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestParseHandshakeResponse(t *testing.T) {
+	m := &mysql{}
+
+	payload := make([]byte, mysqlSSLRequestSize)
+	binary.LittleEndian.PutUint32(payload, mysqlClientProtocol41|mysqlClientSSL)
+	if user, db, err := m.parseHandshakeResponse(payload); err != nil || user != "" || db != "" {
+		t.Errorf("ssl request should have no user and database, got %q %q %v", user, db, err)
+	}
+
+	binary.LittleEndian.PutUint32(payload, mysqlClientProtocol41|mysqlClientPluginAuthLenenc|mysqlClientConnectWithDB)
+	payload = append(payload, "carol\x00"...)
+	payload = append(payload, 0xfc, 2, 0, 'x', 'y')
+	payload = append(payload, "sales\x00"...)
+	if user, db, err := m.parseHandshakeResponse(payload); err != nil || user != "carol" || db != "sales" {
+		t.Errorf("unexpected user and database %q %q %v", user, db, err)
+	}
+
+	if _, _, err := m.parseHandshakeResponse(payload[:mysqlSSLRequestSize+3]); err == nil {
+		t.Error("truncated handshake response should fail")
+	}
+}
@@ -0,0 +1,339 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+// Package deprecationpolicy marks routes as deprecated, the policies are
+// enforced by the DeprecationEnforcer filter in pipelines.
+package deprecationpolicy
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/ratelimiter"
+)
+
+const (
+	// Kind is the kind of DeprecationPolicy.
+	Kind = "DeprecationPolicy"
+
+	// Anonymous is the consumer of usages whose consumer is unknown.
+	Anonymous = "anonymous"
+
+	maxLimiters = 10000
+)
+
+var (
+	mutex    sync.RWMutex
+	policies = make(map[string]*Policy)
+)
+
+func init() {
+	supervisor.Register(&DeprecationPolicy{})
+}
+
+type (
+	// DeprecationPolicy marks routes as deprecated with a sunset date.
+	DeprecationPolicy struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+		policy    *Policy
+	}
+
+	// Spec describes the DeprecationPolicy.
+	Spec struct {
+		PathPrefixes []string `yaml:"pathPrefixes" jsonschema:"required,minItems=1,uniqueItems=true"`
+		Methods      []string `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		// DeprecatedAt and SunsetAt are in RFC3339.
+		DeprecatedAt string `yaml:"deprecatedAt" jsonschema:"required"`
+		SunsetAt     string `yaml:"sunsetAt" jsonschema:"omitempty"`
+		// Link is the documentation of the deprecation, e.g. the migration guide.
+		Link        string       `yaml:"link" jsonschema:"omitempty"`
+		AfterSunset *AfterSunset `yaml:"afterSunset" jsonschema:"omitempty"`
+	}
+
+	// AfterSunset describes the actions to requests after the sunset date.
+	AfterSunset struct {
+		// Warning is the text of the Warning header.
+		Warning   string     `yaml:"warning" jsonschema:"omitempty"`
+		RateLimit *RateLimit `yaml:"rateLimit" jsonschema:"omitempty"`
+		// Gone rejects requests with 410.
+		Gone bool `yaml:"gone" jsonschema:"omitempty"`
+	}
+
+	// RateLimit limits the requests of every consumer on every member,
+	// at most LimitForPeriod requests are permitted in every
+	// LimitRefreshPeriod.
+	RateLimit struct {
+		LimitRefreshPeriod string `yaml:"limitRefreshPeriod" jsonschema:"required,format=duration"`
+		LimitForPeriod     int    `yaml:"limitForPeriod" jsonschema:"required,minimum=1"`
+	}
+
+	// Policy is the runtime of a DeprecationPolicy.
+	Policy struct {
+		Name         string
+		Spec         *Spec
+		DeprecatedAt time.Time
+		// SunsetAt is zero if there's no sunset date.
+		SunsetAt time.Time
+
+		limiters *lru.Cache
+
+		usageMutex sync.Mutex
+		// usages are *uint64 of consumers, they are kept across generations.
+		usages map[string]*uint64
+	}
+
+	// Status is the status of DeprecationPolicy.
+	Status struct {
+		Deprecated bool `yaml:"deprecated"`
+		Sunset     bool `yaml:"sunset"`
+		// Usages are the numbers of requests after the deprecation
+		// by consumers on this member.
+		Usages map[string]uint64 `yaml:"usages"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	deprecatedAt, err := time.Parse(time.RFC3339, spec.DeprecatedAt)
+	if err != nil {
+		return fmt.Errorf("invalid deprecatedAt: %v", err)
+	}
+	if spec.SunsetAt != "" {
+		sunsetAt, err := time.Parse(time.RFC3339, spec.SunsetAt)
+		if err != nil {
+			return fmt.Errorf("invalid sunsetAt: %v", err)
+		}
+		if sunsetAt.Before(deprecatedAt) {
+			return fmt.Errorf("sunsetAt is before deprecatedAt")
+		}
+	} else if spec.AfterSunset != nil {
+		return fmt.Errorf("afterSunset is specified without sunsetAt")
+	}
+	if spec.Link != "" {
+		if u, err := url.Parse(spec.Link); err != nil || !u.IsAbs() {
+			return fmt.Errorf("invalid link %s", spec.Link)
+		}
+	}
+	if spec.AfterSunset != nil && spec.AfterSunset.RateLimit != nil {
+		d, err := time.ParseDuration(spec.AfterSunset.RateLimit.LimitRefreshPeriod)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid limitRefreshPeriod %s", spec.AfterSunset.RateLimit.LimitRefreshPeriod)
+		}
+	}
+	return nil
+}
+
+// matchLen returns the length of the longest path prefix matching
+// the request, or -1 if the request doesn't match the policy.
+func (spec *Spec) matchLen(method, path string) int {
+	if len(spec.Methods) > 0 {
+		matched := false
+		for _, m := range spec.Methods {
+			if m == method {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return -1
+		}
+	}
+
+	l := -1
+	for _, prefix := range spec.PathPrefixes {
+		if len(prefix) > l && strings.HasPrefix(path, prefix) {
+			l = len(prefix)
+		}
+	}
+	return l
+}
+
+func newPolicy(name string, spec *Spec, prev *Policy) *Policy {
+	p := &Policy{Name: name, Spec: spec}
+	p.DeprecatedAt, _ = time.Parse(time.RFC3339, spec.DeprecatedAt)
+	if spec.SunsetAt != "" {
+		p.SunsetAt, _ = time.Parse(time.RFC3339, spec.SunsetAt)
+	}
+	p.limiters, _ = lru.New(maxLimiters)
+
+	if prev != nil {
+		prev.usageMutex.Lock()
+		p.usages = prev.usages
+		prev.usageMutex.Unlock()
+	} else {
+		p.usages = make(map[string]*uint64)
+	}
+	return p
+}
+
+// Deprecated returns whether the routes are deprecated at the time.
+func (p *Policy) Deprecated(now time.Time) bool {
+	return !now.Before(p.DeprecatedAt)
+}
+
+// Sunset returns whether the routes are sunset at the time.
+func (p *Policy) Sunset(now time.Time) bool {
+	return !p.SunsetAt.IsZero() && !now.Before(p.SunsetAt)
+}
+
+// Record records a request of the consumer.
+func (p *Policy) Record(consumer string) {
+	if consumer == "" {
+		consumer = Anonymous
+	}
+
+	p.usageMutex.Lock()
+	count := p.usages[consumer]
+	if count == nil {
+		count = new(uint64)
+		p.usages[consumer] = count
+	}
+	p.usageMutex.Unlock()
+
+	atomic.AddUint64(count, 1)
+}
+
+// Usages returns the numbers of requests by consumers.
+func (p *Policy) Usages() map[string]uint64 {
+	p.usageMutex.Lock()
+	defer p.usageMutex.Unlock()
+
+	usages := make(map[string]uint64, len(p.usages))
+	for c, count := range p.usages {
+		usages[c] = atomic.LoadUint64(count)
+	}
+	return usages
+}
+
+// Acquire returns whether the request of the consumer is permitted by
+// the rate limit after the sunset date.
+func (p *Policy) Acquire(consumer string) bool {
+	if p.Spec.AfterSunset == nil || p.Spec.AfterSunset.RateLimit == nil {
+		return true
+	}
+
+	v, ok := p.limiters.Get(consumer)
+	if !ok {
+		rateLimit := p.Spec.AfterSunset.RateLimit
+		period, err := time.ParseDuration(rateLimit.LimitRefreshPeriod)
+		if err != nil {
+			logger.Errorf("BUG: parse duration %s failed: %v", rateLimit.LimitRefreshPeriod, err)
+			return true
+		}
+		v = ratelimiter.New(&ratelimiter.Policy{
+			LimitRefreshPeriod: period,
+			LimitForPeriod:     rateLimit.LimitForPeriod,
+		})
+		p.limiters.Add(consumer, v)
+	}
+
+	permitted, _ := v.(*ratelimiter.RateLimiter).AcquirePermission()
+	return permitted
+}
+
+// Match returns the policy of the request, the policy with the longest
+// matching path prefix wins. It returns nil if no policy matches.
+func Match(method, path string) *Policy {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	var policy *Policy
+	longest := -1
+	for _, name := range sortedNames() {
+		p := policies[name]
+		if l := p.Spec.matchLen(method, path); l > longest {
+			policy, longest = p, l
+		}
+	}
+	return policy
+}
+
+func sortedNames() []string {
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Category returns the category of DeprecationPolicy.
+func (dp *DeprecationPolicy) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+
+// Kind returns the kind of DeprecationPolicy.
+func (dp *DeprecationPolicy) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of DeprecationPolicy.
+func (dp *DeprecationPolicy) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Init initializes DeprecationPolicy.
+func (dp *DeprecationPolicy) Init(superSpec *supervisor.Spec) {
+	dp.reload(superSpec, nil)
+}
+
+// Inherit inherits previous generation of DeprecationPolicy.
+func (dp *DeprecationPolicy) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	// NOTE: Registering the new generation replaces the previous one.
+	dp.reload(superSpec, previousGeneration.(*DeprecationPolicy).policy)
+}
+
+func (dp *DeprecationPolicy) reload(superSpec *supervisor.Spec, prev *Policy) {
+	dp.superSpec, dp.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	dp.policy = newPolicy(superSpec.Name(), dp.spec, prev)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	policies[dp.policy.Name] = dp.policy
+}
+
+// Status returns the status of DeprecationPolicy.
+func (dp *DeprecationPolicy) Status() *supervisor.Status {
+	now := time.Now()
+	return &supervisor.Status{
+		ObjectStatus: &Status{
+			Deprecated: dp.policy.Deprecated(now),
+			Sunset:     dp.policy.Sunset(now),
+			Usages:     dp.policy.Usages(),
+		},
+	}
+}
+
+// Close closes DeprecationPolicy.
+func (dp *DeprecationPolicy) Close() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// NOTE: The next generation may have registered itself.
+	if policies[dp.policy.Name] == dp.policy {
+		delete(policies, dp.policy.Name)
+	}
+}
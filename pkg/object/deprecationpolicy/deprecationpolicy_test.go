@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package deprecationpolicy
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newPolicyObject(t *testing.T, yamlConfig string) *DeprecationPolicy {
+	spec, err := supervisor.NewSpec(yamlConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dp := &DeprecationPolicy{}
+	dp.Init(spec)
+	return dp
+}
+
+func TestMatch(t *testing.T) {
+	v1 := newPolicyObject(t, `
+kind: DeprecationPolicy
+name: v1
+pathPrefixes: [/api/v1]
+deprecatedAt: 2020-01-01T00:00:00Z
+`)
+	defer v1.Close()
+	orders := newPolicyObject(t, `
+kind: DeprecationPolicy
+name: v1-orders
+pathPrefixes: [/api/v1/orders]
+methods: [POST]
+deprecatedAt: 2020-01-01T00:00:00Z
+sunsetAt: 2021-01-01T00:00:00Z
+`)
+	defer orders.Close()
+
+	cases := []struct {
+		method, path, policy string
+	}{
+		{"GET", "/api/v1/users", "v1"},
+		{"GET", "/api/v1/orders", "v1"},
+		{"POST", "/api/v1/orders", "v1-orders"},
+		{"GET", "/api/v2/users", ""},
+	}
+	for _, c := range cases {
+		p, name := Match(c.method, c.path), ""
+		if p != nil {
+			name = p.Name
+		}
+		if name != c.policy {
+			t.Errorf("%s %s: want policy %q, got %q", c.method, c.path, c.policy, name)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	yamlConfig := `
+kind: DeprecationPolicy
+name: v1
+pathPrefixes: [/api/v1]
+deprecatedAt: 2020-01-01T00:00:00Z
+sunsetAt: 2021-01-01T00:00:00Z
+afterSunset:
+  rateLimit:
+    limitRefreshPeriod: 1h
+    limitForPeriod: 1
+`
+	dp := newPolicyObject(t, yamlConfig)
+	p := dp.policy
+
+	if p.Deprecated(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)) || !p.Deprecated(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected deprecation")
+	}
+	if p.Sunset(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)) || !p.Sunset(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected sunset")
+	}
+
+	if !p.Acquire("alice") || p.Acquire("alice") || !p.Acquire("bob") {
+		t.Errorf("unexpected rate limiting")
+	}
+
+	p.Record("alice")
+	p.Record("alice")
+	p.Record("")
+
+	spec, _ := supervisor.NewSpec(yamlConfig)
+	next := &DeprecationPolicy{}
+	next.Inherit(spec, dp)
+	defer next.Close()
+	dp.Close()
+	if Match("GET", "/api/v1/users") != next.policy {
+		t.Fatalf("closing the previous generation should keep the next one")
+	}
+
+	status := next.Status().ObjectStatus.(*Status)
+	if !status.Deprecated || !status.Sunset || status.Usages["alice"] != 2 || status.Usages[Anonymous] != 1 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, spec := range []Spec{
+		{DeprecatedAt: "2020-01-01"},
+		{DeprecatedAt: "2020-01-01T00:00:00Z", Link: "docs/migration"},
+		{DeprecatedAt: "2020-01-01T00:00:00Z", SunsetAt: "2019-01-01T00:00:00Z"},
+		{DeprecatedAt: "2020-01-01T00:00:00Z", AfterSunset: &AfterSunset{Gone: true}},
+		{DeprecatedAt: "2020-01-01T00:00:00Z", SunsetAt: "2021-01-01T00:00:00Z",
+			AfterSunset: &AfterSunset{RateLimit: &RateLimit{LimitRefreshPeriod: "0s", LimitForPeriod: 1}}},
+	} {
+		if spec.Validate() == nil {
+			t.Errorf("spec %+v should be invalid", spec)
+		}
+	}
+}
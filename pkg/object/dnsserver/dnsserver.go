@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package dnsserver provides DNSServer, which serves the services in the
+// service registries by DNS.
+package dnsserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Kind is the kind of DNSServer.
+	Kind = "DNSServer"
+)
+
+func init() {
+	supervisor.Register(&DNSServer{})
+}
+
+type (
+	// DNSServer is an authoritative DNS responder of the services in the
+	// service registries, so that clients could discover them by DNS.
+	DNSServer struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		server *server
+	}
+
+	// Spec describes the DNSServer.
+	Spec struct {
+		// Port is the port serving both UDP and TCP.
+		Port uint16 `yaml:"port" jsonschema:"required"`
+		// Domain is the zone the DNSServer is authoritative for, services
+		// are named <service>.<registry>.<domain> and <service>.<domain>.
+		Domain string `yaml:"domain" jsonschema:"required"`
+		// TTL is the time to live of the records in seconds.
+		TTL uint32 `yaml:"ttl" jsonschema:"required,minimum=0"`
+	}
+
+	// Status is the status of DNSServer.
+	Status struct {
+		Queries  uint64 `yaml:"queries"`
+		NXDomain uint64 `yaml:"nxDomain"`
+		Refused  uint64 `yaml:"refused"`
+
+		Error string `yaml:"error,omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if strings.Trim(spec.Domain, ".") == "" {
+		return fmt.Errorf("invalid domain %s", spec.Domain)
+	}
+	return nil
+}
+
+// Category returns the category of DNSServer.
+func (ds *DNSServer) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+
+// Kind returns the kind of DNSServer.
+func (ds *DNSServer) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of DNSServer.
+func (ds *DNSServer) DefaultSpec() interface{} {
+	return &Spec{
+		TTL: 30,
+	}
+}
+
+// Init initializes DNSServer.
+func (ds *DNSServer) Init(superSpec *supervisor.Spec) {
+	ds.superSpec, ds.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	ds.reload()
+}
+
+// Inherit inherits previous generation of DNSServer.
+func (ds *DNSServer) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	// NOTE: Close the previous generation first to release the port.
+	previousGeneration.Close()
+	ds.Init(superSpec)
+}
+
+func (ds *DNSServer) reload() {
+	ds.server = newServer(ds.superSpec.Name(), ds.spec)
+	if err := ds.server.listen(); err != nil {
+		logger.Errorf("%s listen on port %d failed: %v", ds.superSpec.Name(), ds.spec.Port, err)
+	}
+}
+
+// Status returns the status of DNSServer.
+func (ds *DNSServer) Status() *supervisor.Status {
+	return &supervisor.Status{ObjectStatus: ds.server.status()}
+}
+
+// Close closes DNSServer.
+func (ds *DNSServer) Close() {
+	ds.server.close()
+}
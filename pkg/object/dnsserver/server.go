@@ -0,0 +1,448 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dnsserver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/serviceregistry"
+)
+
+const (
+	maxUDPSize   = 512
+	tcpIOTimeout = 10 * time.Second
+)
+
+type (
+	server struct {
+		name   string
+		spec   *Spec
+		domain string
+		// registry is the service registry, it's replaceable for testing.
+		registry *serviceregistry.ServiceRegistry
+
+		udp       net.PacketConn
+		tcp       net.Listener
+		listenErr string
+		wg        sync.WaitGroup
+
+		mutex  sync.Mutex
+		conns  map[net.Conn]struct{}
+		closed bool
+
+		queries  uint64
+		nxDomain uint64
+		refused  uint64
+	}
+
+	// record is the resolved record of a name.
+	record struct {
+		// exists reports whether the name exists.
+		exists  bool
+		servers []*serviceregistry.Server
+	}
+)
+
+func newServer(name string, spec *Spec) *server {
+	return &server{
+		name:     name,
+		spec:     spec,
+		domain:   strings.ToLower(strings.Trim(spec.Domain, ".")),
+		registry: serviceregistry.Global,
+		conns:    map[net.Conn]struct{}{},
+	}
+}
+
+func (s *server) listen() error {
+	udp, err := net.ListenPacket("udp", fmt.Sprintf(":%d", s.spec.Port))
+	if err != nil {
+		s.listenErr = err.Error()
+		return err
+	}
+
+	// NOTE: Listen TCP on the same port of UDP, which is random if the
+	// port is zero in testing.
+	port := udp.LocalAddr().(*net.UDPAddr).Port
+	tcp, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		udp.Close()
+		s.listenErr = err.Error()
+		return err
+	}
+
+	s.udp, s.tcp = udp, tcp
+	s.wg.Add(2)
+	go s.serveUDP()
+	go s.serveTCP()
+	return nil
+}
+
+func (s *server) serveUDP() {
+	defer s.wg.Done()
+
+	buff := make([]byte, 65535)
+	for {
+		n, addr, err := s.udp.ReadFrom(buff)
+		if err != nil {
+			return
+		}
+		resp := s.handle(buff[:n], maxUDPSize)
+		if resp != nil {
+			s.udp.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (s *server) serveTCP() {
+	defer s.wg.Done()
+
+	for {
+		c, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			c.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mutex.Unlock()
+
+		go s.handleTCP(c)
+	}
+}
+
+// handleTCP serves the queries of the connection, messages are prefixed
+// with 2-byte lengths over TCP.
+func (s *server) handleTCP(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		c.Close()
+		s.mutex.Lock()
+		delete(s.conns, c)
+		s.mutex.Unlock()
+	}()
+
+	for {
+		c.SetDeadline(time.Now().Add(tcpIOTimeout))
+
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(c, header); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint16(header))
+		if _, err := io.ReadFull(c, req); err != nil {
+			return
+		}
+
+		resp := s.handle(req, 65535)
+		if resp == nil {
+			return
+		}
+		binary.BigEndian.PutUint16(header, uint16(len(resp)))
+		if _, err := c.Write(append(header, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers the query, it returns nil if the query is malformed.
+func (s *server) handle(req []byte, maxSize int) []byte {
+	var p dnsmessage.Parser
+	h, err := p.Start(req)
+	if err != nil || h.Response {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+	atomic.AddUint64(&s.queries, 1)
+
+	resp := s.answer(h, q)
+	msg, err := resp.Pack()
+	if err != nil {
+		logger.Errorf("%s pack response of %s failed: %v", s.name, q.Name, err)
+		resp.Header.RCode = dnsmessage.RCodeServerFailure
+		resp.Answers, resp.Authorities, resp.Additionals = nil, nil, nil
+		msg, _ = resp.Pack()
+		return msg
+	}
+
+	if len(msg) > maxSize {
+		resp.Header.Truncated = true
+		resp.Answers, resp.Authorities, resp.Additionals = nil, nil, nil
+		msg, _ = resp.Pack()
+	}
+	return msg
+}
+
+func (s *server) answer(h dnsmessage.Header, q dnsmessage.Question) *dnsmessage.Message {
+	resp := &dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               h.ID,
+			Response:         true,
+			OpCode:           h.OpCode,
+			Authoritative:    true,
+			RecursionDesired: h.RecursionDesired,
+		},
+		Questions: []dnsmessage.Question{q},
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(q.Name.String(), "."))
+	if h.OpCode != 0 || q.Class != dnsmessage.ClassINET ||
+		(name != s.domain && !strings.HasSuffix(name, "."+s.domain)) {
+		atomic.AddUint64(&s.refused, 1)
+		resp.Header.Authoritative = false
+		resp.Header.RCode = dnsmessage.RCodeRefused
+		return resp
+	}
+
+	if name == s.domain {
+		switch q.Type {
+		case dnsmessage.TypeSOA:
+			resp.Answers = append(resp.Answers, s.soa())
+		case dnsmessage.TypeNS:
+			resp.Answers = append(resp.Answers, s.ns())
+		default:
+			resp.Authorities = append(resp.Authorities, s.soa())
+		}
+		return resp
+	}
+
+	rec := s.resolve(strings.Split(strings.TrimSuffix(name, "."+s.domain), "."))
+	if !rec.exists {
+		atomic.AddUint64(&s.nxDomain, 1)
+		resp.Header.RCode = dnsmessage.RCodeNameError
+		resp.Authorities = append(resp.Authorities, s.soa())
+		return resp
+	}
+
+	switch q.Type {
+	case dnsmessage.TypeA, dnsmessage.TypeAAAA:
+		resp.Answers = s.addressRecords(q.Name, q.Type, rec.servers)
+	case dnsmessage.TypeSRV:
+		resp.Answers, resp.Additionals = s.srvRecords(q.Name, rec.servers)
+	}
+	if len(resp.Answers) == 0 {
+		resp.Authorities = append(resp.Authorities, s.soa())
+	}
+	return resp
+}
+
+// resolve resolves the labels under the domain, which are one of
+// <service>, <service>.<registry>, <instance>.<service> and
+// <instance>.<service>.<registry>. The instance is the IP of the server
+// with dots or colons replaced by dashes.
+func (s *server) resolve(labels []string) *record {
+	switch len(labels) {
+	case 1:
+		return s.lookup("", labels[0])
+	case 2:
+		if rec := s.lookup(labels[1], labels[0]); rec.exists {
+			return rec
+		}
+		return s.lookupInstance(labels[0], s.lookup("", labels[1]))
+	case 3:
+		return s.lookupInstance(labels[0], s.lookup(labels[2], labels[1]))
+	default:
+		return &record{}
+	}
+}
+
+// lookup looks up the service case-insensitively, the services of all
+// registries are merged if the registry is empty.
+func (s *server) lookup(registry, service string) *record {
+	rec := &record{}
+	for registryName, services := range s.registry.ListServices() {
+		if registry != "" && !strings.EqualFold(registryName, registry) {
+			continue
+		}
+		for _, svc := range services {
+			if strings.EqualFold(svc.Name(), service) {
+				rec.exists = true
+				rec.servers = append(rec.servers, svc.Servers()...)
+			}
+		}
+	}
+	return rec
+}
+
+func (s *server) lookupInstance(instance string, service *record) *record {
+	rec := &record{}
+	for _, server := range service.servers {
+		if ip := serverIP(server); ip != nil && instanceLabel(ip) == instance {
+			rec.exists = true
+			rec.servers = append(rec.servers, server)
+		}
+	}
+	return rec
+}
+
+func serverIP(server *serviceregistry.Server) net.IP {
+	if ip := net.ParseIP(server.HostIP); ip != nil {
+		return ip
+	}
+	return net.ParseIP(server.Hostname)
+}
+
+func instanceLabel(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return strings.ReplaceAll(ip4.String(), ".", "-")
+	}
+	return strings.ReplaceAll(ip.String(), ":", "-")
+}
+
+func serverPort(server *serviceregistry.Server) uint16 {
+	if server.Port != 0 {
+		return server.Port
+	}
+	if server.Scheme == "https" {
+		return 443
+	}
+	return 80
+}
+
+func (s *server) header(name dnsmessage.Name, typ dnsmessage.Type) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Name: name, Type: typ, Class: dnsmessage.ClassINET, TTL: s.spec.TTL}
+}
+
+func (s *server) addressRecords(name dnsmessage.Name, typ dnsmessage.Type, servers []*serviceregistry.Server) []dnsmessage.Resource {
+	var records []dnsmessage.Resource
+	seen := map[string]bool{}
+	for _, server := range servers {
+		ip := serverIP(server)
+		if ip == nil || seen[ip.String()] {
+			continue
+		}
+		seen[ip.String()] = true
+
+		if ip4 := ip.To4(); ip4 != nil && typ == dnsmessage.TypeA {
+			r := &dnsmessage.AResource{}
+			copy(r.A[:], ip4)
+			records = append(records, dnsmessage.Resource{Header: s.header(name, typ), Body: r})
+		} else if ip4 == nil && typ == dnsmessage.TypeAAAA {
+			r := &dnsmessage.AAAAResource{}
+			copy(r.AAAA[:], ip.To16())
+			records = append(records, dnsmessage.Resource{Header: s.header(name, typ), Body: r})
+		}
+	}
+	return records
+}
+
+// srvRecords returns the SRV records and the address records of their
+// targets, the target is the host name of the server, or the instance
+// name under the queried name if the server has only an IP.
+func (s *server) srvRecords(name dnsmessage.Name, servers []*serviceregistry.Server) ([]dnsmessage.Resource, []dnsmessage.Resource) {
+	var answers, additionals []dnsmessage.Resource
+	for _, server := range servers {
+		target := server.Hostname
+		ip := serverIP(server)
+		if ip != nil {
+			target = instanceLabel(ip) + "." + name.String()
+		}
+		targetName, err := dnsmessage.NewName(strings.TrimSuffix(target, ".") + ".")
+		if err != nil {
+			continue
+		}
+
+		weight := server.Weight
+		if weight < 0 {
+			weight = 0
+		} else if weight > 65535 {
+			weight = 65535
+		}
+		answers = append(answers, dnsmessage.Resource{
+			Header: s.header(name, dnsmessage.TypeSRV),
+			Body: &dnsmessage.SRVResource{
+				Weight: uint16(weight),
+				Port:   serverPort(server),
+				Target: targetName,
+			},
+		})
+
+		if ip != nil {
+			typ := dnsmessage.TypeA
+			if ip.To4() == nil {
+				typ = dnsmessage.TypeAAAA
+			}
+			additionals = append(additionals, s.addressRecords(targetName, typ, []*serviceregistry.Server{server})...)
+		}
+	}
+	return answers, additionals
+}
+
+func (s *server) soa() dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: s.header(dnsmessage.MustNewName(s.domain+"."), dnsmessage.TypeSOA),
+		Body: &dnsmessage.SOAResource{
+			NS:      dnsmessage.MustNewName("ns." + s.domain + "."),
+			MBox:    dnsmessage.MustNewName("hostmaster." + s.domain + "."),
+			Serial:  1,
+			Refresh: 3600,
+			Retry:   600,
+			Expire:  86400,
+			MinTTL:  s.spec.TTL,
+		},
+	}
+}
+
+func (s *server) ns() dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: s.header(dnsmessage.MustNewName(s.domain+"."), dnsmessage.TypeNS),
+		Body:   &dnsmessage.NSResource{NS: dnsmessage.MustNewName("ns." + s.domain + ".")},
+	}
+}
+
+func (s *server) status() *Status {
+	return &Status{
+		Queries:  atomic.LoadUint64(&s.queries),
+		NXDomain: atomic.LoadUint64(&s.nxDomain),
+		Refused:  atomic.LoadUint64(&s.refused),
+		Error:    s.listenErr,
+	}
+}
+
+func (s *server) close() {
+	s.mutex.Lock()
+	s.closed = true
+	if s.udp != nil {
+		s.udp.Close()
+		s.tcp.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dnsserver
+
+import (
+	"context"
+	"net"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/serviceregistry"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	os.Exit(m.Run())
+}
+
+func TestServer(t *testing.T) {
+	registry := serviceregistry.New()
+	registry.ReplaceServers("consul", []*serviceregistry.Server{
+		{ServiceName: "order", HostIP: "10.0.0.1", Port: 8080, Weight: 2},
+		{ServiceName: "order", HostIP: "10.0.0.2", Port: 8081},
+		{ServiceName: "order", HostIP: "fd00::1", Port: 8082},
+		{ServiceName: "user", Hostname: "user.example.com", Scheme: "https"},
+	})
+
+	s := newServer("dns", &Spec{Domain: "svc.easegress.", TTL: 30})
+	s.registry = registry
+	if err := s.listen(); err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer s.close()
+
+	for _, network := range []string{"udp", "tcp"} {
+		resolver := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, s.udp.LocalAddr().String())
+			},
+		}
+		ctx := context.Background()
+
+		for _, name := range []string{"order.svc.easegress", "ORDER.consul.svc.easegress."} {
+			addrs, err := resolver.LookupHost(ctx, name)
+			if err != nil {
+				t.Fatalf("%s: lookup %s failed: %v", network, name, err)
+			}
+			sort.Strings(addrs)
+			if len(addrs) != 3 || addrs[0] != "10.0.0.1" || addrs[1] != "10.0.0.2" || addrs[2] != "fd00::1" {
+				t.Errorf("%s: unexpected addresses of %s: %v", network, name, addrs)
+			}
+		}
+
+		addrs, err := resolver.LookupHost(ctx, "10-0-0-2.order.consul.svc.easegress")
+		if err != nil || len(addrs) != 1 || addrs[0] != "10.0.0.2" {
+			t.Errorf("%s: unexpected addresses of instance: %v, %v", network, addrs, err)
+		}
+
+		_, srvs, err := resolver.LookupSRV(ctx, "", "", "order.consul.svc.easegress")
+		if err != nil {
+			t.Fatalf("%s: lookup SRV failed: %v", network, err)
+		}
+		sort.Slice(srvs, func(i, j int) bool { return srvs[i].Port < srvs[j].Port })
+		if len(srvs) != 3 || srvs[0].Target != "10-0-0-1.order.consul.svc.easegress." ||
+			srvs[0].Port != 8080 || srvs[0].Weight != 2 || srvs[2].Target != "fd00--1.order.consul.svc.easegress." {
+			t.Errorf("%s: unexpected SRV records: %+v", network, srvs)
+		}
+
+		_, srvs, err = resolver.LookupSRV(ctx, "", "", "user.svc.easegress")
+		if err != nil || len(srvs) != 1 || srvs[0].Target != "user.example.com." || srvs[0].Port != 443 {
+			t.Errorf("%s: unexpected SRV records: %+v, %v", network, srvs, err)
+		}
+
+		for _, name := range []string{"unknown.svc.easegress", "order.eureka.svc.easegress", "www.example.com"} {
+			if _, err := resolver.LookupHost(ctx, name); err == nil {
+				t.Errorf("%s: lookup %s should fail", network, name)
+			}
+		}
+	}
+
+	status := s.status()
+	if status.Queries == 0 || status.NXDomain == 0 || status.Refused == 0 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
@@ -78,12 +78,16 @@ func newIngressServer(superSpec *supervisor.Spec, controllerName string) *ingres
 	if !ok {
 		panic(fmt.Errorf("BUG: want *TrafficController, got %T", entity.Instance()))
 	}
+
+	namespace := fmt.Sprintf("%s/%s", superSpec.Name(), "ingress")
+	tc.SetOwner(namespace, &trafficcontroller.Owner{Kind: superSpec.Kind(), Name: superSpec.Name()})
+
 	return &ingressServer{
 		pipelines:  make(map[string]struct{}),
 		httpServer: nil,
 		superSpec:  superSpec,
 		mutex:      sync.RWMutex{},
-		namespace:  fmt.Sprintf("%s/%s", superSpec.Name(), "ingress"),
+		namespace:  namespace,
 		tc:         tc,
 	}
 }
@@ -0,0 +1,251 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package httppipeline
+
+import (
+	stdcontext "context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	// ResultFilterTimeout is the built-in result of filters exceeding the
+	// filterTimeout of the pipeline.
+	ResultFilterTimeout = "filterTimeout"
+
+	// ResultFilterPanic is the built-in result of panicked filters, if
+	// recoverPanic of the pipeline is true.
+	ResultFilterPanic = "filterPanic"
+)
+
+var errFilterTimeout = fmt.Errorf("filter timeout")
+
+// isBuiltInResult reports whether the result could be returned by filters
+// of all kinds.
+func isBuiltInResult(result string) bool {
+	return result == ResultFilterTimeout || result == ResultFilterPanic
+}
+
+type (
+	// filterCall is an execution of a filter. The timeout is for the
+	// filter itself, so the timer is paused when the filter calls the next
+	// handler, and resumed after the next handler returns.
+	filterCall struct {
+		ctx        context.HTTPContext
+		timeout    time.Duration
+		calledNext bool
+
+		// stdctx is cancelled when the filter times out, the context of
+		// the request is left alone for the filters after it.
+		stdctx stdcontext.Context
+		cancel stdcontext.CancelFunc
+
+		mutex     sync.Mutex
+		remaining time.Duration
+		start     time.Time
+		timer     *time.Timer
+		timedOut  bool
+	}
+
+	// filterContext is the context passed to the filter with a timeout,
+	// which is done when either the request or the filter call is done.
+	filterContext struct {
+		context.HTTPContext
+		call *filterCall
+	}
+
+	filterOutcome struct {
+		result   string
+		panicErr interface{}
+	}
+)
+
+func newFilterCall(ctx context.HTTPContext, timeout time.Duration) *filterCall {
+	fc := &filterCall{ctx: ctx, timeout: timeout, remaining: timeout}
+	if timeout > 0 {
+		// NOTE: The cancel function is only called on timeout, as the
+		// response body may be read after the filter returns, it's
+		// released along with the context of the request.
+		fc.stdctx, fc.cancel = stdcontext.WithCancel(ctx)
+		fc.resume()
+	}
+	return fc
+}
+
+func (fc *filterCall) resume() {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	if fc.remaining <= 0 || fc.timedOut {
+		return
+	}
+	fc.start = time.Now()
+	fc.timer = time.AfterFunc(fc.remaining, fc.fire)
+}
+
+func (fc *filterCall) pause() {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	if fc.timer != nil && fc.timer.Stop() {
+		fc.remaining -= time.Since(fc.start)
+		fc.timer = nil
+	}
+}
+
+// fire cancels the context of the filter call, cooperative filters stop
+// on ctx.Done(), like WasmHost and JavaScript interrupt their code.
+func (fc *filterCall) fire() {
+	fc.mutex.Lock()
+	fc.timedOut = true
+	fc.mutex.Unlock()
+
+	fc.cancel()
+}
+
+// stop stops the timer and reports whether the filter timed out, the
+// timer is not resumed after it's stopped.
+func (fc *filterCall) stop() bool {
+	fc.pause()
+
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	fc.remaining = 0
+	return fc.timedOut
+}
+
+// enterNext reports whether the filter could call the next handler, it
+// can't after it timed out.
+func (fc *filterCall) enterNext() bool {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	return !fc.timedOut
+}
+
+// Done returns the channel closed when the request is done or the filter
+// timed out.
+func (ctx *filterContext) Done() <-chan struct{} {
+	return ctx.call.stdctx.Done()
+}
+
+// Err returns errFilterTimeout after the filter timed out.
+func (ctx *filterContext) Err() error {
+	if err := ctx.HTTPContext.Err(); err != nil {
+		return err
+	}
+	if ctx.call.stdctx.Err() != nil {
+		return errFilterTimeout
+	}
+	return nil
+}
+
+// Cancelled reports whether the request is cancelled or the filter timed
+// out.
+func (ctx *filterContext) Cancelled() bool {
+	return ctx.HTTPContext.Cancelled() || ctx.call.stdctx.Err() != nil
+}
+
+// CallNextHandler calls the next handler unless the filter timed out, as
+// the pipeline may have moved on to the fallback filters.
+func (ctx *filterContext) CallNextHandler(lastResult string) string {
+	if !ctx.call.enterNext() {
+		return lastResult
+	}
+	return ctx.HTTPContext.CallNextHandler(lastResult)
+}
+
+// UnwrapContext returns the context of the request, as filters with
+// timeouts are called with their own contexts wrapping it. It's the key
+// of the states of the request, e.g. pipelines nested by Bridge share the
+// pipeline context.
+func UnwrapContext(ctx context.HTTPContext) context.HTTPContext {
+	for {
+		fctx, ok := ctx.(*filterContext)
+		if !ok {
+			return ctx
+		}
+		ctx = fctx.HTTPContext
+	}
+}
+
+// callFilter calls the filter with its timeout and panic recovery. The
+// failure is passed to the next handler like results of the filter, so
+// it could be handled by jumpIf, unless the filter has called the next
+// handler before the failure.
+//
+// NOTE: A timed out filter is waited for until it returns, as it shares
+// the context with the filters after it, so only cooperative filters stop
+// early on the timeout.
+func (hp *HTTPPipeline) callFilter(ctx context.HTTPContext, filter *runningFilter, call *filterCall) string {
+	var outcome *filterOutcome
+	if call.timeout > 0 {
+		fctx := &filterContext{HTTPContext: ctx, call: call}
+		outcome = hp.runFilter(fctx, filter, hp.spec.RecoverPanic)
+	} else {
+		outcome = hp.runFilter(ctx, filter, hp.spec.RecoverPanic)
+	}
+	timedOut := call.stop()
+
+	fail := func(failure string, code int) string {
+		ctx.Response().SetStatusCode(code)
+		if call.calledNext {
+			return failure
+		}
+		call.calledNext = true
+		return ctx.CallNextHandler(failure)
+	}
+
+	if outcome.panicErr != nil {
+		if !hp.spec.RecoverPanic {
+			panic(outcome.panicErr)
+		}
+		ctx.AddTag(fmt.Sprintf("filter %s panicked: %v", filter.spec.Name(), outcome.panicErr))
+		filter.counter.observePanic()
+		return fail(ResultFilterPanic, http.StatusInternalServerError)
+	}
+
+	if timedOut {
+		ctx.AddTag(fmt.Sprintf("filter %s timed out", filter.spec.Name()))
+		filter.counter.observeTimeout()
+		return fail(ResultFilterTimeout, http.StatusGatewayTimeout)
+	}
+	return outcome.result
+}
+
+// runFilter runs the filter in the current goroutine.
+func (hp *HTTPPipeline) runFilter(ctx context.HTTPContext, filter *runningFilter, recoverPanic bool) (outcome *filterOutcome) {
+	outcome = &filterOutcome{}
+	if recoverPanic {
+		defer func() {
+			if err := recover(); err != nil {
+				logger.Errorf("filter %s of pipeline %s panicked: %v, stack trace: \n%s\n",
+					filter.spec.Name(), hp.superSpec.Name(), err, debug.Stack())
+				outcome.panicErr = err
+			}
+		}()
+	}
+
+	outcome.result = filter.filter.Handle(ctx)
+	return outcome
+}
@@ -0,0 +1,307 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httppipeline
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/tracing"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	Register(&testFilter{})
+	code := m.Run()
+	os.Exit(code)
+}
+
+type (
+	testFilter struct {
+		spec *testFilterSpec
+		name string
+	}
+
+	testFilterSpec struct {
+		Sleep       string `yaml:"sleep"`
+		Cooperative bool   `yaml:"cooperative"`
+		Panic       bool   `yaml:"panic"`
+	}
+
+	// testRecord is what the filter observed.
+	testRecord struct {
+		called         int
+		err            error
+		pipelineCtxSet bool
+	}
+)
+
+var (
+	testRecordsMutex sync.Mutex
+	testRecords      = map[string]*testRecord{}
+)
+
+func (f *testFilter) Kind() string                    { return "TestFilter" }
+func (f *testFilter) DefaultSpec() interface{}        { return &testFilterSpec{} }
+func (f *testFilter) Description() string             { return "TestFilter is for testing." }
+func (f *testFilter) Results() []string               { return nil }
+func (f *testFilter) Status() interface{}             { return nil }
+func (f *testFilter) Close()                          {}
+func (f *testFilter) Inherit(s *FilterSpec, _ Filter) { f.Init(s) }
+
+func (f *testFilter) Init(s *FilterSpec) {
+	f.spec, f.name = s.FilterSpec().(*testFilterSpec), s.Name()
+}
+
+func (f *testFilter) Handle(ctx context.HTTPContext) string {
+	if f.spec.Panic {
+		panic("test panic")
+	}
+
+	if f.spec.Sleep != "" {
+		d, _ := time.ParseDuration(f.spec.Sleep)
+		if f.spec.Cooperative {
+			select {
+			case <-ctx.Done():
+			case <-time.After(d):
+			}
+		} else {
+			time.Sleep(d)
+		}
+	}
+
+	_, set := GetPipelineContext(ctx)
+	testRecordsMutex.Lock()
+	record := testRecords[f.name]
+	if record == nil {
+		record = &testRecord{}
+		testRecords[f.name] = record
+	}
+	record.called++
+	record.err = ctx.Err()
+	record.pipelineCtxSet = set
+	testRecordsMutex.Unlock()
+
+	return ctx.CallNextHandler("")
+}
+
+func getTestRecord(name string) testRecord {
+	testRecordsMutex.Lock()
+	defer testRecordsMutex.Unlock()
+
+	if record := testRecords[name]; record != nil {
+		return *record
+	}
+	return testRecord{}
+}
+
+func runTestPipeline(t *testing.T, spec string) (*HTTPPipeline, *httptest.ResponseRecorder) {
+	testRecordsMutex.Lock()
+	testRecords = map[string]*testRecord{}
+	testRecordsMutex.Unlock()
+
+	superSpec, err := supervisor.NewSpec(spec)
+	if err != nil {
+		t.Fatalf("new spec failed: %v", err)
+	}
+	hp := &HTTPPipeline{}
+	hp.Init(superSpec, nil)
+	t.Cleanup(hp.Close)
+
+	w := httptest.NewRecorder()
+	stdr := httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx := context.New(w, stdr, tracing.NoopTracing, "test")
+	hp.HandleWithStat(ctx)
+	ctx.Finish()
+	return hp, w
+}
+
+func getTestCounter(hp *HTTPPipeline, name string) (timeouts, panics uint64) {
+	counter := hp.getRunningFilter(name).counter
+	return atomic.LoadUint64(&counter.timeouts), atomic.LoadUint64(&counter.panics)
+}
+
+func TestFilterTimeoutCooperative(t *testing.T) {
+	spec := `
+name: pipeline
+kind: HTTPPipeline
+filterTimeout: 20ms
+flow:
+- filter: slow
+  jumpIf: { filterTimeout: fallback }
+- filter: skipped
+- filter: fallback
+filters:
+- name: slow
+  kind: TestFilter
+  sleep: 10s
+  cooperative: true
+- name: skipped
+  kind: TestFilter
+- name: fallback
+  kind: TestFilter
+`
+	startAt := time.Now()
+	hp, w := runTestPipeline(t, spec)
+	if elapsed := time.Since(startAt); elapsed > time.Second {
+		t.Fatalf("the slow filter is not stopped: %v", elapsed)
+	}
+
+	if timeouts, _ := getTestCounter(hp, "slow"); timeouts != 1 {
+		t.Errorf("unexpected timeouts %d", timeouts)
+	}
+	if getTestRecord("slow").err != errFilterTimeout {
+		t.Errorf("the slow filter should see errFilterTimeout, got %v", getTestRecord("slow").err)
+	}
+	if getTestRecord("skipped").called != 0 {
+		t.Errorf("the next filter of the timed out filter should be skipped")
+	}
+
+	fallback := getTestRecord("fallback")
+	if fallback.called != 1 || fallback.err != nil {
+		t.Errorf("the fallback should run with the live context, got %+v", fallback)
+	}
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("unexpected status code %d", w.Code)
+	}
+}
+
+func TestFilterTimeoutNotCooperative(t *testing.T) {
+	spec := `
+name: pipeline
+kind: HTTPPipeline
+flow:
+- filter: stuck
+  timeout: 20ms
+  jumpIf: { filterTimeout: fallback }
+- filter: skipped
+- filter: fallback
+filters:
+- name: stuck
+  kind: TestFilter
+  sleep: 100ms
+- name: skipped
+  kind: TestFilter
+- name: fallback
+  kind: TestFilter
+`
+	hp, w := runTestPipeline(t, spec)
+
+	// The fallback runs after the stuck filter returns, never along with it.
+	if getTestRecord("stuck").called != 1 {
+		t.Fatalf("the stuck filter should be waited for")
+	}
+	if timeouts, _ := getTestCounter(hp, "stuck"); timeouts != 1 || getTestRecord("fallback").called != 1 {
+		t.Fatalf("the stuck filter should time out to the fallback")
+	}
+	if getTestRecord("stuck").err != errFilterTimeout {
+		t.Errorf("the stuck filter should see errFilterTimeout, got %v", getTestRecord("stuck").err)
+	}
+	if getTestRecord("skipped").called != 0 {
+		t.Errorf("the timed out filter should not call the next filters")
+	}
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("unexpected status code %d", w.Code)
+	}
+}
+
+func TestFilterTimeoutExcludesNext(t *testing.T) {
+	spec := `
+name: pipeline
+kind: HTTPPipeline
+flow:
+- filter: fast
+  timeout: 50ms
+- filter: slow
+filters:
+- name: fast
+  kind: TestFilter
+- name: slow
+  kind: TestFilter
+  sleep: 100ms
+`
+	hp, w := runTestPipeline(t, spec)
+	if timeouts, _ := getTestCounter(hp, "fast"); timeouts != 0 || getTestRecord("slow").called != 1 {
+		t.Fatalf("the time of the next filters should be excluded")
+	}
+	record := getTestRecord("fast")
+	if record.err != nil || !record.pipelineCtxSet {
+		t.Errorf("unexpected record of the fast filter %+v", record)
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status code %d", w.Code)
+	}
+}
+
+func TestFilterPanic(t *testing.T) {
+	for _, timeout := range []string{"", "1s"} {
+		spec := `
+name: pipeline
+kind: HTTPPipeline
+recoverPanic: true
+filterTimeout: "` + timeout + `"
+flow:
+- filter: panic
+  jumpIf: { filterPanic: fallback }
+- filter: skipped
+- filter: fallback
+filters:
+- name: panic
+  kind: TestFilter
+  panic: true
+- name: skipped
+  kind: TestFilter
+- name: fallback
+  kind: TestFilter
+`
+		hp, w := runTestPipeline(t, spec)
+		if _, panics := getTestCounter(hp, "panic"); panics != 1 || getTestRecord("fallback").called != 1 ||
+			getTestRecord("skipped").called != 0 {
+			t.Errorf("timeout %q: the panic should be recovered to the fallback", timeout)
+		}
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("timeout %q: unexpected status code %d", timeout, w.Code)
+		}
+	}
+}
+
+func TestFilterPanicNotRecovered(t *testing.T) {
+	spec := `
+name: pipeline
+kind: HTTPPipeline
+filterTimeout: 1s
+filters:
+- name: panic
+  kind: TestFilter
+  panic: true
+`
+	defer func() {
+		if err := recover(); err != "test panic" {
+			t.Fatalf("the panic should be passed to the caller, got %v", err)
+		}
+	}()
+	runTestPipeline(t, spec)
+}
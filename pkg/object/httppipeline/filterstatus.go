@@ -0,0 +1,243 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httppipeline
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+type (
+	// FilterStatus is the standard status of filters, the pipeline maintains
+	// it for filters of all kinds besides their own status.
+	FilterStatus struct {
+		Kind string `yaml:"kind"`
+		// Counters are the monotonic numbers of executions and results.
+		Counters map[string]uint64 `yaml:"counters"`
+		// Gauges are the numeric fields of the status of the filter.
+		Gauges map[string]float64 `yaml:"gauges"`
+		// LastError is the last non-empty result of the filter.
+		LastError     string `yaml:"lastError,omitempty"`
+		LastErrorTime string `yaml:"lastErrorTime,omitempty"`
+	}
+
+	// filterCounter counts the executions of a filter, it's inherited
+	// across generations to keep the counters monotonic.
+	filterCounter struct {
+		executions uint64
+		timeouts   uint64
+		panics     uint64
+
+		mutex         sync.Mutex
+		results       map[string]uint64
+		lastError     string
+		lastErrorTime time.Time
+	}
+
+	// statusCollector bridges the standard status of filters of all
+	// pipelines into Prometheus metrics.
+	statusCollector struct {
+		mutex     sync.Mutex
+		pipelines map[string]*HTTPPipeline
+	}
+)
+
+var (
+	filterCounterDesc = prometheus.NewDesc("easegress_filter_counter",
+		"The counters of the standard status of filters.",
+		[]string{"pipeline", "filter", "kind", "name"}, nil)
+	filterGaugeDesc = prometheus.NewDesc("easegress_filter_gauge",
+		"The numeric fields of the status of filters.",
+		[]string{"pipeline", "filter", "kind", "name"}, nil)
+
+	collector = &statusCollector{pipelines: map[string]*HTTPPipeline{}}
+)
+
+func init() {
+	prometheus.MustRegister(collector)
+}
+
+func newFilterCounter() *filterCounter {
+	return &filterCounter{results: map[string]uint64{}}
+}
+
+func (fc *filterCounter) observe(result string) {
+	atomic.AddUint64(&fc.executions, 1)
+	if result == "" {
+		return
+	}
+
+	fc.mutex.Lock()
+	fc.results[result]++
+	fc.lastError, fc.lastErrorTime = result, time.Now()
+	fc.mutex.Unlock()
+}
+
+func (fc *filterCounter) observeTimeout() {
+	atomic.AddUint64(&fc.timeouts, 1)
+}
+
+func (fc *filterCounter) observePanic() {
+	atomic.AddUint64(&fc.panics, 1)
+}
+
+func (rf *runningFilter) standardStatus(status interface{}) *FilterStatus {
+	fc := rf.counter
+	s := &FilterStatus{
+		Kind: rf.spec.Kind(),
+		Counters: map[string]uint64{
+			"executions": atomic.LoadUint64(&fc.executions),
+			"timeouts":   atomic.LoadUint64(&fc.timeouts),
+			"panics":     atomic.LoadUint64(&fc.panics),
+		},
+		Gauges: map[string]float64{},
+	}
+
+	fc.mutex.Lock()
+	for result, count := range fc.results {
+		s.Counters[stringtool.Cat("results.", result)] = count
+	}
+	if fc.lastError != "" {
+		s.LastError = fc.lastError
+		s.LastErrorTime = fc.lastErrorTime.Format(time.RFC3339)
+	}
+	fc.mutex.Unlock()
+
+	flattenNumbers(s.Gauges, "", reflect.ValueOf(status))
+	return s
+}
+
+// flattenNumbers collects the numeric fields of v into m, the names of
+// nested fields are joined by dots, like `pools.0.connections.requests`.
+func flattenNumbers(m map[string]float64, prefix string, v reflect.Value) {
+	join := func(name string) string {
+		if prefix == "" {
+			return name
+		}
+		return stringtool.Cat(prefix, ".", name)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			flattenNumbers(m, prefix, v.Elem())
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			flattenNumbers(m, join(name), v.Field(i))
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			flattenNumbers(m, join(iter.Key().String()), iter.Value())
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			flattenNumbers(m, join(strconv.Itoa(i)), v.Index(i))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if prefix != "" {
+			m[prefix] = float64(v.Int())
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if prefix != "" {
+			m[prefix] = float64(v.Uint())
+		}
+	case reflect.Float32, reflect.Float64:
+		if prefix != "" {
+			m[prefix] = v.Float()
+		}
+	case reflect.Bool:
+		if prefix != "" {
+			m[prefix] = 0
+			if v.Bool() {
+				m[prefix] = 1
+			}
+		}
+	}
+}
+
+func (c *statusCollector) add(hp *HTTPPipeline) {
+	c.mutex.Lock()
+	c.pipelines[hp.superSpec.Name()] = hp
+	c.mutex.Unlock()
+}
+
+func (c *statusCollector) remove(hp *HTTPPipeline) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// NOTE: The previous generation is closed after the new one is added.
+	if c.pipelines[hp.superSpec.Name()] == hp {
+		delete(c.pipelines, hp.superSpec.Name())
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- filterCounterDesc
+	ch <- filterGaugeDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *statusCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	pipelines := make([]*HTTPPipeline, 0, len(c.pipelines))
+	for _, hp := range c.pipelines {
+		pipelines = append(pipelines, hp)
+	}
+	c.mutex.Unlock()
+
+	for _, hp := range pipelines {
+		pipeline := hp.superSpec.Name()
+		for _, rf := range hp.runningFilters {
+			s := rf.standardStatus(rf.filter.Status())
+			for name, value := range s.Counters {
+				ch <- prometheus.MustNewConstMetric(filterCounterDesc, prometheus.CounterValue,
+					float64(value), pipeline, rf.spec.Name(), s.Kind, name)
+			}
+			for name, value := range s.Gauges {
+				ch <- prometheus.MustNewConstMetric(filterGaugeDesc, prometheus.GaugeValue,
+					value, pipeline, rf.spec.Name(), s.Kind, name)
+			}
+		}
+	}
+}
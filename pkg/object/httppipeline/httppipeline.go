@@ -24,6 +24,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/megaease/easegress/pkg/cluster"
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/protocol"
@@ -56,6 +57,7 @@ type (
 		muxMapper      protocol.MuxMapper
 		runningFilters []*runningFilter
 		ht             *context.HTTPTemplate
+		kvStore        *kvStore
 	}
 
 	runningFilter struct {
@@ -63,18 +65,30 @@ type (
 		jumpIf     map[string]string
 		rootFilter Filter
 		filter     Filter
+		counter    *filterCounter
+		timeout    time.Duration
 	}
 
 	// Spec describes the HTTPPipeline.
 	Spec struct {
 		Flow    []Flow                   `yaml:"flow" jsonschema:"omitempty"`
 		Filters []map[string]interface{} `yaml:"filters" jsonschema:"required"`
+		// FilterTimeout limits the execution time of each filter, the time
+		// spent in the filters after it is excluded. The context of the
+		// filter is cancelled after the timeout, and the result is
+		// filterTimeout.
+		FilterTimeout string `yaml:"filterTimeout" jsonschema:"omitempty,format=duration"`
+		// RecoverPanic recovers panics of filters, the result of the
+		// panicked filter is filterPanic.
+		RecoverPanic bool `yaml:"recoverPanic" jsonschema:"omitempty"`
 	}
 
 	// Flow controls the flow of pipeline.
 	Flow struct {
 		Filter string            `yaml:"filter" jsonschema:"required,format=urlname"`
 		JumpIf map[string]string `yaml:"jumpIf" jsonschema:"omitempty"`
+		// Timeout overrides the filterTimeout of the pipeline.
+		Timeout string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
 	}
 
 	// Status is the status of HTTPPipeline.
@@ -82,11 +96,18 @@ type (
 		Health string `yaml:"health"`
 
 		Filters map[string]interface{} `yaml:"filters"`
+		// FilterStatuses are the standard status of all filters.
+		FilterStatuses map[string]*FilterStatus `yaml:"filterStatuses"`
 	}
 
 	// PipelineContext contains the context of the HTTPPipeline.
 	PipelineContext struct {
 		FilterStats *FilterStat
+
+		// caller and ht are restored after handling the context of
+		// a nested pipeline, e.g. called by a Bridge filter.
+		caller context.HandlerCaller
+		ht     *context.HTTPTemplate
 	}
 
 	// FilterStat records the statistics of the running filter.
@@ -152,7 +173,7 @@ var runningContexts = sync.Map{}
 func newAndSetPipelineContext(ctx context.HTTPContext) *PipelineContext {
 	pipeCtx := &PipelineContext{}
 
-	runningContexts.Store(ctx, pipeCtx)
+	runningContexts.Store(UnwrapContext(ctx), pipeCtx)
 
 	return pipeCtx
 }
@@ -160,7 +181,7 @@ func newAndSetPipelineContext(ctx context.HTTPContext) *PipelineContext {
 // GetPipelineContext returns the corresponding PipelineContext of the HTTPContext,
 // and a bool flag to represent it succeed or not.
 func GetPipelineContext(ctx context.HTTPContext) (*PipelineContext, bool) {
-	value, ok := runningContexts.Load(ctx)
+	value, ok := runningContexts.Load(UnwrapContext(ctx))
 	if !ok {
 		return nil, false
 	}
@@ -175,7 +196,7 @@ func GetPipelineContext(ctx context.HTTPContext) (*PipelineContext, bool) {
 }
 
 func deletePipelineContext(ctx context.HTTPContext) {
-	runningContexts.Delete(ctx)
+	runningContexts.Delete(UnwrapContext(ctx))
 }
 
 func extractFiltersData(config []byte) interface{} {
@@ -275,7 +296,7 @@ func (s Spec) Validate() (err error) {
 		}
 		expectedResults := spec.RootFilter().Results()
 		for result, label := range f.JumpIf {
-			if !stringtool.StrInSlice(result, expectedResults) {
+			if !isBuiltInResult(result) && !stringtool.StrInSlice(result, expectedResults) {
 				panic(fmt.Errorf("filter %s: result %s is not in %v",
 					f.Filter, result, expectedResults))
 			}
@@ -323,6 +344,16 @@ func (hp *HTTPPipeline) Inherit(superSpec *supervisor.Spec, previousGeneration s
 }
 
 func (hp *HTTPPipeline) reload(previousGeneration *HTTPPipeline) {
+	if previousGeneration != nil {
+		hp.kvStore = previousGeneration.kvStore
+	} else {
+		var cls cluster.Cluster
+		if super := hp.superSpec.Super(); super != nil {
+			cls = super.Cluster()
+		}
+		hp.kvStore = newKVStore(cls, hp.superSpec.Name())
+	}
+
 	runningFilters := make([]*runningFilter, 0)
 	if len(hp.spec.Flow) == 0 {
 		for _, filterSpec := range hp.spec.Filters {
@@ -330,6 +361,7 @@ func (hp *HTTPPipeline) reload(previousGeneration *HTTPPipeline) {
 			if err != nil {
 				panic(err)
 			}
+			spec.pipeline, spec.kvStore = hp.superSpec.Name(), hp.kvStore
 
 			runningFilters = append(runningFilters, &runningFilter{
 				spec: spec,
@@ -351,6 +383,7 @@ func (hp *HTTPPipeline) reload(previousGeneration *HTTPPipeline) {
 			if spec == nil {
 				panic(fmt.Errorf("flow filter %s not found in filters", f.Filter))
 			}
+			spec.pipeline, spec.kvStore = hp.superSpec.Name(), hp.kvStore
 
 			runningFilters = append(runningFilters, &runningFilter{
 				spec:   spec,
@@ -359,6 +392,14 @@ func (hp *HTTPPipeline) reload(previousGeneration *HTTPPipeline) {
 		}
 	}
 
+	filterTimeout, _ := time.ParseDuration(hp.spec.FilterTimeout)
+	for i, runningFilter := range runningFilters {
+		runningFilter.timeout = filterTimeout
+		if len(hp.spec.Flow) > 0 && hp.spec.Flow[i].Timeout != "" {
+			runningFilter.timeout, _ = time.ParseDuration(hp.spec.Flow[i].Timeout)
+		}
+	}
+
 	var filterBuffs []context.FilterBuff
 	for _, runningFilter := range runningFilters {
 		name, kind := runningFilter.spec.Name(), runningFilter.spec.Kind()
@@ -368,14 +409,21 @@ func (hp *HTTPPipeline) reload(previousGeneration *HTTPPipeline) {
 		}
 
 		var prevInstance Filter
+		runningFilter.counter = newFilterCounter()
 		if previousGeneration != nil {
-			runningFilter := previousGeneration.getRunningFilter(name)
-			if runningFilter != nil {
-				prevInstance = runningFilter.filter
+			prevFilter := previousGeneration.getRunningFilter(name)
+			if prevFilter != nil {
+				prevInstance = prevFilter.filter
+				if prevFilter.spec.Kind() == kind {
+					runningFilter.counter = prevFilter.counter
+				}
 			}
 		}
 
 		filter := reflect.New(reflect.TypeOf(rootFilter).Elem()).Interface().(Filter)
+		if injectable, ok := filter.(MuxMapperInjectable); ok {
+			injectable.InjectMuxMapper(hp.muxMapper)
+		}
 		if prevInstance == nil {
 			filter.Init(runningFilter.spec)
 		} else {
@@ -398,6 +446,7 @@ func (hp *HTTPPipeline) reload(previousGeneration *HTTPPipeline) {
 	}
 
 	hp.runningFilters = runningFilters
+	collector.add(hp)
 }
 
 func (hp *HTTPPipeline) getNextFilterIndex(index int, result string) int {
@@ -409,7 +458,7 @@ func (hp *HTTPPipeline) getNextFilterIndex(index int, result string) int {
 	// check the jumpIf table of current filter, return its index if the jump
 	// target is valid and -1 otherwise
 	filter := hp.runningFilters[index]
-	if !stringtool.StrInSlice(result, filter.rootFilter.Results()) {
+	if !isBuiltInResult(result) && !stringtool.StrInSlice(result, filter.rootFilter.Results()) {
 		format := "BUG: invalid result %s not in %v"
 		logger.Errorf(format, result, filter.rootFilter.Results())
 	}
@@ -436,12 +485,29 @@ func (hp *HTTPPipeline) getNextFilterIndex(index int, result string) int {
 
 // Handle is the handler to deal with HTTP
 func (hp *HTTPPipeline) Handle(ctx context.HTTPContext) {
+	hp.HandleWithStat(ctx)
+}
+
+// HandleWithStat handles the context like Handle, and returns the statistics
+// of the filters executed, it's for testing pipelines.
+func (hp *HTTPPipeline) HandleWithStat(ctx context.HTTPContext) *FilterStat {
+	parent, nested := GetPipelineContext(ctx)
 	pipeCtx := newAndSetPipelineContext(ctx)
-	defer deletePipelineContext(ctx)
+	if nested {
+		defer func() {
+			runningContexts.Store(UnwrapContext(ctx), parent)
+			ctx.SetTemplate(parent.ht)
+			ctx.SetHandlerCaller(parent.caller)
+		}()
+	} else {
+		defer deletePipelineContext(ctx)
+	}
 	ctx.SetTemplate(hp.ht)
+	pipeCtx.ht = hp.ht
 
 	filterIndex := -1
 	filterStat := &FilterStat{}
+	var call *filterCall
 
 	handle := func(lastResult string) string {
 		// For saving the `filterIndex`'s filter generated HTTP Response.
@@ -460,11 +526,21 @@ func (hp *HTTPPipeline) Handle(ctx context.HTTPContext) {
 		// state and restore it before return
 		lastIndex := filterIndex
 		lastStat := filterStat
+		lastCall := call
 		defer func() {
 			filterIndex = lastIndex
 			filterStat = lastStat
+			call = lastCall
 		}()
 
+		// The time spent in the next filters is not counted in the
+		// timeout of the current filter.
+		if lastCall != nil {
+			lastCall.calledNext = true
+			lastCall.pause()
+			defer lastCall.resume()
+		}
+
 		filterIndex = hp.getNextFilterIndex(filterIndex, lastResult)
 		if filterIndex == len(hp.runningFilters) {
 			return "" // reach the end of pipeline
@@ -475,6 +551,13 @@ func (hp *HTTPPipeline) Handle(ctx context.HTTPContext) {
 		filter := hp.runningFilters[filterIndex]
 		name := filter.spec.Name()
 
+		// NOTE: The remaining filters are skipped after the client closed
+		// the connection, as nobody waits for the response.
+		if ctx.ClientDisconnected() {
+			ctx.AddTag(stringtool.Cat("pipeline: client closed connection, skip ", name))
+			return lastResult
+		}
+
 		if err := ctx.SaveReqToTemplate(name); err != nil {
 			format := "save http req failed, dict is %#v err is %v"
 			logger.Errorf(format, ctx.Template().GetDict(), err)
@@ -483,23 +566,27 @@ func (hp *HTTPPipeline) Handle(ctx context.HTTPContext) {
 		logger.Debugf("filter %s saved request dict %v", name, ctx.Template().GetDict())
 		filterStat = &FilterStat{Name: name, Kind: filter.spec.Kind()}
 
+		call = newFilterCall(ctx, filter.timeout)
 		startTime := time.Now()
-		result := filter.filter.Handle(ctx)
+		result := hp.callFilter(ctx, filter, call)
 
 		filterStat.Duration = time.Since(startTime)
 		filterStat.Result = result
+		filter.counter.observe(result)
 
 		lastStat.Next = append(lastStat.Next, filterStat)
 		return result
 	}
 
 	ctx.SetHandlerCaller(handle)
+	pipeCtx.caller = handle
 	handle("")
 
 	if len(filterStat.Next) > 0 {
 		pipeCtx.FilterStats = filterStat.Next[0]
 	}
 	ctx.AddTag(stringtool.Cat("pipeline: ", pipeCtx.log()))
+	return pipeCtx.FilterStats
 }
 
 func (hp *HTTPPipeline) getRunningFilter(name string) *runningFilter {
@@ -515,11 +602,14 @@ func (hp *HTTPPipeline) getRunningFilter(name string) *runningFilter {
 // Status returns Status generated by Runtime.
 func (hp *HTTPPipeline) Status() *supervisor.Status {
 	s := &Status{
-		Filters: make(map[string]interface{}),
+		Filters:        make(map[string]interface{}),
+		FilterStatuses: make(map[string]*FilterStatus),
 	}
 
 	for _, runningFilter := range hp.runningFilters {
-		s.Filters[runningFilter.spec.Name()] = runningFilter.filter.Status()
+		status := runningFilter.filter.Status()
+		s.Filters[runningFilter.spec.Name()] = status
+		s.FilterStatuses[runningFilter.spec.Name()] = runningFilter.standardStatus(status)
 	}
 
 	return &supervisor.Status{
@@ -529,7 +619,9 @@ func (hp *HTTPPipeline) Status() *supervisor.Status {
 
 // Close closes HTTPPipeline.
 func (hp *HTTPPipeline) Close() {
+	collector.remove(hp)
 	for _, runningFilter := range hp.runningFilters {
 		runningFilter.filter.Close()
 	}
+	hp.kvStore.close()
 }
@@ -0,0 +1,73 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httppipeline
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/megaease/easegress/pkg/cluster"
+)
+
+// kvStore is the key-value store shared by all filters of a pipeline,
+// it's created on the first use and inherited by the next generations.
+type kvStore struct {
+	cls       cluster.Cluster
+	namespace string
+
+	mutex sync.Mutex
+	store *cluster.KVStore
+}
+
+func newKVStore(cls cluster.Cluster, pipeline string) *kvStore {
+	return &kvStore{
+		cls:       cls,
+		namespace: pipeline,
+	}
+}
+
+func (s *kvStore) get() (*cluster.KVStore, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.store != nil {
+		return s.store, nil
+	}
+
+	if s.cls == nil {
+		return nil, fmt.Errorf("key-value store of pipeline %s is unavailable", s.namespace)
+	}
+
+	store, err := cluster.NewKVStore(s.cls, s.namespace)
+	if err != nil {
+		return nil, fmt.Errorf("create key-value store of pipeline %s failed: %v", s.namespace, err)
+	}
+	s.store = store
+
+	return store, nil
+}
+
+func (s *kvStore) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.store != nil {
+		s.store.Close()
+		s.store = nil
+	}
+}
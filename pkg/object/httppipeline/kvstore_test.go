@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httppipeline
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/option"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+func newStandaloneCluster(t *testing.T) cluster.Cluster {
+	opt := option.New()
+	opt.Name = "standalone-member"
+	opt.Standalone = true
+
+	cls, err := cluster.New(opt)
+	if err != nil {
+		t.Fatalf("new standalone cluster failed: %v", err)
+	}
+	t.Cleanup(func() {
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		cls.Close(wg)
+		wg.Wait()
+	})
+	return cls
+}
+
+func TestKVStoreUnavailable(t *testing.T) {
+	spec := &FilterSpec{}
+	if _, err := spec.KVStore(); err == nil {
+		t.Errorf("key-value store should be unavailable without pipeline")
+	}
+
+	spec.kvStore = newKVStore(nil, "pipeline")
+	if _, err := spec.KVStore(); err == nil {
+		t.Errorf("key-value store should be unavailable without cluster")
+	}
+}
+
+func TestKVStore(t *testing.T) {
+	cls := newStandaloneCluster(t)
+	s := newKVStore(cls, "pipeline")
+	spec := &FilterSpec{kvStore: s}
+
+	store, err := spec.KVStore()
+	if err != nil {
+		t.Fatalf("get key-value store failed: %v", err)
+	}
+	if err = store.Put("flag", "on", 0); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+
+	// The store is created once and shared by the filters.
+	other, _ := (&FilterSpec{kvStore: s}).KVStore()
+	if other != store {
+		t.Errorf("key-value store should be shared")
+	}
+
+	// A new store is created after closing, and the data is kept in
+	// the cluster.
+	s.close()
+	store, err = spec.KVStore()
+	if err != nil {
+		t.Fatalf("get key-value store failed: %v", err)
+	}
+	defer s.close()
+	if store == other {
+		t.Errorf("key-value store should be recreated")
+	}
+	if n, err := store.Incr("counter", 1, 0); n != 1 || err != nil {
+		t.Errorf("want 1, got %d, %v", n, err)
+	}
+	if v, _ := cls.Get(cls.Layout().KVStorePrefix("pipeline") + "flag"); v == nil {
+		t.Errorf("flag should be kept in the cluster")
+	}
+}
+
+func TestKVStoreInherit(t *testing.T) {
+	yamlConfig := `
+name: pipeline
+kind: HTTPPipeline
+filters:
+- kind: TestFilter
+  name: filter
+`
+	superSpec, err := supervisor.NewSpec(yamlConfig)
+	if err != nil {
+		t.Fatalf("new spec failed: %v", err)
+	}
+	hp := &HTTPPipeline{}
+	hp.Init(superSpec, nil)
+
+	next := &HTTPPipeline{}
+	next.Inherit(superSpec, hp, nil)
+	defer next.Close()
+
+	if next.kvStore != hp.kvStore {
+		t.Errorf("key-value store should be inherited")
+	}
+	if spec := next.getRunningFilter("filter").spec; spec.kvStore != hp.kvStore {
+		t.Errorf("key-value store should be passed to the filters")
+	}
+}
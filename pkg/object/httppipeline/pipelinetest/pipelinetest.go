@@ -0,0 +1,276 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package pipelinetest runs test cases of HTTPPipeline specs locally by the
+// real filter implementations, so that gateway configs could be tested in CI.
+// Filters must be registered by their packages before running the tests.
+package pipelinetest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/tracing"
+)
+
+type (
+	// Spec describes the test cases of a pipeline.
+	Spec struct {
+		// Pipeline is the spec file of the pipeline, relative to the test file.
+		Pipeline string  `yaml:"pipeline"`
+		Cases    []*Case `yaml:"cases"`
+	}
+
+	// Case is a test case, it sends the request to the pipeline and checks
+	// the response by the expectation.
+	Case struct {
+		Name    string  `yaml:"name"`
+		Request Request `yaml:"request"`
+		Expect  Expect  `yaml:"expect"`
+	}
+
+	// Request is the request of the case.
+	Request struct {
+		Method  string            `yaml:"method"`
+		URL     string            `yaml:"url"`
+		Headers map[string]string `yaml:"headers"`
+		Body    string            `yaml:"body"`
+	}
+
+	// Expect is the expectation of the case, zero values aren't checked.
+	Expect struct {
+		// Results are the expected results of filters, empty means success.
+		Results    map[string]string `yaml:"results"`
+		StatusCode int               `yaml:"statusCode"`
+		Headers    map[string]string `yaml:"headers"`
+		Body       *BodyMatcher      `yaml:"body"`
+	}
+
+	// BodyMatcher matches the body of the response.
+	BodyMatcher struct {
+		Equals   *string  `yaml:"equals"`
+		Contains []string `yaml:"contains"`
+		Regexp   string   `yaml:"regexp"`
+		// JSON maps GJSON paths to the expected values.
+		JSON map[string]string `yaml:"json"`
+	}
+
+	// Report is the report of the test cases of a file.
+	Report struct {
+		File   string        `yaml:"file"`
+		Passed int           `yaml:"passed"`
+		Failed int           `yaml:"failed"`
+		Cases  []*CaseReport `yaml:"cases"`
+	}
+
+	// CaseReport is the report of a test case.
+	CaseReport struct {
+		Name     string   `yaml:"name"`
+		Passed   bool     `yaml:"passed"`
+		Failures []string `yaml:"failures,omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.Pipeline == "" {
+		return fmt.Errorf("pipeline is required")
+	}
+	if len(spec.Cases) == 0 {
+		return fmt.Errorf("no cases")
+	}
+
+	for i, c := range spec.Cases {
+		if c.Name == "" {
+			return fmt.Errorf("name of case %d is required", i)
+		}
+		if c.Request.URL == "" {
+			return fmt.Errorf("url of case %s is required", c.Name)
+		}
+		if c.Expect.Body != nil && c.Expect.Body.Regexp != "" {
+			if _, err := regexp.Compile(c.Expect.Body.Regexp); err != nil {
+				return fmt.Errorf("invalid regexp of case %s: %v", c.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RunFile runs the test cases of the file.
+func RunFile(file string) (*Report, error) {
+	buff, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &Spec{}
+	err = yaml.UnmarshalStrict(buff, spec)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal %s failed: %v", file, err)
+	}
+	if err = spec.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", file, err)
+	}
+
+	pipelineFile := spec.Pipeline
+	if !filepath.IsAbs(pipelineFile) {
+		pipelineFile = filepath.Join(filepath.Dir(file), pipelineFile)
+	}
+	buff, err = ioutil.ReadFile(pipelineFile)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := Run(string(buff), spec.Cases)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", pipelineFile, err)
+	}
+	report.File = file
+	return report, nil
+}
+
+// Run runs the test cases against the pipeline spec.
+func Run(pipelineSpec string, cases []*Case) (report *Report, err error) {
+	superSpec, err := supervisor.NewSpec(pipelineSpec)
+	if err != nil {
+		return nil, err
+	}
+	if superSpec.Kind() != httppipeline.Kind {
+		return nil, fmt.Errorf("kind %s is not %s", superSpec.Kind(), httppipeline.Kind)
+	}
+
+	hp := &httppipeline.HTTPPipeline{}
+	defer func() {
+		// NOTE: Filters panic on specs they couldn't run with.
+		if r := recover(); r != nil {
+			report, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	hp.Init(superSpec, nil)
+	defer hp.Close()
+
+	report = &Report{}
+	for _, c := range cases {
+		cr := runCase(hp, c)
+		if cr.Passed {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		report.Cases = append(report.Cases, cr)
+	}
+	return report, nil
+}
+
+func runCase(hp *httppipeline.HTTPPipeline, c *Case) *CaseReport {
+	method := c.Request.Method
+	if method == "" {
+		method = "GET"
+	}
+	stdr := httptest.NewRequest(method, c.Request.URL, strings.NewReader(c.Request.Body))
+	for key, value := range c.Request.Headers {
+		stdr.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+
+	ctx := context.New(w, stdr, tracing.NoopTracing, "pipelinetest")
+	stat := hp.HandleWithStat(ctx)
+	ctx.Finish()
+
+	results := map[string]string{}
+	var collect func(stat *httppipeline.FilterStat)
+	collect = func(stat *httppipeline.FilterStat) {
+		results[stat.Name] = stat.Result
+		for _, next := range stat.Next {
+			collect(next)
+		}
+	}
+	if stat != nil {
+		collect(stat)
+	}
+
+	cr := &CaseReport{Name: c.Name}
+	fail := func(format string, a ...interface{}) {
+		cr.Failures = append(cr.Failures, fmt.Sprintf(format, a...))
+	}
+
+	for _, filter := range sortedKeys(c.Expect.Results) {
+		expected := c.Expect.Results[filter]
+		result, executed := results[filter]
+		if !executed {
+			fail("filter %s is not executed", filter)
+		} else if result != expected {
+			fail("result of filter %s is %q, expected %q", filter, result, expected)
+		}
+	}
+
+	if c.Expect.StatusCode != 0 && w.Code != c.Expect.StatusCode {
+		fail("status code is %d, expected %d", w.Code, c.Expect.StatusCode)
+	}
+
+	for _, key := range sortedKeys(c.Expect.Headers) {
+		expected := c.Expect.Headers[key]
+		if value := w.Header().Get(key); value != expected {
+			fail("header %s is %q, expected %q", key, value, expected)
+		}
+	}
+
+	if m := c.Expect.Body; m != nil {
+		body := w.Body.String()
+		if m.Equals != nil && body != *m.Equals {
+			fail("body is %q, expected %q", body, *m.Equals)
+		}
+		for _, s := range m.Contains {
+			if !strings.Contains(body, s) {
+				fail("body doesn't contain %q", s)
+			}
+		}
+		if m.Regexp != "" && !regexp.MustCompile(m.Regexp).MatchString(body) {
+			fail("body doesn't match %s", m.Regexp)
+		}
+		for _, path := range sortedKeys(m.JSON) {
+			expected := m.JSON[path]
+			if value := gjson.Get(body, path); !value.Exists() || value.String() != expected {
+				fail("json %s of body is %q, expected %q", path, value.String(), expected)
+			}
+		}
+	}
+
+	cr.Passed = len(cr.Failures) == 0
+	return cr
+}
+
+// sortedKeys returns the sorted keys, so that the failures are in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pipelinetest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/megaease/easegress/pkg/filter/mock"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+const pipelineSpec = `
+name: pipeline
+kind: HTTPPipeline
+flow:
+- filter: mock
+filters:
+- name: mock
+  kind: Mock
+  rules:
+  - path: /users/1
+    code: 200
+    headers:
+      Content-Type: application/json
+    body: '{"name": "alice", "age": 30}'
+`
+
+const testSpec = `
+pipeline: pipeline.yaml
+cases:
+- name: user
+  request:
+    url: /users/1
+  expect:
+    results:
+      mock: mocked
+    statusCode: 200
+    headers:
+      Content-Type: application/json
+    body:
+      contains: [alice]
+      regexp: '"age": \d+'
+      json:
+        name: alice
+- name: unknown user
+  request:
+    method: POST
+    url: /users/2
+  expect:
+    results:
+      mock: mocked
+    statusCode: 201
+    body:
+      equals: ''
+`
+
+func TestRunFile(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "pipeline.yaml"), []byte(pipelineSpec), 0o644)
+	file := filepath.Join(dir, "pipeline_test.yaml")
+	os.WriteFile(file, []byte(testSpec), 0o644)
+
+	report, err := RunFile(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Passed != 1 || report.Failed != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if !report.Cases[0].Passed {
+		t.Errorf("case user should pass, failures: %v", report.Cases[0].Failures)
+	}
+
+	failures := report.Cases[1].Failures
+	if len(failures) != 2 {
+		t.Fatalf("unexpected failures %v", failures)
+	}
+	if failures[0] != `result of filter mock is "", expected "mocked"` {
+		t.Errorf("unexpected failure %s", failures[0])
+	}
+	if failures[1] != "status code is 200, expected 201" {
+		t.Errorf("unexpected failure %s", failures[1])
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := &Spec{}
+	if spec.Validate() == nil {
+		t.Error("validate should fail")
+	}
+
+	spec.Pipeline = "pipeline.yaml"
+	spec.Cases = []*Case{{Name: "case", Request: Request{URL: "/"}}}
+	if spec.Validate() != nil {
+		t.Error("validate should succeed")
+	}
+
+	spec.Cases[0].Expect.Body = &BodyMatcher{Regexp: "("}
+	if spec.Validate() == nil {
+		t.Error("validate should fail")
+	}
+}
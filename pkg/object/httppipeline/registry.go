@@ -22,6 +22,7 @@ import (
 	"reflect"
 
 	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/protocol"
 )
 
 type (
@@ -60,6 +61,12 @@ type (
 		// Close closes itself.
 		Close()
 	}
+
+	// MuxMapperInjectable is the filter calling other pipelines, the
+	// pipeline injects its MuxMapper before initializing the filter.
+	MuxMapperInjectable interface {
+		InjectMuxMapper(mapper protocol.MuxMapper)
+	}
 )
 
 var filterRegistry = map[string]Filter{}
@@ -20,6 +20,7 @@ package httppipeline
 import (
 	"fmt"
 
+	"github.com/megaease/easegress/pkg/cluster"
 	"github.com/megaease/easegress/pkg/supervisor"
 	"github.com/megaease/easegress/pkg/util/yamltool"
 	"github.com/megaease/easegress/pkg/v"
@@ -35,6 +36,8 @@ type (
 		meta       *FilterMetaSpec
 		filterSpec interface{}
 		rootFilter Filter
+		pipeline   string
+		kvStore    *kvStore
 	}
 
 	// FilterMetaSpec is metadata for all specs.
@@ -111,6 +114,20 @@ func (s *FilterSpec) Super() *supervisor.Supervisor {
 	return s.super
 }
 
+// Pipeline returns the name of the pipeline which the filter belongs to.
+func (s *FilterSpec) Pipeline() string {
+	return s.pipeline
+}
+
+// KVStore returns the key-value store shared by all filters of the pipeline
+// and across members, it's for counters, feature toggles, etc.
+func (s *FilterSpec) KVStore() (*cluster.KVStore, error) {
+	if s.kvStore == nil {
+		return nil, fmt.Errorf("key-value store is unavailable")
+	}
+	return s.kvStore.get()
+}
+
 // Name returns name.
 func (s *FilterSpec) Name() string { return s.meta.Name }
 
@@ -35,7 +35,10 @@ type (
 		ipFilterChan     *ipfilter.IPFilters
 		notFound         bool
 		methodNotAllowed bool
+		notAcceptable    bool
 		path             *muxPath
+		// backend overrides the backend of the path if it's not empty.
+		backend string
 	}
 )
 
@@ -29,10 +29,17 @@ import (
 	"github.com/megaease/easegress/pkg/protocol"
 	"github.com/megaease/easegress/pkg/supervisor"
 	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/clientcert"
+	"github.com/megaease/easegress/pkg/util/earlydata"
+	"github.com/megaease/easegress/pkg/util/headersanitizer"
 	"github.com/megaease/easegress/pkg/util/httpheader"
 	"github.com/megaease/easegress/pkg/util/httpstat"
 	"github.com/megaease/easegress/pkg/util/ipfilter"
+	"github.com/megaease/easegress/pkg/util/pathnorm"
+	"github.com/megaease/easegress/pkg/util/propagation"
+	"github.com/megaease/easegress/pkg/util/spiffe"
 	"github.com/megaease/easegress/pkg/util/stringtool"
+	"github.com/megaease/easegress/pkg/util/tlsfingerprint"
 	"github.com/megaease/easegress/pkg/util/topn"
 )
 
@@ -40,6 +47,9 @@ type (
 	mux struct {
 		httpStat *httpstat.HTTPStat
 		topN     *topn.TopN
+		// fingerprints records the fingerprints of the TLS clients,
+		// the server records them only if tlsFingerprint is set.
+		fingerprints *tlsfingerprint.Recorder
 
 		rules atomic.Value // *muxRules
 	}
@@ -56,6 +66,11 @@ type (
 		ipFilter     *ipfilter.IPFilter
 		ipFilterChan *ipfilter.IPFilters
 
+		pathNormalizer *pathnorm.Normalizer
+		sanitizer      *headersanitizer.Sanitizer
+		certHeaders    *clientcert.Headers
+		tlsFingerprint *TLSFingerprint
+
 		rules []*muxRule
 	}
 
@@ -81,6 +96,12 @@ type (
 		rewriteTarget string
 		backend       string
 		headers       []*Header
+		versioning    *versioning
+		spiffeIDs     []string
+		clientCert    *clientcert.Matcher
+		// allowEarlyData allows non-idempotent requests in early data.
+		allowEarlyData bool
+		propagator     *propagation.Propagator
 	}
 )
 
@@ -219,7 +240,28 @@ func newMuxPath(parentIPFilters *ipfilter.IPFilters, path *Path) *muxPath {
 		methods:       path.Methods,
 		backend:       path.Backend,
 		headers:       path.Headers,
+		versioning:    newVersioning(path.Versioning),
+		spiffeIDs:     path.SPIFFEIDs,
+		clientCert:    newClientCertMatcher(path.ClientCertificate),
+
+		allowEarlyData: path.AllowEarlyData,
+		propagator:     newPropagator(path.Propagation),
+	}
+}
+
+func newClientCertMatcher(spec *clientcert.Spec) *clientcert.Matcher {
+	if spec == nil {
+		return nil
+	}
+	return clientcert.NewMatcher(spec)
+}
+
+func newPropagator(spec *propagation.Spec) *propagation.Propagator {
+	if spec == nil {
+		return nil
 	}
+
+	return propagation.New(spec)
 }
 
 func (mp *muxPath) pass(ctx context.HTTPContext) bool {
@@ -279,8 +321,9 @@ func (mp *muxPath) matchHeaders(ctx context.HTTPContext) bool {
 
 func newMux(httpStat *httpstat.HTTPStat, topN *topn.TopN, mapper protocol.MuxMapper) *mux {
 	m := &mux{
-		httpStat: httpStat,
-		topN:     topN,
+		httpStat:     httpStat,
+		topN:         topN,
+		fingerprints: tlsfingerprint.NewRecorder(),
 	}
 
 	m.rules.Store(&muxRules{
@@ -328,6 +371,19 @@ func (m *mux) reloadRules(superSpec *supervisor.Spec, muxMapper protocol.MuxMapp
 		rules.cache = newCache(spec.CacheSize)
 	}
 
+	if spec.PathNormalization != nil {
+		rules.pathNormalizer = pathnorm.New(spec.PathNormalization)
+	}
+
+	if spec.HeaderSanitization != nil {
+		rules.sanitizer = headersanitizer.New(spec.HeaderSanitization)
+	}
+
+	if spec.MTLS != nil && spec.MTLS.Headers != nil {
+		rules.certHeaders = spec.MTLS.Headers
+	}
+	rules.tlsFingerprint = spec.TLSFingerprint
+
 	for i := 0; i < len(rules.rules); i++ {
 		specRule := spec.Rules[i]
 
@@ -345,9 +401,51 @@ func (m *mux) reloadRules(superSpec *supervisor.Spec, muxMapper protocol.MuxMapp
 	m.rules.Store(rules)
 }
 
+// injectFingerprint sets the fingerprint headers of the TLS client, the
+// headers from clients are always deleted so they can't be spoofed.
+func (m *mux) injectFingerprint(tf *TLSFingerprint, stdr *http.Request) {
+	header := tf.Header
+	if header == "" {
+		header = tlsfingerprint.DefaultHeader
+	}
+	stdr.Header.Del(header)
+	if tf.RawHeader != "" {
+		stdr.Header.Del(tf.RawHeader)
+	}
+
+	fp := m.fingerprints.Lookup(stdr.RemoteAddr)
+	if fp == nil {
+		return
+	}
+	stdr.Header.Set(header, fp.JA3Hash)
+	if tf.RawHeader != "" {
+		stdr.Header.Set(tf.RawHeader, fp.JA3)
+	}
+}
+
 func (m *mux) ServeHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 	rules := m.rules.Load().(*muxRules)
 
+	// NOTE: The path is normalized before creating the context, which
+	// copies the path, so routing, filters and servers see the same path.
+	var normalizeErr error
+	if rules.pathNormalizer != nil {
+		normalizeErr = rules.pathNormalizer.Normalize(stdr.URL)
+	}
+
+	// NOTE: The internal headers are stripped before everything, so
+	// neither routing nor filters see the spoofed ones.
+	var stripped []string
+	if rules.sanitizer != nil {
+		stripped = rules.sanitizer.Sanitize(stdr)
+	}
+	if rules.certHeaders != nil {
+		rules.certHeaders.Inject(stdr.Header, clientcert.FromTLS(stdr.TLS))
+	}
+	if rules.tlsFingerprint != nil {
+		m.injectFingerprint(rules.tlsFingerprint, stdr)
+	}
+
 	ctx := context.New(stdw, stdr, rules.tracer, rules.superSpec.Name())
 	defer ctx.Finish()
 	ctx.OnFinish(func() {
@@ -356,6 +454,16 @@ func (m *mux) ServeHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 		m.topN.Stat(ctx)
 	})
 
+	if len(stripped) > 0 {
+		ctx.AddTag(stringtool.Cat("strip untrusted headers: ", strings.Join(stripped, ",")))
+	}
+
+	if normalizeErr != nil {
+		ctx.AddTag(stringtool.Cat("normalize path failed: ", normalizeErr.Error()))
+		ctx.Response().SetStatusCode(http.StatusBadRequest)
+		return
+	}
+
 	ci := rules.getCacheItem(ctx)
 	if ci != nil {
 		m.handleRequestWithCache(rules, ctx, ci)
@@ -394,19 +502,25 @@ func (m *mux) ServeHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 				return
 			}
 
-			if !path.hasHeaders() {
+			if !path.hasHeaders() && path.versioning == nil {
 				ci = &cacheItem{ipFilterChan: path.ipFilterChain, path: path}
 				rules.putCacheItem(ctx, ci)
 				m.handleRequestWithCache(rules, ctx, ci)
 				return
 			}
 
-			if path.matchHeaders(ctx) {
-				// NOTE: No cache for the request matching headers.
-				ci = &cacheItem{ipFilterChan: path.ipFilterChain, path: path}
-				m.handleRequestWithCache(rules, ctx, ci)
-				return
+			if path.hasHeaders() && !path.matchHeaders(ctx) {
+				continue
+			}
+
+			// NOTE: No cache for the request matching headers or versions.
+			ci = &cacheItem{ipFilterChan: path.ipFilterChain, path: path}
+			if path.versioning != nil {
+				backend, acceptable := path.versioning.negotiate(ctx)
+				ci.backend, ci.notAcceptable = backend, !acceptable
 			}
+			m.handleRequestWithCache(rules, ctx, ci)
+			return
 		}
 	}
 
@@ -433,10 +547,49 @@ func (m *mux) handleRequestWithCache(rules *muxRules, ctx context.HTTPContext, c
 		ctx.Response().SetStatusCode(http.StatusNotFound)
 	case ci.methodNotAllowed:
 		ctx.Response().SetStatusCode(http.StatusMethodNotAllowed)
+	case ci.notAcceptable:
+		ctx.AddTag("api version not acceptable")
+		ctx.Response().SetStatusCode(http.StatusNotAcceptable)
 	case ci.path != nil:
-		handler, exists := rules.muxMapper.GetHandler(ci.path.backend)
+		if len(ci.path.spiffeIDs) != 0 {
+			id := spiffe.PeerID(ctx.Request().Std().TLS)
+			if !spiffe.Match(ci.path.spiffeIDs, id) {
+				ctx.AddTag(stringtool.Cat("spiffe id ", id, " not allow"))
+				ctx.Response().SetStatusCode(http.StatusForbidden)
+				return
+			}
+		}
+
+		if ci.path.clientCert != nil {
+			if !ci.path.clientCert.Match(clientcert.FromTLS(ctx.Request().Std().TLS)) {
+				ctx.AddTag("client certificate not allow")
+				ctx.Response().SetStatusCode(http.StatusForbidden)
+				return
+			}
+		}
+
+		if !earlydata.Check(ctx.Request().Method(), ctx.Request().Header().Std(), ci.path.allowEarlyData) {
+			ctx.AddTag("early data not allow")
+			ctx.Response().SetStatusCode(http.StatusTooEarly)
+			return
+		}
+
+		if ci.path.propagator != nil {
+			err := ci.path.propagator.Apply(ctx.Request().Header().Std())
+			if err != nil {
+				ctx.AddTag(err.Error())
+				ctx.Response().SetStatusCode(http.StatusBadRequest)
+				return
+			}
+		}
+
+		backend := ci.path.backend
+		if ci.backend != "" {
+			backend = ci.backend
+		}
+		handler, exists := rules.muxMapper.GetHandler(backend)
 		if !exists {
-			ctx.AddTag(stringtool.Cat("backend ", ci.path.backend, " not found"))
+			ctx.AddTag(stringtool.Cat("backend ", backend, " not found"))
 			ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
 			return
 		}
@@ -31,6 +31,9 @@ import (
 	"github.com/megaease/easegress/pkg/protocol"
 	"github.com/megaease/easegress/pkg/supervisor"
 	"github.com/megaease/easegress/pkg/util/httpstat"
+	"github.com/megaease/easegress/pkg/util/keysigner"
+	"github.com/megaease/easegress/pkg/util/spiffe"
+	"github.com/megaease/easegress/pkg/util/tlsprofile"
 	"github.com/megaease/easegress/pkg/util/topn"
 )
 
@@ -76,8 +79,10 @@ type (
 		eventChan chan interface{}
 
 		// status
-		state atomic.Value // stateType
-		err   atomic.Value // error
+		state      atomic.Value // stateType
+		err        atomic.Value // error
+		keySigners atomic.Value // map[string]*keysigner.Signer
+		spiffe     atomic.Value // *spiffe.Source
 
 		httpStat      *httpstat.HTTPStat
 		topN          *topn.TopN
@@ -91,6 +96,9 @@ type (
 		State stateType `yaml:"state"`
 		Error string    `yaml:"error,omitempty"`
 
+		KeySigners map[string]*keysigner.Status `yaml:"keySigners,omitempty"`
+		SPIFFE     *spiffe.Status               `yaml:"spiffe,omitempty"`
+
 		*httpstat.Status
 		TopN *topn.Status `yaml:"topN"`
 	}
@@ -107,6 +115,8 @@ func newRuntime(superSpec *supervisor.Spec, muxMapper protocol.MuxMapper) *runti
 	r.mux = newMux(r.httpStat, r.topN, muxMapper)
 	r.setState(stateNil)
 	r.setError(errNil)
+	r.keySigners.Store(map[string]*keysigner.Signer{})
+	r.spiffe.Store((*spiffe.Source)(nil))
 
 	go r.fsm()
 	go r.checkFailed()
@@ -125,12 +135,34 @@ func (r *runtime) Close() {
 func (r *runtime) Status() *Status {
 	health := r.getError().Error()
 
+	var keySigners map[string]*keysigner.Status
+	for domain, signer := range r.keySigners.Load().(map[string]*keysigner.Signer) {
+		if keySigners == nil {
+			keySigners = map[string]*keysigner.Status{}
+		}
+		status := signer.Status()
+		keySigners[domain] = status
+		if health == "" && !status.Healthy {
+			health = fmt.Sprintf("key signer of %s unavailable: %s", domain, status.LastError)
+		}
+	}
+
+	var spiffeStatus *spiffe.Status
+	if source := r.spiffe.Load().(*spiffe.Source); source != nil {
+		spiffeStatus = source.Status()
+		if health == "" && !spiffeStatus.Healthy {
+			health = fmt.Sprintf("spiffe unavailable: %s", spiffeStatus.LastError)
+		}
+	}
+
 	return &Status{
-		Health: health,
-		State:  r.getState(),
-		Error:  r.getError().Error(),
-		Status: r.httpStat.Status(),
-		TopN:   r.topN.Status(),
+		Health:     health,
+		State:      r.getState(),
+		Error:      r.getError().Error(),
+		KeySigners: keySigners,
+		SPIFFE:     spiffeStatus,
+		Status:     r.httpStat.Status(),
+		TopN:       r.topN.Status(),
 	}
 }
 
@@ -251,9 +283,26 @@ func (r *runtime) startServer() {
 	}
 	srv.SetKeepAlivesEnabled(r.spec.KeepAlive)
 
-	if r.spec.HTTPS {
-		tlsConfig, _ := r.spec.tlsConfig()
+	r.keySigners.Store(map[string]*keysigner.Signer{})
+	r.releaseSPIFFE()
+	if r.spec.HTTPS && r.spec.SPIFFE != nil {
+		source := spiffe.Acquire(r.spec.SPIFFE.SocketPath)
+		tlsConfig := source.ServerTLSConfig(r.spec.SPIFFE.AllowedIDs)
+		// NOTE: The profile has been validated.
+		tlsprofile.Apply(tlsConfig, r.spec.TLSProfile)
+		srv.TLSConfig = tlsConfig
+		r.spiffe.Store(source)
+	} else if r.spec.HTTPS {
+		tlsConfig, keySigners, _ := r.spec.tlsConfig()
 		srv.TLSConfig = tlsConfig
+		r.keySigners.Store(keySigners)
+		for domain, signer := range keySigners {
+			go probeKeySigner(domain, signer)
+		}
+	}
+	if r.spec.TLSFingerprint != nil {
+		srv.TLSConfig = r.mux.fingerprints.Wrap(srv.TLSConfig)
+		srv.ConnState = r.mux.fingerprints.ConnState
 	}
 
 	r.server = srv
@@ -262,8 +311,21 @@ func (r *runtime) startServer() {
 	r.setError(nil)
 
 	if r.spec.HTTP3 {
+		// NOTE: HTTP/3 accepts early data of resumed sessions, and it
+		// doesn't tell whether a request is in early data, so resumption
+		// is disabled unless early data is accepted. The TLS config is
+		// cloned for HTTP/3 only, the shared one is left alone.
+		tlsConfig := srv.TLSConfig.Clone()
+		if !r.spec.EarlyData {
+			tlsConfig.SessionTicketsDisabled = true
+		}
 		r.server3 = &http3.Server{
-			Server: r.server,
+			Server: &http.Server{
+				Addr:        srv.Addr,
+				Handler:     srv.Handler,
+				IdleTimeout: srv.IdleTimeout,
+				TLSConfig:   tlsConfig,
+			},
 		}
 		go r.runHTTP3Server(r.startNum)
 	} else {
@@ -281,6 +343,13 @@ func (r *runtime) startServer() {
 	}
 }
 
+func probeKeySigner(domain string, signer *keysigner.Signer) {
+	err := signer.Probe()
+	if err != nil {
+		logger.Errorf("probe key signer of %s failed: %v", domain, err)
+	}
+}
+
 func (r *runtime) runHTTP3Server(startNum uint64) {
 	err := r.server3.ListenAndServe()
 	if err != http.ErrServerClosed {
@@ -306,10 +375,18 @@ func (r *runtime) runHTTP1And2Server(limitListener *LimitListener, https bool, s
 	}
 }
 
+func (r *runtime) releaseSPIFFE() {
+	if source := r.spiffe.Load().(*spiffe.Source); source != nil {
+		r.spiffe.Store((*spiffe.Source)(nil))
+		source.Release()
+	}
+}
+
 func (r *runtime) closeServer() {
 	if r.server == nil {
 		return
 	}
+	defer r.releaseSPIFFE()
 
 	if r.server3 != nil {
 		err := r.server3.Close()
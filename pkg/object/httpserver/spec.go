@@ -19,23 +19,34 @@ package httpserver
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
 	"fmt"
 	"regexp"
 
 	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/clientcert"
+	"github.com/megaease/easegress/pkg/util/headersanitizer"
 	"github.com/megaease/easegress/pkg/util/ipfilter"
+	"github.com/megaease/easegress/pkg/util/keysigner"
+	"github.com/megaease/easegress/pkg/util/pathnorm"
+	"github.com/megaease/easegress/pkg/util/propagation"
+	"github.com/megaease/easegress/pkg/util/spiffe"
+	"github.com/megaease/easegress/pkg/util/tlsprofile"
 )
 
 type (
 	// Spec describes the HTTPServer.
 	Spec struct {
 		HTTP3            bool          `yaml:"http3" jsonschema:"omitempty"`
+		EarlyData        bool          `yaml:"earlyData" jsonschema:"omitempty"`
 		Port             uint16        `yaml:"port" jsonschema:"required,minimum=1"`
 		KeepAlive        bool          `yaml:"keepAlive" jsonschema:"required"`
 		KeepAliveTimeout string        `yaml:"keepAliveTimeout" jsonschema:"omitempty,format=duration"`
 		MaxConnections   uint32        `yaml:"maxConnections" jsonschema:"omitempty,minimum=1"`
 		HTTPS            bool          `yaml:"https" jsonschema:"required"`
+		TLSProfile       string        `yaml:"tlsProfile" jsonschema:"omitempty"`
 		CacheSize        uint32        `yaml:"cacheSize" jsonschema:"omitempty"`
 		XForwardedFor    bool          `yaml:"xForwardedFor" jsonschema:"omitempty"`
 		Tracing          *tracing.Spec `yaml:"tracing" jsonschema:"omitempty"`
@@ -49,6 +60,29 @@ type (
 		Certs map[string]string `yaml:"certs" jsonschema:"omitempty"`
 		// Keys saved as map, key is domain name, value is secret
 		Keys map[string]string `yaml:"keys" jsonschema:"omitempty"`
+		// KeySigners saved as map, key is domain name, value is the
+		// key management service holding the private key of the cert,
+		// it's used when there is no secret in Keys.
+		KeySigners map[string]*keysigner.Spec `yaml:"keySigners" jsonschema:"omitempty"`
+		// SPIFFE replaces the certs by the X.509-SVIDs from SPIRE agents,
+		// and requires client X.509-SVIDs.
+		SPIFFE *spiffe.Spec `yaml:"spiffe,omitempty" jsonschema:"omitempty"`
+		// MTLS authenticates clients by certificates.
+		MTLS *MTLS `yaml:"mtls,omitempty" jsonschema:"omitempty"`
+
+		// PathNormalization normalizes the paths of requests before
+		// routing, paths are untouched if it's nil.
+		PathNormalization *pathnorm.Spec `yaml:"pathNormalization,omitempty" jsonschema:"omitempty"`
+
+		// HeaderSanitization strips the internal headers of requests
+		// from untrusted clients before routing, headers are untouched
+		// if it's nil.
+		HeaderSanitization *headersanitizer.Spec `yaml:"headerSanitization,omitempty" jsonschema:"omitempty"`
+
+		// TLSFingerprint carries the JA3 fingerprints of TLS clients to
+		// pipelines, they are set before routing like the identities
+		// of client certs.
+		TLSFingerprint *TLSFingerprint `yaml:"tlsFingerprint,omitempty" jsonschema:"omitempty"`
 
 		IPFilter *ipfilter.Spec `yaml:"ipFilter,omitempty" jsonschema:"omitempty"`
 		Rules    []*Rule        `yaml:"rules" jsonschema:"omitempty"`
@@ -79,6 +113,40 @@ type (
 		Methods       []string       `yaml:"methods,omitempty" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
 		Backend       string         `yaml:"backend" jsonschema:"required"`
 		Headers       []*Header      `yaml:"headers" jsonschema:"omitempty"`
+		Versioning    *Versioning    `yaml:"versioning,omitempty" jsonschema:"omitempty"`
+		// SPIFFEIDs is the allowlist of client SPIFFE IDs, it requires spiffe.
+		SPIFFEIDs []string `yaml:"spiffeIDs,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+		// ClientCertificate requires client certificates meeting it, it
+		// requires mtls or spiffe.
+		ClientCertificate *clientcert.Spec `yaml:"clientCertificate,omitempty" jsonschema:"omitempty"`
+		// AllowEarlyData allows requests in early data of non-idempotent
+		// methods, which are rejected by 425 by default.
+		AllowEarlyData bool `yaml:"allowEarlyData,omitempty" jsonschema:"omitempty"`
+		// Propagation controls the trace context and baggage headers of clients.
+		Propagation *propagation.Spec `yaml:"propagation,omitempty" jsonschema:"omitempty"`
+	}
+
+	// MTLS describes the authentication of clients by certificates.
+	MTLS struct {
+		// CACerts are the PEM encoded certs of CAs verifying client
+		// certs, the trust bundle of SPIRE is used with spiffe.
+		CACerts []string `yaml:"caCerts" jsonschema:"omitempty"`
+		// Required rejects the handshakes without client certs, or the
+		// clients without certs are served except by the paths requiring
+		// certs. Client certs are always required with spiffe.
+		Required bool `yaml:"required" jsonschema:"omitempty"`
+		// Headers carry the identities of clients to pipelines, they are
+		// set before routing, so paths could route by them.
+		Headers *clientcert.Headers `yaml:"headers,omitempty" jsonschema:"omitempty"`
+	}
+
+	// TLSFingerprint describes the headers of the JA3 fingerprints.
+	TLSFingerprint struct {
+		// Header is the header of the JA3 hashes, X-EG-JA3 by default.
+		Header string `yaml:"header" jsonschema:"omitempty"`
+		// RawHeader is the header of the JA3 strings, which are not
+		// set if it's empty.
+		RawHeader string `yaml:"rawHeader" jsonschema:"omitempty"`
 	}
 
 	// Header is the third level entry of router. A header entry is always under a specific path entry, that is to mean
@@ -100,11 +168,63 @@ func (spec *Spec) Validate() error {
 		return fmt.Errorf("https is disabled when http3 enabled")
 	}
 
-	if spec.HTTPS {
+	err := tlsprofile.Validate(spec.TLSProfile)
+	if err != nil {
+		return err
+	}
+	if spec.HTTP3 && spec.TLSProfile == tlsprofile.FIPS {
+		return fmt.Errorf("http3 requires tls 1.3, which is not supported by tls profile %s", spec.TLSProfile)
+	}
+	// NOTE: The TLS stack of HTTP/1.1 and HTTP/2 doesn't support early data.
+	if spec.EarlyData && !spec.HTTP3 {
+		return fmt.Errorf("earlyData is supported by http3 only")
+	}
+
+	if spec.SPIFFE != nil && !spec.HTTPS {
+		return fmt.Errorf("https is disabled when spiffe enabled")
+	}
+	if spec.MTLS != nil {
+		if !spec.HTTPS {
+			return fmt.Errorf("https is disabled when mtls enabled")
+		}
+		if spec.SPIFFE != nil && len(spec.MTLS.CACerts) != 0 {
+			return fmt.Errorf("caCerts of mtls conflict with spiffe")
+		}
+		if spec.SPIFFE == nil && len(spec.MTLS.CACerts) == 0 {
+			return fmt.Errorf("caCerts of mtls are required without spiffe")
+		}
+	}
+	if spec.TLSFingerprint != nil {
+		if !spec.HTTPS {
+			return fmt.Errorf("https is disabled when tlsFingerprint enabled")
+		}
+		if spec.HTTP3 {
+			return fmt.Errorf("tlsFingerprint is not supported by http3")
+		}
+	}
+	for _, rule := range spec.Rules {
+		for _, path := range rule.Paths {
+			if path.ClientCertificate != nil && spec.MTLS == nil && spec.SPIFFE == nil {
+				return fmt.Errorf("clientCertificate of paths requires mtls or spiffe")
+			}
+			if len(path.SPIFFEIDs) == 0 {
+				continue
+			}
+			if spec.SPIFFE == nil {
+				return fmt.Errorf("spiffeIDs of paths require spiffe")
+			}
+			err := spiffe.ValidateIDs(path.SPIFFEIDs)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if spec.HTTPS && spec.SPIFFE == nil {
 		if spec.CertBase64 == "" && spec.KeyBase64 == "" && len(spec.Certs) == 0 && len(spec.Keys) == 0 {
 			return fmt.Errorf("certBase64/keyBase64, certs/keys are both empty when https enabled")
 		}
-		_, err := spec.tlsConfig()
+		_, _, err := spec.tlsConfig()
 		if err != nil {
 			return err
 		}
@@ -113,7 +233,37 @@ func (spec *Spec) Validate() error {
 	return nil
 }
 
-func (spec *Spec) tlsConfig() (*tls.Config, error) {
+// References returns the names of the backends referenced by the rules.
+func (spec *Spec) References() []string {
+	backends := []string{}
+	seen := map[string]bool{}
+	add := func(backend string) {
+		if backend != "" && !seen[backend] {
+			seen[backend] = true
+			backends = append(backends, backend)
+		}
+	}
+
+	for _, rule := range spec.Rules {
+		for _, path := range rule.Paths {
+			add(path.Backend)
+			for _, header := range path.Headers {
+				add(header.Backend)
+			}
+			if path.Versioning != nil {
+				for _, version := range path.Versioning.Versions {
+					add(version.Backend)
+				}
+			}
+		}
+	}
+
+	return backends
+}
+
+// tlsConfig returns the tls config and the key signers of the certs,
+// key signers are keyed by the domain names.
+func (spec *Spec) tlsConfig() (*tls.Config, map[string]*keysigner.Signer, error) {
 	var certificates []tls.Certificate
 	if spec.CertBase64 != "" && spec.KeyBase64 != "" {
 		// Prefer add CertBase64 and KeyBase64
@@ -121,28 +271,89 @@ func (spec *Spec) tlsConfig() (*tls.Config, error) {
 		keyPem, _ := base64.StdEncoding.DecodeString(spec.KeyBase64)
 		cert, err := tls.X509KeyPair(certPem, keyPem)
 		if err != nil {
-			return nil, fmt.Errorf("generate x509 key pair failed: %v", err)
+			return nil, nil, fmt.Errorf("generate x509 key pair failed: %v", err)
 		}
 		certificates = append(certificates, cert)
 	}
 
+	signers := map[string]*keysigner.Signer{}
 	for k, v := range spec.Certs {
 		if secret, exists := spec.Keys[k]; exists {
 			cert, err := tls.X509KeyPair([]byte(v), []byte(secret))
 			if err != nil {
-				return nil, fmt.Errorf("generate x509 key pair for %s failed: %s ", k, err)
+				return nil, nil, fmt.Errorf("generate x509 key pair for %s failed: %s ", k, err)
 			}
 			certificates = append(certificates, cert)
+		} else if signerSpec, exists := spec.KeySigners[k]; exists {
+			cert, signer, err := signerCertificate([]byte(v), signerSpec)
+			if err != nil {
+				return nil, nil, fmt.Errorf("generate certificate with key signer for %s failed: %v", k, err)
+			}
+			certificates = append(certificates, cert)
+			signers[k] = signer
 		} else {
-			return nil, fmt.Errorf("certs %s hasn't secret corresponded to it", k)
+			return nil, nil, fmt.Errorf("certs %s hasn't secret corresponded to it", k)
 		}
 	}
 
 	if len(certificates) == 0 {
-		return nil, fmt.Errorf("none valid certs and secret")
+		return nil, nil, fmt.Errorf("none valid certs and secret")
+	}
+
+	config := &tls.Config{Certificates: certificates}
+	err := tlsprofile.Apply(config, spec.TLSProfile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if spec.MTLS != nil {
+		pool := x509.NewCertPool()
+		for i, ca := range spec.MTLS.CACerts {
+			if !pool.AppendCertsFromPEM([]byte(ca)) {
+				return nil, nil, fmt.Errorf("no valid cert in caCerts[%d] of mtls", i)
+			}
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if spec.MTLS.Required {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return config, signers, nil
+}
+
+// signerCertificate parses the PEM encoded cert chain, and uses the key
+// signer as its private key.
+func signerCertificate(certPEM []byte, spec *keysigner.Spec) (tls.Certificate, *keysigner.Signer, error) {
+	var cert tls.Certificate
+	for {
+		var block *pem.Block
+		block, certPEM = pem.Decode(certPEM)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return cert, nil, fmt.Errorf("no certificate found")
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return cert, nil, err
+	}
+	cert.Leaf = leaf
+
+	signer, err := keysigner.New(spec, leaf.PublicKey)
+	if err != nil {
+		return cert, nil, err
 	}
+	cert.PrivateKey = signer
 
-	return &tls.Config{Certificates: certificates}, nil
+	return cert, signer, nil
 }
 
 func (h *Header) initHeaderRoute() {
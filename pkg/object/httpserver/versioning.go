@@ -0,0 +1,227 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/semver"
+)
+
+const (
+	defaultVersionHeader         = "API-Version"
+	defaultVersionMediaTypeParam = "version"
+)
+
+type (
+	// Versioning routes requests under a path to backends by the API
+	// version negotiated from the version header or the Accept header.
+	Versioning struct {
+		Header             string     `yaml:"header" jsonschema:"omitempty"`
+		MediaTypeParameter string     `yaml:"mediaTypeParameter" jsonschema:"omitempty"`
+		Versions           []*Version `yaml:"versions" jsonschema:"required"`
+		// Strict rejects requests asking for unknown versions with 406,
+		// they are routed to the backend of the path otherwise.
+		Strict bool `yaml:"strict" jsonschema:"omitempty"`
+	}
+
+	// Version is an API version served by a backend.
+	Version struct {
+		Range      string   `yaml:"range" jsonschema:"omitempty"`
+		MediaTypes []string `yaml:"mediaTypes" jsonschema:"omitempty,uniqueItems=true"`
+		Backend    string   `yaml:"backend" jsonschema:"required"`
+	}
+
+	versioning struct {
+		header   string
+		param    string
+		strict   bool
+		vary     string
+		versions []*muxVersion
+	}
+
+	muxVersion struct {
+		r          *semver.Range
+		mediaTypes []*mediaType
+		backend    string
+	}
+
+	mediaType struct {
+		typ    string
+		params map[string]string
+		q      float64
+	}
+)
+
+// Validate validates Versioning.
+func (v *Versioning) Validate() error {
+	if len(v.Versions) == 0 {
+		return fmt.Errorf("versions are empty")
+	}
+	for _, version := range v.Versions {
+		if version.Range == "" && len(version.MediaTypes) == 0 {
+			return fmt.Errorf("both of range and mediaTypes are empty for backend: %s", version.Backend)
+		}
+		if version.Range != "" {
+			if _, err := semver.ParseRange(version.Range); err != nil {
+				return err
+			}
+		}
+		for _, mt := range version.MediaTypes {
+			if _, _, err := mime.ParseMediaType(mt); err != nil {
+				return fmt.Errorf("invalid media type %s: %v", mt, err)
+			}
+		}
+	}
+	return nil
+}
+
+func newVersioning(spec *Versioning) *versioning {
+	if spec == nil {
+		return nil
+	}
+
+	v := &versioning{
+		header: spec.Header,
+		param:  spec.MediaTypeParameter,
+		strict: spec.Strict,
+	}
+	if v.header == "" {
+		v.header = defaultVersionHeader
+	}
+	if v.param == "" {
+		v.param = defaultVersionMediaTypeParam
+	}
+	v.vary = v.header + ", " + httpheader.KeyAccept
+
+	for _, version := range spec.Versions {
+		mv := &muxVersion{backend: version.Backend}
+		if version.Range != "" {
+			r, err := semver.ParseRange(version.Range)
+			// defensive programming
+			if err != nil {
+				logger.Errorf("BUG: parse range %s failed: %v", version.Range, err)
+			}
+			mv.r = r
+		}
+		for _, s := range version.MediaTypes {
+			if mt := parseMediaType(s); mt != nil {
+				mv.mediaTypes = append(mv.mediaTypes, mt)
+			}
+		}
+		v.versions = append(v.versions, mv)
+	}
+
+	return v
+}
+
+func parseMediaType(s string) *mediaType {
+	typ, params, err := mime.ParseMediaType(s)
+	if err != nil {
+		return nil
+	}
+
+	mt := &mediaType{typ: typ, params: params, q: 1}
+	if q, ok := params["q"]; ok {
+		delete(params, "q")
+		if f, err := strconv.ParseFloat(q, 64); err == nil {
+			mt.q = f
+		}
+	}
+	return mt
+}
+
+// accepts returns whether the media range accepts the media type of the
+// version, all parameters of the version must be in the media range.
+func (mt *mediaType) accepts(version *mediaType) bool {
+	if mt.typ != version.typ {
+		return false
+	}
+	for k, v := range version.params {
+		if mt.params[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// negotiate returns the backend of the negotiated version, it's empty if
+// no version is asked for. The returned bool reports whether the asked
+// version is acceptable.
+func (v *versioning) negotiate(ctx context.HTTPContext) (string, bool) {
+	ctx.Response().Header().Add(httpheader.KeyVary, v.vary)
+
+	header := ctx.Request().Header()
+	if s := strings.TrimSpace(header.Get(v.header)); s != "" {
+		return v.matchVersion(s)
+	}
+
+	var accepts []*mediaType
+	for _, s := range header.GetAll(httpheader.KeyAccept) {
+		for _, item := range strings.Split(s, ",") {
+			if mt := parseMediaType(item); mt != nil && mt.q > 0 {
+				accepts = append(accepts, mt)
+			}
+		}
+	}
+	sort.SliceStable(accepts, func(i, j int) bool {
+		return accepts[i].q > accepts[j].q
+	})
+
+	asked := false
+	for _, accept := range accepts {
+		for _, version := range v.versions {
+			for _, mt := range version.mediaTypes {
+				if accept.accepts(mt) {
+					return version.backend, true
+				}
+			}
+		}
+
+		if s, ok := accept.params[v.param]; ok {
+			asked = true
+			if backend, ok := v.matchVersion(s); ok {
+				return backend, true
+			}
+		}
+	}
+
+	if asked {
+		return "", !v.strict
+	}
+	return "", true
+}
+
+func (v *versioning) matchVersion(s string) (string, bool) {
+	version, err := semver.Parse(s)
+	if err == nil {
+		for _, mv := range v.versions {
+			if mv.r != nil && mv.r.Match(version) {
+				return mv.backend, true
+			}
+		}
+	}
+	return "", !v.strict
+}
@@ -114,6 +114,7 @@ func (ic *IngressController) reload() {
 	}
 
 	ic.namespace = fmt.Sprintf("%s/%s", ic.superSpec.Name(), "ingresscontroller")
+	ic.tc.SetOwner(ic.namespace, &trafficcontroller.Owner{Kind: Kind, Name: ic.superSpec.Name()})
 	ic.stopCh = make(chan struct{})
 
 	ic.wg.Add(1)
@@ -70,6 +70,8 @@ func New(superSpec *supervisor.Spec) *IngressController {
 	}
 
 	store := storage.New(superSpec.Name(), superSpec.Super().Cluster())
+	namespace := fmt.Sprintf("%s/%s", superSpec.Name(), "ingresscontroller")
+	tc.SetOwner(namespace, &trafficcontroller.Owner{Kind: superSpec.Kind(), Name: superSpec.Name()})
 
 	ic := &IngressController{
 		superSpec: superSpec,
@@ -78,7 +80,7 @@ func New(superSpec *supervisor.Spec) *IngressController {
 		informer:  informer.NewInformer(store, ""),
 		service:   service.New(superSpec),
 		tc:        tc,
-		namespace: fmt.Sprintf("%s/%s", superSpec.Name(), "ingresscontroller"),
+		namespace: namespace,
 
 		backendHTTPPipelines: make(map[string]*supervisor.ObjectEntity),
 		ingressBackends:      make(map[string]struct{}),
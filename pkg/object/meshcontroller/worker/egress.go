@@ -73,13 +73,16 @@ func NewEgressServer(superSpec *supervisor.Spec, super *supervisor.Supervisor,
 		panic(fmt.Errorf("BUG: want *TrafficController, got %T", entity.Instance()))
 	}
 
+	namespace := fmt.Sprintf("%s/%s", superSpec.Name(), "egress")
+	tc.SetOwner(namespace, &trafficcontroller.Owner{Kind: superSpec.Kind(), Name: superSpec.Name()})
+
 	return &EgressServer{
 		super:     super,
 		superSpec: superSpec,
 
 		inf:         inf,
 		tc:          tc,
-		namespace:   fmt.Sprintf("%s/%s", superSpec.Name(), "egress"),
+		namespace:   namespace,
 		pipelines:   make(map[string]*supervisor.ObjectEntity),
 		serviceName: serviceName,
 		service:     service,
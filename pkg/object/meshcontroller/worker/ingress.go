@@ -61,11 +61,14 @@ func NewIngressServer(superSpec *supervisor.Spec, super *supervisor.Supervisor,
 		panic(fmt.Errorf("BUG: want *TrafficController, got %T", entity.Instance()))
 	}
 
+	namespace := fmt.Sprintf("%s/%s", superSpec.Name(), "ingress")
+	tc.SetOwner(namespace, &trafficcontroller.Owner{Kind: superSpec.Kind(), Name: superSpec.Name()})
+
 	return &IngressServer{
 		super: super,
 
 		tc:        tc,
-		namespace: fmt.Sprintf("%s/%s", superSpec.Name(), "ingress"),
+		namespace: namespace,
 
 		pipelines:   make(map[string]*supervisor.ObjectEntity),
 		httpServer:  nil,
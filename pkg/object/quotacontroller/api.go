@@ -0,0 +1,99 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package quotacontroller
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/api"
+)
+
+const (
+	apiGroupName = "quotacontroller_admin"
+	apiPrefix    = "/quotas/{name}/usage"
+)
+
+var registerOnce sync.Once
+
+func registerAPIs() {
+	api.RegisterAPIs(&api.Group{
+		Group: apiGroupName,
+		Entries: []*api.Entry{
+			{Path: apiPrefix, Method: "GET", Handler: listUsage},
+			{Path: apiPrefix + "/{quota}/{consumer}", Method: "DELETE", Handler: resetUsage},
+		},
+	})
+}
+
+func writeYAML(w http.ResponseWriter, v interface{}) {
+	buff, err := yaml.Marshal(v)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", v, err))
+	}
+	w.Header().Set("Content-Type", "text/vnd.yaml")
+	w.Write(buff)
+}
+
+// listUsage lists the usage of the consumers in all retained windows,
+// the quota and the consumer could be filtered by query parameters.
+func listUsage(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	qc := getController(name)
+	if qc == nil {
+		api.HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("quota controller %s not found", name))
+		return
+	}
+
+	query := r.URL.Query()
+	usages := qc.usages(query.Get("quota"), query.Get("consumer"))
+	if usages == nil {
+		usages = []*Usage{}
+	}
+	writeYAML(w, usages)
+}
+
+// resetUsage resets the usage of the consumer in the current window,
+// e.g. after the consumer purchased more requests.
+func resetUsage(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	qc := getController(name)
+	if qc == nil {
+		api.HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("quota controller %s not found", name))
+		return
+	}
+
+	quota := chi.URLParam(r, "quota")
+	q := qc.quota(quota)
+	if q == nil {
+		api.HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("quota %s not found in %s", quota, name))
+		return
+	}
+
+	start, _ := windowOf(q.Window, time.Now().In(qc.location))
+	err := qc.counter.reset(counterKey(quota, start, chi.URLParam(r, "consumer")))
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package quotacontroller
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+type (
+	// counter counts requests locally and synchronizes the counts with
+	// the key-value store periodically, so the store is not accessed by
+	// every request, at the cost of quotas being slightly exceeded.
+	counter struct {
+		// store is nil if the cluster is unavailable, the counts are
+		// kept in memory then.
+		store *cluster.KVStore
+
+		mutex  sync.Mutex
+		counts map[string]*count
+	}
+
+	count struct {
+		// synced is the count of all members at the last synchronization.
+		synced int64
+		// pending is the local count not yet synchronized.
+		pending  int64
+		expireAt time.Time
+	}
+)
+
+// counterKey returns the key of the counter of the consumer in the
+// window of the quota starting at start, the consumer is the last part,
+// as it may contain '/'.
+func counterKey(quota string, start time.Time, consumer string) string {
+	return quota + "/" + strconv.FormatInt(start.Unix(), 10) + "/" + consumer
+}
+
+func parseCounterKey(key string) (quota string, start time.Time, consumer string, ok bool) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) != 3 {
+		return "", time.Time{}, "", false
+	}
+	sec, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, "", false
+	}
+	return parts[0], time.Unix(sec, 0), parts[2], true
+}
+
+func newCounter(store *cluster.KVStore) *counter {
+	return &counter{store: store, counts: make(map[string]*count)}
+}
+
+// take takes a request from the counter, it returns the count including
+// the request and true, or the count and false if the limit is reached.
+func (c *counter) take(key string, limit int64, ttl time.Duration) (int64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cnt := c.counts[key]
+	if cnt == nil {
+		cnt = &count{expireAt: time.Now().Add(ttl)}
+		// NOTE: Start from the count persisted in the store, which is
+		// kept across restarts.
+		if c.store != nil {
+			if value, ok := c.store.Get(key); ok {
+				cnt.synced, _ = strconv.ParseInt(value, 10, 64)
+			}
+		}
+		c.counts[key] = cnt
+	}
+
+	used := cnt.synced + cnt.pending
+	if used >= limit {
+		return used, false
+	}
+	cnt.pending++
+	return used + 1, true
+}
+
+// sync synchronizes the counts with the store.
+func (c *counter) sync() {
+	type snapshot struct {
+		delta    int64
+		expireAt time.Time
+	}
+
+	now := time.Now()
+	snapshots := map[string]snapshot{}
+
+	c.mutex.Lock()
+	for key, cnt := range c.counts {
+		if !cnt.expireAt.After(now) {
+			delete(c.counts, key)
+			continue
+		}
+		if c.store == nil {
+			cnt.synced += cnt.pending
+			cnt.pending = 0
+			continue
+		}
+		snapshots[key] = snapshot{delta: cnt.pending, expireAt: cnt.expireAt}
+		cnt.pending = 0
+	}
+	c.mutex.Unlock()
+
+	for key, s := range snapshots {
+		var total int64
+		var err error
+		if s.delta > 0 {
+			total, err = c.store.Incr(key, s.delta, s.expireAt.Sub(now))
+		} else {
+			// NOTE: Pick up the counts of other members, a missing key
+			// means the count is reset.
+			if value, ok := c.store.Get(key); ok {
+				total, err = strconv.ParseInt(value, 10, 64)
+			}
+		}
+
+		c.mutex.Lock()
+		if cnt := c.counts[key]; cnt != nil {
+			if err != nil {
+				cnt.pending += s.delta
+			} else {
+				cnt.synced = total
+			}
+		}
+		c.mutex.Unlock()
+
+		if err != nil {
+			logger.Errorf("sync quota counter %s failed: %v", key, err)
+		}
+	}
+}
+
+// usage returns the counts of all keys, including the ones of other
+// members and the ones not yet synchronized.
+func (c *counter) usage() map[string]int64 {
+	result := map[string]int64{}
+
+	if c.store != nil {
+		for _, key := range c.store.Keys() {
+			if value, ok := c.store.Get(key); ok {
+				result[key], _ = strconv.ParseInt(value, 10, 64)
+			}
+		}
+	}
+
+	now := time.Now()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, cnt := range c.counts {
+		if !cnt.expireAt.After(now) {
+			continue
+		}
+		if c.store == nil {
+			result[key] = cnt.synced + cnt.pending
+		} else {
+			result[key] += cnt.pending
+		}
+	}
+	return result
+}
+
+// reset resets the count of the key.
+func (c *counter) reset(key string) error {
+	c.mutex.Lock()
+	delete(c.counts, key)
+	c.mutex.Unlock()
+
+	if c.store == nil {
+		return nil
+	}
+	return c.store.Delete(key)
+}
+
+func (c *counter) close() {
+	if c.store != nil {
+		c.store.Close()
+	}
+}
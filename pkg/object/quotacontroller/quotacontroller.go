@@ -0,0 +1,328 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package quotacontroller tracks the usage of consumers in long windows,
+// such as requests per day or per month, the counters are persisted in
+// the cluster storage, and the usage is exposed by the admin API for
+// billing.
+package quotacontroller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Kind is the kind of QuotaController.
+	Kind = "QuotaController"
+
+	// WindowHour is the window of an hour.
+	WindowHour = "hour"
+	// WindowDay is the window of a calendar day.
+	WindowDay = "day"
+	// WindowWeek is the window of a calendar week starting on Monday.
+	WindowWeek = "week"
+	// WindowMonth is the window of a calendar month.
+	WindowMonth = "month"
+
+	// storeNamespacePrefix is the prefix of the namespace of the key-value
+	// store, '@' is not allowed in object names, so it never conflicts
+	// with pipelines.
+	storeNamespacePrefix = "@quota/"
+)
+
+func init() {
+	supervisor.Register(&QuotaController{})
+}
+
+type (
+	// QuotaController tracks the usage of consumers and enforces
+	// their quotas, with the help of the QuotaEnforcer filter.
+	QuotaController struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		location     *time.Location
+		syncInterval time.Duration
+		counter      *counter
+
+		done chan struct{}
+		wg   sync.WaitGroup
+	}
+
+	// Spec describes the QuotaController.
+	Spec struct {
+		Quotas []*QuotaSpec `yaml:"quotas" jsonschema:"required,minItems=1"`
+		// TimeZone is the time zone of the calendar windows, like
+		// Asia/Shanghai, default is UTC.
+		TimeZone string `yaml:"timeZone" jsonschema:"omitempty"`
+		// SyncInterval is the interval to synchronize the counters with
+		// the cluster, quotas could be exceeded slightly in an interval.
+		SyncInterval string `yaml:"syncInterval" jsonschema:"omitempty,format=duration"`
+		// Retention is the number of ended windows whose usage is kept
+		// for billing.
+		Retention int          `yaml:"retention" jsonschema:"omitempty,minimum=1"`
+		Headers   *HeadersSpec `yaml:"headers" jsonschema:"omitempty"`
+	}
+
+	// QuotaSpec is a quota of every consumer.
+	QuotaSpec struct {
+		Name   string `yaml:"name" jsonschema:"required"`
+		Window string `yaml:"window" jsonschema:"required,enum=hour,enum=day,enum=week,enum=month"`
+		Limit  int64  `yaml:"limit" jsonschema:"required,minimum=1"`
+		// Consumers overrides the limit of the consumers.
+		Consumers map[string]int64 `yaml:"consumers" jsonschema:"omitempty"`
+	}
+
+	// HeadersSpec is the names of the response headers about the quota,
+	// a header is not set if its name is empty.
+	HeadersSpec struct {
+		Limit     string `yaml:"limit" jsonschema:"omitempty"`
+		Remaining string `yaml:"remaining" jsonschema:"omitempty"`
+		Reset     string `yaml:"reset" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of QuotaController.
+	Status struct {
+		Quotas []*QuotaStatus `yaml:"quotas"`
+	}
+
+	// QuotaStatus is the status of a quota.
+	QuotaStatus struct {
+		Name      string    `yaml:"name"`
+		Start     time.Time `yaml:"start"`
+		End       time.Time `yaml:"end"`
+		Consumers int       `yaml:"consumers"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if _, err := time.LoadLocation(spec.TimeZone); err != nil {
+		return fmt.Errorf("invalid time zone %s: %v", spec.TimeZone, err)
+	}
+
+	names := map[string]bool{}
+	for _, q := range spec.Quotas {
+		if names[q.Name] {
+			return fmt.Errorf("quota %s is defined more than once", q.Name)
+		}
+		names[q.Name] = true
+
+		for c, limit := range q.Consumers {
+			if limit < 0 {
+				return fmt.Errorf("quota %s: limit of consumer %s is negative", q.Name, c)
+			}
+		}
+	}
+	return nil
+}
+
+// limit returns the limit of the consumer.
+func (q *QuotaSpec) limit(consumer string) int64 {
+	if limit, ok := q.Consumers[consumer]; ok {
+		return limit
+	}
+	return q.Limit
+}
+
+// windowOf returns the window of the quota containing t.
+func windowOf(window string, t time.Time) (start, end time.Time) {
+	y, m, d := t.Date()
+	switch window {
+	case WindowHour:
+		start = time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location())
+		return start, start.Add(time.Hour)
+	case WindowWeek:
+		// NOTE: Weeks start on Monday, time.Weekday starts on Sunday.
+		offset := (int(t.Weekday()) + 6) % 7
+		start = time.Date(y, m, d-offset, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 0, 7)
+	case WindowMonth:
+		start = time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 1, 0)
+	default:
+		start = time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 0, 1)
+	}
+}
+
+// Category returns the category of QuotaController.
+func (qc *QuotaController) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+
+// Kind returns the kind of QuotaController.
+func (qc *QuotaController) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of QuotaController.
+func (qc *QuotaController) DefaultSpec() interface{} {
+	return &Spec{
+		TimeZone:     "UTC",
+		SyncInterval: "5s",
+		Retention:    3,
+		Headers: &HeadersSpec{
+			Limit:     "X-RateLimit-Limit",
+			Remaining: "X-RateLimit-Remaining",
+			Reset:     "X-RateLimit-Reset",
+		},
+	}
+}
+
+// Init initializes QuotaController.
+func (qc *QuotaController) Init(superSpec *supervisor.Spec) {
+	qc.superSpec, qc.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	qc.counter = newCounter(qc.newStore())
+	qc.reload()
+}
+
+// Inherit inherits previous generation of QuotaController.
+func (qc *QuotaController) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	prev := previousGeneration.(*QuotaController)
+	prev.stop()
+
+	// NOTE: The counters are kept, so the usage of the current windows
+	// is not lost, the previous generation is replaced when the new
+	// generation registers itself.
+	qc.superSpec, qc.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	qc.counter = prev.counter
+	qc.reload()
+}
+
+func (qc *QuotaController) newStore() *cluster.KVStore {
+	super := qc.superSpec.Super()
+	if super == nil || super.Cluster() == nil {
+		// NOTE: Quotas are counted by this member alone.
+		return nil
+	}
+
+	s, err := cluster.NewKVStore(super.Cluster(), storeNamespacePrefix+qc.superSpec.Name())
+	if err != nil {
+		logger.Errorf("create key-value store of quota controller %s failed: %v", qc.superSpec.Name(), err)
+		return nil
+	}
+	return s
+}
+
+func (qc *QuotaController) reload() {
+	qc.location, _ = time.LoadLocation(qc.spec.TimeZone)
+	qc.syncInterval, _ = time.ParseDuration(qc.spec.SyncInterval)
+	if qc.syncInterval <= 0 {
+		qc.syncInterval = 5 * time.Second
+	}
+
+	qc.done = make(chan struct{})
+	qc.wg.Add(1)
+	go qc.run()
+
+	registerController(qc.superSpec.Name(), qc)
+}
+
+func (qc *QuotaController) run() {
+	defer qc.wg.Done()
+
+	for {
+		select {
+		case <-qc.done:
+			qc.counter.sync()
+			return
+		case <-time.After(qc.syncInterval):
+			qc.counter.sync()
+		}
+	}
+}
+
+// stop stops the synchronization, the counters are synchronized for
+// the last time before it returns.
+func (qc *QuotaController) stop() {
+	close(qc.done)
+	qc.wg.Wait()
+}
+
+func (qc *QuotaController) quota(name string) *QuotaSpec {
+	for _, q := range qc.spec.Quotas {
+		if q.Name == name {
+			return q
+		}
+	}
+	return nil
+}
+
+// ttl returns the ttl of the counter of the window ending at end.
+func (qc *QuotaController) ttl(window string, end time.Time) time.Duration {
+	t := end
+	for i := 0; i < qc.spec.Retention; i++ {
+		_, t = windowOf(window, t)
+	}
+	return time.Until(t)
+}
+
+// take takes a request from the quota of the consumer.
+func (qc *QuotaController) take(quota, consumer string) (*Decision, error) {
+	q := qc.quota(quota)
+	if q == nil {
+		return nil, fmt.Errorf("quota %s not found in %s", quota, qc.superSpec.Name())
+	}
+
+	start, end := windowOf(q.Window, time.Now().In(qc.location))
+	d := &Decision{
+		Limit:   q.limit(consumer),
+		Reset:   end,
+		headers: qc.spec.Headers,
+	}
+
+	key := counterKey(q.Name, start, consumer)
+	used, ok := qc.counter.take(key, d.Limit, qc.ttl(q.Window, end))
+	d.Allowed = ok
+	if d.Remaining = d.Limit - used; d.Remaining < 0 {
+		d.Remaining = 0
+	}
+	return d, nil
+}
+
+// Status returns the status of QuotaController.
+func (qc *QuotaController) Status() *supervisor.Status {
+	s := &Status{}
+
+	now := time.Now().In(qc.location)
+	for _, q := range qc.spec.Quotas {
+		start, end := windowOf(q.Window, now)
+		qs := &QuotaStatus{Name: q.Name, Start: start, End: end}
+		for _, u := range qc.usages(q.Name, "") {
+			if u.Start.Equal(start) {
+				qs.Consumers++
+			}
+		}
+		s.Quotas = append(s.Quotas, qs)
+	}
+
+	return &supervisor.Status{ObjectStatus: s}
+}
+
+// Close closes QuotaController.
+func (qc *QuotaController) Close() {
+	qc.stop()
+	unregisterController(qc.superSpec.Name(), qc)
+	qc.counter.close()
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package quotacontroller
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newController(t *testing.T, yamlConfig string) *QuotaController {
+	spec, err := supervisor.NewSpec(yamlConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	qc := &QuotaController{}
+	qc.Init(spec)
+	return qc
+}
+
+func TestWindowOf(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	// 2021-06-16 is a Wednesday.
+	now := time.Date(2021, 6, 16, 13, 45, 10, 0, loc)
+
+	cases := []struct {
+		window     string
+		start, end time.Time
+	}{
+		{WindowHour, time.Date(2021, 6, 16, 13, 0, 0, 0, loc), time.Date(2021, 6, 16, 14, 0, 0, 0, loc)},
+		{WindowDay, time.Date(2021, 6, 16, 0, 0, 0, 0, loc), time.Date(2021, 6, 17, 0, 0, 0, 0, loc)},
+		{WindowWeek, time.Date(2021, 6, 14, 0, 0, 0, 0, loc), time.Date(2021, 6, 21, 0, 0, 0, 0, loc)},
+		{WindowMonth, time.Date(2021, 6, 1, 0, 0, 0, 0, loc), time.Date(2021, 7, 1, 0, 0, 0, 0, loc)},
+	}
+	for _, c := range cases {
+		start, end := windowOf(c.window, now)
+		if !start.Equal(c.start) || !end.Equal(c.end) {
+			t.Errorf("%s: want [%v, %v), got [%v, %v)", c.window, c.start, c.end, start, end)
+		}
+	}
+
+	// Sunday belongs to the week starting on the previous Monday.
+	start, _ := windowOf(WindowWeek, time.Date(2021, 6, 20, 23, 0, 0, 0, loc))
+	if want := time.Date(2021, 6, 14, 0, 0, 0, 0, loc); !start.Equal(want) {
+		t.Errorf("want week start %v, got %v", want, start)
+	}
+}
+
+func TestTake(t *testing.T) {
+	qc := newController(t, `
+kind: QuotaController
+name: quota-test
+quotas:
+- name: daily
+  window: day
+  limit: 3
+  consumers:
+    vip: 5
+`)
+	defer qc.Close()
+
+	for i := int64(1); i <= 3; i++ {
+		d, err := Take("quota-test", "daily", "alice")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !d.Allowed || d.Limit != 3 || d.Remaining != 3-i {
+			t.Errorf("request %d: unexpected decision %+v", i, d)
+		}
+	}
+	if d, _ := Take("quota-test", "daily", "alice"); d.Allowed || d.Remaining != 0 {
+		t.Errorf("unexpected decision %+v", d)
+	}
+
+	if d, _ := Take("quota-test", "daily", "vip"); !d.Allowed || d.Limit != 5 || d.Remaining != 4 {
+		t.Errorf("vip: unexpected decision %+v", d)
+	}
+
+	if _, err := Take("quota-test", "monthly", "alice"); err == nil {
+		t.Errorf("want an error for unknown quota")
+	}
+	if _, err := Take("unknown", "daily", "alice"); err == nil {
+		t.Errorf("want an error for unknown controller")
+	}
+
+	qc.counter.sync()
+	usages := qc.usages("daily", "")
+	if len(usages) != 2 {
+		t.Fatalf("want 2 usages, got %d", len(usages))
+	}
+	if u := usages[0]; u.Consumer != "alice" || u.Used != 3 || u.Limit != 3 {
+		t.Errorf("unexpected usage %+v", u)
+	}
+	if u := usages[1]; u.Consumer != "vip" || u.Used != 1 || u.Limit != 5 {
+		t.Errorf("unexpected usage %+v", u)
+	}
+
+	start, _ := windowOf(WindowDay, time.Now().In(qc.location))
+	qc.counter.reset(counterKey("daily", start, "alice"))
+	if d, _ := Take("quota-test", "daily", "alice"); !d.Allowed || d.Remaining != 2 {
+		t.Errorf("after reset: unexpected decision %+v", d)
+	}
+}
+
+func TestInherit(t *testing.T) {
+	qc := newController(t, `
+kind: QuotaController
+name: quota-inherit
+quotas:
+- name: hourly
+  window: hour
+  limit: 2
+`)
+	Take("quota-inherit", "hourly", "alice")
+
+	spec, err := supervisor.NewSpec(`
+kind: QuotaController
+name: quota-inherit
+quotas:
+- name: hourly
+  window: hour
+  limit: 3
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	next := &QuotaController{}
+	next.Inherit(spec, qc)
+	defer next.Close()
+
+	// The usage is kept and the new limit takes effect.
+	if d, _ := Take("quota-inherit", "hourly", "alice"); !d.Allowed || d.Remaining != 1 {
+		t.Errorf("unexpected decision %+v", d)
+	}
+}
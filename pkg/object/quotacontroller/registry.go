@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package quotacontroller
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+var (
+	mutex       sync.RWMutex
+	controllers = make(map[string]*QuotaController)
+)
+
+type (
+	// Decision is the decision of taking a request from a quota.
+	Decision struct {
+		Allowed   bool
+		Limit     int64
+		Remaining int64
+		// Reset is the end of the current window.
+		Reset time.Time
+
+		headers *HeadersSpec
+	}
+
+	// Usage is the usage of a consumer in a window of a quota.
+	Usage struct {
+		Quota    string    `yaml:"quota" json:"quota"`
+		Consumer string    `yaml:"consumer" json:"consumer"`
+		Start    time.Time `yaml:"start" json:"start"`
+		End      time.Time `yaml:"end" json:"end"`
+		Used     int64     `yaml:"used" json:"used"`
+		Limit    int64     `yaml:"limit" json:"limit"`
+	}
+)
+
+func registerController(name string, qc *QuotaController) {
+	registerOnce.Do(registerAPIs)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	controllers[name] = qc
+}
+
+func unregisterController(name string, qc *QuotaController) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// NOTE: The next generation may have registered itself.
+	if controllers[name] == qc {
+		delete(controllers, name)
+	}
+}
+
+func getController(name string) *QuotaController {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return controllers[name]
+}
+
+// Take takes a request of the consumer from the quota of the controller.
+func Take(controller, quota, consumer string) (*Decision, error) {
+	qc := getController(controller)
+	if qc == nil {
+		return nil, fmt.Errorf("quota controller %s not found", controller)
+	}
+	return qc.take(quota, consumer)
+}
+
+// SetHeaders sets the headers about the quota.
+func (d *Decision) SetHeaders(h *httpheader.HTTPHeader) {
+	if d.headers == nil {
+		return
+	}
+	if d.headers.Limit != "" {
+		h.Set(d.headers.Limit, strconv.FormatInt(d.Limit, 10))
+	}
+	if d.headers.Remaining != "" {
+		h.Set(d.headers.Remaining, strconv.FormatInt(d.Remaining, 10))
+	}
+	if d.headers.Reset != "" {
+		// NOTE: The reset is the seconds until the window ends, the same
+		// as the IETF draft of RateLimit header fields.
+		reset := int64(time.Until(d.Reset).Seconds() + 0.5)
+		h.Set(d.headers.Reset, strconv.FormatInt(reset, 10))
+	}
+}
+
+// usages returns the usage of the consumers in all retained windows,
+// they are filtered by the quota and the consumer if not empty.
+func (qc *QuotaController) usages(quota, consumer string) []*Usage {
+	var result []*Usage
+	for key, used := range qc.counter.usage() {
+		q, start, c, ok := parseCounterKey(key)
+		if !ok || (quota != "" && q != quota) || (consumer != "" && c != consumer) {
+			continue
+		}
+		spec := qc.quota(q)
+		if spec == nil {
+			continue
+		}
+
+		start = start.In(qc.location)
+		_, end := windowOf(spec.Window, start)
+		result = append(result, &Usage{
+			Quota:    q,
+			Consumer: c,
+			Start:    start,
+			End:      end,
+			Used:     used,
+			Limit:    spec.limit(c),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Quota != b.Quota {
+			return a.Quota < b.Quota
+		}
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		return a.Consumer < b.Consumer
+	})
+	return result
+}
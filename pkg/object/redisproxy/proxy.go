@@ -0,0 +1,363 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package redisproxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/ratelimiter"
+	"github.com/megaease/easegress/pkg/util/redisclient"
+)
+
+type (
+	proxy struct {
+		name string
+		spec *Spec
+
+		listener  net.Listener
+		listenErr string
+
+		primary  *redisclient.Client
+		replicas []*redisclient.Client
+		next     uint64
+
+		allow map[string]bool
+		deny  map[string]bool
+
+		mutex   sync.Mutex
+		conns   map[net.Conn]struct{}
+		clients map[string]*client
+		closed  bool
+		wg      sync.WaitGroup
+
+		connections int64
+		commands    uint64
+		denied      uint64
+		rateLimited uint64
+		failed      uint64
+	}
+
+	// client is the rate limiter shared by the connections of a client IP.
+	client struct {
+		limiter *ratelimiter.RateLimiter
+		conns   int
+	}
+)
+
+// readCommands are the read-only commands which go to the replicas.
+var readCommands = toSet(
+	"GET", "MGET", "GETRANGE", "STRLEN", "EXISTS", "TYPE", "TTL", "PTTL",
+	"HGET", "HMGET", "HGETALL", "HKEYS", "HVALS", "HLEN", "HEXISTS", "HSTRLEN", "HSCAN",
+	"LRANGE", "LLEN", "LINDEX",
+	"SCARD", "SISMEMBER", "SMISMEMBER", "SMEMBERS", "SRANDMEMBER", "SSCAN",
+	"ZCARD", "ZCOUNT", "ZLEXCOUNT", "ZRANGE", "ZRANGEBYSCORE", "ZRANGEBYLEX",
+	"ZREVRANGE", "ZREVRANGEBYSCORE", "ZRANK", "ZREVRANK", "ZSCORE", "ZMSCORE", "ZSCAN",
+	"GETBIT", "BITCOUNT", "BITPOS", "PFCOUNT",
+	"GEOPOS", "GEODIST", "GEOHASH", "GEOSEARCH",
+	"XRANGE", "XREVRANGE", "XLEN",
+	"SCAN", "KEYS", "RANDOMKEY", "DBSIZE",
+)
+
+// unsupportedCommands depend on the state of the connection or block it,
+// which can't work with pooled connections.
+var unsupportedCommands = toSet(
+	"SELECT", "MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH",
+	"SUBSCRIBE", "PSUBSCRIBE", "SSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE", "MONITOR",
+	"BLPOP", "BRPOP", "BRPOPLPUSH", "BLMOVE", "BLMPOP", "BZPOPMIN", "BZPOPMAX", "BZMPOP",
+	"CLIENT", "HELLO", "RESET", "WAIT",
+)
+
+func toSet(items ...string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[strings.ToUpper(item)] = true
+	}
+	return set
+}
+
+func newProxy(name string, spec *Spec) *proxy {
+	p := &proxy{
+		name:    name,
+		spec:    spec,
+		allow:   toSet(spec.AllowCommands...),
+		deny:    toSet(spec.DenyCommands...),
+		conns:   map[net.Conn]struct{}{},
+		clients: map[string]*client{},
+	}
+
+	newClient := func(address string) *redisclient.Client {
+		return redisclient.New(&redisclient.Options{
+			Address:  address,
+			Username: spec.Username,
+			Password: spec.Password,
+			DB:       spec.DB,
+			Timeout:  spec.timeout(),
+			PoolSize: spec.PoolSize,
+		})
+	}
+	p.primary = newClient(spec.Primary)
+	for _, r := range spec.Replicas {
+		p.replicas = append(p.replicas, newClient(r))
+	}
+
+	return p
+}
+
+func (p *proxy) listen() error {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", p.spec.Port))
+	if err != nil {
+		p.listenErr = err.Error()
+		return err
+	}
+	p.listener = l
+
+	p.wg.Add(1)
+	go p.serve()
+	return nil
+}
+
+func (p *proxy) serve() {
+	defer p.wg.Done()
+
+	for {
+		c, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		p.mutex.Lock()
+		if p.closed {
+			p.mutex.Unlock()
+			c.Close()
+			return
+		}
+		p.conns[c] = struct{}{}
+		cl := p.acquireClient(c)
+		p.mutex.Unlock()
+
+		p.wg.Add(1)
+		go p.handle(c, cl)
+	}
+}
+
+// acquireClient returns the client of the connection, the caller must
+// hold the mutex.
+func (p *proxy) acquireClient(c net.Conn) *client {
+	ip, _, _ := net.SplitHostPort(c.RemoteAddr().String())
+	cl := p.clients[ip]
+	if cl == nil {
+		cl = &client{}
+		if p.spec.ClientRateLimit > 0 {
+			cl.limiter = ratelimiter.New(ratelimiter.NewPolicy(0, 1000, p.spec.ClientRateLimit))
+		}
+		p.clients[ip] = cl
+	}
+	cl.conns++
+	return cl
+}
+
+func (p *proxy) release(c net.Conn) {
+	c.Close()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.conns, c)
+	ip, _, _ := net.SplitHostPort(c.RemoteAddr().String())
+	if cl := p.clients[ip]; cl != nil {
+		cl.conns--
+		if cl.conns == 0 {
+			delete(p.clients, ip)
+		}
+	}
+}
+
+func (p *proxy) handle(c net.Conn, cl *client) {
+	defer p.wg.Done()
+	defer p.release(c)
+
+	atomic.AddInt64(&p.connections, 1)
+	defer atomic.AddInt64(&p.connections, -1)
+
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+	authed := p.spec.ClientPassword == ""
+
+	for {
+		req, err := redisclient.ReadReply(r)
+		if err != nil {
+			return
+		}
+		args, ok := toArgs(req)
+		if !ok {
+			writeReply(w, redisclient.Error("ERR protocol error: expected an array of bulk strings"))
+			w.Flush()
+			return
+		}
+
+		atomic.AddUint64(&p.commands, 1)
+		cmd := strings.ToUpper(args[0])
+		quit := false
+
+		switch {
+		case cmd == "QUIT":
+			writeReply(w, "OK")
+			quit = true
+		case cmd == "AUTH":
+			if p.spec.ClientPassword == "" {
+				writeReply(w, redisclient.Error("ERR AUTH called without any password configured for the proxy"))
+			} else if args[len(args)-1] == p.spec.ClientPassword {
+				authed = true
+				writeReply(w, "OK")
+			} else {
+				authed = false
+				writeReply(w, redisclient.Error("WRONGPASS invalid password"))
+			}
+		case !authed:
+			writeReply(w, redisclient.Error("NOAUTH Authentication required."))
+		default:
+			writeReply(w, p.do(cl, cmd, args))
+		}
+
+		if err := w.Flush(); err != nil || quit {
+			return
+		}
+	}
+}
+
+// do checks the command and runs it on the primary or a replica.
+func (p *proxy) do(cl *client, cmd string, args []string) interface{} {
+	switch {
+	case unsupportedCommands[cmd]:
+		atomic.AddUint64(&p.denied, 1)
+		return redisclient.Error("ERR command '" + args[0] + "' is not supported by the proxy")
+	case len(p.allow) != 0 && !p.allow[cmd], p.deny[cmd]:
+		atomic.AddUint64(&p.denied, 1)
+		return redisclient.Error("NOPERM command '" + args[0] + "' is not allowed")
+	}
+
+	if cl.limiter != nil {
+		if permitted, _ := cl.limiter.AcquirePermission(); !permitted {
+			atomic.AddUint64(&p.rateLimited, 1)
+			return redisclient.Error("ERR rate limited")
+		}
+	}
+
+	if cmd == "PING" {
+		if len(args) > 1 {
+			return args[1]
+		}
+		return "PONG"
+	}
+
+	reply, err := p.backend(cmd).Do(args...)
+	if err == nil {
+		return reply
+	}
+	if e, ok := err.(redisclient.Error); ok {
+		return e
+	}
+	atomic.AddUint64(&p.failed, 1)
+	logger.Errorf("%s run command %s failed: %v", p.name, cmd, err)
+	return redisclient.Error("ERR proxy: " + err.Error())
+}
+
+func (p *proxy) backend(cmd string) *redisclient.Client {
+	if len(p.replicas) == 0 || !readCommands[cmd] {
+		return p.primary
+	}
+	n := atomic.AddUint64(&p.next, 1)
+	return p.replicas[n%uint64(len(p.replicas))]
+}
+
+func (p *proxy) status() *Status {
+	s := &Status{
+		Connections: atomic.LoadInt64(&p.connections),
+		Commands:    atomic.LoadUint64(&p.commands),
+		Denied:      atomic.LoadUint64(&p.denied),
+		RateLimited: atomic.LoadUint64(&p.rateLimited),
+		Failed:      atomic.LoadUint64(&p.failed),
+		Primary:     p.primary.PoolStatus(),
+		Error:       p.listenErr,
+	}
+	if len(p.replicas) != 0 {
+		s.Replicas = map[string]*redisclient.PoolStatus{}
+		for i, r := range p.replicas {
+			s.Replicas[p.spec.Replicas[i]] = r.PoolStatus()
+		}
+	}
+	return s
+}
+
+func (p *proxy) close() {
+	p.mutex.Lock()
+	p.closed = true
+	if p.listener != nil {
+		p.listener.Close()
+	}
+	for c := range p.conns {
+		c.Close()
+	}
+	p.mutex.Unlock()
+
+	p.wg.Wait()
+
+	p.primary.Close()
+	for _, r := range p.replicas {
+		r.Close()
+	}
+}
+
+// toArgs converts a request to the command arguments.
+func toArgs(req interface{}) ([]string, bool) {
+	items, ok := req.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, false
+	}
+	args := make([]string, len(items))
+	for i, item := range items {
+		if args[i], ok = item.(string); !ok {
+			return nil, false
+		}
+	}
+	return args, true
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch r := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(r)) + "\r\n" + r + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(r, 10) + "\r\n")
+	case redisclient.Error:
+		w.WriteString("-" + string(r) + "\r\n")
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(r)) + "\r\n")
+		for _, item := range r {
+			writeReply(w, item)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package redisproxy
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/redisclient"
+	"github.com/megaease/easegress/pkg/util/redisclient/redistest"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newTestProxy(t *testing.T, spec *Spec) (*proxy, *redisclient.Client) {
+	p := newProxy("test", spec)
+	if err := p.listen(); err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	c := redisclient.New(&redisclient.Options{
+		Address:  p.listener.Addr().String(),
+		Password: spec.ClientPassword,
+		Timeout:  time.Second,
+	})
+	return p, c
+}
+
+func TestProxy(t *testing.T) {
+	primary := redistest.NewServer("primary")
+	defer primary.Close()
+	replica := redistest.NewServer("primary")
+	defer replica.Close()
+
+	p, c := newTestProxy(t, &Spec{
+		Primary:        primary.Addr(),
+		Replicas:       []string{replica.Addr()},
+		Password:       "primary",
+		ClientPassword: "secret",
+		DenyCommands:   []string{"del"},
+		Timeout:        "1s",
+	})
+	defer p.close()
+	defer c.Close()
+
+	if _, err := c.Do("SET", "name", "alice"); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	// NOTE: Reads go to the replica, which is not replicated in tests.
+	if _, err := c.String("GET", "name"); err != redisclient.ErrNil {
+		t.Errorf("get should go to the replica, got %v", err)
+	}
+	if n, err := c.Int("INCRBY", "name", "1"); err == nil {
+		t.Errorf("incrby should fail on the primary, got %d", n)
+	}
+
+	if _, err := c.Do("DEL", "name"); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Errorf("del should be denied, got %v", err)
+	}
+	if _, err := c.Do("MULTI"); err == nil {
+		t.Error("multi should not be supported")
+	}
+	if reply, err := c.String("PING"); err != nil || reply != "PONG" {
+		t.Errorf("ping should be answered by the proxy, got %q %v", reply, err)
+	}
+
+	s := p.status()
+	if s.Denied != 2 || s.Primary == nil || len(s.Replicas) != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+
+	unauthed := redisclient.New(&redisclient.Options{Address: p.listener.Addr().String(), Timeout: time.Second})
+	defer unauthed.Close()
+	if _, err := unauthed.Do("GET", "name"); err == nil || !strings.HasPrefix(err.Error(), "NOAUTH") {
+		t.Errorf("unauthenticated clients should be rejected, got %v", err)
+	}
+}
+
+func TestProxyRateLimit(t *testing.T) {
+	primary := redistest.NewServer("")
+	defer primary.Close()
+
+	p, c := newTestProxy(t, &Spec{
+		Primary:         primary.Addr(),
+		AllowCommands:   []string{"get", "set"},
+		ClientRateLimit: 2,
+		Timeout:         "1s",
+	})
+	defer p.close()
+	defer c.Close()
+
+	if _, err := c.Do("INCRBY", "n", "1"); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Errorf("incrby should not be allowed, got %v", err)
+	}
+
+	limited := 0
+	for i := 0; i < 4; i++ {
+		if _, err := c.Do("SET", "n", "1"); err != nil && err.Error() == "ERR rate limited" {
+			limited++
+		}
+	}
+	if limited < 2 {
+		t.Errorf("at least 2 commands should be rate limited, got %d", limited)
+	}
+	if s := p.status(); s.RateLimited != uint64(limited) {
+		t.Errorf("expected %d rate limited, got %d", limited, s.RateLimited)
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := &Spec{AllowCommands: []string{"GET"}, DenyCommands: []string{"SET"}}
+	if spec.Validate() == nil {
+		t.Error("allowCommands and denyCommands should be exclusive")
+	}
+	spec = &Spec{AllowCommands: []string{"multi"}}
+	if spec.Validate() == nil {
+		t.Error("unsupported commands should not be allowed")
+	}
+}
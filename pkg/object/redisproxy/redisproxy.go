@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package redisproxy provides RedisProxy, which fronts Redis with the
+// RESP protocol to govern the commands of the clients.
+package redisproxy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/redisclient"
+)
+
+const (
+	// Kind is the kind of RedisProxy.
+	Kind = "RedisProxy"
+)
+
+func init() {
+	supervisor.Register(&RedisProxy{})
+}
+
+type (
+	// RedisProxy is a RESP proxy of Redis with connection pooling,
+	// read/write splitting, command ACLs and per-client rate limits.
+	RedisProxy struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		proxy *proxy
+	}
+
+	// Spec describes the RedisProxy.
+	Spec struct {
+		// Port is the port to serve the clients.
+		Port uint16 `yaml:"port" jsonschema:"required"`
+		// Primary is host:port of the primary, all writes go to it.
+		Primary string `yaml:"primary" jsonschema:"required"`
+		// Replicas are host:port of the replicas, reads are balanced
+		// among them in round robin, they go to the primary if it's empty.
+		Replicas []string `yaml:"replicas" jsonschema:"omitempty,uniqueItems=true"`
+		Username string   `yaml:"username" jsonschema:"omitempty"`
+		Password string   `yaml:"password" jsonschema:"omitempty"`
+		DB       int      `yaml:"db" jsonschema:"omitempty,minimum=0"`
+		// ClientPassword is the password the clients must AUTH with, the
+		// clients are not authenticated if it's empty.
+		ClientPassword string `yaml:"clientPassword" jsonschema:"omitempty"`
+		PoolSize       int    `yaml:"poolSize" jsonschema:"omitempty,minimum=1"`
+		Timeout        string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+		// AllowCommands and DenyCommands are the command ACLs, only the
+		// allowed commands could run if AllowCommands is not empty.
+		AllowCommands []string `yaml:"allowCommands" jsonschema:"omitempty,uniqueItems=true"`
+		DenyCommands  []string `yaml:"denyCommands" jsonschema:"omitempty,uniqueItems=true"`
+		// ClientRateLimit is the max commands per second of a client IP,
+		// zero means unlimited.
+		ClientRateLimit int `yaml:"clientRateLimit" jsonschema:"omitempty,minimum=0"`
+	}
+
+	// Status is the status of RedisProxy.
+	Status struct {
+		Connections int64  `yaml:"connections"`
+		Commands    uint64 `yaml:"commands"`
+		Denied      uint64 `yaml:"denied"`
+		RateLimited uint64 `yaml:"rateLimited"`
+		Failed      uint64 `yaml:"failed"`
+
+		Primary  *redisclient.PoolStatus            `yaml:"primary"`
+		Replicas map[string]*redisclient.PoolStatus `yaml:"replicas,omitempty"`
+
+		Error string `yaml:"error,omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if len(spec.AllowCommands) != 0 && len(spec.DenyCommands) != 0 {
+		return fmt.Errorf("allowCommands and denyCommands are mutually exclusive")
+	}
+	for _, cmd := range spec.AllowCommands {
+		if unsupportedCommands[strings.ToUpper(cmd)] {
+			return fmt.Errorf("command %s is not supported by the proxy", cmd)
+		}
+	}
+	return nil
+}
+
+// Category returns the category of RedisProxy.
+func (rp *RedisProxy) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+
+// Kind returns the kind of RedisProxy.
+func (rp *RedisProxy) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of RedisProxy.
+func (rp *RedisProxy) DefaultSpec() interface{} {
+	return &Spec{
+		PoolSize: 16,
+		Timeout:  "5s",
+	}
+}
+
+// Init initializes RedisProxy.
+func (rp *RedisProxy) Init(superSpec *supervisor.Spec) {
+	rp.superSpec, rp.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	rp.reload()
+}
+
+// Inherit inherits previous generation of RedisProxy.
+func (rp *RedisProxy) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	// NOTE: Close the previous generation first to release the port.
+	previousGeneration.Close()
+	rp.Init(superSpec)
+}
+
+func (rp *RedisProxy) reload() {
+	rp.proxy = newProxy(rp.superSpec.Name(), rp.spec)
+	if err := rp.proxy.listen(); err != nil {
+		logger.Errorf("%s listen on port %d failed: %v", rp.superSpec.Name(), rp.spec.Port, err)
+	}
+}
+
+// Status returns the status of RedisProxy.
+func (rp *RedisProxy) Status() *supervisor.Status {
+	return &supervisor.Status{ObjectStatus: rp.proxy.status()}
+}
+
+// Close closes RedisProxy.
+func (rp *RedisProxy) Close() {
+	rp.proxy.close()
+}
+
+func (spec *Spec) timeout() time.Duration {
+	d, _ := time.ParseDuration(spec.Timeout)
+	return d
+}
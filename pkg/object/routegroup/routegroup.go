@@ -0,0 +1,245 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package routegroup provides RouteGroup, a high-level object which
+// exposes routine APIs of a host, it's expanded to an HTTPServer and
+// HTTPPipelines which are still available as low-level objects.
+package routegroup
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/megaease/easegress/pkg/filter/corsadaptor"
+	"github.com/megaease/easegress/pkg/filter/proxy"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httpserver"
+	"github.com/megaease/easegress/pkg/object/trafficcontroller"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Category is the category of RouteGroup.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of RouteGroup.
+	Kind = "RouteGroup"
+)
+
+var loadBalancePolicies = []string{
+	proxy.PolicyRoundRobin,
+	proxy.PolicyRandom,
+	proxy.PolicyWeightedRandom,
+	proxy.PolicyIPHash,
+}
+
+func init() {
+	supervisor.Register(&RouteGroup{})
+}
+
+type (
+	// RouteGroup expands its routes to an HTTPServer and HTTPPipelines.
+	RouteGroup struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		tc        *trafficcontroller.TrafficController
+		namespace string
+
+		mutex  sync.Mutex
+		status *Status
+	}
+
+	// Spec describes the RouteGroup.
+	Spec struct {
+		// HTTPServer is the template of the HTTPServer, its rules are
+		// generated from the routes.
+		HTTPServer *httpserver.Spec `yaml:"httpServer" jsonschema:"required"`
+		Host       string           `yaml:"host" jsonschema:"omitempty"`
+		Routes     []*Route         `yaml:"routes" jsonschema:"required,minItems=1"`
+	}
+
+	// Route maps a path to backends with inline policies.
+	Route struct {
+		Name        string   `yaml:"name" jsonschema:"required,format=urlname"`
+		Path        string   `yaml:"path,omitempty" jsonschema:"omitempty,pattern=^/"`
+		PathPrefix  string   `yaml:"pathPrefix,omitempty" jsonschema:"omitempty,pattern=^/"`
+		Methods     []string `yaml:"methods,omitempty" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		Backends    []string `yaml:"backends" jsonschema:"required,minItems=1,uniqueItems=true"`
+		LoadBalance string   `yaml:"loadBalance" jsonschema:"omitempty"`
+
+		// Timeout is the timeout of the whole request.
+		Timeout string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+		// RateLimit is the max requests per second.
+		RateLimit int               `yaml:"rateLimit,omitempty" jsonschema:"omitempty,minimum=1"`
+		CORS      *corsadaptor.Spec `yaml:"cors,omitempty" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of RouteGroup.
+	Status struct {
+		HTTPServer string   `yaml:"httpServer"`
+		Pipelines  []string `yaml:"pipelines"`
+		Error      string   `yaml:"error,omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if spec.HTTPServer != nil && len(spec.HTTPServer.Rules) != 0 {
+		return fmt.Errorf("rules of httpServer are generated from routes")
+	}
+
+	names := map[string]bool{}
+	for _, r := range spec.Routes {
+		if names[r.Name] {
+			return fmt.Errorf("duplicated route %s", r.Name)
+		}
+		names[r.Name] = true
+
+		if (r.Path == "") == (r.PathPrefix == "") {
+			return fmt.Errorf("route %s: exactly one of path and pathPrefix is required", r.Name)
+		}
+
+		for _, b := range r.Backends {
+			u, err := url.Parse(b)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("route %s: invalid backend %s", r.Name, b)
+			}
+		}
+
+		if r.LoadBalance != "" && !validLoadBalance(r.LoadBalance) {
+			return fmt.Errorf("route %s: unsupported loadBalance %s", r.Name, r.LoadBalance)
+		}
+	}
+	return nil
+}
+
+func validLoadBalance(policy string) bool {
+	for _, p := range loadBalancePolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// Category returns the category of RouteGroup.
+func (rg *RouteGroup) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of RouteGroup.
+func (rg *RouteGroup) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of RouteGroup.
+func (rg *RouteGroup) DefaultSpec() interface{} {
+	return &Spec{
+		HTTPServer: &httpserver.Spec{
+			KeepAlive:        true,
+			KeepAliveTimeout: "60s",
+			MaxConnections:   10240,
+		},
+	}
+}
+
+// Init initializes RouteGroup.
+func (rg *RouteGroup) Init(superSpec *supervisor.Spec) {
+	rg.superSpec = superSpec
+	rg.spec = superSpec.ObjectSpec().(*Spec)
+	rg.reload()
+}
+
+// Inherit inherits previous generation of RouteGroup.
+func (rg *RouteGroup) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	// NOTE: The generated objects are updated in place rather than
+	// cleaned by closing the previous generation, to avoid downtime.
+	rg.Init(superSpec)
+}
+
+func (rg *RouteGroup) reload() {
+	if entity, exists := rg.superSpec.Super().GetSystemController(trafficcontroller.Kind); !exists {
+		panic(fmt.Errorf("BUG: traffic controller not found"))
+	} else if tc, ok := entity.Instance().(*trafficcontroller.TrafficController); !ok {
+		panic(fmt.Errorf("BUG: want *TrafficController, got %T", entity.Instance()))
+	} else {
+		rg.tc = tc
+	}
+
+	rg.namespace = fmt.Sprintf("%s/%s", rg.superSpec.Name(), "routegroup")
+	rg.tc.SetOwner(rg.namespace, &trafficcontroller.Owner{Kind: Kind, Name: rg.superSpec.Name()})
+
+	status := &Status{}
+	if err := rg.apply(status); err != nil {
+		logger.Errorf("route group %s: %v", rg.superSpec.Name(), err)
+		status.Error = err.Error()
+	}
+
+	rg.mutex.Lock()
+	rg.status = status
+	rg.mutex.Unlock()
+}
+
+// apply applies the generated objects and deletes the stale pipelines,
+// the pipelines are applied first since the HTTPServer references them.
+func (rg *RouteGroup) apply(status *Status) error {
+	t := newTranslator(rg.superSpec.Name(), rg.spec)
+
+	pipelines, err := t.pipelineSpecs()
+	if err != nil {
+		return err
+	}
+	server, err := t.httpServerSpec()
+	if err != nil {
+		return err
+	}
+
+	names := map[string]bool{}
+	for _, spec := range pipelines {
+		if _, err := rg.tc.ApplyHTTPPipelineForSpec(rg.namespace, spec); err != nil {
+			return fmt.Errorf("apply pipeline %s failed: %v", spec.Name(), err)
+		}
+		names[spec.Name()] = true
+		status.Pipelines = append(status.Pipelines, spec.Name())
+	}
+
+	if _, err := rg.tc.ApplyHTTPServerForSpec(rg.namespace, server); err != nil {
+		return fmt.Errorf("apply http server %s failed: %v", server.Name(), err)
+	}
+	status.HTTPServer = server.Name()
+
+	for _, p := range rg.tc.ListHTTPPipelines(rg.namespace) {
+		if name := p.Spec().Name(); !names[name] {
+			rg.tc.DeleteHTTPPipeline(rg.namespace, name)
+		}
+	}
+	return nil
+}
+
+// Status returns the status of RouteGroup.
+func (rg *RouteGroup) Status() *supervisor.Status {
+	rg.mutex.Lock()
+	defer rg.mutex.Unlock()
+	return &supervisor.Status{ObjectStatus: rg.status}
+}
+
+// Close closes RouteGroup.
+func (rg *RouteGroup) Close() {
+	rg.tc.Clean(rg.namespace)
+}
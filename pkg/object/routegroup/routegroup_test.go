@@ -0,0 +1,245 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package routegroup
+
+import (
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/filter/corsadaptor"
+	"github.com/megaease/easegress/pkg/filter/proxy"
+	"github.com/megaease/easegress/pkg/filter/ratelimiter"
+	"github.com/megaease/easegress/pkg/filter/timelimiter"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httpserver"
+	"github.com/megaease/easegress/pkg/object/trafficcontroller"
+	"github.com/megaease/easegress/pkg/option"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const testSpec = `
+kind: RouteGroup
+name: shop
+host: shop.example.com
+httpServer:
+  port: 18652
+routes:
+- name: orders
+  pathPrefix: /orders
+  methods: [GET, POST]
+  backends: ["http://10.0.0.1:8080", "http://10.0.0.2:8080"]
+  loadBalance: ipHash
+  timeout: 3s
+  rateLimit: 100
+  cors:
+    allowedOrigins: ["*"]
+- name: health
+  path: /health
+  backends: ["http://10.0.0.3:8080"]
+`
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newSuperSpec(t *testing.T, super *supervisor.Supervisor, yamlSpec string) *supervisor.Spec {
+	spec, err := super.NewSpec(yamlSpec)
+	if err != nil {
+		t.Fatalf("new spec failed: %v", err)
+	}
+	return spec
+}
+
+func TestValidate(t *testing.T) {
+	for _, spec := range []Spec{
+		{HTTPServer: &httpserver.Spec{Rules: []*httpserver.Rule{{}}}},
+		{Routes: []*Route{{Name: "a", Path: "/a"}, {Name: "a", Path: "/b"}}},
+		{Routes: []*Route{{Name: "a"}}},
+		{Routes: []*Route{{Name: "a", Path: "/a", PathPrefix: "/a"}}},
+		{Routes: []*Route{{Name: "a", Path: "/a", Backends: []string{"10.0.0.1:8080"}}}},
+		{Routes: []*Route{{Name: "a", Path: "/a", Backends: []string{"http://10.0.0.1"}, LoadBalance: "leastConn"}}},
+	} {
+		if spec.Validate() == nil {
+			t.Errorf("spec %+v should be invalid", spec)
+		}
+	}
+
+	spec := Spec{Routes: []*Route{{Name: "a", Path: "/a", Backends: []string{"https://10.0.0.1"}, LoadBalance: "random"}}}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestTranslator(t *testing.T) {
+	spec := newSuperSpec(t, nil, testSpec)
+	tr := newTranslator("shop", spec.ObjectSpec().(*Spec))
+
+	server, err := tr.httpServerSpec()
+	if err != nil {
+		t.Fatalf("translate http server failed: %v", err)
+	}
+	if server.Kind() != httpserver.Kind || server.Name() != "shop" {
+		t.Errorf("unexpected http server %s %s", server.Kind(), server.Name())
+	}
+	serverSpec := server.ObjectSpec().(*httpserver.Spec)
+	if serverSpec.Port != 18652 || !serverSpec.KeepAlive || serverSpec.MaxConnections != 10240 {
+		t.Errorf("the template and its defaults should be kept: %+v", serverSpec)
+	}
+	if len(serverSpec.Rules) != 1 || serverSpec.Rules[0].Host != "shop.example.com" {
+		t.Fatalf("unexpected rules %+v", serverSpec.Rules)
+	}
+	paths := serverSpec.Rules[0].Paths
+	if len(paths) != 2 ||
+		paths[0].PathPrefix != "/orders" || paths[0].Backend != "shop-orders" ||
+		!reflect.DeepEqual(paths[0].Methods, []string{"GET", "POST"}) ||
+		paths[1].Path != "/health" || paths[1].Backend != "shop-health" {
+		t.Errorf("unexpected paths %+v %+v", paths[0], paths[1])
+	}
+
+	pipelines, err := tr.pipelineSpecs()
+	if err != nil {
+		t.Fatalf("translate pipelines failed: %v", err)
+	}
+	if len(pipelines) != 2 || pipelines[0].Name() != "shop-orders" || pipelines[1].Name() != "shop-health" {
+		t.Fatalf("unexpected pipelines %v", pipelines)
+	}
+
+	orders := pipelines[0].ObjectSpec().(*httppipeline.Spec)
+	expectedFlow := []httppipeline.Flow{
+		{Filter: "cors", JumpIf: map[string]string{"preflighted": httppipeline.LabelEND}},
+		{Filter: "rateLimiter", JumpIf: map[string]string{"rateLimited": httppipeline.LabelEND}},
+		{Filter: "timeLimiter", JumpIf: map[string]string{}},
+		{Filter: "proxy", JumpIf: map[string]string{}},
+	}
+	if !reflect.DeepEqual(orders.Flow, expectedFlow) {
+		t.Errorf("expect flow %+v, got %+v", expectedFlow, orders.Flow)
+	}
+	filterSpec := func(raw map[string]interface{}) interface{} {
+		spec, err := httppipeline.NewFilterSpec(raw, nil)
+		if err != nil {
+			t.Fatalf("invalid filter %v: %v", raw, err)
+		}
+		return spec.FilterSpec()
+	}
+	if len(orders.Filters) != 4 {
+		t.Fatalf("unexpected filters %v", orders.Filters)
+	}
+	cors := filterSpec(orders.Filters[0]).(*corsadaptor.Spec)
+	if !reflect.DeepEqual(cors.AllowedOrigins, []string{"*"}) {
+		t.Errorf("unexpected cors %+v", cors)
+	}
+	rl := filterSpec(orders.Filters[1]).(*ratelimiter.Spec)
+	if rl.DefaultPolicyRef != "default" || len(rl.Policies) != 1 ||
+		rl.Policies[0].LimitForPeriod != 100 || rl.Policies[0].LimitRefreshPeriod != "1s" {
+		t.Errorf("unexpected rate limiter %+v", rl)
+	}
+	tl := filterSpec(orders.Filters[2]).(*timelimiter.Spec)
+	if tl.DefaultTimeoutDuration != "3s" {
+		t.Errorf("unexpected time limiter %+v", tl)
+	}
+	px := filterSpec(orders.Filters[3]).(*proxy.Spec)
+	if len(px.MainPool.Servers) != 2 || px.MainPool.Servers[1].URL != "http://10.0.0.2:8080" ||
+		px.MainPool.LoadBalance.Policy != proxy.PolicyIPHash {
+		t.Errorf("unexpected proxy %+v", px.MainPool)
+	}
+
+	// A route without policies is proxied only, round robin by default.
+	health := pipelines[1].ObjectSpec().(*httppipeline.Spec)
+	if len(health.Flow) != 1 || len(health.Filters) != 1 {
+		t.Fatalf("unexpected pipeline %+v", health)
+	}
+	px = filterSpec(health.Filters[0]).(*proxy.Spec)
+	if len(px.MainPool.Servers) != 1 || px.MainPool.LoadBalance.Policy != proxy.PolicyRoundRobin {
+		t.Errorf("unexpected proxy %+v", px.MainPool)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	opt := option.New()
+	opt.Name = "standalone-member"
+	opt.Standalone = true
+	cls, err := cluster.New(opt)
+	if err != nil {
+		t.Fatalf("new standalone cluster failed: %v", err)
+	}
+	super := supervisor.MustNew(opt, cls)
+	defer func() {
+		wg := &sync.WaitGroup{}
+		wg.Add(2)
+		super.Close(wg)
+		cls.Close(wg)
+		wg.Wait()
+	}()
+	<-super.FirstHandleDone()
+
+	entity, _ := super.GetSystemController(trafficcontroller.Kind)
+	tc := entity.Instance().(*trafficcontroller.TrafficController)
+	namespace := "shop/routegroup"
+	pipelineNames := func() []string {
+		names := []string{}
+		for _, p := range tc.ListHTTPPipelines(namespace) {
+			names = append(names, p.Spec().Name())
+		}
+		return names
+	}
+
+	rg := &RouteGroup{}
+	rg.Init(newSuperSpec(t, super, testSpec))
+	status := rg.Status().ObjectStatus.(*Status)
+	if status.Error != "" || status.HTTPServer != "shop" ||
+		!reflect.DeepEqual(status.Pipelines, []string{"shop-orders", "shop-health"}) {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if _, exists := tc.GetHTTPServer(namespace, "shop"); !exists {
+		t.Errorf("http server should be created")
+	}
+	if names := pipelineNames(); len(names) != 2 {
+		t.Errorf("unexpected pipelines %v", names)
+	}
+
+	// The removed route is deleted, the others are updated in place.
+	next := &RouteGroup{}
+	next.Inherit(newSuperSpec(t, super, `
+kind: RouteGroup
+name: shop
+httpServer:
+  port: 18652
+routes:
+- name: orders
+  pathPrefix: /orders
+  backends: ["http://10.0.0.1:8080"]
+`), rg)
+	if names := pipelineNames(); !reflect.DeepEqual(names, []string{"shop-orders"}) {
+		t.Errorf("stale pipelines should be deleted, got %v", names)
+	}
+	p, _ := tc.GetHTTPPipeline(namespace, "shop-orders")
+	if filters := p.Spec().ObjectSpec().(*httppipeline.Spec).Filters; len(filters) != 1 {
+		t.Errorf("pipeline should be updated, got %v", filters)
+	}
+
+	next.Close()
+	if _, exists := tc.GetHTTPServer(namespace, "shop"); exists || len(pipelineNames()) != 0 {
+		t.Errorf("generated objects should be cleaned")
+	}
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package routegroup
+
+import (
+	"fmt"
+
+	"github.com/megaease/easegress/pkg/filter/corsadaptor"
+	"github.com/megaease/easegress/pkg/filter/proxy"
+	"github.com/megaease/easegress/pkg/filter/ratelimiter"
+	"github.com/megaease/easegress/pkg/filter/timelimiter"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httpserver"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"gopkg.in/yaml.v2"
+)
+
+// translator translates a RouteGroup to the specs of an HTTPServer and
+// an HTTPPipeline per route.
+type translator struct {
+	name string
+	spec *Spec
+}
+
+func newTranslator(name string, spec *Spec) *translator {
+	return &translator{name: name, spec: spec}
+}
+
+func (t *translator) pipelineName(r *Route) string {
+	return fmt.Sprintf("%s-%s", t.name, r.Name)
+}
+
+// httpServerSpec copies the template and routes the paths of the host
+// to the pipelines.
+func (t *translator) httpServerSpec() (*supervisor.Spec, error) {
+	spec := *t.spec.HTTPServer
+	rule := &httpserver.Rule{Host: t.spec.Host}
+	for _, r := range t.spec.Routes {
+		rule.Paths = append(rule.Paths, &httpserver.Path{
+			Path:       r.Path,
+			PathPrefix: r.PathPrefix,
+			Methods:    r.Methods,
+			Backend:    t.pipelineName(r),
+		})
+	}
+	spec.Rules = []*httpserver.Rule{rule}
+
+	return newSpec(httpserver.Kind, t.name, &spec)
+}
+
+// pipelineSpecs returns the pipeline specs of routes in order.
+func (t *translator) pipelineSpecs() ([]*supervisor.Spec, error) {
+	specs := make([]*supervisor.Spec, 0, len(t.spec.Routes))
+	for _, r := range t.spec.Routes {
+		spec, err := newSpec(httppipeline.Kind, t.pipelineName(r), t.pipeline(r))
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %v", r.Name, err)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// pipeline builds the pipeline of the route, the inline policies are
+// translated to filters in front of the proxy.
+func (t *translator) pipeline(r *Route) *httppipeline.Spec {
+	spec := &httppipeline.Spec{}
+	matchAll := []map[string]interface{}{
+		{"url": map[string]interface{}{"prefix": "/"}, "policyRef": "default"},
+	}
+
+	if r.CORS != nil {
+		spec.Flow = append(spec.Flow, httppipeline.Flow{
+			Filter: "cors",
+			JumpIf: map[string]string{"preflighted": httppipeline.LabelEND},
+		})
+		spec.Filters = append(spec.Filters, map[string]interface{}{
+			"kind":             corsadaptor.Kind,
+			"name":             "cors",
+			"allowedOrigins":   r.CORS.AllowedOrigins,
+			"allowedMethods":   r.CORS.AllowedMethods,
+			"allowedHeaders":   r.CORS.AllowedHeaders,
+			"allowCredentials": r.CORS.AllowCredentials,
+			"exposedHeaders":   r.CORS.ExposedHeaders,
+		})
+	}
+
+	if r.RateLimit > 0 {
+		spec.Flow = append(spec.Flow, httppipeline.Flow{
+			Filter: "rateLimiter",
+			JumpIf: map[string]string{"rateLimited": httppipeline.LabelEND},
+		})
+		spec.Filters = append(spec.Filters, map[string]interface{}{
+			"kind": ratelimiter.Kind,
+			"name": "rateLimiter",
+			"policies": []map[string]interface{}{{
+				"name":               "default",
+				"limitRefreshPeriod": "1s",
+				"limitForPeriod":     r.RateLimit,
+			}},
+			"defaultPolicyRef": "default",
+			"urls":             matchAll,
+		})
+	}
+
+	if r.Timeout != "" {
+		spec.Flow = append(spec.Flow, httppipeline.Flow{Filter: "timeLimiter"})
+		spec.Filters = append(spec.Filters, map[string]interface{}{
+			"kind":                   timelimiter.Kind,
+			"name":                   "timeLimiter",
+			"defaultTimeoutDuration": r.Timeout,
+			"urls":                   matchAll,
+		})
+	}
+
+	servers := make([]map[string]interface{}, 0, len(r.Backends))
+	for _, b := range r.Backends {
+		servers = append(servers, map[string]interface{}{"url": b})
+	}
+	policy := r.LoadBalance
+	if policy == "" {
+		policy = proxy.PolicyRoundRobin
+	}
+	spec.Flow = append(spec.Flow, httppipeline.Flow{Filter: "proxy"})
+	spec.Filters = append(spec.Filters, map[string]interface{}{
+		"kind": proxy.Kind,
+		"name": "proxy",
+		"mainPool": map[string]interface{}{
+			"servers":     servers,
+			"loadBalance": map[string]interface{}{"policy": policy},
+		},
+	})
+
+	return spec
+}
+
+// newSpec creates the supervisor spec of the object, the nil fields are
+// dropped since null is invalid for the json schema of objects.
+func newSpec(kind, name string, spec interface{}) (*supervisor.Spec, error) {
+	buff, err := yaml.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("BUG: marshal %#v to yaml failed: %v", spec, err)
+	}
+
+	m := map[string]interface{}{}
+	if err = yaml.Unmarshal(buff, &m); err != nil {
+		return nil, fmt.Errorf("BUG: unmarshal %s to map failed: %v", buff, err)
+	}
+	for k, v := range m {
+		if v == nil {
+			delete(m, k)
+		}
+	}
+	m["kind"], m["name"] = kind, name
+
+	buff, err = yaml.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("BUG: marshal %#v to yaml failed: %v", m, err)
+	}
+	return supervisor.NewSpec(string(buff))
+}
@@ -18,6 +18,7 @@
 package consulserviceregistry
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
@@ -25,6 +26,7 @@ import (
 
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/serviceregistry"
+	"github.com/megaease/easegress/pkg/object/serviceregistry/registration"
 	"github.com/megaease/easegress/pkg/supervisor"
 )
 
@@ -49,10 +51,16 @@ type (
 		clientMutex sync.RWMutex
 		client      *api.Client
 
-		statusMutex sync.Mutex
-		serversNum  map[string]int
+		statusMutex   sync.Mutex
+		serversNum    map[string]int
+		registrations map[string]string
 
-		done chan struct{}
+		// registered is the registered instances by service names,
+		// it's only accessed in the run goroutine and Close after it.
+		registered map[string]*registration.Instance
+
+		done    chan struct{}
+		runDone chan struct{}
 	}
 
 	// Spec describes the ConsulServiceRegistry.
@@ -64,15 +72,31 @@ type (
 		Namespace    string   `yaml:"namespace" jsonschema:"omitempty"`
 		SyncInterval string   `yaml:"syncInterval" jsonschema:"required,format=duration"`
 		ServiceTags  []string `yaml:"serviceTags" jsonschema:"omitempty"`
+		// Registrations are the services of Easegress registered into Consul.
+		Registrations []*registration.Spec `yaml:"registrations" jsonschema:"omitempty"`
 	}
 
 	// Status is the status of ConsulServiceRegistry.
 	Status struct {
 		Health     string         `yaml:"health"`
 		ServersNum map[string]int `yaml:"serversNum"`
+		// Registrations are the statuses of the registered services.
+		Registrations map[string]string `yaml:"registrations,omitempty"`
 	}
 )
 
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	serviceNames := map[string]bool{}
+	for _, r := range spec.Registrations {
+		if serviceNames[r.ServiceName] {
+			return fmt.Errorf("registration of service %s is repeated", r.ServiceName)
+		}
+		serviceNames[r.ServiceName] = true
+	}
+	return nil
+}
+
 // Category returns the category of ConsulServiceRegistry.
 func (c *ConsulServiceRegistry) Category() supervisor.ObjectCategory {
 	return Category
@@ -106,7 +130,9 @@ func (c *ConsulServiceRegistry) Inherit(superSpec *supervisor.Spec, previousGene
 
 func (c *ConsulServiceRegistry) reload() {
 	c.serversNum = map[string]int{}
+	c.registered = map[string]*registration.Instance{}
 	c.done = make(chan struct{})
+	c.runDone = make(chan struct{})
 
 	_, err := c.getClient()
 	if err != nil {
@@ -174,6 +200,8 @@ func (c *ConsulServiceRegistry) closeClient() {
 }
 
 func (c *ConsulServiceRegistry) run() {
+	defer close(c.runDone)
+
 	syncInterval, err := time.ParseDuration(c.spec.SyncInterval)
 	if err != nil {
 		logger.Errorf("BUG: parse duration %s failed: %v",
@@ -182,6 +210,7 @@ func (c *ConsulServiceRegistry) run() {
 	}
 
 	c.update()
+	c.register(syncInterval)
 
 	for {
 		select {
@@ -189,6 +218,7 @@ func (c *ConsulServiceRegistry) run() {
 			return
 		case <-time.After(syncInterval):
 			c.update()
+			c.register(syncInterval)
 		}
 	}
 }
@@ -252,6 +282,112 @@ func (c *ConsulServiceRegistry) update() {
 	c.statusMutex.Unlock()
 }
 
+// register registers the instances of the registrations with TTL checks,
+// the instances whose states are unchanged are only updated the checks.
+// Unhealthy instances are in warning state, and the ones of crashed
+// members turn critical and are deregistered by Consul after the TTL.
+func (c *ConsulServiceRegistry) register(syncInterval time.Duration) {
+	if len(c.spec.Registrations) == 0 && len(c.registered) == 0 {
+		return
+	}
+
+	client, err := c.getClient()
+	if err != nil {
+		logger.Errorf("%s get consul client failed: %v",
+			c.superSpec.Name(), err)
+		return
+	}
+	agent := client.Agent()
+
+	registered := map[string]*registration.Instance{}
+	registrations := map[string]string{}
+	for _, r := range c.spec.Registrations {
+		instance, err := r.Instance(c.superSpec.Super())
+		if err != nil {
+			registrations[r.ServiceName] = err.Error()
+			continue
+		}
+
+		if previous := c.registered[r.ServiceName]; !previous.Equals(instance) {
+			err = agent.ServiceRegister(c.serviceRegistration(instance, syncInterval))
+		}
+		if err == nil {
+			status, output := api.HealthPassing, ""
+			if instance.Health != "" {
+				status, output = api.HealthWarning, instance.Health
+			}
+			err = agent.UpdateTTL(checkID(instance), output, status)
+		}
+		if err != nil {
+			logger.Errorf("%s register service %s failed: %v",
+				c.superSpec.Name(), r.ServiceName, err)
+			registrations[r.ServiceName] = err.Error()
+			// NOTE: Keep the instance for deregistering it if needed.
+			registered[r.ServiceName] = &registration.Instance{ID: instance.ID, ServiceName: instance.ServiceName}
+			continue
+		}
+
+		registered[r.ServiceName] = instance
+		if instance.Health != "" {
+			registrations[r.ServiceName] = fmt.Sprintf("%s: %s", api.HealthWarning, instance.Health)
+		} else {
+			registrations[r.ServiceName] = api.HealthPassing
+		}
+	}
+
+	for serviceName, instance := range c.registered {
+		if _, exists := registered[serviceName]; !exists {
+			c.deregister(client, instance)
+		}
+	}
+	c.registered = registered
+
+	c.statusMutex.Lock()
+	c.registrations = registrations
+	c.statusMutex.Unlock()
+}
+
+func (c *ConsulServiceRegistry) serviceRegistration(instance *registration.Instance, syncInterval time.Duration) *api.AgentServiceRegistration {
+	scheme := "http"
+	if instance.HTTPS {
+		scheme = "https"
+	}
+
+	// NOTE: Consul reaps critical services at least one minute later.
+	ttl := 3 * syncInterval
+	deregisterAfter := ttl
+	if deregisterAfter < time.Minute {
+		deregisterAfter = time.Minute
+	}
+
+	return &api.AgentServiceRegistration{
+		ID:        instance.ID,
+		Name:      instance.ServiceName,
+		Address:   instance.HostIP,
+		Port:      int(instance.Port),
+		Tags:      instance.Tags,
+		Meta:      map[string]string{"scheme": scheme},
+		Namespace: c.spec.Namespace,
+		Check: &api.AgentServiceCheck{
+			CheckID:                        checkID(instance),
+			TTL:                            ttl.String(),
+			DeregisterCriticalServiceAfter: deregisterAfter.String(),
+		},
+	}
+}
+
+func (c *ConsulServiceRegistry) deregister(client *api.Client, instance *registration.Instance) {
+	err := client.Agent().ServiceDeregister(instance.ID)
+	if err != nil {
+		logger.Errorf("%s deregister service %s failed: %v",
+			c.superSpec.Name(), instance.ServiceName, err)
+	}
+}
+
+func checkID(instance *registration.Instance) string {
+	return "service:" + instance.ID
+}
+
 // Status returns status of ConsulServiceRegister.
 func (c *ConsulServiceRegistry) Status() *supervisor.Status {
 	s := &Status{}
@@ -265,9 +401,11 @@ func (c *ConsulServiceRegistry) Status() *supervisor.Status {
 
 	c.statusMutex.Lock()
 	serversNum := c.serversNum
+	registrations := c.registrations
 	c.statusMutex.Unlock()
 
 	s.ServersNum = serversNum
+	s.Registrations = registrations
 
 	return &supervisor.Status{
 		ObjectStatus: s,
@@ -276,8 +414,17 @@ func (c *ConsulServiceRegistry) Status() *supervisor.Status {
 
 // Close closes ConsulServiceRegistry.
 func (c *ConsulServiceRegistry) Close() {
-	c.closeClient()
 	close(c.done)
+	<-c.runDone
+
+	if len(c.registered) != 0 {
+		if client, err := c.getClient(); err == nil {
+			for _, instance := range c.registered {
+				c.deregister(client, instance)
+			}
+		}
+	}
+	c.closeClient()
 
 	serviceregistry.Global.CloseRegistry(c.superSpec.Name())
 }
@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package consulserviceregistry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/rawconfigtrafficcontroller"
+	"github.com/megaease/easegress/pkg/object/trafficcontroller"
+	"github.com/megaease/easegress/pkg/option"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+// mockAgent is the agent API of Consul recording the registrations.
+type mockAgent struct {
+	mutex         sync.Mutex
+	registrations []*api.AgentServiceRegistration
+	ttlUpdates    []string
+	deregistered  []string
+}
+
+func (m *mockAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	switch {
+	case r.URL.Path == "/v1/catalog/services":
+		w.Write([]byte("{}"))
+	case r.URL.Path == "/v1/agent/service/register":
+		reg := &api.AgentServiceRegistration{}
+		json.NewDecoder(r.Body).Decode(reg)
+		m.registrations = append(m.registrations, reg)
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/check/update/"):
+		update := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&update)
+		id := strings.TrimPrefix(r.URL.Path, "/v1/agent/check/update/")
+		m.ttlUpdates = append(m.ttlUpdates, fmt.Sprintf("%s %s %s", id, update["Status"], update["Output"]))
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+		m.deregistered = append(m.deregistered, strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (m *mockAgent) snapshot() ([]*api.AgentServiceRegistration, []string, []string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]*api.AgentServiceRegistration{}, m.registrations...),
+		append([]string{}, m.ttlUpdates...), append([]string{}, m.deregistered...)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("wait timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRegistrations(t *testing.T) {
+	agent := &mockAgent{}
+	server := httptest.NewServer(agent)
+	defer server.Close()
+
+	opt := option.New()
+	opt.Name = "member-1"
+	opt.Standalone = true
+	cls, err := cluster.New(opt)
+	if err != nil {
+		t.Fatalf("new standalone cluster failed: %v", err)
+	}
+	super := supervisor.MustNew(opt, cls)
+	defer func() {
+		wg := &sync.WaitGroup{}
+		wg.Add(2)
+		super.Close(wg)
+		cls.Close(wg)
+		wg.Wait()
+	}()
+	<-super.FirstHandleDone()
+
+	entity, _ := super.GetSystemController(trafficcontroller.Kind)
+	tc := entity.Instance().(*trafficcontroller.TrafficController)
+	namespace := rawconfigtrafficcontroller.DefaultNamespace
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	for _, yamlSpec := range []string{
+		fmt.Sprintf("kind: HTTPServer\nname: server\nport: %d\nrules: []\n", port),
+		"kind: HTTPPipeline\nname: pipeline\nfilters: []\n",
+	} {
+		spec, err := super.NewSpec(yamlSpec)
+		if err != nil {
+			t.Fatalf("new spec failed: %v", err)
+		}
+		if spec.Kind() == "HTTPServer" {
+			_, err = tc.CreateHTTPServerForSpec(namespace, spec)
+		} else {
+			_, err = tc.CreateHTTPPipelineForSpec(namespace, spec)
+		}
+		if err != nil {
+			t.Fatalf("create %s failed: %v", spec.Name(), err)
+		}
+	}
+
+	spec, err := super.NewSpec(fmt.Sprintf(`
+kind: ConsulServiceRegistry
+name: consul
+address: %s
+syncInterval: 50ms
+registrations:
+- serviceName: gateway
+  httpServer: server
+  pipelines: [pipeline]
+  hostIP: 10.0.0.1
+  tags: [v1]
+- serviceName: missing
+  httpServer: missing
+`, strings.TrimPrefix(server.URL, "http://")))
+	if err != nil {
+		t.Fatalf("new spec failed: %v", err)
+	}
+	c := &ConsulServiceRegistry{}
+	c.Init(spec)
+	status := func() map[string]string {
+		return c.Status().ObjectStatus.(*Status).Registrations
+	}
+
+	waitFor(t, func() bool {
+		_, ttlUpdates, _ := agent.snapshot()
+		return len(ttlUpdates) >= 3
+	})
+	registrations, ttlUpdates, _ := agent.snapshot()
+	// The unchanged instance is registered once, and then only its TTL
+	// check is updated.
+	if len(registrations) != 1 {
+		t.Fatalf("expect 1 registration, got %d", len(registrations))
+	}
+	reg := registrations[0]
+	if reg.ID != "gateway-member-1" || reg.Name != "gateway" || reg.Address != "10.0.0.1" || reg.Port != port ||
+		len(reg.Tags) != 1 || reg.Meta["scheme"] != "http" || reg.Check.CheckID != "service:gateway-member-1" ||
+		reg.Check.TTL != "150ms" || reg.Check.DeregisterCriticalServiceAfter != "1m0s" {
+		t.Errorf("unexpected registration %+v %+v", reg, reg.Check)
+	}
+	if ttlUpdates[0] != "service:gateway-member-1 passing " {
+		t.Errorf("unexpected ttl update %q", ttlUpdates[0])
+	}
+	if s := status(); s["gateway"] != api.HealthPassing || s["missing"] != "httpserver missing not found" {
+		t.Errorf("unexpected status %v", s)
+	}
+
+	// The instance turns unhealthy, so it's registered again in warning.
+	tc.DeleteHTTPPipeline(namespace, "pipeline")
+	waitFor(t, func() bool {
+		return status()["gateway"] == "warning: pipeline pipeline not found"
+	})
+	registrations, ttlUpdates, _ = agent.snapshot()
+	if len(registrations) != 2 {
+		t.Errorf("expect 2 registrations, got %d", len(registrations))
+	}
+	if last := ttlUpdates[len(ttlUpdates)-1]; last != "service:gateway-member-1 warning pipeline pipeline not found" {
+		t.Errorf("unexpected ttl update %q", last)
+	}
+
+	c.Close()
+	if _, _, deregistered := agent.snapshot(); len(deregistered) != 1 || deregistered[0] != "gateway-member-1" {
+		t.Errorf("the registered instance should be deregistered on closing, got %v", deregistered)
+	}
+}
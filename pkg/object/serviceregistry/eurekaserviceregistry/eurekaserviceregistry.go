@@ -18,6 +18,8 @@
 package eurekaserviceregistry
 
 import (
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,6 +27,7 @@ import (
 
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/serviceregistry"
+	"github.com/megaease/easegress/pkg/object/serviceregistry/registration"
 	"github.com/megaease/easegress/pkg/supervisor"
 )
 
@@ -49,25 +52,48 @@ type (
 		clientMutex sync.RWMutex
 		client      *eurekaapi.Client
 
-		statusMutex sync.Mutex
-		serversNum  map[string]int
+		statusMutex   sync.Mutex
+		serversNum    map[string]int
+		registrations map[string]string
 
-		done chan struct{}
+		// registered is the registered instances by service names,
+		// it's only accessed in the run goroutine and Close after it.
+		registered map[string]*registration.Instance
+
+		done    chan struct{}
+		runDone chan struct{}
 	}
 
 	// Spec describes the EurekaServiceRegistry.
 	Spec struct {
 		Endpoints    []string `yaml:"endpoints" jsonschema:"required,uniqueItems=true"`
 		SyncInterval string   `yaml:"syncInterval" jsonschema:"required,format=duration"`
+		// Registrations are the services of Easegress registered into Eureka.
+		Registrations []*registration.Spec `yaml:"registrations" jsonschema:"omitempty"`
 	}
 
 	// Status is the status of EurekaServiceRegistry.
 	Status struct {
 		Health     string         `yaml:"health"`
 		ServersNum map[string]int `yaml:"serversNum"`
+		// Registrations are the statuses of the registered services.
+		Registrations map[string]string `yaml:"registrations,omitempty"`
 	}
 )
 
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	serviceNames := map[string]bool{}
+	for _, r := range spec.Registrations {
+		name := strings.ToUpper(r.ServiceName)
+		if serviceNames[name] {
+			return fmt.Errorf("registration of service %s is repeated", r.ServiceName)
+		}
+		serviceNames[name] = true
+	}
+	return nil
+}
+
 // Category returns the category of EurekaServiceRegistry.
 func (eureka *EurekaServiceRegistry) Category() supervisor.ObjectCategory {
 	return Category
@@ -100,7 +126,9 @@ func (eureka *EurekaServiceRegistry) Inherit(superSpec *supervisor.Spec, previou
 
 func (eureka *EurekaServiceRegistry) reload() {
 	eureka.serversNum = make(map[string]int)
+	eureka.registered = make(map[string]*registration.Instance)
 	eureka.done = make(chan struct{})
+	eureka.runDone = make(chan struct{})
 
 	_, err := eureka.getClient()
 	if err != nil {
@@ -150,6 +178,8 @@ func (eureka *EurekaServiceRegistry) closeClient() {
 }
 
 func (eureka *EurekaServiceRegistry) run() {
+	defer close(eureka.runDone)
+
 	syncInterval, err := time.ParseDuration(eureka.spec.SyncInterval)
 	if err != nil {
 		logger.Errorf("BUG: parse duration %s failed: %v",
@@ -158,6 +188,7 @@ func (eureka *EurekaServiceRegistry) run() {
 	}
 
 	eureka.update()
+	eureka.register()
 
 	for {
 		select {
@@ -165,6 +196,7 @@ func (eureka *EurekaServiceRegistry) run() {
 			return
 		case <-time.After(syncInterval):
 			eureka.update()
+			eureka.register()
 		}
 	}
 }
@@ -217,6 +249,101 @@ func (eureka *EurekaServiceRegistry) update() {
 	eureka.statusMutex.Unlock()
 }
 
+// register registers the instances of the registrations, the instances
+// whose states are unchanged are only sent heartbeats.
+func (eureka *EurekaServiceRegistry) register() {
+	if len(eureka.spec.Registrations) == 0 && len(eureka.registered) == 0 {
+		return
+	}
+
+	client, err := eureka.getClient()
+	if err != nil {
+		logger.Errorf("%s get eureka client failed: %v",
+			eureka.superSpec.Name(), err)
+		return
+	}
+
+	registered := map[string]*registration.Instance{}
+	registrations := map[string]string{}
+	for _, r := range eureka.spec.Registrations {
+		instance, err := r.Instance(eureka.superSpec.Super())
+		if err != nil {
+			registrations[r.ServiceName] = err.Error()
+			continue
+		}
+
+		appID := strings.ToUpper(instance.ServiceName)
+		previous := eureka.registered[r.ServiceName]
+		if previous.Equals(instance) {
+			err = client.SendHeartbeat(appID, instance.ID)
+		}
+		if !previous.Equals(instance) || err != nil {
+			err = client.RegisterInstance(appID, eurekaInstanceInfo(instance))
+		}
+		if err != nil {
+			logger.Errorf("%s register service %s failed: %v",
+				eureka.superSpec.Name(), r.ServiceName, err)
+			registrations[r.ServiceName] = err.Error()
+			// NOTE: Keep the instance for deregistering it if needed.
+			registered[r.ServiceName] = &registration.Instance{ID: instance.ID, ServiceName: instance.ServiceName}
+			continue
+		}
+
+		registered[r.ServiceName] = instance
+		if instance.Health != "" {
+			registrations[r.ServiceName] = fmt.Sprintf("%s: %s", eurekaapi.DOWN, instance.Health)
+		} else {
+			registrations[r.ServiceName] = eurekaapi.UP
+		}
+	}
+
+	for serviceName, instance := range eureka.registered {
+		if _, exists := registered[serviceName]; !exists {
+			eureka.deregister(client, instance)
+		}
+	}
+	eureka.registered = registered
+
+	eureka.statusMutex.Lock()
+	eureka.registrations = registrations
+	eureka.statusMutex.Unlock()
+}
+
+func (eureka *EurekaServiceRegistry) deregister(client *eurekaapi.Client, instance *registration.Instance) {
+	err := client.UnregisterInstance(strings.ToUpper(instance.ServiceName), instance.ID)
+	if err != nil {
+		logger.Errorf("%s deregister service %s failed: %v",
+			eureka.superSpec.Name(), instance.ServiceName, err)
+	}
+}
+
+func eurekaInstanceInfo(instance *registration.Instance) *eurekaapi.InstanceInfo {
+	info := &eurekaapi.InstanceInfo{
+		InstanceID:       instance.ID,
+		HostName:         instance.Hostname,
+		App:              strings.ToUpper(instance.ServiceName),
+		IpAddr:           instance.HostIP,
+		VipAddress:       instance.ServiceName,
+		SecureVipAddress: instance.ServiceName,
+		Status:           eurekaapi.UP,
+		Port:             &eurekaapi.Port{Port: int(instance.Port), Enabled: !instance.HTTPS},
+		SecurePort:       &eurekaapi.Port{Port: int(instance.Port), Enabled: instance.HTTPS},
+		DataCenterInfo: &eurekaapi.DataCenterInfo{
+			Name:  "MyOwn",
+			Class: "com.netflix.appinfo.InstanceInfo$DefaultDataCenterInfo",
+		},
+	}
+	if instance.Health != "" {
+		info.Status = eurekaapi.DOWN
+	}
+	if len(instance.Tags) != 0 {
+		info.Metadata = &eurekaapi.MetaData{Map: map[string]string{
+			"tags": strings.Join(instance.Tags, ","),
+		}}
+	}
+	return info
+}
+
 // Status returns status of EurekaServiceRegister.
 func (eureka *EurekaServiceRegistry) Status() *supervisor.Status {
 	s := &Status{}
@@ -230,9 +357,11 @@ func (eureka *EurekaServiceRegistry) Status() *supervisor.Status {
 
 	eureka.statusMutex.Lock()
 	serversNum := eureka.serversNum
+	registrations := eureka.registrations
 	eureka.statusMutex.Unlock()
 
 	s.ServersNum = serversNum
+	s.Registrations = registrations
 
 	return &supervisor.Status{
 		ObjectStatus: s,
@@ -241,8 +370,17 @@ func (eureka *EurekaServiceRegistry) Status() *supervisor.Status {
 
 // Close closes EurekaServiceRegistry.
 func (eureka *EurekaServiceRegistry) Close() {
-	eureka.closeClient()
 	close(eureka.done)
+	<-eureka.runDone
+
+	if len(eureka.registered) != 0 {
+		if client, err := eureka.getClient(); err == nil {
+			for _, instance := range eureka.registered {
+				eureka.deregister(client, instance)
+			}
+		}
+	}
+	eureka.closeClient()
 
 	serviceregistry.Global.CloseRegistry(eureka.superSpec.Name())
 }
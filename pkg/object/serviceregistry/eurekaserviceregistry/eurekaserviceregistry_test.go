@@ -0,0 +1,223 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eurekaserviceregistry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	eurekaapi "github.com/ArthurHlt/go-eureka-client/eureka"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/rawconfigtrafficcontroller"
+	"github.com/megaease/easegress/pkg/object/trafficcontroller"
+	"github.com/megaease/easegress/pkg/option"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+// mockEureka is the API of Eureka recording the registrations, it forgets
+// the instances if lost is set, so the heartbeats fail.
+type mockEureka struct {
+	mutex         sync.Mutex
+	lost          bool
+	registrations []*eurekaapi.InstanceInfo
+	heartbeats    int
+	deregistered  []string
+}
+
+func (m *mockEureka) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/eureka/")
+	switch {
+	case r.Method == http.MethodGet && path == "apps":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"applications": {"application": []}}`))
+	case r.Method == http.MethodPost:
+		body, _ := ioutil.ReadAll(r.Body)
+		instance := &eurekaapi.Instance{}
+		json.Unmarshal(body, instance)
+		// MetaData of the client fails to unmarshal JSON, so decodes it here.
+		metadata := &struct {
+			Instance struct {
+				Metadata map[string]string `json:"metadata"`
+			} `json:"instance"`
+		}{}
+		json.Unmarshal(body, metadata)
+		instance.Instance.Metadata = &eurekaapi.MetaData{Map: metadata.Instance.Metadata}
+		m.registrations = append(m.registrations, instance.Instance)
+		m.lost = false
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		if m.lost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		m.heartbeats++
+	case r.Method == http.MethodDelete:
+		m.deregistered = append(m.deregistered, path)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (m *mockEureka) snapshot() ([]*eurekaapi.InstanceInfo, int, []string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]*eurekaapi.InstanceInfo{}, m.registrations...), m.heartbeats,
+		append([]string{}, m.deregistered...)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("wait timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRegistrations(t *testing.T) {
+	mock := &mockEureka{}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	opt := option.New()
+	opt.Name = "member-1"
+	opt.Standalone = true
+	cls, err := cluster.New(opt)
+	if err != nil {
+		t.Fatalf("new standalone cluster failed: %v", err)
+	}
+	super := supervisor.MustNew(opt, cls)
+	defer func() {
+		wg := &sync.WaitGroup{}
+		wg.Add(2)
+		super.Close(wg)
+		cls.Close(wg)
+		wg.Wait()
+	}()
+	<-super.FirstHandleDone()
+
+	entity, _ := super.GetSystemController(trafficcontroller.Kind)
+	tc := entity.Instance().(*trafficcontroller.TrafficController)
+	namespace := rawconfigtrafficcontroller.DefaultNamespace
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	for _, yamlSpec := range []string{
+		fmt.Sprintf("kind: HTTPServer\nname: server\nport: %d\nrules: []\n", port),
+		"kind: HTTPPipeline\nname: pipeline\nfilters: []\n",
+	} {
+		spec, err := super.NewSpec(yamlSpec)
+		if err != nil {
+			t.Fatalf("new spec failed: %v", err)
+		}
+		if spec.Kind() == "HTTPServer" {
+			_, err = tc.CreateHTTPServerForSpec(namespace, spec)
+		} else {
+			_, err = tc.CreateHTTPPipelineForSpec(namespace, spec)
+		}
+		if err != nil {
+			t.Fatalf("create %s failed: %v", spec.Name(), err)
+		}
+	}
+
+	spec, err := super.NewSpec(fmt.Sprintf(`
+kind: EurekaServiceRegistry
+name: eureka
+endpoints: [%s/eureka]
+syncInterval: 50ms
+registrations:
+- serviceName: gateway
+  httpServer: server
+  pipelines: [pipeline]
+  hostIP: 10.0.0.1
+  tags: [v1, v2]
+`, server.URL))
+	if err != nil {
+		t.Fatalf("new spec failed: %v", err)
+	}
+	e := &EurekaServiceRegistry{}
+	e.Init(spec)
+	status := func() map[string]string {
+		return e.Status().ObjectStatus.(*Status).Registrations
+	}
+
+	waitFor(t, func() bool {
+		_, heartbeats, _ := mock.snapshot()
+		return heartbeats >= 2
+	})
+	// The unchanged instance is registered once, and then only sent heartbeats.
+	registrations, _, _ := mock.snapshot()
+	if len(registrations) != 1 {
+		t.Fatalf("expect 1 registration, got %d", len(registrations))
+	}
+	info := registrations[0]
+	if info.InstanceID != "gateway-member-1" || info.App != "GATEWAY" || info.IpAddr != "10.0.0.1" ||
+		info.Status != eurekaapi.UP || info.Port.Port != port || !info.Port.Enabled || info.SecurePort.Enabled ||
+		info.Metadata.Map["tags"] != "v1,v2" {
+		t.Errorf("unexpected instance %+v", info)
+	}
+	if s := status(); s["gateway"] != eurekaapi.UP {
+		t.Errorf("unexpected status %v", s)
+	}
+
+	// Eureka lost the instance, so it's registered again.
+	mock.mutex.Lock()
+	mock.lost = true
+	mock.mutex.Unlock()
+	waitFor(t, func() bool {
+		registrations, _, _ := mock.snapshot()
+		return len(registrations) == 2
+	})
+
+	// The instance turns unhealthy, so it's registered again in DOWN.
+	tc.DeleteHTTPPipeline(namespace, "pipeline")
+	waitFor(t, func() bool {
+		return status()["gateway"] == "DOWN: pipeline pipeline not found"
+	})
+	registrations, _, _ = mock.snapshot()
+	if last := registrations[len(registrations)-1]; last.Status != eurekaapi.DOWN {
+		t.Errorf("expect status DOWN, got %s", last.Status)
+	}
+
+	e.Close()
+	if _, _, deregistered := mock.snapshot(); len(deregistered) != 1 || deregistered[0] != "apps/GATEWAY/gateway-member-1" {
+		t.Errorf("the registered instance should be deregistered on closing, got %v", deregistered)
+	}
+}
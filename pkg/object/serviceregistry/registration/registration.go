@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package registration resolves the services of Easegress, which are
+// registered into the external service registries.
+package registration
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httpserver"
+	"github.com/megaease/easegress/pkg/object/rawconfigtrafficcontroller"
+	"github.com/megaease/easegress/pkg/object/trafficcontroller"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+type (
+	// Spec describes a service of Easegress which is registered into the
+	// external service registry, so that discovery-based clients could
+	// find the gateway endpoints. The service is served by the HTTPServer
+	// on every member of the cluster.
+	Spec struct {
+		ServiceName string `yaml:"serviceName" jsonschema:"required"`
+		HTTPServer  string `yaml:"httpServer" jsonschema:"required"`
+		// Pipelines are the pipelines behind the service, the instance is
+		// unhealthy if any of them doesn't exist or is unhealthy.
+		Pipelines []string `yaml:"pipelines" jsonschema:"omitempty,uniqueItems=true"`
+		// HostIP is the IP of the registered instance, the first
+		// non-loopback IPv4 of the host is used if it's empty.
+		HostIP string   `yaml:"hostIP" jsonschema:"omitempty"`
+		Tags   []string `yaml:"tags" jsonschema:"omitempty,uniqueItems=true"`
+	}
+
+	// Instance is the instance of the registration on the current member.
+	Instance struct {
+		ID          string
+		ServiceName string
+		Hostname    string
+		HostIP      string
+		Port        uint16
+		HTTPS       bool
+		Tags        []string
+		// Health is empty if the instance is healthy,
+		// otherwise it's the reason of being unhealthy.
+		Health string
+	}
+)
+
+// Instance returns the instance of the registration on the current member,
+// it returns an error if the HTTPServer doesn't exist.
+func (r *Spec) Instance(super *supervisor.Supervisor) (*Instance, error) {
+	entity, exists := super.GetSystemController(trafficcontroller.Kind)
+	if !exists {
+		return nil, fmt.Errorf("traffic controller not found")
+	}
+	tc := entity.Instance().(*trafficcontroller.TrafficController)
+
+	namespace := rawconfigtrafficcontroller.DefaultNamespace
+	server, exists := tc.GetHTTPServer(namespace, r.HTTPServer)
+	if !exists {
+		return nil, fmt.Errorf("httpserver %s not found", r.HTTPServer)
+	}
+	serverSpec := server.Spec().ObjectSpec().(*httpserver.Spec)
+
+	hostIP := r.HostIP
+	if hostIP == "" {
+		hostIP = hostIPv4()
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = hostIP
+	}
+
+	instance := &Instance{
+		ID:          fmt.Sprintf("%s-%s", r.ServiceName, super.Options().Name),
+		ServiceName: r.ServiceName,
+		Hostname:    hostname,
+		HostIP:      hostIP,
+		Port:        serverSpec.Port,
+		HTTPS:       serverSpec.HTTPS,
+		Tags:        r.Tags,
+	}
+
+	if health := server.Instance().Status().ObjectStatus.(*httpserver.Status).Health; health != "" {
+		instance.Health = fmt.Sprintf("httpserver %s: %s", r.HTTPServer, health)
+		return instance, nil
+	}
+
+	for _, name := range r.Pipelines {
+		pipeline, exists := tc.GetHTTPPipeline(namespace, name)
+		if !exists {
+			instance.Health = fmt.Sprintf("pipeline %s not found", name)
+			break
+		}
+		if health := pipeline.Instance().Status().ObjectStatus.(*httppipeline.Status).Health; health != "" {
+			instance.Health = fmt.Sprintf("pipeline %s: %s", name, health)
+			break
+		}
+	}
+
+	return instance, nil
+}
+
+// Equals reports whether the instance equals to the other one.
+func (i *Instance) Equals(other *Instance) bool {
+	if i == nil || other == nil {
+		return i == other
+	}
+	if len(i.Tags) != len(other.Tags) {
+		return false
+	}
+	for idx := range i.Tags {
+		if i.Tags[idx] != other.Tags[idx] {
+			return false
+		}
+	}
+	return i.ID == other.ID && i.ServiceName == other.ServiceName &&
+		i.Hostname == other.Hostname && i.HostIP == other.HostIP &&
+		i.Port == other.Port && i.HTTPS == other.HTTPS && i.Health == other.Health
+}
+
+func hostIPv4() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "127.0.0.1"
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP.String()
+		}
+	}
+	return "127.0.0.1"
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registration
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/rawconfigtrafficcontroller"
+	"github.com/megaease/easegress/pkg/object/trafficcontroller"
+	"github.com/megaease/easegress/pkg/option"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestInstance(t *testing.T) {
+	opt := option.New()
+	opt.Name = "member-1"
+	opt.Standalone = true
+	cls, err := cluster.New(opt)
+	if err != nil {
+		t.Fatalf("new standalone cluster failed: %v", err)
+	}
+	super := supervisor.MustNew(opt, cls)
+	defer func() {
+		wg := &sync.WaitGroup{}
+		wg.Add(2)
+		super.Close(wg)
+		cls.Close(wg)
+		wg.Wait()
+	}()
+	<-super.FirstHandleDone()
+
+	entity, _ := super.GetSystemController(trafficcontroller.Kind)
+	tc := entity.Instance().(*trafficcontroller.TrafficController)
+	create := func(yamlSpec string, args ...interface{}) {
+		spec, err := super.NewSpec(fmt.Sprintf(yamlSpec, args...))
+		if err != nil {
+			t.Fatalf("new spec failed: %v", err)
+		}
+		namespace := rawconfigtrafficcontroller.DefaultNamespace
+		if spec.Kind() == "HTTPServer" {
+			_, err = tc.CreateHTTPServerForSpec(namespace, spec)
+		} else {
+			_, err = tc.CreateHTTPPipelineForSpec(namespace, spec)
+		}
+		if err != nil {
+			t.Fatalf("create %s failed: %v", spec.Name(), err)
+		}
+	}
+
+	port := freePort(t)
+	create("kind: HTTPServer\nname: server\nport: %d\nhttps: false\nrules: []\n", port)
+	create("kind: HTTPPipeline\nname: pipeline\nfilters: []\n")
+
+	spec := &Spec{
+		ServiceName: "gateway",
+		HTTPServer:  "server",
+		Pipelines:   []string{"pipeline"},
+		HostIP:      "10.0.0.1",
+		Tags:        []string{"v1"},
+	}
+	instance, err := spec.Instance(super)
+	if err != nil {
+		t.Fatalf("resolve instance failed: %v", err)
+	}
+	if instance.ID != "gateway-member-1" || instance.ServiceName != "gateway" || instance.HostIP != "10.0.0.1" ||
+		int(instance.Port) != port || instance.HTTPS || instance.Hostname == "" || instance.Health != "" {
+		t.Errorf("unexpected instance %+v", instance)
+	}
+
+	spec.HostIP = ""
+	if instance, _ = spec.Instance(super); net.ParseIP(instance.HostIP).To4() == nil {
+		t.Errorf("host ip should be an IPv4 by default, got %s", instance.HostIP)
+	}
+
+	spec.Pipelines = []string{"pipeline", "missing"}
+	if instance, _ = spec.Instance(super); instance.Health != "pipeline missing not found" {
+		t.Errorf("instance should be unhealthy for missing pipeline, got %q", instance.Health)
+	}
+
+	spec.HTTPServer = "missing"
+	if _, err = spec.Instance(super); err == nil {
+		t.Errorf("resolving instance of missing http server should fail")
+	}
+
+	// The port of the server is occupied, so it's unhealthy.
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer l.Close()
+	create("kind: HTTPServer\nname: busy\nport: %d\nrules: []\n", l.Addr().(*net.TCPAddr).Port)
+	spec = &Spec{ServiceName: "busy", HTTPServer: "busy"}
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		instance, err = spec.Instance(super)
+		if err == nil && strings.HasPrefix(instance.Health, "httpserver busy: ") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("instance of the busy server should be unhealthy, got %+v, %v", instance, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEquals(t *testing.T) {
+	var nilInstance *Instance
+	instance := &Instance{ID: "gateway-1", ServiceName: "gateway", Port: 80, Tags: []string{"v1"}}
+	if !nilInstance.Equals(nil) || nilInstance.Equals(instance) || instance.Equals(nil) {
+		t.Errorf("nil instances should only equal each other")
+	}
+
+	other := *instance
+	if !instance.Equals(&other) {
+		t.Errorf("copied instance should be equal")
+	}
+	for _, modify := range []func(i *Instance){
+		func(i *Instance) { i.Health = "down" },
+		func(i *Instance) { i.Port = 8080 },
+		func(i *Instance) { i.HTTPS = true },
+		func(i *Instance) { i.Tags = []string{"v2"} },
+		func(i *Instance) { i.Tags = nil },
+	} {
+		other := *instance
+		modify(&other)
+		if instance.Equals(&other) {
+			t.Errorf("modified instance %+v should not be equal", other)
+		}
+	}
+}
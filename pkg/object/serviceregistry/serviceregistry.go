@@ -87,6 +87,20 @@ func (sg *ServiceRegistry) GetService(registryName, serviceName string) (*Servic
 	return service, nil
 }
 
+// ListServices lists the services of all registries by the registry names.
+func (sg *ServiceRegistry) ListServices() map[string][]*Service {
+	sg.mutex.RLock()
+	defer sg.mutex.RUnlock()
+
+	services := make(map[string][]*Service, len(sg.registries))
+	for registryName, registry := range sg.registries {
+		for _, service := range registry {
+			services[registryName] = append(services[registryName], service)
+		}
+	}
+	return services
+}
+
 // ReplaceServers replaces all servers of the registry.
 func (sg *ServiceRegistry) ReplaceServers(registryName string, servers []*Server) {
 	serversByService := map[string][]*Server{}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package sharedstateprovider provides the controller which sets the
+// provider of the state shared by all members.
+package sharedstateprovider
+
+import (
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/sharedstate"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Kind is the kind of SharedStateProvider.
+	Kind = "SharedStateProvider"
+)
+
+func init() {
+	supervisor.Register(&SharedStateProvider{})
+}
+
+type (
+	// SharedStateProvider sets the provider of the shared state used by
+	// distributed rate limiting, idempotency, sessions and caching, the
+	// etcd of the cluster is used if there is no SharedStateProvider.
+	// There should be at most one SharedStateProvider.
+	SharedStateProvider struct {
+		superSpec *supervisor.Spec
+		spec      *sharedstate.Spec
+		provider  *sharedstate.Provider
+		err       error
+	}
+)
+
+// Category returns the category of SharedStateProvider.
+func (ssp *SharedStateProvider) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+
+// Kind returns the kind of SharedStateProvider.
+func (ssp *SharedStateProvider) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of SharedStateProvider.
+func (ssp *SharedStateProvider) DefaultSpec() interface{} {
+	return &sharedstate.Spec{}
+}
+
+// Init initializes SharedStateProvider.
+func (ssp *SharedStateProvider) Init(superSpec *supervisor.Spec) {
+	ssp.reload(superSpec)
+}
+
+// Inherit inherits previous generation of SharedStateProvider.
+func (ssp *SharedStateProvider) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	// NOTE: The new provider replaces the previous one before it's
+	// closed, so there is no window falling back to etcd.
+	ssp.reload(superSpec)
+	previousGeneration.Close()
+}
+
+func (ssp *SharedStateProvider) reload(superSpec *supervisor.Spec) {
+	ssp.superSpec, ssp.spec = superSpec, superSpec.ObjectSpec().(*sharedstate.Spec)
+
+	ssp.provider, ssp.err = sharedstate.New(ssp.spec, superSpec.Super().Cluster())
+	if ssp.err != nil {
+		logger.Errorf("create shared state provider %s failed: %v", superSpec.Name(), ssp.err)
+		return
+	}
+	sharedstate.SetProvider(ssp.provider)
+}
+
+// Status returns the status of SharedStateProvider.
+func (ssp *SharedStateProvider) Status() *supervisor.Status {
+	if ssp.provider == nil {
+		return &supervisor.Status{
+			ObjectStatus: &sharedstate.Status{
+				Provider:  ssp.spec.Provider,
+				LastError: ssp.err.Error(),
+			},
+		}
+	}
+	return &supervisor.Status{ObjectStatus: ssp.provider.Status()}
+}
+
+// Close closes SharedStateProvider.
+func (ssp *SharedStateProvider) Close() {
+	if ssp.provider == nil {
+		return
+	}
+	// NOTE: The next generation may have set itself.
+	sharedstate.ResetProvider(ssp.provider)
+	ssp.provider.Close()
+}
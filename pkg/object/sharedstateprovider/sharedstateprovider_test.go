@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sharedstateprovider
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/option"
+	"github.com/megaease/easegress/pkg/sharedstate"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+var testSuper *supervisor.Supervisor
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+
+	opt := option.New()
+	opt.Name = "standalone-member"
+	opt.Standalone = true
+	cls, err := cluster.New(opt)
+	if err != nil {
+		panic(err)
+	}
+	testSuper = supervisor.MustNew(opt, cls)
+	<-testSuper.FirstHandleDone()
+
+	code := m.Run()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	testSuper.Close(wg)
+	cls.Close(wg)
+	wg.Wait()
+	os.Exit(code)
+}
+
+func newSpec(t *testing.T, provider string) *supervisor.Spec {
+	spec, err := testSuper.NewSpec("kind: SharedStateProvider\nname: ssp\nprovider: " + provider + "\n")
+	if err != nil {
+		t.Fatalf("new spec failed: %v", err)
+	}
+	return spec
+}
+
+func put(t *testing.T, key, value string) {
+	s, err := sharedstate.Namespace(nil, "test")
+	if err != nil {
+		t.Fatalf("namespace failed: %v", err)
+	}
+	if err := s.Put(key, value, 0); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for i, yamlConfig := range []string{
+		"kind: SharedStateProvider\nname: ssp\n",
+		"kind: SharedStateProvider\nname: ssp\nprovider: mysql\n",
+		"kind: SharedStateProvider\nname: ssp\nprovider: redis\n",
+		"kind: SharedStateProvider\nname: ssp\nprovider: memory\nredis:\n  address: 127.0.0.1:6379\n",
+	} {
+		if _, err := testSuper.NewSpec(yamlConfig); err == nil {
+			t.Errorf("spec %d should be invalid", i)
+		}
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	ssp := &SharedStateProvider{}
+	ssp.Init(newSpec(t, sharedstate.ProviderMemory))
+
+	put(t, "k", "v1")
+	if v, ok, _ := ssp.provider.Store("test").Get("k"); !ok || v != "v1" {
+		t.Fatalf("the store should be from the provider, got %s %v", v, ok)
+	}
+	status := ssp.Status().ObjectStatus.(*sharedstate.Status)
+	if status.Provider != sharedstate.ProviderMemory || !status.Healthy || status.Operations != 2 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	// The next generation takes over before the previous one is closed.
+	next := &SharedStateProvider{}
+	next.Inherit(newSpec(t, sharedstate.ProviderMemory), ssp)
+	put(t, "k", "v2")
+	if v, _, _ := next.provider.Store("test").Get("k"); v != "v2" {
+		t.Fatalf("the store should be from the next generation, got %s", v)
+	}
+
+	// Namespace falls back to the etcd of the cluster after closing.
+	next.Close()
+	if _, err := sharedstate.Namespace(nil, "test"); err == nil {
+		t.Fatalf("the provider should be reset")
+	}
+}
+
+func TestEtcdProvider(t *testing.T) {
+	ssp := &SharedStateProvider{}
+	ssp.Init(newSpec(t, sharedstate.ProviderEtcd))
+	defer ssp.Close()
+
+	put(t, "k", "v")
+	if v, ok, err := ssp.provider.Store("test").Get("k"); !ok || err != nil || v != "v" {
+		t.Fatalf("get: %s %v %v", v, ok, err)
+	}
+	if status := ssp.Status().ObjectStatus.(*sharedstate.Status); status.Provider != sharedstate.ProviderEtcd {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
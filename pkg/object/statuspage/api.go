@@ -0,0 +1,202 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package statuspage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/api"
+)
+
+const (
+	apiGroupName = "statuspage_admin"
+	apiPrefix    = "/statuspages/{name}/incidents"
+)
+
+var registerOnce sync.Once
+
+func registerAPIs() {
+	api.RegisterAPIs(&api.Group{
+		Group: apiGroupName,
+		Entries: []*api.Entry{
+			{Path: apiPrefix, Method: "GET", Handler: listIncidentsHandler},
+			{Path: apiPrefix, Method: "POST", Handler: createIncident},
+			{Path: apiPrefix + "/{id}", Method: "GET", Handler: getIncidentHandler},
+			{Path: apiPrefix + "/{id}", Method: "PUT", Handler: updateIncident},
+			{Path: apiPrefix + "/{id}", Method: "DELETE", Handler: deleteIncidentHandler},
+		},
+	})
+}
+
+func writeYAML(w http.ResponseWriter, v interface{}) {
+	buff, err := yaml.Marshal(v)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", v, err))
+	}
+	w.Header().Set("Content-Type", "text/vnd.yaml")
+	w.Write(buff)
+}
+
+// pageName returns the name of the status page in the path,
+// it writes the error if the page doesn't exist.
+func pageName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := chi.URLParam(r, "name")
+	if getPage(name) == nil {
+		api.HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("status page %s not found", name))
+		return "", false
+	}
+	return name, true
+}
+
+func readIncident(w http.ResponseWriter, r *http.Request) (*Incident, bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("read body failed: %v", err))
+		return nil, false
+	}
+
+	incident := &Incident{}
+	err = yaml.Unmarshal(body, incident)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("unmarshal %s to yaml failed: %v", body, err))
+		return nil, false
+	}
+	return incident, true
+}
+
+func listIncidentsHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := pageName(w, r)
+	if !ok {
+		return
+	}
+
+	incidents, err := listIncidents(name)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+	sort.Slice(incidents, func(i, j int) bool { return incidents[i].StartedAt.After(incidents[j].StartedAt) })
+	writeYAML(w, incidents)
+}
+
+func getIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := pageName(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	incident, exists, err := getIncident(name, id)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+	if !exists {
+		api.HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("incident %s not found", id))
+		return
+	}
+	writeYAML(w, incident)
+}
+
+func createIncident(w http.ResponseWriter, r *http.Request) {
+	name, ok := pageName(w, r)
+	if !ok {
+		return
+	}
+	incident, ok := readIncident(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	incident.ID = newIncidentID(now)
+	if incident.StartedAt.IsZero() {
+		incident.StartedAt = now
+	}
+	err := incident.Validate()
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = putIncident(name, incident)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeYAML(w, incident)
+}
+
+func updateIncident(w http.ResponseWriter, r *http.Request) {
+	name, ok := pageName(w, r)
+	if !ok {
+		return
+	}
+	incident, ok := readIncident(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	prev, exists, err := getIncident(name, id)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+	if !exists {
+		api.HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("incident %s not found", id))
+		return
+	}
+
+	incident.ID = id
+	if incident.StartedAt.IsZero() {
+		incident.StartedAt = prev.StartedAt
+	}
+	err = incident.Validate()
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = putIncident(name, incident)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+}
+
+func deleteIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := pageName(w, r)
+	if !ok {
+		return
+	}
+
+	err := deleteIncident(name, chi.URLParam(r, "id"))
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+}
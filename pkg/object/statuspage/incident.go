@@ -0,0 +1,218 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package statuspage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+// storeNamespace is the namespace of the key-value store, '@' is not
+// allowed in object names, so it never conflicts with pipelines.
+const storeNamespace = "@statuspage"
+
+var (
+	pagesMutex sync.RWMutex
+	pages      = make(map[string]*StatusPage)
+
+	storeMutex sync.Mutex
+	store      *cluster.KVStore
+)
+
+type (
+	// Incident is an incident annotated manually, it's shared by all members.
+	Incident struct {
+		ID     string `yaml:"id" json:"id"`
+		Title  string `yaml:"title" json:"title"`
+		Impact string `yaml:"impact" json:"impact"`
+		// APIs are the names of affected APIs, empty means all APIs.
+		APIs       []string   `yaml:"apis" json:"apis"`
+		Message    string     `yaml:"message" json:"message"`
+		StartedAt  time.Time  `yaml:"startedAt" json:"startedAt"`
+		ResolvedAt *time.Time `yaml:"resolvedAt,omitempty" json:"resolvedAt,omitempty"`
+	}
+)
+
+// Validate validates Incident.
+func (i *Incident) Validate() error {
+	if i.Title == "" {
+		return fmt.Errorf("title is required")
+	}
+
+	switch i.Impact {
+	case statusMaintenance, statusDegraded, statusOutage:
+	default:
+		return fmt.Errorf("impact must be one of %s, %s and %s",
+			statusMaintenance, statusDegraded, statusOutage)
+	}
+
+	if i.ResolvedAt != nil && i.ResolvedAt.Before(i.StartedAt) {
+		return fmt.Errorf("resolvedAt is before startedAt")
+	}
+
+	return nil
+}
+
+func (i *Incident) affects(api string) bool {
+	if len(i.APIs) == 0 {
+		return true
+	}
+	for _, name := range i.APIs {
+		if name == api {
+			return true
+		}
+	}
+	return false
+}
+
+// initStore creates the key-value store shared by all members, which
+// holds the incidents. The store lives as long as the process, as
+// incidents outlive objects.
+func initStore(super *supervisor.Supervisor) {
+	registerOnce.Do(registerAPIs)
+
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	if store != nil || super == nil || super.Cluster() == nil {
+		return
+	}
+
+	s, err := cluster.NewKVStore(super.Cluster(), storeNamespace)
+	if err != nil {
+		logger.Errorf("create key-value store of status pages failed: %v", err)
+		return
+	}
+	store = s
+}
+
+func getStore() (*cluster.KVStore, error) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	if store == nil {
+		return nil, fmt.Errorf("key-value store of status pages is unavailable")
+	}
+	return store, nil
+}
+
+func registerPage(name string, sp *StatusPage) {
+	pagesMutex.Lock()
+	defer pagesMutex.Unlock()
+
+	pages[name] = sp
+}
+
+func unregisterPage(name string, sp *StatusPage) {
+	pagesMutex.Lock()
+	defer pagesMutex.Unlock()
+
+	// NOTE: The next generation may have registered itself.
+	if pages[name] == sp {
+		delete(pages, name)
+	}
+}
+
+func getPage(name string) *StatusPage {
+	pagesMutex.RLock()
+	defer pagesMutex.RUnlock()
+
+	return pages[name]
+}
+
+func incidentKey(page, id string) string {
+	return page + "/" + id
+}
+
+func listIncidents(page string) ([]*Incident, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+
+	incidents := []*Incident{}
+	prefix := incidentKey(page, "")
+	for _, key := range s.Keys() {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		value, ok := s.Get(key)
+		if !ok {
+			continue
+		}
+		incident := &Incident{}
+		err := yaml.Unmarshal([]byte(value), incident)
+		if err != nil {
+			logger.Errorf("unmarshal incident %s failed: %v", key, err)
+			continue
+		}
+		incidents = append(incidents, incident)
+	}
+	return incidents, nil
+}
+
+func getIncident(page, id string) (*Incident, bool, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, false, err
+	}
+
+	value, ok := s.Get(incidentKey(page, id))
+	if !ok {
+		return nil, false, nil
+	}
+	incident := &Incident{}
+	err = yaml.Unmarshal([]byte(value), incident)
+	if err != nil {
+		return nil, false, fmt.Errorf("unmarshal incident %s failed: %v", id, err)
+	}
+	return incident, true, nil
+}
+
+func putIncident(page string, incident *Incident) error {
+	s, err := getStore()
+	if err != nil {
+		return err
+	}
+
+	buff, err := yaml.Marshal(incident)
+	if err != nil {
+		return fmt.Errorf("marshal %#v to yaml failed: %v", incident, err)
+	}
+	return s.Put(incidentKey(page, incident.ID), string(buff), 0)
+}
+
+func deleteIncident(page, id string) error {
+	s, err := getStore()
+	if err != nil {
+		return err
+	}
+	return s.Delete(incidentKey(page, id))
+}
+
+func newIncidentID(now time.Time) string {
+	return strconv.FormatInt(now.UnixNano(), 36)
+}
@@ -0,0 +1,249 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package statuspage
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	statusOperational = "operational"
+	statusUnknown     = "unknown"
+	statusMaintenance = "maintenance"
+	statusDegraded    = "degraded"
+	statusOutage      = "outage"
+)
+
+// severities are the severities of statuses, the worst status wins.
+var severities = map[string]int{
+	statusOperational: 0,
+	statusUnknown:     0,
+	statusMaintenance: 1,
+	statusDegraded:    2,
+	statusOutage:      3,
+}
+
+type (
+	// Report is the content of the status page.
+	Report struct {
+		Title     string       `json:"title"`
+		Status    string       `json:"status"`
+		UpdatedAt time.Time    `json:"updatedAt"`
+		APIs      []*APIReport `json:"apis"`
+		Incidents []*Incident  `json:"incidents"`
+	}
+
+	// APIReport is the status of an API.
+	APIReport struct {
+		Name    string          `json:"name"`
+		Status  string          `json:"status"`
+		Windows []*WindowReport `json:"windows"`
+	}
+
+	// WindowReport is the stats of an API in a window,
+	// stats are nil if there's no request.
+	WindowReport struct {
+		Window        string   `json:"window"`
+		Requests      uint64   `json:"requests"`
+		Availability  *float64 `json:"availability"`
+		LatencyMeanMs *float64 `json:"latencyMeanMs"`
+		LatencyP99Ms  *float64 `json:"latencyP99Ms"`
+	}
+)
+
+var pageTemplate = template.Must(template.New("page").Funcs(template.FuncMap{
+	"percent": func(f *float64) string {
+		if f == nil {
+			return "-"
+		}
+		return strconv.FormatFloat(*f, 'f', 3, 64) + "%"
+	},
+	"ms": func(f *float64) string {
+		if f == nil {
+			return "-"
+		}
+		return strconv.FormatFloat(*f, 'f', 1, 64) + " ms"
+	},
+	"time": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02 15:04 MST")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="60">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 960px; margin: 0 auto; padding: 16px; color: #222; }
+table { width: 100%; border-collapse: collapse; margin-bottom: 24px; }
+th, td { text-align: left; padding: 6px 8px; border-bottom: 1px solid #ddd; }
+.status { font-weight: bold; text-transform: capitalize; }
+.operational { color: #2e7d32; }
+.unknown { color: #757575; }
+.maintenance { color: #1565c0; }
+.degraded { color: #ef6c00; }
+.outage { color: #c62828; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="status {{.Status}}">{{.Status}}</p>
+{{- if .Incidents}}
+<h2>Incidents</h2>
+{{- range .Incidents}}
+<div>
+<h3 class="{{.Impact}}">{{.Title}}</h3>
+<p>{{.Message}}</p>
+<p><small>Started at {{time .StartedAt}}{{if .ResolvedAt}}, resolved at {{time .ResolvedAt}}{{end}}</small></p>
+</div>
+{{- end}}
+{{- end}}
+<h2>APIs</h2>
+{{- range .APIs}}
+<h3>{{.Name}} <span class="status {{.Status}}">{{.Status}}</span></h3>
+<table>
+<tr><th>Window</th><th>Requests</th><th>Availability</th><th>Mean Latency</th><th>P99 Latency</th></tr>
+{{- range .Windows}}
+<tr><td>{{.Window}}</td><td>{{.Requests}}</td><td>{{percent .Availability}}</td><td>{{ms .LatencyMeanMs}}</td><td>{{ms .LatencyP99Ms}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+<p><small>Updated at {{time .UpdatedAt}}</small></p>
+</body>
+</html>
+`))
+
+func (sp *StatusPage) handleHTML(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := pageTemplate.Execute(w, sp.report(time.Now(), sp.incidents()))
+	if err != nil {
+		logger.Errorf("%s render page failed: %v", sp.superSpec.Name(), err)
+	}
+}
+
+func (sp *StatusPage) handleJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sp.report(time.Now(), sp.incidents()))
+}
+
+// incidents returns the incidents of the page, failures
+// are logged as the page is still useful without them.
+func (sp *StatusPage) incidents() []*Incident {
+	incidents, err := listIncidents(sp.superSpec.Name())
+	if err != nil {
+		logger.Errorf("%s list incidents failed: %v", sp.superSpec.Name(), err)
+	}
+	return incidents
+}
+
+func (sp *StatusPage) report(now time.Time, incidents []*Incident) *Report {
+	report := &Report{
+		Title:     sp.spec.Title,
+		Status:    statusOperational,
+		UpdatedAt: now,
+		Incidents: []*Incident{},
+	}
+
+	// NOTE: Resolved incidents are shown as long as the largest window.
+	since := now.Add(-sp.maxWindow())
+	for _, incident := range incidents {
+		if incident.ResolvedAt == nil || incident.ResolvedAt.After(since) {
+			report.Incidents = append(report.Incidents, incident)
+		}
+	}
+	sort.Slice(report.Incidents, func(i, j int) bool {
+		return report.Incidents[i].StartedAt.After(report.Incidents[j].StartedAt)
+	})
+
+	sp.mutex.RLock()
+	defer sp.mutex.RUnlock()
+
+	for _, api := range sp.spec.APIs {
+		ar := &APIReport{Name: api.Name}
+		for i, window := range sp.windows {
+			wr := sp.apis[api.Name].window(now, window)
+			wr.Window = sp.spec.Windows[i]
+			ar.Windows = append(ar.Windows, wr)
+		}
+		ar.Status = sp.apiStatus(ar, report.Incidents)
+
+		if severities[ar.Status] > severities[report.Status] {
+			report.Status = ar.Status
+		}
+		report.APIs = append(report.APIs, ar)
+	}
+
+	return report
+}
+
+func (sp *StatusPage) apiStatus(ar *APIReport, incidents []*Incident) string {
+	status := ""
+	for _, incident := range incidents {
+		if incident.ResolvedAt == nil && incident.affects(ar.Name) &&
+			severities[incident.Impact] > severities[status] {
+			status = incident.Impact
+		}
+	}
+	if status != "" {
+		return status
+	}
+
+	if len(ar.Windows) == 0 || ar.Windows[0].Availability == nil {
+		return statusUnknown
+	}
+	if *ar.Windows[0].Availability < sp.spec.Objective {
+		return statusDegraded
+	}
+	return statusOperational
+}
+
+func (s *apiStats) window(now time.Time, window time.Duration) *WindowReport {
+	since := now.Add(-window)
+	total := bucket{}
+	for _, b := range s.buckets {
+		if b.start.Add(bucketPeriod).After(since) && !b.start.After(now) {
+			total.requests += b.requests
+			total.serverErrors += b.serverErrors
+			total.durationMs += b.durationMs
+			total.p99Sum += b.p99Sum
+		}
+	}
+
+	wr := &WindowReport{Requests: total.requests}
+	if total.requests == 0 {
+		return wr
+	}
+
+	requests := float64(total.requests)
+	availability := float64(total.requests-total.serverErrors) / requests * 100
+	mean := float64(total.durationMs) / requests
+	p99 := total.p99Sum / requests
+	wr.Availability, wr.LatencyMeanMs, wr.LatencyP99Ms = &availability, &mean, &p99
+	return wr
+}
@@ -0,0 +1,385 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package statuspage
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/filter/proxy"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/rawconfigtrafficcontroller"
+	"github.com/megaease/easegress/pkg/object/statussynccontroller"
+	"github.com/megaease/easegress/pkg/object/trafficcontroller"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/httpstat"
+)
+
+const (
+	// Kind is the kind of StatusPage.
+	Kind = "StatusPage"
+
+	// bucketPeriod is the granularity of the stats of APIs.
+	bucketPeriod = time.Minute
+)
+
+func init() {
+	supervisor.Register(&StatusPage{})
+}
+
+type (
+	// StatusPage renders a public status page of APIs, the availability
+	// and latency are computed from the stats of their Proxy filters.
+	StatusPage struct {
+		super     *supervisor.Supervisor
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		ssc     *statussynccontroller.StatusSyncController
+		windows []time.Duration
+		server  *http.Server
+		// serveErr is the error of listening.
+		serveErr string
+
+		mutex  sync.RWMutex
+		apis   map[string]*apiStats
+		synced int64
+
+		done chan struct{}
+	}
+
+	// Spec describes the StatusPage.
+	Spec struct {
+		Title string `yaml:"title" jsonschema:"omitempty"`
+		// Port is the port serving the public status page.
+		Port uint16 `yaml:"port" jsonschema:"required"`
+		// Windows are the durations the stats are computed over.
+		Windows []string `yaml:"windows" jsonschema:"omitempty"`
+		// Objective is the availability in percentage, APIs below it
+		// in the first window are degraded.
+		Objective float64 `yaml:"objective" jsonschema:"omitempty"`
+		APIs      []*API  `yaml:"apis" jsonschema:"required,minItems=1"`
+	}
+
+	// API is an API on the status page.
+	API struct {
+		Name     string `yaml:"name" jsonschema:"required"`
+		Pipeline string `yaml:"pipeline" jsonschema:"required"`
+		// Filter is the name of the Proxy filter,
+		// all Proxy filters of the pipeline if it's empty.
+		Filter string `yaml:"filter" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of StatusPage.
+	Status struct {
+		Error string `yaml:"error,omitempty"`
+	}
+
+	// apiStats is the stats of an API in buckets of minutes.
+	apiStats struct {
+		// last is the last cumulative stats, nil means never synced.
+		last    *counter
+		buckets []*bucket
+	}
+
+	counter struct {
+		requests     uint64
+		serverErrors uint64
+		durationMs   uint64
+	}
+
+	bucket struct {
+		start time.Time
+		counter
+		// p99Sum is the sum of sampled p99 latencies weighted
+		// by the requests, it's an approximation.
+		p99Sum float64
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	for _, w := range spec.Windows {
+		d, err := time.ParseDuration(w)
+		if err != nil {
+			return fmt.Errorf("invalid window %s: %v", w, err)
+		}
+		if d < bucketPeriod {
+			return fmt.Errorf("window %s is shorter than %s", w, bucketPeriod)
+		}
+	}
+
+	if spec.Objective < 0 || spec.Objective > 100 {
+		return fmt.Errorf("objective must be in [0, 100]")
+	}
+
+	names := map[string]bool{}
+	for _, api := range spec.APIs {
+		if names[api.Name] {
+			return fmt.Errorf("duplicated api %s", api.Name)
+		}
+		names[api.Name] = true
+	}
+
+	return nil
+}
+
+// Category returns the category of StatusPage.
+func (sp *StatusPage) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+
+// Kind returns the kind of StatusPage.
+func (sp *StatusPage) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of StatusPage.
+func (sp *StatusPage) DefaultSpec() interface{} {
+	return &Spec{
+		Title:     "Status",
+		Windows:   []string{"1h", "24h", "168h"},
+		Objective: 99.9,
+	}
+}
+
+// Init initializes StatusPage.
+func (sp *StatusPage) Init(superSpec *supervisor.Spec) {
+	sp.superSpec, sp.spec, sp.super = superSpec, superSpec.ObjectSpec().(*Spec), superSpec.Super()
+	sp.reload(nil)
+}
+
+// Inherit inherits previous generation of StatusPage.
+func (sp *StatusPage) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	prev := previousGeneration.(*StatusPage)
+	prev.Close()
+	sp.superSpec, sp.spec, sp.super = superSpec, superSpec.ObjectSpec().(*Spec), superSpec.Super()
+	sp.reload(prev)
+}
+
+func (sp *StatusPage) reload(prev *StatusPage) {
+	initStore(sp.super)
+
+	sp.windows = nil
+	for _, w := range sp.spec.Windows {
+		d, _ := time.ParseDuration(w)
+		sp.windows = append(sp.windows, d)
+	}
+
+	// NOTE: Keep the stats of APIs which are still on the page.
+	sp.apis = make(map[string]*apiStats)
+	for _, api := range sp.spec.APIs {
+		if prev != nil && prev.apis[api.Name] != nil {
+			sp.apis[api.Name] = prev.apis[api.Name]
+		} else {
+			sp.apis[api.Name] = &apiStats{}
+		}
+	}
+	if prev != nil {
+		sp.synced = prev.synced
+	}
+
+	registerPage(sp.superSpec.Name(), sp)
+
+	entity, exists := sp.super.GetSystemController(statussynccontroller.Kind)
+	if !exists {
+		logger.Errorf("BUG: status sync controller not found")
+	} else {
+		sp.ssc = entity.Instance().(*statussynccontroller.StatusSyncController)
+	}
+
+	sp.done = make(chan struct{})
+	sp.serve()
+
+	go sp.run()
+}
+
+func (sp *StatusPage) serve() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", sp.handleHTML)
+	mux.HandleFunc("/status.json", sp.handleJSON)
+	sp.server = &http.Server{Handler: mux}
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", sp.spec.Port))
+	if err != nil {
+		logger.Errorf("%s listen on port %d failed: %v", sp.superSpec.Name(), sp.spec.Port, err)
+		sp.serveErr = err.Error()
+		return
+	}
+
+	go func() {
+		err := sp.server.Serve(l)
+		if err != http.ErrServerClosed {
+			logger.Errorf("%s serve failed: %v", sp.superSpec.Name(), err)
+		}
+	}()
+}
+
+func (sp *StatusPage) run() {
+	for {
+		select {
+		case <-sp.done:
+			return
+		case <-time.After(statussynccontroller.SyncStatusPaceInUnixSeconds * time.Second):
+			if sp.ssc == nil {
+				continue
+			}
+			for _, record := range sp.ssc.GetStatusesRecords() {
+				sp.sync(record)
+			}
+		}
+	}
+}
+
+// sync adds the stats of APIs in the record to the buckets.
+func (sp *StatusPage) sync(record *statussynccontroller.StatusesRecord) {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	if record.UnixTimestamp <= sp.synced {
+		return
+	}
+	sp.synced = record.UnixTimestamp
+
+	now := time.Unix(record.UnixTimestamp, 0)
+	for _, api := range sp.spec.APIs {
+		stats := sp.apis[api.Name]
+		current, p99, ok := apiCounter(record, api)
+		if !ok {
+			stats.last = nil
+			continue
+		}
+		stats.add(now, current, p99)
+		stats.purge(now.Add(-sp.maxWindow()))
+	}
+}
+
+func (sp *StatusPage) maxWindow() time.Duration {
+	max := time.Duration(0)
+	for _, w := range sp.windows {
+		if w > max {
+			max = w
+		}
+	}
+	return max
+}
+
+// apiCounter returns the cumulative counter of the Proxy filters of the
+// API in the default namespace, and the p99 latency of the most busy one.
+func apiCounter(record *statussynccontroller.StatusesRecord, api *API) (*counter, float64, bool) {
+	for _, status := range record.Statuses {
+		s, ok := status.ObjectStatus.(*trafficcontroller.StatusInSameNamespace)
+		if !ok || s.Namespace != rawconfigtrafficcontroller.DefaultNamespace {
+			continue
+		}
+		pipeline := s.HTTPPipelines[api.Pipeline]
+		if pipeline == nil || pipeline.Status == nil {
+			return nil, 0, false
+		}
+		return pipelineCounter(pipeline.Status, api.Filter)
+	}
+	return nil, 0, false
+}
+
+func pipelineCounter(status *httppipeline.Status, filter string) (*counter, float64, bool) {
+	c, p99, found := &counter{}, 0.0, false
+	busiest := uint64(0)
+	for name, filterStatus := range status.Filters {
+		if filter != "" && name != filter {
+			continue
+		}
+		s, ok := filterStatus.(*proxy.Status)
+		if !ok || s.MainPool == nil || s.MainPool.Stat == nil {
+			continue
+		}
+
+		found = true
+		c.addStat(s.MainPool.Stat)
+		if s.MainPool.Stat.Count >= busiest {
+			busiest, p99 = s.MainPool.Stat.Count, s.MainPool.Stat.P99
+		}
+	}
+	return c, p99, found
+}
+
+func (c *counter) addStat(s *httpstat.Status) {
+	c.requests += s.Count
+	c.durationMs += s.Mean * s.Count
+	for code, count := range s.Codes {
+		if code >= 500 {
+			c.serverErrors += count
+		}
+	}
+}
+
+func (s *apiStats) add(now time.Time, current *counter, p99 float64) {
+	last := s.last
+	s.last = current
+	if last == nil {
+		return
+	}
+
+	delta := *current
+	// NOTE: The counter restarts if the filter is reloaded.
+	if current.requests >= last.requests && current.serverErrors >= last.serverErrors &&
+		current.durationMs >= last.durationMs {
+		delta.requests -= last.requests
+		delta.serverErrors -= last.serverErrors
+		delta.durationMs -= last.durationMs
+	}
+	if delta.requests == 0 {
+		return
+	}
+
+	start := now.Truncate(bucketPeriod)
+	var b *bucket
+	if n := len(s.buckets); n > 0 && s.buckets[n-1].start.Equal(start) {
+		b = s.buckets[n-1]
+	} else {
+		b = &bucket{start: start}
+		s.buckets = append(s.buckets, b)
+	}
+
+	b.requests += delta.requests
+	b.serverErrors += delta.serverErrors
+	b.durationMs += delta.durationMs
+	b.p99Sum += p99 * float64(delta.requests)
+}
+
+func (s *apiStats) purge(before time.Time) {
+	i := 0
+	for i < len(s.buckets) && s.buckets[i].start.Add(bucketPeriod).Before(before) {
+		i++
+	}
+	s.buckets = s.buckets[i:]
+}
+
+// Status returns the status of StatusPage.
+func (sp *StatusPage) Status() *supervisor.Status {
+	return &supervisor.Status{ObjectStatus: &Status{Error: sp.serveErr}}
+}
+
+// Close closes StatusPage.
+func (sp *StatusPage) Close() {
+	close(sp.done)
+	sp.server.Close()
+	unregisterPage(sp.superSpec.Name(), sp)
+}
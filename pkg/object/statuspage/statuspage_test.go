@@ -0,0 +1,447 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package statuspage
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/filter/proxy"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/rawconfigtrafficcontroller"
+	"github.com/megaease/easegress/pkg/object/statussynccontroller"
+	"github.com/megaease/easegress/pkg/object/trafficcontroller"
+	"github.com/megaease/easegress/pkg/option"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/httpstat"
+)
+
+// testSuper is the supervisor upon a standalone cluster, the key-value
+// store of incidents is shared by the process, so is the supervisor.
+var testSuper *supervisor.Supervisor
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+
+	opt := option.New()
+	opt.Name = "standalone-member"
+	opt.Standalone = true
+	cls, err := cluster.New(opt)
+	if err != nil {
+		panic(err)
+	}
+	testSuper = supervisor.MustNew(opt, cls)
+	<-testSuper.FirstHandleDone()
+
+	code := m.Run()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	testSuper.Close(wg)
+	cls.Close(wg)
+	wg.Wait()
+	os.Exit(code)
+}
+
+func newStatusPage(t *testing.T, yamlSpec string) *StatusPage {
+	spec, err := testSuper.NewSpec(yamlSpec)
+	if err != nil {
+		t.Fatalf("new spec failed: %v", err)
+	}
+	sp := &StatusPage{}
+	sp.Init(spec)
+	t.Cleanup(sp.Close)
+	return sp
+}
+
+// newRecord creates a record of the pipelines in the default namespace,
+// which are the stats of the Proxy filters by pipeline and filter names.
+func newRecord(at time.Time, pipelines map[string]map[string]*httpstat.Status) *statussynccontroller.StatusesRecord {
+	s := &trafficcontroller.StatusInSameNamespace{
+		Namespace:     rawconfigtrafficcontroller.DefaultNamespace,
+		HTTPPipelines: map[string]*trafficcontroller.HTTPPipelineStatus{},
+	}
+	for pipeline, filters := range pipelines {
+		status := &httppipeline.Status{Filters: map[string]interface{}{}}
+		for filter, stat := range filters {
+			status.Filters[filter] = &proxy.Status{MainPool: &proxy.PoolStatus{Stat: stat}}
+		}
+		s.HTTPPipelines[pipeline] = &trafficcontroller.HTTPPipelineStatus{Status: status}
+	}
+
+	// The pipelines of other namespaces are ignored.
+	other := &trafficcontroller.StatusInSameNamespace{
+		Namespace: "other",
+		HTTPPipelines: map[string]*trafficcontroller.HTTPPipelineStatus{
+			"orders": {Status: &httppipeline.Status{Filters: map[string]interface{}{
+				"proxy": &proxy.Status{MainPool: &proxy.PoolStatus{Stat: newStat(1e6, 1e6, 1, 1)}},
+			}}},
+		},
+	}
+
+	return &statussynccontroller.StatusesRecord{
+		Statuses: map[string]*supervisor.Status{
+			"default": {ObjectStatus: s},
+			"other":   {ObjectStatus: other},
+		},
+		UnixTimestamp: at.Unix(),
+	}
+}
+
+func newStat(count, serverErrors, mean uint64, p99 float64) *httpstat.Status {
+	return &httpstat.Status{
+		Count: count,
+		Mean:  mean,
+		P99:   p99,
+		Codes: map[int]uint64{200: count - serverErrors, 503: serverErrors},
+	}
+}
+
+func floatEqual(f *float64, expected float64) bool {
+	return f != nil && math.Abs(*f-expected) < 0.01
+}
+
+func TestValidate(t *testing.T) {
+	for _, spec := range []Spec{
+		{Windows: []string{"1x"}},
+		{Windows: []string{"30s"}},
+		{Objective: 101},
+		{APIs: []*API{{Name: "orders"}, {Name: "orders"}}},
+	} {
+		if spec.Validate() == nil {
+			t.Errorf("spec %+v should be invalid", spec)
+		}
+	}
+	spec := Spec{Windows: []string{"1h"}, Objective: 99, APIs: []*API{{Name: "orders"}}}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	now := time.Now()
+	before := now.Add(-time.Hour)
+	for _, incident := range []*Incident{
+		{Impact: statusOutage},
+		{Title: "down", Impact: statusOperational},
+		{Title: "down", Impact: statusOutage, StartedAt: now, ResolvedAt: &before},
+	} {
+		if incident.Validate() == nil {
+			t.Errorf("incident %+v should be invalid", incident)
+		}
+	}
+	incident := &Incident{Title: "down", Impact: statusOutage, StartedAt: before, ResolvedAt: &now}
+	if err := incident.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAggregation(t *testing.T) {
+	sp := newStatusPage(t, `
+kind: StatusPage
+name: aggregation
+port: 0
+windows: ["1h", "24h"]
+objective: 99
+apis:
+- name: orders
+  pipeline: orders
+- name: payments
+  pipeline: payments
+  filter: proxy
+- name: inventory
+  pipeline: inventory
+`)
+
+	// NOTE: The records are in the future, so the ones synced
+	// from the status sync controller are always ignored.
+	base := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+	payments := map[string]*httpstat.Status{
+		"proxy":  newStat(10, 0, 5, 20),
+		"mirror": newStat(1000, 1000, 100, 100),
+	}
+	sp.sync(newRecord(base, map[string]map[string]*httpstat.Status{
+		"orders":   {"proxy": newStat(100, 0, 10, 10)},
+		"payments": payments,
+	}))
+	if len(sp.apis["orders"].buckets) != 0 {
+		t.Fatalf("the first record should only be the baseline")
+	}
+
+	payments["proxy"] = newStat(110, 0, 5, 20)
+	sp.sync(newRecord(base.Add(time.Minute), map[string]map[string]*httpstat.Status{
+		"orders":   {"proxy": newStat(300, 10, 10, 40)},
+		"payments": payments,
+	}))
+	// A record not newer than the synced one is ignored.
+	sp.sync(newRecord(base.Add(time.Minute), map[string]map[string]*httpstat.Status{
+		"orders": {"proxy": newStat(1000, 1000, 10, 40)},
+	}))
+	sp.sync(newRecord(base.Add(2*time.Hour), map[string]map[string]*httpstat.Status{
+		"orders":   {"proxy": newStat(400, 30, 10, 60)},
+		"payments": payments,
+	}))
+
+	report := sp.report(base.Add(2*time.Hour+30*time.Second), nil)
+	if report.Status != statusDegraded {
+		t.Errorf("expect status %s, got %s", statusDegraded, report.Status)
+	}
+
+	orders := report.APIs[0]
+	if orders.Status != statusDegraded {
+		t.Errorf("orders should be degraded, got %s", orders.Status)
+	}
+	hour, day := orders.Windows[0], orders.Windows[1]
+	if hour.Window != "1h" || hour.Requests != 100 || !floatEqual(hour.Availability, 80) ||
+		!floatEqual(hour.LatencyMeanMs, 10) || !floatEqual(hour.LatencyP99Ms, 60) {
+		t.Errorf("unexpected stats of 1h: %+v", hour)
+	}
+	if day.Window != "24h" || day.Requests != 300 || !floatEqual(day.Availability, 90) ||
+		!floatEqual(day.LatencyMeanMs, 10) || !floatEqual(day.LatencyP99Ms, 140.0/3) {
+		t.Errorf("unexpected stats of 24h: %+v", day)
+	}
+
+	// Only the proxy filter is counted, and there's no request in the last hour.
+	pay := report.APIs[1]
+	if pay.Status != statusUnknown || pay.Windows[0].Availability != nil {
+		t.Errorf("payments should be unknown without requests, got %s", pay.Status)
+	}
+	if w := pay.Windows[1]; w.Requests != 100 || !floatEqual(w.Availability, 100) ||
+		!floatEqual(w.LatencyMeanMs, 5) || !floatEqual(w.LatencyP99Ms, 20) {
+		t.Errorf("unexpected stats of payments: %+v", w)
+	}
+
+	inventory := report.APIs[2]
+	if inventory.Status != statusUnknown || inventory.Windows[1].Requests != 0 {
+		t.Errorf("inventory without pipeline should be unknown, got %+v", inventory)
+	}
+
+	// Buckets older than the largest window are purged.
+	sp.sync(newRecord(base.Add(25*time.Hour), map[string]map[string]*httpstat.Status{
+		"orders": {"proxy": newStat(500, 30, 10, 60)},
+	}))
+	if n := len(sp.apis["orders"].buckets); n != 2 {
+		t.Errorf("expect 2 buckets, got %d", n)
+	}
+	if sp.apis["payments"].last != nil {
+		t.Errorf("the stats of the missing pipeline should be reset")
+	}
+}
+
+func TestCounterRestart(t *testing.T) {
+	now := time.Now()
+	s := &apiStats{}
+	s.add(now, &counter{requests: 100, durationMs: 1000}, 10)
+	s.add(now.Add(time.Minute), &counter{requests: 150, durationMs: 1500}, 10)
+	// The filter is reloaded, so its counter restarts.
+	s.add(now.Add(2*time.Minute), &counter{requests: 30, serverErrors: 3, durationMs: 600}, 10)
+
+	w := s.window(now.Add(2*time.Minute), time.Hour)
+	if w.Requests != 80 || !floatEqual(w.Availability, 100*77.0/80) || !floatEqual(w.LatencyMeanMs, 1100.0/80) {
+		t.Errorf("unexpected stats: %+v", w)
+	}
+}
+
+func TestIncidents(t *testing.T) {
+	sp := newStatusPage(t, `
+kind: StatusPage
+name: incidents
+port: 0
+windows: ["1h", "24h"]
+apis:
+- name: orders
+  pipeline: orders
+- name: payments
+  pipeline: payments
+`)
+
+	now := time.Now()
+	longAgo, recently := now.Add(-48*time.Hour), now.Add(-time.Hour)
+	incidents := []*Incident{
+		{ID: "old", Title: "old", Impact: statusOutage, StartedAt: longAgo, ResolvedAt: &longAgo},
+		{ID: "resolved", Title: "resolved", Impact: statusOutage, StartedAt: now.Add(-2 * time.Hour), ResolvedAt: &recently},
+		{ID: "maintenance", Title: "maintenance", Impact: statusMaintenance, StartedAt: now.Add(-time.Minute)},
+		{ID: "outage", Title: "outage", Impact: statusOutage, APIs: []string{"orders"}, StartedAt: now.Add(-2 * time.Minute)},
+	}
+
+	report := sp.report(now, incidents)
+	ids := []string{}
+	for _, incident := range report.Incidents {
+		ids = append(ids, incident.ID)
+	}
+	if strings.Join(ids, ",") != "maintenance,outage,resolved" {
+		t.Errorf("unexpected incidents %v", ids)
+	}
+	if report.APIs[0].Status != statusOutage || report.APIs[1].Status != statusMaintenance {
+		t.Errorf("unexpected statuses %s and %s", report.APIs[0].Status, report.APIs[1].Status)
+	}
+	if report.Status != statusOutage {
+		t.Errorf("the worst status should win, got %s", report.Status)
+	}
+
+	report = sp.report(now, incidents[:2])
+	if report.Status != statusOperational || report.APIs[0].Status != statusUnknown {
+		t.Errorf("resolved incidents should not affect the status, got %s", report.Status)
+	}
+}
+
+func TestRender(t *testing.T) {
+	sp := newStatusPage(t, `
+kind: StatusPage
+name: render
+title: Acme <Status>
+port: 0
+windows: ["1h"]
+objective: 99
+apis:
+- name: orders
+  pipeline: orders
+- name: payments
+  pipeline: payments
+`)
+
+	sp.mutex.Lock()
+	sp.apis["orders"].buckets = []*bucket{{
+		start:   time.Now().Truncate(bucketPeriod),
+		counter: counter{requests: 1000, serverErrors: 1, durationMs: 12345},
+		p99Sum:  50 * 1000,
+	}}
+	sp.mutex.Unlock()
+
+	err := putIncident("render", &Incident{
+		ID: "1", Title: "Payments <down>", Impact: statusOutage,
+		APIs: []string{"payments"}, StartedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("put incident failed: %v", err)
+	}
+	defer deleteIncident("render", "1")
+
+	w := httptest.NewRecorder()
+	sp.handleHTML(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := w.Body.String()
+	for _, s := range []string{
+		"<title>Acme &lt;Status&gt;</title>",
+		`<p class="status outage">outage</p>`,
+		"Payments &lt;down&gt;",
+		`orders <span class="status operational">operational</span>`,
+		`payments <span class="status outage">outage</span>`,
+		"<td>1h</td><td>1000</td><td>99.900%</td><td>12.3 ms</td><td>50.0 ms</td>",
+		"<td>1h</td><td>0</td><td>-</td><td>-</td><td>-</td>",
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("page should contain %q:\n%s", s, body)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	sp.handleHTML(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expect status code 404, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	sp.handleJSON(w, httptest.NewRequest(http.MethodGet, "/status.json", nil))
+	report := &Report{}
+	if err = json.Unmarshal(w.Body.Bytes(), report); err != nil {
+		t.Fatalf("unmarshal report failed: %v", err)
+	}
+	if report.Title != "Acme <Status>" || report.Status != statusOutage || len(report.APIs) != 2 ||
+		len(report.Incidents) != 1 || !floatEqual(report.APIs[0].Windows[0].Availability, 99.9) {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
+
+func TestIncidentAPIs(t *testing.T) {
+	newStatusPage(t, `
+kind: StatusPage
+name: api
+port: 0
+apis:
+- name: orders
+  pipeline: orders
+`)
+
+	router := chi.NewRouter()
+	router.Get(apiPrefix, listIncidentsHandler)
+	router.Post(apiPrefix, createIncident)
+	router.Get(apiPrefix+"/{id}", getIncidentHandler)
+	router.Put(apiPrefix+"/{id}", updateIncident)
+	router.Delete(apiPrefix+"/{id}", deleteIncidentHandler)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	if w := do(http.MethodGet, "/statuspages/missing/incidents", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expect status code 404 for missing page, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/statuspages/api/incidents", "title: down\nimpact: fine\n"); w.Code != http.StatusBadRequest {
+		t.Errorf("expect status code 400 for invalid impact, got %d", w.Code)
+	}
+
+	w := do(http.MethodPost, "/statuspages/api/incidents", "title: down\nimpact: outage\n")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expect status code 201, got %d", w.Code)
+	}
+	created := &Incident{}
+	yaml.Unmarshal(w.Body.Bytes(), created)
+	if created.ID == "" || created.StartedAt.IsZero() {
+		t.Fatalf("id and startedAt should be set: %+v", created)
+	}
+	path := "/statuspages/api/incidents/" + created.ID
+
+	resolvedAt := created.StartedAt.Add(time.Minute).Format(time.RFC3339Nano)
+	if w = do(http.MethodPut, path, "title: down\nimpact: outage\nresolvedAt: "+resolvedAt+"\n"); w.Code != http.StatusOK {
+		t.Errorf("expect status code 200, got %d", w.Code)
+	}
+	if w = do(http.MethodPut, "/statuspages/api/incidents/missing", "title: down\nimpact: outage\n"); w.Code != http.StatusNotFound {
+		t.Errorf("expect status code 404 for missing incident, got %d", w.Code)
+	}
+
+	w = do(http.MethodGet, path, "")
+	got := &Incident{}
+	yaml.Unmarshal(w.Body.Bytes(), got)
+	if got.ResolvedAt == nil || !got.StartedAt.Equal(created.StartedAt) {
+		t.Errorf("update should resolve the incident and keep startedAt: %+v", got)
+	}
+
+	w = do(http.MethodGet, "/statuspages/api/incidents", "")
+	list := []*Incident{}
+	yaml.Unmarshal(w.Body.Bytes(), &list)
+	if len(list) != 1 || list[0].ID != created.ID {
+		t.Errorf("unexpected incidents %+v", list)
+	}
+
+	do(http.MethodDelete, path, "")
+	if w = do(http.MethodGet, path, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expect status code 404 after deletion, got %d", w.Code)
+	}
+}
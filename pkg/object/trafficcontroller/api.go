@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package trafficcontroller
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/api"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	apiGroupName = "trafficcontroller_admin"
+	apiPrefix    = "/ownership"
+)
+
+var registerOnce sync.Once
+
+// registerAPIs registers the APIs once, the handlers look up the latest
+// generation of TrafficController from the supervisor.
+func registerAPIs(super *supervisor.Supervisor) {
+	api.RegisterAPIs(&api.Group{
+		Group: apiGroupName,
+		Entries: []*api.Entry{
+			{
+				Path:   apiPrefix,
+				Method: "GET",
+				Handler: func(w http.ResponseWriter, r *http.Request) {
+					listOwnership(super, w, r)
+				},
+			},
+		},
+	})
+}
+
+// listOwnership lists the objects generated by controllers grouped by
+// their owners, it only covers the objects running in the current member.
+func listOwnership(super *supervisor.Supervisor, w http.ResponseWriter, r *http.Request) {
+	entity, exists := super.GetSystemController(Kind)
+	if !exists {
+		api.HandleAPIError(w, r, http.StatusServiceUnavailable, fmt.Errorf("traffic controller not found"))
+		return
+	}
+
+	tc, ok := entity.Instance().(*TrafficController)
+	if !ok {
+		panic(fmt.Errorf("BUG: want *TrafficController, got %T", entity.Instance()))
+	}
+
+	trees := tc.Ownership()
+	buff, err := yaml.Marshal(trees)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", trees, err))
+	}
+	w.Header().Set("Content-Type", "text/vnd.yaml")
+	w.Write(buff)
+}
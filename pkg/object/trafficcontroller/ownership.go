@@ -0,0 +1,182 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package trafficcontroller
+
+import (
+	"sort"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	gcInterval = time.Minute
+	// gcGracePeriod is how long a namespace must be orphaned before it's
+	// collected, as the owner generates objects in its Init, before the
+	// supervisor registers it.
+	gcGracePeriod = time.Minute
+)
+
+type (
+	// Owner is the owner of the objects in a namespace, i.e. the business
+	// controller generating them.
+	Owner struct {
+		Kind string `yaml:"kind"`
+		Name string `yaml:"name"`
+	}
+
+	// OwnershipTree is an owner and the objects it generates, the owner is
+	// nil for the namespaces without owner references.
+	OwnershipTree struct {
+		Owner      *Owner            `yaml:"owner"`
+		Namespaces []*OwnedNamespace `yaml:"namespaces"`
+	}
+
+	// OwnedNamespace is a namespace and the objects in it.
+	OwnedNamespace struct {
+		Namespace string `yaml:"namespace"`
+		// OrphanedSince is the time the owner was found missing, the
+		// namespace is garbage collected after the grace period.
+		OrphanedSince *time.Time `yaml:"orphanedSince,omitempty"`
+		HTTPServers   []string   `yaml:"httpServers"`
+		HTTPPipelines []string   `yaml:"httpPipelines"`
+	}
+
+	ownerReference struct {
+		owner         *Owner
+		orphanedSince time.Time
+	}
+)
+
+// SetOwner records the owner of the objects in the namespace, so they're
+// garbage collected once the owner is deleted without cleaning them. The
+// reference is removed by Clean.
+func (tc *TrafficController) SetOwner(namespace string, owner *Owner) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	tc.owners[namespace] = &ownerReference{owner: owner}
+}
+
+// Ownership returns the ownership trees sorted by owner, the unowned
+// namespaces come first.
+func (tc *TrafficController) Ownership() []*OwnershipTree {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	trees := map[Owner]*OwnershipTree{}
+	for namespace, space := range tc.namespaces {
+		var owner *Owner
+		ns := &OwnedNamespace{
+			Namespace:     namespace,
+			HTTPServers:   []string{},
+			HTTPPipelines: []string{},
+		}
+		if ref := tc.owners[namespace]; ref != nil {
+			owner = ref.owner
+			if !ref.orphanedSince.IsZero() {
+				orphanedSince := ref.orphanedSince
+				ns.OrphanedSince = &orphanedSince
+			}
+		}
+
+		space.httpservers.Range(func(k, v interface{}) bool {
+			ns.HTTPServers = append(ns.HTTPServers, k.(string))
+			return true
+		})
+		space.httppipelines.Range(func(k, v interface{}) bool {
+			ns.HTTPPipelines = append(ns.HTTPPipelines, k.(string))
+			return true
+		})
+		sort.Strings(ns.HTTPServers)
+		sort.Strings(ns.HTTPPipelines)
+
+		key := Owner{}
+		if owner != nil {
+			key = *owner
+		}
+		tree := trees[key]
+		if tree == nil {
+			tree = &OwnershipTree{Owner: owner}
+			trees[key] = tree
+		}
+		tree.Namespaces = append(tree.Namespaces, ns)
+	}
+
+	result := make([]*OwnershipTree, 0, len(trees))
+	for _, tree := range trees {
+		sort.Slice(tree.Namespaces, func(i, j int) bool {
+			return tree.Namespaces[i].Namespace < tree.Namespaces[j].Namespace
+		})
+		result = append(result, tree)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		oi, oj := result[i].Owner, result[j].Owner
+		if oi == nil || oj == nil {
+			return oi == nil && oj != nil
+		}
+		if oi.Kind != oj.Kind {
+			return oi.Kind < oj.Kind
+		}
+		return oi.Name < oj.Name
+	})
+	return result
+}
+
+func (tc *TrafficController) runGC() {
+	for {
+		select {
+		case <-tc.done:
+			return
+		case <-time.After(gcInterval):
+			tc.collectGarbage(time.Now())
+		}
+	}
+}
+
+// collectGarbage cleans the namespaces whose owners have been deleted or
+// replaced by objects of other kinds for the grace period.
+func (tc *TrafficController) collectGarbage(now time.Time) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	for namespace, ref := range tc.owners {
+		if tc.ownerExists(ref.owner) {
+			ref.orphanedSince = time.Time{}
+			continue
+		}
+		if ref.orphanedSince.IsZero() {
+			ref.orphanedSince = now
+			logger.Warnf("namespace %s is orphaned by %s %s", namespace, ref.owner.Kind, ref.owner.Name)
+			continue
+		}
+		if now.Sub(ref.orphanedSince) < gcGracePeriod {
+			continue
+		}
+
+		logger.Warnf("garbage collect namespace %s orphaned by %s %s", namespace, ref.owner.Kind, ref.owner.Name)
+		if _, exists := tc.namespaces[namespace]; exists {
+			tc._clean(namespace)
+		}
+		delete(tc.owners, namespace)
+	}
+}
+
+func (tc *TrafficController) ownerExists(owner *Owner) bool {
+	entity, exists := tc.super.GetBusinessController(owner.Name)
+	return exists && entity.Spec().Kind() == owner.Kind
+}
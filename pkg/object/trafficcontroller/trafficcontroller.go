@@ -45,8 +45,15 @@ type (
 		superSpec *supervisor.Spec
 		spec      *Spec
 
-		mutex      sync.Mutex
+		// mutex is shared by all generations, as the namespaces and owners
+		// are inherited, and the GC of the previous generation may still
+		// be running.
+		mutex      *sync.Mutex
 		namespaces map[string]*Namespace
+		// owners is keyed by namespace.
+		owners map[string]*ownerReference
+
+		done chan struct{}
 	}
 
 	// Namespace is the namespace
@@ -134,7 +141,11 @@ func (tc *TrafficController) DefaultSpec() interface{} {
 func (tc *TrafficController) Init(superSpec *supervisor.Spec) {
 	tc.superSpec, tc.spec, tc.super = superSpec, superSpec.ObjectSpec().(*Spec), superSpec.Super()
 
+	tc.mutex = &sync.Mutex{}
 	tc.namespaces = make(map[string]*Namespace)
+	tc.owners = make(map[string]*ownerReference)
+
+	registerOnce.Do(func() { registerAPIs(tc.super) })
 
 	tc.reload(nil)
 }
@@ -148,7 +159,12 @@ func (tc *TrafficController) Inherit(superSpec *supervisor.Spec, previousGenerat
 func (tc *TrafficController) reload(previousGeneration *TrafficController) {
 	if previousGeneration != nil {
 		tc.mutex, tc.namespaces = previousGeneration.mutex, previousGeneration.namespaces
+		tc.owners = previousGeneration.owners
+		close(previousGeneration.done)
 	}
+
+	tc.done = make(chan struct{})
+	go tc.runGC()
 }
 
 // CreateHTTPServerForSpec creates HTTP server with a spec
@@ -575,16 +591,28 @@ func (tc *TrafficController) ListHTTPPipelines(namespace string) []*supervisor.O
 	return entities
 }
 
-// Clean all http servers and http pipelines of one namespace.
+// Clean all http servers and http pipelines of one namespace,
+// and the owner reference of it.
 func (tc *TrafficController) Clean(namespace string) error {
 	tc.mutex.Lock()
 	defer tc.mutex.Unlock()
 
-	space, exist := tc.namespaces[namespace]
-	if !exist {
+	delete(tc.owners, namespace)
+
+	if _, exist := tc.namespaces[namespace]; !exist {
 		return fmt.Errorf("namespace %s not found", namespace)
 	}
 
+	tc._clean(namespace)
+
+	return nil
+}
+
+// _clean closes all http servers and http pipelines of one namespace.
+// It's caller's duty to keep concurrent safety.
+func (tc *TrafficController) _clean(namespace string) {
+	space := tc.namespaces[namespace]
+
 	space.httpservers.Range(func(k, v interface{}) bool {
 		v.(*supervisor.ObjectEntity).CloseWithRecovery()
 		logger.Infof("delete http server %s/%s", namespace, k)
@@ -600,8 +628,6 @@ func (tc *TrafficController) Clean(namespace string) error {
 	})
 
 	tc._cleanSpace(namespace)
-
-	return nil
 }
 
 // _cleanSpace must be called after deleting HTTPServer or HTTPPipeline.
@@ -650,6 +676,8 @@ func (tc *TrafficController) Status() *supervisor.Status {
 
 // Close closes TrafficController.
 func (tc *TrafficController) Close() {
+	close(tc.done)
+
 	tc.mutex.Lock()
 	defer tc.mutex.Unlock()
 
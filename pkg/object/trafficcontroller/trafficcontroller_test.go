@@ -0,0 +1,224 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package trafficcontroller
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/option"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	supervisor.Register(&testOwner{})
+	code := m.Run()
+	os.Exit(code)
+}
+
+// testOwner is a business controller owning the generated objects.
+type testOwner struct{}
+
+func (o *testOwner) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+func (o *testOwner) Kind() string                                { return "TestOwner" }
+func (o *testOwner) DefaultSpec() interface{}                    { return &struct{}{} }
+func (o *testOwner) Init(*supervisor.Spec)                       {}
+func (o *testOwner) Inherit(*supervisor.Spec, supervisor.Object) {}
+func (o *testOwner) Status() *supervisor.Status                  { return &supervisor.Status{} }
+func (o *testOwner) Close()                                      {}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("wait timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newSupervisor creates a supervisor upon a standalone cluster, and returns
+// the TrafficController run by it.
+func newSupervisor(t *testing.T) (cluster.Cluster, *supervisor.Supervisor, *TrafficController) {
+	opt := option.New()
+	opt.Name = "standalone-member"
+	opt.Standalone = true
+	// NOTE: The supervisor writes the running objects into the home dir.
+	opt.AbsHomeDir = t.TempDir()
+	opt.AbsDataDir = filepath.Join(opt.AbsHomeDir, "data")
+	opt.AbsWALDir = filepath.Join(opt.AbsHomeDir, "wal")
+	opt.AbsLogDir = filepath.Join(opt.AbsHomeDir, "log")
+	opt.AbsMemberDir = filepath.Join(opt.AbsHomeDir, "member")
+
+	cls, err := cluster.New(opt)
+	if err != nil {
+		t.Fatalf("new standalone cluster failed: %v", err)
+	}
+	super := supervisor.MustNew(opt, cls)
+	t.Cleanup(func() {
+		wg := &sync.WaitGroup{}
+		wg.Add(2)
+		super.Close(wg)
+		cls.Close(wg)
+		wg.Wait()
+	})
+	<-super.FirstHandleDone()
+
+	entity, exists := super.GetSystemController(Kind)
+	if !exists {
+		t.Fatalf("traffic controller not found")
+	}
+	return cls, super, entity.Instance().(*TrafficController)
+}
+
+func createPipeline(t *testing.T, super *supervisor.Supervisor, tc *TrafficController, namespace, name string) {
+	spec, err := super.NewSpec("kind: HTTPPipeline\nname: " + name + "\nfilters: []\n")
+	if err != nil {
+		t.Fatalf("new spec failed: %v", err)
+	}
+	if _, err = tc.CreateHTTPPipelineForSpec(namespace, spec); err != nil {
+		t.Fatalf("create pipeline failed: %v", err)
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	cls, super, tc := newSupervisor(t)
+
+	err := cls.Put(cls.Layout().ConfigObjectKey("owner"), "kind: TestOwner\nname: owner\n")
+	if err != nil {
+		t.Fatalf("put owner failed: %v", err)
+	}
+	waitFor(t, func() bool {
+		_, exists := super.GetBusinessController("owner")
+		return exists
+	})
+
+	createPipeline(t, super, tc, "free", "p1")
+	createPipeline(t, super, tc, "owned", "p2")
+	createPipeline(t, super, tc, "owned", "p1")
+	createPipeline(t, super, tc, "orphan", "p1")
+	createPipeline(t, super, tc, "replaced", "p1")
+	tc.SetOwner("owned", &Owner{Kind: "TestOwner", Name: "owner"})
+	tc.SetOwner("orphan", &Owner{Kind: "TestOwner", Name: "missing"})
+	// The owner is replaced by an object of another kind.
+	tc.SetOwner("replaced", &Owner{Kind: "OtherOwner", Name: "owner"})
+
+	trees := tc.Ownership()
+	if len(trees) != 4 {
+		t.Fatalf("want 4 trees, got %d", len(trees))
+	}
+	if trees[0].Owner != nil || len(trees[0].Namespaces) != 1 || trees[0].Namespaces[0].Namespace != "free" {
+		t.Errorf("want the unowned namespace first, got %+v", trees[0])
+	}
+	if o := trees[1].Owner; o == nil || o.Kind != "OtherOwner" {
+		t.Errorf("want trees sorted by owner, got %+v", o)
+	}
+	owned := trees[3]
+	if o := owned.Owner; o == nil || o.Kind != "TestOwner" || o.Name != "owner" {
+		t.Fatalf("want owner TestOwner/owner, got %+v", o)
+	}
+	if ns := owned.Namespaces[0]; ns.Namespace != "owned" || len(ns.HTTPPipelines) != 2 || ns.HTTPPipelines[0] != "p1" {
+		t.Errorf("unexpected owned namespace %+v", ns)
+	}
+
+	// The orphaned namespaces are collected after the grace period.
+	now := time.Now()
+	tc.collectGarbage(now)
+	for _, tree := range tc.Ownership() {
+		for _, ns := range tree.Namespaces {
+			orphaned := ns.Namespace == "orphan" || ns.Namespace == "replaced"
+			if orphaned != (ns.OrphanedSince != nil) {
+				t.Errorf("namespace %s: unexpected orphaned since %v", ns.Namespace, ns.OrphanedSince)
+			}
+		}
+	}
+
+	tc.collectGarbage(now.Add(gcGracePeriod / 2))
+	if _, exists := tc.GetHTTPPipeline("orphan", "p1"); !exists {
+		t.Errorf("orphan should not be collected in the grace period")
+	}
+
+	tc.collectGarbage(now.Add(gcGracePeriod))
+	for _, namespace := range []string{"orphan", "replaced"} {
+		if _, exists := tc.GetHTTPPipeline(namespace, "p1"); exists {
+			t.Errorf("%s should be collected", namespace)
+		}
+		if _, exists := tc.owners[namespace]; exists {
+			t.Errorf("owner of %s should be removed", namespace)
+		}
+	}
+	for _, namespace := range []string{"owned", "free"} {
+		if _, exists := tc.GetHTTPPipeline(namespace, "p1"); !exists {
+			t.Errorf("%s should not be collected", namespace)
+		}
+	}
+
+	// Clean removes the owner reference.
+	if err := tc.Clean("owned"); err != nil {
+		t.Fatalf("clean failed: %v", err)
+	}
+	if _, exists := tc.owners["owned"]; exists {
+		t.Errorf("owner of owned should be removed")
+	}
+}
+
+func TestInheritSharesState(t *testing.T) {
+	_, super, _ := newSupervisor(t)
+
+	spec, err := super.NewSpec("kind: TrafficController\nname: TrafficController\n")
+	if err != nil {
+		t.Fatalf("new spec failed: %v", err)
+	}
+	prev := &TrafficController{}
+	prev.Init(spec)
+	createPipeline(t, super, prev, "ns", "p1")
+	prev.SetOwner("ns", &Owner{Kind: "TestOwner", Name: "missing"})
+
+	next := &TrafficController{}
+	next.Inherit(spec, prev)
+	defer next.Close()
+
+	if next.mutex != prev.mutex {
+		t.Errorf("mutex should be shared by generations")
+	}
+
+	// The GC of the previous generation may still be running.
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		prev.collectGarbage(time.Now())
+	}()
+	go func() {
+		defer wg.Done()
+		next.collectGarbage(time.Now())
+	}()
+	wg.Wait()
+
+	if trees := next.Ownership(); len(trees) != 1 || trees[0].Namespaces[0].OrphanedSince == nil {
+		t.Errorf("the owner reference should be inherited")
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package weightadjuster
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const signalTimeout = 10 * time.Second
+
+type (
+	// Signal is where the value deciding the weight comes from,
+	// exactly one of Prometheus and Webhook must be specified.
+	Signal struct {
+		Prometheus *PrometheusSpec `yaml:"prometheus" jsonschema:"omitempty"`
+		Webhook    *WebhookSpec    `yaml:"webhook" jsonschema:"omitempty"`
+	}
+
+	// PrometheusSpec describes an instant query of Prometheus,
+	// the query must return a scalar or a vector of one element.
+	PrometheusSpec struct {
+		URL   string `yaml:"url" jsonschema:"required,format=uri"`
+		Query string `yaml:"query" jsonschema:"required"`
+	}
+
+	// WebhookSpec describes a webhook returning the value in
+	// the JSON body, e.g. {"value": 0.05}.
+	WebhookSpec struct {
+		URL     string            `yaml:"url" jsonschema:"required,format=uri"`
+		Headers map[string]string `yaml:"headers" jsonschema:"omitempty"`
+	}
+
+	// signal fetches the value of a signal, ok is false if
+	// there is no data.
+	signal interface {
+		fetch() (value float64, ok bool, err error)
+	}
+
+	prometheusSignal struct {
+		spec   *PrometheusSpec
+		client *http.Client
+	}
+
+	webhookSignal struct {
+		spec   *WebhookSpec
+		client *http.Client
+	}
+
+	prometheusResponse struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+)
+
+// Validate validates Signal.
+func (s Signal) Validate() error {
+	if (s.Prometheus == nil) == (s.Webhook == nil) {
+		return fmt.Errorf("exactly one of prometheus and webhook must be specified")
+	}
+	return nil
+}
+
+func newSignal(spec *Signal) signal {
+	client := &http.Client{Timeout: signalTimeout}
+	if spec.Prometheus != nil {
+		return &prometheusSignal{spec: spec.Prometheus, client: client}
+	}
+	return &webhookSignal{spec: spec.Webhook, client: client}
+}
+
+func getJSON(client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read body failed: %v", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+	}
+	if err = json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("unmarshal %s to json failed: %v", body, err)
+	}
+	return nil
+}
+
+func (s *prometheusSignal) fetch() (float64, bool, error) {
+	u := strings.TrimSuffix(s.spec.URL, "/") + "/api/v1/query?query=" + url.QueryEscape(s.spec.Query)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return 0, false, err
+	}
+
+	resp := &prometheusResponse{}
+	if err = getJSON(s.client, req, resp); err != nil {
+		return 0, false, err
+	}
+	if resp.Status != "success" {
+		return 0, false, fmt.Errorf("query failed: %s", resp.Error)
+	}
+
+	// NOTE: A sample is [timestamp, "value"].
+	var sample []interface{}
+	switch resp.Data.ResultType {
+	case "scalar":
+		err = json.Unmarshal(resp.Data.Result, &sample)
+	case "vector":
+		var vector []struct {
+			Value []interface{} `json:"value"`
+		}
+		err = json.Unmarshal(resp.Data.Result, &vector)
+		if err == nil && len(vector) > 1 {
+			err = fmt.Errorf("got %d series, expected one", len(vector))
+		}
+		if err == nil && len(vector) == 0 {
+			return 0, false, nil
+		}
+		if err == nil {
+			sample = vector[0].Value
+		}
+	default:
+		err = fmt.Errorf("unsupported result type %s", resp.Data.ResultType)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	if len(sample) != 2 {
+		return 0, false, fmt.Errorf("invalid sample %v", sample)
+	}
+	str, _ := sample[1].(string)
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid sample value %v", sample[1])
+	}
+	if math.IsNaN(value) {
+		return 0, false, nil
+	}
+	return value, true, nil
+}
+
+func (s *webhookSignal) fetch() (float64, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, s.spec.URL, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	for k, v := range s.spec.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp := &struct {
+		Value *float64 `json:"value"`
+	}{}
+	if err = getJSON(s.client, req, resp); err != nil {
+		return 0, false, err
+	}
+	if resp.Value == nil {
+		return 0, false, nil
+	}
+	return *resp.Value, true, nil
+}
@@ -0,0 +1,322 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package weightadjuster
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/filter/proxy"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Kind is the kind of WeightAdjuster.
+	Kind = "WeightAdjuster"
+
+	maxAdjustments = 100
+)
+
+func init() {
+	supervisor.Register(&WeightAdjuster{})
+}
+
+type (
+	// WeightAdjuster adjusts the weights of the servers of a Proxy by
+	// external signals, e.g. shifts traffic away from a region whose
+	// error rate exceeds a threshold.
+	WeightAdjuster struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		signals []signal
+
+		mutex       sync.Mutex
+		targets     []*TargetStatus
+		adjustments []*Adjustment
+
+		done chan struct{}
+	}
+
+	// Spec describes the WeightAdjuster.
+	Spec struct {
+		// Pipeline and Filter locate the Proxy filter.
+		Pipeline string `yaml:"pipeline" jsonschema:"required"`
+		Filter   string `yaml:"filter" jsonschema:"required"`
+		// Interval is the interval of fetching signals and adjusting.
+		Interval string `yaml:"interval" jsonschema:"required,format=duration"`
+		// MaxStep is the max change of a factor in percentage points
+		// in an adjustment, it bounds the rate of change.
+		MaxStep int       `yaml:"maxStep" jsonschema:"required,minimum=1,maximum=100"`
+		Targets []*Target `yaml:"targets" jsonschema:"required,minItems=1"`
+	}
+
+	// Target is a group of servers adjusted by a signal.
+	Target struct {
+		// Tag selects the servers of the Proxy.
+		Tag    string  `yaml:"tag" jsonschema:"required"`
+		Signal *Signal `yaml:"signal" jsonschema:"required"`
+		// Threshold is the value of the signal above which the
+		// servers are degraded.
+		Threshold float64 `yaml:"threshold" jsonschema:"omitempty"`
+		// DegradedFactor is the factor of weights in percentage
+		// when degraded, it's 100 otherwise.
+		DegradedFactor int `yaml:"degradedFactor" jsonschema:"omitempty,minimum=0,maximum=99"`
+	}
+
+	// Adjustment is an audit record of a change of a factor.
+	Adjustment struct {
+		Time      time.Time `yaml:"time"`
+		Tag       string    `yaml:"tag"`
+		Value     float64   `yaml:"value"`
+		Threshold float64   `yaml:"threshold"`
+		From      int       `yaml:"from"`
+		To        int       `yaml:"to"`
+	}
+
+	// TargetStatus is the status of a Target.
+	TargetStatus struct {
+		Tag string `yaml:"tag"`
+		// Value is the last value of the signal, nil if no data.
+		Value     *float64  `yaml:"value"`
+		Factor    int       `yaml:"factor"`
+		UpdatedAt time.Time `yaml:"updatedAt"`
+		LastError string    `yaml:"lastError,omitempty"`
+	}
+
+	// Status is the status of WeightAdjuster.
+	Status struct {
+		Targets []*TargetStatus `yaml:"targets"`
+		// Adjustments are the latest adjustments, the oldest first.
+		Adjustments []*Adjustment `yaml:"adjustments"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	interval, err := time.ParseDuration(spec.Interval)
+	if err != nil || interval < time.Second {
+		return fmt.Errorf("invalid interval %s, it must be at least 1s", spec.Interval)
+	}
+
+	tags := map[string]bool{}
+	for _, t := range spec.Targets {
+		if tags[t.Tag] {
+			return fmt.Errorf("duplicated tag %s", t.Tag)
+		}
+		tags[t.Tag] = true
+		if err := t.Signal.Validate(); err != nil {
+			return fmt.Errorf("target %s: %v", t.Tag, err)
+		}
+	}
+	return nil
+}
+
+// Category returns the category of WeightAdjuster.
+func (wa *WeightAdjuster) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+
+// Kind returns the kind of WeightAdjuster.
+func (wa *WeightAdjuster) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of WeightAdjuster.
+func (wa *WeightAdjuster) DefaultSpec() interface{} {
+	return &Spec{
+		Interval: "30s",
+		MaxStep:  10,
+	}
+}
+
+// Init initializes WeightAdjuster.
+func (wa *WeightAdjuster) Init(superSpec *supervisor.Spec) {
+	wa.superSpec, wa.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	wa.reload(nil)
+}
+
+// Inherit inherits previous generation of WeightAdjuster.
+func (wa *WeightAdjuster) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	prev := previousGeneration.(*WeightAdjuster)
+	prev.stop()
+
+	wa.superSpec, wa.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	wa.reload(prev)
+}
+
+func (wa *WeightAdjuster) reload(prev *WeightAdjuster) {
+	// NOTE: Start from the current factors, so the rate of change
+	// is bounded across generations too.
+	factors := proxy.WeightFactors(wa.spec.Pipeline, wa.spec.Filter)
+	if prev != nil && (prev.spec.Pipeline != wa.spec.Pipeline || prev.spec.Filter != wa.spec.Filter) {
+		proxy.SetWeightFactors(prev.spec.Pipeline, prev.spec.Filter, nil)
+	}
+	if prev != nil {
+		wa.adjustments = prev.adjustments
+	}
+
+	wa.signals = make([]signal, len(wa.spec.Targets))
+	wa.targets = make([]*TargetStatus, len(wa.spec.Targets))
+	for i, t := range wa.spec.Targets {
+		wa.signals[i] = newSignal(t.Signal)
+		factor, ok := factors[t.Tag]
+		if !ok {
+			factor = 100
+		}
+		wa.targets[i] = &TargetStatus{Tag: t.Tag, Factor: factor}
+	}
+	wa.apply()
+
+	wa.done = make(chan struct{})
+	go wa.run()
+}
+
+func (wa *WeightAdjuster) run() {
+	interval, _ := time.ParseDuration(wa.spec.Interval)
+	for {
+		select {
+		case <-wa.done:
+			return
+		case <-time.After(interval):
+			wa.adjust()
+		}
+	}
+}
+
+// adjust fetches the signals and moves every factor towards the
+// desired one by at most MaxStep. Factors are kept if signals fail
+// or have no data.
+func (wa *WeightAdjuster) adjust() {
+	type fetched struct {
+		value float64
+		ok    bool
+		err   error
+	}
+
+	results := make([]fetched, len(wa.signals))
+	for i, s := range wa.signals {
+		v, ok, err := s.fetch()
+		results[i] = fetched{value: v, ok: ok, err: err}
+	}
+
+	wa.mutex.Lock()
+	defer wa.mutex.Unlock()
+
+	now := time.Now()
+	changed := false
+	for i, t := range wa.spec.Targets {
+		status, r := wa.targets[i], results[i]
+		status.UpdatedAt = now
+		if r.err != nil {
+			status.LastError = r.err.Error()
+			logger.Errorf("%s fetch signal of %s failed: %v", wa.superSpec.Name(), t.Tag, r.err)
+			continue
+		}
+		status.LastError = ""
+		if !r.ok {
+			status.Value = nil
+			continue
+		}
+		value := r.value
+		status.Value = &value
+
+		desired := 100
+		if value > t.Threshold {
+			desired = t.DegradedFactor
+		}
+		factor := stepTowards(status.Factor, desired, wa.spec.MaxStep)
+		if factor == status.Factor {
+			continue
+		}
+
+		a := &Adjustment{
+			Time:      now,
+			Tag:       t.Tag,
+			Value:     value,
+			Threshold: t.Threshold,
+			From:      status.Factor,
+			To:        factor,
+		}
+		logger.Infof("%s adjust weight factor of %s in %s/%s from %d%% to %d%%, signal: %v, threshold: %v",
+			wa.superSpec.Name(), t.Tag, wa.spec.Pipeline, wa.spec.Filter, a.From, a.To, a.Value, a.Threshold)
+
+		wa.adjustments = append(wa.adjustments, a)
+		if len(wa.adjustments) > maxAdjustments {
+			wa.adjustments = wa.adjustments[len(wa.adjustments)-maxAdjustments:]
+		}
+		status.Factor, changed = factor, true
+	}
+
+	if changed {
+		wa.applyLocked()
+	}
+}
+
+func stepTowards(current, desired, maxStep int) int {
+	switch {
+	case desired > current+maxStep:
+		return current + maxStep
+	case desired < current-maxStep:
+		return current - maxStep
+	default:
+		return desired
+	}
+}
+
+func (wa *WeightAdjuster) apply() {
+	wa.mutex.Lock()
+	defer wa.mutex.Unlock()
+	wa.applyLocked()
+}
+
+func (wa *WeightAdjuster) applyLocked() {
+	factors := make(map[string]int, len(wa.targets))
+	for _, t := range wa.targets {
+		factors[t.Tag] = t.Factor
+	}
+	proxy.SetWeightFactors(wa.spec.Pipeline, wa.spec.Filter, factors)
+}
+
+// Status returns the status of WeightAdjuster.
+func (wa *WeightAdjuster) Status() *supervisor.Status {
+	wa.mutex.Lock()
+	defer wa.mutex.Unlock()
+
+	s := &Status{
+		Targets:     make([]*TargetStatus, len(wa.targets)),
+		Adjustments: append([]*Adjustment(nil), wa.adjustments...),
+	}
+	for i, t := range wa.targets {
+		copied := *t
+		s.Targets[i] = &copied
+	}
+
+	return &supervisor.Status{ObjectStatus: s}
+}
+
+func (wa *WeightAdjuster) stop() {
+	close(wa.done)
+}
+
+// Close closes WeightAdjuster, the configured weights are restored.
+func (wa *WeightAdjuster) Close() {
+	wa.stop()
+	proxy.SetWeightFactors(wa.spec.Pipeline, wa.spec.Filter, nil)
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package weightadjuster
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/filter/proxy"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newWeightAdjuster(t *testing.T, yamlConfig string) *WeightAdjuster {
+	spec, err := supervisor.NewSpec(yamlConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wa := &WeightAdjuster{}
+	wa.Init(spec)
+	return wa
+}
+
+func TestAdjust(t *testing.T) {
+	var errorRate atomic.Value
+	errorRate.Store("0.01")
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" || r.URL.Query().Get("query") != "error_rate{region=\"east\"}" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1600000000,"%s"]}]}}`,
+			errorRate.Load())
+	}))
+	defer prometheus.Close()
+
+	webhookFailed := int32(1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&webhookFailed) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"value": 100}`))
+	}))
+	defer webhook.Close()
+
+	wa := newWeightAdjuster(t, fmt.Sprintf(`
+kind: WeightAdjuster
+name: weight-adjuster
+pipeline: pipeline
+filter: proxy
+interval: 1h
+maxStep: 40
+targets:
+- tag: east
+  threshold: 0.05
+  signal:
+    prometheus:
+      url: %s
+      query: error_rate{region="east"}
+- tag: west
+  threshold: 10
+  degradedFactor: 50
+  signal:
+    webhook:
+      url: %s
+`, prometheus.URL, webhook.URL))
+
+	factors := func() map[string]int {
+		return proxy.WeightFactors("pipeline", "proxy")
+	}
+
+	wa.adjust()
+	if f := factors(); !reflect.DeepEqual(f, map[string]int{"east": 100, "west": 100}) {
+		t.Errorf("unexpected factors %v", f)
+	}
+	if s := wa.Status().ObjectStatus.(*Status); s.Targets[1].LastError == "" || len(s.Adjustments) != 0 {
+		t.Errorf("unexpected status %+v", s)
+	}
+
+	errorRate.Store("0.1")
+	atomic.StoreInt32(&webhookFailed, 0)
+	for _, expected := range []map[string]int{
+		{"east": 60, "west": 60},
+		{"east": 20, "west": 50},
+		{"east": 0, "west": 50},
+		{"east": 0, "west": 50},
+	} {
+		wa.adjust()
+		if f := factors(); !reflect.DeepEqual(f, expected) {
+			t.Errorf("expected factors %v, got %v", expected, f)
+		}
+	}
+
+	s := wa.Status().ObjectStatus.(*Status)
+	if len(s.Adjustments) != 5 || s.Targets[1].LastError != "" {
+		t.Errorf("unexpected status %+v", s)
+	}
+	if a := s.Adjustments[0]; a.Tag != "east" || a.From != 100 || a.To != 60 || a.Value != 0.1 {
+		t.Errorf("unexpected adjustment %+v", a)
+	}
+
+	// NOTE: The new generation starts from the current factors.
+	spec, _ := supervisor.NewSpec(fmt.Sprintf(`
+kind: WeightAdjuster
+name: weight-adjuster
+pipeline: pipeline
+filter: proxy
+interval: 1h
+maxStep: 30
+targets:
+- tag: east
+  threshold: 0.05
+  signal:
+    prometheus:
+      url: %s
+      query: error_rate{region="east"}
+`, prometheus.URL))
+	next := &WeightAdjuster{}
+	next.Inherit(spec, wa)
+	wa = next
+
+	errorRate.Store("0.01")
+	wa.adjust()
+	if f := factors(); !reflect.DeepEqual(f, map[string]int{"east": 30}) {
+		t.Errorf("unexpected factors %v", f)
+	}
+
+	wa.Close()
+	if f := factors(); len(f) != 0 {
+		t.Errorf("factors should be cleared, got %v", f)
+	}
+}
+
+func TestPrometheusSignal(t *testing.T) {
+	var body atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body.Load().(string)))
+	}))
+	defer server.Close()
+
+	s := newSignal(&Signal{Prometheus: &PrometheusSpec{URL: server.URL, Query: "up"}})
+	cases := []struct {
+		body  string
+		value float64
+		ok    bool
+		err   bool
+	}{
+		{`{"status":"success","data":{"resultType":"scalar","result":[1600000000,"1.5"]}}`, 1.5, true, false},
+		{`{"status":"success","data":{"resultType":"vector","result":[]}}`, 0, false, false},
+		{`{"status":"success","data":{"resultType":"vector","result":[{"value":[1600000000,"NaN"]}]}}`, 0, false, false},
+		{`{"status":"success","data":{"resultType":"vector","result":[{"value":[1,"1"]},{"value":[1,"2"]}]}}`, 0, false, true},
+		{`{"status":"success","data":{"resultType":"matrix","result":[]}}`, 0, false, true},
+		{`{"status":"error","error":"bad query"}`, 0, false, true},
+	}
+	for _, c := range cases {
+		body.Store(c.body)
+		value, ok, err := s.fetch()
+		if value != c.value || ok != c.ok || (err != nil) != c.err {
+			t.Errorf("%s: unexpected result %v, %v, %v", c.body, value, ok, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	spec := Spec{
+		Interval: "1s",
+		Targets: []*Target{
+			{Tag: "east", Signal: &Signal{Webhook: &WebhookSpec{URL: "http://127.0.0.1"}}},
+		},
+	}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	spec.Targets = append(spec.Targets, spec.Targets[0])
+	if err := spec.Validate(); err == nil {
+		t.Errorf("duplicated tags should be invalid")
+	}
+
+	spec.Targets = spec.Targets[:1]
+	spec.Targets[0].Signal.Prometheus = &PrometheusSpec{URL: "http://127.0.0.1", Query: "up"}
+	if err := spec.Validate(); err == nil {
+		t.Errorf("both prometheus and webhook should be invalid")
+	}
+
+	spec.Interval = "100ms"
+	if err := spec.Validate(); err == nil {
+		t.Errorf("interval less than 1s should be invalid")
+	}
+}
@@ -0,0 +1,318 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xdsserver
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/serviceregistry"
+	"github.com/megaease/easegress/pkg/object/xdsserver/xdspb"
+)
+
+type (
+	server struct {
+		xdspb.UnimplementedAggregatedDiscoveryServiceServer
+
+		name string
+		spec *Spec
+		// registry is the service registry, it's replaceable for testing.
+		registry *serviceregistry.ServiceRegistry
+
+		grpc      *grpc.Server
+		listener  net.Listener
+		listenErr string
+		done      chan struct{}
+		wg        sync.WaitGroup
+
+		mutex    sync.Mutex
+		snapshot snapshot
+		// changed is closed and replaced when the snapshot is changed.
+		changed chan struct{}
+		streams int
+
+		pushes uint64
+		nacks  uint64
+	}
+
+	// stream is the state of an ADS stream of an Envoy.
+	stream struct {
+		s      *server
+		stream xdspb.AggregatedDiscoveryService_StreamAggregatedResourcesServer
+		node   string
+		nonce  uint64
+		// watches are the subscriptions by the type URLs.
+		watches map[string]*watch
+	}
+
+	// watch is the subscription of a type, and the last response of it.
+	watch struct {
+		names   []string
+		version string
+		nonce   string
+	}
+)
+
+func newServer(name string, spec *Spec) *server {
+	return &server{
+		name:     name,
+		spec:     spec,
+		registry: serviceregistry.Global,
+		done:     make(chan struct{}),
+		changed:  make(chan struct{}),
+	}
+}
+
+func (s *server) listen() error {
+	s.sync()
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.spec.Port))
+	if err != nil {
+		s.listenErr = err.Error()
+		return err
+	}
+
+	s.listener = listener
+	s.grpc = grpc.NewServer()
+	xdspb.RegisterAggregatedDiscoveryServiceServer(s.grpc, s)
+
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		s.grpc.Serve(listener)
+	}()
+	go s.run()
+	return nil
+}
+
+func (s *server) run() {
+	defer s.wg.Done()
+
+	interval, _ := time.ParseDuration(s.spec.SyncInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.sync()
+		}
+	}
+}
+
+// sync rebuilds the snapshot, and notifies the streams if it's changed.
+func (s *server) sync() {
+	snap := buildSnapshot(s.spec, s.registry.ListServices())
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.snapshot != nil {
+		changed := false
+		for typeURL, r := range snap {
+			if s.snapshot.resources(typeURL).version != r.version {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			return
+		}
+	}
+
+	s.snapshot = snap
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *server) current() (snapshot, chan struct{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.snapshot, s.changed
+}
+
+func (s *server) addStreams(delta int) {
+	s.mutex.Lock()
+	s.streams += delta
+	s.mutex.Unlock()
+}
+
+// StreamAggregatedResources serves the ADS stream of an Envoy, it's the
+// state of the world protocol, so every response contains all resources
+// subscribed of the type.
+func (s *server) StreamAggregatedResources(ss xdspb.AggregatedDiscoveryService_StreamAggregatedResourcesServer) error {
+	s.addStreams(1)
+	defer s.addStreams(-1)
+
+	reqs := make(chan *xdspb.DiscoveryRequest)
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			req, err := ss.Recv()
+			if err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-ss.Context().Done():
+				return
+			}
+		}
+	}()
+
+	st := &stream{s: s, stream: ss, watches: map[string]*watch{}}
+	for {
+		snap, changed := s.current()
+		select {
+		case req := <-reqs:
+			if err := st.handle(req, snap); err != nil {
+				return err
+			}
+		case <-changed:
+			snap, _ = s.current()
+			if err := st.push(snap); err != nil {
+				return err
+			}
+		case err := <-errc:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-ss.Context().Done():
+			return ss.Context().Err()
+		}
+	}
+}
+
+// handle handles the request, which subscribes to resources of a type,
+// or ACKs or NACKs the previous response.
+func (st *stream) handle(req *xdspb.DiscoveryRequest, snap snapshot) error {
+	if req.Node != nil && req.Node.Id != "" {
+		st.node = req.Node.Id
+	}
+
+	w := st.watches[req.TypeUrl]
+	if req.ResponseNonce != "" && (w == nil || w.nonce != req.ResponseNonce) {
+		// NOTE: The request is for a stale response, which is replaced
+		// by a newer one.
+		return nil
+	}
+	if w == nil {
+		w = &watch{}
+		st.watches[req.TypeUrl] = w
+	}
+
+	if req.ErrorDetail != nil {
+		atomic.AddUint64(&st.s.nacks, 1)
+		logger.Warnf("%s: %s rejected %s of version %s: %s",
+			st.s.name, st.node, req.TypeUrl, w.version, req.ErrorDetail.Message)
+	}
+
+	names := append([]string{}, req.ResourceNames...)
+	sort.Strings(names)
+	namesChanged := !stringsEqual(w.names, names)
+	w.names = names
+
+	// NOTE: Respond to the first request of the type even if Envoy has
+	// the version from the previous stream, and the rejected version
+	// isn't sent again until the resources are changed.
+	if req.ResponseNonce == "" || namesChanged {
+		return st.send(req.TypeUrl, w, snap)
+	}
+	return nil
+}
+
+// push sends the types whose resources are changed.
+func (st *stream) push(snap snapshot) error {
+	for typeURL, w := range st.watches {
+		if snap.resources(typeURL).version != w.version {
+			if err := st.send(typeURL, w, snap); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (st *stream) send(typeURL string, w *watch, snap snapshot) error {
+	r := snap.resources(typeURL)
+	st.nonce++
+	resp := &xdspb.DiscoveryResponse{
+		VersionInfo: r.version,
+		Resources:   r.get(w.names),
+		TypeUrl:     typeURL,
+		Nonce:       strconv.FormatUint(st.nonce, 10),
+	}
+	if err := st.stream.Send(resp); err != nil {
+		return err
+	}
+
+	w.version, w.nonce = resp.VersionInfo, resp.Nonce
+	atomic.AddUint64(&st.s.pushes, 1)
+	return nil
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *server) status() *Status {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	versions := map[string]string{}
+	for typeURL, r := range s.snapshot {
+		versions[typeURL] = r.version
+	}
+	return &Status{
+		Streams:  s.streams,
+		Versions: versions,
+		Pushes:   atomic.LoadUint64(&s.pushes),
+		NACKs:    atomic.LoadUint64(&s.nacks),
+		Error:    s.listenErr,
+	}
+}
+
+func (s *server) close() {
+	close(s.done)
+	if s.grpc != nil {
+		s.grpc.Stop()
+	}
+	s.wg.Wait()
+}
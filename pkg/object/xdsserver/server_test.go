@@ -0,0 +1,234 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xdsserver
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/serviceregistry"
+	"github.com/megaease/easegress/pkg/object/xdsserver/xdspb"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	os.Exit(m.Run())
+}
+
+func TestValidate(t *testing.T) {
+	spec := &Spec{
+		RouteConfigs: []*RouteConfig{{
+			Name: "local",
+			VirtualHosts: []*VirtualHost{{
+				Name:    "all",
+				Domains: []string{"*"},
+				Routes:  []*Route{{PathPrefix: "/", Cluster: "order"}},
+			}},
+		}},
+	}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, r := range []*Route{
+		{Cluster: "order"},
+		{Path: "/a", PathPrefix: "/", Cluster: "order"},
+		{PathRegexp: "(", Cluster: "order"},
+		{PathPrefix: "/", Cluster: "order", Timeout: "1"},
+	} {
+		spec.RouteConfigs[0].VirtualHosts[0].Routes[0] = r
+		if spec.Validate() == nil {
+			t.Errorf("route %+v should be invalid", r)
+		}
+	}
+}
+
+type adsClient struct {
+	t      *testing.T
+	stream xdspb.AggregatedDiscoveryService_StreamAggregatedResourcesClient
+	resps  chan *xdspb.DiscoveryResponse
+}
+
+func (c *adsClient) send(req *xdspb.DiscoveryRequest) {
+	req.Node = &xdspb.Node{Id: "envoy-1"}
+	if err := c.stream.Send(req); err != nil {
+		c.t.Fatalf("send failed: %v", err)
+	}
+}
+
+func (c *adsClient) recv() *xdspb.DiscoveryResponse {
+	select {
+	case resp := <-c.resps:
+		return resp
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("no response")
+		return nil
+	}
+}
+
+func (c *adsClient) noResponse() {
+	select {
+	case resp := <-c.resps:
+		c.t.Fatalf("unexpected response: %v", resp)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestServer(t *testing.T) {
+	registry := serviceregistry.New()
+	registry.ReplaceServers("consul", []*serviceregistry.Server{
+		{ServiceName: "order", HostIP: "10.0.0.1", Port: 8080, Weight: 2},
+		{ServiceName: "order", HostIP: "10.0.0.2", Port: 8081},
+		{ServiceName: "user", Hostname: "user.example.com", Scheme: "https"},
+	})
+
+	s := newServer("xds", &Spec{
+		SyncInterval:   "1h",
+		ConnectTimeout: "2s",
+		LBPolicy:       "leastRequest",
+		RouteConfigs: []*RouteConfig{{
+			Name: "local",
+			VirtualHosts: []*VirtualHost{{
+				Name:    "all",
+				Domains: []string{"*"},
+				Routes:  []*Route{{PathPrefix: "/order", Cluster: "order", Timeout: "3s"}},
+			}},
+		}},
+	})
+	s.registry = registry
+	if err := s.listen(); err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer s.close()
+
+	conn, err := grpc.Dial(s.listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := xdspb.NewAggregatedDiscoveryServiceClient(conn).StreamAggregatedResources(ctx)
+	if err != nil {
+		t.Fatalf("stream failed: %v", err)
+	}
+	c := &adsClient{t: t, stream: stream, resps: make(chan *xdspb.DiscoveryResponse, 10)}
+	go func() {
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			c.resps <- resp
+		}
+	}()
+
+	// CDS
+	c.send(&xdspb.DiscoveryRequest{TypeUrl: typeCluster})
+	resp := c.recv()
+	if len(resp.Resources) != 2 {
+		t.Fatalf("expect 2 clusters, got %d", len(resp.Resources))
+	}
+	order, user := &xdspb.Cluster{}, &xdspb.Cluster{}
+	proto.Unmarshal(resp.Resources[0].Value, order)
+	proto.Unmarshal(resp.Resources[1].Value, user)
+	if order.Name != "order" || order.Type != xdspb.Cluster_EDS || order.EdsClusterConfig.EdsConfig.Ads == nil ||
+		order.LbPolicy != xdspb.Cluster_LEAST_REQUEST || order.ConnectTimeout.Seconds != 2 || order.TransportSocket != nil {
+		t.Errorf("unexpected cluster: %v", order)
+	}
+	if user.Name != "user" || user.Type != xdspb.Cluster_STRICT_DNS || user.TransportSocket == nil ||
+		user.LoadAssignment.Endpoints[0].LbEndpoints[0].Endpoint.Address.SocketAddress.PortValue != 443 {
+		t.Errorf("unexpected cluster: %v", user)
+	}
+	cdsVersion := resp.VersionInfo
+	c.send(&xdspb.DiscoveryRequest{TypeUrl: typeCluster, VersionInfo: cdsVersion, ResponseNonce: resp.Nonce})
+	c.noResponse()
+
+	// EDS
+	c.send(&xdspb.DiscoveryRequest{TypeUrl: typeEndpoint, ResourceNames: []string{"order"}})
+	resp = c.recv()
+	cla := &xdspb.ClusterLoadAssignment{}
+	proto.Unmarshal(resp.Resources[0].Value, cla)
+	endpoints := cla.Endpoints[0].LbEndpoints
+	if len(resp.Resources) != 1 || cla.ClusterName != "order" || len(endpoints) != 2 ||
+		endpoints[0].Endpoint.Address.SocketAddress.Address != "10.0.0.1" ||
+		endpoints[0].LoadBalancingWeight.Value != 2 || endpoints[1].LoadBalancingWeight != nil {
+		t.Errorf("unexpected endpoints: %v", cla)
+	}
+	c.send(&xdspb.DiscoveryRequest{TypeUrl: typeEndpoint, ResourceNames: []string{"order"},
+		VersionInfo: resp.VersionInfo, ResponseNonce: resp.Nonce})
+	c.noResponse()
+
+	// RDS
+	c.send(&xdspb.DiscoveryRequest{TypeUrl: typeRoute, ResourceNames: []string{"local"}})
+	resp = c.recv()
+	rc := &xdspb.RouteConfiguration{}
+	proto.Unmarshal(resp.Resources[0].Value, rc)
+	route := rc.VirtualHosts[0].Routes[0]
+	if rc.Name != "local" || route.Match.Prefix != "/order" || route.Route.Cluster != "order" ||
+		route.Route.Timeout.Seconds != 3 {
+		t.Errorf("unexpected route config: %v", rc)
+	}
+	c.send(&xdspb.DiscoveryRequest{TypeUrl: typeRoute, ResourceNames: []string{"local"},
+		VersionInfo: resp.VersionInfo, ResponseNonce: resp.Nonce})
+	c.noResponse()
+
+	// Only the endpoints are pushed if the servers are changed.
+	registry.ReplaceServers("consul", []*serviceregistry.Server{
+		{ServiceName: "order", HostIP: "10.0.0.3", Port: 8080},
+		{ServiceName: "user", Hostname: "user.example.com", Scheme: "https"},
+	})
+	s.sync()
+	resp = c.recv()
+	cla = &xdspb.ClusterLoadAssignment{}
+	proto.Unmarshal(resp.Resources[0].Value, cla)
+	if resp.TypeUrl != typeEndpoint || len(cla.Endpoints[0].LbEndpoints) != 1 {
+		t.Fatalf("unexpected push: %v", resp)
+	}
+	c.send(&xdspb.DiscoveryRequest{TypeUrl: typeEndpoint, ResourceNames: []string{"order"},
+		VersionInfo: resp.VersionInfo, ResponseNonce: resp.Nonce})
+	c.noResponse()
+
+	// The rejected version isn't sent again.
+	registry.ReplaceServers("consul", []*serviceregistry.Server{
+		{ServiceName: "user", Hostname: "user.example.com", Scheme: "https"},
+	})
+	s.sync()
+	for i := 0; i < 2; i++ {
+		resp = c.recv()
+		if resp.TypeUrl == typeCluster {
+			if len(resp.Resources) != 1 {
+				t.Errorf("expect 1 cluster, got %d", len(resp.Resources))
+			}
+			c.send(&xdspb.DiscoveryRequest{TypeUrl: typeCluster, VersionInfo: cdsVersion,
+				ResponseNonce: resp.Nonce, ErrorDetail: &xdspb.Status{Message: "rejected"}})
+		}
+	}
+	c.noResponse()
+
+	status := s.status()
+	if status.Streams != 1 || status.NACKs != 1 || status.Pushes != 6 || len(status.Versions) != 3 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
@@ -0,0 +1,276 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xdsserver
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/megaease/easegress/pkg/object/serviceregistry"
+	"github.com/megaease/easegress/pkg/object/xdsserver/xdspb"
+)
+
+const (
+	typeURLPrefix = "type.googleapis.com/"
+
+	typeCluster     = typeURLPrefix + "envoy.config.cluster.v3.Cluster"
+	typeEndpoint    = typeURLPrefix + "envoy.config.endpoint.v3.ClusterLoadAssignment"
+	typeRoute       = typeURLPrefix + "envoy.config.route.v3.RouteConfiguration"
+	typeUpstreamTLS = typeURLPrefix + "envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext"
+)
+
+type (
+	// snapshot contains the resources of all types at a point in time,
+	// it's immutable once built.
+	snapshot map[string]*resources
+
+	// resources contains the resources of a type by their names.
+	resources struct {
+		version string
+		names   []string
+		items   map[string]*xdspb.Any
+	}
+)
+
+var marshalOptions = proto.MarshalOptions{Deterministic: true}
+
+func newResources(typeURL string, messages map[string]proto.Message) *resources {
+	r := &resources{items: map[string]*xdspb.Any{}}
+	for name := range messages {
+		r.names = append(r.names, name)
+	}
+	sort.Strings(r.names)
+
+	// NOTE: The version is the hash of the resources, so it's stable
+	// across restarts and Envoy isn't pushed the same resources again.
+	h := fnv.New64a()
+	for _, name := range r.names {
+		value, err := marshalOptions.Marshal(messages[name])
+		if err != nil {
+			panic(fmt.Errorf("BUG: marshal %s %s failed: %v", typeURL, name, err))
+		}
+		r.items[name] = &xdspb.Any{TypeUrl: typeURL, Value: value}
+		h.Write([]byte(name))
+		h.Write(value)
+	}
+	r.version = fmt.Sprintf("%016x", h.Sum64())
+
+	return r
+}
+
+// get returns the resources of the names, all resources are returned if
+// names are empty, and the names not existing are ignored.
+func (r *resources) get(names []string) []*xdspb.Any {
+	if len(names) == 0 {
+		names = r.names
+	}
+	var items []*xdspb.Any
+	for _, name := range names {
+		if item, exists := r.items[name]; exists {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// resources returns the resources of the type, they are empty if the
+// type isn't served.
+func (s snapshot) resources(typeURL string) *resources {
+	if r, exists := s[typeURL]; exists {
+		return r
+	}
+	return newResources(typeURL, nil)
+}
+
+// buildSnapshot builds the snapshot of the services by their registry
+// names, the services of the same name in different registries are
+// merged into one cluster.
+func buildSnapshot(spec *Spec, services map[string][]*serviceregistry.Service) snapshot {
+	registries := map[string]bool{}
+	for _, name := range spec.ServiceRegistries {
+		registries[name] = true
+	}
+
+	serversByService := map[string][]*serviceregistry.Server{}
+	for registryName, services := range services {
+		if len(registries) != 0 && !registries[registryName] {
+			continue
+		}
+		for _, service := range services {
+			servers := service.Servers()
+			if len(servers) != 0 {
+				name := servers[0].ServiceName
+				serversByService[name] = append(serversByService[name], servers...)
+			}
+		}
+	}
+
+	connectTimeout, _ := time.ParseDuration(spec.ConnectTimeout)
+	clusters := map[string]proto.Message{}
+	endpoints := map[string]proto.Message{}
+	for name, servers := range serversByService {
+		sort.Slice(servers, func(i, j int) bool { return servers[i].URL() < servers[j].URL() })
+		cluster, cla := newCluster(name, servers, connectTimeout, spec.LBPolicy)
+		clusters[name] = cluster
+		if cla != nil {
+			endpoints[name] = cla
+		}
+	}
+
+	routes := map[string]proto.Message{}
+	for _, rc := range spec.RouteConfigs {
+		routes[rc.Name] = newRouteConfiguration(rc)
+	}
+
+	return snapshot{
+		typeCluster:  newResources(typeCluster, clusters),
+		typeEndpoint: newResources(typeEndpoint, endpoints),
+		typeRoute:    newResources(typeRoute, routes),
+	}
+}
+
+// newCluster creates the cluster of the service. The endpoints are served
+// by EDS if all servers have IP addresses, and the cluster load assignment
+// is returned too. Otherwise, Envoy resolves the hostnames by itself as
+// a STRICT_DNS cluster, because EDS doesn't support hostnames.
+func newCluster(name string, servers []*serviceregistry.Server,
+	connectTimeout time.Duration, lbPolicy string) (*xdspb.Cluster, *xdspb.ClusterLoadAssignment) {
+
+	cla := &xdspb.ClusterLoadAssignment{
+		ClusterName: name,
+		Endpoints:   []*xdspb.LocalityLbEndpoints{{}},
+	}
+	allIP, allTLS := true, true
+	for _, server := range servers {
+		host := server.HostIP
+		if host == "" {
+			host = server.Hostname
+		}
+		if net.ParseIP(host) == nil {
+			allIP = false
+		}
+		if server.Scheme != "https" {
+			allTLS = false
+		}
+
+		endpoint := &xdspb.LbEndpoint{
+			Endpoint: &xdspb.Endpoint{
+				Address: &xdspb.Address{
+					SocketAddress: &xdspb.SocketAddress{
+						Address:   host,
+						PortValue: uint32(serverPort(server)),
+					},
+				},
+			},
+		}
+		if server.Weight > 0 {
+			endpoint.LoadBalancingWeight = &xdspb.UInt32Value{Value: uint32(server.Weight)}
+		}
+		cla.Endpoints[0].LbEndpoints = append(cla.Endpoints[0].LbEndpoints, endpoint)
+	}
+
+	cluster := &xdspb.Cluster{
+		Name:           name,
+		ConnectTimeout: durationPB(connectTimeout),
+	}
+	switch lbPolicy {
+	case "leastRequest":
+		cluster.LbPolicy = xdspb.Cluster_LEAST_REQUEST
+	case "random":
+		cluster.LbPolicy = xdspb.Cluster_RANDOM
+	default:
+		cluster.LbPolicy = xdspb.Cluster_ROUND_ROBIN
+	}
+	if allTLS {
+		tls, _ := marshalOptions.Marshal(&xdspb.UpstreamTlsContext{})
+		cluster.TransportSocket = &xdspb.TransportSocket{
+			Name:        "envoy.transport_sockets.tls",
+			TypedConfig: &xdspb.Any{TypeUrl: typeUpstreamTLS, Value: tls},
+		}
+	}
+
+	if !allIP {
+		cluster.Type = xdspb.Cluster_STRICT_DNS
+		cluster.LoadAssignment = cla
+		return cluster, nil
+	}
+
+	cluster.Type = xdspb.Cluster_EDS
+	cluster.EdsClusterConfig = &xdspb.Cluster_EdsClusterConfig{
+		EdsConfig: &xdspb.ConfigSource{
+			Ads:                &xdspb.AggregatedConfigSource{},
+			ResourceApiVersion: xdspb.ConfigSource_V3,
+		},
+		ServiceName: name,
+	}
+	return cluster, cla
+}
+
+func newRouteConfiguration(rc *RouteConfig) *xdspb.RouteConfiguration {
+	config := &xdspb.RouteConfiguration{Name: rc.Name}
+	for _, vh := range rc.VirtualHosts {
+		host := &xdspb.VirtualHost{
+			Name:    vh.Name,
+			Domains: vh.Domains,
+		}
+		for _, r := range vh.Routes {
+			route := &xdspb.Route{
+				Match: &xdspb.RouteMatch{
+					Path:   r.Path,
+					Prefix: r.PathPrefix,
+				},
+				Route: &xdspb.RouteAction{
+					Cluster:       r.Cluster,
+					PrefixRewrite: r.PrefixRewrite,
+				},
+			}
+			if r.PathRegexp != "" {
+				route.Match.SafeRegex = &xdspb.RegexMatcher{Regex: r.PathRegexp}
+			}
+			if r.Timeout != "" {
+				timeout, _ := time.ParseDuration(r.Timeout)
+				route.Route.Timeout = durationPB(timeout)
+			}
+			host.Routes = append(host.Routes, route)
+		}
+		config.VirtualHosts = append(config.VirtualHosts, host)
+	}
+	return config
+}
+
+func durationPB(d time.Duration) *xdspb.Duration {
+	return &xdspb.Duration{
+		Seconds: int64(d / time.Second),
+		Nanos:   int32(d % time.Second),
+	}
+}
+
+func serverPort(server *serviceregistry.Server) uint16 {
+	if server.Port != 0 {
+		return server.Port
+	}
+	if server.Scheme == "https" {
+		return 443
+	}
+	return 80
+}
@@ -0,0 +1,196 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package xdsserver provides XDSServer, which serves the services in the
+// service registries to Envoy by xDS.
+package xdsserver
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Kind is the kind of XDSServer.
+	Kind = "XDSServer"
+)
+
+func init() {
+	supervisor.Register(&XDSServer{})
+}
+
+type (
+	// XDSServer is a minimal control plane of Envoy, it serves the
+	// services in the service registries as clusters (CDS) and their
+	// endpoints (EDS), and the route configurations of the spec (RDS),
+	// by the aggregated discovery service (ADS) of xDS v3.
+	XDSServer struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		server *server
+	}
+
+	// Spec describes the XDSServer.
+	Spec struct {
+		// Port is the port of the gRPC server.
+		Port uint16 `yaml:"port" jsonschema:"required"`
+		// ServiceRegistries are the names of the service registries to
+		// serve, empty means all.
+		ServiceRegistries []string `yaml:"serviceRegistries" jsonschema:"omitempty,uniqueItems=true"`
+		// SyncInterval is the interval to sync the services.
+		SyncInterval string `yaml:"syncInterval" jsonschema:"required,format=duration"`
+		// ConnectTimeout is the timeout of Envoy connecting to endpoints.
+		ConnectTimeout string `yaml:"connectTimeout" jsonschema:"required,format=duration"`
+		// LBPolicy is the load balancing policy of the clusters.
+		LBPolicy string `yaml:"lbPolicy" jsonschema:"omitempty,enum=,enum=roundRobin,enum=leastRequest,enum=random"`
+		// RouteConfigs are the route configurations served by RDS.
+		RouteConfigs []*RouteConfig `yaml:"routeConfigs" jsonschema:"omitempty"`
+	}
+
+	// RouteConfig is a route configuration of Envoy.
+	RouteConfig struct {
+		Name         string         `yaml:"name" jsonschema:"required"`
+		VirtualHosts []*VirtualHost `yaml:"virtualHosts" jsonschema:"required"`
+	}
+
+	// VirtualHost is a virtual host of the route configuration.
+	VirtualHost struct {
+		Name    string   `yaml:"name" jsonschema:"required"`
+		Domains []string `yaml:"domains" jsonschema:"required,minItems=1"`
+		Routes  []*Route `yaml:"routes" jsonschema:"required"`
+	}
+
+	// Route routes the requests matching one of Path, PathPrefix and
+	// PathRegexp to the cluster, which is a service name.
+	Route struct {
+		Path          string `yaml:"path" jsonschema:"omitempty,pattern=^/"`
+		PathPrefix    string `yaml:"pathPrefix" jsonschema:"omitempty,pattern=^/"`
+		PathRegexp    string `yaml:"pathRegexp" jsonschema:"omitempty,format=regexp"`
+		Cluster       string `yaml:"cluster" jsonschema:"required"`
+		PrefixRewrite string `yaml:"prefixRewrite" jsonschema:"omitempty"`
+		Timeout       string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// Status is the status of XDSServer.
+	Status struct {
+		// Streams is the number of connected Envoys.
+		Streams int `yaml:"streams"`
+		// Versions are the versions of the resources by their type URLs.
+		Versions map[string]string `yaml:"versions"`
+		Pushes   uint64            `yaml:"pushes"`
+		NACKs    uint64            `yaml:"nacks"`
+
+		Error string `yaml:"error,omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	names := map[string]struct{}{}
+	for _, rc := range spec.RouteConfigs {
+		if _, exists := names[rc.Name]; exists {
+			return fmt.Errorf("route config %s is duplicated", rc.Name)
+		}
+		names[rc.Name] = struct{}{}
+
+		for _, vh := range rc.VirtualHosts {
+			for i, r := range vh.Routes {
+				if err := r.validate(); err != nil {
+					return fmt.Errorf("route %d of virtual host %s of route config %s: %v",
+						i+1, vh.Name, rc.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Route) validate() error {
+	matchers := 0
+	for _, m := range []string{r.Path, r.PathPrefix, r.PathRegexp} {
+		if m != "" {
+			matchers++
+		}
+	}
+	if matchers != 1 {
+		return fmt.Errorf("exactly one of path, pathPrefix and pathRegexp is required")
+	}
+	if r.PathRegexp != "" {
+		if _, err := regexp.Compile(r.PathRegexp); err != nil {
+			return err
+		}
+	}
+	if r.Timeout != "" {
+		if _, err := time.ParseDuration(r.Timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Category returns the category of XDSServer.
+func (xs *XDSServer) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+
+// Kind returns the kind of XDSServer.
+func (xs *XDSServer) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of XDSServer.
+func (xs *XDSServer) DefaultSpec() interface{} {
+	return &Spec{
+		SyncInterval:   "5s",
+		ConnectTimeout: "5s",
+	}
+}
+
+// Init initializes XDSServer.
+func (xs *XDSServer) Init(superSpec *supervisor.Spec) {
+	xs.superSpec, xs.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	xs.reload()
+}
+
+// Inherit inherits previous generation of XDSServer.
+func (xs *XDSServer) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	// NOTE: Close the previous generation first to release the port.
+	previousGeneration.Close()
+	xs.Init(superSpec)
+}
+
+func (xs *XDSServer) reload() {
+	xs.server = newServer(xs.superSpec.Name(), xs.spec)
+	if err := xs.server.listen(); err != nil {
+		logger.Errorf("%s listen on port %d failed: %v", xs.superSpec.Name(), xs.spec.Port, err)
+	}
+}
+
+// Status returns the status of XDSServer.
+func (xs *XDSServer) Status() *supervisor.Status {
+	return &supervisor.Status{ObjectStatus: xs.server.status()}
+}
+
+// Close closes XDSServer.
+func (xs *XDSServer) Close() {
+	xs.server.close()
+}
@@ -23,6 +23,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -62,26 +63,37 @@ type Options struct {
 	ClusterAdvertiseClientURLs      []string          `yaml:"cluster-advertise-client-urls"`
 	ClusterInitialAdvertisePeerURLs []string          `yaml:"cluster-initial-advertise-peer-urls"`
 	ClusterJoinURLs                 []string          `yaml:"cluster-join-urls"`
+	Standalone                      bool              `yaml:"standalone"`
+	StandaloneStorage               string            `yaml:"standalone-storage"`
 	APIAddr                         string            `yaml:"api-addr"`
+	GRPCAPIAddr                     string            `yaml:"grpc-api-addr"`
+	APISocket                       string            `yaml:"api-socket"`
+	APISocketMode                   string            `yaml:"api-socket-mode"`
 	Debug                           bool              `yaml:"debug"`
 
 	// Path.
-	HomeDir   string `yaml:"home-dir"`
-	DataDir   string `yaml:"data-dir"`
-	WALDir    string `yaml:"wal-dir"`
-	LogDir    string `yaml:"log-dir"`
-	MemberDir string `yaml:"member-dir"`
+	HomeDir           string `yaml:"home-dir"`
+	DataDir           string `yaml:"data-dir"`
+	WALDir            string `yaml:"wal-dir"`
+	LogDir            string `yaml:"log-dir"`
+	MemberDir         string `yaml:"member-dir"`
+	InitialObjectsDir string `yaml:"initial-objects-dir"`
+	ObjectsDir        string `yaml:"objects-dir"`
 
 	// Profile.
 	CPUProfileFile    string `yaml:"cpu-profile-file"`
 	MemoryProfileFile string `yaml:"memory-profile-file"`
 
 	// Prepare the items below in advance.
-	AbsHomeDir   string `yaml:"-"`
-	AbsDataDir   string `yaml:"-"`
-	AbsWALDir    string `yaml:"-"`
-	AbsLogDir    string `yaml:"-"`
-	AbsMemberDir string `yaml:"-"`
+	AbsHomeDir           string `yaml:"-"`
+	AbsDataDir           string `yaml:"-"`
+	AbsWALDir            string `yaml:"-"`
+	AbsLogDir            string `yaml:"-"`
+	AbsMemberDir         string `yaml:"-"`
+	AbsInitialObjectsDir string `yaml:"-"`
+	AbsObjectsDir        string `yaml:"-"`
+	AbsAPISocket         string `yaml:"-"`
+	APISocketFileMode    uint32 `yaml:"-"`
 }
 
 // New creates a default Options.
@@ -107,7 +119,12 @@ func New() *Options {
 	opt.flags.StringSliceVar(&opt.ClusterAdvertiseClientURLs, "cluster-advertise-client-urls", []string{"http://localhost:2379"}, "List of this member’s client URLs to advertise to the rest of the cluster.")
 	opt.flags.StringSliceVar(&opt.ClusterInitialAdvertisePeerURLs, "cluster-initial-advertise-peer-urls", []string{"http://localhost:2380"}, "List of this member’s peer URLs to advertise to the rest of the cluster.")
 	opt.flags.StringSliceVar(&opt.ClusterJoinURLs, "cluster-join-urls", nil, "List of URLs to join, when the first url is the same with any one of cluster-initial-advertise-peer-urls, it means to join itself, and this config will be treated empty.")
+	opt.flags.BoolVar(&opt.Standalone, "standalone", false, "Run as a single member without embedded etcd, all cluster data is kept in memory.")
+	opt.flags.StringVar(&opt.StandaloneStorage, "standalone-storage", "memory", "Storage for cluster data in standalone mode (memory, bbolt), bbolt persists data in the data directory.")
 	opt.flags.StringVar(&opt.APIAddr, "api-addr", "localhost:2381", "Address([host]:port) to listen on for administration traffic.")
+	opt.flags.StringVar(&opt.GRPCAPIAddr, "grpc-api-addr", "", "Address([host]:port) to listen on for gRPC administration traffic, empty means disabled.")
+	opt.flags.StringVar(&opt.APISocket, "api-socket", "", "Path to the Unix domain socket to listen on for administration traffic in addition to api-addr, empty means disabled.")
+	opt.flags.StringVar(&opt.APISocketMode, "api-socket-mode", "0600", "File permissions of the Unix domain socket for administration traffic, in octal.")
 	opt.flags.BoolVar(&opt.Debug, "debug", false, "Flag to set lowest log level from INFO downgrade DEBUG.")
 
 	opt.flags.StringVar(&opt.HomeDir, "home-dir", "./", "Path to the home directory.")
@@ -115,6 +132,8 @@ func New() *Options {
 	opt.flags.StringVar(&opt.WALDir, "wal-dir", "", "Path to the WAL directory.")
 	opt.flags.StringVar(&opt.LogDir, "log-dir", "log", "Path to the log directory.")
 	opt.flags.StringVar(&opt.MemberDir, "member-dir", "member", "Path to the member directory.")
+	opt.flags.StringVar(&opt.ObjectsDir, "objects-dir", "", "Path to the directory of object specs to load in standalone mode, they are reloaded on file change.")
+	opt.flags.StringVar(&opt.InitialObjectsDir, "initial-objects-dir", "", "Path to the directory of object specs to create on the first boot of the cluster.")
 
 	opt.flags.StringVar(&opt.CPUProfileFile, "cpu-profile-file", "", "Path to the CPU profile file.")
 	opt.flags.StringVar(&opt.MemoryProfileFile, "memory-profile-file", "", "Path to the memory profile file.")
@@ -297,6 +316,15 @@ func (opt *Options) validate() error {
 		return fmt.Errorf("invalid cluster-role(support writer, reader)")
 	}
 
+	if opt.ObjectsDir != "" && !opt.Standalone {
+		return fmt.Errorf("objects-dir is only supported in standalone mode")
+	}
+	switch opt.StandaloneStorage {
+	case "memory", "bbolt":
+	default:
+		return fmt.Errorf("invalid standalone-storage(support memory, bbolt)")
+	}
+
 	_, err := time.ParseDuration(opt.ClusterRequestTimeout)
 	if err != nil {
 		return fmt.Errorf("invalid cluster-request-timeout: %v", err)
@@ -311,6 +339,19 @@ func (opt *Options) validate() error {
 		return fmt.Errorf("invalid api-url: %v", err)
 	}
 
+	if opt.GRPCAPIAddr != "" {
+		_, _, err = net.SplitHostPort(opt.GRPCAPIAddr)
+		if err != nil {
+			return fmt.Errorf("invalid grpc-api-addr: %v", err)
+		}
+	}
+
+	mode, err := strconv.ParseUint(opt.APISocketMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return fmt.Errorf("invalid api-socket-mode: %s", opt.APISocketMode)
+	}
+	opt.APISocketFileMode = uint32(mode)
+
 	// dirs
 	if opt.HomeDir == "" {
 		return fmt.Errorf("empty home-dir")
@@ -363,6 +404,9 @@ func (opt *Options) prepare() error {
 		{dir: opt.WALDir, absDir: &opt.AbsWALDir},
 		{dir: opt.LogDir, absDir: &opt.AbsLogDir},
 		{dir: opt.MemberDir, absDir: &opt.AbsMemberDir},
+		{dir: opt.InitialObjectsDir, absDir: &opt.AbsInitialObjectsDir},
+		{dir: opt.ObjectsDir, absDir: &opt.AbsObjectsDir},
+		{dir: opt.APISocket, absDir: &opt.AbsAPISocket},
 	}
 	for _, di := range table {
 		if di.dir == "" {
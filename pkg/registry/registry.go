@@ -15,39 +15,87 @@
  * limitations under the License.
  */
 
+// Package registry imports all filters and objects to register them.
+// The heavy subsystems are in separate files with build tags, so that
+// slimmed binaries could exclude them, e.g. go build -tags nomesh,nofaas.
 package registry
 
 import (
 
 	// Filters
+	_ "github.com/megaease/easegress/pkg/filter/aigatewayproxy"
+	_ "github.com/megaease/easegress/pkg/filter/alsexporter"
+	_ "github.com/megaease/easegress/pkg/filter/analyticssampler"
 	_ "github.com/megaease/easegress/pkg/filter/apiaggregator"
+	_ "github.com/megaease/easegress/pkg/filter/audittrail"
+	_ "github.com/megaease/easegress/pkg/filter/bodylimit"
+	_ "github.com/megaease/easegress/pkg/filter/botdetector"
 	_ "github.com/megaease/easegress/pkg/filter/bridge"
+	_ "github.com/megaease/easegress/pkg/filter/canary"
 	_ "github.com/megaease/easegress/pkg/filter/circuitbreaker"
+	_ "github.com/megaease/easegress/pkg/filter/contentmoderator"
 	_ "github.com/megaease/easegress/pkg/filter/corsadaptor"
+	_ "github.com/megaease/easegress/pkg/filter/deprecationenforcer"
+	_ "github.com/megaease/easegress/pkg/filter/developerportal"
+	_ "github.com/megaease/easegress/pkg/filter/earlyhints"
+	_ "github.com/megaease/easegress/pkg/filter/fairqueue"
 	_ "github.com/megaease/easegress/pkg/filter/fallback"
+	_ "github.com/megaease/easegress/pkg/filter/featureflag"
+	_ "github.com/megaease/easegress/pkg/filter/graphqlgateway"
+	_ "github.com/megaease/easegress/pkg/filter/grpcweb"
+	_ "github.com/megaease/easegress/pkg/filter/htmlrewriter"
+	_ "github.com/megaease/easegress/pkg/filter/imageoptimizer"
+	_ "github.com/megaease/easegress/pkg/filter/iprestriction"
+	_ "github.com/megaease/easegress/pkg/filter/javascript"
 	_ "github.com/megaease/easegress/pkg/filter/mock"
+	_ "github.com/megaease/easegress/pkg/filter/oidc"
+	_ "github.com/megaease/easegress/pkg/filter/piimasker"
+	_ "github.com/megaease/easegress/pkg/filter/planenforcer"
+	_ "github.com/megaease/easegress/pkg/filter/policy"
 	_ "github.com/megaease/easegress/pkg/filter/proxy"
+	_ "github.com/megaease/easegress/pkg/filter/quotaenforcer"
 	_ "github.com/megaease/easegress/pkg/filter/ratelimiter"
 	_ "github.com/megaease/easegress/pkg/filter/remotefilter"
 	_ "github.com/megaease/easegress/pkg/filter/requestadaptor"
+	_ "github.com/megaease/easegress/pkg/filter/requestcoalescer"
+	_ "github.com/megaease/easegress/pkg/filter/requestsigner"
 	_ "github.com/megaease/easegress/pkg/filter/responseadaptor"
+	_ "github.com/megaease/easegress/pkg/filter/responsecache"
 	_ "github.com/megaease/easegress/pkg/filter/retryer"
+	_ "github.com/megaease/easegress/pkg/filter/samlsp"
+	_ "github.com/megaease/easegress/pkg/filter/semanticcache"
+	_ "github.com/megaease/easegress/pkg/filter/session"
+	_ "github.com/megaease/easegress/pkg/filter/shadowcompare"
+	_ "github.com/megaease/easegress/pkg/filter/spnego"
 	_ "github.com/megaease/easegress/pkg/filter/timelimiter"
+	_ "github.com/megaease/easegress/pkg/filter/transform"
 	_ "github.com/megaease/easegress/pkg/filter/validator"
+	_ "github.com/megaease/easegress/pkg/filter/waf"
 	_ "github.com/megaease/easegress/pkg/filter/wasmhost"
 
 	// Objects
+	_ "github.com/megaease/easegress/pkg/object/analyticsexporter"
+	_ "github.com/megaease/easegress/pkg/object/apiproduct"
+	_ "github.com/megaease/easegress/pkg/object/billingexporter"
+	_ "github.com/megaease/easegress/pkg/object/cron"
+	_ "github.com/megaease/easegress/pkg/object/databaseproxy"
+	_ "github.com/megaease/easegress/pkg/object/deprecationpolicy"
+	_ "github.com/megaease/easegress/pkg/object/dnsserver"
 	_ "github.com/megaease/easegress/pkg/object/easemonitormetrics"
-	_ "github.com/megaease/easegress/pkg/object/function"
 	_ "github.com/megaease/easegress/pkg/object/httppipeline"
 	_ "github.com/megaease/easegress/pkg/object/httpserver"
-	_ "github.com/megaease/easegress/pkg/object/ingresscontroller"
-	_ "github.com/megaease/easegress/pkg/object/meshcontroller"
+	_ "github.com/megaease/easegress/pkg/object/quotacontroller"
 	_ "github.com/megaease/easegress/pkg/object/rawconfigtrafficcontroller"
+	_ "github.com/megaease/easegress/pkg/object/redisproxy"
+	_ "github.com/megaease/easegress/pkg/object/routegroup"
 	_ "github.com/megaease/easegress/pkg/object/serviceregistry/consulserviceregistry"
 	_ "github.com/megaease/easegress/pkg/object/serviceregistry/etcdserviceregistry"
 	_ "github.com/megaease/easegress/pkg/object/serviceregistry/eurekaserviceregistry"
 	_ "github.com/megaease/easegress/pkg/object/serviceregistry/zookeeperserviceregistry"
+	_ "github.com/megaease/easegress/pkg/object/sharedstateprovider"
+	_ "github.com/megaease/easegress/pkg/object/statuspage"
 	_ "github.com/megaease/easegress/pkg/object/trafficcontroller"
 	_ "github.com/megaease/easegress/pkg/object/websocketserver"
+	_ "github.com/megaease/easegress/pkg/object/weightadjuster"
+	_ "github.com/megaease/easegress/pkg/object/xdsserver"
 )
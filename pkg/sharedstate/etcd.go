@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sharedstate
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/util/redisclient"
+)
+
+const etcdNamespace = "sharedstate"
+
+// etcdBackend keeps the state in the key-value store of the cluster,
+// which is created on the first use.
+type etcdBackend struct {
+	cls cluster.Cluster
+
+	mutex sync.Mutex
+	store *cluster.KVStore
+}
+
+func newEtcdBackend(cls cluster.Cluster) *etcdBackend {
+	return &etcdBackend{cls: cls}
+}
+
+func (b *etcdBackend) kvStore() (*cluster.KVStore, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.store != nil {
+		return b.store, nil
+	}
+
+	store, err := cluster.NewKVStore(b.cls, etcdNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("create key-value store failed: %v", err)
+	}
+	b.store = store
+	return store, nil
+}
+
+// get gets the value from the local cache of the store, so values
+// written by other members are visible after a synchronization.
+func (b *etcdBackend) get(key string) (string, bool, error) {
+	store, err := b.kvStore()
+	if err != nil {
+		return "", false, err
+	}
+	value, ok := store.Get(key)
+	return value, ok, nil
+}
+
+func (b *etcdBackend) put(key, value string, ttl time.Duration) error {
+	store, err := b.kvStore()
+	if err != nil {
+		return err
+	}
+	return store.Put(key, value, ttl)
+}
+
+func (b *etcdBackend) putIfAbsent(key, value string, ttl time.Duration) (bool, error) {
+	store, err := b.kvStore()
+	if err != nil {
+		return false, err
+	}
+	return store.PutIfAbsent(key, value, ttl)
+}
+
+func (b *etcdBackend) delete(key string) error {
+	store, err := b.kvStore()
+	if err != nil {
+		return err
+	}
+	return store.Delete(key)
+}
+
+func (b *etcdBackend) incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	store, err := b.kvStore()
+	if err != nil {
+		return 0, err
+	}
+	return store.Incr(key, delta, ttl)
+}
+
+func (b *etcdBackend) ping() error {
+	_, err := b.kvStore()
+	return err
+}
+
+func (b *etcdBackend) poolStatus() *redisclient.PoolStatus {
+	return nil
+}
+
+func (b *etcdBackend) close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.store != nil {
+		b.store.Close()
+		b.store = nil
+	}
+}
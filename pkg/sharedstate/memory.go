@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sharedstate
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/redisclient"
+)
+
+const memoryPurgeInterval = time.Minute
+
+type (
+	// memoryBackend keeps the state in the memory of the member.
+	memoryBackend struct {
+		mutex   sync.Mutex
+		entries map[string]*memoryEntry
+		done    chan struct{}
+	}
+
+	memoryEntry struct {
+		value    string
+		expireAt time.Time
+	}
+)
+
+func newMemoryBackend() *memoryBackend {
+	b := &memoryBackend{
+		entries: make(map[string]*memoryEntry),
+		done:    make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func newMemoryEntry(value string, ttl time.Duration) *memoryEntry {
+	e := &memoryEntry{value: value}
+	if ttl > 0 {
+		e.expireAt = time.Now().Add(ttl)
+	}
+	return e
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !e.expireAt.After(now)
+}
+
+func (b *memoryBackend) run() {
+	ticker := time.NewTicker(memoryPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			b.purge()
+		}
+	}
+}
+
+func (b *memoryBackend) purge() {
+	now := time.Now()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for k, e := range b.entries {
+		if e.expired(now) {
+			delete(b.entries, k)
+		}
+	}
+}
+
+// lookup returns the unexpired entry of the key, the caller must
+// hold the lock.
+func (b *memoryBackend) lookup(key string) *memoryEntry {
+	e := b.entries[key]
+	if e == nil || e.expired(time.Now()) {
+		return nil
+	}
+	return e
+}
+
+func (b *memoryBackend) get(key string) (string, bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	e := b.lookup(key)
+	if e == nil {
+		return "", false, nil
+	}
+	return e.value, true, nil
+}
+
+func (b *memoryBackend) put(key, value string, ttl time.Duration) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.entries[key] = newMemoryEntry(value, ttl)
+	return nil
+}
+
+func (b *memoryBackend) putIfAbsent(key, value string, ttl time.Duration) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.lookup(key) != nil {
+		return false, nil
+	}
+	b.entries[key] = newMemoryEntry(value, ttl)
+	return true, nil
+}
+
+func (b *memoryBackend) delete(key string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.entries, key)
+	return nil
+}
+
+func (b *memoryBackend) incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	e := b.lookup(key)
+	if e == nil {
+		e = newMemoryEntry("0", ttl)
+		b.entries[key] = e
+	}
+
+	n, err := strconv.ParseInt(e.value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value of %s is not an integer", key)
+	}
+	n += delta
+	e.value = strconv.FormatInt(n, 10)
+
+	return n, nil
+}
+
+func (b *memoryBackend) ping() error {
+	return nil
+}
+
+func (b *memoryBackend) poolStatus() *redisclient.PoolStatus {
+	return nil
+}
+
+func (b *memoryBackend) close() {
+	close(b.done)
+}
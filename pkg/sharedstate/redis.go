@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sharedstate
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/redisclient"
+)
+
+const defaultKeyPrefix = "easegress:"
+
+// redisBackend keeps the state in a Redis server, all namespaces share
+// the connection pool of one client.
+type redisBackend struct {
+	client    *redisclient.Client
+	keyPrefix string
+}
+
+func newRedisBackend(spec *RedisSpec) (*redisBackend, error) {
+	if spec == nil {
+		return nil, fmt.Errorf("redis is required by provider redis")
+	}
+
+	opts := &redisclient.Options{
+		Address:  spec.Address,
+		Username: spec.Username,
+		Password: spec.Password,
+		DB:       spec.DB,
+		Timeout:  spec.timeout(),
+		PoolSize: spec.PoolSize,
+	}
+	if spec.TLS {
+		opts.TLS = &tls.Config{}
+	}
+
+	keyPrefix := spec.KeyPrefix
+	if keyPrefix == "" {
+		keyPrefix = defaultKeyPrefix
+	}
+
+	return &redisBackend{
+		client:    redisclient.New(opts),
+		keyPrefix: keyPrefix,
+	}, nil
+}
+
+func millis(d time.Duration) string {
+	return strconv.FormatInt(d.Milliseconds(), 10)
+}
+
+func (b *redisBackend) get(key string) (string, bool, error) {
+	value, err := b.client.String("GET", b.keyPrefix+key)
+	if err == redisclient.ErrNil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+func (b *redisBackend) put(key, value string, ttl time.Duration) error {
+	args := []string{"SET", b.keyPrefix + key, value}
+	if ttl > 0 {
+		args = append(args, "PX", millis(ttl))
+	}
+	_, err := b.client.Do(args...)
+	return err
+}
+
+func (b *redisBackend) putIfAbsent(key, value string, ttl time.Duration) (bool, error) {
+	args := []string{"SET", b.keyPrefix + key, value, "NX"}
+	if ttl > 0 {
+		args = append(args, "PX", millis(ttl))
+	}
+	reply, err := b.client.Do(args...)
+	if err != nil {
+		return false, err
+	}
+	// NOTE: The reply is nil if the key exists.
+	return reply != nil, nil
+}
+
+func (b *redisBackend) delete(key string) error {
+	_, err := b.client.Do("DEL", b.keyPrefix+key)
+	return err
+}
+
+func (b *redisBackend) incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	key = b.keyPrefix + key
+	incr := []string{"INCRBY", key, strconv.FormatInt(delta, 10)}
+	if ttl <= 0 {
+		return b.client.Int(incr...)
+	}
+
+	// NOTE: Only the missing key gets the ttl, SET NX creates it with
+	// zero, which is a no-op if it exists.
+	replies, err := b.client.Transaction(
+		[]string{"SET", key, "0", "PX", millis(ttl), "NX"},
+		incr,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if len(replies) != 2 {
+		return 0, fmt.Errorf("unexpected replies %v", replies)
+	}
+
+	switch r := replies[1].(type) {
+	case int64:
+		return r, nil
+	case redisclient.Error:
+		return 0, r
+	default:
+		return 0, fmt.Errorf("unexpected reply %v", replies[1])
+	}
+}
+
+func (b *redisBackend) ping() error {
+	_, err := b.client.Do("PING")
+	return err
+}
+
+func (b *redisBackend) poolStatus() *redisclient.PoolStatus {
+	return b.client.PoolStatus()
+}
+
+func (b *redisBackend) close() {
+	b.client.Close()
+}
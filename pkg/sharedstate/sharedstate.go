@@ -0,0 +1,311 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package sharedstate provides the state shared by all members, like the
+// counters of distributed rate limiting, idempotency keys, sessions and
+// cache entries, on top of a pluggable provider: etcd, Redis or memory.
+package sharedstate
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/util/redisclient"
+)
+
+const (
+	// ProviderEtcd keeps the state in the embedded etcd of the cluster.
+	ProviderEtcd = "etcd"
+	// ProviderRedis keeps the state in a Redis server.
+	ProviderRedis = "redis"
+	// ProviderMemory keeps the state in the memory of every member,
+	// so it's not shared actually, it's for single-member deployments.
+	ProviderMemory = "memory"
+
+	pingInterval = 10 * time.Second
+)
+
+type (
+	// Store is a namespace of the shared state. Stores must not be kept
+	// across operations, as they are invalid after their provider is
+	// replaced, callers get the store by Namespace every time instead.
+	Store interface {
+		// Get gets the value of the key.
+		Get(key string) (string, bool, error)
+		// Put puts the key-value, zero ttl means it never expires.
+		Put(key, value string, ttl time.Duration) error
+		// PutIfAbsent puts the key-value only if the key is missing or
+		// expired, it returns whether the value is put.
+		PutIfAbsent(key, value string, ttl time.Duration) (bool, error)
+		// Delete deletes the key.
+		Delete(key string) error
+		// Incr increases the integer value of the key by delta atomically
+		// and returns the new value. A missing key counts from zero with
+		// the ttl, otherwise the original expire time is kept.
+		Incr(key string, delta int64, ttl time.Duration) (int64, error)
+	}
+
+	// Provider is a provider of the shared state.
+	Provider struct {
+		kind    string
+		backend backend
+		metrics metrics
+		done    chan struct{}
+	}
+
+	// Status is the status of a provider.
+	Status struct {
+		Provider   string `yaml:"provider"`
+		Healthy    bool   `yaml:"healthy"`
+		LastError  string `yaml:"lastError,omitempty"`
+		Operations uint64 `yaml:"operations"`
+		Errors     uint64 `yaml:"errors"`
+		// MeanLatency is the mean latency of all operations.
+		MeanLatency string `yaml:"meanLatency"`
+
+		Pool *redisclient.PoolStatus `yaml:"pool,omitempty"`
+	}
+
+	// backend is the implementation of a provider, the keys are
+	// qualified by namespaces already.
+	backend interface {
+		get(key string) (string, bool, error)
+		put(key, value string, ttl time.Duration) error
+		putIfAbsent(key, value string, ttl time.Duration) (bool, error)
+		delete(key string) error
+		incr(key string, delta int64, ttl time.Duration) (int64, error)
+		// ping checks the health of the backend.
+		ping() error
+		poolStatus() *redisclient.PoolStatus
+		close()
+	}
+
+	metrics struct {
+		operations uint64
+		errors     uint64
+		latency    int64
+
+		mutex     sync.Mutex
+		lastError string
+	}
+
+	store struct {
+		provider  *Provider
+		namespace string
+	}
+)
+
+var (
+	mutex    sync.Mutex
+	provider *Provider
+
+	// defaultProvider is the etcd provider used if no provider is set.
+	defaultProvider *Provider
+)
+
+// New creates a provider by the spec.
+func New(spec *Spec, cls cluster.Cluster) (*Provider, error) {
+	var b backend
+	switch spec.Provider {
+	case ProviderEtcd:
+		if cls == nil {
+			return nil, fmt.Errorf("cluster is unavailable")
+		}
+		b = newEtcdBackend(cls)
+	case ProviderRedis:
+		rb, err := newRedisBackend(spec.Redis)
+		if err != nil {
+			return nil, err
+		}
+		b = rb
+	case ProviderMemory:
+		b = newMemoryBackend()
+	default:
+		return nil, fmt.Errorf("unknown provider %s", spec.Provider)
+	}
+
+	p := &Provider{kind: spec.Provider, backend: b, done: make(chan struct{})}
+	if spec.Provider == ProviderRedis {
+		// NOTE: The health of Redis is unknown if there are no operations,
+		// so it's checked periodically, the health of etcd is reported by
+		// the cluster.
+		go p.run()
+	}
+	return p, nil
+}
+
+// SetProvider sets the provider used by Namespace.
+func SetProvider(p *Provider) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	provider = p
+}
+
+// ResetProvider resets the provider used by Namespace to the default
+// one, if it's still p.
+func ResetProvider(p *Provider) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider == p {
+		provider = nil
+	}
+}
+
+// Namespace returns the store of the namespace, from the provider set by
+// SetProvider, or the etcd of the cluster if there is none.
+func Namespace(cls cluster.Cluster, namespace string) (Store, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return provider.Store(namespace), nil
+	}
+
+	if defaultProvider == nil {
+		if cls == nil {
+			return nil, fmt.Errorf("shared state is unavailable")
+		}
+		defaultProvider = &Provider{
+			kind:    ProviderEtcd,
+			backend: newEtcdBackend(cls),
+			done:    make(chan struct{}),
+		}
+	}
+	return defaultProvider.Store(namespace), nil
+}
+
+func (p *Provider) run() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.Ping()
+		}
+	}
+}
+
+// Store returns the store of the namespace.
+func (p *Provider) Store(namespace string) Store {
+	return &store{provider: p, namespace: namespace}
+}
+
+// Ping checks the health of the provider.
+func (p *Provider) Ping() error {
+	start := time.Now()
+	err := p.backend.ping()
+	p.metrics.record(start, err)
+	return err
+}
+
+// Status returns the status of the provider.
+func (p *Provider) Status() *Status {
+	s := p.metrics.status()
+	s.Provider = p.kind
+	s.Pool = p.backend.poolStatus()
+	return s
+}
+
+// Close closes the provider.
+func (p *Provider) Close() {
+	close(p.done)
+	p.backend.close()
+}
+
+func (s *store) key(key string) string {
+	return s.namespace + "/" + key
+}
+
+func (s *store) Get(key string) (string, bool, error) {
+	start := time.Now()
+	value, ok, err := s.provider.backend.get(s.key(key))
+	s.provider.metrics.record(start, err)
+	return value, ok, err
+}
+
+func (s *store) Put(key, value string, ttl time.Duration) error {
+	start := time.Now()
+	err := s.provider.backend.put(s.key(key), value, ttl)
+	s.provider.metrics.record(start, err)
+	return err
+}
+
+func (s *store) PutIfAbsent(key, value string, ttl time.Duration) (bool, error) {
+	start := time.Now()
+	ok, err := s.provider.backend.putIfAbsent(s.key(key), value, ttl)
+	s.provider.metrics.record(start, err)
+	return ok, err
+}
+
+func (s *store) Delete(key string) error {
+	start := time.Now()
+	err := s.provider.backend.delete(s.key(key))
+	s.provider.metrics.record(start, err)
+	return err
+}
+
+func (s *store) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	start := time.Now()
+	value, err := s.provider.backend.incr(s.key(key), delta, ttl)
+	s.provider.metrics.record(start, err)
+	return value, err
+}
+
+func (m *metrics) record(start time.Time, err error) {
+	atomic.AddUint64(&m.operations, 1)
+	atomic.AddInt64(&m.latency, int64(time.Since(start)))
+
+	if err != nil {
+		atomic.AddUint64(&m.errors, 1)
+	}
+
+	m.mutex.Lock()
+	if err != nil {
+		m.lastError = err.Error()
+	} else {
+		m.lastError = ""
+	}
+	m.mutex.Unlock()
+}
+
+func (m *metrics) status() *Status {
+	s := &Status{
+		Operations: atomic.LoadUint64(&m.operations),
+		Errors:     atomic.LoadUint64(&m.errors),
+	}
+
+	var mean time.Duration
+	if s.Operations > 0 {
+		mean = time.Duration(atomic.LoadInt64(&m.latency) / int64(s.Operations))
+	}
+	s.MeanLatency = mean.Round(time.Microsecond).String()
+
+	m.mutex.Lock()
+	s.LastError = m.lastError
+	m.mutex.Unlock()
+	s.Healthy = s.LastError == ""
+
+	return s
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sharedstate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/redisclient/redistest"
+)
+
+func testStore(t *testing.T, p *Provider) {
+	s := p.Store("test")
+
+	if _, ok, err := s.Get("k"); ok || err != nil {
+		t.Fatalf("get missing key: %v %v", ok, err)
+	}
+
+	if err := s.Put("k", "v", 0); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if v, ok, err := s.Get("k"); !ok || err != nil || v != "v" {
+		t.Fatalf("get: %s %v %v", v, ok, err)
+	}
+
+	if ok, err := s.PutIfAbsent("k", "v2", 0); ok || err != nil {
+		t.Fatalf("put existing key if absent: %v %v", ok, err)
+	}
+	if ok, err := s.PutIfAbsent("k2", "v2", time.Minute); !ok || err != nil {
+		t.Fatalf("put missing key if absent: %v %v", ok, err)
+	}
+
+	// NOTE: Namespaces are isolated.
+	if _, ok, _ := p.Store("other").Get("k"); ok {
+		t.Fatalf("key leaks to other namespace")
+	}
+
+	if n, err := s.Incr("counter", 2, time.Minute); err != nil || n != 2 {
+		t.Fatalf("incr: want 2, got %d %v", n, err)
+	}
+	if n, err := s.Incr("counter", 3, time.Minute); err != nil || n != 5 {
+		t.Fatalf("incr: want 5, got %d %v", n, err)
+	}
+	if v, _, _ := s.Get("counter"); v != "5" {
+		t.Fatalf("counter want 5, got %s", v)
+	}
+
+	if err := s.Delete("k"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, ok, _ := s.Get("k"); ok {
+		t.Fatalf("key is not deleted")
+	}
+
+	status := p.Status()
+	if !status.Healthy || status.Operations == 0 || status.Errors != 0 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestMemoryProvider(t *testing.T) {
+	p, err := New(&Spec{Provider: ProviderMemory}, nil)
+	if err != nil {
+		t.Fatalf("create provider failed: %v", err)
+	}
+	defer p.Close()
+
+	testStore(t, p)
+
+	s := p.Store("test")
+	s.Put("short", "v", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := s.Get("short"); ok {
+		t.Fatalf("key is not expired")
+	}
+	if ok, _ := s.PutIfAbsent("short", "v", 0); !ok {
+		t.Fatalf("expired key is not absent")
+	}
+}
+
+func TestRedisProvider(t *testing.T) {
+	server := redistest.NewServer("secret")
+	defer server.Close()
+
+	spec := &Spec{
+		Provider: ProviderRedis,
+		Redis: &RedisSpec{
+			Address:   server.Addr(),
+			Password:  "secret",
+			KeyPrefix: "eg:",
+		},
+	}
+	p, err := New(spec, nil)
+	if err != nil {
+		t.Fatalf("create provider failed: %v", err)
+	}
+	defer p.Close()
+
+	testStore(t, p)
+
+	if ttl := server.TTL("eg:test/counter"); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("unexpected ttl of counter: %v", ttl)
+	}
+	if status := p.Status(); status.Pool == nil || status.Pool.Size != 10 {
+		t.Fatalf("unexpected pool status %+v", status.Pool)
+	}
+
+	server.Close()
+	if err := p.Ping(); err == nil {
+		t.Fatalf("ping closed server should fail")
+	}
+	if status := p.Status(); status.Healthy || status.LastError == "" {
+		t.Fatalf("provider should be unhealthy")
+	}
+}
+
+func TestProviderRegistry(t *testing.T) {
+	if _, err := Namespace(nil, "test"); err == nil {
+		t.Fatalf("namespace without provider and cluster should fail")
+	}
+
+	p, _ := New(&Spec{Provider: ProviderMemory}, nil)
+	defer p.Close()
+
+	SetProvider(p)
+	s, err := Namespace(nil, "test")
+	if err != nil {
+		t.Fatalf("namespace failed: %v", err)
+	}
+	s.Put("k", "v", 0)
+	if v, _, _ := p.Store("test").Get("k"); v != "v" {
+		t.Fatalf("store is not from the provider")
+	}
+
+	other, _ := New(&Spec{Provider: ProviderMemory}, nil)
+	defer other.Close()
+	ResetProvider(other)
+	if _, err := Namespace(nil, "test"); err != nil {
+		t.Fatalf("provider is reset by others")
+	}
+
+	ResetProvider(p)
+	if _, err := Namespace(nil, "test"); err == nil {
+		t.Fatalf("provider is not reset")
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	if err := (Spec{Provider: ProviderRedis}).Validate(); err == nil {
+		t.Fatalf("redis provider without redis should fail")
+	}
+	if err := (Spec{Provider: ProviderMemory, Redis: &RedisSpec{}}).Validate(); err == nil {
+		t.Fatalf("memory provider with redis should fail")
+	}
+	if err := (Spec{Provider: ProviderEtcd}).Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sharedstate
+
+import (
+	"fmt"
+	"time"
+)
+
+type (
+	// Spec describes the provider of the shared state.
+	Spec struct {
+		Provider string     `yaml:"provider" jsonschema:"required,enum=etcd,enum=redis,enum=memory"`
+		Redis    *RedisSpec `yaml:"redis" jsonschema:"omitempty"`
+	}
+
+	// RedisSpec describes the Redis server.
+	RedisSpec struct {
+		Address  string `yaml:"address" jsonschema:"required"`
+		Username string `yaml:"username" jsonschema:"omitempty"`
+		Password string `yaml:"password" jsonschema:"omitempty"`
+		DB       int    `yaml:"db" jsonschema:"omitempty,minimum=0"`
+		TLS      bool   `yaml:"tls" jsonschema:"omitempty"`
+		// PoolSize is the max number of connections, which are shared
+		// by all namespaces, 10 by default.
+		PoolSize int `yaml:"poolSize" jsonschema:"omitempty,minimum=1"`
+		// KeyPrefix is prepended to all keys, "easegress:" by default.
+		KeyPrefix string `yaml:"keyPrefix" jsonschema:"omitempty"`
+		Timeout   string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+)
+
+// Validate validates the spec.
+func (spec Spec) Validate() error {
+	if spec.Provider == ProviderRedis {
+		if spec.Redis == nil {
+			return fmt.Errorf("redis is required by provider redis")
+		}
+	} else if spec.Redis != nil {
+		return fmt.Errorf("redis is specified for provider %s", spec.Provider)
+	}
+	return nil
+}
+
+func (spec *RedisSpec) timeout() time.Duration {
+	d, _ := time.ParseDuration(spec.Timeout)
+	return d
+}
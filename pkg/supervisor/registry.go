@@ -95,6 +95,13 @@ type (
 		Inherit(superSpec *Spec, previousGeneration Object)
 	}
 
+	// Referrer is the optional interface for object specs which
+	// reference other objects by name, such as the backends of HTTPServer.
+	Referrer interface {
+		// References returns the names of referenced objects.
+		References() []string
+	}
+
 	// ObjectCategory is the type to classify all objects.
 	ObjectCategory string
 )
@@ -148,6 +155,17 @@ func ObjectKinds() []string {
 	return kinds
 }
 
+// GetObjectRegistry returns a copy of the object registry, key is kind.
+func GetObjectRegistry() map[string]Object {
+	result := map[string]Object{}
+
+	for kind, o := range objectRegistry {
+		result[kind] = o
+	}
+
+	return result
+}
+
 // Register registers object.
 func Register(o Object) {
 	if o.Kind() == "" {
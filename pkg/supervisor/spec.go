@@ -148,3 +148,11 @@ func (s *Spec) Equals(other *Spec) bool {
 func (s *Spec) ObjectSpec() interface{} {
 	return s.objectSpec
 }
+
+// References returns the names of objects referenced by the spec.
+func (s *Spec) References() []string {
+	if referrer, ok := s.objectSpec.(Referrer); ok {
+		return referrer.References()
+	}
+	return nil
+}
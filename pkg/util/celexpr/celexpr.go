@@ -0,0 +1,559 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package celexpr implements a subset of the Common Expression Language
+// (https://github.com/google/cel-spec), which is enough for the policies
+// of requests while keeping away from the heavy dependencies.
+//
+// Supported are the literals of null, bool, int, double, string, list and
+// map, the field selections and indexes, the operators of CEL, the has()
+// macro, the functions size(), int(), double(), string() and cidr(), and
+// the methods size(), startsWith(), endsWith(), contains(), matches(),
+// lowerAscii(), upperAscii() and containsIP().
+//
+// Unlike CEL, there is no type checking at compile time, and int and double
+// are compared and calculated with each other, because numbers of JSON are
+// decoded as double.
+package celexpr
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type (
+	// Activation resolves the variables of expressions.
+	Activation interface {
+		Resolve(name string) (interface{}, bool)
+	}
+
+	// Vars is the Activation of a map.
+	Vars map[string]interface{}
+
+	// Object is a value whose fields are resolved on demand, so callers
+	// don't need to convert their data to maps for every evaluation.
+	Object interface {
+		Field(name string) (interface{}, bool)
+	}
+
+	// Program is a compiled expression, it's safe for concurrent use.
+	Program struct {
+		source string
+		root   *node
+	}
+
+	evalFunc func(a Activation) (interface{}, error)
+
+	// function is a function or a method, whose receiver is the first
+	// argument.
+	function struct {
+		arity int
+		fn    func(args []interface{}) (interface{}, error)
+	}
+)
+
+// Resolve resolves the variable by the name.
+func (v Vars) Resolve(name string) (interface{}, bool) {
+	value, ok := v[name]
+	return value, ok
+}
+
+// Compile compiles the expression.
+func Compile(expr string) (*Program, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &Program{source: expr, root: root}, nil
+}
+
+// String returns the source of the program.
+func (p *Program) String() string {
+	return p.source
+}
+
+// Eval evaluates the program.
+func (p *Program) Eval(a Activation) (interface{}, error) {
+	return p.root.eval(a)
+}
+
+// EvalBool evaluates the program whose result must be bool.
+func (p *Program) EvalBool(a Activation) (bool, error) {
+	return evalBool(p.root.eval, a)
+}
+
+// EvalString evaluates the program and converts the result to string,
+// null is converted to an empty string.
+func (p *Program) EvalString(a Activation) (string, error) {
+	v, err := p.root.eval(a)
+	if err != nil || v == nil {
+		return "", err
+	}
+	return toString(v)
+}
+
+func evalBool(fn evalFunc, a Activation) (bool, error) {
+	v, err := fn(a)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected bool, got %s", typeName(v))
+	}
+	return b, nil
+}
+
+// normalize converts the Go values to the ones of expressions.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	}
+	return v
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null_type"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case []interface{}, []string:
+		return "list"
+	case map[string]interface{}, map[string]string:
+		return "map"
+	case *net.IPNet:
+		return "cidr"
+	case Object:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func noOverload(name string, args ...interface{}) error {
+	types := make([]string, len(args))
+	for i, arg := range args {
+		types[i] = typeName(arg)
+	}
+	return fmt.Errorf("no such overload: %s(%s)", name, strings.Join(types, ", "))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func toList(v interface{}) ([]interface{}, bool) {
+	switch v := v.(type) {
+	case []interface{}:
+		return v, true
+	case []string:
+		list := make([]interface{}, len(v))
+		for i, s := range v {
+			list[i] = s
+		}
+		return list, true
+	}
+	return nil, false
+}
+
+func toString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", noOverload("string", v)
+}
+
+func equals(l, r interface{}) bool {
+	if lf, ok := toFloat(l); ok {
+		rf, ok := toFloat(r)
+		return ok && lf == rf
+	}
+
+	switch l := l.(type) {
+	case nil:
+		return r == nil
+	case bool:
+		rb, ok := r.(bool)
+		return ok && l == rb
+	case string:
+		rs, ok := r.(string)
+		return ok && l == rs
+	}
+
+	if ll, ok := toList(l); ok {
+		rl, ok := toList(r)
+		if !ok || len(ll) != len(rl) {
+			return false
+		}
+		for i := range ll {
+			if !equals(ll[i], rl[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(l, r)
+}
+
+func compare(op string, l, r interface{}) (int, error) {
+	if li, ok := l.(int64); ok {
+		if ri, ok := r.(int64); ok {
+			switch {
+			case li < ri:
+				return -1, nil
+			case li > ri:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	if lf, ok := toFloat(l); ok {
+		if rf, ok := toFloat(r); ok {
+			switch {
+			case lf < rf:
+				return -1, nil
+			case lf > rf:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	if ls, ok := l.(string); ok {
+		if rs, ok := r.(string); ok {
+			return strings.Compare(ls, rs), nil
+		}
+	}
+	return 0, noOverload(op, l, r)
+}
+
+func relation(op string, test func(int) bool) func(l, r interface{}) (interface{}, error) {
+	return func(l, r interface{}) (interface{}, error) {
+		c, err := compare(op, l, r)
+		if err != nil {
+			return nil, err
+		}
+		return test(c), nil
+	}
+}
+
+func arithmetic(op string, ints func(l, r int64) (int64, error), floats func(l, r float64) float64) func(l, r interface{}) (interface{}, error) {
+	return func(l, r interface{}) (interface{}, error) {
+		if li, ok := l.(int64); ok {
+			if ri, ok := r.(int64); ok {
+				return ints(li, ri)
+			}
+		}
+		if floats != nil {
+			if lf, ok := toFloat(l); ok {
+				if rf, ok := toFloat(r); ok {
+					return floats(lf, rf), nil
+				}
+			}
+		}
+		return nil, noOverload(op, l, r)
+	}
+}
+
+var binaryOps = map[string]func(l, r interface{}) (interface{}, error){
+	"==": func(l, r interface{}) (interface{}, error) { return equals(l, r), nil },
+	"!=": func(l, r interface{}) (interface{}, error) { return !equals(l, r), nil },
+	"<":  relation("<", func(c int) bool { return c < 0 }),
+	"<=": relation("<=", func(c int) bool { return c <= 0 }),
+	">":  relation(">", func(c int) bool { return c > 0 }),
+	">=": relation(">=", func(c int) bool { return c >= 0 }),
+	"in": in,
+	"[]": func(l, r interface{}) (interface{}, error) { return index(l, r) },
+	"+":  add,
+	"-": arithmetic("-", func(l, r int64) (int64, error) { return l - r, nil },
+		func(l, r float64) float64 { return l - r }),
+	"*": arithmetic("*", func(l, r int64) (int64, error) { return l * r, nil },
+		func(l, r float64) float64 { return l * r }),
+	"/": arithmetic("/", func(l, r int64) (int64, error) {
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	}, func(l, r float64) float64 { return l / r }),
+	"%": arithmetic("%", func(l, r int64) (int64, error) {
+		if r == 0 {
+			return 0, fmt.Errorf("modulus by zero")
+		}
+		return l % r, nil
+	}, nil),
+}
+
+func add(l, r interface{}) (interface{}, error) {
+	if ls, ok := l.(string); ok {
+		if rs, ok := r.(string); ok {
+			return ls + rs, nil
+		}
+	}
+	if ll, ok := toList(l); ok {
+		if rl, ok := toList(r); ok {
+			list := make([]interface{}, 0, len(ll)+len(rl))
+			return append(append(list, ll...), rl...), nil
+		}
+	}
+	return arithmetic("+", func(l, r int64) (int64, error) { return l + r, nil },
+		func(l, r float64) float64 { return l + r })(l, r)
+}
+
+func negate(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case int64:
+		return -v, nil
+	case float64:
+		return -v, nil
+	}
+	return nil, noOverload("-", v)
+}
+
+func in(l, r interface{}) (interface{}, error) {
+	if list, ok := toList(r); ok {
+		for _, elem := range list {
+			if equals(l, elem) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	key, ok := l.(string)
+	if !ok {
+		return nil, noOverload("in", l, r)
+	}
+	return present(r, key)
+}
+
+// present tests the presence of the key of maps and objects.
+func present(v interface{}, key string) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		_, ok := v[key]
+		return ok, nil
+	case map[string]string:
+		_, ok := v[key]
+		return ok, nil
+	case Object:
+		_, ok := v.Field(key)
+		return ok, nil
+	}
+	return nil, fmt.Errorf("%s has no fields", typeName(v))
+}
+
+func index(v, key interface{}) (interface{}, error) {
+	var value interface{}
+	found := false
+
+	switch container := v.(type) {
+	case map[string]interface{}, map[string]string, Object:
+		k, ok := key.(string)
+		if !ok {
+			return nil, noOverload("[]", v, key)
+		}
+		switch container := container.(type) {
+		case map[string]interface{}:
+			value, found = container[k]
+		case map[string]string:
+			value, found = container[k]
+		case Object:
+			value, found = container.Field(k)
+		}
+		if !found {
+			return nil, fmt.Errorf("no such key: %s", k)
+		}
+	case []interface{}, []string:
+		list, _ := toList(container)
+		i, ok := key.(int64)
+		if !ok {
+			f, isFloat := key.(float64)
+			if !isFloat || f != math.Trunc(f) {
+				return nil, noOverload("[]", v, key)
+			}
+			i = int64(f)
+		}
+		if i < 0 || i >= int64(len(list)) {
+			return nil, fmt.Errorf("index out of range: %d", i)
+		}
+		value = list[i]
+	default:
+		return nil, noOverload("[]", v, key)
+	}
+
+	return normalize(value), nil
+}
+
+func size(v interface{}) (int64, error) {
+	switch v := v.(type) {
+	case string:
+		return int64(utf8.RuneCountInString(v)), nil
+	case []interface{}:
+		return int64(len(v)), nil
+	case []string:
+		return int64(len(v)), nil
+	case map[string]interface{}:
+		return int64(len(v)), nil
+	case map[string]string:
+		return int64(len(v)), nil
+	}
+	return 0, noOverload("size", v)
+}
+
+func stringMethod(name string, fn func(s, arg string) interface{}) function {
+	return function{arity: 2, fn: func(args []interface{}) (interface{}, error) {
+		s, ok1 := args[0].(string)
+		arg, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, noOverload(name, args...)
+		}
+		return fn(s, arg), nil
+	}}
+}
+
+func caseMethod(name string, fn func(string) string) function {
+	return function{arity: 1, fn: func(args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, noOverload(name, args...)
+		}
+		return fn(s), nil
+	}}
+}
+
+var sizeFunction = function{arity: 1, fn: func(args []interface{}) (interface{}, error) {
+	return size(args[0])
+}}
+
+var functions = map[string]function{
+	"size": sizeFunction,
+	"int": {arity: 1, fn: func(args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case int64:
+			return v, nil
+		case float64:
+			if math.IsNaN(v) || v >= math.MaxInt64 || v <= math.MinInt64 {
+				return nil, fmt.Errorf("int() out of range")
+			}
+			return int64(v), nil
+		case string:
+			i, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("int() invalid string %q", v)
+			}
+			return i, nil
+		}
+		return nil, noOverload("int", args...)
+	}},
+	"double": {arity: 1, fn: func(args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case int64:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("double() invalid string %q", v)
+			}
+			return f, nil
+		}
+		return nil, noOverload("double", args...)
+	}},
+	"string": {arity: 1, fn: func(args []interface{}) (interface{}, error) {
+		return toString(args[0])
+	}},
+	"cidr": {arity: 1, fn: func(args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, noOverload("cidr", args...)
+		}
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("cidr() invalid string %q", s)
+		}
+		return ipNet, nil
+	}},
+}
+
+var methods = map[string]function{
+	"size":       sizeFunction,
+	"startsWith": stringMethod("startsWith", func(s, arg string) interface{} { return strings.HasPrefix(s, arg) }),
+	"endsWith":   stringMethod("endsWith", func(s, arg string) interface{} { return strings.HasSuffix(s, arg) }),
+	"contains":   stringMethod("contains", func(s, arg string) interface{} { return strings.Contains(s, arg) }),
+	// NOTE: matches() with a constant pattern is compiled by the parser,
+	// this one is for the patterns evaluated at runtime.
+	"matches": {arity: 2, fn: func(args []interface{}) (interface{}, error) {
+		s, ok1 := args[0].(string)
+		pattern, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, noOverload("matches", args...)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
+		return re.MatchString(s), nil
+	}},
+	"lowerAscii": caseMethod("lowerAscii", strings.ToLower),
+	"upperAscii": caseMethod("upperAscii", strings.ToUpper),
+	"containsIP": {arity: 2, fn: func(args []interface{}) (interface{}, error) {
+		ipNet, ok1 := args[0].(*net.IPNet)
+		s, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, noOverload("containsIP", args...)
+		}
+		ip := net.ParseIP(s)
+		return ip != nil && ipNet.Contains(ip), nil
+	}},
+}
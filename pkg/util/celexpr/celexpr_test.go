@@ -0,0 +1,173 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package celexpr
+
+import (
+	"reflect"
+	"testing"
+)
+
+type object map[string]interface{}
+
+func (o object) Field(name string) (interface{}, bool) {
+	v, ok := o[name]
+	return v, ok
+}
+
+func testVars() Vars {
+	return Vars{
+		"request": object{
+			"method":   "GET",
+			"path":     "/admin/users",
+			"clientIP": "10.1.2.3",
+			"headers":  map[string]string{"x-tier": "gold"},
+		},
+		"jwt": map[string]interface{}{
+			"sub":   "alice",
+			"roles": []interface{}{"admin", "dev"},
+			"level": float64(3),
+		},
+		"n":     5,
+		"names": []string{"a", "b"},
+	}
+}
+
+func TestEval(t *testing.T) {
+	cases := []struct {
+		expr   string
+		result interface{}
+	}{
+		{`1 + 2 * 3`, int64(7)},
+		{`(1 + 2) * 3 % 4`, int64(1)},
+		{`7 / 2`, int64(3)},
+		{`7.0 / 2`, 3.5},
+		{`-n + 1`, int64(-4)},
+		{`'a' + "b"`, "ab"},
+		{`'it\'s'`, "it's"},
+		{`[1, 2] + [3]`, []interface{}{int64(1), int64(2), int64(3)}},
+		{`{'a': 1}['a']`, int64(1)},
+		{`null`, nil},
+		{`request.method == 'GET' && request.path.startsWith('/admin')`, true},
+		{`request.headers['x-tier'] in ['gold', 'silver']`, true},
+		{`'admin' in jwt.roles`, true},
+		{`jwt.level == 3 && jwt.level >= 2.5`, true},
+		{`jwt.roles[1]`, "dev"},
+		{`names.size() == size(names)`, true},
+		{`size('你好')`, int64(2)},
+		{`has(jwt.sub) && !has(jwt.email)`, true},
+		{`'sub' in jwt && !('x' in request)`, true},
+		{`has(jwt.email) ? jwt.email : 'none'`, "none"},
+		{`request.path.matches('^/admin/[a-z]+$')`, true},
+		{`request.path.matches('^/' + 'api')`, false},
+		{`request.method.lowerAscii() + jwt.sub.upperAscii()`, "getALICE"},
+		{`cidr('10.0.0.0/8').containsIP(request.clientIP)`, true},
+		{`cidr('192.168.0.0/16').containsIP('bad')`, false},
+		{`int('42') + int(2.9)`, int64(44)},
+		{`string(1.5) + string(true) + string(3)`, "1.5true3"},
+		{`double(1) == 1.0`, true},
+		{`'b' > 'a' && 2 <= 2 && 1 != 2`, true},
+		{`jwt.missing == 'x' || true`, true},
+		{`false && jwt.missing == 'x'`, false},
+		{`names == ['a', 'b']`, true},
+	}
+
+	vars := testVars()
+	for _, c := range cases {
+		p, err := Compile(c.expr)
+		if err != nil {
+			t.Errorf("compile %s failed: %v", c.expr, err)
+			continue
+		}
+		result, err := p.Eval(vars)
+		if err != nil {
+			t.Errorf("eval %s failed: %v", c.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, c.result) {
+			t.Errorf("eval %s: expected %#v, got %#v", c.expr, c.result, result)
+		}
+	}
+}
+
+func TestEvalError(t *testing.T) {
+	cases := []string{
+		`jwt.missing`,
+		`unknown`,
+		`1 / 0`,
+		`'a' + 1`,
+		`jwt.roles[5]`,
+		`jwt.sub < 1`,
+		`!'a'`,
+		`1 ? 2 : 3`,
+		`jwt.missing == 'x' && true`,
+		`request.path.matches(request.method + '(')`,
+		`cidr('bad')`,
+	}
+
+	vars := testVars()
+	for _, expr := range cases {
+		p, err := Compile(expr)
+		if err != nil {
+			t.Errorf("compile %s failed: %v", expr, err)
+			continue
+		}
+		if v, err := p.Eval(vars); err == nil {
+			t.Errorf("eval %s: expected error, got %v", expr, v)
+		}
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	cases := []string{
+		``,
+		`1 +`,
+		`(1`,
+		`a.`,
+		`'abc`,
+		`a # b`,
+		`has(a)`,
+		`foo(1)`,
+		`a.foo()`,
+		`size(1, 2)`,
+		`a.matches('(')`,
+		`1 2`,
+	}
+
+	for _, expr := range cases {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("compile %s: expected error", expr)
+		}
+	}
+}
+
+func TestEvalBoolString(t *testing.T) {
+	vars := testVars()
+
+	p, _ := Compile(`jwt.level`)
+	if _, err := p.EvalBool(vars); err == nil {
+		t.Errorf("expected error for non-bool result")
+	}
+	if s, err := p.EvalString(vars); err != nil || s != "3" {
+		t.Errorf("expected 3, got %s, %v", s, err)
+	}
+
+	p, _ = Compile(`null`)
+	if s, err := p.EvalString(vars); err != nil || s != "" {
+		t.Errorf("expected empty string, got %s, %v", s, err)
+	}
+}
@@ -0,0 +1,687 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package celexpr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type (
+	tokenKind int
+
+	token struct {
+		kind  tokenKind
+		text  string
+		pos   int
+		value interface{}
+	}
+
+	// node is a compiled sub-expression, sel is set if it is a field
+	// selection, which is required by the has() macro.
+	node struct {
+		eval     evalFunc
+		constant bool
+		sel      *selection
+	}
+
+	selection struct {
+		operand evalFunc
+		field   string
+	}
+
+	parser struct {
+		tokens []token
+		pos    int
+	}
+)
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+var twoCharOps = []string{"==", "!=", "<=", ">=", "&&", "||"}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isLetter(c):
+			start := i
+			for i < len(src) && (isLetter(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[start:i], pos: start})
+		case isDigit(c):
+			start := i
+			isFloat := false
+			for i < len(src) && isDigit(src[i]) {
+				i++
+			}
+			if i+1 < len(src) && src[i] == '.' && isDigit(src[i+1]) {
+				isFloat = true
+				i++
+				for i < len(src) && isDigit(src[i]) {
+					i++
+				}
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				isFloat = true
+				i++
+				if i < len(src) && (src[i] == '+' || src[i] == '-') {
+					i++
+				}
+				for i < len(src) && isDigit(src[i]) {
+					i++
+				}
+			}
+			text := src[start:i]
+			var value interface{}
+			var err error
+			if isFloat {
+				value, err = strconv.ParseFloat(text, 64)
+			} else {
+				value, err = strconv.ParseInt(text, 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %s at %d", text, start)
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: text, pos: start, value: value})
+		case c == '\'' || c == '"':
+			s, n, err := scanString(src[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%v at %d", err, i)
+			}
+			tokens = append(tokens, token{kind: tokString, text: src[i : i+n], pos: i, value: s})
+			i += n
+		default:
+			op := ""
+			for _, o := range twoCharOps {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				if !strings.ContainsRune("()[]{}.,?:!<>+-*/%", rune(c)) {
+					return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+				}
+				op = string(c)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// scanString scans the quoted string at the beginning of src, and returns
+// the unquoted string and the length of the quoted one.
+func scanString(src string) (string, int, error) {
+	quote := src[0]
+	var sb strings.Builder
+	for i := 1; i < len(src); i++ {
+		c := src[i]
+		switch c {
+		case quote:
+			return sb.String(), i + 1, nil
+		case '\\':
+			i++
+			if i == len(src) {
+				break
+			}
+			switch src[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case '\\', '\'', '"':
+				sb.WriteByte(src[i])
+			default:
+				return "", 0, fmt.Errorf("invalid escape \\%c", src[i])
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// back moves back the token t returned by next.
+func (p *parser) back(t token) {
+	if t.kind != tokEOF {
+		p.pos--
+	}
+}
+
+func (p *parser) accept(op string) bool {
+	t := p.peek()
+	if t.kind == tokOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+func (p *parser) unexpected() error {
+	t := p.peek()
+	if t.kind == tokEOF {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected %s at %d", t.text, t.pos)
+}
+
+func (p *parser) parse() (*node, error) {
+	n, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.unexpected()
+	}
+	return n, nil
+}
+
+func (p *parser) ternary() (*node, error) {
+	cond, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("?") {
+		return cond, nil
+	}
+
+	t, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	f, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &node{eval: func(a Activation) (interface{}, error) {
+		c, err := evalBool(cond.eval, a)
+		if err != nil {
+			return nil, err
+		}
+		if c {
+			return t.eval(a)
+		}
+		return f.eval(a)
+	}}, nil
+}
+
+func (p *parser) or() (*node, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = &node{eval: logical(l.eval, r.eval, true)}
+	}
+	return l, nil
+}
+
+func (p *parser) and() (*node, error) {
+	l, err := p.relation()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		r, err := p.relation()
+		if err != nil {
+			return nil, err
+		}
+		l = &node{eval: logical(l.eval, r.eval, false)}
+	}
+	return l, nil
+}
+
+// logical returns the evaluation of || if or is true, else the one of &&.
+// Like CEL, an error of one side is ignored if the other side decides the
+// result.
+func logical(l, r evalFunc, or bool) evalFunc {
+	return func(a Activation) (interface{}, error) {
+		lv, lerr := evalBool(l, a)
+		if lerr == nil && lv == or {
+			return or, nil
+		}
+		rv, rerr := evalBool(r, a)
+		if rerr == nil && rv == or {
+			return or, nil
+		}
+		if lerr != nil {
+			return nil, lerr
+		}
+		if rerr != nil {
+			return nil, rerr
+		}
+		return !or, nil
+	}
+}
+
+func (p *parser) relation() (*node, error) {
+	l, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		op := ""
+		switch {
+		case t.kind == tokOp && (t.text == "==" || t.text == "!=" || t.text == "<" ||
+			t.text == "<=" || t.text == ">" || t.text == ">="):
+			op = t.text
+		case t.kind == tokIdent && t.text == "in":
+			op = "in"
+		default:
+			return l, nil
+		}
+		p.next()
+
+		r, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		l = binary(l, r, op)
+	}
+}
+
+func (p *parser) additive() (*node, error) {
+	l, err := p.multiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "+" && t.text != "-") {
+			return l, nil
+		}
+		p.next()
+		r, err := p.multiplicative()
+		if err != nil {
+			return nil, err
+		}
+		l = binary(l, r, t.text)
+	}
+}
+
+func (p *parser) multiplicative() (*node, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "*" && t.text != "/" && t.text != "%") {
+			return l, nil
+		}
+		p.next()
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = binary(l, r, t.text)
+	}
+}
+
+func binary(l, r *node, op string) *node {
+	fn := binaryOps[op]
+	return &node{
+		eval: func(a Activation) (interface{}, error) {
+			lv, err := l.eval(a)
+			if err != nil {
+				return nil, err
+			}
+			rv, err := r.eval(a)
+			if err != nil {
+				return nil, err
+			}
+			return fn(lv, rv)
+		},
+		constant: l.constant && r.constant,
+	}
+}
+
+func (p *parser) unary() (*node, error) {
+	switch {
+	case p.accept("!"):
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &node{eval: func(a Activation) (interface{}, error) {
+			v, err := evalBool(n.eval, a)
+			if err != nil {
+				return nil, err
+			}
+			return !v, nil
+		}, constant: n.constant}, nil
+	case p.accept("-"):
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &node{eval: func(a Activation) (interface{}, error) {
+			v, err := n.eval(a)
+			if err != nil {
+				return nil, err
+			}
+			return negate(v)
+		}, constant: n.constant}, nil
+	default:
+		return p.member()
+	}
+}
+
+func (p *parser) member() (*node, error) {
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			if t.kind != tokIdent {
+				p.back(t)
+				return nil, p.unexpected()
+			}
+			if p.accept("(") {
+				args, err := p.args(")")
+				if err != nil {
+					return nil, err
+				}
+				if n, err = compileMethod(n, t.text, args); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			operand, field := n.eval, t.text
+			n = &node{
+				eval: func(a Activation) (interface{}, error) {
+					v, err := operand(a)
+					if err != nil {
+						return nil, err
+					}
+					return index(v, field)
+				},
+				sel: &selection{operand: operand, field: field},
+			}
+		case p.accept("["):
+			key, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = binary(n, key, "[]")
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) args(end string) ([]*node, error) {
+	var args []*node
+	if p.accept(end) {
+		return args, nil
+	}
+	for {
+		arg, err := p.ternary()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.accept(end) {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) primary() (*node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber, tokString:
+		return constant(t.value), nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return constant(true), nil
+		case "false":
+			return constant(false), nil
+		case "null":
+			return constant(nil), nil
+		}
+		if p.accept("(") {
+			args, err := p.args(")")
+			if err != nil {
+				return nil, err
+			}
+			return compileFunction(t.text, args)
+		}
+		name := t.text
+		return &node{eval: func(a Activation) (interface{}, error) {
+			v, ok := a.Resolve(name)
+			if !ok {
+				return nil, fmt.Errorf("undeclared reference to %s", name)
+			}
+			return normalize(v), nil
+		}}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			n, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			elems, err := p.args("]")
+			if err != nil {
+				return nil, err
+			}
+			return &node{eval: func(a Activation) (interface{}, error) {
+				list := make([]interface{}, len(elems))
+				for i, e := range elems {
+					v, err := e.eval(a)
+					if err != nil {
+						return nil, err
+					}
+					list[i] = v
+				}
+				return list, nil
+			}}, nil
+		case "{":
+			return p.mapLiteral()
+		}
+	}
+	p.back(t)
+	return nil, p.unexpected()
+}
+
+func (p *parser) mapLiteral() (*node, error) {
+	var keys, values []*node
+	for !p.accept("}") {
+		if len(keys) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		k, err := p.ternary()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		v, err := p.ternary()
+		if err != nil {
+			return nil, err
+		}
+		keys, values = append(keys, k), append(values, v)
+	}
+
+	return &node{eval: func(a Activation) (interface{}, error) {
+		m := make(map[string]interface{}, len(keys))
+		for i := range keys {
+			k, err := keys[i].eval(a)
+			if err != nil {
+				return nil, err
+			}
+			s, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("map key must be string, got %s", typeName(k))
+			}
+			if m[s], err = values[i].eval(a); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}}, nil
+}
+
+func constant(v interface{}) *node {
+	return &node{
+		eval:     func(Activation) (interface{}, error) { return v, nil },
+		constant: true,
+	}
+}
+
+// compileFunction compiles the global function call, has() is a macro
+// which tests the presence of the field instead of evaluating it.
+func compileFunction(name string, args []*node) (*node, error) {
+	if name == "has" {
+		if len(args) != 1 || args[0].sel == nil {
+			return nil, fmt.Errorf("has() requires a field selection argument")
+		}
+		sel := args[0].sel
+		return &node{eval: func(a Activation) (interface{}, error) {
+			v, err := sel.operand(a)
+			if err != nil {
+				return nil, err
+			}
+			return present(v, sel.field)
+		}}, nil
+	}
+
+	fn, ok := functions[name]
+	if !ok {
+		return nil, fmt.Errorf("undeclared function %s", name)
+	}
+	return call(fn, name, args)
+}
+
+func compileMethod(receiver *node, name string, args []*node) (*node, error) {
+	fn, ok := methods[name]
+	if !ok {
+		return nil, fmt.Errorf("undeclared method %s", name)
+	}
+
+	// NOTE: The pattern of matches() is compiled only once if it's
+	// a constant.
+	if name == "matches" && len(args) == 1 && args[0].constant {
+		v, _ := args[0].eval(nil)
+		pattern, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("matches() requires a string pattern")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
+		fn = function{arity: 2, fn: func(args []interface{}) (interface{}, error) {
+			s, ok := args[0].(string)
+			if !ok {
+				return nil, noOverload("matches", args[0])
+			}
+			return re.MatchString(s), nil
+		}}
+	}
+
+	return call(fn, name, append([]*node{receiver}, args...))
+}
+
+func call(fn function, name string, args []*node) (*node, error) {
+	if len(args) != fn.arity {
+		return nil, fmt.Errorf("%s() requires %d arguments, got %d", name, fn.arity, len(args))
+	}
+	return &node{eval: func(a Activation) (interface{}, error) {
+		values := make([]interface{}, len(args))
+		for i, arg := range args {
+			v, err := arg.eval(a)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return fn.fn(values)
+	}}, nil
+}
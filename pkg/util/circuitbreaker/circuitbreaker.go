@@ -391,6 +391,33 @@ func (cb *CircuitBreaker) State() State {
 	return cb.state
 }
 
+// RetryAfter returns how long the circuit breaker rejects calls before
+// permitting the probe calls in half open state, the calls are permitted
+// now if it's zero. ok is false if it's unknown: force open, or half open
+// with all of the permitted calls in flight, which decide the next state.
+func (cb *CircuitBreaker) RetryAfter() (d time.Duration, ok bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	switch cb.state {
+	case StateDisabled, StateClosed:
+		return 0, true
+	case StateOpen:
+		d = cb.policy.WaitDurationInOpen - nowFunc().Sub(cb.transitTime)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	case StateHalfOpen:
+		if cb.numberOfCallsInHalfOpen < cb.policy.PermittedNumberOfCallsInHalfOpen {
+			return 0, true
+		}
+		return 0, false
+	}
+
+	return 0, false
+}
+
 // AcquirePermission acquires a permission from the circuit breaker
 // returns true & stateID if the request is permitted
 // returns false & stateID if the request is rejected
@@ -222,3 +222,39 @@ func TestTimeBased(t *testing.T) {
 		t.Errorf("circuit breaker state should be Open")
 	}
 }
+
+func TestRetryAfter(t *testing.T) {
+	policy := NewPolicy(50, 60, CountBased, 10, 2, 10,
+		10*time.Millisecond, 0, 5*time.Second)
+
+	cb := New(policy)
+	if d, ok := cb.RetryAfter(); !ok || d != 0 {
+		t.Errorf("closed circuit breaker should permit calls now")
+	}
+
+	cb.SetState(StateOpen)
+	now = now.Add(2 * time.Second)
+	if d, ok := cb.RetryAfter(); !ok || d != 3*time.Second {
+		t.Errorf("expected 3s, got %v, %v", d, ok)
+	}
+
+	now = now.Add(3 * time.Second)
+	if d, ok := cb.RetryAfter(); !ok || d != 0 {
+		t.Errorf("expected 0, got %v, %v", d, ok)
+	}
+
+	// acquire all permitted calls in half open state
+	for i := 0; i < 2; i++ {
+		if permitted, _ := cb.AcquirePermission(); !permitted {
+			t.Errorf("acquire permission should succeeded, i = %d", i)
+		}
+	}
+	if _, ok := cb.RetryAfter(); ok {
+		t.Errorf("retry after should be unknown in half open state with calls in flight")
+	}
+
+	cb.SetState(StateForceOpen)
+	if _, ok := cb.RetryAfter(); ok {
+		t.Errorf("retry after should be unknown in force open state")
+	}
+}
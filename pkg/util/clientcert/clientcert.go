@@ -0,0 +1,247 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+// Package clientcert extracts the identities of clients from their verified
+// certificates, and matches them against requirements.
+package clientcert
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/util/spiffe"
+)
+
+type (
+	// Identity is the identity in the leaf certificate of a client.
+	Identity struct {
+		Subject    string
+		CommonName string
+		Issuer     string
+		Serial     string
+		SPIFFEID   string
+		DNSNames   []string
+		URIs       []string
+		Emails     []string
+		IPs        []string
+		// Fingerprint is the hex encoded SHA-256 of the certificate.
+		Fingerprint string
+	}
+
+	// Spec describes the requirement of client certificates, a
+	// certificate meets it if it matches any of the values, and any
+	// certificate meets an empty one.
+	Spec struct {
+		// SPIFFEIDs are SPIFFE IDs, an ID ending with /* matches the IDs
+		// under the path.
+		SPIFFEIDs []string `yaml:"spiffeIDs" jsonschema:"omitempty,uniqueItems=true"`
+		// DNSNames are DNS SANs, *.example.com matches the names of one
+		// more label.
+		DNSNames []string `yaml:"dnsNames" jsonschema:"omitempty,uniqueItems=true"`
+		// URIs are URI SANs, an URI ending with * matches the prefix.
+		URIs []string `yaml:"uris" jsonschema:"omitempty,uniqueItems=true"`
+		// Emails are email SANs.
+		Emails []string `yaml:"emails" jsonschema:"omitempty,uniqueItems=true"`
+		// CommonNames are common names of subjects.
+		CommonNames []string `yaml:"commonNames" jsonschema:"omitempty,uniqueItems=true"`
+		// Fingerprints are hex encoded SHA-256 of certificates, colons
+		// between bytes are allowed.
+		Fingerprints []string `yaml:"fingerprints" jsonschema:"omitempty,uniqueItems=true"`
+	}
+
+	// Matcher matches identities by the Spec.
+	Matcher struct {
+		spec         *Spec
+		fingerprints map[string]bool
+	}
+
+	// Headers are the names of headers carrying the identity to
+	// pipelines and backends, a header is not set if its name is empty.
+	// Multiple values are joined by commas.
+	Headers struct {
+		Subject     string `yaml:"subject" jsonschema:"omitempty"`
+		CommonName  string `yaml:"commonName" jsonschema:"omitempty"`
+		Issuer      string `yaml:"issuer" jsonschema:"omitempty"`
+		Serial      string `yaml:"serial" jsonschema:"omitempty"`
+		SPIFFEID    string `yaml:"spiffeID" jsonschema:"omitempty"`
+		DNSNames    string `yaml:"dnsNames" jsonschema:"omitempty"`
+		URIs        string `yaml:"uris" jsonschema:"omitempty"`
+		Emails      string `yaml:"emails" jsonschema:"omitempty"`
+		Fingerprint string `yaml:"fingerprint" jsonschema:"omitempty"`
+	}
+)
+
+// FromTLS returns the identity of the client, or nil if there isn't a
+// client certificate. The certificate must have been verified by the
+// handshake.
+func FromTLS(state *tls.ConnectionState) *Identity {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+
+	cert := state.PeerCertificates[0]
+	fingerprint := sha256.Sum256(cert.Raw)
+	id := &Identity{
+		Subject:     cert.Subject.String(),
+		CommonName:  cert.Subject.CommonName,
+		Issuer:      cert.Issuer.String(),
+		Serial:      cert.SerialNumber.Text(16),
+		SPIFFEID:    spiffe.PeerID(state),
+		DNSNames:    cert.DNSNames,
+		Emails:      cert.EmailAddresses,
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+	}
+	for _, uri := range cert.URIs {
+		id.URIs = append(id.URIs, uri.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		id.IPs = append(id.IPs, ip.String())
+	}
+
+	return id
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.Replace(fingerprint, ":", "", -1))
+}
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if err := spiffe.ValidateIDs(spec.SPIFFEIDs); err != nil {
+		return err
+	}
+
+	for _, f := range spec.Fingerprints {
+		b, err := hex.DecodeString(normalizeFingerprint(f))
+		if err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("invalid SHA-256 fingerprint %s", f)
+		}
+	}
+
+	return nil
+}
+
+// NewMatcher creates a Matcher.
+func NewMatcher(spec *Spec) *Matcher {
+	m := &Matcher{
+		spec:         spec,
+		fingerprints: map[string]bool{},
+	}
+	for _, f := range spec.Fingerprints {
+		m.fingerprints[normalizeFingerprint(f)] = true
+	}
+	return m
+}
+
+func (m *Matcher) empty() bool {
+	s := m.spec
+	return len(s.SPIFFEIDs) == 0 && len(s.DNSNames) == 0 && len(s.URIs) == 0 &&
+		len(s.Emails) == 0 && len(s.CommonNames) == 0 && len(s.Fingerprints) == 0
+}
+
+// Match reports whether the identity meets the requirement, a nil
+// identity never meets it.
+func (m *Matcher) Match(id *Identity) bool {
+	if id == nil {
+		return false
+	}
+	if m.empty() {
+		return true
+	}
+
+	if m.fingerprints[id.Fingerprint] {
+		return true
+	}
+	if spiffe.Match(m.spec.SPIFFEIDs, id.SPIFFEID) {
+		return true
+	}
+	for _, cn := range m.spec.CommonNames {
+		if cn == id.CommonName {
+			return true
+		}
+	}
+	for _, name := range id.DNSNames {
+		for _, pattern := range m.spec.DNSNames {
+			if matchDNSName(pattern, name) {
+				return true
+			}
+		}
+	}
+	for _, uri := range id.URIs {
+		for _, pattern := range m.spec.URIs {
+			if uri == pattern || (strings.HasSuffix(pattern, "*") &&
+				strings.HasPrefix(uri, pattern[:len(pattern)-1])) {
+				return true
+			}
+		}
+	}
+	for _, email := range id.Emails {
+		for _, e := range m.spec.Emails {
+			if strings.EqualFold(e, email) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// matchDNSName matches the name by the pattern, whose leftmost label could
+// be the wildcard *.
+func matchDNSName(pattern, name string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if pattern == name {
+		return true
+	}
+
+	if !strings.HasPrefix(pattern, "*.") {
+		return false
+	}
+	dot := strings.IndexByte(name, '.')
+	return dot > 0 && name[dot:] == pattern[1:]
+}
+
+// Inject sets the headers of the identity, the headers are always deleted
+// first, so clients could not spoof them.
+func (h *Headers) Inject(header http.Header, id *Identity) {
+	set := func(name string, value func() string) {
+		if name == "" {
+			return
+		}
+		header.Del(name)
+		if id == nil {
+			return
+		}
+		if v := value(); v != "" {
+			header.Set(name, v)
+		}
+	}
+
+	set(h.Subject, func() string { return id.Subject })
+	set(h.CommonName, func() string { return id.CommonName })
+	set(h.Issuer, func() string { return id.Issuer })
+	set(h.Serial, func() string { return id.Serial })
+	set(h.SPIFFEID, func() string { return id.SPIFFEID })
+	set(h.DNSNames, func() string { return strings.Join(id.DNSNames, ",") })
+	set(h.URIs, func() string { return strings.Join(id.URIs, ",") })
+	set(h.Emails, func() string { return strings.Join(id.Emails, ",") })
+	set(h.Fingerprint, func() string { return id.Fingerprint })
+}
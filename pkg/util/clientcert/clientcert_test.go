@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package clientcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newCertificate(t *testing.T) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key failed: %v", err)
+	}
+
+	spiffeID, _ := url.Parse("spiffe://example.org/ns/prod/sa/order")
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(0x1f),
+		Subject:        pkix.Name{CommonName: "order", Organization: []string{"MegaEase"}},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		DNSNames:       []string{"order.prod.example.org"},
+		EmailAddresses: []string{"ops@example.org"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:           []*url.URL{spiffeID},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate failed: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate failed: %v", err)
+	}
+	return cert
+}
+
+func TestFromTLS(t *testing.T) {
+	if FromTLS(nil) != nil || FromTLS(&tls.ConnectionState{}) != nil {
+		t.Errorf("identity should be nil without client certificate")
+	}
+
+	cert := newCertificate(t)
+	id := FromTLS(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+	if id.CommonName != "order" || id.Subject != "CN=order,O=MegaEase" || id.Serial != "1f" {
+		t.Errorf("unexpected subject: %+v", id)
+	}
+	if id.SPIFFEID != "spiffe://example.org/ns/prod/sa/order" {
+		t.Errorf("unexpected spiffe id: %s", id.SPIFFEID)
+	}
+	if len(id.IPs) != 1 || id.IPs[0] != "10.0.0.1" || len(id.Fingerprint) != 64 {
+		t.Errorf("unexpected identity: %+v", id)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, spec := range []Spec{
+		{SPIFFEIDs: []string{"example.org/order"}},
+		{Fingerprints: []string{"abcd"}},
+	} {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("spec %+v should be invalid", spec)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cert := newCertificate(t)
+	id := FromTLS(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+
+	fingerprint := ""
+	for i := 0; i < len(id.Fingerprint); i += 2 {
+		if i > 0 {
+			fingerprint += ":"
+		}
+		fingerprint += id.Fingerprint[i : i+2]
+	}
+
+	cases := []struct {
+		spec  Spec
+		match bool
+	}{
+		{Spec{}, true},
+		{Spec{SPIFFEIDs: []string{"spiffe://example.org/ns/prod/*"}}, true},
+		{Spec{SPIFFEIDs: []string{"spiffe://example.org/ns/test/*"}}, false},
+		{Spec{DNSNames: []string{"*.prod.example.org"}}, true},
+		{Spec{DNSNames: []string{"*.example.org"}}, false},
+		{Spec{URIs: []string{"spiffe://example.org/*"}}, true},
+		{Spec{Emails: []string{"OPS@example.org"}}, true},
+		{Spec{CommonNames: []string{"payment", "order"}}, true},
+		{Spec{CommonNames: []string{"payment"}}, false},
+		{Spec{Fingerprints: []string{fingerprint}}, true},
+		{Spec{CommonNames: []string{"payment"}, DNSNames: []string{"order.prod.example.org"}}, true},
+	}
+
+	for i, c := range cases {
+		if err := c.spec.Validate(); err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+		}
+		if m := NewMatcher(&c.spec); m.Match(id) != c.match {
+			t.Errorf("case %d: expect match %v", i, c.match)
+		}
+	}
+
+	if NewMatcher(&Spec{}).Match(nil) {
+		t.Errorf("nil identity should never match")
+	}
+}
+
+func TestInject(t *testing.T) {
+	cert := newCertificate(t)
+	id := FromTLS(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+	h := &Headers{SPIFFEID: "X-Client-Spiffe-Id", DNSNames: "X-Client-Dns", Fingerprint: "X-Client-Fingerprint"}
+
+	header := http.Header{}
+	header.Set("X-Client-Spiffe-Id", "spiffe://example.org/admin")
+	h.Inject(header, id)
+	if header.Get("X-Client-Spiffe-Id") != id.SPIFFEID ||
+		header.Get("X-Client-Dns") != "order.prod.example.org" ||
+		header.Get("X-Client-Fingerprint") != id.Fingerprint {
+		t.Errorf("unexpected headers: %v", header)
+	}
+
+	// spoofed headers are deleted without client certificates
+	header = http.Header{}
+	header.Set("X-Client-Spiffe-Id", "spiffe://example.org/admin")
+	h.Inject(header, nil)
+	if len(header) != 0 {
+		t.Errorf("unexpected headers: %v", header)
+	}
+}
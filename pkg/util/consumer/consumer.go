@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+// Package consumer resolves the identity of the consumer of a request,
+// which is the key of per-consumer features such as auditing and billing.
+package consumer
+
+import (
+	"fmt"
+	"strings"
+
+	jwtgo "github.com/golang-jwt/jwt"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+// Sources of the consumer identity.
+const (
+	SourceHeader   = "Header"
+	SourceCookie   = "Cookie"
+	SourceJwt      = "Jwt"
+	SourceClientIP = "ClientIP"
+	SourceAPIKey   = "APIKey"
+)
+
+// apiKeyLookup returns the consumer owning the API key.
+var apiKeyLookup func(key string) string
+
+type (
+	// Spec describes where to resolve the consumer identity from.
+	Spec struct {
+		Source string `yaml:"source" jsonschema:"required,enum=Header,enum=Cookie,enum=Jwt,enum=ClientIP,enum=APIKey"`
+		// Name is the name of the header, the cookie or the jwt claim,
+		// it's the name of the header carrying the key for APIKey.
+		Name string `yaml:"name" jsonschema:"omitempty"`
+	}
+
+	// Resolver resolves the consumer identity of a request,
+	// it returns an empty string for anonymous requests.
+	Resolver func(ctx context.HTTPContext) string
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if spec.Source != SourceClientIP && spec.Name == "" {
+		return fmt.Errorf("name is required for source %s", spec.Source)
+	}
+	return nil
+}
+
+// RegisterAPIKeyLookup registers the function returning the consumer
+// owning an API key, or an empty string if the key is unknown.
+func RegisterAPIKeyLookup(fn func(key string) string) {
+	apiKeyLookup = fn
+}
+
+// NewResolver creates a Resolver by the spec.
+func NewResolver(spec *Spec) Resolver {
+	name := spec.Name
+
+	switch spec.Source {
+	case SourceHeader:
+		return func(ctx context.HTTPContext) string {
+			return ctx.Request().Header().Get(name)
+		}
+	case SourceCookie:
+		return func(ctx context.HTTPContext) string {
+			c, err := ctx.Request().Cookie(name)
+			if err != nil {
+				return ""
+			}
+			return c.Value
+		}
+	case SourceJwt:
+		return func(ctx context.HTTPContext) string {
+			return jwtClaim(ctx.Request().Header().Get("Authorization"), name)
+		}
+	case SourceAPIKey:
+		return func(ctx context.HTTPContext) string {
+			key := ctx.Request().Header().Get(name)
+			if key == "" || apiKeyLookup == nil {
+				return ""
+			}
+			return apiKeyLookup(key)
+		}
+	default:
+		return func(ctx context.HTTPContext) string {
+			return ctx.Request().RealIP()
+		}
+	}
+}
+
+// jwtClaim returns the string claim of a bearer token without verifying
+// it, the token should have been verified by the Validator filter.
+func jwtClaim(auth, claim string) string {
+	fields := strings.Fields(auth)
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "Bearer") {
+		return ""
+	}
+
+	t, _, err := new(jwtgo.Parser).ParseUnverified(fields[1], jwtgo.MapClaims{})
+	if err != nil {
+		return ""
+	}
+	claims, ok := t.Claims.(jwtgo.MapClaims)
+	if !ok {
+		return ""
+	}
+	v, _ := claims[claim].(string)
+	return v
+}
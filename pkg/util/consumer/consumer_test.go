@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package consumer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jwtgo "github.com/golang-jwt/jwt"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+)
+
+func TestResolver(t *testing.T) {
+	token, _ := jwtgo.NewWithClaims(jwtgo.SigningMethodHS256, jwtgo.MapClaims{"sub": "carol"}).SignedString([]byte("key"))
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Consumer", "alice")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.AddCookie(&http.Cookie{Name: "uid", Value: "bob"})
+
+	ctx := contexttest.NewMockedHTTPContext(req, httptest.NewRecorder())
+
+	cases := []struct {
+		spec     Spec
+		consumer string
+	}{
+		{Spec{Source: SourceHeader, Name: "X-Consumer"}, "alice"},
+		{Spec{Source: SourceCookie, Name: "uid"}, "bob"},
+		{Spec{Source: SourceJwt, Name: "sub"}, "carol"},
+		{Spec{Source: SourceClientIP}, "10.0.0.1"},
+		{Spec{Source: SourceHeader, Name: "X-None"}, ""},
+		{Spec{Source: SourceCookie, Name: "none"}, ""},
+		{Spec{Source: SourceJwt, Name: "none"}, ""},
+		{Spec{Source: SourceAPIKey, Name: "X-Consumer"}, "owner of alice"},
+		{Spec{Source: SourceAPIKey, Name: "X-None"}, ""},
+	}
+
+	RegisterAPIKeyLookup(func(key string) string { return "owner of " + key })
+	defer RegisterAPIKeyLookup(nil)
+
+	for _, c := range cases {
+		if err := c.spec.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if got := NewResolver(&c.spec)(ctx); got != c.consumer {
+			t.Errorf("%+v: want %q, got %q", c.spec, c.consumer, got)
+		}
+	}
+
+	if (Spec{Source: SourceHeader}).Validate() == nil {
+		t.Errorf("name should be required")
+	}
+}
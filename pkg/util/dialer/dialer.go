@@ -0,0 +1,333 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package dialer provides the dialer of upstreams, which connects to
+// dual-stack hosts by the Happy Eyeballs of RFC 8305.
+package dialer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// IPv6First prefers IPv6 addresses, it's recommended by RFC 8305.
+	IPv6First = "ipv6First"
+	// IPv4First prefers IPv4 addresses.
+	IPv4First = "ipv4First"
+	// IPv6Only dials IPv6 addresses only.
+	IPv6Only = "ipv6Only"
+	// IPv4Only dials IPv4 addresses only.
+	IPv4Only = "ipv4Only"
+
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+
+	defaultAttemptDelay = 250 * time.Millisecond
+	defaultTimeout      = 30 * time.Second
+	defaultKeepAlive    = 60 * time.Second
+)
+
+type (
+	// Spec describes the Dialer.
+	Spec struct {
+		// AddressFamily is one of ipv6First, ipv4First, ipv6Only and
+		// ipv4Only, the default is ipv6First.
+		AddressFamily string `yaml:"addressFamily" jsonschema:"omitempty"`
+		// AttemptDelay is the Connection Attempt Delay of RFC 8305, the
+		// next address is tried if the last one doesn't connect in it.
+		AttemptDelay string `yaml:"attemptDelay" jsonschema:"omitempty,format=duration"`
+		Timeout      string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+		// KeepAlive is the interval of TCP keepalive probes, dead
+		// connections dropped by NAT are detected quickly by a short
+		// one, negative values disable the probes.
+		KeepAlive string `yaml:"keepAlive" jsonschema:"omitempty,format=duration"`
+	}
+
+	// Resolver resolves host names to IPs.
+	Resolver interface {
+		LookupIP(ctx context.Context, host string) ([]net.IP, error)
+	}
+
+	// Dialer dials hosts by Happy Eyeballs.
+	Dialer struct {
+		addressFamily string
+		attemptDelay  time.Duration
+		resolver      Resolver
+		dialer        *net.Dialer
+		// dial is replaced in tests.
+		dial func(ctx context.Context, network, address string) (net.Conn, error)
+
+		ipv4 *familyMetrics
+		ipv6 *familyMetrics
+	}
+
+	familyMetrics struct {
+		attempts  uint64
+		failures  uint64
+		connected uint64
+		// latency is the total nanoseconds of connected attempts.
+		latency uint64
+	}
+
+	// Status is the status of Dialer.
+	Status struct {
+		IPv4 *FamilyStatus `yaml:"ipv4"`
+		IPv6 *FamilyStatus `yaml:"ipv6"`
+	}
+
+	// FamilyStatus is the status of an address family.
+	FamilyStatus struct {
+		Attempts uint64 `yaml:"attempts"`
+		Failures uint64 `yaml:"failures"`
+		// Connected is the number of attempts winning the race, the
+		// others are failed or canceled.
+		Connected   uint64 `yaml:"connected"`
+		MeanLatency string `yaml:"meanLatency"`
+	}
+
+	systemResolver struct{}
+
+	attempt struct {
+		ip      net.IP
+		conn    net.Conn
+		err     error
+		latency time.Duration
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	switch spec.AddressFamily {
+	case "", IPv6First, IPv4First, IPv6Only, IPv4Only:
+	default:
+		return fmt.Errorf("invalid addressFamily %s", spec.AddressFamily)
+	}
+	if spec.AttemptDelay != "" {
+		// NOTE: RFC 8305 requires the delay to be at least 10ms.
+		if d, _ := time.ParseDuration(spec.AttemptDelay); d < 10*time.Millisecond {
+			return fmt.Errorf("attemptDelay must be at least 10ms")
+		}
+	}
+	if spec.Timeout != "" {
+		if d, _ := time.ParseDuration(spec.Timeout); d <= 0 {
+			return fmt.Errorf("timeout must be positive")
+		}
+	}
+	if spec.KeepAlive != "" {
+		if d, _ := time.ParseDuration(spec.KeepAlive); d == 0 {
+			return fmt.Errorf("keepAlive must not be zero")
+		}
+	}
+	return nil
+}
+
+func (systemResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips, nil
+}
+
+// New creates a Dialer, the spec must be valid, and the resolver of the
+// system is used if resolver is nil.
+func New(spec *Spec, resolver Resolver) *Dialer {
+	if resolver == nil {
+		resolver = systemResolver{}
+	}
+
+	d := &Dialer{
+		addressFamily: spec.AddressFamily,
+		attemptDelay:  defaultAttemptDelay,
+		resolver:      resolver,
+		dialer: &net.Dialer{
+			Timeout:   defaultTimeout,
+			KeepAlive: defaultKeepAlive,
+		},
+		ipv4: &familyMetrics{},
+		ipv6: &familyMetrics{},
+	}
+	if d.addressFamily == "" {
+		d.addressFamily = IPv6First
+	}
+	if spec.AttemptDelay != "" {
+		d.attemptDelay, _ = time.ParseDuration(spec.AttemptDelay)
+	}
+	if spec.Timeout != "" {
+		d.dialer.Timeout, _ = time.ParseDuration(spec.Timeout)
+	}
+	if spec.KeepAlive != "" {
+		d.dialer.KeepAlive, _ = time.ParseDuration(spec.KeepAlive)
+	}
+	d.dial = d.dialer.DialContext
+	return d
+}
+
+// sort filters the IPs by the address family, and interleaves the
+// families starting with the preferred one, as RFC 8305 section 4.
+func (d *Dialer) sort(ips []net.IP) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	var first, second []net.IP
+	switch d.addressFamily {
+	case IPv4Only:
+		return v4
+	case IPv6Only:
+		return v6
+	case IPv4First:
+		first, second = v4, v6
+	default:
+		first, second = v6, v4
+	}
+
+	sorted := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			sorted = append(sorted, first[i])
+		}
+		if i < len(second) {
+			sorted = append(sorted, second[i])
+		}
+	}
+	return sorted
+}
+
+func (d *Dialer) metrics(ip net.IP) *familyMetrics {
+	if ip.To4() != nil {
+		return d.ipv4
+	}
+	return d.ipv6
+}
+
+// DialContext dials the address, attempts to the IPs of the host are
+// started one by one every attempt delay, or once the last one fails,
+// and the first connected one wins.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else if ips, err = d.resolver.LookupIP(ctx, host); err != nil {
+		return nil, fmt.Errorf("resolve %s failed: %v", host, err)
+	}
+	ips = d.sort(ips)
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no %s address of %s", d.addressFamily, host)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan *attempt, len(ips))
+	start := func(ip net.IP) {
+		atomic.AddUint64(&d.metrics(ip).attempts, 1)
+		go func() {
+			startAt := time.Now()
+			conn, err := d.dial(ctx, network, net.JoinHostPort(ip.String(), port))
+			results <- &attempt{ip: ip, conn: conn, err: err, latency: time.Since(startAt)}
+		}()
+	}
+
+	timer := time.NewTimer(d.attemptDelay)
+	defer timer.Stop()
+
+	start(ips[0])
+	next, pending := 1, 1
+	var lastErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if next < len(ips) && ctx.Err() == nil {
+				start(ips[next])
+				next, pending = next+1, pending+1
+				timer.Reset(d.attemptDelay)
+			}
+		case a := <-results:
+			pending--
+			m := d.metrics(a.ip)
+			if a.err == nil {
+				atomic.AddUint64(&m.connected, 1)
+				atomic.AddUint64(&m.latency, uint64(a.latency))
+				go closeLosers(results, pending)
+				return a.conn, nil
+			}
+			lastErr = a.err
+			// NOTE: Attempts canceled by the caller are not failures.
+			if ctx.Err() != nil {
+				continue
+			}
+			atomic.AddUint64(&m.failures, 1)
+			if next < len(ips) {
+				start(ips[next])
+				next, pending = next+1, pending+1
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(d.attemptDelay)
+			}
+		}
+	}
+	return nil, lastErr
+}
+
+// closeLosers closes the connections of the attempts losing the race.
+func closeLosers(results chan *attempt, pending int) {
+	for ; pending > 0; pending-- {
+		if a := <-results; a.conn != nil {
+			a.conn.Close()
+		}
+	}
+}
+
+func (m *familyMetrics) status() *FamilyStatus {
+	s := &FamilyStatus{
+		Attempts:  atomic.LoadUint64(&m.attempts),
+		Failures:  atomic.LoadUint64(&m.failures),
+		Connected: atomic.LoadUint64(&m.connected),
+	}
+	if s.Connected > 0 {
+		s.MeanLatency = (time.Duration(atomic.LoadUint64(&m.latency) / s.Connected)).String()
+	}
+	return s
+}
+
+// Status returns the status of Dialer.
+func (d *Dialer) Status() *Status {
+	return &Status{IPv4: d.ipv4.status(), IPv6: d.ipv6.status()}
+}
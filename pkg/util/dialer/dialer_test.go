@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dialer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/dnsresolver"
+)
+
+type staticResolver []net.IP
+
+func (r staticResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	return r, nil
+}
+
+func parseIPs(ss ...string) []net.IP {
+	ips := make([]net.IP, 0, len(ss))
+	for _, s := range ss {
+		ips = append(ips, net.ParseIP(s))
+	}
+	return ips
+}
+
+func TestSort(t *testing.T) {
+	ips := parseIPs("10.0.0.1", "10.0.0.2", "10.0.0.3", "2001:db8::1", "2001:db8::2")
+
+	cases := map[string]string{
+		IPv6First: "[2001:db8::1 10.0.0.1 2001:db8::2 10.0.0.2 10.0.0.3]",
+		IPv4First: "[10.0.0.1 2001:db8::1 10.0.0.2 2001:db8::2 10.0.0.3]",
+		IPv6Only:  "[2001:db8::1 2001:db8::2]",
+		IPv4Only:  "[10.0.0.1 10.0.0.2 10.0.0.3]",
+	}
+	for family, want := range cases {
+		d := New(&Spec{AddressFamily: family}, nil)
+		if got := fmt.Sprint(d.sort(ips)); got != want {
+			t.Errorf("%s: want %s, got %s", family, want, got)
+		}
+	}
+
+	if New(&Spec{}, nil).addressFamily != IPv6First {
+		t.Errorf("default address family should be %s", IPv6First)
+	}
+}
+
+func TestHappyEyeballs(t *testing.T) {
+	d := New(&Spec{AttemptDelay: "20ms"},
+		staticResolver(parseIPs("2001:db8::1", "2001:db8::2", "10.0.0.1")))
+
+	// NOTE: The order is 2001:db8::1, 10.0.0.1 and 2001:db8::2, which is
+	// a black hole, refused and connected respectively.
+	closed := make(chan struct{}, 1)
+	d.dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, _ := net.SplitHostPort(address)
+		switch host {
+		case "2001:db8::1":
+			<-ctx.Done()
+			closed <- struct{}{}
+			return nil, ctx.Err()
+		case "10.0.0.1":
+			return nil, fmt.Errorf("connection refused")
+		default:
+			c1, c2 := net.Pipe()
+			c2.Close()
+			return c1, nil
+		}
+	}
+
+	startAt := time.Now()
+	conn, err := d.DialContext(context.Background(), "tcp", "backend:80")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn.Close()
+	if elapsed := time.Since(startAt); elapsed > time.Second {
+		t.Errorf("dialing takes too long: %v", elapsed)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("the losing attempt is not canceled")
+	}
+
+	status := d.Status()
+	if status.IPv6.Attempts != 2 || status.IPv6.Failures != 0 || status.IPv6.Connected != 1 || status.IPv6.MeanLatency == "" {
+		t.Errorf("unexpected ipv6 status %+v", status.IPv6)
+	}
+	if status.IPv4.Attempts != 1 || status.IPv4.Failures != 1 || status.IPv4.Connected != 0 {
+		t.Errorf("unexpected ipv4 status %+v", status.IPv4)
+	}
+}
+
+func TestDialFailure(t *testing.T) {
+	d := New(&Spec{AddressFamily: IPv4Only}, staticResolver(parseIPs("2001:db8::1")))
+	if _, err := d.DialContext(context.Background(), "tcp", "backend:80"); err == nil {
+		t.Errorf("dialing without ipv4 addresses should fail")
+	}
+
+	d = New(&Spec{}, staticResolver(parseIPs("10.0.0.1", "10.0.0.2")))
+	d.dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, fmt.Errorf("connection refused")
+	}
+	if _, err := d.DialContext(context.Background(), "tcp", "backend:80"); err == nil {
+		t.Errorf("dialing should fail")
+	}
+	if status := d.Status(); status.IPv4.Failures != 2 {
+		t.Errorf("unexpected ipv4 status %+v", status.IPv4)
+	}
+}
+
+func TestDialWithResolver(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	r := dnsresolver.New(&dnsresolver.Spec{
+		Hosts: map[string][]string{"backend": {"127.0.0.1"}},
+	})
+	conn, err := New(&Spec{}, r).DialContext(context.Background(), "tcp", net.JoinHostPort("backend", port))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn.Close()
+}
+
+func TestSpecValidate(t *testing.T) {
+	invalid := []Spec{
+		{AddressFamily: "ipv5"},
+		{AttemptDelay: "1ms"},
+		{Timeout: "-1s"},
+		{KeepAlive: "0s"},
+	}
+	for i, spec := range invalid {
+		if spec.Validate() == nil {
+			t.Errorf("spec %d should be invalid", i)
+		}
+	}
+
+	spec := Spec{AddressFamily: IPv4First, AttemptDelay: "100ms", Timeout: "10s", KeepAlive: "-1s"}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package dnsresolver provides resolvers of custom DNS servers, search
+// domains and hosts overrides, for split-horizon DNS environments where
+// the gateway resolves upstreams differently from its host, the resolvers
+// are used by the dialer package to connect upstreams.
+package dnsresolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+	defaultPort    = "53"
+)
+
+type (
+	// Spec describes the Resolver.
+	Spec struct {
+		// Hosts maps host names to IPs like /etc/hosts, which takes
+		// precedence over DNS servers.
+		Hosts map[string][]string `yaml:"hosts" jsonschema:"omitempty"`
+		// Servers are the addresses of DNS servers, port 53 is used if
+		// it's omitted, the servers of the system are used if it's empty.
+		Servers []string `yaml:"servers" jsonschema:"omitempty,uniqueItems=true"`
+		// SearchDomains are appended to host names without dots in order.
+		SearchDomains []string `yaml:"searchDomains" jsonschema:"omitempty,uniqueItems=true"`
+		Timeout       string   `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// Resolver resolves host names by the spec.
+	Resolver struct {
+		hosts         map[string][]net.IP
+		servers       []string
+		searchDomains []string
+		timeout       time.Duration
+
+		resolver *net.Resolver
+		// next is the index of the server for the next query.
+		next uint32
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	for host, ips := range spec.Hosts {
+		if len(ips) == 0 {
+			return fmt.Errorf("no IPs of host %s", host)
+		}
+		for _, ip := range ips {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("invalid IP %s of host %s", ip, host)
+			}
+		}
+	}
+
+	for _, server := range spec.Servers {
+		host, _, err := net.SplitHostPort(serverAddress(server))
+		if err != nil || net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid DNS server %s", server)
+		}
+	}
+
+	for _, domain := range spec.SearchDomains {
+		if domain == "" || strings.HasPrefix(domain, ".") {
+			return fmt.Errorf("invalid search domain %q", domain)
+		}
+	}
+
+	if spec.Timeout != "" {
+		if d, _ := time.ParseDuration(spec.Timeout); d <= 0 {
+			return fmt.Errorf("timeout must be positive")
+		}
+	}
+	return nil
+}
+
+// serverAddress adds the default port to the server if it's omitted.
+func serverAddress(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), defaultPort)
+}
+
+func canonicalName(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// New creates a Resolver, the spec must be valid.
+func New(spec *Spec) *Resolver {
+	r := &Resolver{
+		hosts:         map[string][]net.IP{},
+		searchDomains: spec.SearchDomains,
+		timeout:       defaultTimeout,
+	}
+	if spec.Timeout != "" {
+		r.timeout, _ = time.ParseDuration(spec.Timeout)
+	}
+
+	for host, ips := range spec.Hosts {
+		for _, ip := range ips {
+			r.hosts[canonicalName(host)] = append(r.hosts[canonicalName(host)], net.ParseIP(ip))
+		}
+	}
+
+	for _, server := range spec.Servers {
+		r.servers = append(r.servers, serverAddress(server))
+	}
+
+	r.resolver = &net.Resolver{PreferGo: true}
+	if len(r.servers) > 0 {
+		r.resolver.Dial = r.dialServer
+	}
+	return r
+}
+
+// dialServer dials the DNS servers in turn instead of the system ones.
+func (r *Resolver) dialServer(ctx context.Context, network, _ string) (net.Conn, error) {
+	i := atomic.AddUint32(&r.next, 1)
+	d := net.Dialer{Timeout: r.timeout}
+	return d.DialContext(ctx, network, r.servers[int(i)%len(r.servers)])
+}
+
+// candidates returns the names to resolve of the host in order.
+func (r *Resolver) candidates(host string) []string {
+	// NOTE: Like ndots:1 of resolv.conf, names with dots are absolute.
+	if strings.Contains(strings.TrimSuffix(host, "."), ".") || strings.HasSuffix(host, ".") {
+		return []string{canonicalName(host)}
+	}
+
+	names := make([]string, 0, len(r.searchDomains)+1)
+	for _, domain := range r.searchDomains {
+		names = append(names, canonicalName(host+"."+domain))
+	}
+	return append(names, canonicalName(host))
+}
+
+// LookupIP looks up the IPs of the host, the hosts overrides take
+// precedence over the DNS servers for every candidate name.
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	var lastErr error
+	for _, name := range r.candidates(host) {
+		if ips, ok := r.hosts[name]; ok {
+			return ips, nil
+		}
+
+		// NOTE: The trailing dot stops the search domains of the system.
+		addrs, err := r.resolver.LookupIPAddr(ctx, name+".")
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ips := make([]net.IP, 0, len(addrs))
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+		return ips, nil
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dnsresolver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveDNS serves A records of the names until the connection is closed.
+func serveDNS(t *testing.T, records map[string]string) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var req dnsmessage.Message
+			if req.Unpack(buf[:n]) != nil || len(req.Questions) != 1 {
+				continue
+			}
+			q := req.Questions[0]
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: req.ID, Response: true, RCode: dnsmessage.RCodeNameError},
+				Questions: req.Questions,
+			}
+			if ip, ok := records[q.Name.String()]; ok {
+				resp.RCode = dnsmessage.RCodeSuccess
+				if q.Type == dnsmessage.TypeA {
+					a := dnsmessage.AResource{}
+					copy(a.A[:], net.ParseIP(ip).To4())
+					resp.Answers = []dnsmessage.Resource{{
+						Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+						Body:   &a,
+					}}
+				}
+			}
+			b, _ := resp.Pack()
+			conn.WriteTo(b, addr)
+		}
+	}()
+
+	return conn
+}
+
+func TestLookupIP(t *testing.T) {
+	conn := serveDNS(t, map[string]string{
+		"api.corp.test.":    "10.0.0.1",
+		"api.example.test.": "10.0.0.2",
+	})
+	defer conn.Close()
+
+	spec := &Spec{
+		Hosts: map[string][]string{
+			"db.corp.test":      {"10.0.1.1", "10.0.1.2"},
+			"API.example.test.": {"10.0.2.1"},
+		},
+		Servers:       []string{conn.LocalAddr().String()},
+		SearchDomains: []string{"corp.test", "example.test"},
+		Timeout:       "1s",
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := New(spec)
+
+	cases := map[string]string{
+		"api":              "10.0.0.1",
+		"db":               "10.0.1.1",
+		"api.example.test": "10.0.2.1",
+		"api.corp.test.":   "10.0.0.1",
+		"192.168.1.1":      "192.168.1.1",
+	}
+	for host, want := range cases {
+		ips, err := r.LookupIP(context.Background(), host)
+		if err != nil {
+			t.Fatalf("lookup %s failed: %v", host, err)
+		}
+		if ips[0].String() != want {
+			t.Errorf("lookup %s: want %s, got %v", host, want, ips)
+		}
+	}
+
+	if _, err := r.LookupIP(context.Background(), "unknown"); err == nil {
+		t.Errorf("lookup unknown host should fail")
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	invalid := []Spec{
+		{Hosts: map[string][]string{"backend": {}}},
+		{Hosts: map[string][]string{"backend": {"backend.local"}}},
+		{Servers: []string{"dns.local"}},
+		{SearchDomains: []string{".corp.test"}},
+		{Timeout: "-1s"},
+	}
+	for i, spec := range invalid {
+		if spec.Validate() == nil {
+			t.Errorf("spec %d should be invalid", i)
+		}
+	}
+
+	spec := Spec{Servers: []string{"10.0.0.53", "10.0.0.54:5353", "[::1]:53", "::1"}}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package earlydata handles requests sent in TLS early data (0-RTT), which
+// are marked by the Early-Data header of RFC 8470 by TLS terminating proxies
+// before the gateway.
+package earlydata
+
+import (
+	"net/http"
+
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+// Is returns whether the request is marked as sent in early data, other
+// values than "1" are ignored by RFC 8470.
+func Is(header http.Header) bool {
+	for _, value := range header.Values(httpheader.KeyEarlyData) {
+		if value == "1" {
+			return true
+		}
+	}
+	return false
+}
+
+// Check reports whether the request could be handled, the requests in
+// early data of non-idempotent methods are rejected by 425 Too Early
+// unless allowed, as early data could be replayed. The accepted ones are
+// marked by exactly "Early-Data: 1", so that the upstreams know it.
+func Check(method string, header http.Header, allow bool) bool {
+	if !Is(header) {
+		return true
+	}
+	if !allow && !IsIdempotent(method) {
+		return false
+	}
+
+	// NOTE: Reference: https://www.rfc-editor.org/rfc/rfc8470#section-5.1
+	// An intermediary that forwards a request received in early data MUST
+	// send it with the Early-Data header field set to "1".
+	header.Set(httpheader.KeyEarlyData, "1")
+	return true
+}
+
+// IsIdempotent returns whether the method is idempotent by RFC 7231, such
+// requests are safe to be replayed.
+func IsIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
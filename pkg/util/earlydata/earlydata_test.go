@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package earlydata
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIs(t *testing.T) {
+	cases := []struct {
+		values []string
+		want   bool
+	}{
+		{nil, false},
+		{[]string{"0"}, false},
+		{[]string{"1"}, true},
+		{[]string{"yes", "1"}, true},
+	}
+	for _, c := range cases {
+		header := http.Header{"Early-Data": c.values}
+		if got := Is(header); got != c.want {
+			t.Errorf("Is(%v) = %v, want %v", c.values, got, c.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if !Check(http.MethodPost, http.Header{}, false) {
+		t.Errorf("requests not in early data should be accepted")
+	}
+
+	header := http.Header{"Early-Data": []string{"1"}}
+	if Check(http.MethodPost, header, false) {
+		t.Errorf("POST in early data should be rejected")
+	}
+	if Check(http.MethodPatch, header, false) {
+		t.Errorf("PATCH in early data should be rejected")
+	}
+
+	header = http.Header{"Early-Data": []string{"yes", "1"}}
+	if !Check(http.MethodGet, header, false) {
+		t.Errorf("GET in early data should be accepted")
+	}
+	if values := header.Values("Early-Data"); len(values) != 1 || values[0] != "1" {
+		t.Errorf("the upstream should get Early-Data: 1, got %v", values)
+	}
+
+	header = http.Header{"Early-Data": []string{"1"}}
+	if !Check(http.MethodPost, header, true) || header.Get("Early-Data") != "1" {
+		t.Errorf("allowed POST in early data should be accepted and marked")
+	}
+}
+
+func TestIsIdempotent(t *testing.T) {
+	for _, m := range []string{http.MethodGet, http.MethodHead, http.MethodOptions,
+		http.MethodTrace, http.MethodPut, http.MethodDelete} {
+		if !IsIdempotent(m) {
+			t.Errorf("%s should be idempotent", m)
+		}
+	}
+	for _, m := range []string{http.MethodPost, http.MethodPatch, http.MethodConnect} {
+		if IsIdempotent(m) {
+			t.Errorf("%s should not be idempotent", m)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+// Package geoip looks up the geolocations and the autonomous systems of IPs
+// in the MaxMind DB files, e.g. GeoLite2-Country, GeoLite2-City and
+// GeoLite2-ASN.
+//
+// See https://maxmind.github.io/MaxMind-DB/ for the file format.
+package geoip
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+)
+
+// metadataMarker starts the metadata section at the end of the file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+const dataSectionSeparator = 16
+
+// Data types of the data section.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+type (
+	// Reader reads a MaxMind DB file.
+	Reader struct {
+		buf         []byte
+		data        []byte
+		nodeCount   uint
+		recordSize  uint
+		ipVersion   uint
+		ipv4Start   uint
+		dbType      string
+		buildEpoch  uint64
+		treeSize    uint
+		nodeByteLen uint
+	}
+
+	// Record is the geolocation and the autonomous system of an IP, the
+	// fields not in the database are empty.
+	Record struct {
+		Country        string
+		Continent      string
+		City           string
+		ASN            uint
+		ASOrganization string
+	}
+)
+
+// Open reads the MaxMind DB file.
+func Open(path string) (*Reader, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(buf)
+}
+
+// FromBytes creates a Reader of the MaxMind DB in buf.
+func FromBytes(buf []byte) (*Reader, error) {
+	i := bytes.LastIndex(buf, metadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("invalid MaxMind DB: metadata not found")
+	}
+
+	d := decoder{buf: buf[i+len(metadataMarker):]}
+	v, _, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxMind DB metadata: %v", err)
+	}
+	metadata, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid MaxMind DB metadata: not a map")
+	}
+
+	r := &Reader{buf: buf}
+	r.nodeCount = uint(toUint(metadata["node_count"]))
+	r.recordSize = uint(toUint(metadata["record_size"]))
+	r.ipVersion = uint(toUint(metadata["ip_version"]))
+	r.dbType, _ = metadata["database_type"].(string)
+	r.buildEpoch = toUint(metadata["build_epoch"])
+
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("invalid MaxMind DB: unsupported record size %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("invalid MaxMind DB: unsupported ip version %d", r.ipVersion)
+	}
+
+	r.nodeByteLen = r.recordSize / 4
+	r.treeSize = r.nodeCount * r.nodeByteLen
+	if r.treeSize+dataSectionSeparator > uint(i) {
+		return nil, fmt.Errorf("invalid MaxMind DB: search tree exceeds the file")
+	}
+	r.data = buf[r.treeSize+dataSectionSeparator : i]
+
+	// NOTE: IPv4 addresses are in the ::/96 subnet of IPv6 databases.
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.readRecord(node, 0)
+		}
+		r.ipv4Start = node
+	}
+
+	return r, nil
+}
+
+// DatabaseType returns the type of the database, e.g. GeoLite2-Country.
+func (r *Reader) DatabaseType() string {
+	return r.dbType
+}
+
+// BuildEpoch returns the build time of the database in Unix seconds.
+func (r *Reader) BuildEpoch() uint64 {
+	return r.buildEpoch
+}
+
+func (r *Reader) readRecord(node, bit uint) uint {
+	b := r.buf[node*r.nodeByteLen:]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		b = b[bit*4:]
+		return uint(b[0])<<24 | uint(b[1])<<16 | uint(b[2])<<8 | uint(b[3])
+	}
+}
+
+// LookupData returns the data of the IP, it returns nil if the IP is not
+// in the database.
+func (r *Reader) LookupData(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if r.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < bits && node < r.nodeCount; i++ {
+		bit := uint(ip[i>>3]>>(7-uint(i&7))) & 1
+		node = r.readRecord(node, bit)
+	}
+
+	switch {
+	case node == r.nodeCount:
+		return nil, nil
+	case node < r.nodeCount:
+		return nil, fmt.Errorf("invalid MaxMind DB: search tree is deeper than the IP")
+	}
+
+	offset := node - r.nodeCount - dataSectionSeparator
+	if offset >= uint(len(r.data)) {
+		return nil, fmt.Errorf("invalid MaxMind DB: data offset %d exceeds the data section", offset)
+	}
+
+	d := decoder{buf: r.data}
+	v, _, err := d.decode(offset)
+	return v, err
+}
+
+// Lookup returns the record of the IP, it returns nil if the IP is not in
+// the database.
+func (r *Reader) Lookup(ip net.IP) (*Record, error) {
+	v, err := r.LookupData(ip)
+	if err != nil || v == nil {
+		return nil, err
+	}
+
+	m, _ := v.(map[string]interface{})
+	record := &Record{}
+	record.Country, _ = path(m, "country", "iso_code").(string)
+	if record.Country == "" {
+		record.Country, _ = path(m, "registered_country", "iso_code").(string)
+	}
+	record.Continent, _ = path(m, "continent", "code").(string)
+	record.City, _ = path(m, "city", "names", "en").(string)
+	record.ASN = uint(toUint(m["autonomous_system_number"]))
+	record.ASOrganization, _ = m["autonomous_system_organization"].(string)
+	return record, nil
+}
+
+// Merge fills the empty fields of the record by the other one, which is
+// from another database.
+func (r *Record) Merge(other *Record) {
+	if other == nil {
+		return
+	}
+	if r.Country == "" {
+		r.Country = other.Country
+	}
+	if r.Continent == "" {
+		r.Continent = other.Continent
+	}
+	if r.City == "" {
+		r.City = other.City
+	}
+	if r.ASN == 0 {
+		r.ASN = other.ASN
+	}
+	if r.ASOrganization == "" {
+		r.ASOrganization = other.ASOrganization
+	}
+}
+
+func path(m map[string]interface{}, keys ...string) interface{} {
+	var v interface{} = m
+	for _, key := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+func toUint(v interface{}) uint64 {
+	switch v := v.(type) {
+	case uint64:
+		return v
+	case int64:
+		if v > 0 {
+			return uint64(v)
+		}
+	}
+	return 0
+}
+
+// decoder decodes the data section, the pointers are offsets in buf.
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) bytes(offset, size uint) ([]byte, error) {
+	if offset+size > uint(len(d.buf)) || offset+size < offset {
+		return nil, fmt.Errorf("unexpected end of data at %d", offset)
+	}
+	return d.buf[offset : offset+size], nil
+}
+
+func uintOf(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// decode decodes the value at offset, and returns the offset after it.
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	b, err := d.bytes(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	offset++
+
+	typ := uint(ctrl >> 5)
+	if typ == typePointer {
+		return d.decodePointer(ctrl, offset)
+	}
+	if typ == typeExtended {
+		b, err := d.bytes(offset, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + uint(b[0])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 && typ != typeBool {
+		n := size - 28
+		b, err := d.bytes(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + uint(uintOf(b))
+		case 2:
+			size = 285 + uint(uintOf(b))
+		default:
+			size = 65821 + uint(uintOf(b))
+		}
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key at %d is not a string", offset)
+			}
+			v, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	b, err = d.bytes(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += size
+
+	switch typ {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return append([]byte(nil), b...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size %d", size)
+		}
+		return math.Float64frombits(uintOf(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size %d", size)
+		}
+		return float64(math.Float32frombits(uint32(uintOf(b)))), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		return uintOf(b), offset, nil
+	case typeInt32:
+		return int64(int32(uint32(uintOf(b)))), offset, nil
+	case typeUint128:
+		return new(big.Int).SetBytes(b), offset, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported data type %d at %d", typ, offset)
+	}
+}
+
+func (d *decoder) decodePointer(ctrl byte, offset uint) (interface{}, uint, error) {
+	n := uint(ctrl>>3)&0x3 + 1
+	b, err := d.bytes(offset, n)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var pointer uint
+	vvv := uint(ctrl & 0x7)
+	switch n {
+	case 1:
+		pointer = vvv<<8 | uint(uintOf(b))
+	case 2:
+		pointer = (vvv<<16 | uint(uintOf(b))) + 2048
+	case 3:
+		pointer = (vvv<<24 | uint(uintOf(b))) + 526336
+	default:
+		pointer = uint(uintOf(b))
+	}
+
+	// NOTE: The value after the pointer follows the pointer itself, not
+	// the value it points to.
+	v, _, err := d.decode(pointer)
+	return v, offset + n, err
+}
@@ -0,0 +1,274 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package geoip
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"testing"
+)
+
+// pointer is encoded as a pointer to the offset in the data section, which
+// must be less than 2048.
+type pointer uint
+
+func encodeUint(v uint64) []byte {
+	var b []byte
+	for ; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	return b
+}
+
+func encodeHeader(typ int, size int) []byte {
+	var ctrl []byte
+	var ext []byte
+	if typ > 7 {
+		ext = []byte{byte(typ - 7)}
+		typ = 0
+	}
+	switch {
+	case size < 29:
+		ctrl = []byte{byte(typ<<5 | size)}
+	case size < 285:
+		ctrl = []byte{byte(typ<<5 | 29), byte(size - 29)}
+	default:
+		ctrl = []byte{byte(typ<<5 | 30), byte((size - 285) >> 8), byte(size - 285)}
+	}
+	return append(append(ctrl[:1:1], ext...), ctrl[1:]...)
+}
+
+func encode(v interface{}) []byte {
+	switch v := v.(type) {
+	case pointer:
+		return []byte{byte(typePointer<<5 | int(v)>>8), byte(v)}
+	case string:
+		return append(encodeHeader(typeString, len(v)), v...)
+	case int:
+		b := encodeUint(uint64(v))
+		return append(encodeHeader(typeUint32, len(b)), b...)
+	case bool:
+		if v {
+			return encodeHeader(typeBool, 1)
+		}
+		return encodeHeader(typeBool, 0)
+	case []interface{}:
+		b := encodeHeader(typeArray, len(v))
+		for _, e := range v {
+			b = append(b, encode(e)...)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b := encodeHeader(typeMap, len(v))
+		for _, k := range keys {
+			b = append(b, encode(k)...)
+			b = append(b, encode(v[k])...)
+		}
+		return b
+	}
+	panic("unsupported type")
+}
+
+// builder builds a MaxMind DB.
+type builder struct {
+	ipVersion  int
+	recordSize int
+	// nodes are the records, a negative one is the data offset minus 1,
+	// and zero means empty.
+	nodes [][2]int
+	data  []byte
+}
+
+func newBuilder(ipVersion, recordSize int) *builder {
+	return &builder{ipVersion: ipVersion, recordSize: recordSize, nodes: [][2]int{{}}}
+}
+
+func (b *builder) addData(v interface{}) int {
+	offset := len(b.data)
+	b.data = append(b.data, encode(v)...)
+	return offset
+}
+
+func (b *builder) insert(cidr string, offset int) {
+	ip, ipNet, _ := net.ParseCIDR(cidr)
+	ones, _ := ipNet.Mask.Size()
+	if ip4 := ip.To4(); ip4 != nil && b.ipVersion == 6 {
+		ip, ones = ip.To16(), ones+96
+		copy(ip[:12], make([]byte, 12))
+	} else if ip4 != nil {
+		ip = ip4
+	}
+
+	node := 0
+	for i := 0; i < ones; i++ {
+		bit := int(ip[i>>3]>>(7-uint(i&7))) & 1
+		if i == ones-1 {
+			b.nodes[node][bit] = -offset - 1
+			return
+		}
+		if b.nodes[node][bit] <= 0 {
+			b.nodes = append(b.nodes, [2]int{})
+			b.nodes[node][bit] = len(b.nodes) - 1
+		}
+		node = b.nodes[node][bit]
+	}
+}
+
+func (b *builder) bytes() []byte {
+	nodeCount := len(b.nodes)
+	record := func(v int) uint32 {
+		switch {
+		case v == 0:
+			return uint32(nodeCount)
+		case v < 0:
+			return uint32(nodeCount + dataSectionSeparator - v - 1)
+		}
+		return uint32(v)
+	}
+
+	var buf []byte
+	for _, n := range b.nodes {
+		l, r := record(n[0]), record(n[1])
+		switch b.recordSize {
+		case 24:
+			buf = append(buf, byte(l>>16), byte(l>>8), byte(l), byte(r>>16), byte(r>>8), byte(r))
+		case 28:
+			buf = append(buf, byte(l>>16), byte(l>>8), byte(l), byte(l>>24)<<4|byte(r>>24), byte(r>>16), byte(r>>8), byte(r))
+		default:
+			buf = append(buf, byte(l>>24), byte(l>>16), byte(l>>8), byte(l), byte(r>>24), byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+
+	buf = append(buf, make([]byte, dataSectionSeparator)...)
+	buf = append(buf, b.data...)
+	buf = append(buf, metadataMarker...)
+	buf = append(buf, encode(map[string]interface{}{
+		"node_count":    nodeCount,
+		"record_size":   b.recordSize,
+		"ip_version":    b.ipVersion,
+		"database_type": "Test-DB",
+		"build_epoch":   1600000000,
+		"languages":     []interface{}{"en"},
+	})...)
+	return buf
+}
+
+func TestLookup(t *testing.T) {
+	for _, recordSize := range []int{24, 28, 32} {
+		b := newBuilder(6, recordSize)
+		us := b.addData(map[string]interface{}{
+			"country":   map[string]interface{}{"iso_code": "US"},
+			"continent": map[string]interface{}{"code": "NA"},
+			"city":      map[string]interface{}{"names": map[string]interface{}{"en": "Mountain View"}},
+		})
+		// The pointer reuses the data of us.
+		shared := b.addData(pointer(us))
+		anon := b.addData(map[string]interface{}{
+			"registered_country": map[string]interface{}{"iso_code": "DE"},
+			"traits":             map[string]interface{}{"is_anonymous_proxy": true},
+		})
+		b.insert("8.8.8.0/24", us)
+		b.insert("2001:4860::/32", shared)
+		b.insert("192.0.2.0/25", anon)
+
+		r, err := FromBytes(b.bytes())
+		if err != nil {
+			t.Fatalf("record size %d: unexpected error: %v", recordSize, err)
+		}
+		if r.DatabaseType() != "Test-DB" || r.BuildEpoch() != 1600000000 {
+			t.Errorf("unexpected metadata %s %d", r.DatabaseType(), r.BuildEpoch())
+		}
+
+		for _, ip := range []string{"8.8.8.8", "2001:4860:4860::8888"} {
+			record, err := r.Lookup(net.ParseIP(ip))
+			if err != nil || record == nil {
+				t.Fatalf("record size %d: lookup %s failed: %v", recordSize, ip, err)
+			}
+			if record.Country != "US" || record.Continent != "NA" || record.City != "Mountain View" {
+				t.Errorf("record size %d: unexpected record of %s: %+v", recordSize, ip, record)
+			}
+		}
+
+		record, _ := r.Lookup(net.ParseIP("192.0.2.1"))
+		if record == nil || record.Country != "DE" {
+			t.Errorf("record size %d: registered country should be used: %+v", recordSize, record)
+		}
+		v, _ := r.LookupData(net.ParseIP("192.0.2.1"))
+		if path(v.(map[string]interface{}), "traits", "is_anonymous_proxy") != true {
+			t.Errorf("record size %d: unexpected data %v", recordSize, v)
+		}
+
+		for _, ip := range []string{"8.8.9.8", "192.0.2.200", "2001:db8::1"} {
+			if record, err := r.Lookup(net.ParseIP(ip)); record != nil || err != nil {
+				t.Errorf("record size %d: %s should not be found: %+v %v", recordSize, ip, record, err)
+			}
+		}
+	}
+}
+
+func TestLookupIPv4Database(t *testing.T) {
+	b := newBuilder(4, 24)
+	asn := b.addData(map[string]interface{}{
+		"autonomous_system_number":       15169,
+		"autonomous_system_organization": "GOOGLE",
+	})
+	b.insert("8.8.0.0/16", asn)
+
+	r, err := FromBytes(b.bytes())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	record, _ := r.Lookup(net.ParseIP("8.8.4.4"))
+	if record == nil || record.ASN != 15169 || record.ASOrganization != "GOOGLE" {
+		t.Errorf("unexpected record %+v", record)
+	}
+	if record, _ := r.Lookup(net.ParseIP("2001:4860::1")); record != nil {
+		t.Errorf("IPv6 should not be found in IPv4 database")
+	}
+
+	record.Merge(&Record{Country: "US", ASN: 1})
+	if record.Country != "US" || record.ASN != 15169 {
+		t.Errorf("unexpected merged record %+v", record)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	if _, err := FromBytes([]byte("not a database")); err == nil {
+		t.Errorf("database without metadata should be invalid")
+	}
+
+	b := newBuilder(6, 24)
+	b.insert("8.8.8.0/24", b.addData("x"))
+	buf := b.bytes()
+	// Truncate the data section.
+	i := bytes.Index(buf, metadataMarker)
+	buf = append(buf[:i-1:i-1], buf[i:]...)
+	r, err := FromBytes(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.Lookup(net.ParseIP("8.8.8.8")); err == nil {
+		t.Errorf("lookup truncated data should fail")
+	}
+}
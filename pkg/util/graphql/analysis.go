@@ -0,0 +1,289 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Operation returns the operation to execute by the operation name, the
+// name could be empty if the document contains only one operation.
+func (d *Document) Operation(name string) (*Operation, error) {
+	if name == "" {
+		if len(d.Operations) != 1 {
+			return nil, fmt.Errorf("operation name is required for multiple operations")
+		}
+		return d.Operations[0], nil
+	}
+
+	for _, op := range d.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("operation %s not found", name)
+}
+
+// VariableValues returns the values of the variables of the operation,
+// the default values are used for the variables not provided.
+func (op *Operation) VariableValues(variables map[string]interface{}) map[string]interface{} {
+	values := make(map[string]interface{}, len(variables))
+	for name, value := range variables {
+		values[name] = value
+	}
+	for _, def := range op.VariableDefinitions {
+		if _, exists := values[def.Variable]; !exists && def.DefaultValue != nil {
+			values[def.Variable] = def.DefaultValue.Resolve(nil)
+		}
+	}
+	return values
+}
+
+// CollectFields returns the fields of the selection set, the fragments are
+// expanded regardless of their type conditions, and the fields skipped by
+// @skip or @include are excluded.
+func (d *Document) CollectFields(sels []Selection, variables map[string]interface{}) []*Field {
+	return d.collectFields(sels, func(directives []*Directive) bool {
+		return included(directives, variables)
+	})
+}
+
+func (d *Document) collectFields(sels []Selection, included func(directives []*Directive) bool) []*Field {
+	var fields []*Field
+	var collect func(sels []Selection)
+	collect = func(sels []Selection) {
+		for _, sel := range sels {
+			switch sel := sel.(type) {
+			case *Field:
+				if included(sel.Directives) {
+					fields = append(fields, sel)
+				}
+			case *InlineFragment:
+				if included(sel.Directives) {
+					collect(sel.SelectionSet)
+				}
+			case *FragmentSpread:
+				// NOTE: Fragments have been checked without cycles.
+				if f := d.Fragment(sel.Name); f != nil && included(sel.Directives) {
+					collect(f.SelectionSet)
+				}
+			}
+		}
+	}
+	collect(sels)
+	return fields
+}
+
+// included reports whether the selection is included by @skip and @include.
+func included(directives []*Directive, variables map[string]interface{}) bool {
+	for _, d := range directives {
+		if d.Name != "skip" && d.Name != "include" {
+			continue
+		}
+		for _, arg := range d.Arguments {
+			if arg.Name != "if" {
+				continue
+			}
+			value, _ := arg.Value.Resolve(variables).(bool)
+			if d.Name == "skip" && value || d.Name == "include" && !value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// includeAll includes all selections regardless of @skip and @include,
+// which is for the worst case analysis.
+func includeAll(directives []*Directive) bool {
+	return true
+}
+
+// Depth returns the max depth of the fields in the selection set,
+// the depth of top level fields is 1.
+func (d *Document) Depth(sels []Selection) int {
+	depth := 0
+	for _, f := range d.collectFields(sels, includeAll) {
+		if fd := 1 + d.Depth(f.SelectionSet); fd > depth {
+			depth = fd
+		}
+	}
+	return depth
+}
+
+// Complexity returns the number of fields in the selection set with the
+// fragments expanded.
+func (d *Document) Complexity(sels []Selection) int {
+	complexity := 0
+	for _, f := range d.collectFields(sels, includeAll) {
+		complexity += 1 + d.Complexity(f.SelectionSet)
+	}
+	return complexity
+}
+
+// FragmentsOf returns the fragments spread in the selection set directly
+// or indirectly, in the order of the document.
+func (d *Document) FragmentsOf(sels []Selection) []*Fragment {
+	used := map[string]bool{}
+	var walk func(sels []Selection)
+	walk = func(sels []Selection) {
+		for _, sel := range sels {
+			switch sel := sel.(type) {
+			case *Field:
+				walk(sel.SelectionSet)
+			case *InlineFragment:
+				walk(sel.SelectionSet)
+			case *FragmentSpread:
+				if used[sel.Name] {
+					continue
+				}
+				used[sel.Name] = true
+				if f := d.Fragment(sel.Name); f != nil {
+					walk(f.SelectionSet)
+				}
+			}
+		}
+	}
+	walk(sels)
+
+	var fragments []*Fragment
+	for _, f := range d.Fragments {
+		if used[f.Name] {
+			fragments = append(fragments, f)
+		}
+	}
+	return fragments
+}
+
+// VariablesOf returns the names of the variables used in the selection
+// set and the fragments.
+func VariablesOf(sels []Selection, fragments []*Fragment) map[string]bool {
+	vars := map[string]bool{}
+	var walkValue func(v *Value)
+	walkValue = func(v *Value) {
+		switch v.Kind {
+		case ValueVariable:
+			vars[v.Raw] = true
+		case ValueList:
+			for _, elem := range v.List {
+				walkValue(elem)
+			}
+		case ValueObject:
+			for _, field := range v.Object {
+				walkValue(field.Value)
+			}
+		}
+	}
+	walkDirectives := func(directives []*Directive) {
+		for _, d := range directives {
+			for _, arg := range d.Arguments {
+				walkValue(arg.Value)
+			}
+		}
+	}
+	var walk func(sels []Selection)
+	walk = func(sels []Selection) {
+		for _, sel := range sels {
+			switch sel := sel.(type) {
+			case *Field:
+				for _, arg := range sel.Arguments {
+					walkValue(arg.Value)
+				}
+				walkDirectives(sel.Directives)
+				walk(sel.SelectionSet)
+			case *InlineFragment:
+				walkDirectives(sel.Directives)
+				walk(sel.SelectionSet)
+			case *FragmentSpread:
+				walkDirectives(sel.Directives)
+			}
+		}
+	}
+
+	walk(sels)
+	for _, f := range fragments {
+		walkDirectives(f.Directives)
+		walk(f.SelectionSet)
+	}
+	return vars
+}
+
+// Resolve returns the Go value of the value like the ones decoded from
+// JSON, the variables are replaced by their values.
+func (v *Value) Resolve(variables map[string]interface{}) interface{} {
+	switch v.Kind {
+	case ValueVariable:
+		return variables[v.Raw]
+	case ValueInt, ValueFloat:
+		f, _ := strconv.ParseFloat(v.Raw, 64)
+		return f
+	case ValueString, ValueEnum:
+		return v.Raw
+	case ValueBoolean:
+		return v.Raw == "true"
+	case ValueList:
+		list := make([]interface{}, len(v.List))
+		for i, elem := range v.List {
+			list[i] = elem.Resolve(variables)
+		}
+		return list
+	case ValueObject:
+		obj := make(map[string]interface{}, len(v.Object))
+		for _, field := range v.Object {
+			obj[field.Name] = field.Value.Resolve(variables)
+		}
+		return obj
+	default:
+		return nil
+	}
+}
+
+// String returns the GraphQL literal of the value.
+func (v *Value) String() string {
+	switch v.Kind {
+	case ValueVariable:
+		return "$" + v.Raw
+	case ValueString:
+		buff, _ := json.Marshal(v.Raw)
+		return string(buff)
+	case ValueNull:
+		return "null"
+	case ValueList:
+		s := "["
+		for i, elem := range v.List {
+			if i > 0 {
+				s += ","
+			}
+			s += elem.String()
+		}
+		return s + "]"
+	case ValueObject:
+		s := "{"
+		for i, field := range v.Object {
+			if i > 0 {
+				s += ","
+			}
+			s += field.Name + ":" + field.Value.String()
+		}
+		return s + "}"
+	default:
+		return v.Raw
+	}
+}
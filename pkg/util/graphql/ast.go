@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package graphql parses GraphQL executable documents, i.e. queries,
+// mutations, subscriptions and fragments, and analyzes and prints them.
+// Schema definitions aren't supported.
+package graphql
+
+type (
+	// Document is a parsed GraphQL executable document.
+	Document struct {
+		Operations []*Operation
+		Fragments  []*Fragment
+	}
+
+	// OperationType is the type of operation.
+	OperationType string
+
+	// Operation is an operation definition.
+	Operation struct {
+		Type                OperationType
+		Name                string
+		VariableDefinitions []*VariableDefinition
+		Directives          []*Directive
+		SelectionSet        []Selection
+	}
+
+	// VariableDefinition is a variable definition of an operation.
+	VariableDefinition struct {
+		Variable     string
+		Type         string
+		DefaultValue *Value
+		Directives   []*Directive
+	}
+
+	// Fragment is a fragment definition.
+	Fragment struct {
+		Name          string
+		TypeCondition string
+		Directives    []*Directive
+		SelectionSet  []Selection
+	}
+
+	// Selection is one of *Field, *FragmentSpread and *InlineFragment.
+	Selection interface {
+		selection()
+	}
+
+	// Field is a field selection.
+	Field struct {
+		Alias        string
+		Name         string
+		Arguments    []*Argument
+		Directives   []*Directive
+		SelectionSet []Selection
+	}
+
+	// FragmentSpread is a fragment spread selection.
+	FragmentSpread struct {
+		Name       string
+		Directives []*Directive
+	}
+
+	// InlineFragment is an inline fragment selection.
+	InlineFragment struct {
+		TypeCondition string
+		Directives    []*Directive
+		SelectionSet  []Selection
+	}
+
+	// Argument is an argument of fields or directives.
+	Argument struct {
+		Name  string
+		Value *Value
+	}
+
+	// Directive is a directive like @include(if: $flag).
+	Directive struct {
+		Name      string
+		Arguments []*Argument
+	}
+
+	// ValueKind is the kind of value.
+	ValueKind int
+
+	// Value is an input value.
+	Value struct {
+		Kind ValueKind
+		// Raw is the variable name, the number literal, the unescaped
+		// string, true or false, or the enum value.
+		Raw    string
+		List   []*Value
+		Object []*ObjectField
+	}
+
+	// ObjectField is a field of object values.
+	ObjectField struct {
+		Name  string
+		Value *Value
+	}
+)
+
+const (
+	// OperationQuery is the query operation.
+	OperationQuery OperationType = "query"
+	// OperationMutation is the mutation operation.
+	OperationMutation OperationType = "mutation"
+	// OperationSubscription is the subscription operation.
+	OperationSubscription OperationType = "subscription"
+)
+
+const (
+	// ValueVariable is a variable like $id.
+	ValueVariable ValueKind = iota
+	// ValueInt is an integer.
+	ValueInt
+	// ValueFloat is a float.
+	ValueFloat
+	// ValueString is a string or a block string.
+	ValueString
+	// ValueBoolean is true or false.
+	ValueBoolean
+	// ValueNull is null.
+	ValueNull
+	// ValueEnum is an enum value.
+	ValueEnum
+	// ValueList is a list.
+	ValueList
+	// ValueObject is an input object.
+	ValueObject
+)
+
+func (f *Field) selection()          {}
+func (f *FragmentSpread) selection() {}
+func (f *InlineFragment) selection() {}
+
+// ResponseKey returns the key of the field in the response,
+// which is the alias if it's not empty.
+func (f *Field) ResponseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// Argument returns the argument by name, or nil if it doesn't exist.
+func (f *Field) Argument(name string) *Argument {
+	for _, arg := range f.Arguments {
+		if arg.Name == name {
+			return arg
+		}
+	}
+	return nil
+}
+
+// Fragment returns the fragment by name, or nil if it doesn't exist.
+func (d *Document) Fragment(name string) *Fragment {
+	for _, f := range d.Fragments {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
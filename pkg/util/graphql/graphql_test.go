@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graphql
+
+import (
+	"testing"
+)
+
+const testQuery = `
+# Fetch the user with orders.
+query GetUser($id: ID!, $withOrders: Boolean = true, $tags: [String!]) @cached {
+  user(id: $id) {
+    ...UserFields
+    orders(first: 10, filter: {status: PAID, tags: $tags}) @include(if: $withOrders) {
+      id
+      total
+    }
+  }
+  me: viewer { name }
+  ... on Query { version }
+}
+
+fragment UserFields on User {
+  id
+  name
+  bio(format: """
+      markdown
+        indented
+  """)
+  friends { ...FriendFields }
+}
+
+fragment FriendFields on User { name email: contact(kind: "email\n") }
+
+mutation Noop { noop }
+`
+
+func TestParse(t *testing.T) {
+	doc, err := Parse(testQuery)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if len(doc.Operations) != 2 || len(doc.Fragments) != 2 {
+		t.Fatalf("unexpected document: %+v", doc)
+	}
+
+	op, err := doc.Operation("GetUser")
+	if err != nil {
+		t.Fatalf("get operation failed: %v", err)
+	}
+	if op.Type != OperationQuery || len(op.VariableDefinitions) != 3 ||
+		op.VariableDefinitions[2].Type != "[String!]" || op.VariableDefinitions[1].DefaultValue.Raw != "true" {
+		t.Errorf("unexpected operation: %+v", op)
+	}
+	if _, err := doc.Operation(""); err == nil {
+		t.Errorf("operation name should be required")
+	}
+
+	fields := doc.CollectFields(op.SelectionSet, nil)
+	if len(fields) != 3 || fields[0].Name != "user" || fields[1].ResponseKey() != "me" || fields[2].Name != "version" {
+		t.Errorf("unexpected fields: %+v", fields)
+	}
+
+	bio := doc.Fragment("UserFields").SelectionSet[2].(*Field)
+	if bio.Argument("format").Value.Raw != "markdown\n  indented" {
+		t.Errorf("unexpected block string: %q", bio.Argument("format").Value.Raw)
+	}
+	email := doc.Fragment("FriendFields").SelectionSet[1].(*Field)
+	if email.Alias != "email" || email.Argument("kind").Value.Raw != "email\n" {
+		t.Errorf("unexpected field: %+v", email)
+	}
+
+	// user > friends > name, and user > orders > id.
+	if depth := doc.Depth(op.SelectionSet); depth != 3 {
+		t.Errorf("unexpected depth: %d", depth)
+	}
+	// user, id, name, bio, friends, name, email, orders, id, total, me, name, version.
+	if complexity := doc.Complexity(op.SelectionSet); complexity != 13 {
+		t.Errorf("unexpected complexity: %d", complexity)
+	}
+
+	userFields := doc.CollectFields(fields[0].SelectionSet, op.VariableValues(nil))
+	if len(userFields) != 5 {
+		t.Errorf("orders should be included by default: %+v", userFields)
+	}
+	userFields = doc.CollectFields(fields[0].SelectionSet, op.VariableValues(map[string]interface{}{"withOrders": false}))
+	if len(userFields) != 4 {
+		t.Errorf("orders should be skipped: %+v", userFields)
+	}
+
+	fragments := doc.FragmentsOf(op.SelectionSet)
+	if len(fragments) != 2 {
+		t.Errorf("unexpected fragments: %+v", fragments)
+	}
+	vars := VariablesOf(op.SelectionSet, fragments)
+	if len(vars) != 3 || !vars["id"] || !vars["withOrders"] || !vars["tags"] {
+		t.Errorf("unexpected variables: %v", vars)
+	}
+
+	filter := fields[0].SelectionSet[1].(*Field).Argument("filter").Value.Resolve(map[string]interface{}{"tags": []interface{}{"a"}})
+	if m := filter.(map[string]interface{}); m["status"] != "PAID" || len(m["tags"].([]interface{})) != 1 {
+		t.Errorf("unexpected resolved value: %v", filter)
+	}
+}
+
+func TestPrintOperation(t *testing.T) {
+	doc, err := Parse(testQuery)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	op, _ := doc.Operation("GetUser")
+
+	printed := PrintOperation(op, doc.FragmentsOf(op.SelectionSet))
+	expected := `query GetUser($id:ID!,$withOrders:Boolean=true,$tags:[String!])@cached{user(id:$id){...UserFields orders(first:10,filter:{status:PAID,tags:$tags})@include(if:$withOrders){id total}} me:viewer{name} ... on Query{version}} fragment UserFields on User{id name bio(format:"markdown\n  indented") friends{...FriendFields}} fragment FriendFields on User{name email:contact(kind:"email\n")}`
+	if printed != expected {
+		t.Errorf("unexpected printed operation:\n%s", printed)
+	}
+
+	reparsed, err := Parse(printed)
+	if err != nil {
+		t.Fatalf("parse printed operation failed: %v", err)
+	}
+	reop, _ := reparsed.Operation("GetUser")
+	if PrintOperation(reop, reparsed.FragmentsOf(reop.SelectionSet)) != printed {
+		t.Errorf("printing isn't stable")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []string{
+		``,
+		`{}`,
+		`{ user(id: ) }`,
+		`{ user(id: 01) }`,
+		`{ user(id: "abc) }`,
+		`{ ...Missing }`,
+		`{ ...A } fragment A on T { ...B } fragment B on T { ...A }`,
+		`fragment A on T { id } fragment A on T { id } { ...A }`,
+		`query A { a } { b }`,
+		`query ($v: Int = $w) { a }`,
+		`fragment on on T { id }`,
+		`{ a ~ }`,
+		`{ a { b { c `,
+	}
+
+	for _, c := range cases {
+		if _, err := Parse(c); err == nil {
+			t.Errorf("%q: should fail", c)
+		}
+	}
+
+	deep := ""
+	for i := 0; i < 300; i++ {
+		deep += "{a"
+	}
+	if _, err := Parse(deep); err == nil {
+		t.Errorf("deep nesting should fail")
+	}
+}
@@ -0,0 +1,544 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graphql
+
+import (
+	"fmt"
+)
+
+// maxNesting is the max nesting of selection sets and values, which
+// protects the parser from stack exhausting.
+const maxNesting = 256
+
+type parser struct {
+	lexer   *lexer
+	token   token
+	nesting int
+}
+
+// Parse parses the GraphQL executable document.
+func Parse(src string) (*Document, error) {
+	p := &parser{lexer: &lexer{src: src}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	doc := &Document{}
+	for p.token.kind != tokenEOF {
+		if p.peekName("fragment") {
+			f, err := p.parseFragment()
+			if err != nil {
+				return nil, err
+			}
+			if doc.Fragment(f.Name) != nil {
+				return nil, fmt.Errorf("fragment %s is defined repeatedly", f.Name)
+			}
+			doc.Fragments = append(doc.Fragments, f)
+			continue
+		}
+
+		op, err := p.parseOperation()
+		if err != nil {
+			return nil, err
+		}
+		doc.Operations = append(doc.Operations, op)
+	}
+
+	if len(doc.Operations) == 0 {
+		return nil, fmt.Errorf("no operation")
+	}
+	for _, op := range doc.Operations {
+		if op.Name == "" && len(doc.Operations) > 1 {
+			return nil, fmt.Errorf("anonymous operation must be the only operation")
+		}
+	}
+	if err := doc.checkFragments(); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (p *parser) advance() (err error) {
+	p.token, err = p.lexer.next()
+	return err
+}
+
+func (p *parser) unexpected() error {
+	return p.lexer.errorf(p.token.pos, "unexpected %s", p.token)
+}
+
+func (p *parser) peek(punctuator string) bool {
+	return p.token.kind == tokenPunctuator && p.token.value == punctuator
+}
+
+func (p *parser) peekName(name string) bool {
+	return p.token.kind == tokenName && p.token.value == name
+}
+
+func (p *parser) expect(punctuator string) error {
+	if !p.peek(punctuator) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *parser) parseName() (string, error) {
+	if p.token.kind != tokenName {
+		return "", p.unexpected()
+	}
+	name := p.token.value
+	return name, p.advance()
+}
+
+func (p *parser) enter() error {
+	p.nesting++
+	if p.nesting > maxNesting {
+		return p.lexer.errorf(p.token.pos, "nesting exceeds %d", maxNesting)
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.nesting--
+}
+
+func (p *parser) parseOperation() (*Operation, error) {
+	op := &Operation{Type: OperationQuery}
+	if p.peek("{") {
+		sels, err := p.parseSelectionSet()
+		if err != nil {
+			return nil, err
+		}
+		op.SelectionSet = sels
+		return op, nil
+	}
+
+	switch {
+	case p.peekName("query"), p.peekName("mutation"), p.peekName("subscription"):
+		op.Type = OperationType(p.token.value)
+	default:
+		return nil, p.unexpected()
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if p.token.kind == tokenName {
+		if op.Name, err = p.parseName(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek("(") {
+		if op.VariableDefinitions, err = p.parseVariableDefinitions(); err != nil {
+			return nil, err
+		}
+	}
+	if op.Directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	if op.SelectionSet, err = p.parseSelectionSet(); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (p *parser) parseVariableDefinitions() ([]*VariableDefinition, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var defs []*VariableDefinition
+	for !p.peek(")") {
+		def := &VariableDefinition{}
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+		var err error
+		if def.Variable, err = p.parseName(); err != nil {
+			return nil, err
+		}
+		if err = p.expect(":"); err != nil {
+			return nil, err
+		}
+		if def.Type, err = p.parseType(); err != nil {
+			return nil, err
+		}
+		if p.peek("=") {
+			if err = p.advance(); err != nil {
+				return nil, err
+			}
+			if def.DefaultValue, err = p.parseValue(true); err != nil {
+				return nil, err
+			}
+		}
+		if def.Directives, err = p.parseDirectives(); err != nil {
+			return nil, err
+		}
+		defs = append(defs, def)
+	}
+
+	if len(defs) == 0 {
+		return nil, p.unexpected()
+	}
+	return defs, p.advance()
+}
+
+// parseType parses the type and returns its canonical text like [Int!]!.
+func (p *parser) parseType() (string, error) {
+	var typ string
+	if p.peek("[") {
+		if err := p.enter(); err != nil {
+			return "", err
+		}
+		defer p.leave()
+
+		if err := p.advance(); err != nil {
+			return "", err
+		}
+		elem, err := p.parseType()
+		if err != nil {
+			return "", err
+		}
+		if err = p.expect("]"); err != nil {
+			return "", err
+		}
+		typ = "[" + elem + "]"
+	} else {
+		name, err := p.parseName()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+
+	if p.peek("!") {
+		typ += "!"
+		return typ, p.advance()
+	}
+	return typ, nil
+}
+
+func (p *parser) parseFragment() (*Fragment, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	f := &Fragment{}
+	var err error
+	if p.peekName("on") {
+		return nil, p.unexpected()
+	}
+	if f.Name, err = p.parseName(); err != nil {
+		return nil, err
+	}
+	if !p.peekName("on") {
+		return nil, p.unexpected()
+	}
+	if err = p.advance(); err != nil {
+		return nil, err
+	}
+	if f.TypeCondition, err = p.parseName(); err != nil {
+		return nil, err
+	}
+	if f.Directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	if f.SelectionSet, err = p.parseSelectionSet(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *parser) parseSelectionSet() ([]Selection, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	var sels []Selection
+	for !p.peek("}") {
+		var (
+			sel Selection
+			err error
+		)
+		if p.peek("...") {
+			sel, err = p.parseFragmentSelection()
+		} else {
+			sel, err = p.parseField()
+		}
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+
+	if len(sels) == 0 {
+		return nil, p.unexpected()
+	}
+	return sels, p.advance()
+}
+
+func (p *parser) parseField() (*Field, error) {
+	f := &Field{}
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek(":") {
+		if err = p.advance(); err != nil {
+			return nil, err
+		}
+		f.Alias = name
+		if name, err = p.parseName(); err != nil {
+			return nil, err
+		}
+	}
+	f.Name = name
+
+	if f.Arguments, err = p.parseArguments(false); err != nil {
+		return nil, err
+	}
+	if f.Directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		if f.SelectionSet, err = p.parseSelectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (p *parser) parseFragmentSelection() (Selection, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	if p.token.kind == tokenName && !p.peekName("on") {
+		spread := &FragmentSpread{}
+		var err error
+		if spread.Name, err = p.parseName(); err != nil {
+			return nil, err
+		}
+		if spread.Directives, err = p.parseDirectives(); err != nil {
+			return nil, err
+		}
+		return spread, nil
+	}
+
+	inline := &InlineFragment{}
+	var err error
+	if p.peekName("on") {
+		if err = p.advance(); err != nil {
+			return nil, err
+		}
+		if inline.TypeCondition, err = p.parseName(); err != nil {
+			return nil, err
+		}
+	}
+	if inline.Directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	if inline.SelectionSet, err = p.parseSelectionSet(); err != nil {
+		return nil, err
+	}
+	return inline, nil
+}
+
+func (p *parser) parseArguments(isConst bool) ([]*Argument, error) {
+	if !p.peek("(") {
+		return nil, nil
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var args []*Argument
+	for !p.peek(")") {
+		arg := &Argument{}
+		var err error
+		if arg.Name, err = p.parseName(); err != nil {
+			return nil, err
+		}
+		if err = p.expect(":"); err != nil {
+			return nil, err
+		}
+		if arg.Value, err = p.parseValue(isConst); err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	if len(args) == 0 {
+		return nil, p.unexpected()
+	}
+	return args, p.advance()
+}
+
+func (p *parser) parseDirectives() ([]*Directive, error) {
+	var directives []*Directive
+	for p.peek("@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		d := &Directive{}
+		var err error
+		if d.Name, err = p.parseName(); err != nil {
+			return nil, err
+		}
+		if d.Arguments, err = p.parseArguments(false); err != nil {
+			return nil, err
+		}
+		directives = append(directives, d)
+	}
+	return directives, nil
+}
+
+func (p *parser) parseValue(isConst bool) (*Value, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	t := p.token
+	switch {
+	case t.kind == tokenPunctuator && t.value == "$" && !isConst:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		return &Value{Kind: ValueVariable, Raw: name}, nil
+	case t.kind == tokenInt:
+		return &Value{Kind: ValueInt, Raw: t.value}, p.advance()
+	case t.kind == tokenFloat:
+		return &Value{Kind: ValueFloat, Raw: t.value}, p.advance()
+	case t.kind == tokenString, t.kind == tokenBlockString:
+		return &Value{Kind: ValueString, Raw: t.value}, p.advance()
+	case t.kind == tokenName:
+		v := &Value{Kind: ValueEnum, Raw: t.value}
+		switch t.value {
+		case "true", "false":
+			v.Kind = ValueBoolean
+		case "null":
+			v.Kind = ValueNull
+		}
+		return v, p.advance()
+	case t.kind == tokenPunctuator && t.value == "[":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		v := &Value{Kind: ValueList}
+		for !p.peek("]") {
+			elem, err := p.parseValue(isConst)
+			if err != nil {
+				return nil, err
+			}
+			v.List = append(v.List, elem)
+		}
+		return v, p.advance()
+	case t.kind == tokenPunctuator && t.value == "{":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		v := &Value{Kind: ValueObject}
+		for !p.peek("}") {
+			field := &ObjectField{}
+			var err error
+			if field.Name, err = p.parseName(); err != nil {
+				return nil, err
+			}
+			if err = p.expect(":"); err != nil {
+				return nil, err
+			}
+			if field.Value, err = p.parseValue(isConst); err != nil {
+				return nil, err
+			}
+			v.Object = append(v.Object, field)
+		}
+		return v, p.advance()
+	default:
+		return nil, p.unexpected()
+	}
+}
+
+// checkFragments checks the fragment spreads refer to defined fragments
+// without cycles.
+func (d *Document) checkFragments() error {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	states := map[string]int{}
+
+	var checkSelections func(sels []Selection) error
+	checkFragment := func(name string) error {
+		switch states[name] {
+		case visiting:
+			return fmt.Errorf("fragment %s spreads itself", name)
+		case visited:
+			return nil
+		}
+		f := d.Fragment(name)
+		if f == nil {
+			return fmt.Errorf("fragment %s is not defined", name)
+		}
+		states[name] = visiting
+		if err := checkSelections(f.SelectionSet); err != nil {
+			return err
+		}
+		states[name] = visited
+		return nil
+	}
+	checkSelections = func(sels []Selection) error {
+		for _, sel := range sels {
+			var err error
+			switch sel := sel.(type) {
+			case *Field:
+				err = checkSelections(sel.SelectionSet)
+			case *InlineFragment:
+				err = checkSelections(sel.SelectionSet)
+			case *FragmentSpread:
+				err = checkFragment(sel.Name)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, op := range d.Operations {
+		if err := checkSelections(op.SelectionSet); err != nil {
+			return err
+		}
+	}
+	for _, f := range d.Fragments {
+		if err := checkFragment(f.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graphql
+
+import (
+	"strings"
+)
+
+// PrintOperation prints the operation and the fragments compactly as a
+// GraphQL document.
+func PrintOperation(op *Operation, fragments []*Fragment) string {
+	var sb strings.Builder
+
+	sb.WriteString(string(op.Type))
+	if op.Name != "" {
+		sb.WriteString(" ")
+		sb.WriteString(op.Name)
+	}
+	if len(op.VariableDefinitions) != 0 {
+		sb.WriteString("(")
+		for i, def := range op.VariableDefinitions {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString("$")
+			sb.WriteString(def.Variable)
+			sb.WriteString(":")
+			sb.WriteString(def.Type)
+			if def.DefaultValue != nil {
+				sb.WriteString("=")
+				sb.WriteString(def.DefaultValue.String())
+			}
+			printDirectives(&sb, def.Directives)
+		}
+		sb.WriteString(")")
+	}
+	printDirectives(&sb, op.Directives)
+	printSelectionSet(&sb, op.SelectionSet)
+
+	for _, f := range fragments {
+		sb.WriteString(" fragment ")
+		sb.WriteString(f.Name)
+		sb.WriteString(" on ")
+		sb.WriteString(f.TypeCondition)
+		printDirectives(&sb, f.Directives)
+		printSelectionSet(&sb, f.SelectionSet)
+	}
+
+	return sb.String()
+}
+
+func printSelectionSet(sb *strings.Builder, sels []Selection) {
+	sb.WriteString("{")
+	for i, sel := range sels {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		switch sel := sel.(type) {
+		case *Field:
+			if sel.Alias != "" {
+				sb.WriteString(sel.Alias)
+				sb.WriteString(":")
+			}
+			sb.WriteString(sel.Name)
+			printArguments(sb, sel.Arguments)
+			printDirectives(sb, sel.Directives)
+			if len(sel.SelectionSet) != 0 {
+				printSelectionSet(sb, sel.SelectionSet)
+			}
+		case *FragmentSpread:
+			sb.WriteString("...")
+			sb.WriteString(sel.Name)
+			printDirectives(sb, sel.Directives)
+		case *InlineFragment:
+			sb.WriteString("...")
+			if sel.TypeCondition != "" {
+				sb.WriteString(" on ")
+				sb.WriteString(sel.TypeCondition)
+			}
+			printDirectives(sb, sel.Directives)
+			printSelectionSet(sb, sel.SelectionSet)
+		}
+	}
+	sb.WriteString("}")
+}
+
+func printArguments(sb *strings.Builder, args []*Argument) {
+	if len(args) == 0 {
+		return
+	}
+	sb.WriteString("(")
+	for i, arg := range args {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(arg.Name)
+		sb.WriteString(":")
+		sb.WriteString(arg.Value.String())
+	}
+	sb.WriteString(")")
+}
+
+func printDirectives(sb *strings.Builder, directives []*Directive) {
+	for _, d := range directives {
+		sb.WriteString("@")
+		sb.WriteString(d.Name)
+		printArguments(sb, d.Arguments)
+	}
+}
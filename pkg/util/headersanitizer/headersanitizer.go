@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+// Package headersanitizer strips the internal headers of requests from
+// untrusted clients, so the identities and the routing hints injected by
+// filters and the previous hops could not be spoofed.
+package headersanitizer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// DefaultHeaders are the internal headers stripped if no header is
+// specified: the headers of Easegress and its service mesh, and the
+// consumer and plan headers trusted as identity by the filters.
+var DefaultHeaders = []string{
+	"X-Easegress-*", "X-EG-*", "X-Mesh-*",
+	"X-Consumer", "X-Plan", "X-Plan-Features",
+}
+
+type (
+	// Spec describes the sanitization of headers.
+	Spec struct {
+		// Headers are the names of the internal headers, a name ending
+		// with * matches the headers with the prefix, DefaultHeaders is
+		// used if it's empty.
+		Headers []string `yaml:"headers" jsonschema:"omitempty,uniqueItems=true"`
+		// Overrides sets the headers after stripping, e.g. marks the
+		// requests as unverified.
+		Overrides map[string]string `yaml:"overrides" jsonschema:"omitempty"`
+		// TrustedPeers are the IPs or CIDRs of the previous hops, whose
+		// requests are untouched. The peer is the remote address of the
+		// connection, X-Forwarded-For is never trusted.
+		TrustedPeers []string `yaml:"trustedPeers" jsonschema:"omitempty,uniqueItems=true,format=ipcidr-array"`
+	}
+
+	// Sanitizer sanitizes headers by the Spec.
+	Sanitizer struct {
+		names        map[string]bool
+		prefixes     []string
+		overrides    map[string]string
+		trustedPeers []*net.IPNet
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	for _, h := range spec.Headers {
+		name := strings.TrimSuffix(h, "*")
+		if name == "" || strings.Contains(name, "*") {
+			return fmt.Errorf("invalid header %q", h)
+		}
+	}
+	for k := range spec.Overrides {
+		if k == "" {
+			return fmt.Errorf("empty header name in overrides")
+		}
+	}
+	return nil
+}
+
+// New creates a Sanitizer.
+func New(spec *Spec) *Sanitizer {
+	s := &Sanitizer{
+		names:     map[string]bool{},
+		overrides: map[string]string{},
+	}
+
+	headers := spec.Headers
+	if len(headers) == 0 {
+		headers = DefaultHeaders
+	}
+	for _, h := range headers {
+		if strings.HasSuffix(h, "*") {
+			s.prefixes = append(s.prefixes, strings.TrimSuffix(h, "*"))
+		} else {
+			s.names[textproto.CanonicalMIMEHeaderKey(h)] = true
+		}
+	}
+
+	for k, v := range spec.Overrides {
+		s.overrides[textproto.CanonicalMIMEHeaderKey(k)] = v
+	}
+
+	for _, peer := range spec.TrustedPeers {
+		if !strings.Contains(peer, "/") {
+			if strings.Contains(peer, ":") {
+				peer += "/128"
+			} else {
+				peer += "/32"
+			}
+		}
+		if _, ipNet, err := net.ParseCIDR(peer); err == nil {
+			s.trustedPeers = append(s.trustedPeers, ipNet)
+		}
+	}
+
+	return s
+}
+
+// Trusted returns whether the remote address is a trusted peer.
+func (s *Sanitizer) Trusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range s.trustedPeers {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Sanitizer) internal(name string) bool {
+	if s.names[textproto.CanonicalMIMEHeaderKey(name)] {
+		return true
+	}
+	for _, prefix := range s.prefixes {
+		if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sanitize strips the internal headers of the request and sets the
+// overrides if it's not from a trusted peer, it returns the sorted names
+// of the stripped headers.
+func (s *Sanitizer) Sanitize(req *http.Request) []string {
+	if s.Trusted(req.RemoteAddr) {
+		return nil
+	}
+
+	var stripped []string
+	for name := range req.Header {
+		if s.internal(name) {
+			stripped = append(stripped, name)
+			delete(req.Header, name)
+		}
+	}
+	sort.Strings(stripped)
+
+	for k, v := range s.overrides {
+		req.Header.Set(k, v)
+	}
+
+	return stripped
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package headersanitizer
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func newRequest(remoteAddr string) *http.Request {
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("X-Easegress-Bridge-Dest", "internal")
+	req.Header.Set("X-Mesh-Rpc-Service", "order")
+	req.Header.Set("X-User", "admin")
+	req.Header.Set("X-User-Roles", "root")
+	req.Header.Set("X-Claim-Sub", "alice")
+	req.Header.Set("X-Consumer", "acme")
+	req.Header.Set("X-Plan", "enterprise")
+	req.Header.Set("Accept", "*/*")
+	return req
+}
+
+func TestValidate(t *testing.T) {
+	for _, spec := range []Spec{
+		{Headers: []string{"*"}},
+		{Headers: []string{"X-*-Id"}},
+		{Overrides: map[string]string{"": "x"}},
+	} {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("spec %+v should be invalid", spec)
+		}
+	}
+
+	spec := Spec{Headers: []string{"X-User", "x-claim-*"}}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDefaultHeaders(t *testing.T) {
+	s := New(&Spec{})
+
+	req := newRequest("10.0.0.1:1234")
+	stripped := s.Sanitize(req)
+	expected := []string{"X-Consumer", "X-Easegress-Bridge-Dest", "X-Mesh-Rpc-Service", "X-Plan"}
+	if !reflect.DeepEqual(stripped, expected) {
+		t.Errorf("expect %v, got %v", expected, stripped)
+	}
+	if req.Header.Get("X-User") != "admin" || req.Header.Get("Accept") != "*/*" {
+		t.Errorf("other headers should be kept")
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	s := New(&Spec{
+		Headers:      []string{"x-user", "x-claim-*", "X-Easegress-*"},
+		Overrides:    map[string]string{"x-user-verified": "false"},
+		TrustedPeers: []string{"10.1.0.0/16", "::1"},
+	})
+
+	req := newRequest("10.0.0.1:1234")
+	stripped := s.Sanitize(req)
+	expected := []string{"X-Claim-Sub", "X-Easegress-Bridge-Dest", "X-User"}
+	if !reflect.DeepEqual(stripped, expected) {
+		t.Errorf("expect %v, got %v", expected, stripped)
+	}
+	if req.Header.Get("X-User-Roles") != "root" || req.Header.Get("X-Mesh-Rpc-Service") != "order" {
+		t.Errorf("headers not matched should be kept")
+	}
+	if req.Header.Get("X-User-Verified") != "false" {
+		t.Errorf("override should be set")
+	}
+
+	// X-Forwarded-For is never trusted
+	req = newRequest("192.168.0.1:1234")
+	req.Header.Set("X-Forwarded-For", "10.1.2.3")
+	if stripped := s.Sanitize(req); len(stripped) != 3 {
+		t.Errorf("expect 3 stripped headers, got %v", stripped)
+	}
+
+	for _, addr := range []string{"10.1.2.3:1234", "[::1]:1234"} {
+		req = newRequest(addr)
+		if stripped := s.Sanitize(req); stripped != nil {
+			t.Errorf("request from trusted peer %s should be untouched, got %v", addr, stripped)
+		}
+		if req.Header.Get("X-User") != "admin" || req.Header.Get("X-User-Verified") != "" {
+			t.Errorf("request from trusted peer %s should be untouched", addr)
+		}
+	}
+
+	if s.Trusted("unix-socket") {
+		t.Errorf("invalid address should not be trusted")
+	}
+}
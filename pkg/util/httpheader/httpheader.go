@@ -23,7 +23,6 @@ import (
 	"strings"
 
 	"github.com/megaease/easegress/pkg/logger"
-	"github.com/megaease/easegress/pkg/util/stringtool"
 	"github.com/megaease/easegress/pkg/util/texttemplate"
 )
 
@@ -48,24 +47,35 @@ func New(src http.Header) *HTTPHeader {
 	return &HTTPHeader{h: src}
 }
 
-// Reset resets internal src http.Header.
+// share returns values which could be shared by another http.Header.
+//
+// NOTE: The capacity is limited to the length, so the first append on
+// either side clones the values lazily, while Set and Del replace or drop
+// the values of the key. The values of http.Header must never be modified
+// in place, which neither the standard library nor Easegress does.
+func share(values []string) []string {
+	return values[:len(values):len(values)]
+}
+
+// Reset resets internal src http.Header, the values of src are shared
+// instead of copied.
 func (h *HTTPHeader) Reset(src http.Header) {
 	for key := range h.h {
 		delete(h.h, key)
 	}
 
 	for key, values := range src {
-		h.h[key] = values
+		h.h[key] = share(values)
 	}
 }
 
-// Copy copies HTTPHeader to a whole new HTTPHeader.
+// Copy copies HTTPHeader to a whole new HTTPHeader, modifications of either
+// one are invisible to the other, but the values are only cloned when
+// they are appended.
 func (h *HTTPHeader) Copy() *HTTPHeader {
-	n := make(http.Header)
+	n := make(http.Header, len(h.h))
 	for key, values := range h.h {
-		copyValues := make([]string, len(values))
-		copy(copyValues, values)
-		n[key] = copyValues
+		n[key] = share(values)
 	}
 
 	return &HTTPHeader{h: n}
@@ -113,42 +123,56 @@ func (h *HTTPHeader) VisitAll(fn func(key, value string)) {
 
 // Dump dumps HTTPHeader in RFC format.
 func (h *HTTPHeader) Dump() string {
-	var headers []string
+	var sb strings.Builder
 	h.VisitAll(func(key, value string) {
-		headers = append(headers, stringtool.Cat(key, ": ", value))
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString(key)
+		sb.WriteString(": ")
+		sb.WriteString(value)
 	})
 
-	return strings.Join(headers, "\r\n")
+	return sb.String()
 }
 
 // AddFrom adds values from another HTTPHeader.
 func (h *HTTPHeader) AddFrom(src *HTTPHeader) {
-	for key, values := range src.h {
-		for _, value := range values {
-			h.h.Add(key, value)
-		}
-	}
+	h.AddFromStd(src.h)
 }
 
 // AddFromStd wraps AddFrom by replacing
 // the parameter type *HTTPHeader with standard http.Header.
 func (h *HTTPHeader) AddFromStd(src http.Header) {
-	h.AddFrom(New(src))
+	for key, values := range src {
+		if len(values) == 0 {
+			continue
+		}
+		key = textproto.CanonicalMIMEHeaderKey(key)
+		if existing, ok := h.h[key]; ok {
+			h.h[key] = append(existing, values...)
+		} else {
+			h.h[key] = share(values)
+		}
+	}
 }
 
 // SetFrom sets values from another HTTPHeader.
 func (h *HTTPHeader) SetFrom(src *HTTPHeader) {
-	for key, values := range src.h {
-		for _, value := range values {
-			h.h.Set(key, value)
-		}
-	}
+	h.SetFromStd(src.h)
 }
 
 // SetFromStd wraps Setfrom by replacing
 // the parameter type *HTTPHeader with standard http.Header.
+// The LAST value of every key in src wins.
 func (h *HTTPHeader) SetFromStd(src http.Header) {
-	h.SetFrom(New(src))
+	for key, values := range src {
+		if len(values) == 0 {
+			continue
+		}
+		key = textproto.CanonicalMIMEHeaderKey(key)
+		h.h[key] = share(values[len(values)-1:])
+	}
 }
 
 func renderTemplate(input string, te texttemplate.TemplateEngine) (output string, ok bool) {
@@ -20,15 +20,51 @@ package httpheader
 const (
 	// KeyCacheControl is the key of Cache-Control.
 	KeyCacheControl = "Cache-Control"
+	// KeyAccept is the key of Accept.
+	KeyAccept = "Accept"
 	// KeyAcceptEncoding is the key of Accept-Encoding.
 	KeyAcceptEncoding = "Accept-Encoding"
 	// KeyContentEncoding is the key of Content-Encoding.
 	KeyContentEncoding = "Content-Encoding"
 	// KeyContentLength is the key of Content-Length.
 	KeyContentLength = "Content-Length"
+	// KeyContentRange is the key of Content-Range.
+	KeyContentRange = "Content-Range"
+	// KeyContentType is the key of Content-Type.
+	KeyContentType = "Content-Type"
+	// KeyAcceptRanges is the key of Accept-Ranges.
+	KeyAcceptRanges = "Accept-Ranges"
+	// KeyRange is the key of Range.
+	KeyRange = "Range"
+	// KeyIfRange is the key of If-Range.
+	KeyIfRange = "If-Range"
+	// KeyETag is the key of ETag.
+	KeyETag = "ETag"
+	// KeyLastModified is the key of Last-Modified.
+	KeyLastModified = "Last-Modified"
 	// KeyVary is the key of Vary.
 	KeyVary = "Vary"
+	// KeyIfNoneMatch is the key of If-None-Match.
+	KeyIfNoneMatch = "If-None-Match"
+	// KeyIfModifiedSince is the key of If-Modified-Since.
+	KeyIfModifiedSince = "If-Modified-Since"
+	// KeyAuthorization is the key of Authorization.
+	KeyAuthorization = "Authorization"
+	// KeyCookie is the key of Cookie.
+	KeyCookie = "Cookie"
+	// KeySetCookie is the key of Set-Cookie.
+	KeySetCookie = "Set-Cookie"
+	// KeyAge is the key of Age.
+	KeyAge = "Age"
+	// KeyDate is the key of Date.
+	KeyDate = "Date"
+	// KeyExpires is the key of Expires.
+	KeyExpires = "Expires"
+	// KeyPragma is the key of Pragma.
+	KeyPragma = "Pragma"
 
 	// KeyXForwardedFor is the key of X-Forwarded-For.
 	KeyXForwardedFor = "X-Forwarded-For"
+	// KeyEarlyData is the key of Early-Data.
+	KeyEarlyData = "Early-Data"
 )
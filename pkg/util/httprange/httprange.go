@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+// Package httprange implements the byte range semantics of RFC 7233 for
+// serving partial content out of a complete response body.
+package httprange
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Unit is the only range unit supported.
+	Unit = "bytes"
+)
+
+// ErrUnsatisfiable is returned when none of the ranges overlap the body,
+// the caller should respond 416 with UnsatisfiedContentRange.
+var ErrUnsatisfiable = errors.New("range not satisfiable")
+
+// Range is a byte range of a body.
+type Range struct {
+	Start  int64
+	Length int64
+}
+
+// ContentRange returns the value of the Content-Range header for the range.
+func (r Range) ContentRange(size int64) string {
+	return fmt.Sprintf("%s %d-%d/%d", Unit, r.Start, r.Start+r.Length-1, size)
+}
+
+// UnsatisfiedContentRange returns the value of the Content-Range header
+// for a 416 response.
+func UnsatisfiedContentRange(size int64) string {
+	return fmt.Sprintf("%s */%d", Unit, size)
+}
+
+// Parse parses the value of a Range header against a body of size bytes.
+// It returns nil ranges without error when the header should be ignored,
+// i.e. it is syntactically invalid, uses an unknown unit, or requests
+// more bytes than the body itself, so the full body should be served.
+func Parse(s string, size int64) ([]Range, error) {
+	const prefix = Unit + "="
+	if !strings.HasPrefix(s, prefix) {
+		return nil, nil
+	}
+
+	var ranges []Range
+	noOverlap := false
+	for _, ra := range strings.Split(s[len(prefix):], ",") {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			continue
+		}
+		i := strings.Index(ra, "-")
+		if i < 0 {
+			return nil, nil
+		}
+		start, end := strings.TrimSpace(ra[:i]), strings.TrimSpace(ra[i+1:])
+
+		var r Range
+		if start == "" {
+			// suffix-byte-range-spec: the last N bytes.
+			n, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 {
+				noOverlap = true
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r.Start = size - n
+			r.Length = n
+		} else {
+			i, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || i < 0 {
+				return nil, nil
+			}
+			if i >= size {
+				noOverlap = true
+				continue
+			}
+			r.Start = i
+			if end == "" {
+				r.Length = size - i
+			} else {
+				j, err := strconv.ParseInt(end, 10, 64)
+				if err != nil || j < i {
+					return nil, nil
+				}
+				if j >= size {
+					j = size - 1
+				}
+				r.Length = j - i + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		if noOverlap {
+			return nil, ErrUnsatisfiable
+		}
+		return nil, nil
+	}
+
+	// Refuse to amplify the response, the same as net/http does.
+	var total int64
+	for _, r := range ranges {
+		total += r.Length
+	}
+	if total > size {
+		return nil, nil
+	}
+
+	return ranges, nil
+}
+
+// IfRangeMatch reports whether the value of an If-Range header matches
+// the validators of the full body, only strong comparison is allowed.
+// An empty ifRange always matches.
+func IfRangeMatch(ifRange, etag, lastModified string) bool {
+	ifRange = strings.TrimSpace(ifRange)
+	if ifRange == "" {
+		return true
+	}
+
+	if strings.HasPrefix(ifRange, `"`) {
+		return etag != "" && !strings.HasPrefix(etag, "W/") && ifRange == etag
+	}
+
+	return lastModified != "" && ifRange == lastModified
+}
+
+// Multipart builds a multipart/byteranges body for the ranges, it returns
+// the Content-Type of the response and the body.
+func Multipart(ranges []Range, contentType string, body []byte) (string, []byte) {
+	size := int64(len(body))
+	buff := bytes.NewBuffer(nil)
+	mw := multipart.NewWriter(buff)
+	for _, r := range ranges {
+		h := textproto.MIMEHeader{}
+		if contentType != "" {
+			h.Set("Content-Type", contentType)
+		}
+		h.Set("Content-Range", r.ContentRange(size))
+		part, _ := mw.CreatePart(h)
+		part.Write(body[r.Start : r.Start+r.Length])
+	}
+	mw.Close()
+
+	return "multipart/byteranges; boundary=" + mw.Boundary(), buff.Bytes()
+}
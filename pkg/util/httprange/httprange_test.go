@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package httprange
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		value  string
+		ranges []Range
+		err    error
+	}{
+		{"", nil, nil},
+		{"items=0-1", nil, nil},
+		{"bytes=0-9", []Range{{0, 10}}, nil},
+		{"bytes=0-", []Range{{0, 100}}, nil},
+		{"bytes=90-200", []Range{{90, 10}}, nil},
+		{"bytes=-10", []Range{{90, 10}}, nil},
+		{"bytes=-200", []Range{{0, 100}}, nil},
+		{"bytes=0-0, 10-19", []Range{{0, 1}, {10, 10}}, nil},
+		{"bytes=100-", nil, ErrUnsatisfiable},
+		{"bytes=-0", nil, ErrUnsatisfiable},
+		{"bytes=5-1", nil, nil},
+		{"bytes=a-b", nil, nil},
+		{"bytes=0-99,0-99", nil, nil},
+	}
+
+	for _, c := range cases {
+		ranges, err := Parse(c.value, 100)
+		if err != c.err {
+			t.Errorf("%q: want error %v, got %v", c.value, c.err, err)
+		}
+		if len(ranges) != len(c.ranges) {
+			t.Errorf("%q: want %v, got %v", c.value, c.ranges, ranges)
+			continue
+		}
+		for i := range ranges {
+			if ranges[i] != c.ranges[i] {
+				t.Errorf("%q: want %v, got %v", c.value, c.ranges, ranges)
+			}
+		}
+	}
+}
+
+func TestContentRange(t *testing.T) {
+	if s := (Range{Start: 10, Length: 5}).ContentRange(100); s != "bytes 10-14/100" {
+		t.Errorf("unexpected content range %s", s)
+	}
+	if s := UnsatisfiedContentRange(100); s != "bytes */100" {
+		t.Errorf("unexpected content range %s", s)
+	}
+}
+
+func TestIfRangeMatch(t *testing.T) {
+	const lastModified = "Wed, 21 Oct 2015 07:28:00 GMT"
+	cases := []struct {
+		ifRange string
+		etag    string
+		match   bool
+	}{
+		{"", "", true},
+		{`"v1"`, `"v1"`, true},
+		{`"v1"`, `"v2"`, false},
+		{`W/"v1"`, `W/"v1"`, false},
+		{`"v1"`, `W/"v1"`, false},
+		{lastModified, "", true},
+		{"Thu, 22 Oct 2015 07:28:00 GMT", "", false},
+	}
+
+	for _, c := range cases {
+		if got := IfRangeMatch(c.ifRange, c.etag, lastModified); got != c.match {
+			t.Errorf("%q against %q: want %v, got %v", c.ifRange, c.etag, c.match, got)
+		}
+	}
+}
+
+func TestMultipart(t *testing.T) {
+	body := []byte("0123456789")
+	contentType, data := Multipart([]Range{{0, 2}, {8, 2}}, "text/plain", body)
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("unexpected content type %s", contentType)
+	}
+
+	mr := multipart.NewReader(bytes.NewReader(data), params["boundary"])
+	expected := []struct{ contentRange, body string }{
+		{"bytes 0-1/10", "01"},
+		{"bytes 8-9/10", "89"},
+	}
+	for _, e := range expected {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("read part failed: %v", err)
+		}
+		if part.Header.Get("Content-Type") != "text/plain" {
+			t.Errorf("unexpected part content type %s", part.Header.Get("Content-Type"))
+		}
+		if part.Header.Get("Content-Range") != e.contentRange {
+			t.Errorf("want content range %s, got %s", e.contentRange, part.Header.Get("Content-Range"))
+		}
+		p, _ := ioutil.ReadAll(part)
+		if string(p) != e.body {
+			t.Errorf("want body %s, got %s", e.body, p)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package jwks fetches JSON Web Key Sets of authorization servers and
+// caches the keys to verify JWTs.
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	// MinRefreshInterval is the minimum interval to fetch the keys, so
+	// tokens of unknown keys don't flood the server.
+	MinRefreshInterval = 10 * time.Second
+
+	maxResponseSize = 1024 * 1024
+)
+
+type (
+	// KeySet is a JSON Web Key Set. The keys are fetched again if they're
+	// older than the max age, or for unknown key IDs, so the rotated keys
+	// are picked up without restarting.
+	KeySet struct {
+		url    string
+		client *http.Client
+		maxAge time.Duration
+
+		mutex       sync.Mutex
+		keys        map[string]interface{}
+		lastRefresh time.Time
+	}
+
+	jwk struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+)
+
+// New creates a KeySet of the URL, the keys never expire if maxAge is
+// zero.
+func New(url string, client *http.Client, maxAge time.Duration) *KeySet {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &KeySet{url: url, client: client, maxAge: maxAge}
+}
+
+// refresh fetches the keys, the caller must hold the lock.
+func (ks *KeySet) refresh() error {
+	if time.Since(ks.lastRefresh) < MinRefreshInterval {
+		return nil
+	}
+	ks.lastRefresh = time.Now()
+
+	resp, err := ks.client.Get(ks.url)
+	if err != nil {
+		return fmt.Errorf("fetch keys failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("fetch keys failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch keys failed: unexpected status code %d", resp.StatusCode)
+	}
+
+	set := struct {
+		Keys []*jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return fmt.Errorf("parse keys failed: %v", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	ks.keys = keys
+	return nil
+}
+
+// Key returns the public key of the key ID.
+func (ks *KeySet) Key(kid string) (interface{}, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	expired := ks.maxAge > 0 && time.Since(ks.lastRefresh) > ks.maxAge
+	if key, ok := ks.keys[kid]; ok && !expired {
+		return key, nil
+	}
+
+	// NOTE: The cached keys are still used if the server is unavailable.
+	err := ks.refresh()
+	if key, ok := ks.keys[kid]; ok {
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// NOTE: The kid is optional if the server has only one key.
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("key %s not found", kid)
+}
+
+// Keyfunc is the jwt.Keyfunc returning the key of the kid of the token,
+// it accepts asymmetric signing methods only.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+	default:
+		// NOTE: HMAC and none are rejected, which are not signed by the
+		// keys of the server.
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	kid, _ := token.Header["kid"].(string)
+	return ks.Key(kid)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	buff, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(buff), nil
+}
+
+func (k *jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
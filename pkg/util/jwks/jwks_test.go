@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func encode(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func TestKeySet(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	fetches := 0
+	keys := []map[string]string{{
+		"kty": "RSA", "kid": "rsa1", "use": "sig",
+		"n": encode(rsaKey.N), "e": encode(big.NewInt(int64(rsaKey.E))),
+	}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer server.Close()
+
+	ks := New(server.URL, nil, 0)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "alice"})
+	token.Header["kid"] = "rsa1"
+	signed, _ := token.SignedString(rsaKey)
+	if _, err := jwt.Parse(signed, ks.Keyfunc); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// The kid is optional if there's only one key.
+	if _, err := ks.Key(""); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Keys are not fetched again within the min refresh interval.
+	keys = append(keys, map[string]string{
+		"kty": "EC", "kid": "ec1", "crv": "P-256",
+		"x": encode(ecKey.X), "y": encode(ecKey.Y),
+	})
+	if _, err := ks.Key("ec1"); err == nil {
+		t.Errorf("key ec1 should not be found")
+	}
+	if fetches != 1 {
+		t.Errorf("keys should be fetched once, but %d", fetches)
+	}
+
+	// The rotated keys are fetched for unknown key IDs.
+	ks.lastRefresh = time.Now().Add(-MinRefreshInterval)
+	token = jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"sub": "alice"})
+	token.Header["kid"] = "ec1"
+	signed, _ = token.SignedString(ecKey)
+	if _, err := jwt.Parse(signed, ks.Keyfunc); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// HMAC tokens are rejected.
+	token = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "alice"})
+	token.Header["kid"] = "rsa1"
+	signed, _ = token.SignedString([]byte("secret"))
+	if _, err := jwt.Parse(signed, ks.Keyfunc); err == nil {
+		t.Errorf("HMAC token should be rejected")
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write([]byte(`{"keys": [{"kty": "RSA", "kid": "k", "n": "AQAB", "e": "AQAB"}]}`))
+	}))
+	defer server.Close()
+
+	ks := New(server.URL, nil, time.Minute)
+	ks.Key("k")
+	ks.Key("k")
+	if fetches != 1 {
+		t.Errorf("keys should be fetched once, but %d", fetches)
+	}
+
+	ks.lastRefresh = time.Now().Add(-2 * time.Minute)
+	if _, err := ks.Key("k"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if fetches != 2 {
+		t.Errorf("expired keys should be fetched again, but %d", fetches)
+	}
+
+	// The cached keys are used if the server is unavailable.
+	server.Close()
+	ks.lastRefresh = time.Now().Add(-2 * time.Minute)
+	if _, err := ks.Key("k"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
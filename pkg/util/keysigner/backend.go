@@ -0,0 +1,196 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package keysigner
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/signer"
+)
+
+type (
+	// AWSKMSSpec is the spec of an asymmetric key of AWS KMS.
+	AWSKMSSpec struct {
+		Region string `yaml:"region" jsonschema:"required"`
+		// KeyID is the id, ARN or alias of the key.
+		KeyID string `yaml:"keyId" jsonschema:"required"`
+		// Endpoint defaults to https://kms.{region}.amazonaws.com.
+		Endpoint string `yaml:"endpoint" jsonschema:"omitempty"`
+		// The credential defaults to the environment variables
+		// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+		AccessKeyID     string `yaml:"accessKeyId" jsonschema:"omitempty"`
+		SecretAccessKey string `yaml:"secretAccessKey" jsonschema:"omitempty"`
+	}
+
+	// RemoteSpec is the spec of a remote signing service, e.g. a service
+	// in front of HSMs, which aren't supported directly. The service receives
+	// {"keyId": "...", "algorithm": "ECDSA_SHA_256", "digest": "<base64>"}
+	// and returns {"signature": "<base64>"}, algorithms are in the names
+	// of AWS KMS.
+	RemoteSpec struct {
+		URL     string            `yaml:"url" jsonschema:"required,format=url"`
+		KeyID   string            `yaml:"keyId" jsonschema:"omitempty"`
+		Headers map[string]string `yaml:"headers" jsonschema:"omitempty"`
+	}
+
+	awsKMS struct {
+		spec         *AWSKMSSpec
+		endpoint     string
+		sessionToken string
+		client       *http.Client
+		signer       *signer.Signer
+	}
+
+	remote struct {
+		spec   *RemoteSpec
+		client *http.Client
+	}
+
+	signRequest struct {
+		KeyID     string `json:"keyId"`
+		Algorithm string `json:"algorithm"`
+		Digest    string `json:"digest"`
+	}
+
+	signResponse struct {
+		Signature string `json:"signature"`
+	}
+)
+
+var awsLiteral = &signer.Literal{
+	ScopeSuffix:      "aws4_request",
+	AlgorithmName:    "X-Amz-Algorithm",
+	AlgorithmValue:   "AWS4-HMAC-SHA256",
+	SignedHeaders:    "X-Amz-SignedHeaders",
+	Signature:        "X-Amz-Signature",
+	Date:             "X-Amz-Date",
+	Expires:          "X-Amz-Expires",
+	Credential:       "X-Amz-Credential",
+	ContentSHA256:    "X-Amz-Content-Sha256",
+	SigningKeyPrefix: "AWS4",
+}
+
+func newAWSKMS(spec *AWSKMSSpec, client *http.Client) *awsKMS {
+	endpoint := spec.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://kms.%s.amazonaws.com", spec.Region)
+	}
+
+	id, secret, token := spec.AccessKeyID, spec.SecretAccessKey, ""
+	if id == "" {
+		id, secret = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+		token = os.Getenv("AWS_SESSION_TOKEN")
+	}
+
+	return &awsKMS{
+		spec:         spec,
+		endpoint:     endpoint,
+		sessionToken: token,
+		client:       client,
+		signer:       signer.New().SetLiteral(awsLiteral).SetCredential(id, secret),
+	}
+}
+
+func (k *awsKMS) sign(ctx context.Context, digest []byte, algorithm string) ([]byte, error) {
+	body, _ := json.Marshal(map[string]string{
+		"KeyId":            k.spec.KeyID,
+		"Message":          base64.StdEncoding.EncodeToString(digest),
+		"MessageType":      "DIGEST",
+		"SigningAlgorithm": algorithm,
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.Sign")
+	if k.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", k.sessionToken)
+	}
+	err = k.signer.NewContext(time.Now(), k.spec.Region, "kms").Sign(req)
+	if err != nil {
+		return nil, fmt.Errorf("sign request failed: %v", err)
+	}
+
+	resp := struct {
+		Signature string `json:"Signature"`
+	}{}
+	err = doJSON(k.client, req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Signature)
+}
+
+func newRemote(spec *RemoteSpec, client *http.Client) *remote {
+	return &remote{spec: spec, client: client}
+}
+
+func (r *remote) sign(ctx context.Context, digest []byte, algorithm string) ([]byte, error) {
+	body, _ := json.Marshal(&signRequest{
+		KeyID:     r.spec.KeyID,
+		Algorithm: algorithm,
+		Digest:    base64.StdEncoding.EncodeToString(digest),
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.spec.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range r.spec.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp := &signResponse{}
+	err = doJSON(r.client, req, resp)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Signature)
+}
+
+func doJSON(client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+	}
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return fmt.Errorf("unmarshal %s failed: %v", body, err)
+	}
+	return nil
+}
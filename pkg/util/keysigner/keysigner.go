@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package keysigner provides crypto.Signer backed by key management
+// services, so that private keys of TLS certificates never leave them.
+package keysigner
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// signTimeout is the deadline of a signing, it's a variable for tests.
+var signTimeout = 10 * time.Second
+
+type (
+	// Spec describes where the private key is, exactly one of the
+	// backends must be specified.
+	Spec struct {
+		AWSKMS *AWSKMSSpec `yaml:"awsKMS,omitempty" jsonschema:"omitempty"`
+		Remote *RemoteSpec `yaml:"remote,omitempty" jsonschema:"omitempty"`
+	}
+
+	// Signer is a crypto.Signer whose private key is in the backend.
+	Signer struct {
+		backend backend
+		public  crypto.PublicKey
+
+		mutex  sync.Mutex
+		status Status
+	}
+
+	// Status is the health of the signer, it's updated by every signing.
+	Status struct {
+		Healthy      bool      `yaml:"healthy"`
+		LastError    string    `yaml:"lastError,omitempty"`
+		LastSignedAt time.Time `yaml:"lastSignedAt,omitempty"`
+	}
+
+	backend interface {
+		sign(ctx context.Context, digest []byte, algorithm string) ([]byte, error)
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if (spec.AWSKMS == nil) == (spec.Remote == nil) {
+		return fmt.Errorf("exactly one of awsKMS and remote is required")
+	}
+	return nil
+}
+
+// New creates a Signer, public is the public key of the certificate,
+// it doesn't talk to the backend.
+func New(spec *Spec, public crypto.PublicKey) (*Signer, error) {
+	switch public.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", public)
+	}
+
+	s := &Signer{public: public, status: Status{Healthy: true}}
+	// NOTE: The timeout is the deadline of the context of every signing.
+	client := &http.Client{}
+	if spec.AWSKMS != nil {
+		s.backend = newAWSKMS(spec.AWSKMS, client)
+	} else {
+		s.backend = newRemote(spec.Remote, client)
+	}
+	return s, nil
+}
+
+// Public returns the public key.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign signs the digest by the backend.
+func (s *Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	algorithm, err := s.algorithm(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), signTimeout)
+	defer cancel()
+	signature, err := s.backend.sign(ctx, digest, algorithm)
+
+	s.mutex.Lock()
+	if err != nil {
+		s.status.Healthy = false
+		s.status.LastError = err.Error()
+	} else {
+		s.status = Status{Healthy: true, LastSignedAt: time.Now()}
+	}
+	s.mutex.Unlock()
+
+	return signature, err
+}
+
+// algorithm returns the signing algorithm in the names of AWS KMS,
+// which are used by the remote backend too.
+func (s *Signer) algorithm(opts crypto.SignerOpts) (string, error) {
+	var hash string
+	switch opts.HashFunc() {
+	case crypto.SHA256:
+		hash = "SHA_256"
+	case crypto.SHA384:
+		hash = "SHA_384"
+	case crypto.SHA512:
+		hash = "SHA_512"
+	default:
+		return "", fmt.Errorf("unsupported hash %v", opts.HashFunc())
+	}
+
+	switch s.public.(type) {
+	case *ecdsa.PublicKey:
+		return "ECDSA_" + hash, nil
+	default:
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			// NOTE: The salt length of backends is the length of the hash.
+			if pss.SaltLength != rsa.PSSSaltLengthEqualsHash && pss.SaltLength != opts.HashFunc().Size() {
+				return "", fmt.Errorf("unsupported salt length %d", pss.SaltLength)
+			}
+			return "RSASSA_PSS_" + hash, nil
+		}
+		return "RSASSA_PKCS1_V1_5_" + hash, nil
+	}
+}
+
+// Probe signs a random digest and verifies the signature by the public
+// key, so that an unavailable backend or a mismatched key is reported
+// before handshakes fail.
+func (s *Signer) Probe() error {
+	msg := make([]byte, 32)
+	rand.Read(msg)
+	digest := sha256.Sum256(msg)
+
+	signature, err := s.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return err
+	}
+
+	switch public := s.public.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(public, digest[:], signature) {
+			err = fmt.Errorf("signature mismatches the public key of the certificate")
+		}
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], signature)
+		if err != nil {
+			err = fmt.Errorf("signature mismatches the public key of the certificate: %v", err)
+		}
+	}
+
+	if err != nil {
+		s.mutex.Lock()
+		s.status.Healthy = false
+		s.status.LastError = err.Error()
+		s.mutex.Unlock()
+	}
+	return err
+}
+
+// Status returns the status of the signer.
+func (s *Signer) Status() *Status {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := s.status
+	return &status
+}
@@ -0,0 +1,268 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package keysigner
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signDigest(t *testing.T, key crypto.Signer, algorithm string, digest []byte) []byte {
+	var opts crypto.SignerOpts = crypto.SHA256
+	if strings.HasPrefix(algorithm, "RSASSA_PSS_") {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+	}
+	signature, err := key.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+	return signature
+}
+
+func newRemoteServer(t *testing.T, key crypto.Signer, algorithms *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		req := &signRequest{}
+		json.NewDecoder(r.Body).Decode(req)
+		*algorithms = append(*algorithms, req.Algorithm)
+		digest, _ := base64.StdEncoding.DecodeString(req.Digest)
+		signature := signDigest(t, key, req.Algorithm, digest)
+		json.NewEncoder(w).Encode(&signResponse{Signature: base64.StdEncoding.EncodeToString(signature)})
+	}))
+}
+
+func TestValidate(t *testing.T) {
+	if (Spec{}).Validate() == nil {
+		t.Errorf("empty spec should be invalid")
+	}
+	spec := Spec{AWSKMS: &AWSKMSSpec{}, Remote: &RemoteSpec{}}
+	if spec.Validate() == nil {
+		t.Errorf("spec with both backends should be invalid")
+	}
+	spec.AWSKMS = nil
+	if spec.Validate() != nil {
+		t.Errorf("spec with one backend should be valid")
+	}
+}
+
+func TestRemote(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	for _, key := range []crypto.Signer{ecKey, rsaKey} {
+		algorithms := []string{}
+		server := newRemoteServer(t, key, &algorithms)
+
+		spec := &Spec{Remote: &RemoteSpec{
+			URL:     server.URL,
+			KeyID:   "key",
+			Headers: map[string]string{"Authorization": "Bearer token"},
+		}}
+		s, err := New(spec, key.Public())
+		if err != nil {
+			t.Fatalf("new signer failed: %v", err)
+		}
+		if err = s.Probe(); err != nil {
+			t.Errorf("probe failed: %v", err)
+		}
+		if !s.Status().Healthy {
+			t.Errorf("signer should be healthy")
+		}
+
+		if _, ok := key.(*rsa.PrivateKey); ok {
+			digest := sha256.Sum256([]byte("hello"))
+			opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+			signature, err := s.Sign(rand.Reader, digest[:], opts)
+			if err != nil {
+				t.Fatalf("sign failed: %v", err)
+			}
+			if err = rsa.VerifyPSS(&rsaKey.PublicKey, crypto.SHA256, digest[:], signature, opts); err != nil {
+				t.Errorf("verify pss failed: %v", err)
+			}
+			if algorithms[1] != "RSASSA_PSS_SHA_256" {
+				t.Errorf("unexpected algorithm %s", algorithms[1])
+			}
+		} else if algorithms[0] != "ECDSA_SHA_256" {
+			t.Errorf("unexpected algorithm %s", algorithms[0])
+		}
+
+		server.Close()
+		if s.Probe() == nil {
+			t.Errorf("probe should fail when the server is down")
+		}
+		if s.Status().Healthy || s.Status().LastError == "" {
+			t.Errorf("signer should be unhealthy")
+		}
+	}
+}
+
+func TestMismatchedKey(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	algorithms := []string{}
+	server := newRemoteServer(t, key, &algorithms)
+	defer server.Close()
+
+	spec := &Spec{Remote: &RemoteSpec{
+		URL:     server.URL,
+		Headers: map[string]string{"Authorization": "Bearer token"},
+	}}
+	s, _ := New(spec, other.Public())
+	if s.Probe() == nil {
+		t.Errorf("probe should fail for mismatched key")
+	}
+	if s.Status().Healthy {
+		t.Errorf("signer should be unhealthy")
+	}
+}
+
+func TestSignTimeout(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	timeout := signTimeout
+	signTimeout = 100 * time.Millisecond
+	defer func() { signTimeout = timeout }()
+
+	s, _ := New(&Spec{Remote: &RemoteSpec{URL: server.URL}}, key.Public())
+	start := time.Now()
+	if s.Probe() == nil {
+		t.Errorf("probe should fail when the server hangs")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("signing should be bounded by the deadline")
+	}
+	if s.Status().Healthy {
+		t.Errorf("signer should be unhealthy")
+	}
+}
+
+func TestAWSKMS(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "TrentService.Sign" ||
+			!strings.Contains(r.Header.Get("Authorization"), "Credential=id/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/us-east-1/kms/aws4_request") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		req := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["KeyId"] != "alias/tls" || req["MessageType"] != "DIGEST" || req["SigningAlgorithm"] != "ECDSA_SHA_256" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		digest, _ := base64.StdEncoding.DecodeString(req["Message"])
+		signature := signDigest(t, key, req["SigningAlgorithm"], digest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"KeyId":     req["KeyId"],
+			"Signature": base64.StdEncoding.EncodeToString(signature),
+		})
+	}))
+	defer server.Close()
+
+	spec := &Spec{AWSKMS: &AWSKMSSpec{
+		Region:          "us-east-1",
+		KeyID:           "alias/tls",
+		Endpoint:        server.URL,
+		AccessKeyID:     "id",
+		SecretAccessKey: "secret",
+	}}
+	s, err := New(spec, key.Public())
+	if err != nil {
+		t.Fatalf("new signer failed: %v", err)
+	}
+	if err = s.Probe(); err != nil {
+		t.Errorf("probe failed: %v", err)
+	}
+
+	digest := sha256.Sum256([]byte("hello"))
+	if _, err = s.Sign(rand.Reader, digest[:], crypto.MD5); err == nil {
+		t.Errorf("sign with md5 should fail")
+	}
+}
+
+func TestTLSHandshake(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	algorithms := []string{}
+	server := newRemoteServer(t, key, &algorithms)
+	defer server.Close()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	pool := x509.NewCertPool()
+	leaf, _ := x509.ParseCertificate(der)
+	pool.AddCert(leaf)
+
+	s, _ := New(&Spec{Remote: &RemoteSpec{
+		URL:     server.URL,
+		Headers: map[string]string{"Authorization": "Bearer token"},
+	}}, leaf.PublicKey)
+
+	serverConn, clientConn := net.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		conn := tls.Server(serverConn, &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: s}},
+		})
+		errCh <- conn.Handshake()
+		conn.Close()
+	}()
+
+	conn := tls.Client(clientConn, &tls.Config{RootCAs: pool, ServerName: "example.com"})
+	if err := conn.Handshake(); err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	conn.Close()
+	if err := <-errCh; err != nil {
+		t.Fatalf("server handshake failed: %v", err)
+	}
+	if len(algorithms) == 0 {
+		t.Errorf("the handshake should be signed by the remote signer")
+	}
+}
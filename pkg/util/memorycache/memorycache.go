@@ -19,6 +19,8 @@ package memorycache
 
 import (
 	"bytes"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -27,6 +29,7 @@ import (
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/httprange"
 	"github.com/megaease/easegress/pkg/util/stringtool"
 )
 
@@ -108,15 +111,67 @@ func (mc *MemoryCache) Load(ctx context.HTTPContext) (loaded bool) {
 	v, ok := mc.cache.Get(mc.key(ctx))
 	if ok {
 		entry := v.(*cacheEntry)
-		w.SetStatusCode(entry.statusCode)
 		w.Header().AddFrom(entry.header)
-		w.SetBody(bytes.NewReader(entry.body))
+		if !mc.loadRange(ctx, entry) {
+			w.SetStatusCode(entry.statusCode)
+			w.SetBody(bytes.NewReader(entry.body))
+		}
 		ctx.AddTag("cacheLoad")
 	}
 
 	return ok
 }
 
+// loadRange serves partial content out of a cached full body.
+// Reference: https://tools.ietf.org/html/rfc7233
+func (mc *MemoryCache) loadRange(ctx context.HTTPContext, entry *cacheEntry) bool {
+	r, w := ctx.Request(), ctx.Response()
+
+	if entry.statusCode != http.StatusOK {
+		return false
+	}
+	w.Header().Set(httpheader.KeyAcceptRanges, httprange.Unit)
+
+	rangeValue := r.Header().Get(httpheader.KeyRange)
+	if r.Method() != http.MethodGet || rangeValue == "" {
+		return false
+	}
+	if !httprange.IfRangeMatch(r.Header().Get(httpheader.KeyIfRange),
+		entry.header.Get(httpheader.KeyETag), entry.header.Get(httpheader.KeyLastModified)) {
+		return false
+	}
+
+	size := int64(len(entry.body))
+	ranges, err := httprange.Parse(rangeValue, size)
+	if err != nil {
+		w.SetStatusCode(http.StatusRequestedRangeNotSatisfiable)
+		w.Header().Set(httpheader.KeyContentRange, httprange.UnsatisfiedContentRange(size))
+		w.Header().Del(httpheader.KeyContentLength)
+		w.SetBody(bytes.NewReader(nil))
+		return true
+	}
+	if ranges == nil {
+		return false
+	}
+
+	var body []byte
+	if len(ranges) == 1 {
+		ra := ranges[0]
+		body = entry.body[ra.Start : ra.Start+ra.Length]
+		w.Header().Set(httpheader.KeyContentRange, ra.ContentRange(size))
+	} else {
+		var contentType string
+		contentType, body = httprange.Multipart(ranges, entry.header.Get(httpheader.KeyContentType), entry.body)
+		w.Header().Set(httpheader.KeyContentType, contentType)
+	}
+	w.Header().Set(httpheader.KeyContentLength, strconv.Itoa(len(body)))
+	w.SetStatusCode(http.StatusPartialContent)
+	w.SetBody(bytes.NewReader(body))
+	ctx.AddTag("cacheLoadRange")
+
+	return true
+}
+
 // Store tries to store cache for HTTPContext.
 func (mc *MemoryCache) Store(ctx context.HTTPContext) {
 	r, w := ctx.Request(), ctx.Response()
@@ -139,7 +194,8 @@ func (mc *MemoryCache) Store(ctx context.HTTPContext) {
 			break
 		}
 	}
-	if !matchCode {
+	// Partial content must never be served as the full body.
+	if !matchCode || w.StatusCode() == http.StatusPartialContent {
 		return
 	}
 
@@ -0,0 +1,152 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package memorycache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+type mockedExchange struct {
+	ctx        *contexttest.MockedHTTPContext
+	w          *httptest.ResponseRecorder
+	reqHeader  *httpheader.HTTPHeader
+	respHeader *httpheader.HTTPHeader
+	flush      func(body []byte, complete bool) []byte
+}
+
+func newExchange(method string) *mockedExchange {
+	req := httptest.NewRequest(method, "/video.mp4", nil)
+	w := httptest.NewRecorder()
+	e := &mockedExchange{
+		ctx:        contexttest.NewMockedHTTPContext(req, w),
+		w:          w,
+		reqHeader:  httpheader.New(req.Header),
+		respHeader: httpheader.New(w.Header()),
+	}
+	e.ctx.MockedResponse.MockedOnFlushBody = func(fn func([]byte, bool) []byte) { e.flush = fn }
+
+	return e
+}
+
+func (e *mockedExchange) readBody(t *testing.T) string {
+	data, err := ioutil.ReadAll(e.ctx.Response().Body())
+	if err != nil {
+		t.Fatalf("read body failed: %v", err)
+	}
+	return string(data)
+}
+
+func newCache(codes ...int) *MemoryCache {
+	return New(&Spec{
+		Expiration:    "10s",
+		MaxEntryBytes: 1024,
+		Codes:         codes,
+		Methods:       []string{http.MethodGet},
+	})
+}
+
+func store(mc *MemoryCache, statusCode int, body string, header map[string]string) {
+	e := newExchange(http.MethodGet)
+	e.w.Code = statusCode
+	for k, v := range header {
+		e.respHeader.Set(k, v)
+	}
+	mc.Store(e.ctx)
+	if e.flush != nil {
+		e.flush([]byte(body), true)
+	}
+}
+
+func TestLoadRange(t *testing.T) {
+	mc := newCache(http.StatusOK)
+	store(mc, http.StatusOK, "0123456789", map[string]string{
+		httpheader.KeyContentLength: "10",
+		httpheader.KeyContentType:   "video/mp4",
+		httpheader.KeyETag:          `"v1"`,
+	})
+
+	e := newExchange(http.MethodGet)
+	if !mc.Load(e.ctx) {
+		t.Fatalf("cache should be loaded")
+	}
+	if e.w.Code != http.StatusOK || e.readBody(t) != "0123456789" {
+		t.Errorf("full body should be served")
+	}
+	if e.respHeader.Get(httpheader.KeyAcceptRanges) != "bytes" {
+		t.Errorf("Accept-Ranges should be set")
+	}
+
+	e = newExchange(http.MethodGet)
+	e.reqHeader.Set(httpheader.KeyRange, "bytes=2-5")
+	mc.Load(e.ctx)
+	if e.w.Code != http.StatusPartialContent {
+		t.Fatalf("want status 206, got %d", e.w.Code)
+	}
+	if body := e.readBody(t); body != "2345" {
+		t.Errorf("want body 2345, got %s", body)
+	}
+	if v := e.respHeader.Get(httpheader.KeyContentRange); v != "bytes 2-5/10" {
+		t.Errorf("unexpected Content-Range %s", v)
+	}
+	if v := e.respHeader.GetAll(httpheader.KeyContentLength); len(v) != 1 || v[0] != "4" {
+		t.Errorf("unexpected Content-Length %v", v)
+	}
+
+	e = newExchange(http.MethodGet)
+	e.reqHeader.Set(httpheader.KeyRange, "bytes=2-5")
+	e.reqHeader.Set(httpheader.KeyIfRange, `"v0"`)
+	mc.Load(e.ctx)
+	if e.w.Code != http.StatusOK || e.readBody(t) != "0123456789" {
+		t.Errorf("full body should be served on mismatched If-Range")
+	}
+
+	e = newExchange(http.MethodGet)
+	e.reqHeader.Set(httpheader.KeyRange, "bytes=0-0,-1")
+	mc.Load(e.ctx)
+	if e.w.Code != http.StatusPartialContent {
+		t.Fatalf("want status 206, got %d", e.w.Code)
+	}
+	if v := e.respHeader.Get(httpheader.KeyContentType); v[:len("multipart/byteranges")] != "multipart/byteranges" {
+		t.Errorf("unexpected Content-Type %s", v)
+	}
+
+	e = newExchange(http.MethodGet)
+	e.reqHeader.Set(httpheader.KeyRange, "bytes=10-")
+	mc.Load(e.ctx)
+	if e.w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("want status 416, got %d", e.w.Code)
+	}
+	if v := e.respHeader.Get(httpheader.KeyContentRange); v != "bytes */10" {
+		t.Errorf("unexpected Content-Range %s", v)
+	}
+}
+
+func TestStorePartialContent(t *testing.T) {
+	mc := newCache(http.StatusOK, http.StatusPartialContent)
+	store(mc, http.StatusPartialContent, "2345", nil)
+
+	e := newExchange(http.MethodGet)
+	if mc.Load(e.ctx) {
+		t.Errorf("partial content should not be cached")
+	}
+}
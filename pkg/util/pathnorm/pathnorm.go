@@ -0,0 +1,275 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package pathnorm normalizes the paths of requests before routing, so
+// equivalent paths are routed and sent to servers in the same form.
+// Reference: https://tools.ietf.org/html/rfc3986#section-6.2.2
+package pathnorm
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	// CaseLower lowercases the paths.
+	CaseLower = "lower"
+)
+
+type (
+	// Spec describes the normalization of paths.
+	Spec struct {
+		// DecodeUnreserved decodes the percent-encoded unreserved
+		// characters, and uppercases the hex digits of the others.
+		DecodeUnreserved bool `yaml:"decodeUnreserved" jsonschema:"omitempty"`
+		// RemoveDotSegments removes the segments . and .. of paths.
+		RemoveDotSegments bool `yaml:"removeDotSegments" jsonschema:"omitempty"`
+		// MergeSlashes merges the duplicate slashes of paths.
+		MergeSlashes bool `yaml:"mergeSlashes" jsonschema:"omitempty"`
+		// Case is empty to keep the case of paths, or lower to
+		// lowercase them.
+		Case string `yaml:"case" jsonschema:"omitempty,enum=,enum=lower"`
+		// Strict rejects the paths with suspicious encodings, which
+		// are usually used to bypass the rules of routing.
+		Strict bool `yaml:"strict" jsonschema:"omitempty"`
+	}
+
+	// Normalizer normalizes paths by the Spec.
+	Normalizer struct {
+		spec *Spec
+	}
+)
+
+// New creates a Normalizer.
+func New(spec *Spec) *Normalizer {
+	return &Normalizer{spec: spec}
+}
+
+// Normalize normalizes the path of the URL in place, the escaped path is
+// always consistent with the path.
+func (n *Normalizer) Normalize(u *url.URL) error {
+	escaped := u.EscapedPath()
+	p, err := n.NormalizePath(escaped)
+	if err != nil {
+		return err
+	}
+	if p == escaped {
+		return nil
+	}
+
+	path, err := url.PathUnescape(p)
+	if err != nil {
+		return err
+	}
+	u.Path, u.RawPath = path, p
+	return nil
+}
+
+// NormalizePath normalizes the escaped path, and returns the escaped one.
+func (n *Normalizer) NormalizePath(p string) (string, error) {
+	if n.spec.Strict {
+		if err := checkStrict(p); err != nil {
+			return "", err
+		}
+	}
+
+	lower := n.spec.Case == CaseLower
+	if n.spec.DecodeUnreserved || lower {
+		var err error
+		if p, err = rewrite(p, n.spec.DecodeUnreserved, lower); err != nil {
+			return "", err
+		}
+	}
+	if n.spec.MergeSlashes {
+		p = mergeSlashes(p)
+	}
+	if n.spec.RemoveDotSegments {
+		var err error
+		if p, err = removeDotSegments(p, n.spec.Strict); err != nil {
+			return "", err
+		}
+	}
+	return p, nil
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// decodeAt decodes the percent-encoding at the index i of p.
+func decodeAt(p string, i int) (byte, error) {
+	if i+2 >= len(p) {
+		return 0, fmt.Errorf("invalid percent-encoding %s", p[i:])
+	}
+	h, ok1 := unhex(p[i+1])
+	l, ok2 := unhex(p[i+2])
+	if !ok1 || !ok2 {
+		return 0, fmt.Errorf("invalid percent-encoding %s", p[i:i+3])
+	}
+	return h<<4 | l, nil
+}
+
+func isUnreserved(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// rewrite decodes the unreserved characters and lowercases the path in
+// one pass, so the decoded characters are lowercased as well, while the
+// hex digits of percent-encodings are always uppercase.
+func rewrite(p string, decode, lower bool) (string, error) {
+	const upperhex = "0123456789ABCDEF"
+
+	var sb strings.Builder
+	sb.Grow(len(p))
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c != '%' {
+			if lower {
+				c = toLower(c)
+			}
+			sb.WriteByte(c)
+			continue
+		}
+
+		v, err := decodeAt(p, i)
+		if err != nil {
+			return "", err
+		}
+		i += 2
+		if decode && isUnreserved(v) {
+			if lower {
+				v = toLower(v)
+			}
+			sb.WriteByte(v)
+			continue
+		}
+		if lower && v >= 'A' && v <= 'Z' {
+			v = toLower(v)
+		}
+		sb.WriteByte('%')
+		sb.WriteByte(upperhex[v>>4])
+		sb.WriteByte(upperhex[v&15])
+	}
+	return sb.String(), nil
+}
+
+func mergeSlashes(p string) string {
+	if !strings.Contains(p, "//") {
+		return p
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(p))
+	for i := 0; i < len(p); i++ {
+		if p[i] == '/' && i > 0 && p[i-1] == '/' {
+			continue
+		}
+		sb.WriteByte(p[i])
+	}
+	return sb.String()
+}
+
+// removeDotSegments removes the dot segments of the absolute path, it
+// returns an error if the path escapes the root in strict mode.
+// Reference: https://tools.ietf.org/html/rfc3986#section-5.2.4
+func removeDotSegments(p string, strict bool) (string, error) {
+	if !strings.HasPrefix(p, "/") || !strings.Contains(p, ".") {
+		return p, nil
+	}
+
+	segments := strings.Split(p[1:], "/")
+	out := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+		case "..":
+			if len(out) == 0 {
+				if strict {
+					return "", fmt.Errorf("path escapes the root")
+				}
+			} else {
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, segment)
+			continue
+		}
+
+		// NOTE: The path ending with a dot segment is a directory.
+		if last {
+			out = append(out, "")
+		}
+	}
+	return "/" + strings.Join(out, "/"), nil
+}
+
+// checkStrict checks the suspicious encodings of the escaped path.
+func checkStrict(p string) error {
+	if strings.IndexByte(p, '\\') >= 0 {
+		return fmt.Errorf("backslash in path")
+	}
+
+	for _, segment := range strings.Split(p, "/") {
+		if strings.IndexByte(segment, '%') < 0 {
+			continue
+		}
+
+		for i := 0; i < len(segment); i++ {
+			if segment[i] != '%' {
+				continue
+			}
+			v, err := decodeAt(segment, i)
+			if err != nil {
+				return err
+			}
+			switch {
+			case v == '/':
+				return fmt.Errorf("encoded slash in path")
+			case v == '\\':
+				return fmt.Errorf("encoded backslash in path")
+			case v == '%':
+				return fmt.Errorf("double percent-encoding in path")
+			case v < 0x20 || v == 0x7f:
+				return fmt.Errorf("encoded control character in path")
+			}
+			i += 2
+		}
+
+		if s, _ := url.PathUnescape(segment); s == "." || s == ".." {
+			return fmt.Errorf("encoded dot segment in path")
+		}
+	}
+	return nil
+}
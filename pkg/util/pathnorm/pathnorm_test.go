@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pathnorm
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNormalizePath(t *testing.T) {
+	all := &Spec{DecodeUnreserved: true, RemoveDotSegments: true, MergeSlashes: true}
+
+	cases := []struct {
+		spec     *Spec
+		path     string
+		expected string
+	}{
+		{&Spec{}, "/a/../b//%7e", "/a/../b//%7e"},
+		{all, "/", "/"},
+		{all, "/a/b/c", "/a/b/c"},
+		{all, "/%7Euser/%61%2d%5F", "/~user/a-_"},
+		{all, "/a%2fb%c3%a9", "/a%2Fb%C3%A9"},
+		{all, "/a//b///c", "/a/b/c"},
+		{all, "/a/./b/../c", "/a/c"},
+		{all, "/a/b/..", "/a/"},
+		{all, "/a/b/.", "/a/b/"},
+		{all, "/../../a", "/a"},
+		{all, "/a/%2E%2E/b", "/b"},
+		{all, "/a/.hidden/..b", "/a/.hidden/..b"},
+		{all, "/a//../b", "/b"},
+		{&Spec{RemoveDotSegments: true}, "/a//../b", "/a/b"},
+		{&Spec{Case: CaseLower}, "/API/Users%2F%41", "/api/users%2F%61"},
+		{&Spec{Case: CaseLower, DecodeUnreserved: true}, "/API/%41", "/api/a"},
+		{all, "*", "*"},
+	}
+
+	for _, c := range cases {
+		got, err := New(c.spec).NormalizePath(c.path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.path, err)
+			continue
+		}
+		if got != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.path, c.expected, got)
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	n := New(&Spec{DecodeUnreserved: true, RemoveDotSegments: true, Strict: true})
+
+	for _, p := range []string{
+		"/a%2Fb",
+		"/a%5cb",
+		"/a\\b",
+		"/a%252e",
+		"/a%00",
+		"/a%0a",
+		"/a/%2e%2E/b",
+		"/a/%2e",
+		"/../a",
+		"/a/../../b",
+		"/a%zz",
+		"/a%2",
+	} {
+		if _, err := n.NormalizePath(p); err == nil {
+			t.Errorf("%s should be rejected", p)
+		}
+	}
+
+	for _, p := range []string{"/a/../b", "/a%20b", "/%C3%A9", "/a.b/..c"} {
+		if _, err := n.NormalizePath(p); err != nil {
+			t.Errorf("%s: unexpected error: %v", p, err)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	n := New(&Spec{DecodeUnreserved: true, RemoveDotSegments: true, MergeSlashes: true})
+
+	u, _ := url.Parse("http://example.com/a//b/../%7Ec%2Fd%20e?x=1")
+	if err := n.Normalize(u); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if u.Path != "/a/~c/d e" || u.EscapedPath() != "/a/~c%2Fd%20e" || u.RawQuery != "x=1" {
+		t.Errorf("unexpected url: path %s, escaped path %s", u.Path, u.EscapedPath())
+	}
+
+	u, _ = url.Parse("http://example.com/a/b")
+	if err := n.Normalize(u); err != nil || u.Path != "/a/b" || u.RawPath != "" {
+		t.Errorf("unexpected url: %v, %v", u, err)
+	}
+}
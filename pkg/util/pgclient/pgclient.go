@@ -0,0 +1,380 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+// Package pgclient is a minimal PostgreSQL client of the simple query
+// protocol, which is enough to execute statements without results, e.g.
+// inserting rows. It supports trust, cleartext, MD5 and SCRAM-SHA-256
+// authentication.
+package pgclient
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const protocolVersion = 196608 // 3.0
+
+type (
+	// Options is the options of connections.
+	Options struct {
+		// Address is host:port of the server.
+		Address  string
+		Database string
+		Username string
+		Password string
+		// TLS is the TLS config, the connection is not encrypted if it's nil.
+		TLS     *tls.Config
+		Timeout time.Duration
+	}
+
+	// Conn is a connection to PostgreSQL, it's not safe for concurrent use.
+	Conn struct {
+		conn    net.Conn
+		r       *bufio.Reader
+		timeout time.Duration
+	}
+
+	// Error is an error reported by the server.
+	Error struct {
+		Severity string
+		Code     string
+		Message  string
+	}
+)
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s (SQLSTATE %s)", e.Severity, e.Message, e.Code)
+}
+
+// Connect connects to the server and authenticates.
+func Connect(opts *Options) (*Conn, error) {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	conn, err := net.DialTimeout("tcp", opts.Address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{conn: conn, r: bufio.NewReader(conn), timeout: timeout}
+	c.setDeadline()
+
+	if err = c.startup(opts); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Conn) setDeadline() {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+}
+
+func (c *Conn) startup(opts *Options) error {
+	if opts.TLS != nil {
+		// SSLRequest
+		msg := make([]byte, 8)
+		binary.BigEndian.PutUint32(msg, 8)
+		binary.BigEndian.PutUint32(msg[4:], 80877103)
+		if _, err := c.conn.Write(msg); err != nil {
+			return err
+		}
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != 'S' {
+			return fmt.Errorf("server does not support tls")
+		}
+		config := opts.TLS.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(opts.Address)
+		}
+		c.conn = tls.Client(c.conn, config)
+		c.r = bufio.NewReader(c.conn)
+	}
+
+	body := make([]byte, 4)
+	binary.BigEndian.PutUint32(body, protocolVersion)
+	for _, kv := range [][2]string{{"user", opts.Username}, {"database", opts.Database}, {"client_encoding", "UTF8"}} {
+		if kv[1] == "" {
+			continue
+		}
+		body = append(body, kv[0]...)
+		body = append(body, 0)
+		body = append(body, kv[1]...)
+		body = append(body, 0)
+	}
+	body = append(body, 0)
+	if err := c.send(0, body); err != nil {
+		return err
+	}
+
+	var scram *scramClient
+	for {
+		typ, msg, err := c.receive()
+		if err != nil {
+			return err
+		}
+
+		switch typ {
+		case 'R':
+			if len(msg) < 4 {
+				return fmt.Errorf("invalid authentication message")
+			}
+			switch code := binary.BigEndian.Uint32(msg); code {
+			case 0: // AuthenticationOk
+			case 3: // AuthenticationCleartextPassword
+				err = c.send('p', append([]byte(opts.Password), 0))
+			case 5: // AuthenticationMD5Password
+				if len(msg) < 8 {
+					return fmt.Errorf("invalid md5 authentication message")
+				}
+				err = c.send('p', append([]byte(md5Password(opts.Username, opts.Password, msg[4:8])), 0))
+			case 10: // AuthenticationSASL
+				if !strings.Contains(string(msg[4:]), "SCRAM-SHA-256\x00") {
+					return fmt.Errorf("unsupported sasl mechanisms")
+				}
+				scram = newSCRAMClient(opts.Password)
+				first := scram.clientFirst()
+				body := append([]byte("SCRAM-SHA-256\x00"), 0, 0, 0, 0)
+				binary.BigEndian.PutUint32(body[len(body)-4:], uint32(len(first)))
+				err = c.send('p', append(body, first...))
+			case 11: // AuthenticationSASLContinue
+				if scram == nil {
+					return fmt.Errorf("unexpected sasl continue")
+				}
+				var final string
+				final, err = scram.clientFinal(string(msg[4:]))
+				if err == nil {
+					err = c.send('p', []byte(final))
+				}
+			case 12: // AuthenticationSASLFinal
+				if scram == nil || !scram.verify(string(msg[4:])) {
+					return fmt.Errorf("invalid server signature")
+				}
+			default:
+				return fmt.Errorf("unsupported authentication method %d", code)
+			}
+			if err != nil {
+				return err
+			}
+		case 'E':
+			return parseError(msg)
+		case 'Z':
+			return nil
+		}
+	}
+}
+
+func md5Password(username, password string, salt []byte) string {
+	sum := md5.Sum([]byte(password + username))
+	sum = md5.Sum(append([]byte(hex.EncodeToString(sum[:])), salt...))
+	return "md5" + hex.EncodeToString(sum[:])
+}
+
+func (c *Conn) send(typ byte, body []byte) error {
+	msg := make([]byte, 0, len(body)+5)
+	if typ != 0 {
+		msg = append(msg, typ)
+	}
+	msg = append(msg, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(msg[len(msg)-4:], uint32(len(body)+4))
+	msg = append(msg, body...)
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+func (c *Conn) receive() (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return 0, nil, err
+	}
+	size := int(binary.BigEndian.Uint32(header[1:])) - 4
+	if size < 0 || size > 64*1024*1024 {
+		return 0, nil, fmt.Errorf("invalid message size %d", size)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(c.r, msg); err != nil {
+		return 0, nil, err
+	}
+	return header[0], msg, nil
+}
+
+func parseError(msg []byte) error {
+	e := &Error{}
+	for _, field := range strings.Split(string(msg), "\x00") {
+		if field == "" {
+			continue
+		}
+		switch field[0] {
+		case 'S':
+			e.Severity = field[1:]
+		case 'C':
+			e.Code = field[1:]
+		case 'M':
+			e.Message = field[1:]
+		}
+	}
+	return e
+}
+
+// Exec executes the statements by the simple query protocol, results
+// are discarded. The connection is still usable after server errors.
+func (c *Conn) Exec(query string) error {
+	c.setDeadline()
+	if err := c.send('Q', append([]byte(query), 0)); err != nil {
+		return err
+	}
+
+	var execErr error
+	for {
+		typ, msg, err := c.receive()
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case 'E':
+			if execErr == nil {
+				execErr = parseError(msg)
+			}
+		case 'Z':
+			return execErr
+		}
+	}
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	c.send('X', nil)
+	return c.conn.Close()
+}
+
+// QuoteIdentifier quotes the identifier, dots separate
+// the schema and the table, e.g. analytics.requests.
+func QuoteIdentifier(s string) string {
+	parts := strings.Split(s, ".")
+	for i, p := range parts {
+		parts[i] = `"` + strings.ReplaceAll(p, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+// QuoteLiteral quotes the string literal, it assumes
+// standard_conforming_strings is on, which is the default.
+func QuoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, "\x00", ""), "'", "''") + "'"
+}
+
+type scramClient struct {
+	password    string
+	clientNonce string
+	firstBare   string
+	serverSig   []byte
+}
+
+func newSCRAMClient(password string) *scramClient {
+	nonce := make([]byte, 18)
+	rand.Read(nonce)
+	return &scramClient{password: password, clientNonce: base64.RawStdEncoding.EncodeToString(nonce)}
+}
+
+func (s *scramClient) clientFirst() string {
+	// NOTE: The user name is in the startup message, so it's empty here.
+	s.firstBare = "n=,r=" + s.clientNonce
+	return "n,," + s.firstBare
+}
+
+func (s *scramClient) clientFinal(serverFirst string) (string, error) {
+	var nonce, salt string
+	iterations := 0
+	for _, attr := range strings.Split(serverFirst, ",") {
+		if len(attr) < 2 || attr[1] != '=' {
+			continue
+		}
+		switch attr[0] {
+		case 'r':
+			nonce = attr[2:]
+		case 's':
+			salt = attr[2:]
+		case 'i':
+			iterations, _ = strconv.Atoi(attr[2:])
+		}
+	}
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil || !strings.HasPrefix(nonce, s.clientNonce) || iterations <= 0 {
+		return "", fmt.Errorf("invalid sasl server first message")
+	}
+
+	finalBare := "c=biws,r=" + nonce
+	authMessage := s.firstBare + "," + serverFirst + "," + finalBare
+
+	salted := pbkdf2SHA256([]byte(s.password), saltBytes, iterations)
+	clientKey := hmacSHA256(salted, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	clientSig := hmacSHA256(storedKey[:], []byte(authMessage))
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ clientSig[i]
+	}
+
+	serverKey := hmacSHA256(salted, []byte("Server Key"))
+	s.serverSig = hmacSHA256(serverKey, []byte(authMessage))
+
+	return finalBare + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func (s *scramClient) verify(serverFinal string) bool {
+	if !strings.HasPrefix(serverFinal, "v=") {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(serverFinal[2:]))
+	return err == nil && hmac.Equal(sig, s.serverSig)
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// pbkdf2SHA256 derives a key of the size of SHA256, which is the
+// only size SCRAM-SHA-256 needs.
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	u := hmacSHA256(password, append(append([]byte{}, salt...), 0, 0, 0, 1))
+	result := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		u = hmacSHA256(password, u)
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
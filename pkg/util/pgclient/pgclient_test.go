@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package pgclient
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	got := hex.EncodeToString(pbkdf2SHA256([]byte("password"), []byte("salt"), 4096))
+	want := "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"
+	if got != want {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if got := QuoteIdentifier(`analytics.re"qs`); got != `"analytics"."re""qs"` {
+		t.Errorf("unexpected identifier %s", got)
+	}
+	if got := QuoteLiteral("it's"); got != "'it''s'" {
+		t.Errorf("unexpected literal %s", got)
+	}
+}
+
+// fakeServer is a PostgreSQL server accepting the password, it records
+// queries and fails queries containing "fail".
+type fakeServer struct {
+	listener net.Listener
+	auth     string
+	queries  chan string
+}
+
+func newFakeServer(t *testing.T, auth string) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	s := &fakeServer{listener: l, auth: auth, queries: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	send := func(typ byte, body []byte) {
+		msg := []byte{typ, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(msg[1:], uint32(len(body)+4))
+		conn.Write(append(msg, body...))
+	}
+	auth := func(code uint32, data []byte) {
+		body := make([]byte, 4)
+		binary.BigEndian.PutUint32(body, code)
+		send('R', append(body, data...))
+	}
+	receive := func(typed bool) (byte, []byte) {
+		var typ byte
+		if typed {
+			typ, _ = r.ReadByte()
+		}
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return 0, nil
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header)-4)
+		io.ReadFull(r, body)
+		return typ, body
+	}
+
+	_, startup := receive(false)
+	if !strings.Contains(string(startup), "user\x00alice\x00") {
+		return
+	}
+
+	switch s.auth {
+	case "md5":
+		salt := []byte{1, 2, 3, 4}
+		auth(5, salt)
+		_, password := receive(true)
+		if string(password) != md5Password("alice", "secret", salt)+"\x00" {
+			send('E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00"))
+			return
+		}
+	case "scram":
+		auth(10, []byte("SCRAM-SHA-256\x00\x00"))
+		_, first := receive(true)
+		clientFirst := string(first[len("SCRAM-SHA-256\x00")+4:])
+		firstBare := strings.TrimPrefix(clientFirst, "n,,")
+		nonce := strings.TrimPrefix(firstBare, "n=,r=") + "server"
+		serverFirst := "r=" + nonce + ",s=" + base64.StdEncoding.EncodeToString([]byte("salt")) + ",i=4096"
+		auth(11, []byte(serverFirst))
+
+		_, final := receive(true)
+		clientFinal := string(final)
+		i := strings.Index(clientFinal, ",p=")
+		proof, _ := base64.StdEncoding.DecodeString(clientFinal[i+3:])
+		authMessage := firstBare + "," + serverFirst + "," + clientFinal[:i]
+
+		salted := pbkdf2SHA256([]byte("secret"), []byte("salt"), 4096)
+		storedKey := sha256.Sum256(hmacSHA256(salted, []byte("Client Key")))
+		clientSig := hmacSHA256(storedKey[:], []byte(authMessage))
+		clientKey := make([]byte, len(proof))
+		for j := range proof {
+			clientKey[j] = proof[j] ^ clientSig[j]
+		}
+		if sha256.Sum256(clientKey) != storedKey {
+			send('E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00"))
+			return
+		}
+		serverSig := hmacSHA256(hmacSHA256(salted, []byte("Server Key")), []byte(authMessage))
+		auth(12, []byte("v="+base64.StdEncoding.EncodeToString(serverSig)))
+	}
+	auth(0, nil)
+	send('Z', []byte{'I'})
+
+	for {
+		typ, body := receive(true)
+		switch typ {
+		case 'Q':
+			query := strings.TrimSuffix(string(body), "\x00")
+			if strings.Contains(query, "fail") {
+				send('E', []byte("SERROR\x00C42P01\x00Mrelation does not exist\x00\x00"))
+			} else {
+				s.queries <- query
+				send('C', []byte("INSERT 0 1\x00"))
+			}
+			send('Z', []byte{'I'})
+		default:
+			return
+		}
+	}
+}
+
+func TestConn(t *testing.T) {
+	for _, auth := range []string{"trust", "md5", "scram"} {
+		s := newFakeServer(t, auth)
+
+		c, err := Connect(&Options{Address: s.listener.Addr().String(), Username: "alice", Password: "secret"})
+		if err != nil {
+			t.Fatalf("%s: connect failed: %v", auth, err)
+		}
+
+		err = c.Exec("INSERT INTO fail VALUES (1)")
+		if e, ok := err.(*Error); !ok || e.Code != "42P01" {
+			t.Fatalf("%s: unexpected error %v", auth, err)
+		}
+		if err = c.Exec("INSERT INTO t VALUES (1)"); err != nil {
+			t.Fatalf("%s: exec failed: %v", auth, err)
+		}
+		if q := <-s.queries; q != "INSERT INTO t VALUES (1)" {
+			t.Fatalf("%s: unexpected query %s", auth, q)
+		}
+		c.Close()
+
+		if auth != "trust" {
+			_, err = Connect(&Options{Address: s.listener.Addr().String(), Username: "alice", Password: "wrong"})
+			if err == nil {
+				t.Fatalf("%s: wrong password should fail", auth)
+			}
+		}
+		s.listener.Close()
+	}
+}
@@ -0,0 +1,257 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package propagation controls the trace context and baggage headers
+// propagated from clients, so that spoofed headers of untrusted clients
+// won't pollute the internal telemetry.
+package propagation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// KeyTraceparent is the key of traceparent of W3C Trace Context.
+	KeyTraceparent = "Traceparent"
+	// KeyTracestate is the key of tracestate of W3C Trace Context.
+	KeyTracestate = "Tracestate"
+	// KeyBaggage is the key of baggage of W3C Baggage.
+	KeyBaggage = "Baggage"
+)
+
+// wellKnownHeaders are the propagation headers of the popular tracing
+// systems, which are stripped unless they are trusted.
+var wellKnownHeaders = canonicalNames([]string{
+	KeyTraceparent, KeyTracestate, KeyBaggage,
+	"B3", "X-B3-*", "Uber-Trace-Id", "Uberctx-*", "Ot-Tracer-*", "Ot-Baggage-*",
+	"X-Cloud-Trace-Context", "X-Amzn-Trace-Id", "Sw8", "Sw8-*",
+})
+
+var traceparentRE = regexp.MustCompile(`^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+type (
+	// Spec describes the Propagator.
+	Spec struct {
+		// Trusted are the propagation headers trusted from clients, the
+		// other well-known ones are stripped, a name ending with `*`
+		// matches all headers with the prefix.
+		Trusted []string `yaml:"trusted" jsonschema:"omitempty,uniqueItems=true"`
+		// Strip are the extra headers to strip, even if they're trusted.
+		Strip []string `yaml:"strip" jsonschema:"omitempty,uniqueItems=true"`
+		// GenerateTraceparent generates traceparent if there is no valid
+		// one trusted.
+		GenerateTraceparent bool `yaml:"generateTraceparent" jsonschema:"omitempty"`
+
+		Baggage *BaggageSpec `yaml:"baggage" jsonschema:"omitempty"`
+	}
+
+	// BaggageSpec describes the baggage entries.
+	BaggageSpec struct {
+		// AllowedKeys are the keys of baggage entries accepted from
+		// clients, empty means all keys, it requires baggage is trusted.
+		AllowedKeys []string `yaml:"allowedKeys" jsonschema:"omitempty,uniqueItems=true"`
+		// Required are the keys of baggage entries which requests must have.
+		Required []string `yaml:"required" jsonschema:"omitempty,uniqueItems=true"`
+		// Set are the baggage entries generated by the gateway, which
+		// override the ones of clients.
+		Set map[string]string `yaml:"set" jsonschema:"omitempty"`
+	}
+
+	// Propagator applies the spec to requests.
+	Propagator struct {
+		spec    *Spec
+		trusted []string
+		strip   []string
+	}
+
+	// RequiredBaggageError is the error of missing required baggage entries.
+	RequiredBaggageError struct {
+		Key string
+	}
+)
+
+func (e *RequiredBaggageError) Error() string {
+	return fmt.Sprintf("baggage %s is required", e.Key)
+}
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	for _, name := range append(append([]string{}, spec.Trusted...), spec.Strip...) {
+		if strings.TrimSuffix(name, "*") == "" {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	return nil
+}
+
+// Validate validates BaggageSpec.
+func (spec BaggageSpec) Validate() error {
+	for key := range spec.Set {
+		if !validBaggageKey(key) {
+			return fmt.Errorf("invalid baggage key %q", key)
+		}
+	}
+	for _, key := range append(append([]string{}, spec.AllowedKeys...), spec.Required...) {
+		if !validBaggageKey(key) {
+			return fmt.Errorf("invalid baggage key %q", key)
+		}
+	}
+	return nil
+}
+
+func validBaggageKey(key string) bool {
+	return key != "" && !strings.ContainsAny(key, " \t,;=")
+}
+
+func canonicalNames(names []string) []string {
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		if strings.HasSuffix(name, "*") {
+			result = append(result, textproto.CanonicalMIMEHeaderKey(strings.TrimSuffix(name, "*"))+"*")
+		} else {
+			result = append(result, textproto.CanonicalMIMEHeaderKey(name))
+		}
+	}
+	return result
+}
+
+func matchName(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(p, "*")) {
+				return true
+			}
+		} else if p == key {
+			return true
+		}
+	}
+	return false
+}
+
+// New creates a Propagator, the spec must be valid.
+func New(spec *Spec) *Propagator {
+	return &Propagator{
+		spec:    spec,
+		trusted: canonicalNames(spec.Trusted),
+		strip:   canonicalNames(spec.Strip),
+	}
+}
+
+// Apply strips the untrusted headers, rewrites the baggage and generates
+// traceparent of the request header, it returns RequiredBaggageError if
+// the required baggage entries are missing.
+func (p *Propagator) Apply(h http.Header) error {
+	for key := range h {
+		if matchName(p.strip, key) || (matchName(wellKnownHeaders, key) && !matchName(p.trusted, key)) {
+			h.Del(key)
+		}
+	}
+
+	if tp := h.Get(KeyTraceparent); tp != "" && !validTraceparent(tp) {
+		h.Del(KeyTraceparent)
+		h.Del(KeyTracestate)
+	}
+	if p.spec.GenerateTraceparent && h.Get(KeyTraceparent) == "" {
+		h.Del(KeyTracestate)
+		h.Set(KeyTraceparent, newTraceparent())
+	}
+
+	if p.spec.Baggage != nil {
+		return p.applyBaggage(h)
+	}
+	return nil
+}
+
+func (p *Propagator) applyBaggage(h http.Header) error {
+	spec := p.spec.Baggage
+
+	var members []string
+	keys := map[string]bool{}
+	for _, v := range h.Values(KeyBaggage) {
+		for _, member := range strings.Split(v, ",") {
+			member = strings.TrimSpace(member)
+			key := strings.TrimSpace(strings.SplitN(strings.SplitN(member, ";", 2)[0], "=", 2)[0])
+			if key == "" || !strings.Contains(member, "=") {
+				continue
+			}
+			if len(spec.AllowedKeys) != 0 && !contains(spec.AllowedKeys, key) {
+				continue
+			}
+			if _, exists := spec.Set[key]; exists {
+				continue
+			}
+			members = append(members, member)
+			keys[key] = true
+		}
+	}
+
+	setKeys := make([]string, 0, len(spec.Set))
+	for key := range spec.Set {
+		setKeys = append(setKeys, key)
+	}
+	sort.Strings(setKeys)
+	for _, key := range setKeys {
+		members = append(members, key+"="+url.PathEscape(spec.Set[key]))
+		keys[key] = true
+	}
+
+	h.Del(KeyBaggage)
+	if len(members) != 0 {
+		h.Set(KeyBaggage, strings.Join(members, ","))
+	}
+
+	for _, key := range spec.Required {
+		if !keys[key] {
+			return &RequiredBaggageError{Key: key}
+		}
+	}
+	return nil
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func validTraceparent(tp string) bool {
+	if !traceparentRE.MatchString(tp) || strings.HasPrefix(tp, "ff") {
+		return false
+	}
+	parts := strings.Split(tp, "-")
+	return strings.Trim(parts[1], "0") != "" && strings.Trim(parts[2], "0") != ""
+}
+
+// newTraceparent generates a sampled traceparent of random IDs.
+func newTraceparent() string {
+	ids := make([]byte, 24)
+	rand.Read(ids)
+	// NOTE: All-zero IDs are invalid, the chance is negligible but cheap to avoid.
+	ids[15], ids[23] = ids[15]|1, ids[23]|1
+	return "00-" + hex.EncodeToString(ids[:16]) + "-" + hex.EncodeToString(ids[16:]) + "-01"
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package propagation
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestSpecValidate(t *testing.T) {
+	if (Spec{Trusted: []string{"*"}}).Validate() == nil {
+		t.Error("validate should fail")
+	}
+	if (Spec{Trusted: []string{"traceparent", "x-b3-*"}}).Validate() != nil {
+		t.Error("validate should succeed")
+	}
+	if (BaggageSpec{Set: map[string]string{"a=b": "c"}}).Validate() == nil {
+		t.Error("validate should fail")
+	}
+	if (BaggageSpec{Required: []string{"tenant"}}).Validate() != nil {
+		t.Error("validate should succeed")
+	}
+}
+
+func TestStrip(t *testing.T) {
+	p := New(&Spec{Trusted: []string{"traceparent", "x-b3-*"}, Strip: []string{"x-b3-flags", "X-Debug"}})
+
+	h := http.Header{}
+	h.Set("Traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	h.Set("Tracestate", "vendor=1")
+	h.Set("Baggage", "user=admin")
+	h.Set("X-B3-Traceid", "0af7651916cd43dd8448eb211c80319c")
+	h.Set("X-B3-Flags", "1")
+	h.Set("Uber-Trace-Id", "1:2:3:1")
+	h.Set("X-Debug", "true")
+	h.Set("X-Custom", "kept")
+
+	if err := p.Apply(h); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, key := range []string{"Tracestate", "Baggage", "X-B3-Flags", "Uber-Trace-Id", "X-Debug"} {
+		if h.Get(key) != "" {
+			t.Errorf("%s should be stripped", key)
+		}
+	}
+	for _, key := range []string{"Traceparent", "X-B3-Traceid", "X-Custom"} {
+		if h.Get(key) == "" {
+			t.Errorf("%s should be kept", key)
+		}
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	p := New(&Spec{Trusted: []string{"traceparent", "tracestate"}, GenerateTraceparent: true})
+
+	h := http.Header{}
+	h.Set("Traceparent", "00-00000000000000000000000000000000-b7ad6b7169203331-01")
+	h.Set("Tracestate", "vendor=1")
+	p.Apply(h)
+	tp := h.Get("Traceparent")
+	if !validTraceparent(tp) || tp == "00-00000000000000000000000000000000-b7ad6b7169203331-01" {
+		t.Errorf("invalid traceparent should be replaced, got %s", tp)
+	}
+	if h.Get("Tracestate") != "" {
+		t.Error("tracestate should be stripped with the invalid traceparent")
+	}
+
+	valid := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	h = http.Header{}
+	h.Set("Traceparent", valid)
+	p.Apply(h)
+	if h.Get("Traceparent") != valid {
+		t.Error("valid traceparent should be kept")
+	}
+
+	p = New(&Spec{})
+	h = http.Header{}
+	h.Set("Traceparent", valid)
+	p.Apply(h)
+	if h.Get("Traceparent") != "" {
+		t.Error("untrusted traceparent should be stripped")
+	}
+}
+
+func TestBaggage(t *testing.T) {
+	p := New(&Spec{
+		Trusted: []string{"baggage"},
+		Baggage: &BaggageSpec{
+			AllowedKeys: []string{"tenant", "region"},
+			Required:    []string{"tenant"},
+			Set:         map[string]string{"region": "us east", "gateway": "eg"},
+		},
+	})
+
+	h := http.Header{}
+	h.Add("Baggage", "tenant=acme;ttl=1, user=admin")
+	h.Add("Baggage", "region=eu")
+	if err := p.Apply(h); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := h.Get("Baggage"); got != "tenant=acme;ttl=1,gateway=eg,region=us%20east" {
+		t.Errorf("unexpected baggage %s", got)
+	}
+
+	h = http.Header{}
+	h.Set("Baggage", "user=admin")
+	err := p.Apply(h)
+	var rbe *RequiredBaggageError
+	if !errors.As(err, &rbe) || rbe.Key != "tenant" {
+		t.Errorf("expected error of required tenant, got %v", err)
+	}
+}
@@ -145,16 +145,13 @@ func (rl *RateLimiter) AcquirePermission() (bool, time.Duration) {
 	// current cycle index
 	cycle := int(now.Sub(rl.startTime) / rl.policy.LimitRefreshPeriod)
 
-	// rl.tokens is the number of permitted tokens from the beginning of rl.cycle
 	// tokens is the number of tokens have already been permitted from the beginning
-	// of current cycle. note tokens could be less than zero
-	tokens := rl.tokens - (cycle-rl.cycle)*rl.policy.LimitForPeriod
-	if tokens < 0 {
-		tokens = 0
-	}
+	// of current cycle
+	tokens := rl.tokensOf(cycle)
 
-	// reject if already reached the permission limitation
-	if tokens == maxTokens {
+	// reject if already reached the permission limitation, tokens could
+	// exceed it after Consume
+	if tokens >= maxTokens {
 		return false, rl.policy.TimeoutDuration
 	}
 
@@ -186,6 +183,60 @@ func (rl *RateLimiter) AcquirePermission() (bool, time.Duration) {
 	return true, timeToWait
 }
 
+// tokensOf returns the number of permitted tokens from the beginning of
+// the current cycle, the caller must hold the lock.
+func (rl *RateLimiter) tokensOf(cycle int) int {
+	tokens := rl.tokens - (cycle-rl.cycle)*rl.policy.LimitForPeriod
+	if tokens < 0 {
+		tokens = 0
+	}
+	return tokens
+}
+
+// Remaining returns the number of tokens could be permitted now, including
+// the ones reserved within the timeout. It's zero or negative if the limit
+// is reached.
+func (rl *RateLimiter) Remaining() int {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	if rl.state == StateDisabled {
+		return rl.policy.LimitForPeriod
+	}
+
+	maxTokens := rl.policy.LimitForPeriod
+	maxTokens *= int(rl.policy.TimeoutDuration/rl.policy.LimitRefreshPeriod) + 1
+
+	cycle := int(nowFunc().Sub(rl.startTime) / rl.policy.LimitRefreshPeriod)
+	return maxTokens - rl.tokensOf(cycle)
+}
+
+// Consume consumes n tokens without limitation, e.g. the bytes already
+// sent, and returns the duration the caller should wait until the tokens
+// are within the limit.
+func (rl *RateLimiter) Consume(n int) time.Duration {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	if rl.state == StateDisabled || n <= 0 {
+		return 0
+	}
+
+	now := nowFunc()
+	cycle := int(now.Sub(rl.startTime) / rl.policy.LimitRefreshPeriod)
+	rl.tokens = rl.tokensOf(cycle) + n
+	rl.cycle = cycle
+
+	if rl.tokens <= rl.policy.LimitForPeriod {
+		return 0
+	}
+
+	// the last token is permitted in the cycle of its index
+	cycle += (rl.tokens - 1) / rl.policy.LimitForPeriod
+	d := rl.policy.LimitRefreshPeriod * time.Duration(cycle)
+	return rl.startTime.Add(d).Sub(now)
+}
+
 // WaitPermission waits a permission from the rate limiter
 // returns true if the request is permitted and false if timed out
 func (rl *RateLimiter) WaitPermission() bool {
@@ -181,3 +181,39 @@ func TestRateLimiter(t *testing.T) {
 	}
 	limiter.SetState(StateDisabled)
 }
+
+func TestConsume(t *testing.T) {
+	policy := NewPolicy(0, 100, 1000)
+	limiter := New(policy)
+
+	if n := limiter.Remaining(); n != 1000 {
+		t.Errorf("remaining should be 1000, but got %d", n)
+	}
+	if d := limiter.Consume(600); d != 0 {
+		t.Errorf("consuming within the limit should not wait, but got %s", d)
+	}
+	if n := limiter.Remaining(); n != 400 {
+		t.Errorf("remaining should be 400, but got %d", n)
+	}
+
+	// 2500 tokens are permitted in the third cycle.
+	if d := limiter.Consume(1900); d != 200*time.Millisecond {
+		t.Errorf("should wait 200ms, but got %s", d)
+	}
+	if n := limiter.Remaining(); n > 0 {
+		t.Errorf("limit should be reached, but %d remains", n)
+	}
+	if permitted, _ := limiter.AcquirePermission(); permitted {
+		t.Errorf("permission should not be acquired")
+	}
+
+	now = now.Add(200 * time.Millisecond)
+	if n := limiter.Remaining(); n != 500 {
+		t.Errorf("remaining should be 500, but got %d", n)
+	}
+
+	limiter.SetState(StateDisabled)
+	if d := limiter.Consume(10000); d != 0 {
+		t.Errorf("disabled limiter should not wait, but got %s", d)
+	}
+}
@@ -0,0 +1,406 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package redisclient is a minimal Redis client of the RESP protocol with
+// a connection pool, which is enough to run commands with simple replies.
+package redisclient
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// Options is the options of the client.
+	Options struct {
+		// Address is host:port of the server.
+		Address  string
+		Username string
+		Password string
+		DB       int
+		// TLS is the TLS config, the connection is not encrypted if it's nil.
+		TLS      *tls.Config
+		Timeout  time.Duration
+		PoolSize int
+	}
+
+	// Client is a Redis client with a connection pool, it's safe for
+	// concurrent use.
+	Client struct {
+		opts *Options
+
+		// tokens limits the number of open connections.
+		tokens chan struct{}
+
+		mutex  sync.Mutex
+		idle   []*conn
+		closed bool
+	}
+
+	// PoolStatus is the status of the connection pool.
+	PoolStatus struct {
+		Open int `yaml:"open"`
+		Idle int `yaml:"idle"`
+		Size int `yaml:"size"`
+	}
+
+	// Error is an error reply of the server.
+	Error string
+
+	conn struct {
+		conn net.Conn
+		r    *bufio.Reader
+		w    *bufio.Writer
+	}
+)
+
+// ErrNil is returned by the helpers for nil replies.
+var ErrNil = fmt.Errorf("redis: nil")
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// New creates a client, connections are created on demand.
+func New(opts *Options) *Client {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 10
+	}
+
+	return &Client{
+		opts:   opts,
+		tokens: make(chan struct{}, opts.PoolSize),
+	}
+}
+
+// Do runs the command and returns the reply, which is nil, string,
+// int64, Error or []interface{}. Error replies are returned as the
+// error too.
+func (c *Client) Do(args ...string) (interface{}, error) {
+	select {
+	case c.tokens <- struct{}{}:
+	case <-time.After(c.opts.Timeout):
+		return nil, fmt.Errorf("get connection from pool timeout")
+	}
+	defer func() { <-c.tokens }()
+
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := cn.do(c.opts.Timeout, args...)
+	if _, ok := err.(Error); err != nil && !ok {
+		// NOTE: The connection is broken.
+		cn.conn.Close()
+		return nil, err
+	}
+
+	c.put(cn)
+	return reply, err
+}
+
+// Transaction runs the commands in a transaction of MULTI and EXEC, and
+// returns their replies.
+func (c *Client) Transaction(cmds ...[]string) ([]interface{}, error) {
+	select {
+	case c.tokens <- struct{}{}:
+	case <-time.After(c.opts.Timeout):
+		return nil, fmt.Errorf("get connection from pool timeout")
+	}
+	defer func() { <-c.tokens }()
+
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := cn.transaction(c.opts.Timeout, cmds)
+	if _, ok := err.(Error); err != nil && !ok {
+		// NOTE: The connection is broken.
+		cn.conn.Close()
+		return nil, err
+	}
+
+	c.put(cn)
+	return replies, err
+}
+
+// String runs the command and returns the reply as a string, it returns
+// ErrNil for nil replies.
+func (c *Client) String(args ...string) (string, error) {
+	reply, err := c.Do(args...)
+	if err != nil {
+		return "", err
+	}
+	switch r := reply.(type) {
+	case nil:
+		return "", ErrNil
+	case string:
+		return r, nil
+	case int64:
+		return strconv.FormatInt(r, 10), nil
+	default:
+		return "", fmt.Errorf("unexpected reply %v", reply)
+	}
+}
+
+// Int runs the command and returns the reply as an integer.
+func (c *Client) Int(args ...string) (int64, error) {
+	reply, err := c.Do(args...)
+	if err != nil {
+		return 0, err
+	}
+	switch r := reply.(type) {
+	case nil:
+		return 0, ErrNil
+	case int64:
+		return r, nil
+	case string:
+		return strconv.ParseInt(r, 10, 64)
+	default:
+		return 0, fmt.Errorf("unexpected reply %v", reply)
+	}
+}
+
+// PoolStatus returns the status of the connection pool.
+func (c *Client) PoolStatus() *PoolStatus {
+	c.mutex.Lock()
+	idle := len(c.idle)
+	c.mutex.Unlock()
+
+	return &PoolStatus{
+		Open: len(c.tokens) + idle,
+		Idle: idle,
+		Size: c.opts.PoolSize,
+	}
+}
+
+// Close closes all idle connections, connections in use are closed
+// when they are returned.
+func (c *Client) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closed = true
+	for _, cn := range c.idle {
+		cn.conn.Close()
+	}
+	c.idle = nil
+}
+
+func (c *Client) get() (*conn, error) {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil, fmt.Errorf("client closed")
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mutex.Unlock()
+		return cn, nil
+	}
+	c.mutex.Unlock()
+
+	return c.dial()
+}
+
+func (c *Client) put(cn *conn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		cn.conn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+func (c *Client) dial() (*conn, error) {
+	dialer := &net.Dialer{Timeout: c.opts.Timeout}
+	var nc net.Conn
+	var err error
+	if c.opts.TLS != nil {
+		nc, err = tls.DialWithDialer(dialer, "tcp", c.opts.Address, c.opts.TLS)
+	} else {
+		nc, err = dialer.Dial("tcp", c.opts.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cn := &conn{conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+
+	if c.opts.Password != "" {
+		args := []string{"AUTH", c.opts.Password}
+		if c.opts.Username != "" {
+			args = []string{"AUTH", c.opts.Username, c.opts.Password}
+		}
+		if _, err = cn.do(c.opts.Timeout, args...); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("auth failed: %v", err)
+		}
+	}
+	if c.opts.DB != 0 {
+		if _, err = cn.do(c.opts.Timeout, "SELECT", strconv.Itoa(c.opts.DB)); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("select db %d failed: %v", c.opts.DB, err)
+		}
+	}
+
+	return cn, nil
+}
+
+func (cn *conn) write(args []string) {
+	cn.w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		cn.w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		cn.w.WriteString(arg)
+		cn.w.WriteString("\r\n")
+	}
+}
+
+func (cn *conn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	cn.conn.SetDeadline(time.Now().Add(timeout))
+
+	cn.write(args)
+	if err := cn.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	reply, err := ReadReply(cn.r)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(Error); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+func (cn *conn) transaction(timeout time.Duration, cmds [][]string) ([]interface{}, error) {
+	cn.conn.SetDeadline(time.Now().Add(timeout))
+
+	cn.write([]string{"MULTI"})
+	for _, cmd := range cmds {
+		cn.write(cmd)
+	}
+	cn.write([]string{"EXEC"})
+	if err := cn.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	// NOTE: Read all replies to keep the connection reusable, the first
+	// error of MULTI and queued commands is returned.
+	var firstErr error
+	for i := 0; i < len(cmds)+1; i++ {
+		reply, err := ReadReply(cn.r)
+		if err != nil {
+			return nil, err
+		}
+		if e, ok := reply.(Error); ok && firstErr == nil {
+			firstErr = e
+		}
+	}
+
+	reply, err := ReadReply(cn.r)
+	if err != nil {
+		return nil, err
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if e, ok := reply.(Error); ok {
+		return nil, e
+	}
+	replies, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("transaction aborted")
+	}
+	return replies, nil
+}
+
+// ReadReply reads a reply of RESP, it's exported for fake servers in tests.
+func ReadReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk length %s", line[1:])
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buff := make([]byte, n+2)
+		if _, err = io.ReadFull(r, buff); err != nil {
+			return nil, err
+		}
+		return string(buff[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid array length %s", line[1:])
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		array := make([]interface{}, n)
+		for i := range array {
+			if array[i], err = ReadReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	default:
+		return nil, fmt.Errorf("unknown reply type %q", line[0])
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("invalid line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
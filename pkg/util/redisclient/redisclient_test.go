@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package redisclient_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/redisclient"
+	"github.com/megaease/easegress/pkg/util/redisclient/redistest"
+)
+
+func TestClient(t *testing.T) {
+	server := redistest.NewServer("secret")
+	defer server.Close()
+
+	bad := redisclient.New(&redisclient.Options{Address: server.Addr(), Password: "bad"})
+	if _, err := bad.Do("PING"); err == nil {
+		t.Errorf("auth with bad password should fail")
+	}
+
+	c := redisclient.New(&redisclient.Options{Address: server.Addr(), Password: "secret", DB: 1, PoolSize: 2})
+	defer c.Close()
+
+	if _, err := c.String("GET", "k"); err != redisclient.ErrNil {
+		t.Errorf("want ErrNil, got %v", err)
+	}
+	if _, err := c.Do("SET", "k", "v", "PX", "60000"); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if v, err := c.String("GET", "k"); err != nil || v != "v" {
+		t.Errorf("want v, got %s, %v", v, err)
+	}
+	if ttl := server.TTL("k"); ttl <= 59*time.Second {
+		t.Errorf("unexpected ttl %v", ttl)
+	}
+	if _, err := c.Do("UNKNOWN"); err == nil {
+		t.Errorf("unknown command should fail")
+	} else if _, ok := err.(redisclient.Error); !ok {
+		t.Errorf("want redis error, got %v", err)
+	}
+
+	replies, err := c.Transaction(
+		[]string{"SET", "n", "0", "PX", "60000", "NX"},
+		[]string{"INCRBY", "n", "5"},
+	)
+	if err != nil || len(replies) != 2 || replies[1] != int64(5) {
+		t.Fatalf("unexpected transaction replies %v, %v", replies, err)
+	}
+	if n, err := c.Int("INCRBY", "n", "2"); err != nil || n != 7 {
+		t.Errorf("want 7, got %d, %v", n, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Int("INCRBY", "n", "1")
+		}()
+	}
+	wg.Wait()
+	if n, _ := c.Int("GET", "n"); n != 27 {
+		t.Errorf("want 27, got %d", n)
+	}
+	if s := c.PoolStatus(); s.Open > 2 || s.Idle == 0 || s.Size != 2 {
+		t.Errorf("unexpected pool status %+v", s)
+	}
+
+	server.Close()
+	if _, err := c.Do("PING"); err == nil {
+		t.Errorf("ping closed server should fail")
+	}
+}
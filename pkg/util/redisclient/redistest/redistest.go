@@ -0,0 +1,259 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package redistest provides an in-memory Redis server for testing, it
+// supports the commands used by Easegress only.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/redisclient"
+)
+
+type (
+	// Server is an in-memory Redis server.
+	Server struct {
+		listener net.Listener
+		password string
+
+		mutex sync.Mutex
+		data  map[string]*entry
+		conns map[net.Conn]struct{}
+	}
+
+	entry struct {
+		value    string
+		expireAt time.Time
+	}
+)
+
+// NewServer starts a server on a random local port, clients must
+// authenticate if password is not empty.
+func NewServer(password string) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Errorf("listen failed: %v", err))
+	}
+
+	s := &Server{
+		listener: listener,
+		password: password,
+		data:     map[string]*entry{},
+		conns:    map[net.Conn]struct{}{},
+	}
+	go s.serve()
+	return s
+}
+
+// Addr returns the address of the server.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close closes the server and all connections.
+func (s *Server) Close() {
+	s.listener.Close()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// TTL returns the remaining time to live of the key, zero means the key
+// doesn't expire or doesn't exist.
+func (s *Server) TTL(key string) time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e := s.get(key)
+	if e == nil || e.expireAt.IsZero() {
+		return 0
+	}
+	return time.Until(e.expireAt)
+}
+
+func (s *Server) serve() {
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.conns[c] = struct{}{}
+		s.mutex.Unlock()
+		go s.handle(c)
+	}
+}
+
+func (s *Server) handle(c net.Conn) {
+	defer func() {
+		c.Close()
+		s.mutex.Lock()
+		delete(s.conns, c)
+		s.mutex.Unlock()
+	}()
+
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+	authed := s.password == ""
+	var queue [][]string
+	inMulti := false
+
+	for {
+		req, err := redisclient.ReadReply(r)
+		if err != nil {
+			return
+		}
+		items, _ := req.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		if len(args) == 0 {
+			writeReply(w, redisclient.Error("ERR empty command"))
+			w.Flush()
+			continue
+		}
+
+		cmd := strings.ToUpper(args[0])
+		switch {
+		case cmd == "AUTH":
+			if args[len(args)-1] == s.password {
+				authed = true
+				writeReply(w, "OK")
+			} else {
+				writeReply(w, redisclient.Error("WRONGPASS invalid password"))
+			}
+		case !authed:
+			writeReply(w, redisclient.Error("NOAUTH Authentication required."))
+		case cmd == "MULTI":
+			inMulti, queue = true, nil
+			writeReply(w, "OK")
+		case cmd == "EXEC":
+			s.mutex.Lock()
+			replies := make([]interface{}, len(queue))
+			for i, q := range queue {
+				replies[i] = s.exec(q)
+			}
+			s.mutex.Unlock()
+			inMulti = false
+			writeReply(w, replies)
+		case inMulti:
+			queue = append(queue, args)
+			writeReply(w, "QUEUED")
+		default:
+			s.mutex.Lock()
+			reply := s.exec(args)
+			s.mutex.Unlock()
+			writeReply(w, reply)
+		}
+		w.Flush()
+	}
+}
+
+func (s *Server) get(key string) *entry {
+	e := s.data[key]
+	if e != nil && !e.expireAt.IsZero() && !time.Now().Before(e.expireAt) {
+		delete(s.data, key)
+		return nil
+	}
+	return e
+}
+
+// exec executes the command, the caller must hold the mutex.
+func (s *Server) exec(args []string) interface{} {
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "PONG"
+	case "SELECT":
+		return "OK"
+	case "GET":
+		if e := s.get(args[1]); e != nil {
+			return e.value
+		}
+		return nil
+	case "SET":
+		e := &entry{value: args[2]}
+		nx := false
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "PX":
+				i++
+				ms, _ := strconv.ParseInt(args[i], 10, 64)
+				e.expireAt = time.Now().Add(time.Duration(ms) * time.Millisecond)
+			}
+		}
+		if nx && s.get(args[1]) != nil {
+			return nil
+		}
+		s.data[args[1]] = e
+		return "OK"
+	case "DEL":
+		var n int64
+		for _, key := range args[1:] {
+			if s.get(key) != nil {
+				delete(s.data, key)
+				n++
+			}
+		}
+		return n
+	case "INCRBY":
+		e := s.get(args[1])
+		if e == nil {
+			e = &entry{value: "0"}
+			s.data[args[1]] = e
+		}
+		n, err := strconv.ParseInt(e.value, 10, 64)
+		if err != nil {
+			return redisclient.Error("ERR value is not an integer or out of range")
+		}
+		delta, _ := strconv.ParseInt(args[2], 10, 64)
+		n += delta
+		e.value = strconv.FormatInt(n, 10)
+		return n
+	default:
+		return redisclient.Error("ERR unknown command '" + args[0] + "'")
+	}
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch r := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(r)) + "\r\n" + r + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(r, 10) + "\r\n")
+	case redisclient.Error:
+		w.WriteString("-" + string(r) + "\r\n")
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(r)) + "\r\n")
+		for _, item := range r {
+			writeReply(w, item)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+// Package retryafter hints clients when to retry the requests failed by
+// unavailable upstreams, with the Retry-After header.
+package retryafter
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultMin is the default minimal hint.
+	DefaultMin = time.Second
+	// DefaultMax is the default maximal hint.
+	DefaultMax = 2 * time.Minute
+
+	// ReasonHeader is the header explaining the hint in debug mode.
+	ReasonHeader = "X-EG-Retry-After-Reason"
+)
+
+type (
+	// Spec describes the Retry-After hints.
+	Spec struct {
+		// Min is the minimal hint, DefaultMin is used if it's empty.
+		Min string `yaml:"min" jsonschema:"omitempty,format=duration"`
+		// Max is the maximal hint, DefaultMax is used if it's empty.
+		Max string `yaml:"max" jsonschema:"omitempty,format=duration"`
+		// Debug explains the hints in the header X-EG-Retry-After-Reason.
+		Debug bool `yaml:"debug" jsonschema:"omitempty"`
+	}
+
+	// Hinter sets the Retry-After hints by the Spec.
+	Hinter struct {
+		min   time.Duration
+		max   time.Duration
+		debug bool
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	min, max := DefaultMin, DefaultMax
+	if spec.Min != "" {
+		min, _ = time.ParseDuration(spec.Min)
+		if min <= 0 {
+			return fmt.Errorf("min must be positive")
+		}
+	}
+	if spec.Max != "" {
+		max, _ = time.ParseDuration(spec.Max)
+		if max <= 0 {
+			return fmt.Errorf("max must be positive")
+		}
+	}
+	if min > max {
+		return fmt.Errorf("min %v is greater than max %v", min, max)
+	}
+	return nil
+}
+
+// New creates a Hinter.
+func New(spec *Spec) *Hinter {
+	h := &Hinter{min: DefaultMin, max: DefaultMax, debug: spec.Debug}
+	if d, err := time.ParseDuration(spec.Min); err == nil && d > 0 {
+		h.min = d
+	}
+	if d, err := time.ParseDuration(spec.Max); err == nil && d > 0 {
+		h.max = d
+	}
+	return h
+}
+
+// Clamp returns d limited to the range of the Hinter.
+func (h *Hinter) Clamp(d time.Duration) time.Duration {
+	if d < h.min {
+		return h.min
+	}
+	if d > h.max {
+		return h.max
+	}
+	return d
+}
+
+// Backoff returns the hint growing exponentially from the minimal one
+// with the number of the consecutive failures.
+func (h *Hinter) Backoff(failures uint32) time.Duration {
+	d := h.min
+	for i := uint32(1); i < failures && d < h.max; i++ {
+		d *= 2
+	}
+	return h.Clamp(d)
+}
+
+// Hint sets the Retry-After header to d in seconds rounded up after
+// clamping, and the reason too in debug mode.
+func (h *Hinter) Hint(header http.Header, d time.Duration, reason string) {
+	d = h.Clamp(d)
+	seconds := (d + time.Second - 1) / time.Second
+	header.Set("Retry-After", strconv.FormatInt(int64(seconds), 10))
+	if h.debug {
+		header.Set(ReasonHeader, reason)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package retryafter
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		spec Spec
+		ok   bool
+	}{
+		{Spec{}, true},
+		{Spec{Min: "2s", Max: "10s"}, true},
+		{Spec{Min: "0s"}, false},
+		{Spec{Max: "-1s"}, false},
+		{Spec{Min: "3m"}, false},
+		{Spec{Min: "10s", Max: "5s"}, false},
+	}
+	for i, c := range cases {
+		if err := c.spec.Validate(); (err == nil) != c.ok {
+			t.Errorf("case %d: unexpected error %v", i, err)
+		}
+	}
+}
+
+func TestHint(t *testing.T) {
+	h := New(&Spec{Min: "2s", Max: "30s"})
+
+	header := http.Header{}
+	h.Hint(header, 1500*time.Millisecond, "open")
+	if got := header.Get("Retry-After"); got != "2" {
+		t.Errorf("expected 2, got %s", got)
+	}
+	if header.Get(ReasonHeader) != "" {
+		t.Errorf("reason should not be set without debug")
+	}
+
+	h.Hint(header, 10100*time.Millisecond, "open")
+	if got := header.Get("Retry-After"); got != "11" {
+		t.Errorf("expected 11, got %s", got)
+	}
+
+	h.Hint(header, time.Hour, "open")
+	if got := header.Get("Retry-After"); got != "30" {
+		t.Errorf("expected 30, got %s", got)
+	}
+
+	h = New(&Spec{Debug: true})
+	h.Hint(header, 0, "no server available")
+	if got := header.Get("Retry-After"); got != "1" {
+		t.Errorf("expected 1, got %s", got)
+	}
+	if got := header.Get(ReasonHeader); got != "no server available" {
+		t.Errorf("unexpected reason %q", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	h := New(&Spec{Min: "1s", Max: "10s"})
+	expected := []time.Duration{time.Second, time.Second, 2 * time.Second,
+		4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, d := range expected {
+		if got := h.Backoff(uint32(i)); got != d {
+			t.Errorf("failures %d: expected %v, got %v", i, d, got)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schemaregistry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type (
+	// avroSchema is a parsed Avro schema, the logical types are handled
+	// as their underlying types.
+	avroSchema struct {
+		typ  string
+		name string
+
+		fields   []*avroField  // record
+		symbols  []string      // enum
+		items    *avroSchema   // array
+		values   *avroSchema   // map
+		size     int           // fixed
+		branches []*avroSchema // union
+	}
+
+	avroField struct {
+		name       string
+		schema     *avroSchema
+		def        interface{}
+		hasDefault bool
+	}
+
+	avroParser struct {
+		named map[string]*avroSchema
+	}
+)
+
+var avroPrimitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+// parseAvro parses the Avro schema in JSON.
+func parseAvro(schema string) (*avroSchema, error) {
+	var v interface{}
+	d := json.NewDecoder(strings.NewReader(schema))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid avro schema: %v", err)
+	}
+
+	p := &avroParser{named: map[string]*avroSchema{}}
+	return p.parse(v, "")
+}
+
+func fullName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+func (p *avroParser) parse(v interface{}, namespace string) (*avroSchema, error) {
+	switch v := v.(type) {
+	case string:
+		if avroPrimitives[v] {
+			return &avroSchema{typ: v}, nil
+		}
+		if s := p.named[fullName(v, namespace)]; s != nil {
+			return s, nil
+		}
+		if s := p.named[v]; s != nil {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unknown avro type %s", v)
+
+	case []interface{}:
+		s := &avroSchema{typ: "union"}
+		for _, b := range v {
+			branch, err := p.parse(b, namespace)
+			if err != nil {
+				return nil, err
+			}
+			s.branches = append(s.branches, branch)
+		}
+		return s, nil
+
+	case map[string]interface{}:
+		return p.parseComplex(v, namespace)
+	}
+
+	return nil, fmt.Errorf("invalid avro schema %v", v)
+}
+
+func (p *avroParser) parseComplex(v map[string]interface{}, namespace string) (*avroSchema, error) {
+	typ, _ := v["type"].(string)
+	if typ == "" {
+		// NOTE: The type could be a nested schema, e.g. {"type": {"type": "int"}}.
+		return p.parse(v["type"], namespace)
+	}
+
+	s := &avroSchema{typ: typ}
+	switch typ {
+	case "record", "error", "enum", "fixed":
+		s.typ = strings.Replace(typ, "error", "record", 1)
+		name, _ := v["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("name of avro %s is required", typ)
+		}
+		if ns, ok := v["namespace"].(string); ok && !strings.Contains(name, ".") {
+			namespace = ns
+		}
+		s.name = fullName(name, namespace)
+		if i := strings.LastIndex(s.name, "."); i >= 0 {
+			namespace = s.name[:i]
+		}
+		// NOTE: Register before parsing the fields for recursive types.
+		p.named[s.name] = s
+	}
+
+	switch s.typ {
+	case "record":
+		fields, _ := v["fields"].([]interface{})
+		for _, f := range fields {
+			fm, ok := f.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid field of record %s", s.name)
+			}
+			name, _ := fm["name"].(string)
+			fs, err := p.parse(fm["type"], namespace)
+			if err != nil {
+				return nil, fmt.Errorf("field %s of record %s: %v", name, s.name, err)
+			}
+			def, hasDefault := fm["default"]
+			s.fields = append(s.fields, &avroField{name: name, schema: fs, def: def, hasDefault: hasDefault})
+		}
+
+	case "enum":
+		symbols, _ := v["symbols"].([]interface{})
+		for _, sym := range symbols {
+			str, _ := sym.(string)
+			s.symbols = append(s.symbols, str)
+		}
+
+	case "fixed":
+		size, _ := v["size"].(json.Number)
+		n, err := size.Int64()
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid size of fixed %s", s.name)
+		}
+		s.size = int(n)
+
+	case "array":
+		items, err := p.parse(v["items"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		s.items = items
+
+	case "map":
+		values, err := p.parse(v["values"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		s.values = values
+
+	default:
+		if !avroPrimitives[s.typ] {
+			return p.parse(s.typ, namespace)
+		}
+	}
+
+	return s, nil
+}
+
+// typeName is the name of the type in the JSON encoding of unions.
+func (s *avroSchema) typeName() string {
+	if s.name != "" {
+		return s.name
+	}
+	return s.typ
+}
+
+func writeLong(buff *bytes.Buffer, n int64) {
+	var b [binary.MaxVarintLen64]byte
+	buff.Write(b[:binary.PutVarint(b[:], n)])
+}
+
+func toInt64(datum interface{}) (int64, bool) {
+	switch d := datum.(type) {
+	case json.Number:
+		n, err := d.Int64()
+		return n, err == nil
+	case float64:
+		return int64(d), d == math.Trunc(d)
+	}
+	return 0, false
+}
+
+func toFloat64(datum interface{}) (float64, bool) {
+	switch d := datum.(type) {
+	case json.Number:
+		f, err := d.Float64()
+		return f, err == nil
+	case float64:
+		return d, true
+	}
+	return 0, false
+}
+
+// toBytes converts the JSON string of bytes and fixed, whose code points
+// are the bytes.
+func toBytes(datum interface{}) ([]byte, bool) {
+	str, ok := datum.(string)
+	if !ok {
+		return nil, false
+	}
+	b := make([]byte, 0, len(str))
+	for _, r := range str {
+		if r > 255 {
+			return nil, false
+		}
+		b = append(b, byte(r))
+	}
+	return b, true
+}
+
+func pathError(path string, format string, args ...interface{}) error {
+	if path == "" {
+		path = "$"
+	}
+	return fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...))
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// encode validates the datum decoded from JSON and encodes it in the
+// Avro binary encoding.
+func (s *avroSchema) encode(buff *bytes.Buffer, datum interface{}, path string) error {
+	switch s.typ {
+	case "null":
+		if datum != nil {
+			return pathError(path, "null expected")
+		}
+
+	case "boolean":
+		b, ok := datum.(bool)
+		if !ok {
+			return pathError(path, "boolean expected")
+		}
+		if b {
+			buff.WriteByte(1)
+		} else {
+			buff.WriteByte(0)
+		}
+
+	case "int", "long":
+		n, ok := toInt64(datum)
+		if !ok || (s.typ == "int" && (n < math.MinInt32 || n > math.MaxInt32)) {
+			return pathError(path, "%s expected", s.typ)
+		}
+		writeLong(buff, n)
+
+	case "float":
+		f, ok := toFloat64(datum)
+		if !ok {
+			return pathError(path, "float expected")
+		}
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(float32(f)))
+		buff.Write(b[:])
+
+	case "double":
+		f, ok := toFloat64(datum)
+		if !ok {
+			return pathError(path, "double expected")
+		}
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+		buff.Write(b[:])
+
+	case "bytes", "fixed":
+		b, ok := toBytes(datum)
+		if !ok {
+			return pathError(path, "%s expected", s.typ)
+		}
+		if s.typ == "fixed" {
+			if len(b) != s.size {
+				return pathError(path, "fixed of size %d expected", s.size)
+			}
+			buff.Write(b)
+			break
+		}
+		writeLong(buff, int64(len(b)))
+		buff.Write(b)
+
+	case "string":
+		str, ok := datum.(string)
+		if !ok {
+			return pathError(path, "string expected")
+		}
+		writeLong(buff, int64(len(str)))
+		buff.WriteString(str)
+
+	case "enum":
+		str, _ := datum.(string)
+		for i, sym := range s.symbols {
+			if sym == str {
+				writeLong(buff, int64(i))
+				return nil
+			}
+		}
+		return pathError(path, "one of %v expected", s.symbols)
+
+	case "array":
+		items, ok := datum.([]interface{})
+		if !ok {
+			return pathError(path, "array expected")
+		}
+		if len(items) > 0 {
+			writeLong(buff, int64(len(items)))
+			for i, item := range items {
+				if err := s.items.encode(buff, item, joinPath(path, strconv.Itoa(i))); err != nil {
+					return err
+				}
+			}
+		}
+		writeLong(buff, 0)
+
+	case "map":
+		m, ok := datum.(map[string]interface{})
+		if !ok {
+			return pathError(path, "map expected")
+		}
+		if len(m) > 0 {
+			writeLong(buff, int64(len(m)))
+			for k, v := range m {
+				writeLong(buff, int64(len(k)))
+				buff.WriteString(k)
+				if err := s.values.encode(buff, v, joinPath(path, k)); err != nil {
+					return err
+				}
+			}
+		}
+		writeLong(buff, 0)
+
+	case "record":
+		m, ok := datum.(map[string]interface{})
+		if !ok {
+			return pathError(path, "record %s expected", s.name)
+		}
+		for _, f := range s.fields {
+			v, ok := m[f.name]
+			if !ok {
+				if !f.hasDefault {
+					return pathError(joinPath(path, f.name), "required")
+				}
+				v = f.def
+			}
+			if err := f.schema.encode(buff, v, joinPath(path, f.name)); err != nil {
+				return err
+			}
+		}
+
+	case "union":
+		return s.encodeUnion(buff, datum, path)
+
+	default:
+		return pathError(path, "unsupported avro type %s", s.typ)
+	}
+
+	return nil
+}
+
+// encodeUnion encodes the datum in the branch named by the JSON encoding
+// of Avro, e.g. {"string": "a"}, or the first branch it matches.
+func (s *avroSchema) encodeUnion(buff *bytes.Buffer, datum interface{}, path string) error {
+	if m, ok := datum.(map[string]interface{}); ok && len(m) == 1 {
+		for name, v := range m {
+			for i, b := range s.branches {
+				if b.typeName() == name {
+					writeLong(buff, int64(i))
+					return b.encode(buff, v, path)
+				}
+			}
+		}
+	}
+
+	for i, b := range s.branches {
+		branch := bytes.NewBuffer(nil)
+		if b.encode(branch, datum, path) == nil {
+			writeLong(buff, int64(i))
+			buff.Write(branch.Bytes())
+			return nil
+		}
+	}
+
+	names := make([]string, 0, len(s.branches))
+	for _, b := range s.branches {
+		names = append(names, b.typeName())
+	}
+	return pathError(path, "one of %v expected", names)
+}
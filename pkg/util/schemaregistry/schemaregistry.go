@@ -0,0 +1,290 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package schemaregistry validates and serializes payloads by the schemas
+// in Confluent Schema Registry, so that bad events are rejected before
+// being produced to Kafka.
+package schemaregistry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+const (
+	// TopicNameStrategy names subjects by topics, e.g. orders-value.
+	TopicNameStrategy = "topicName"
+	// RecordNameStrategy names subjects by the full names of records.
+	RecordNameStrategy = "recordName"
+	// TopicRecordNameStrategy names subjects by topics and records,
+	// e.g. orders-com.example.Order.
+	TopicRecordNameStrategy = "topicRecordName"
+
+	// TypeAvro is the type of Avro schemas.
+	TypeAvro = "AVRO"
+	// TypeJSON is the type of JSON schemas.
+	TypeJSON = "JSON"
+	// TypeProtobuf is the type of Protobuf schemas.
+	TypeProtobuf = "PROTOBUF"
+
+	// magicByte is the first byte of the wire format of Confluent.
+	magicByte = 0
+
+	defaultCacheTTL = 5 * time.Minute
+)
+
+type (
+	// Spec describes the Registry.
+	Spec struct {
+		URL      string `yaml:"url" jsonschema:"required,format=uri"`
+		Username string `yaml:"username" jsonschema:"omitempty"`
+		Password string `yaml:"password" jsonschema:"omitempty"`
+		// SubjectNameStrategy is one of topicName, recordName and
+		// topicRecordName, default is topicName.
+		SubjectNameStrategy string `yaml:"subjectNameStrategy" jsonschema:"omitempty,enum=,enum=topicName,enum=recordName,enum=topicRecordName"`
+		// Key selects the key schemas of topics rather than the value ones.
+		Key bool `yaml:"key" jsonschema:"omitempty"`
+		// CacheTTL is how long the latest schemas are cached, default is 5m.
+		CacheTTL string `yaml:"cacheTTL" jsonschema:"omitempty,format=duration"`
+	}
+
+	// Registry is the client of a schema registry.
+	Registry struct {
+		spec     *Spec
+		client   *http.Client
+		cacheTTL time.Duration
+
+		mutex sync.Mutex
+		cache map[string]*cachedSchema
+	}
+
+	// Schema is a compiled schema of a subject.
+	Schema struct {
+		ID      int
+		Version int
+		Type    string
+
+		avro *avroSchema
+		json *gojsonschema.Schema
+	}
+
+	cachedSchema struct {
+		schema    *Schema
+		expiresAt time.Time
+	}
+
+	// ValidationError is the error of invalid payloads.
+	ValidationError struct {
+		Message string
+	}
+
+	subjectVersion struct {
+		ID         int    `json:"id"`
+		Version    int    `json:"version"`
+		Schema     string `json:"schema"`
+		SchemaType string `json:"schemaType"`
+	}
+)
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if spec.CacheTTL != "" {
+		if d, _ := time.ParseDuration(spec.CacheTTL); d <= 0 {
+			return fmt.Errorf("cacheTTL must be positive")
+		}
+	}
+	return nil
+}
+
+// New creates a Registry, the spec must be valid.
+func New(spec *Spec) *Registry {
+	r := &Registry{
+		spec:     spec,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		cacheTTL: defaultCacheTTL,
+		cache:    map[string]*cachedSchema{},
+	}
+	if spec.CacheTTL != "" {
+		r.cacheTTL, _ = time.ParseDuration(spec.CacheTTL)
+	}
+	return r
+}
+
+// Subject returns the subject of the topic and record by the strategy.
+func (r *Registry) Subject(topic, record string) (string, error) {
+	switch r.spec.SubjectNameStrategy {
+	case RecordNameStrategy:
+		if record == "" {
+			return "", fmt.Errorf("record name is required")
+		}
+		return record, nil
+	case TopicRecordNameStrategy:
+		if topic == "" || record == "" {
+			return "", fmt.Errorf("topic and record name are required")
+		}
+		return topic + "-" + record, nil
+	default:
+		if topic == "" {
+			return "", fmt.Errorf("topic is required")
+		}
+		if r.spec.Key {
+			return topic + "-key", nil
+		}
+		return topic + "-value", nil
+	}
+}
+
+// Latest returns the latest schema of the subject, the cached schema is
+// returned if the registry is unavailable.
+func (r *Registry) Latest(subject string) (*Schema, error) {
+	r.mutex.Lock()
+	cached := r.cache[subject]
+	r.mutex.Unlock()
+
+	if cached != nil && time.Now().Before(cached.expiresAt) {
+		return cached.schema, nil
+	}
+
+	schema, err := r.fetch(subject)
+	if err != nil {
+		if cached != nil {
+			return cached.schema, nil
+		}
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	r.cache[subject] = &cachedSchema{schema: schema, expiresAt: time.Now().Add(r.cacheTTL)}
+	r.mutex.Unlock()
+	return schema, nil
+}
+
+func (r *Registry) fetch(subject string) (*Schema, error) {
+	u := strings.TrimSuffix(r.spec.URL, "/") + "/subjects/" + url.PathEscape(subject) + "/versions/latest"
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if r.spec.Username != "" {
+		req.SetBasicAuth(r.spec.Username, r.spec.Password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch schema of subject %s failed: %v", subject, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch schema of subject %s failed: status code %d", subject, resp.StatusCode)
+	}
+
+	sv := &subjectVersion{}
+	if err = json.NewDecoder(resp.Body).Decode(sv); err != nil {
+		return nil, fmt.Errorf("decode schema of subject %s failed: %v", subject, err)
+	}
+	return compile(sv)
+}
+
+func compile(sv *subjectVersion) (*Schema, error) {
+	s := &Schema{ID: sv.ID, Version: sv.Version, Type: sv.SchemaType}
+	if s.Type == "" {
+		s.Type = TypeAvro
+	}
+
+	var err error
+	switch s.Type {
+	case TypeAvro:
+		s.avro, err = parseAvro(sv.Schema)
+	case TypeJSON:
+		s.json, err = gojsonschema.NewSchema(gojsonschema.NewStringLoader(sv.Schema))
+	default:
+		err = fmt.Errorf("%s schemas are not supported", s.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("compile schema %d failed: %v", s.ID, err)
+	}
+	return s, nil
+}
+
+// Validate validates the JSON payload, the error is a *ValidationError if
+// the payload is invalid.
+func (s *Schema) Validate(payload []byte) error {
+	_, err := s.encode(payload)
+	return err
+}
+
+// Serialize validates the JSON payload and serializes it in the wire
+// format of Confluent: the magic byte, the schema ID in 4 bytes and the
+// Avro binary encoding, or the JSON itself for JSON schemas.
+func (s *Schema) Serialize(payload []byte) ([]byte, error) {
+	data, err := s.encode(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	buff := bytes.NewBuffer(make([]byte, 0, len(data)+5))
+	buff.WriteByte(magicByte)
+	var id [4]byte
+	binary.BigEndian.PutUint32(id[:], uint32(s.ID))
+	buff.Write(id[:])
+	buff.Write(data)
+	return buff.Bytes(), nil
+}
+
+func (s *Schema) encode(payload []byte) ([]byte, error) {
+	if s.json != nil {
+		result, err := s.json.Validate(gojsonschema.NewBytesLoader(payload))
+		if err != nil {
+			return nil, &ValidationError{Message: fmt.Sprintf("invalid json: %v", err)}
+		}
+		if !result.Valid() {
+			msgs := make([]string, 0, len(result.Errors()))
+			for _, e := range result.Errors() {
+				msgs = append(msgs, e.String())
+			}
+			return nil, &ValidationError{Message: strings.Join(msgs, "; ")}
+		}
+		return payload, nil
+	}
+
+	var datum interface{}
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	if err := d.Decode(&datum); err != nil {
+		return nil, &ValidationError{Message: fmt.Sprintf("invalid json: %v", err)}
+	}
+
+	buff := bytes.NewBuffer(nil)
+	if err := s.avro.encode(buff, datum, ""); err != nil {
+		return nil, &ValidationError{Message: err.Error()}
+	}
+	return buff.Bytes(), nil
+}
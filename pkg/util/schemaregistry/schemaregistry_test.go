@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schemaregistry
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+const orderSchema = `{
+  "type": "record",
+  "name": "Order",
+  "namespace": "com.example",
+  "fields": [
+    {"name": "id", "type": "long"},
+    {"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["NEW", "PAID"]}},
+    {"name": "items", "type": {"type": "array", "items": "string"}},
+    {"name": "note", "type": ["null", "string"], "default": null}
+  ]
+}`
+
+func TestSubject(t *testing.T) {
+	r := New(&Spec{})
+	if s, _ := r.Subject("orders", ""); s != "orders-value" {
+		t.Errorf("unexpected subject %s", s)
+	}
+	if _, err := r.Subject("", "com.example.Order"); err == nil {
+		t.Error("topic should be required")
+	}
+
+	r = New(&Spec{Key: true})
+	if s, _ := r.Subject("orders", ""); s != "orders-key" {
+		t.Errorf("unexpected subject %s", s)
+	}
+
+	r = New(&Spec{SubjectNameStrategy: RecordNameStrategy})
+	if s, _ := r.Subject("orders", "com.example.Order"); s != "com.example.Order" {
+		t.Errorf("unexpected subject %s", s)
+	}
+
+	r = New(&Spec{SubjectNameStrategy: TopicRecordNameStrategy})
+	if s, _ := r.Subject("orders", "com.example.Order"); s != "orders-com.example.Order" {
+		t.Errorf("unexpected subject %s", s)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		switch r.URL.Path {
+		case "/subjects/orders-value/versions/latest":
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 7, "version": 2, "schema": orderSchema})
+		case "/subjects/users-value/versions/latest":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"id": 8, "version": 1, "schemaType": "JSON",
+				"schema": `{"type": "object", "required": ["name"]}`,
+			})
+		case "/subjects/events-value/versions/latest":
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 9, "version": 1, "schemaType": "PROTOBUF", "schema": "syntax = \"proto3\";"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	r := New(&Spec{URL: server.URL})
+	schema, err := r.Latest("orders-value")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if schema.ID != 7 || schema.Type != TypeAvro {
+		t.Errorf("unexpected schema %+v", schema)
+	}
+	if _, err = r.Latest("orders-value"); err != nil || atomic.LoadInt32(&fetches) != 1 {
+		t.Error("schema should be cached")
+	}
+
+	data, err := schema.Serialize([]byte(`{"id": 1, "status": "PAID", "items": ["a"]}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// magic byte, id, long 1, enum 1, array of 1 "a", end of array, null branch
+	expected := []byte{0, 0, 0, 0, 7, 2, 2, 2, 2, 'a', 0, 0}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("unexpected serialized data %v", data)
+	}
+
+	data, _ = schema.Serialize([]byte(`{"id": 1, "status": "NEW", "items": [], "note": "x"}`))
+	if !bytes.Equal(data[5:], []byte{2, 0, 0, 2, 2, 'x'}) {
+		t.Errorf("unexpected serialized data %v", data)
+	}
+
+	err = schema.Validate([]byte(`{"id": 1, "status": "DONE", "items": []}`))
+	if _, ok := err.(*ValidationError); !ok {
+		t.Errorf("validation error expected, got %v", err)
+	}
+	if err = schema.Validate([]byte(`{"status": "NEW", "items": []}`)); err == nil || err.Error() != "id: required" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	schema, err = r.Latest("users-value")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if schema.Validate([]byte(`{"name": "alice"}`)) != nil {
+		t.Error("validate should succeed")
+	}
+	if _, ok := schema.Validate([]byte(`{}`)).(*ValidationError); !ok {
+		t.Error("validate should fail")
+	}
+
+	if _, err = r.Latest("events-value"); err == nil {
+		t.Error("protobuf schemas should not be supported")
+	}
+	if _, err = r.Latest("unknown-value"); err == nil {
+		t.Error("unknown subjects should fail")
+	}
+}
+
+func TestAvroRecursive(t *testing.T) {
+	s, err := parseAvro(`{"type": "record", "name": "Node", "fields": [
+		{"name": "value", "type": "int"},
+		{"name": "next", "type": ["null", "Node"]}
+	]}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var datum interface{}
+	json.Unmarshal([]byte(`{"value": 1, "next": {"Node": {"value": 2, "next": null}}}`), &datum)
+	buff := bytes.NewBuffer(nil)
+	if err = s.encode(buff, datum, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(buff.Bytes(), []byte{2, 2, 4, 0}) {
+		t.Errorf("unexpected encoded data %v", buff.Bytes())
+	}
+}
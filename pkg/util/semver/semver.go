@@ -0,0 +1,324 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+// Package semver parses semantic versions and matches them against ranges.
+// Versions are parsed leniently, e.g. "v2" and "2.1" are 2.0.0 and 2.1.0.
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Version is a semantic version, build metadata is dropped.
+	Version struct {
+		Major, Minor, Patch uint64
+		Prerelease          string
+	}
+
+	// Range is a set of alternatives separated by "||", a version
+	// matches the range if it satisfies all comparators of any alternative.
+	Range struct {
+		alternatives [][]*comparator
+	}
+
+	comparator struct {
+		op      string
+		version *Version
+	}
+)
+
+// Parse parses a version, missing minor and patch are zeros.
+func Parse(s string) (*Version, error) {
+	v, parts, err := parse(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range parts {
+		if p == "x" {
+			return nil, fmt.Errorf("invalid version %s", s)
+		}
+	}
+	return v, nil
+}
+
+// parse parses the version and returns its numeric parts,
+// which are used to tell the precision of partial versions.
+func parse(s string) (*Version, []string, error) {
+	orig := s
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+
+	v := &Version{}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s, v.Prerelease = s[:i], s[i+1:]
+		if v.Prerelease == "" {
+			return nil, nil, fmt.Errorf("invalid version %s", orig)
+		}
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return nil, nil, fmt.Errorf("invalid version %s", orig)
+	}
+	numbers := []*uint64{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		if p == "x" || p == "X" || p == "*" {
+			parts[i] = "x"
+			continue
+		}
+		if i > 0 && parts[i-1] == "x" {
+			return nil, nil, fmt.Errorf("invalid version %s", orig)
+		}
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid version %s", orig)
+		}
+		*numbers[i] = n
+	}
+	return v, parts, nil
+}
+
+// Compare returns -1, 0 or 1 if v is less than, equal to
+// or greater than o, a prerelease is less than its release.
+func (v *Version) Compare(o *Version) int {
+	for _, pair := range [][2]uint64{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case v.Prerelease == o.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case o.Prerelease == "":
+		return -1
+	}
+	return comparePrerelease(v.Prerelease, o.Prerelease)
+}
+
+func comparePrerelease(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aErr := strconv.ParseUint(as[i], 10, 64)
+		bn, bErr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		case as[i] < bs[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+func (v *Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// ParseRange parses a range, comparators are separated by spaces or
+// commas, and support =, !=, >, >=, <, <=, ^, ~ and wildcards, e.g.
+// ">=1.2.0 <2.0.0", "^1.2", "~1.2.3", "2.x" and "1 || >=3".
+func ParseRange(s string) (*Range, error) {
+	r := &Range{}
+	for _, alt := range strings.Split(s, "||") {
+		fields := strings.FieldsFunc(alt, func(c rune) bool {
+			return c == ' ' || c == ','
+		})
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("invalid range %s", s)
+		}
+
+		var comparators []*comparator
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			// NOTE: Allow spaces between operators and versions, e.g. ">= 1.2".
+			if strings.Trim(field, "=!<>^~") == "" && i+1 < len(fields) {
+				field += fields[i+1]
+				i++
+			}
+			cs, err := parseComparator(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %s: %v", s, err)
+			}
+			comparators = append(comparators, cs...)
+		}
+		r.alternatives = append(r.alternatives, comparators)
+	}
+	return r, nil
+}
+
+// parseComparator parses a comparator, partial versions, carets,
+// tildes and wildcards are expanded into a pair of bounds.
+func parseComparator(s string) ([]*comparator, error) {
+	op := strings.TrimRight(s[:len(s)-len(strings.TrimLeft(s, "=!<>^~"))], " ")
+	v, parts, err := parse(s[len(op):])
+	if err != nil {
+		return nil, err
+	}
+
+	// precision is the number of specified numeric parts.
+	precision := 0
+	for _, p := range parts {
+		if p == "x" {
+			break
+		}
+		precision++
+	}
+
+	switch op {
+	case "", "=":
+		if precision == 3 {
+			return []*comparator{{"=", v}}, nil
+		}
+		return bounds(v, precision), nil
+	case "^":
+		// NOTE: ^ allows changes not modifying the left-most non-zero part.
+		switch {
+		case v.Major > 0 || precision <= 1:
+			return above(v, 1), nil
+		case v.Minor > 0 || precision == 2:
+			return above(v, 2), nil
+		default:
+			return above(v, 3), nil
+		}
+	case "~":
+		if precision <= 1 {
+			return above(v, 1), nil
+		}
+		return above(v, 2), nil
+	case "!=", ">", ">=", "<", "<=":
+		if precision == 0 {
+			return nil, fmt.Errorf("wildcard with %s", op)
+		}
+		if precision < 3 {
+			// NOTE: Partial versions are expanded by their bounds,
+			// e.g. >1.2 is >=1.3.0, <=1.2 is <1.3.0.
+			upper := bounds(v, precision)[1].version
+			switch op {
+			case ">":
+				return []*comparator{{">=", upper}}, nil
+			case "<=":
+				return []*comparator{{"<", upper}}, nil
+			case "!=":
+				return nil, fmt.Errorf("partial version with !=")
+			}
+		}
+		return []*comparator{{op, v}}, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+// bounds returns the comparators of versions starting with the first
+// precision parts of v, a zero precision matches all versions.
+func bounds(v *Version, precision int) []*comparator {
+	lower := &Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch, Prerelease: v.Prerelease}
+	upper := &Version{}
+	switch precision {
+	case 0:
+		return []*comparator{{">=", &Version{}}}
+	case 1:
+		lower.Minor, lower.Patch = 0, 0
+		upper.Major = v.Major + 1
+	case 2:
+		lower.Patch = 0
+		upper.Major, upper.Minor = v.Major, v.Minor+1
+	default:
+		upper.Major, upper.Minor, upper.Patch = v.Major, v.Minor, v.Patch+1
+	}
+	if precision < 3 {
+		lower.Prerelease = ""
+	}
+	// NOTE: Prereleases of the upper bound are excluded, e.g. ^1.2
+	// doesn't match 2.0.0-beta.
+	upper.Prerelease = "0"
+	return []*comparator{{">=", lower}, {"<", upper}}
+}
+
+// above returns the comparators of versions not less than v and
+// starting with the first precision parts of v.
+func above(v *Version, precision int) []*comparator {
+	cs := bounds(v, precision)
+	cs[0].version = v
+	return cs
+}
+
+func (c *comparator) match(v *Version) bool {
+	n := v.Compare(c.version)
+	switch c.op {
+	case "=":
+		return n == 0
+	case "!=":
+		return n != 0
+	case ">":
+		return n > 0
+	case ">=":
+		return n >= 0
+	case "<":
+		return n < 0
+	default:
+		return n <= 0
+	}
+}
+
+// Match returns whether the version is in the range.
+func (r *Range) Match(v *Version) bool {
+	for _, alt := range r.alternatives {
+		matched := true
+		for _, c := range alt {
+			if !c.match(v) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
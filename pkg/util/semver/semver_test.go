@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package semver
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	cases := map[string]string{
+		"1.2.3":           "1.2.3",
+		"v2":              "2.0.0",
+		"2.1":             "2.1.0",
+		"1.0.0-beta.1":    "1.0.0-beta.1",
+		"1.0.0+build.123": "1.0.0",
+	}
+	for s, want := range cases {
+		v, err := Parse(s)
+		if err != nil {
+			t.Fatalf("parse %s failed: %v", s, err)
+		}
+		if v.String() != want {
+			t.Errorf("parse %s: want %s, got %s", s, want, v)
+		}
+	}
+
+	for _, s := range []string{"", "a.b", "1.2.3.4", "1.x", "1.0.0-"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("parse %s should fail", s)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0", "1.0.1", "1.1.0", "2.0.0"}
+	for i := 0; i+1 < len(ordered); i++ {
+		a, _ := Parse(ordered[i])
+		b, _ := Parse(ordered[i+1])
+		if a.Compare(b) != -1 || b.Compare(a) != 1 || a.Compare(a) != 0 {
+			t.Errorf("%s should be less than %s", a, b)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	cases := []struct {
+		r        string
+		matched  []string
+		rejected []string
+	}{
+		{">=1.2.0 <2.0.0", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0"}},
+		{">= 1.2, < 2", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0"}},
+		{"^1.2", []string{"1.2.0", "1.9.0"}, []string{"1.1.0", "2.0.0", "2.0.0-beta"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0", "0.2.2"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.3.0"}},
+		{"2", []string{"2.0.0", "2.5.1"}, []string{"1.0.0", "3.0.0"}},
+		{"2.x", []string{"2.0.0", "2.5.1"}, []string{"3.0.0"}},
+		{"*", []string{"0.0.1", "9.0.0"}, nil},
+		{"1.2.3", []string{"1.2.3"}, []string{"1.2.4"}},
+		{">1.2", []string{"1.3.0"}, []string{"1.2.9"}},
+		{"<=1.2", []string{"1.2.9"}, []string{"1.3.0"}},
+		{"1 || >=3", []string{"1.5.0", "3.0.0"}, []string{"2.0.0"}},
+		{"!=1.2.3", []string{"1.2.4"}, []string{"1.2.3"}},
+	}
+
+	for _, c := range cases {
+		r, err := ParseRange(c.r)
+		if err != nil {
+			t.Fatalf("parse range %s failed: %v", c.r, err)
+		}
+		for _, s := range c.matched {
+			v, _ := Parse(s)
+			if !r.Match(v) {
+				t.Errorf("%s should match %s", c.r, s)
+			}
+		}
+		for _, s := range c.rejected {
+			v, _ := Parse(s)
+			if r.Match(v) {
+				t.Errorf("%s should not match %s", c.r, s)
+			}
+		}
+	}
+
+	for _, s := range []string{"", "||", ">x", "?1.0", "1.a", "!=1.2"} {
+		if _, err := ParseRange(s); err == nil {
+			t.Errorf("parse range %s should fail", s)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package signer
+
+import (
+	"container/heap"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultNonceCacheSize is the default max number of nonces remembered
+const DefaultNonceCacheSize = 100000
+
+// never is the expire time of nonces of signatures which never expire
+var never = time.Unix(1<<62, 0)
+
+type (
+	// nonceCache remembers the nonces of verified requests until their
+	// signatures expire, the nonces expiring earliest are evicted when
+	// the cache is full.
+	nonceCache struct {
+		header     string
+		maxEntries int
+
+		mutex   sync.Mutex
+		entries map[string]*nonceEntry
+		queue   nonceQueue
+	}
+
+	nonceEntry struct {
+		nonce    string
+		expireAt time.Time
+	}
+
+	nonceQueue []*nonceEntry
+)
+
+func (q nonceQueue) Len() int           { return len(q) }
+func (q nonceQueue) Less(i, j int) bool { return q[i].expireAt.Before(q[j].expireAt) }
+func (q nonceQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *nonceQueue) Push(x interface{}) {
+	*q = append(*q, x.(*nonceEntry))
+}
+
+func (q *nonceQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return e
+}
+
+func newNonceCache(header string, maxEntries int) *nonceCache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultNonceCacheSize
+	}
+	return &nonceCache{
+		header:     header,
+		maxEntries: maxEntries,
+		entries:    map[string]*nonceEntry{},
+	}
+}
+
+// nonceOf returns the nonce of a verified request
+func (c *nonceCache) nonceOf(ctx *SigningContext, req *http.Request) (string, error) {
+	if c.header == "" {
+		return ctx.Signature, nil
+	}
+
+	nonce := req.Header.Get(c.header)
+	if nonce == "" {
+		return "", fmt.Errorf("nonce header %s is missing", c.header)
+	}
+
+	name := strings.ToLower(c.header)
+	for _, h := range strings.Split(ctx.SignedHeaders, ";") {
+		if h == name {
+			// nonces of different access keys never conflict
+			return ctx.AccessKeyID + "/" + nonce, nil
+		}
+	}
+	return "", fmt.Errorf("nonce header %s is not signed", c.header)
+}
+
+// check records the nonce of a verified request, and returns an error if
+// the nonce has been used.
+func (c *nonceCache) check(ctx *SigningContext, req *http.Request, now time.Time) error {
+	nonce, err := c.nonceOf(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	expireAt := never
+	if ctx.ttl > 0 {
+		expireAt = ctx.Time.Add(ctx.ttl)
+	}
+	if ctx.isPresign {
+		if t := ctx.Time.Add(ctx.ExpireTime); t.Before(expireAt) {
+			expireAt = t
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for len(c.queue) > 0 && !c.queue[0].expireAt.After(now) {
+		e := heap.Pop(&c.queue).(*nonceEntry)
+		delete(c.entries, e.nonce)
+	}
+
+	if _, ok := c.entries[nonce]; ok {
+		return fmt.Errorf("signature replayed")
+	}
+
+	for len(c.queue) >= c.maxEntries {
+		e := heap.Pop(&c.queue).(*nonceEntry)
+		delete(c.entries, e.nonce)
+	}
+
+	e := &nonceEntry{nonce: nonce, expireAt: expireAt}
+	heap.Push(&c.queue, e)
+	c.entries[nonce] = e
+	return nil
+}
@@ -109,14 +109,21 @@ type (
 	Signer struct {
 		literal        *Literal
 		ignoredHeaders map[string]bool
+		signedHeaders  map[string]bool
 		headerHoisting *HeaderHoisting
 		ttl            time.Duration
+		clockSkew      time.Duration
 		excludeBody    bool
+		// disableURIPathEscaping escapes the URI path only once when building
+		// the canonical request, as Amazon S3 does
+		disableURIPathEscaping bool
 		// accessKeyID & accessKeySecret are for signing
 		accessKeyID     string
 		accessKeySecret string
 		// accessKeyStore is for signature verification
 		accessKeyStore AccessKeyStore
+		// nonces rejects replayed signatures during verification
+		nonces *nonceCache
 	}
 
 	// SigningContext is the signing context for a single request
@@ -174,7 +181,7 @@ func init() {
 	}
 }
 
-func buildCanonicalURI(u *url.URL) string {
+func buildCanonicalURI(u *url.URL, escape bool) string {
 	const hex = "0123456789ABCDEF"
 
 	var uri string
@@ -189,6 +196,10 @@ func buildCanonicalURI(u *url.URL) string {
 		return "/"
 	}
 
+	if !escape {
+		return uri
+	}
+
 	var buf bytes.Buffer
 	for i := 0; i < len(uri); i++ {
 		c := uri[i]
@@ -223,6 +234,25 @@ func (signer *Signer) IgnoreHeader(headers ...string) *Signer {
 	return signer
 }
 
+// SignHeader is an option function for Signer to add headers which must be
+// signed, other headers are not signed if any header is added
+func (signer *Signer) SignHeader(headers ...string) *Signer {
+	if signer.signedHeaders == nil {
+		signer.signedHeaders = map[string]bool{}
+	}
+	for _, h := range headers {
+		signer.signedHeaders[textproto.CanonicalMIMEHeaderKey(h)] = true
+	}
+	return signer
+}
+
+// DisableURIPathEscaping is an option function for Signer to escape the URI
+// path only once in the canonical request, which is required by Amazon S3
+func (signer *Signer) DisableURIPathEscaping(disable bool) *Signer {
+	signer.disableURIPathEscaping = disable
+	return signer
+}
+
 // SetHeaderHoisting is an option function for Singer to set header hoisting
 func (signer *Signer) SetHeaderHoisting(hh *HeaderHoisting) *Signer {
 	hh.disallowed = map[string]bool{}
@@ -239,6 +269,23 @@ func (signer *Signer) SetTTL(d time.Duration) *Signer {
 	return signer
 }
 
+// SetClockSkew is an option function for Signer to set the tolerance of
+// the request time later than now, TTL is used if it is zero
+func (signer *Signer) SetClockSkew(d time.Duration) *Signer {
+	signer.clockSkew = d
+	return signer
+}
+
+// SetReplayProtection is an option function for Signer to reject the reuse of
+// signatures during verification. The nonce is read from nonceHeader, which
+// must be signed, or the signature is the nonce if nonceHeader is empty. At
+// most maxEntries unexpired nonces are remembered, the earliest expiring ones
+// are forgotten when there are more.
+func (signer *Signer) SetReplayProtection(nonceHeader string, maxEntries int) *Signer {
+	signer.nonces = newNonceCache(nonceHeader, maxEntries)
+	return signer
+}
+
 // SetAccessKeyStore is an option function for Signer to set access key store
 func (signer *Signer) SetAccessKeyStore(store AccessKeyStore) *Signer {
 	signer.accessKeyStore = store
@@ -440,6 +487,17 @@ func (ctx *SigningContext) needHoisting(header string) bool {
 	return false
 }
 
+func (ctx *SigningContext) needSigning(header string) bool {
+	if ctx.signedHeaders == nil {
+		return true
+	}
+	if header == textproto.CanonicalMIMEHeaderKey(ctx.literal.Date) ||
+		header == textproto.CanonicalMIMEHeaderKey(ctx.literal.ContentSHA256) {
+		return true
+	}
+	return ctx.signedHeaders[header]
+}
+
 func (ctx *SigningContext) buildCanonicalHeaders(req *http.Request) {
 	type pair struct {
 		Name  string
@@ -453,7 +511,7 @@ func (ctx *SigningContext) buildCanonicalHeaders(req *http.Request) {
 	})
 
 	for k, v := range req.Header {
-		if ctx.ignoredHeaders[k] {
+		if ctx.ignoredHeaders[k] || !ctx.needSigning(k) {
 			continue
 		}
 
@@ -525,7 +583,7 @@ func (ctx *SigningContext) hashCanonicalRequest(req *http.Request) string {
 	buf.WriteString(req.Method)
 	buf.WriteByte('\n')
 
-	buf.WriteString(buildCanonicalURI(req.URL))
+	buf.WriteString(buildCanonicalURI(req.URL, !ctx.disableURIPathEscaping))
 	buf.WriteByte('\n')
 
 	buf.WriteString(ctx.getCanonicalQuery(req.URL))
@@ -749,11 +807,17 @@ func (signer *Signer) Verify(req *http.Request) error {
 		return e
 	}
 
-	age := time.Now().Sub(ctx.Time)
-	if ctx.ttl > 0 {
-		if age < -ctx.ttl || age > ctx.ttl {
-			return fmt.Errorf("signature expired")
-		}
+	now := time.Now()
+	age := now.Sub(ctx.Time)
+	skew := ctx.clockSkew
+	if skew == 0 {
+		skew = ctx.ttl
+	}
+	if skew > 0 && age < -skew {
+		return fmt.Errorf("signature expired")
+	}
+	if ctx.ttl > 0 && age > ctx.ttl {
+		return fmt.Errorf("signature expired")
 	}
 	if ctx.isPresign {
 		if age > ctx.ExpireTime {
@@ -761,6 +825,10 @@ func (signer *Signer) Verify(req *http.Request) error {
 		}
 	}
 
+	if e := ctx.checkSignedHeaders(); e != nil {
+		return e
+	}
+
 	secret, ok := signer.accessKeyStore.GetSecret(ctx.AccessKeyID)
 	if !ok {
 		return fmt.Errorf("access-key-id not found")
@@ -777,5 +845,30 @@ func (signer *Signer) Verify(req *http.Request) error {
 		return fmt.Errorf("signature verification failed")
 	}
 
+	// check replay after the verification, so that forged requests
+	// cannot consume the nonces
+	if signer.nonces != nil {
+		return signer.nonces.check(ctx, req, now)
+	}
+
+	return nil
+}
+
+// checkSignedHeaders checks all headers required to be signed are signed
+func (ctx *SigningContext) checkSignedHeaders() error {
+	if len(ctx.signedHeaders) == 0 {
+		return nil
+	}
+
+	signed := map[string]bool{}
+	for _, name := range strings.Split(ctx.SignedHeaders, ";") {
+		signed[name] = true
+	}
+	for name := range ctx.signedHeaders {
+		if !signed[strings.ToLower(name)] {
+			return fmt.Errorf("header %s is not signed", name)
+		}
+	}
+
 	return nil
 }
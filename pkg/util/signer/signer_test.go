@@ -321,6 +321,132 @@ func TestPresignVerify(t *testing.T) {
 	}
 }
 
+func TestSignedHeaders(t *testing.T) {
+	req := buildRequest("dynamodb", "us-east-1", "{}")
+
+	signer := CreateFromSpec(awsSpec).SignHeader("x-amz-target")
+	signer.NewContext(time.Now(), "us-east-1", "dynamodb").Sign(req)
+
+	auth := req.Header.Get("Authorization")
+	if !strings.Contains(auth, "SignedHeaders=host;x-amz-date;x-amz-target,") {
+		t.Errorf("unexpected authorization header: %s", auth)
+	}
+	if e := signer.Verify(req); e != nil {
+		t.Errorf("verification failed: %s", e.Error())
+	}
+
+	// headers out of the list can be changed
+	req.Header.Set("Content-Type", "text/plain")
+	if e := signer.Verify(req); e != nil {
+		t.Errorf("verification failed: %s", e.Error())
+	}
+
+	signer.SignHeader("Content-Type")
+	if e := signer.Verify(req); e == nil || e.Error() != "header Content-Type is not signed" {
+		t.Errorf("verification should failed for unsigned header, got %v", e)
+	}
+}
+
+func TestDisableURIPathEscaping(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.org/bucket/a%20b", nil)
+
+	if uri := buildCanonicalURI(req.URL, true); uri != "/bucket/a%2520b" {
+		t.Errorf("expect /bucket/a%%2520b, got %s", uri)
+	}
+	if uri := buildCanonicalURI(req.URL, false); uri != "/bucket/a%20b" {
+		t.Errorf("expect /bucket/a%%20b, got %s", uri)
+	}
+
+	signer := CreateFromSpec(awsSpec).DisableURIPathEscaping(true)
+	signer.NewContext(time.Now(), "us-east-1", "s3").Sign(req)
+	if e := signer.Verify(req); e != nil {
+		t.Errorf("verification failed: %s", e.Error())
+	}
+
+	signer.DisableURIPathEscaping(false)
+	if e := signer.Verify(req); e == nil {
+		t.Errorf("verification should failed, but didn't")
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	req := buildRequest("dynamodb", "us-east-1", "{}")
+
+	signer := CreateFromSpec(awsSpec).SetTTL(15 * time.Minute)
+	signer.NewContext(time.Now().Add(5*time.Minute), "us-east-1", "dynamodb").Sign(req)
+
+	// TTL is the tolerance if clock skew is not set
+	if e := signer.Verify(req); e != nil {
+		t.Errorf("verification failed: %s", e.Error())
+	}
+
+	signer.SetClockSkew(time.Minute)
+	if e := signer.Verify(req); e == nil {
+		t.Errorf("verification should failed, but didn't")
+	}
+
+	signer.SetClockSkew(10 * time.Minute)
+	if e := signer.Verify(req); e != nil {
+		t.Errorf("verification failed: %s", e.Error())
+	}
+}
+
+func TestReplayProtection(t *testing.T) {
+	signer := CreateFromSpec(awsSpec).SetTTL(15*time.Minute).SetReplayProtection("", 2)
+
+	req := buildRequest("dynamodb", "us-east-1", "{}")
+	signer.NewContext(time.Now(), "us-east-1", "dynamodb").Sign(req)
+	if e := signer.Verify(req); e != nil {
+		t.Errorf("verification failed: %s", e.Error())
+	}
+	if e := signer.Verify(req); e == nil || e.Error() != "signature replayed" {
+		t.Errorf("verification should failed for replay, got %v", e)
+	}
+
+	// the earliest expiring nonce is evicted when the cache is full
+	for i := 1; i <= 2; i++ {
+		r := buildRequest("dynamodb", "us-east-1", "{}")
+		signer.NewContext(time.Now().Add(time.Duration(i)*time.Second), "us-east-1", "dynamodb").Sign(r)
+		if e := signer.Verify(r); e != nil {
+			t.Errorf("verification failed: %s", e.Error())
+		}
+	}
+	if e := signer.Verify(req); e != nil {
+		t.Errorf("verification failed: %s", e.Error())
+	}
+
+	// expired nonces are removed
+	c := signer.nonces
+	c.queue[0].expireAt = time.Now().Add(-time.Second)
+	c.check(&SigningContext{Signer: signer, Signature: "x"}, req, time.Now())
+	if len(c.entries) != 2 || len(c.queue) != 2 {
+		t.Errorf("expect 2 nonces, got %d", len(c.entries))
+	}
+
+	signer.SetReplayProtection("X-Amz-Nonce", 0)
+	req = buildRequest("dynamodb", "us-east-1", "{}")
+	signer.NewContext(time.Now(), "us-east-1", "dynamodb").Sign(req)
+	if e := signer.Verify(req); e == nil || e.Error() != "nonce header X-Amz-Nonce is missing" {
+		t.Errorf("verification should failed for missing nonce, got %v", e)
+	}
+
+	req.Header.Set("X-Amz-Nonce", "n1")
+	if e := signer.Verify(req); e == nil {
+		t.Errorf("verification should failed, but didn't")
+	}
+
+	signer.NewContext(time.Now(), "us-east-1", "dynamodb").Sign(req)
+	if e := signer.Verify(req); e != nil {
+		t.Errorf("verification failed: %s", e.Error())
+	}
+
+	// a new signature with the same nonce is a replay
+	signer.NewContext(time.Now().Add(time.Second), "us-east-1", "dynamodb").Sign(req)
+	if e := signer.Verify(req); e == nil || e.Error() != "signature replayed" {
+		t.Errorf("verification should failed for replay, got %v", e)
+	}
+}
+
 func BenchmarkPresignRequest(b *testing.B) {
 	req := buildRequest("dynamodb", "us-east-1", "{}")
 
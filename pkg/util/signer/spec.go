@@ -19,18 +19,32 @@ package signer
 
 import "time"
 
-// Spec defines the configuration of a Signer
-type Spec struct {
-	Literal         *Literal          `yaml:"literial,omitempty" json:"literial,omitempty" jsonschema:"omitempty"`
-	HeaderHoisting  *HeaderHoisting   `yaml:"headerHoisting,omitempty" json:"headerHoisting,omitempty" jsonschema:"omitempty"`
-	IgnoredHeaders  []string          `yaml:"ignoredHeaders" json:"ignoredHeaders" jsonschema:"omitempty,uniqueItems=true"`
-	ExcludeBody     bool              `yaml:"excludeBody" json:"excludeBody" jsonschema:"omitempty"`
-	TTL             string            `yaml:"ttl" json:"ttl" jsonschema:"omitempty,format=duration"`
-	AccessKeyID     string            `yaml:"accessKeyId" json:"accessKeyId" jsonschema:"omitempty"`
-	AccessKeySecret string            `yaml:"accessKeySecret" json:"accessKeySecret" jsonschema:"omitempty"`
-	AccessKeys      map[string]string `yaml:"accessKeys" json:"accessKeys" jsonschema:"omitempty"`
-	// TODO: AccessKeys is used as an internal access key store, but an external store is also needed
-}
+type (
+	// Spec defines the configuration of a Signer
+	Spec struct {
+		Literal         *Literal          `yaml:"literial,omitempty" json:"literial,omitempty" jsonschema:"omitempty"`
+		HeaderHoisting  *HeaderHoisting   `yaml:"headerHoisting,omitempty" json:"headerHoisting,omitempty" jsonschema:"omitempty"`
+		IgnoredHeaders  []string          `yaml:"ignoredHeaders" json:"ignoredHeaders" jsonschema:"omitempty,uniqueItems=true"`
+		SignedHeaders   []string          `yaml:"signedHeaders" json:"signedHeaders" jsonschema:"omitempty,uniqueItems=true"`
+		ExcludeBody     bool              `yaml:"excludeBody" json:"excludeBody" jsonschema:"omitempty"`
+		TTL             string            `yaml:"ttl" json:"ttl" jsonschema:"omitempty,format=duration"`
+		ClockSkew       string            `yaml:"clockSkew" json:"clockSkew" jsonschema:"omitempty,format=duration"`
+		AccessKeyID     string            `yaml:"accessKeyId" json:"accessKeyId" jsonschema:"omitempty"`
+		AccessKeySecret string            `yaml:"accessKeySecret" json:"accessKeySecret" jsonschema:"omitempty"`
+		AccessKeys      map[string]string `yaml:"accessKeys" json:"accessKeys" jsonschema:"omitempty"`
+		// TODO: AccessKeys is used as an internal access key store, but an external store is also needed
+
+		// DisableURIPathEscaping escapes the URI path only once, as Amazon S3 requires
+		DisableURIPathEscaping bool              `yaml:"disableURIPathEscaping" json:"disableURIPathEscaping" jsonschema:"omitempty"`
+		ReplayProtection       *ReplayProtection `yaml:"replayProtection,omitempty" json:"replayProtection,omitempty" jsonschema:"omitempty"`
+	}
+
+	// ReplayProtection defines the nonce cache rejecting replayed requests
+	ReplayProtection struct {
+		NonceHeader string `yaml:"nonceHeader" json:"nonceHeader" jsonschema:"omitempty"`
+		MaxEntries  int    `yaml:"maxEntries" json:"maxEntries" jsonschema:"omitempty,minimum=1"`
+	}
+)
 
 type idSecretMap map[string]string
 
@@ -54,12 +68,24 @@ func CreateFromSpec(spec *Spec) *Signer {
 	}
 
 	signer.IgnoreHeader(spec.IgnoredHeaders...)
+	if len(spec.SignedHeaders) > 0 {
+		signer.SignHeader(spec.SignedHeaders...)
+	}
 	signer.ExcludeBody(spec.ExcludeBody)
+	signer.DisableURIPathEscaping(spec.DisableURIPathEscaping)
 
 	if ttl, e := time.ParseDuration(spec.TTL); e == nil {
 		signer.SetTTL(ttl)
 	}
 
+	if skew, e := time.ParseDuration(spec.ClockSkew); e == nil {
+		signer.SetClockSkew(skew)
+	}
+
+	if rp := spec.ReplayProtection; rp != nil {
+		signer.SetReplayProtection(rp.NonceHeader, rp.MaxEntries)
+	}
+
 	if len(spec.AccessKeys) > 0 {
 		signer.SetAccessKeyStore(idSecretMap(spec.AccessKeys))
 	}
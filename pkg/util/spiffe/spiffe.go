@@ -0,0 +1,399 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package spiffe obtains and rotates X.509-SVIDs from the SPIFFE Workload
+// API served by SPIRE agents, and authenticates peers by SPIFFE IDs.
+package spiffe
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/spiffe/workloadpb"
+)
+
+const (
+	// DefaultSocketPath is the default socket path of SPIRE agents.
+	DefaultSocketPath = "/run/spire/sockets/agent.sock"
+
+	schemePrefix = "spiffe://"
+
+	minRetryInterval = time.Second
+	maxRetryInterval = 30 * time.Second
+)
+
+type (
+	// Spec describes the SPIFFE identity of Easegress.
+	Spec struct {
+		// SocketPath is the unix socket of the Workload API.
+		SocketPath string `yaml:"socketPath" jsonschema:"omitempty"`
+		// AllowedIDs are the SPIFFE IDs of the peers allowed, an ID
+		// ending with /* matches all IDs under the path, all IDs of
+		// the trust domain are allowed if it's empty.
+		AllowedIDs []string `yaml:"allowedIDs" jsonschema:"omitempty,uniqueItems=true"`
+	}
+
+	// Source is the X.509-SVID source of a Workload API socket, it is
+	// shared by all users of the same socket.
+	Source struct {
+		socketPath string
+		refs       int
+		cancel     context.CancelFunc
+		done       chan struct{}
+
+		mutex     sync.RWMutex
+		svid      *tls.Certificate
+		id        string
+		bundle    *x509.CertPool
+		updatedAt time.Time
+		lastError string
+	}
+
+	// Status is the status of Source.
+	Status struct {
+		SocketPath string    `yaml:"socketPath"`
+		Healthy    bool      `yaml:"healthy"`
+		ID         string    `yaml:"id,omitempty"`
+		ExpiresAt  time.Time `yaml:"expiresAt,omitempty"`
+		UpdatedAt  time.Time `yaml:"updatedAt,omitempty"`
+		LastError  string    `yaml:"lastError,omitempty"`
+	}
+)
+
+var (
+	sourcesMutex sync.Mutex
+	sources      = map[string]*Source{}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	return ValidateIDs(spec.AllowedIDs)
+}
+
+// ValidateIDs validates the SPIFFE IDs of allowlists.
+func ValidateIDs(ids []string) error {
+	for _, id := range ids {
+		if !strings.HasPrefix(id, schemePrefix) || len(id) == len(schemePrefix) {
+			return fmt.Errorf("invalid spiffe id %s", id)
+		}
+	}
+	return nil
+}
+
+// Acquire returns the source of the socket, the caller must release
+// it after using.
+func Acquire(socketPath string) *Source {
+	if socketPath == "" {
+		socketPath = DefaultSocketPath
+	}
+
+	sourcesMutex.Lock()
+	defer sourcesMutex.Unlock()
+
+	s := sources[socketPath]
+	if s == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		s = &Source{
+			socketPath: socketPath,
+			cancel:     cancel,
+			done:       make(chan struct{}),
+			lastError:  "waiting for the first X.509-SVID",
+		}
+		sources[socketPath] = s
+		go s.run(ctx)
+	}
+	s.refs++
+
+	return s
+}
+
+// Release releases the source, it's closed when released by all users.
+func (s *Source) Release() {
+	sourcesMutex.Lock()
+	s.refs--
+	closed := s.refs == 0
+	if closed {
+		delete(sources, s.socketPath)
+	}
+	sourcesMutex.Unlock()
+
+	if closed {
+		s.cancel()
+		<-s.done
+	}
+}
+
+func (s *Source) run(ctx context.Context) {
+	defer close(s.done)
+
+	interval := minRetryInterval
+	for {
+		updatedAt := s.Status().UpdatedAt
+		err := s.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.setError(err)
+		logger.Errorf("watch x509-svid from %s failed: %v", s.socketPath, err)
+
+		// NOTE: Retry quickly if the stream worked before.
+		if !s.Status().UpdatedAt.Equal(updatedAt) {
+			interval = minRetryInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > maxRetryInterval {
+			interval = maxRetryInterval
+		}
+	}
+}
+
+func (s *Source) watch(ctx context.Context) error {
+	conn, err := grpc.DialContext(ctx, s.socketPath, grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", addr)
+		}))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// NOTE: The header is required by the Workload API to prevent SSRF.
+	ctx = metadata.AppendToOutgoingContext(ctx, "workload.spiffe.io", "true")
+	client := workloadpb.NewSpiffeWorkloadAPIClient(conn)
+	stream, err := client.FetchX509SVID(ctx, &workloadpb.X509SVIDRequest{})
+	if err != nil {
+		return err
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		err = s.update(resp)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Source) update(resp *workloadpb.X509SVIDResponse) error {
+	if len(resp.Svids) == 0 {
+		return fmt.Errorf("no x509-svid in the response")
+	}
+	svid := resp.Svids[0]
+
+	certs, err := x509.ParseCertificates(svid.X509Svid)
+	if err != nil {
+		return fmt.Errorf("parse x509-svid of %s failed: %v", svid.SpiffeId, err)
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("no certificate in x509-svid of %s", svid.SpiffeId)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(svid.X509SvidKey)
+	if err != nil {
+		return fmt.Errorf("parse private key of %s failed: %v", svid.SpiffeId, err)
+	}
+	bundleCerts, err := x509.ParseCertificates(svid.Bundle)
+	if err != nil {
+		return fmt.Errorf("parse bundle of %s failed: %v", svid.SpiffeId, err)
+	}
+
+	cert := &tls.Certificate{PrivateKey: key, Leaf: certs[0]}
+	for _, c := range certs {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	bundle := x509.NewCertPool()
+	for _, c := range bundleCerts {
+		bundle.AddCert(c)
+	}
+
+	s.mutex.Lock()
+	s.svid, s.id, s.bundle = cert, svid.SpiffeId, bundle
+	s.updatedAt, s.lastError = time.Now(), ""
+	s.mutex.Unlock()
+
+	logger.Infof("x509-svid of %s updated from %s, expires at %s",
+		svid.SpiffeId, s.socketPath, certs[0].NotAfter)
+
+	return nil
+}
+
+func (s *Source) setError(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastError = err.Error()
+}
+
+func (s *Source) certificate() (*tls.Certificate, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.svid == nil {
+		return nil, fmt.Errorf("no x509-svid from %s: %s", s.socketPath, s.lastError)
+	}
+	return s.svid, nil
+}
+
+// verify verifies the peer certificate chain against the bundle and
+// the allowlist.
+func (s *Source) verify(rawCerts [][]byte, allowedIDs []string) error {
+	s.mutex.RLock()
+	bundle := s.bundle
+	s.mutex.RUnlock()
+
+	if bundle == nil {
+		return fmt.Errorf("no trust bundle from %s", s.socketPath)
+	}
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("no peer certificate")
+	}
+
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("parse peer certificate failed: %v", err)
+		}
+		certs[i] = cert
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         bundle,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("verify peer certificate failed: %v", err)
+	}
+
+	id := certificateID(certs[0])
+	if id == "" {
+		return fmt.Errorf("no spiffe id in peer certificate")
+	}
+	if len(allowedIDs) != 0 && !Match(allowedIDs, id) {
+		return fmt.Errorf("spiffe id %s is not allowed", id)
+	}
+
+	return nil
+}
+
+// ServerTLSConfig returns the tls config of servers, which presents the
+// X.509-SVID and requires client X.509-SVIDs in the allowlist.
+func (s *Source) ServerTLSConfig(allowedIDs []string) *tls.Config {
+	return &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.certificate()
+		},
+		ClientAuth: tls.RequireAnyClientCert,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return s.verify(rawCerts, allowedIDs)
+		},
+	}
+}
+
+// ClientTLSConfig returns the tls config of clients, which presents the
+// X.509-SVID and requires server X.509-SVIDs in the allowlist.
+func (s *Source) ClientTLSConfig(allowedIDs []string) *tls.Config {
+	return &tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return s.certificate()
+		},
+		// NOTE: X.509-SVIDs are verified by SPIFFE IDs instead of
+		// host names, which is done in VerifyPeerCertificate.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return s.verify(rawCerts, allowedIDs)
+		},
+	}
+}
+
+// Status returns the status of the source.
+func (s *Source) Status() *Status {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	status := &Status{
+		SocketPath: s.socketPath,
+		Healthy:    s.svid != nil && s.lastError == "",
+		ID:         s.id,
+		UpdatedAt:  s.updatedAt,
+		LastError:  s.lastError,
+	}
+	if s.svid != nil {
+		status.ExpiresAt = s.svid.Leaf.NotAfter
+		if time.Now().After(status.ExpiresAt) {
+			status.Healthy = false
+		}
+	}
+	return status
+}
+
+// PeerID returns the SPIFFE ID of the peer, or empty if there isn't.
+func PeerID(state *tls.ConnectionState) string {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	return certificateID(state.PeerCertificates[0])
+}
+
+func certificateID(cert *x509.Certificate) string {
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			return uri.String()
+		}
+	}
+	return ""
+}
+
+// Match reports whether the SPIFFE ID matches any of the allowlist.
+func Match(allowedIDs []string, id string) bool {
+	if id == "" {
+		return false
+	}
+
+	for _, allowed := range allowedIDs {
+		if allowed == id {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(id, allowed[:len(allowed)-1]) {
+			return true
+		}
+	}
+	return false
+}
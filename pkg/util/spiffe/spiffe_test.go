@@ -0,0 +1,286 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spiffe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/spiffe/workloadpb"
+)
+
+type (
+	testCA struct {
+		cert *x509.Certificate
+		key  *ecdsa.PrivateKey
+	}
+
+	testWorkloadAPI struct {
+		workloadpb.UnimplementedSpiffeWorkloadAPIServer
+		responses chan *workloadpb.X509SVIDResponse
+	}
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		URIs:                  []*url.URL{{Scheme: "spiffe", Host: "example.org"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("create ca failed: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) svid(t *testing.T, id string) *workloadpb.X509SVID {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	u, _ := url.Parse(id)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		URIs:         []*url.URL{u},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatalf("create svid failed: %v", err)
+	}
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+	return &workloadpb.X509SVID{
+		SpiffeId:    id,
+		X509Svid:    der,
+		X509SvidKey: keyDER,
+		Bundle:      ca.cert.Raw,
+	}
+}
+
+func (api *testWorkloadAPI) FetchX509SVID(req *workloadpb.X509SVIDRequest, stream workloadpb.SpiffeWorkloadAPI_FetchX509SVIDServer) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	if len(md.Get("workload.spiffe.io")) == 0 {
+		return fmt.Errorf("security header missing")
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case resp := <-api.responses:
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func startWorkloadAPI(t *testing.T) (string, *testWorkloadAPI, func()) {
+	dir, _ := ioutil.TempDir("", "spiffe")
+	socketPath := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+
+	api := &testWorkloadAPI{responses: make(chan *workloadpb.X509SVIDResponse, 10)}
+	server := grpc.NewServer()
+	workloadpb.RegisterSpiffeWorkloadAPIServer(server, api)
+	go server.Serve(listener)
+
+	return socketPath, api, func() {
+		server.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func waitForID(t *testing.T, s *Source, id string) {
+	for i := 0; i < 100; i++ {
+		if status := s.Status(); status.Healthy && status.ID == id {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("wait for svid %s timeout, status: %+v", id, s.Status())
+}
+
+func handshake(serverConfig, clientConfig *tls.Config) (string, error) {
+	// NOTE: The buffered TCP connections prevent both sides from blocking
+	// on writing alerts, which happens on net.Pipe.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+
+	errCh := make(chan error, 1)
+	idCh := make(chan string, 1)
+	go func() {
+		serverConn, err := listener.Accept()
+		if err != nil {
+			idCh <- ""
+			errCh <- err
+			return
+		}
+		conn := tls.Server(serverConn, serverConfig)
+		err = conn.Handshake()
+		state := conn.ConnectionState()
+		idCh <- PeerID(&state)
+		errCh <- err
+		conn.Close()
+	}()
+
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return "", err
+	}
+	conn := tls.Client(clientConn, clientConfig)
+	clientErr := conn.Handshake()
+	conn.Close()
+	serverErr := <-errCh
+	if clientErr != nil {
+		return "", clientErr
+	}
+	return <-idCh, serverErr
+}
+
+func TestMatch(t *testing.T) {
+	allowed := []string{"spiffe://example.org/web", "spiffe://example.org/ns/prod/*"}
+	cases := map[string]bool{
+		"spiffe://example.org/web":          true,
+		"spiffe://example.org/web2":         false,
+		"spiffe://example.org/ns/prod/api":  true,
+		"spiffe://example.org/ns/prod":      false,
+		"spiffe://example.org/ns/staging/a": false,
+		"":                                  false,
+	}
+	for id, want := range cases {
+		if got := Match(allowed, id); got != want {
+			t.Errorf("match %q: want %v, got %v", id, want, got)
+		}
+	}
+
+	if ValidateIDs([]string{"spiffe://example.org/a"}) != nil {
+		t.Errorf("valid id should pass")
+	}
+	if ValidateIDs([]string{"https://example.org/a"}) == nil || ValidateIDs([]string{"spiffe://"}) == nil {
+		t.Errorf("invalid id should fail")
+	}
+}
+
+func TestSource(t *testing.T) {
+	socketPath, api, stop := startWorkloadAPI(t)
+	defer stop()
+
+	ca := newTestCA(t)
+	serverID, clientID := "spiffe://example.org/gateway", "spiffe://example.org/ns/prod/web"
+	api.responses <- &workloadpb.X509SVIDResponse{Svids: []*workloadpb.X509SVID{ca.svid(t, serverID)}}
+
+	server := Acquire(socketPath)
+	waitForID(t, server, serverID)
+
+	// NOTE: Both sides share the same source for the same socket, so the
+	// client uses the source of another fake agent.
+	clientSocketPath, clientAPI, clientStop := startWorkloadAPI(t)
+	defer clientStop()
+	clientAPI.responses <- &workloadpb.X509SVIDResponse{Svids: []*workloadpb.X509SVID{ca.svid(t, clientID)}}
+	client := Acquire(clientSocketPath)
+	defer client.Release()
+	waitForID(t, client, clientID)
+
+	id, err := handshake(server.ServerTLSConfig([]string{"spiffe://example.org/ns/prod/*"}),
+		client.ClientTLSConfig([]string{serverID}))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	if id != clientID {
+		t.Errorf("want peer id %s, got %s", clientID, id)
+	}
+
+	_, err = handshake(server.ServerTLSConfig([]string{"spiffe://example.org/admin"}),
+		client.ClientTLSConfig(nil))
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("client not in allowlist should be rejected, got %v", err)
+	}
+	_, err = handshake(server.ServerTLSConfig(nil),
+		client.ClientTLSConfig([]string{"spiffe://example.org/other"}))
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("server not in allowlist should be rejected, got %v", err)
+	}
+
+	// Rotation.
+	rotatedID := "spiffe://example.org/gateway-v2"
+	api.responses <- &workloadpb.X509SVIDResponse{Svids: []*workloadpb.X509SVID{ca.svid(t, rotatedID)}}
+	waitForID(t, server, rotatedID)
+
+	// Shared by users of the same socket.
+	if Acquire(socketPath) != server {
+		t.Errorf("source of the same socket should be shared")
+	}
+	server.Release()
+	server.Release()
+	if s := Acquire(socketPath); s == server {
+		t.Errorf("released source should be closed")
+	} else {
+		s.Release()
+	}
+}
+
+func TestUnavailableAgent(t *testing.T) {
+	s := Acquire(filepath.Join(os.TempDir(), "spiffe-nonexistent.sock"))
+	defer s.Release()
+
+	if s.Status().Healthy {
+		t.Errorf("source without svid should be unhealthy")
+	}
+	_, err := handshake(s.ServerTLSConfig(nil), &tls.Config{InsecureSkipVerify: true})
+	if err == nil {
+		t.Errorf("handshake without svid should fail")
+	}
+}
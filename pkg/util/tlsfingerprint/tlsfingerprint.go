@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+// Package tlsfingerprint fingerprints TLS clients by their ClientHellos,
+// by the JA3 method: https://github.com/salesforce/ja3.
+package tlsfingerprint
+
+import (
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultHeader is the default header of the JA3 hashes.
+	DefaultHeader = "X-EG-JA3"
+
+	versionTLS12 = 0x0303
+)
+
+type (
+	// Fingerprint is the fingerprint of a TLS client.
+	Fingerprint struct {
+		// JA3 is the string of the version, ciphers, extensions,
+		// curves and point formats.
+		JA3 string
+		// JA3Hash is the MD5 hex of JA3.
+		JA3Hash string
+	}
+
+	// Recorder records the fingerprints of the connections of a server
+	// by the remote addresses.
+	Recorder struct {
+		fingerprints sync.Map // remote address -> *Fingerprint
+	}
+)
+
+// isGREASE returns whether v is a GREASE value of RFC 8701, which are
+// ignored by JA3.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func join(values []uint16) string {
+	var sb strings.Builder
+	for _, v := range values {
+		if isGREASE(v) {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte('-')
+		}
+		sb.WriteString(strconv.Itoa(int(v)))
+	}
+	return sb.String()
+}
+
+// FromClientHello fingerprints the client of the ClientHello.
+//
+// NOTE: The legacy version of the ClientHello is not exposed, it's the
+// highest supported version up to TLS 1.2, like the clients send.
+func FromClientHello(hello *tls.ClientHelloInfo) *Fingerprint {
+	version := uint16(0)
+	for _, v := range hello.SupportedVersions {
+		if !isGREASE(v) && v > version {
+			version = v
+		}
+	}
+	if version > versionTLS12 {
+		version = versionTLS12
+	}
+
+	curves := make([]uint16, len(hello.SupportedCurves))
+	for i, c := range hello.SupportedCurves {
+		curves[i] = uint16(c)
+	}
+	points := make([]uint16, len(hello.SupportedPoints))
+	for i, p := range hello.SupportedPoints {
+		points[i] = uint16(p)
+	}
+
+	ja3 := strings.Join([]string{
+		strconv.Itoa(int(version)),
+		join(hello.CipherSuites),
+		join(hello.Extensions),
+		join(curves),
+		join(points),
+	}, ",")
+	sum := md5.Sum([]byte(ja3))
+	return &Fingerprint{JA3: ja3, JA3Hash: hex.EncodeToString(sum[:])}
+}
+
+// NewRecorder creates a Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Wrap returns a copy of config recording the fingerprints of clients,
+// the GetConfigForClient of config is still called.
+func (r *Recorder) Wrap(config *tls.Config) *tls.Config {
+	config = config.Clone()
+	getConfigForClient := config.GetConfigForClient
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if hello.Conn != nil {
+			r.fingerprints.Store(hello.Conn.RemoteAddr().String(), FromClientHello(hello))
+		}
+		if getConfigForClient != nil {
+			return getConfigForClient(hello)
+		}
+		return nil, nil
+	}
+	return config
+}
+
+// Lookup returns the fingerprint of the connection from remoteAddr, or
+// nil if it's not recorded.
+func (r *Recorder) Lookup(remoteAddr string) *Fingerprint {
+	if fp, ok := r.fingerprints.Load(remoteAddr); ok {
+		return fp.(*Fingerprint)
+	}
+	return nil
+}
+
+// ConnState forgets the fingerprints of closed connections, it's the
+// ConnState of http.Server.
+func (r *Recorder) ConnState(conn net.Conn, state http.ConnState) {
+	if state == http.StateClosed || state == http.StateHijacked {
+		r.fingerprints.Delete(conn.RemoteAddr().String())
+	}
+}
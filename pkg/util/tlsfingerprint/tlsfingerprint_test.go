@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tlsfingerprint
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFromClientHello(t *testing.T) {
+	hello := &tls.ClientHelloInfo{
+		SupportedVersions: []uint16{0x3a3a, tls.VersionTLS13, tls.VersionTLS12},
+		CipherSuites:      []uint16{0x0a0a, 4865, 4866, 49195},
+		Extensions:        []uint16{0x1a1a, 0, 23, 65281, 10, 11},
+		SupportedCurves:   []tls.CurveID{0x2a2a, tls.X25519, tls.CurveP256},
+		SupportedPoints:   []uint8{0},
+	}
+	fp := FromClientHello(hello)
+	if fp.JA3 != "771,4865-4866-49195,0-23-65281-10-11,29-23,0" {
+		t.Errorf("unexpected JA3 %s", fp.JA3)
+	}
+	if len(fp.JA3Hash) != 32 {
+		t.Errorf("unexpected JA3 hash %s", fp.JA3Hash)
+	}
+
+	hello.SupportedVersions = []uint16{tls.VersionTLS11, tls.VersionTLS10}
+	if fp = FromClientHello(hello); !strings.HasPrefix(fp.JA3, "770,") {
+		t.Errorf("unexpected JA3 %s", fp.JA3)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+
+	var fp *Fingerprint
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fp = r.Lookup(req.RemoteAddr)
+	}))
+	server.Config.ConnState = r.ConnState
+	server.TLS = r.Wrap(&tls.Config{})
+	server.StartTLS()
+	defer server.Close()
+
+	client := server.Client()
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if fp == nil || fp.JA3Hash == "" {
+		t.Fatalf("fingerprint should be recorded")
+	}
+
+	client.CloseIdleConnections()
+	server.Close()
+	count := 0
+	r.fingerprints.Range(func(k, v interface{}) bool {
+		count++
+		return true
+	})
+	if count != 0 {
+		t.Errorf("fingerprints of closed connections should be forgotten")
+	}
+}
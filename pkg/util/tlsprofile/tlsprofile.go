@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package tlsprofile provides named TLS policy profiles, which pin the
+// protocol versions, curves and cipher suites of TLS configurations.
+package tlsprofile
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	// Modern supports TLS 1.3 only, for clients which don't need
+	// backward compatibility.
+	Modern = "modern"
+	// Intermediate supports TLS 1.2 and 1.3 with AEAD cipher suites
+	// of forward secrecy, it's recommended for general purposes.
+	Intermediate = "intermediate"
+	// FIPS supports TLS 1.2 with the curves and cipher suites approved
+	// by FIPS 140-2 only, build with the tag boringcrypto to use the
+	// FIPS validated crypto backend.
+	FIPS = "fips"
+)
+
+type profile struct {
+	minVersion   uint16
+	maxVersion   uint16
+	curves       []tls.CurveID
+	cipherSuites []uint16
+}
+
+var profiles = map[string]*profile{
+	Modern: {
+		minVersion: tls.VersionTLS13,
+		maxVersion: tls.VersionTLS13,
+		curves:     []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		// NOTE: Cipher suites of TLS 1.3 are not configurable.
+	},
+	Intermediate: {
+		minVersion: tls.VersionTLS12,
+		maxVersion: tls.VersionTLS13,
+		curves:     []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		cipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	},
+	FIPS: {
+		// NOTE: The FIPS mode of BoringCrypto supports TLS 1.2 only.
+		minVersion: tls.VersionTLS12,
+		maxVersion: tls.VersionTLS12,
+		curves:     []tls.CurveID{tls.CurveP256, tls.CurveP384},
+		cipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		},
+	},
+}
+
+// FIPSBuild returns true if it's built with the FIPS validated crypto backend.
+func FIPSBuild() bool {
+	return fipsBuild
+}
+
+// Validate validates the name of the profile, empty name means no profile.
+func Validate(name string) error {
+	if name == "" {
+		return nil
+	}
+	if profiles[name] == nil {
+		return fmt.Errorf("unknown tls profile %s, supported: %s, %s, %s",
+			name, Modern, Intermediate, FIPS)
+	}
+	return nil
+}
+
+// Apply pins the protocol versions, curves and cipher suites of
+// the profile to the config, it does nothing if the name is empty.
+func Apply(config *tls.Config, name string) error {
+	if name == "" {
+		return nil
+	}
+
+	p := profiles[name]
+	if p == nil {
+		return Validate(name)
+	}
+
+	if name == FIPS && !fipsBuild {
+		logger.Warnf("tls profile %s is used, but the crypto backend is not FIPS validated", name)
+	}
+
+	config.MinVersion = p.minVersion
+	config.MaxVersion = p.maxVersion
+	config.CurvePreferences = append([]tls.CurveID(nil), p.curves...)
+	config.CipherSuites = append([]uint16(nil), p.cipherSuites...)
+	config.PreferServerCipherSuites = true
+
+	return nil
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tlsprofile
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// handshake returns the negotiated version between the server and
+// the client with the profiles.
+func handshake(t *testing.T, cert tls.Certificate, serverProfile, clientProfile string) (uint16, error) {
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if err := Apply(serverConfig, serverProfile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clientConfig := &tls.Config{InsecureSkipVerify: true}
+	if err := Apply(clientConfig, clientProfile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	server := tls.Server(c1, serverConfig)
+	go func() {
+		server.Handshake()
+		c1.Close()
+	}()
+
+	client := tls.Client(c2, clientConfig)
+	err := client.Handshake()
+	if err != nil {
+		return 0, err
+	}
+	return client.ConnectionState().Version, nil
+}
+
+func TestValidate(t *testing.T) {
+	for _, name := range []string{"", Modern, Intermediate, FIPS} {
+		if err := Validate(name); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if Validate("old") == nil {
+		t.Errorf("profile old should be invalid")
+	}
+	if Apply(&tls.Config{}, "old") == nil {
+		t.Errorf("profile old should be invalid")
+	}
+}
+
+func TestApply(t *testing.T) {
+	config := &tls.Config{}
+	Apply(config, "")
+	if config.MinVersion != 0 || config.CipherSuites != nil {
+		t.Errorf("empty profile should not change the config")
+	}
+
+	Apply(config, FIPS)
+	if config.MinVersion != tls.VersionTLS12 || config.MaxVersion != tls.VersionTLS12 {
+		t.Errorf("unexpected versions %x-%x", config.MinVersion, config.MaxVersion)
+	}
+	for _, c := range config.CurvePreferences {
+		if c == tls.X25519 {
+			t.Errorf("X25519 is not approved by FIPS")
+		}
+	}
+
+	// The profile is not shared by configs.
+	config.CipherSuites[0] = 0
+	if profiles[FIPS].cipherSuites[0] == 0 {
+		t.Errorf("cipher suites of the profile should not be changed")
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	cert := newCertificate(t)
+
+	cases := []struct {
+		server, client string
+		version        uint16
+	}{
+		{Modern, Modern, tls.VersionTLS13},
+		{Modern, Intermediate, tls.VersionTLS13},
+		{Intermediate, Intermediate, tls.VersionTLS13},
+		{Intermediate, FIPS, tls.VersionTLS12},
+		{FIPS, "", tls.VersionTLS12},
+		{FIPS, Modern, 0},
+		{Modern, FIPS, 0},
+	}
+	for _, c := range cases {
+		version, err := handshake(t, cert, c.server, c.client)
+		if c.version == 0 {
+			if err == nil {
+				t.Errorf("handshake between %s and %s should fail", c.server, c.client)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("handshake between %s and %s failed: %v", c.server, c.client, err)
+		} else if version != c.version {
+			t.Errorf("handshake between %s and %s: want version %x, got %x", c.server, c.client, c.version, version)
+		}
+	}
+}
@@ -0,0 +1,486 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package vault obtains and renews dynamic credentials from HashiCorp
+// Vault, so that specs don't contain static secrets of upstreams.
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	defaultKubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	requestTimeout = 10 * time.Second
+
+	// refreshInterval is the interval to read credentials without leases.
+	refreshInterval = 5 * time.Minute
+
+	minRetryInterval = 5 * time.Second
+	maxRetryInterval = time.Minute
+)
+
+type (
+	// Spec describes the credentials in Vault, the token defaults to
+	// the environment variable VAULT_TOKEN if no auth method specified.
+	Spec struct {
+		Address   string `yaml:"address" jsonschema:"required,format=url"`
+		Namespace string `yaml:"namespace" jsonschema:"omitempty"`
+		// Path is the path of the credentials, e.g. database/creds/analytics,
+		// the secret must contain username and password.
+		Path string `yaml:"path" jsonschema:"required"`
+
+		Token      string          `yaml:"token" jsonschema:"omitempty"`
+		AppRole    *AppRoleSpec    `yaml:"appRole,omitempty" jsonschema:"omitempty"`
+		Kubernetes *KubernetesSpec `yaml:"kubernetes,omitempty" jsonschema:"omitempty"`
+	}
+
+	// AppRoleSpec is the spec of the AppRole auth method.
+	AppRoleSpec struct {
+		MountPath string `yaml:"mountPath" jsonschema:"omitempty"`
+		RoleID    string `yaml:"roleId" jsonschema:"required"`
+		SecretID  string `yaml:"secretId" jsonschema:"omitempty"`
+	}
+
+	// KubernetesSpec is the spec of the Kubernetes auth method.
+	KubernetesSpec struct {
+		MountPath string `yaml:"mountPath" jsonschema:"omitempty"`
+		Role      string `yaml:"role" jsonschema:"required"`
+		TokenPath string `yaml:"tokenPath" jsonschema:"omitempty"`
+	}
+
+	// Credentials is the credentials read from Vault, it's immutable,
+	// rotated credentials are new Credentials.
+	Credentials struct {
+		Username string
+		Password string
+
+		leaseID       string
+		leaseDuration time.Duration
+		renewable     bool
+		expiresAt     time.Time
+	}
+
+	// Client reads the credentials and keeps them valid by renewing the
+	// lease, new credentials are read if the lease can't be renewed.
+	Client struct {
+		spec       *Spec
+		httpClient *http.Client
+
+		token          string
+		tokenExpiresAt time.Time
+
+		mutex       sync.Mutex
+		credentials *Credentials
+		lastError   string
+
+		done chan struct{}
+		wg   sync.WaitGroup
+	}
+
+	// Status is the status of Client.
+	Status struct {
+		Path      string    `yaml:"path"`
+		LeaseID   string    `yaml:"leaseID,omitempty"`
+		ExpiresAt time.Time `yaml:"expiresAt,omitempty"`
+		LastError string    `yaml:"lastError,omitempty"`
+	}
+
+	secret struct {
+		LeaseID       string                 `json:"lease_id"`
+		LeaseDuration int64                  `json:"lease_duration"`
+		Renewable     bool                   `json:"renewable"`
+		Data          map[string]interface{} `json:"data"`
+		Auth          *struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int64  `json:"lease_duration"`
+		} `json:"auth"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	methods := 0
+	if spec.Token != "" {
+		methods++
+	}
+	if spec.AppRole != nil {
+		methods++
+	}
+	if spec.Kubernetes != nil {
+		methods++
+	}
+	if methods > 1 {
+		return fmt.Errorf("at most one of token, appRole and kubernetes can be specified")
+	}
+	if strings.HasPrefix(spec.Path, "/") {
+		return fmt.Errorf("path %s must not start with /", spec.Path)
+	}
+	return nil
+}
+
+// New creates a Client, it reads the credentials in background.
+func New(spec *Spec) *Client {
+	c := &Client{
+		spec:       spec,
+		httpClient: &http.Client{Timeout: requestTimeout},
+		done:       make(chan struct{}),
+	}
+
+	c.wg.Add(1)
+	go c.run()
+
+	return c
+}
+
+// Get returns the current credentials, it reads them if there are no
+// valid ones. Callers should compare the returned pointer with the
+// previous one to find out rotated credentials.
+func (c *Client) Get() (*Credentials, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.credentials != nil && !c.credentials.expired() {
+		return c.credentials, nil
+	}
+
+	err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	return c.credentials, nil
+}
+
+// Status returns the status of Client.
+func (c *Client) Status() *Status {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s := &Status{Path: c.spec.Path, LastError: c.lastError}
+	if c.credentials != nil {
+		s.LeaseID = c.credentials.leaseID
+		if c.credentials.leaseDuration != 0 {
+			s.ExpiresAt = c.credentials.expiresAt
+		}
+	}
+	return s
+}
+
+// Close stops renewing and revokes the lease.
+func (c *Client) Close() {
+	close(c.done)
+	c.wg.Wait()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.credentials != nil && c.credentials.leaseID != "" {
+		err := c.do(http.MethodPut, "sys/leases/revoke", map[string]interface{}{
+			"lease_id": c.credentials.leaseID,
+		}, nil)
+		if err != nil {
+			logger.Warnf("revoke vault lease %s failed: %v", c.credentials.leaseID, err)
+		}
+	}
+	c.credentials = nil
+}
+
+func (c *Client) run() {
+	defer c.wg.Done()
+
+	retryInterval := minRetryInterval
+	for {
+		wait, err := c.refresh()
+		if err != nil {
+			logger.Errorf("refresh vault credentials of %s failed: %v", c.spec.Path, err)
+			wait = retryInterval
+			if retryInterval *= 2; retryInterval > maxRetryInterval {
+				retryInterval = maxRetryInterval
+			}
+		} else {
+			retryInterval = minRetryInterval
+		}
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// refresh renews or reads the credentials, and returns the duration
+// to the next refreshing.
+func (c *Client) refresh() (time.Duration, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	creds := c.credentials
+	switch {
+	case creds == nil || creds.expired():
+		if err := c.read(); err != nil {
+			return 0, err
+		}
+	case creds.leaseDuration == 0:
+		// NOTE: Credentials without leases are re-read periodically,
+		// e.g. the ones in the KV secrets engine.
+		if err := c.read(); err != nil {
+			return 0, err
+		}
+	case creds.renewable:
+		if err := c.renew(); err != nil {
+			logger.Warnf("renew vault lease %s failed, read new credentials: %v", creds.leaseID, err)
+			if err = c.read(); err != nil {
+				return 0, err
+			}
+		}
+	default:
+		if err := c.read(); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.credentials.nextRefresh(), nil
+}
+
+// read reads new credentials, the caller must hold the mutex.
+func (c *Client) read() error {
+	s := &secret{}
+	err := c.do(http.MethodGet, c.spec.Path, nil, s)
+	if err != nil {
+		c.lastError = err.Error()
+		return err
+	}
+
+	data := s.Data
+	// NOTE: Secrets of the KV secrets engine version 2 are nested.
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	username, _ := data["username"].(string)
+	password, _ := data["password"].(string)
+	if username == "" {
+		err = fmt.Errorf("no username in secret %s", c.spec.Path)
+		c.lastError = err.Error()
+		return err
+	}
+
+	old := c.credentials
+	c.credentials = &Credentials{
+		Username:      username,
+		Password:      password,
+		leaseID:       s.LeaseID,
+		leaseDuration: time.Duration(s.LeaseDuration) * time.Second,
+		renewable:     s.Renewable,
+		expiresAt:     time.Now().Add(time.Duration(s.LeaseDuration) * time.Second),
+	}
+	c.lastError = ""
+
+	if old != nil && old.Username != username {
+		logger.Infof("vault credentials of %s rotated from %s to %s", c.spec.Path, old.Username, username)
+	}
+	return nil
+}
+
+// renew renews the lease, the caller must hold the mutex.
+func (c *Client) renew() error {
+	creds := c.credentials
+	s := &secret{}
+	err := c.do(http.MethodPut, "sys/leases/renew", map[string]interface{}{
+		"lease_id":  creds.leaseID,
+		"increment": int64(creds.leaseDuration / time.Second),
+	}, s)
+	if err != nil {
+		return err
+	}
+
+	duration := time.Duration(s.LeaseDuration) * time.Second
+	// NOTE: The lease is capped by the max TTL, read new credentials
+	// before it runs out.
+	if duration < creds.leaseDuration/3 {
+		return fmt.Errorf("lease is approaching max ttl")
+	}
+
+	// NOTE: Keep the pointer for users, it's the same credentials,
+	// and users don't read the lease fields.
+	creds.expiresAt = time.Now().Add(duration)
+	c.lastError = ""
+	return nil
+}
+
+func (c *Client) getToken() (string, error) {
+	switch {
+	case c.spec.AppRole != nil || c.spec.Kubernetes != nil:
+		if c.token != "" && (c.tokenExpiresAt.IsZero() || time.Now().Before(c.tokenExpiresAt)) {
+			return c.token, nil
+		}
+		return c.login()
+	case c.spec.Token != "":
+		return c.spec.Token, nil
+	default:
+		return os.Getenv("VAULT_TOKEN"), nil
+	}
+}
+
+func (c *Client) login() (string, error) {
+	var mountPath string
+	var body map[string]interface{}
+	if c.spec.AppRole != nil {
+		mountPath = c.spec.AppRole.MountPath
+		if mountPath == "" {
+			mountPath = "approle"
+		}
+		body = map[string]interface{}{
+			"role_id":   c.spec.AppRole.RoleID,
+			"secret_id": c.spec.AppRole.SecretID,
+		}
+	} else {
+		mountPath = c.spec.Kubernetes.MountPath
+		if mountPath == "" {
+			mountPath = "kubernetes"
+		}
+		tokenPath := c.spec.Kubernetes.TokenPath
+		if tokenPath == "" {
+			tokenPath = defaultKubernetesTokenPath
+		}
+		jwt, err := ioutil.ReadFile(tokenPath)
+		if err != nil {
+			return "", fmt.Errorf("read service account token failed: %v", err)
+		}
+		body = map[string]interface{}{
+			"role": c.spec.Kubernetes.Role,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		}
+	}
+
+	s := &secret{}
+	err := c.request(http.MethodPost, "auth/"+mountPath+"/login", "", body, s)
+	if err != nil {
+		return "", fmt.Errorf("login failed: %v", err)
+	}
+	if s.Auth == nil || s.Auth.ClientToken == "" {
+		return "", fmt.Errorf("login failed: no client token")
+	}
+
+	c.token = s.Auth.ClientToken
+	c.tokenExpiresAt = time.Time{}
+	if s.Auth.LeaseDuration > 0 {
+		// NOTE: Login again a little earlier than the token expires.
+		ttl := time.Duration(s.Auth.LeaseDuration) * time.Second
+		c.tokenExpiresAt = time.Now().Add(ttl * 9 / 10)
+	}
+	return c.token, nil
+}
+
+// do sends the request with the token, it logins again if the token
+// of the auth method is denied.
+func (c *Client) do(method, path string, body, result interface{}) error {
+	token, err := c.getToken()
+	if err != nil {
+		return err
+	}
+
+	err = c.request(method, path, token, body, result)
+	if e, ok := err.(*statusError); ok && e.code == http.StatusForbidden &&
+		(c.spec.AppRole != nil || c.spec.Kubernetes != nil) {
+		c.token = ""
+		token, err = c.login()
+		if err != nil {
+			return err
+		}
+		err = c.request(method, path, token, body, result)
+	}
+	return err
+}
+
+type statusError struct {
+	code int
+	body string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d: %s", e.code, e.body)
+}
+
+func (c *Client) request(method, path, token string, body, result interface{}) error {
+	var reqBody []byte
+	if body != nil {
+		reqBody, _ = json.Marshal(body)
+	}
+
+	url := strings.TrimSuffix(c.spec.Address, "/") + "/v1/" + path
+	req, err := http.NewRequest(method, url, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if c.spec.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.spec.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return &statusError{code: resp.StatusCode, body: strings.TrimSpace(string(respBody))}
+	}
+	if result == nil || len(respBody) == 0 {
+		return nil
+	}
+	return json.Unmarshal(respBody, result)
+}
+
+func (c *Credentials) expired() bool {
+	return c.leaseDuration != 0 && !time.Now().Before(c.expiresAt)
+}
+
+// nextRefresh returns the duration to the next refreshing, which is at
+// two thirds of the remaining lease.
+func (c *Credentials) nextRefresh() time.Duration {
+	if c.leaseDuration == 0 {
+		return refreshInterval
+	}
+
+	wait := time.Until(c.expiresAt) * 2 / 3
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
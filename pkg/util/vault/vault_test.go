@@ -0,0 +1,218 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+type fakeVault struct {
+	mutex sync.Mutex
+
+	leaseDuration int64
+	renewDuration int64
+	reads         int
+	renews        int
+	logins        int
+	revoked       []string
+	validToken    string
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	body := map[string]interface{}{}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	if r.URL.Path == "/v1/auth/approle/login" {
+		if body["role_id"] != "role" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		v.logins++
+		v.validToken = fmt.Sprintf("approle-token-%d", v.logins)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": v.validToken, "lease_duration": 3600},
+		})
+		return
+	}
+
+	if r.Header.Get("X-Vault-Token") != v.validToken || r.Header.Get("X-Vault-Namespace") != "ns1" {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"errors":["permission denied"]}`)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v1/database/creds/analytics":
+		v.reads++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"lease_id":       fmt.Sprintf("database/creds/analytics/%d", v.reads),
+			"lease_duration": v.leaseDuration,
+			"renewable":      true,
+			"data":           map[string]interface{}{"username": fmt.Sprintf("user-%d", v.reads), "password": "secret"},
+		})
+	case "/v1/secret/data/kafka":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data": map[string]interface{}{"username": "kafka", "password": "kafka-secret"},
+			},
+		})
+	case "/v1/sys/leases/renew":
+		v.renews++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"lease_id":       body["lease_id"],
+			"lease_duration": v.renewDuration,
+			"renewable":      true,
+		})
+	case "/v1/sys/leases/revoke":
+		v.revoked = append(v.revoked, body["lease_id"].(string))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (v *fakeVault) stats() (reads, renews, logins int, revoked []string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.reads, v.renews, v.logins, append([]string{}, v.revoked...)
+}
+
+func TestValidate(t *testing.T) {
+	spec := Spec{Address: "http://127.0.0.1:8200", Path: "database/creds/a"}
+	if spec.Validate() != nil {
+		t.Errorf("spec should be valid")
+	}
+	spec.Token, spec.AppRole = "token", &AppRoleSpec{RoleID: "role"}
+	if spec.Validate() == nil {
+		t.Errorf("spec with two auth methods should be invalid")
+	}
+	spec = Spec{Path: "/database/creds/a"}
+	if spec.Validate() == nil {
+		t.Errorf("path starting with / should be invalid")
+	}
+}
+
+func TestTokenAndKV(t *testing.T) {
+	v := &fakeVault{validToken: "root"}
+	server := httptest.NewServer(v)
+	defer server.Close()
+
+	os.Setenv("VAULT_TOKEN", "root")
+	defer os.Unsetenv("VAULT_TOKEN")
+
+	c := New(&Spec{Address: server.URL, Namespace: "ns1", Path: "secret/data/kafka"})
+	defer c.Close()
+
+	creds, err := c.Get()
+	if err != nil {
+		t.Fatalf("get credentials failed: %v", err)
+	}
+	if creds.Username != "kafka" || creds.Password != "kafka-secret" {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+	if creds2, _ := c.Get(); creds2 != creds {
+		t.Errorf("credentials without lease should be cached")
+	}
+
+	bad := New(&Spec{Address: server.URL, Namespace: "ns1", Path: "secret/data/kafka", Token: "bad"})
+	defer bad.Close()
+	if _, err = bad.Get(); err == nil {
+		t.Errorf("get with bad token should fail")
+	}
+	if bad.Status().LastError == "" {
+		t.Errorf("status should report the error")
+	}
+}
+
+func TestRenewAndRotate(t *testing.T) {
+	v := &fakeVault{leaseDuration: 3, renewDuration: 3}
+	server := httptest.NewServer(v)
+	defer server.Close()
+
+	c := New(&Spec{
+		Address:   server.URL,
+		Namespace: "ns1",
+		Path:      "database/creds/analytics",
+		AppRole:   &AppRoleSpec{RoleID: "role", SecretID: "secret"},
+	})
+
+	creds, err := c.Get()
+	if err != nil {
+		t.Fatalf("get credentials failed: %v", err)
+	}
+
+	// The lease is renewed at 2/3 of it.
+	time.Sleep(2500 * time.Millisecond)
+	_, renews, _, _ := v.stats()
+	if renews == 0 {
+		t.Fatalf("lease should be renewed")
+	}
+	if creds2, _ := c.Get(); creds2 != creds {
+		t.Errorf("renewed credentials should be the same")
+	}
+
+	// The lease reaches max ttl, new credentials are read.
+	v.mutex.Lock()
+	v.renewDuration = 0
+	v.mutex.Unlock()
+	time.Sleep(2500 * time.Millisecond)
+	creds2, err := c.Get()
+	if err != nil {
+		t.Fatalf("get credentials failed: %v", err)
+	}
+	if creds2 == creds || creds2.Username == creds.Username {
+		t.Errorf("credentials should be rotated, got %+v", creds2)
+	}
+
+	// The token is revoked, login again.
+	v.mutex.Lock()
+	v.validToken = "revoked"
+	v.mutex.Unlock()
+	c.mutex.Lock()
+	err = c.read()
+	c.mutex.Unlock()
+	if err != nil {
+		t.Errorf("read should login again: %v", err)
+	}
+
+	c.Close()
+	_, _, logins, revoked := v.stats()
+	if logins < 2 {
+		t.Errorf("should login again when the token is denied")
+	}
+	if len(revoked) != 1 {
+		t.Errorf("lease should be revoked on close, got %v", revoked)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package waf
+
+// baselineRules are the built-in rules against the common attacks, the
+// rule ids are in the ranges of the OWASP Core Rule Set for the same
+// attacks, so the exclusions by the ranges apply to both.
+const baselineRules = `
+# Scanners
+SecRule REQUEST_HEADERS:User-Agent "@pm sqlmap nikto nmap masscan dirbuster acunetix nessus w3af zgrab" \
+    "id:913100,phase:1,block,t:none,t:lowercase,msg:'Security scanner detected',tag:'attack-reputation-scanner',severity:'CRITICAL'"
+
+# Protocol
+SecRule REQUEST_URI|REQUEST_HEADERS|ARGS|ARGS_NAMES "@validateByteRange 1-255" \
+    "id:920270,phase:2,block,t:none,t:urlDecodeUni,msg:'Invalid character in request (null character)',tag:'attack-protocol',severity:'CRITICAL'"
+
+# Local file inclusion
+SecRule REQUEST_URI|ARGS|REQUEST_HEADERS:Referer "@rx (?:^|[\\/])\.\.(?:[\\/]|$)" \
+    "id:930100,phase:2,block,t:none,t:urlDecodeUni,t:urlDecodeUni,msg:'Path traversal attack (/../)',tag:'attack-lfi',severity:'CRITICAL'"
+SecRule REQUEST_FILENAME|ARGS "@pm etc/passwd etc/shadow proc/self/environ windows/win.ini boot.ini .htaccess .htpasswd" \
+    "id:930120,phase:2,block,t:none,t:urlDecodeUni,t:normalizePathWin,t:lowercase,msg:'OS file access attempt',tag:'attack-lfi',severity:'CRITICAL'"
+
+# Remote command execution
+SecRule ARGS|REQUEST_COOKIES "@rx (?:[;|&\x60\n]|\$\(|\|\|)\s*(?:cat|ls|id|whoami|uname|wget|curl|nc|ncat|bash|sh|zsh|python[23]?|perl|ruby|php|rm|chmod|ping|nslookup)\b" \
+    "id:932100,phase:2,block,t:none,t:urlDecodeUni,t:lowercase,msg:'Remote command execution: Unix command injection',tag:'attack-rce',severity:'CRITICAL'"
+SecRule ARGS|REQUEST_COOKIES "@pm /bin/bash /bin/sh /bin/zsh /usr/bin/env /bin/busybox cmd.exe powershell" \
+    "id:932160,phase:2,block,t:none,t:urlDecodeUni,t:cmdLine,t:normalizePath,msg:'Remote command execution: shell invocation',tag:'attack-rce',severity:'CRITICAL'"
+
+# PHP injection
+SecRule ARGS|REQUEST_COOKIES|REQUEST_BODY "@rx <\?(?:php|=)" \
+    "id:933100,phase:2,block,t:none,t:urlDecodeUni,t:lowercase,msg:'PHP injection attack: opening tag',tag:'attack-injection-php',severity:'CRITICAL'"
+
+# Cross site scripting
+SecRule ARGS|ARGS_NAMES|REQUEST_COOKIES|REQUEST_HEADERS:Referer|REQUEST_HEADERS:User-Agent "@rx <script[^>]*>" \
+    "id:941100,phase:2,block,t:none,t:urlDecodeUni,t:htmlEntityDecode,t:removeNulls,t:lowercase,msg:'XSS attack: script tag',tag:'attack-xss',severity:'CRITICAL'"
+SecRule ARGS|ARGS_NAMES|REQUEST_COOKIES|REQUEST_HEADERS:Referer "@rx (?:^|[\s\"'/<>])on(?:error|load|click|dblclick|mouse\w+|key\w+|focus|blur|submit|change|toggle|animationstart)\s*=" \
+    "id:941110,phase:2,block,t:none,t:urlDecodeUni,t:htmlEntityDecode,t:removeNulls,t:lowercase,msg:'XSS attack: event handler',tag:'attack-xss',severity:'CRITICAL'"
+SecRule ARGS|REQUEST_COOKIES|REQUEST_HEADERS:Referer "@rx (?:javascript|vbscript|livescript)\s*:" \
+    "id:941120,phase:2,block,t:none,t:urlDecodeUni,t:htmlEntityDecode,t:removeWhitespace,t:lowercase,msg:'XSS attack: script URI',tag:'attack-xss',severity:'CRITICAL'"
+SecRule ARGS|REQUEST_COOKIES "@rx <(?:iframe|object|embed|svg|img|body|style|base|form|meta)\b[^>]*(?:\bon\w+|\bsrc|\bhref|\bdata|\baction)\s*=" \
+    "id:941130,phase:2,block,t:none,t:urlDecodeUni,t:htmlEntityDecode,t:removeNulls,t:lowercase,msg:'XSS attack: dangerous tag',tag:'attack-xss',severity:'CRITICAL'"
+
+# SQL injection
+SecRule ARGS|ARGS_NAMES|REQUEST_COOKIES "@rx \bunion\b(?:\s+(?:all|distinct))?\s+select\b" \
+    "id:942100,phase:2,block,t:none,t:urlDecodeUni,t:replaceComments,t:compressWhitespace,t:lowercase,msg:'SQL injection attack: union select',tag:'attack-sqli',severity:'CRITICAL'"
+SecRule ARGS|REQUEST_COOKIES "@rx ['\"\d)]\s*\b(?:or|and|xor)\b\s*['\"(]?\s*[\w'\"]+\s*['\"]?\s*(?:=|<>|!=|<|>|\blike\b|\bis\b)" \
+    "id:942110,phase:2,block,t:none,t:urlDecodeUni,t:replaceComments,t:compressWhitespace,t:lowercase,msg:'SQL injection attack: tautology',tag:'attack-sqli',severity:'CRITICAL'"
+SecRule ARGS|REQUEST_COOKIES "@rx ['\"]\s*(?:;|--|#|/\*)" \
+    "id:942120,phase:2,block,t:none,t:urlDecodeUni,t:lowercase,msg:'SQL injection attack: string termination',tag:'attack-sqli',severity:'CRITICAL'"
+SecRule ARGS|REQUEST_COOKIES "@rx \b(?:sleep|benchmark|pg_sleep|load_file|extractvalue|updatexml)\s*\(|\bwaitfor\s+delay\b|\binto\s+(?:out|dump)file\b" \
+    "id:942130,phase:2,block,t:none,t:urlDecodeUni,t:replaceComments,t:compressWhitespace,t:lowercase,msg:'SQL injection attack: dangerous function',tag:'attack-sqli',severity:'CRITICAL'"
+SecRule ARGS|REQUEST_COOKIES "@pm information_schema mysql.user sysobjects syscolumns pg_catalog pg_shadow xp_cmdshell sqlite_master" \
+    "id:942140,phase:2,block,t:none,t:urlDecodeUni,t:lowercase,msg:'SQL injection attack: database names',tag:'attack-sqli',severity:'CRITICAL'"
+SecRule ARGS|REQUEST_COOKIES "@rx ;\s*(?:drop|delete|insert|update|alter|create|truncate|exec|shutdown)\b" \
+    "id:942150,phase:2,block,t:none,t:urlDecodeUni,t:replaceComments,t:compressWhitespace,t:lowercase,msg:'SQL injection attack: stacked query',tag:'attack-sqli',severity:'CRITICAL'"
+`
@@ -0,0 +1,193 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package waf
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type operator struct {
+	name   string
+	negate bool
+	fn     func(value string) bool
+}
+
+// newOperator parses an operator like "@rx ^a", "!@pm a b" or "^a",
+// which is a @rx without the name.
+func newOperator(text string) (*operator, error) {
+	op := &operator{}
+	if strings.HasPrefix(text, "!") {
+		op.negate, text = true, text[1:]
+	}
+
+	name, arg := "rx", text
+	if strings.HasPrefix(text, "@") {
+		name, arg = text[1:], ""
+		if i := strings.IndexAny(text, " \t"); i >= 0 {
+			name, arg = text[1:i], strings.TrimSpace(text[i+1:])
+		}
+	}
+	op.name = name
+
+	build, ok := operators[name]
+	if !ok {
+		return nil, fmt.Errorf("operator @%s is not supported", name)
+	}
+	fn, err := build(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid argument of operator @%s: %v", name, err)
+	}
+	op.fn = fn
+	return op, nil
+}
+
+func (op *operator) match(value string) bool {
+	return op.fn(value) != op.negate
+}
+
+var operators = map[string]func(arg string) (func(string) bool, error){
+	"rx": func(arg string) (func(string) bool, error) {
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	},
+	"pm": func(arg string) (func(string) bool, error) {
+		phrases := strings.Fields(strings.ToLower(arg))
+		if len(phrases) == 0 {
+			return nil, fmt.Errorf("no phrase")
+		}
+		return func(value string) bool {
+			value = strings.ToLower(value)
+			for _, p := range phrases {
+				if strings.Contains(value, p) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	},
+	"contains": func(arg string) (func(string) bool, error) {
+		return func(value string) bool { return strings.Contains(value, arg) }, nil
+	},
+	"containsWord": func(arg string) (func(string) bool, error) {
+		re, err := regexp.Compile(`(?:^|\W)` + regexp.QuoteMeta(arg) + `(?:\W|$)`)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	},
+	"streq": func(arg string) (func(string) bool, error) {
+		return func(value string) bool { return value == arg }, nil
+	},
+	"beginsWith": func(arg string) (func(string) bool, error) {
+		return func(value string) bool { return strings.HasPrefix(value, arg) }, nil
+	},
+	"endsWith": func(arg string) (func(string) bool, error) {
+		return func(value string) bool { return strings.HasSuffix(value, arg) }, nil
+	},
+	"within": func(arg string) (func(string) bool, error) {
+		return func(value string) bool { return value != "" && strings.Contains(arg, value) }, nil
+	},
+	"eq": numeric(func(v, n int) bool { return v == n }),
+	"ge": numeric(func(v, n int) bool { return v >= n }),
+	"gt": numeric(func(v, n int) bool { return v > n }),
+	"le": numeric(func(v, n int) bool { return v <= n }),
+	"lt": numeric(func(v, n int) bool { return v < n }),
+	"ipMatch": func(arg string) (func(string) bool, error) {
+		var nets []*net.IPNet
+		for _, s := range strings.Split(arg, ",") {
+			s = strings.TrimSpace(s)
+			if !strings.Contains(s, "/") {
+				if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+					s += "/32"
+				} else {
+					s += "/128"
+				}
+			}
+			_, n, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, err
+			}
+			nets = append(nets, n)
+		}
+		return func(value string) bool {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return false
+			}
+			for _, n := range nets {
+				if n.Contains(ip) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	},
+	"validateByteRange": func(arg string) (func(string) bool, error) {
+		var allowed [256]bool
+		for _, s := range strings.Split(arg, ",") {
+			s = strings.TrimSpace(s)
+			from, to := s, s
+			if i := strings.IndexByte(s, '-'); i > 0 {
+				from, to = s[:i], s[i+1:]
+			}
+			f, err1 := strconv.Atoi(from)
+			t, err2 := strconv.Atoi(to)
+			if err1 != nil || err2 != nil || f < 0 || t > 255 || f > t {
+				return nil, fmt.Errorf("invalid byte range %s", s)
+			}
+			for b := f; b <= t; b++ {
+				allowed[b] = true
+			}
+		}
+		return func(value string) bool {
+			for i := 0; i < len(value); i++ {
+				if !allowed[value[i]] {
+					return true
+				}
+			}
+			return false
+		}, nil
+	},
+	"unconditionalMatch": func(arg string) (func(string) bool, error) {
+		return func(string) bool { return true }, nil
+	},
+	"noMatch": func(arg string) (func(string) bool, error) {
+		return func(string) bool { return false }, nil
+	},
+}
+
+// numeric builds the operators comparing numbers, values which are not
+// numbers are regarded as 0 like ModSecurity.
+func numeric(cmp func(v, n int) bool) func(arg string) (func(string) bool, error) {
+	return func(arg string) (func(string) bool, error) {
+		n, err := strconv.Atoi(strings.TrimSpace(arg))
+		if err != nil {
+			return nil, err
+		}
+		return func(value string) bool {
+			v, _ := strconv.Atoi(strings.TrimSpace(value))
+			return cmp(v, n)
+		}, nil
+	}
+}
@@ -0,0 +1,352 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package waf
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type (
+	// parser parses the ModSecurity directives of the rule sources in
+	// order, the removals and updates of the rules apply to the rules
+	// defined before them, like ModSecurity.
+	parser struct {
+		engine    Engine
+		engineSet bool
+		rules     []*Rule
+		ids       map[int]bool
+		// chained is the last rule of the chain waiting for the
+		// next rule.
+		chained *Rule
+	}
+
+	idRange struct {
+		from, to int
+	}
+)
+
+func newParser() *parser {
+	return &parser{ids: map[int]bool{}}
+}
+
+// parse parses the directives in text, source names the text in errors.
+func (p *parser) parse(source, text string) error {
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(lines[i])
+		for strings.HasSuffix(line, "\\") && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + strings.TrimSpace(lines[i])
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		args, err := splitArgs(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", source, lineNo, err)
+		}
+		if err = p.directive(args); err != nil {
+			return fmt.Errorf("%s:%d: %v", source, lineNo, err)
+		}
+	}
+
+	if p.chained != nil {
+		return fmt.Errorf("%s: rule %d: chain without the next rule", source, p.chained.ID)
+	}
+	return nil
+}
+
+func (p *parser) directive(args []string) error {
+	name, args := args[0], args[1:]
+	switch strings.ToLower(name) {
+	case "secrule":
+		return p.secRule(args)
+	case "secruleengine":
+		if len(args) != 1 {
+			return fmt.Errorf("SecRuleEngine requires 1 argument")
+		}
+		switch strings.ToLower(args[0]) {
+		case "on":
+			p.engine = EngineOn
+		case "detectiononly":
+			p.engine = EngineDetectionOnly
+		case "off":
+			p.engine = EngineOff
+		default:
+			return fmt.Errorf("invalid SecRuleEngine %s", args[0])
+		}
+		p.engineSet = true
+	case "secruleremovebyid":
+		ranges, err := parseIDRanges(args)
+		if err != nil {
+			return err
+		}
+		p.removeRules(func(r *Rule) bool { return r.inRanges(ranges) })
+	case "secruleremovebytag":
+		if len(args) != 1 {
+			return fmt.Errorf("SecRuleRemoveByTag requires 1 argument")
+		}
+		re, err := regexp.Compile(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid tag pattern: %v", err)
+		}
+		p.removeRules(func(r *Rule) bool { return r.hasTag(re) })
+	case "secruleupdatetargetbyid":
+		if len(args) != 2 {
+			return fmt.Errorf("SecRuleUpdateTargetById requires 2 arguments")
+		}
+		ranges, err := parseIDRanges(args[:1])
+		if err != nil {
+			return err
+		}
+		targets, err := parseTargets(args[1])
+		if err != nil {
+			return err
+		}
+		for _, r := range p.rules {
+			if r.inRanges(ranges) {
+				r.addTargets(targets)
+			}
+		}
+	case "secmarker", "seccomponentsignature":
+		// NOTE: Markers are only used by skipAfter, which is not supported.
+	default:
+		return fmt.Errorf("directive %s is not supported", name)
+	}
+	return nil
+}
+
+func (p *parser) removeRules(remove func(r *Rule) bool) {
+	rules := p.rules[:0]
+	for _, r := range p.rules {
+		if remove(r) {
+			delete(p.ids, r.ID)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	p.rules = rules
+}
+
+func (p *parser) secRule(args []string) error {
+	if len(args) != 2 && len(args) != 3 {
+		return fmt.Errorf("SecRule requires 2 or 3 arguments")
+	}
+
+	r := &Rule{Phase: 2}
+	var err error
+	if r.targets, err = parseTargets(args[0]); err != nil {
+		return err
+	}
+	if r.op, err = newOperator(args[1]); err != nil {
+		return err
+	}
+	actions := ""
+	if len(args) == 3 {
+		actions = args[2]
+	}
+
+	head := p.chained
+	if err = r.parseActions(actions, head != nil); err != nil {
+		return err
+	}
+
+	if head != nil {
+		head.last().chain = r
+		if !r.chainNext {
+			p.chained = nil
+		}
+		return nil
+	}
+
+	if r.ID == 0 {
+		return fmt.Errorf("rule id is required")
+	}
+	if p.ids[r.ID] {
+		return fmt.Errorf("duplicate rule id %d", r.ID)
+	}
+	p.ids[r.ID] = true
+	p.rules = append(p.rules, r)
+	if r.chainNext {
+		p.chained = r
+	}
+	return nil
+}
+
+// parseActions parses the actions of a rule, a chained rule can't have
+// the actions of the chain head.
+func (r *Rule) parseActions(text string, chained bool) error {
+	for _, action := range splitActions(text) {
+		name, value := action, ""
+		if i := strings.IndexByte(action, ':'); i >= 0 {
+			name, value = action[:i], strings.Trim(action[i+1:], "'")
+		}
+		name = strings.TrimSpace(name)
+
+		if chained {
+			switch name {
+			case "t", "chain", "capture", "log", "nolog", "auditlog", "noauditlog":
+			default:
+				return fmt.Errorf("action %s is not allowed in chained rules", name)
+			}
+		}
+
+		var err error
+		switch name {
+		case "id":
+			r.ID, err = strconv.Atoi(value)
+			if err != nil || r.ID <= 0 {
+				return fmt.Errorf("invalid id %s", value)
+			}
+		case "phase":
+			switch value {
+			case "1":
+				r.Phase = 1
+			case "2", "request":
+				r.Phase = 2
+			default:
+				return fmt.Errorf("phase %s is not supported, only request phases 1 and 2 are", value)
+			}
+		case "msg":
+			r.Msg = value
+		case "tag":
+			r.Tags = append(r.Tags, value)
+		case "severity":
+			r.Severity = value
+		case "status":
+			r.Status, err = strconv.Atoi(value)
+			if err != nil || r.Status < 100 || r.Status > 599 {
+				return fmt.Errorf("invalid status %s", value)
+			}
+		case "deny", "block", "drop":
+			r.Deny = true
+		case "pass":
+			r.Deny = false
+		case "chain":
+			r.chainNext = true
+		case "t":
+			if value == "none" {
+				r.transforms = nil
+				continue
+			}
+			t, ok := transforms[value]
+			if !ok {
+				return fmt.Errorf("transformation %s is not supported", value)
+			}
+			r.transforms = append(r.transforms, t)
+		case "log", "nolog", "auditlog", "noauditlog", "capture",
+			"ver", "rev", "maturity", "accuracy", "logdata":
+			// NOTE: Hits are always counted and logged by the filter.
+		default:
+			return fmt.Errorf("action %s is not supported", name)
+		}
+	}
+	return nil
+}
+
+// splitArgs splits a directive into arguments separated by spaces, an
+// argument could be double quoted, in which \" is a quote.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+
+		if line[i] != '"' {
+			j := strings.IndexAny(line[i:], " \t")
+			if j < 0 {
+				j = len(line) - i
+			}
+			args = append(args, line[i:i+j])
+			i += j
+			continue
+		}
+
+		var sb strings.Builder
+		i++
+		for ; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' && i+1 < len(line) && line[i+1] == '"' {
+				i++
+			}
+			sb.WriteByte(line[i])
+		}
+		if i == len(line) {
+			return nil, fmt.Errorf("unterminated quoted argument")
+		}
+		args = append(args, sb.String())
+		i++
+	}
+	return args, nil
+}
+
+// splitActions splits the actions by commas out of single quotes.
+func splitActions(text string) []string {
+	var actions []string
+	quoted, start := false, 0
+	for i := 0; i <= len(text); i++ {
+		if i < len(text) && text[i] == '\'' {
+			quoted = !quoted
+		}
+		if i == len(text) || (text[i] == ',' && !quoted) {
+			if action := strings.TrimSpace(text[start:i]); action != "" {
+				actions = append(actions, action)
+			}
+			start = i + 1
+		}
+	}
+	return actions
+}
+
+// parseIDRanges parses the rule ids and the id ranges like 942000-942999.
+func parseIDRanges(args []string) ([]idRange, error) {
+	var ranges []idRange
+	for _, arg := range args {
+		for _, s := range strings.Fields(strings.ReplaceAll(arg, ",", " ")) {
+			r, err := parseIDRange(s)
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, r)
+		}
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no rule id")
+	}
+	return ranges, nil
+}
+
+func parseIDRange(s string) (idRange, error) {
+	from, to := s, s
+	if i := strings.IndexByte(s, '-'); i > 0 {
+		from, to = s[:i], s[i+1:]
+	}
+	r := idRange{}
+	var err1, err2 error
+	r.from, err1 = strconv.Atoi(from)
+	r.to, err2 = strconv.Atoi(to)
+	if err1 != nil || err2 != nil || r.from <= 0 || r.from > r.to {
+		return r, fmt.Errorf("invalid rule id %s", s)
+	}
+	return r, nil
+}
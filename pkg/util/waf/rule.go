@@ -0,0 +1,238 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package waf
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type (
+	// Rule is a SecRule.
+	Rule struct {
+		ID       int
+		Phase    int
+		Msg      string
+		Tags     []string
+		Severity string
+		// Deny is true if the rule blocks the request by deny, block
+		// or drop, the rule only logs its hits by pass.
+		Deny bool
+		// Status is the status code of the blocked requests, the one
+		// of the WAF is used if it's zero.
+		Status int
+
+		targets    []*target
+		op         *operator
+		transforms []transform
+		chainNext  bool
+		chain      *Rule
+	}
+
+	// target is a variable of a rule like ARGS:id, or an exclusion of
+	// variables like !ARGS:password.
+	target struct {
+		collection string
+		key        string
+		keyRe      *regexp.Regexp
+		count      bool
+		exclude    bool
+	}
+)
+
+func (r *Rule) last() *Rule {
+	for r.chain != nil {
+		r = r.chain
+	}
+	return r
+}
+
+func (r *Rule) inRanges(ranges []idRange) bool {
+	for _, ir := range ranges {
+		if r.ID >= ir.from && r.ID <= ir.to {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) hasTag(re *regexp.Regexp) bool {
+	for _, tag := range r.Tags {
+		if re.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// addTargets adds the targets to the rule and its chained rules, the
+// exclusions only apply to the variables of the same collection.
+func (r *Rule) addTargets(targets []*target) {
+	for ; r != nil; r = r.chain {
+		for _, t := range targets {
+			if t.exclude {
+				r.targets = append(r.targets, t)
+				continue
+			}
+			if !r.hasTarget(t) {
+				r.targets = append(r.targets, t)
+			}
+		}
+	}
+}
+
+func (r *Rule) hasTarget(t *target) bool {
+	for _, rt := range r.targets {
+		if rt.String() == t.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate returns the first variable matched by the rule and its
+// chained rules, excluded are the variables excluded for the request.
+func (r *Rule) evaluate(tx *Transaction, excluded []*target) *variable {
+	v := r.match(tx, excluded)
+	if v == nil {
+		return nil
+	}
+	if r.chain != nil && r.chain.evaluate(tx, excluded) == nil {
+		return nil
+	}
+	return v
+}
+
+func (r *Rule) match(tx *Transaction, excluded []*target) *variable {
+	for _, t := range r.targets {
+		if t.exclude {
+			continue
+		}
+
+		for _, v := range tx.variables(t) {
+			if r.isExcluded(v, excluded) {
+				continue
+			}
+			value := v.value
+			for _, transform := range r.transforms {
+				value = transform(value)
+			}
+			if r.op.match(value) {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Rule) isExcluded(v *variable, excluded []*target) bool {
+	for _, t := range r.targets {
+		if t.exclude && t.excludes(v) {
+			return true
+		}
+	}
+	for _, t := range excluded {
+		if t.excludes(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTargets parses the variables of a rule separated by |.
+func parseTargets(text string) ([]*target, error) {
+	var targets []*target
+	for _, s := range strings.Split(text, "|") {
+		t, err := parseTarget(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+func parseTarget(s string) (*target, error) {
+	t := &target{}
+	if strings.HasPrefix(s, "!") {
+		t.exclude, s = true, s[1:]
+	} else if strings.HasPrefix(s, "&") {
+		t.count, s = true, s[1:]
+	}
+
+	name := s
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		name, t.key = s[:i], strings.Trim(s[i+1:], "'")
+		if t.key == "" {
+			return nil, fmt.Errorf("empty key of variable %s", name)
+		}
+	}
+	t.collection = strings.ToUpper(name)
+
+	c, ok := collections[t.collection]
+	if !ok {
+		return nil, fmt.Errorf("variable %s is not supported", name)
+	}
+	if t.key != "" && !c.keyed {
+		return nil, fmt.Errorf("variable %s has no keys", name)
+	}
+	if t.exclude && t.key == "" && !c.keyed {
+		return nil, fmt.Errorf("variable %s could not be excluded", name)
+	}
+
+	if len(t.key) > 1 && t.key[0] == '/' && t.key[len(t.key)-1] == '/' {
+		re, err := regexp.Compile("(?i)" + t.key[1:len(t.key)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid key of variable %s: %v", name, err)
+		}
+		t.keyRe = re
+	}
+
+	return t, nil
+}
+
+// excludes returns whether the variable is excluded by the target.
+func (t *target) excludes(v *variable) bool {
+	if t.collection != v.collection && !(t.collection == "ARGS" && isArgs(v.collection)) {
+		return false
+	}
+	return t.matchKey(v.key)
+}
+
+func (t *target) matchKey(key string) bool {
+	if t.key == "" {
+		return true
+	}
+	if t.keyRe != nil {
+		return t.keyRe.MatchString(key)
+	}
+	return strings.EqualFold(t.key, key)
+}
+
+func (t *target) String() string {
+	s := t.collection
+	if t.key != "" {
+		s += ":" + t.key
+	}
+	if t.exclude {
+		s = "!" + s
+	} else if t.count {
+		s = "&" + s
+	}
+	return s
+}
@@ -0,0 +1,325 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package waf
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Request is the inspected part of a request.
+	Request struct {
+		RemoteAddr string
+		Method     string
+		// URI is the escaped path and the query.
+		URI    string
+		Path   string
+		Query  string
+		Proto  string
+		Header http.Header
+		// Body is the inspected part of the body, it's only inspected
+		// in phase 2.
+		Body []byte
+	}
+
+	// Transaction is the inspection of a request, it parses the
+	// variables lazily and only once.
+	Transaction struct {
+		req   *Request
+		phase int
+
+		argsGet  []*variable
+		argsPost []*variable
+		headers  []*variable
+		cookies  []*variable
+		parsed   map[string]bool
+	}
+
+	variable struct {
+		collection string
+		key        string
+		value      string
+	}
+
+	collection struct {
+		keyed bool
+		// body is true if the collection is only available in phase 2.
+		body      bool
+		variables func(tx *Transaction, name string) []*variable
+	}
+)
+
+var collections = map[string]*collection{
+	"ARGS": {keyed: true, variables: func(tx *Transaction, name string) []*variable {
+		return relabel(name, tx.getArgs(), tx.postArgs())
+	}},
+	"ARGS_GET": {keyed: true, variables: func(tx *Transaction, name string) []*variable {
+		return relabel(name, tx.getArgs())
+	}},
+	"ARGS_POST": {keyed: true, body: true, variables: func(tx *Transaction, name string) []*variable {
+		return relabel(name, tx.postArgs())
+	}},
+	"ARGS_NAMES": {keyed: true, variables: func(tx *Transaction, name string) []*variable {
+		return names(name, tx.getArgs(), tx.postArgs())
+	}},
+	"ARGS_GET_NAMES": {keyed: true, variables: func(tx *Transaction, name string) []*variable {
+		return names(name, tx.getArgs())
+	}},
+	"ARGS_POST_NAMES": {keyed: true, body: true, variables: func(tx *Transaction, name string) []*variable {
+		return names(name, tx.postArgs())
+	}},
+	"REQUEST_HEADERS": {keyed: true, variables: func(tx *Transaction, name string) []*variable {
+		return relabel(name, tx.requestHeaders())
+	}},
+	"REQUEST_HEADERS_NAMES": {keyed: true, variables: func(tx *Transaction, name string) []*variable {
+		return names(name, tx.requestHeaders())
+	}},
+	"REQUEST_COOKIES": {keyed: true, variables: func(tx *Transaction, name string) []*variable {
+		return relabel(name, tx.requestCookies())
+	}},
+	"REQUEST_COOKIES_NAMES": {keyed: true, variables: func(tx *Transaction, name string) []*variable {
+		return names(name, tx.requestCookies())
+	}},
+	"REQUEST_URI":      scalar(func(r *Request) string { return r.URI }),
+	"REQUEST_FILENAME": scalar(func(r *Request) string { return r.Path }),
+	"REQUEST_BASENAME": scalar(func(r *Request) string { return r.Path[strings.LastIndexByte(r.Path, '/')+1:] }),
+	"REQUEST_METHOD":   scalar(func(r *Request) string { return r.Method }),
+	"REQUEST_PROTOCOL": scalar(func(r *Request) string { return r.Proto }),
+	"REQUEST_LINE": scalar(func(r *Request) string {
+		return r.Method + " " + r.URI + " " + r.Proto
+	}),
+	"QUERY_STRING": scalar(func(r *Request) string { return r.Query }),
+	"REMOTE_ADDR":  scalar(func(r *Request) string { return r.RemoteAddr }),
+	"REQUEST_BODY": {body: true, variables: func(tx *Transaction, name string) []*variable {
+		return []*variable{{collection: name, value: string(tx.req.Body)}}
+	}},
+}
+
+func scalar(fn func(r *Request) string) *collection {
+	return &collection{variables: func(tx *Transaction, name string) []*variable {
+		return []*variable{{collection: name, value: fn(tx.req)}}
+	}}
+}
+
+func relabel(name string, lists ...[]*variable) []*variable {
+	var result []*variable
+	for _, list := range lists {
+		for _, v := range list {
+			result = append(result, &variable{collection: name, key: v.key, value: v.value})
+		}
+	}
+	return result
+}
+
+func names(name string, lists ...[]*variable) []*variable {
+	var result []*variable
+	for _, list := range lists {
+		for _, v := range list {
+			result = append(result, &variable{collection: name, key: v.key, value: v.key})
+		}
+	}
+	return result
+}
+
+func isArgs(collection string) bool {
+	return collection == "ARGS_GET" || collection == "ARGS_POST"
+}
+
+// NewTransaction creates a Transaction to inspect req.
+func NewTransaction(req *Request) *Transaction {
+	return &Transaction{req: req, parsed: map[string]bool{}}
+}
+
+// variables returns the variables of the target in the current phase.
+func (tx *Transaction) variables(t *target) []*variable {
+	c := collections[t.collection]
+	if c.body && tx.phase < 2 {
+		if t.count {
+			return []*variable{{collection: "&" + t.collection, value: "0"}}
+		}
+		return nil
+	}
+
+	all := c.variables(tx, t.collection)
+	if t.key != "" {
+		matched := all[:0]
+		for _, v := range all {
+			if t.matchKey(v.key) {
+				matched = append(matched, v)
+			}
+		}
+		all = matched
+	}
+
+	if t.count {
+		return []*variable{{collection: "&" + t.collection, value: strconv.Itoa(len(all))}}
+	}
+	return all
+}
+
+func (tx *Transaction) getArgs() []*variable {
+	if !tx.parsed["ARGS_GET"] {
+		tx.parsed["ARGS_GET"] = true
+		tx.argsGet = parseForm("ARGS_GET", tx.req.Query)
+	}
+	return tx.argsGet
+}
+
+func (tx *Transaction) postArgs() []*variable {
+	if tx.phase < 2 {
+		return nil
+	}
+	if !tx.parsed["ARGS_POST"] {
+		tx.parsed["ARGS_POST"] = true
+		tx.argsPost = parseBody(tx.req.Header.Get("Content-Type"), tx.req.Body)
+	}
+	return tx.argsPost
+}
+
+func (tx *Transaction) requestHeaders() []*variable {
+	if !tx.parsed["REQUEST_HEADERS"] {
+		tx.parsed["REQUEST_HEADERS"] = true
+		keys := make([]string, 0, len(tx.req.Header))
+		for k := range tx.req.Header {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, v := range tx.req.Header[k] {
+				tx.headers = append(tx.headers, &variable{collection: "REQUEST_HEADERS", key: k, value: v})
+			}
+		}
+	}
+	return tx.headers
+}
+
+func (tx *Transaction) requestCookies() []*variable {
+	if !tx.parsed["REQUEST_COOKIES"] {
+		tx.parsed["REQUEST_COOKIES"] = true
+		r := &http.Request{Header: http.Header{"Cookie": tx.req.Header.Values("Cookie")}}
+		for _, c := range r.Cookies() {
+			tx.cookies = append(tx.cookies, &variable{collection: "REQUEST_COOKIES", key: c.Name, value: c.Value})
+		}
+	}
+	return tx.cookies
+}
+
+// parseForm parses the url encoded form leniently, the undecodable
+// parts are kept as they are.
+func parseForm(collection, form string) []*variable {
+	var vars []*variable
+	for _, pair := range strings.Split(form, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value := pair, ""
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			key, value = pair[:i], pair[i+1:]
+		}
+		vars = append(vars, &variable{
+			collection: collection,
+			key:        urlDecode(key),
+			value:      urlDecode(value),
+		})
+	}
+	return vars
+}
+
+// parseBody parses the form, JSON and multipart bodies into arguments,
+// JSON fields are named like json.user.name and json.items.0.
+func parseBody(contentType string, body []byte) []*variable {
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		return parseForm("ARGS_POST", string(body))
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var doc interface{}
+		if json.Unmarshal(body, &doc) != nil {
+			return nil
+		}
+		var vars []*variable
+		flattenJSON("json", doc, &vars)
+		return vars
+	case mediaType == "multipart/form-data":
+		return parseMultipart(params["boundary"], body)
+	}
+	return nil
+}
+
+func flattenJSON(key string, doc interface{}, vars *[]*variable) {
+	switch doc := doc.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(doc))
+		for k := range doc {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			flattenJSON(key+"."+k, doc[k], vars)
+		}
+	case []interface{}:
+		for i, v := range doc {
+			flattenJSON(key+"."+strconv.Itoa(i), v, vars)
+		}
+	case string:
+		*vars = append(*vars, &variable{collection: "ARGS_POST", key: key, value: doc})
+	case nil:
+		*vars = append(*vars, &variable{collection: "ARGS_POST", key: key})
+	default:
+		data, _ := json.Marshal(doc)
+		*vars = append(*vars, &variable{collection: "ARGS_POST", key: key, value: string(data)})
+	}
+}
+
+// parseMultipart parses the form fields of a multipart body, files are
+// not inspected.
+func parseMultipart(boundary string, body []byte) []*variable {
+	if boundary == "" {
+		return nil
+	}
+	var vars []*variable
+	r := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := r.NextPart()
+		if err != nil {
+			return vars
+		}
+		if part.FileName() != "" {
+			continue
+		}
+		value, err := ioutil.ReadAll(part)
+		if err != nil {
+			// NOTE: The body may be truncated by the inspection limit.
+			return vars
+		}
+		vars = append(vars, &variable{collection: "ARGS_POST", key: part.FormName(), value: string(value)})
+	}
+}
+
+// SetBody sets the inspected part of the body before phase 2.
+func (tx *Transaction) SetBody(body []byte) {
+	tx.req.Body = body
+	delete(tx.parsed, "ARGS_POST")
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package waf
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"html"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// transform is a transformation function of the values before matching.
+type transform func(string) string
+
+var transforms = map[string]transform{
+	"lowercase":          strings.ToLower,
+	"uppercase":          strings.ToUpper,
+	"trim":               strings.TrimSpace,
+	"trimLeft":           func(s string) string { return strings.TrimLeftFunc(s, unicode.IsSpace) },
+	"trimRight":          func(s string) string { return strings.TrimRightFunc(s, unicode.IsSpace) },
+	"urlDecode":          urlDecode,
+	"urlDecodeUni":       urlDecodeUni,
+	"htmlEntityDecode":   html.UnescapeString,
+	"compressWhitespace": compressWhitespace,
+	"removeWhitespace":   func(s string) string { return strings.Join(strings.Fields(s), "") },
+	"removeNulls":        func(s string) string { return strings.ReplaceAll(s, "\x00", "") },
+	"replaceNulls":       func(s string) string { return strings.ReplaceAll(s, "\x00", " ") },
+	"replaceComments":    replaceComments,
+	"cmdLine":            cmdLine,
+	"normalizePath":      normalizePath,
+	"normalisePath":      normalizePath,
+	"normalizePathWin":   func(s string) string { return normalizePath(strings.ReplaceAll(s, "\\", "/")) },
+	"normalisePathWin":   func(s string) string { return normalizePath(strings.ReplaceAll(s, "\\", "/")) },
+	"base64Decode":       base64Decode,
+	"hexDecode":          hexDecode,
+	"length":             func(s string) string { return strconv.Itoa(len(s)) },
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// urlDecode decodes %XX and +, invalid escapes are kept as they are.
+func urlDecode(s string) string {
+	return decodeURL(s, false)
+}
+
+// urlDecodeUni decodes %uXXXX in addition to urlDecode.
+func urlDecodeUni(s string) string {
+	return decodeURL(s, true)
+}
+
+func decodeURL(s string, unicode bool) string {
+	if !strings.ContainsAny(s, "%+") {
+		return s
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '+' {
+			sb.WriteByte(' ')
+			continue
+		}
+		if c != '%' {
+			sb.WriteByte(c)
+			continue
+		}
+
+		if unicode && i+5 < len(s) && (s[i+1] == 'u' || s[i+1] == 'U') {
+			if r, err := strconv.ParseUint(s[i+2:i+6], 16, 32); err == nil {
+				sb.WriteRune(rune(r))
+				i += 5
+				continue
+			}
+		}
+		if i+2 < len(s) {
+			h, ok1 := unhex(s[i+1])
+			l, ok2 := unhex(s[i+2])
+			if ok1 && ok2 {
+				sb.WriteByte(h<<4 | l)
+				i += 2
+				continue
+			}
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+func compressWhitespace(s string) string {
+	var sb strings.Builder
+	space := false
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space {
+			sb.WriteByte(' ')
+			space = false
+		}
+		sb.WriteRune(r)
+	}
+	if space {
+		sb.WriteByte(' ')
+	}
+	return sb.String()
+}
+
+// replaceComments replaces C style comments with a space, an unterminated
+// comment is replaced to the end.
+func replaceComments(s string) string {
+	var sb strings.Builder
+	for {
+		i := strings.Index(s, "/*")
+		if i < 0 {
+			sb.WriteString(s)
+			return sb.String()
+		}
+		sb.WriteString(s[:i])
+		sb.WriteByte(' ')
+		j := strings.Index(s[i+2:], "*/")
+		if j < 0 {
+			return sb.String()
+		}
+		s = s[i+2+j+2:]
+	}
+}
+
+// cmdLine normalizes command lines like ModSecurity: it deletes \ " ' ^
+// and the spaces before / and (, replaces , and ; with a space,
+// compresses the spaces and lowers the case.
+func cmdLine(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\', '"', '\'', '^':
+			continue
+		case ',', ';':
+			r = ' '
+		case '/', '(':
+			trimmed := strings.TrimRight(sb.String(), " \t\r\n")
+			sb.Reset()
+			sb.WriteString(trimmed)
+		}
+		sb.WriteRune(r)
+	}
+	return strings.ToLower(compressWhitespace(sb.String()))
+}
+
+// normalizePath removes the repeated slashes, the self references and
+// the back references, the trailing slash is kept.
+func normalizePath(s string) string {
+	if s == "" {
+		return s
+	}
+	cleaned := path.Clean(s)
+	if strings.HasSuffix(s, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// base64Decode decodes the standard and the URL encodings with or
+// without paddings, the invalid input is kept as it is.
+func base64Decode(s string) string {
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding, base64.RawStdEncoding,
+		base64.URLEncoding, base64.RawURLEncoding,
+	} {
+		if data, err := enc.DecodeString(s); err == nil {
+			return string(data)
+		}
+	}
+	return s
+}
+
+func hexDecode(s string) string {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return s
+	}
+	return string(data)
+}
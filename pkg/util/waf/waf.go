@@ -0,0 +1,270 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+// Package waf is a web application firewall inspecting requests by the
+// ModSecurity compatible rules, it supports the SecRule directives with
+// the common variables, operators, transformations and actions of the
+// request phases, like the ones of the OWASP Core Rule Set, but not the
+// anomaly scoring of it.
+package waf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/megaease/easegress/pkg/util/urlrule"
+)
+
+// Engine is the mode of the rule engine set by SecRuleEngine.
+type Engine int
+
+const (
+	// EngineOn blocks the requests matched by the deny rules.
+	EngineOn Engine = iota
+	// EngineDetectionOnly only logs the matched requests.
+	EngineDetectionOnly
+	// EngineOff inspects nothing.
+	EngineOff
+)
+
+type (
+	// Spec describes the rules of a WAF.
+	Spec struct {
+		// Baseline enables the built-in rules against the common SQL
+		// injection, XSS, command injection and path traversal attacks.
+		Baseline bool `yaml:"baseline" jsonschema:"omitempty"`
+		// RuleFiles are the files of ModSecurity directives, loaded
+		// after the baseline rules.
+		RuleFiles []string `yaml:"ruleFiles" jsonschema:"omitempty"`
+		// Rules are the ModSecurity directives loaded at last.
+		Rules string `yaml:"rules" jsonschema:"omitempty"`
+		// Exclusions exclude rules or variables of rules, e.g. to fix
+		// the false positives of some paths.
+		Exclusions []*Exclusion `yaml:"exclusions" jsonschema:"omitempty"`
+	}
+
+	// Exclusion excludes the rules, or the variables of the rules if
+	// variables is set, for the requests of the path.
+	Exclusion struct {
+		// RuleIDs are the ids or the id ranges like 942000-942999,
+		// the exclusion applies to all rules if both ruleIds and tags
+		// are empty.
+		RuleIDs []string `yaml:"ruleIds" jsonschema:"omitempty"`
+		// Tags are the tags of the rules.
+		Tags []string `yaml:"tags" jsonschema:"omitempty"`
+		// Variables are the variables like ARGS:password or
+		// REQUEST_COOKIES:/^session/ excluded from the rules.
+		Variables []string `yaml:"variables" jsonschema:"omitempty"`
+		// Path is the path of the requests, all requests if it's empty.
+		Path *urlrule.StringMatch `yaml:"path" jsonschema:"omitempty"`
+	}
+
+	// WAF inspects requests by the rules.
+	WAF struct {
+		engine     Engine
+		rules      []*Rule
+		exclusions []*exclusion
+	}
+
+	exclusion struct {
+		ranges  []idRange
+		tags    map[string]bool
+		targets []*target
+		path    *urlrule.StringMatch
+	}
+
+	// Match is a rule matched by a request.
+	Match struct {
+		RuleID   int
+		Msg      string
+		Tags     []string
+		Severity string
+		Deny     bool
+		Status   int
+		// Variable is the matched variable like ARGS:id.
+		Variable string
+		// Value is the value of the variable, truncated to 128 bytes.
+		Value string
+	}
+)
+
+const maxMatchValueLen = 128
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if !spec.Baseline && len(spec.RuleFiles) == 0 && spec.Rules == "" {
+		return fmt.Errorf("no rules")
+	}
+	_, err := New(&spec)
+	return err
+}
+
+// Validate validates Exclusion.
+func (e Exclusion) Validate() error {
+	_, err := newExclusion(&e)
+	return err
+}
+
+// New creates a WAF, loading the rules of the Spec.
+func New(spec *Spec) (*WAF, error) {
+	p := newParser()
+	if spec.Baseline {
+		if err := p.parse("baseline", baselineRules); err != nil {
+			return nil, err
+		}
+	}
+	for _, file := range spec.RuleFiles {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err = p.parse(file, string(data)); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.parse("rules", spec.Rules); err != nil {
+		return nil, err
+	}
+
+	w := &WAF{engine: p.engine, rules: p.rules}
+	for _, e := range spec.Exclusions {
+		ex, err := newExclusion(e)
+		if err != nil {
+			return nil, err
+		}
+		w.exclusions = append(w.exclusions, ex)
+	}
+	return w, nil
+}
+
+func newExclusion(e *Exclusion) (*exclusion, error) {
+	ex := &exclusion{tags: map[string]bool{}, path: e.Path}
+	if len(e.RuleIDs) > 0 {
+		ranges, err := parseIDRanges(e.RuleIDs)
+		if err != nil {
+			return nil, err
+		}
+		ex.ranges = ranges
+	}
+	for _, tag := range e.Tags {
+		ex.tags[tag] = true
+	}
+	for _, v := range e.Variables {
+		t, err := parseTarget(v)
+		if err != nil {
+			return nil, err
+		}
+		if t.exclude || t.count {
+			return nil, fmt.Errorf("invalid variable %s", v)
+		}
+		t.exclude = true
+		ex.targets = append(ex.targets, t)
+	}
+	if ex.path != nil {
+		if err := ex.path.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid path: %v", err)
+		}
+		if _, err := regexp.Compile(ex.path.RegEx); err != nil {
+			return nil, fmt.Errorf("invalid path: %v", err)
+		}
+		ex.path.Init()
+	}
+	return ex, nil
+}
+
+func (ex *exclusion) appliesTo(r *Rule, tx *Transaction) bool {
+	if ex.path != nil && !ex.path.Match(tx.req.Path) {
+		return false
+	}
+	if len(ex.ranges) == 0 && len(ex.tags) == 0 {
+		return true
+	}
+	if r.inRanges(ex.ranges) {
+		return true
+	}
+	for _, tag := range r.Tags {
+		if ex.tags[tag] {
+			return true
+		}
+	}
+	return false
+}
+
+// Engine returns the engine mode set by SecRuleEngine, which is
+// EngineOn by default.
+func (w *WAF) Engine() Engine {
+	return w.engine
+}
+
+// Len returns the number of the rules.
+func (w *WAF) Len() int {
+	return len(w.rules)
+}
+
+// Inspect inspects the request of the transaction by the rules of the
+// phase, and returns the matched rules. It stops at the first deny rule
+// unless detectionOnly.
+func (w *WAF) Inspect(tx *Transaction, phase int, detectionOnly bool) []*Match {
+	tx.phase = phase
+
+	var matches []*Match
+RuleLoop:
+	for _, r := range w.rules {
+		if r.Phase != phase {
+			continue
+		}
+
+		var excluded []*target
+		for _, ex := range w.exclusions {
+			if !ex.appliesTo(r, tx) {
+				continue
+			}
+			if len(ex.targets) == 0 {
+				continue RuleLoop
+			}
+			excluded = append(excluded, ex.targets...)
+		}
+
+		v := r.evaluate(tx, excluded)
+		if v == nil {
+			continue
+		}
+
+		m := &Match{
+			RuleID:   r.ID,
+			Msg:      r.Msg,
+			Tags:     r.Tags,
+			Severity: r.Severity,
+			Deny:     r.Deny,
+			Status:   r.Status,
+			Variable: v.collection,
+			Value:    v.value,
+		}
+		if v.key != "" {
+			m.Variable += ":" + v.key
+		}
+		if len(m.Value) > maxMatchValueLen {
+			m.Value = m.Value[:maxMatchValueLen]
+		}
+		matches = append(matches, m)
+
+		if m.Deny && !detectionOnly {
+			break
+		}
+	}
+	return matches
+}
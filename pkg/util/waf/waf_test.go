@@ -0,0 +1,281 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package waf
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/util/urlrule"
+)
+
+func newRequest(method, target, contentType, body string) *Request {
+	u, _ := url.Parse(target)
+	header := http.Header{"User-Agent": {"Mozilla/5.0"}}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	return &Request{
+		RemoteAddr: "192.168.1.10",
+		Method:     method,
+		URI:        u.RequestURI(),
+		Path:       u.Path,
+		Query:      u.RawQuery,
+		Proto:      "HTTP/1.1",
+		Header:     header,
+		Body:       []byte(body),
+	}
+}
+
+func inspect(w *WAF, req *Request, detectionOnly bool) []*Match {
+	tx := NewTransaction(req)
+	matches := w.Inspect(tx, 1, detectionOnly)
+	if len(matches) > 0 && matches[len(matches)-1].Deny && !detectionOnly {
+		return matches
+	}
+	return append(matches, w.Inspect(tx, 2, detectionOnly)...)
+}
+
+func TestParse(t *testing.T) {
+	valid := []string{
+		`SecRule ARGS "@rx a" "id:1"`,
+		`SecRule ARGS:id|!ARGS:password "!@pm a b" "id:1,phase:1,deny,status:406,msg:'a, b',tag:'x',t:none,t:lowercase"`,
+		"SecRule REQUEST_METHOD \"@streq POST\" \\\n  \"id:1,chain,deny\"\nSecRule &ARGS \"@gt 2\"",
+		"SecRuleEngine DetectionOnly\nSecMarker END",
+		"SecRule ARGS \"a\" \"id:1\"\nSecRule ARGS \"b\" \"id:2\"\nSecRuleRemoveById 1\nSecRuleUpdateTargetById 2 \"!ARGS:/^pass/\"",
+	}
+	for i, rules := range valid {
+		if _, err := New(&Spec{Rules: rules}); err != nil {
+			t.Errorf("case %d: unexpected error %v", i, err)
+		}
+	}
+
+	invalid := []string{
+		`SecRule ARGS "@rx a"`,
+		`SecRule ARGS "@rx (" "id:1"`,
+		`SecRule ARGS "@detectSQLi" "id:1"`,
+		`SecRule ARGS "@rx a" "id:1,phase:3"`,
+		`SecRule ARGS "@rx a" "id:1,t:sha1"`,
+		`SecRule ARGS "@rx a" "id:1,setvar:tx.score=+5"`,
+		`SecRule RESPONSE_BODY "@rx a" "id:1"`,
+		`SecRule REQUEST_URI:a "@rx a" "id:1"`,
+		`SecRule ARGS "@rx a" "id:1,chain"`,
+		"SecRule ARGS \"a\" \"id:1\"\nSecRule ARGS \"b\" \"id:1\"",
+		`SecRule ARGS "@rx a`,
+		`SecRequestBodyAccess On`,
+	}
+	for i, rules := range invalid {
+		if _, err := New(&Spec{Rules: rules}); err == nil {
+			t.Errorf("case %d: should fail", i)
+		}
+	}
+
+	w, _ := New(&Spec{Rules: "SecRuleEngine DetectionOnly\nSecRule ARGS \"a\" \"id:1\"\nSecRule ARGS \"b\" \"id:2\"\nSecRuleRemoveById 1"})
+	if w.Engine() != EngineDetectionOnly || w.Len() != 1 {
+		t.Errorf("unexpected engine %v or rules %d", w.Engine(), w.Len())
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	w, err := New(&Spec{Baseline: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	attacks := []struct {
+		req  *Request
+		rule int
+	}{
+		{newRequest("GET", "/items?id=1%20UNION%20/**/SELECT%20password%20FROM%20users", "", ""), 942100},
+		{newRequest("GET", "/login?user=admin'%20or%20'1'='1", "", ""), 942110},
+		{newRequest("GET", "/login?user=admin'--", "", ""), 942120},
+		{newRequest("GET", "/items?id=1%20and%20sleep(5)", "", ""), 942130},
+		{newRequest("POST", "/items", "application/json", `{"filter": {"name": "x; DROP TABLE users"}}`), 942150},
+		{newRequest("GET", "/search?q=<script>alert(1)</script>", "", ""), 941100},
+		{newRequest("POST", "/comments", "application/x-www-form-urlencoded", "body=%3Cimg%20src%3Dx%20onerror%3Dalert(1)%3E"), 941110},
+		{newRequest("GET", "/go?to=javascript:alert(1)", "", ""), 941120},
+		{newRequest("GET", "/download?file=../../etc/passwd", "", ""), 930100},
+		{newRequest("GET", "/ping?host=127.0.0.1;cat%20/etc/hosts", "", ""), 932100},
+		{newRequest("GET", "/run?cmd=%2Fbin%2Fbash%20-i", "", ""), 932160},
+		{newRequest("GET", "/?x=%3C%3Fphp%20system('id')", "", ""), 933100},
+		{newRequest("GET", "/?x=a%00b", "", ""), 920270},
+	}
+	for _, c := range attacks {
+		matches := inspect(w, c.req, false)
+		if len(matches) == 0 {
+			t.Errorf("rule %d: %s not detected", c.rule, c.req.URI)
+			continue
+		}
+		if m := matches[len(matches)-1]; m.RuleID != c.rule || !m.Deny {
+			t.Errorf("rule %d: %s matched by %d", c.rule, c.req.URI, m.RuleID)
+		}
+	}
+
+	scanner := newRequest("GET", "/", "", "")
+	scanner.Header.Set("User-Agent", "sqlmap/1.5")
+	if matches := inspect(w, scanner, false); len(matches) != 1 || matches[0].RuleID != 913100 {
+		t.Errorf("scanner not detected in phase 1")
+	}
+
+	benign := []*Request{
+		newRequest("GET", "/search?q=select%20a%20union%20member&page=2", "", ""),
+		newRequest("GET", "/articles?title=Tom%20and%20Jerry&sort=date", "", ""),
+		newRequest("GET", "/profile?name=O'Brien", "", ""),
+		newRequest("POST", "/comments", "application/json", `{"text": "I'd like to learn about <b>HTML</b> and SQL", "rating": 5}`),
+		newRequest("POST", "/files", "application/x-www-form-urlencoded", "path=docs/readme.md&online=true"),
+	}
+	for _, req := range benign {
+		if matches := inspect(w, req, false); len(matches) != 0 {
+			t.Errorf("%s %s: false positive of rule %d on %s", req.URI, req.Body, matches[0].RuleID, matches[0].Variable)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	rules := `
+SecRule REQUEST_METHOD "@streq POST" "id:1,phase:1,deny,status:405,chain,msg:'too many args'"
+SecRule &ARGS_GET "@gt 2"
+SecRule REQUEST_HEADERS:X-Debug "!@within 0 false" "id:2,phase:1,pass,tag:'debug'"
+SecRule ARGS|!ARGS:comment "@contains evil" "id:3,deny"
+SecRule ARGS_POST:/^user\./ "@beginsWith admin" "id:4,deny,t:lowercase"
+SecRule REMOTE_ADDR "@ipMatch 10.0.0.0/8,192.168.1.10" "id:5,pass"
+`
+	w, err := New(&Spec{Rules: rules})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	req := newRequest("POST", "/?a=1&b=2&c=3", "", "")
+	matches := inspect(w, req, false)
+	if len(matches) != 1 || matches[0].RuleID != 1 || matches[0].Status != 405 {
+		t.Errorf("chained rule should match")
+	}
+	req = newRequest("POST", "/?a=1&b=2", "", "")
+	if matches = inspect(w, req, false); len(matches) != 1 || matches[0].RuleID != 5 {
+		t.Errorf("chained rule should not match")
+	}
+
+	req = newRequest("GET", "/?comment=evil", "", "")
+	req.Header.Set("X-Debug", "1")
+	matches = inspect(w, req, false)
+	if len(matches) != 2 || matches[0].RuleID != 2 || matches[0].Deny || matches[1].RuleID != 5 {
+		t.Errorf("unexpected matches %+v", matches)
+	}
+
+	req = newRequest("POST", "/?name=evil", "application/x-www-form-urlencoded", "user.name=Administrator")
+	matches = inspect(w, req, true)
+	if len(matches) != 3 || matches[0].Variable != "ARGS:name" || matches[1].Variable != "ARGS_POST:user.name" {
+		t.Errorf("unexpected matches %+v", matches)
+	}
+	matches = inspect(w, req, false)
+	if len(matches) != 1 || matches[0].RuleID != 3 {
+		t.Errorf("inspection should stop at the first deny rule")
+	}
+}
+
+func TestExclusions(t *testing.T) {
+	spec := &Spec{
+		Baseline: true,
+		Exclusions: []*Exclusion{
+			{RuleIDs: []string{"942100-942199"}, Variables: []string{"ARGS:query"}},
+			{Tags: []string{"attack-xss"}, Path: &urlrule.StringMatch{Prefix: "/cms/"}},
+			{RuleIDs: []string{"930100"}},
+		},
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	w, _ := New(spec)
+
+	if matches := inspect(w, newRequest("GET", "/?query=1%20union%20select%202", "", ""), false); len(matches) != 0 {
+		t.Errorf("variable should be excluded")
+	}
+	if matches := inspect(w, newRequest("GET", "/?q=1%20union%20select%202", "", ""), false); len(matches) == 0 {
+		t.Errorf("other variables should be inspected")
+	}
+	if matches := inspect(w, newRequest("GET", "/cms/edit?html=<script>a()</script>", "", ""), false); len(matches) != 0 {
+		t.Errorf("rules should be excluded for the path")
+	}
+	if matches := inspect(w, newRequest("GET", "/edit?html=<script>a()</script>", "", ""), false); len(matches) == 0 {
+		t.Errorf("rules should be excluded only for the path")
+	}
+	if matches := inspect(w, newRequest("GET", "/?f=../x", "", ""), false); len(matches) != 0 {
+		t.Errorf("rule should be excluded")
+	}
+
+	invalid := []*Exclusion{
+		{RuleIDs: []string{"2-1"}},
+		{Variables: []string{"UNKNOWN"}},
+		{Variables: []string{"!ARGS:a"}},
+		{Path: &urlrule.StringMatch{RegEx: "("}},
+	}
+	for i, e := range invalid {
+		if e.Validate() == nil {
+			t.Errorf("case %d: should fail", i)
+		}
+	}
+}
+
+func TestRuleFiles(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "rules.conf")
+	os.WriteFile(file, []byte("# custom rules\nSecRule REQUEST_FILENAME \"@endsWith .bak\" \\\n    \"id:100,phase:1,deny\"\n"), 0o644)
+
+	w, err := New(&Spec{RuleFiles: []string{file}, Rules: "SecRuleRemoveById 100"})
+	if err != nil || w.Len() != 0 {
+		t.Errorf("rules should be loaded in order, %v", err)
+	}
+
+	if (Spec{}).Validate() == nil {
+		t.Errorf("spec without rules should fail")
+	}
+	if (Spec{RuleFiles: []string{filepath.Join(dir, "none.conf")}}).Validate() == nil {
+		t.Errorf("missing rule file should fail")
+	}
+	os.WriteFile(file, []byte("SecRule ARGS \"@rx a\""), 0o644)
+	if err := (Spec{RuleFiles: []string{file}}).Validate(); err == nil || !strings.Contains(err.Error(), "rules.conf:1") {
+		t.Errorf("error should locate the rule, got %v", err)
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	cases := []struct {
+		name, in, out string
+	}{
+		{"urlDecode", "a%20b+c%zz%4", "a b c%zz%4"},
+		{"urlDecodeUni", "%u003cscript%3E", "<script>"},
+		{"htmlEntityDecode", "&lt;a&#62;", "<a>"},
+		{"compressWhitespace", "a \t\n b  ", "a b "},
+		{"removeWhitespace", " a b\tc ", "abc"},
+		{"replaceComments", "un/**/ion/* x", "un ion "},
+		{"cmdLine", "C^A\"T /e'tc/pa\\sswd;ls  -la", "cat/etc/passwd ls -la"},
+		{"normalizePath", "/a//b/./c/../d/", "/a/b/d/"},
+		{"normalizePathWin", "a\\..\\..\\b", "../b"},
+		{"base64Decode", "PHNjcmlwdD4", "<script>"},
+		{"hexDecode", "3c61", "<a"},
+		{"length", "abcd", "4"},
+	}
+	for _, c := range cases {
+		if got := transforms[c.name](c.in); got != c.out {
+			t.Errorf("%s(%q): expected %q, got %q", c.name, c.in, c.out, got)
+		}
+	}
+}
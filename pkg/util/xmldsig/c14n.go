@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xmldsig
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
+
+// Canonicalize canonicalizes the element by Exclusive XML
+// Canonicalization 1.0. The prefixes in inclusive are handled by the
+// inclusive canonicalization, "#default" is the default namespace. The
+// exclude element and its descendants are omitted, which is for the
+// enveloped signature transform.
+func Canonicalize(e *Element, inclusive []string, withComments bool, exclude *Element) []byte {
+	c := &canonicalizer{withComments: withComments, exclude: exclude}
+	for _, p := range inclusive {
+		if p == "#default" {
+			p = ""
+		}
+		c.inclusive = append(c.inclusive, p)
+	}
+
+	buff := &bytes.Buffer{}
+	c.element(buff, e, map[string]string{})
+	return buff.Bytes()
+}
+
+type (
+	canonicalizer struct {
+		inclusive    []string
+		withComments bool
+		exclude      *Element
+	}
+
+	attr struct {
+		space string
+		name  string
+		value string
+	}
+)
+
+func qname(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+func (c *canonicalizer) element(buff *bytes.Buffer, e *Element, rendered map[string]string) {
+	// NOTE: The namespaces visibly utilized by the element and its
+	// attributes are rendered if they are not rendered by the output
+	// ancestors with the same values.
+	utilized := map[string]bool{e.Prefix: true}
+	for _, a := range e.Attrs {
+		if a.Name.Space != "" && a.Name.Space != "xmlns" && a.Name.Space != "xml" {
+			utilized[a.Name.Space] = true
+		}
+	}
+	for _, p := range c.inclusive {
+		if _, ok := e.LookupNamespace(p); ok {
+			utilized[p] = true
+		}
+	}
+
+	next := make(map[string]string, len(rendered))
+	for k, v := range rendered {
+		next[k] = v
+	}
+
+	var prefixes []string
+	for p := range utilized {
+		space, _ := e.LookupNamespace(p)
+		if prev, ok := rendered[p]; ok && prev == space {
+			continue
+		}
+		if _, ok := rendered[p]; !ok && p == "" && space == "" {
+			continue
+		}
+		next[p] = space
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+
+	var attrs []attr
+	for _, a := range e.Attrs {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+			continue
+		}
+		space := ""
+		if a.Name.Space != "" {
+			space, _ = e.LookupNamespace(a.Name.Space)
+		}
+		attrs = append(attrs, attr{
+			space: space,
+			name:  qname(a.Name.Space, a.Name.Local),
+			value: a.Value,
+		})
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].space != attrs[j].space {
+			return attrs[i].space < attrs[j].space
+		}
+		return localName(attrs[i].name) < localName(attrs[j].name)
+	})
+
+	name := qname(e.Prefix, e.Local)
+	buff.WriteString("<" + name)
+	for _, p := range prefixes {
+		if p == "" {
+			buff.WriteString(` xmlns="`)
+		} else {
+			buff.WriteString(` xmlns:` + p + `="`)
+		}
+		buff.WriteString(escapeAttr(next[p]) + `"`)
+	}
+	for _, a := range attrs {
+		buff.WriteString(" " + a.name + `="` + escapeAttr(a.value) + `"`)
+	}
+	buff.WriteString(">")
+
+	for _, n := range e.Children {
+		switch n := n.(type) {
+		case *Element:
+			if n != c.exclude {
+				c.element(buff, n, next)
+			}
+		case Text:
+			buff.WriteString(escapeText(string(n)))
+		case Comment:
+			if c.withComments {
+				buff.WriteString("<!--" + string(n) + "-->")
+			}
+		case ProcInst:
+			buff.WriteString("<?" + n.Target)
+			if len(n.Inst) > 0 {
+				buff.WriteString(" " + string(n.Inst))
+			}
+			buff.WriteString("?>")
+		}
+	}
+
+	buff.WriteString("</" + name + ">")
+}
+
+func localName(name string) string {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+var (
+	attrEscaper = strings.NewReplacer(
+		"&", "&amp;", "<", "&lt;", `"`, "&quot;",
+		"\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;",
+	)
+	textEscaper = strings.NewReplacer(
+		"&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;",
+	)
+)
+
+func escapeAttr(s string) string {
+	return attrEscaper.Replace(s)
+}
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
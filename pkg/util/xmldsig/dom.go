@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package xmldsig verifies enveloped XML signatures, as used by SAML.
+package xmldsig
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+type (
+	// Element is an element of an XML document, names keep their raw
+	// prefixes, which are needed by the canonicalization.
+	Element struct {
+		Prefix   string
+		Local    string
+		Attrs    []xml.Attr
+		Children []Node
+		Parent   *Element
+	}
+
+	// Node is *Element, Text, Comment or ProcInst.
+	Node interface{}
+
+	// Text is character data.
+	Text string
+
+	// Comment is a comment.
+	Comment string
+
+	// ProcInst is a processing instruction.
+	ProcInst xml.ProcInst
+)
+
+// Parse parses the document and returns the root element. Documents with
+// DTDs are rejected.
+func Parse(data []byte) (*Element, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+
+	var root, current *Element
+	for {
+		t, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			if current == nil && root != nil {
+				return nil, fmt.Errorf("multiple root elements")
+			}
+			e := &Element{
+				Prefix: t.Name.Space,
+				Local:  t.Name.Local,
+				Attrs:  append([]xml.Attr(nil), t.Attr...),
+				Parent: current,
+			}
+			if current == nil {
+				root = e
+			} else {
+				current.Children = append(current.Children, e)
+			}
+			current = e
+		case xml.EndElement:
+			if current == nil || t.Name.Space != current.Prefix || t.Name.Local != current.Local {
+				return nil, fmt.Errorf("unexpected end element %s", t.Name.Local)
+			}
+			current = current.Parent
+		case xml.CharData:
+			if current != nil {
+				current.Children = append(current.Children, Text(t))
+			} else if len(bytes.TrimSpace(t)) != 0 {
+				return nil, fmt.Errorf("character data outside the root element")
+			}
+		case xml.Comment:
+			if current != nil {
+				current.Children = append(current.Children, Comment(t))
+			}
+		case xml.ProcInst:
+			if current != nil {
+				current.Children = append(current.Children, ProcInst(t.Copy()))
+			}
+		case xml.Directive:
+			return nil, fmt.Errorf("DTD is not allowed")
+		}
+	}
+
+	if root == nil || current != nil {
+		return nil, fmt.Errorf("incomplete document")
+	}
+	return root, nil
+}
+
+// LookupNamespace returns the namespace bound to the prefix in the scope
+// of the element, the empty prefix is the default namespace.
+func (e *Element) LookupNamespace(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return xmlNamespace, true
+	}
+	for el := e; el != nil; el = el.Parent {
+		for _, a := range el.Attrs {
+			if prefix == "" && a.Name.Space == "" && a.Name.Local == "xmlns" {
+				return a.Value, true
+			}
+			if prefix != "" && a.Name.Space == "xmlns" && a.Name.Local == prefix {
+				return a.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Space returns the namespace of the element.
+func (e *Element) Space() string {
+	space, _ := e.LookupNamespace(e.Prefix)
+	return space
+}
+
+// Is reports whether the element has the namespace and the local name.
+func (e *Element) Is(space, local string) bool {
+	return e.Local == local && e.Space() == space
+}
+
+// Attr returns the value of the unqualified attribute.
+func (e *Element) Attr(name string) string {
+	for _, a := range e.Attrs {
+		if a.Name.Space == "" && a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// Elements returns the child elements with the namespace and the local
+// name.
+func (e *Element) Elements(space, local string) []*Element {
+	var result []*Element
+	for _, n := range e.Children {
+		if c, ok := n.(*Element); ok && c.Is(space, local) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// Element returns the first child element with the namespace and the
+// local name, or nil.
+func (e *Element) Element(space, local string) *Element {
+	for _, n := range e.Children {
+		if c, ok := n.(*Element); ok && c.Is(space, local) {
+			return c
+		}
+	}
+	return nil
+}
+
+// Text returns the concatenated character data of the element and its
+// descendants.
+func (e *Element) Text() string {
+	var sb strings.Builder
+	var walk func(e *Element)
+	walk = func(e *Element) {
+		for _, n := range e.Children {
+			switch n := n.(type) {
+			case Text:
+				sb.WriteString(string(n))
+			case *Element:
+				walk(n)
+			}
+		}
+	}
+	walk(e)
+	return sb.String()
+}
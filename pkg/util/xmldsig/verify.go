@@ -0,0 +1,222 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xmldsig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+
+	// Register the hash functions.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// Namespaces and algorithms.
+const (
+	NamespaceDSig = "http://www.w3.org/2000/09/xmldsig#"
+
+	AlgorithmExcC14N             = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	AlgorithmExcC14NWithComments = "http://www.w3.org/2001/10/xml-exc-c14n#WithComments"
+	AlgorithmEnvelopedSignature  = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+
+	AlgorithmRSASHA256   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	AlgorithmRSASHA512   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	AlgorithmECDSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+
+	AlgorithmSHA256 = "http://www.w3.org/2001/04/xmlenc#sha256"
+	AlgorithmSHA512 = "http://www.w3.org/2001/04/xmlenc#sha512"
+)
+
+var (
+	signatureHashes = map[string]crypto.Hash{
+		AlgorithmRSASHA256:   crypto.SHA256,
+		AlgorithmRSASHA512:   crypto.SHA512,
+		AlgorithmECDSASHA256: crypto.SHA256,
+	}
+
+	digestHashes = map[string]crypto.Hash{
+		AlgorithmSHA256: crypto.SHA256,
+		AlgorithmSHA512: crypto.SHA512,
+	}
+)
+
+// Signature returns the signature element of the element, which is its
+// direct child.
+func Signature(e *Element) *Element {
+	return e.Element(NamespaceDSig, "Signature")
+}
+
+// Verify verifies the enveloped signature of the element by the
+// certificates, the certificates in the signature are ignored. The
+// signature must be a direct child of the element and refer to the
+// element by its ID attribute, so the signed content is exactly the
+// element, which defends against signature wrapping.
+// NOTE: SHA-1 is not supported, as it's broken.
+func Verify(e *Element, certs []*x509.Certificate) error {
+	sigs := e.Elements(NamespaceDSig, "Signature")
+	if len(sigs) != 1 {
+		return fmt.Errorf("want exactly one signature, got %d", len(sigs))
+	}
+	sig := sigs[0]
+
+	signedInfo := sig.Element(NamespaceDSig, "SignedInfo")
+	if signedInfo == nil {
+		return fmt.Errorf("SignedInfo not found")
+	}
+
+	c14n := signedInfo.Element(NamespaceDSig, "CanonicalizationMethod")
+	if c14n == nil {
+		return fmt.Errorf("CanonicalizationMethod not found")
+	}
+	withComments, inclusive, err := c14nMethod(c14n)
+	if err != nil {
+		return err
+	}
+
+	method := signedInfo.Element(NamespaceDSig, "SignatureMethod")
+	if method == nil {
+		return fmt.Errorf("SignatureMethod not found")
+	}
+	hash, ok := signatureHashes[method.Attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("unsupported signature method %s", method.Attr("Algorithm"))
+	}
+
+	refs := signedInfo.Elements(NamespaceDSig, "Reference")
+	if len(refs) != 1 {
+		return fmt.Errorf("want exactly one reference, got %d", len(refs))
+	}
+	if err := verifyReference(e, sig, refs[0]); err != nil {
+		return err
+	}
+
+	value, err := decodeBase64(sig.Element(NamespaceDSig, "SignatureValue"))
+	if err != nil {
+		return fmt.Errorf("invalid SignatureValue: %v", err)
+	}
+
+	h := hash.New()
+	h.Write(Canonicalize(signedInfo, inclusive, withComments, nil))
+	digest := h.Sum(nil)
+
+	for _, cert := range certs {
+		if verifySignature(cert.PublicKey, hash, digest, value) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature is not signed by any trusted certificate")
+}
+
+func c14nMethod(e *Element) (withComments bool, inclusive []string, err error) {
+	switch e.Attr("Algorithm") {
+	case AlgorithmExcC14N:
+	case AlgorithmExcC14NWithComments:
+		withComments = true
+	default:
+		return false, nil, fmt.Errorf("unsupported canonicalization %s", e.Attr("Algorithm"))
+	}
+
+	for _, n := range e.Children {
+		if c, ok := n.(*Element); ok && c.Local == "InclusiveNamespaces" && c.Space() == AlgorithmExcC14N {
+			inclusive = strings.Fields(c.Attr("PrefixList"))
+		}
+	}
+	return withComments, inclusive, nil
+}
+
+func verifyReference(e, sig, ref *Element) error {
+	id := e.Attr("ID")
+	if id == "" || ref.Attr("URI") != "#"+id {
+		return fmt.Errorf("reference %s doesn't refer to the element", ref.Attr("URI"))
+	}
+
+	withComments, inclusive := false, []string(nil)
+	enveloped := false
+	if transforms := ref.Element(NamespaceDSig, "Transforms"); transforms != nil {
+		for _, t := range transforms.Elements(NamespaceDSig, "Transform") {
+			if t.Attr("Algorithm") == AlgorithmEnvelopedSignature {
+				enveloped = true
+				continue
+			}
+			var err error
+			withComments, inclusive, err = c14nMethod(t)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !enveloped {
+		return fmt.Errorf("enveloped signature transform is required")
+	}
+
+	method := ref.Element(NamespaceDSig, "DigestMethod")
+	if method == nil {
+		return fmt.Errorf("DigestMethod not found")
+	}
+	hash, ok := digestHashes[method.Attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("unsupported digest method %s", method.Attr("Algorithm"))
+	}
+
+	want, err := decodeBase64(ref.Element(NamespaceDSig, "DigestValue"))
+	if err != nil {
+		return fmt.Errorf("invalid DigestValue: %v", err)
+	}
+
+	h := hash.New()
+	h.Write(Canonicalize(e, inclusive, withComments, sig))
+	if !bytes.Equal(h.Sum(nil), want) {
+		return fmt.Errorf("digest mismatch")
+	}
+	return nil
+}
+
+func decodeBase64(e *Element) ([]byte, error) {
+	if e == nil {
+		return nil, fmt.Errorf("not found")
+	}
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(e.Text()), ""))
+}
+
+func verifySignature(key crypto.PublicKey, hash crypto.Hash, digest, sig []byte) error {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		// NOTE: XML signatures of ECDSA are r||s rather than ASN.1.
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key %T", key)
+	}
+}
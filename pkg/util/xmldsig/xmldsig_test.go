@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xmldsig_test
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/util/xmldsig"
+	"github.com/megaease/easegress/pkg/util/xmldsig/xmldsigtest"
+)
+
+func TestCanonicalize(t *testing.T) {
+	cases := []struct {
+		doc  string
+		path []string
+		want string
+	}{
+		{
+			// The example of the Exclusive XML Canonicalization spec.
+			doc:  `<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"/></n1:elem2></n0:local>`,
+			path: []string{"elem2"},
+			want: `<n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"></n3:stuff></n1:elem2>`,
+		},
+		{
+			doc:  `<a xmlns="http://d" b:x="1" xmlns:b="http://b" z="2" c="&#xD;&lt;&quot;"><!-- c --><t>a&gt;b &amp; "q"</t><u xmlns=""/></a>`,
+			want: `<a xmlns="http://d" xmlns:b="http://b" c="&#xD;&lt;&quot;" z="2" b:x="1"><t>a&gt;b &amp; "q"</t><u xmlns=""></u></a>`,
+		},
+		{
+			// Unused namespaces are omitted.
+			doc:  `<p:r xmlns:p="urn:p" xmlns:q="urn:q"><p:s xmlns:q="urn:q"/></p:r>`,
+			want: `<p:r xmlns:p="urn:p"><p:s></p:s></p:r>`,
+		},
+	}
+
+	for i, c := range cases {
+		root, err := xmldsig.Parse([]byte(c.doc))
+		if err != nil {
+			t.Fatalf("case %d: parse failed: %v", i, err)
+		}
+		e := root
+		for _, name := range c.path {
+			for _, n := range e.Children {
+				if child, ok := n.(*xmldsig.Element); ok && child.Local == name {
+					e = child
+				}
+			}
+		}
+		if got := string(xmldsig.Canonicalize(e, nil, false, nil)); got != c.want {
+			t.Errorf("case %d:\nwant %s\ngot  %s", i, c.want, got)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, doc := range []string{
+		`<!DOCTYPE a [<!ENTITY x "y">]><a>&x;</a>`,
+		`<a><b></a>`,
+		`<a/><b/>`,
+		``,
+	} {
+		if _, err := xmldsig.Parse([]byte(doc)); err == nil {
+			t.Errorf("%s should be rejected", doc)
+		}
+	}
+}
+
+const template = `<r:Response xmlns:r="urn:r" ID="resp"><r:Assertion ID="a1">` + xmldsigtest.Placeholder +
+	`<r:Subject>alice</r:Subject></r:Assertion></r:Response>`
+
+func TestVerify(t *testing.T) {
+	signer := xmldsigtest.NewSigner()
+	certs := []*x509.Certificate{signer.Cert}
+
+	doc, err := signer.Sign(template, "a1")
+	if err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+
+	assertion := func(doc string) *xmldsig.Element {
+		root, err := xmldsig.Parse([]byte(doc))
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		return root.Element("urn:r", "Assertion")
+	}
+
+	if err := xmldsig.Verify(assertion(doc), certs); err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+
+	// Tampered content.
+	tampered := strings.Replace(doc, "alice", "mallory", 1)
+	if err := xmldsig.Verify(assertion(tampered), certs); err == nil {
+		t.Fatalf("tampered document is verified")
+	}
+
+	// Untrusted certificate.
+	other := xmldsigtest.NewSigner()
+	if err := xmldsig.Verify(assertion(doc), []*x509.Certificate{other.Cert}); err == nil {
+		t.Fatalf("document is verified by untrusted certificate")
+	}
+
+	// The signature refers to another element.
+	wrapped := strings.Replace(doc, `ID="a1"`, `ID="a2"`, 1)
+	if err := xmldsig.Verify(assertion(wrapped), certs); err == nil {
+		t.Fatalf("wrapped document is verified")
+	}
+
+	// Unsigned.
+	unsigned := strings.Replace(template, xmldsigtest.Placeholder, "", 1)
+	if err := xmldsig.Verify(assertion(unsigned), certs); err == nil {
+		t.Fatalf("unsigned document is verified")
+	}
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package xmldsigtest signs XML documents for tests.
+package xmldsigtest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/xmldsig"
+)
+
+// Placeholder is replaced by the signature in templates.
+const Placeholder = "{{signature}}"
+
+// Signer signs documents by a self-signed certificate.
+type Signer struct {
+	Key  *rsa.PrivateKey
+	Cert *x509.Certificate
+	// PEM is the certificate in PEM.
+	PEM string
+}
+
+// NewSigner creates a signer.
+func NewSigner() *Signer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	return &Signer{
+		Key:  key,
+		Cert: cert,
+		PEM:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+}
+
+func find(e *xmldsig.Element, id string) *xmldsig.Element {
+	if e.Attr("ID") == id {
+		return e
+	}
+	for _, n := range e.Children {
+		if c, ok := n.(*xmldsig.Element); ok {
+			if found := find(c, id); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+// Sign signs the element of the id in the template, and replaces the
+// placeholder, which must be the first child of the element, by the
+// enveloped signature.
+func (s *Signer) Sign(template, id string) (string, error) {
+	root, err := xmldsig.Parse([]byte(strings.Replace(template, Placeholder, "", 1)))
+	if err != nil {
+		return "", err
+	}
+	e := find(root, id)
+	if e == nil {
+		return "", fmt.Errorf("element %s not found", id)
+	}
+
+	digest := sha256.Sum256(xmldsig.Canonicalize(e, nil, false, nil))
+	signedInfo := `<ds:SignedInfo>` +
+		`<ds:CanonicalizationMethod Algorithm="` + xmldsig.AlgorithmExcC14N + `"/>` +
+		`<ds:SignatureMethod Algorithm="` + xmldsig.AlgorithmRSASHA256 + `"/>` +
+		`<ds:Reference URI="#` + id + `"><ds:Transforms>` +
+		`<ds:Transform Algorithm="` + xmldsig.AlgorithmEnvelopedSignature + `"/>` +
+		`<ds:Transform Algorithm="` + xmldsig.AlgorithmExcC14N + `"/>` +
+		`</ds:Transforms>` +
+		`<ds:DigestMethod Algorithm="` + xmldsig.AlgorithmSHA256 + `"/>` +
+		`<ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) + `</ds:DigestValue>` +
+		`</ds:Reference></ds:SignedInfo>`
+	start := `<ds:Signature xmlns:ds="` + xmldsig.NamespaceDSig + `">`
+
+	sig, err := xmldsig.Parse([]byte(start + signedInfo + `</ds:Signature>`))
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(xmldsig.Canonicalize(sig.Element(xmldsig.NamespaceDSig, "SignedInfo"), nil, false, nil))
+	value, err := rsa.SignPKCS1v15(rand.Reader, s.Key, crypto.SHA256, h[:])
+	if err != nil {
+		return "", err
+	}
+
+	signature := start + signedInfo +
+		`<ds:SignatureValue>` + base64.StdEncoding.EncodeToString(value) + `</ds:SignatureValue>` +
+		`</ds:Signature>`
+	return strings.Replace(template, Placeholder, signature, 1), nil
+}